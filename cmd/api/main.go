@@ -2,19 +2,41 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log/slog"
 	"os"
+	"time"
 
 	"github.com/google/uuid"
-	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/labstack/echo/v4"
 	"github.com/pixperk/goiler/internal/auth"
-	"github.com/pixperk/goiler/internal/channel"
+	"github.com/pixperk/goiler/internal/auth/webauthn"
+	"github.com/pixperk/goiler/internal/changefeed"
+	"github.com/pixperk/goiler/internal/compliance"
 	"github.com/pixperk/goiler/internal/config"
+	"github.com/pixperk/goiler/internal/consent"
+	"github.com/pixperk/goiler/internal/emaillog"
+	"github.com/pixperk/goiler/internal/org"
+	"github.com/pixperk/goiler/internal/privacy"
+	"github.com/pixperk/goiler/internal/rbac"
 	"github.com/pixperk/goiler/internal/server"
+	"github.com/pixperk/goiler/internal/tenant"
+	"github.com/pixperk/goiler/internal/ticket"
 	"github.com/pixperk/goiler/internal/user"
 	"github.com/pixperk/goiler/internal/websocket"
+	"github.com/pixperk/goiler/internal/wellknown"
 	"github.com/pixperk/goiler/internal/worker"
+	"github.com/pixperk/goiler/pkg/clock"
+	"github.com/pixperk/goiler/pkg/dbconn"
+	"github.com/pixperk/goiler/pkg/events"
+	"github.com/pixperk/goiler/pkg/idgen"
+	"github.com/pixperk/goiler/pkg/logging"
 	"github.com/pixperk/goiler/pkg/otel"
+	"github.com/pixperk/goiler/pkg/pubsub"
+	"github.com/pixperk/goiler/pkg/redisconn"
+	"github.com/pixperk/goiler/pkg/storage"
+	"github.com/pixperk/goiler/pkg/waitfor"
+	"github.com/pixperk/goiler/pkg/watchdog"
 )
 
 // @title Goiler API
@@ -26,18 +48,23 @@ import (
 // @in header
 // @name Authorization
 func main() {
-	// Initialize logger
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-		Level: slog.LevelInfo,
-	}))
-	slog.SetDefault(logger)
-
 	// Load configuration
 	cfg := config.Load()
 
+	// Initialize loggers
+	logs := logging.New(cfg.Log)
+	logger := logs.Logger()
+	slog.SetDefault(logger)
+
 	// Initialize context
 	ctx := context.Background()
 
+	// Wait for the services in WAIT_FOR, e.g. while containers start
+	if err := waitfor.Dependencies(ctx, cfg, logs.For("startup")); err != nil {
+		logger.Error("dependencies not ready", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
 	// Initialize OpenTelemetry
 	tracerProvider, err := otel.NewTracerProvider(ctx, cfg, logger)
 	if err != nil {
@@ -54,7 +81,12 @@ func main() {
 	defer meterProvider.Shutdown(ctx)
 
 	// Initialize database connection
-	dbpool, err := pgxpool.New(ctx, cfg.Database.URL)
+	if err := dbconn.Validate(cfg.Database); err != nil {
+		logger.Error("invalid database config", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	dbTracer := otel.NewDBTracingWrapper(cfg.OTEL.ServiceName, meterProvider, otel.DBTracingOptions(cfg.OTEL.DB)...)
+	dbpool, err := dbconn.NewPool(ctx, cfg.Database, dbconn.WithTracer(dbTracer))
 	if err != nil {
 		logger.Error("failed to connect to database", slog.String("error", err.Error()))
 		os.Exit(1)
@@ -69,63 +101,542 @@ func main() {
 	logger.Info("connected to database")
 
 	// Initialize repositories
-	userRepo := user.NewPostgresRepository(dbpool)
+	userStore := user.NewPostgresRepository(dbpool)
+	var tokenRepo auth.TokenRepository
+	switch cfg.Auth.TokenStore {
+	case auth.TokenStorePostgres:
+		tokenRepo = auth.NewPostgresTokenRepository(dbpool)
+	case auth.TokenStoreNone:
+	default:
+		logger.Error("invalid auth config", slog.String("error", "unknown AUTH_TOKEN_STORE "+cfg.Auth.TokenStore))
+		os.Exit(1)
+	}
+
+	// Initialize password hashing
+	if cfg.Auth.Hash.Benchmark {
+		logHashRecommendation(logs.For("auth"), cfg.Auth.Hash.BenchmarkTarget)
+	}
+	hasher, err := auth.NewPasswordHasherFromConfig(cfg.Auth.Hash)
+	if err != nil {
+		logger.Error("invalid password hashing config", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
+	// Initialize worker client
+	if err := redisconn.Validate(cfg.Redis); err != nil {
+		logger.Error("invalid redis config", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	if err := worker.SetPayloadCodec(cfg.Worker.PayloadCodec); err != nil {
+		logger.Error("invalid worker config", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	workerClient, err := worker.NewClient(cfg, logs.For("worker"))
+	if err != nil {
+		logger.Error("invalid app config", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	defer workerClient.Close()
+	deadLetters := worker.NewDeadLetterQueue(cfg)
+	defer deadLetters.Close()
+	schedules := worker.NewScheduleList(cfg)
+	defer schedules.Close()
+	var scaling *worker.ScalingMonitor
+	if cfg.Worker.ScalingInterval > 0 {
+		scaling = worker.NewScalingMonitor(cfg, logs.For("worker"))
+		scaling.Start()
+		defer scaling.Close()
+	}
+
+	clk := clock.New()
+	ids, err := idgen.New(cfg.App.IDFormat)
+	if err != nil {
+		logger.Error("invalid app config", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
+	// Profile updates, password changes and deletions are recorded in the
+	// account activity log, whichever service makes them
+	userRepo := user.NewAuditedRepository(userStore, userStore, logs.For("user"), clk, ids)
+
+	// Per-user caps on concurrent requests to expensive routes
+	limitsRedis := redisconn.NewClient(cfg.Redis)
+	defer limitsRedis.Close()
+	limits := server.NewConcurrencyLimiter(limitsRedis, cfg.RateLimit.ConcurrencyLeaseTTL, logs.For("http"))
+	limits.SetClock(clk)
+
+	// Initialize login lockout
+	lockout := auth.LockoutPolicyFromConfig(cfg.Auth.Lockout)
+	if err := lockout.Validate(); err != nil {
+		logger.Error("invalid auth config", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	loginAlerts := auth.LoginAlertPolicyFromConfig(cfg.Auth.LoginAlerts)
+	if err := loginAlerts.Validate(); err != nil {
+		logger.Error("invalid auth config", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	// Login countries are kept alongside the login attempts
+	var loginAttempts auth.LoginAttemptStore
+	var knownCountries auth.KnownCountryStore
+	switch cfg.Auth.Lockout.Store {
+	case auth.LoginAttemptStoreRedis:
+		lockoutRedis := redisconn.NewClient(cfg.Redis)
+		defer lockoutRedis.Close()
+		loginAttempts = auth.NewRedisLoginAttemptStore(lockoutRedis, clk)
+		knownCountries = auth.NewRedisKnownCountryStore(lockoutRedis)
+	case auth.LoginAttemptStoreMemory:
+		loginAttempts = auth.NewMemoryLoginAttemptStore(clk)
+		knownCountries = auth.NewMemoryKnownCountryStore()
+	default:
+		logger.Error("invalid auth config", slog.String("error", "unknown AUTH_LOCKOUT_STORE "+cfg.Auth.Lockout.Store))
+		os.Exit(1)
+	}
+
+	// Initialize cookie sessions
+	var sessions *auth.SessionManager
+	if cfg.Auth.Mode == auth.AuthModeCookie {
+		sessionPolicy := auth.SessionPolicyFromConfig(cfg.Auth.Session)
+		if err := sessionPolicy.Validate(); err != nil {
+			logger.Error("invalid session config", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		var sessionStore auth.SessionStore
+		switch cfg.Auth.Session.Store {
+		case auth.SessionStoreRedis:
+			sessionRedis := redisconn.NewClient(cfg.Redis)
+			defer sessionRedis.Close()
+			sessionStore = auth.NewRedisSessionStore(sessionRedis)
+		case auth.SessionStorePostgres:
+			sessionStore = auth.NewPostgresSessionStore(dbpool)
+		default:
+			logger.Error("invalid session config", slog.String("error", "unknown SESSION_STORE "+cfg.Auth.Session.Store))
+			os.Exit(1)
+		}
+		sessions = auth.NewSessionManager(sessionStore, sessionPolicy, clk, ids)
+	}
+
+	// Initialize pub/sub; topics and their payloads are cataloged in
+	// pkg/events
+	bus := pubsub.New(logs.For("pubsub"), 100)
+
+	// Security events are stored for admins and published for in-process
+	// consumers
+	auditStore := auth.NewPostgresAuditStore(dbpool)
+
+	// Initialize role permissions
+	var roleSource rbac.Source
+	switch cfg.Auth.RBAC.Source {
+	case rbac.SourceConfig:
+		roleSource = rbac.ConfigSource(cfg.Auth.RBAC)
+	case rbac.SourcePostgres:
+		roleSource = rbac.NewPostgresSource(dbpool)
+	default:
+		logger.Error("invalid rbac config", slog.String("error", "unknown RBAC_SOURCE "+cfg.Auth.RBAC.Source))
+		os.Exit(1)
+	}
+	authz := rbac.NewAuthorizer(nil, logs.For("auth"))
+	if err := authz.Reload(ctx, roleSource); err != nil {
+		logger.Error("failed to load role permissions", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	if cfg.Auth.RBAC.Source == rbac.SourcePostgres && cfg.Auth.RBAC.ReloadInterval > 0 {
+		go authz.Watch(ctx, roleSource, cfg.Auth.RBAC.ReloadInterval)
+	}
 
 	// Initialize auth service
-	authService, err := auth.NewServiceFromConfig(cfg, &userRepoAdapter{repo: userRepo}, nil)
+	authService, err := auth.NewServiceFromConfig(cfg, &userRepoAdapter{repo: userRepo}, tokenRepo,
+		auth.WithLogger(logs.For("auth")),
+		auth.WithHasher(hasher),
+		auth.WithSecurityNotifier(&securityNotifierAdapter{client: workerClient, logger: logs.For("auth")}),
+		auth.WithVerificationMailer(&verificationMailerAdapter{client: workerClient, logger: logs.For("auth")}),
+		auth.WithLoginAttemptStore(loginAttempts),
+		auth.WithKnownCountryStore(knownCountries),
+		auth.WithClock(clk),
+		auth.WithIDGenerator(ids),
+		auth.WithSessions(sessions),
+		auth.WithImpersonationStore(auth.NewPostgresImpersonationStore(dbpool)),
+		auth.WithIncidentStore(auth.NewPostgresIncidentStore(dbpool)),
+		auth.WithAuditSink(auth.AuditSinks{auditStore, auth.NewPubSubAuditSink(bus)}),
+		auth.WithAuditReader(auditStore),
+		auth.WithEventPublisher(bus),
+		auth.WithRoles(authz),
+	)
 	if err != nil {
 		logger.Error("failed to initialize auth service", slog.String("error", err.Error()))
 		os.Exit(1)
 	}
 
+	// Enforce security incidents, including those declared on other
+	// instances, before accepting any token
+	if err := authService.ReloadIncidents(ctx); err != nil {
+		logger.Error("failed to load security incidents", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	go authService.WatchIncidents(ctx, cfg.Auth.IncidentReload)
+
 	// Initialize handlers
 	authHandler := auth.NewHandler(authService)
-	userService := user.NewService(userRepo, nil)
+	rbacHandler := rbac.NewHandler(authz)
+	store, err := storage.New(cfg.Storage)
+	if err != nil {
+		logger.Error("invalid storage config", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	avatarPolicy := user.AvatarPolicy{
+		MaxBytes:  cfg.Avatar.MaxBytes,
+		MaxPixels: cfg.Avatar.MaxPixels,
+		Size:      cfg.Avatar.Size,
+		URLExpiry: cfg.Storage.URLExpiry,
+	}
+	userService := user.NewService(userRepo, hasher, user.WithClock(clk), user.WithIDGenerator(ids), user.WithAuditor(authService.Auditor()), user.WithPasswordPolicy(authService.PasswordPolicy()), user.WithRoleAuthorizer(authz),
+		user.WithAvatars(userStore, store, avatarPolicy), user.WithActivity(userStore), user.WithTokenRevoker(authService), user.WithEventPublisher(bus))
 	userHandler := user.NewHandler(userService)
+	consentService := consent.NewService(consent.NewPostgresRepository(dbpool),
+		consent.WithLogger(logs.For("consent")),
+		consent.WithClock(clk),
+		consent.WithIDGenerator(ids),
+	)
+	consentHandler := consent.NewHandler(consentService, logs.For("consent"))
+	emailLogHandler := emaillog.NewHandler(emaillog.NewService(emaillog.NewPostgresRepository(dbpool)))
+	syncFeed := changefeed.NewFeed(changefeed.NewPostgresRepository(dbpool),
+		changefeed.WithLogger(logs.For("sync")),
+		changefeed.WithClock(clk),
+	)
+	syncHandler := changefeed.NewHandler(syncFeed, logs.For("sync"))
+	if cfg.Sync.Retention > 0 {
+		go syncFeed.Retain(ctx, cfg.Sync.Retention)
+	}
+
+	var webauthnHandler *webauthn.Handler
+	if cfg.Auth.WebAuthn.RPID != "" {
+		webauthnService, err := webauthn.NewService(cfg.Auth.WebAuthn, &userRepoAdapter{repo: userRepo},
+			webauthn.NewPostgresCredentialRepository(dbpool), authService)
+		if err != nil {
+			logger.Error("failed to initialize webauthn service", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		webauthnHandler = webauthn.NewHandler(webauthnService)
+	}
 
 	// Initialize WebSocket hub
-	wsHub := websocket.NewHub(logger)
+	wsHub, wsHandler, err := websocket.New(cfg.WebSocket, logs.For("websocket"),
+		websocket.PushWithinTenant(userRepo))
+	if err != nil {
+		logger.Error("invalid websocket config", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	if cfg.Tenancy.Enabled {
+		// Clients only use the rooms of their tenant
+		websocket.IsolateTenants(cfg.WebSocket, wsHub)
+	}
+	wsHub.SetEventPublisher(bus)
+	go websocket.RelayTaskProgress(ctx, bus, wsHub, logs.For("websocket"))
+	wsHub.SetTokenAuthenticator(websocket.Authenticator(authService))
 	go wsHub.Run()
-	wsHandler := websocket.NewHandler(wsHub, logger)
 
-	// Initialize worker client
-	workerClient := worker.NewClient(cfg, logger)
-	defer workerClient.Close()
+	// Relay broadcasts to clients connected to other instances
+	brokerHub, err := websocket.Broker(cfg.WebSocket, cfg.Redis, wsHub, logs.For("websocket"))
+	if err != nil {
+		logger.Error("invalid websocket config", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	if brokerHub != nil {
+		go brokerHub.Run(ctx)
+	}
+
+	// Watch for goroutine leaks and queues that stop draining
+	if cfg.Watchdog.Interval > 0 {
+		dog := watchdog.New(watchdog.Config{
+			Interval:      cfg.Watchdog.Interval,
+			MaxGoroutines: cfg.Watchdog.MaxGoroutines,
+			GrowthChecks:  cfg.Watchdog.GrowthChecks,
+			MaxQueueFill:  cfg.Watchdog.MaxQueueFill,
+			StackSamples:  cfg.Watchdog.StackSamples,
+		}, logs.For("watchdog"), clk)
+		dog.WatchQueue("ws_hub_broadcast", wsHub.Backlog)
+		dog.WatchQueue("pubsub_subscriber", bus.Backlog)
+		dog.OnAlert(func(alert watchdog.Alert) {
+			events.WatchdogAlert.Publish(bus, alert)
+		})
+		dog.Start()
+		defer dog.Close()
+	}
+	if cfg.Sync.PollInterval > 0 {
+		go syncFeed.Watch(ctx, wsHandler, cfg.Sync.PollInterval)
+	}
 
-	// Initialize pub/sub
-	pubsub := channel.NewPubSub(logger, 100)
-	_ = pubsub // Available for use in handlers
+	// Support tickets notify users of replies and push them over WebSocket
+	ticketService := ticket.NewService(ticket.NewPostgresRepository(dbpool),
+		ticket.WithLogger(logs.For("tickets")),
+		ticket.WithClock(clk),
+		ticket.WithIDGenerator(ids),
+		ticket.WithNotifier(workerClient),
+		ticket.WithPusher(wsHandler),
+	)
+	ticketHandler := ticket.NewHandler(ticketService, logs.For("tickets"))
+
+	// Tenants partition users; requests pick theirs by header or subdomain
+	tenantService := tenant.NewService(tenant.NewPostgresRepository(dbpool),
+		tenant.WithLogger(logs.For("tenant")),
+		tenant.WithClock(clk),
+		tenant.WithIDGenerator(ids),
+		tenant.WithCacheTTL(cfg.Tenancy.CacheTTL),
+	)
+	tenantHandler := tenant.NewHandler(tenantService, logs.For("tenant"))
+
+	// Organizations invite members by email and select the org claim
+	orgService := org.NewService(org.NewPostgresRepository(dbpool),
+		org.WithLogger(logs.For("org")),
+		org.WithClock(clk),
+		org.WithIDGenerator(ids),
+		org.WithMailer(workerClient),
+		org.WithInvitations(cfg.Org.InvitationURL, cfg.Org.InvitationExpiry),
+	)
+	orgHandler := org.NewHandler(orgService, authService, logs.For("org"))
+
+	// Requests to regulated routes are archived for compliance
+	var archive *compliance.Archive
+	if cfg.Compliance.Enabled {
+		archive, err = compliance.New(compliance.NewPostgresStore(dbpool), cfg.Compliance,
+			compliance.WithLogger(logs.For("compliance")),
+			compliance.WithClock(clk),
+			compliance.WithIDGenerator(ids),
+		)
+		if err != nil {
+			logger.Error("invalid compliance config", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		go archive.Maintain(ctx, cfg.Compliance.Retention)
+	}
+
+	// Data export and erasure requests are processed by the worker
+	var privacyHandler *privacy.Handler
+	if cfg.Privacy.Enabled {
+		privacyService := privacy.NewService(privacy.NewPostgresRepository(dbpool),
+			privacy.WithLogger(logs.For("privacy")),
+			privacy.WithClock(clk),
+			privacy.WithIDGenerator(ids),
+			privacy.WithQueue(workerClient),
+			privacy.WithExportRetention(cfg.Privacy.ExportRetention),
+		)
+		privacyHandler = privacy.NewHandler(privacyService, userService, logs.For("privacy"))
+	}
 
 	// Initialize server
-	srv := server.New(cfg, logger)
+	srv := server.New(cfg, logs.For("http"))
 
 	// Setup middleware
 	srv.SetupMiddleware()
+	srv.SetPermissions(func(role, perm string) bool {
+		return authz.Can(role, rbac.Permission(perm))
+	})
+	wsHub.SetWriteGate(srv.ReadOnly())
+	srv.OnShutdown(func(ctx context.Context) {
+		if err := wsHub.Shutdown(ctx); err != nil {
+			logger.Warn("websocket hub shutdown incomplete", slog.String("error", err.Error()))
+		}
+	})
+
+	// Stream live metrics to admins in the metrics:live room
+	liveMetrics := map[string]websocket.MetricFunc{
+		websocket.MetricActiveRequests:   func() any { return srv.ActiveRequests() },
+		websocket.MetricConnectedClients: func() any { return wsHub.GetConnectedClients() },
+	}
+	if scaling != nil {
+		liveMetrics[websocket.MetricQueueDepth] = func() any {
+			if snapshot := scaling.Snapshot(); snapshot != nil {
+				return snapshot.TotalBacklog
+			}
+			return nil
+		}
+	}
+	metricsStreamer, err := websocket.LiveMetrics(cfg.WebSocket, wsHub, liveMetrics, func(c *websocket.Client) bool {
+		return authz.Can(c.Role, rbac.PermSystemRead)
+	}, logs.For("websocket"))
+	if err != nil {
+		logger.Error("invalid websocket config", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	if metricsStreamer != nil {
+		go metricsStreamer.Run(ctx)
+	}
 
 	// Add OTEL middleware
 	srv.Echo().Use(otel.CombinedMiddleware(cfg.OTEL.ServiceName, meterProvider))
 
+	// Client country for new country login alerts, from the CDN or proxy
+	if header := cfg.Auth.LoginAlerts.CountryHeader; header != "" {
+		srv.Echo().Use(auth.ClientCountry(header))
+	}
+
 	// Setup routes
 	srv.SetupRoutes()
 
+	// /.well-known documents, including the public signing keys for
+	// services verifying our tokens
+	wellKnown, err := wellknown.New(cfg.WellKnown, clk)
+	if err != nil {
+		logger.Error("invalid well-known config", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	wellKnown.Register(wellknown.JWKS, authHandler.JWKS)
+	wellKnown.Mount(srv.Echo())
+
+	// Files of the local storage backend, read through their signed URLs
+	if local, ok := store.(*storage.LocalStore); ok {
+		srv.Echo().GET(local.RoutePath()+"/*", local.Handler())
+	}
+
 	// Register auth routes
 	api := srv.Echo().Group("/api/v1")
+	if cfg.Tenancy.Enabled {
+		api.Use(tenant.NewResolver(tenantService, cfg.Tenancy, logs.For("tenant")).Middleware())
+		api.GET("/tenant", tenantHandler.Current)
+	}
+	api.Use(tenant.NewSandboxRouter(tenantService, cfg.Tenancy, logs.For("tenant")).Middleware())
+	if archive != nil {
+		api.Use(archive.Middleware())
+	}
 	api.POST("/auth/register", authHandler.Register)
+	// Guests need no email, so creating them is limited per client IP on
+	// top of the API-wide limit
+	var guestLimit []echo.MiddlewareFunc
+	if cfg.Auth.GuestAccounts && cfg.Auth.GuestRateLimit > 0 {
+		guestLimiter := server.NewRateLimiter(server.RateLimiterConfig{
+			Name:        "guest",
+			Requests:    cfg.Auth.GuestRateLimit,
+			Duration:    cfg.Auth.GuestRateWindow,
+			MaxVisitors: cfg.RateLimit.MaxVisitors,
+			IdleTimeout: cfg.Auth.GuestRateWindow,
+			Clock:       clk,
+		})
+		srv.OnShutdown(func(context.Context) { guestLimiter.Stop() })
+		guestLimit = append(guestLimit, guestLimiter.Middleware())
+	}
+	api.POST("/auth/guest", authHandler.CreateGuest, guestLimit...)
 	api.POST("/auth/login", authHandler.Login)
 	api.POST("/auth/refresh", authHandler.RefreshToken)
 	api.POST("/auth/logout", authHandler.Logout)
+	api.GET("/auth/verify-email", authHandler.VerifyEmail)
+	api.POST("/auth/verify-email", authHandler.VerifyEmail)
+	api.GET("/auth/secure-account", authHandler.SecureAccountPage)
+	api.POST("/auth/secure-account", authHandler.SecureAccount)
+	api.GET("/policies", consentHandler.ListCurrent)
 
 	// Protected routes
 	protected := api.Group("")
 	protected.Use(authHandler.AuthMiddleware())
+	// Admins manage policies before they've accepted them themselves
+	protected.Use(consentHandler.RequireAcceptance("/api/v1/users/me/consents", "/api/v1/admin/policies", "/api/v1/admin/users/:id/consents"))
+	protected.GET("/users", userHandler.ListUsers, authz.RequirePermission(rbac.PermUsersRead))
 	protected.GET("/users/me", userHandler.GetProfile)
 	protected.PUT("/users/me", userHandler.UpdateProfile)
-	protected.PUT("/users/me/password", userHandler.ChangePassword)
-	protected.DELETE("/users/me", userHandler.DeleteAccount)
+	protected.PUT("/users/me/password", userHandler.ChangePassword, authHandler.RejectImpersonation())
+	protected.DELETE("/users/me", userHandler.DeleteAccount, authHandler.RejectImpersonation())
+	protected.GET("/users/me/avatar", userHandler.GetAvatar)
+	protected.PUT("/users/me/avatar", userHandler.UploadAvatar)
+	protected.DELETE("/users/me/avatar", userHandler.DeleteAvatar)
+	protected.GET("/users/me/activity", userHandler.ListActivity)
+	protected.GET("/users/me/identities", userHandler.ListIdentities)
+	protected.GET("/users/me/emails", emailLogHandler.ListMine)
+	protected.POST("/users/me/identities/password", userHandler.LinkPassword, authHandler.RejectImpersonation())
+	protected.DELETE("/users/me/identities/:provider", userHandler.UnlinkIdentity, authHandler.RejectImpersonation())
+	protected.GET("/users/me/sessions", authHandler.ListSessions)
+	protected.DELETE("/users/me/sessions/:id", authHandler.RevokeSession, authHandler.RejectImpersonation())
+	protected.GET("/users/me/permissions", rbacHandler.GetMyPermissions)
+	protected.GET("/users/me/consents", consentHandler.GetMyConsents)
+	protected.POST("/users/me/consents", consentHandler.Accept, authHandler.RejectImpersonation())
+	if privacyHandler != nil {
+		protected.POST("/users/me/export", privacyHandler.RequestExport)
+		protected.POST("/users/me/erasure", privacyHandler.RequestErasure, authHandler.RejectImpersonation())
+		protected.GET("/users/me/privacy-requests/:id", privacyHandler.GetMine)
+		protected.GET("/users/me/privacy-requests/:id/archive", privacyHandler.DownloadArchive)
+	}
+	protected.GET("/sync", syncHandler.Sync)
+	protected.POST("/tickets", ticketHandler.Create)
+	protected.GET("/tickets", ticketHandler.ListMine)
+	protected.GET("/tickets/:id", ticketHandler.GetMine)
+	protected.POST("/orgs", orgHandler.Create)
+	protected.GET("/orgs", orgHandler.ListMine)
+	protected.GET("/orgs/current", orgHandler.Current)
+	protected.POST("/orgs/invitations/accept", orgHandler.Accept, authHandler.RejectImpersonation())
+	protected.GET("/orgs/:id", orgHandler.Get)
+	protected.POST("/orgs/:id/invitations", orgHandler.Invite)
+	protected.POST("/orgs/:id/switch", orgHandler.Switch, authHandler.RejectImpersonation())
+	protected.DELETE("/orgs/:id/members/:user_id", orgHandler.RemoveMember)
+	protected.DELETE("/auth/impersonation", authHandler.StopImpersonating)
+	protected.POST("/auth/guest/upgrade", authHandler.UpgradeGuest, authHandler.RejectImpersonation())
+
+	// Passkey routes
+	if webauthnHandler != nil {
+		api.POST("/auth/webauthn/login/begin", webauthnHandler.BeginLogin)
+		api.POST("/auth/webauthn/login/finish", webauthnHandler.FinishLogin)
+		protected.POST("/auth/webauthn/register/begin", webauthnHandler.BeginRegistration, authHandler.RejectImpersonation())
+		protected.POST("/auth/webauthn/register/finish", webauthnHandler.FinishRegistration, authHandler.RejectImpersonation())
+	}
 
 	// WebSocket routes
 	api.GET("/ws", wsHandler.HandleConnection)
+	api.GET("/events", wsHandler.HandleEvents)
 	protected.GET("/ws/auth", wsHandler.HandleAuthenticatedConnection)
+	protected.GET("/ws/presence/:user_id", wsHandler.HandlePresence, wsHandler.RequireSelf(authz.RequirePermission(rbac.PermSystemRead)))
+	protected.GET("/ws/stats", wsHandler.HandleStats, authz.RequirePermission(rbac.PermSystemRead))
+	// Users push to users of their tenant; platform pushes reach rooms of
+	// any tenant
+	canPush := wsHandler.RequirePushKey(authHandler.AuthMiddleware(), authz.RequirePermission(rbac.PermWebSocketPush))
+	canBroadcast := wsHandler.RequirePushKey(authHandler.AuthMiddleware(), authz.RequirePermission(rbac.PermPlatformBroadcast))
+	api.POST("/ws/broadcast", wsHandler.HandlePushAll, canBroadcast)
+	api.POST("/ws/rooms/:room/broadcast", wsHandler.HandlePushRoom, canBroadcast)
+	api.POST("/ws/users/:id/send", wsHandler.HandlePushUser, canPush)
+
+	// Admin routes; each route also requires its own permission, and reads
+	// only span tenants for platform operators
+	admin := protected.Group("/admin", authz.RequirePermission(rbac.PermAdminAccess), authz.CrossTenant(rbac.PermPlatformRead))
+	srv.RegisterAdminRoutes(admin, authz.RequirePermission(rbac.PermSystemRead), authz.RequirePermission(rbac.PermSystemWrite))
+	admin.GET("/roles", rbacHandler.ListRoles, authz.RequirePermission(rbac.PermSystemRead))
+	admin.PATCH("/users/roles", userHandler.BulkUpdateRoles, authz.RequirePermission(rbac.PermUsersWrite))
+	admin.POST("/users/:id/suspend", userHandler.SuspendUser, authz.RequirePermission(rbac.PermUsersWrite))
+	admin.POST("/users/:id/unsuspend", userHandler.UnsuspendUser, authz.RequirePermission(rbac.PermUsersWrite))
+	admin.GET("/users/:id/consents", consentHandler.GetUserConsents, authz.RequirePermission(rbac.PermUsersRead))
+	admin.GET("/users/:id/emails", emailLogHandler.ListForUser, authz.RequirePermission(rbac.PermUsersRead))
+	if privacyHandler != nil {
+		admin.GET("/privacy-requests/:id", privacyHandler.Get, authz.RequirePermission(rbac.PermUsersRead))
+	}
+	admin.POST("/users/:id/impersonate", authHandler.Impersonate, authz.RequirePermission(rbac.PermUsersImpersonate))
+	admin.GET("/impersonations", authHandler.ListImpersonations, authz.RequirePermission(rbac.PermUsersRead))
+	admin.DELETE("/impersonations/:id", authHandler.EndImpersonation, authz.RequirePermission(rbac.PermUsersImpersonate))
+	admin.GET("/audit", authHandler.ListAuditEvents, authz.RequirePermission(rbac.PermUsersRead))
+	admin.POST("/incidents", authHandler.DeclareIncident, authz.RequirePermission(rbac.PermPlatformIncidents), authHandler.RejectImpersonation())
+	admin.GET("/incidents", authHandler.ListIncidents, authz.RequirePermission(rbac.PermPlatformIncidents))
+	admin.POST("/policies", consentHandler.CreatePolicy, authz.RequirePermission(rbac.PermPoliciesWrite))
+	admin.GET("/policies", consentHandler.ListPolicies, authz.RequirePermission(rbac.PermPoliciesRead))
+	admin.GET("/policies/:id/users", consentHandler.ListPolicyUsers, authz.RequirePermission(rbac.PermPoliciesRead))
+	admin.GET("/ws/rooms/:room", wsHandler.HandleGetRoom, authz.RequirePermission(rbac.PermSystemRead))
+	admin.PUT("/ws/rooms/:room/policy", wsHandler.HandleSetRoomPolicy, authz.RequirePermission(rbac.PermSystemWrite))
+	admin.DELETE("/ws/rooms/:room/policy", wsHandler.HandleClearRoomPolicy, authz.RequirePermission(rbac.PermSystemWrite))
+	admin.GET("/tickets", ticketHandler.List, authz.RequirePermission(rbac.PermTicketsRead))
+	admin.GET("/tickets/:id", ticketHandler.Get, authz.RequirePermission(rbac.PermTicketsRead))
+	admin.POST("/tickets/:id/replies", ticketHandler.Reply, authz.RequirePermission(rbac.PermTicketsWrite))
+	if cfg.Tenancy.Enabled {
+		admin.POST("/tenants", tenantHandler.Create, authz.RequirePermission(rbac.PermPlatformTenants))
+		admin.GET("/tenants", tenantHandler.List, authz.RequirePermission(rbac.PermPlatformTenants))
+	}
+	if archive != nil {
+		complianceHandler := compliance.NewHandler(archive, logs.For("compliance"))
+		admin.GET("/compliance/requests", complianceHandler.Export, authz.RequirePermission(rbac.PermComplianceRead))
+	}
+
+	taskAdmin := worker.NewAdminHandler(deadLetters, scaling, schedules)
+	canReadTasks, canWriteTasks := authz.RequirePermission(rbac.PermTasksRead), authz.RequirePermission(rbac.PermTasksWrite)
+	admin.GET("/queues/scaling", taskAdmin.QueueScaling, authz.RequirePermission(rbac.PermSystemRead))
+	admin.GET("/tasks/schedules", taskAdmin.ListSchedules, canReadTasks)
+	admin.GET("/tasks/dead", taskAdmin.ListDeadTasks, canReadTasks)
+	admin.POST("/tasks/dead/retry", taskAdmin.BulkRetryDeadTasks, canWriteTasks, limits.Limit("dead_task_bulk", 1))
+	admin.POST("/tasks/dead/delete", taskAdmin.BulkDeleteDeadTasks, canWriteTasks, limits.Limit("dead_task_bulk", 1))
+	admin.POST("/tasks/dead/:queue/:id/retry", taskAdmin.RetryDeadTask, canWriteTasks)
+	admin.PUT("/tasks/dead/:queue/:id/annotation", taskAdmin.AnnotateDeadTask, canWriteTasks)
+	admin.DELETE("/tasks/dead/:queue/:id", taskAdmin.DeleteDeadTask, canWriteTasks)
 
 	// Start server
 	if err := srv.Start(); err != nil {
@@ -134,20 +645,89 @@ func main() {
 	}
 }
 
+// logHashRecommendation benchmarks password hashing on this host and logs
+// the parameters that fit within target
+func logHashRecommendation(logger *slog.Logger, target time.Duration) {
+	logger.Info("benchmarking password hashing", slog.Duration("target", target))
+	rec := auth.BenchmarkHashParams(target)
+	logger.Info("recommended password hashing parameters",
+		slog.Int("HASH_ARGON2_MEMORY", int(rec.Argon2.Memory)),
+		slog.Int("HASH_ARGON2_ITERATIONS", int(rec.Argon2.Iterations)),
+		slog.Int("HASH_ARGON2_PARALLELISM", int(rec.Argon2.Parallelism)),
+		slog.Duration("argon2_duration", rec.Argon2Duration),
+		slog.Int("HASH_BCRYPT_COST", rec.BcryptCost),
+		slog.Duration("bcrypt_duration", rec.BcryptDuration),
+	)
+}
+
+// securityNotifierAdapter delivers auth security events as worker tasks
+type securityNotifierAdapter struct {
+	client *worker.Client
+	logger *slog.Logger
+}
+
+func (a *securityNotifierAdapter) NotifySecurityEvent(ctx context.Context, event auth.SecurityEvent) error {
+	coalesced, err := a.client.SendSecurityAlert(ctx, worker.SecurityAlertPayload{
+		UserID:     event.UserID,
+		Email:      event.Email,
+		Event:      event.Type,
+		IPAddress:  event.IPAddress,
+		UserAgent:  event.UserAgent,
+		Country:    event.Country,
+		OccurredAt: event.OccurredAt,
+
+		FailedAttempts:   event.FailedAttempts,
+		SecureAccountURL: event.SecureAccountURL,
+	})
+	if coalesced {
+		a.logger.InfoContext(ctx, "security alert already queued", slog.String("event", event.Type))
+	}
+	return err
+}
+
+// verificationMailerAdapter sends verification links with the welcome email task
+type verificationMailerAdapter struct {
+	client *worker.Client
+	logger *slog.Logger
+}
+
+func (a *verificationMailerAdapter) SendVerificationEmail(ctx context.Context, u *auth.User, link string) error {
+	coalesced, err := a.client.SendWelcomeEmail(ctx, u.ID.String(), u.Email, "", link)
+	if coalesced {
+		a.logger.InfoContext(ctx, "verification email already queued", slog.String("user_id", u.ID.String()))
+	}
+	return err
+}
+
+// The handlers must keep satisfying the server's typed route registration
+var (
+	_ server.AuthRoutes = (*auth.Handler)(nil)
+	_ server.UserRoutes = (*user.Handler)(nil)
+)
+
 // userRepoAdapter adapts user.Repository to auth.UserRepository
 type userRepoAdapter struct {
 	repo user.Repository
 }
 
 func (a *userRepoAdapter) Create(ctx context.Context, u *auth.User) error {
-	return a.repo.Create(ctx, &user.User{
+	created := &user.User{
 		ID:           u.ID,
 		Email:        u.Email,
 		PasswordHash: u.PasswordHash,
 		Role:         u.Role,
+		Guest:        u.Guest,
 		CreatedAt:    u.CreatedAt,
 		UpdatedAt:    u.UpdatedAt,
-	})
+	}
+	if err := a.repo.Create(ctx, created); err != nil {
+		if errors.Is(err, user.ErrEmailTaken) {
+			return auth.ErrUserAlreadyExists
+		}
+		return err
+	}
+	u.TenantID = created.TenantID
+	return nil
 }
 
 func (a *userRepoAdapter) GetByID(ctx context.Context, id uuid.UUID) (*auth.User, error) {
@@ -156,12 +736,16 @@ func (a *userRepoAdapter) GetByID(ctx context.Context, id uuid.UUID) (*auth.User
 		return nil, err
 	}
 	return &auth.User{
-		ID:           u.ID,
-		Email:        u.Email,
-		PasswordHash: u.PasswordHash,
-		Role:         u.Role,
-		CreatedAt:    u.CreatedAt,
-		UpdatedAt:    u.UpdatedAt,
+		ID:            u.ID,
+		Email:         u.Email,
+		PasswordHash:  u.PasswordHash,
+		Role:          u.Role,
+		TenantID:      u.TenantID,
+		EmailVerified: u.EmailVerified,
+		Guest:         u.Guest,
+		Suspended:     u.Suspended,
+		CreatedAt:     u.CreatedAt,
+		UpdatedAt:     u.UpdatedAt,
 	}, nil
 }
 
@@ -171,17 +755,21 @@ func (a *userRepoAdapter) GetByEmail(ctx context.Context, email string) (*auth.U
 		return nil, err
 	}
 	return &auth.User{
-		ID:           u.ID,
-		Email:        u.Email,
-		PasswordHash: u.PasswordHash,
-		Role:         u.Role,
-		CreatedAt:    u.CreatedAt,
-		UpdatedAt:    u.UpdatedAt,
+		ID:            u.ID,
+		Email:         u.Email,
+		PasswordHash:  u.PasswordHash,
+		Role:          u.Role,
+		TenantID:      u.TenantID,
+		EmailVerified: u.EmailVerified,
+		Guest:         u.Guest,
+		Suspended:     u.Suspended,
+		CreatedAt:     u.CreatedAt,
+		UpdatedAt:     u.UpdatedAt,
 	}, nil
 }
 
 func (a *userRepoAdapter) Update(ctx context.Context, u *auth.User) error {
-	return a.repo.Update(ctx, &user.User{
+	err := a.repo.Update(ctx, &user.User{
 		ID:           u.ID,
 		Email:        u.Email,
 		PasswordHash: u.PasswordHash,
@@ -189,8 +777,28 @@ func (a *userRepoAdapter) Update(ctx context.Context, u *auth.User) error {
 		CreatedAt:    u.CreatedAt,
 		UpdatedAt:    u.UpdatedAt,
 	})
+	if errors.Is(err, user.ErrEmailTaken) {
+		return auth.ErrUserAlreadyExists
+	}
+	return err
+}
+
+func (a *userRepoAdapter) UpdatePassword(ctx context.Context, id uuid.UUID, oldHash, newHash string) (bool, error) {
+	return a.repo.UpdatePassword(ctx, id, oldHash, newHash)
 }
 
 func (a *userRepoAdapter) Delete(ctx context.Context, id uuid.UUID) error {
 	return a.repo.Delete(ctx, id)
 }
+
+func (a *userRepoAdapter) MarkEmailVerified(ctx context.Context, id uuid.UUID) error {
+	return a.repo.MarkEmailVerified(ctx, id)
+}
+
+func (a *userRepoAdapter) UpgradeGuest(ctx context.Context, id uuid.UUID, email, passwordHash string) error {
+	err := a.repo.UpgradeGuest(ctx, id, email, passwordHash)
+	if errors.Is(err, user.ErrEmailTaken) {
+		return auth.ErrUserAlreadyExists
+	}
+	return err
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"time"
+
+	"github.com/pixperk/goiler/internal/config"
+	"github.com/pixperk/goiler/internal/doctor"
+)
+
+// runDoctor checks the services in the environment's config and prints a
+// pass/fail report; it fails if any check does
+func runDoctor(args []string) error {
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	migrations := fs.String("migrations", "db/migrations", "directory of the migrations the database should be up to date with")
+	timeout := fs.Duration("timeout", 5*time.Second, "time limit for each check")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	checker := doctor.New(config.Load(), doctor.Options{MigrationsDir: *migrations})
+	defer checker.Close()
+
+	results := doctor.Run(context.Background(), checker.Checks(), *timeout)
+	if err := doctor.Write(os.Stdout, results); err != nil {
+		return err
+	}
+	if !doctor.Passed(results) {
+		return errors.New("checks failed")
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/pixperk/goiler/internal/config"
+)
+
+// runEnv generates .env.example and a JSON Schema of the environment from
+// the config structs
+func runEnv(args []string) error {
+	fs := flag.NewFlagSet("env", flag.ContinueOnError)
+	source := fs.String("source", "internal/config/config.go", "Go file declaring the config structs, read for descriptions")
+	example := fs.String("example", ".env.example", "where to write the example environment; empty skips it")
+	schema := fs.String("schema", "env.schema.json", "where to write the JSON Schema; empty skips it")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	docs, err := config.ReadFieldDocs(*source)
+	if err != nil {
+		return fmt.Errorf("read config docs: %w", err)
+	}
+	vars, err := config.EnvVars(docs)
+	if err != nil {
+		return err
+	}
+
+	if *example != "" {
+		var buf bytes.Buffer
+		if err := config.WriteEnvExample(&buf, vars); err != nil {
+			return err
+		}
+		if err := os.WriteFile(*example, buf.Bytes(), 0o644); err != nil {
+			return err
+		}
+		fmt.Printf("wrote %s (%d variables)\n", *example, len(vars))
+	}
+
+	if *schema != "" {
+		data, err := config.EnvJSONSchema(vars)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(*schema, data, 0o644); err != nil {
+			return err
+		}
+		fmt.Printf("wrote %s\n", *schema)
+	}
+	return nil
+}
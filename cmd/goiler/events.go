@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/pixperk/goiler/pkg/events"
+)
+
+// runEvents prints the event catalog, each topic with its description and
+// payload JSON Schema, for consumers outside the process
+func runEvents(args []string) error {
+	fs := flag.NewFlagSet("events", flag.ContinueOnError)
+	out := fs.String("out", "", "where to write the catalog; empty prints it")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(events.Catalog(), "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if *out == "" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(*out, data, 0o644); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %s (%d topics)\n", *out, len(events.Catalog()))
+	return nil
+}
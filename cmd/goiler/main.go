@@ -0,0 +1,65 @@
+// Command goiler holds development tooling for goiler projects:
+//
+//	goiler sdk [flags]      generate TypeScript and Go API clients
+//	goiler doctor [flags]   check the configured services before a deploy
+//	goiler env [flags]      generate .env.example and its JSON Schema
+//	goiler events [flags]   print the event catalog with payload schemas
+//	goiler scaffold [flags] <name> <field:type>...
+//	                        generate a CRUD module laid out like internal/ticket
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// command is a goiler subcommand; run receives the arguments after its name
+type command struct {
+	summary string
+	run     func(args []string) error
+}
+
+var commands = map[string]command{
+	"doctor":   {summary: "Check the database, Redis, queue, OTEL and token keys in the loaded config", run: runDoctor},
+	"env":      {summary: "Generate .env.example and env.schema.json from the config structs", run: runEnv},
+	"events":   {summary: "Print the pubsub event catalog with JSON Schemas of the payloads", run: runEvents},
+	"scaffold": {summary: "Generate a CRUD module: migration, queries, repository, service, handler, routes and tests", run: runScaffold},
+	"sdk":      {summary: "Generate TypeScript and Go API clients from the OpenAPI document", run: runSDK},
+}
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] == "-h" || os.Args[1] == "--help" || os.Args[1] == "help" {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "goiler: unknown command %q\n\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+
+	if err := cmd.run(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "goiler %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: goiler <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].summary)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, `Run "goiler <command> -h" for a command's flags.`)
+}
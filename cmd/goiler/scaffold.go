@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/pixperk/goiler/internal/scaffold"
+)
+
+// runScaffold generates a CRUD module, laid out like internal/ticket, from a
+// name and its fields
+func runScaffold(args []string) error {
+	fs := flag.NewFlagSet("scaffold", flag.ContinueOnError)
+	root := fs.String("root", ".", "project root, where go.mod is")
+	plural := fs.String("plural", "", "table name and plural, if not the name with an s appended")
+	dryRun := fs.Bool("dry-run", false, "list the files without writing them")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: goiler scaffold [flags] <name> <field:type>...")
+		fmt.Fprintln(fs.Output())
+		fmt.Fprintln(fs.Output(), "Example: goiler scaffold product name:string description:text price:float in_stock:bool")
+		fmt.Fprintf(fs.Output(), "Field types: %s\n\n", strings.Join(scaffold.Kinds(), ", "))
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return errors.New("a name and at least one field are required")
+	}
+
+	module := &scaffold.Module{Name: fs.Arg(0), Plural: *plural}
+	for _, arg := range fs.Args()[1:] {
+		field, err := scaffold.ParseField(arg)
+		if err != nil {
+			return err
+		}
+		module.Fields = append(module.Fields, field)
+	}
+
+	var err error
+	if module.ModulePath, err = scaffold.ModulePath(*root); err != nil {
+		return fmt.Errorf("read module path: %w", err)
+	}
+	if module.Migration, err = scaffold.NextMigration(filepath.Join(*root, "db", "migrations")); err != nil {
+		return fmt.Errorf("number migration: %w", err)
+	}
+
+	files, err := scaffold.Generate(module)
+	if err != nil {
+		return err
+	}
+	if !*dryRun {
+		if err := scaffold.Write(*root, files); err != nil {
+			return err
+		}
+	}
+	for _, f := range files {
+		fmt.Println("wrote", f.Path)
+	}
+
+	fmt.Printf(`
+Next steps:
+  1. make migrate-up, then make sqlc-generate to regenerate db/sqlc from
+     db/queries/%s.sql
+  2. wire the module in cmd/api/main.go:
+
+	%sService := %s.NewService(%s.NewPostgresRepository(dbpool),
+		%s.WithLogger(logs.For("%s")),
+		%s.WithClock(clk),
+		%s.WithIDGenerator(ids),
+	)
+	srv.Mount(protected, %s.NewHandler(%sService, logs.For("%s")))
+`, module.Name, module.Var(), module.Package(), module.Package(),
+		module.Package(), module.Package(), module.Package(), module.Package(),
+		module.Package(), module.Var(), module.Package())
+	return nil
+}
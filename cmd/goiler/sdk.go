@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pixperk/goiler/internal/sdkgen"
+)
+
+// runSDK generates API clients from the OpenAPI document served by a running
+// API (or a swagger.json on disk)
+func runSDK(args []string) error {
+	fs := flag.NewFlagSet("sdk", flag.ContinueOnError)
+	spec := fs.String("spec", "http://localhost:8080/swagger/doc.json", "OpenAPI document URL or file")
+	out := fs.String("out", "sdk", "output directory")
+	langs := fs.String("lang", "ts,go", "comma-separated languages to generate (ts, go)")
+	goPackage := fs.String("go-package", "client", "package name of the Go client")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	data, err := sdkgen.Load(context.Background(), *spec)
+	if err != nil {
+		return fmt.Errorf("load OpenAPI document: %w", err)
+	}
+	api, err := sdkgen.Parse(data)
+	if err != nil {
+		return err
+	}
+
+	for _, lang := range strings.Split(*langs, ",") {
+		var (
+			path string
+			src  []byte
+		)
+		switch strings.TrimSpace(lang) {
+		case "ts", "typescript":
+			path = filepath.Join(*out, "typescript", "client.ts")
+			src, err = sdkgen.TypeScript(api)
+		case "go":
+			path = filepath.Join(*out, "go", *goPackage, "client.go")
+			src, err = sdkgen.Go(api, *goPackage)
+		default:
+			return fmt.Errorf("unknown language %q", lang)
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, src, 0o644); err != nil {
+			return err
+		}
+		fmt.Printf("wrote %s (%d operations, %d types)\n", path, len(api.Operations), len(api.Schemas))
+	}
+	return nil
+}
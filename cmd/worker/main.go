@@ -7,26 +7,42 @@ import (
 	"os/signal"
 	"syscall"
 
+	"github.com/pixperk/goiler/internal/auth"
 	"github.com/pixperk/goiler/internal/config"
+	"github.com/pixperk/goiler/internal/emaillog"
+	"github.com/pixperk/goiler/internal/privacy"
+	"github.com/pixperk/goiler/internal/user"
+	"github.com/pixperk/goiler/internal/websocket"
 	"github.com/pixperk/goiler/internal/worker"
+	"github.com/pixperk/goiler/pkg/dbconn"
+	"github.com/pixperk/goiler/pkg/logging"
 	"github.com/pixperk/goiler/pkg/otel"
+	"github.com/pixperk/goiler/pkg/pubsub"
+	"github.com/pixperk/goiler/pkg/redisconn"
+	"github.com/pixperk/goiler/pkg/storage"
+	"github.com/pixperk/goiler/pkg/waitfor"
 )
 
 func main() {
-	// Initialize logger
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-		Level: slog.LevelInfo,
-	}))
+	// Load configuration
+	cfg := config.Load()
+
+	// Initialize loggers
+	logs := logging.New(cfg.Log)
+	logger := logs.Logger()
 	slog.SetDefault(logger)
 
 	logger.Info("starting worker")
 
-	// Load configuration
-	cfg := config.Load()
-
 	// Initialize context
 	ctx := context.Background()
 
+	// Wait for the services in WAIT_FOR, e.g. while containers start
+	if err := waitfor.Dependencies(ctx, cfg, logs.For("startup")); err != nil {
+		logger.Error("dependencies not ready", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
 	// Initialize OpenTelemetry
 	tracerProvider, err := otel.NewTracerProvider(ctx, cfg, logger)
 	if err != nil {
@@ -36,20 +52,96 @@ func main() {
 	defer tracerProvider.Shutdown(ctx)
 
 	// Create worker server
-	srv := worker.NewServer(cfg, logger)
-
-	// Handle shutdown signals
-	go func() {
-		quit := make(chan os.Signal, 1)
-		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-		<-quit
-		logger.Info("shutting down worker")
-		srv.Shutdown()
-	}()
-
-	// Start worker server
+	if err := redisconn.Validate(cfg.Redis); err != nil {
+		logger.Error("invalid redis config", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	if err := worker.SetPayloadCodec(cfg.Worker.PayloadCodec); err != nil {
+		logger.Error("invalid worker config", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	srv := worker.NewServer(cfg, logs.For("worker"))
+
+	// Token cleanup tasks need the token store's database, and the email
+	// log, privacy requests and guests are kept in it
+	purgeGuests := cfg.Auth.GuestAccounts && cfg.Worker.GuestRetention > 0
+	if cfg.Auth.TokenStore == auth.TokenStorePostgres || cfg.Worker.EmailLog || cfg.Privacy.Enabled || purgeGuests {
+		// Tasks run under the worker's statement timeout, not the API's
+		dbCfg := cfg.Database
+		dbCfg.StatementTimeout = cfg.Database.WorkerStatementTimeout
+		if err := dbconn.Validate(dbCfg); err != nil {
+			logger.Error("invalid database config", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		dbTracer := otel.NewDBTracingWrapper(cfg.OTEL.ServiceName, nil, otel.DBTracingOptions(cfg.OTEL.DB)...)
+		dbpool, err := dbconn.NewPool(ctx, dbCfg, dbconn.WithTracer(dbTracer))
+		if err != nil {
+			logger.Error("failed to connect to database", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		defer dbpool.Close()
+		if cfg.Auth.TokenStore == auth.TokenStorePostgres {
+			srv.SetTokenPurger(auth.NewPostgresTokenRepository(dbpool))
+		}
+		if cfg.Worker.EmailLog {
+			srv.SetEmailLog(emaillog.NewService(emaillog.NewPostgresRepository(dbpool),
+				emaillog.WithLogger(logs.For("emaillog")),
+			))
+		}
+		// Erasures and guest purges delete avatar images
+		var store storage.Store
+		if cfg.Privacy.Enabled || purgeGuests {
+			store, err = storage.New(cfg.Storage)
+			if err != nil {
+				logger.Error("invalid storage config", slog.String("error", err.Error()))
+				os.Exit(1)
+			}
+		}
+		if purgeGuests {
+			srv.SetGuestPurger(user.NewGuestPurger(user.NewPostgresRepository(dbpool), store, logs.For("user")))
+		}
+		if cfg.Privacy.Enabled {
+			// Erasures also end sessions kept in Redis
+			opts := []privacy.ServiceOption{
+				privacy.WithLogger(logs.For("privacy")),
+				privacy.WithExportRetention(cfg.Privacy.ExportRetention),
+				privacy.WithObjectStore(store),
+			}
+			if cfg.Auth.Mode == auth.AuthModeCookie && cfg.Auth.Session.Store == auth.SessionStoreRedis {
+				sessionRedis := redisconn.NewClient(cfg.Redis)
+				defer sessionRedis.Close()
+				opts = append(opts, privacy.WithSessions(auth.NewRedisSessionStore(sessionRedis)))
+			}
+			srv.SetPrivacy(privacy.NewService(privacy.NewPostgresRepository(dbpool), opts...))
+		}
+	}
+
+	// Tell users how their tasks are doing over their WebSocket connections
+	// to the API, through the WebSocket broker
+	sender, err := websocket.Sender(cfg.WebSocket, cfg.Redis, logs.For("websocket"))
+	if err != nil {
+		logger.Error("invalid websocket config", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	if sender != nil {
+		bus := pubsub.New(logs.For("pubsub"), 100)
+		go websocket.RelayTaskProgress(ctx, bus, sender, logs.For("websocket"))
+		srv.SetProgressPublisher(bus)
+	}
+
+	// Register periodic tasks besides the built-in ones here, e.g.
+	// srv.Schedule("nightly_reports", "0 2 * * *", task, asynq.Queue("low"))
+
+	// Start worker server and scheduler
 	if err := srv.Start(); err != nil {
 		logger.Error("worker error", slog.String("error", err.Error()))
 		os.Exit(1)
 	}
+
+	// Wait for a shutdown signal
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+	logger.Info("shutting down worker")
+	srv.Shutdown()
 }
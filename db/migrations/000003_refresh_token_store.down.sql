@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_refresh_tokens_revoked_at;
+
+DELETE FROM refresh_tokens WHERE token_hash IS NULL;
+ALTER TABLE refresh_tokens ALTER COLUMN token_hash SET NOT NULL;
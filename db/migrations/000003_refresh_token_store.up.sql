@@ -0,0 +1,6 @@
+-- Refresh tokens are tracked by their ID (the token's jti), so the raw
+-- token never needs to be stored or hashed
+ALTER TABLE refresh_tokens ALTER COLUMN token_hash DROP NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_revoked_at ON refresh_tokens(revoked_at)
+    WHERE revoked_at IS NOT NULL;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS policy_acceptances;
+DROP TABLE IF EXISTS policies;
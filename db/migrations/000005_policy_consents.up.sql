@@ -0,0 +1,26 @@
+-- Versioned policies (terms of service, privacy policy, ...) users accept.
+-- A new version of a kind supersedes older ones once it takes effect.
+CREATE TABLE IF NOT EXISTS policies (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    kind VARCHAR(50) NOT NULL,
+    version VARCHAR(50) NOT NULL,
+    title VARCHAR(255) NOT NULL DEFAULT '',
+    url TEXT NOT NULL DEFAULT '',
+    mandatory BOOLEAN NOT NULL DEFAULT TRUE,
+    effective_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (kind, version)
+);
+
+-- One row per user and accepted policy version, with the client it was
+-- accepted from
+CREATE TABLE IF NOT EXISTS policy_acceptances (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    policy_id UUID NOT NULL REFERENCES policies(id) ON DELETE CASCADE,
+    accepted_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    ip_address VARCHAR(45) NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (user_id, policy_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_policy_acceptances_policy_id ON policy_acceptances(policy_id);
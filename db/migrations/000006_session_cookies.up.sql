@@ -0,0 +1,6 @@
+-- Server-side sessions for cookie authentication (AUTH_MODE=cookie). Each
+-- session carries the CSRF token unsafe requests must echo, and when it was
+-- last used so idle sessions can be expired.
+ALTER TABLE sessions
+    ADD COLUMN IF NOT EXISTS csrf_token VARCHAR(64) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
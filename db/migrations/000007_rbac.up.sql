@@ -0,0 +1,12 @@
+-- Permissions granted by each role, used when RBAC_SOURCE=postgres. A
+-- permission is "resource:action", "resource:*" or "*" for everything.
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role VARCHAR(50) NOT NULL,
+    permission VARCHAR(100) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (role, permission)
+);
+
+INSERT INTO role_permissions (role, permission)
+VALUES ('admin', '*')
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS sync_user_identities ON user_identities;
+DROP TRIGGER IF EXISTS sync_users ON users;
+DROP FUNCTION IF EXISTS record_sync_change();
+DROP TABLE IF EXISTS sync_changes;
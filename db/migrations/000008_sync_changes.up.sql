@@ -0,0 +1,76 @@
+-- Change log for offline sync. Every change to data a user syncs is
+-- appended here in the same transaction as the change itself, so seq gives
+-- clients a resumable cursor.
+CREATE TABLE IF NOT EXISTS sync_changes (
+    seq BIGSERIAL PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    entity VARCHAR(64) NOT NULL,
+    entity_id VARCHAR(255) NOT NULL,
+    op VARCHAR(16) NOT NULL CHECK (op IN ('upsert', 'delete')),
+    data JSONB,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_sync_changes_user_seq ON sync_changes(user_id, seq);
+CREATE INDEX IF NOT EXISTS idx_sync_changes_created_at ON sync_changes(created_at);
+
+-- Records a row change in sync_changes. Arguments: the entity name, the
+-- column holding the owning user's ID, then any columns to leave out of the
+-- synced data. Writers take a transaction-scoped advisory lock so changes
+-- commit in seq order and readers never skip one that commits late.
+CREATE OR REPLACE FUNCTION record_sync_change()
+RETURNS TRIGGER AS $$
+DECLARE
+    row_data JSONB;
+    old_data JSONB;
+    owner UUID;
+    i INT;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        row_data := to_jsonb(OLD);
+    ELSE
+        row_data := to_jsonb(NEW);
+    END IF;
+    FOR i IN 2 .. TG_NARGS - 1 LOOP
+        row_data := row_data - TG_ARGV[i];
+    END LOOP;
+
+    IF TG_OP = 'UPDATE' THEN
+        old_data := to_jsonb(OLD);
+        FOR i IN 2 .. TG_NARGS - 1 LOOP
+            old_data := old_data - TG_ARGV[i];
+        END LOOP;
+        -- Only excluded columns (e.g. password_hash) and updated_at changed
+        IF (row_data - 'updated_at') = (old_data - 'updated_at') THEN
+            RETURN NULL;
+        END IF;
+    END IF;
+
+    owner := (row_data->>TG_ARGV[1])::uuid;
+    -- The owner is being deleted; their change log goes with them
+    IF NOT EXISTS (SELECT 1 FROM users WHERE id = owner) THEN
+        RETURN NULL;
+    END IF;
+
+    PERFORM pg_advisory_xact_lock(hashtext('sync_changes'));
+    INSERT INTO sync_changes (user_id, entity, entity_id, op, data)
+    VALUES (
+        owner,
+        TG_ARGV[0],
+        row_data->>'id',
+        CASE WHEN TG_OP = 'DELETE' THEN 'delete' ELSE 'upsert' END,
+        CASE WHEN TG_OP = 'DELETE' THEN NULL ELSE row_data END
+    );
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER sync_users
+    AFTER INSERT OR UPDATE ON users
+    FOR EACH ROW
+    EXECUTE FUNCTION record_sync_change('user', 'id', 'password_hash');
+
+CREATE TRIGGER sync_user_identities
+    AFTER INSERT OR UPDATE OR DELETE ON user_identities
+    FOR EACH ROW
+    EXECUTE FUNCTION record_sync_change('identity', 'user_id');
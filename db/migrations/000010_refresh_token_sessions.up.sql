@@ -0,0 +1,17 @@
+-- Refresh tokens issued from the same login share a family, which users see
+-- and revoke as a session. Rotated tokens keep the family and its start time,
+-- and record the device they were last issued to.
+ALTER TABLE refresh_tokens
+    ADD COLUMN IF NOT EXISTS family_id UUID,
+    ADD COLUMN IF NOT EXISTS user_agent TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS ip_address VARCHAR(45) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS session_started_at TIMESTAMPTZ;
+
+UPDATE refresh_tokens SET family_id = id, session_started_at = created_at;
+
+ALTER TABLE refresh_tokens
+    ALTER COLUMN family_id SET NOT NULL,
+    ALTER COLUMN session_started_at SET NOT NULL,
+    ALTER COLUMN session_started_at SET DEFAULT NOW();
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family_id ON refresh_tokens(family_id);
@@ -0,0 +1,24 @@
+-- Tenants partition users. Existing users move to the default tenant, which
+-- is also where requests without a tenant land while tenancy is off.
+CREATE TABLE IF NOT EXISTS tenants (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    slug VARCHAR(63) NOT NULL UNIQUE,
+    name VARCHAR(255) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+INSERT INTO tenants (id, slug, name)
+VALUES ('00000000-0000-0000-0000-000000000001', 'default', 'Default')
+ON CONFLICT (id) DO NOTHING;
+
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS tenant_id UUID NOT NULL
+    DEFAULT '00000000-0000-0000-0000-000000000001'
+    REFERENCES tenants(id) ON DELETE CASCADE;
+
+-- New users must name their tenant
+ALTER TABLE users ALTER COLUMN tenant_id DROP DEFAULT;
+
+-- Emails are unique per tenant rather than globally
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_key;
+ALTER TABLE users ADD CONSTRAINT users_tenant_email_key UNIQUE (tenant_id, email);
@@ -0,0 +1,5 @@
+ALTER TABLE sessions DROP COLUMN IF EXISTS org_id;
+
+DROP TABLE IF EXISTS org_invitations;
+DROP TABLE IF EXISTS org_memberships;
+DROP TABLE IF EXISTS organizations;
@@ -0,0 +1,4 @@
+DROP FUNCTION IF EXISTS drop_request_archive_partitions(TIMESTAMPTZ);
+DROP FUNCTION IF EXISTS create_request_archive_partition(TIMESTAMPTZ);
+DROP TABLE IF EXISTS request_archive;
+DROP FUNCTION IF EXISTS reject_request_archive_change();
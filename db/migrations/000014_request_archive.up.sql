@@ -0,0 +1,83 @@
+-- Requests to regulated routes are archived for compliance. The archive is
+-- append-only: rows can't be updated or deleted, only whole monthly
+-- partitions dropped once they are past retention.
+CREATE TABLE IF NOT EXISTS request_archive (
+    id UUID NOT NULL,
+    request_id VARCHAR(64) NOT NULL DEFAULT '',
+    tenant_id UUID NOT NULL,
+    user_id UUID,
+    method VARCHAR(10) NOT NULL,
+    route VARCHAR(255) NOT NULL,
+    path TEXT NOT NULL,
+    query TEXT NOT NULL DEFAULT '',
+    status INTEGER NOT NULL,
+    latency_ms INTEGER NOT NULL,
+    ip_address VARCHAR(45) NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    body JSONB,
+    body_truncated BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (created_at, id)
+) PARTITION BY RANGE (created_at);
+
+CREATE INDEX IF NOT EXISTS idx_request_archive_user_id ON request_archive(user_id, created_at);
+
+CREATE OR REPLACE FUNCTION reject_request_archive_change()
+RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'request_archive is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER request_archive_append_only
+    BEFORE UPDATE OR DELETE ON request_archive
+    FOR EACH ROW
+    EXECUTE FUNCTION reject_request_archive_change();
+
+CREATE TRIGGER request_archive_no_truncate
+    BEFORE TRUNCATE ON request_archive
+    FOR EACH STATEMENT
+    EXECUTE FUNCTION reject_request_archive_change();
+
+-- Creates the partition request_archive_YYYYMM holding the month of at, if
+-- it doesn't exist yet
+CREATE OR REPLACE FUNCTION create_request_archive_partition(at TIMESTAMPTZ)
+RETURNS VOID AS $$
+DECLARE
+    month_start TIMESTAMPTZ := date_trunc('month', at AT TIME ZONE 'UTC') AT TIME ZONE 'UTC';
+BEGIN
+    EXECUTE format(
+        'CREATE TABLE IF NOT EXISTS %I PARTITION OF request_archive FOR VALUES FROM (%L) TO (%L)',
+        'request_archive_' || to_char(month_start AT TIME ZONE 'UTC', 'YYYYMM'),
+        month_start,
+        month_start + INTERVAL '1 month'
+    );
+END;
+$$ LANGUAGE plpgsql;
+
+-- Drops the partitions whose whole month is before the given time and
+-- returns how many were dropped
+CREATE OR REPLACE FUNCTION drop_request_archive_partitions(before TIMESTAMPTZ)
+RETURNS INTEGER AS $$
+DECLARE
+    partition_name TEXT;
+    dropped INTEGER := 0;
+BEGIN
+    FOR partition_name IN
+        SELECT c.relname
+        FROM pg_inherits i
+        JOIN pg_class c ON c.oid = i.inhrelid
+        WHERE i.inhparent = 'request_archive'::regclass
+          AND c.relname ~ '^request_archive_[0-9]{6}$'
+    LOOP
+        IF (to_date(right(partition_name, 6), 'YYYYMM') + INTERVAL '1 month') AT TIME ZONE 'UTC' <= before THEN
+            EXECUTE format('DROP TABLE %I', partition_name);
+            dropped := dropped + 1;
+        END IF;
+    END LOOP;
+    RETURN dropped;
+END;
+$$ LANGUAGE plpgsql;
+
+SELECT create_request_archive_partition(NOW());
+SELECT create_request_archive_partition(NOW() + INTERVAL '1 month');
@@ -0,0 +1,6 @@
+DELETE FROM users WHERE is_guest;
+
+DROP INDEX IF EXISTS users_tenant_email_key;
+ALTER TABLE users ADD CONSTRAINT users_tenant_email_key UNIQUE (tenant_id, email);
+
+ALTER TABLE users DROP COLUMN IF EXISTS is_guest;
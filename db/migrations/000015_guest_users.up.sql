@@ -0,0 +1,6 @@
+-- Guest users have no email or password until they upgrade, so emails are
+-- only unique among full accounts
+ALTER TABLE users ADD COLUMN IF NOT EXISTS is_guest BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_tenant_email_key;
+CREATE UNIQUE INDEX IF NOT EXISTS users_tenant_email_key ON users(tenant_id, email) WHERE NOT is_guest;
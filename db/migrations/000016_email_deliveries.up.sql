@@ -0,0 +1,17 @@
+-- Transactional emails handled by the worker, one row per task updated on
+-- each attempt, so support can see what a user was sent
+CREATE TABLE IF NOT EXISTS email_deliveries (
+    id UUID PRIMARY KEY,
+    task_id VARCHAR(64) NOT NULL UNIQUE,
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    email VARCHAR(255) NOT NULL,
+    type VARCHAR(50) NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    error TEXT,
+    attempts INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_email_deliveries_user_id ON email_deliveries(user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_email_deliveries_created_at ON email_deliveries(created_at);
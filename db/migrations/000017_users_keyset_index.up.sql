@@ -0,0 +1,2 @@
+-- Serves keyset pages of a tenant's users, newest first
+CREATE INDEX IF NOT EXISTS idx_users_tenant_created_at ON users(tenant_id, created_at DESC, id DESC);
@@ -0,0 +1,19 @@
+-- Data export and erasure requests, processed by the worker. Completed
+-- exports keep their archive until expires_at.
+CREATE TABLE IF NOT EXISTS privacy_requests (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    type VARCHAR(20) NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    error TEXT,
+    archive BYTEA,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    completed_at TIMESTAMPTZ,
+    expires_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_privacy_requests_user_id ON privacy_requests(user_id, created_at DESC);
+-- At most one open request of each type per user
+CREATE UNIQUE INDEX IF NOT EXISTS idx_privacy_requests_open ON privacy_requests(user_id, type)
+    WHERE status IN ('pending', 'processing');
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS user_audit;
+DROP FUNCTION IF EXISTS reject_user_audit_change();
@@ -0,0 +1,43 @@
+-- Account activity users can review: profile updates, password changes and
+-- deletions. Rows outlive their user, so deletions stay on record, and are
+-- append-only except that erasure may blank the client IP and user agent.
+CREATE TABLE IF NOT EXISTS user_audit (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL,
+    tenant_id UUID NOT NULL,
+    action VARCHAR(50) NOT NULL,
+    fields TEXT[] NOT NULL DEFAULT '{}',
+    ip_address VARCHAR(45) NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_audit_user_id ON user_audit(user_id, created_at DESC, id DESC);
+
+CREATE OR REPLACE FUNCTION reject_user_audit_change()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'UPDATE'
+        AND NEW.id = OLD.id
+        AND NEW.user_id = OLD.user_id
+        AND NEW.tenant_id = OLD.tenant_id
+        AND NEW.action = OLD.action
+        AND NEW.fields = OLD.fields
+        AND NEW.created_at = OLD.created_at
+        AND NEW.ip_address = ''
+        AND NEW.user_agent = '' THEN
+        RETURN NEW;
+    END IF;
+    RAISE EXCEPTION 'user_audit is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER user_audit_append_only
+    BEFORE UPDATE OR DELETE ON user_audit
+    FOR EACH ROW
+    EXECUTE FUNCTION reject_user_audit_change();
+
+CREATE TRIGGER user_audit_no_truncate
+    BEFORE TRUNCATE ON user_audit
+    FOR EACH STATEMENT
+    EXECUTE FUNCTION reject_user_audit_change();
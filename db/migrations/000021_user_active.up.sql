@@ -0,0 +1,2 @@
+-- Suspended accounts keep their data but can't log in until reactivated
+ALTER TABLE users ADD COLUMN IF NOT EXISTS is_active BOOLEAN NOT NULL DEFAULT TRUE;
@@ -0,0 +1,15 @@
+-- Security incidents declared by admins after a credential leak. Tokens and
+-- sessions issued before an incident's not_before are rejected for users
+-- with its role, or for everyone when role is empty. keys_rotated records
+-- that signing switched to the next scheduled key at not_before.
+CREATE TABLE IF NOT EXISTS auth_incidents (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    role VARCHAR(50) NOT NULL DEFAULT '',
+    reason TEXT NOT NULL DEFAULT '',
+    keys_rotated BOOLEAN NOT NULL DEFAULT FALSE,
+    revoked_tokens BIGINT NOT NULL DEFAULT 0,
+    declared_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    not_before TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_auth_incidents_not_before ON auth_incidents(not_before);
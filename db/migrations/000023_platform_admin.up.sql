@@ -0,0 +1,6 @@
+-- Platform admins operate the whole deployment: "*" grants everything
+-- within a tenant, and "platform:*" tenant management and cross-tenant
+-- reads, which "*" doesn't grant.
+INSERT INTO role_permissions (role, permission)
+VALUES ('platform_admin', '*'), ('platform_admin', 'platform:*')
+ON CONFLICT DO NOTHING;
@@ -0,0 +1 @@
+ALTER TABLE tenants DROP COLUMN IF EXISTS sandbox_of;
@@ -0,0 +1,12 @@
+-- Sandbox tenants name the tenant whose sandbox data they hold, so a slug
+-- can't turn an ordinary tenant into one. Each tenant has at most one.
+ALTER TABLE tenants
+    ADD COLUMN IF NOT EXISTS sandbox_of UUID UNIQUE
+    REFERENCES tenants(id) ON DELETE CASCADE;
+
+-- Sandboxes set up before the column were named <slug>-sandbox
+UPDATE tenants sandbox
+SET sandbox_of = live.id
+FROM tenants live
+WHERE sandbox.slug = live.slug || '-sandbox'
+  AND sandbox.sandbox_of IS NULL;
@@ -0,0 +1,55 @@
+-- The change log lock was global, serializing every write to synced tables.
+-- It is now taken per owning user.
+
+-- Records a row change in sync_changes. Arguments: the entity name, the
+-- column holding the owning user's ID, then any columns to leave out of the
+-- synced data. Writers take a transaction-scoped advisory lock on the owner,
+-- so each user's changes commit in seq order and readers never skip one
+-- that commits late, while different users' writes don't wait on each
+-- other.
+CREATE OR REPLACE FUNCTION record_sync_change()
+RETURNS TRIGGER AS $$
+DECLARE
+    row_data JSONB;
+    old_data JSONB;
+    owner UUID;
+    i INT;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        row_data := to_jsonb(OLD);
+    ELSE
+        row_data := to_jsonb(NEW);
+    END IF;
+    FOR i IN 2 .. TG_NARGS - 1 LOOP
+        row_data := row_data - TG_ARGV[i];
+    END LOOP;
+
+    IF TG_OP = 'UPDATE' THEN
+        old_data := to_jsonb(OLD);
+        FOR i IN 2 .. TG_NARGS - 1 LOOP
+            old_data := old_data - TG_ARGV[i];
+        END LOOP;
+        -- Only excluded columns (e.g. password_hash) and updated_at changed
+        IF (row_data - 'updated_at') = (old_data - 'updated_at') THEN
+            RETURN NULL;
+        END IF;
+    END IF;
+
+    owner := (row_data->>TG_ARGV[1])::uuid;
+    -- The owner is being deleted; their change log goes with them
+    IF NOT EXISTS (SELECT 1 FROM users WHERE id = owner) THEN
+        RETURN NULL;
+    END IF;
+
+    PERFORM pg_advisory_xact_lock(hashtext('sync_changes'), hashtext(owner::text));
+    INSERT INTO sync_changes (user_id, entity, entity_id, op, data)
+    VALUES (
+        owner,
+        TG_ARGV[0],
+        row_data->>'id',
+        CASE WHEN TG_OP = 'DELETE' THEN 'delete' ELSE 'upsert' END,
+        CASE WHEN TG_OP = 'DELETE' THEN NULL ELSE row_data END
+    );
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,17 @@
+-- name: GetUserAvatar :one
+SELECT user_id, key, content_type, size_bytes, updated_at
+FROM user_avatars
+WHERE user_id = $1;
+
+-- name: UpsertUserAvatar :exec
+INSERT INTO user_avatars (user_id, key, content_type, size_bytes, updated_at)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (user_id) DO UPDATE
+SET key = EXCLUDED.key, content_type = EXCLUDED.content_type,
+    size_bytes = EXCLUDED.size_bytes, updated_at = EXCLUDED.updated_at;
+
+-- name: DeleteUserAvatar :one
+-- Returns the deleted avatar's key so its object can be deleted too
+DELETE FROM user_avatars
+WHERE user_id = $1
+RETURNING key;
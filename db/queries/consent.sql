@@ -0,0 +1,54 @@
+-- name: CreatePolicy :exec
+INSERT INTO policies (id, kind, version, title, url, mandatory, effective_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7);
+
+-- name: GetPolicy :one
+SELECT id, kind, version, title, url, mandatory, effective_at, created_at
+FROM policies
+WHERE id = $1;
+
+-- name: ListPolicies :many
+SELECT id, kind, version, title, url, mandatory, effective_at, created_at
+FROM policies
+ORDER BY kind, effective_at DESC;
+
+-- name: CreatePolicyAcceptance :execrows
+INSERT INTO policy_acceptances (user_id, policy_id, accepted_at, ip_address, user_agent)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (user_id, policy_id) DO NOTHING;
+
+-- name: ListUserPolicyAcceptances :many
+-- A null tenant_id finds acceptances of a user in any tenant.
+SELECT user_id, policy_id, accepted_at, ip_address, user_agent
+FROM policy_acceptances
+WHERE user_id = @user_id
+  AND (sqlc.narg(tenant_id)::uuid IS NULL OR user_id IN (SELECT id FROM users WHERE tenant_id = sqlc.narg(tenant_id)))
+ORDER BY accepted_at;
+
+-- name: CountPolicyAcceptances :one
+-- A null tenant_id counts acceptances by users in every tenant.
+SELECT COUNT(*) FROM policy_acceptances
+WHERE policy_id = @policy_id
+  AND (sqlc.narg(tenant_id)::uuid IS NULL OR user_id IN (SELECT id FROM users WHERE tenant_id = sqlc.narg(tenant_id)));
+
+-- name: ListPolicyAcceptances :many
+-- A null tenant_id lists acceptances by users in every tenant.
+SELECT a.user_id, u.email, a.accepted_at, a.ip_address, a.user_agent
+FROM policy_acceptances a
+JOIN users u ON u.id = a.user_id
+WHERE a.policy_id = @policy_id
+  AND (sqlc.narg(tenant_id)::uuid IS NULL OR u.tenant_id = sqlc.narg(tenant_id))
+ORDER BY a.accepted_at DESC
+LIMIT @lim OFFSET @off;
+
+-- name: ListUsersWithoutPolicyAcceptance :many
+-- A null tenant_id lists users in every tenant.
+SELECT u.id, u.email, u.created_at
+FROM users u
+WHERE NOT EXISTS (
+    SELECT 1 FROM policy_acceptances a
+    WHERE a.user_id = u.id AND a.policy_id = @policy_id
+)
+  AND (sqlc.narg(tenant_id)::uuid IS NULL OR u.tenant_id = sqlc.narg(tenant_id))
+ORDER BY u.created_at
+LIMIT @lim OFFSET @off;
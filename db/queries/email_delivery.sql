@@ -0,0 +1,32 @@
+-- name: RecordEmailDelivery :exec
+-- Records the latest attempt of an email task, keeping when it was first
+-- handled
+INSERT INTO email_deliveries (id, task_id, user_id, email, type, status, error, attempts, created_at, updated_at)
+VALUES (
+    sqlc.arg(id), sqlc.arg(task_id), sqlc.narg(user_id), sqlc.arg(email), sqlc.arg(type),
+    sqlc.arg(status), sqlc.narg(error), sqlc.arg(attempts), sqlc.arg(handled_at), sqlc.arg(handled_at)
+)
+ON CONFLICT (task_id) DO UPDATE
+SET status = EXCLUDED.status,
+    error = EXCLUDED.error,
+    attempts = EXCLUDED.attempts,
+    updated_at = EXCLUDED.updated_at;
+
+-- name: ListUserEmailDeliveries :many
+-- A null tenant_id finds deliveries to a user in any tenant.
+SELECT id, task_id, user_id, email, type, status, error, attempts, created_at, updated_at
+FROM email_deliveries
+WHERE user_id = @user_id
+  AND (sqlc.narg(tenant_id)::uuid IS NULL OR user_id IN (SELECT id FROM users WHERE tenant_id = sqlc.narg(tenant_id)))
+ORDER BY created_at DESC, id DESC
+LIMIT @lim OFFSET @off;
+
+-- name: CountUserEmailDeliveries :one
+-- A null tenant_id counts deliveries to a user in any tenant.
+SELECT COUNT(*) FROM email_deliveries
+WHERE user_id = @user_id
+  AND (sqlc.narg(tenant_id)::uuid IS NULL OR user_id IN (SELECT id FROM users WHERE tenant_id = sqlc.narg(tenant_id)));
+
+-- name: DeleteEmailDeliveriesBefore :execrows
+DELETE FROM email_deliveries
+WHERE created_at < $1;
@@ -0,0 +1,27 @@
+-- name: CreateUserIdentity :exec
+INSERT INTO user_identities (id, user_id, provider, provider_user_id, email)
+VALUES ($1, $2, $3, $4, $5);
+
+-- name: ListUserIdentities :many
+SELECT id, user_id, provider, provider_user_id, email, created_at
+FROM user_identities
+WHERE user_id = $1
+ORDER BY created_at;
+
+-- name: DeleteUserIdentity :execrows
+DELETE FROM user_identities
+WHERE user_id = $1 AND provider = $2;
+
+-- name: LockUserLoginMethods :one
+-- Locks the user's row, so unlinks count the remaining login methods one at
+-- a time
+SELECT password_hash <> '' AS has_password,
+    (SELECT COUNT(*) FROM user_identities WHERE user_identities.user_id = users.id) AS identities
+FROM users
+WHERE id = $1 AND tenant_id = $2
+FOR UPDATE;
+
+-- name: ClearUserPassword :execrows
+UPDATE users
+SET password_hash = ''
+WHERE id = $1 AND tenant_id = $2 AND password_hash <> '';
@@ -0,0 +1,23 @@
+-- name: CreateImpersonation :exec
+INSERT INTO impersonations (id, admin_id, user_id, reason, ip_address, user_agent, started_at, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8);
+
+-- name: GetImpersonation :one
+SELECT id, admin_id, user_id, reason, ip_address, user_agent, started_at, expires_at, ended_at, ended_by
+FROM impersonations
+WHERE id = $1;
+
+-- name: ListImpersonations :many
+-- A null tenant_id lists impersonations of users in every tenant.
+SELECT id, admin_id, user_id, reason, ip_address, user_agent, started_at, expires_at, ended_at, ended_by
+FROM impersonations
+WHERE (sqlc.narg(tenant_id)::uuid IS NULL OR user_id IN (SELECT id FROM users WHERE tenant_id = sqlc.narg(tenant_id)))
+ORDER BY started_at DESC
+LIMIT @lim OFFSET @off;
+
+-- name: EndImpersonation :execrows
+-- A null tenant_id ends impersonations of users in any tenant.
+UPDATE impersonations
+SET ended_at = @ended_at, ended_by = @ended_by
+WHERE id = @id AND ended_at IS NULL
+  AND (sqlc.narg(tenant_id)::uuid IS NULL OR user_id IN (SELECT id FROM users WHERE tenant_id = sqlc.narg(tenant_id)));
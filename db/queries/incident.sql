@@ -0,0 +1,33 @@
+-- name: CreateAuthIncident :exec
+INSERT INTO auth_incidents (id, role, reason, keys_rotated, revoked_tokens, declared_by, not_before)
+VALUES ($1, $2, $3, $4, $5, $6, $7);
+
+-- name: ListAuthIncidents :many
+SELECT id, role, reason, keys_rotated, revoked_tokens, declared_by, not_before
+FROM auth_incidents
+ORDER BY not_before DESC
+LIMIT $1 OFFSET $2;
+
+-- name: ListKeyRotations :many
+-- When signing keys were rotated, oldest first
+SELECT not_before
+FROM auth_incidents
+WHERE keys_rotated
+ORDER BY not_before;
+
+-- name: ListTokenCutoffs :many
+-- The latest cutoff per role, the empty role covering everyone
+SELECT role, MAX(not_before)::timestamptz AS not_before
+FROM auth_incidents
+GROUP BY role;
+
+-- name: RevokeAllRefreshTokens :execrows
+UPDATE refresh_tokens
+SET revoked_at = NOW()
+WHERE revoked_at IS NULL AND expires_at > NOW();
+
+-- name: RevokeRoleRefreshTokens :execrows
+UPDATE refresh_tokens
+SET revoked_at = NOW()
+WHERE revoked_at IS NULL AND expires_at > NOW()
+  AND user_id IN (SELECT id FROM users WHERE role = $1);
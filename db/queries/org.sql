@@ -0,0 +1,57 @@
+-- name: CreateOrganization :exec
+INSERT INTO organizations (id, tenant_id, name, created_at)
+VALUES ($1, $2, $3, $4);
+
+-- name: GetOrganization :one
+SELECT id, tenant_id, name, created_at
+FROM organizations
+WHERE id = $1 AND tenant_id = $2;
+
+-- name: ListUserOrganizations :many
+SELECT o.id, o.tenant_id, o.name, o.created_at, m.role
+FROM organizations o
+JOIN org_memberships m ON m.org_id = o.id
+WHERE m.user_id = $1 AND o.tenant_id = $2
+ORDER BY o.name;
+
+-- name: CreateOrgMembership :exec
+INSERT INTO org_memberships (org_id, user_id, role, created_at)
+VALUES ($1, $2, $3, $4);
+
+-- name: GetOrgMembership :one
+SELECT org_id, user_id, role, created_at
+FROM org_memberships
+WHERE org_id = $1 AND user_id = $2;
+
+-- name: ListOrgMembers :many
+SELECT m.org_id, m.user_id, m.role, m.created_at, u.email
+FROM org_memberships m
+JOIN users u ON u.id = m.user_id
+WHERE m.org_id = $1
+ORDER BY m.created_at;
+
+-- name: CountOrgOwners :one
+SELECT COUNT(*) FROM org_memberships WHERE org_id = $1 AND role = 'owner';
+
+-- name: DeleteOrgMembership :execrows
+DELETE FROM org_memberships
+WHERE org_id = $1 AND user_id = $2;
+
+-- name: CreateOrgInvitation :exec
+INSERT INTO org_invitations (id, org_id, email, role, token_hash, invited_by, expires_at, created_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8);
+
+-- name: DeletePendingOrgInvitations :exec
+-- Pending invitations are replaced when the same email is invited again.
+DELETE FROM org_invitations
+WHERE org_id = $1 AND email = $2 AND accepted_at IS NULL;
+
+-- name: GetOrgInvitationByToken :one
+SELECT id, org_id, email, role, token_hash, invited_by, expires_at, accepted_at, created_at
+FROM org_invitations
+WHERE token_hash = $1;
+
+-- name: AcceptOrgInvitation :execrows
+UPDATE org_invitations
+SET accepted_at = $2
+WHERE id = $1 AND accepted_at IS NULL;
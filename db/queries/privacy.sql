@@ -0,0 +1,91 @@
+-- name: CreatePrivacyRequest :exec
+INSERT INTO privacy_requests (id, user_id, type, status, created_at, updated_at)
+VALUES (sqlc.arg(id), sqlc.arg(user_id), sqlc.arg(type), sqlc.arg(status), sqlc.arg(requested_at), sqlc.arg(requested_at));
+
+-- name: GetPrivacyRequest :one
+-- A null tenant_id finds requests of users in any tenant.
+SELECT id, user_id, type, status, error, created_at, updated_at, completed_at, expires_at
+FROM privacy_requests
+WHERE id = @id
+  AND (sqlc.narg(tenant_id)::uuid IS NULL OR user_id IN (SELECT id FROM users WHERE tenant_id = sqlc.narg(tenant_id)));
+
+-- name: GetOpenPrivacyRequest :one
+-- Returns the user's pending or processing request of a type
+SELECT id, user_id, type, status, error, created_at, updated_at, completed_at, expires_at
+FROM privacy_requests
+WHERE user_id = $1 AND type = $2 AND status IN ('pending', 'processing');
+
+-- name: GetPrivacyExportArchive :one
+-- Returns the archive of a user's completed export that hasn't expired
+SELECT archive
+FROM privacy_requests
+WHERE id = $1 AND user_id = $2 AND type = 'export' AND status = 'completed'
+  AND archive IS NOT NULL AND expires_at > $3;
+
+-- name: GetUserAccount :one
+-- Returns a user in any tenant, for the worker exporting or erasing it
+SELECT id, email, name, password_hash, role, email_verified_at, created_at, updated_at, tenant_id, is_guest, is_active
+FROM users
+WHERE id = $1;
+
+-- name: StartPrivacyRequest :execrows
+-- Marks an open request as processing; finished requests are left alone
+UPDATE privacy_requests
+SET status = 'processing', updated_at = $2
+WHERE id = $1 AND status IN ('pending', 'processing');
+
+-- name: CompletePrivacyRequest :exec
+UPDATE privacy_requests
+SET status = 'completed', error = NULL, archive = $2, completed_at = $3, updated_at = $3, expires_at = $4
+WHERE id = $1;
+
+-- name: FailPrivacyRequest :exec
+UPDATE privacy_requests
+SET status = 'failed', error = $2, updated_at = $3
+WHERE id = $1 AND status <> 'completed';
+
+-- name: ExpirePrivacyArchives :execrows
+-- Drops export archives past their expiry, keeping the requests
+UPDATE privacy_requests
+SET archive = NULL
+WHERE archive IS NOT NULL AND expires_at <= $1;
+
+-- Erasure queries
+
+-- name: AnonymizeUser :execrows
+-- Replaces a user's personal data, keeping the row so records that
+-- reference the user stay intact
+UPDATE users
+SET email = $2, name = NULL, password_hash = '', email_verified_at = NULL, is_active = FALSE
+WHERE id = $1;
+
+-- name: DeleteUserIdentities :exec
+DELETE FROM user_identities
+WHERE user_id = $1;
+
+-- name: DeleteUserWebauthnCredentials :exec
+DELETE FROM webauthn_credentials
+WHERE user_id = $1;
+
+-- name: DeleteUserRefreshTokens :exec
+DELETE FROM refresh_tokens
+WHERE user_id = $1;
+
+-- name: DeleteUserEmailDeliveries :exec
+DELETE FROM email_deliveries
+WHERE user_id = $1;
+
+-- name: AnonymizeUserAuditLogs :exec
+UPDATE audit_logs
+SET ip_address = NULL, user_agent = NULL
+WHERE user_id = $1;
+
+-- name: AnonymizeUserPolicyAcceptances :exec
+UPDATE policy_acceptances
+SET ip_address = '', user_agent = ''
+WHERE user_id = $1;
+
+-- name: ClearUserPrivacyArchives :exec
+UPDATE privacy_requests
+SET archive = NULL
+WHERE user_id = $1;
@@ -0,0 +1,4 @@
+-- name: ListRolePermissions :many
+SELECT role, permission
+FROM role_permissions
+ORDER BY role, permission;
@@ -0,0 +1,28 @@
+-- name: CreateArchivedRequest :exec
+INSERT INTO request_archive (
+    id, request_id, tenant_id, user_id, method, route, path, query, status,
+    latency_ms, ip_address, user_agent, body, body_truncated, created_at
+) VALUES (
+    $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15
+);
+
+-- name: ListArchivedRequests :many
+-- Pages through archived requests in (created_at, id) order, resuming after
+-- the last request of the previous page. A null tenant_id lists requests
+-- to every tenant.
+SELECT id, request_id, tenant_id, user_id, method, route, path, query, status,
+       latency_ms, ip_address, user_agent, body, body_truncated, created_at
+FROM request_archive
+WHERE created_at >= sqlc.arg(from_time)::timestamptz
+  AND created_at < sqlc.arg(to_time)::timestamptz
+  AND (created_at, id) > (sqlc.arg(after_created_at)::timestamptz, sqlc.arg(after_id)::uuid)
+  AND (sqlc.narg(user_id)::uuid IS NULL OR user_id = sqlc.narg(user_id))
+  AND (sqlc.narg(tenant_id)::uuid IS NULL OR tenant_id = sqlc.narg(tenant_id))
+ORDER BY created_at, id
+LIMIT sqlc.arg(max_requests);
+
+-- name: CreateRequestArchivePartition :exec
+SELECT create_request_archive_partition($1);
+
+-- name: DropRequestArchivePartitions :one
+SELECT drop_request_archive_partitions($1)::int;
@@ -0,0 +1,49 @@
+-- name: CreateSyncChange :one
+-- The owner's advisory lock is held until the surrounding transaction
+-- commits, so each user's changes become visible in seq order and readers
+-- never skip a change that commits after a later one.
+WITH ordered AS (
+    SELECT pg_advisory_xact_lock(hashtext('sync_changes'), hashtext(sqlc.arg(user_id)::uuid::text))
+)
+INSERT INTO sync_changes (user_id, entity, entity_id, op, data, created_at)
+SELECT sqlc.arg(user_id)::uuid, sqlc.arg(entity)::varchar, sqlc.arg(entity_id)::varchar,
+       sqlc.arg(op)::varchar, sqlc.narg(data)::jsonb, sqlc.arg(created_at)::timestamptz
+FROM ordered
+RETURNING seq;
+
+-- name: ListSyncChanges :many
+SELECT seq, user_id, entity, entity_id, op, data, created_at
+FROM sync_changes
+WHERE user_id = sqlc.arg(user_id)
+  AND seq > sqlc.arg(after_seq)
+  AND seq <= sqlc.arg(until_seq)
+ORDER BY seq
+LIMIT sqlc.arg(max_changes);
+
+-- name: ListSyncChangesAfter :many
+SELECT seq, user_id, entity, entity_id, op, data, created_at
+FROM sync_changes
+WHERE seq > $1
+ORDER BY seq
+LIMIT $2;
+
+-- name: GetLatestSyncSeq :one
+SELECT COALESCE(MAX(seq), 0)::bigint FROM sync_changes;
+
+-- name: GetLatestUserSyncSeq :one
+-- Waits for the user's uncommitted changes, so each of their changes up to
+-- the returned seq is visible to the queries that follow.
+WITH settled AS (
+    SELECT pg_advisory_xact_lock_shared(hashtext('sync_changes'), hashtext(sqlc.arg(user_id)::uuid::text))
+)
+SELECT COALESCE(MAX(seq), 0)::bigint FROM settled LEFT JOIN sync_changes ON true;
+
+-- name: GetOldestSyncSeq :one
+SELECT COALESCE(MIN(seq), 0)::bigint FROM sync_changes;
+
+-- name: DeleteSyncChangesBefore :execrows
+-- The newest change is always kept, so cursors older than the pruned changes
+-- can be told apart from ones that are simply up to date.
+DELETE FROM sync_changes
+WHERE created_at < $1
+  AND seq < (SELECT MAX(seq) FROM sync_changes);
@@ -0,0 +1,23 @@
+-- name: CreateTenant :exec
+INSERT INTO tenants (id, slug, name, sandbox_of, created_at)
+VALUES ($1, $2, $3, $4, $5);
+
+-- name: GetSandboxTenant :one
+SELECT id, slug, name, created_at, sandbox_of
+FROM tenants
+WHERE sandbox_of = $1;
+
+-- name: GetTenant :one
+SELECT id, slug, name, created_at, sandbox_of
+FROM tenants
+WHERE id = $1;
+
+-- name: GetTenantBySlug :one
+SELECT id, slug, name, created_at, sandbox_of
+FROM tenants
+WHERE slug = $1;
+
+-- name: ListTenants :many
+SELECT id, slug, name, created_at, sandbox_of
+FROM tenants
+ORDER BY slug;
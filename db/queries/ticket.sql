@@ -0,0 +1,48 @@
+-- name: CreateTicket :exec
+INSERT INTO tickets (id, user_id, subject, status, created_at, updated_at)
+VALUES ($1, $2, $3, $4, $5, $6);
+
+-- name: GetTicket :one
+-- A null tenant_id finds tickets of users in any tenant.
+SELECT id, user_id, subject, status, created_at, updated_at
+FROM tickets
+WHERE id = @id
+  AND (sqlc.narg(tenant_id)::uuid IS NULL OR user_id IN (SELECT id FROM users WHERE tenant_id = sqlc.narg(tenant_id)));
+
+-- name: ListUserTickets :many
+SELECT id, user_id, subject, status, created_at, updated_at
+FROM tickets
+WHERE user_id = $1
+ORDER BY updated_at DESC
+LIMIT $2 OFFSET $3;
+
+-- name: CountUserTickets :one
+SELECT COUNT(*) FROM tickets WHERE user_id = $1;
+
+-- name: ListTickets :many
+-- An empty status lists tickets in every status, and a null tenant_id
+-- tickets of users in every tenant.
+SELECT id, user_id, subject, status, created_at, updated_at
+FROM tickets
+WHERE (@status::text = '' OR status = @status::text)
+  AND (sqlc.narg(tenant_id)::uuid IS NULL OR user_id IN (SELECT id FROM users WHERE tenant_id = sqlc.narg(tenant_id)))
+ORDER BY updated_at DESC
+LIMIT @lim OFFSET @off;
+
+-- name: CountTickets :one
+SELECT COUNT(*) FROM tickets
+WHERE (@status::text = '' OR status = @status::text)
+  AND (sqlc.narg(tenant_id)::uuid IS NULL OR user_id IN (SELECT id FROM users WHERE tenant_id = sqlc.narg(tenant_id)));
+
+-- name: UpdateTicketStatus :exec
+UPDATE tickets SET status = $2, updated_at = $3 WHERE id = $1;
+
+-- name: CreateTicketMessage :exec
+INSERT INTO ticket_messages (id, ticket_id, author_id, staff, body, created_at)
+VALUES ($1, $2, $3, $4, $5, $6);
+
+-- name: ListTicketMessages :many
+SELECT id, ticket_id, author_id, staff, body, created_at
+FROM ticket_messages
+WHERE ticket_id = $1
+ORDER BY created_at;
@@ -1,64 +1,177 @@
 -- name: CreateUser :exec
-INSERT INTO users (id, email, name, password_hash, role)
-VALUES ($1, $2, $3, $4, $5);
+INSERT INTO users (id, email, name, password_hash, role, tenant_id, is_guest)
+VALUES ($1, $2, $3, $4, $5, $6, $7);
 
 -- name: GetUserByID :one
-SELECT id, email, name, password_hash, role, email_verified_at, created_at, updated_at
+SELECT id, email, name, password_hash, role, email_verified_at, created_at, updated_at, tenant_id, is_guest, is_active
 FROM users
-WHERE id = $1;
+WHERE id = $1 AND tenant_id = $2;
 
 -- name: GetUserByEmail :one
-SELECT id, email, name, password_hash, role, email_verified_at, created_at, updated_at
+SELECT id, email, name, password_hash, role, email_verified_at, created_at, updated_at, tenant_id, is_guest, is_active
 FROM users
-WHERE email = $1;
+WHERE tenant_id = $1 AND email = $2;
 
 -- name: UpdateUser :exec
 UPDATE users
 SET email = $2, name = $3, password_hash = $4
-WHERE id = $1;
+WHERE id = $1 AND tenant_id = $5;
 
--- name: UpdateUserPassword :exec
+-- name: UpdateUserPassword :execrows
+-- Replaces the password hash only while it's still old_hash, so a re-hash
+-- can't undo a password change made since it was read
 UPDATE users
-SET password_hash = $2
-WHERE id = $1;
+SET password_hash = sqlc.arg(new_hash)
+WHERE id = sqlc.arg(id) AND tenant_id = sqlc.arg(tenant_id) AND password_hash = sqlc.arg(old_hash);
 
 -- name: UpdateUserEmail :exec
 UPDATE users
 SET email = $2
-WHERE id = $1;
+WHERE id = $1 AND tenant_id = $3;
+
+-- name: UpdateUserRole :execrows
+UPDATE users
+SET role = $2
+WHERE id = $1 AND tenant_id = $3;
+
+-- name: SetUserActive :execrows
+-- Suspends or reactivates a user; suspended users can't log in
+UPDATE users
+SET is_active = $2
+WHERE id = $1 AND tenant_id = $3;
+
+-- name: UpgradeGuestUser :execrows
+-- Gives a guest user an email and password, keeping its ID and data
+UPDATE users
+SET email = $2, password_hash = $3, is_guest = FALSE
+WHERE id = $1 AND tenant_id = $4 AND is_guest;
 
 -- name: VerifyUserEmail :exec
 UPDATE users
 SET email_verified_at = NOW()
-WHERE id = $1;
+WHERE id = $1 AND tenant_id = $2;
 
 -- name: DeleteUser :exec
 DELETE FROM users
-WHERE id = $1;
+WHERE id = $1 AND tenant_id = $2;
+
+-- name: DeleteStaleGuestUsers :many
+-- Deletes guest users, in every tenant, created before the cutoff and issued
+-- no refresh token or session since. Returns the deleted users with the key
+-- of their avatar, if they had one, so its object can be deleted too.
+WITH deleted AS (
+    DELETE FROM users
+    WHERE is_guest
+      AND created_at < sqlc.arg(cutoff)::timestamptz
+      AND NOT EXISTS (SELECT 1 FROM refresh_tokens
+                      WHERE refresh_tokens.user_id = users.id AND refresh_tokens.created_at >= sqlc.arg(cutoff)::timestamptz)
+      AND NOT EXISTS (SELECT 1 FROM sessions
+                      WHERE sessions.user_id = users.id AND sessions.created_at >= sqlc.arg(cutoff)::timestamptz)
+    RETURNING id
+)
+SELECT deleted.id, user_avatars.key
+FROM deleted
+LEFT JOIN user_avatars ON user_avatars.user_id = deleted.id;
 
 -- name: ListUsers :many
-SELECT id, email, name, password_hash, role, email_verified_at, created_at, updated_at
+-- Lists a tenant's users matching the filters, sorted by sort_by (created_at,
+-- email or name). search matches the name, and the email if search_email.
+SELECT id, email, name, password_hash, role, email_verified_at, created_at, updated_at, tenant_id, is_guest, is_active
 FROM users
-ORDER BY created_at DESC
-LIMIT $1 OFFSET $2;
+WHERE tenant_id = sqlc.arg(tenant_id)
+  AND (sqlc.narg(search)::text IS NULL
+       OR name ILIKE '%' || sqlc.narg(search) || '%' ESCAPE '\'
+       OR (sqlc.arg(search_email)::bool AND email ILIKE '%' || sqlc.narg(search) || '%' ESCAPE '\'))
+  AND (sqlc.narg(role)::varchar IS NULL OR role = sqlc.narg(role))
+  AND (sqlc.narg(created_after)::timestamptz IS NULL OR created_at >= sqlc.narg(created_after))
+  AND (sqlc.narg(created_before)::timestamptz IS NULL OR created_at < sqlc.narg(created_before))
+ORDER BY
+  CASE WHEN sqlc.arg(sort_by)::text = 'email' AND NOT sqlc.arg(descending)::bool THEN email END ASC,
+  CASE WHEN sqlc.arg(sort_by)::text = 'email' AND sqlc.arg(descending)::bool THEN email END DESC,
+  CASE WHEN sqlc.arg(sort_by)::text = 'name' AND NOT sqlc.arg(descending)::bool THEN name END ASC,
+  CASE WHEN sqlc.arg(sort_by)::text = 'name' AND sqlc.arg(descending)::bool THEN name END DESC,
+  CASE WHEN NOT sqlc.arg(descending)::bool THEN created_at END ASC,
+  CASE WHEN sqlc.arg(descending)::bool THEN created_at END DESC,
+  id
+LIMIT sqlc.arg(max_entries) OFFSET sqlc.arg(skip_entries);
+
+-- name: ListUsersAfter :many
+-- Keyset page of ListUsers by creation time, newest first: the users created
+-- before the (after_created_at, after_id) position, or the newest users
+-- without one. Unlike OFFSET, the cost doesn't grow with the page number.
+SELECT id, email, name, password_hash, role, email_verified_at, created_at, updated_at, tenant_id, is_guest, is_active
+FROM users
+WHERE tenant_id = sqlc.arg(tenant_id)
+  AND (sqlc.narg(after_created_at)::timestamptz IS NULL
+       OR (created_at, id) < (sqlc.narg(after_created_at), sqlc.narg(after_id)::uuid))
+  AND (sqlc.narg(search)::text IS NULL
+       OR name ILIKE '%' || sqlc.narg(search) || '%' ESCAPE '\'
+       OR (sqlc.arg(search_email)::bool AND email ILIKE '%' || sqlc.narg(search) || '%' ESCAPE '\'))
+  AND (sqlc.narg(role)::varchar IS NULL OR role = sqlc.narg(role))
+  AND (sqlc.narg(created_after)::timestamptz IS NULL OR created_at >= sqlc.narg(created_after))
+  AND (sqlc.narg(created_before)::timestamptz IS NULL OR created_at < sqlc.narg(created_before))
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg(max_entries);
+
+-- name: ListUsersBefore :many
+-- Keyset page of ListUsers walking back towards newer users: the users
+-- created after the (before_created_at, before_id) position, oldest first.
+-- Callers reverse the rows to keep the list newest first.
+SELECT id, email, name, password_hash, role, email_verified_at, created_at, updated_at, tenant_id, is_guest, is_active
+FROM users
+WHERE tenant_id = sqlc.arg(tenant_id)
+  AND (created_at, id) > (sqlc.arg(before_created_at)::timestamptz, sqlc.arg(before_id)::uuid)
+  AND (sqlc.narg(search)::text IS NULL
+       OR name ILIKE '%' || sqlc.narg(search) || '%' ESCAPE '\'
+       OR (sqlc.arg(search_email)::bool AND email ILIKE '%' || sqlc.narg(search) || '%' ESCAPE '\'))
+  AND (sqlc.narg(role)::varchar IS NULL OR role = sqlc.narg(role))
+  AND (sqlc.narg(created_after)::timestamptz IS NULL OR created_at >= sqlc.narg(created_after))
+  AND (sqlc.narg(created_before)::timestamptz IS NULL OR created_at < sqlc.narg(created_before))
+ORDER BY created_at ASC, id ASC
+LIMIT sqlc.arg(max_entries);
+
+-- name: CountListedUsers :one
+-- Counts the users ListUsers matches with the same filters
+SELECT COUNT(*)
+FROM users
+WHERE tenant_id = sqlc.arg(tenant_id)
+  AND (sqlc.narg(search)::text IS NULL
+       OR name ILIKE '%' || sqlc.narg(search) || '%' ESCAPE '\'
+       OR (sqlc.arg(search_email)::bool AND email ILIKE '%' || sqlc.narg(search) || '%' ESCAPE '\'))
+  AND (sqlc.narg(role)::varchar IS NULL OR role = sqlc.narg(role))
+  AND (sqlc.narg(created_after)::timestamptz IS NULL OR created_at >= sqlc.narg(created_after))
+  AND (sqlc.narg(created_before)::timestamptz IS NULL OR created_at < sqlc.narg(created_before));
 
 -- name: CountUsers :one
+-- Counts users across every tenant
 SELECT COUNT(*) FROM users;
 
+-- name: CountTenantUsers :one
+SELECT COUNT(*) FROM users WHERE tenant_id = $1;
+
 -- name: UserExists :one
-SELECT EXISTS(SELECT 1 FROM users WHERE email = $1);
+SELECT EXISTS(SELECT 1 FROM users WHERE tenant_id = $1 AND email = $2);
 
 -- Refresh token queries
 
 -- name: CreateRefreshToken :exec
-INSERT INTO refresh_tokens (id, user_id, token_hash, expires_at)
-VALUES ($1, $2, $3, $4);
+INSERT INTO refresh_tokens (id, user_id, token_hash, expires_at, family_id, user_agent, ip_address, session_started_at)
+VALUES (
+    $1, $2, $3, $4, $5, $6, $7,
+    COALESCE((SELECT MIN(rt.session_started_at) FROM refresh_tokens rt WHERE rt.family_id = $5), NOW())
+);
 
 -- name: GetRefreshToken :one
-SELECT id, user_id, token_hash, expires_at, revoked_at, created_at
+SELECT id, user_id, token_hash, expires_at, revoked_at, created_at, family_id, user_agent, ip_address, session_started_at
 FROM refresh_tokens
 WHERE id = $1 AND revoked_at IS NULL AND expires_at > NOW();
 
+-- name: ListActiveRefreshTokens :many
+SELECT id, user_id, token_hash, expires_at, revoked_at, created_at, family_id, user_agent, ip_address, session_started_at
+FROM refresh_tokens
+WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+ORDER BY created_at DESC;
+
 -- name: RevokeRefreshToken :exec
 UPDATE refresh_tokens
 SET revoked_at = NOW()
@@ -69,25 +182,45 @@ UPDATE refresh_tokens
 SET revoked_at = NOW()
 WHERE user_id = $1 AND revoked_at IS NULL;
 
--- name: DeleteExpiredRefreshTokens :exec
+-- name: RevokeRefreshTokenFamily :execrows
+UPDATE refresh_tokens
+SET revoked_at = NOW()
+WHERE user_id = $1 AND family_id = $2 AND revoked_at IS NULL;
+
+-- name: DeleteExpiredRefreshTokens :execrows
 DELETE FROM refresh_tokens
 WHERE expires_at < NOW() OR revoked_at IS NOT NULL;
 
 -- Session queries
 
 -- name: CreateSession :exec
-INSERT INTO sessions (id, user_id, token_hash, user_agent, ip_address, expires_at)
-VALUES ($1, $2, $3, $4, $5, $6);
+INSERT INTO sessions (id, user_id, token_hash, csrf_token, user_agent, ip_address, last_seen_at, expires_at, org_id)
+VALUES (
+    sqlc.arg(id), sqlc.arg(user_id), sqlc.arg(token_hash), sqlc.arg(csrf_token), sqlc.arg(user_agent),
+    NULLIF(sqlc.arg(ip_address)::text, '')::inet, sqlc.arg(last_seen_at), sqlc.arg(expires_at), sqlc.arg(org_id)
+);
 
 -- name: GetSessionByToken :one
-SELECT id, user_id, token_hash, user_agent, ip_address, expires_at, created_at
-FROM sessions
-WHERE token_hash = $1 AND expires_at > NOW();
+SELECT s.id, s.user_id, u.email, u.role, s.csrf_token, s.user_agent,
+       COALESCE(host(s.ip_address), '')::text AS ip_address,
+       s.last_seen_at, s.expires_at, s.created_at, u.tenant_id, s.org_id
+FROM sessions s
+JOIN users u ON u.id = s.user_id
+WHERE s.token_hash = $1 AND s.expires_at > NOW();
+
+-- name: TouchSession :exec
+UPDATE sessions
+SET last_seen_at = $2
+WHERE token_hash = $1;
 
 -- name: DeleteSession :exec
 DELETE FROM sessions
 WHERE id = $1;
 
+-- name: DeleteSessionByToken :exec
+DELETE FROM sessions
+WHERE token_hash = $1;
+
 -- name: DeleteUserSessions :exec
 DELETE FROM sessions
 WHERE user_id = $1;
@@ -99,8 +232,12 @@ WHERE expires_at < NOW();
 -- Audit log queries
 
 -- name: CreateAuditLog :exec
-INSERT INTO audit_logs (id, user_id, action, entity_type, entity_id, old_values, new_values, ip_address, user_agent)
-VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9);
+INSERT INTO audit_logs (id, user_id, action, entity_type, entity_id, old_values, new_values, ip_address, user_agent, created_at)
+VALUES (
+    sqlc.arg(id), sqlc.arg(user_id), sqlc.arg(action), sqlc.arg(entity_type), sqlc.arg(entity_id),
+    sqlc.arg(old_values), sqlc.arg(new_values), NULLIF(sqlc.arg(ip_address)::text, '')::inet,
+    sqlc.arg(user_agent), sqlc.arg(created_at)
+);
 
 -- name: GetAuditLogs :many
 SELECT id, user_id, action, entity_type, entity_id, old_values, new_values, ip_address, user_agent, created_at
@@ -109,3 +246,17 @@ WHERE ($1::uuid IS NULL OR user_id = $1)
   AND ($2::varchar IS NULL OR entity_type = $2)
 ORDER BY created_at DESC
 LIMIT $3 OFFSET $4;
+
+-- name: ListAuditLogs :many
+-- A null tenant_id lists events of every tenant, including events of no
+-- user; otherwise only events of the tenant's users are listed.
+SELECT id, user_id, action, entity_type, entity_id, old_values, new_values,
+       COALESCE(host(ip_address), '')::text AS ip_address, user_agent, created_at
+FROM audit_logs
+WHERE entity_type = sqlc.arg(entity_type)
+  AND (sqlc.narg(user_id)::uuid IS NULL OR user_id = sqlc.narg(user_id))
+  AND (sqlc.narg(action)::varchar IS NULL OR action = sqlc.narg(action))
+  AND (sqlc.narg(since)::timestamptz IS NULL OR created_at >= sqlc.narg(since))
+  AND (sqlc.narg(tenant_id)::uuid IS NULL OR user_id IN (SELECT id FROM users WHERE tenant_id = sqlc.narg(tenant_id)))
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg(max_entries) OFFSET sqlc.arg(skip_entries);
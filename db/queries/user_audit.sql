@@ -0,0 +1,20 @@
+-- name: RecordUserActivity :exec
+INSERT INTO user_audit (id, user_id, tenant_id, action, fields, ip_address, user_agent, created_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8);
+
+-- name: ListUserActivity :many
+SELECT id, user_id, tenant_id, action, fields, ip_address, user_agent, created_at
+FROM user_audit
+WHERE user_id = $1
+ORDER BY created_at DESC, id DESC
+LIMIT $2 OFFSET $3;
+
+-- name: CountUserActivity :one
+SELECT COUNT(*) FROM user_audit WHERE user_id = $1;
+
+-- name: AnonymizeUserActivity :exec
+-- Blanks the client details of a user's activity, the only change the
+-- append-only trigger allows
+UPDATE user_audit
+SET ip_address = '', user_agent = ''
+WHERE user_id = $1;
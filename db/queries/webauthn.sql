@@ -0,0 +1,19 @@
+-- name: CreateWebauthnCredential :exec
+INSERT INTO webauthn_credentials (id, user_id, name, credential)
+VALUES ($1, $2, $3, $4);
+
+-- name: GetWebauthnCredential :one
+SELECT id, user_id, name, credential, created_at, last_used_at
+FROM webauthn_credentials
+WHERE id = $1;
+
+-- name: ListWebauthnCredentials :many
+SELECT id, user_id, name, credential, created_at, last_used_at
+FROM webauthn_credentials
+WHERE user_id = $1
+ORDER BY created_at;
+
+-- name: UpdateWebauthnCredential :exec
+UPDATE webauthn_credentials
+SET credential = $2, last_used_at = NOW()
+WHERE id = $1;
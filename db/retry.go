@@ -0,0 +1,208 @@
+// Package db holds helpers shared by the Postgres repositories. The
+// generated queries live in db/sqlc.
+package db
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Postgres error codes of transient failures. Classes 08 (connection
+// exception) and 57P (operator intervention) are matched by prefix.
+const (
+	codeSerializationFailure = "40001"
+	codeDeadlockDetected     = "40P01"
+	codeTooManyConnections   = "53300"
+	// codeReadOnlyTransaction is returned by a primary demoted during a
+	// failover until the pool reconnects to the new one
+	codeReadOnlyTransaction = "25006"
+)
+
+// Reasons a failure was retried, as reported in metrics
+const (
+	ReasonSerialization = "serialization"
+	ReasonDeadlock      = "deadlock"
+	ReasonConnection    = "connection"
+	ReasonFailover      = "failover"
+	ReasonOverloaded    = "overloaded"
+)
+
+// Classify returns why err is transient, or "" if retrying it won't help.
+// Cancelled and timed out contexts are never transient, since the caller
+// has given up.
+func Classify(err error) string {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return ""
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch {
+		case pgErr.Code == codeSerializationFailure:
+			return ReasonSerialization
+		case pgErr.Code == codeDeadlockDetected:
+			return ReasonDeadlock
+		case pgErr.Code == codeTooManyConnections:
+			return ReasonOverloaded
+		case pgErr.Code == codeReadOnlyTransaction, len(pgErr.Code) == 5 && pgErr.Code[:3] == "57P":
+			// admin_shutdown, crash_shutdown and cannot_connect_now are
+			// what a restarting or failing over server answers
+			return ReasonFailover
+		case len(pgErr.Code) == 5 && pgErr.Code[:2] == "08":
+			return ReasonConnection
+		}
+		return ""
+	}
+
+	var connectErr *pgconn.ConnectError
+	if errors.As(err, &connectErr) || pgconn.SafeToRetry(err) {
+		return ReasonConnection
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return ReasonConnection
+	}
+	var netErr *net.OpError
+	if errors.As(err, &netErr) && !netErr.Timeout() {
+		return ReasonConnection
+	}
+	return ""
+}
+
+// IsTransient reports whether err is a failure worth retrying
+func IsTransient(err error) bool {
+	return Classify(err) != ""
+}
+
+// Policy says how often and how long to retry
+type Policy struct {
+	// MaxAttempts counts the first attempt; 1 disables retries
+	MaxAttempts int
+	// BaseDelay is the wait before the first retry. It doubles on each
+	// further retry, up to MaxDelay, and is jittered by up to half.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// DefaultPolicy is the policy Retry uses
+var DefaultPolicy = Policy{
+	MaxAttempts: 3,
+	BaseDelay:   50 * time.Millisecond,
+	MaxDelay:    time.Second,
+}
+
+// Retry runs fn with DefaultPolicy. Only pass operations that are safe to
+// run twice: reads, and transactions whose failure rolled them back. A
+// write that failed on a dropped connection may have committed.
+//
+//	err := db.Retry(ctx, "user.get_by_id", func(ctx context.Context) error {
+//		dbUser, err = r.queries.GetUserByID(ctx, params)
+//		return err
+//	})
+func Retry(ctx context.Context, operation string, fn func(context.Context) error) error {
+	return DefaultPolicy.Retry(ctx, operation, fn)
+}
+
+// Retry runs fn until it succeeds, fails with an error that isn't
+// transient, or has been attempted MaxAttempts times, and returns its last
+// error. operation names fn in metrics.
+func (p Policy) Retry(ctx context.Context, operation string, fn func(context.Context) error) error {
+	attempts := max(p.MaxAttempts, 1)
+	delay := p.BaseDelay
+
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		reason := Classify(err)
+		if reason == "" {
+			return err
+		}
+		if attempt >= attempts {
+			recordExhausted(ctx, operation, reason)
+			return err
+		}
+		recordRetry(ctx, operation, reason)
+
+		if err := sleep(ctx, jitter(delay)); err != nil {
+			return err
+		}
+		delay *= 2
+		if p.MaxDelay > 0 && delay > p.MaxDelay {
+			delay = p.MaxDelay
+		}
+	}
+}
+
+// jitter spreads d over [d/2, d] so retrying clients don't stay in step
+func jitter(d time.Duration) time.Duration {
+	if d <= 1 {
+		return d
+	}
+	half := d / 2
+	return half + rand.N(d-half+1)
+}
+
+// sleep waits for d, returning early with the context's error if it ends
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+var (
+	metricsOnce sync.Once
+	retries     metric.Int64Counter
+	exhausted   metric.Int64Counter
+)
+
+func initMetrics() {
+	meter := otel.Meter("goiler/db")
+	retries, _ = meter.Int64Counter(
+		"db_retries_total",
+		metric.WithDescription("Total number of retried database operations, by operation and reason"),
+		metric.WithUnit("1"),
+	)
+	exhausted, _ = meter.Int64Counter(
+		"db_retries_exhausted_total",
+		metric.WithDescription("Total number of database operations that still failed transiently on their last attempt"),
+		metric.WithUnit("1"),
+	)
+}
+
+func recordRetry(ctx context.Context, operation, reason string) {
+	metricsOnce.Do(initMetrics)
+	if retries != nil {
+		retries.Add(ctx, 1, metric.WithAttributes(
+			attribute.String("operation", operation),
+			attribute.String("reason", reason),
+		))
+	}
+}
+
+func recordExhausted(ctx context.Context, operation, reason string) {
+	metricsOnce.Do(initMetrics)
+	if exhausted != nil {
+		exhausted.Add(ctx, 1, metric.WithAttributes(
+			attribute.String("operation", operation),
+			attribute.String("reason", reason),
+		))
+	}
+}
@@ -0,0 +1,118 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"nil", nil, ""},
+		{"no rows", pgx.ErrNoRows, ""},
+		{"unique violation", &pgconn.PgError{Code: "23505"}, ""},
+		{"serialization failure", &pgconn.PgError{Code: "40001"}, ReasonSerialization},
+		{"deadlock", fmt.Errorf("update: %w", &pgconn.PgError{Code: "40P01"}), ReasonDeadlock},
+		{"admin shutdown", &pgconn.PgError{Code: "57P01"}, ReasonFailover},
+		{"read-only after failover", &pgconn.PgError{Code: "25006"}, ReasonFailover},
+		{"connection failure", &pgconn.PgError{Code: "08006"}, ReasonConnection},
+		{"too many connections", &pgconn.PgError{Code: "53300"}, ReasonOverloaded},
+		{"connection reset", fmt.Errorf("read: %w", syscall.ECONNRESET), ReasonConnection},
+		{"unexpected EOF", io.ErrUnexpectedEOF, ReasonConnection},
+		{"cancelled", context.Canceled, ""},
+		{"deadline", fmt.Errorf("query: %w", context.DeadlineExceeded), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Classify(tt.err); got != tt.want {
+				t.Errorf("Classify(%v) = %q, want %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+var testPolicy = Policy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond}
+
+func TestRetry_RetriesTransientErrors(t *testing.T) {
+	calls := 0
+	err := testPolicy.Retry(context.Background(), "test", func(ctx context.Context) error {
+		calls++
+		if calls < 3 {
+			return &pgconn.PgError{Code: "40001"}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Expected success on the third attempt, got: %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("Expected 3 attempts, got %d", calls)
+	}
+}
+
+func TestRetry_StopsAtMaxAttempts(t *testing.T) {
+	calls := 0
+	transient := &pgconn.PgError{Code: "40P01"}
+	err := testPolicy.Retry(context.Background(), "test", func(ctx context.Context) error {
+		calls++
+		return transient
+	})
+	if !errors.Is(err, transient) {
+		t.Errorf("Expected the last error, got: %v", err)
+	}
+	if calls != testPolicy.MaxAttempts {
+		t.Errorf("Expected %d attempts, got %d", testPolicy.MaxAttempts, calls)
+	}
+}
+
+func TestRetry_DoesNotRetryPermanentErrors(t *testing.T) {
+	calls := 0
+	err := testPolicy.Retry(context.Background(), "test", func(ctx context.Context) error {
+		calls++
+		return pgx.ErrNoRows
+	})
+	if !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("Expected ErrNoRows, got: %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected 1 attempt, got %d", calls)
+	}
+}
+
+func TestRetry_StopsWhenContextEnds(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	policy := Policy{MaxAttempts: 5, BaseDelay: time.Hour}
+
+	calls := 0
+	err := policy.Retry(ctx, "test", func(ctx context.Context) error {
+		calls++
+		cancel()
+		return &pgconn.PgError{Code: "40001"}
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got: %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected 1 attempt, got %d", calls)
+	}
+}
+
+func TestJitter(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		if d := jitter(100 * time.Millisecond); d < 50*time.Millisecond || d > 100*time.Millisecond {
+			t.Fatalf("Expected a delay between 50ms and 100ms, got %v", d)
+		}
+	}
+}
@@ -25,22 +25,183 @@ type AuditLog struct {
 	CreatedAt  sql.NullTime    `db:"created_at" json:"created_at"`
 }
 
-type RefreshToken struct {
+type AuthIncident struct {
+	ID            uuid.UUID    `db:"id" json:"id"`
+	Role          string       `db:"role" json:"role"`
+	Reason        string       `db:"reason" json:"reason"`
+	KeysRotated   bool         `db:"keys_rotated" json:"keys_rotated"`
+	RevokedTokens int64        `db:"revoked_tokens" json:"revoked_tokens"`
+	DeclaredBy    pgtype.UUID  `db:"declared_by" json:"declared_by"`
+	NotBefore     sql.NullTime `db:"not_before" json:"not_before"`
+}
+
+type EmailDelivery struct {
+	ID        uuid.UUID    `db:"id" json:"id"`
+	TaskID    string       `db:"task_id" json:"task_id"`
+	UserID    pgtype.UUID  `db:"user_id" json:"user_id"`
+	Email     string       `db:"email" json:"email"`
+	Type      string       `db:"type" json:"type"`
+	Status    string       `db:"status" json:"status"`
+	Error     pgtype.Text  `db:"error" json:"error"`
+	Attempts  int32        `db:"attempts" json:"attempts"`
+	CreatedAt sql.NullTime `db:"created_at" json:"created_at"`
+	UpdatedAt sql.NullTime `db:"updated_at" json:"updated_at"`
+}
+
+type Impersonation struct {
 	ID        uuid.UUID          `db:"id" json:"id"`
+	AdminID   pgtype.UUID        `db:"admin_id" json:"admin_id"`
 	UserID    uuid.UUID          `db:"user_id" json:"user_id"`
-	TokenHash string             `db:"token_hash" json:"token_hash"`
+	Reason    string             `db:"reason" json:"reason"`
+	IpAddress string             `db:"ip_address" json:"ip_address"`
+	UserAgent string             `db:"user_agent" json:"user_agent"`
+	StartedAt sql.NullTime       `db:"started_at" json:"started_at"`
 	ExpiresAt sql.NullTime       `db:"expires_at" json:"expires_at"`
-	RevokedAt pgtype.Timestamptz `db:"revoked_at" json:"revoked_at"`
-	CreatedAt sql.NullTime       `db:"created_at" json:"created_at"`
+	EndedAt   pgtype.Timestamptz `db:"ended_at" json:"ended_at"`
+	EndedBy   pgtype.UUID        `db:"ended_by" json:"ended_by"`
+}
+
+type OrgInvitation struct {
+	ID         uuid.UUID          `db:"id" json:"id"`
+	OrgID      uuid.UUID          `db:"org_id" json:"org_id"`
+	Email      string             `db:"email" json:"email"`
+	Role       string             `db:"role" json:"role"`
+	TokenHash  string             `db:"token_hash" json:"token_hash"`
+	InvitedBy  pgtype.UUID        `db:"invited_by" json:"invited_by"`
+	ExpiresAt  sql.NullTime       `db:"expires_at" json:"expires_at"`
+	AcceptedAt pgtype.Timestamptz `db:"accepted_at" json:"accepted_at"`
+	CreatedAt  sql.NullTime       `db:"created_at" json:"created_at"`
+}
+
+type OrgMembership struct {
+	OrgID     uuid.UUID    `db:"org_id" json:"org_id"`
+	UserID    uuid.UUID    `db:"user_id" json:"user_id"`
+	Role      string       `db:"role" json:"role"`
+	CreatedAt sql.NullTime `db:"created_at" json:"created_at"`
+}
+
+type Organization struct {
+	ID        uuid.UUID    `db:"id" json:"id"`
+	TenantID  uuid.UUID    `db:"tenant_id" json:"tenant_id"`
+	Name      string       `db:"name" json:"name"`
+	CreatedAt sql.NullTime `db:"created_at" json:"created_at"`
+}
+
+type Policy struct {
+	ID          uuid.UUID    `db:"id" json:"id"`
+	Kind        string       `db:"kind" json:"kind"`
+	Version     string       `db:"version" json:"version"`
+	Title       string       `db:"title" json:"title"`
+	Url         string       `db:"url" json:"url"`
+	Mandatory   bool         `db:"mandatory" json:"mandatory"`
+	EffectiveAt sql.NullTime `db:"effective_at" json:"effective_at"`
+	CreatedAt   sql.NullTime `db:"created_at" json:"created_at"`
+}
+
+type PolicyAcceptance struct {
+	UserID     uuid.UUID    `db:"user_id" json:"user_id"`
+	PolicyID   uuid.UUID    `db:"policy_id" json:"policy_id"`
+	AcceptedAt sql.NullTime `db:"accepted_at" json:"accepted_at"`
+	IpAddress  string       `db:"ip_address" json:"ip_address"`
+	UserAgent  string       `db:"user_agent" json:"user_agent"`
+}
+
+type PrivacyRequest struct {
+	ID          uuid.UUID          `db:"id" json:"id"`
+	UserID      uuid.UUID          `db:"user_id" json:"user_id"`
+	Type        string             `db:"type" json:"type"`
+	Status      string             `db:"status" json:"status"`
+	Error       pgtype.Text        `db:"error" json:"error"`
+	Archive     []byte             `db:"archive" json:"archive"`
+	CreatedAt   sql.NullTime       `db:"created_at" json:"created_at"`
+	UpdatedAt   sql.NullTime       `db:"updated_at" json:"updated_at"`
+	CompletedAt pgtype.Timestamptz `db:"completed_at" json:"completed_at"`
+	ExpiresAt   pgtype.Timestamptz `db:"expires_at" json:"expires_at"`
+}
+
+type RefreshToken struct {
+	ID               uuid.UUID          `db:"id" json:"id"`
+	UserID           uuid.UUID          `db:"user_id" json:"user_id"`
+	TokenHash        pgtype.Text        `db:"token_hash" json:"token_hash"`
+	ExpiresAt        sql.NullTime       `db:"expires_at" json:"expires_at"`
+	RevokedAt        pgtype.Timestamptz `db:"revoked_at" json:"revoked_at"`
+	CreatedAt        sql.NullTime       `db:"created_at" json:"created_at"`
+	FamilyID         uuid.UUID          `db:"family_id" json:"family_id"`
+	UserAgent        string             `db:"user_agent" json:"user_agent"`
+	IpAddress        string             `db:"ip_address" json:"ip_address"`
+	SessionStartedAt sql.NullTime       `db:"session_started_at" json:"session_started_at"`
+}
+
+type RequestArchive struct {
+	ID            uuid.UUID       `db:"id" json:"id"`
+	RequestID     string          `db:"request_id" json:"request_id"`
+	TenantID      uuid.UUID       `db:"tenant_id" json:"tenant_id"`
+	UserID        pgtype.UUID     `db:"user_id" json:"user_id"`
+	Method        string          `db:"method" json:"method"`
+	Route         string          `db:"route" json:"route"`
+	Path          string          `db:"path" json:"path"`
+	Query         string          `db:"query" json:"query"`
+	Status        int32           `db:"status" json:"status"`
+	LatencyMs     int32           `db:"latency_ms" json:"latency_ms"`
+	IpAddress     string          `db:"ip_address" json:"ip_address"`
+	UserAgent     string          `db:"user_agent" json:"user_agent"`
+	Body          json.RawMessage `db:"body" json:"body"`
+	BodyTruncated bool            `db:"body_truncated" json:"body_truncated"`
+	CreatedAt     sql.NullTime    `db:"created_at" json:"created_at"`
+}
+
+type RolePermission struct {
+	Role       string       `db:"role" json:"role"`
+	Permission string       `db:"permission" json:"permission"`
+	CreatedAt  sql.NullTime `db:"created_at" json:"created_at"`
 }
 
 type Session struct {
+	ID         uuid.UUID    `db:"id" json:"id"`
+	UserID     uuid.UUID    `db:"user_id" json:"user_id"`
+	TokenHash  string       `db:"token_hash" json:"token_hash"`
+	UserAgent  pgtype.Text  `db:"user_agent" json:"user_agent"`
+	IpAddress  string       `db:"ip_address" json:"ip_address"`
+	ExpiresAt  sql.NullTime `db:"expires_at" json:"expires_at"`
+	CreatedAt  sql.NullTime `db:"created_at" json:"created_at"`
+	CsrfToken  string       `db:"csrf_token" json:"csrf_token"`
+	LastSeenAt sql.NullTime `db:"last_seen_at" json:"last_seen_at"`
+	OrgID      pgtype.UUID  `db:"org_id" json:"org_id"`
+}
+
+type SyncChange struct {
+	Seq       int64           `db:"seq" json:"seq"`
+	UserID    uuid.UUID       `db:"user_id" json:"user_id"`
+	Entity    string          `db:"entity" json:"entity"`
+	EntityID  string          `db:"entity_id" json:"entity_id"`
+	Op        string          `db:"op" json:"op"`
+	Data      json.RawMessage `db:"data" json:"data"`
+	CreatedAt sql.NullTime    `db:"created_at" json:"created_at"`
+}
+
+type Tenant struct {
+	ID        uuid.UUID    `db:"id" json:"id"`
+	Slug      string       `db:"slug" json:"slug"`
+	Name      string       `db:"name" json:"name"`
+	CreatedAt sql.NullTime `db:"created_at" json:"created_at"`
+	SandboxOf pgtype.UUID  `db:"sandbox_of" json:"sandbox_of"`
+}
+
+type Ticket struct {
 	ID        uuid.UUID    `db:"id" json:"id"`
 	UserID    uuid.UUID    `db:"user_id" json:"user_id"`
-	TokenHash string       `db:"token_hash" json:"token_hash"`
-	UserAgent pgtype.Text  `db:"user_agent" json:"user_agent"`
-	IpAddress string       `db:"ip_address" json:"ip_address"`
-	ExpiresAt sql.NullTime `db:"expires_at" json:"expires_at"`
+	Subject   string       `db:"subject" json:"subject"`
+	Status    string       `db:"status" json:"status"`
+	CreatedAt sql.NullTime `db:"created_at" json:"created_at"`
+	UpdatedAt sql.NullTime `db:"updated_at" json:"updated_at"`
+}
+
+type TicketMessage struct {
+	ID        uuid.UUID    `db:"id" json:"id"`
+	TicketID  uuid.UUID    `db:"ticket_id" json:"ticket_id"`
+	AuthorID  pgtype.UUID  `db:"author_id" json:"author_id"`
+	Staff     bool         `db:"staff" json:"staff"`
+	Body      string       `db:"body" json:"body"`
 	CreatedAt sql.NullTime `db:"created_at" json:"created_at"`
 }
 
@@ -53,4 +214,44 @@ type User struct {
 	EmailVerifiedAt pgtype.Timestamptz `db:"email_verified_at" json:"email_verified_at"`
 	CreatedAt       sql.NullTime       `db:"created_at" json:"created_at"`
 	UpdatedAt       sql.NullTime       `db:"updated_at" json:"updated_at"`
+	TenantID        uuid.UUID          `db:"tenant_id" json:"tenant_id"`
+	IsGuest         bool               `db:"is_guest" json:"is_guest"`
+	IsActive        bool               `db:"is_active" json:"is_active"`
+}
+
+type UserAudit struct {
+	ID        uuid.UUID    `db:"id" json:"id"`
+	UserID    uuid.UUID    `db:"user_id" json:"user_id"`
+	TenantID  uuid.UUID    `db:"tenant_id" json:"tenant_id"`
+	Action    string       `db:"action" json:"action"`
+	Fields    []string     `db:"fields" json:"fields"`
+	IpAddress string       `db:"ip_address" json:"ip_address"`
+	UserAgent string       `db:"user_agent" json:"user_agent"`
+	CreatedAt sql.NullTime `db:"created_at" json:"created_at"`
+}
+
+type UserAvatar struct {
+	UserID      uuid.UUID    `db:"user_id" json:"user_id"`
+	Key         string       `db:"key" json:"key"`
+	ContentType string       `db:"content_type" json:"content_type"`
+	SizeBytes   int32        `db:"size_bytes" json:"size_bytes"`
+	UpdatedAt   sql.NullTime `db:"updated_at" json:"updated_at"`
+}
+
+type UserIdentity struct {
+	ID             uuid.UUID    `db:"id" json:"id"`
+	UserID         uuid.UUID    `db:"user_id" json:"user_id"`
+	Provider       string       `db:"provider" json:"provider"`
+	ProviderUserID string       `db:"provider_user_id" json:"provider_user_id"`
+	Email          pgtype.Text  `db:"email" json:"email"`
+	CreatedAt      sql.NullTime `db:"created_at" json:"created_at"`
+}
+
+type WebauthnCredential struct {
+	ID         []byte             `db:"id" json:"id"`
+	UserID     uuid.UUID          `db:"user_id" json:"user_id"`
+	Name       string             `db:"name" json:"name"`
+	Credential []byte             `db:"credential" json:"credential"`
+	CreatedAt  sql.NullTime       `db:"created_at" json:"created_at"`
+	LastUsedAt pgtype.Timestamptz `db:"last_used_at" json:"last_used_at"`
 }
@@ -6,37 +6,204 @@ package sqlc
 
 import (
 	"context"
+	"database/sql"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
 type Querier interface {
+	AcceptOrgInvitation(ctx context.Context, arg AcceptOrgInvitationParams) (int64, error)
+	// Erasure queries
+	// Replaces a user's personal data, keeping the row so records that
+	// reference the user stay intact
+	AnonymizeUser(ctx context.Context, arg AnonymizeUserParams) (int64, error)
+	// Blanks the client details of a user's activity, the only change the
+	// append-only trigger allows
+	AnonymizeUserActivity(ctx context.Context, userID uuid.UUID) error
+	AnonymizeUserAuditLogs(ctx context.Context, userID pgtype.UUID) error
+	AnonymizeUserPolicyAcceptances(ctx context.Context, userID uuid.UUID) error
+	ClearUserPassword(ctx context.Context, arg ClearUserPasswordParams) (int64, error)
+	ClearUserPrivacyArchives(ctx context.Context, userID uuid.UUID) error
+	CompletePrivacyRequest(ctx context.Context, arg CompletePrivacyRequestParams) error
+	// Counts the users ListUsers matches with the same filters
+	CountListedUsers(ctx context.Context, arg CountListedUsersParams) (int64, error)
+	CountOrgOwners(ctx context.Context, orgID uuid.UUID) (int64, error)
+	// A null tenant_id counts acceptances by users in every tenant.
+	CountPolicyAcceptances(ctx context.Context, arg CountPolicyAcceptancesParams) (int64, error)
+	CountTenantUsers(ctx context.Context, tenantID uuid.UUID) (int64, error)
+	CountTickets(ctx context.Context, arg CountTicketsParams) (int64, error)
+	CountUserActivity(ctx context.Context, userID uuid.UUID) (int64, error)
+	// A null tenant_id counts deliveries to a user in any tenant.
+	CountUserEmailDeliveries(ctx context.Context, arg CountUserEmailDeliveriesParams) (int64, error)
+	CountUserTickets(ctx context.Context, userID uuid.UUID) (int64, error)
+	// Counts users across every tenant
 	CountUsers(ctx context.Context) (int64, error)
+	CreateArchivedRequest(ctx context.Context, arg CreateArchivedRequestParams) error
 	// Audit log queries
 	CreateAuditLog(ctx context.Context, arg CreateAuditLogParams) error
+	CreateAuthIncident(ctx context.Context, arg CreateAuthIncidentParams) error
+	CreateImpersonation(ctx context.Context, arg CreateImpersonationParams) error
+	CreateOrgInvitation(ctx context.Context, arg CreateOrgInvitationParams) error
+	CreateOrgMembership(ctx context.Context, arg CreateOrgMembershipParams) error
+	CreateOrganization(ctx context.Context, arg CreateOrganizationParams) error
+	CreatePolicy(ctx context.Context, arg CreatePolicyParams) error
+	CreatePolicyAcceptance(ctx context.Context, arg CreatePolicyAcceptanceParams) (int64, error)
+	CreatePrivacyRequest(ctx context.Context, arg CreatePrivacyRequestParams) error
 	// Refresh token queries
 	CreateRefreshToken(ctx context.Context, arg CreateRefreshTokenParams) error
+	CreateRequestArchivePartition(ctx context.Context, at sql.NullTime) error
 	// Session queries
 	CreateSession(ctx context.Context, arg CreateSessionParams) error
+	// The owner's advisory lock is held until the surrounding transaction
+	// commits, so each user's changes become visible in seq order and readers
+	// never skip a change that commits after a later one.
+	CreateSyncChange(ctx context.Context, arg CreateSyncChangeParams) (int64, error)
+	CreateTenant(ctx context.Context, arg CreateTenantParams) error
+	CreateTicket(ctx context.Context, arg CreateTicketParams) error
+	CreateTicketMessage(ctx context.Context, arg CreateTicketMessageParams) error
 	CreateUser(ctx context.Context, arg CreateUserParams) error
-	DeleteExpiredRefreshTokens(ctx context.Context) error
+	CreateUserIdentity(ctx context.Context, arg CreateUserIdentityParams) error
+	CreateWebauthnCredential(ctx context.Context, arg CreateWebauthnCredentialParams) error
+	DeleteEmailDeliveriesBefore(ctx context.Context, createdAt sql.NullTime) (int64, error)
+	DeleteExpiredRefreshTokens(ctx context.Context) (int64, error)
 	DeleteExpiredSessions(ctx context.Context) error
+	DeleteOrgMembership(ctx context.Context, arg DeleteOrgMembershipParams) (int64, error)
+	// Pending invitations are replaced when the same email is invited again.
+	DeletePendingOrgInvitations(ctx context.Context, arg DeletePendingOrgInvitationsParams) error
 	DeleteSession(ctx context.Context, id uuid.UUID) error
-	DeleteUser(ctx context.Context, id uuid.UUID) error
+	DeleteSessionByToken(ctx context.Context, tokenHash string) error
+	// Deletes guest users, in every tenant, created before the cutoff and issued
+	// no refresh token or session since. Returns the deleted users with the key
+	// of their avatar, if they had one, so its object can be deleted too.
+	DeleteStaleGuestUsers(ctx context.Context, cutoff sql.NullTime) ([]*DeleteStaleGuestUsersRow, error)
+	// The newest change is always kept, so cursors older than the pruned changes
+	// can be told apart from ones that are simply up to date.
+	DeleteSyncChangesBefore(ctx context.Context, createdAt sql.NullTime) (int64, error)
+	DeleteUser(ctx context.Context, arg DeleteUserParams) error
+	// Returns the deleted avatar's key so its object can be deleted too
+	DeleteUserAvatar(ctx context.Context, userID uuid.UUID) (string, error)
+	DeleteUserEmailDeliveries(ctx context.Context, userID pgtype.UUID) error
+	DeleteUserIdentities(ctx context.Context, userID uuid.UUID) error
+	DeleteUserIdentity(ctx context.Context, arg DeleteUserIdentityParams) (int64, error)
+	DeleteUserRefreshTokens(ctx context.Context, userID uuid.UUID) error
 	DeleteUserSessions(ctx context.Context, userID uuid.UUID) error
+	DeleteUserWebauthnCredentials(ctx context.Context, userID uuid.UUID) error
+	DropRequestArchivePartitions(ctx context.Context, before sql.NullTime) (int32, error)
+	// A null tenant_id ends impersonations of users in any tenant.
+	EndImpersonation(ctx context.Context, arg EndImpersonationParams) (int64, error)
+	// Drops export archives past their expiry, keeping the requests
+	ExpirePrivacyArchives(ctx context.Context, expiresAt pgtype.Timestamptz) (int64, error)
+	FailPrivacyRequest(ctx context.Context, arg FailPrivacyRequestParams) error
 	GetAuditLogs(ctx context.Context, arg GetAuditLogsParams) ([]*AuditLog, error)
+	GetLatestSyncSeq(ctx context.Context) (int64, error)
+	// Waits for the user's uncommitted changes, so each of their changes up to
+	// the returned seq is visible to the queries that follow.
+	GetLatestUserSyncSeq(ctx context.Context, userID uuid.UUID) (int64, error)
+	GetOldestSyncSeq(ctx context.Context) (int64, error)
+	GetImpersonation(ctx context.Context, id uuid.UUID) (*Impersonation, error)
+	// Returns the user's pending or processing request of a type
+	GetOpenPrivacyRequest(ctx context.Context, arg GetOpenPrivacyRequestParams) (*GetOpenPrivacyRequestRow, error)
+	GetOrgInvitationByToken(ctx context.Context, tokenHash string) (*OrgInvitation, error)
+	GetOrgMembership(ctx context.Context, arg GetOrgMembershipParams) (*OrgMembership, error)
+	GetOrganization(ctx context.Context, arg GetOrganizationParams) (*Organization, error)
+	GetPolicy(ctx context.Context, id uuid.UUID) (*Policy, error)
+	// Returns the archive of a user's completed export that hasn't expired
+	GetPrivacyExportArchive(ctx context.Context, arg GetPrivacyExportArchiveParams) ([]byte, error)
+	// A null tenant_id finds requests of users in any tenant.
+	GetPrivacyRequest(ctx context.Context, arg GetPrivacyRequestParams) (*GetPrivacyRequestRow, error)
 	GetRefreshToken(ctx context.Context, id uuid.UUID) (*RefreshToken, error)
-	GetSessionByToken(ctx context.Context, tokenHash string) (*Session, error)
-	GetUserByEmail(ctx context.Context, email string) (*User, error)
-	GetUserByID(ctx context.Context, id uuid.UUID) (*User, error)
+	GetSandboxTenant(ctx context.Context, sandboxOf pgtype.UUID) (*Tenant, error)
+	GetSessionByToken(ctx context.Context, tokenHash string) (*GetSessionByTokenRow, error)
+	GetTenant(ctx context.Context, id uuid.UUID) (*Tenant, error)
+	GetTenantBySlug(ctx context.Context, slug string) (*Tenant, error)
+	// A null tenant_id finds tickets of users in any tenant.
+	GetTicket(ctx context.Context, arg GetTicketParams) (*Ticket, error)
+	// Returns a user in any tenant, for the worker exporting or erasing it
+	GetUserAccount(ctx context.Context, id uuid.UUID) (*User, error)
+	GetUserAvatar(ctx context.Context, userID uuid.UUID) (*UserAvatar, error)
+	GetUserByEmail(ctx context.Context, arg GetUserByEmailParams) (*User, error)
+	GetUserByID(ctx context.Context, arg GetUserByIDParams) (*User, error)
+	GetWebauthnCredential(ctx context.Context, id []byte) (*WebauthnCredential, error)
+	ListActiveRefreshTokens(ctx context.Context, userID uuid.UUID) ([]*RefreshToken, error)
+	// Pages through archived requests in (created_at, id) order, resuming after
+	// the last request of the previous page. A null tenant_id lists requests
+	// to every tenant.
+	ListArchivedRequests(ctx context.Context, arg ListArchivedRequestsParams) ([]*RequestArchive, error)
+	// A null tenant_id lists events of every tenant, including events of no
+	// user; otherwise only events of the tenant's users are listed.
+	ListAuditLogs(ctx context.Context, arg ListAuditLogsParams) ([]*ListAuditLogsRow, error)
+	ListAuthIncidents(ctx context.Context, arg ListAuthIncidentsParams) ([]*AuthIncident, error)
+	// A null tenant_id lists impersonations of users in every tenant.
+	ListImpersonations(ctx context.Context, arg ListImpersonationsParams) ([]*Impersonation, error)
+	// When signing keys were rotated, oldest first
+	ListKeyRotations(ctx context.Context) ([]sql.NullTime, error)
+	ListOrgMembers(ctx context.Context, orgID uuid.UUID) ([]*ListOrgMembersRow, error)
+	ListPolicies(ctx context.Context) ([]*Policy, error)
+	// A null tenant_id lists acceptances by users in every tenant.
+	ListPolicyAcceptances(ctx context.Context, arg ListPolicyAcceptancesParams) ([]*ListPolicyAcceptancesRow, error)
+	ListRolePermissions(ctx context.Context) ([]*ListRolePermissionsRow, error)
+	ListSyncChanges(ctx context.Context, arg ListSyncChangesParams) ([]*SyncChange, error)
+	ListSyncChangesAfter(ctx context.Context, arg ListSyncChangesAfterParams) ([]*SyncChange, error)
+	ListTicketMessages(ctx context.Context, ticketID uuid.UUID) ([]*TicketMessage, error)
+	ListTenants(ctx context.Context) ([]*Tenant, error)
+	// An empty status lists tickets in every status, and a null tenant_id
+	// tickets of users in every tenant.
+	ListTickets(ctx context.Context, arg ListTicketsParams) ([]*Ticket, error)
+	// The latest cutoff per role, the empty role covering everyone
+	ListTokenCutoffs(ctx context.Context) ([]*ListTokenCutoffsRow, error)
+	ListUserActivity(ctx context.Context, arg ListUserActivityParams) ([]*UserAudit, error)
+	// A null tenant_id finds deliveries to a user in any tenant.
+	ListUserEmailDeliveries(ctx context.Context, arg ListUserEmailDeliveriesParams) ([]*EmailDelivery, error)
+	ListUserIdentities(ctx context.Context, userID uuid.UUID) ([]*UserIdentity, error)
+	ListUserOrganizations(ctx context.Context, arg ListUserOrganizationsParams) ([]*ListUserOrganizationsRow, error)
+	// A null tenant_id finds acceptances of a user in any tenant.
+	ListUserPolicyAcceptances(ctx context.Context, arg ListUserPolicyAcceptancesParams) ([]*PolicyAcceptance, error)
+	ListUserTickets(ctx context.Context, arg ListUserTicketsParams) ([]*Ticket, error)
+	// Lists a tenant's users matching the filters, sorted by sort_by (created_at,
+	// email or name). search matches the name, and the email if search_email.
 	ListUsers(ctx context.Context, arg ListUsersParams) ([]*User, error)
+	// Keyset page of ListUsers by creation time, newest first: the users created
+	// before the (after_created_at, after_id) position, or the newest users
+	// without one. Unlike OFFSET, the cost doesn't grow with the page number.
+	ListUsersAfter(ctx context.Context, arg ListUsersAfterParams) ([]*User, error)
+	// Keyset page of ListUsers walking back towards newer users: the users
+	// created after the (before_created_at, before_id) position, oldest first.
+	// Callers reverse the rows to keep the list newest first.
+	ListUsersBefore(ctx context.Context, arg ListUsersBeforeParams) ([]*User, error)
+	// A null tenant_id lists users in every tenant.
+	ListUsersWithoutPolicyAcceptance(ctx context.Context, arg ListUsersWithoutPolicyAcceptanceParams) ([]*ListUsersWithoutPolicyAcceptanceRow, error)
+	ListWebauthnCredentials(ctx context.Context, userID uuid.UUID) ([]*WebauthnCredential, error)
+	// Locks the user's row, so unlinks count the remaining login methods one at
+	// a time
+	LockUserLoginMethods(ctx context.Context, arg LockUserLoginMethodsParams) (*LockUserLoginMethodsRow, error)
+	// Records the latest attempt of an email task, keeping when it was first
+	// handled
+	RecordEmailDelivery(ctx context.Context, arg RecordEmailDeliveryParams) error
+	RecordUserActivity(ctx context.Context, arg RecordUserActivityParams) error
+	RevokeAllRefreshTokens(ctx context.Context) (int64, error)
 	RevokeAllUserRefreshTokens(ctx context.Context, userID uuid.UUID) error
 	RevokeRefreshToken(ctx context.Context, id uuid.UUID) error
+	RevokeRefreshTokenFamily(ctx context.Context, arg RevokeRefreshTokenFamilyParams) (int64, error)
+	RevokeRoleRefreshTokens(ctx context.Context, role string) (int64, error)
+	// Suspends or reactivates a user; suspended users can't log in
+	SetUserActive(ctx context.Context, arg SetUserActiveParams) (int64, error)
+	// Marks an open request as processing; finished requests are left alone
+	StartPrivacyRequest(ctx context.Context, arg StartPrivacyRequestParams) (int64, error)
+	TouchSession(ctx context.Context, arg TouchSessionParams) error
+	UpdateTicketStatus(ctx context.Context, arg UpdateTicketStatusParams) error
 	UpdateUser(ctx context.Context, arg UpdateUserParams) error
 	UpdateUserEmail(ctx context.Context, arg UpdateUserEmailParams) error
-	UpdateUserPassword(ctx context.Context, arg UpdateUserPasswordParams) error
-	UserExists(ctx context.Context, email string) (bool, error)
-	VerifyUserEmail(ctx context.Context, id uuid.UUID) error
+	// Replaces the password hash only while it's still old_hash, so a re-hash
+	// can't undo a password change made since it was read
+	UpdateUserPassword(ctx context.Context, arg UpdateUserPasswordParams) (int64, error)
+	UpdateUserRole(ctx context.Context, arg UpdateUserRoleParams) (int64, error)
+	UpdateWebauthnCredential(ctx context.Context, arg UpdateWebauthnCredentialParams) error
+	// Gives a guest user an email and password, keeping its ID and data
+	UpgradeGuestUser(ctx context.Context, arg UpgradeGuestUserParams) (int64, error)
+	UpsertUserAvatar(ctx context.Context, arg UpsertUserAvatarParams) error
+	UserExists(ctx context.Context, arg UserExistsParams) (bool, error)
+	VerifyUserEmail(ctx context.Context, arg VerifyUserEmailParams) error
 }
 
 var _ Querier = (*Queries)(nil)
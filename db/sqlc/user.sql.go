@@ -14,10 +14,58 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+const countListedUsers = `-- name: CountListedUsers :one
+SELECT COUNT(*)
+FROM users
+WHERE tenant_id = $1
+  AND ($2::text IS NULL
+       OR name ILIKE '%' || $2 || '%' ESCAPE '\'
+       OR ($3::bool AND email ILIKE '%' || $2 || '%' ESCAPE '\'))
+  AND ($4::varchar IS NULL OR role = $4)
+  AND ($5::timestamptz IS NULL OR created_at >= $5)
+  AND ($6::timestamptz IS NULL OR created_at < $6)
+`
+
+type CountListedUsersParams struct {
+	TenantID      uuid.UUID          `db:"tenant_id" json:"tenant_id"`
+	Search        pgtype.Text        `db:"search" json:"search"`
+	SearchEmail   bool               `db:"search_email" json:"search_email"`
+	Role          pgtype.Text        `db:"role" json:"role"`
+	CreatedAfter  pgtype.Timestamptz `db:"created_after" json:"created_after"`
+	CreatedBefore pgtype.Timestamptz `db:"created_before" json:"created_before"`
+}
+
+// Counts the users ListUsers matches with the same filters
+func (q *Queries) CountListedUsers(ctx context.Context, arg CountListedUsersParams) (int64, error) {
+	row := q.db.QueryRow(ctx, countListedUsers,
+		arg.TenantID,
+		arg.Search,
+		arg.SearchEmail,
+		arg.Role,
+		arg.CreatedAfter,
+		arg.CreatedBefore,
+	)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countTenantUsers = `-- name: CountTenantUsers :one
+SELECT COUNT(*) FROM users WHERE tenant_id = $1
+`
+
+func (q *Queries) CountTenantUsers(ctx context.Context, tenantID uuid.UUID) (int64, error) {
+	row := q.db.QueryRow(ctx, countTenantUsers, tenantID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const countUsers = `-- name: CountUsers :one
 SELECT COUNT(*) FROM users
 `
 
+// Counts users across every tenant
 func (q *Queries) CountUsers(ctx context.Context) (int64, error) {
 	row := q.db.QueryRow(ctx, countUsers)
 	var count int64
@@ -27,8 +75,12 @@ func (q *Queries) CountUsers(ctx context.Context) (int64, error) {
 
 const createAuditLog = `-- name: CreateAuditLog :exec
 
-INSERT INTO audit_logs (id, user_id, action, entity_type, entity_id, old_values, new_values, ip_address, user_agent)
-VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+INSERT INTO audit_logs (id, user_id, action, entity_type, entity_id, old_values, new_values, ip_address, user_agent, created_at)
+VALUES (
+    $1, $2, $3, $4, $5,
+    $6, $7, NULLIF($8::text, '')::inet,
+    $9, $10
+)
 `
 
 type CreateAuditLogParams struct {
@@ -41,6 +93,7 @@ type CreateAuditLogParams struct {
 	NewValues  json.RawMessage `db:"new_values" json:"new_values"`
 	IpAddress  string          `db:"ip_address" json:"ip_address"`
 	UserAgent  pgtype.Text     `db:"user_agent" json:"user_agent"`
+	CreatedAt  sql.NullTime    `db:"created_at" json:"created_at"`
 }
 
 // Audit log queries
@@ -55,21 +108,28 @@ func (q *Queries) CreateAuditLog(ctx context.Context, arg CreateAuditLogParams)
 		arg.NewValues,
 		arg.IpAddress,
 		arg.UserAgent,
+		arg.CreatedAt,
 	)
 	return err
 }
 
 const createRefreshToken = `-- name: CreateRefreshToken :exec
 
-INSERT INTO refresh_tokens (id, user_id, token_hash, expires_at)
-VALUES ($1, $2, $3, $4)
+INSERT INTO refresh_tokens (id, user_id, token_hash, expires_at, family_id, user_agent, ip_address, session_started_at)
+VALUES (
+    $1, $2, $3, $4, $5, $6, $7,
+    COALESCE((SELECT MIN(rt.session_started_at) FROM refresh_tokens rt WHERE rt.family_id = $5), NOW())
+)
 `
 
 type CreateRefreshTokenParams struct {
 	ID        uuid.UUID    `db:"id" json:"id"`
 	UserID    uuid.UUID    `db:"user_id" json:"user_id"`
-	TokenHash string       `db:"token_hash" json:"token_hash"`
+	TokenHash pgtype.Text  `db:"token_hash" json:"token_hash"`
 	ExpiresAt sql.NullTime `db:"expires_at" json:"expires_at"`
+	FamilyID  uuid.UUID    `db:"family_id" json:"family_id"`
+	UserAgent string       `db:"user_agent" json:"user_agent"`
+	IpAddress string       `db:"ip_address" json:"ip_address"`
 }
 
 // Refresh token queries
@@ -79,23 +139,31 @@ func (q *Queries) CreateRefreshToken(ctx context.Context, arg CreateRefreshToken
 		arg.UserID,
 		arg.TokenHash,
 		arg.ExpiresAt,
+		arg.FamilyID,
+		arg.UserAgent,
+		arg.IpAddress,
 	)
 	return err
 }
 
 const createSession = `-- name: CreateSession :exec
-
-INSERT INTO sessions (id, user_id, token_hash, user_agent, ip_address, expires_at)
-VALUES ($1, $2, $3, $4, $5, $6)
+INSERT INTO sessions (id, user_id, token_hash, csrf_token, user_agent, ip_address, last_seen_at, expires_at, org_id)
+VALUES (
+    $1, $2, $3, $4, $5,
+    NULLIF($6::text, '')::inet, $7, $8, $9
+)
 `
 
 type CreateSessionParams struct {
-	ID        uuid.UUID    `db:"id" json:"id"`
-	UserID    uuid.UUID    `db:"user_id" json:"user_id"`
-	TokenHash string       `db:"token_hash" json:"token_hash"`
-	UserAgent pgtype.Text  `db:"user_agent" json:"user_agent"`
-	IpAddress string       `db:"ip_address" json:"ip_address"`
-	ExpiresAt sql.NullTime `db:"expires_at" json:"expires_at"`
+	ID         uuid.UUID    `db:"id" json:"id"`
+	UserID     uuid.UUID    `db:"user_id" json:"user_id"`
+	TokenHash  string       `db:"token_hash" json:"token_hash"`
+	CsrfToken  string       `db:"csrf_token" json:"csrf_token"`
+	UserAgent  pgtype.Text  `db:"user_agent" json:"user_agent"`
+	IpAddress  string       `db:"ip_address" json:"ip_address"`
+	LastSeenAt sql.NullTime `db:"last_seen_at" json:"last_seen_at"`
+	ExpiresAt  sql.NullTime `db:"expires_at" json:"expires_at"`
+	OrgID      pgtype.UUID  `db:"org_id" json:"org_id"`
 }
 
 // Session queries
@@ -104,16 +172,19 @@ func (q *Queries) CreateSession(ctx context.Context, arg CreateSessionParams) er
 		arg.ID,
 		arg.UserID,
 		arg.TokenHash,
+		arg.CsrfToken,
 		arg.UserAgent,
 		arg.IpAddress,
+		arg.LastSeenAt,
 		arg.ExpiresAt,
+		arg.OrgID,
 	)
 	return err
 }
 
 const createUser = `-- name: CreateUser :exec
-INSERT INTO users (id, email, name, password_hash, role)
-VALUES ($1, $2, $3, $4, $5)
+INSERT INTO users (id, email, name, password_hash, role, tenant_id, is_guest)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
 `
 
 type CreateUserParams struct {
@@ -122,6 +193,8 @@ type CreateUserParams struct {
 	Name         pgtype.Text `db:"name" json:"name"`
 	PasswordHash string      `db:"password_hash" json:"password_hash"`
 	Role         string      `db:"role" json:"role"`
+	TenantID     uuid.UUID   `db:"tenant_id" json:"tenant_id"`
+	IsGuest      bool        `db:"is_guest" json:"is_guest"`
 }
 
 func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) error {
@@ -131,18 +204,23 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) error {
 		arg.Name,
 		arg.PasswordHash,
 		arg.Role,
+		arg.TenantID,
+		arg.IsGuest,
 	)
 	return err
 }
 
-const deleteExpiredRefreshTokens = `-- name: DeleteExpiredRefreshTokens :exec
+const deleteExpiredRefreshTokens = `-- name: DeleteExpiredRefreshTokens :execrows
 DELETE FROM refresh_tokens
 WHERE expires_at < NOW() OR revoked_at IS NOT NULL
 `
 
-func (q *Queries) DeleteExpiredRefreshTokens(ctx context.Context) error {
-	_, err := q.db.Exec(ctx, deleteExpiredRefreshTokens)
-	return err
+func (q *Queries) DeleteExpiredRefreshTokens(ctx context.Context) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteExpiredRefreshTokens)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
 }
 
 const deleteExpiredSessions = `-- name: DeleteExpiredSessions :exec
@@ -165,13 +243,72 @@ func (q *Queries) DeleteSession(ctx context.Context, id uuid.UUID) error {
 	return err
 }
 
+const deleteSessionByToken = `-- name: DeleteSessionByToken :exec
+DELETE FROM sessions
+WHERE token_hash = $1
+`
+
+func (q *Queries) DeleteSessionByToken(ctx context.Context, tokenHash string) error {
+	_, err := q.db.Exec(ctx, deleteSessionByToken, tokenHash)
+	return err
+}
+
+const deleteStaleGuestUsers = `-- name: DeleteStaleGuestUsers :many
+WITH deleted AS (
+    DELETE FROM users
+    WHERE is_guest
+      AND created_at < $1::timestamptz
+      AND NOT EXISTS (SELECT 1 FROM refresh_tokens
+                      WHERE refresh_tokens.user_id = users.id AND refresh_tokens.created_at >= $1::timestamptz)
+      AND NOT EXISTS (SELECT 1 FROM sessions
+                      WHERE sessions.user_id = users.id AND sessions.created_at >= $1::timestamptz)
+    RETURNING id
+)
+SELECT deleted.id, user_avatars.key
+FROM deleted
+LEFT JOIN user_avatars ON user_avatars.user_id = deleted.id
+`
+
+type DeleteStaleGuestUsersRow struct {
+	ID  uuid.UUID   `db:"id" json:"id"`
+	Key pgtype.Text `db:"key" json:"key"`
+}
+
+// Deletes guest users, in every tenant, created before the cutoff and issued
+// no refresh token or session since. Returns the deleted users with the key
+// of their avatar, if they had one, so its object can be deleted too.
+func (q *Queries) DeleteStaleGuestUsers(ctx context.Context, cutoff sql.NullTime) ([]*DeleteStaleGuestUsersRow, error) {
+	rows, err := q.db.Query(ctx, deleteStaleGuestUsers, cutoff)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []*DeleteStaleGuestUsersRow{}
+	for rows.Next() {
+		var i DeleteStaleGuestUsersRow
+		if err := rows.Scan(&i.ID, &i.Key); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const deleteUser = `-- name: DeleteUser :exec
 DELETE FROM users
-WHERE id = $1
+WHERE id = $1 AND tenant_id = $2
 `
 
-func (q *Queries) DeleteUser(ctx context.Context, id uuid.UUID) error {
-	_, err := q.db.Exec(ctx, deleteUser, id)
+type DeleteUserParams struct {
+	ID       uuid.UUID `db:"id" json:"id"`
+	TenantID uuid.UUID `db:"tenant_id" json:"tenant_id"`
+}
+
+func (q *Queries) DeleteUser(ctx context.Context, arg DeleteUserParams) error {
+	_, err := q.db.Exec(ctx, deleteUser, arg.ID, arg.TenantID)
 	return err
 }
 
@@ -238,7 +375,7 @@ func (q *Queries) GetAuditLogs(ctx context.Context, arg GetAuditLogsParams) ([]*
 }
 
 const getRefreshToken = `-- name: GetRefreshToken :one
-SELECT id, user_id, token_hash, expires_at, revoked_at, created_at
+SELECT id, user_id, token_hash, expires_at, revoked_at, created_at, family_id, user_agent, ip_address, session_started_at
 FROM refresh_tokens
 WHERE id = $1 AND revoked_at IS NULL AND expires_at > NOW()
 `
@@ -253,39 +390,71 @@ func (q *Queries) GetRefreshToken(ctx context.Context, id uuid.UUID) (*RefreshTo
 		&i.ExpiresAt,
 		&i.RevokedAt,
 		&i.CreatedAt,
+		&i.FamilyID,
+		&i.UserAgent,
+		&i.IpAddress,
+		&i.SessionStartedAt,
 	)
 	return &i, err
 }
 
 const getSessionByToken = `-- name: GetSessionByToken :one
-SELECT id, user_id, token_hash, user_agent, ip_address, expires_at, created_at
-FROM sessions
-WHERE token_hash = $1 AND expires_at > NOW()
+SELECT s.id, s.user_id, u.email, u.role, s.csrf_token, s.user_agent,
+       COALESCE(host(s.ip_address), '')::text AS ip_address,
+       s.last_seen_at, s.expires_at, s.created_at, u.tenant_id, s.org_id
+FROM sessions s
+JOIN users u ON u.id = s.user_id
+WHERE s.token_hash = $1 AND s.expires_at > NOW()
 `
 
-func (q *Queries) GetSessionByToken(ctx context.Context, tokenHash string) (*Session, error) {
+type GetSessionByTokenRow struct {
+	ID         uuid.UUID    `db:"id" json:"id"`
+	UserID     uuid.UUID    `db:"user_id" json:"user_id"`
+	Email      string       `db:"email" json:"email"`
+	Role       string       `db:"role" json:"role"`
+	CsrfToken  string       `db:"csrf_token" json:"csrf_token"`
+	UserAgent  pgtype.Text  `db:"user_agent" json:"user_agent"`
+	IpAddress  string       `db:"ip_address" json:"ip_address"`
+	LastSeenAt sql.NullTime `db:"last_seen_at" json:"last_seen_at"`
+	ExpiresAt  sql.NullTime `db:"expires_at" json:"expires_at"`
+	CreatedAt  sql.NullTime `db:"created_at" json:"created_at"`
+	TenantID   uuid.UUID    `db:"tenant_id" json:"tenant_id"`
+	OrgID      pgtype.UUID  `db:"org_id" json:"org_id"`
+}
+
+func (q *Queries) GetSessionByToken(ctx context.Context, tokenHash string) (*GetSessionByTokenRow, error) {
 	row := q.db.QueryRow(ctx, getSessionByToken, tokenHash)
-	var i Session
+	var i GetSessionByTokenRow
 	err := row.Scan(
 		&i.ID,
 		&i.UserID,
-		&i.TokenHash,
+		&i.Email,
+		&i.Role,
+		&i.CsrfToken,
 		&i.UserAgent,
 		&i.IpAddress,
+		&i.LastSeenAt,
 		&i.ExpiresAt,
 		&i.CreatedAt,
+		&i.TenantID,
+		&i.OrgID,
 	)
 	return &i, err
 }
 
 const getUserByEmail = `-- name: GetUserByEmail :one
-SELECT id, email, name, password_hash, role, email_verified_at, created_at, updated_at
+SELECT id, email, name, password_hash, role, email_verified_at, created_at, updated_at, tenant_id, is_guest, is_active
 FROM users
-WHERE email = $1
+WHERE tenant_id = $1 AND email = $2
 `
 
-func (q *Queries) GetUserByEmail(ctx context.Context, email string) (*User, error) {
-	row := q.db.QueryRow(ctx, getUserByEmail, email)
+type GetUserByEmailParams struct {
+	TenantID uuid.UUID `db:"tenant_id" json:"tenant_id"`
+	Email    string    `db:"email" json:"email"`
+}
+
+func (q *Queries) GetUserByEmail(ctx context.Context, arg GetUserByEmailParams) (*User, error) {
+	row := q.db.QueryRow(ctx, getUserByEmail, arg.TenantID, arg.Email)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -296,18 +465,26 @@ func (q *Queries) GetUserByEmail(ctx context.Context, email string) (*User, erro
 		&i.EmailVerifiedAt,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.TenantID,
+		&i.IsGuest,
+		&i.IsActive,
 	)
 	return &i, err
 }
 
 const getUserByID = `-- name: GetUserByID :one
-SELECT id, email, name, password_hash, role, email_verified_at, created_at, updated_at
+SELECT id, email, name, password_hash, role, email_verified_at, created_at, updated_at, tenant_id, is_guest, is_active
 FROM users
-WHERE id = $1
+WHERE id = $1 AND tenant_id = $2
 `
 
-func (q *Queries) GetUserByID(ctx context.Context, id uuid.UUID) (*User, error) {
-	row := q.db.QueryRow(ctx, getUserByID, id)
+type GetUserByIDParams struct {
+	ID       uuid.UUID `db:"id" json:"id"`
+	TenantID uuid.UUID `db:"tenant_id" json:"tenant_id"`
+}
+
+func (q *Queries) GetUserByID(ctx context.Context, arg GetUserByIDParams) (*User, error) {
+	row := q.db.QueryRow(ctx, getUserByID, arg.ID, arg.TenantID)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -318,24 +495,250 @@ func (q *Queries) GetUserByID(ctx context.Context, id uuid.UUID) (*User, error)
 		&i.EmailVerifiedAt,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.TenantID,
+		&i.IsGuest,
+		&i.IsActive,
 	)
 	return &i, err
 }
 
+const listActiveRefreshTokens = `-- name: ListActiveRefreshTokens :many
+SELECT id, user_id, token_hash, expires_at, revoked_at, created_at, family_id, user_agent, ip_address, session_started_at
+FROM refresh_tokens
+WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+ORDER BY created_at DESC
+`
+
+func (q *Queries) ListActiveRefreshTokens(ctx context.Context, userID uuid.UUID) ([]*RefreshToken, error) {
+	rows, err := q.db.Query(ctx, listActiveRefreshTokens, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []*RefreshToken{}
+	for rows.Next() {
+		var i RefreshToken
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.TokenHash,
+			&i.ExpiresAt,
+			&i.RevokedAt,
+			&i.CreatedAt,
+			&i.FamilyID,
+			&i.UserAgent,
+			&i.IpAddress,
+			&i.SessionStartedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAuditLogs = `-- name: ListAuditLogs :many
+SELECT id, user_id, action, entity_type, entity_id, old_values, new_values,
+       COALESCE(host(ip_address), '')::text AS ip_address, user_agent, created_at
+FROM audit_logs
+WHERE entity_type = $1
+  AND ($2::uuid IS NULL OR user_id = $2)
+  AND ($3::varchar IS NULL OR action = $3)
+  AND ($4::timestamptz IS NULL OR created_at >= $4)
+  AND ($5::uuid IS NULL OR user_id IN (SELECT id FROM users WHERE tenant_id = $5))
+ORDER BY created_at DESC, id DESC
+LIMIT $6 OFFSET $7
+`
+
+type ListAuditLogsParams struct {
+	EntityType  string             `db:"entity_type" json:"entity_type"`
+	UserID      pgtype.UUID        `db:"user_id" json:"user_id"`
+	Action      pgtype.Text        `db:"action" json:"action"`
+	Since       pgtype.Timestamptz `db:"since" json:"since"`
+	TenantID    pgtype.UUID        `db:"tenant_id" json:"tenant_id"`
+	MaxEntries  int32              `db:"max_entries" json:"max_entries"`
+	SkipEntries int32              `db:"skip_entries" json:"skip_entries"`
+}
+
+type ListAuditLogsRow struct {
+	ID         uuid.UUID       `db:"id" json:"id"`
+	UserID     pgtype.UUID     `db:"user_id" json:"user_id"`
+	Action     string          `db:"action" json:"action"`
+	EntityType string          `db:"entity_type" json:"entity_type"`
+	EntityID   pgtype.UUID     `db:"entity_id" json:"entity_id"`
+	OldValues  json.RawMessage `db:"old_values" json:"old_values"`
+	NewValues  json.RawMessage `db:"new_values" json:"new_values"`
+	IpAddress  string          `db:"ip_address" json:"ip_address"`
+	UserAgent  pgtype.Text     `db:"user_agent" json:"user_agent"`
+	CreatedAt  sql.NullTime    `db:"created_at" json:"created_at"`
+}
+
+// A null tenant_id lists events of every tenant, including events of no
+// user; otherwise only events of the tenant's users are listed.
+func (q *Queries) ListAuditLogs(ctx context.Context, arg ListAuditLogsParams) ([]*ListAuditLogsRow, error) {
+	rows, err := q.db.Query(ctx, listAuditLogs,
+		arg.EntityType,
+		arg.UserID,
+		arg.Action,
+		arg.Since,
+		arg.TenantID,
+		arg.MaxEntries,
+		arg.SkipEntries,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []*ListAuditLogsRow{}
+	for rows.Next() {
+		var i ListAuditLogsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Action,
+			&i.EntityType,
+			&i.EntityID,
+			&i.OldValues,
+			&i.NewValues,
+			&i.IpAddress,
+			&i.UserAgent,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const listUsers = `-- name: ListUsers :many
-SELECT id, email, name, password_hash, role, email_verified_at, created_at, updated_at
+SELECT id, email, name, password_hash, role, email_verified_at, created_at, updated_at, tenant_id, is_guest, is_active
 FROM users
-ORDER BY created_at DESC
-LIMIT $1 OFFSET $2
+WHERE tenant_id = $1
+  AND ($2::text IS NULL
+       OR name ILIKE '%' || $2 || '%' ESCAPE '\'
+       OR ($3::bool AND email ILIKE '%' || $2 || '%' ESCAPE '\'))
+  AND ($4::varchar IS NULL OR role = $4)
+  AND ($5::timestamptz IS NULL OR created_at >= $5)
+  AND ($6::timestamptz IS NULL OR created_at < $6)
+ORDER BY
+  CASE WHEN $7::text = 'email' AND NOT $8::bool THEN email END ASC,
+  CASE WHEN $7::text = 'email' AND $8::bool THEN email END DESC,
+  CASE WHEN $7::text = 'name' AND NOT $8::bool THEN name END ASC,
+  CASE WHEN $7::text = 'name' AND $8::bool THEN name END DESC,
+  CASE WHEN NOT $8::bool THEN created_at END ASC,
+  CASE WHEN $8::bool THEN created_at END DESC,
+  id
+LIMIT $9 OFFSET $10
 `
 
 type ListUsersParams struct {
-	Limit  int32 `db:"limit" json:"limit"`
-	Offset int32 `db:"offset" json:"offset"`
+	TenantID      uuid.UUID          `db:"tenant_id" json:"tenant_id"`
+	Search        pgtype.Text        `db:"search" json:"search"`
+	SearchEmail   bool               `db:"search_email" json:"search_email"`
+	Role          pgtype.Text        `db:"role" json:"role"`
+	CreatedAfter  pgtype.Timestamptz `db:"created_after" json:"created_after"`
+	CreatedBefore pgtype.Timestamptz `db:"created_before" json:"created_before"`
+	SortBy        string             `db:"sort_by" json:"sort_by"`
+	Descending    bool               `db:"descending" json:"descending"`
+	MaxEntries    int32              `db:"max_entries" json:"max_entries"`
+	SkipEntries   int32              `db:"skip_entries" json:"skip_entries"`
 }
 
+// Lists a tenant's users matching the filters, sorted by sort_by (created_at,
+// email or name). search matches the name, and the email if search_email.
 func (q *Queries) ListUsers(ctx context.Context, arg ListUsersParams) ([]*User, error) {
-	rows, err := q.db.Query(ctx, listUsers, arg.Limit, arg.Offset)
+	rows, err := q.db.Query(ctx, listUsers,
+		arg.TenantID,
+		arg.Search,
+		arg.SearchEmail,
+		arg.Role,
+		arg.CreatedAfter,
+		arg.CreatedBefore,
+		arg.SortBy,
+		arg.Descending,
+		arg.MaxEntries,
+		arg.SkipEntries,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []*User{}
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.ID,
+			&i.Email,
+			&i.Name,
+			&i.PasswordHash,
+			&i.Role,
+			&i.EmailVerifiedAt,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.TenantID,
+			&i.IsGuest,
+			&i.IsActive,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUsersAfter = `-- name: ListUsersAfter :many
+SELECT id, email, name, password_hash, role, email_verified_at, created_at, updated_at, tenant_id, is_guest, is_active
+FROM users
+WHERE tenant_id = $1
+  AND ($2::timestamptz IS NULL
+       OR (created_at, id) < ($2, $3::uuid))
+  AND ($4::text IS NULL
+       OR name ILIKE '%' || $4 || '%' ESCAPE '\'
+       OR ($5::bool AND email ILIKE '%' || $4 || '%' ESCAPE '\'))
+  AND ($6::varchar IS NULL OR role = $6)
+  AND ($7::timestamptz IS NULL OR created_at >= $7)
+  AND ($8::timestamptz IS NULL OR created_at < $8)
+ORDER BY created_at DESC, id DESC
+LIMIT $9
+`
+
+type ListUsersAfterParams struct {
+	TenantID       uuid.UUID          `db:"tenant_id" json:"tenant_id"`
+	AfterCreatedAt pgtype.Timestamptz `db:"after_created_at" json:"after_created_at"`
+	AfterID        pgtype.UUID        `db:"after_id" json:"after_id"`
+	Search         pgtype.Text        `db:"search" json:"search"`
+	SearchEmail    bool               `db:"search_email" json:"search_email"`
+	Role           pgtype.Text        `db:"role" json:"role"`
+	CreatedAfter   pgtype.Timestamptz `db:"created_after" json:"created_after"`
+	CreatedBefore  pgtype.Timestamptz `db:"created_before" json:"created_before"`
+	MaxEntries     int32              `db:"max_entries" json:"max_entries"`
+}
+
+// Keyset page of ListUsers by creation time, newest first: the users created
+// before the (after_created_at, after_id) position, or the newest users
+// without one. Unlike OFFSET, the cost doesn't grow with the page number.
+func (q *Queries) ListUsersAfter(ctx context.Context, arg ListUsersAfterParams) ([]*User, error) {
+	rows, err := q.db.Query(ctx, listUsersAfter,
+		arg.TenantID,
+		arg.AfterCreatedAt,
+		arg.AfterID,
+		arg.Search,
+		arg.SearchEmail,
+		arg.Role,
+		arg.CreatedAfter,
+		arg.CreatedBefore,
+		arg.MaxEntries,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -352,6 +755,81 @@ func (q *Queries) ListUsers(ctx context.Context, arg ListUsersParams) ([]*User,
 			&i.EmailVerifiedAt,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.TenantID,
+			&i.IsGuest,
+			&i.IsActive,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUsersBefore = `-- name: ListUsersBefore :many
+SELECT id, email, name, password_hash, role, email_verified_at, created_at, updated_at, tenant_id, is_guest, is_active
+FROM users
+WHERE tenant_id = $1
+  AND (created_at, id) > ($2::timestamptz, $3::uuid)
+  AND ($4::text IS NULL
+       OR name ILIKE '%' || $4 || '%' ESCAPE '\'
+       OR ($5::bool AND email ILIKE '%' || $4 || '%' ESCAPE '\'))
+  AND ($6::varchar IS NULL OR role = $6)
+  AND ($7::timestamptz IS NULL OR created_at >= $7)
+  AND ($8::timestamptz IS NULL OR created_at < $8)
+ORDER BY created_at ASC, id ASC
+LIMIT $9
+`
+
+type ListUsersBeforeParams struct {
+	TenantID        uuid.UUID          `db:"tenant_id" json:"tenant_id"`
+	BeforeCreatedAt sql.NullTime       `db:"before_created_at" json:"before_created_at"`
+	BeforeID        uuid.UUID          `db:"before_id" json:"before_id"`
+	Search          pgtype.Text        `db:"search" json:"search"`
+	SearchEmail     bool               `db:"search_email" json:"search_email"`
+	Role            pgtype.Text        `db:"role" json:"role"`
+	CreatedAfter    pgtype.Timestamptz `db:"created_after" json:"created_after"`
+	CreatedBefore   pgtype.Timestamptz `db:"created_before" json:"created_before"`
+	MaxEntries      int32              `db:"max_entries" json:"max_entries"`
+}
+
+// Keyset page of ListUsers walking back towards newer users: the users
+// created after the (before_created_at, before_id) position, oldest first.
+// Callers reverse the rows to keep the list newest first.
+func (q *Queries) ListUsersBefore(ctx context.Context, arg ListUsersBeforeParams) ([]*User, error) {
+	rows, err := q.db.Query(ctx, listUsersBefore,
+		arg.TenantID,
+		arg.BeforeCreatedAt,
+		arg.BeforeID,
+		arg.Search,
+		arg.SearchEmail,
+		arg.Role,
+		arg.CreatedAfter,
+		arg.CreatedBefore,
+		arg.MaxEntries,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []*User{}
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.ID,
+			&i.Email,
+			&i.Name,
+			&i.PasswordHash,
+			&i.Role,
+			&i.EmailVerifiedAt,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.TenantID,
+			&i.IsGuest,
+			&i.IsActive,
 		); err != nil {
 			return nil, err
 		}
@@ -385,10 +863,66 @@ func (q *Queries) RevokeRefreshToken(ctx context.Context, id uuid.UUID) error {
 	return err
 }
 
+const revokeRefreshTokenFamily = `-- name: RevokeRefreshTokenFamily :execrows
+UPDATE refresh_tokens
+SET revoked_at = NOW()
+WHERE user_id = $1 AND family_id = $2 AND revoked_at IS NULL
+`
+
+type RevokeRefreshTokenFamilyParams struct {
+	UserID   uuid.UUID `db:"user_id" json:"user_id"`
+	FamilyID uuid.UUID `db:"family_id" json:"family_id"`
+}
+
+func (q *Queries) RevokeRefreshTokenFamily(ctx context.Context, arg RevokeRefreshTokenFamilyParams) (int64, error) {
+	result, err := q.db.Exec(ctx, revokeRefreshTokenFamily, arg.UserID, arg.FamilyID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const setUserActive = `-- name: SetUserActive :execrows
+UPDATE users
+SET is_active = $2
+WHERE id = $1 AND tenant_id = $3
+`
+
+type SetUserActiveParams struct {
+	ID       uuid.UUID `db:"id" json:"id"`
+	IsActive bool      `db:"is_active" json:"is_active"`
+	TenantID uuid.UUID `db:"tenant_id" json:"tenant_id"`
+}
+
+// Suspends or reactivates a user; suspended users can't log in
+func (q *Queries) SetUserActive(ctx context.Context, arg SetUserActiveParams) (int64, error) {
+	result, err := q.db.Exec(ctx, setUserActive, arg.ID, arg.IsActive, arg.TenantID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const touchSession = `-- name: TouchSession :exec
+UPDATE sessions
+SET last_seen_at = $2
+WHERE token_hash = $1
+`
+
+type TouchSessionParams struct {
+	TokenHash  string       `db:"token_hash" json:"token_hash"`
+	LastSeenAt sql.NullTime `db:"last_seen_at" json:"last_seen_at"`
+}
+
+func (q *Queries) TouchSession(ctx context.Context, arg TouchSessionParams) error {
+	_, err := q.db.Exec(ctx, touchSession, arg.TokenHash, arg.LastSeenAt)
+	return err
+}
+
 const updateUser = `-- name: UpdateUser :exec
 UPDATE users
 SET email = $2, name = $3, password_hash = $4
-WHERE id = $1
+WHERE id = $1 AND tenant_id = $5
 `
 
 type UpdateUserParams struct {
@@ -396,6 +930,7 @@ type UpdateUserParams struct {
 	Email        string      `db:"email" json:"email"`
 	Name         pgtype.Text `db:"name" json:"name"`
 	PasswordHash string      `db:"password_hash" json:"password_hash"`
+	TenantID     uuid.UUID   `db:"tenant_id" json:"tenant_id"`
 }
 
 func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) error {
@@ -404,6 +939,7 @@ func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) error {
 		arg.Email,
 		arg.Name,
 		arg.PasswordHash,
+		arg.TenantID,
 	)
 	return err
 }
@@ -411,41 +947,106 @@ func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) error {
 const updateUserEmail = `-- name: UpdateUserEmail :exec
 UPDATE users
 SET email = $2
-WHERE id = $1
+WHERE id = $1 AND tenant_id = $3
 `
 
 type UpdateUserEmailParams struct {
-	ID    uuid.UUID `db:"id" json:"id"`
-	Email string    `db:"email" json:"email"`
+	ID       uuid.UUID `db:"id" json:"id"`
+	Email    string    `db:"email" json:"email"`
+	TenantID uuid.UUID `db:"tenant_id" json:"tenant_id"`
 }
 
 func (q *Queries) UpdateUserEmail(ctx context.Context, arg UpdateUserEmailParams) error {
-	_, err := q.db.Exec(ctx, updateUserEmail, arg.ID, arg.Email)
+	_, err := q.db.Exec(ctx, updateUserEmail, arg.ID, arg.Email, arg.TenantID)
 	return err
 }
 
-const updateUserPassword = `-- name: UpdateUserPassword :exec
+const updateUserPassword = `-- name: UpdateUserPassword :execrows
 UPDATE users
-SET password_hash = $2
-WHERE id = $1
+SET password_hash = $1
+WHERE id = $2 AND tenant_id = $3 AND password_hash = $4
 `
 
 type UpdateUserPasswordParams struct {
+	NewHash  string    `db:"new_hash" json:"new_hash"`
+	ID       uuid.UUID `db:"id" json:"id"`
+	TenantID uuid.UUID `db:"tenant_id" json:"tenant_id"`
+	OldHash  string    `db:"old_hash" json:"old_hash"`
+}
+
+// Replaces the password hash only while it's still old_hash, so a re-hash
+// can't undo a password change made since it was read
+func (q *Queries) UpdateUserPassword(ctx context.Context, arg UpdateUserPasswordParams) (int64, error) {
+	result, err := q.db.Exec(ctx, updateUserPassword,
+		arg.NewHash,
+		arg.ID,
+		arg.TenantID,
+		arg.OldHash,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const updateUserRole = `-- name: UpdateUserRole :execrows
+UPDATE users
+SET role = $2
+WHERE id = $1 AND tenant_id = $3
+`
+
+type UpdateUserRoleParams struct {
+	ID       uuid.UUID `db:"id" json:"id"`
+	Role     string    `db:"role" json:"role"`
+	TenantID uuid.UUID `db:"tenant_id" json:"tenant_id"`
+}
+
+func (q *Queries) UpdateUserRole(ctx context.Context, arg UpdateUserRoleParams) (int64, error) {
+	result, err := q.db.Exec(ctx, updateUserRole, arg.ID, arg.Role, arg.TenantID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const upgradeGuestUser = `-- name: UpgradeGuestUser :execrows
+UPDATE users
+SET email = $2, password_hash = $3, is_guest = FALSE
+WHERE id = $1 AND tenant_id = $4 AND is_guest
+`
+
+type UpgradeGuestUserParams struct {
 	ID           uuid.UUID `db:"id" json:"id"`
+	Email        string    `db:"email" json:"email"`
 	PasswordHash string    `db:"password_hash" json:"password_hash"`
+	TenantID     uuid.UUID `db:"tenant_id" json:"tenant_id"`
 }
 
-func (q *Queries) UpdateUserPassword(ctx context.Context, arg UpdateUserPasswordParams) error {
-	_, err := q.db.Exec(ctx, updateUserPassword, arg.ID, arg.PasswordHash)
-	return err
+// Gives a guest user an email and password, keeping its ID and data
+func (q *Queries) UpgradeGuestUser(ctx context.Context, arg UpgradeGuestUserParams) (int64, error) {
+	result, err := q.db.Exec(ctx, upgradeGuestUser,
+		arg.ID,
+		arg.Email,
+		arg.PasswordHash,
+		arg.TenantID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
 }
 
 const userExists = `-- name: UserExists :one
-SELECT EXISTS(SELECT 1 FROM users WHERE email = $1)
+SELECT EXISTS(SELECT 1 FROM users WHERE tenant_id = $1 AND email = $2)
 `
 
-func (q *Queries) UserExists(ctx context.Context, email string) (bool, error) {
-	row := q.db.QueryRow(ctx, userExists, email)
+type UserExistsParams struct {
+	TenantID uuid.UUID `db:"tenant_id" json:"tenant_id"`
+	Email    string    `db:"email" json:"email"`
+}
+
+func (q *Queries) UserExists(ctx context.Context, arg UserExistsParams) (bool, error) {
+	row := q.db.QueryRow(ctx, userExists, arg.TenantID, arg.Email)
 	var exists bool
 	err := row.Scan(&exists)
 	return exists, err
@@ -454,10 +1055,15 @@ func (q *Queries) UserExists(ctx context.Context, email string) (bool, error) {
 const verifyUserEmail = `-- name: VerifyUserEmail :exec
 UPDATE users
 SET email_verified_at = NOW()
-WHERE id = $1
+WHERE id = $1 AND tenant_id = $2
 `
 
-func (q *Queries) VerifyUserEmail(ctx context.Context, id uuid.UUID) error {
-	_, err := q.db.Exec(ctx, verifyUserEmail, id)
+type VerifyUserEmailParams struct {
+	ID       uuid.UUID `db:"id" json:"id"`
+	TenantID uuid.UUID `db:"tenant_id" json:"tenant_id"`
+}
+
+func (q *Queries) VerifyUserEmail(ctx context.Context, arg VerifyUserEmailParams) error {
+	_, err := q.db.Exec(ctx, verifyUserEmail, arg.ID, arg.TenantID)
 	return err
 }
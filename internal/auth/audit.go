@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pixperk/goiler/pkg/clock"
+	"github.com/pixperk/goiler/pkg/events"
+	"github.com/pixperk/goiler/pkg/idgen"
+)
+
+// ErrAuditUnavailable is returned when no audit reader is configured
+var ErrAuditUnavailable = errors.New("audit trail is not available")
+
+// Audit event types
+const (
+	AuditLoginSucceeded   = "auth.login_succeeded"
+	AuditLoginFailed      = "auth.login_failed"
+	AuditLogout           = "auth.logout"
+	AuditPasswordChanged  = "auth.password_changed"
+	AuditTokenRevoked     = "auth.token_revoked"
+	AuditPasskeyAdded     = "auth.passkey_added"
+	AuditGuestUpgraded    = "auth.guest_upgraded"
+	AuditIncidentDeclared = "auth.incident_declared"
+)
+
+// AuditTopicPrefix prefixes the pubsub topic of each event type, so
+// subscribers to "audit.auth.login_failed" see failed logins. The topics
+// are cataloged in pkg/events, e.g. events.AuthLoginFailed.
+const AuditTopicPrefix = events.AuditTopicPrefix
+
+// AuditEvent is a security-relevant action on an account. UserID is unset
+// for failed logins to unknown emails.
+type AuditEvent struct {
+	ID         uuid.UUID      `json:"id"`
+	Type       string         `json:"type"`
+	UserID     *uuid.UUID     `json:"user_id,omitempty"`
+	IPAddress  string         `json:"ip_address,omitempty"`
+	UserAgent  string         `json:"user_agent,omitempty"`
+	Details    map[string]any `json:"details,omitempty"`
+	OccurredAt time.Time      `json:"occurred_at"`
+}
+
+// AuditSink receives audit events
+type AuditSink interface {
+	RecordAuditEvent(ctx context.Context, event *AuditEvent) error
+}
+
+// AuditFilter selects audit events; zero fields match everything
+type AuditFilter struct {
+	UserID *uuid.UUID
+	Type   string
+	Since  time.Time
+	Limit  int
+	Offset int
+}
+
+// AuditReader queries recorded audit events
+type AuditReader interface {
+	// ListAuditEvents returns matching events, newest first
+	ListAuditEvents(ctx context.Context, filter AuditFilter) ([]*AuditEvent, error)
+}
+
+// AuditSinks records each event to every sink. All sinks are tried; the
+// errors are joined.
+type AuditSinks []AuditSink
+
+// RecordAuditEvent implements AuditSink
+func (s AuditSinks) RecordAuditEvent(ctx context.Context, event *AuditEvent) error {
+	var errs []error
+	for _, sink := range s {
+		if err := sink.RecordAuditEvent(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// AuditPublisher publishes to topics. *pubsub.PubSub satisfies it.
+type AuditPublisher = events.Publisher
+
+// PubSubAuditSink publishes audit events to AuditTopicPrefix plus the event
+// type, for in-process consumers such as alerting. Payloads are
+// events.AuthEvent values.
+type PubSubAuditSink struct {
+	publisher AuditPublisher
+}
+
+// NewPubSubAuditSink creates an audit sink publishing to publisher
+func NewPubSubAuditSink(publisher AuditPublisher) *PubSubAuditSink {
+	return &PubSubAuditSink{publisher: publisher}
+}
+
+// RecordAuditEvent implements AuditSink
+func (s *PubSubAuditSink) RecordAuditEvent(ctx context.Context, event *AuditEvent) error {
+	events.AuditTopic(event.Type).Publish(s.publisher, events.AuthEvent{
+		ID:         event.ID,
+		Type:       event.Type,
+		UserID:     event.UserID,
+		IPAddress:  event.IPAddress,
+		UserAgent:  event.UserAgent,
+		Details:    event.Details,
+		OccurredAt: event.OccurredAt,
+	})
+	return nil
+}
+
+// Auditor stamps audit events with an ID, the time and the client from the
+// context, and records them. Failures are logged rather than returned so
+// auditing never fails the action being audited. A nil Auditor records
+// nothing.
+type Auditor struct {
+	sink   AuditSink
+	logger *slog.Logger
+	clock  clock.Clock
+	ids    idgen.Generator
+}
+
+// NewAuditor creates an auditor recording to sink
+func NewAuditor(sink AuditSink, logger *slog.Logger, c clock.Clock, ids idgen.Generator) *Auditor {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Auditor{
+		sink:   sink,
+		logger: logger,
+		clock:  clock.OrReal(c),
+		ids:    idgen.OrDefault(ids),
+	}
+}
+
+// Record records an event of the given type for userID, which may be
+// uuid.Nil when the account is unknown
+func (a *Auditor) Record(ctx context.Context, eventType string, userID uuid.UUID, details map[string]any) {
+	if a == nil || a.sink == nil {
+		return
+	}
+
+	event := &AuditEvent{
+		ID:         a.ids.NewID(),
+		Type:       eventType,
+		Details:    details,
+		OccurredAt: a.clock.Now(),
+	}
+	if userID != uuid.Nil {
+		event.UserID = &userID
+	}
+	if client, ok := ClientFromContext(ctx); ok {
+		event.IPAddress = client.IPAddress
+		event.UserAgent = client.UserAgent
+	}
+
+	if err := a.sink.RecordAuditEvent(ctx, event); err != nil {
+		a.logger.ErrorContext(ctx, "failed to record audit event",
+			slog.String("type", eventType),
+			slog.String("error", err.Error()),
+		)
+	}
+}
+
+// Auditor returns the service's auditor, for recording events that happen
+// outside the service, such as password changes. It is nil when auditing is
+// off, which is safe to record to.
+func (s *Service) Auditor() *Auditor {
+	return s.auditor
+}
+
+// ListAuditEvents returns recorded audit events, newest first
+func (s *Service) ListAuditEvents(ctx context.Context, filter AuditFilter) ([]*AuditEvent, error) {
+	if s.auditReader == nil {
+		return nil, ErrAuditUnavailable
+	}
+	return s.auditReader.ListAuditEvents(ctx, filter)
+}
@@ -1,10 +1,41 @@
 package auth
 
 import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/internal/config"
+	"github.com/pixperk/goiler/internal/tenant"
+	"github.com/pixperk/goiler/pkg/authctx"
+	"github.com/pixperk/goiler/pkg/clock"
+	"github.com/pixperk/goiler/pkg/events"
+	"github.com/pixperk/goiler/pkg/idgen"
+	"github.com/pixperk/goiler/pkg/pubsub"
+	"github.com/pixperk/goiler/pkg/sandbox"
+	"github.com/pixperk/goiler/pkg/validator"
+	"golang.org/x/crypto/bcrypt"
 )
 
 // --- Password Hashing Tests ---
@@ -97,6 +128,87 @@ func TestBcryptHasher_Verify(t *testing.T) {
 	}
 }
 
+func TestArgon2Params_Validate(t *testing.T) {
+	if err := DefaultArgon2Params().Validate(); err != nil {
+		t.Fatalf("Default params should be valid: %v", err)
+	}
+
+	weak := DefaultArgon2Params()
+	weak.Memory = 8 * 1024
+	if err := weak.Validate(); !errors.Is(err, ErrWeakHashParams) {
+		t.Errorf("Expected ErrWeakHashParams for low memory, got: %v", err)
+	}
+
+	weak = DefaultArgon2Params()
+	weak.Iterations = 1
+	if err := weak.Validate(); !errors.Is(err, ErrWeakHashParams) {
+		t.Errorf("Expected ErrWeakHashParams for low iterations, got: %v", err)
+	}
+}
+
+func TestValidateBcryptCost(t *testing.T) {
+	if err := ValidateBcryptCost(12); err != nil {
+		t.Errorf("Cost 12 should be valid: %v", err)
+	}
+	if err := ValidateBcryptCost(4); !errors.Is(err, ErrWeakHashParams) {
+		t.Errorf("Expected ErrWeakHashParams for cost 4, got: %v", err)
+	}
+}
+
+func TestNewPasswordHasherFromConfig(t *testing.T) {
+	argon2Cfg := config.HashConfig{Argon2Memory: MinArgon2Memory, Argon2Iterations: 2, Argon2Parallelism: 1, BcryptCost: MinBcryptCost}
+	argon2Hasher, err := NewPasswordHasherFromConfig(argon2Cfg)
+	if err != nil {
+		t.Fatalf("Failed to create argon2id hasher: %v", err)
+	}
+
+	bcryptCfg := argon2Cfg
+	bcryptCfg.Algorithm = HashAlgorithmBcrypt
+	bcryptHasher, err := NewPasswordHasherFromConfig(bcryptCfg)
+	if err != nil {
+		t.Fatalf("Failed to create bcrypt hasher: %v", err)
+	}
+
+	bcryptHash, err := bcryptHasher.Hash("SecureP@ssw0rd!")
+	if err != nil {
+		t.Fatalf("Failed to hash: %v", err)
+	}
+	if !strings.HasPrefix(bcryptHash, "$2a$10$") {
+		t.Errorf("Expected a bcrypt hash with the configured cost, got %q", bcryptHash)
+	}
+	argon2Hash, err := argon2Hasher.Hash("SecureP@ssw0rd!")
+	if err != nil {
+		t.Fatalf("Failed to hash: %v", err)
+	}
+	if !strings.HasPrefix(argon2Hash, "$argon2id$v=19$m=19456,t=2,p=1$") {
+		t.Errorf("Expected an argon2id hash with the configured params, got %q", argon2Hash)
+	}
+
+	// Either hasher verifies both formats, so switching keeps logins working
+	for _, hasher := range []PasswordHasher{argon2Hasher, bcryptHasher} {
+		for _, hash := range []string{bcryptHash, argon2Hash} {
+			if valid, err := hasher.Verify("SecureP@ssw0rd!", hash); err != nil || !valid {
+				t.Errorf("Expected %T to verify %q, got %v, %v", hasher, hash[:8], valid, err)
+			}
+			if valid, _ := hasher.Verify("wrong-password", hash); valid {
+				t.Errorf("Expected %T to reject a wrong password", hasher)
+			}
+		}
+	}
+
+	bcryptCfg.BcryptCost = 4
+	if _, err := NewPasswordHasherFromConfig(bcryptCfg); !errors.Is(err, ErrWeakHashParams) {
+		t.Errorf("Expected ErrWeakHashParams for a low bcrypt cost, got: %v", err)
+	}
+	argon2Cfg.Argon2Iterations = 1
+	if _, err := NewPasswordHasherFromConfig(argon2Cfg); !errors.Is(err, ErrWeakHashParams) {
+		t.Errorf("Expected ErrWeakHashParams for low argon2 iterations, got: %v", err)
+	}
+	if _, err := NewPasswordHasherFromConfig(config.HashConfig{Algorithm: "md5"}); err == nil {
+		t.Errorf("Expected an unknown algorithm to be rejected")
+	}
+}
+
 // --- JWT Tests ---
 
 func TestJWTMaker_CreateToken(t *testing.T) {
@@ -319,6 +431,619 @@ func TestTokenPayload_Expired(t *testing.T) {
 	}
 }
 
+func TestJWTMaker_AudienceMismatch(t *testing.T) {
+	secret := "12345678901234567890123456789012"
+	staging, _ := NewJWTMaker(secret, WithIssuer("goiler"), WithAudience("staging"))
+	production, _ := NewJWTMaker(secret, WithIssuer("goiler"), WithAudience("production"))
+
+	token, _, err := staging.CreateToken(uuid.New(), "test@example.com", "user", AccessToken, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+
+	if _, err := staging.VerifyToken(token); err != nil {
+		t.Fatalf("Token should verify for its own audience: %v", err)
+	}
+	if _, err := production.VerifyToken(token); err != ErrInvalidToken {
+		t.Errorf("Expected ErrInvalidToken for another audience, got: %v", err)
+	}
+}
+
+func TestJWTMaker_IssuerMismatch(t *testing.T) {
+	secret := "12345678901234567890123456789012"
+	issuer, _ := NewJWTMaker(secret, WithIssuer("goiler-staging"))
+	verifier, _ := NewJWTMaker(secret, WithIssuer("goiler-production"))
+
+	token, _, err := issuer.CreateToken(uuid.New(), "test@example.com", "user", AccessToken, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+
+	if _, err := verifier.VerifyToken(token); err != ErrInvalidToken {
+		t.Errorf("Expected ErrInvalidToken for another issuer, got: %v", err)
+	}
+}
+
+func TestPASETOMaker_AudienceMismatch(t *testing.T) {
+	key := []byte("12345678901234567890123456789012")
+	staging, _ := NewPASETOMaker(key, WithAudience("staging"))
+	production, _ := NewPASETOMaker(key, WithAudience("production"))
+
+	token, _, err := staging.CreateToken(uuid.New(), "test@example.com", "user", AccessToken, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+
+	if _, err := staging.VerifyToken(token); err != nil {
+		t.Fatalf("Token should verify for its own audience: %v", err)
+	}
+	if _, err := production.VerifyToken(token); err != ErrInvalidToken {
+		t.Errorf("Expected ErrInvalidToken for another audience, got: %v", err)
+	}
+}
+
+func TestTokenPayload_Leeway(t *testing.T) {
+	payload, _ := NewTokenPayload(uuid.New(), "test@example.com", "user", AccessToken, -10*time.Second)
+
+	if err := payload.Validate(TokenOptions{}); err != ErrExpiredToken {
+		t.Errorf("Expected ErrExpiredToken without leeway, got: %v", err)
+	}
+	if err := payload.Validate(TokenOptions{Leeway: time.Minute}); err != nil {
+		t.Errorf("Expected token to be valid within leeway, got: %v", err)
+	}
+}
+
+func TestTokenPayload_NotBefore(t *testing.T) {
+	payload, _ := NewTokenPayload(uuid.New(), "test@example.com", "user", AccessToken, time.Hour)
+	payload.NotBefore = time.Now().Add(time.Minute)
+
+	if err := payload.Valid(); err != ErrTokenNotYetValid {
+		t.Errorf("Expected ErrTokenNotYetValid, got: %v", err)
+	}
+}
+
+// --- Service Tests ---
+
+// memoryUserRepo is a minimal in-memory UserRepository for service tests.
+// Users are keyed by email, and guests by ID.
+type memoryUserRepo struct {
+	users map[string]*User
+}
+
+func newMemoryUserRepo() *memoryUserRepo {
+	return &memoryUserRepo{users: make(map[string]*User)}
+}
+
+func (r *memoryUserRepo) Create(ctx context.Context, user *User) error {
+	if user.Guest {
+		r.users[user.ID.String()] = user
+		return nil
+	}
+	r.users[user.Email] = user
+	return nil
+}
+
+func (r *memoryUserRepo) GetByID(ctx context.Context, id uuid.UUID) (*User, error) {
+	for _, user := range r.users {
+		if user.ID == id {
+			return user, nil
+		}
+	}
+	return nil, ErrUserNotFound
+}
+
+func (r *memoryUserRepo) GetByEmail(ctx context.Context, email string) (*User, error) {
+	if user, ok := r.users[email]; ok {
+		return user, nil
+	}
+	return nil, ErrUserNotFound
+}
+
+func (r *memoryUserRepo) Update(ctx context.Context, user *User) error {
+	r.users[user.Email] = user
+	return nil
+}
+
+func (r *memoryUserRepo) UpdatePassword(ctx context.Context, id uuid.UUID, oldHash, newHash string) (bool, error) {
+	for _, user := range r.users {
+		if user.ID == id {
+			if user.PasswordHash != oldHash {
+				return false, nil
+			}
+			user.PasswordHash = newHash
+			return true, nil
+		}
+	}
+	return false, ErrUserNotFound
+}
+
+func (r *memoryUserRepo) Delete(ctx context.Context, id uuid.UUID) error {
+	for email, user := range r.users {
+		if user.ID == id {
+			delete(r.users, email)
+		}
+	}
+	return nil
+}
+
+func (r *memoryUserRepo) MarkEmailVerified(ctx context.Context, id uuid.UUID) error {
+	for _, user := range r.users {
+		if user.ID == id {
+			user.EmailVerified = true
+			return nil
+		}
+	}
+	return ErrUserNotFound
+}
+
+func (r *memoryUserRepo) UpgradeGuest(ctx context.Context, id uuid.UUID, email, passwordHash string) error {
+	if _, ok := r.users[email]; ok {
+		return ErrUserAlreadyExists
+	}
+	guest, ok := r.users[id.String()]
+	if !ok {
+		return ErrUserNotFound
+	}
+	delete(r.users, id.String())
+	upgraded := *guest
+	upgraded.Email = email
+	upgraded.PasswordHash = passwordHash
+	upgraded.Guest = false
+	r.users[email] = &upgraded
+	return nil
+}
+
+// countingHasher counts Verify calls
+type countingHasher struct {
+	PasswordHasher
+	verifies int
+}
+
+func (h *countingHasher) Verify(password, hash string) (bool, error) {
+	h.verifies++
+	return h.PasswordHasher.Verify(password, hash)
+}
+
+func newTestService(t *testing.T, cfg ServiceConfig) *Service {
+	t.Helper()
+	maker, err := NewJWTMaker("this-is-a-very-secure-secret-key-32chars")
+	if err != nil {
+		t.Fatalf("Failed to create JWT maker: %v", err)
+	}
+	cfg.TokenMaker = maker
+	if cfg.UserRepo == nil {
+		cfg.UserRepo = newMemoryUserRepo()
+	}
+	return NewService(cfg)
+}
+
+// racingUserRepo misses an existing email on lookup, as when a concurrent
+// registration commits between the check and the insert, and rejects it on
+// create like the unique index does
+type racingUserRepo struct {
+	*memoryUserRepo
+}
+
+func (r racingUserRepo) GetByEmail(ctx context.Context, email string) (*User, error) {
+	return nil, ErrUserNotFound
+}
+
+func (r racingUserRepo) Create(ctx context.Context, user *User) error {
+	if _, ok := r.users[user.Email]; ok {
+		return ErrUserAlreadyExists
+	}
+	return r.memoryUserRepo.Create(ctx, user)
+}
+
+func TestHandler_RegisterDuplicateRaceIsConflict(t *testing.T) {
+	repo := racingUserRepo{newMemoryUserRepo()}
+	svc := newTestService(t, ServiceConfig{Hasher: NewBcryptHasher(MinBcryptCost), UserRepo: repo})
+	h := NewHandler(svc)
+	e := echo.New()
+	e.Validator = validator.New()
+
+	register := func() int {
+		body := `{"email":"race@example.com","password":"SecureP@ssw0rd!"}`
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/register", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		if err := h.Register(e.NewContext(req, rec)); err != nil {
+			t.Fatalf("Register returned an error: %v", err)
+		}
+		return rec.Code
+	}
+
+	if code := register(); code != http.StatusCreated {
+		t.Fatalf("Expected the first registration to succeed, got %d", code)
+	}
+	if code := register(); code != http.StatusConflict {
+		t.Errorf("Expected 409 for the racing duplicate, got %d", code)
+	}
+}
+
+func TestService_LoginUnknownEmailVerifiesHash(t *testing.T) {
+	hasher := &countingHasher{PasswordHasher: NewBcryptHasher(MinBcryptCost)}
+	svc := newTestService(t, ServiceConfig{Hasher: hasher})
+
+	_, err := svc.Login(context.Background(), &LoginRequest{Email: "nobody@example.com", Password: "whatever"})
+	if !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("Expected ErrInvalidCredentials, got: %v", err)
+	}
+	if hasher.verifies != 1 {
+		t.Errorf("Expected one hash verification for unknown email, got %d", hasher.verifies)
+	}
+}
+
+func TestService_LoginUpgradesPasswordHash(t *testing.T) {
+	hasher, err := NewPasswordHasherFromConfig(config.HashConfig{
+		Argon2Memory: MinArgon2Memory, Argon2Iterations: 2, Argon2Parallelism: 1,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create hasher: %v", err)
+	}
+	oldArgon2, _ := NewArgon2Hasher(&Argon2Params{Memory: MinArgon2Memory, Iterations: 3, Parallelism: 1, SaltLength: 16, KeyLength: 32}).Hash("SecureP@ssw0rd!")
+	oldBcrypt, _ := NewBcryptHasher(bcrypt.MinCost).Hash("SecureP@ssw0rd!")
+	current, _ := hasher.Hash("SecureP@ssw0rd!")
+
+	tests := []struct {
+		name     string
+		hash     string
+		upgraded bool
+	}{
+		{"bcrypt", oldBcrypt, true},
+		{"old argon2 params", oldArgon2, true},
+		{"current", current, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newMemoryUserRepo()
+			repo.Create(context.Background(), &User{ID: uuid.New(), Email: "user@example.com", PasswordHash: tt.hash, Role: "user"})
+			svc := newTestService(t, ServiceConfig{Hasher: hasher, UserRepo: repo})
+
+			if _, err := svc.Login(context.Background(), &LoginRequest{Email: "user@example.com", Password: "SecureP@ssw0rd!"}); err != nil {
+				t.Fatalf("Login failed: %v", err)
+			}
+
+			stored := repo.users["user@example.com"].PasswordHash
+			if upgraded := stored != tt.hash; upgraded != tt.upgraded {
+				t.Fatalf("Expected upgraded=%v, stored hash %q", tt.upgraded, stored)
+			}
+			if NeedsRehash(hasher, stored) {
+				t.Errorf("Expected the stored hash to use the current params, got %q", stored)
+			}
+			if _, err := svc.Login(context.Background(), &LoginRequest{Email: "user@example.com", Password: "SecureP@ssw0rd!"}); err != nil {
+				t.Errorf("Login with the upgraded hash failed: %v", err)
+			}
+		})
+	}
+}
+
+// changingUserRepo changes the password right after each lookup, as when a
+// password reset commits while a login verifies the old password
+type changingUserRepo struct {
+	*memoryUserRepo
+	newHash string
+}
+
+func (r changingUserRepo) GetByEmail(ctx context.Context, email string) (*User, error) {
+	user, err := r.memoryUserRepo.GetByEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	read := *user
+	user.PasswordHash = r.newHash
+	return &read, nil
+}
+
+func TestService_LoginRehashKeepsConcurrentPasswordChange(t *testing.T) {
+	oldHash, _ := NewBcryptHasher(bcrypt.MinCost).Hash("SecureP@ssw0rd!")
+	repo := changingUserRepo{memoryUserRepo: newMemoryUserRepo(), newHash: "changed"}
+	repo.Create(context.Background(), &User{ID: uuid.New(), Email: "user@example.com", PasswordHash: oldHash, Role: "user"})
+	svc := newTestService(t, ServiceConfig{Hasher: NewBcryptHasher(MinBcryptCost), UserRepo: repo})
+
+	if _, err := svc.Login(context.Background(), &LoginRequest{Email: "user@example.com", Password: "SecureP@ssw0rd!"}); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	if stored := repo.users["user@example.com"].PasswordHash; stored != "changed" {
+		t.Errorf("Expected the re-hash not to overwrite the new password, got %q", stored)
+	}
+}
+
+func TestService_SuspendedAccount(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryUserRepo()
+	tokens := newMemoryTokenRepo()
+	svc := newTestService(t, ServiceConfig{Hasher: NewBcryptHasher(MinBcryptCost), UserRepo: repo, TokenRepo: tokens})
+
+	result, err := svc.Register(ctx, &RegisterRequest{Email: "user@example.com", Password: "SecureP@ssw0rd!"})
+	if err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	userID := result.User.ID
+	repo.users["user@example.com"].Suspended = true
+
+	if err := svc.RevokeUserTokens(ctx, userID, "suspended"); err != nil {
+		t.Fatalf("RevokeUserTokens failed: %v", err)
+	}
+	if active, _ := tokens.ListRefreshTokens(ctx, userID); len(active) != 0 {
+		t.Errorf("Expected every refresh token to be revoked, got %d active", len(active))
+	}
+
+	_, err = svc.Login(ctx, &LoginRequest{Email: "user@example.com", Password: "SecureP@ssw0rd!"})
+	if !errors.Is(err, ErrAccountSuspended) {
+		t.Errorf("Expected ErrAccountSuspended from Login, got: %v", err)
+	}
+	if _, err := svc.Login(ctx, &LoginRequest{Email: "user@example.com", Password: "wrong-password"}); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected a wrong password to stay ErrInvalidCredentials, got: %v", err)
+	}
+	if _, err := svc.CompleteLogin(ctx, userID, "passkey"); !errors.Is(err, ErrAccountSuspended) {
+		t.Errorf("Expected ErrAccountSuspended from CompleteLogin, got: %v", err)
+	}
+
+	repo.users["user@example.com"].Suspended = false
+	if _, err := svc.Login(ctx, &LoginRequest{Email: "user@example.com", Password: "SecureP@ssw0rd!"}); err != nil {
+		t.Errorf("Expected login to work once unsuspended, got: %v", err)
+	}
+}
+
+func TestService_MinFailureDuration(t *testing.T) {
+	svc := newTestService(t, ServiceConfig{
+		Hasher:             NewBcryptHasher(MinBcryptCost),
+		MinFailureDuration: 200 * time.Millisecond,
+	})
+
+	start := time.Now()
+	_, err := svc.Login(context.Background(), &LoginRequest{Email: "nobody@example.com", Password: "whatever"})
+	if !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("Expected ErrInvalidCredentials, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("Failed login returned after %v, expected at least 200ms", elapsed)
+	}
+}
+
+func TestFingerprint_Matches(t *testing.T) {
+	base := ClientInfo{IPAddress: "203.0.113.10", UserAgent: "Firefox"}.Fingerprint()
+
+	sameNetwork := ClientInfo{IPAddress: "203.0.113.99", UserAgent: "Firefox"}.Fingerprint()
+	if !base.Matches(sameNetwork) {
+		t.Error("Address change within the same /24 should match")
+	}
+
+	newNetwork := ClientInfo{IPAddress: "198.51.100.7", UserAgent: "Firefox"}.Fingerprint()
+	if !base.Matches(newNetwork) {
+		t.Error("Network change alone should match")
+	}
+
+	different := ClientInfo{IPAddress: "198.51.100.7", UserAgent: "curl"}.Fingerprint()
+	if base.Matches(different) {
+		t.Error("Network and user agent change should not match")
+	}
+
+	device := ClientInfo{IPAddress: "198.51.100.7", UserAgent: "curl", DeviceID: "device-1"}.Fingerprint()
+	sameDevice := ClientInfo{IPAddress: "203.0.113.10", UserAgent: "Firefox", DeviceID: "device-1"}.Fingerprint()
+	if !device.Matches(sameDevice) {
+		t.Error("Same device ID should match regardless of other attributes")
+	}
+
+	// A stolen token replayed without the device header, copying the rest
+	withoutDevice := ClientInfo{IPAddress: "198.51.100.7", UserAgent: "curl"}.Fingerprint()
+	if device.Matches(withoutDevice) {
+		t.Error("Missing device ID should not match a device-bound fingerprint")
+	}
+}
+
+// recordingNotifier records security events
+type recordingNotifier struct {
+	events []SecurityEvent
+}
+
+func (n *recordingNotifier) NotifySecurityEvent(ctx context.Context, event SecurityEvent) error {
+	n.events = append(n.events, event)
+	return nil
+}
+
+func TestService_RefreshFingerprintMismatch(t *testing.T) {
+	notifier := &recordingNotifier{}
+	svc := newTestService(t, ServiceConfig{
+		Hasher:            NewBcryptHasher(MinBcryptCost),
+		BindRefreshTokens: true,
+		Notifier:          notifier,
+	})
+
+	laptop := ContextWithClient(context.Background(), ClientInfo{IPAddress: "203.0.113.10", UserAgent: "Firefox"})
+	tokens, err := svc.Register(laptop, &RegisterRequest{Email: "user@example.com", Password: "SecureP@ssw0rd!"})
+	if err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+
+	if _, err := svc.RefreshToken(laptop, tokens.RefreshToken); err != nil {
+		t.Fatalf("Refresh from the same client should succeed: %v", err)
+	}
+
+	elsewhere := ContextWithClient(context.Background(), ClientInfo{IPAddress: "198.51.100.7", UserAgent: "curl"})
+	if _, err := svc.RefreshToken(elsewhere, tokens.RefreshToken); !errors.Is(err, ErrStepUpRequired) {
+		t.Fatalf("Expected ErrStepUpRequired, got: %v", err)
+	}
+	if len(notifier.events) != 1 || notifier.events[0].Type != SecurityEventRefreshFingerprintMismatch {
+		t.Errorf("Expected one fingerprint mismatch notification, got: %+v", notifier.events)
+	}
+}
+
+func TestService_FailedLoginAlert(t *testing.T) {
+	notifier := &recordingNotifier{}
+	tokens := newMemoryTokenRepo()
+	svc := newTestService(t, ServiceConfig{
+		Hasher:    NewBcryptHasher(MinBcryptCost),
+		TokenRepo: tokens,
+		Notifier:  notifier,
+		Lockout:   LockoutPolicy{Window: time.Hour},
+		LoginAlerts: LoginAlertPolicy{
+			FailedAttempts:      3,
+			SecureAccountURL:    "https://app.example.com/secure-account",
+			SecureAccountExpiry: time.Hour,
+		},
+	})
+
+	ctx := ContextWithClient(context.Background(), ClientInfo{IPAddress: "203.0.113.10", UserAgent: "curl", Country: "NL"})
+	registered, err := svc.Register(ctx, &RegisterRequest{Email: "user@example.com", Password: "SecureP@ssw0rd!"})
+	if err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+
+	for i := 0; i < 4; i++ {
+		if _, err := svc.Login(ctx, &LoginRequest{Email: "user@example.com", Password: "wrong"}); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("Expected ErrInvalidCredentials, got: %v", err)
+		}
+	}
+
+	if len(notifier.events) != 1 {
+		t.Fatalf("Expected one notification at the threshold, got: %+v", notifier.events)
+	}
+	event := notifier.events[0]
+	if event.Type != SecurityEventFailedLogins || event.FailedAttempts != 3 || event.Country != "NL" || event.UserAgent != "curl" {
+		t.Errorf("Expected a failed login event with client details, got: %+v", event)
+	}
+
+	link, err := url.Parse(event.SecureAccountURL)
+	if err != nil || link.Query().Get("token") == "" {
+		t.Fatalf("Expected a secure account link, got %q", event.SecureAccountURL)
+	}
+	if err := svc.SecureAccount(context.Background(), link.Query().Get("token")); err != nil {
+		t.Fatalf("SecureAccount failed: %v", err)
+	}
+	if revoked, _ := tokens.IsRefreshTokenRevoked(context.Background(), mustRefreshTokenID(t, svc, registered.RefreshToken)); !revoked {
+		t.Error("Expected securing the account to revoke its refresh tokens")
+	}
+
+	if err := svc.SecureAccount(context.Background(), registered.AccessToken); !errors.Is(err, ErrInvalidSecureAccountToken) {
+		t.Errorf("Expected access tokens to be rejected, got: %v", err)
+	}
+}
+
+func mustRefreshTokenID(t *testing.T, svc *Service, token string) uuid.UUID {
+	t.Helper()
+	payload, err := svc.tokenMaker.VerifyToken(token)
+	if err != nil {
+		t.Fatalf("Failed to verify refresh token: %v", err)
+	}
+	return payload.ID
+}
+
+func TestHandler_SecureAccount(t *testing.T) {
+	tokens := newMemoryTokenRepo()
+	svc := newTestService(t, ServiceConfig{Hasher: NewBcryptHasher(MinBcryptCost), TokenRepo: tokens})
+	ctx := context.Background()
+	registered, err := svc.Register(ctx, &RegisterRequest{Email: "user@example.com", Password: "SecureP@ssw0rd!"})
+	if err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+	user, err := svc.userRepo.GetByEmail(ctx, "user@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	link, err := svc.tokenLink(user, SecureAccountToken, time.Hour, "https://api.example.com/api/v1/auth/secure-account")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, _ := url.Parse(link)
+	token := parsed.Query().Get("token")
+
+	h := NewHandler(svc)
+	e := echo.New()
+	e.Validator = validator.New()
+	e.GET("/api/v1/auth/secure-account", h.SecureAccountPage)
+	e.POST("/api/v1/auth/secure-account", h.SecureAccount)
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+	revoked := func() bool {
+		revoked, _ := tokens.IsRefreshTokenRevoked(ctx, mustRefreshTokenID(t, svc, registered.RefreshToken))
+		return revoked
+	}
+
+	// Opening the link, as mail scanners and link previews do, only shows
+	// the confirmation page
+	rec := serve(httptest.NewRequest(http.MethodGet, "/api/v1/auth/secure-account?token="+url.QueryEscape(token), nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `method="post"`) || !strings.Contains(rec.Body.String(), token) {
+		t.Fatalf("Expected a confirmation form, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("Expected the page not to be cached, got %q", rec.Header().Get("Cache-Control"))
+	}
+	if revoked() {
+		t.Fatal("Expected GET not to sign the account out")
+	}
+
+	if rec := serve(httptest.NewRequest(http.MethodGet, "/api/v1/auth/secure-account", nil)); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without a token, got %d", rec.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/secure-account", strings.NewReader(`{"token":"invalid"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if rec := serve(req); rec.Code != http.StatusBadRequest || !strings.HasPrefix(rec.Header().Get(echo.HeaderContentType), echo.MIMEApplicationJSON) {
+		t.Errorf("Expected a JSON 400 for an invalid token, got %d %s", rec.Code, rec.Header().Get(echo.HeaderContentType))
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/auth/secure-account", strings.NewReader(url.Values{"token": {token}}.Encode()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	rec = serve(req)
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get(echo.HeaderContentType), echo.MIMETextHTML) {
+		t.Fatalf("Expected an HTML page for the form post, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if !revoked() {
+		t.Error("Expected the form post to revoke the refresh tokens")
+	}
+}
+
+func TestService_NewCountryLoginAlert(t *testing.T) {
+	notifier := &recordingNotifier{}
+	svc := newTestService(t, ServiceConfig{
+		Hasher:      NewBcryptHasher(MinBcryptCost),
+		Notifier:    notifier,
+		LoginAlerts: LoginAlertPolicy{NewCountry: true},
+	})
+	if _, err := svc.Register(context.Background(), &RegisterRequest{Email: "user@example.com", Password: "SecureP@ssw0rd!"}); err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+
+	for _, country := range []string{"NL", "NL", "", "BR", "NL", "BR"} {
+		ctx := ContextWithClient(context.Background(), ClientInfo{IPAddress: "203.0.113.10", Country: country})
+		if _, err := svc.Login(ctx, &LoginRequest{Email: "user@example.com", Password: "SecureP@ssw0rd!"}); err != nil {
+			t.Fatalf("Login failed: %v", err)
+		}
+	}
+
+	if len(notifier.events) != 1 || notifier.events[0].Type != SecurityEventNewCountryLogin || notifier.events[0].Country != "BR" {
+		t.Errorf("Expected one new country notification for BR, got: %+v", notifier.events)
+	}
+}
+
+func TestClientCountry(t *testing.T) {
+	e := echo.New()
+	tests := map[string]string{"nl": "NL", "XX": "", "T1": "", "": "", "Netherlands": ""}
+
+	for header, want := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("CF-IPCountry", header)
+		c := e.NewContext(req, httptest.NewRecorder())
+
+		var got string
+		handler := ClientCountry("CF-IPCountry")(func(c echo.Context) error {
+			client, _ := ClientFromContext(ClientContext(c))
+			got = client.Country
+			return nil
+		})
+		handler(c)
+		if got != want {
+			t.Errorf("%q: expected country %q, got %q", header, want, got)
+		}
+	}
+}
+
 // --- Benchmark Tests ---
 
 func BenchmarkArgon2Hash(b *testing.B) {
@@ -393,3 +1118,2289 @@ func BenchmarkPASETOVerifyToken(b *testing.B) {
 		_, _ = maker.VerifyToken(token)
 	}
 }
+
+// recordingMailer records verification links
+type recordingMailer struct {
+	links []string
+}
+
+func (m *recordingMailer) SendVerificationEmail(ctx context.Context, user *User, link string) error {
+	m.links = append(m.links, link)
+	return nil
+}
+
+func TestService_EmailVerification(t *testing.T) {
+	mailer := &recordingMailer{}
+	svc := newTestService(t, ServiceConfig{
+		Hasher:                   NewBcryptHasher(MinBcryptCost),
+		RequireEmailVerification: true,
+		VerificationURL:          "https://app.example.com/verify?lang=en",
+		Mailer:                   mailer,
+	})
+	ctx := context.Background()
+
+	result, err := svc.Register(ctx, &RegisterRequest{Email: "new@example.com", Password: "password123"})
+	if err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+	if !result.VerificationRequired || result.AccessToken != "" {
+		t.Error("Registration should not issue tokens while verification is required")
+	}
+	if len(mailer.links) != 1 {
+		t.Fatalf("Expected one verification email, got %d", len(mailer.links))
+	}
+
+	login := &LoginRequest{Email: "new@example.com", Password: "password123"}
+	if _, err := svc.Login(ctx, login); !errors.Is(err, ErrEmailNotVerified) {
+		t.Fatalf("Expected ErrEmailNotVerified, got: %v", err)
+	}
+
+	link, err := url.Parse(mailer.links[0])
+	if err != nil {
+		t.Fatalf("Invalid verification link: %v", err)
+	}
+	if link.Query().Get("lang") != "en" {
+		t.Error("Verification link should keep existing query parameters")
+	}
+	token := link.Query().Get("token")
+
+	if _, err := svc.ValidateToken(ctx, token); err == nil {
+		t.Error("Verification token should not be accepted as an access token")
+	}
+	if err := svc.VerifyEmail(ctx, token); err != nil {
+		t.Fatalf("Failed to verify email: %v", err)
+	}
+	if _, err := svc.Login(ctx, login); err != nil {
+		t.Errorf("Login should succeed after verification, got: %v", err)
+	}
+}
+
+func TestService_CreateGuestDisabled(t *testing.T) {
+	svc := newTestService(t, ServiceConfig{})
+
+	if _, err := svc.CreateGuest(context.Background()); !errors.Is(err, ErrGuestAccountsDisabled) {
+		t.Errorf("Expected ErrGuestAccountsDisabled, got: %v", err)
+	}
+}
+
+func TestService_UpgradeGuest(t *testing.T) {
+	repo := newMemoryUserRepo()
+	mailer := &recordingMailer{}
+	svc := newTestService(t, ServiceConfig{
+		UserRepo:      repo,
+		Hasher:        NewBcryptHasher(MinBcryptCost),
+		GuestAccounts: true,
+		Mailer:        mailer,
+	})
+	ctx := context.Background()
+
+	guest, err := svc.CreateGuest(ctx)
+	if err != nil {
+		t.Fatalf("Failed to create guest: %v", err)
+	}
+	if !guest.User.Guest || guest.User.Email != "" || guest.AccessToken == "" {
+		t.Fatalf("Expected a guest with tokens and no email, got %+v", guest.User)
+	}
+	payload, err := svc.ValidateToken(ctx, guest.AccessToken)
+	if err != nil {
+		t.Fatalf("Guest access token rejected: %v", err)
+	}
+	if len(mailer.links) != 0 {
+		t.Error("Guests should not be sent verification emails")
+	}
+
+	if _, err := svc.Register(ctx, &RegisterRequest{Email: "taken@example.com", Password: "password123"}); err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+	_, err = svc.UpgradeGuest(ctx, payload.UserID, &UpgradeGuestRequest{Email: "taken@example.com", Password: "password123"})
+	if !errors.Is(err, ErrUserAlreadyExists) {
+		t.Fatalf("Expected ErrUserAlreadyExists, got: %v", err)
+	}
+
+	upgraded, err := svc.UpgradeGuest(ctx, payload.UserID, &UpgradeGuestRequest{Email: "guest@example.com", Password: "password123"})
+	if err != nil {
+		t.Fatalf("Failed to upgrade guest: %v", err)
+	}
+	if upgraded.User.ID != payload.UserID {
+		t.Errorf("Upgrade should keep the user ID %s, got %s", payload.UserID, upgraded.User.ID)
+	}
+	if upgraded.User.Guest || upgraded.User.Email != "guest@example.com" || upgraded.AccessToken == "" {
+		t.Errorf("Expected a full account with tokens, got %+v", upgraded.User)
+	}
+	if len(mailer.links) != 2 {
+		t.Errorf("Expected a verification email for the upgraded account, got %d emails", len(mailer.links))
+	}
+
+	result, err := svc.Login(ctx, &LoginRequest{Email: "guest@example.com", Password: "password123"})
+	if err != nil {
+		t.Fatalf("Login after upgrade failed: %v", err)
+	}
+	if result.User.ID != payload.UserID {
+		t.Errorf("Login should reach the upgraded guest, got user %s", result.User.ID)
+	}
+
+	_, err = svc.UpgradeGuest(ctx, payload.UserID, &UpgradeGuestRequest{Email: "again@example.com", Password: "password123"})
+	if !errors.Is(err, ErrNotGuest) {
+		t.Errorf("Expected ErrNotGuest upgrading a full account, got: %v", err)
+	}
+}
+
+func TestService_VerifyEmailRejectsOtherTokens(t *testing.T) {
+	svc := newTestService(t, ServiceConfig{Hasher: NewBcryptHasher(MinBcryptCost)})
+	ctx := context.Background()
+
+	result, err := svc.Register(ctx, &RegisterRequest{Email: "user@example.com", Password: "password123"})
+	if err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+
+	if err := svc.VerifyEmail(ctx, result.AccessToken); !errors.Is(err, ErrInvalidVerificationToken) {
+		t.Errorf("Expected ErrInvalidVerificationToken for an access token, got: %v", err)
+	}
+}
+
+func TestService_AccountLockout(t *testing.T) {
+	svc := newTestService(t, ServiceConfig{
+		Hasher: NewBcryptHasher(MinBcryptCost),
+		Lockout: LockoutPolicy{
+			MaxAttempts: 3,
+			Duration:    time.Minute,
+			MaxDuration: time.Hour,
+			Window:      15 * time.Minute,
+		},
+	})
+	ctx := context.Background()
+
+	if _, err := svc.Register(ctx, &RegisterRequest{Email: "locked@example.com", Password: "correct-password"}); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		_, err := svc.Login(ctx, &LoginRequest{Email: "locked@example.com", Password: "wrong-password"})
+		if !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("Attempt %d: expected ErrInvalidCredentials, got: %v", i+1, err)
+		}
+	}
+
+	// The correct password is rejected while locked
+	_, err := svc.Login(ctx, &LoginRequest{Email: "LOCKED@example.com", Password: "correct-password"})
+	if !errors.Is(err, ErrAccountLocked) {
+		t.Fatalf("Expected ErrAccountLocked, got: %v", err)
+	}
+	var lockout *LockoutError
+	if !errors.As(err, &lockout) || lockout.RetryAfter <= 0 || lockout.RetryAfter > time.Minute {
+		t.Errorf("Expected a retry after of at most a minute, got: %v", err)
+	}
+}
+
+func TestService_LockoutResetOnSuccess(t *testing.T) {
+	svc := newTestService(t, ServiceConfig{
+		Hasher: NewBcryptHasher(MinBcryptCost),
+		Lockout: LockoutPolicy{
+			MaxAttempts: 2,
+			Duration:    time.Minute,
+			MaxDuration: time.Hour,
+			Window:      15 * time.Minute,
+		},
+	})
+	ctx := context.Background()
+
+	if _, err := svc.Register(ctx, &RegisterRequest{Email: "reset@example.com", Password: "correct-password"}); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := svc.Login(ctx, &LoginRequest{Email: "reset@example.com", Password: "wrong-password"}); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("Expected ErrInvalidCredentials, got: %v", err)
+		}
+		if _, err := svc.Login(ctx, &LoginRequest{Email: "reset@example.com", Password: "correct-password"}); err != nil {
+			t.Fatalf("Expected login to succeed, got: %v", err)
+		}
+	}
+}
+
+func TestService_LockoutConcurrentGuesses(t *testing.T) {
+	svc := newTestService(t, ServiceConfig{
+		Hasher: NewBcryptHasher(MinBcryptCost),
+		Lockout: LockoutPolicy{
+			MaxAttempts: 3,
+			Duration:    time.Minute,
+			MaxDuration: time.Hour,
+			Window:      15 * time.Minute,
+		},
+	})
+	ctx := context.Background()
+
+	if _, err := svc.Register(ctx, &RegisterRequest{Email: "parallel@example.com", Password: "correct-password"}); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, 20)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = svc.Login(ctx, &LoginRequest{Email: "parallel@example.com", Password: "wrong-password"})
+		}()
+	}
+	wg.Wait()
+
+	verified := 0
+	for _, err := range errs {
+		switch {
+		case errors.Is(err, ErrInvalidCredentials):
+			verified++
+		case !errors.Is(err, ErrAccountLocked):
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if verified != 3 {
+		t.Errorf("Expected 3 guesses to be checked before the lockout, got %d", verified)
+	}
+}
+
+func TestService_IPLockout(t *testing.T) {
+	svc := newTestService(t, ServiceConfig{
+		Hasher: NewBcryptHasher(MinBcryptCost),
+		Lockout: LockoutPolicy{
+			MaxIPAttempts: 2,
+			Duration:      time.Minute,
+			MaxDuration:   time.Hour,
+			Window:        15 * time.Minute,
+		},
+	})
+	attacker := ContextWithClient(context.Background(), ClientInfo{IPAddress: "203.0.113.7"})
+	other := ContextWithClient(context.Background(), ClientInfo{IPAddress: "198.51.100.1"})
+
+	for _, email := range []string{"a@example.com", "b@example.com"} {
+		if _, err := svc.Login(attacker, &LoginRequest{Email: email, Password: "guess"}); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("Expected ErrInvalidCredentials, got: %v", err)
+		}
+	}
+
+	if _, err := svc.Login(attacker, &LoginRequest{Email: "c@example.com", Password: "guess"}); !errors.Is(err, ErrAccountLocked) {
+		t.Errorf("Expected the IP to be locked out, got: %v", err)
+	}
+	if _, err := svc.Login(other, &LoginRequest{Email: "c@example.com", Password: "guess"}); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected other clients to be unaffected, got: %v", err)
+	}
+}
+
+func TestLockoutPolicy_Backoff(t *testing.T) {
+	policy := LockoutPolicy{MaxAttempts: 3, Duration: time.Minute, MaxDuration: 10 * time.Minute, Window: time.Hour}
+	last := time.Now()
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{2, 0},
+		{3, time.Minute},
+		{4, 2 * time.Minute},
+		{5, 4 * time.Minute},
+		{6, 8 * time.Minute},
+		{7, 10 * time.Minute},
+		{100, 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		until := policy.lockedUntil(LoginAttempts{Failures: tt.failures, LastFailure: last}, policy.MaxAttempts)
+		var got time.Duration
+		if !until.IsZero() {
+			got = until.Sub(last)
+		}
+		if got != tt.want {
+			t.Errorf("%d failures: expected lockout %v, got %v", tt.failures, tt.want, got)
+		}
+	}
+}
+
+func TestMemoryLoginAttemptStore(t *testing.T) {
+	store := NewMemoryLoginAttemptStore(nil)
+	ctx := context.Background()
+
+	for i := 1; i <= 2; i++ {
+		if recorded, err := store.RecordFailure(ctx, "account:a@example.com", i-1, time.Minute); err != nil || !recorded {
+			t.Fatalf("Expected failure %d to be recorded, got %v (%v)", i, recorded, err)
+		}
+	}
+	if attempts, _ := store.Get(ctx, "account:a@example.com"); attempts.Failures != 2 {
+		t.Fatalf("Expected 2 failures, got %d", attempts.Failures)
+	}
+
+	// A failure recorded on a stale count is refused
+	if recorded, err := store.RecordFailure(ctx, "account:a@example.com", 1, time.Minute); err != nil || recorded {
+		t.Errorf("Expected a stale count to be refused, got %v (%v)", recorded, err)
+	}
+
+	if err := store.Forgive(ctx, "account:a@example.com"); err != nil {
+		t.Fatalf("Forgive failed: %v", err)
+	}
+	if attempts, _ := store.Get(ctx, "account:a@example.com"); attempts.Failures != 1 {
+		t.Errorf("Expected 1 failure after forgiving one, got %d", attempts.Failures)
+	}
+
+	if _, err := store.RecordFailure(ctx, "account:expired@example.com", 0, -time.Second); err != nil {
+		t.Fatalf("RecordFailure failed: %v", err)
+	}
+	if attempts, _ := store.Get(ctx, "account:expired@example.com"); attempts.Failures != 0 {
+		t.Errorf("Expected expired attempts to be forgotten, got %d", attempts.Failures)
+	}
+
+	if err := store.Extend(ctx, "account:expired@example.com", time.Minute); err != nil {
+		t.Fatalf("Extend failed: %v", err)
+	}
+	if attempts, _ := store.Get(ctx, "account:expired@example.com"); attempts.Failures != 0 {
+		t.Errorf("Expected Extend not to revive expired attempts, got %d", attempts.Failures)
+	}
+
+	if err := store.Reset(ctx, "account:a@example.com"); err != nil {
+		t.Fatalf("Reset failed: %v", err)
+	}
+	if attempts, _ := store.Get(ctx, "account:a@example.com"); attempts.Failures != 0 {
+		t.Errorf("Expected no failures after reset, got %d", attempts.Failures)
+	}
+}
+
+func TestService_LockoutExpires(t *testing.T) {
+	clk := clock.NewFrozen(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	svc := newTestService(t, ServiceConfig{
+		Hasher: NewBcryptHasher(MinBcryptCost),
+		Clock:  clk,
+		Lockout: LockoutPolicy{
+			MaxAttempts: 1,
+			Duration:    time.Minute,
+			MaxDuration: time.Hour,
+			Window:      15 * time.Minute,
+		},
+	})
+	ctx := context.Background()
+	req := &LoginRequest{Email: "nobody@example.com", Password: "guess"}
+
+	if _, err := svc.Login(ctx, req); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("Expected ErrInvalidCredentials, got: %v", err)
+	}
+
+	clk.Advance(30 * time.Second)
+	var lockout *LockoutError
+	if _, err := svc.Login(ctx, req); !errors.As(err, &lockout) || lockout.RetryAfter != 30*time.Second {
+		t.Fatalf("Expected a lockout with 30s left, got: %v", err)
+	}
+
+	clk.Advance(30 * time.Second)
+	if _, err := svc.Login(ctx, req); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected the lockout to have ended, got: %v", err)
+	}
+}
+
+func TestService_LockoutWindow(t *testing.T) {
+	clk := clock.NewFrozen(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	svc := newTestService(t, ServiceConfig{
+		Hasher: NewBcryptHasher(MinBcryptCost),
+		Clock:  clk,
+		Lockout: LockoutPolicy{
+			MaxAttempts: 2,
+			Duration:    10 * time.Minute,
+			MaxDuration: time.Hour,
+			Window:      5 * time.Minute,
+		},
+	})
+	ctx := context.Background()
+	req := &LoginRequest{Email: "nobody@example.com", Password: "guess"}
+
+	// A failure is forgotten after the window, not after the longest lockout
+	if _, err := svc.Login(ctx, req); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("Expected ErrInvalidCredentials, got: %v", err)
+	}
+	clk.Advance(6 * time.Minute)
+	if _, err := svc.Login(ctx, req); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("Expected ErrInvalidCredentials, got: %v", err)
+	}
+	if _, err := svc.Login(ctx, req); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("Expected the earlier failure to be forgotten, got: %v", err)
+	}
+
+	// A lockout longer than the window outlasts it
+	clk.Advance(7 * time.Minute)
+	var lockout *LockoutError
+	if _, err := svc.Login(ctx, req); !errors.As(err, &lockout) || lockout.RetryAfter != 3*time.Minute {
+		t.Fatalf("Expected a lockout with 3m left, got: %v", err)
+	}
+}
+
+func TestService_LockoutPerTenant(t *testing.T) {
+	svc := newTestService(t, ServiceConfig{
+		Hasher: NewBcryptHasher(MinBcryptCost),
+		Lockout: LockoutPolicy{
+			MaxAttempts: 1,
+			Duration:    time.Minute,
+			MaxDuration: time.Hour,
+			Window:      15 * time.Minute,
+		},
+	})
+	acme := tenant.WithTenant(context.Background(), &tenant.Tenant{ID: uuid.New(), Slug: "acme"})
+	req := &LoginRequest{Email: "nobody@example.com", Password: "guess"}
+
+	_, _ = svc.Login(acme, req)
+	var lockout *LockoutError
+	if _, err := svc.Login(acme, req); !errors.As(err, &lockout) {
+		t.Fatalf("Expected a lockout in the tenant, got: %v", err)
+	}
+	if _, err := svc.Login(context.Background(), req); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected the same email in another tenant not to be locked out, got: %v", err)
+	}
+}
+
+func TestTokenMaker_FrozenClockExpiry(t *testing.T) {
+	clk := clock.NewFrozen(time.Now().Truncate(time.Second))
+	jwtMaker, err := NewJWTMaker("this-is-a-very-secure-secret-key-32chars", WithTokenClock(clk))
+	if err != nil {
+		t.Fatalf("Failed to create JWT maker: %v", err)
+	}
+	pasetoMaker, err := NewPASETOMaker([]byte("12345678901234567890123456789012"), WithTokenClock(clk))
+	if err != nil {
+		t.Fatalf("Failed to create PASETO maker: %v", err)
+	}
+
+	for name, maker := range map[string]TokenMaker{"jwt": jwtMaker, "paseto": pasetoMaker} {
+		t.Run(name, func(t *testing.T) {
+			start := clk.Now()
+			defer clk.Set(start)
+
+			token, _, err := maker.CreateToken(uuid.New(), "test@example.com", "user", AccessToken, time.Minute)
+			if err != nil {
+				t.Fatalf("Failed to create token: %v", err)
+			}
+
+			clk.Advance(59 * time.Second)
+			if _, err := maker.VerifyToken(token); err != nil {
+				t.Fatalf("Expected token to be valid, got: %v", err)
+			}
+
+			clk.Advance(2 * time.Second)
+			if _, err := maker.VerifyToken(token); !errors.Is(err, ErrExpiredToken) {
+				t.Errorf("Expected ErrExpiredToken, got: %v", err)
+			}
+		})
+	}
+}
+
+// --- Asymmetric JWT Tests ---
+
+func TestAsymmetricJWTMaker_RoundTrip(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate ECDSA key: %v", err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate Ed25519 key: %v", err)
+	}
+
+	tests := []struct {
+		key crypto.Signer
+		alg string
+	}{
+		{rsaKey, AlgRS256},
+		{ecKey, AlgES256},
+		{edKey, AlgEdDSA},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.alg, func(t *testing.T) {
+			maker, err := NewAsymmetricJWTMaker(tt.key)
+			if err != nil {
+				t.Fatalf("Failed to create maker: %v", err)
+			}
+			if maker.Algorithm() != tt.alg {
+				t.Errorf("Expected %s, got %s", tt.alg, maker.Algorithm())
+			}
+
+			userID := uuid.New()
+			token, _, err := maker.CreateToken(userID, "test@example.com", "user", AccessToken, time.Minute)
+			if err != nil {
+				t.Fatalf("Failed to create token: %v", err)
+			}
+
+			payload, err := maker.VerifyToken(token)
+			if err != nil {
+				t.Fatalf("Failed to verify token: %v", err)
+			}
+			if payload.UserID != userID {
+				t.Errorf("Expected user %s, got %s", userID, payload.UserID)
+			}
+
+			jwks := maker.JWKS()
+			if len(jwks.Keys) != 1 || jwks.Keys[0].KeyID != maker.KeyID() || jwks.Keys[0].Algorithm != tt.alg {
+				t.Errorf("Unexpected JWKS: %+v", jwks)
+			}
+		})
+	}
+}
+
+func TestAsymmetricJWTMaker_RejectsOtherKeys(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	maker, err := NewEd25519JWTMaker(key)
+	if err != nil {
+		t.Fatalf("Failed to create maker: %v", err)
+	}
+	other, err := NewEd25519JWTMaker(otherKey)
+	if err != nil {
+		t.Fatalf("Failed to create maker: %v", err)
+	}
+	hmacMaker, err := NewJWTMaker("this-is-a-very-secure-secret-key-32chars")
+	if err != nil {
+		t.Fatalf("Failed to create JWT maker: %v", err)
+	}
+
+	for name, signer := range map[string]TokenMaker{"other key": other, "hmac": hmacMaker} {
+		token, _, err := signer.CreateToken(uuid.New(), "test@example.com", "user", AccessToken, time.Minute)
+		if err != nil {
+			t.Fatalf("Failed to create token: %v", err)
+		}
+		if _, err := maker.VerifyToken(token); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: expected ErrInvalidToken, got: %v", name, err)
+		}
+	}
+}
+
+func TestNewECDSAJWTMaker_RequiresP256(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	if _, err := NewECDSAJWTMaker(key); !errors.Is(err, ErrUnsupportedKey) {
+		t.Errorf("Expected ErrUnsupportedKey, got: %v", err)
+	}
+}
+
+func TestJWK_Thumbprint(t *testing.T) {
+	// Example from RFC 7638, section 3.1
+	jwk := JWK{
+		KeyType: "RSA",
+		N:       "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		E:       "AQAB",
+	}
+
+	thumbprint, err := jwk.Thumbprint()
+	if err != nil {
+		t.Fatalf("Thumbprint failed: %v", err)
+	}
+	if want := "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"; thumbprint != want {
+		t.Errorf("Expected %s, got %s", want, thumbprint)
+	}
+}
+
+func TestNewTokenMakerFromConfig_PrivateKeyPEM(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+	keyPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+
+	maker, err := NewTokenMakerFromConfig(config.AuthConfig{Type: "jwt", JWTAlgorithm: AlgEdDSA, JWTPrivateKey: keyPEM})
+	if err != nil {
+		t.Fatalf("Failed to create maker: %v", err)
+	}
+	if _, ok := maker.(JWKSProvider); !ok {
+		t.Errorf("Expected a JWKS provider, got %T", maker)
+	}
+
+	if _, err := NewTokenMakerFromConfig(config.AuthConfig{Type: "jwt", JWTAlgorithm: AlgRS256, JWTPrivateKey: keyPEM}); err == nil {
+		t.Error("Expected an error for a key that doesn't match the algorithm")
+	}
+	if _, err := NewTokenMakerFromConfig(config.AuthConfig{Type: "jwt", JWTAlgorithm: AlgES256}); err == nil {
+		t.Error("Expected an error without a private key")
+	}
+}
+
+func TestPASETOV4_LocalVector(t *testing.T) {
+	// Test vector 4-E-1 from the PASETO specification
+	key, _ := hex.DecodeString("707172737475767778797a7b7c7d7e7f808182838485868788898a8b8c8d8e8f")
+	nonce := make([]byte, pasetoV4NonceSize)
+	message := []byte(`{"data":"this is a secret message","exp":"2022-01-01T00:00:00+00:00"}`)
+	want := "v4.local.AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAQAr68PS4AXe7If_ZgesdkUMvSwscFlAl1pk5HC0e8kApeaqMfGo_7OpBnwJOAbY9V7WU6abu74MmcUE8YWAiaArVI8XJ5hOb_4v9RmDkneN0S92dx0OW4pgy7omxgf3S8c3LlQg"
+
+	token, err := pasetoV4Encrypt(key, nonce, message, nil)
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	if token != want {
+		t.Errorf("Expected %s, got %s", want, token)
+	}
+
+	decrypted, _, err := pasetoV4Decrypt(key, want)
+	if err != nil {
+		t.Fatalf("Decrypt failed: %v", err)
+	}
+	if string(decrypted) != string(message) {
+		t.Errorf("Expected %s, got %s", message, decrypted)
+	}
+}
+
+func TestPASETOV4Makers_RoundTrip(t *testing.T) {
+	symmetricKey, err := NewPASETOV4SymmetricKey()
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	local, err := NewPASETOV4LocalMaker(symmetricKey, WithAudience("api"))
+	if err != nil {
+		t.Fatalf("Failed to create v4.local maker: %v", err)
+	}
+
+	keyPEM, err := NewEd25519PrivateKeyPEM()
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	public, err := NewTokenMakerFromConfig(config.AuthConfig{Type: AuthTypePASETOPublic, PASETOPrivateKey: string(keyPEM)}, WithAudience("api"))
+	if err != nil {
+		t.Fatalf("Failed to create v4.public maker: %v", err)
+	}
+	if _, ok := public.(JWKSProvider); !ok {
+		t.Errorf("Expected a JWKS provider, got %T", public)
+	}
+
+	fp := &Fingerprint{Device: "device"}
+	for name, maker := range map[string]TokenMaker{"v4.local.": local, "v4.public.": public} {
+		userID := uuid.New()
+		token, created, err := maker.CreateToken(userID, "v4@example.com", "admin", RefreshToken, time.Minute, WithFingerprint(fp))
+		if err != nil {
+			t.Fatalf("%s: CreateToken failed: %v", name, err)
+		}
+		if !strings.HasPrefix(token, name) {
+			t.Errorf("%s: unexpected token %s", name, token)
+		}
+
+		payload, err := maker.VerifyToken(token)
+		if err != nil {
+			t.Fatalf("%s: VerifyToken failed: %v", name, err)
+		}
+		if payload.ID != created.ID || payload.UserID != userID || payload.Role != "admin" || payload.TokenType != RefreshToken {
+			t.Errorf("%s: unexpected payload %+v", name, payload)
+		}
+		if payload.Fingerprint == nil || payload.Fingerprint.Device != fp.Device {
+			t.Errorf("%s: fingerprint not preserved: %+v", name, payload.Fingerprint)
+		}
+
+		// Flip a character in the body
+		i := len(name) + 10
+		flipped := byte('A')
+		if token[i] == 'A' {
+			flipped = 'B'
+		}
+		tampered := token[:i] + string(flipped) + token[i+1:]
+		if _, err := maker.VerifyToken(tampered); err != ErrInvalidToken {
+			t.Errorf("%s: expected ErrInvalidToken for a tampered token, got %v", name, err)
+		}
+	}
+
+	// Other keys, versions and audiences are rejected
+	otherKey, _ := NewPASETOV4SymmetricKey()
+	other, _ := NewPASETOV4LocalMaker(otherKey, WithAudience("api"))
+	token, _, _ := local.CreateToken(uuid.New(), "v4@example.com", "user", AccessToken, time.Minute)
+	if _, err := other.VerifyToken(token); err != ErrInvalidToken {
+		t.Errorf("Expected ErrInvalidToken for another key, got %v", err)
+	}
+	v2, _ := NewPASETOMaker(symmetricKey)
+	if _, err := v2.VerifyToken(token); err != ErrInvalidToken {
+		t.Errorf("Expected v2 to reject v4 tokens, got %v", err)
+	}
+	noAudience, _ := NewPASETOV4LocalMaker(symmetricKey, WithAudience("other"))
+	if _, err := noAudience.VerifyToken(token); err != ErrInvalidToken {
+		t.Errorf("Expected ErrInvalidToken for another audience, got %v", err)
+	}
+
+	otherPEM, _ := NewEd25519PrivateKeyPEM()
+	otherPublic, _ := NewTokenMakerFromConfig(config.AuthConfig{Type: AuthTypePASETOPublic, PASETOPrivateKey: string(otherPEM)})
+	token, _, _ = public.CreateToken(uuid.New(), "v4@example.com", "user", AccessToken, time.Minute)
+	if _, err := otherPublic.VerifyToken(token); err != ErrInvalidToken {
+		t.Errorf("Expected ErrInvalidToken for another signing key, got %v", err)
+	}
+}
+
+func TestPASETOV4LocalMaker_Expired(t *testing.T) {
+	clk := clock.NewFrozen(time.Now().Truncate(time.Second))
+	key, _ := NewPASETOV4SymmetricKey()
+	maker, err := NewPASETOV4LocalMaker(key, WithTokenClock(clk))
+	if err != nil {
+		t.Fatalf("Failed to create maker: %v", err)
+	}
+
+	token, _, err := maker.CreateToken(uuid.New(), "v4@example.com", "user", AccessToken, time.Minute)
+	if err != nil {
+		t.Fatalf("CreateToken failed: %v", err)
+	}
+	clk.Advance(2 * time.Minute)
+	if _, err := maker.VerifyToken(token); err != ErrExpiredToken {
+		t.Errorf("Expected ErrExpiredToken, got %v", err)
+	}
+}
+
+func TestNewTokenMakerFromConfig_PASETOPublicRequiresEd25519(t *testing.T) {
+	if _, err := NewTokenMakerFromConfig(config.AuthConfig{Type: AuthTypePASETOPublic}); err == nil {
+		t.Error("Expected an error without a private key")
+	}
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	keyPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if _, err := NewTokenMakerFromConfig(config.AuthConfig{Type: AuthTypePASETOPublic, PASETOPrivateKey: keyPEM}); !errors.Is(err, ErrUnsupportedKey) {
+		t.Errorf("Expected ErrUnsupportedKey for an ECDSA key, got %v", err)
+	}
+}
+
+func TestKeyRingJWTMaker_Rotation(t *testing.T) {
+	start := time.Now().Truncate(time.Second)
+	clk := clock.NewFrozen(start)
+
+	_, oldKey, _ := ed25519.GenerateKey(rand.Reader)
+	_, newKey, _ := ed25519.GenerateKey(rand.Reader)
+	ring, err := NewKeyRing(2*time.Hour,
+		RingKey{ID: "old", Key: oldKey},
+		RingKey{ID: "new", Key: newKey, ActivatesAt: start.Add(time.Hour)},
+	)
+	if err != nil {
+		t.Fatalf("NewKeyRing failed: %v", err)
+	}
+	maker := NewKeyRingJWTMaker(ring, WithTokenClock(clk))
+
+	// The upcoming key is published before it signs
+	if keys := maker.JWKS().Keys; len(keys) != 2 || keys[0].KeyID != "old" || keys[1].KeyID != "new" {
+		t.Errorf("Expected both keys in JWKS, got %+v", keys)
+	}
+
+	oldToken, _, err := maker.CreateToken(uuid.New(), "ring@example.com", "user", RefreshToken, 3*time.Hour)
+	if err != nil {
+		t.Fatalf("CreateToken failed: %v", err)
+	}
+
+	clk.Advance(90 * time.Minute)
+	newToken, _, err := maker.CreateToken(uuid.New(), "ring@example.com", "user", RefreshToken, 3*time.Hour)
+	if err != nil {
+		t.Fatalf("CreateToken failed: %v", err)
+	}
+	if kid := jwtHeaderKID(t, newToken); kid != "new" {
+		t.Errorf("Expected the new key to sign after activation, got kid %q", kid)
+	}
+
+	// The retired key still verifies the tokens it signed
+	if _, err := maker.VerifyToken(oldToken); err != nil {
+		t.Errorf("Expected a token from the retired key to verify, got %v", err)
+	}
+	if _, err := maker.VerifyToken(newToken); err != nil {
+		t.Errorf("Expected a token from the active key to verify, got %v", err)
+	}
+
+	// Tokens signed by the retired key after it retired are rejected
+	retiredOnly, _ := NewKeyRing(0, RingKey{ID: "old", Key: oldKey})
+	late, _, _ := NewKeyRingJWTMaker(retiredOnly, WithTokenClock(clk)).CreateToken(uuid.New(), "ring@example.com", "user", AccessToken, time.Minute)
+	if _, err := maker.VerifyToken(late); err != ErrInvalidToken {
+		t.Errorf("Expected ErrInvalidToken for a token signed after retirement, got %v", err)
+	}
+
+	// After the retention period the old key is dropped
+	clk.Advance(2 * time.Hour)
+	if _, err := maker.VerifyToken(oldToken); err != ErrInvalidToken {
+		t.Errorf("Expected ErrInvalidToken once the key expired, got %v", err)
+	}
+	if keys := maker.JWKS().Keys; len(keys) != 1 || keys[0].KeyID != "new" {
+		t.Errorf("Expected only the new key in JWKS, got %+v", keys)
+	}
+}
+
+func TestKeyRingJWTMaker_FromHS256(t *testing.T) {
+	start := time.Now().Truncate(time.Second)
+	clk := clock.NewFrozen(start)
+
+	secret := "this-is-a-very-secure-secret-key-32chars"
+	legacy, err := NewJWTMaker(secret, WithTokenClock(clk))
+	if err != nil {
+		t.Fatalf("Failed to create JWT maker: %v", err)
+	}
+	legacyToken, _, err := legacy.CreateToken(uuid.New(), "ring@example.com", "user", AccessToken, time.Hour)
+	if err != nil {
+		t.Fatalf("CreateToken failed: %v", err)
+	}
+
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	ring, err := NewKeyRing(time.Hour,
+		RingKey{ID: "hs", Secret: []byte(secret)},
+		RingKey{ID: "ed", Key: edKey, ActivatesAt: start.Add(time.Second)},
+	)
+	if err != nil {
+		t.Fatalf("NewKeyRing failed: %v", err)
+	}
+	maker := NewKeyRingJWTMaker(ring, WithTokenClock(clk))
+	clk.Advance(time.Minute)
+
+	// Tokens from before the ring, without a kid, verify against the secret
+	if _, err := maker.VerifyToken(legacyToken); err != nil {
+		t.Errorf("Expected a token without kid to verify, got %v", err)
+	}
+	if keys := maker.JWKS().Keys; len(keys) != 1 || keys[0].Algorithm != AlgEdDSA {
+		t.Errorf("Expected only the Ed25519 key in JWKS, got %+v", keys)
+	}
+
+	token, _, err := maker.CreateToken(uuid.New(), "ring@example.com", "user", AccessToken, time.Minute)
+	if err != nil {
+		t.Fatalf("CreateToken failed: %v", err)
+	}
+	if kid := jwtHeaderKID(t, token); kid != "ed" {
+		t.Errorf("Expected kid ed, got %q", kid)
+	}
+	if _, err := legacy.VerifyToken(token); err != ErrInvalidToken {
+		t.Errorf("Expected the HS256 maker to reject EdDSA tokens, got %v", err)
+	}
+}
+
+func TestNewKeyRing_Validation(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	now := time.Now()
+
+	cases := map[string][]RingKey{
+		"empty":          nil,
+		"missing id":     {{Key: key}},
+		"duplicate id":   {{ID: "a", Key: key}, {ID: "a", Key: key, ActivatesAt: now}},
+		"same time":      {{ID: "a", Key: key, ActivatesAt: now}, {ID: "b", Key: key, ActivatesAt: now}},
+		"short secret":   {{ID: "a", Secret: []byte("short")}},
+		"unsupported ec": {{ID: "a", Key: mustECDSAKey(t, elliptic.P384())}},
+	}
+	for name, keys := range cases {
+		if _, err := NewKeyRing(time.Hour, keys...); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	ring, _ := NewKeyRing(time.Hour, RingKey{ID: "later", Key: key, ActivatesAt: now.Add(time.Hour)})
+	if _, err := ring.SigningKey(now); !errors.Is(err, ErrNoSigningKey) {
+		t.Errorf("Expected ErrNoSigningKey before the first activation, got %v", err)
+	}
+}
+
+func TestLoadKeyRing(t *testing.T) {
+	dir := t.TempDir()
+	keyPEM, _ := NewEd25519PrivateKeyPEM()
+	if err := os.WriteFile(filepath.Join(dir, "ed.pem"), keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "hs.key"), []byte("this-is-a-very-secure-secret-key-32chars\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	activation := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	cfg := config.AuthConfig{
+		Type:             "jwt",
+		JWTRefreshExpiry: 24 * time.Hour,
+		SigningKeys: []string{
+			"hs=" + filepath.Join(dir, "hs.key"),
+			"ed=" + filepath.Join(dir, "ed.pem") + "@" + activation.Format(time.RFC3339),
+		},
+	}
+	ring, err := LoadKeyRing(cfg)
+	if err != nil {
+		t.Fatalf("LoadKeyRing failed: %v", err)
+	}
+	keys := ring.Keys()
+	if len(keys) != 2 || keys[0].ID != "hs" || keys[1].ID != "ed" || !keys[1].ActivatesAt.Equal(activation) {
+		t.Errorf("Unexpected keys %+v", keys)
+	}
+	if string(keys[0].Secret) != "this-is-a-very-secure-secret-key-32chars" {
+		t.Errorf("Expected the secret to be trimmed, got %q", keys[0].Secret)
+	}
+	if ring.Retention() != 24*time.Hour {
+		t.Errorf("Expected retention to default to the refresh expiry, got %v", ring.Retention())
+	}
+
+	if _, err := NewTokenMakerFromConfig(cfg); err != nil {
+		t.Errorf("Expected a JWT key ring maker, got %v", err)
+	}
+	cfg.Type = AuthTypePASETOPublic
+	if _, err := NewTokenMakerFromConfig(cfg); !errors.Is(err, ErrUnsupportedKey) {
+		t.Errorf("Expected v4.public to reject the HS256 key, got %v", err)
+	}
+
+	for _, entry := range []string{"no-path", "a=" + filepath.Join(dir, "missing"), "a=" + filepath.Join(dir, "ed.pem") + "@tomorrow"} {
+		if _, err := LoadKeyRing(config.AuthConfig{SigningKeys: []string{entry}}); err == nil {
+			t.Errorf("Expected an error for %q", entry)
+		}
+	}
+}
+
+func TestPASETOV4PublicMaker_KeyRing(t *testing.T) {
+	start := time.Now().Truncate(time.Second)
+	clk := clock.NewFrozen(start)
+
+	_, oldKey, _ := ed25519.GenerateKey(rand.Reader)
+	_, newKey, _ := ed25519.GenerateKey(rand.Reader)
+	ring, _ := NewKeyRing(time.Hour,
+		RingKey{ID: "old", Key: oldKey},
+		RingKey{ID: "new", Key: newKey, ActivatesAt: start.Add(time.Minute)},
+	)
+	maker, err := NewPASETOV4PublicKeyRingMaker(ring, WithTokenClock(clk))
+	if err != nil {
+		t.Fatalf("Failed to create maker: %v", err)
+	}
+
+	oldToken, _, _ := maker.CreateToken(uuid.New(), "ring@example.com", "user", AccessToken, 30*time.Minute)
+	clk.Advance(2 * time.Minute)
+	if maker.KeyID() != "new" {
+		t.Errorf("Expected the new key to sign, got %q", maker.KeyID())
+	}
+	newToken, _, _ := maker.CreateToken(uuid.New(), "ring@example.com", "user", AccessToken, 30*time.Minute)
+
+	for _, token := range []string{oldToken, newToken} {
+		if _, err := maker.VerifyToken(token); err != nil {
+			t.Errorf("Expected %s to verify, got %v", token, err)
+		}
+	}
+}
+
+func jwtHeaderKID(t *testing.T, token string) string {
+	t.Helper()
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, &JWTClaims{})
+	if err != nil {
+		t.Fatalf("Failed to parse token: %v", err)
+	}
+	kid, _ := parsed.Header["kid"].(string)
+	return kid
+}
+
+func mustECDSAKey(t *testing.T, curve elliptic.Curve) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	return key
+}
+
+func TestService_IDGenerator(t *testing.T) {
+	svc := newTestService(t, ServiceConfig{Hasher: NewBcryptHasher(MinBcryptCost)})
+
+	result, err := svc.Register(context.Background(), &RegisterRequest{Email: "ids@example.com", Password: "password123"})
+	if err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	if v := result.User.ID.Version(); v != 7 {
+		t.Errorf("Expected a v7 user ID by default, got v%d", v)
+	}
+
+	maker, err := NewJWTMaker("this-is-a-very-secure-secret-key-32chars", WithTokenIDs(idgen.V4))
+	if err != nil {
+		t.Fatalf("Failed to create JWT maker: %v", err)
+	}
+	_, payload, err := maker.CreateToken(uuid.New(), "ids@example.com", "user", AccessToken, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+	if v := payload.ID.Version(); v != 4 {
+		t.Errorf("Expected a v4 token ID, got v%d", v)
+	}
+}
+
+// --- Session Tests ---
+
+type memorySessionStore struct {
+	sessions map[string]Session
+}
+
+func newMemorySessionStore() *memorySessionStore {
+	return &memorySessionStore{sessions: make(map[string]Session)}
+}
+
+func (s *memorySessionStore) CreateSession(ctx context.Context, tokenHash string, session *Session) error {
+	s.sessions[tokenHash] = *session
+	return nil
+}
+
+func (s *memorySessionStore) GetSession(ctx context.Context, tokenHash string) (*Session, error) {
+	session, ok := s.sessions[tokenHash]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	return &session, nil
+}
+
+func (s *memorySessionStore) TouchSession(ctx context.Context, tokenHash string, lastSeen time.Time) error {
+	session, ok := s.sessions[tokenHash]
+	if ok {
+		session.LastSeenAt = lastSeen
+		s.sessions[tokenHash] = session
+	}
+	return nil
+}
+
+func (s *memorySessionStore) DeleteSession(ctx context.Context, tokenHash string) error {
+	delete(s.sessions, tokenHash)
+	return nil
+}
+
+func (s *memorySessionStore) DeleteUserSessions(ctx context.Context, userID uuid.UUID) error {
+	for hash, session := range s.sessions {
+		if session.UserID == userID {
+			delete(s.sessions, hash)
+		}
+	}
+	return nil
+}
+
+func testSessionPolicy() SessionPolicy {
+	return SessionPolicy{
+		IdleTimeout:    time.Hour,
+		MaxAge:         24 * time.Hour,
+		CookieName:     "goiler_session",
+		CookiePath:     "/",
+		CookieSecure:   true,
+		SameSite:       "lax",
+		CSRFCookieName: "goiler_csrf",
+		CSRFHeader:     "X-CSRF-Token",
+	}
+}
+
+func TestSessionPolicy_Validate(t *testing.T) {
+	if err := testSessionPolicy().Validate(); err != nil {
+		t.Fatalf("Expected the test policy to be valid, got: %v", err)
+	}
+
+	insecureNone := testSessionPolicy()
+	insecureNone.SameSite, insecureNone.CookieSecure = "none", false
+	shortMaxAge := testSessionPolicy()
+	shortMaxAge.MaxAge = time.Minute
+	sameNames := testSessionPolicy()
+	sameNames.CSRFCookieName = sameNames.CookieName
+	unknownSameSite := testSessionPolicy()
+	unknownSameSite.SameSite = "sometimes"
+
+	for name, policy := range map[string]SessionPolicy{
+		"insecure SameSite=None": insecureNone,
+		"max age below idle":     shortMaxAge,
+		"shared cookie name":     sameNames,
+		"unknown SameSite":       unknownSameSite,
+	} {
+		if err := policy.Validate(); err == nil {
+			t.Errorf("%s: expected a validation error", name)
+		}
+	}
+}
+
+func TestSessionManager_Expiry(t *testing.T) {
+	clk := clock.NewFrozen(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	store := newMemorySessionStore()
+	sessions := NewSessionManager(store, testSessionPolicy(), clk, nil)
+	ctx := context.Background()
+	user := &User{ID: uuid.New(), Email: "session@example.com", Role: "user"}
+
+	_, token, err := sessions.Create(ctx, user)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, ok := store.sessions[token]; ok {
+		t.Error("Expected the store to key sessions by hash, not by secret")
+	}
+
+	// Regular use keeps the session alive past the idle timeout...
+	for range 4 {
+		clk.Advance(50 * time.Minute)
+		if _, err := sessions.Validate(ctx, token); err != nil {
+			t.Fatalf("Expected an active session to stay valid, got: %v", err)
+		}
+	}
+
+	// ...but not past an idle period
+	clk.Advance(time.Hour)
+	if _, err := sessions.Validate(ctx, token); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected an idle session to expire, got: %v", err)
+	}
+
+	// Sessions end at MaxAge however active they are
+	session, token, _ := sessions.Create(ctx, user)
+	for clk.Now().Before(session.ExpiresAt.Add(time.Hour)) {
+		clk.Advance(50 * time.Minute)
+		if _, err := sessions.Validate(ctx, token); err != nil {
+			break
+		}
+	}
+	if _, err := sessions.Validate(ctx, token); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected the session to end at its max age, got: %v", err)
+	}
+}
+
+func TestSessionManager_CSRF(t *testing.T) {
+	sessions := NewSessionManager(newMemorySessionStore(), testSessionPolicy(), nil, nil)
+	session, token, err := sessions.Create(context.Background(), &User{ID: uuid.New()})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	newRequest := func(method, csrf string) *http.Request {
+		req := httptest.NewRequest(method, "/api/v1/users/me", nil)
+		for _, cookie := range sessions.Cookies(session, token) {
+			req.AddCookie(cookie)
+		}
+		if csrf != "" {
+			req.Header.Set("X-CSRF-Token", csrf)
+		}
+		return req
+	}
+
+	if _, _, err := sessions.Authenticate(newRequest(http.MethodGet, "")); err != nil {
+		t.Errorf("Expected safe methods to need no CSRF token, got: %v", err)
+	}
+	if _, _, err := sessions.Authenticate(newRequest(http.MethodPost, "")); !errors.Is(err, ErrInvalidCSRFToken) {
+		t.Errorf("Expected a missing CSRF token to be rejected, got: %v", err)
+	}
+	if _, _, err := sessions.Authenticate(newRequest(http.MethodPost, "forged")); !errors.Is(err, ErrInvalidCSRFToken) {
+		t.Errorf("Expected a wrong CSRF token to be rejected, got: %v", err)
+	}
+	if _, _, err := sessions.Authenticate(newRequest(http.MethodPost, session.CSRFToken)); err != nil {
+		t.Errorf("Expected the session's CSRF token to be accepted, got: %v", err)
+	}
+}
+
+func TestService_CookieMode(t *testing.T) {
+	sessions := NewSessionManager(newMemorySessionStore(), testSessionPolicy(), nil, nil)
+	svc := newTestService(t, ServiceConfig{Hasher: NewBcryptHasher(MinBcryptCost), Sessions: sessions})
+	handler := NewHandler(svc)
+	ctx := context.Background()
+
+	result, err := svc.Register(ctx, &RegisterRequest{Email: "cookie@example.com", Password: "password123"})
+	if err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	if result.AccessToken != "" || result.RefreshToken != "" || result.CSRFToken == "" {
+		t.Fatalf("Expected a CSRF token and no bearer tokens, got %+v", result)
+	}
+
+	cookies := result.Cookies()
+	if len(cookies) != 2 || !cookies[0].HttpOnly || cookies[1].HttpOnly {
+		t.Fatalf("Expected an HttpOnly session cookie and a readable CSRF cookie, got %+v", cookies)
+	}
+
+	e := echo.New()
+	protected := handler.AuthMiddleware()(func(c echo.Context) error {
+		return c.String(http.StatusOK, GetCurrentUser(c).Email)
+	})
+	call := func(method string, csrf string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/", nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		req.Header.Set("X-CSRF-Token", csrf)
+		rec := httptest.NewRecorder()
+		_ = protected(e.NewContext(req, rec))
+		return rec
+	}
+
+	if rec := call(http.MethodPost, result.CSRFToken, cookies); rec.Code != http.StatusOK || rec.Body.String() != "cookie@example.com" {
+		t.Errorf("Expected the session to authenticate, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := call(http.MethodPost, "", cookies); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 without a CSRF token, got %d", rec.Code)
+	}
+	if rec := call(http.MethodGet, "", nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a session cookie, got %d", rec.Code)
+	}
+
+	rotated, err := svc.RefreshSession(ctx, cookies[0].Value)
+	if err != nil {
+		t.Fatalf("RefreshSession failed: %v", err)
+	}
+	if rotated.CSRFToken == result.CSRFToken {
+		t.Error("Expected rotation to replace the CSRF token")
+	}
+	if rec := call(http.MethodGet, "", cookies); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the rotated-out session to be rejected, got %d", rec.Code)
+	}
+	if rec := call(http.MethodGet, "", rotated.Cookies()); rec.Code != http.StatusOK {
+		t.Errorf("Expected the rotated session to authenticate, got %d", rec.Code)
+	}
+
+	if err := svc.EndSession(ctx, rotated.Cookies()[0].Value); err != nil {
+		t.Fatalf("EndSession failed: %v", err)
+	}
+	if rec := call(http.MethodGet, "", rotated.Cookies()); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected an ended session to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestHandler_AuthMiddlewareSetsAuthContext(t *testing.T) {
+	svc := newTestService(t, ServiceConfig{Hasher: NewBcryptHasher(MinBcryptCost)})
+	handler := NewHandler(svc)
+
+	result, err := svc.Register(context.Background(), &RegisterRequest{Email: "ctx@example.com", Password: "password123"})
+	if err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	e := echo.New()
+	var role string
+	var ctxUser *TokenPayload
+	protected := handler.AuthMiddleware()(func(c echo.Context) error {
+		role, _ = authctx.Role(c)
+		ctxUser, _ = UserFromContext(c.Request().Context())
+		return c.NoContent(http.StatusNoContent)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+result.AccessToken)
+	rec := httptest.NewRecorder()
+	if err := protected(e.NewContext(req, rec)); err != nil || rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected the token to authenticate, got %d: %v", rec.Code, err)
+	}
+	if role != "user" {
+		t.Errorf("Expected the role to be readable via authctx, got %q", role)
+	}
+	if ctxUser == nil || ctxUser.Email != "ctx@example.com" {
+		t.Errorf("Expected the token payload in the request context, got %+v", ctxUser)
+	}
+}
+
+type memoryImpersonationStore struct {
+	impersonations map[uuid.UUID]*Impersonation
+}
+
+func newMemoryImpersonationStore() *memoryImpersonationStore {
+	return &memoryImpersonationStore{impersonations: make(map[uuid.UUID]*Impersonation)}
+}
+
+func (s *memoryImpersonationStore) CreateImpersonation(ctx context.Context, imp *Impersonation) error {
+	stored := *imp
+	s.impersonations[imp.ID] = &stored
+	return nil
+}
+
+func (s *memoryImpersonationStore) GetImpersonation(ctx context.Context, id uuid.UUID) (*Impersonation, error) {
+	imp, ok := s.impersonations[id]
+	if !ok {
+		return nil, ErrImpersonationNotFound
+	}
+	found := *imp
+	return &found, nil
+}
+
+func (s *memoryImpersonationStore) EndImpersonation(ctx context.Context, id, endedBy uuid.UUID, endedAt time.Time) error {
+	imp, ok := s.impersonations[id]
+	if !ok || imp.EndedAt != nil {
+		return ErrImpersonationNotFound
+	}
+	imp.EndedAt = &endedAt
+	imp.EndedBy = &endedBy
+	return nil
+}
+
+func (s *memoryImpersonationStore) ListImpersonations(ctx context.Context, limit, offset int) ([]*Impersonation, error) {
+	var list []*Impersonation
+	for _, imp := range s.impersonations {
+		list = append(list, imp)
+	}
+	return list, nil
+}
+
+func TestService_Impersonate(t *testing.T) {
+	clk := clock.NewFrozen(time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC))
+	maker, err := NewJWTMaker("this-is-a-very-secure-secret-key-32chars", WithTokenClock(clk))
+	if err != nil {
+		t.Fatalf("Failed to create JWT maker: %v", err)
+	}
+	users := newMemoryUserRepo()
+	store := newMemoryImpersonationStore()
+	svc := NewService(ServiceConfig{
+		UserRepo:                    users,
+		TokenMaker:                  maker,
+		Clock:                       clk,
+		Impersonations:              store,
+		ImpersonationExpiry:         10 * time.Minute,
+		ImpersonationProtectedRoles: []string{"admin"},
+		Roles: rolePermissions{
+			"admin":   {"*"},
+			"support": {"users:impersonate", "users:read"},
+			"billing": {"billing:refund", "users:read"},
+			"user":    {"users:read"},
+		},
+	})
+	ctx := context.Background()
+
+	admin := &User{ID: uuid.New(), Email: "admin@example.com", Role: "admin"}
+	target := &User{ID: uuid.New(), Email: "user@example.com", Role: "user"}
+	_ = users.Create(ctx, admin)
+	_ = users.Create(ctx, target)
+
+	// Impersonation can't grant permissions the admin doesn't hold
+	support := &User{ID: uuid.New(), Email: "support@example.com", Role: "support"}
+	billing := &User{ID: uuid.New(), Email: "billing@example.com", Role: "billing"}
+	_ = users.Create(ctx, support)
+	_ = users.Create(ctx, billing)
+	if _, err := svc.Impersonate(ctx, support.ID, billing.ID, "testing"); !errors.Is(err, ErrImpersonationNotAllowed) {
+		t.Errorf("Expected a user with more permissions to be refused, got: %v", err)
+	}
+	if _, err := svc.Impersonate(ctx, support.ID, target.ID, "testing"); err != nil {
+		t.Errorf("Expected a user with fewer permissions to be impersonated, got: %v", err)
+	}
+
+	if _, err := svc.Impersonate(ctx, admin.ID, admin.ID, "testing"); !errors.Is(err, ErrImpersonationNotAllowed) {
+		t.Errorf("Expected admins to be unable to impersonate themselves, got: %v", err)
+	}
+	other := &User{ID: uuid.New(), Email: "other@example.com", Role: "admin"}
+	_ = users.Create(ctx, other)
+	if _, err := svc.Impersonate(ctx, admin.ID, other.ID, "testing"); !errors.Is(err, ErrImpersonationNotAllowed) {
+		t.Errorf("Expected protected roles to be refused, got: %v", err)
+	}
+	if _, err := svc.Impersonate(ctx, admin.ID, uuid.New(), "testing"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound for an unknown user, got: %v", err)
+	}
+
+	result, err := svc.Impersonate(ctx, admin.ID, target.ID, "ticket #42")
+	if err != nil {
+		t.Fatalf("Impersonate failed: %v", err)
+	}
+	if !result.ExpiresAt.Equal(clk.Now().Add(10 * time.Minute)) {
+		t.Errorf("Expected the token to last the impersonation expiry, expires at %v", result.ExpiresAt)
+	}
+	if result.Impersonation.Reason != "ticket #42" || result.Impersonation.AdminID != admin.ID {
+		t.Errorf("Expected the impersonation to be recorded, got %+v", result.Impersonation)
+	}
+
+	payload, err := svc.ValidateToken(ctx, result.AccessToken)
+	if err != nil {
+		t.Fatalf("Expected the impersonation token to be accepted: %v", err)
+	}
+	if payload.UserID != target.ID || payload.ImpersonatedBy == nil || *payload.ImpersonatedBy != admin.ID {
+		t.Errorf("Expected a token for the user impersonated by the admin, got %+v", payload)
+	}
+	if user := payload.AuthUser(); user.ImpersonatedBy == nil || *user.ImpersonatedBy != admin.ID {
+		t.Error("Expected the impersonator on the authctx user")
+	}
+
+	// Impersonation tokens can't be used to impersonate someone else
+	nested := ContextWithUser(ctx, payload)
+	if _, err := svc.Impersonate(nested, target.ID, other.ID, "hop"); !errors.Is(err, ErrImpersonationNotAllowed) {
+		t.Errorf("Expected nested impersonation to be refused, got: %v", err)
+	}
+
+	if err := svc.EndImpersonation(ctx, result.Impersonation.ID, admin.ID); err != nil {
+		t.Fatalf("EndImpersonation failed: %v", err)
+	}
+	if _, err := svc.ValidateToken(ctx, result.AccessToken); !errors.Is(err, ErrImpersonationEnded) {
+		t.Errorf("Expected the token to be revoked with its impersonation, got: %v", err)
+	}
+	if err := svc.EndImpersonation(ctx, result.Impersonation.ID, admin.ID); !errors.Is(err, ErrImpersonationNotFound) {
+		t.Errorf("Expected ending twice to fail, got: %v", err)
+	}
+}
+
+func TestService_ImpersonationTokenRequiresRecord(t *testing.T) {
+	svc := newTestService(t, ServiceConfig{Impersonations: newMemoryImpersonationStore()})
+
+	// A forged family that was never recorded is rejected
+	token, _, err := svc.tokenMaker.CreateToken(uuid.New(), "user@example.com", "user", AccessToken, time.Minute,
+		WithImpersonatedBy(uuid.New()), WithFamily(uuid.New()))
+	if err != nil {
+		t.Fatalf("CreateToken failed: %v", err)
+	}
+	if _, err := svc.ValidateToken(context.Background(), token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected an unrecorded impersonation token to be rejected, got: %v", err)
+	}
+}
+
+func TestTokenMakers_ImpersonationClaims(t *testing.T) {
+	jwtMaker, err := NewJWTMaker("this-is-a-very-secure-secret-key-32chars")
+	if err != nil {
+		t.Fatalf("Failed to create JWT maker: %v", err)
+	}
+	pasetoMaker, err := NewPASETOMaker([]byte("12345678901234567890123456789012"))
+	if err != nil {
+		t.Fatalf("Failed to create PASETO maker: %v", err)
+	}
+	v4Maker, err := NewPASETOV4LocalMaker([]byte("12345678901234567890123456789012"))
+	if err != nil {
+		t.Fatalf("Failed to create PASETO v4 maker: %v", err)
+	}
+
+	adminID, family := uuid.New(), uuid.New()
+	for name, maker := range map[string]TokenMaker{"jwt": jwtMaker, "paseto": pasetoMaker, "paseto-v4": v4Maker} {
+		token, _, err := maker.CreateToken(uuid.New(), "user@example.com", "user", AccessToken, time.Minute,
+			WithImpersonatedBy(adminID), WithFamily(family))
+		if err != nil {
+			t.Fatalf("%s: CreateToken failed: %v", name, err)
+		}
+		payload, err := maker.VerifyToken(token)
+		if err != nil {
+			t.Fatalf("%s: VerifyToken failed: %v", name, err)
+		}
+		if payload.ImpersonatedBy == nil || *payload.ImpersonatedBy != adminID {
+			t.Errorf("%s: impersonated_by not carried through, got %v", name, payload.ImpersonatedBy)
+		}
+		if payload.Family == nil || *payload.Family != family {
+			t.Errorf("%s: family not carried through, got %v", name, payload.Family)
+		}
+	}
+}
+
+func TestTokenMakers_TenantClaim(t *testing.T) {
+	jwtMaker, err := NewJWTMaker("this-is-a-very-secure-secret-key-32chars")
+	if err != nil {
+		t.Fatalf("Failed to create JWT maker: %v", err)
+	}
+	pasetoMaker, err := NewPASETOMaker([]byte("12345678901234567890123456789012"))
+	if err != nil {
+		t.Fatalf("Failed to create PASETO maker: %v", err)
+	}
+	v4Maker, err := NewPASETOV4LocalMaker([]byte("12345678901234567890123456789012"))
+	if err != nil {
+		t.Fatalf("Failed to create PASETO v4 maker: %v", err)
+	}
+
+	tenantID, orgID := uuid.New(), uuid.New()
+	for name, maker := range map[string]TokenMaker{"jwt": jwtMaker, "paseto": pasetoMaker, "paseto-v4": v4Maker} {
+		token, _, err := maker.CreateToken(uuid.New(), "user@example.com", "user", AccessToken, time.Minute, WithTenantID(tenantID), WithOrgID(orgID), WithSandbox(sandbox.With(context.Background())))
+		if err != nil {
+			t.Fatalf("%s: CreateToken failed: %v", name, err)
+		}
+		payload, err := maker.VerifyToken(token)
+		if err != nil {
+			t.Fatalf("%s: VerifyToken failed: %v", name, err)
+		}
+		if payload.Tenant() != tenantID {
+			t.Errorf("%s: tenant not carried through, got %v", name, payload.TenantID)
+		}
+		if payload.OrgID == nil || *payload.OrgID != orgID || *payload.AuthUser().OrgID != orgID {
+			t.Errorf("%s: org not carried through, got %v", name, payload.OrgID)
+		}
+		if !payload.Sandbox {
+			t.Errorf("%s: sandbox not carried through", name)
+		}
+		if ctx, _ := payload.tenantContext(context.Background()); !sandbox.Enabled(ctx) || tenant.ID(ctx) != tenantID {
+			t.Errorf("%s: expected sandbox tokens to scope requests to their sandbox", name)
+		}
+		fallback := tenant.WithFallback(context.Background(), tenant.Default())
+		if ctx, ok := payload.tenantContext(fallback); !ok || tenant.ID(ctx) != tenantID {
+			t.Errorf("%s: expected requests naming no tenant to take the token's", name)
+		}
+		if _, ok := payload.tenantContext(tenant.WithTenant(context.Background(), tenant.Default())); ok {
+			t.Errorf("%s: expected requests naming another tenant to be rejected", name)
+		}
+
+		// Tokens issued before tenancy belong to the default tenant
+		legacy, _, err := maker.CreateToken(uuid.New(), "user@example.com", "user", AccessToken, time.Minute)
+		if err != nil {
+			t.Fatalf("%s: CreateToken failed: %v", name, err)
+		}
+		payload, err = maker.VerifyToken(legacy)
+		if err != nil {
+			t.Fatalf("%s: VerifyToken failed: %v", name, err)
+		}
+		if payload.TenantID != nil || payload.Tenant() != tenant.DefaultID || payload.Sandbox {
+			t.Errorf("%s: expected the default tenant, got %v", name, payload.TenantID)
+		}
+	}
+}
+
+func TestService_SwitchOrg(t *testing.T) {
+	orgID := uuid.New()
+
+	t.Run("bearer", func(t *testing.T) {
+		svc := newTestService(t, ServiceConfig{Hasher: NewBcryptHasher(MinBcryptCost), TokenRepo: newMemoryTokenRepo()})
+		result, err := svc.Register(context.Background(), &RegisterRequest{Email: "org@example.com", Password: "password123"})
+		if err != nil {
+			t.Fatalf("Register failed: %v", err)
+		}
+		current, err := svc.ValidateToken(context.Background(), result.AccessToken)
+		if err != nil {
+			t.Fatalf("ValidateToken failed: %v", err)
+		}
+
+		ctx := ContextWithUser(context.Background(), current)
+		switched, err := svc.SwitchOrg(ctx, httptest.NewRequest(http.MethodPost, "/", nil), orgID)
+		if err != nil {
+			t.Fatalf("SwitchOrg failed: %v", err)
+		}
+		payload, err := svc.ValidateToken(context.Background(), switched.AccessToken)
+		if err != nil {
+			t.Fatalf("ValidateToken failed: %v", err)
+		}
+		if payload.OrgID == nil || *payload.OrgID != orgID {
+			t.Errorf("Expected org claim %s, got %v", orgID, payload.OrgID)
+		}
+		if payload.Family == nil || *payload.Family != *current.Family {
+			t.Errorf("Expected the switched tokens to stay in family %s, got %v", *current.Family, payload.Family)
+		}
+
+		// The old refresh token would act in the previous org
+		if _, err := svc.RefreshToken(context.Background(), result.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+			t.Errorf("Expected the old refresh token to be revoked, got %v", err)
+		}
+
+		// Refreshing keeps the active org
+		refreshed, err := svc.RefreshToken(context.Background(), switched.RefreshToken)
+		if err != nil {
+			t.Fatalf("RefreshToken failed: %v", err)
+		}
+		payload, err = svc.ValidateToken(context.Background(), refreshed.AccessToken)
+		if err != nil {
+			t.Fatalf("ValidateToken failed: %v", err)
+		}
+		if payload.OrgID == nil || *payload.OrgID != orgID {
+			t.Errorf("Expected refresh to keep org claim %s, got %v", orgID, payload.OrgID)
+		}
+
+		if _, err := svc.SwitchOrg(context.Background(), httptest.NewRequest(http.MethodPost, "/", nil), orgID); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("Expected ErrInvalidToken without a current user, got %v", err)
+		}
+	})
+
+	t.Run("cookie", func(t *testing.T) {
+		sessions := NewSessionManager(newMemorySessionStore(), testSessionPolicy(), nil, nil)
+		svc := newTestService(t, ServiceConfig{Hasher: NewBcryptHasher(MinBcryptCost), Sessions: sessions})
+		result, err := svc.Register(context.Background(), &RegisterRequest{Email: "org@example.com", Password: "password123"})
+		if err != nil {
+			t.Fatalf("Register failed: %v", err)
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.AddCookie(result.Cookies()[0])
+		switched, err := svc.SwitchOrg(context.Background(), req, orgID)
+		if err != nil {
+			t.Fatalf("SwitchOrg failed: %v", err)
+		}
+
+		if _, err := sessions.Validate(context.Background(), result.Cookies()[0].Value); !errors.Is(err, ErrSessionNotFound) {
+			t.Errorf("Expected the old session to be rotated out, got %v", err)
+		}
+		session, err := sessions.Validate(context.Background(), switched.Cookies()[0].Value)
+		if err != nil {
+			t.Fatalf("Validate failed: %v", err)
+		}
+		if payload := session.Payload(); payload.OrgID == nil || *payload.OrgID != orgID {
+			t.Errorf("Expected the session to act in org %s, got %v", orgID, payload.OrgID)
+		}
+	})
+}
+
+func TestHandler_AuthMiddlewareRejectsOtherTenants(t *testing.T) {
+	svc := newTestService(t, ServiceConfig{Hasher: NewBcryptHasher(MinBcryptCost)})
+	handler := NewHandler(svc)
+
+	result, err := svc.Register(context.Background(), &RegisterRequest{Email: "tenant@example.com", Password: "password123"})
+	if err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	e := echo.New()
+	protected := handler.AuthMiddleware()(func(c echo.Context) error {
+		return c.NoContent(http.StatusNoContent)
+	})
+
+	for _, tt := range []struct {
+		name   string
+		tenant *tenant.Tenant
+		want   int
+	}{
+		{name: "no tenant resolved", want: http.StatusNoContent},
+		{name: "issuing tenant", tenant: tenant.Default(), want: http.StatusNoContent},
+		{name: "other tenant", tenant: &tenant.Tenant{ID: uuid.New(), Slug: "acme"}, want: http.StatusUnauthorized},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", "Bearer "+result.AccessToken)
+			if tt.tenant != nil {
+				req = req.WithContext(tenant.WithTenant(req.Context(), tt.tenant))
+			}
+			rec := httptest.NewRecorder()
+			if err := protected(e.NewContext(req, rec)); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if rec.Code != tt.want {
+				t.Errorf("Expected status %d, got %d: %s", tt.want, rec.Code, rec.Body.String())
+			}
+		})
+	}
+
+	// Refresh tokens don't cross tenants either
+	other := tenant.WithTenant(context.Background(), &tenant.Tenant{ID: uuid.New(), Slug: "acme"})
+	if _, err := svc.RefreshToken(other, result.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("Expected ErrInvalidRefreshToken, got %v", err)
+	}
+}
+
+func TestHandler_RejectImpersonation(t *testing.T) {
+	handler := NewHandler(newTestService(t, ServiceConfig{}))
+	guarded := handler.RejectImpersonation()(func(c echo.Context) error {
+		return c.NoContent(http.StatusNoContent)
+	})
+
+	e := echo.New()
+	adminID := uuid.New()
+	for _, tc := range []struct {
+		name           string
+		impersonatedBy *uuid.UUID
+		want           int
+	}{
+		{"regular token", nil, http.StatusNoContent},
+		{"impersonation token", &adminID, http.StatusForbidden},
+	} {
+		rec := httptest.NewRecorder()
+		c := e.NewContext(httptest.NewRequest(http.MethodPut, "/", nil), rec)
+		SetCurrentUser(c, &TokenPayload{UserID: uuid.New(), Role: "user", ImpersonatedBy: tc.impersonatedBy})
+		if err := guarded(c); err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.name, err)
+		}
+		if rec.Code != tc.want {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.want, rec.Code)
+		}
+	}
+}
+
+// --- Device Session Tests ---
+
+type memoryTokenRepo struct {
+	tokens  []*RefreshTokenInfo
+	revoked map[uuid.UUID]bool
+}
+
+func newMemoryTokenRepo() *memoryTokenRepo {
+	return &memoryTokenRepo{revoked: make(map[uuid.UUID]bool)}
+}
+
+func (r *memoryTokenRepo) StoreRefreshToken(ctx context.Context, token *RefreshTokenInfo) error {
+	stored := *token
+	stored.SessionStartedAt = token.IssuedAt
+	for _, t := range r.tokens {
+		if t.Family == token.Family && t.SessionStartedAt.Before(stored.SessionStartedAt) {
+			stored.SessionStartedAt = t.SessionStartedAt
+		}
+	}
+	r.tokens = append(r.tokens, &stored)
+	return nil
+}
+
+func (r *memoryTokenRepo) RevokeRefreshToken(ctx context.Context, tokenID uuid.UUID) error {
+	r.revoked[tokenID] = true
+	return nil
+}
+
+func (r *memoryTokenRepo) IsRefreshTokenRevoked(ctx context.Context, tokenID uuid.UUID) (bool, error) {
+	for _, t := range r.tokens {
+		if t.ID == tokenID {
+			return r.revoked[tokenID], nil
+		}
+	}
+	return true, nil
+}
+
+func (r *memoryTokenRepo) RevokeAllUserTokens(ctx context.Context, userID uuid.UUID) error {
+	for _, t := range r.tokens {
+		if t.UserID == userID {
+			r.revoked[t.ID] = true
+		}
+	}
+	return nil
+}
+
+func (r *memoryTokenRepo) ListRefreshTokens(ctx context.Context, userID uuid.UUID) ([]*RefreshTokenInfo, error) {
+	var active []*RefreshTokenInfo
+	for i := len(r.tokens) - 1; i >= 0; i-- {
+		if t := r.tokens[i]; t.UserID == userID && !r.revoked[t.ID] {
+			active = append(active, t)
+		}
+	}
+	return active, nil
+}
+
+func (r *memoryTokenRepo) RevokeRefreshTokenFamily(ctx context.Context, userID, family uuid.UUID) error {
+	revoked := 0
+	for _, t := range r.tokens {
+		if t.UserID == userID && t.Family == family && !r.revoked[t.ID] {
+			r.revoked[t.ID] = true
+			revoked++
+		}
+	}
+	if revoked == 0 {
+		return ErrDeviceSessionNotFound
+	}
+	return nil
+}
+
+func TestService_DeviceSessions(t *testing.T) {
+	clk := clock.NewFrozen(time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC))
+	maker, err := NewJWTMaker("this-is-a-very-secure-secret-key-32chars", WithTokenClock(clk))
+	if err != nil {
+		t.Fatalf("Failed to create JWT maker: %v", err)
+	}
+	svc := NewService(ServiceConfig{
+		UserRepo:   newMemoryUserRepo(),
+		TokenRepo:  newMemoryTokenRepo(),
+		TokenMaker: maker,
+		Hasher:     NewBcryptHasher(MinBcryptCost),
+		Clock:      clk,
+	})
+
+	laptop := ContextWithClient(context.Background(), ClientInfo{IPAddress: "203.0.113.10", UserAgent: "Firefox"})
+	phone := ContextWithClient(context.Background(), ClientInfo{IPAddress: "198.51.100.7", UserAgent: "Safari"})
+	registered, err := svc.Register(laptop, &RegisterRequest{Email: "user@example.com", Password: "SecureP@ssw0rd!"})
+	if err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+	started := clk.Now()
+	clk.Advance(time.Minute)
+	onPhone, err := svc.Login(phone, &LoginRequest{Email: "user@example.com", Password: "SecureP@ssw0rd!"})
+	if err != nil {
+		t.Fatalf("Failed to log in: %v", err)
+	}
+
+	clk.Advance(time.Minute)
+	refreshed, err := svc.RefreshToken(laptop, registered.RefreshToken)
+	if err != nil {
+		t.Fatalf("Failed to refresh: %v", err)
+	}
+
+	payload, err := svc.ValidateToken(context.Background(), refreshed.AccessToken)
+	if err != nil {
+		t.Fatalf("Failed to validate access token: %v", err)
+	}
+	ctx := ContextWithUser(context.Background(), payload)
+	sessions, err := svc.ListDeviceSessions(ctx, registered.User.ID)
+	if err != nil {
+		t.Fatalf("ListDeviceSessions failed: %v", err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("Expected a session per login, got %d", len(sessions))
+	}
+
+	current := sessions[0]
+	if !current.Current || current.UserAgent != "Firefox" {
+		t.Errorf("Expected the refreshed laptop session first and current, got %+v", current)
+	}
+	if !current.CreatedAt.Equal(started) || !current.LastUsedAt.Equal(clk.Now()) {
+		t.Errorf("Expected the session to keep its start and record the refresh, got %+v", current)
+	}
+	if payload.Family == nil || current.ID != *payload.Family {
+		t.Errorf("Expected the session ID to be the token family")
+	}
+	if sessions[1].Current || sessions[1].UserAgent != "Safari" {
+		t.Errorf("Expected the phone session second, got %+v", sessions[1])
+	}
+
+	if err := svc.RevokeDeviceSession(ctx, registered.User.ID, sessions[1].ID); err != nil {
+		t.Fatalf("RevokeDeviceSession failed: %v", err)
+	}
+	if _, err := svc.RefreshToken(phone, onPhone.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("Expected the revoked session to be unable to refresh, got: %v", err)
+	}
+	if err := svc.RevokeDeviceSession(ctx, registered.User.ID, sessions[1].ID); !errors.Is(err, ErrDeviceSessionNotFound) {
+		t.Errorf("Expected ErrDeviceSessionNotFound for a revoked session, got: %v", err)
+	}
+	if err := svc.RevokeDeviceSession(ctx, uuid.New(), current.ID); !errors.Is(err, ErrDeviceSessionNotFound) {
+		t.Errorf("Expected other users' sessions to be out of reach, got: %v", err)
+	}
+}
+
+type memoryAuditSink struct {
+	events []*AuditEvent
+	err    error
+}
+
+func (s *memoryAuditSink) RecordAuditEvent(ctx context.Context, event *AuditEvent) error {
+	s.events = append(s.events, event)
+	return s.err
+}
+
+func (s *memoryAuditSink) types() []string {
+	types := make([]string, len(s.events))
+	for i, event := range s.events {
+		types[i] = event.Type
+	}
+	return types
+}
+
+func TestService_AuditTrail(t *testing.T) {
+	sink := &memoryAuditSink{}
+	svc := newTestService(t, ServiceConfig{
+		Hasher:    NewBcryptHasher(MinBcryptCost),
+		TokenRepo: newMemoryTokenRepo(),
+		AuditSink: sink,
+	})
+	ctx := ContextWithClient(context.Background(), ClientInfo{IPAddress: "203.0.113.10", UserAgent: "Firefox"})
+
+	registered, err := svc.Register(ctx, &RegisterRequest{Email: "user@example.com", Password: "SecureP@ssw0rd!"})
+	if err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+	if _, err := svc.Login(ctx, &LoginRequest{Email: "nobody@example.com", Password: "whatever"}); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("Expected ErrInvalidCredentials, got: %v", err)
+	}
+	if _, err := svc.Login(ctx, &LoginRequest{Email: "user@example.com", Password: "wrong-password"}); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("Expected ErrInvalidCredentials, got: %v", err)
+	}
+	if _, err := svc.Login(ctx, &LoginRequest{Email: "user@example.com", Password: "SecureP@ssw0rd!"}); err != nil {
+		t.Fatalf("Failed to log in: %v", err)
+	}
+	if err := svc.Logout(ctx, registered.RefreshToken); err != nil {
+		t.Fatalf("Failed to log out: %v", err)
+	}
+
+	want := []string{AuditLoginFailed, AuditLoginFailed, AuditLoginSucceeded, AuditLogout}
+	if got := sink.types(); !slices.Equal(got, want) {
+		t.Fatalf("Expected events %v, got %v", want, got)
+	}
+
+	unknown := sink.events[0]
+	if unknown.UserID != nil || unknown.Details["email"] != "nobody@example.com" || unknown.Details["reason"] != "unknown_email" {
+		t.Errorf("Expected a failure without a user for the unknown email, got %+v", unknown)
+	}
+	wrong := sink.events[1]
+	if wrong.UserID == nil || *wrong.UserID != registered.User.ID || wrong.Details["reason"] != "invalid_password" {
+		t.Errorf("Expected a failure for the user with the reason, got %+v", wrong)
+	}
+	for _, event := range sink.events {
+		if event.ID == uuid.Nil || event.OccurredAt.IsZero() {
+			t.Errorf("Expected %s to be stamped with an ID and time", event.Type)
+		}
+		if event.IPAddress != "203.0.113.10" || event.UserAgent != "Firefox" {
+			t.Errorf("Expected %s to carry the client, got %q %q", event.Type, event.IPAddress, event.UserAgent)
+		}
+	}
+}
+
+func TestService_AuditSinkFailureDoesNotFailLogin(t *testing.T) {
+	sink := &memoryAuditSink{err: errors.New("audit store down")}
+	svc := newTestService(t, ServiceConfig{
+		Hasher:    NewBcryptHasher(MinBcryptCost),
+		AuditSink: AuditSinks{sink, NewPubSubAuditSink(nopPublisher{})},
+	})
+	ctx := context.Background()
+
+	if _, err := svc.Register(ctx, &RegisterRequest{Email: "user@example.com", Password: "SecureP@ssw0rd!"}); err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+	if _, err := svc.Login(ctx, &LoginRequest{Email: "user@example.com", Password: "SecureP@ssw0rd!"}); err != nil {
+		t.Fatalf("Expected the login to succeed despite the audit failure, got: %v", err)
+	}
+	if len(sink.events) != 1 {
+		t.Errorf("Expected the event to reach the sink, got %d", len(sink.events))
+	}
+	if _, err := svc.ListAuditEvents(ctx, AuditFilter{}); !errors.Is(err, ErrAuditUnavailable) {
+		t.Errorf("Expected ErrAuditUnavailable without a reader, got: %v", err)
+	}
+}
+
+func TestService_CompleteLogin(t *testing.T) {
+	sink := &memoryAuditSink{}
+	repo := newMemoryUserRepo()
+	svc := newTestService(t, ServiceConfig{
+		Hasher:                   NewBcryptHasher(MinBcryptCost),
+		UserRepo:                 repo,
+		RequireEmailVerification: true,
+		AuditSink:                sink,
+		Mailer:                   &recordingMailer{},
+	})
+	ctx := context.Background()
+
+	registered, err := svc.Register(ctx, &RegisterRequest{Email: "user@example.com", Password: "SecureP@ssw0rd!"})
+	if err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+	userID := registered.User.ID
+	logins := func() []*AuditEvent {
+		var events []*AuditEvent
+		for _, event := range sink.events {
+			if event.Type == AuditLoginFailed || event.Type == AuditLoginSucceeded {
+				events = append(events, event)
+			}
+		}
+		sink.events = nil
+		return events
+	}
+
+	// Passkey logins pass the same gate as password logins
+	if _, err := svc.CompleteLogin(ctx, userID, "passkey"); !errors.Is(err, ErrEmailNotVerified) {
+		t.Fatalf("Expected ErrEmailNotVerified, got: %v", err)
+	}
+	if events := logins(); len(events) != 1 || events[0].Type != AuditLoginFailed || events[0].Details["method"] != "passkey" || events[0].Details["reason"] != "email_not_verified" {
+		t.Errorf("Expected a failed passkey login to be audited, got %+v", events)
+	}
+
+	repo.users["user@example.com"].EmailVerified = true
+	result, err := svc.CompleteLogin(ctx, userID, "passkey")
+	if err != nil || result.AccessToken == "" {
+		t.Fatalf("Expected tokens once verified, got %+v, %v", result, err)
+	}
+	if events := logins(); len(events) != 1 || events[0].Type != AuditLoginSucceeded || events[0].Details["method"] != "passkey" {
+		t.Errorf("Expected the passkey login to be audited, got %+v", events)
+	}
+
+	if _, err := svc.CompleteLogin(ctx, uuid.New(), "passkey"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound for an unknown user, got: %v", err)
+	}
+}
+
+type nopPublisher struct{}
+
+func (nopPublisher) Publish(topic string, payload interface{}) int { return 0 }
+
+func TestPubSubAuditSink_PublishesCatalogedEvents(t *testing.T) {
+	bus := pubsub.New(nil, 10)
+	sub := bus.Subscribe(context.Background(), "test", events.AuthLoginFailed.Name())
+	defer bus.Unsubscribe(sub)
+
+	sink := NewPubSubAuditSink(bus)
+	if err := sink.RecordAuditEvent(context.Background(), &AuditEvent{Type: AuditLoginFailed, Details: map[string]any{"reason": "invalid_password"}}); err != nil {
+		t.Fatalf("RecordAuditEvent failed: %v", err)
+	}
+
+	e := <-sub.Channel
+	payload, ok := events.AuthLoginFailed.Payload(e.Payload)
+	if !ok || payload.Type != AuditLoginFailed || payload.Details["reason"] != "invalid_password" {
+		t.Errorf("Unexpected payload: %#v", e.Payload)
+	}
+
+	for _, eventType := range []string{AuditLoginSucceeded, AuditLoginFailed, AuditLogout, AuditPasswordChanged, AuditTokenRevoked, AuditPasskeyAdded, AuditGuestUpgraded, AuditIncidentDeclared} {
+		if _, ok := events.Lookup(AuditTopicPrefix + eventType); !ok {
+			t.Errorf("%s is missing from the event catalog", eventType)
+		}
+	}
+}
+
+func TestService_PublishesUserCreated(t *testing.T) {
+	bus := pubsub.New(nil, 10)
+	sub := bus.Subscribe(context.Background(), "test", events.UserCreated.Name())
+	defer bus.Unsubscribe(sub)
+
+	svc := newTestService(t, ServiceConfig{
+		Hasher: NewBcryptHasher(MinBcryptCost),
+		Events: bus,
+	})
+	resp, err := svc.Register(context.Background(), &RegisterRequest{Email: "user@example.com", Password: "SecureP@ssw0rd!"})
+	if err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+
+	e := <-sub.Channel
+	created, ok := events.UserCreated.Payload(e.Payload)
+	if !ok || created.UserID != resp.User.ID || created.Guest {
+		t.Errorf("Unexpected payload: %#v", e.Payload)
+	}
+}
+
+func TestAuditSinks_JoinsErrors(t *testing.T) {
+	failing := &memoryAuditSink{err: errors.New("down")}
+	working := &memoryAuditSink{}
+
+	err := AuditSinks{failing, working}.RecordAuditEvent(context.Background(), &AuditEvent{Type: AuditLogout})
+	if err == nil || err.Error() != "down" {
+		t.Errorf("Expected the failing sink's error, got: %v", err)
+	}
+	if len(working.events) != 1 {
+		t.Errorf("Expected every sink to be tried")
+	}
+}
+
+type fakeBreachChecker struct {
+	counts map[string]int
+	err    error
+}
+
+func (f fakeBreachChecker) BreachCount(ctx context.Context, password string) (int, error) {
+	return f.counts[password], f.err
+}
+
+func TestPasswordPolicy_Check(t *testing.T) {
+	policy := NewPasswordPolicy(30, "Goiler2024")
+	policy.Breaches = fakeBreachChecker{counts: map[string]int{"Tr0ub4dor&3": 12}}
+
+	tests := []struct {
+		name     string
+		password string
+		want     []string
+	}{
+		{"strong", "SecureP@ssw0rd!", nil},
+		{"common", "Password123", []string{ViolationDenylisted}},
+		{"configured denylist", "goiler2024", []string{ViolationDenylisted}},
+		{"repeated", "aaaaaaaaaaaa", []string{ViolationLowEntropy}},
+		{"sequence", "abcdefghijkl", []string{ViolationLowEntropy}},
+		{"common and weak", "11111111", []string{ViolationDenylisted, ViolationLowEntropy}},
+		{"breached", "Tr0ub4dor&3", []string{ViolationBreached}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Check(context.Background(), tt.password)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("Expected the password to pass, got %v", err)
+				}
+				return
+			}
+
+			var policyErr *PasswordPolicyError
+			if !errors.As(err, &policyErr) || !errors.Is(err, ErrWeakPassword) {
+				t.Fatalf("Expected a PasswordPolicyError, got %v", err)
+			}
+			var codes []string
+			for _, v := range policyErr.Violations {
+				codes = append(codes, v.Code)
+			}
+			if !slices.Equal(codes, tt.want) {
+				t.Errorf("Expected violations %v, got %v", tt.want, codes)
+			}
+		})
+	}
+}
+
+func TestPasswordPolicy_BreachLookupFailsOpen(t *testing.T) {
+	policy := NewPasswordPolicy(0)
+	policy.Breaches = fakeBreachChecker{err: errors.New("lookup unavailable")}
+
+	if err := policy.Check(context.Background(), "SecureP@ssw0rd!"); err != nil {
+		t.Errorf("Expected a failed lookup to accept the password, got %v", err)
+	}
+
+	var nilPolicy *PasswordPolicy
+	if err := nilPolicy.Check(context.Background(), "a"); err != nil {
+		t.Errorf("Expected a nil policy to accept every password, got %v", err)
+	}
+}
+
+func TestPwnedPasswords_BreachCount(t *testing.T) {
+	// SHA-1 of "password" is 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8
+	var gotPath, gotPadding string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotPadding = r.Header.Get("Add-Padding")
+		w.Write([]byte("0018A45C4D1DEF81644B54AB7F969B88D65:1\r\n1E4C9B93F3F0682250B6CF8331B7EE68FD8:3861493\r\n011053FD0102E94D6AE2F8B83D76FAF94F6:0\r\n"))
+	}))
+	defer server.Close()
+
+	client := NewPwnedPasswords(server.URL, server.Client())
+
+	count, err := client.BreachCount(context.Background(), "password")
+	if err != nil {
+		t.Fatalf("BreachCount failed: %v", err)
+	}
+	if count != 3861493 {
+		t.Errorf("Expected 3861493 breaches, got %d", count)
+	}
+	if gotPath != "/5BAA6" {
+		t.Errorf("Expected only the hash prefix to be sent, got path %q", gotPath)
+	}
+	if gotPadding != "true" {
+		t.Error("Expected padded responses to be requested")
+	}
+
+	if count, err := client.BreachCount(context.Background(), "SecureP@ssw0rd!"); err != nil || count != 0 {
+		t.Errorf("Expected an unlisted password to have no breaches, got %d, %v", count, err)
+	}
+}
+
+func TestService_RegisterPasswordPolicy(t *testing.T) {
+	repo := newMemoryUserRepo()
+	svc := newTestService(t, ServiceConfig{UserRepo: repo, PasswordPolicy: NewPasswordPolicy(30)})
+
+	_, err := svc.Register(context.Background(), &RegisterRequest{Email: "weak@example.com", Password: "password123"})
+	if !errors.Is(err, ErrWeakPassword) {
+		t.Fatalf("Expected ErrWeakPassword, got %v", err)
+	}
+	if _, ok := repo.users["weak@example.com"]; ok {
+		t.Error("Expected no user to be created with a weak password")
+	}
+
+	if _, err := svc.Register(context.Background(), &RegisterRequest{Email: "strong@example.com", Password: "SecureP@ssw0rd!"}); err != nil {
+		t.Errorf("Register with a strong password failed: %v", err)
+	}
+}
+
+type memoryIncidentStore struct {
+	incidents []*Incident
+}
+
+func (s *memoryIncidentStore) CreateIncident(ctx context.Context, inc *Incident) error {
+	stored := *inc
+	s.incidents = append(s.incidents, &stored)
+	return nil
+}
+
+func (s *memoryIncidentStore) ListIncidents(ctx context.Context, limit, offset int) ([]*Incident, error) {
+	return slices.Clone(s.incidents), nil
+}
+
+func (s *memoryIncidentStore) TokenCutoffs(ctx context.Context) (map[string]time.Time, error) {
+	cutoffs := make(map[string]time.Time)
+	for _, inc := range s.incidents {
+		if inc.NotBefore.After(cutoffs[inc.Role]) {
+			cutoffs[inc.Role] = inc.NotBefore
+		}
+	}
+	return cutoffs, nil
+}
+
+func (s *memoryIncidentStore) KeyRotations(ctx context.Context) ([]time.Time, error) {
+	var rotations []time.Time
+	for _, inc := range s.incidents {
+		if inc.RotatedKeys {
+			rotations = append(rotations, inc.NotBefore)
+		}
+	}
+	return rotations, nil
+}
+
+func TestService_DeclareIncidentForRole(t *testing.T) {
+	clk := clock.NewFrozen(time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC))
+	maker, err := NewJWTMaker("this-is-a-very-secure-secret-key-32chars", WithTokenClock(clk))
+	if err != nil {
+		t.Fatalf("Failed to create JWT maker: %v", err)
+	}
+	store := &memoryIncidentStore{}
+	svc := NewService(ServiceConfig{UserRepo: newMemoryUserRepo(), TokenMaker: maker, Clock: clk, Incidents: store})
+	// Another instance sharing the store
+	other := NewService(ServiceConfig{UserRepo: newMemoryUserRepo(), TokenMaker: maker, Clock: clk, Incidents: store})
+	ctx := context.Background()
+
+	adminToken, _, _ := maker.CreateToken(uuid.New(), "admin@example.com", "admin", AccessToken, time.Hour)
+	userToken, _, _ := maker.CreateToken(uuid.New(), "user@example.com", "user", AccessToken, time.Hour)
+	userRefresh, _, _ := maker.CreateToken(uuid.New(), "user@example.com", "user", RefreshToken, time.Hour)
+
+	clk.Advance(2 * time.Second)
+	incident, err := svc.DeclareIncident(ctx, uuid.New(), &IncidentRequest{Reason: "leaked user sessions", Role: "user"})
+	if err != nil {
+		t.Fatalf("DeclareIncident failed: %v", err)
+	}
+	if !incident.NotBefore.Equal(clk.Now()) || incident.Role != "user" {
+		t.Errorf("Unexpected incident: %+v", incident)
+	}
+
+	if _, err := svc.ValidateToken(ctx, userToken); !errors.Is(err, ErrRevokedByIncident) {
+		t.Errorf("Expected the user's token to be revoked, got: %v", err)
+	}
+	if _, err := svc.RefreshToken(ctx, userRefresh); !errors.Is(err, ErrRevokedByIncident) {
+		t.Errorf("Expected the user's refresh token to be revoked, got: %v", err)
+	}
+	if _, err := svc.ValidateToken(ctx, adminToken); err != nil {
+		t.Errorf("Expected other roles to keep their tokens, got: %v", err)
+	}
+	fresh, _, _ := maker.CreateToken(uuid.New(), "user@example.com", "user", AccessToken, time.Hour)
+	if _, err := svc.ValidateToken(ctx, fresh); err != nil {
+		t.Errorf("Expected tokens issued after the incident to be accepted, got: %v", err)
+	}
+
+	// Other instances enforce it once they reload
+	if _, err := other.ValidateToken(ctx, userToken); err != nil {
+		t.Errorf("Expected the incident to be unknown before reloading, got: %v", err)
+	}
+	if err := other.ReloadIncidents(ctx); err != nil {
+		t.Fatalf("ReloadIncidents failed: %v", err)
+	}
+	if _, err := other.ValidateToken(ctx, userToken); !errors.Is(err, ErrRevokedByIncident) {
+		t.Errorf("Expected the incident to apply after reloading, got: %v", err)
+	}
+}
+
+func TestTokenCutoffs_Revokes(t *testing.T) {
+	incident := time.Date(2025, 1, 1, 12, 0, 0, 500_000_000, time.UTC)
+	cutoffs := tokenCutoffs{"user": incident}
+
+	if !cutoffs.revokes("user", incident.Add(-time.Millisecond)) {
+		t.Error("Expected credentials issued within the incident's second, before it, to be revoked")
+	}
+	if !cutoffs.revokes("user", incident.Truncate(time.Second)) {
+		t.Error("Expected tokens whose timestamp was truncated to the incident's second to be revoked")
+	}
+	if cutoffs.revokes("user", incident) || cutoffs.revokes("admin", incident.Add(-time.Hour)) {
+		t.Error("Expected later credentials and other roles to be kept")
+	}
+}
+
+// rolePermissions maps roles to the permissions they grant, "*" granting
+// every other
+type rolePermissions map[string][]string
+
+func (r rolePermissions) HasRole(role string) bool {
+	_, ok := r[role]
+	return ok
+}
+
+func (r rolePermissions) Covers(role, other string) bool {
+	for _, perm := range r[other] {
+		if !slices.Contains(r[role], perm) && !slices.Contains(r[role], "*") {
+			return false
+		}
+	}
+	return true
+}
+
+func TestService_DeclareIncidentUnknownRole(t *testing.T) {
+	store := &memoryIncidentStore{}
+	svc := newTestService(t, ServiceConfig{Incidents: store, Roles: rolePermissions{"admin": {"*"}, "user": nil}})
+
+	if _, err := svc.DeclareIncident(context.Background(), uuid.New(), &IncidentRequest{Reason: "typo", Role: "usr"}); !errors.Is(err, ErrUnknownRole) {
+		t.Fatalf("Expected ErrUnknownRole, got: %v", err)
+	}
+	if len(store.incidents) != 0 {
+		t.Error("Expected no incident to be declared for an unknown role")
+	}
+	if _, err := svc.DeclareIncident(context.Background(), uuid.New(), &IncidentRequest{Reason: "leak", Role: "user"}); err != nil {
+		t.Errorf("Expected a known role to be accepted, got: %v", err)
+	}
+}
+
+func TestService_DeclareIncidentRotatesKeys(t *testing.T) {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	clk := clock.NewFrozen(start)
+	_, oldKey, _ := ed25519.GenerateKey(rand.Reader)
+	_, newKey, _ := ed25519.GenerateKey(rand.Reader)
+	newRing := func() *KeyRing {
+		ring, err := NewKeyRing(time.Hour,
+			RingKey{ID: "old", Key: oldKey},
+			RingKey{ID: "next", Key: newKey, ActivatesAt: start.Add(30 * 24 * time.Hour)},
+		)
+		if err != nil {
+			t.Fatalf("NewKeyRing failed: %v", err)
+		}
+		return ring
+	}
+	store := &memoryIncidentStore{}
+	maker := NewKeyRingJWTMaker(newRing(), WithTokenClock(clk))
+	svc := NewService(ServiceConfig{UserRepo: newMemoryUserRepo(), TokenMaker: maker, Clock: clk, Incidents: store})
+	ctx := context.Background()
+
+	if _, err := svc.DeclareIncident(ctx, uuid.New(), &IncidentRequest{Reason: "key leak", Role: "user", RotateKeys: true}); !errors.Is(err, ErrRotateKeysForRole) {
+		t.Errorf("Expected ErrRotateKeysForRole, got: %v", err)
+	}
+	if _, err := newTestService(t, ServiceConfig{Incidents: store}).DeclareIncident(ctx, uuid.New(), &IncidentRequest{Reason: "key leak", RotateKeys: true}); !errors.Is(err, ErrKeyRotationUnavailable) {
+		t.Errorf("Expected ErrKeyRotationUnavailable without a key ring, got: %v", err)
+	}
+
+	clk.Advance(time.Minute)
+	if _, err := svc.DeclareIncident(ctx, uuid.New(), &IncidentRequest{Reason: "key leak", RotateKeys: true}); err != nil {
+		t.Fatalf("DeclareIncident failed: %v", err)
+	}
+
+	token, _, err := maker.CreateToken(uuid.New(), "user@example.com", "user", AccessToken, time.Hour)
+	if err != nil {
+		t.Fatalf("CreateToken failed: %v", err)
+	}
+	if kid := jwtHeaderKID(t, token); kid != "next" {
+		t.Errorf("Expected the next key to sign after rotating, got kid %q", kid)
+	}
+	if keys := maker.JWKS().Keys; len(keys) != 1 || keys[0].KeyID != "next" {
+		t.Errorf("Expected the old key to be unpublished, got %+v", keys)
+	}
+
+	// A restarted instance loading the original ring catches up on reload
+	restarted := NewKeyRingJWTMaker(newRing(), WithTokenClock(clk))
+	other := NewService(ServiceConfig{UserRepo: newMemoryUserRepo(), TokenMaker: restarted, Clock: clk, Incidents: store})
+	if err := other.ReloadIncidents(ctx); err != nil {
+		t.Fatalf("ReloadIncidents failed: %v", err)
+	}
+	if _, err := other.ValidateToken(ctx, token); err != nil {
+		t.Errorf("Expected the rotated key's token to verify after reloading, got: %v", err)
+	}
+	if err := restarted.KeyRing().RotateKeys(clk.Now().Add(time.Minute)); !errors.Is(err, ErrNoUpcomingKey) {
+		t.Errorf("Expected ErrNoUpcomingKey once the last key signs, got: %v", err)
+	}
+}
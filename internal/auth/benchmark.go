@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"runtime"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Upper bounds for the parameter search
+const (
+	maxBenchmarkArgon2Iterations = 10
+	maxBenchmarkBcryptCost       = 14
+)
+
+// HashRecommendation holds hashing parameters measured on the current host
+type HashRecommendation struct {
+	Argon2         *Argon2Params
+	Argon2Duration time.Duration
+	BcryptCost     int
+	BcryptDuration time.Duration
+}
+
+// BenchmarkHashParams measures hashing on the current host and recommends
+// the strongest parameters whose single hash stays within target. It never
+// recommends parameters below the accepted minimums, even on slow hosts.
+func BenchmarkHashParams(target time.Duration) *HashRecommendation {
+	rec := &HashRecommendation{}
+	rec.Argon2, rec.Argon2Duration = benchmarkArgon2(target)
+	rec.BcryptCost, rec.BcryptDuration = benchmarkBcrypt(target)
+	return rec
+}
+
+// benchmarkArgon2 starts from the default memory cost, lowering it toward the
+// minimum if even the minimum iteration count is too slow, then raises the
+// iteration count while the hash stays within target
+func benchmarkArgon2(target time.Duration) (*Argon2Params, time.Duration) {
+	params := DefaultArgon2Params()
+	params.Iterations = MinArgon2Iterations
+	params.Parallelism = uint8(min(max(runtime.NumCPU(), 1), 4))
+
+	elapsed := timeArgon2(params)
+	for elapsed > target && params.Memory/2 >= MinArgon2Memory {
+		params.Memory /= 2
+		elapsed = timeArgon2(params)
+	}
+
+	for params.Iterations < maxBenchmarkArgon2Iterations {
+		next := *params
+		next.Iterations++
+		nextElapsed := timeArgon2(&next)
+		if nextElapsed > target {
+			break
+		}
+		params, elapsed = &next, nextElapsed
+	}
+
+	return params, elapsed
+}
+
+// benchmarkBcrypt raises the cost while the hash stays within target
+func benchmarkBcrypt(target time.Duration) (int, time.Duration) {
+	cost := MinBcryptCost
+	elapsed := timeBcrypt(cost)
+
+	// Each cost step doubles the work
+	for cost < maxBenchmarkBcryptCost && elapsed*2 <= target {
+		cost++
+		elapsed = timeBcrypt(cost)
+	}
+
+	return cost, elapsed
+}
+
+func timeArgon2(params *Argon2Params) time.Duration {
+	salt := make([]byte, params.SaltLength)
+	start := time.Now()
+	argon2.IDKey([]byte("benchmark-password"), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	return time.Since(start)
+}
+
+func timeBcrypt(cost int) time.Duration {
+	start := time.Now()
+	_, _ = bcrypt.GenerateFromPassword([]byte("benchmark-password"), cost)
+	return time.Since(start)
+}
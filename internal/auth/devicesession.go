@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrDeviceSessionNotFound = errors.New("session not found")
+	// ErrDeviceSessionsUnavailable is returned when refresh tokens aren't
+	// stored or the service issues cookie sessions
+	ErrDeviceSessionsUnavailable = errors.New("session management is not available")
+)
+
+// RefreshTokenInfo is a stored refresh token and the device it was issued
+// to. Tokens rotated from one another share a family.
+type RefreshTokenInfo struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	Family    uuid.UUID
+	UserAgent string
+	IPAddress string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+	// SessionStartedAt is when the first token in the family was issued
+	SessionStartedAt time.Time
+}
+
+// DeviceSession is a login on a device: the family of refresh tokens
+// issued from one sign-in. The device details and LastUsedAt are those of
+// the latest refresh.
+type DeviceSession struct {
+	ID         uuid.UUID `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	// Current is set on the session the request's access token came from
+	Current bool `json:"current"`
+}
+
+// ListDeviceSessions returns the user's active sessions, most recently
+// used first
+func (s *Service) ListDeviceSessions(ctx context.Context, userID uuid.UUID) ([]*DeviceSession, error) {
+	if s.tokenRepo == nil || s.sessions != nil {
+		return nil, ErrDeviceSessionsUnavailable
+	}
+
+	tokens, err := s.tokenRepo.ListRefreshTokens(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var current uuid.UUID
+	if caller, ok := UserFromContext(ctx); ok && caller.Family != nil && caller.ImpersonatedBy == nil {
+		current = *caller.Family
+	}
+
+	// A family briefly has two live tokens while one is rotated; the
+	// newest describes the session
+	seen := make(map[uuid.UUID]bool, len(tokens))
+	sessions := make([]*DeviceSession, 0, len(tokens))
+	for _, token := range tokens {
+		if seen[token.Family] {
+			continue
+		}
+		seen[token.Family] = true
+		sessions = append(sessions, &DeviceSession{
+			ID:         token.Family,
+			UserAgent:  token.UserAgent,
+			IPAddress:  token.IPAddress,
+			CreatedAt:  token.SessionStartedAt,
+			LastUsedAt: token.IssuedAt,
+			ExpiresAt:  token.ExpiresAt,
+			Current:    token.Family == current,
+		})
+	}
+	return sessions, nil
+}
+
+// RevokeDeviceSession revokes the refresh tokens of one of the user's
+// sessions, so the device can't refresh again. Access tokens already issued
+// to it stay valid until they expire.
+func (s *Service) RevokeDeviceSession(ctx context.Context, userID, id uuid.UUID) error {
+	if s.tokenRepo == nil || s.sessions != nil {
+		return ErrDeviceSessionsUnavailable
+	}
+	if err := s.tokenRepo.RevokeRefreshTokenFamily(ctx, userID, id); err != nil {
+		return err
+	}
+	s.auditor.Record(ctx, AuditTokenRevoked, userID, map[string]any{
+		"session_id": id.String(),
+		"reason":     "session_revoked",
+	})
+	return nil
+}
@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"time"
+)
+
+// DeviceIDHeader lets clients send a stable device identifier that takes
+// precedence over user-agent and network matching
+const DeviceIDHeader = "X-Device-ID"
+
+// Security event types
+const (
+	SecurityEventRefreshFingerprintMismatch = "refresh_fingerprint_mismatch"
+	SecurityEventFailedLogins               = "failed_logins"
+	SecurityEventNewCountryLogin            = "new_country_login"
+)
+
+// ClientInfo describes the client making an auth request
+type ClientInfo struct {
+	IPAddress string
+	UserAgent string
+	DeviceID  string
+	// Country is the client's ISO 3166-1 alpha-2 country code, if known
+	Country string
+}
+
+type clientInfoKey struct{}
+
+// ContextWithClient returns a context carrying client info
+func ContextWithClient(ctx context.Context, client ClientInfo) context.Context {
+	return context.WithValue(ctx, clientInfoKey{}, client)
+}
+
+// ClientFromContext returns the client info stored in ctx, if any
+func ClientFromContext(ctx context.Context) (ClientInfo, bool) {
+	client, ok := ctx.Value(clientInfoKey{}).(ClientInfo)
+	return client, ok
+}
+
+// Fingerprint identifies a client by hashed attributes. Raw values are
+// never stored in tokens.
+type Fingerprint struct {
+	Device  string `json:"device,omitempty"`
+	Agent   string `json:"agent,omitempty"`
+	Network string `json:"network,omitempty"`
+}
+
+// Fingerprint returns the hashed fingerprint of the client
+func (c ClientInfo) Fingerprint() *Fingerprint {
+	return &Fingerprint{
+		Device:  hashComponent(c.DeviceID),
+		Agent:   hashComponent(c.UserAgent),
+		Network: hashComponent(networkPrefix(c.IPAddress)),
+	}
+}
+
+// Matches reports whether other plausibly belongs to the same client. A
+// fingerprint with a device ID only matches the same device ID, so a client
+// can't drop the header and pass on the weaker signals; otherwise a change
+// of either user agent or network is tolerated, but not both at once.
+func (f *Fingerprint) Matches(other *Fingerprint) bool {
+	if f == nil || other == nil {
+		return false
+	}
+	if f.Device != "" {
+		return f.Device == other.Device
+	}
+	return f.Agent == other.Agent || f.Network == other.Network
+}
+
+// SecurityEvent describes a security-relevant event for a user
+type SecurityEvent struct {
+	Type       string
+	UserID     string
+	Email      string
+	IPAddress  string
+	UserAgent  string
+	Country    string
+	OccurredAt time.Time
+	// FailedAttempts is the number of failed logins, for failed login events
+	FailedAttempts int
+	// SecureAccountURL signs the account out everywhere, if configured
+	SecureAccountURL string
+}
+
+// SecurityNotifier delivers security events to users, typically through a
+// background task
+type SecurityNotifier interface {
+	NotifySecurityEvent(ctx context.Context, event SecurityEvent) error
+}
+
+// networkPrefix reduces an IP to its /24 (IPv4) or /48 (IPv6) network so
+// that address churn within a provider doesn't change the fingerprint
+func networkPrefix(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return parsed.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// hashComponent hashes a fingerprint component, leaving empty values empty
+func hashComponent(value string) string {
+	if value == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:16])
+}
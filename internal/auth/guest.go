@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrGuestAccountsDisabled = errors.New("guest accounts are disabled")
+	ErrNotGuest              = errors.New("user is not a guest")
+)
+
+// UpgradeGuestRequest represents a request to turn a guest account into a
+// full account
+type UpgradeGuestRequest struct {
+	Email    string `json:"email" validate:"required,email"`
+	Password string `json:"password" validate:"required,min=8"`
+}
+
+// GuestAccounts reports whether guest accounts can be created
+func (s *Service) GuestAccounts() bool {
+	return s.guestAccounts
+}
+
+// CreateGuest creates a guest account, with no email or password, and
+// issues it tokens. Guests are never asked to verify an email; they keep
+// their ID and everything tied to it when they upgrade.
+func (s *Service) CreateGuest(ctx context.Context) (*AuthResponse, error) {
+	if !s.guestAccounts {
+		return nil, ErrGuestAccountsDisabled
+	}
+
+	user := &User{
+		ID:        s.ids.NewID(),
+		Role:      "user",
+		Guest:     true,
+		CreatedAt: s.clock.Now(),
+		UpdatedAt: s.clock.Now(),
+	}
+	if err := s.userRepo.Create(ctx, user); err != nil {
+		return nil, err
+	}
+
+	s.logger.InfoContext(ctx, "guest created", slog.String("user_id", user.ID.String()))
+	s.publishUserCreated(ctx, user)
+
+	return s.generateTokenPair(ctx, user)
+}
+
+// UpgradeGuest gives a guest account an email and password, keeping its
+// ID. Passwords failing the password policy are rejected with a
+// *PasswordPolicyError, and registered emails with ErrUserAlreadyExists.
+// The response carries new tokens naming the email, or VerificationRequired
+// like Register; tokens issued to the guest stay valid until they expire.
+func (s *Service) UpgradeGuest(ctx context.Context, userID uuid.UUID, req *UpgradeGuestRequest) (*AuthResponse, error) {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	if !user.Guest {
+		return nil, ErrNotGuest
+	}
+
+	if err := s.passwordPolicy.Check(ctx, req.Password); err != nil {
+		return nil, err
+	}
+	passwordHash, err := s.hasher.Hash(req.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	if existing, _ := s.userRepo.GetByEmail(ctx, req.Email); existing != nil {
+		return nil, ErrUserAlreadyExists
+	}
+	if err := s.userRepo.UpgradeGuest(ctx, user.ID, req.Email, passwordHash); err != nil {
+		return nil, err
+	}
+
+	user.Email = req.Email
+	user.PasswordHash = passwordHash
+	user.Guest = false
+	user.UpdatedAt = s.clock.Now()
+
+	s.logger.InfoContext(ctx, "guest upgraded", slog.String("user_id", user.ID.String()))
+	s.auditor.Record(ctx, AuditGuestUpgraded, user.ID, nil)
+
+	s.sendVerificationEmail(ctx, user)
+
+	if s.requireVerification {
+		return &AuthResponse{User: newUserResponse(user), VerificationRequired: true}, nil
+	}
+	return s.generateTokenPair(ctx, user)
+}
@@ -1,14 +1,37 @@
 package auth
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"html/template"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/internal/tenant"
+	"github.com/pixperk/goiler/pkg/authctx"
+	"github.com/pixperk/goiler/pkg/pagination"
 	"github.com/pixperk/goiler/pkg/response"
+	"github.com/pixperk/goiler/pkg/sandbox"
 	"github.com/pixperk/goiler/pkg/validator"
 )
 
+// NeutralRegisterMessage is returned by Register when neutral responses are enabled
+const NeutralRegisterMessage = "Registration received. If this email was not already registered, you can now log in."
+
+// ErrCodeInvalidCSRFToken is the error code of requests rejected by CSRF
+// protection in cookie mode
+const ErrCodeInvalidCSRFToken = "INVALID_CSRF_TOKEN"
+
+// ErrCodeImpersonationForbidden is the error code of requests
+// RejectImpersonation refuses
+const ErrCodeImpersonationForbidden = "IMPERSONATION_FORBIDDEN"
+
 // Handler handles HTTP requests for authentication
 type Handler struct {
 	service *Service
@@ -26,7 +49,8 @@ func NewHandler(service *Service) *Handler {
 // @Accept json
 // @Produce json
 // @Param request body RegisterRequest true "Registration details"
-// @Success 201 {object} AuthResponse
+// @Success 201 {object} AuthResponse "Tokens (session cookies with AUTH_MODE=cookie), or verification_required when AUTH_REQUIRE_EMAIL_VERIFICATION is set"
+// @Success 202 {object} response.Response "Neutral response (AUTH_NEUTRAL_RESPONSES)"
 // @Failure 400 {object} response.Response
 // @Failure 409 {object} response.Response
 // @Failure 422 {object} response.Response
@@ -41,19 +65,108 @@ func (h *Handler) Register(c echo.Context) error {
 		return response.ValidationError(c, validator.FormatErrors(err))
 	}
 
-	result, err := h.service.Register(c.Request().Context(), &req)
+	result, err := h.service.Register(ClientContext(c), &req)
+
+	// In neutral mode, new and existing emails get the same response so the
+	// endpoint can't be used to discover registered accounts
+	if h.service.NeutralResponses() && (err == nil || errors.Is(err, ErrUserAlreadyExists)) {
+		return response.AcceptedWithMessage(c, NeutralRegisterMessage, nil)
+	}
+
 	if err != nil {
 		if errors.Is(err, ErrUserAlreadyExists) {
 			return response.Conflict(c, "User with this email already exists")
 		}
+		var policyErr *PasswordPolicyError
+		if errors.As(err, &policyErr) {
+			return WeakPassword(c, policyErr)
+		}
 		return response.InternalError(c, "Failed to create user")
 	}
 
-	return c.JSON(http.StatusCreated, response.Response{
-		Success: true,
-		Message: "User registered successfully",
-		Data:    result,
-	})
+	message := "User registered successfully"
+	if result.VerificationRequired {
+		message = "User registered. Check your email to verify your account before logging in."
+	}
+	SetCookies(c, result.Cookies())
+
+	return response.CreatedWithMessage(c, message, result)
+}
+
+// CreateGuest handles guest account creation
+// @Summary Create a guest account
+// @Description Create an account without an email or password and sign in to it. Upgrade it with /auth/guest/upgrade to keep its data. Requires AUTH_GUEST_ACCOUNTS.
+// @Tags Auth
+// @Produce json
+// @Success 201 {object} AuthResponse "Tokens (session cookies with AUTH_MODE=cookie)"
+// @Failure 404 {object} response.Response "Guest accounts are disabled"
+// @Failure 429 {object} response.Response "Too many guest accounts created from this IP"
+// @Router /api/v1/auth/guest [post]
+func (h *Handler) CreateGuest(c echo.Context) error {
+	result, err := h.service.CreateGuest(ClientContext(c))
+	if err != nil {
+		if errors.Is(err, ErrGuestAccountsDisabled) {
+			return response.NotFound(c, "Guest accounts are not enabled")
+		}
+		return response.InternalError(c, "Failed to create guest account")
+	}
+	SetCookies(c, result.Cookies())
+
+	return response.CreatedWithMessage(c, "Guest account created", result)
+}
+
+// UpgradeGuest handles upgrading the current guest account
+// @Summary Upgrade a guest account
+// @Description Give the current guest account an email and password, keeping its ID and data. Returns new tokens, or verification_required when AUTH_REQUIRE_EMAIL_VERIFICATION is set.
+// @Tags Auth
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body UpgradeGuestRequest true "Account details"
+// @Success 200 {object} AuthResponse
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response "Impersonating"
+// @Failure 409 {object} response.Response "Not a guest, or email already registered"
+// @Failure 422 {object} response.Response
+// @Router /api/v1/auth/guest/upgrade [post]
+func (h *Handler) UpgradeGuest(c echo.Context) error {
+	payload := GetCurrentUser(c)
+	if payload == nil {
+		return response.Unauthorized(c, "User not authenticated")
+	}
+
+	var req UpgradeGuestRequest
+	if err := c.Bind(&req); err != nil {
+		return response.BadRequest(c, "Invalid request body")
+	}
+
+	if err := c.Validate(&req); err != nil {
+		return response.ValidationError(c, validator.FormatErrors(err))
+	}
+
+	result, err := h.service.UpgradeGuest(ClientContext(c), payload.UserID, &req)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrNotGuest):
+			return response.Conflict(c, "Account is not a guest account")
+		case errors.Is(err, ErrUserAlreadyExists):
+			return response.Conflict(c, "User with this email already exists")
+		}
+		var policyErr *PasswordPolicyError
+		if errors.As(err, &policyErr) {
+			return WeakPassword(c, policyErr)
+		}
+		return response.InternalError(c, "Failed to upgrade guest account")
+	}
+
+	message := "Guest account upgraded"
+	if result.VerificationRequired {
+		message = "Guest account upgraded. Check your email to verify your account before logging in again."
+	}
+	SetCookies(c, result.Cookies())
+
+	return response.SuccessWithMessage(c, message, result)
 }
 
 // Login handles user login
@@ -66,7 +179,9 @@ func (h *Handler) Register(c echo.Context) error {
 // @Success 200 {object} AuthResponse
 // @Failure 400 {object} response.Response
 // @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response "Email not verified, or account suspended"
 // @Failure 422 {object} response.Response
+// @Failure 423 {object} response.Response "Too many failed attempts"
 // @Router /api/v1/auth/login [post]
 func (h *Handler) Login(c echo.Context) error {
 	var req LoginRequest
@@ -78,17 +193,180 @@ func (h *Handler) Login(c echo.Context) error {
 		return response.ValidationError(c, validator.FormatErrors(err))
 	}
 
-	result, err := h.service.Login(c.Request().Context(), &req)
+	result, err := h.service.Login(ClientContext(c), &req)
 	if err != nil {
 		if errors.Is(err, ErrInvalidCredentials) {
 			return response.Unauthorized(c, "Invalid email or password")
 		}
+		if errors.Is(err, ErrEmailNotVerified) {
+			return response.Error(c, http.StatusForbidden, "EMAIL_NOT_VERIFIED", "Please verify your email before logging in")
+		}
+		if errors.Is(err, ErrAccountSuspended) {
+			return AccountSuspended(c)
+		}
+		if errors.Is(err, ErrAccountLocked) {
+			var lockout *LockoutError
+			if errors.As(err, &lockout) {
+				seconds := int(math.Ceil(lockout.RetryAfter.Seconds()))
+				c.Response().Header().Set("Retry-After", strconv.Itoa(max(seconds, 1)))
+			}
+			return response.Error(c, http.StatusLocked, "ACCOUNT_LOCKED", "Too many failed login attempts. Please try again later.")
+		}
 		return response.InternalError(c, "Failed to authenticate")
 	}
 
+	SetCookies(c, result.Cookies())
 	return response.SuccessWithMessage(c, "Login successful", result)
 }
 
+// VerifyEmail handles email verification links
+// @Summary Verify email
+// @Description Mark the user's email as verified using the token from the verification link
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param token query string false "Verification token (GET)"
+// @Param request body VerifyEmailRequest false "Verification token (POST)"
+// @Success 200 {object} response.Response
+// @Failure 400 {object} response.Response
+// @Failure 422 {object} response.Response
+// @Router /api/v1/auth/verify-email [get]
+// @Router /api/v1/auth/verify-email [post]
+func (h *Handler) VerifyEmail(c echo.Context) error {
+	var req VerifyEmailRequest
+	if err := c.Bind(&req); err != nil {
+		return response.BadRequest(c, "Invalid request body")
+	}
+
+	if err := c.Validate(&req); err != nil {
+		return response.ValidationError(c, validator.FormatErrors(err))
+	}
+
+	if err := h.service.VerifyEmail(ClientContext(c), req.Token); err != nil {
+		if errors.Is(err, ErrInvalidVerificationToken) || errors.Is(err, ErrExpiredToken) {
+			return response.BadRequest(c, "Invalid or expired verification link")
+		}
+		return response.InternalError(c, "Failed to verify email")
+	}
+
+	return response.SuccessWithMessage(c, "Email verified successfully", nil)
+}
+
+// secureAccountPage asks users who followed a "secure my account" link to
+// confirm, so that link scanners and prefetchers opening it don't sign the
+// account out. It is also the page shown after submitting the form.
+var secureAccountPage = template.Must(template.New("secure-account").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Secure your account</title>
+</head>
+<body>
+<main>
+<h1>Secure your account</h1>
+{{- if .Message}}
+<p>{{.Message}}</p>
+{{- else}}
+<p>This signs your account out of every device and browser. Change your password afterwards if you don't recognize the activity.</p>
+<form method="post" action="{{.Action}}">
+<input type="hidden" name="token" value="{{.Token}}">
+<button type="submit">Sign out everywhere</button>
+</form>
+{{- end}}
+</main>
+</body>
+</html>
+`))
+
+// secureAccountView is the data secureAccountPage renders
+type secureAccountView struct {
+	Action  string
+	Token   string
+	Message string
+}
+
+// renderSecureAccount writes secureAccountPage with status. The page
+// carries a token, so it's neither cached nor sent as a referrer.
+func renderSecureAccount(c echo.Context, status int, view secureAccountView) error {
+	var buf bytes.Buffer
+	if err := secureAccountPage.Execute(&buf, view); err != nil {
+		return err
+	}
+	header := c.Response().Header()
+	header.Set("Cache-Control", "no-store")
+	header.Set("Referrer-Policy", "no-referrer")
+	return c.HTMLBlob(status, buf.Bytes())
+}
+
+// SecureAccountPage shows the confirmation page "secure my account" links
+// from security notifications open. Opening it changes nothing; its form
+// posts the token to SecureAccount.
+// @Summary Secure account confirmation page
+// @Description HTML page asking to confirm signing the account out everywhere; submitting it posts the token to the same path
+// @Tags Auth
+// @Produce html
+// @Param token query string true "Secure account token"
+// @Success 200 {string} string "Confirmation page"
+// @Failure 400 {string} string "Missing token"
+// @Router /api/v1/auth/secure-account [get]
+func (h *Handler) SecureAccountPage(c echo.Context) error {
+	token := c.QueryParam("token")
+	if token == "" {
+		return renderSecureAccount(c, http.StatusBadRequest, secureAccountView{Message: "This link is incomplete. Open the link from the email again."})
+	}
+	return renderSecureAccount(c, http.StatusOK, secureAccountView{Action: c.Request().URL.Path, Token: token})
+}
+
+// SecureAccount signs the account a security notification was sent for
+// out everywhere. Requests from the confirmation page's form get a page
+// back; others get JSON.
+// @Summary Secure account
+// @Description Sign the account a security notification was sent for out everywhere: every refresh token is revoked and every session ended. Form posts from the confirmation page are answered with HTML.
+// @Tags Auth
+// @Accept json
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param request body SecureAccountRequest true "Secure account token"
+// @Success 200 {object} response.Response
+// @Failure 400 {object} response.Response
+// @Failure 422 {object} response.Response
+// @Router /api/v1/auth/secure-account [post]
+func (h *Handler) SecureAccount(c echo.Context) error {
+	form := strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), echo.MIMEApplicationForm)
+
+	var req SecureAccountRequest
+	if err := c.Bind(&req); err != nil {
+		return response.BadRequest(c, "Invalid request body")
+	}
+
+	if err := c.Validate(&req); err != nil {
+		if form {
+			return renderSecureAccount(c, http.StatusBadRequest, secureAccountView{Message: "This link is incomplete. Open the link from the email again."})
+		}
+		return response.ValidationError(c, validator.FormatErrors(err))
+	}
+
+	if err := h.service.SecureAccount(ClientContext(c), req.Token); err != nil {
+		invalid := errors.Is(err, ErrInvalidSecureAccountToken) || errors.Is(err, ErrExpiredToken)
+		switch {
+		case invalid && form:
+			return renderSecureAccount(c, http.StatusBadRequest, secureAccountView{Message: "This link is invalid or has expired."})
+		case invalid:
+			return response.BadRequest(c, "Invalid or expired link")
+		case form:
+			return renderSecureAccount(c, http.StatusInternalServerError, secureAccountView{Message: "Something went wrong. Please try again."})
+		}
+		return response.InternalError(c, "Failed to secure account")
+	}
+
+	const done = "Signed out of all sessions. Change your password if you don't recognize the activity."
+	if form {
+		return renderSecureAccount(c, http.StatusOK, secureAccountView{Message: done})
+	}
+	return response.SuccessWithMessage(c, done, nil)
+}
+
 // RefreshTokenRequest represents a token refresh request
 type RefreshTokenRequest struct {
 	RefreshToken string `json:"refresh_token" validate:"required"`
@@ -96,16 +374,21 @@ type RefreshTokenRequest struct {
 
 // RefreshToken handles token refresh
 // @Summary Refresh token
-// @Description Get a new access token using refresh token
+// @Description Get a new access token using refresh token. With AUTH_MODE=cookie the session cookie is rotated instead and no body is needed.
 // @Tags Auth
 // @Accept json
 // @Produce json
-// @Param request body RefreshTokenRequest true "Refresh token"
+// @Param request body RefreshTokenRequest false "Refresh token (bearer mode)"
 // @Success 200 {object} AuthResponse
 // @Failure 400 {object} response.Response
 // @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response "Account suspended, or invalid CSRF token (cookie mode)"
 // @Router /api/v1/auth/refresh [post]
 func (h *Handler) RefreshToken(c echo.Context) error {
+	if h.service.CookieMode() {
+		return h.refreshSession(c)
+	}
+
 	var req RefreshTokenRequest
 	if err := c.Bind(&req); err != nil {
 		return response.BadRequest(c, "Invalid request body")
@@ -115,8 +398,17 @@ func (h *Handler) RefreshToken(c echo.Context) error {
 		return response.ValidationError(c, validator.FormatErrors(err))
 	}
 
-	result, err := h.service.RefreshToken(c.Request().Context(), req.RefreshToken)
+	result, err := h.service.RefreshToken(ClientContext(c), req.RefreshToken)
 	if err != nil {
+		if errors.Is(err, ErrStepUpRequired) {
+			return response.Error(c, http.StatusUnauthorized, "STEP_UP_REQUIRED", "Please log in again to continue")
+		}
+		if errors.Is(err, ErrAccountSuspended) {
+			return AccountSuspended(c)
+		}
+		if errors.Is(err, ErrRevokedByIncident) {
+			return ReauthRequired(c)
+		}
 		if errors.Is(err, ErrInvalidRefreshToken) || errors.Is(err, ErrExpiredToken) {
 			return response.Unauthorized(c, "Invalid or expired refresh token")
 		}
@@ -126,6 +418,22 @@ func (h *Handler) RefreshToken(c echo.Context) error {
 	return response.SuccessWithMessage(c, "Token refreshed successfully", result)
 }
 
+// refreshSession rotates the session of the request's cookie
+func (h *Handler) refreshSession(c echo.Context) error {
+	_, token, err := h.service.sessions.Authenticate(c.Request())
+	if err != nil {
+		return h.sessionError(c, err)
+	}
+
+	result, err := h.service.RefreshSession(ClientContext(c), token)
+	if err != nil {
+		return h.sessionError(c, err)
+	}
+
+	SetCookies(c, result.Cookies())
+	return response.SuccessWithMessage(c, "Session refreshed successfully", result)
+}
+
 // LogoutRequest represents a logout request
 type LogoutRequest struct {
 	RefreshToken string `json:"refresh_token" validate:"required"`
@@ -133,15 +441,20 @@ type LogoutRequest struct {
 
 // Logout handles user logout
 // @Summary Logout
-// @Description Invalidate refresh token
+// @Description Invalidate refresh token. With AUTH_MODE=cookie the session is ended and its cookies cleared instead.
 // @Tags Auth
 // @Accept json
 // @Produce json
-// @Param request body LogoutRequest true "Refresh token to invalidate"
+// @Param request body LogoutRequest false "Refresh token to invalidate (bearer mode)"
 // @Success 200 {object} response.Response
 // @Failure 400 {object} response.Response
+// @Failure 403 {object} response.Response "Invalid CSRF token (cookie mode)"
 // @Router /api/v1/auth/logout [post]
 func (h *Handler) Logout(c echo.Context) error {
+	if h.service.CookieMode() {
+		return h.endSession(c)
+	}
+
 	var req LogoutRequest
 	if err := c.Bind(&req); err != nil {
 		return response.BadRequest(c, "Invalid request body")
@@ -151,15 +464,428 @@ func (h *Handler) Logout(c echo.Context) error {
 		return response.ValidationError(c, validator.FormatErrors(err))
 	}
 
-	_ = h.service.Logout(c.Request().Context(), req.RefreshToken)
+	_ = h.service.Logout(ClientContext(c), req.RefreshToken)
+
+	return response.SuccessWithMessage(c, "Logged out successfully", nil)
+}
+
+// endSession ends the session of the request's cookie. Sessions that are
+// already gone only have their cookies cleared.
+func (h *Handler) endSession(c echo.Context) error {
+	session, token, err := h.service.sessions.Authenticate(c.Request())
+	if errors.Is(err, ErrInvalidCSRFToken) {
+		return h.sessionError(c, err)
+	}
+	if err == nil {
+		ctx := ClientContext(c)
+		if err := h.service.EndSession(ctx, token); err == nil {
+			h.service.auditor.Record(ctx, AuditLogout, session.UserID, nil)
+		}
+	}
 
+	SetCookies(c, h.service.sessions.ClearCookies())
 	return response.SuccessWithMessage(c, "Logged out successfully", nil)
 }
 
-// AuthMiddleware returns middleware that validates access tokens
+// sessionError responds to a failed session lookup
+func (h *Handler) sessionError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, ErrInvalidCSRFToken):
+		return response.Error(c, http.StatusForbidden, ErrCodeInvalidCSRFToken, "Missing or invalid CSRF token")
+	case errors.Is(err, ErrSessionNotFound), errors.Is(err, ErrUserNotFound):
+		SetCookies(c, h.service.sessions.ClearCookies())
+		return response.Unauthorized(c, "Session has expired, please log in again")
+	case errors.Is(err, ErrAccountSuspended):
+		SetCookies(c, h.service.sessions.ClearCookies())
+		return AccountSuspended(c)
+	case errors.Is(err, ErrRevokedByIncident):
+		SetCookies(c, h.service.sessions.ClearCookies())
+		return ReauthRequired(c)
+	}
+	return response.InternalError(c, "Failed to load session")
+}
+
+// Impersonate issues an admin a short-lived token for another user
+// @Summary Impersonate a user
+// @Description Issue a short-lived access token for acting as the user; the token carries an impersonated_by claim and no refresh token is issued. Users whose role grants permissions the admin's role doesn't can't be impersonated (admin only)
+// @Tags Admin
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param request body ImpersonateRequest true "Why the user is being impersonated"
+// @Success 201 {object} ImpersonationResponse
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response "User can't be impersonated"
+// @Failure 404 {object} response.Response "Unknown user, or impersonation not available"
+// @Failure 422 {object} response.Response
+// @Router /api/v1/admin/users/{id}/impersonate [post]
+func (h *Handler) Impersonate(c echo.Context) error {
+	admin := GetCurrentUser(c)
+	if admin == nil {
+		return response.Unauthorized(c, "User not authenticated")
+	}
+
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return response.BadRequest(c, "Invalid user ID")
+	}
+
+	var req ImpersonateRequest
+	if err := c.Bind(&req); err != nil {
+		return response.BadRequest(c, "Invalid request body")
+	}
+	if err := c.Validate(&req); err != nil {
+		return response.ValidationError(c, validator.FormatErrors(err))
+	}
+
+	result, err := h.service.Impersonate(ClientContext(c), admin.UserID, userID, req.Reason)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrUserNotFound):
+			return response.NotFound(c, "User not found")
+		case errors.Is(err, ErrImpersonationNotAllowed):
+			return response.Forbidden(c, "This user can't be impersonated")
+		case errors.Is(err, ErrImpersonationUnavailable):
+			return response.NotFound(c, "Impersonation is not available")
+		}
+		return response.InternalError(c, "Failed to impersonate user")
+	}
+
+	return response.CreatedWithMessage(c, "Impersonation started", result)
+}
+
+// ListImpersonations lists impersonation sessions
+// @Summary List impersonations
+// @Description List impersonation sessions, newest first, with who impersonated whom, why, and when they ended (admin only)
+// @Tags Admin
+// @Security BearerAuth
+// @Produce json
+// @Param page query int false "Page number" default(1)
+// @Param per_page query int false "Items per page, at most 100" default(20)
+// @Success 200 {array} Impersonation
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 404 {object} response.Response "Impersonation not available"
+// @Failure 422 {object} response.Response
+// @Router /api/v1/admin/impersonations [get]
+func (h *Handler) ListImpersonations(c echo.Context) error {
+	params, err := pagination.Bind(c, pagination.Options{})
+	if err != nil {
+		return response.ValidationError(c, pagination.Details(err))
+	}
+
+	impersonations, err := h.service.ListImpersonations(c.Request().Context(), params.Limit(), params.Offset())
+	if err != nil {
+		if errors.Is(err, ErrImpersonationUnavailable) {
+			return response.NotFound(c, "Impersonation is not available")
+		}
+		return response.InternalError(c, "Failed to list impersonations")
+	}
+	return response.Success(c, impersonations)
+}
+
+// DeclareIncident signs out everyone, or a role, after a credential leak
+// @Summary Declare a security incident
+// @Description Revoke every refresh token of everyone, or of the users with a role, and reject tokens and sessions issued before now with 401 REAUTH_REQUIRED, on other instances within AUTH_INCIDENT_RELOAD. With rotate_keys, signing switches to the next key scheduled in AUTH_SIGNING_KEYS and tokens signed by earlier keys are rejected; this signs out every role. The admin is signed out too unless a role they don't hold is given (platform admins only).
+// @Tags Admin
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body IncidentRequest true "Reason, and optionally the role to sign out and whether to rotate signing keys"
+// @Success 201 {object} Incident
+// @Failure 400 {object} response.Response "rotate_keys with a role, or an unknown role"
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 404 {object} response.Response "Incident mode not available"
+// @Failure 409 {object} response.Response "No upcoming signing key to rotate to"
+// @Failure 422 {object} response.Response
+// @Router /api/v1/admin/incidents [post]
+func (h *Handler) DeclareIncident(c echo.Context) error {
+	admin := GetCurrentUser(c)
+	if admin == nil {
+		return response.Unauthorized(c, "User not authenticated")
+	}
+
+	var req IncidentRequest
+	if err := c.Bind(&req); err != nil {
+		return response.BadRequest(c, "Invalid request body")
+	}
+	if err := c.Validate(&req); err != nil {
+		return response.ValidationError(c, validator.FormatErrors(err))
+	}
+
+	incident, err := h.service.DeclareIncident(ClientContext(c), admin.UserID, &req)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrRotateKeysForRole):
+			return response.BadRequest(c, "Rotating signing keys signs out every role; leave role empty")
+		case errors.Is(err, ErrUnknownRole):
+			return response.BadRequest(c, "Unknown role")
+		case errors.Is(err, ErrKeyRotationUnavailable):
+			return response.Conflict(c, "No upcoming signing key is scheduled in AUTH_SIGNING_KEYS")
+		case errors.Is(err, ErrIncidentsUnavailable):
+			return response.NotFound(c, "Incident mode is not available")
+		}
+		return response.InternalError(c, "Failed to declare incident")
+	}
+
+	return response.CreatedWithMessage(c, "Incident declared", incident)
+}
+
+// ListIncidents lists declared security incidents
+// @Summary List incidents
+// @Description List declared security incidents, newest first, with who declared them, why, and how many refresh tokens were revoked (platform admins only)
+// @Tags Admin
+// @Security BearerAuth
+// @Produce json
+// @Param page query int false "Page number" default(1)
+// @Param per_page query int false "Items per page, at most 100" default(20)
+// @Success 200 {array} Incident
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 404 {object} response.Response "Incident mode not available"
+// @Failure 422 {object} response.Response
+// @Router /api/v1/admin/incidents [get]
+func (h *Handler) ListIncidents(c echo.Context) error {
+	params, err := pagination.Bind(c, pagination.Options{})
+	if err != nil {
+		return response.ValidationError(c, pagination.Details(err))
+	}
+
+	incidents, err := h.service.ListIncidents(c.Request().Context(), params.Limit(), params.Offset())
+	if err != nil {
+		if errors.Is(err, ErrIncidentsUnavailable) {
+			return response.NotFound(c, "Incident mode is not available")
+		}
+		return response.InternalError(c, "Failed to list incidents")
+	}
+	return response.Success(c, incidents)
+}
+
+// ListAuditEvents lists recorded security events
+// @Summary List audit events
+// @Description List logins, logouts, password changes, token revocations and passkey changes, newest first (admin only)
+// @Tags Admin
+// @Security BearerAuth
+// @Produce json
+// @Param user_id query string false "Only events for this user"
+// @Param type query string false "Only events of this type, e.g. auth.login_failed"
+// @Param since query string false "Only events at or after this RFC 3339 time"
+// @Param page query int false "Page number" default(1)
+// @Param per_page query int false "Items per page, at most 100" default(20)
+// @Success 200 {array} AuditEvent
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 404 {object} response.Response "Audit trail not available"
+// @Failure 422 {object} response.Response
+// @Router /api/v1/admin/audit [get]
+func (h *Handler) ListAuditEvents(c echo.Context) error {
+	params, err := pagination.Bind(c, pagination.Options{})
+	if err != nil {
+		return response.ValidationError(c, pagination.Details(err))
+	}
+
+	filter := AuditFilter{
+		Type:   c.QueryParam("type"),
+		Limit:  params.Limit(),
+		Offset: params.Offset(),
+	}
+	if raw := c.QueryParam("user_id"); raw != "" {
+		userID, err := uuid.Parse(raw)
+		if err != nil {
+			return response.BadRequest(c, "Invalid user ID")
+		}
+		filter.UserID = &userID
+	}
+	if raw := c.QueryParam("since"); raw != "" {
+		since, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return response.BadRequest(c, "Invalid since time, expected RFC 3339")
+		}
+		filter.Since = since
+	}
+
+	events, err := h.service.ListAuditEvents(c.Request().Context(), filter)
+	if err != nil {
+		if errors.Is(err, ErrAuditUnavailable) {
+			return response.NotFound(c, "Audit trail is not available")
+		}
+		return response.InternalError(c, "Failed to list audit events")
+	}
+	return response.Success(c, events)
+}
+
+// EndImpersonation ends an impersonation early
+// @Summary End an impersonation
+// @Description End an impersonation session before it expires, revoking its tokens (admin only)
+// @Tags Admin
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Impersonation ID"
+// @Success 200 {object} response.Response
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 404 {object} response.Response "Unknown or already ended"
+// @Router /api/v1/admin/impersonations/{id} [delete]
+func (h *Handler) EndImpersonation(c echo.Context) error {
+	admin := GetCurrentUser(c)
+	if admin == nil {
+		return response.Unauthorized(c, "User not authenticated")
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return response.BadRequest(c, "Invalid impersonation ID")
+	}
+
+	return h.endImpersonation(c, id, admin.UserID)
+}
+
+// StopImpersonating ends the impersonation the request's token belongs to
+// @Summary Stop impersonating
+// @Description End the impersonation session of the current impersonation token, revoking it
+// @Tags Auth
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} response.Response
+// @Failure 400 {object} response.Response "Not an impersonation token"
+// @Failure 401 {object} response.Response
+// @Router /api/v1/auth/impersonation [delete]
+func (h *Handler) StopImpersonating(c echo.Context) error {
+	payload := GetCurrentUser(c)
+	if payload == nil {
+		return response.Unauthorized(c, "User not authenticated")
+	}
+	if payload.ImpersonatedBy == nil || payload.Family == nil {
+		return response.BadRequest(c, "Not impersonating a user")
+	}
+
+	return h.endImpersonation(c, *payload.Family, *payload.ImpersonatedBy)
+}
+
+func (h *Handler) endImpersonation(c echo.Context, id, endedBy uuid.UUID) error {
+	if err := h.service.EndImpersonation(c.Request().Context(), id, endedBy); err != nil {
+		switch {
+		case errors.Is(err, ErrImpersonationNotFound):
+			return response.NotFound(c, "Impersonation not found or already ended")
+		case errors.Is(err, ErrImpersonationUnavailable):
+			return response.NotFound(c, "Impersonation is not available")
+		}
+		return response.InternalError(c, "Failed to end impersonation")
+	}
+	return response.SuccessWithMessage(c, "Impersonation ended", nil)
+}
+
+// ListSessions lists the current user's active sessions
+// @Summary List my sessions
+// @Description List the devices signed in to the account, most recently used first. Each session is a login whose refresh tokens are rotated together; current marks the one the request came from.
+// @Tags Users
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {array} DeviceSession
+// @Failure 401 {object} response.Response
+// @Failure 404 {object} response.Response "Session management not available"
+// @Router /api/v1/users/me/sessions [get]
+func (h *Handler) ListSessions(c echo.Context) error {
+	payload := GetCurrentUser(c)
+	if payload == nil {
+		return response.Unauthorized(c, "User not authenticated")
+	}
+
+	sessions, err := h.service.ListDeviceSessions(c.Request().Context(), payload.UserID)
+	if err != nil {
+		if errors.Is(err, ErrDeviceSessionsUnavailable) {
+			return response.NotFound(c, "Session management is not available")
+		}
+		return response.InternalError(c, "Failed to list sessions")
+	}
+	return response.Success(c, sessions)
+}
+
+// RevokeSession signs a device out
+// @Summary Revoke a session
+// @Description Revoke one of the current user's sessions so it can't refresh its tokens. Access tokens already issued to it stay valid until they expire.
+// @Tags Users
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Session ID"
+// @Success 200 {object} response.Response
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 404 {object} response.Response "Unknown session, or session management not available"
+// @Router /api/v1/users/me/sessions/{id} [delete]
+func (h *Handler) RevokeSession(c echo.Context) error {
+	payload := GetCurrentUser(c)
+	if payload == nil {
+		return response.Unauthorized(c, "User not authenticated")
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return response.BadRequest(c, "Invalid session ID")
+	}
+
+	if err := h.service.RevokeDeviceSession(ClientContext(c), payload.UserID, id); err != nil {
+		switch {
+		case errors.Is(err, ErrDeviceSessionNotFound):
+			return response.NotFound(c, "Session not found")
+		case errors.Is(err, ErrDeviceSessionsUnavailable):
+			return response.NotFound(c, "Session management is not available")
+		}
+		return response.InternalError(c, "Failed to revoke session")
+	}
+	return response.SuccessWithMessage(c, "Session revoked", nil)
+}
+
+// JWKS serves the public keys tokens can be verified with
+// @Summary JSON Web Key Set
+// @Description Public keys for verifying access tokens; only available with RS256, ES256 or EdDSA signing
+// @Tags Auth
+// @Produce json
+// @Success 200 {object} JWKSet
+// @Failure 404 {object} response.Response
+// @Router /.well-known/jwks.json [get]
+func (h *Handler) JWKS(c echo.Context) error {
+	provider, ok := h.service.tokenMaker.(JWKSProvider)
+	if !ok {
+		return response.NotFound(c, "Tokens are not signed with public keys")
+	}
+	set := provider.JWKS()
+	if len(set.Keys) == 0 {
+		return response.NotFound(c, "Tokens are not signed with public keys")
+	}
+
+	// JWKS clients expect the bare key set (RFC 7517), never the envelope
+	c.Response().Header().Set(echo.HeaderCacheControl, "public, max-age=300")
+	return c.JSON(http.StatusOK, set)
+}
+
+// AuthMiddleware returns middleware that validates access tokens, or in
+// cookie mode the session cookie and, for unsafe methods, the CSRF token.
+// Credentials must belong to the request's tenant.
 func (h *Handler) AuthMiddleware() echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
+			if h.service.CookieMode() {
+				session, _, err := h.service.sessions.Authenticate(c.Request())
+				if err != nil {
+					return h.sessionError(c, err)
+				}
+				payload := session.Payload()
+				if err := h.service.checkIncidents(payload); err != nil {
+					return h.sessionError(c, err)
+				}
+				if payload.Tenant() != tenant.ID(c.Request().Context()) {
+					return response.Unauthorized(c, "Session belongs to another tenant")
+				}
+				SetCurrentUser(c, payload)
+				return next(c)
+			}
+
 			authHeader := c.Request().Header.Get("Authorization")
 			if authHeader == "" {
 				return response.Unauthorized(c, "Missing authorization header")
@@ -171,30 +897,134 @@ func (h *Handler) AuthMiddleware() echo.MiddlewareFunc {
 			}
 
 			token := authHeader[len(bearerPrefix):]
-			payload, err := h.service.ValidateToken(token)
+			payload, err := h.service.ValidateToken(c.Request().Context(), token)
 			if err != nil {
 				if errors.Is(err, ErrExpiredToken) {
 					return response.Unauthorized(c, "Token has expired")
 				}
+				if errors.Is(err, ErrImpersonationEnded) {
+					return response.Unauthorized(c, "Impersonation has ended")
+				}
+				if errors.Is(err, ErrRevokedByIncident) {
+					return ReauthRequired(c)
+				}
 				return response.Unauthorized(c, "Invalid token")
 			}
+			if payload.Sandbox && !sandbox.Enabled(c.Request().Context()) {
+				return response.Unauthorized(c, "Sandbox tokens must be sent with the "+sandbox.Header+" header")
+			}
+			if payload.Tenant() != tenant.ID(c.Request().Context()) {
+				return response.Unauthorized(c, "Token was issued for another tenant")
+			}
+
+			SetCurrentUser(c, payload)
+			return next(c)
+		}
+	}
+}
+
+// SetCurrentUser stores the authenticated user in the echo and request
+// contexts via authctx
+func SetCurrentUser(c echo.Context, payload *TokenPayload) {
+	authctx.Set(c, payload.AuthUser())
+}
+
+// RequireRoles returns middleware that only admits users holding one of the
+// given roles. It must run after AuthMiddleware.
+func (h *Handler) RequireRoles(roles ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			payload := GetCurrentUser(c)
+			if payload == nil {
+				return response.Unauthorized(c, "User not authenticated")
+			}
+
+			for _, role := range roles {
+				if payload.Role == role {
+					return next(c)
+				}
+			}
 
-			// Store user info in context
-			c.Set("user_id", payload.UserID)
-			c.Set("user_email", payload.Email)
-			c.Set("user_role", payload.Role)
-			c.Set("token_payload", payload)
+			return response.Forbidden(c, "Insufficient permissions")
+		}
+	}
+}
 
+// RejectImpersonation returns middleware that refuses requests made with an
+// impersonation token, for routes such as changing credentials that an
+// admin must not do on a user's behalf. It must run after AuthMiddleware.
+func (h *Handler) RejectImpersonation() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if user, ok := authctx.Get(c); ok && user.ImpersonatedBy != nil {
+				return response.Error(c, http.StatusForbidden, ErrCodeImpersonationForbidden, "Not allowed while impersonating a user")
+			}
 			return next(c)
 		}
 	}
 }
 
-// GetCurrentUser returns the current authenticated user from context
+const clientCountryKey = "auth.client_country"
+
+// ClientCountry returns middleware that reads the client's country from a
+// header set by a CDN or proxy, such as Cloudflare's CF-IPCountry. Only use
+// it behind a proxy that overwrites the header on every request. Unknown
+// ("XX") and Tor ("T1") values are ignored.
+func ClientCountry(header string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			country := strings.ToUpper(strings.TrimSpace(c.Request().Header.Get(header)))
+			if len(country) == 2 && country != "XX" && country != "T1" {
+				c.Set(clientCountryKey, country)
+			}
+			return next(c)
+		}
+	}
+}
+
+// ClientContext returns the request context annotated with client info
+func ClientContext(c echo.Context) context.Context {
+	country, _ := c.Get(clientCountryKey).(string)
+	return ContextWithClient(c.Request().Context(), ClientInfo{
+		IPAddress: c.RealIP(),
+		UserAgent: c.Request().UserAgent(),
+		DeviceID:  c.Request().Header.Get(DeviceIDHeader),
+		Country:   country,
+	})
+}
+
+// SetCookies adds cookies to the response, such as the session cookies of
+// an AuthResponse
+func SetCookies(c echo.Context, cookies []*http.Cookie) {
+	for _, cookie := range cookies {
+		c.SetCookie(cookie)
+	}
+}
+
+// WeakPassword responds 422 WEAK_PASSWORD with the broken password rules
+// keyed by violation code
+func WeakPassword(c echo.Context, err *PasswordPolicyError) error {
+	return response.ErrorWithDetails(c, http.StatusUnprocessableEntity, "WEAK_PASSWORD", "Password does not meet the password policy", err.Details())
+}
+
+// GetCurrentUser returns the token payload of the authenticated user, or nil
 func GetCurrentUser(c echo.Context) *TokenPayload {
-	payload, ok := c.Get("token_payload").(*TokenPayload)
+	payload, _ := authctx.Payload(c).(*TokenPayload)
+	return payload
+}
+
+// ContextWithUser returns a context carrying the authenticated user
+func ContextWithUser(ctx context.Context, payload *TokenPayload) context.Context {
+	return authctx.WithUser(ctx, payload.AuthUser())
+}
+
+// UserFromContext returns the token payload of the user authenticated in
+// ctx, if any
+func UserFromContext(ctx context.Context) (*TokenPayload, bool) {
+	user, ok := authctx.FromContext(ctx)
 	if !ok {
-		return nil
+		return nil, false
 	}
-	return payload
+	payload, ok := user.Payload.(*TokenPayload)
+	return payload, ok && payload != nil
 }
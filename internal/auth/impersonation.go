@@ -0,0 +1,210 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrImpersonationNotFound = errors.New("impersonation not found")
+	// ErrImpersonationNotAllowed is returned for targets that can't be
+	// impersonated: the admin themselves, protected roles, users whose
+	// role grants more than the admin's, or anyone while already
+	// impersonating
+	ErrImpersonationNotAllowed = errors.New("user can't be impersonated")
+	// ErrImpersonationUnavailable is returned when no impersonation store is
+	// configured or the service issues cookie sessions
+	ErrImpersonationUnavailable = errors.New("impersonation is not available")
+	// ErrImpersonationEnded is returned for impersonation tokens whose
+	// session was ended early
+	ErrImpersonationEnded = errors.New("impersonation has ended")
+)
+
+// Impersonation is an admin acting as another user. It is the audit record
+// of the session and the family its tokens belong to: ending it revokes
+// every token issued for it.
+type Impersonation struct {
+	ID        uuid.UUID  `json:"id"`
+	AdminID   uuid.UUID  `json:"admin_id"`
+	UserID    uuid.UUID  `json:"user_id"`
+	Reason    string     `json:"reason"`
+	IPAddress string     `json:"ip_address,omitempty"`
+	UserAgent string     `json:"user_agent,omitempty"`
+	StartedAt time.Time  `json:"started_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+	EndedBy   *uuid.UUID `json:"ended_by,omitempty"`
+}
+
+// Active reports whether the impersonation's tokens are accepted at now
+func (i *Impersonation) Active(now time.Time) bool {
+	return i.EndedAt == nil && now.Before(i.ExpiresAt)
+}
+
+// ImpersonationStore stores impersonation sessions
+type ImpersonationStore interface {
+	CreateImpersonation(ctx context.Context, imp *Impersonation) error
+	// GetImpersonation returns ErrImpersonationNotFound for unknown IDs
+	GetImpersonation(ctx context.Context, id uuid.UUID) (*Impersonation, error)
+	// EndImpersonation ends an impersonation, returning
+	// ErrImpersonationNotFound if it is unknown or already ended
+	EndImpersonation(ctx context.Context, id, endedBy uuid.UUID, endedAt time.Time) error
+	// ListImpersonations returns impersonations, newest first
+	ListImpersonations(ctx context.Context, limit, offset int) ([]*Impersonation, error)
+}
+
+// ImpersonateRequest represents a request to impersonate a user
+type ImpersonateRequest struct {
+	Reason string `json:"reason" validate:"required,max=500"`
+}
+
+// ImpersonationResponse is the access token an admin acts as a user with.
+// No refresh token is issued; start a new impersonation once it expires.
+type ImpersonationResponse struct {
+	Impersonation *Impersonation `json:"impersonation"`
+	User          *UserResponse  `json:"user"`
+	AccessToken   string         `json:"access_token"`
+	ExpiresAt     time.Time      `json:"expires_at"`
+}
+
+// Impersonate issues the admin a short-lived access token for the user.
+// The token carries the admin in its impersonated_by claim and is only
+// accepted while the impersonation recorded for it is active.
+func (s *Service) Impersonate(ctx context.Context, adminID, userID uuid.UUID, reason string) (*ImpersonationResponse, error) {
+	if s.impersonations == nil || s.sessions != nil {
+		return nil, ErrImpersonationUnavailable
+	}
+	if adminID == userID {
+		return nil, ErrImpersonationNotAllowed
+	}
+	// Impersonation tokens can't be used to hop on to another user
+	if caller, ok := UserFromContext(ctx); ok && caller.ImpersonatedBy != nil {
+		return nil, ErrImpersonationNotAllowed
+	}
+
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	if slices.Contains(s.impersonationProtectedRoles, user.Role) {
+		return nil, ErrImpersonationNotAllowed
+	}
+	// Impersonation must not grant the admin permissions they don't hold
+	admin, err := s.userRepo.GetByID(ctx, adminID)
+	if err != nil {
+		return nil, err
+	}
+	if !s.canImpersonate(admin.Role, user.Role) {
+		return nil, ErrImpersonationNotAllowed
+	}
+
+	impersonationID := s.ids.NewID()
+	token, payload, err := s.tokenMaker.CreateToken(
+		user.ID,
+		user.Email,
+		user.Role,
+		AccessToken,
+		s.impersonationExpiry,
+		WithImpersonatedBy(adminID),
+		WithFamily(impersonationID),
+		WithTenantID(user.TenantID),
+		WithSandbox(ctx),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	client, _ := ClientFromContext(ctx)
+	imp := &Impersonation{
+		ID:        impersonationID,
+		AdminID:   adminID,
+		UserID:    user.ID,
+		Reason:    reason,
+		IPAddress: client.IPAddress,
+		UserAgent: client.UserAgent,
+		StartedAt: payload.IssuedAt,
+		ExpiresAt: payload.ExpiresAt,
+	}
+	// Until the record is stored the token is rejected, so a failure here
+	// leaves nothing usable behind
+	if err := s.impersonations.CreateImpersonation(ctx, imp); err != nil {
+		return nil, err
+	}
+
+	s.logger.WarnContext(ctx, "impersonation started",
+		slog.String("impersonation_id", imp.ID.String()),
+		slog.String("admin_id", adminID.String()),
+		slog.String("user_id", user.ID.String()),
+		slog.String("reason", reason),
+		slog.Time("expires_at", imp.ExpiresAt),
+	)
+
+	return &ImpersonationResponse{
+		Impersonation: imp,
+		User:          newUserResponse(user),
+		AccessToken:   token,
+		ExpiresAt:     payload.ExpiresAt,
+	}, nil
+}
+
+// canImpersonate reports whether adminRole grants every permission of
+// userRole. Without Roles only the admin's own role qualifies.
+func (s *Service) canImpersonate(adminRole, userRole string) bool {
+	if s.roles == nil {
+		return adminRole == userRole
+	}
+	return s.roles.Covers(adminRole, userRole)
+}
+
+// EndImpersonation ends an impersonation before it expires, revoking its
+// tokens. endedBy is recorded in the audit trail.
+func (s *Service) EndImpersonation(ctx context.Context, id, endedBy uuid.UUID) error {
+	if s.impersonations == nil {
+		return ErrImpersonationUnavailable
+	}
+	if err := s.impersonations.EndImpersonation(ctx, id, endedBy, s.clock.Now()); err != nil {
+		return err
+	}
+
+	s.logger.WarnContext(ctx, "impersonation ended",
+		slog.String("impersonation_id", id.String()),
+		slog.String("ended_by", endedBy.String()),
+	)
+	return nil
+}
+
+// ListImpersonations returns impersonations, newest first
+func (s *Service) ListImpersonations(ctx context.Context, limit, offset int) ([]*Impersonation, error) {
+	if s.impersonations == nil {
+		return nil, ErrImpersonationUnavailable
+	}
+	return s.impersonations.ListImpersonations(ctx, limit, offset)
+}
+
+// checkImpersonation rejects impersonation tokens whose session has ended,
+// expired, or can't be looked up
+func (s *Service) checkImpersonation(ctx context.Context, payload *TokenPayload) error {
+	if s.impersonations == nil || payload.Family == nil {
+		return ErrInvalidToken
+	}
+
+	imp, err := s.impersonations.GetImpersonation(ctx, *payload.Family)
+	if err != nil {
+		if !errors.Is(err, ErrImpersonationNotFound) {
+			s.logger.ErrorContext(ctx, "failed to check impersonation", slog.String("error", err.Error()))
+		}
+		return ErrInvalidToken
+	}
+	if imp.UserID != payload.UserID || imp.AdminID != *payload.ImpersonatedBy {
+		return ErrInvalidToken
+	}
+	if !imp.Active(s.clock.Now()) {
+		return ErrImpersonationEnded
+	}
+	return nil
+}
@@ -0,0 +1,265 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"maps"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/pkg/response"
+)
+
+var (
+	// ErrRevokedByIncident is returned for tokens and sessions issued before
+	// a security incident covering their role
+	ErrRevokedByIncident = errors.New("credentials revoked by a security incident")
+	// ErrIncidentsUnavailable is returned when no incident store is
+	// configured
+	ErrIncidentsUnavailable = errors.New("incident mode is not available")
+	// ErrKeyRotationUnavailable is returned when an incident asks to rotate
+	// signing keys but tokens aren't signed with a key ring holding an
+	// upcoming key
+	ErrKeyRotationUnavailable = errors.New("signing keys can't be rotated")
+	// ErrRotateKeysForRole is returned when an incident limited to a role
+	// asks to rotate signing keys, which signs out every role
+	ErrRotateKeysForRole = errors.New("rotating signing keys can't be limited to a role")
+	// ErrUnknownRole is returned when an incident is limited to a role
+	// that doesn't exist
+	ErrUnknownRole = errors.New("unknown role")
+)
+
+// ErrCodeReauthRequired is the error code of requests whose credentials an
+// incident revoked
+const ErrCodeReauthRequired = "REAUTH_REQUIRED"
+
+// ReauthRequired responds 401 REAUTH_REQUIRED
+func ReauthRequired(c echo.Context) error {
+	return response.Error(c, http.StatusUnauthorized, ErrCodeReauthRequired, "Please log in again")
+}
+
+// Incident is a security incident declared by an admin, such as a
+// credential leak. Every token and session issued before NotBefore to a
+// user with Role, or to anyone when Role is empty, is rejected, and their
+// refresh tokens are revoked. With RotatedKeys, signing also switched to
+// the next scheduled key at NotBefore.
+type Incident struct {
+	ID            uuid.UUID `json:"id"`
+	Role          string    `json:"role,omitempty"`
+	Reason        string    `json:"reason"`
+	RotatedKeys   bool      `json:"rotated_keys"`
+	RevokedTokens int64     `json:"revoked_tokens"`
+	DeclaredBy    uuid.UUID `json:"declared_by"`
+	NotBefore     time.Time `json:"not_before"`
+}
+
+// IncidentRequest declares an incident
+type IncidentRequest struct {
+	Reason string `json:"reason" validate:"required,max=500"`
+	// Role limits the incident to users with the role; empty covers
+	// everyone
+	Role string `json:"role" validate:"omitempty,max=50"`
+	// RotateKeys switches signing to the next key scheduled in
+	// AUTH_SIGNING_KEYS
+	RotateKeys bool `json:"rotate_keys"`
+}
+
+// IncidentStore stores incidents. Incidents cover every tenant.
+type IncidentStore interface {
+	// CreateIncident revokes the refresh tokens the incident covers and
+	// records it in one transaction, setting inc.RevokedTokens
+	CreateIncident(ctx context.Context, inc *Incident) error
+	// ListIncidents returns incidents, newest first
+	ListIncidents(ctx context.Context, limit, offset int) ([]*Incident, error)
+	// TokenCutoffs returns the latest NotBefore by role, "" for incidents
+	// covering everyone
+	TokenCutoffs(ctx context.Context) (map[string]time.Time, error)
+	// KeyRotations returns when signing keys were rotated, oldest first
+	KeyRotations(ctx context.Context) ([]time.Time, error)
+}
+
+// WithIncidentStore sets the store for incidents
+func WithIncidentStore(store IncidentStore) ServiceOption {
+	return func(cfg *ServiceConfig) {
+		cfg.Incidents = store
+	}
+}
+
+// tokenCutoffs are the NotBefore times in force, by role
+type tokenCutoffs map[string]time.Time
+
+// revokes reports whether credentials issued to role at issuedAt predate an
+// incident. JWT timestamps are truncated to the second, so tokens issued
+// within the incident's second are revoked too.
+func (c tokenCutoffs) revokes(role string, issuedAt time.Time) bool {
+	for _, cutoff := range []time.Time{c[""], c[role]} {
+		if !cutoff.IsZero() && issuedAt.Before(cutoff) {
+			return true
+		}
+	}
+	return false
+}
+
+// DeclareIncident signs out everyone, or the users with req.Role: their
+// refresh tokens are revoked, and every token and session issued before
+// now is rejected with ErrRevokedByIncident on every instance once it
+// reloads the cutoffs. With req.RotateKeys, signing switches to the next
+// key scheduled in the key ring and tokens signed by earlier keys are no
+// longer accepted.
+func (s *Service) DeclareIncident(ctx context.Context, adminID uuid.UUID, req *IncidentRequest) (*Incident, error) {
+	if s.incidents == nil {
+		return nil, ErrIncidentsUnavailable
+	}
+	if req.Role != "" && s.roles != nil && !s.roles.HasRole(req.Role) {
+		return nil, ErrUnknownRole
+	}
+	now := s.clock.Now()
+
+	var ring *KeyRing
+	if req.RotateKeys {
+		if req.Role != "" {
+			return nil, ErrRotateKeysForRole
+		}
+		provider, ok := s.tokenMaker.(KeyRingProvider)
+		if !ok {
+			return nil, ErrKeyRotationUnavailable
+		}
+		ring = provider.KeyRing()
+		if _, err := ring.NextKey(now); err != nil {
+			return nil, ErrKeyRotationUnavailable
+		}
+	}
+
+	inc := &Incident{
+		ID:          s.ids.NewID(),
+		Role:        req.Role,
+		Reason:      req.Reason,
+		RotatedKeys: req.RotateKeys,
+		DeclaredBy:  adminID,
+		NotBefore:   now,
+	}
+	if err := s.incidents.CreateIncident(ctx, inc); err != nil {
+		return nil, err
+	}
+
+	// This instance enforces the incident at once; others on their next
+	// reload
+	if ring != nil {
+		if err := ring.RotateKeys(inc.NotBefore); err != nil {
+			return nil, err
+		}
+	}
+	s.raiseCutoffs(tokenCutoffs{inc.Role: inc.NotBefore})
+
+	s.logger.WarnContext(ctx, "security incident declared",
+		slog.String("incident_id", inc.ID.String()),
+		slog.String("admin_id", adminID.String()),
+		slog.String("role", inc.Role),
+		slog.String("reason", inc.Reason),
+		slog.Bool("rotated_keys", inc.RotatedKeys),
+		slog.Int64("revoked_tokens", inc.RevokedTokens),
+	)
+	s.auditor.Record(ctx, AuditIncidentDeclared, adminID, map[string]any{
+		"incident_id":    inc.ID.String(),
+		"role":           inc.Role,
+		"reason":         inc.Reason,
+		"rotated_keys":   inc.RotatedKeys,
+		"revoked_tokens": inc.RevokedTokens,
+	})
+	return inc, nil
+}
+
+// ListIncidents returns declared incidents, newest first
+func (s *Service) ListIncidents(ctx context.Context, limit, offset int) ([]*Incident, error) {
+	if s.incidents == nil {
+		return nil, ErrIncidentsUnavailable
+	}
+	return s.incidents.ListIncidents(ctx, limit, offset)
+}
+
+// ReloadIncidents loads the cutoffs of every declared incident and applies
+// their key rotations, picking up incidents declared on other instances.
+// Call it at startup, before serving, and then periodically; see
+// WatchIncidents.
+func (s *Service) ReloadIncidents(ctx context.Context) error {
+	if s.incidents == nil {
+		return nil
+	}
+
+	rotations, err := s.incidents.KeyRotations(ctx)
+	if err != nil {
+		return err
+	}
+	if provider, ok := s.tokenMaker.(KeyRingProvider); ok {
+		for _, at := range rotations {
+			if err := provider.KeyRing().RotateKeys(at); err != nil {
+				s.logger.ErrorContext(ctx, "failed to apply signing key rotation",
+					slog.Time("rotated_at", at),
+					slog.String("error", err.Error()),
+				)
+			}
+		}
+	}
+
+	cutoffs, err := s.incidents.TokenCutoffs(ctx)
+	if err != nil {
+		return err
+	}
+	s.raiseCutoffs(cutoffs)
+	return nil
+}
+
+// WatchIncidents reloads incidents every interval until ctx is cancelled.
+// Failures keep the cutoffs last loaded.
+func (s *Service) WatchIncidents(ctx context.Context, interval time.Duration) {
+	if s.incidents == nil || interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.ReloadIncidents(ctx); err != nil {
+				s.logger.ErrorContext(ctx, "failed to reload incidents", slog.String("error", err.Error()))
+			}
+		}
+	}
+}
+
+// raiseCutoffs merges cutoffs into those enforced, keeping the later time
+// for each role. Cutoffs only ever move forward, so a reload that started
+// before an incident was declared here can't undo it.
+func (s *Service) raiseCutoffs(cutoffs tokenCutoffs) {
+	for {
+		current := s.cutoffs.Load()
+		next := tokenCutoffs{}
+		if current != nil {
+			maps.Copy(next, *current)
+		}
+		for role, cutoff := range cutoffs {
+			if cutoff.After(next[role]) {
+				next[role] = cutoff
+			}
+		}
+		if s.cutoffs.CompareAndSwap(current, &next) {
+			return
+		}
+	}
+}
+
+// checkIncidents rejects credentials issued before an incident covering
+// their role
+func (s *Service) checkIncidents(payload *TokenPayload) error {
+	cutoffs := s.cutoffs.Load()
+	if cutoffs != nil && cutoffs.revokes(payload.Role, payload.IssuedAt) {
+		return ErrRevokedByIncident
+	}
+	return nil
+}
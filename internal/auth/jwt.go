@@ -14,45 +14,42 @@ const minSecretKeySize = 32
 // JWTMaker implements TokenMaker interface using JWT
 type JWTMaker struct {
 	secretKey string
+	options   TokenOptions
 }
 
 // JWTClaims represents JWT custom claims
 type JWTClaims struct {
 	jwt.RegisteredClaims
-	UserID    uuid.UUID `json:"user_id"`
-	Email     string    `json:"email"`
-	Role      string    `json:"role"`
-	TokenType TokenType `json:"token_type"`
+	UserID      uuid.UUID    `json:"user_id"`
+	Email       string       `json:"email"`
+	Role        string       `json:"role"`
+	TokenType   TokenType    `json:"token_type"`
+	Fingerprint *Fingerprint `json:"fingerprint,omitempty"`
+
+	ImpersonatedBy *uuid.UUID `json:"impersonated_by,omitempty"`
+	Family         *uuid.UUID `json:"family,omitempty"`
+	TenantID       *uuid.UUID `json:"tenant_id,omitempty"`
+	OrgID          *uuid.UUID `json:"org_id,omitempty"`
+	Sandbox        bool       `json:"sandbox,omitempty"`
 }
 
 // NewJWTMaker creates a new JWTMaker
-func NewJWTMaker(secretKey string) (*JWTMaker, error) {
+func NewJWTMaker(secretKey string, opts ...TokenOption) (*JWTMaker, error) {
 	if len(secretKey) < minSecretKeySize {
 		return nil, fmt.Errorf("secret key must be at least %d characters", minSecretKeySize)
 	}
-	return &JWTMaker{secretKey: secretKey}, nil
+	return &JWTMaker{secretKey: secretKey, options: newTokenOptions(opts)}, nil
 }
 
 // CreateToken creates a new JWT token
-func (m *JWTMaker) CreateToken(userID uuid.UUID, email, role string, tokenType TokenType, duration time.Duration) (string, *TokenPayload, error) {
-	payload, err := NewTokenPayload(userID, email, role, tokenType, duration)
-	if err != nil {
-		return "", nil, err
+func (m *JWTMaker) CreateToken(userID uuid.UUID, email, role string, tokenType TokenType, duration time.Duration, opts ...PayloadOption) (string, *TokenPayload, error) {
+	payload := newTokenPayloadAt(m.options.IDs.NewID(), m.options.Clock.Now(), userID, email, role, tokenType, duration)
+	m.options.apply(payload)
+	for _, opt := range opts {
+		opt(payload)
 	}
 
-	claims := JWTClaims{
-		RegisteredClaims: jwt.RegisteredClaims{
-			ID:        payload.ID.String(),
-			Subject:   payload.UserID.String(),
-			IssuedAt:  jwt.NewNumericDate(payload.IssuedAt),
-			ExpiresAt: jwt.NewNumericDate(payload.ExpiresAt),
-			Issuer:    "goiler",
-		},
-		UserID:    payload.UserID,
-		Email:     payload.Email,
-		Role:      payload.Role,
-		TokenType: tokenType,
-	}
+	claims := newJWTClaims(payload)
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	tokenString, err := token.SignedString([]byte(m.secretKey))
@@ -72,12 +69,9 @@ func (m *JWTMaker) VerifyToken(tokenString string) (*TokenPayload, error) {
 		return []byte(m.secretKey), nil
 	}
 
-	token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, keyFunc)
+	token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, keyFunc, m.options.jwtParserOptions()...)
 	if err != nil {
-		if errors.Is(err, jwt.ErrTokenExpired) {
-			return nil, ErrExpiredToken
-		}
-		return nil, ErrInvalidToken
+		return nil, jwtError(err)
 	}
 
 	claims, ok := token.Claims.(*JWTClaims)
@@ -85,18 +79,89 @@ func (m *JWTMaker) VerifyToken(tokenString string) (*TokenPayload, error) {
 		return nil, ErrInvalidToken
 	}
 
-	tokenID, err := uuid.Parse(claims.ID)
+	return claims.payload()
+}
+
+// newJWTClaims converts a payload into JWT claims
+func newJWTClaims(payload *TokenPayload) JWTClaims {
+	return JWTClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        payload.ID.String(),
+			Subject:   payload.UserID.String(),
+			IssuedAt:  jwt.NewNumericDate(payload.IssuedAt),
+			NotBefore: jwt.NewNumericDate(payload.NotBefore),
+			ExpiresAt: jwt.NewNumericDate(payload.ExpiresAt),
+			Issuer:    payload.Issuer,
+			Audience:  payload.Audience,
+		},
+		UserID:      payload.UserID,
+		Email:       payload.Email,
+		Role:        payload.Role,
+		TokenType:   payload.TokenType,
+		Fingerprint: payload.Fingerprint,
+
+		ImpersonatedBy: payload.ImpersonatedBy,
+		Family:         payload.Family,
+		TenantID:       payload.TenantID,
+		OrgID:          payload.OrgID,
+		Sandbox:        payload.Sandbox,
+	}
+}
+
+// payload converts verified claims back into a payload
+func (c *JWTClaims) payload() (*TokenPayload, error) {
+	tokenID, err := uuid.Parse(c.ID)
 	if err != nil {
 		return nil, ErrInvalidToken
 	}
 
-	return &TokenPayload{
+	payload := &TokenPayload{
 		ID:        tokenID,
-		UserID:    claims.UserID,
-		Email:     claims.Email,
-		Role:      claims.Role,
-		TokenType: claims.TokenType,
-		IssuedAt:  claims.IssuedAt.Time,
-		ExpiresAt: claims.ExpiresAt.Time,
-	}, nil
+		UserID:    c.UserID,
+		Email:     c.Email,
+		Role:      c.Role,
+		TokenType: c.TokenType,
+		Issuer:    c.Issuer,
+		Audience:  c.Audience,
+		IssuedAt:  c.IssuedAt.Time,
+		ExpiresAt: c.ExpiresAt.Time,
+
+		Fingerprint:    c.Fingerprint,
+		ImpersonatedBy: c.ImpersonatedBy,
+		Family:         c.Family,
+		TenantID:       c.TenantID,
+		OrgID:          c.OrgID,
+		Sandbox:        c.Sandbox,
+	}
+	if c.NotBefore != nil {
+		payload.NotBefore = c.NotBefore.Time
+	}
+
+	return payload, nil
+}
+
+// jwtParserOptions returns the parser options enforcing the token options
+func (o TokenOptions) jwtParserOptions() []jwt.ParserOption {
+	parserOpts := []jwt.ParserOption{
+		jwt.WithLeeway(o.Leeway),
+		jwt.WithTimeFunc(o.Clock.Now),
+	}
+	if o.Issuer != "" {
+		parserOpts = append(parserOpts, jwt.WithIssuer(o.Issuer))
+	}
+	if o.Audience != "" {
+		parserOpts = append(parserOpts, jwt.WithAudience(o.Audience))
+	}
+	return parserOpts
+}
+
+// jwtError maps a JWT parsing error to a token error
+func jwtError(err error) error {
+	if errors.Is(err, jwt.ErrTokenExpired) {
+		return ErrExpiredToken
+	}
+	if errors.Is(err, jwt.ErrTokenNotValidYet) {
+		return ErrTokenNotYetValid
+	}
+	return ErrInvalidToken
 }
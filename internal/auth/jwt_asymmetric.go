@@ -0,0 +1,181 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// JWT signing algorithms
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgES256 = "ES256"
+	AlgEdDSA = "EdDSA"
+)
+
+const minRSAKeyBits = 2048
+
+// ErrUnsupportedKey is returned for signing keys of an unsupported type or size
+var ErrUnsupportedKey = errors.New("unsupported signing key")
+
+// AsymmetricJWTMaker implements TokenMaker with RS256, ES256 or EdDSA
+// signatures. Only the private key holder can issue tokens; anyone with the
+// public key, published through JWKS, can verify them.
+type AsymmetricJWTMaker struct {
+	method  jwt.SigningMethod
+	key     crypto.Signer
+	jwk     JWK
+	options TokenOptions
+}
+
+// NewRSAJWTMaker creates a maker signing with RS256. Keys must be at least
+// 2048 bits.
+func NewRSAJWTMaker(key *rsa.PrivateKey, opts ...TokenOption) (*AsymmetricJWTMaker, error) {
+	if key.N.BitLen() < minRSAKeyBits {
+		return nil, fmt.Errorf("%w: RSA key must be at least %d bits", ErrUnsupportedKey, minRSAKeyBits)
+	}
+	return newAsymmetricJWTMaker(jwt.SigningMethodRS256, key, opts)
+}
+
+// NewECDSAJWTMaker creates a maker signing with ES256. Keys must use P-256.
+func NewECDSAJWTMaker(key *ecdsa.PrivateKey, opts ...TokenOption) (*AsymmetricJWTMaker, error) {
+	if key.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("%w: ES256 requires a P-256 key", ErrUnsupportedKey)
+	}
+	return newAsymmetricJWTMaker(jwt.SigningMethodES256, key, opts)
+}
+
+// NewEd25519JWTMaker creates a maker signing with EdDSA
+func NewEd25519JWTMaker(key ed25519.PrivateKey, opts ...TokenOption) (*AsymmetricJWTMaker, error) {
+	if len(key) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("%w: invalid Ed25519 key size", ErrUnsupportedKey)
+	}
+	return newAsymmetricJWTMaker(jwt.SigningMethodEdDSA, key, opts)
+}
+
+// NewAsymmetricJWTMaker creates a maker for key, choosing the algorithm
+// from the key type
+func NewAsymmetricJWTMaker(key crypto.Signer, opts ...TokenOption) (*AsymmetricJWTMaker, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return NewRSAJWTMaker(k, opts...)
+	case *ecdsa.PrivateKey:
+		return NewECDSAJWTMaker(k, opts...)
+	case ed25519.PrivateKey:
+		return NewEd25519JWTMaker(k, opts...)
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedKey, key)
+	}
+}
+
+func newAsymmetricJWTMaker(method jwt.SigningMethod, key crypto.Signer, opts []TokenOption) (*AsymmetricJWTMaker, error) {
+	jwk, err := NewJWK(key.Public(), method.Alg())
+	if err != nil {
+		return nil, err
+	}
+	return &AsymmetricJWTMaker{
+		method:  method,
+		key:     key,
+		jwk:     jwk,
+		options: newTokenOptions(opts),
+	}, nil
+}
+
+// Algorithm returns the JWS algorithm tokens are signed with
+func (m *AsymmetricJWTMaker) Algorithm() string {
+	return m.method.Alg()
+}
+
+// KeyID returns the kid written to token headers, the key's RFC 7638
+// thumbprint
+func (m *AsymmetricJWTMaker) KeyID() string {
+	return m.jwk.KeyID
+}
+
+// JWKS implements JWKSProvider
+func (m *AsymmetricJWTMaker) JWKS() JWKSet {
+	return JWKSet{Keys: []JWK{m.jwk}}
+}
+
+// CreateToken creates a new signed JWT
+func (m *AsymmetricJWTMaker) CreateToken(userID uuid.UUID, email, role string, tokenType TokenType, duration time.Duration, opts ...PayloadOption) (string, *TokenPayload, error) {
+	payload := newTokenPayloadAt(m.options.IDs.NewID(), m.options.Clock.Now(), userID, email, role, tokenType, duration)
+	m.options.apply(payload)
+	for _, opt := range opts {
+		opt(payload)
+	}
+
+	token := jwt.NewWithClaims(m.method, newJWTClaims(payload))
+	token.Header["kid"] = m.jwk.KeyID
+	tokenString, err := token.SignedString(m.key)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return tokenString, payload, nil
+}
+
+// VerifyToken verifies the JWT and returns the payload. Tokens must use the
+// maker's algorithm, and a kid, if present, must match its key.
+func (m *AsymmetricJWTMaker) VerifyToken(tokenString string) (*TokenPayload, error) {
+	keyFunc := func(token *jwt.Token) (interface{}, error) {
+		if token.Method.Alg() != m.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		if kid, ok := token.Header["kid"]; ok && kid != m.jwk.KeyID {
+			return nil, fmt.Errorf("unknown key id: %v", kid)
+		}
+		return m.key.Public(), nil
+	}
+
+	token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, keyFunc, m.options.jwtParserOptions()...)
+	if err != nil {
+		return nil, jwtError(err)
+	}
+
+	claims, ok := token.Claims.(*JWTClaims)
+	if !ok || !token.Valid {
+		return nil, ErrInvalidToken
+	}
+
+	return claims.payload()
+}
+
+// ParsePrivateKeyPEM parses a PEM encoded RSA, ECDSA or Ed25519 private key
+// in PKCS #8, PKCS #1 or SEC 1 form
+func ParsePrivateKeyPEM(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("%w: %T", ErrUnsupportedKey, key)
+		}
+		return signer, nil
+	default:
+		return nil, fmt.Errorf("unexpected PEM block %q", block.Type)
+	}
+}
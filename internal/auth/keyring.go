@@ -0,0 +1,354 @@
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pixperk/goiler/internal/config"
+)
+
+// ErrNoSigningKey is returned when no key in a KeyRing is active yet
+var ErrNoSigningKey = errors.New("no active signing key")
+
+// ErrNoUpcomingKey is returned when rotating a KeyRing that has no key
+// scheduled to take over
+var ErrNoUpcomingKey = errors.New("no upcoming signing key")
+
+// Key states reported by RingKey.State
+const (
+	KeyStateUpcoming = "upcoming" // published, not signing yet
+	KeyStateActive   = "active"   // signing new tokens
+	KeyStateRetired  = "retired"  // verifying tokens it signed before retiring
+	KeyStateExpired  = "expired"  // no longer accepted
+)
+
+// RingKey is a signing key in a KeyRing. Set either Key or Secret.
+type RingKey struct {
+	// ID is written to tokens as kid
+	ID string
+	// Key is an RSA, ECDSA (P-256) or Ed25519 private key
+	Key crypto.Signer
+	// Secret is an HS256 secret, used when Key is nil
+	Secret []byte
+	// ActivatesAt is when the key starts signing; zero means from the start.
+	// The key retires when the next key activates.
+	ActivatesAt time.Time
+
+	retiresAt time.Time
+	revokedAt time.Time // set by RotateKeys
+	method    jwt.SigningMethod
+	jwk       *JWK
+}
+
+// State returns the key's state at now
+func (k *RingKey) State(now time.Time, retention time.Duration) string {
+	switch {
+	case !k.revokedAt.IsZero() && !now.Before(k.revokedAt):
+		return KeyStateExpired
+	case now.Before(k.ActivatesAt):
+		return KeyStateUpcoming
+	case k.retiresAt.IsZero() || now.Before(k.retiresAt):
+		return KeyStateActive
+	case now.Before(k.retiresAt.Add(retention)):
+		return KeyStateRetired
+	default:
+		return KeyStateExpired
+	}
+}
+
+// signedInWindow reports whether a token issued at issuedAt could have been
+// signed by the key, i.e. while it was active
+func (k *RingKey) signedInWindow(issuedAt time.Time, leeway time.Duration) bool {
+	if issuedAt.Add(leeway).Before(k.ActivatesAt) {
+		return false
+	}
+	return k.retiresAt.IsZero() || !issuedAt.After(k.retiresAt.Add(leeway))
+}
+
+// KeyRing holds the keys tokens are signed and verified with. One key signs
+// at a time, following a schedule of activation times; a retired key keeps
+// verifying the tokens it signed for the retention period, which should be
+// at least the longest token lifetime. Upcoming keys are published through
+// JWKS before they activate so verifiers can cache them in time.
+//
+// To rotate, add a key with a future activation time and deploy; drop the
+// old key once the retention period after the switch has passed. To stop
+// trusting a compromised key at once, see RotateKeys.
+type KeyRing struct {
+	mu        sync.RWMutex
+	keys      []*RingKey // by activation time
+	retention time.Duration
+	rotatedAt time.Time
+}
+
+// KeyRingProvider is implemented by token makers that sign with a KeyRing
+type KeyRingProvider interface {
+	KeyRing() *KeyRing
+}
+
+// NewKeyRing creates a key ring. Key IDs must be unique and activation
+// times distinct.
+func NewKeyRing(retention time.Duration, keys ...RingKey) (*KeyRing, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("key ring needs at least one key")
+	}
+
+	ring := &KeyRing{retention: retention}
+	ids := make(map[string]bool, len(keys))
+	for i := range keys {
+		key := keys[i]
+		if key.ID == "" {
+			return nil, errors.New("key ring keys need an ID")
+		}
+		if ids[key.ID] {
+			return nil, fmt.Errorf("duplicate key ID %q", key.ID)
+		}
+		ids[key.ID] = true
+
+		if err := key.init(); err != nil {
+			return nil, fmt.Errorf("key %q: %w", key.ID, err)
+		}
+		ring.keys = append(ring.keys, &key)
+	}
+
+	sort.SliceStable(ring.keys, func(i, j int) bool {
+		return ring.keys[i].ActivatesAt.Before(ring.keys[j].ActivatesAt)
+	})
+	for i := 1; i < len(ring.keys); i++ {
+		if ring.keys[i].ActivatesAt.Equal(ring.keys[i-1].ActivatesAt) {
+			return nil, fmt.Errorf("keys %q and %q activate at the same time", ring.keys[i-1].ID, ring.keys[i].ID)
+		}
+		ring.keys[i-1].retiresAt = ring.keys[i].ActivatesAt
+	}
+
+	return ring, nil
+}
+
+// init picks the signing method for the key and describes its public key
+func (k *RingKey) init() error {
+	switch key := k.Key.(type) {
+	case nil:
+		if len(k.Secret) < minSecretKeySize {
+			return fmt.Errorf("secret must be at least %d bytes", minSecretKeySize)
+		}
+		k.method = jwt.SigningMethodHS256
+		return nil
+	case *rsa.PrivateKey:
+		if key.N.BitLen() < minRSAKeyBits {
+			return fmt.Errorf("%w: RSA key must be at least %d bits", ErrUnsupportedKey, minRSAKeyBits)
+		}
+		k.method = jwt.SigningMethodRS256
+	case *ecdsa.PrivateKey:
+		if key.Curve.Params().Name != "P-256" {
+			return fmt.Errorf("%w: ES256 requires a P-256 key", ErrUnsupportedKey)
+		}
+		k.method = jwt.SigningMethodES256
+	case ed25519.PrivateKey:
+		k.method = jwt.SigningMethodEdDSA
+	default:
+		return fmt.Errorf("%w: %T", ErrUnsupportedKey, k.Key)
+	}
+
+	jwk, err := NewJWK(k.Key.Public(), k.method.Alg())
+	if err != nil {
+		return err
+	}
+	jwk.KeyID = k.ID
+	k.jwk = &jwk
+	return nil
+}
+
+// signingKey returns the key material golang-jwt signs with
+func (k *RingKey) signingKey() interface{} {
+	if k.Key == nil {
+		return k.Secret
+	}
+	return k.Key
+}
+
+// verificationKey returns the key material golang-jwt verifies with
+func (k *RingKey) verificationKey() interface{} {
+	if k.Key == nil {
+		return k.Secret
+	}
+	return k.Key.Public()
+}
+
+// Retention returns how long retired keys keep verifying tokens
+func (r *KeyRing) Retention() time.Duration {
+	return r.retention
+}
+
+// Keys returns the ring's keys in activation order
+func (r *KeyRing) Keys() []*RingKey {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	keys := make([]*RingKey, len(r.keys))
+	copy(keys, r.keys)
+	return keys
+}
+
+// SigningKey returns the key that signs new tokens at now
+func (r *KeyRing) SigningKey(now time.Time) (*RingKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for i := len(r.keys) - 1; i >= 0; i-- {
+		if !now.Before(r.keys[i].ActivatesAt) {
+			return r.keys[i], nil
+		}
+	}
+	return nil, ErrNoSigningKey
+}
+
+// candidates returns the keys a token with the given kid may have been
+// signed with: the key with that ID, or for tokens without a kid (issued
+// before the ring was introduced) every key still accepted. Expired keys
+// are never returned.
+func (r *KeyRing) candidates(kid string, now time.Time) []*RingKey {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var keys []*RingKey
+	for _, key := range r.keys {
+		if kid != "" && key.ID != kid {
+			continue
+		}
+		if key.State(now, r.retention) == KeyStateExpired {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// JWKS returns the public keys that are upcoming, active or retired at now
+func (r *KeyRing) JWKS(now time.Time) JWKSet {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range r.keys {
+		if key.jwk != nil && key.State(now, r.retention) != KeyStateExpired {
+			set.Keys = append(set.Keys, *key.jwk)
+		}
+	}
+	return set
+}
+
+// NextKey returns the first key scheduled to activate after now, which
+// RotateKeys would switch to
+func (r *KeyRing) NextKey(now time.Time) (*RingKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, key := range r.keys {
+		if key.ActivatesAt.After(now) {
+			return key, nil
+		}
+	}
+	return nil, ErrNoUpcomingKey
+}
+
+// RotateKeys brings the first key scheduled after at forward to sign from
+// at, and stops accepting the keys before it from at instead of after the
+// retention period, so every token they signed is rejected. Rotations at or
+// before the last one are ignored, so each instance can apply the same
+// rotations in order and end up with the same ring.
+func (r *KeyRing) RotateKeys(at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.rotatedAt.IsZero() && !at.After(r.rotatedAt) {
+		return nil
+	}
+	next := slices.IndexFunc(r.keys, func(key *RingKey) bool {
+		return key.ActivatesAt.After(at)
+	})
+	if next < 0 {
+		return ErrNoUpcomingKey
+	}
+
+	// Keys are copied rather than changed, as verifiers may be reading them
+	keys := make([]*RingKey, len(r.keys))
+	for i, key := range r.keys {
+		rotated := *key
+		switch {
+		case i < next:
+			if rotated.retiresAt.After(at) {
+				rotated.retiresAt = at
+			}
+			rotated.revokedAt = at
+		case i == next:
+			rotated.ActivatesAt = at
+		}
+		keys[i] = &rotated
+	}
+	r.keys = keys
+	r.rotatedAt = at
+	return nil
+}
+
+// LoadKeyRing builds a key ring from AUTH_SIGNING_KEYS entries of the form
+// "kid=path" or "kid=path@activation", with activation in RFC 3339. Files
+// hold a PEM private key, or an HS256 secret. Retired keys are kept for
+// cfg.KeyRetention, defaulting to the refresh token lifetime.
+func LoadKeyRing(cfg config.AuthConfig) (*KeyRing, error) {
+	keys := make([]RingKey, 0, len(cfg.SigningKeys))
+	for _, entry := range cfg.SigningKeys {
+		key, err := loadRingKey(entry)
+		if err != nil {
+			return nil, fmt.Errorf("AUTH_SIGNING_KEYS entry %q: %w", entry, err)
+		}
+		keys = append(keys, key)
+	}
+
+	retention := cfg.KeyRetention
+	if retention <= 0 {
+		retention = cfg.JWTRefreshExpiry
+	}
+	return NewKeyRing(retention, keys...)
+}
+
+func loadRingKey(entry string) (RingKey, error) {
+	id, rest, ok := strings.Cut(entry, "=")
+	if !ok || id == "" || rest == "" {
+		return RingKey{}, errors.New(`expected "kid=path" or "kid=path@activation"`)
+	}
+
+	key := RingKey{ID: id}
+	path, activation, scheduled := strings.Cut(rest, "@")
+	if scheduled {
+		activatesAt, err := time.Parse(time.RFC3339, activation)
+		if err != nil {
+			return RingKey{}, fmt.Errorf("parse activation time: %w", err)
+		}
+		key.ActivatesAt = activatesAt
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return RingKey{}, fmt.Errorf("read key: %w", err)
+	}
+	if bytes.Contains(data, []byte("-----BEGIN")) {
+		if key.Key, err = ParsePrivateKeyPEM(data); err != nil {
+			return RingKey{}, fmt.Errorf("parse key: %w", err)
+		}
+	} else {
+		key.Secret = bytes.TrimSpace(data)
+	}
+	return key, nil
+}
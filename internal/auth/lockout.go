@@ -0,0 +1,474 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pixperk/goiler/internal/config"
+	"github.com/pixperk/goiler/internal/tenant"
+	"github.com/pixperk/goiler/pkg/clock"
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrAccountLocked is returned by Login while too many recent failures lock
+// the account or client out
+var ErrAccountLocked = errors.New("account temporarily locked")
+
+// Login attempt store backends
+const (
+	LoginAttemptStoreMemory = "memory"
+	LoginAttemptStoreRedis  = "redis"
+)
+
+// LockoutError is returned while a lockout is in effect. It matches
+// ErrAccountLocked with errors.Is.
+type LockoutError struct {
+	Until      time.Time
+	RetryAfter time.Duration
+}
+
+func (e *LockoutError) Error() string {
+	return ErrAccountLocked.Error()
+}
+
+func (e *LockoutError) Unwrap() error {
+	return ErrAccountLocked
+}
+
+// LoginAttempts is the failure history recorded for a key
+type LoginAttempts struct {
+	Failures    int
+	LastFailure time.Time
+}
+
+// LoginAttemptStore tracks failed logins per key. Keys identify either an
+// account (by email) or a client IP.
+type LoginAttemptStore interface {
+	// Get returns the attempts recorded for key; the zero value if none
+	Get(ctx context.Context, key string) (LoginAttempts, error)
+	// RecordFailure adds a failure to key if it still has the given
+	// failures, and keeps the key for ttl after it. It reports false,
+	// recording nothing, if another failure was recorded in between.
+	RecordFailure(ctx context.Context, key string, failures int, ttl time.Duration) (bool, error)
+	// Forgive takes back one failure recorded for key
+	Forgive(ctx context.Context, key string) error
+	// Extend keeps key for at least ttl from now
+	Extend(ctx context.Context, key string, ttl time.Duration) error
+	// Reset forgets the attempts recorded for key
+	Reset(ctx context.Context, key string) error
+}
+
+// LockoutPolicy decides when failed logins lock an account or client out.
+// Once the threshold is reached every further failure doubles the lockout,
+// starting at Duration and capped at MaxDuration.
+type LockoutPolicy struct {
+	// MaxAttempts is the number of failures per account before it is
+	// locked; 0 disables account lockout
+	MaxAttempts int
+	// MaxIPAttempts is the number of failures per client IP, across all
+	// accounts, before the IP is locked; 0 disables IP lockout
+	MaxIPAttempts int
+	Duration      time.Duration
+	MaxDuration   time.Duration
+	// Window is how long failures are remembered after the last one
+	Window time.Duration
+}
+
+// LockoutPolicyFromConfig returns the lockout policy described by cfg
+func LockoutPolicyFromConfig(cfg config.LockoutConfig) LockoutPolicy {
+	return LockoutPolicy{
+		MaxAttempts:   cfg.MaxAttempts,
+		MaxIPAttempts: cfg.MaxIPAttempts,
+		Duration:      cfg.Duration,
+		MaxDuration:   cfg.MaxDuration,
+		Window:        cfg.Window,
+	}
+}
+
+// Enabled reports whether any lockout applies
+func (p LockoutPolicy) Enabled() bool {
+	return p.MaxAttempts > 0 || p.MaxIPAttempts > 0
+}
+
+// Validate checks that the policy is usable
+func (p LockoutPolicy) Validate() error {
+	if !p.Enabled() {
+		return nil
+	}
+	if p.MaxAttempts < 0 || p.MaxIPAttempts < 0 {
+		return fmt.Errorf("lockout attempts must not be negative")
+	}
+	if p.Duration <= 0 {
+		return fmt.Errorf("lockout duration must be positive")
+	}
+	if p.MaxDuration < p.Duration {
+		return fmt.Errorf("lockout max duration %s is shorter than duration %s", p.MaxDuration, p.Duration)
+	}
+	if p.Window <= 0 {
+		return fmt.Errorf("lockout window must be positive")
+	}
+	return nil
+}
+
+// lockedUntil returns when the lockout for attempts ends, or the zero time
+// if maxAttempts hasn't been reached
+func (p LockoutPolicy) lockedUntil(attempts LoginAttempts, maxAttempts int) time.Time {
+	if maxAttempts <= 0 || attempts.Failures < maxAttempts {
+		return time.Time{}
+	}
+
+	lockout := p.MaxDuration
+	if excess := attempts.Failures - maxAttempts; excess < 32 {
+		if d := p.Duration << excess; d > 0 && d < p.MaxDuration {
+			lockout = d
+		}
+	}
+	return attempts.LastFailure.Add(lockout)
+}
+
+// accountAttemptKey is per tenant, since each tenant has its own account
+// for an email
+func accountAttemptKey(ctx context.Context, email string) string {
+	return "account:" + tenant.ID(ctx).String() + ":" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipAttemptKey(ip string) string {
+	return "ip:" + ip
+}
+
+// attemptKeys returns the keys a login for email from ctx's client counts
+// against, paired with their lockout thresholds. Accounts are also tracked
+// without a threshold for failed login alerts.
+func (s *Service) attemptKeys(ctx context.Context, email string) map[string]int {
+	keys := make(map[string]int, 2)
+	if s.lockout.MaxAttempts > 0 || s.loginAlerts.FailedAttempts > 0 {
+		keys[accountAttemptKey(ctx, email)] = s.lockout.MaxAttempts
+	}
+	if client, ok := ClientFromContext(ctx); ok && client.IPAddress != "" && s.lockout.MaxIPAttempts > 0 {
+		keys[ipAttemptKey(client.IPAddress)] = s.lockout.MaxIPAttempts
+	}
+	return keys
+}
+
+// loginAttempt is a login counted as failed against its keys before the
+// password is checked
+type loginAttempt struct {
+	email string
+	keys  map[string]countedAttempt
+}
+
+type countedAttempt struct {
+	// failures includes this attempt
+	failures    int
+	maxAttempts int
+}
+
+// countLoginAttempt counts a login as failed before its password is
+// checked, so concurrent guesses each see the ones before them and no more
+// than the threshold are verified. It returns a LockoutError, counting
+// nothing, if the login is locked out. Store errors are logged and the
+// login is allowed, so a store outage doesn't lock everyone out.
+func (s *Service) countLoginAttempt(ctx context.Context, email string) (*loginAttempt, error) {
+	attempt := &loginAttempt{email: email, keys: make(map[string]countedAttempt, 2)}
+	if s.attempts == nil {
+		return attempt, nil
+	}
+
+	var until time.Time
+	for key, maxAttempts := range s.attemptKeys(ctx, email) {
+		failures, lockedUntil, err := s.recordAttempt(ctx, key, maxAttempts)
+		if err != nil {
+			s.logger.ErrorContext(ctx, "failed to record login attempt", slog.String("error", err.Error()))
+			continue
+		}
+		if lockedUntil.After(until) {
+			until = lockedUntil
+		}
+		if failures > 0 {
+			attempt.keys[key] = countedAttempt{failures: failures, maxAttempts: maxAttempts}
+		}
+	}
+
+	if retryAfter := s.clock.Until(until); retryAfter > 0 {
+		for key := range attempt.keys {
+			s.forgiveAttempt(ctx, key)
+		}
+		return nil, &LockoutError{Until: until, RetryAfter: retryAfter}
+	}
+	return attempt, nil
+}
+
+// recordAttempt adds a failure to key unless it is locked out, retrying
+// while concurrent logins record theirs. It returns the failures including
+// this one, or when the lockout ends.
+func (s *Service) recordAttempt(ctx context.Context, key string, maxAttempts int) (int, time.Time, error) {
+	for {
+		attempts, err := s.attempts.Get(ctx, key)
+		if err != nil {
+			return 0, time.Time{}, err
+		}
+		if until := s.lockout.lockedUntil(attempts, maxAttempts); s.clock.Until(until) > 0 {
+			return 0, until, nil
+		}
+
+		recorded, err := s.attempts.RecordFailure(ctx, key, attempts.Failures, s.lockout.Window)
+		if err != nil {
+			return 0, time.Time{}, err
+		}
+		if recorded {
+			return attempts.Failures + 1, time.Time{}, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return 0, time.Time{}, err
+		}
+	}
+}
+
+func (s *Service) forgiveAttempt(ctx context.Context, key string) {
+	if err := s.attempts.Forgive(ctx, key); err != nil {
+		s.logger.ErrorContext(ctx, "failed to forgive login attempt", slog.String("error", err.Error()))
+	}
+}
+
+// loginFailed keeps the failure counted for attempt and returns the
+// account's failures, 0 if they aren't tracked
+func (s *Service) loginFailed(ctx context.Context, attempt *loginAttempt) int {
+	var accountFailures int
+	for key, counted := range attempt.keys {
+		// Failures are forgotten after Window, unless the lockout they
+		// caused lasts longer
+		attempts := LoginAttempts{Failures: counted.failures, LastFailure: s.clock.Now()}
+		if keep := s.clock.Until(s.lockout.lockedUntil(attempts, counted.maxAttempts)); keep > s.lockout.Window {
+			if err := s.attempts.Extend(ctx, key, keep); err != nil {
+				s.logger.ErrorContext(ctx, "failed to extend login attempts", slog.String("error", err.Error()))
+			}
+		}
+		if key == accountAttemptKey(ctx, attempt.email) {
+			accountFailures = counted.failures
+		}
+		if counted.maxAttempts > 0 && counted.failures == counted.maxAttempts {
+			s.logger.WarnContext(ctx, "login locked out",
+				slog.String("scope", strings.SplitN(key, ":", 2)[0]),
+				slog.Int("failures", counted.failures),
+			)
+		}
+	}
+	return accountFailures
+}
+
+// loginSucceeded clears the account's failures. Only the failure counted
+// for attempt is taken back from the client IP, since earlier ones may
+// belong to other accounts.
+func (s *Service) loginSucceeded(ctx context.Context, attempt *loginAttempt) {
+	for key := range attempt.keys {
+		if key != accountAttemptKey(ctx, attempt.email) {
+			s.forgiveAttempt(ctx, key)
+			continue
+		}
+		if err := s.attempts.Reset(ctx, key); err != nil {
+			s.logger.ErrorContext(ctx, "failed to reset login attempts", slog.String("error", err.Error()))
+		}
+	}
+}
+
+// MemoryLoginAttemptStore keeps login attempts in memory. It is only
+// suitable for a single instance.
+type MemoryLoginAttemptStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryLoginAttempts
+	clock   clock.Clock
+}
+
+type memoryLoginAttempts struct {
+	attempts  LoginAttempts
+	expiresAt time.Time
+}
+
+// NewMemoryLoginAttemptStore creates an in-memory login attempt store. A
+// nil clock uses the system clock.
+func NewMemoryLoginAttemptStore(c clock.Clock) *MemoryLoginAttemptStore {
+	return &MemoryLoginAttemptStore{
+		entries: make(map[string]memoryLoginAttempts),
+		clock:   clock.OrReal(c),
+	}
+}
+
+// Get implements LoginAttemptStore
+func (s *MemoryLoginAttemptStore) Get(_ context.Context, key string) (LoginAttempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok || s.clock.Now().After(entry.expiresAt) {
+		return LoginAttempts{}, nil
+	}
+	return entry.attempts, nil
+}
+
+// RecordFailure implements LoginAttemptStore
+func (s *MemoryLoginAttemptStore) RecordFailure(_ context.Context, key string, failures int, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	// Sweep expired entries so abandoned keys don't accumulate
+	for k, entry := range s.entries {
+		if now.After(entry.expiresAt) {
+			delete(s.entries, k)
+		}
+	}
+
+	entry := s.entries[key]
+	if entry.attempts.Failures != failures {
+		return false, nil
+	}
+	entry.attempts.Failures++
+	entry.attempts.LastFailure = now
+	entry.expiresAt = now.Add(ttl)
+	s.entries[key] = entry
+
+	return true, nil
+}
+
+// Forgive implements LoginAttemptStore
+func (s *MemoryLoginAttemptStore) Forgive(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok || s.clock.Now().After(entry.expiresAt) {
+		return nil
+	}
+	if entry.attempts.Failures <= 1 {
+		delete(s.entries, key)
+		return nil
+	}
+	entry.attempts.Failures--
+	s.entries[key] = entry
+	return nil
+}
+
+// Extend implements LoginAttemptStore
+func (s *MemoryLoginAttemptStore) Extend(_ context.Context, key string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	entry, ok := s.entries[key]
+	if !ok || now.After(entry.expiresAt) {
+		return nil
+	}
+	if expiresAt := now.Add(ttl); expiresAt.After(entry.expiresAt) {
+		entry.expiresAt = expiresAt
+		s.entries[key] = entry
+	}
+	return nil
+}
+
+// Reset implements LoginAttemptStore
+func (s *MemoryLoginAttemptStore) Reset(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+// RedisLoginAttemptStore keeps login attempts in Redis so lockouts are
+// shared by all instances. Each key is a hash with the failure count and
+// the time of the last failure.
+type RedisLoginAttemptStore struct {
+	client redis.UniversalClient
+	prefix string
+	clock  clock.Clock
+}
+
+// The failure count is compared and incremented in one step, so concurrent
+// logins can't both record on top of the same count
+var (
+	recordFailureScript = redis.NewScript(`
+if tonumber(redis.call("HGET", KEYS[1], "failures") or "0") ~= tonumber(ARGV[1]) then
+	return 0
+end
+redis.call("HINCRBY", KEYS[1], "failures", 1)
+redis.call("HSET", KEYS[1], "last_failure", ARGV[2])
+redis.call("PEXPIRE", KEYS[1], ARGV[3])
+return 1
+`)
+	forgiveFailureScript = redis.NewScript(`
+local failures = tonumber(redis.call("HGET", KEYS[1], "failures") or "0")
+if failures > 1 then
+	redis.call("HINCRBY", KEYS[1], "failures", -1)
+elseif failures == 1 then
+	redis.call("DEL", KEYS[1])
+end
+return failures
+`)
+)
+
+// NewRedisLoginAttemptStore creates a Redis-backed login attempt store. A
+// nil clock uses the system clock.
+func NewRedisLoginAttemptStore(client redis.UniversalClient, c clock.Clock) *RedisLoginAttemptStore {
+	return &RedisLoginAttemptStore{client: client, prefix: "goiler:login_attempts:", clock: clock.OrReal(c)}
+}
+
+// Get implements LoginAttemptStore
+func (s *RedisLoginAttemptStore) Get(ctx context.Context, key string) (LoginAttempts, error) {
+	values, err := s.client.HMGet(ctx, s.prefix+key, "failures", "last_failure").Result()
+	if err != nil {
+		return LoginAttempts{}, err
+	}
+	return parseRedisLoginAttempts(values)
+}
+
+// RecordFailure implements LoginAttemptStore
+func (s *RedisLoginAttemptStore) RecordFailure(ctx context.Context, key string, failures int, ttl time.Duration) (bool, error) {
+	recorded, err := recordFailureScript.Run(ctx, s.client, []string{s.prefix + key},
+		failures, s.clock.Now().UnixNano(), ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return recorded == 1, nil
+}
+
+// Forgive implements LoginAttemptStore
+func (s *RedisLoginAttemptStore) Forgive(ctx context.Context, key string) error {
+	return forgiveFailureScript.Run(ctx, s.client, []string{s.prefix + key}).Err()
+}
+
+// Extend implements LoginAttemptStore
+func (s *RedisLoginAttemptStore) Extend(ctx context.Context, key string, ttl time.Duration) error {
+	return s.client.ExpireGT(ctx, s.prefix+key, ttl).Err()
+}
+
+// Reset implements LoginAttemptStore
+func (s *RedisLoginAttemptStore) Reset(ctx context.Context, key string) error {
+	return s.client.Del(ctx, s.prefix+key).Err()
+}
+
+func parseRedisLoginAttempts(values []interface{}) (LoginAttempts, error) {
+	var attempts LoginAttempts
+	if len(values) != 2 || values[0] == nil {
+		return attempts, nil
+	}
+
+	failures, err := strconv.Atoi(fmt.Sprint(values[0]))
+	if err != nil {
+		return attempts, fmt.Errorf("parse login failures: %w", err)
+	}
+	attempts.Failures = failures
+
+	if values[1] != nil {
+		nanos, err := strconv.ParseInt(fmt.Sprint(values[1]), 10, 64)
+		if err != nil {
+			return attempts, fmt.Errorf("parse last login failure: %w", err)
+		}
+		attempts.LastFailure = time.Unix(0, nanos)
+	}
+	return attempts, nil
+}
@@ -0,0 +1,228 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pixperk/goiler/internal/config"
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrInvalidSecureAccountToken is returned for secure-account links that
+// are malformed or were issued for another purpose
+var ErrInvalidSecureAccountToken = errors.New("invalid secure account token")
+
+// LoginAlertPolicy decides which logins users are notified about. Each
+// notification carries a link that signs the account out everywhere.
+type LoginAlertPolicy struct {
+	// FailedAttempts is the number of failed logins on an account, within
+	// the lockout window, that triggers a notification; 0 disables it
+	FailedAttempts int
+	// NewCountry notifies users of successful logins from a country they
+	// haven't logged in from before. Needs the client country, see
+	// ClientCountry.
+	NewCountry bool
+	// SecureAccountURL is where secure-account links point; the token is
+	// added as the "token" query parameter. Notifications carry no link
+	// when it is empty.
+	SecureAccountURL string
+	// SecureAccountExpiry is the lifetime of secure-account links
+	SecureAccountExpiry time.Duration
+}
+
+// LoginAlertPolicyFromConfig returns the login alert policy described by cfg
+func LoginAlertPolicyFromConfig(cfg config.LoginAlertConfig) LoginAlertPolicy {
+	return LoginAlertPolicy{
+		FailedAttempts:      cfg.FailedAttempts,
+		NewCountry:          cfg.NewCountry,
+		SecureAccountURL:    cfg.SecureAccountURL,
+		SecureAccountExpiry: cfg.SecureAccountExpiry,
+	}
+}
+
+// Validate checks that the policy is usable
+func (p LoginAlertPolicy) Validate() error {
+	if p.FailedAttempts < 0 {
+		return fmt.Errorf("login alert failed attempts must not be negative")
+	}
+	if p.SecureAccountURL != "" {
+		if _, err := url.Parse(p.SecureAccountURL); err != nil {
+			return fmt.Errorf("invalid secure account URL: %w", err)
+		}
+		if p.SecureAccountExpiry <= 0 {
+			return fmt.Errorf("secure account link expiry must be positive")
+		}
+	}
+	return nil
+}
+
+// KnownCountryStore remembers the countries each user has logged in from
+type KnownCountryStore interface {
+	// RememberCountry records a login from country and reports whether it
+	// is new: the user logged in from other countries before, but not
+	// from this one. A user's first login is never new.
+	RememberCountry(ctx context.Context, userID uuid.UUID, country string) (bool, error)
+}
+
+// MemoryKnownCountryStore keeps login countries in memory. It is only
+// suitable for a single instance.
+type MemoryKnownCountryStore struct {
+	mu        sync.Mutex
+	countries map[uuid.UUID]map[string]struct{}
+}
+
+// NewMemoryKnownCountryStore creates an in-memory known country store
+func NewMemoryKnownCountryStore() *MemoryKnownCountryStore {
+	return &MemoryKnownCountryStore{countries: make(map[uuid.UUID]map[string]struct{})}
+}
+
+// RememberCountry implements KnownCountryStore
+func (s *MemoryKnownCountryStore) RememberCountry(ctx context.Context, userID uuid.UUID, country string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	known, ok := s.countries[userID]
+	if !ok {
+		known = make(map[string]struct{})
+		s.countries[userID] = known
+	}
+	if _, seen := known[country]; seen {
+		return false, nil
+	}
+	known[country] = struct{}{}
+	return len(known) > 1, nil
+}
+
+// knownCountryRetention is how long Redis remembers a user's countries
+// after their last login
+const knownCountryRetention = 365 * 24 * time.Hour
+
+// RedisKnownCountryStore keeps login countries in Redis, as a set per user
+type RedisKnownCountryStore struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// NewRedisKnownCountryStore creates a Redis-backed known country store
+func NewRedisKnownCountryStore(client redis.UniversalClient) *RedisKnownCountryStore {
+	return &RedisKnownCountryStore{client: client, prefix: "goiler:login_countries:"}
+}
+
+// RememberCountry implements KnownCountryStore
+func (s *RedisKnownCountryStore) RememberCountry(ctx context.Context, userID uuid.UUID, country string) (bool, error) {
+	key := s.prefix + userID.String()
+
+	var added, total *redis.IntCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		added = pipe.SAdd(ctx, key, country)
+		total = pipe.SCard(ctx, key)
+		pipe.PExpire(ctx, key, knownCountryRetention)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return added.Val() == 1 && total.Val() > 1, nil
+}
+
+// SecureAccountRequest represents a request to sign an account out
+// everywhere. The token is read from the JSON body or, for the
+// confirmation page's form, the form body.
+type SecureAccountRequest struct {
+	Token string `json:"token" form:"token" validate:"required"`
+}
+
+// SecureAccount signs the user a secure-account token was issued for out
+// everywhere: every refresh token is revoked and every cookie session
+// ended. Access tokens already issued expire on their own.
+func (s *Service) SecureAccount(ctx context.Context, token string) error {
+	payload, err := s.tokenMaker.VerifyToken(token)
+	if err != nil {
+		if errors.Is(err, ErrExpiredToken) {
+			return err
+		}
+		return ErrInvalidSecureAccountToken
+	}
+	if payload.TokenType != SecureAccountToken {
+		return ErrInvalidSecureAccountToken
+	}
+	ctx, ok := payload.tenantContext(ctx)
+	if !ok {
+		return ErrInvalidSecureAccountToken
+	}
+
+	user, err := s.userRepo.GetByID(ctx, payload.UserID)
+	if err != nil || user.Email != payload.Email {
+		return ErrInvalidSecureAccountToken
+	}
+
+	return s.RevokeUserTokens(ctx, user.ID, "secure_account")
+}
+
+// alertFailedLogins notifies the user once the account's failed logins
+// reach the alert threshold
+func (s *Service) alertFailedLogins(ctx context.Context, user *User, failures int) {
+	if s.loginAlerts.FailedAttempts <= 0 || failures != s.loginAlerts.FailedAttempts {
+		return
+	}
+	s.notify(ctx, user, SecurityEvent{
+		Type:           SecurityEventFailedLogins,
+		FailedAttempts: failures,
+	})
+}
+
+// alertNewCountry notifies the user of a login from a country they haven't
+// logged in from before. Logins without a known country are skipped.
+func (s *Service) alertNewCountry(ctx context.Context, user *User) {
+	if s.knownCountries == nil {
+		return
+	}
+	client, _ := ClientFromContext(ctx)
+	if client.Country == "" {
+		return
+	}
+
+	isNew, err := s.knownCountries.RememberCountry(ctx, user.ID, client.Country)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to record login country", slog.String("error", err.Error()))
+		return
+	}
+	if isNew {
+		s.notify(ctx, user, SecurityEvent{Type: SecurityEventNewCountryLogin})
+	}
+}
+
+// notify sends a security event about user, filling in the client details
+// and a secure-account link. Failures are logged.
+func (s *Service) notify(ctx context.Context, user *User, event SecurityEvent) {
+	if s.notifier == nil {
+		return
+	}
+
+	client, _ := ClientFromContext(ctx)
+	event.UserID = user.ID.String()
+	event.Email = user.Email
+	event.IPAddress = client.IPAddress
+	event.UserAgent = client.UserAgent
+	event.Country = client.Country
+	event.OccurredAt = s.clock.Now()
+
+	if s.loginAlerts.SecureAccountURL != "" {
+		link, err := s.tokenLink(user, SecureAccountToken, s.loginAlerts.SecureAccountExpiry, s.loginAlerts.SecureAccountURL)
+		if err != nil {
+			s.logger.ErrorContext(ctx, "failed to create secure account link", slog.String("error", err.Error()))
+		} else {
+			event.SecureAccountURL = link
+		}
+	}
+
+	if err := s.notifier.NotifySecurityEvent(ctx, event); err != nil {
+		s.logger.ErrorContext(ctx, "failed to send security notification", slog.String("error", err.Error()))
+	}
+}
@@ -15,24 +15,27 @@ const symmetricKeySize = 32
 type PASETOMaker struct {
 	paseto       *paseto.V2
 	symmetricKey []byte
+	options      TokenOptions
 }
 
 // NewPASETOMaker creates a new PASETOMaker
-func NewPASETOMaker(symmetricKey []byte) (*PASETOMaker, error) {
+func NewPASETOMaker(symmetricKey []byte, opts ...TokenOption) (*PASETOMaker, error) {
 	if len(symmetricKey) != symmetricKeySize {
 		return nil, fmt.Errorf("symmetric key must be exactly %d bytes", symmetricKeySize)
 	}
 	return &PASETOMaker{
 		paseto:       paseto.NewV2(),
 		symmetricKey: symmetricKey,
+		options:      newTokenOptions(opts),
 	}, nil
 }
 
 // CreateToken creates a new PASETO token
-func (m *PASETOMaker) CreateToken(userID uuid.UUID, email, role string, tokenType TokenType, duration time.Duration) (string, *TokenPayload, error) {
-	payload, err := NewTokenPayload(userID, email, role, tokenType, duration)
-	if err != nil {
-		return "", nil, err
+func (m *PASETOMaker) CreateToken(userID uuid.UUID, email, role string, tokenType TokenType, duration time.Duration, opts ...PayloadOption) (string, *TokenPayload, error) {
+	payload := newTokenPayloadAt(m.options.IDs.NewID(), m.options.Clock.Now(), userID, email, role, tokenType, duration)
+	m.options.apply(payload)
+	for _, opt := range opts {
+		opt(payload)
 	}
 
 	token, err := m.paseto.Encrypt(m.symmetricKey, payload, nil)
@@ -52,7 +55,7 @@ func (m *PASETOMaker) VerifyToken(token string) (*TokenPayload, error) {
 		return nil, ErrInvalidToken
 	}
 
-	if err := payload.Valid(); err != nil {
+	if err := payload.Validate(m.options); err != nil {
 		return nil, err
 	}
 
@@ -66,8 +69,18 @@ type PASETOPayloadJSON struct {
 	Email     string    `json:"email"`
 	Role      string    `json:"role"`
 	TokenType TokenType `json:"token_type"`
+	Issuer    string    `json:"issuer,omitempty"`
+	Audience  []string  `json:"audience,omitempty"`
 	IssuedAt  time.Time `json:"issued_at"`
+	NotBefore time.Time `json:"not_before"`
 	ExpiresAt time.Time `json:"expires_at"`
+
+	Fingerprint    *Fingerprint `json:"fingerprint,omitempty"`
+	ImpersonatedBy *uuid.UUID   `json:"impersonated_by,omitempty"`
+	Family         *uuid.UUID   `json:"family,omitempty"`
+	TenantID       *uuid.UUID   `json:"tenant_id,omitempty"`
+	OrgID          *uuid.UUID   `json:"org_id,omitempty"`
+	Sandbox        bool         `json:"sandbox,omitempty"`
 }
 
 // MarshalJSON implements json.Marshaler
@@ -78,8 +91,18 @@ func (p *TokenPayload) MarshalJSON() ([]byte, error) {
 		Email:     p.Email,
 		Role:      p.Role,
 		TokenType: p.TokenType,
+		Issuer:    p.Issuer,
+		Audience:  p.Audience,
 		IssuedAt:  p.IssuedAt,
+		NotBefore: p.NotBefore,
 		ExpiresAt: p.ExpiresAt,
+
+		Fingerprint:    p.Fingerprint,
+		ImpersonatedBy: p.ImpersonatedBy,
+		Family:         p.Family,
+		TenantID:       p.TenantID,
+		OrgID:          p.OrgID,
+		Sandbox:        p.Sandbox,
 	})
 }
 
@@ -105,8 +128,17 @@ func (p *TokenPayload) UnmarshalJSON(data []byte) error {
 	p.Email = pj.Email
 	p.Role = pj.Role
 	p.TokenType = pj.TokenType
+	p.Issuer = pj.Issuer
+	p.Audience = pj.Audience
 	p.IssuedAt = pj.IssuedAt
+	p.NotBefore = pj.NotBefore
 	p.ExpiresAt = pj.ExpiresAt
+	p.Fingerprint = pj.Fingerprint
+	p.ImpersonatedBy = pj.ImpersonatedBy
+	p.Family = pj.Family
+	p.TenantID = pj.TenantID
+	p.OrgID = pj.OrgID
+	p.Sandbox = pj.Sandbox
 
 	return nil
 }
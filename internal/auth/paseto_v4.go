@@ -0,0 +1,482 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/chacha20"
+)
+
+// Token types selectable with AUTH_TYPE, besides "jwt" and "paseto" (v2.local)
+const (
+	AuthTypePASETOV4     = "paseto-v4"
+	AuthTypePASETOPublic = "paseto-public"
+)
+
+const (
+	pasetoV4LocalHeader  = "v4.local."
+	pasetoV4PublicHeader = "v4.public."
+	pasetoV4NonceSize    = 32
+	pasetoV4MACSize      = 32
+)
+
+// Migrating from v2 ("paseto") to v4: the formats are not interchangeable,
+// so tokens issued before the switch stop verifying. Access tokens expire on
+// their own; refresh tokens are rejected and their users have to log in
+// again. v4.local can reuse the v2 symmetric key. To avoid forced logins,
+// switch during a quiet period or wait until JWT_REFRESH_EXPIRY has passed
+// since the last v2 login.
+
+// pasetoV4Claims are the claims of v4 tokens. Registered claims use the
+// names from the PASETO spec so other implementations can read them.
+type pasetoV4Claims struct {
+	TokenID   string    `json:"jti"`
+	Subject   string    `json:"sub"`
+	Issuer    string    `json:"iss,omitempty"`
+	Audience  string    `json:"aud,omitempty"`
+	IssuedAt  time.Time `json:"iat"`
+	NotBefore time.Time `json:"nbf"`
+	ExpiresAt time.Time `json:"exp"`
+
+	Email       string       `json:"email"`
+	Role        string       `json:"role"`
+	TokenType   TokenType    `json:"token_type"`
+	Fingerprint *Fingerprint `json:"fingerprint,omitempty"`
+
+	ImpersonatedBy *uuid.UUID `json:"impersonated_by,omitempty"`
+	Family         *uuid.UUID `json:"family,omitempty"`
+	TenantID       *uuid.UUID `json:"tenant_id,omitempty"`
+	OrgID          *uuid.UUID `json:"org_id,omitempty"`
+	Sandbox        bool       `json:"sandbox,omitempty"`
+}
+
+func newPASETOV4Claims(p *TokenPayload) pasetoV4Claims {
+	claims := pasetoV4Claims{
+		TokenID:     p.ID.String(),
+		Subject:     p.UserID.String(),
+		Issuer:      p.Issuer,
+		IssuedAt:    p.IssuedAt.UTC().Truncate(time.Second),
+		NotBefore:   p.NotBefore.UTC().Truncate(time.Second),
+		ExpiresAt:   p.ExpiresAt.UTC().Truncate(time.Second),
+		Email:       p.Email,
+		Role:        p.Role,
+		TokenType:   p.TokenType,
+		Fingerprint: p.Fingerprint,
+
+		ImpersonatedBy: p.ImpersonatedBy,
+		Family:         p.Family,
+		TenantID:       p.TenantID,
+		OrgID:          p.OrgID,
+		Sandbox:        p.Sandbox,
+	}
+	if len(p.Audience) > 0 {
+		claims.Audience = p.Audience[0]
+	}
+	return claims
+}
+
+func (c pasetoV4Claims) payload() (*TokenPayload, error) {
+	tokenID, err := uuid.Parse(c.TokenID)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	userID, err := uuid.Parse(c.Subject)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	payload := &TokenPayload{
+		ID:          tokenID,
+		UserID:      userID,
+		Email:       c.Email,
+		Role:        c.Role,
+		TokenType:   c.TokenType,
+		Issuer:      c.Issuer,
+		IssuedAt:    c.IssuedAt,
+		NotBefore:   c.NotBefore,
+		ExpiresAt:   c.ExpiresAt,
+		Fingerprint: c.Fingerprint,
+
+		ImpersonatedBy: c.ImpersonatedBy,
+		Family:         c.Family,
+		TenantID:       c.TenantID,
+		OrgID:          c.OrgID,
+		Sandbox:        c.Sandbox,
+	}
+	if c.Audience != "" {
+		payload.Audience = []string{c.Audience}
+	}
+	return payload, nil
+}
+
+// PASETOV4LocalMaker implements TokenMaker with v4.local tokens: encrypted
+// with XChaCha20 and authenticated with keyed BLAKE2b
+type PASETOV4LocalMaker struct {
+	key     []byte
+	options TokenOptions
+}
+
+// NewPASETOV4LocalMaker creates a v4.local maker with a 32-byte key
+func NewPASETOV4LocalMaker(key []byte, opts ...TokenOption) (*PASETOV4LocalMaker, error) {
+	if len(key) != symmetricKeySize {
+		return nil, fmt.Errorf("symmetric key must be exactly %d bytes", symmetricKeySize)
+	}
+	return &PASETOV4LocalMaker{key: key, options: newTokenOptions(opts)}, nil
+}
+
+// CreateToken creates a new v4.local token
+func (m *PASETOV4LocalMaker) CreateToken(userID uuid.UUID, email, role string, tokenType TokenType, duration time.Duration, opts ...PayloadOption) (string, *TokenPayload, error) {
+	payload := newTokenPayloadAt(m.options.IDs.NewID(), m.options.Clock.Now(), userID, email, role, tokenType, duration)
+	m.options.apply(payload)
+	for _, opt := range opts {
+		opt(payload)
+	}
+
+	message, err := json.Marshal(newPASETOV4Claims(payload))
+	if err != nil {
+		return "", nil, err
+	}
+
+	nonce := make([]byte, pasetoV4NonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, err
+	}
+	token, err := pasetoV4Encrypt(m.key, nonce, message, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, payload, nil
+}
+
+// VerifyToken decrypts a v4.local token and returns the payload
+func (m *PASETOV4LocalMaker) VerifyToken(token string) (*TokenPayload, error) {
+	message, _, err := pasetoV4Decrypt(m.key, token)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	return verifyPASETOV4Claims(message, m.options)
+}
+
+// PASETOV4PublicMaker implements TokenMaker with v4.public tokens: signed,
+// not encrypted, with Ed25519. Public keys are published through JWKS and
+// the signing key's ID is written to the token footer as the kid.
+type PASETOV4PublicMaker struct {
+	ring    *KeyRing
+	options TokenOptions
+}
+
+// pasetoFooter is the JSON footer of v4.public tokens
+type pasetoFooter struct {
+	KeyID string `json:"kid"`
+}
+
+// NewPASETOV4PublicMaker creates a v4.public maker signing with key. The
+// kid is the key's RFC 7638 thumbprint.
+func NewPASETOV4PublicMaker(key ed25519.PrivateKey, opts ...TokenOption) (*PASETOV4PublicMaker, error) {
+	if len(key) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("%w: invalid Ed25519 key size", ErrUnsupportedKey)
+	}
+	jwk, err := NewJWK(key.Public(), "")
+	if err != nil {
+		return nil, err
+	}
+	ring, err := NewKeyRing(0, RingKey{ID: jwk.KeyID, Key: key})
+	if err != nil {
+		return nil, err
+	}
+	return NewPASETOV4PublicKeyRingMaker(ring, opts...)
+}
+
+// NewPASETOV4PublicKeyRingMaker creates a v4.public maker backed by ring,
+// whose keys must all be Ed25519
+func NewPASETOV4PublicKeyRingMaker(ring *KeyRing, opts ...TokenOption) (*PASETOV4PublicMaker, error) {
+	for _, key := range ring.Keys() {
+		if _, ok := key.Key.(ed25519.PrivateKey); !ok {
+			return nil, fmt.Errorf("%w: v4.public requires Ed25519 keys, key %q is not", ErrUnsupportedKey, key.ID)
+		}
+	}
+	return &PASETOV4PublicMaker{ring: ring, options: newTokenOptions(opts)}, nil
+}
+
+// KeyID returns the kid written to the footers of new tokens
+func (m *PASETOV4PublicMaker) KeyID() string {
+	key, err := m.ring.SigningKey(m.options.Clock.Now())
+	if err != nil {
+		return ""
+	}
+	return key.ID
+}
+
+// KeyRing returns the maker's key ring
+func (m *PASETOV4PublicMaker) KeyRing() *KeyRing {
+	return m.ring
+}
+
+// JWKS implements JWKSProvider. The keys carry no JWS algorithm as they
+// are not used for JWTs.
+func (m *PASETOV4PublicMaker) JWKS() JWKSet {
+	set := m.ring.JWKS(m.options.Clock.Now())
+	for i := range set.Keys {
+		set.Keys[i].Algorithm = ""
+	}
+	return set
+}
+
+// CreateToken creates a new v4.public token
+func (m *PASETOV4PublicMaker) CreateToken(userID uuid.UUID, email, role string, tokenType TokenType, duration time.Duration, opts ...PayloadOption) (string, *TokenPayload, error) {
+	now := m.options.Clock.Now()
+	key, err := m.ring.SigningKey(now)
+	if err != nil {
+		return "", nil, err
+	}
+
+	payload := newTokenPayloadAt(m.options.IDs.NewID(), now, userID, email, role, tokenType, duration)
+	m.options.apply(payload)
+	for _, opt := range opts {
+		opt(payload)
+	}
+
+	message, err := json.Marshal(newPASETOV4Claims(payload))
+	if err != nil {
+		return "", nil, err
+	}
+	footer, err := json.Marshal(pasetoFooter{KeyID: key.ID})
+	if err != nil {
+		return "", nil, err
+	}
+
+	return pasetoV4Sign(key.Key.(ed25519.PrivateKey), message, footer), payload, nil
+}
+
+// VerifyToken checks a v4.public token's signature with the key named in
+// its footer and returns the payload
+func (m *PASETOV4PublicMaker) VerifyToken(token string) (*TokenPayload, error) {
+	_, footer, err := pasetoDecode(pasetoV4PublicHeader, token)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var f pasetoFooter
+	if len(footer) > 0 && json.Unmarshal(footer, &f) != nil {
+		return nil, ErrInvalidToken
+	}
+
+	for _, key := range m.ring.candidates(f.KeyID, m.options.Clock.Now()) {
+		message, _, err := pasetoV4Verify(key.Key.Public().(ed25519.PublicKey), token)
+		if err != nil {
+			continue
+		}
+
+		payload, err := verifyPASETOV4Claims(message, m.options)
+		if err != nil {
+			return nil, err
+		}
+		if !key.signedInWindow(payload.IssuedAt, m.options.Leeway) {
+			return nil, ErrInvalidToken
+		}
+		return payload, nil
+	}
+
+	return nil, ErrInvalidToken
+}
+
+func verifyPASETOV4Claims(message []byte, opts TokenOptions) (*TokenPayload, error) {
+	var claims pasetoV4Claims
+	if err := json.Unmarshal(message, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	payload, err := claims.payload()
+	if err != nil {
+		return nil, err
+	}
+	if err := payload.Validate(opts); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// NewPASETOV4SymmetricKey generates a random key for v4.local tokens
+func NewPASETOV4SymmetricKey() ([]byte, error) {
+	key := make([]byte, symmetricKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// NewEd25519PrivateKeyPEM generates an Ed25519 key for v4.public tokens or
+// EdDSA JWTs, PEM encoded as PKCS #8
+func NewEd25519PrivateKeyPEM() ([]byte, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// pasetoV4Encrypt implements v4.local encryption with no implicit assertion.
+// nonce must be 32 random bytes.
+func pasetoV4Encrypt(key, nonce, message, footer []byte) (string, error) {
+	encKey, nonce2, authKey, err := pasetoV4SplitKey(key, nonce)
+	if err != nil {
+		return "", err
+	}
+
+	cipher, err := chacha20.NewUnauthenticatedCipher(encKey, nonce2)
+	if err != nil {
+		return "", err
+	}
+	ciphertext := make([]byte, len(message))
+	cipher.XORKeyStream(ciphertext, message)
+
+	tag, err := blake2bSum(authKey, pasetoPAE([]byte(pasetoV4LocalHeader), nonce, ciphertext, footer, nil), pasetoV4MACSize)
+	if err != nil {
+		return "", err
+	}
+
+	body := make([]byte, 0, len(nonce)+len(ciphertext)+len(tag))
+	body = append(append(append(body, nonce...), ciphertext...), tag...)
+	return pasetoEncode(pasetoV4LocalHeader, body, footer), nil
+}
+
+// pasetoV4Decrypt implements v4.local decryption with no implicit assertion
+func pasetoV4Decrypt(key []byte, token string) ([]byte, []byte, error) {
+	body, footer, err := pasetoDecode(pasetoV4LocalHeader, token)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(body) < pasetoV4NonceSize+pasetoV4MACSize {
+		return nil, nil, errors.New("token too short")
+	}
+
+	nonce := body[:pasetoV4NonceSize]
+	ciphertext := body[pasetoV4NonceSize : len(body)-pasetoV4MACSize]
+	tag := body[len(body)-pasetoV4MACSize:]
+
+	encKey, nonce2, authKey, err := pasetoV4SplitKey(key, nonce)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	expected, err := blake2bSum(authKey, pasetoPAE([]byte(pasetoV4LocalHeader), nonce, ciphertext, footer, nil), pasetoV4MACSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	if subtle.ConstantTimeCompare(tag, expected) != 1 {
+		return nil, nil, errors.New("invalid token tag")
+	}
+
+	cipher, err := chacha20.NewUnauthenticatedCipher(encKey, nonce2)
+	if err != nil {
+		return nil, nil, err
+	}
+	message := make([]byte, len(ciphertext))
+	cipher.XORKeyStream(message, ciphertext)
+	return message, footer, nil
+}
+
+// pasetoV4SplitKey derives the encryption key, XChaCha20 nonce and
+// authentication key for a v4.local nonce
+func pasetoV4SplitKey(key, nonce []byte) (encKey, nonce2, authKey []byte, err error) {
+	tmp, err := blake2bSum(key, append([]byte("paseto-encryption-key"), nonce...), 56)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	authKey, err = blake2bSum(key, append([]byte("paseto-auth-key-for-aead"), nonce...), 32)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return tmp[:32], tmp[32:], authKey, nil
+}
+
+// pasetoV4Sign implements v4.public signing with no implicit assertion
+func pasetoV4Sign(key ed25519.PrivateKey, message, footer []byte) string {
+	sig := ed25519.Sign(key, pasetoPAE([]byte(pasetoV4PublicHeader), message, footer, nil))
+	return pasetoEncode(pasetoV4PublicHeader, append(bytes.Clone(message), sig...), footer)
+}
+
+// pasetoV4Verify implements v4.public verification with no implicit assertion
+func pasetoV4Verify(key ed25519.PublicKey, token string) ([]byte, []byte, error) {
+	body, footer, err := pasetoDecode(pasetoV4PublicHeader, token)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(body) < ed25519.SignatureSize {
+		return nil, nil, errors.New("token too short")
+	}
+
+	message := body[:len(body)-ed25519.SignatureSize]
+	sig := body[len(body)-ed25519.SignatureSize:]
+	if !ed25519.Verify(key, pasetoPAE([]byte(pasetoV4PublicHeader), message, footer, nil), sig) {
+		return nil, nil, errors.New("invalid token signature")
+	}
+	return message, footer, nil
+}
+
+// pasetoPAE is PASETO's pre-authentication encoding
+func pasetoPAE(pieces ...[]byte) []byte {
+	var buf bytes.Buffer
+	le64 := func(n int) {
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], uint64(n)&^(1<<63))
+		buf.Write(b[:])
+	}
+
+	le64(len(pieces))
+	for _, piece := range pieces {
+		le64(len(piece))
+		buf.Write(piece)
+	}
+	return buf.Bytes()
+}
+
+func pasetoEncode(header string, body, footer []byte) string {
+	token := header + base64.RawURLEncoding.EncodeToString(body)
+	if len(footer) > 0 {
+		token += "." + base64.RawURLEncoding.EncodeToString(footer)
+	}
+	return token
+}
+
+func pasetoDecode(header, token string) (body, footer []byte, err error) {
+	rest, ok := strings.CutPrefix(token, header)
+	if !ok {
+		return nil, nil, errors.New("unexpected token header")
+	}
+
+	encodedBody, encodedFooter, hasFooter := strings.Cut(rest, ".")
+	if body, err = base64.RawURLEncoding.DecodeString(encodedBody); err != nil {
+		return nil, nil, err
+	}
+	if hasFooter {
+		if footer, err = base64.RawURLEncoding.DecodeString(encodedFooter); err != nil {
+			return nil, nil, err
+		}
+	}
+	return body, footer, nil
+}
+
+func blake2bSum(key, message []byte, size int) ([]byte, error) {
+	h, err := blake2b.New(size, key)
+	if err != nil {
+		return nil, err
+	}
+	h.Write(message)
+	return h.Sum(nil), nil
+}
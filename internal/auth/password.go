@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/pixperk/goiler/internal/config"
 	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -15,6 +16,21 @@ import (
 var (
 	ErrInvalidHash         = errors.New("invalid hash format")
 	ErrIncompatibleVersion = errors.New("incompatible argon2 version")
+	ErrWeakHashParams      = errors.New("password hashing parameters below minimum")
+)
+
+// Password hashing algorithms
+const (
+	HashAlgorithmArgon2id = "argon2id"
+	HashAlgorithmBcrypt   = "bcrypt"
+)
+
+// Minimum accepted hashing parameters (OWASP password storage guidance)
+const (
+	MinArgon2Memory      = 19 * 1024 // 19 MB
+	MinArgon2Iterations  = 2
+	MinArgon2Parallelism = 1
+	MinBcryptCost        = 10
 )
 
 // Argon2Params holds the parameters for Argon2id hashing
@@ -37,12 +53,67 @@ func DefaultArgon2Params() *Argon2Params {
 	}
 }
 
+// Validate checks the parameters against the accepted minimums
+func (p *Argon2Params) Validate() error {
+	if p.Memory < MinArgon2Memory {
+		return fmt.Errorf("%w: argon2 memory %d KiB < %d KiB", ErrWeakHashParams, p.Memory, MinArgon2Memory)
+	}
+	if p.Iterations < MinArgon2Iterations {
+		return fmt.Errorf("%w: argon2 iterations %d < %d", ErrWeakHashParams, p.Iterations, MinArgon2Iterations)
+	}
+	if p.Parallelism < MinArgon2Parallelism {
+		return fmt.Errorf("%w: argon2 parallelism %d < %d", ErrWeakHashParams, p.Parallelism, MinArgon2Parallelism)
+	}
+	return nil
+}
+
+// Argon2ParamsFromConfig builds Argon2id parameters from config and validates them
+func Argon2ParamsFromConfig(cfg config.HashConfig) (*Argon2Params, error) {
+	if cfg.Argon2Memory < 0 || cfg.Argon2Iterations < 0 || cfg.Argon2Parallelism < 0 || cfg.Argon2Parallelism > 255 {
+		return nil, fmt.Errorf("%w: argon2 parameters out of range", ErrWeakHashParams)
+	}
+
+	params := DefaultArgon2Params()
+	params.Memory = uint32(cfg.Argon2Memory)
+	params.Iterations = uint32(cfg.Argon2Iterations)
+	params.Parallelism = uint8(cfg.Argon2Parallelism)
+
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+	return params, nil
+}
+
+// ValidateBcryptCost checks a bcrypt cost against the accepted range
+func ValidateBcryptCost(cost int) error {
+	if cost < MinBcryptCost {
+		return fmt.Errorf("%w: bcrypt cost %d < %d", ErrWeakHashParams, cost, MinBcryptCost)
+	}
+	if cost > bcrypt.MaxCost {
+		return fmt.Errorf("bcrypt cost %d exceeds maximum %d", cost, bcrypt.MaxCost)
+	}
+	return nil
+}
+
 // PasswordHasher defines the interface for password hashing
 type PasswordHasher interface {
 	Hash(password string) (string, error)
 	Verify(password, hash string) (bool, error)
 }
 
+// RehashChecker is implemented by hashers that can tell when a hash was made
+// with another algorithm or weaker parameters than they use
+type RehashChecker interface {
+	NeedsRehash(hash string) bool
+}
+
+// NeedsRehash reports whether hash should be replaced with one made by
+// hasher. Hashers that don't implement RehashChecker never ask for it.
+func NeedsRehash(hasher PasswordHasher, hash string) bool {
+	checker, ok := hasher.(RehashChecker)
+	return ok && checker.NeedsRehash(hash)
+}
+
 // Argon2Hasher implements PasswordHasher using Argon2id
 type Argon2Hasher struct {
 	params *Argon2Params
@@ -113,6 +184,19 @@ func (h *Argon2Hasher) Verify(password, encodedHash string) (bool, error) {
 	return false, nil
 }
 
+// NeedsRehash reports whether hash isn't an Argon2id hash with the hasher's
+// parameters
+func (h *Argon2Hasher) NeedsRehash(hash string) bool {
+	params, _, _, err := decodeArgon2Hash(hash)
+	if err != nil {
+		return true
+	}
+	return params.Memory != h.params.Memory ||
+		params.Iterations != h.params.Iterations ||
+		params.Parallelism != h.params.Parallelism ||
+		params.KeyLength != h.params.KeyLength
+}
+
 // decodeArgon2Hash decodes an Argon2id hash string
 func decodeArgon2Hash(encodedHash string) (*Argon2Params, []byte, []byte, error) {
 	parts := strings.Split(encodedHash, "$")
@@ -188,6 +272,57 @@ func (h *BcryptHasher) Verify(password, hash string) (bool, error) {
 	return true, nil
 }
 
+// NeedsRehash reports whether hash isn't a bcrypt hash with the hasher's cost
+func (h *BcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != h.cost
+}
+
+// NewPasswordHasherFromConfig creates a hasher for the configured algorithm,
+// validating its parameters. The hasher verifies hashes made by either
+// algorithm, so existing passwords keep working when the algorithm changes,
+// and reports hashes made any other way as needing a rehash.
+func NewPasswordHasherFromConfig(cfg config.HashConfig) (PasswordHasher, error) {
+	switch cfg.Algorithm {
+	case "", HashAlgorithmArgon2id:
+		params, err := Argon2ParamsFromConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return anyHashVerifier{NewArgon2Hasher(params)}, nil
+	case HashAlgorithmBcrypt:
+		if err := ValidateBcryptCost(cfg.BcryptCost); err != nil {
+			return nil, err
+		}
+		return anyHashVerifier{NewBcryptHasher(cfg.BcryptCost)}, nil
+	default:
+		return nil, fmt.Errorf("unknown hash algorithm %q", cfg.Algorithm)
+	}
+}
+
+// anyHashVerifier hashes with its PasswordHasher and verifies Argon2id and
+// bcrypt hashes alike
+type anyHashVerifier struct {
+	PasswordHasher
+}
+
+// Verify verifies a password with the algorithm the hash was made with
+func (h anyHashVerifier) Verify(password, hash string) (bool, error) {
+	switch {
+	case strings.HasPrefix(hash, "$argon2id$"):
+		return (&Argon2Hasher{}).Verify(password, hash)
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		return (&BcryptHasher{}).Verify(password, hash)
+	}
+	return h.PasswordHasher.Verify(password, hash)
+}
+
+// NeedsRehash reports whether hash was made by another algorithm or with
+// other parameters than the configured ones
+func (h anyHashVerifier) NeedsRehash(hash string) bool {
+	return NeedsRehash(h.PasswordHasher, hash)
+}
+
 // DefaultPasswordHasher returns the recommended password hasher (Argon2id)
 func DefaultPasswordHasher() PasswordHasher {
 	return NewArgon2Hasher(DefaultArgon2Params())
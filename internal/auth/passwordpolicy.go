@@ -0,0 +1,298 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pixperk/goiler/internal/config"
+	"github.com/pixperk/goiler/pkg/budget"
+)
+
+// ErrWeakPassword is returned when a new password fails the password policy
+var ErrWeakPassword = errors.New("password does not meet the password policy")
+
+// Password policy violation codes
+const (
+	ViolationLowEntropy = "low_entropy"
+	ViolationDenylisted = "denylisted"
+	ViolationBreached   = "breached"
+)
+
+// DefaultPwnedPasswordsURL is the HaveIBeenPwned range API
+const DefaultPwnedPasswordsURL = "https://api.pwnedpasswords.com/range/"
+
+// commonPasswords are always denied, on top of the configured denylist
+var commonPasswords = []string{
+	"password", "password1", "password123", "passw0rd", "p@ssw0rd",
+	"12345678", "123456789", "1234567890", "87654321", "11111111",
+	"qwerty123", "qwertyuiop", "1q2w3e4r", "1qaz2wsx", "iloveyou",
+	"letmein1", "welcome1", "welcome123", "admin123", "changeme",
+	"football", "baseball", "sunshine", "princess", "trustno1",
+}
+
+// PasswordViolation is a rule a password broke
+type PasswordViolation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// PasswordPolicyError lists every rule a password broke. It matches
+// ErrWeakPassword with errors.Is.
+type PasswordPolicyError struct {
+	Violations []PasswordViolation
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return ErrWeakPassword.Error()
+}
+
+func (e *PasswordPolicyError) Unwrap() error {
+	return ErrWeakPassword
+}
+
+// Details returns the violation messages keyed by code, for error responses
+func (e *PasswordPolicyError) Details() map[string]string {
+	details := make(map[string]string, len(e.Violations))
+	for _, v := range e.Violations {
+		details[v.Code] = v.Message
+	}
+	return details
+}
+
+// BreachChecker reports how many times a password appears in known breaches
+type BreachChecker interface {
+	BreachCount(ctx context.Context, password string) (int, error)
+}
+
+// PwnedPasswords looks passwords up in the HaveIBeenPwned range API. Only
+// the first five hex characters of the password's SHA-1 leave the process
+// (k-anonymity); the matching suffixes are compared locally.
+type PwnedPasswords struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewPwnedPasswords creates a client for the range API at baseURL;
+// DefaultPwnedPasswordsURL if empty
+func NewPwnedPasswords(baseURL string, client *http.Client) *PwnedPasswords {
+	if baseURL == "" {
+		baseURL = DefaultPwnedPasswordsURL
+	}
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+	if client == nil {
+		client = budget.HTTP.Client()
+	}
+	return &PwnedPasswords{baseURL: baseURL, client: client}
+}
+
+// BreachCount implements BreachChecker
+func (p *PwnedPasswords) BreachCount(ctx context.Context, password string) (int, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.baseURL+prefix, nil)
+	if err != nil {
+		return 0, err
+	}
+	// Padding hides the real number of suffixes sharing the prefix
+	req.Header.Set("Add-Padding", "true")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return 0, fmt.Errorf("pwned passwords lookup failed: %s", resp.Status)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		candidate, count, ok := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !ok || !strings.EqualFold(candidate, suffix) {
+			continue
+		}
+		n, err := strconv.Atoi(count)
+		if err != nil {
+			return 0, fmt.Errorf("invalid pwned passwords count %q", count)
+		}
+		return n, nil
+	}
+	return 0, scanner.Err()
+}
+
+// PasswordPolicy decides whether a new password is strong enough. A nil
+// policy accepts every password.
+type PasswordPolicy struct {
+	// MinEntropy is the minimum estimated strength in bits; 0 disables the
+	// check. See PasswordEntropy for the estimate.
+	MinEntropy float64
+	// Denylist holds lowercased passwords that are always rejected
+	Denylist map[string]struct{}
+	// Breaches looks passwords up in known breaches; nil disables the check
+	Breaches BreachChecker
+	// BreachThreshold is how many breaches a password may appear in before
+	// it is rejected; 0 rejects any breached password
+	BreachThreshold int
+	// Logger records failed breach lookups; defaults to slog.Default
+	Logger *slog.Logger
+}
+
+// NewPasswordPolicy creates a policy requiring minEntropy bits and denying
+// the common passwords plus denylist
+func NewPasswordPolicy(minEntropy float64, denylist ...string) *PasswordPolicy {
+	p := &PasswordPolicy{
+		MinEntropy: minEntropy,
+		Denylist:   make(map[string]struct{}, len(commonPasswords)+len(denylist)),
+	}
+	for _, list := range [][]string{commonPasswords, denylist} {
+		for _, password := range list {
+			if password = strings.ToLower(strings.TrimSpace(password)); password != "" {
+				p.Denylist[password] = struct{}{}
+			}
+		}
+	}
+	return p
+}
+
+// PasswordPolicyFromConfig returns the password policy described by cfg,
+// reading the denylist file if one is set
+func PasswordPolicyFromConfig(cfg config.PasswordPolicyConfig) (*PasswordPolicy, error) {
+	if cfg.MinEntropy < 0 {
+		return nil, fmt.Errorf("password min entropy must not be negative, got %v", cfg.MinEntropy)
+	}
+	if cfg.BreachThreshold < 0 {
+		return nil, fmt.Errorf("password breach threshold must not be negative, got %d", cfg.BreachThreshold)
+	}
+
+	denylist := cfg.Denylist
+	if cfg.DenylistFile != "" {
+		data, err := os.ReadFile(cfg.DenylistFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read password denylist: %w", err)
+		}
+		denylist = append(denylist, strings.Split(string(data), "\n")...)
+	}
+
+	p := NewPasswordPolicy(cfg.MinEntropy, denylist...)
+	if cfg.BreachCheck {
+		client := budget.HTTP.Client()
+		client.Timeout = cfg.BreachCheckTimeout
+		p.Breaches = NewPwnedPasswords(cfg.BreachCheckURL, client)
+		p.BreachThreshold = cfg.BreachThreshold
+	}
+	return p, nil
+}
+
+// Check returns a *PasswordPolicyError listing every rule password breaks,
+// or nil if it passes. Breach lookups that fail are logged and the password
+// is accepted, so an outage of the lookup service doesn't block signups.
+func (p *PasswordPolicy) Check(ctx context.Context, password string) error {
+	if p == nil {
+		return nil
+	}
+
+	var violations []PasswordViolation
+	if _, denied := p.Denylist[strings.ToLower(password)]; denied {
+		violations = append(violations, PasswordViolation{
+			Code:    ViolationDenylisted,
+			Message: "Password is too common",
+		})
+	}
+	if p.MinEntropy > 0 && PasswordEntropy(password) < p.MinEntropy {
+		violations = append(violations, PasswordViolation{
+			Code:    ViolationLowEntropy,
+			Message: "Password is too easy to guess; use a longer password with a mix of character types",
+		})
+	}
+	if p.Breaches != nil {
+		count, err := p.Breaches.BreachCount(ctx, password)
+		if err != nil {
+			p.logger().WarnContext(ctx, "password breach lookup failed",
+				slog.String("error", err.Error()),
+			)
+		} else if count > p.BreachThreshold {
+			violations = append(violations, PasswordViolation{
+				Code:    ViolationBreached,
+				Message: "Password has appeared in a data breach",
+			})
+		}
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+func (p *PasswordPolicy) logger() *slog.Logger {
+	if p.Logger == nil {
+		return slog.Default()
+	}
+	return p.Logger
+}
+
+// PasswordEntropy estimates the strength of a password in bits as its
+// length times log2 of the size of the character classes it uses. Repeated
+// characters and runs like "abc" or "321" only count once, so padding a
+// weak password that way doesn't make it look strong.
+func PasswordEntropy(password string) float64 {
+	var lower, upper, digit, symbol, other bool
+	length := 0
+	var prev, step rune
+
+	for i, r := range []rune(password) {
+		switch {
+		case unicode.IsLower(r) && r < unicode.MaxASCII:
+			lower = true
+		case unicode.IsUpper(r) && r < unicode.MaxASCII:
+			upper = true
+		case unicode.IsDigit(r) && r < unicode.MaxASCII:
+			digit = true
+		case r < unicode.MaxASCII:
+			symbol = true
+		default:
+			other = true
+		}
+
+		delta := r - prev
+		if i == 0 || (delta != 0 && !((delta == 1 || delta == -1) && delta == step)) {
+			length++
+		}
+		if i > 0 {
+			step = delta
+		}
+		prev = r
+	}
+
+	pool := 0
+	for _, class := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.used {
+			pool += class.size
+		}
+	}
+	if pool == 0 {
+		return 0
+	}
+	return float64(length) * math.Log2(float64(pool))
+}
@@ -0,0 +1,633 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pixperk/goiler/db"
+	"github.com/pixperk/goiler/db/sqlc"
+	"github.com/pixperk/goiler/internal/tenant"
+	"github.com/pixperk/goiler/pkg/budget"
+)
+
+// Token store backends
+const (
+	TokenStoreNone     = "none"
+	TokenStorePostgres = "postgres"
+)
+
+// PostgresTokenRepository implements TokenRepository using PostgreSQL.
+// Refresh tokens are tracked as an allowlist: a token is valid only while
+// its row exists, hasn't expired, and hasn't been revoked.
+type PostgresTokenRepository struct {
+	queries *sqlc.Queries
+}
+
+// NewPostgresTokenRepository creates a new PostgreSQL token repository
+func NewPostgresTokenRepository(db *pgxpool.Pool) *PostgresTokenRepository {
+	return &PostgresTokenRepository{
+		queries: sqlc.New(db),
+	}
+}
+
+// StoreRefreshToken stores a refresh token
+func (r *PostgresTokenRepository) StoreRefreshToken(ctx context.Context, token *RefreshTokenInfo) error {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return r.queries.CreateRefreshToken(ctx, sqlc.CreateRefreshTokenParams{
+		ID:        token.ID,
+		UserID:    token.UserID,
+		ExpiresAt: sql.NullTime{Time: token.ExpiresAt, Valid: true},
+		FamilyID:  token.Family,
+		UserAgent: token.UserAgent,
+		IpAddress: token.IPAddress,
+	})
+}
+
+// RevokeRefreshToken revokes a refresh token
+func (r *PostgresTokenRepository) RevokeRefreshToken(ctx context.Context, tokenID uuid.UUID) error {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return r.queries.RevokeRefreshToken(ctx, tokenID)
+}
+
+// IsRefreshTokenRevoked checks if a refresh token is revoked. Tokens that
+// were never stored, or whose rows were purged, count as revoked.
+func (r *PostgresTokenRepository) IsRefreshTokenRevoked(ctx context.Context, tokenID uuid.UUID) (bool, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer cancel()
+
+	err = db.Retry(ctx, "auth.is_refresh_token_revoked", func(ctx context.Context) error {
+		_, err := r.queries.GetRefreshToken(ctx, tokenID)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return true, nil
+		}
+		return false, err
+	}
+	return false, nil
+}
+
+// RevokeAllUserTokens revokes all tokens for a user
+func (r *PostgresTokenRepository) RevokeAllUserTokens(ctx context.Context, userID uuid.UUID) error {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	// Revoking is idempotent, so it's safe to repeat after a dropped
+	// connection
+	return db.Retry(ctx, "auth.revoke_all_user_tokens", func(ctx context.Context) error {
+		return r.queries.RevokeAllUserRefreshTokens(ctx, userID)
+	})
+}
+
+// ListRefreshTokens returns a user's active refresh tokens, newest first
+func (r *PostgresTokenRepository) ListRefreshTokens(ctx context.Context, userID uuid.UUID) ([]*RefreshTokenInfo, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	rows, err := r.queries.ListActiveRefreshTokens(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := make([]*RefreshTokenInfo, len(rows))
+	for i, row := range rows {
+		tokens[i] = &RefreshTokenInfo{
+			ID:               row.ID,
+			UserID:           row.UserID,
+			Family:           row.FamilyID,
+			UserAgent:        row.UserAgent,
+			IPAddress:        row.IpAddress,
+			IssuedAt:         row.CreatedAt.Time,
+			ExpiresAt:        row.ExpiresAt.Time,
+			SessionStartedAt: row.SessionStartedAt.Time,
+		}
+	}
+	return tokens, nil
+}
+
+// RevokeRefreshTokenFamily revokes a user's active tokens in a family
+func (r *PostgresTokenRepository) RevokeRefreshTokenFamily(ctx context.Context, userID, family uuid.UUID) error {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	revoked, err := r.queries.RevokeRefreshTokenFamily(ctx, sqlc.RevokeRefreshTokenFamilyParams{
+		UserID:   userID,
+		FamilyID: family,
+	})
+	if err != nil {
+		return err
+	}
+	if revoked == 0 {
+		return ErrDeviceSessionNotFound
+	}
+	return nil
+}
+
+// PurgeExpiredRefreshTokens deletes expired and revoked refresh tokens and
+// returns the number of rows removed
+func (r *PostgresTokenRepository) PurgeExpiredRefreshTokens(ctx context.Context) (int64, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer cancel()
+
+	return r.queries.DeleteExpiredRefreshTokens(ctx)
+}
+
+// PostgresSessionStore implements SessionStore using the sessions table.
+// The email and role are read from the user on every lookup, so role
+// changes apply to existing sessions.
+type PostgresSessionStore struct {
+	queries *sqlc.Queries
+}
+
+// NewPostgresSessionStore creates a new PostgreSQL session store
+func NewPostgresSessionStore(db *pgxpool.Pool) *PostgresSessionStore {
+	return &PostgresSessionStore{
+		queries: sqlc.New(db),
+	}
+}
+
+// CreateSession implements SessionStore
+func (r *PostgresSessionStore) CreateSession(ctx context.Context, tokenHash string, session *Session) error {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	var orgID pgtype.UUID
+	if session.OrgID != nil {
+		orgID = pgtype.UUID{Bytes: *session.OrgID, Valid: true}
+	}
+	return r.queries.CreateSession(ctx, sqlc.CreateSessionParams{
+		ID:         session.ID,
+		UserID:     session.UserID,
+		TokenHash:  tokenHash,
+		CsrfToken:  session.CSRFToken,
+		UserAgent:  pgtype.Text{String: session.UserAgent, Valid: session.UserAgent != ""},
+		IpAddress:  session.IPAddress,
+		LastSeenAt: sql.NullTime{Time: session.LastSeenAt, Valid: true},
+		ExpiresAt:  sql.NullTime{Time: session.ExpiresAt, Valid: true},
+		OrgID:      orgID,
+	})
+}
+
+// GetSession implements SessionStore
+func (r *PostgresSessionStore) GetSession(ctx context.Context, tokenHash string) (*Session, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	row, err := r.queries.GetSessionByToken(ctx, tokenHash)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, err
+	}
+
+	session := &Session{
+		ID:         row.ID,
+		UserID:     row.UserID,
+		Email:      row.Email,
+		Role:       row.Role,
+		CSRFToken:  row.CsrfToken,
+		IPAddress:  row.IpAddress,
+		UserAgent:  row.UserAgent.String,
+		CreatedAt:  row.CreatedAt.Time,
+		LastSeenAt: row.LastSeenAt.Time,
+		ExpiresAt:  row.ExpiresAt.Time,
+		TenantID:   row.TenantID,
+	}
+	if row.OrgID.Valid {
+		orgID := uuid.UUID(row.OrgID.Bytes)
+		session.OrgID = &orgID
+	}
+	return session, nil
+}
+
+// TouchSession implements SessionStore
+func (r *PostgresSessionStore) TouchSession(ctx context.Context, tokenHash string, lastSeen time.Time) error {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return r.queries.TouchSession(ctx, sqlc.TouchSessionParams{
+		TokenHash:  tokenHash,
+		LastSeenAt: sql.NullTime{Time: lastSeen, Valid: true},
+	})
+}
+
+// DeleteSession implements SessionStore
+func (r *PostgresSessionStore) DeleteSession(ctx context.Context, tokenHash string) error {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return r.queries.DeleteSessionByToken(ctx, tokenHash)
+}
+
+// DeleteUserSessions implements SessionStore
+func (r *PostgresSessionStore) DeleteUserSessions(ctx context.Context, userID uuid.UUID) error {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return r.queries.DeleteUserSessions(ctx, userID)
+}
+
+// PostgresImpersonationStore implements ImpersonationStore using the
+// impersonations table
+type PostgresImpersonationStore struct {
+	queries *sqlc.Queries
+}
+
+// NewPostgresImpersonationStore creates a new PostgreSQL impersonation store
+func NewPostgresImpersonationStore(db *pgxpool.Pool) *PostgresImpersonationStore {
+	return &PostgresImpersonationStore{
+		queries: sqlc.New(db),
+	}
+}
+
+// CreateImpersonation implements ImpersonationStore
+func (r *PostgresImpersonationStore) CreateImpersonation(ctx context.Context, imp *Impersonation) error {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return r.queries.CreateImpersonation(ctx, sqlc.CreateImpersonationParams{
+		ID:        imp.ID,
+		AdminID:   pgtype.UUID{Bytes: imp.AdminID, Valid: true},
+		UserID:    imp.UserID,
+		Reason:    imp.Reason,
+		IpAddress: imp.IPAddress,
+		UserAgent: imp.UserAgent,
+		StartedAt: sql.NullTime{Time: imp.StartedAt, Valid: true},
+		ExpiresAt: sql.NullTime{Time: imp.ExpiresAt, Valid: true},
+	})
+}
+
+// GetImpersonation implements ImpersonationStore
+func (r *PostgresImpersonationStore) GetImpersonation(ctx context.Context, id uuid.UUID) (*Impersonation, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	row, err := r.queries.GetImpersonation(ctx, id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrImpersonationNotFound
+		}
+		return nil, err
+	}
+	return impersonationFromRow(row), nil
+}
+
+// EndImpersonation implements ImpersonationStore, ending impersonations of
+// users in the tenant scope of ctx
+func (r *PostgresImpersonationStore) EndImpersonation(ctx context.Context, id, endedBy uuid.UUID, endedAt time.Time) error {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	ended, err := r.queries.EndImpersonation(ctx, sqlc.EndImpersonationParams{
+		EndedAt:  pgtype.Timestamptz{Time: endedAt, Valid: true},
+		EndedBy:  pgtype.UUID{Bytes: endedBy, Valid: true},
+		ID:       id,
+		TenantID: tenant.ScopeParam(ctx),
+	})
+	if err != nil {
+		return err
+	}
+	if ended == 0 {
+		return ErrImpersonationNotFound
+	}
+	return nil
+}
+
+// ListImpersonations implements ImpersonationStore, listing impersonations
+// of users in the tenant scope of ctx
+func (r *PostgresImpersonationStore) ListImpersonations(ctx context.Context, limit, offset int) ([]*Impersonation, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	rows, err := r.queries.ListImpersonations(ctx, sqlc.ListImpersonationsParams{
+		TenantID: tenant.ScopeParam(ctx),
+		Lim:      int32(limit),
+		Off:      int32(offset),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	impersonations := make([]*Impersonation, len(rows))
+	for i, row := range rows {
+		impersonations[i] = impersonationFromRow(row)
+	}
+	return impersonations, nil
+}
+
+// impersonationFromRow converts a database row. AdminID is uuid.Nil once
+// the admin's account is deleted.
+func impersonationFromRow(row *sqlc.Impersonation) *Impersonation {
+	imp := &Impersonation{
+		ID:        row.ID,
+		UserID:    row.UserID,
+		Reason:    row.Reason,
+		IPAddress: row.IpAddress,
+		UserAgent: row.UserAgent,
+		StartedAt: row.StartedAt.Time,
+		ExpiresAt: row.ExpiresAt.Time,
+	}
+	if row.AdminID.Valid {
+		imp.AdminID = row.AdminID.Bytes
+	}
+	if row.EndedAt.Valid {
+		endedAt := row.EndedAt.Time
+		imp.EndedAt = &endedAt
+	}
+	if row.EndedBy.Valid {
+		endedBy := uuid.UUID(row.EndedBy.Bytes)
+		imp.EndedBy = &endedBy
+	}
+	return imp
+}
+
+// PostgresIncidentStore implements IncidentStore using the auth_incidents
+// table
+type PostgresIncidentStore struct {
+	db      *pgxpool.Pool
+	queries *sqlc.Queries
+}
+
+// NewPostgresIncidentStore creates a new PostgreSQL incident store
+func NewPostgresIncidentStore(db *pgxpool.Pool) *PostgresIncidentStore {
+	return &PostgresIncidentStore{
+		db:      db,
+		queries: sqlc.New(db),
+	}
+}
+
+// CreateIncident implements IncidentStore
+func (r *PostgresIncidentStore) CreateIncident(ctx context.Context, inc *Incident) error {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		q := r.queries.WithTx(tx)
+
+		var revoked int64
+		var err error
+		if inc.Role == "" {
+			revoked, err = q.RevokeAllRefreshTokens(ctx)
+		} else {
+			revoked, err = q.RevokeRoleRefreshTokens(ctx, inc.Role)
+		}
+		if err != nil {
+			return err
+		}
+
+		err = q.CreateAuthIncident(ctx, sqlc.CreateAuthIncidentParams{
+			ID:            inc.ID,
+			Role:          inc.Role,
+			Reason:        inc.Reason,
+			KeysRotated:   inc.RotatedKeys,
+			RevokedTokens: revoked,
+			DeclaredBy:    pgtype.UUID{Bytes: inc.DeclaredBy, Valid: true},
+			NotBefore:     sql.NullTime{Time: inc.NotBefore, Valid: true},
+		})
+		if err != nil {
+			return err
+		}
+		inc.RevokedTokens = revoked
+		return nil
+	})
+}
+
+// ListIncidents implements IncidentStore
+func (r *PostgresIncidentStore) ListIncidents(ctx context.Context, limit, offset int) ([]*Incident, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	rows, err := r.queries.ListAuthIncidents(ctx, sqlc.ListAuthIncidentsParams{
+		Limit:  int32(limit),
+		Offset: int32(offset),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	incidents := make([]*Incident, len(rows))
+	for i, row := range rows {
+		incidents[i] = &Incident{
+			ID:            row.ID,
+			Role:          row.Role,
+			Reason:        row.Reason,
+			RotatedKeys:   row.KeysRotated,
+			RevokedTokens: row.RevokedTokens,
+			NotBefore:     row.NotBefore.Time,
+		}
+		// DeclaredBy is uuid.Nil once the admin's account is deleted
+		if row.DeclaredBy.Valid {
+			incidents[i].DeclaredBy = row.DeclaredBy.Bytes
+		}
+	}
+	return incidents, nil
+}
+
+// TokenCutoffs implements IncidentStore
+func (r *PostgresIncidentStore) TokenCutoffs(ctx context.Context) (map[string]time.Time, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	var rows []*sqlc.ListTokenCutoffsRow
+	err = db.Retry(ctx, "auth.token_cutoffs", func(ctx context.Context) error {
+		rows, err = r.queries.ListTokenCutoffs(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	cutoffs := make(map[string]time.Time, len(rows))
+	for _, row := range rows {
+		cutoffs[row.Role] = row.NotBefore.Time
+	}
+	return cutoffs, nil
+}
+
+// KeyRotations implements IncidentStore
+func (r *PostgresIncidentStore) KeyRotations(ctx context.Context) ([]time.Time, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	var rows []sql.NullTime
+	err = db.Retry(ctx, "auth.key_rotations", func(ctx context.Context) error {
+		rows, err = r.queries.ListKeyRotations(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rotations := make([]time.Time, len(rows))
+	for i, row := range rows {
+		rotations[i] = row.Time
+	}
+	return rotations, nil
+}
+
+// auditEntityType marks auth events among the rows of audit_logs
+const auditEntityType = "auth"
+
+// PostgresAuditStore implements AuditSink and AuditReader using the
+// audit_logs table. Event details are stored as the row's new values.
+type PostgresAuditStore struct {
+	queries *sqlc.Queries
+}
+
+// NewPostgresAuditStore creates a new PostgreSQL audit store
+func NewPostgresAuditStore(db *pgxpool.Pool) *PostgresAuditStore {
+	return &PostgresAuditStore{
+		queries: sqlc.New(db),
+	}
+}
+
+// RecordAuditEvent implements AuditSink
+func (r *PostgresAuditStore) RecordAuditEvent(ctx context.Context, event *AuditEvent) error {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	var details json.RawMessage
+	if len(event.Details) > 0 {
+		if details, err = json.Marshal(event.Details); err != nil {
+			return err
+		}
+	}
+
+	params := sqlc.CreateAuditLogParams{
+		ID:         event.ID,
+		Action:     event.Type,
+		EntityType: auditEntityType,
+		NewValues:  details,
+		IpAddress:  event.IPAddress,
+		UserAgent:  pgtype.Text{String: event.UserAgent, Valid: event.UserAgent != ""},
+		CreatedAt:  sql.NullTime{Time: event.OccurredAt, Valid: true},
+	}
+	if event.UserID != nil {
+		params.UserID = pgtype.UUID{Bytes: *event.UserID, Valid: true}
+	}
+	return r.queries.CreateAuditLog(ctx, params)
+}
+
+// ListAuditEvents implements AuditReader, listing the events of users in
+// the tenant scope of ctx
+func (r *PostgresAuditStore) ListAuditEvents(ctx context.Context, filter AuditFilter) ([]*AuditEvent, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	params := sqlc.ListAuditLogsParams{
+		EntityType:  auditEntityType,
+		Action:      pgtype.Text{String: filter.Type, Valid: filter.Type != ""},
+		Since:       pgtype.Timestamptz{Time: filter.Since, Valid: !filter.Since.IsZero()},
+		TenantID:    tenant.ScopeParam(ctx),
+		MaxEntries:  int32(filter.Limit),
+		SkipEntries: int32(filter.Offset),
+	}
+	if filter.UserID != nil {
+		params.UserID = pgtype.UUID{Bytes: *filter.UserID, Valid: true}
+	}
+	rows, err := r.queries.ListAuditLogs(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]*AuditEvent, len(rows))
+	for i, row := range rows {
+		event := &AuditEvent{
+			ID:         row.ID,
+			Type:       row.Action,
+			IPAddress:  row.IpAddress,
+			UserAgent:  row.UserAgent.String,
+			OccurredAt: row.CreatedAt.Time,
+		}
+		if row.UserID.Valid {
+			userID := uuid.UUID(row.UserID.Bytes)
+			event.UserID = &userID
+		}
+		if len(row.NewValues) > 0 {
+			if err := json.Unmarshal(row.NewValues, &event.Details); err != nil {
+				return nil, err
+			}
+		}
+		events[i] = event
+	}
+	return events, nil
+}
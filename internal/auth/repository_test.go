@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// newTestTokenRepository returns a token repository on the Postgres at
+// TEST_DATABASE_URL, in a schema of its own holding the refresh token
+// migrations, and a user to issue tokens to
+func newTestTokenRepository(t *testing.T) (*PostgresTokenRepository, uuid.UUID) {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	ctx := context.Background()
+
+	schema := "goiler_test_" + uuid.NewString()[:8]
+	cfg, err := pgxpool.ParseConfig(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.ConnConfig.RuntimeParams["search_path"] = schema + ",public"
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() {
+		pool.Exec(context.Background(), "DROP SCHEMA "+schema+" CASCADE")
+		pool.Close()
+	})
+
+	if _, err := pool.Exec(ctx, "CREATE SCHEMA "+schema); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"000001_init_schema", "000003_refresh_token_store", "000010_refresh_token_sessions"} {
+		migration, err := os.ReadFile("../../db/migrations/" + name + ".up.sql")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := pool.Exec(ctx, string(migration)); err != nil {
+			t.Fatalf("Failed to apply %s: %v", name, err)
+		}
+	}
+
+	userID := uuid.New()
+	if _, err := pool.Exec(ctx, "INSERT INTO users (id, email, password_hash) VALUES ($1, 'user@example.com', 'hash')", userID); err != nil {
+		t.Fatalf("Failed to insert user: %v", err)
+	}
+	return NewPostgresTokenRepository(pool), userID
+}
+
+func TestPostgresTokenRepository(t *testing.T) {
+	repo, userID := newTestTokenRepository(t)
+	ctx := context.Background()
+
+	store := func(family uuid.UUID, expiresIn time.Duration) *RefreshTokenInfo {
+		t.Helper()
+		token := &RefreshTokenInfo{
+			ID:        uuid.New(),
+			UserID:    userID,
+			Family:    family,
+			UserAgent: "test",
+			IPAddress: "203.0.113.7",
+			ExpiresAt: time.Now().Add(expiresIn),
+		}
+		if err := repo.StoreRefreshToken(ctx, token); err != nil {
+			t.Fatalf("StoreRefreshToken failed: %v", err)
+		}
+		return token
+	}
+	revoked := func(token *RefreshTokenInfo) bool {
+		t.Helper()
+		revoked, err := repo.IsRefreshTokenRevoked(ctx, token.ID)
+		if err != nil {
+			t.Fatalf("IsRefreshTokenRevoked failed: %v", err)
+		}
+		return revoked
+	}
+
+	laptop := uuid.New()
+	first := store(laptop, time.Hour)
+	rotated := store(laptop, time.Hour)
+	phone := store(uuid.New(), time.Hour)
+	expired := store(uuid.New(), -time.Minute)
+
+	// The allowlist only holds stored, unexpired tokens
+	if revoked(first) || revoked(phone) {
+		t.Error("Expected stored tokens to be valid")
+	}
+	if !revoked(expired) || !revoked(&RefreshTokenInfo{ID: uuid.New()}) {
+		t.Error("Expected expired and unknown tokens to count as revoked")
+	}
+
+	tokens, err := repo.ListRefreshTokens(ctx, userID)
+	if err != nil {
+		t.Fatalf("ListRefreshTokens failed: %v", err)
+	}
+	if len(tokens) != 3 {
+		t.Fatalf("Expected the 3 active tokens, got %d", len(tokens))
+	}
+	started := make(map[uuid.UUID]time.Time)
+	for _, token := range tokens {
+		if token.IPAddress != "203.0.113.7" || token.UserAgent != "test" {
+			t.Errorf("Expected the device to be kept, got %+v", token)
+		}
+		started[token.ID] = token.SessionStartedAt
+	}
+	if !started[rotated.ID].Equal(started[first.ID]) {
+		t.Errorf("Expected the rotated token to keep its session's start %v, got %v", started[first.ID], started[rotated.ID])
+	}
+
+	if err := repo.RevokeRefreshTokenFamily(ctx, userID, laptop); err != nil {
+		t.Fatalf("RevokeRefreshTokenFamily failed: %v", err)
+	}
+	if !revoked(first) || !revoked(rotated) || revoked(phone) {
+		t.Error("Expected only the laptop's tokens to be revoked")
+	}
+	if err := repo.RevokeRefreshTokenFamily(ctx, userID, laptop); !errors.Is(err, ErrDeviceSessionNotFound) {
+		t.Errorf("Expected ErrDeviceSessionNotFound for a revoked family, got %v", err)
+	}
+
+	// Cleanup removes revoked and expired rows and keeps active ones
+	deleted, err := repo.PurgeExpiredRefreshTokens(ctx)
+	if err != nil {
+		t.Fatalf("PurgeExpiredRefreshTokens failed: %v", err)
+	}
+	if deleted != 3 {
+		t.Errorf("Expected the 2 revoked and 1 expired tokens to be purged, got %d", deleted)
+	}
+	if revoked(phone) {
+		t.Error("Expected the active token to survive cleanup")
+	}
+
+	if err := repo.RevokeAllUserTokens(ctx, userID); err != nil {
+		t.Fatalf("RevokeAllUserTokens failed: %v", err)
+	}
+	if !revoked(phone) {
+		t.Error("Expected every token to be revoked")
+	}
+	if tokens, _ := repo.ListRefreshTokens(ctx, userID); len(tokens) != 0 {
+		t.Errorf("Expected no active tokens, got %d", len(tokens))
+	}
+}
@@ -3,27 +3,42 @@ package auth
 import (
 	"context"
 	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/pixperk/goiler/internal/config"
+	"github.com/pixperk/goiler/internal/tenant"
+	"github.com/pixperk/goiler/pkg/authctx"
+	"github.com/pixperk/goiler/pkg/clock"
+	"github.com/pixperk/goiler/pkg/events"
+	"github.com/pixperk/goiler/pkg/idgen"
 )
 
 var (
-	ErrUserNotFound       = errors.New("user not found")
-	ErrInvalidCredentials = errors.New("invalid credentials")
-	ErrUserAlreadyExists  = errors.New("user already exists")
+	ErrUserNotFound        = errors.New("user not found")
+	ErrInvalidCredentials  = errors.New("invalid credentials")
+	ErrUserAlreadyExists   = errors.New("user already exists")
 	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrStepUpRequired      = errors.New("re-authentication required")
 )
 
 // User represents a user in the system
 type User struct {
-	ID           uuid.UUID `json:"id"`
-	Email        string    `json:"email"`
-	PasswordHash string    `json:"-"`
-	Role         string    `json:"role"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ID            uuid.UUID `json:"id"`
+	Email         string    `json:"email"`
+	PasswordHash  string    `json:"-"`
+	Role          string    `json:"role"`
+	TenantID      uuid.UUID `json:"tenant_id"`
+	EmailVerified bool      `json:"email_verified"`
+	Guest         bool      `json:"guest"` // no email or password until upgraded
+	Suspended     bool      `json:"-"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
 }
 
 // UserRepository defines the interface for user data access
@@ -32,19 +47,34 @@ type UserRepository interface {
 	GetByID(ctx context.Context, id uuid.UUID) (*User, error)
 	GetByEmail(ctx context.Context, email string) (*User, error)
 	Update(ctx context.Context, user *User) error
+	// UpdatePassword replaces a user's password hash while it's still
+	// oldHash, leaving the rest of the user untouched, and reports whether
+	// it did
+	UpdatePassword(ctx context.Context, id uuid.UUID, oldHash, newHash string) (bool, error)
 	Delete(ctx context.Context, id uuid.UUID) error
+	MarkEmailVerified(ctx context.Context, id uuid.UUID) error
+	// UpgradeGuest gives a guest user an email and password, returning
+	// ErrUserAlreadyExists if the email is registered
+	UpgradeGuest(ctx context.Context, id uuid.UUID, email, passwordHash string) error
 }
 
 // TokenRepository defines the interface for token blacklist/storage
 type TokenRepository interface {
-	// StoreRefreshToken stores a refresh token
-	StoreRefreshToken(ctx context.Context, tokenID uuid.UUID, userID uuid.UUID, expiresAt time.Time) error
+	// StoreRefreshToken stores a refresh token. A token joining an existing
+	// family inherits its session start time.
+	StoreRefreshToken(ctx context.Context, token *RefreshTokenInfo) error
 	// RevokeRefreshToken revokes a refresh token
 	RevokeRefreshToken(ctx context.Context, tokenID uuid.UUID) error
 	// IsRefreshTokenRevoked checks if a refresh token is revoked
 	IsRefreshTokenRevoked(ctx context.Context, tokenID uuid.UUID) (bool, error)
 	// RevokeAllUserTokens revokes all tokens for a user
 	RevokeAllUserTokens(ctx context.Context, userID uuid.UUID) error
+	// ListRefreshTokens returns a user's unrevoked, unexpired refresh
+	// tokens, newest first
+	ListRefreshTokens(ctx context.Context, userID uuid.UUID) ([]*RefreshTokenInfo, error)
+	// RevokeRefreshTokenFamily revokes a user's tokens in a family,
+	// returning ErrDeviceSessionNotFound if none were active
+	RevokeRefreshTokenFamily(ctx context.Context, userID, family uuid.UUID) error
 }
 
 // Service handles authentication business logic
@@ -55,6 +85,56 @@ type Service struct {
 	hasher        PasswordHasher
 	accessExpiry  time.Duration
 	refreshExpiry time.Duration
+	logger        *slog.Logger
+	clock         clock.Clock
+	ids           idgen.Generator
+
+	neutralResponses   bool
+	minFailureDuration time.Duration
+	dummyHashOnce      sync.Once
+	dummyHash          string
+
+	bindRefreshTokens bool
+	notifier          SecurityNotifier
+
+	guestAccounts bool
+
+	requireVerification bool
+	verificationExpiry  time.Duration
+	verificationURL     string
+	mailer              VerificationMailer
+
+	lockout  LockoutPolicy
+	attempts LoginAttemptStore
+
+	sessions *SessionManager
+
+	impersonations              ImpersonationStore
+	impersonationExpiry         time.Duration
+	impersonationProtectedRoles []string
+
+	incidents IncidentStore
+	cutoffs   atomic.Pointer[tokenCutoffs]
+
+	auditor     *Auditor
+	auditReader AuditReader
+
+	passwordPolicy *PasswordPolicy
+
+	loginAlerts    LoginAlertPolicy
+	knownCountries KnownCountryStore
+
+	events events.Publisher
+
+	roles Roles
+}
+
+// Roles tells which roles exist and what they grant, such as
+// rbac.Authorizer
+type Roles interface {
+	HasRole(role string) bool
+	// Covers reports whether role grants every permission other grants
+	Covers(role, other string) bool
 }
 
 // ServiceConfig holds service configuration
@@ -65,6 +145,184 @@ type ServiceConfig struct {
 	Hasher        PasswordHasher
 	AccessExpiry  time.Duration
 	RefreshExpiry time.Duration
+	Logger        *slog.Logger
+	// Clock stamps users and events and checks lockouts; defaults to the
+	// system clock. NewServiceFromConfig also hands it to the token maker.
+	Clock clock.Clock
+	// IDs generates user IDs; defaults to idgen.Default. NewServiceFromConfig
+	// also hands it to the token maker.
+	IDs idgen.Generator
+	// NeutralResponses hides whether an email is registered from
+	// registration responses
+	NeutralResponses bool
+	// MinFailureDuration pads failed logins and registrations so they take
+	// at least this long, evening out timing differences between failure paths
+	MinFailureDuration time.Duration
+	// BindRefreshTokens ties refresh tokens to the client fingerprint they
+	// were issued to; refreshes from a different client require a new login
+	BindRefreshTokens bool
+	// Notifier receives security events such as fingerprint mismatches
+	Notifier SecurityNotifier
+	// GuestAccounts allows CreateGuest
+	GuestAccounts bool
+	// RequireEmailVerification rejects logins until the email is verified
+	RequireEmailVerification bool
+	// VerificationExpiry is the lifetime of email verification tokens
+	VerificationExpiry time.Duration
+	// VerificationURL is where verification links point; the token is
+	// added as the "token" query parameter
+	VerificationURL string
+	// Mailer sends the welcome email with the verification link
+	Mailer VerificationMailer
+	// Lockout locks accounts and client IPs out after repeated failed logins
+	Lockout LockoutPolicy
+	// LoginAttempts tracks failed logins; defaults to an in-memory store
+	// when Lockout is enabled
+	LoginAttempts LoginAttemptStore
+	// Sessions switches the service to cookie sessions: logins start a
+	// server-side session instead of issuing tokens
+	Sessions *SessionManager
+	// Impersonations stores impersonation sessions; impersonation is
+	// unavailable without it
+	Impersonations ImpersonationStore
+	// ImpersonationExpiry is the lifetime of impersonation tokens
+	ImpersonationExpiry time.Duration
+	// ImpersonationProtectedRoles are roles that can't be impersonated
+	ImpersonationProtectedRoles []string
+	// Incidents stores security incidents; incident mode is unavailable
+	// without it
+	Incidents IncidentStore
+	// AuditSink records logins, logouts and token revocations; nothing is
+	// recorded without it
+	AuditSink AuditSink
+	// AuditReader serves the audit trail to admins
+	AuditReader AuditReader
+	// PasswordPolicy checks passwords on registration; every password is
+	// accepted without it. NewServiceFromConfig builds it from config unless
+	// one is set.
+	PasswordPolicy *PasswordPolicy
+	// LoginAlerts notifies users of repeated failed logins and logins from
+	// new countries, through Notifier
+	LoginAlerts LoginAlertPolicy
+	// KnownCountries remembers the countries users logged in from; defaults
+	// to an in-memory store when new country alerts are enabled
+	KnownCountries KnownCountryStore
+	// Events publishes account registrations, see events.UserCreated
+	Events events.Publisher
+	// Roles validates the roles incidents are limited to, and limits
+	// impersonation to users whose role grants no more than the admin's.
+	// Without it any incident role is accepted, and admins can only
+	// impersonate users with their own role.
+	Roles Roles
+}
+
+// ServiceOption customizes the ServiceConfig built by NewServiceFromConfig
+type ServiceOption func(*ServiceConfig)
+
+// WithLogger sets the logger used by the service
+func WithLogger(logger *slog.Logger) ServiceOption {
+	return func(cfg *ServiceConfig) {
+		cfg.Logger = logger
+	}
+}
+
+// WithClock sets the clock used by the service
+func WithClock(c clock.Clock) ServiceOption {
+	return func(cfg *ServiceConfig) {
+		cfg.Clock = c
+	}
+}
+
+// WithIDGenerator sets the generator for user and token IDs
+func WithIDGenerator(ids idgen.Generator) ServiceOption {
+	return func(cfg *ServiceConfig) {
+		cfg.IDs = ids
+	}
+}
+
+// WithHasher sets the password hasher used by the service
+func WithHasher(hasher PasswordHasher) ServiceOption {
+	return func(cfg *ServiceConfig) {
+		cfg.Hasher = hasher
+	}
+}
+
+// WithSecurityNotifier sets the notifier for security events
+func WithSecurityNotifier(notifier SecurityNotifier) ServiceOption {
+	return func(cfg *ServiceConfig) {
+		cfg.Notifier = notifier
+	}
+}
+
+// WithVerificationMailer sets the mailer for verification links
+func WithVerificationMailer(mailer VerificationMailer) ServiceOption {
+	return func(cfg *ServiceConfig) {
+		cfg.Mailer = mailer
+	}
+}
+
+// WithLoginAttemptStore sets the store used to track failed logins
+func WithLoginAttemptStore(store LoginAttemptStore) ServiceOption {
+	return func(cfg *ServiceConfig) {
+		cfg.LoginAttempts = store
+	}
+}
+
+// WithSessions sets the session manager used in cookie mode
+func WithSessions(sessions *SessionManager) ServiceOption {
+	return func(cfg *ServiceConfig) {
+		cfg.Sessions = sessions
+	}
+}
+
+// WithImpersonationStore sets the store for impersonation sessions
+func WithImpersonationStore(store ImpersonationStore) ServiceOption {
+	return func(cfg *ServiceConfig) {
+		cfg.Impersonations = store
+	}
+}
+
+// WithAuditSink sets the sink security events are recorded to
+func WithAuditSink(sink AuditSink) ServiceOption {
+	return func(cfg *ServiceConfig) {
+		cfg.AuditSink = sink
+	}
+}
+
+// WithAuditReader sets where the audit trail is read from
+func WithAuditReader(reader AuditReader) ServiceOption {
+	return func(cfg *ServiceConfig) {
+		cfg.AuditReader = reader
+	}
+}
+
+// WithPasswordPolicy sets the policy new passwords are checked against
+func WithPasswordPolicy(policy *PasswordPolicy) ServiceOption {
+	return func(cfg *ServiceConfig) {
+		cfg.PasswordPolicy = policy
+	}
+}
+
+// WithKnownCountryStore sets the store remembering login countries
+func WithKnownCountryStore(store KnownCountryStore) ServiceOption {
+	return func(cfg *ServiceConfig) {
+		cfg.KnownCountries = store
+	}
+}
+
+// WithEventPublisher sets where account registrations are published
+func WithEventPublisher(p events.Publisher) ServiceOption {
+	return func(cfg *ServiceConfig) {
+		cfg.Events = p
+	}
+}
+
+// WithRoles sets the roles incidents may be limited to and impersonation
+// is checked against
+func WithRoles(roles Roles) ServiceOption {
+	return func(cfg *ServiceConfig) {
+		cfg.Roles = roles
+	}
 }
 
 // NewService creates a new auth service
@@ -78,6 +336,32 @@ func NewService(cfg ServiceConfig) *Service {
 	if cfg.RefreshExpiry == 0 {
 		cfg.RefreshExpiry = 7 * 24 * time.Hour
 	}
+	if cfg.VerificationExpiry == 0 {
+		cfg.VerificationExpiry = 24 * time.Hour
+	}
+	if cfg.ImpersonationExpiry == 0 {
+		cfg.ImpersonationExpiry = 15 * time.Minute
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	cfg.Clock = clock.OrReal(cfg.Clock)
+	cfg.IDs = idgen.OrDefault(cfg.IDs)
+	// Failed login alerts count failures with the lockout's store
+	if !cfg.Lockout.Enabled() && cfg.LoginAlerts.FailedAttempts <= 0 {
+		cfg.LoginAttempts = nil
+	} else if cfg.LoginAttempts == nil {
+		cfg.LoginAttempts = NewMemoryLoginAttemptStore(cfg.Clock)
+	}
+	if !cfg.LoginAlerts.NewCountry {
+		cfg.KnownCountries = nil
+	} else if cfg.KnownCountries == nil {
+		cfg.KnownCountries = NewMemoryKnownCountryStore()
+	}
+	var auditor *Auditor
+	if cfg.AuditSink != nil {
+		auditor = NewAuditor(cfg.AuditSink, cfg.Logger, cfg.Clock, cfg.IDs)
+	}
 
 	return &Service{
 		userRepo:      cfg.UserRepo,
@@ -86,37 +370,117 @@ func NewService(cfg ServiceConfig) *Service {
 		hasher:        cfg.Hasher,
 		accessExpiry:  cfg.AccessExpiry,
 		refreshExpiry: cfg.RefreshExpiry,
+		logger:        cfg.Logger,
+		clock:         cfg.Clock,
+		ids:           cfg.IDs,
+
+		neutralResponses:   cfg.NeutralResponses,
+		minFailureDuration: cfg.MinFailureDuration,
+
+		bindRefreshTokens: cfg.BindRefreshTokens,
+		notifier:          cfg.Notifier,
+
+		guestAccounts: cfg.GuestAccounts,
+
+		requireVerification: cfg.RequireEmailVerification,
+		verificationExpiry:  cfg.VerificationExpiry,
+		verificationURL:     cfg.VerificationURL,
+		mailer:              cfg.Mailer,
+
+		lockout:  cfg.Lockout,
+		attempts: cfg.LoginAttempts,
+
+		sessions: cfg.Sessions,
+
+		impersonations:              cfg.Impersonations,
+		impersonationExpiry:         cfg.ImpersonationExpiry,
+		impersonationProtectedRoles: cfg.ImpersonationProtectedRoles,
+
+		incidents: cfg.Incidents,
+
+		auditor:     auditor,
+		auditReader: cfg.AuditReader,
+
+		passwordPolicy: cfg.PasswordPolicy,
+
+		loginAlerts:    cfg.LoginAlerts,
+		knownCountries: cfg.KnownCountries,
+
+		events: cfg.Events,
+
+		roles: cfg.Roles,
 	}
 }
 
 // NewServiceFromConfig creates a new auth service from config
-func NewServiceFromConfig(cfg *config.Config, userRepo UserRepository, tokenRepo TokenRepository) (*Service, error) {
-	var symmetricKey []byte
-	if cfg.Auth.PASETOSymmetricKey != "" {
-		symmetricKey = []byte(cfg.Auth.PASETOSymmetricKey)
-		// Pad or truncate to 32 bytes
-		if len(symmetricKey) < 32 {
-			padded := make([]byte, 32)
-			copy(padded, symmetricKey)
-			symmetricKey = padded
-		} else if len(symmetricKey) > 32 {
-			symmetricKey = symmetricKey[:32]
-		}
-	}
-
-	tokenMaker, err := NewTokenMaker(cfg.Auth.Type, cfg.Auth.JWTSecret, symmetricKey)
+func NewServiceFromConfig(cfg *config.Config, userRepo UserRepository, tokenRepo TokenRepository, opts ...ServiceOption) (*Service, error) {
+	hasher, err := NewPasswordHasherFromConfig(cfg.Auth.Hash)
 	if err != nil {
 		return nil, err
 	}
 
-	return NewService(ServiceConfig{
+	serviceCfg := ServiceConfig{
 		UserRepo:      userRepo,
 		TokenRepo:     tokenRepo,
-		TokenMaker:    tokenMaker,
-		Hasher:        DefaultPasswordHasher(),
+		Hasher:        hasher,
 		AccessExpiry:  cfg.Auth.JWTAccessExpiry,
 		RefreshExpiry: cfg.Auth.JWTRefreshExpiry,
-	}), nil
+
+		NeutralResponses:   cfg.Auth.NeutralResponses,
+		MinFailureDuration: cfg.Auth.MinFailureDuration,
+
+		BindRefreshTokens: cfg.Auth.BindRefreshTokens,
+		GuestAccounts:     cfg.Auth.GuestAccounts,
+
+		RequireEmailVerification: cfg.Auth.EmailVerification.Required,
+		VerificationExpiry:       cfg.Auth.EmailVerification.Expiry,
+		VerificationURL:          cfg.Auth.EmailVerification.URL,
+
+		Lockout:     LockoutPolicyFromConfig(cfg.Auth.Lockout),
+		LoginAlerts: LoginAlertPolicyFromConfig(cfg.Auth.LoginAlerts),
+
+		ImpersonationExpiry:         cfg.Auth.Impersonation.Expiry,
+		ImpersonationProtectedRoles: cfg.Auth.Impersonation.ProtectedRoles,
+	}
+	for _, opt := range opts {
+		opt(&serviceCfg)
+	}
+
+	if serviceCfg.PasswordPolicy == nil {
+		policy, err := PasswordPolicyFromConfig(cfg.Auth.PasswordPolicy)
+		if err != nil {
+			return nil, err
+		}
+		policy.Logger = serviceCfg.Logger
+		serviceCfg.PasswordPolicy = policy
+	}
+
+	switch cfg.Auth.Mode {
+	case "", AuthModeBearer:
+		serviceCfg.Sessions = nil
+	case AuthModeCookie:
+		if serviceCfg.Sessions == nil {
+			return nil, fmt.Errorf("auth mode %q requires a session store", cfg.Auth.Mode)
+		}
+	default:
+		return nil, fmt.Errorf("unknown auth mode %q", cfg.Auth.Mode)
+	}
+
+	// Created after the options are applied so tokens share the service's
+	// clock and ID generator
+	tokenMaker, err := NewTokenMakerFromConfig(cfg.Auth,
+		WithIssuer(cfg.Auth.TokenIssuer),
+		WithAudience(cfg.Auth.TokenAudience),
+		WithLeeway(cfg.Auth.TokenLeeway),
+		WithTokenClock(serviceCfg.Clock),
+		WithTokenIDs(serviceCfg.IDs),
+	)
+	if err != nil {
+		return nil, err
+	}
+	serviceCfg.TokenMaker = tokenMaker
+
+	return NewService(serviceCfg), nil
 }
 
 // RegisterRequest represents a registration request
@@ -135,33 +499,73 @@ type LoginRequest struct {
 // AuthResponse represents an authentication response
 type AuthResponse struct {
 	User         *UserResponse `json:"user"`
-	AccessToken  string        `json:"access_token"`
-	RefreshToken string        `json:"refresh_token"`
-	ExpiresAt    time.Time     `json:"expires_at"`
+	AccessToken  string        `json:"access_token,omitempty"`
+	RefreshToken string        `json:"refresh_token,omitempty"`
+	// ExpiresAt is when the access token expires, or in cookie mode when
+	// the session ends at the latest
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// CSRFToken is set in cookie mode; unsafe requests must send it in the
+	// CSRF header
+	CSRFToken string `json:"csrf_token,omitempty"`
+	// VerificationRequired is set instead of tokens when the user must
+	// verify their email before logging in
+	VerificationRequired bool `json:"verification_required,omitempty"`
+
+	cookies []*http.Cookie
+}
+
+// Cookies returns the session cookies to set in cookie mode
+func (r *AuthResponse) Cookies() []*http.Cookie {
+	return r.cookies
 }
 
 // UserResponse represents a user in API responses
 type UserResponse struct {
-	ID        uuid.UUID `json:"id"`
-	Email     string    `json:"email"`
-	Role      string    `json:"role"`
-	CreatedAt time.Time `json:"created_at"`
+	ID            uuid.UUID `json:"id"`
+	Email         string    `json:"email"`
+	Role          string    `json:"role"`
+	EmailVerified bool      `json:"email_verified"`
+	Guest         bool      `json:"guest,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// NeutralResponses reports whether registration responses should avoid
+// revealing whether an email is already registered
+func (s *Service) NeutralResponses() bool {
+	return s.neutralResponses
 }
 
-// Register creates a new user account
+// PasswordPolicy returns the policy new passwords are checked against, for
+// password changes made outside the service. It is nil when passwords
+// aren't checked, which is safe to check against.
+func (s *Service) PasswordPolicy() *PasswordPolicy {
+	return s.passwordPolicy
+}
+
+// Register creates a new user account. Passwords failing the password
+// policy are rejected with a *PasswordPolicyError.
 func (s *Service) Register(ctx context.Context, req *RegisterRequest) (*AuthResponse, error) {
-	// Check if user exists
-	existingUser, _ := s.userRepo.GetByEmail(ctx, req.Email)
-	if existingUser != nil {
-		return nil, ErrUserAlreadyExists
+	start := time.Now()
+
+	// Checked before the existence check, so the policy doesn't reveal
+	// whether an email is registered
+	if err := s.passwordPolicy.Check(ctx, req.Password); err != nil {
+		return nil, err
 	}
 
-	// Hash password
+	// Hash password before the existence check so both paths do the same work
 	passwordHash, err := s.hasher.Hash(req.Password)
 	if err != nil {
 		return nil, err
 	}
 
+	// Check if user exists
+	existingUser, _ := s.userRepo.GetByEmail(ctx, req.Email)
+	if existingUser != nil {
+		s.padFailure(ctx, start)
+		return nil, ErrUserAlreadyExists
+	}
+
 	// Set default role
 	role := req.Role
 	if role == "" {
@@ -170,37 +574,194 @@ func (s *Service) Register(ctx context.Context, req *RegisterRequest) (*AuthResp
 
 	// Create user
 	user := &User{
-		ID:           uuid.New(),
+		ID:           s.ids.NewID(),
 		Email:        req.Email,
 		PasswordHash: passwordHash,
 		Role:         role,
-		CreatedAt:    time.Now(),
-		UpdatedAt:    time.Now(),
+		CreatedAt:    s.clock.Now(),
+		UpdatedAt:    s.clock.Now(),
 	}
 
+	// The repository rejects the email with ErrUserAlreadyExists if another
+	// registration won the race past the check above
 	if err := s.userRepo.Create(ctx, user); err != nil {
+		if errors.Is(err, ErrUserAlreadyExists) {
+			s.padFailure(ctx, start)
+		}
 		return nil, err
 	}
 
+	s.logger.InfoContext(ctx, "user registered",
+		slog.String("user_id", user.ID.String()),
+	)
+	s.publishUserCreated(ctx, user)
+
+	s.sendVerificationEmail(ctx, user)
+
+	if s.requireVerification {
+		return &AuthResponse{User: newUserResponse(user), VerificationRequired: true}, nil
+	}
+
 	// Generate tokens
 	return s.generateTokenPair(ctx, user)
 }
 
+// publishUserCreated announces a new account on the event publisher
+func (s *Service) publishUserCreated(ctx context.Context, user *User) {
+	events.UserCreated.Publish(s.events, events.UserCreatedEvent{
+		UserID:    user.ID,
+		TenantID:  tenant.ID(ctx),
+		Guest:     user.Guest,
+		CreatedAt: user.CreatedAt,
+	})
+}
+
 // Login authenticates a user
 func (s *Service) Login(ctx context.Context, req *LoginRequest) (*AuthResponse, error) {
+	start := time.Now()
+
+	// Counted before the password is checked, so locked out guesses aren't
+	// verified, even in parallel. Unknown emails are tracked the same way,
+	// so lockouts don't reveal which emails are registered.
+	attempt, err := s.countLoginAttempt(ctx, req.Email)
+	if err != nil {
+		s.logger.WarnContext(ctx, "login failed", slog.String("reason", "locked out"))
+		s.auditor.Record(ctx, AuditLoginFailed, uuid.Nil, map[string]any{"email": req.Email, "reason": "locked_out"})
+		return nil, err
+	}
+
 	user, err := s.userRepo.GetByEmail(ctx, req.Email)
 	if err != nil {
+		// Verify against a dummy hash so unknown emails cost as much as wrong passwords
+		_, _ = s.hasher.Verify(req.Password, s.getDummyHash())
+		s.logger.WarnContext(ctx, "login failed", slog.String("reason", "unknown email"))
+		s.auditor.Record(ctx, AuditLoginFailed, uuid.Nil, map[string]any{"email": req.Email, "reason": "unknown_email"})
+		s.loginFailed(ctx, attempt)
+		s.padFailure(ctx, start)
 		return nil, ErrInvalidCredentials
 	}
 
 	valid, err := s.hasher.Verify(req.Password, user.PasswordHash)
 	if err != nil || !valid {
+		s.logger.WarnContext(ctx, "login failed",
+			slog.String("reason", "invalid password"),
+			slog.String("user_id", user.ID.String()),
+		)
+		s.auditor.Record(ctx, AuditLoginFailed, user.ID, map[string]any{"reason": "invalid_password"})
+		failures := s.loginFailed(ctx, attempt)
+		s.alertFailedLogins(ctx, user, failures)
+		s.padFailure(ctx, start)
 		return nil, ErrInvalidCredentials
 	}
+	s.loginSucceeded(ctx, attempt)
+	s.upgradePasswordHash(ctx, user, req.Password)
+
+	return s.completeLogin(ctx, user, "password")
+}
+
+// CompleteLogin issues a token pair for a user who authenticated by other
+// means, such as a passkey, applying the same checks and audit trail as a
+// password login. method names the means in the audit entry.
+func (s *Service) CompleteLogin(ctx context.Context, userID uuid.UUID, method string) (*AuthResponse, error) {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.completeLogin(ctx, user, method)
+}
+
+// completeLogin finishes the login of an authenticated user: suspended and,
+// when required, unverified accounts are turned away, and the outcome is
+// logged and audited
+func (s *Service) completeLogin(ctx context.Context, user *User, method string) (*AuthResponse, error) {
+	if user.Suspended {
+		s.logger.WarnContext(ctx, "login failed",
+			slog.String("reason", "account suspended"),
+			slog.String("user_id", user.ID.String()),
+		)
+		s.auditor.Record(ctx, AuditLoginFailed, user.ID, map[string]any{"method": method, "reason": "suspended"})
+		return nil, ErrAccountSuspended
+	}
+
+	// Checked after authentication so the response doesn't reveal anything
+	// about accounts the caller can't log in to
+	if s.requireVerification && !user.EmailVerified {
+		s.logger.WarnContext(ctx, "login failed",
+			slog.String("reason", "email not verified"),
+			slog.String("user_id", user.ID.String()),
+		)
+		s.auditor.Record(ctx, AuditLoginFailed, user.ID, map[string]any{"method": method, "reason": "email_not_verified"})
+		return nil, ErrEmailNotVerified
+	}
+
+	s.logger.InfoContext(ctx, "user logged in", slog.String("user_id", user.ID.String()))
+	s.auditor.Record(ctx, AuditLoginSucceeded, user.ID, map[string]any{"method": method})
+	s.alertNewCountry(ctx, user)
 
 	return s.generateTokenPair(ctx, user)
 }
 
+// upgradePasswordHash re-hashes a verified password with the configured
+// hasher when the stored hash was made with another algorithm or older
+// parameters, so hashing can be migrated without password resets. Failures
+// are logged; the old hash keeps working until the next login. The hash is
+// only replaced while it's still the one verified, so a password changed
+// meanwhile isn't reverted.
+func (s *Service) upgradePasswordHash(ctx context.Context, user *User, password string) {
+	if !NeedsRehash(s.hasher, user.PasswordHash) {
+		return
+	}
+
+	hash, err := s.hasher.Hash(password)
+	var updated bool
+	if err == nil {
+		updated, err = s.userRepo.UpdatePassword(ctx, user.ID, user.PasswordHash, hash)
+	}
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to upgrade password hash",
+			slog.String("user_id", user.ID.String()),
+			slog.String("error", err.Error()),
+		)
+		return
+	}
+	if !updated {
+		s.logger.InfoContext(ctx, "password changed before its hash was upgraded", slog.String("user_id", user.ID.String()))
+		return
+	}
+
+	user.PasswordHash = hash
+	s.logger.InfoContext(ctx, "password hash upgraded", slog.String("user_id", user.ID.String()))
+}
+
+// getDummyHash returns a hash of a random password made with the service's
+// hasher, used to spend the same verification time on unknown users
+func (s *Service) getDummyHash() string {
+	s.dummyHashOnce.Do(func() {
+		hash, err := s.hasher.Hash(uuid.NewString())
+		if err != nil {
+			s.logger.Error("failed to create dummy password hash", slog.String("error", err.Error()))
+			return
+		}
+		s.dummyHash = hash
+	})
+	return s.dummyHash
+}
+
+// padFailure waits until at least minFailureDuration has passed since start
+func (s *Service) padFailure(ctx context.Context, start time.Time) {
+	wait := s.minFailureDuration - time.Since(start)
+	if wait <= 0 {
+		return
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
 // RefreshToken refreshes the access token
 func (s *Service) RefreshToken(ctx context.Context, refreshToken string) (*AuthResponse, error) {
 	payload, err := s.tokenMaker.VerifyToken(refreshToken)
@@ -211,6 +772,13 @@ func (s *Service) RefreshToken(ctx context.Context, refreshToken string) (*AuthR
 	if payload.TokenType != RefreshToken {
 		return nil, ErrInvalidRefreshToken
 	}
+	if err := s.checkIncidents(payload); err != nil {
+		return nil, err
+	}
+	ctx, ok := payload.tenantContext(ctx)
+	if !ok {
+		return nil, ErrInvalidRefreshToken
+	}
 
 	// Check if token is revoked
 	if s.tokenRepo != nil {
@@ -225,13 +793,61 @@ func (s *Service) RefreshToken(ctx context.Context, refreshToken string) (*AuthR
 	if err != nil {
 		return nil, ErrUserNotFound
 	}
+	if user.Suspended {
+		return nil, ErrAccountSuspended
+	}
 
-	// Revoke old refresh token
+	if s.bindRefreshTokens && payload.Fingerprint != nil {
+		if err := s.checkFingerprint(ctx, payload, user); err != nil {
+			return nil, err
+		}
+	}
+
+	// The new token joins the old one's family. Tokens issued before
+	// families were tracked started their own.
+	family := payload.ID
+	if payload.Family != nil {
+		family = *payload.Family
+	}
+	result, err := s.issueTokenPair(ctx, user, family, payload.OrgID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Revoke old refresh token once its replacement is stored, so the
+	// family's start time carries over
 	if s.tokenRepo != nil {
 		_ = s.tokenRepo.RevokeRefreshToken(ctx, payload.ID)
 	}
 
-	return s.generateTokenPair(ctx, user)
+	return result, nil
+}
+
+// checkFingerprint compares the refreshing client against the fingerprint
+// bound to the refresh token. On a mismatch the token is revoked, the user
+// is notified, and ErrStepUpRequired is returned so the client logs in again.
+func (s *Service) checkFingerprint(ctx context.Context, payload *TokenPayload, user *User) error {
+	client, _ := ClientFromContext(ctx)
+	if payload.Fingerprint.Matches(client.Fingerprint()) {
+		return nil
+	}
+
+	s.logger.WarnContext(ctx, "refresh token used from a different client",
+		slog.String("user_id", user.ID.String()),
+		slog.String("token_id", payload.ID.String()),
+	)
+
+	if s.tokenRepo != nil {
+		_ = s.tokenRepo.RevokeRefreshToken(ctx, payload.ID)
+	}
+	s.auditor.Record(ctx, AuditTokenRevoked, user.ID, map[string]any{
+		"token_id": payload.ID.String(),
+		"reason":   "fingerprint_mismatch",
+	})
+
+	s.notify(ctx, user, SecurityEvent{Type: SecurityEventRefreshFingerprintMismatch})
+
+	return ErrStepUpRequired
 }
 
 // Logout invalidates the refresh token
@@ -242,36 +858,181 @@ func (s *Service) Logout(ctx context.Context, refreshToken string) error {
 	}
 
 	if s.tokenRepo != nil {
-		return s.tokenRepo.RevokeRefreshToken(ctx, payload.ID)
+		if err := s.tokenRepo.RevokeRefreshToken(ctx, payload.ID); err != nil {
+			return err
+		}
 	}
+	s.auditor.Record(ctx, AuditLogout, payload.UserID, nil)
 
 	return nil
 }
 
-// ValidateToken validates an access token and returns the payload
-func (s *Service) ValidateToken(token string) (*TokenPayload, error) {
-	return s.tokenMaker.VerifyToken(token)
+// ValidateToken validates an access token and returns the payload. Refresh
+// and verification tokens are rejected, as are impersonation tokens whose
+// impersonation has ended.
+func (s *Service) ValidateToken(ctx context.Context, token string) (*TokenPayload, error) {
+	payload, err := s.tokenMaker.VerifyToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if payload.TokenType != AccessToken {
+		return nil, ErrInvalidToken
+	}
+	if err := s.checkIncidents(payload); err != nil {
+		return nil, err
+	}
+	if payload.ImpersonatedBy != nil {
+		if err := s.checkImpersonation(ctx, payload); err != nil {
+			return nil, err
+		}
+	}
+	return payload, nil
+}
+
+// CookieMode reports whether the service issues cookie sessions instead of
+// tokens
+func (s *Service) CookieMode() bool {
+	return s.sessions != nil
 }
 
-// generateTokenPair generates access and refresh tokens
+// RefreshSession rotates the session identified by token, replacing its
+// secret and CSRF token
+func (s *Service) RefreshSession(ctx context.Context, token string) (*AuthResponse, error) {
+	session, newToken, err := s.sessions.Rotate(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkIncidents(session.Payload()); err != nil {
+		_ = s.sessions.Destroy(ctx, newToken)
+		return nil, err
+	}
+
+	user, err := s.userRepo.GetByID(ctx, session.UserID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	if user.Suspended {
+		return nil, ErrAccountSuspended
+	}
+	return s.sessionResponse(user, session, newToken), nil
+}
+
+// SwitchOrg reissues the caller's credentials acting in the organization
+// orgID. Bearer clients get a new token pair in their current family, and
+// the family's previous refresh tokens are revoked; in cookie mode the
+// request's session is rotated. Callers check membership
+// first, and impersonation tokens can't switch.
+func (s *Service) SwitchOrg(ctx context.Context, r *http.Request, orgID uuid.UUID) (*AuthResponse, error) {
+	if s.sessions != nil {
+		session, token, err := s.sessions.SwitchOrg(ctx, s.sessions.Token(r), orgID)
+		if err != nil {
+			return nil, err
+		}
+		user, err := s.userRepo.GetByID(ctx, session.UserID)
+		if err != nil {
+			return nil, ErrUserNotFound
+		}
+		return s.sessionResponse(user, session, token), nil
+	}
+
+	current, ok := authctx.FromContext(ctx)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	payload, ok := current.Payload.(*TokenPayload)
+	if !ok || payload.ImpersonatedBy != nil {
+		return nil, ErrInvalidToken
+	}
+
+	user, err := s.userRepo.GetByID(ctx, payload.UserID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	family := payload.ID
+	if payload.Family != nil {
+		family = *payload.Family
+	}
+
+	// The family's refresh tokens still act in the previous org
+	var previous []*RefreshTokenInfo
+	if s.tokenRepo != nil {
+		tokens, err := s.tokenRepo.ListRefreshTokens(ctx, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, token := range tokens {
+			if token.Family == family {
+				previous = append(previous, token)
+			}
+		}
+	}
+
+	result, err := s.issueTokenPair(ctx, user, family, &orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Revoke them once the replacement is stored, so the family's start
+	// time carries over, as RefreshToken does
+	for _, token := range previous {
+		if err := s.tokenRepo.RevokeRefreshToken(ctx, token.ID); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// EndSession deletes the session identified by token
+func (s *Service) EndSession(ctx context.Context, token string) error {
+	return s.sessions.Destroy(ctx, token)
+}
+
+// generateTokenPair generates access and refresh tokens, or starts a
+// session in cookie mode
 func (s *Service) generateTokenPair(ctx context.Context, user *User) (*AuthResponse, error) {
+	if s.sessions != nil {
+		session, token, err := s.sessions.Create(ctx, user)
+		if err != nil {
+			return nil, err
+		}
+		return s.sessionResponse(user, session, token), nil
+	}
+	return s.issueTokenPair(ctx, user, s.ids.NewID(), nil)
+}
+
+// issueTokenPair generates access and refresh tokens in a refresh token
+// family, acting in orgID if it's set. The access token carries the family
+// so it can be matched to its session.
+func (s *Service) issueTokenPair(ctx context.Context, user *User, family uuid.UUID, orgID *uuid.UUID) (*AuthResponse, error) {
+	opts := []PayloadOption{WithFamily(family), WithTenantID(user.TenantID), WithSandbox(ctx)}
+	if orgID != nil {
+		opts = append(opts, WithOrgID(*orgID))
+	}
+
 	accessToken, accessPayload, err := s.tokenMaker.CreateToken(
 		user.ID,
 		user.Email,
 		user.Role,
 		AccessToken,
 		s.accessExpiry,
+		opts...,
 	)
 	if err != nil {
 		return nil, err
 	}
 
+	client, ok := ClientFromContext(ctx)
+	if ok && s.bindRefreshTokens {
+		opts = append(opts, WithFingerprint(client.Fingerprint()))
+	}
+
 	refreshToken, refreshPayload, err := s.tokenMaker.CreateToken(
 		user.ID,
 		user.Email,
 		user.Role,
 		RefreshToken,
 		s.refreshExpiry,
+		opts...,
 	)
 	if err != nil {
 		return nil, err
@@ -279,21 +1040,44 @@ func (s *Service) generateTokenPair(ctx context.Context, user *User) (*AuthRespo
 
 	// Store refresh token
 	if s.tokenRepo != nil {
-		err = s.tokenRepo.StoreRefreshToken(ctx, refreshPayload.ID, user.ID, refreshPayload.ExpiresAt)
+		err = s.tokenRepo.StoreRefreshToken(ctx, &RefreshTokenInfo{
+			ID:        refreshPayload.ID,
+			UserID:    user.ID,
+			Family:    family,
+			UserAgent: client.UserAgent,
+			IPAddress: client.IPAddress,
+			IssuedAt:  refreshPayload.IssuedAt,
+			ExpiresAt: refreshPayload.ExpiresAt,
+		})
 		if err != nil {
 			return nil, err
 		}
 	}
 
 	return &AuthResponse{
-		User: &UserResponse{
-			ID:        user.ID,
-			Email:     user.Email,
-			Role:      user.Role,
-			CreatedAt: user.CreatedAt,
-		},
+		User:         newUserResponse(user),
 		AccessToken:  accessToken,
 		RefreshToken: refreshToken,
-		ExpiresAt:    accessPayload.ExpiresAt,
+		ExpiresAt:    &accessPayload.ExpiresAt,
 	}, nil
 }
+
+func (s *Service) sessionResponse(user *User, session *Session, token string) *AuthResponse {
+	return &AuthResponse{
+		User:      newUserResponse(user),
+		ExpiresAt: &session.ExpiresAt,
+		CSRFToken: session.CSRFToken,
+		cookies:   s.sessions.Cookies(session, token),
+	}
+}
+
+func newUserResponse(user *User) *UserResponse {
+	return &UserResponse{
+		ID:            user.ID,
+		Email:         user.Email,
+		Role:          user.Role,
+		EmailVerified: user.EmailVerified,
+		Guest:         user.Guest,
+		CreatedAt:     user.CreatedAt,
+	}
+}
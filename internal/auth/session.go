@@ -0,0 +1,490 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pixperk/goiler/internal/config"
+	"github.com/pixperk/goiler/pkg/clock"
+	"github.com/pixperk/goiler/pkg/idgen"
+	"github.com/redis/go-redis/v9"
+)
+
+// Authentication modes
+const (
+	// AuthModeBearer issues access and refresh tokens sent in the
+	// Authorization header
+	AuthModeBearer = "bearer"
+	// AuthModeCookie keeps sessions server-side and identifies them with an
+	// HttpOnly cookie, so browser apps never handle tokens
+	AuthModeCookie = "cookie"
+)
+
+// Session store backends
+const (
+	SessionStoreRedis    = "redis"
+	SessionStorePostgres = "postgres"
+)
+
+var (
+	// ErrSessionNotFound is returned for missing, expired and idle sessions
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrInvalidCSRFToken is returned when an unsafe request doesn't echo
+	// its session's CSRF token
+	ErrInvalidCSRFToken = errors.New("invalid CSRF token")
+)
+
+// Session is a server-side login session. The secret identifying it is only
+// sent to the client; stores key sessions by its hash.
+type Session struct {
+	ID         uuid.UUID `json:"id"`
+	UserID     uuid.UUID `json:"user_id"`
+	Email      string    `json:"email"`
+	Role       string    `json:"role"`
+	CSRFToken  string    `json:"csrf_token"`
+	IPAddress  string    `json:"ip_address,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	TenantID   uuid.UUID `json:"tenant_id"`
+	// OrgID is the organization the session is acting in, if any
+	OrgID *uuid.UUID `json:"org_id,omitempty"`
+}
+
+// Payload returns the session as the token payload handlers read the
+// current user from
+func (s *Session) Payload() *TokenPayload {
+	return &TokenPayload{
+		ID:        s.ID,
+		UserID:    s.UserID,
+		Email:     s.Email,
+		Role:      s.Role,
+		TenantID:  &s.TenantID,
+		OrgID:     s.OrgID,
+		TokenType: AccessToken,
+		IssuedAt:  s.CreatedAt,
+		NotBefore: s.CreatedAt,
+		ExpiresAt: s.ExpiresAt,
+	}
+}
+
+// SessionStore persists sessions by the hash of their secret
+type SessionStore interface {
+	// CreateSession stores a new session
+	CreateSession(ctx context.Context, tokenHash string, session *Session) error
+	// GetSession returns the session, or ErrSessionNotFound once it expired
+	GetSession(ctx context.Context, tokenHash string) (*Session, error)
+	// TouchSession records that the session was used at lastSeen
+	TouchSession(ctx context.Context, tokenHash string, lastSeen time.Time) error
+	// DeleteSession removes a session
+	DeleteSession(ctx context.Context, tokenHash string) error
+	// DeleteUserSessions removes all sessions of a user
+	DeleteUserSessions(ctx context.Context, userID uuid.UUID) error
+}
+
+// SessionPolicy decides how long sessions last and how their cookies are set
+type SessionPolicy struct {
+	// IdleTimeout expires sessions that haven't been used for this long
+	IdleTimeout time.Duration
+	// MaxAge is the absolute lifetime of a session, however active
+	MaxAge time.Duration
+
+	CookieName   string
+	CookieDomain string
+	CookiePath   string
+	CookieSecure bool
+	// SameSite is "lax", "strict" or "none"
+	SameSite string
+
+	// CSRFCookieName is the cookie scripts read the CSRF token from; it is
+	// not HttpOnly
+	CSRFCookieName string
+	// CSRFHeader is the header unsafe requests must send the CSRF token in
+	CSRFHeader string
+}
+
+// SessionPolicyFromConfig returns the session policy described by cfg
+func SessionPolicyFromConfig(cfg config.SessionConfig) SessionPolicy {
+	return SessionPolicy{
+		IdleTimeout:    cfg.IdleTimeout,
+		MaxAge:         cfg.MaxAge,
+		CookieName:     cfg.CookieName,
+		CookieDomain:   cfg.CookieDomain,
+		CookiePath:     cfg.CookiePath,
+		CookieSecure:   cfg.CookieSecure,
+		SameSite:       cfg.SameSite,
+		CSRFCookieName: cfg.CSRFCookieName,
+		CSRFHeader:     cfg.CSRFHeader,
+	}
+}
+
+// Validate checks that the policy is usable
+func (p SessionPolicy) Validate() error {
+	if p.IdleTimeout <= 0 {
+		return fmt.Errorf("session idle timeout must be positive")
+	}
+	if p.MaxAge < p.IdleTimeout {
+		return fmt.Errorf("session max age %s is shorter than idle timeout %s", p.MaxAge, p.IdleTimeout)
+	}
+	if p.CookieName == "" || p.CSRFCookieName == "" || p.CSRFHeader == "" {
+		return fmt.Errorf("session cookie names and CSRF header must be set")
+	}
+	if p.CookieName == p.CSRFCookieName {
+		return fmt.Errorf("session and CSRF cookies must have different names")
+	}
+	switch strings.ToLower(p.SameSite) {
+	case "lax", "strict":
+	case "none":
+		// Browsers drop SameSite=None cookies that aren't Secure
+		if !p.CookieSecure {
+			return fmt.Errorf("SameSite=None session cookies must be secure")
+		}
+	default:
+		return fmt.Errorf("unknown session cookie SameSite mode %q", p.SameSite)
+	}
+	return nil
+}
+
+func (p SessionPolicy) sameSite() http.SameSite {
+	switch strings.ToLower(p.SameSite) {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	}
+	return http.SameSiteLaxMode
+}
+
+// SessionManager creates and validates cookie sessions. Sessions slide: each
+// use extends them by IdleTimeout, up to MaxAge after they were created.
+type SessionManager struct {
+	store  SessionStore
+	policy SessionPolicy
+	clock  clock.Clock
+	ids    idgen.Generator
+}
+
+// NewSessionManager creates a session manager
+func NewSessionManager(store SessionStore, policy SessionPolicy, clk clock.Clock, ids idgen.Generator) *SessionManager {
+	return &SessionManager{
+		store:  store,
+		policy: policy,
+		clock:  clock.OrReal(clk),
+		ids:    idgen.OrDefault(ids),
+	}
+}
+
+// Create starts a session for user and returns it with its secret
+func (m *SessionManager) Create(ctx context.Context, user *User) (*Session, string, error) {
+	now := m.clock.Now()
+	session := &Session{
+		UserID:    user.ID,
+		Email:     user.Email,
+		Role:      user.Role,
+		TenantID:  user.TenantID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(m.policy.MaxAge),
+	}
+	if client, ok := ClientFromContext(ctx); ok {
+		session.IPAddress = client.IPAddress
+		session.UserAgent = client.UserAgent
+	}
+	return m.start(ctx, session)
+}
+
+// start stores session under a new ID, secret and CSRF token
+func (m *SessionManager) start(ctx context.Context, session *Session) (*Session, string, error) {
+	token, err := randomSessionToken()
+	if err != nil {
+		return nil, "", err
+	}
+	csrf, err := randomSessionToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	session.ID = m.ids.NewID()
+	session.CSRFToken = csrf
+	session.LastSeenAt = m.clock.Now()
+
+	if err := m.store.CreateSession(ctx, hashSessionToken(token), session); err != nil {
+		return nil, "", err
+	}
+	return session, token, nil
+}
+
+// Validate returns the session identified by token, expiring it if it has
+// been idle too long and recording the use otherwise
+func (m *SessionManager) Validate(ctx context.Context, token string) (*Session, error) {
+	if token == "" {
+		return nil, ErrSessionNotFound
+	}
+
+	hash := hashSessionToken(token)
+	session, err := m.store.GetSession(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	now := m.clock.Now()
+	if !now.Before(session.ExpiresAt) || now.Sub(session.LastSeenAt) >= m.policy.IdleTimeout {
+		_ = m.store.DeleteSession(ctx, hash)
+		return nil, ErrSessionNotFound
+	}
+
+	// Touches are throttled so busy sessions don't write on every request
+	if now.Sub(session.LastSeenAt) >= m.policy.IdleTimeout/100 {
+		if err := m.store.TouchSession(ctx, hash, now); err == nil {
+			session.LastSeenAt = now
+		}
+	}
+	return session, nil
+}
+
+// Authenticate returns the session of the request's cookie. Unsafe
+// requests must also send the session's CSRF token in the CSRF header.
+func (m *SessionManager) Authenticate(r *http.Request) (*Session, string, error) {
+	token := m.Token(r)
+	session, err := m.Validate(r.Context(), token)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := m.CheckCSRF(r, session); err != nil {
+		return nil, "", err
+	}
+	return session, token, nil
+}
+
+// CheckCSRF checks that unsafe requests echo the session's CSRF token
+func (m *SessionManager) CheckCSRF(r *http.Request, session *Session) error {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return nil
+	}
+
+	header := r.Header.Get(m.policy.CSRFHeader)
+	if header == "" || subtle.ConstantTimeCompare([]byte(header), []byte(session.CSRFToken)) != 1 {
+		return ErrInvalidCSRFToken
+	}
+	return nil
+}
+
+// Rotate replaces the session identified by token with a new one for the
+// same user, with a new secret and CSRF token. The absolute expiry is kept.
+func (m *SessionManager) Rotate(ctx context.Context, token string) (*Session, string, error) {
+	return m.rotate(ctx, token, func(*Session) {})
+}
+
+// SwitchOrg rotates the session identified by token into one acting in the
+// organization orgID
+func (m *SessionManager) SwitchOrg(ctx context.Context, token string, orgID uuid.UUID) (*Session, string, error) {
+	return m.rotate(ctx, token, func(s *Session) {
+		s.OrgID = &orgID
+	})
+}
+
+// rotate replaces the session identified by token with a copy changed by
+// update
+func (m *SessionManager) rotate(ctx context.Context, token string, update func(*Session)) (*Session, string, error) {
+	old, err := m.Validate(ctx, token)
+	if err != nil {
+		return nil, "", err
+	}
+
+	session := *old
+	update(&session)
+	rotated, newToken, err := m.start(ctx, &session)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := m.store.DeleteSession(ctx, hashSessionToken(token)); err != nil {
+		return nil, "", err
+	}
+	return rotated, newToken, nil
+}
+
+// Destroy ends the session identified by token
+func (m *SessionManager) Destroy(ctx context.Context, token string) error {
+	if token == "" {
+		return nil
+	}
+	return m.store.DeleteSession(ctx, hashSessionToken(token))
+}
+
+// DestroyUser ends every session of a user
+func (m *SessionManager) DestroyUser(ctx context.Context, userID uuid.UUID) error {
+	return m.store.DeleteUserSessions(ctx, userID)
+}
+
+// Token returns the session secret from the request's cookie
+func (m *SessionManager) Token(r *http.Request) string {
+	cookie, err := r.Cookie(m.policy.CookieName)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+// Cookies returns the cookies that hand session to the browser: the
+// HttpOnly session cookie and the CSRF cookie scripts read the token from
+func (m *SessionManager) Cookies(session *Session, token string) []*http.Cookie {
+	maxAge := int(session.ExpiresAt.Sub(m.clock.Now()).Seconds())
+	return []*http.Cookie{
+		m.cookie(m.policy.CookieName, token, maxAge, true),
+		m.cookie(m.policy.CSRFCookieName, session.CSRFToken, maxAge, false),
+	}
+}
+
+// ClearCookies returns cookies that remove the session from the browser
+func (m *SessionManager) ClearCookies() []*http.Cookie {
+	return []*http.Cookie{
+		m.cookie(m.policy.CookieName, "", -1, true),
+		m.cookie(m.policy.CSRFCookieName, "", -1, false),
+	}
+}
+
+func (m *SessionManager) cookie(name, value string, maxAge int, httpOnly bool) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     m.policy.CookiePath,
+		Domain:   m.policy.CookieDomain,
+		MaxAge:   maxAge,
+		Secure:   m.policy.CookieSecure,
+		HttpOnly: httpOnly,
+		SameSite: m.policy.sameSite(),
+	}
+}
+
+func randomSessionToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashSessionToken hashes a session secret so stores never hold usable
+// secrets
+func hashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// RedisSessionStore keeps sessions in Redis. Each session is a JSON value
+// expiring with the session; a set per user indexes their sessions.
+type RedisSessionStore struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// NewRedisSessionStore creates a Redis-backed session store
+func NewRedisSessionStore(client redis.UniversalClient) *RedisSessionStore {
+	return &RedisSessionStore{client: client, prefix: "goiler:session:"}
+}
+
+// CreateSession implements SessionStore
+func (s *RedisSessionStore) CreateSession(ctx context.Context, tokenHash string, session *Session) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	ttl := time.Until(session.ExpiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+
+	userKey := s.userKey(session.UserID)
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, s.prefix+tokenHash, data, ttl)
+		pipe.SAdd(ctx, userKey, tokenHash)
+		// The index lives as long as the user's longest session: NX sets
+		// the TTL of a new index and GT only ever extends it
+		pipe.ExpireNX(ctx, userKey, ttl)
+		pipe.ExpireGT(ctx, userKey, ttl)
+		return nil
+	})
+	return err
+}
+
+// GetSession implements SessionStore
+func (s *RedisSessionStore) GetSession(ctx context.Context, tokenHash string) (*Session, error) {
+	data, err := s.client.Get(ctx, s.prefix+tokenHash).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, err
+	}
+
+	var session Session
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, fmt.Errorf("decode session: %w", err)
+	}
+	return &session, nil
+}
+
+// TouchSession implements SessionStore
+func (s *RedisSessionStore) TouchSession(ctx context.Context, tokenHash string, lastSeen time.Time) error {
+	session, err := s.GetSession(ctx, tokenHash)
+	if err != nil {
+		return err
+	}
+	session.LastSeenAt = lastSeen
+
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	return s.client.SetArgs(ctx, s.prefix+tokenHash, data, redis.SetArgs{Mode: "XX", KeepTTL: true}).Err()
+}
+
+// DeleteSession implements SessionStore
+func (s *RedisSessionStore) DeleteSession(ctx context.Context, tokenHash string) error {
+	session, err := s.GetSession(ctx, tokenHash)
+	if err != nil {
+		if errors.Is(err, ErrSessionNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, s.prefix+tokenHash)
+		pipe.SRem(ctx, s.userKey(session.UserID), tokenHash)
+		return nil
+	})
+	return err
+}
+
+// DeleteUserSessions implements SessionStore
+func (s *RedisSessionStore) DeleteUserSessions(ctx context.Context, userID uuid.UUID) error {
+	userKey := s.userKey(userID)
+	hashes, err := s.client.SMembers(ctx, userKey).Result()
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(hashes)+1)
+	for _, hash := range hashes {
+		keys = append(keys, s.prefix+hash)
+	}
+	keys = append(keys, userKey)
+	return s.client.Del(ctx, keys...).Err()
+}
+
+func (s *RedisSessionStore) userKey(userID uuid.UUID) string {
+	return s.prefix + "user:" + userID.String()
+}
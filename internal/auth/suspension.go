@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/pkg/response"
+)
+
+// ErrAccountSuspended is returned when a suspended user logs in or
+// refreshes their credentials
+var ErrAccountSuspended = errors.New("account suspended")
+
+// ErrCodeAccountSuspended is the error code of logins rejected because the
+// account is suspended
+const ErrCodeAccountSuspended = "ACCOUNT_SUSPENDED"
+
+// AccountSuspended responds 403 ACCOUNT_SUSPENDED
+func AccountSuspended(c echo.Context) error {
+	return response.Error(c, http.StatusForbidden, ErrCodeAccountSuspended, "This account has been suspended")
+}
+
+// RevokeUserTokens signs a user out everywhere: every refresh token is
+// revoked and every cookie session ended, and the revocation is audited
+// with reason. Access tokens already issued expire on their own.
+func (s *Service) RevokeUserTokens(ctx context.Context, userID uuid.UUID, reason string) error {
+	if s.tokenRepo != nil {
+		if err := s.tokenRepo.RevokeAllUserTokens(ctx, userID); err != nil {
+			return err
+		}
+	}
+	if s.sessions != nil {
+		if err := s.sessions.DestroyUser(ctx, userID); err != nil {
+			return err
+		}
+	}
+
+	s.logger.WarnContext(ctx, "user tokens revoked",
+		slog.String("user_id", userID.String()),
+		slog.String("reason", reason),
+	)
+	s.auditor.Record(ctx, AuditTokenRevoked, userID, map[string]any{"reason": reason})
+	return nil
+}
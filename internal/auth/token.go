@@ -1,15 +1,28 @@
 package auth
 
 import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
 	"errors"
+	"fmt"
+	"os"
+	"slices"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/pixperk/goiler/internal/config"
+	"github.com/pixperk/goiler/internal/tenant"
+	"github.com/pixperk/goiler/pkg/authctx"
+	"github.com/pixperk/goiler/pkg/clock"
+	"github.com/pixperk/goiler/pkg/idgen"
+	"github.com/pixperk/goiler/pkg/sandbox"
 )
 
 var (
-	ErrExpiredToken = errors.New("token has expired")
-	ErrInvalidToken = errors.New("token is invalid")
+	ErrExpiredToken     = errors.New("token has expired")
+	ErrInvalidToken     = errors.New("token is invalid")
+	ErrTokenNotYetValid = errors.New("token is not valid yet")
 )
 
 // TokenType represents the type of token
@@ -18,6 +31,11 @@ type TokenType string
 const (
 	AccessToken  TokenType = "access"
 	RefreshToken TokenType = "refresh"
+	// EmailVerificationToken is sent in verification links
+	EmailVerificationToken TokenType = "email_verification"
+	// SecureAccountToken is sent in security notifications and signs the
+	// account out everywhere
+	SecureAccountToken TokenType = "secure_account"
 )
 
 // TokenPayload contains the token claims
@@ -27,8 +45,166 @@ type TokenPayload struct {
 	Email     string    `json:"email"`
 	Role      string    `json:"role"`
 	TokenType TokenType `json:"token_type"`
+	Issuer    string    `json:"issuer,omitempty"`
+	Audience  []string  `json:"audience,omitempty"`
 	IssuedAt  time.Time `json:"issued_at"`
+	NotBefore time.Time `json:"not_before"`
 	ExpiresAt time.Time `json:"expires_at"`
+
+	// Fingerprint binds a refresh token to the client it was issued to
+	Fingerprint *Fingerprint `json:"fingerprint,omitempty"`
+	// ImpersonatedBy is the admin acting as the user, set on impersonation
+	// tokens
+	ImpersonatedBy *uuid.UUID `json:"impersonated_by,omitempty"`
+	// Family groups tokens that are revoked together; impersonation tokens
+	// carry the ID of their impersonation session
+	Family *uuid.UUID `json:"family,omitempty"`
+	// TenantID is the tenant the user belongs to. Tokens issued before
+	// tenancy don't carry it and belong to the default tenant.
+	TenantID *uuid.UUID `json:"tenant_id,omitempty"`
+	// OrgID is the organization the user is acting in, chosen by switching
+	// orgs. It only selects the org; membership is checked where it's used.
+	OrgID *uuid.UUID `json:"org_id,omitempty"`
+	// Sandbox marks tokens issued in sandbox mode, for a sandbox tenant
+	Sandbox bool `json:"sandbox,omitempty"`
+}
+
+// Tenant returns the tenant the token was issued for
+func (p *TokenPayload) Tenant() uuid.UUID {
+	if p.TenantID == nil {
+		return tenant.DefaultID
+	}
+	return tenant.Normalize(*p.TenantID)
+}
+
+// PayloadOption sets optional claims on a new token payload
+type PayloadOption func(*TokenPayload)
+
+// WithFingerprint binds the token to a client fingerprint
+func WithFingerprint(fp *Fingerprint) PayloadOption {
+	return func(p *TokenPayload) {
+		p.Fingerprint = fp
+	}
+}
+
+// WithImpersonatedBy marks the token as issued to an admin acting as the user
+func WithImpersonatedBy(adminID uuid.UUID) PayloadOption {
+	return func(p *TokenPayload) {
+		p.ImpersonatedBy = &adminID
+	}
+}
+
+// WithFamily adds the token to a family revoked as a whole
+func WithFamily(family uuid.UUID) PayloadOption {
+	return func(p *TokenPayload) {
+		p.Family = &family
+	}
+}
+
+// tenantContext returns ctx scoped to the tenant the token was issued for,
+// in sandbox mode for sandbox tokens, so links emailed to users of any
+// tenant work without naming it. Requests that named a tenant must match
+// it; ok is false when they don't.
+func (p *TokenPayload) tenantContext(ctx context.Context) (context.Context, bool) {
+	if tenant.Named(ctx) {
+		return ctx, tenant.ID(ctx) == p.Tenant()
+	}
+	if p.Sandbox {
+		ctx = sandbox.With(ctx)
+	}
+	return tenant.WithTenant(ctx, &tenant.Tenant{ID: p.Tenant()}), true
+}
+
+// WithTenantID records the tenant the token is issued for
+func WithTenantID(tenantID uuid.UUID) PayloadOption {
+	return func(p *TokenPayload) {
+		p.TenantID = &tenantID
+	}
+}
+
+// WithSandbox marks the token as issued in sandbox mode when ctx is in it
+func WithSandbox(ctx context.Context) PayloadOption {
+	return func(p *TokenPayload) {
+		p.Sandbox = sandbox.Enabled(ctx)
+	}
+}
+
+// WithOrgID records the organization the user is acting in
+func WithOrgID(orgID uuid.UUID) PayloadOption {
+	return func(p *TokenPayload) {
+		p.OrgID = &orgID
+	}
+}
+
+// TokenOptions configures the registered claims a TokenMaker issues and requires
+type TokenOptions struct {
+	// Issuer is written to new tokens and, if set, required on verification
+	Issuer string
+	// Audience is written to new tokens and, if set, required on verification
+	Audience string
+	// Leeway tolerates clock skew when checking expiry and not-before times
+	Leeway time.Duration
+	// Clock stamps new tokens and checks expiry; defaults to the system clock
+	Clock clock.Clock
+	// IDs generates token IDs; defaults to idgen.Default
+	IDs idgen.Generator
+}
+
+// TokenOption customizes TokenOptions
+type TokenOption func(*TokenOptions)
+
+// WithIssuer sets the token issuer
+func WithIssuer(issuer string) TokenOption {
+	return func(o *TokenOptions) {
+		o.Issuer = issuer
+	}
+}
+
+// WithAudience sets the token audience
+func WithAudience(audience string) TokenOption {
+	return func(o *TokenOptions) {
+		o.Audience = audience
+	}
+}
+
+// WithLeeway sets the allowed clock skew
+func WithLeeway(leeway time.Duration) TokenOption {
+	return func(o *TokenOptions) {
+		o.Leeway = leeway
+	}
+}
+
+// WithTokenClock sets the clock used to issue and check tokens
+func WithTokenClock(c clock.Clock) TokenOption {
+	return func(o *TokenOptions) {
+		o.Clock = c
+	}
+}
+
+// WithTokenIDs sets the generator for token IDs
+func WithTokenIDs(ids idgen.Generator) TokenOption {
+	return func(o *TokenOptions) {
+		o.IDs = ids
+	}
+}
+
+// newTokenOptions applies opts over the defaults
+func newTokenOptions(opts []TokenOption) TokenOptions {
+	options := TokenOptions{Issuer: "goiler"}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	options.Clock = clock.OrReal(options.Clock)
+	options.IDs = idgen.OrDefault(options.IDs)
+	return options
+}
+
+// apply stamps the issuer and audience onto a payload
+func (o TokenOptions) apply(p *TokenPayload) {
+	p.Issuer = o.Issuer
+	if o.Audience != "" {
+		p.Audience = []string{o.Audience}
+	}
 }
 
 // NewTokenPayload creates a new token payload
@@ -37,8 +213,11 @@ func NewTokenPayload(userID uuid.UUID, email, role string, tokenType TokenType,
 	if err != nil {
 		return nil, err
 	}
+	return newTokenPayloadAt(tokenID, time.Now(), userID, email, role, tokenType, duration), nil
+}
 
-	now := time.Now()
+// newTokenPayloadAt creates a new token payload with the given ID, issued at now
+func newTokenPayloadAt(tokenID uuid.UUID, now time.Time, userID uuid.UUID, email, role string, tokenType TokenType, duration time.Duration) *TokenPayload {
 	return &TokenPayload{
 		ID:        tokenID,
 		UserID:    userID,
@@ -46,22 +225,43 @@ func NewTokenPayload(userID uuid.UUID, email, role string, tokenType TokenType,
 		Role:      role,
 		TokenType: tokenType,
 		IssuedAt:  now,
+		NotBefore: now,
 		ExpiresAt: now.Add(duration),
-	}, nil
+	}
 }
 
 // Valid checks if the token payload is valid
 func (p *TokenPayload) Valid() error {
-	if time.Now().After(p.ExpiresAt) {
+	return p.Validate(TokenOptions{})
+}
+
+// Validate checks the payload's time window, issuer, and audience against opts
+func (p *TokenPayload) Validate(opts TokenOptions) error {
+	now := clock.OrReal(opts.Clock).Now()
+	if now.After(p.ExpiresAt.Add(opts.Leeway)) {
 		return ErrExpiredToken
 	}
+	if !p.NotBefore.IsZero() && now.Add(opts.Leeway).Before(p.NotBefore) {
+		return ErrTokenNotYetValid
+	}
+	if opts.Issuer != "" && p.Issuer != opts.Issuer {
+		return ErrInvalidToken
+	}
+	if opts.Audience != "" && !slices.Contains(p.Audience, opts.Audience) {
+		return ErrInvalidToken
+	}
 	return nil
 }
 
+// AuthUser returns the authctx user the payload authenticates
+func (p *TokenPayload) AuthUser() *authctx.User {
+	return &authctx.User{ID: p.UserID, Email: p.Email, Role: p.Role, TenantID: p.Tenant(), OrgID: p.OrgID, ImpersonatedBy: p.ImpersonatedBy, Payload: p}
+}
+
 // TokenMaker is the interface for token operations
 type TokenMaker interface {
 	// CreateToken creates a new token for a specific user
-	CreateToken(userID uuid.UUID, email, role string, tokenType TokenType, duration time.Duration) (string, *TokenPayload, error)
+	CreateToken(userID uuid.UUID, email, role string, tokenType TokenType, duration time.Duration, opts ...PayloadOption) (string, *TokenPayload, error)
 
 	// VerifyToken checks if the token is valid and returns the payload
 	VerifyToken(token string) (*TokenPayload, error)
@@ -76,13 +276,133 @@ type TokenPair struct {
 }
 
 // NewTokenMaker creates a new token maker based on the type
-func NewTokenMaker(tokenType, secret string, symmetricKey []byte) (TokenMaker, error) {
+func NewTokenMaker(tokenType, secret string, symmetricKey []byte, opts ...TokenOption) (TokenMaker, error) {
 	switch tokenType {
 	case "jwt":
-		return NewJWTMaker(secret)
+		return NewJWTMaker(secret, opts...)
+	case "paseto":
+		return NewPASETOMaker(symmetricKey, opts...)
+	case AuthTypePASETOV4:
+		return NewPASETOV4LocalMaker(symmetricKey, opts...)
+	default:
+		return NewJWTMaker(secret, opts...)
+	}
+}
+
+// NewTokenMakerFromConfig creates the token maker described by cfg. JWTs
+// are signed with JWTSecret for HS256 or with the configured private key
+// for RS256, ES256 and EdDSA. PASETO v2 and v4.local tokens use
+// PASETOSymmetricKey; v4.public tokens use an Ed25519 private key. With
+// SigningKeys set, JWTs and v4.public tokens use a KeyRing instead.
+func NewTokenMakerFromConfig(cfg config.AuthConfig, opts ...TokenOption) (TokenMaker, error) {
+	if len(cfg.SigningKeys) > 0 {
+		return newKeyRingMakerFromConfig(cfg, opts)
+	}
+
+	switch cfg.Type {
 	case "paseto":
-		return NewPASETOMaker(symmetricKey)
+		return NewPASETOMaker(pasetoKey(cfg.PASETOSymmetricKey), opts...)
+	case AuthTypePASETOV4:
+		return NewPASETOV4LocalMaker(pasetoKey(cfg.PASETOSymmetricKey), opts...)
+	case AuthTypePASETOPublic:
+		return newPASETOPublicMakerFromConfig(cfg, opts)
+	}
+
+	switch cfg.JWTAlgorithm {
+	case "", AlgHS256:
+		return NewJWTMaker(cfg.JWTSecret, opts...)
+	case AlgRS256, AlgES256, AlgEdDSA:
 	default:
-		return NewJWTMaker(secret)
+		return nil, fmt.Errorf("unsupported JWT algorithm %q", cfg.JWTAlgorithm)
+	}
+
+	key, err := loadJWTPrivateKey(cfg)
+	if err != nil {
+		return nil, err
+	}
+	maker, err := NewAsymmetricJWTMaker(key, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if maker.Algorithm() != cfg.JWTAlgorithm {
+		return nil, fmt.Errorf("JWT private key is for %s, not %s", maker.Algorithm(), cfg.JWTAlgorithm)
+	}
+	return maker, nil
+}
+
+// loadJWTPrivateKey reads the PEM private key from config or its file
+func loadJWTPrivateKey(cfg config.AuthConfig) (crypto.Signer, error) {
+	data := []byte(cfg.JWTPrivateKey)
+	if len(data) == 0 {
+		if cfg.JWTPrivateKeyFile == "" {
+			return nil, fmt.Errorf("%s requires JWT_PRIVATE_KEY or JWT_PRIVATE_KEY_FILE", cfg.JWTAlgorithm)
+		}
+		var err error
+		if data, err = os.ReadFile(cfg.JWTPrivateKeyFile); err != nil {
+			return nil, fmt.Errorf("read JWT private key: %w", err)
+		}
+	}
+
+	key, err := ParsePrivateKeyPEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse JWT private key: %w", err)
+	}
+	return key, nil
+}
+
+// newKeyRingMakerFromConfig creates a key ring backed maker for the token
+// types that support rotation
+func newKeyRingMakerFromConfig(cfg config.AuthConfig, opts []TokenOption) (TokenMaker, error) {
+	ring, err := LoadKeyRing(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	switch cfg.Type {
+	case "jwt":
+		return NewKeyRingJWTMaker(ring, opts...), nil
+	case AuthTypePASETOPublic:
+		return NewPASETOV4PublicKeyRingMaker(ring, opts...)
+	default:
+		return nil, fmt.Errorf("AUTH_SIGNING_KEYS is not supported with AUTH_TYPE %q", cfg.Type)
+	}
+}
+
+// newPASETOPublicMakerFromConfig creates a v4.public maker with the Ed25519
+// key from config or its file
+func newPASETOPublicMakerFromConfig(cfg config.AuthConfig, opts []TokenOption) (TokenMaker, error) {
+	data := []byte(cfg.PASETOPrivateKey)
+	if len(data) == 0 {
+		if cfg.PASETOPrivateKeyFile == "" {
+			return nil, errors.New("paseto-public requires PASETO_PRIVATE_KEY or PASETO_PRIVATE_KEY_FILE")
+		}
+		var err error
+		if data, err = os.ReadFile(cfg.PASETOPrivateKeyFile); err != nil {
+			return nil, fmt.Errorf("read PASETO private key: %w", err)
+		}
+	}
+
+	key, err := ParsePrivateKeyPEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse PASETO private key: %w", err)
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: v4.public requires an Ed25519 key, got %T", ErrUnsupportedKey, key)
+	}
+	return NewPASETOV4PublicMaker(edKey, opts...)
+}
+
+// pasetoKey pads or truncates a configured key to the 32 bytes PASETO needs
+func pasetoKey(key string) []byte {
+	if key == "" {
+		return nil
+	}
+	symmetricKey := []byte(key)
+	if len(symmetricKey) < symmetricKeySize {
+		padded := make([]byte, symmetricKeySize)
+		copy(padded, symmetricKey)
+		return padded
 	}
+	return symmetricKey[:symmetricKeySize]
 }
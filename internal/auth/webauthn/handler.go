@@ -0,0 +1,159 @@
+package webauthn
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/internal/auth"
+	"github.com/pixperk/goiler/pkg/response"
+)
+
+// SessionHeader carries the ceremony session ID from a begin step to its
+// finish step
+const SessionHeader = "X-WebAuthn-Session"
+
+// BeginResponse is returned by the begin steps. Options are passed to
+// navigator.credentials.create or navigator.credentials.get as-is.
+type BeginResponse struct {
+	SessionID string      `json:"session_id"`
+	Options   interface{} `json:"options"`
+}
+
+// CredentialResponse describes a registered passkey
+type CredentialResponse struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Handler handles HTTP requests for passkey registration and login
+type Handler struct {
+	service *Service
+}
+
+// NewHandler creates a new WebAuthn handler
+func NewHandler(service *Service) *Handler {
+	return &Handler{service: service}
+}
+
+// BeginRegistration starts registering a passkey
+// @Summary Begin passkey registration
+// @Description Get credential creation options for the current user
+// @Tags Auth
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} BeginResponse
+// @Failure 401 {object} response.Response
+// @Router /api/v1/auth/webauthn/register/begin [post]
+func (h *Handler) BeginRegistration(c echo.Context) error {
+	payload := auth.GetCurrentUser(c)
+	if payload == nil {
+		return response.Unauthorized(c, "User not authenticated")
+	}
+
+	creation, sessionID, err := h.service.BeginRegistration(c.Request().Context(), payload.UserID)
+	if err != nil {
+		if errors.Is(err, auth.ErrUserNotFound) {
+			return response.NotFound(c, "User not found")
+		}
+		return response.InternalError(c, "Failed to begin passkey registration")
+	}
+
+	return response.Success(c, BeginResponse{SessionID: sessionID, Options: creation})
+}
+
+// FinishRegistration verifies and stores a new passkey
+// @Summary Finish passkey registration
+// @Description Verify the authenticator response and save the passkey. The body is the PublicKeyCredential from navigator.credentials.create.
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param X-WebAuthn-Session header string true "Session ID from the begin step"
+// @Param name query string false "Passkey name"
+// @Success 201 {object} CredentialResponse
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Router /api/v1/auth/webauthn/register/finish [post]
+func (h *Handler) FinishRegistration(c echo.Context) error {
+	payload := auth.GetCurrentUser(c)
+	if payload == nil {
+		return response.Unauthorized(c, "User not authenticated")
+	}
+
+	credential, err := h.service.FinishRegistration(
+		auth.ClientContext(c),
+		payload.UserID,
+		c.Request().Header.Get(SessionHeader),
+		c.QueryParam("name"),
+		c.Request(),
+	)
+	if err != nil {
+		if errors.Is(err, ErrSessionNotFound) {
+			return response.BadRequest(c, "Registration session not found or expired")
+		}
+		if errors.Is(err, ErrVerificationFailed) {
+			return response.BadRequest(c, "Passkey verification failed")
+		}
+		return response.InternalError(c, "Failed to register passkey")
+	}
+
+	return response.Created(c, CredentialResponse{
+		ID:        base64.RawURLEncoding.EncodeToString(credential.Credential.ID),
+		Name:      credential.Name,
+		CreatedAt: credential.CreatedAt,
+	})
+}
+
+// BeginLogin starts a passkey login
+// @Summary Begin passkey login
+// @Description Get credential request options for a discoverable passkey login
+// @Tags Auth
+// @Produce json
+// @Success 200 {object} BeginResponse
+// @Router /api/v1/auth/webauthn/login/begin [post]
+func (h *Handler) BeginLogin(c echo.Context) error {
+	assertion, sessionID, err := h.service.BeginLogin(c.Request().Context())
+	if err != nil {
+		return response.InternalError(c, "Failed to begin passkey login")
+	}
+
+	return response.Success(c, BeginResponse{SessionID: sessionID, Options: assertion})
+}
+
+// FinishLogin verifies a passkey assertion and issues tokens
+// @Summary Finish passkey login
+// @Description Verify the authenticator assertion and get tokens. The body is the PublicKeyCredential from navigator.credentials.get.
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param X-WebAuthn-Session header string true "Session ID from the begin step"
+// @Success 200 {object} auth.AuthResponse
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response "Account suspended or email not verified"
+// @Router /api/v1/auth/webauthn/login/finish [post]
+func (h *Handler) FinishLogin(c echo.Context) error {
+	result, err := h.service.FinishLogin(auth.ClientContext(c), c.Request().Header.Get(SessionHeader), c.Request())
+	if err != nil {
+		if errors.Is(err, ErrSessionNotFound) {
+			return response.BadRequest(c, "Login session not found or expired")
+		}
+		if errors.Is(err, ErrVerificationFailed) || errors.Is(err, ErrCredentialNotFound) || errors.Is(err, auth.ErrUserNotFound) {
+			return response.Unauthorized(c, "Passkey verification failed")
+		}
+		if errors.Is(err, auth.ErrAccountSuspended) {
+			return auth.AccountSuspended(c)
+		}
+		if errors.Is(err, auth.ErrEmailNotVerified) {
+			return response.Error(c, http.StatusForbidden, "EMAIL_NOT_VERIFIED", "Please verify your email before logging in")
+		}
+		return response.InternalError(c, "Failed to authenticate")
+	}
+
+	auth.SetCookies(c, result.Cookies())
+	return response.SuccessWithMessage(c, "Login successful", result)
+}
@@ -0,0 +1,128 @@
+package webauthn
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	gowebauthn "github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pixperk/goiler/db/sqlc"
+	"github.com/pixperk/goiler/pkg/budget"
+)
+
+// PostgresCredentialRepository implements CredentialRepository using
+// PostgreSQL. Credentials are stored as JSON so library upgrades that add
+// fields don't need a migration.
+type PostgresCredentialRepository struct {
+	queries *sqlc.Queries
+}
+
+// NewPostgresCredentialRepository creates a new PostgreSQL credential repository
+func NewPostgresCredentialRepository(db *pgxpool.Pool) *PostgresCredentialRepository {
+	return &PostgresCredentialRepository{
+		queries: sqlc.New(db),
+	}
+}
+
+// Create stores a new credential
+func (r *PostgresCredentialRepository) Create(ctx context.Context, credential *Credential) error {
+	data, err := json.Marshal(credential.Credential)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return r.queries.CreateWebauthnCredential(ctx, sqlc.CreateWebauthnCredentialParams{
+		ID:         credential.Credential.ID,
+		UserID:     credential.UserID,
+		Name:       credential.Name,
+		Credential: data,
+	})
+}
+
+// Get returns a credential by its ID
+func (r *PostgresCredentialRepository) Get(ctx context.Context, credentialID []byte) (*Credential, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	row, err := r.queries.GetWebauthnCredential(ctx, credentialID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrCredentialNotFound
+		}
+		return nil, err
+	}
+	return toCredential(row)
+}
+
+// ListByUser returns a user's credentials, oldest first
+func (r *PostgresCredentialRepository) ListByUser(ctx context.Context, userID uuid.UUID) ([]*Credential, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	rows, err := r.queries.ListWebauthnCredentials(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials := make([]*Credential, 0, len(rows))
+	for _, row := range rows {
+		credential, err := toCredential(row)
+		if err != nil {
+			return nil, err
+		}
+		credentials = append(credentials, credential)
+	}
+	return credentials, nil
+}
+
+// Update stores a credential's new state and marks it as used
+func (r *PostgresCredentialRepository) Update(ctx context.Context, credential *Credential) error {
+	data, err := json.Marshal(credential.Credential)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return r.queries.UpdateWebauthnCredential(ctx, sqlc.UpdateWebauthnCredentialParams{
+		ID:         credential.Credential.ID,
+		Credential: data,
+	})
+}
+
+func toCredential(row *sqlc.WebauthnCredential) (*Credential, error) {
+	var credential gowebauthn.Credential
+	if err := json.Unmarshal(row.Credential, &credential); err != nil {
+		return nil, err
+	}
+
+	c := &Credential{
+		UserID:     row.UserID,
+		Name:       row.Name,
+		Credential: credential,
+		CreatedAt:  row.CreatedAt.Time,
+	}
+	if row.LastUsedAt.Valid {
+		c.LastUsedAt = &row.LastUsedAt.Time
+	}
+	return c, nil
+}
@@ -0,0 +1,311 @@
+package webauthn
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	gowebauthn "github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+	"github.com/pixperk/goiler/internal/auth"
+	"github.com/pixperk/goiler/internal/config"
+)
+
+var (
+	ErrSessionNotFound    = errors.New("webauthn session not found or expired")
+	ErrCredentialNotFound = errors.New("webauthn credential not found")
+	ErrVerificationFailed = errors.New("webauthn verification failed")
+)
+
+// Credential is a passkey registered to a user
+type Credential struct {
+	UserID     uuid.UUID
+	Name       string
+	Credential gowebauthn.Credential
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+}
+
+// CredentialRepository stores passkey credentials
+type CredentialRepository interface {
+	Create(ctx context.Context, credential *Credential) error
+	// Get returns ErrCredentialNotFound for unknown credential IDs
+	Get(ctx context.Context, credentialID []byte) (*Credential, error)
+	ListByUser(ctx context.Context, userID uuid.UUID) ([]*Credential, error)
+	// Update persists a credential after login, chiefly its sign count
+	Update(ctx context.Context, credential *Credential) error
+}
+
+// SessionStore holds ceremony state between the begin and finish steps.
+// Sessions are single use.
+type SessionStore interface {
+	Save(ctx context.Context, id string, session *gowebauthn.SessionData, ttl time.Duration) error
+	// Take returns and removes a session, or ErrSessionNotFound
+	Take(ctx context.Context, id string) (*gowebauthn.SessionData, error)
+}
+
+// Service runs WebAuthn registration and login ceremonies
+type Service struct {
+	webauthn    *gowebauthn.WebAuthn
+	users       auth.UserRepository
+	credentials CredentialRepository
+	sessions    SessionStore
+	auth        *auth.Service
+	timeout     time.Duration
+}
+
+// ServiceOption configures a Service
+type ServiceOption func(*Service)
+
+// WithSessionStore replaces the in-memory session store, which only works
+// when a single instance serves both ceremony steps
+func WithSessionStore(store SessionStore) ServiceOption {
+	return func(s *Service) {
+		s.sessions = store
+	}
+}
+
+// NewService creates a new WebAuthn service
+func NewService(cfg config.WebAuthnConfig, users auth.UserRepository, credentials CredentialRepository, authService *auth.Service, opts ...ServiceOption) (*Service, error) {
+	wa, err := gowebauthn.New(&gowebauthn.Config{
+		RPID:          cfg.RPID,
+		RPDisplayName: cfg.RPDisplayName,
+		RPOrigins:     cfg.RPOrigins,
+		Timeouts: gowebauthn.TimeoutsConfig{
+			Login:        gowebauthn.TimeoutConfig{Enforce: true, Timeout: cfg.Timeout},
+			Registration: gowebauthn.TimeoutConfig{Enforce: true, Timeout: cfg.Timeout},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid webauthn config: %w", err)
+	}
+
+	s := &Service{
+		webauthn:    wa,
+		users:       users,
+		credentials: credentials,
+		sessions:    NewMemorySessionStore(),
+		auth:        authService,
+		timeout:     cfg.Timeout,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s, nil
+}
+
+// BeginRegistration starts registering a new passkey for a user. It returns
+// the options for navigator.credentials.create and the ceremony session ID.
+func (s *Service) BeginRegistration(ctx context.Context, userID uuid.UUID) (*protocol.CredentialCreation, string, error) {
+	user, err := s.loadUser(ctx, userID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	creation, session, err := s.webauthn.BeginRegistration(user,
+		gowebauthn.WithExclusions(gowebauthn.Credentials(user.credentials).CredentialDescriptors()),
+		gowebauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementPreferred),
+	)
+	if err != nil {
+		return nil, "", err
+	}
+
+	sessionID, err := s.saveSession(ctx, session)
+	if err != nil {
+		return nil, "", err
+	}
+	return creation, sessionID, nil
+}
+
+// FinishRegistration verifies the authenticator's response and stores the
+// new credential
+func (s *Service) FinishRegistration(ctx context.Context, userID uuid.UUID, sessionID, name string, r *http.Request) (*Credential, error) {
+	session, err := s.sessions.Take(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.loadUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	credential, err := s.webauthn.FinishRegistration(user, *session, r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrVerificationFailed, err)
+	}
+
+	if name == "" {
+		name = "Passkey"
+	}
+	stored := &Credential{
+		UserID:     userID,
+		Name:       name,
+		Credential: *credential,
+		CreatedAt:  time.Now(),
+	}
+	if err := s.credentials.Create(ctx, stored); err != nil {
+		return nil, err
+	}
+	s.auth.Auditor().Record(ctx, auth.AuditPasskeyAdded, userID, map[string]any{"name": name})
+	return stored, nil
+}
+
+// BeginLogin starts a passkey login. The authenticator picks the account,
+// so no email is needed.
+func (s *Service) BeginLogin(ctx context.Context) (*protocol.CredentialAssertion, string, error) {
+	assertion, session, err := s.webauthn.BeginDiscoverableLogin()
+	if err != nil {
+		return nil, "", err
+	}
+
+	sessionID, err := s.saveSession(ctx, session)
+	if err != nil {
+		return nil, "", err
+	}
+	return assertion, sessionID, nil
+}
+
+// FinishLogin verifies the authenticator's assertion and issues tokens for
+// the account it belongs to
+func (s *Service) FinishLogin(ctx context.Context, sessionID string, r *http.Request) (*auth.AuthResponse, error) {
+	session, err := s.sessions.Take(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	var found *webAuthnUser
+	credential, err := s.webauthn.FinishDiscoverableLogin(func(rawID, userHandle []byte) (gowebauthn.User, error) {
+		userID, err := uuid.FromBytes(userHandle)
+		if err != nil {
+			return nil, ErrCredentialNotFound
+		}
+		found, err = s.loadUser(ctx, userID)
+		return found, err
+	}, *session, r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrVerificationFailed, err)
+	}
+
+	stored, err := s.credentials.Get(ctx, credential.ID)
+	if err != nil {
+		return nil, err
+	}
+	if stored.UserID != found.user.ID {
+		return nil, ErrVerificationFailed
+	}
+
+	// Cloned authenticators are reported through the sign count
+	if credential.Authenticator.CloneWarning {
+		s.auth.Auditor().Record(ctx, auth.AuditLoginFailed, found.user.ID, map[string]any{
+			"method": "passkey",
+			"reason": "cloned_authenticator",
+		})
+		return nil, fmt.Errorf("%w: possible cloned authenticator", ErrVerificationFailed)
+	}
+
+	stored.Credential = *credential
+	if err := s.credentials.Update(ctx, stored); err != nil {
+		return nil, err
+	}
+
+	return s.auth.CompleteLogin(ctx, found.user.ID, "passkey")
+}
+
+func (s *Service) loadUser(ctx context.Context, userID uuid.UUID) (*webAuthnUser, error) {
+	user, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	stored, err := s.credentials.ListByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials := make([]gowebauthn.Credential, len(stored))
+	for i, credential := range stored {
+		credentials[i] = credential.Credential
+	}
+	return &webAuthnUser{user: user, credentials: credentials}, nil
+}
+
+func (s *Service) saveSession(ctx context.Context, session *gowebauthn.SessionData) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	id := base64.RawURLEncoding.EncodeToString(buf)
+
+	if err := s.sessions.Save(ctx, id, session, s.timeout); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// webAuthnUser adapts auth.User to the webauthn library. The user handle is
+// the account's UUID, which is opaque and stable.
+type webAuthnUser struct {
+	user        *auth.User
+	credentials []gowebauthn.Credential
+}
+
+func (u *webAuthnUser) WebAuthnID() []byte {
+	id := u.user.ID
+	return id[:]
+}
+
+func (u *webAuthnUser) WebAuthnName() string                         { return u.user.Email }
+func (u *webAuthnUser) WebAuthnDisplayName() string                  { return u.user.Email }
+func (u *webAuthnUser) WebAuthnCredentials() []gowebauthn.Credential { return u.credentials }
+
+// MemorySessionStore keeps ceremony sessions in process memory
+type MemorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]memorySession
+}
+
+type memorySession struct {
+	data      *gowebauthn.SessionData
+	expiresAt time.Time
+}
+
+// NewMemorySessionStore creates a new in-memory session store
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{sessions: make(map[string]memorySession)}
+}
+
+// Save stores a session until ttl elapses, dropping any expired sessions
+func (m *MemorySessionStore) Save(ctx context.Context, id string, session *gowebauthn.SessionData, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for key, existing := range m.sessions {
+		if now.After(existing.expiresAt) {
+			delete(m.sessions, key)
+		}
+	}
+	m.sessions[id] = memorySession{data: session, expiresAt: now.Add(ttl)}
+	return nil
+}
+
+// Take returns and removes a session
+func (m *MemorySessionStore) Take(ctx context.Context, id string) (*gowebauthn.SessionData, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.sessions[id]
+	delete(m.sessions, id)
+	if !ok || time.Now().After(session.expiresAt) {
+		return nil, ErrSessionNotFound
+	}
+	return session.data, nil
+}
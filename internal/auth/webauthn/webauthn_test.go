@@ -0,0 +1,65 @@
+package webauthn
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	gowebauthn "github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+	"github.com/pixperk/goiler/internal/auth"
+	"github.com/pixperk/goiler/internal/config"
+)
+
+func TestMemorySessionStore_SingleUse(t *testing.T) {
+	store := NewMemorySessionStore()
+	ctx := context.Background()
+
+	if err := store.Save(ctx, "abc", &gowebauthn.SessionData{Challenge: "challenge"}, time.Minute); err != nil {
+		t.Fatalf("Failed to save session: %v", err)
+	}
+
+	session, err := store.Take(ctx, "abc")
+	if err != nil {
+		t.Fatalf("Failed to take session: %v", err)
+	}
+	if session.Challenge != "challenge" {
+		t.Errorf("Expected challenge %q, got %q", "challenge", session.Challenge)
+	}
+
+	if _, err := store.Take(ctx, "abc"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected ErrSessionNotFound on reuse, got %v", err)
+	}
+}
+
+func TestMemorySessionStore_Expired(t *testing.T) {
+	store := NewMemorySessionStore()
+	ctx := context.Background()
+
+	_ = store.Save(ctx, "abc", &gowebauthn.SessionData{}, -time.Second)
+
+	if _, err := store.Take(ctx, "abc"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected ErrSessionNotFound for expired session, got %v", err)
+	}
+}
+
+func TestNewService_InvalidConfig(t *testing.T) {
+	_, err := NewService(config.WebAuthnConfig{RPDisplayName: "goiler"}, nil, nil, nil)
+	if err == nil {
+		t.Fatal("Expected error when RP ID and origins are missing")
+	}
+}
+
+func TestWebAuthnUser_ID(t *testing.T) {
+	id := uuid.New()
+	user := &webAuthnUser{user: &auth.User{ID: id, Email: "test@example.com"}}
+
+	parsed, err := uuid.FromBytes(user.WebAuthnID())
+	if err != nil {
+		t.Fatalf("User handle should be a UUID: %v", err)
+	}
+	if parsed != id {
+		t.Errorf("Expected user handle %s, got %s", id, parsed)
+	}
+}
@@ -0,0 +1,225 @@
+// Package changefeed serves incremental sync for offline-first clients.
+// Changes to synced entities are appended to a per-user change log in the
+// same transaction as the change, either by database triggers or by
+// Record; clients page through the log with an opaque cursor and are told
+// over WebSocket when new changes arrive.
+package changefeed
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pixperk/goiler/pkg/clock"
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid sync cursor")
+	// ErrCursorExpired is returned when changes after the cursor may have
+	// been pruned; the client must resync from scratch
+	ErrCursorExpired = errors.New("sync cursor expired")
+)
+
+// Change operations
+const (
+	OpUpsert = "upsert"
+	OpDelete = "delete"
+)
+
+// Page size limits for Changes
+const (
+	DefaultPageSize = 100
+	MaxPageSize     = 1000
+)
+
+// Change is an entry in a user's change log
+type Change struct {
+	Seq       int64           `json:"seq"`
+	UserID    uuid.UUID       `json:"-"`
+	Entity    string          `json:"entity"`
+	EntityID  string          `json:"entity_id"`
+	Op        string          `json:"op"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// Page is a batch of changes and the cursor to resume from
+type Page struct {
+	Changes []*Change `json:"changes"`
+	Cursor  string    `json:"cursor"`
+	// HasMore is set when more changes are available right away
+	HasMore bool `json:"has_more"`
+}
+
+// Repository stores the change log
+type Repository interface {
+	// Create appends a change, setting its Seq
+	Create(ctx context.Context, change *Change) error
+	// ListUserChanges returns a user's changes with after < seq <= until
+	ListUserChanges(ctx context.Context, userID uuid.UUID, after, until int64, limit int) ([]*Change, error)
+	// ListChanges returns every user's changes after seq
+	ListChanges(ctx context.Context, after int64, limit int) ([]*Change, error)
+	LatestSeq(ctx context.Context) (int64, error)
+	// LatestUserSeq returns the latest seq once the user's uncommitted
+	// changes have committed, so none of theirs up to it is still to come
+	LatestUserSeq(ctx context.Context, userID uuid.UUID) (int64, error)
+	OldestSeq(ctx context.Context) (int64, error)
+	DeleteBefore(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Feed reads and appends to the change log
+type Feed struct {
+	repo   Repository
+	logger *slog.Logger
+	clock  clock.Clock
+}
+
+// Option configures a Feed
+type Option func(*Feed)
+
+// WithLogger sets the logger
+func WithLogger(logger *slog.Logger) Option {
+	return func(f *Feed) {
+		f.logger = logger
+	}
+}
+
+// WithClock sets the clock used to stamp recorded changes and prune the log
+func WithClock(c clock.Clock) Option {
+	return func(f *Feed) {
+		f.clock = c
+	}
+}
+
+// NewFeed creates a change feed
+func NewFeed(repo Repository, opts ...Option) *Feed {
+	f := &Feed{
+		repo:   repo,
+		logger: slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	f.clock = clock.OrReal(f.clock)
+	return f
+}
+
+// Changes returns the user's changes after the cursor since; an empty
+// cursor starts from the beginning of the log. The returned cursor covers
+// every change committed so far, so clients with nothing new still advance.
+func (f *Feed) Changes(ctx context.Context, userID uuid.UUID, since string, limit int) (*Page, error) {
+	after, err := ParseCursor(since)
+	if err != nil {
+		return nil, err
+	}
+	if limit < 1 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	if after > 0 {
+		oldest, err := f.repo.OldestSeq(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if after < oldest-1 {
+			return nil, ErrCursorExpired
+		}
+	}
+
+	// Each user's changes commit in seq order, so all of theirs up to the
+	// latest seq read now are visible to the query below
+	until, err := f.repo.LatestUserSeq(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if until < after {
+		until = after
+	}
+
+	changes, err := f.repo.ListUserChanges(ctx, userID, after, until, limit+1)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &Page{Changes: changes, Cursor: FormatCursor(until)}
+	if len(changes) > limit {
+		page.Changes = changes[:limit]
+		page.Cursor = FormatCursor(page.Changes[limit-1].Seq)
+		page.HasMore = true
+	}
+	return page, nil
+}
+
+// Record appends an upsert of an entity to the user's change log. To log
+// the change only if it commits, record it through a feed bound to the
+// same transaction with WithRepository. Entities stored in tables with a
+// record_sync_change trigger are logged automatically.
+func (f *Feed) Record(ctx context.Context, userID uuid.UUID, entity, entityID string, data any) (*Change, error) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return f.record(ctx, &Change{
+		UserID:   userID,
+		Entity:   entity,
+		EntityID: entityID,
+		Op:       OpUpsert,
+		Data:     encoded,
+	})
+}
+
+// RecordDelete appends a deletion of an entity to the user's change log
+func (f *Feed) RecordDelete(ctx context.Context, userID uuid.UUID, entity, entityID string) (*Change, error) {
+	return f.record(ctx, &Change{
+		UserID:   userID,
+		Entity:   entity,
+		EntityID: entityID,
+		Op:       OpDelete,
+	})
+}
+
+func (f *Feed) record(ctx context.Context, change *Change) (*Change, error) {
+	change.CreatedAt = f.clock.Now()
+	if err := f.repo.Create(ctx, change); err != nil {
+		return nil, err
+	}
+	return change, nil
+}
+
+// WithRepository returns a copy of the feed using repo, such as a
+// repository bound to a transaction
+func (f *Feed) WithRepository(repo Repository) *Feed {
+	clone := *f
+	clone.repo = repo
+	return &clone
+}
+
+// Prune deletes changes older than retention. Clients whose cursor
+// predates the pruned changes get ErrCursorExpired and must resync.
+func (f *Feed) Prune(ctx context.Context, retention time.Duration) (int64, error) {
+	return f.repo.DeleteBefore(ctx, f.clock.Now().Add(-retention))
+}
+
+// FormatCursor encodes a seq as a cursor
+func FormatCursor(seq int64) string {
+	return strconv.FormatInt(seq, 10)
+}
+
+// ParseCursor decodes a cursor; an empty cursor is the start of the log
+func ParseCursor(cursor string) (int64, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+	seq, err := strconv.ParseInt(cursor, 10, 64)
+	if err != nil || seq < 0 {
+		return 0, ErrInvalidCursor
+	}
+	return seq, nil
+}
@@ -0,0 +1,291 @@
+package changefeed
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/pkg/authctx"
+	"github.com/pixperk/goiler/pkg/clock"
+)
+
+type memoryRepository struct {
+	mu      sync.Mutex
+	changes []*Change
+	seq     int64
+}
+
+func (r *memoryRepository) Create(_ context.Context, change *Change) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seq++
+	change.Seq = r.seq
+	r.changes = append(r.changes, change)
+	return nil
+}
+
+func (r *memoryRepository) ListUserChanges(_ context.Context, userID uuid.UUID, after, until int64, limit int) ([]*Change, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var changes []*Change
+	for _, c := range r.changes {
+		if c.UserID == userID && c.Seq > after && c.Seq <= until && len(changes) < limit {
+			changes = append(changes, c)
+		}
+	}
+	return changes, nil
+}
+
+func (r *memoryRepository) ListChanges(_ context.Context, after int64, limit int) ([]*Change, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var changes []*Change
+	for _, c := range r.changes {
+		if c.Seq > after && len(changes) < limit {
+			changes = append(changes, c)
+		}
+	}
+	return changes, nil
+}
+
+func (r *memoryRepository) LatestSeq(context.Context) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.changes) == 0 {
+		return 0, nil
+	}
+	return r.changes[len(r.changes)-1].Seq, nil
+}
+
+func (r *memoryRepository) LatestUserSeq(ctx context.Context, _ uuid.UUID) (int64, error) {
+	return r.LatestSeq(ctx)
+}
+
+// reserve takes the next seq for a change committed later with commit,
+// like a transaction still in flight
+func (r *memoryRepository) reserve() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seq++
+	return r.seq
+}
+
+func (r *memoryRepository) commit(change *Change) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.changes = append(r.changes, change)
+	slices.SortFunc(r.changes, func(a, b *Change) int { return cmp.Compare(a.Seq, b.Seq) })
+}
+
+func (r *memoryRepository) OldestSeq(context.Context) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.changes) == 0 {
+		return 0, nil
+	}
+	return r.changes[0].Seq, nil
+}
+
+func (r *memoryRepository) DeleteBefore(_ context.Context, before time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var kept []*Change
+	for i, c := range r.changes {
+		if c.CreatedAt.Before(before) && i < len(r.changes)-1 {
+			continue
+		}
+		kept = append(kept, c)
+	}
+	deleted := int64(len(r.changes) - len(kept))
+	r.changes = kept
+	return deleted, nil
+}
+
+type recordingPusher struct {
+	mu       sync.Mutex
+	messages map[string][]Notification
+}
+
+func (p *recordingPusher) BroadcastToUser(userID, messageType string, payload interface{}) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var n Notification
+	_ = json.Unmarshal(payload.([]byte), &n)
+	if messageType == MessageTypeChanges {
+		p.messages[userID] = append(p.messages[userID], n)
+	}
+	return nil
+}
+
+func TestFeed_ChangesPaging(t *testing.T) {
+	feed := NewFeed(&memoryRepository{})
+	ctx := context.Background()
+	alice, bob := uuid.New(), uuid.New()
+
+	for i := 0; i < 3; i++ {
+		if _, err := feed.Record(ctx, alice, "note", uuid.NewString(), map[string]int{"n": i}); err != nil {
+			t.Fatalf("Record failed: %v", err)
+		}
+	}
+	_, _ = feed.Record(ctx, bob, "note", uuid.NewString(), nil)
+	_, _ = feed.RecordDelete(ctx, alice, "note", "gone")
+
+	page, err := feed.Changes(ctx, alice, "", 2)
+	if err != nil {
+		t.Fatalf("Changes failed: %v", err)
+	}
+	if len(page.Changes) != 2 || !page.HasMore || page.Cursor != "2" {
+		t.Fatalf("Expected a full first page ending at 2, got %d changes, cursor %s, has_more %v", len(page.Changes), page.Cursor, page.HasMore)
+	}
+
+	page, err = feed.Changes(ctx, alice, page.Cursor, 2)
+	if err != nil {
+		t.Fatalf("Changes failed: %v", err)
+	}
+	if len(page.Changes) != 2 || page.HasMore {
+		t.Fatalf("Expected the remaining two changes, got %d (has_more %v)", len(page.Changes), page.HasMore)
+	}
+	if last := page.Changes[1]; last.Op != OpDelete || last.EntityID != "gone" {
+		t.Errorf("Expected the delete last, got %+v", last)
+	}
+	if page.Cursor != "5" {
+		t.Errorf("Expected the cursor to advance to the latest change, got %s", page.Cursor)
+	}
+
+	page, err = feed.Changes(ctx, bob, "4", 0)
+	if err != nil {
+		t.Fatalf("Changes failed: %v", err)
+	}
+	if len(page.Changes) != 0 || page.Cursor != "5" {
+		t.Errorf("Expected no changes for bob after 4 and the cursor to advance, got %d, %s", len(page.Changes), page.Cursor)
+	}
+}
+
+func TestFeed_Cursors(t *testing.T) {
+	clk := clock.NewFrozen(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	feed := NewFeed(&memoryRepository{}, WithClock(clk))
+	ctx := context.Background()
+	userID := uuid.New()
+
+	if _, err := feed.Changes(ctx, userID, "abc", 0); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("Expected ErrInvalidCursor, got: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		_, _ = feed.Record(ctx, userID, "note", "n", nil)
+	}
+	clk.Advance(48 * time.Hour)
+	_, _ = feed.Record(ctx, userID, "note", "n", nil)
+
+	deleted, err := feed.Prune(ctx, 24*time.Hour)
+	if err != nil || deleted != 3 {
+		t.Fatalf("Expected 3 changes pruned, got %d: %v", deleted, err)
+	}
+
+	if _, err := feed.Changes(ctx, userID, "1", 0); !errors.Is(err, ErrCursorExpired) {
+		t.Errorf("Expected a cursor before the pruned changes to expire, got: %v", err)
+	}
+	if page, err := feed.Changes(ctx, userID, "3", 0); err != nil || len(page.Changes) != 1 {
+		t.Errorf("Expected a cursor right before the retained changes to work, got %v: %v", page, err)
+	}
+}
+
+func TestFeed_Relay(t *testing.T) {
+	feed := NewFeed(&memoryRepository{})
+	ctx := context.Background()
+	alice, bob := uuid.New(), uuid.New()
+	pusher := &recordingPusher{messages: make(map[string][]Notification)}
+
+	_, _ = feed.Record(ctx, alice, "note", "before", nil)
+	last, _ := feed.repo.LatestSeq(ctx)
+
+	_, _ = feed.Record(ctx, alice, "note", "a", nil)
+	_, _ = feed.Record(ctx, bob, "note", "b", nil)
+	_, _ = feed.Record(ctx, alice, "note", "c", nil)
+
+	if got := feed.relay(ctx, pusher, last, make(map[int64]time.Time)); got != 4 {
+		t.Errorf("Expected the relay to advance to 4, got %d", got)
+	}
+	if n := pusher.messages[alice.String()]; len(n) != 1 || n[0].Changes != 2 || n[0].Cursor != "4" {
+		t.Errorf("Expected one notification of 2 changes for alice, got %+v", n)
+	}
+	if n := pusher.messages[bob.String()]; len(n) != 1 || n[0].Changes != 1 {
+		t.Errorf("Expected one notification for bob, got %+v", n)
+	}
+}
+
+func TestFeed_RelayLateCommits(t *testing.T) {
+	repo := &memoryRepository{}
+	frozen := clock.NewFrozen(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	feed := NewFeed(repo, WithClock(frozen))
+	ctx := context.Background()
+	alice, bob, carol := uuid.New(), uuid.New(), uuid.New()
+	pusher := &recordingPusher{messages: make(map[string][]Notification)}
+	gaps := make(map[int64]time.Time)
+
+	// Alice's change takes seq 1 but commits after Bob's and Carol's
+	late := repo.reserve()
+	abandoned := repo.reserve()
+	_, _ = feed.Record(ctx, bob, "note", "b", nil)
+	last := feed.relay(ctx, pusher, 0, gaps)
+	if last != 3 || len(pusher.messages[bob.String()]) != 1 {
+		t.Fatalf("Expected bob to be notified up to 3, got %d: %+v", last, pusher.messages)
+	}
+
+	repo.commit(&Change{Seq: late, UserID: alice, Entity: "note", EntityID: "a"})
+	_, _ = feed.Record(ctx, carol, "note", "c", nil)
+	last = feed.relay(ctx, pusher, last, gaps)
+	if n := pusher.messages[alice.String()]; len(n) != 1 || n[0].Cursor != "1" {
+		t.Errorf("Expected alice to be notified of her late change, got %+v", n)
+	}
+	if last != 4 || len(pusher.messages[bob.String()]) != 1 || len(pusher.messages[carol.String()]) != 1 {
+		t.Errorf("Expected each change to be announced once, got %d: %+v", last, pusher.messages)
+	}
+
+	// Gaps left by rolled back writes are given up on
+	frozen.Advance(relayGapTimeout)
+	feed.relay(ctx, pusher, last, gaps)
+	if _, ok := gaps[abandoned]; ok || len(gaps) != 0 {
+		t.Errorf("Expected the abandoned gap to be dropped, got %v", gaps)
+	}
+}
+
+func TestHandler_Sync(t *testing.T) {
+	feed := NewFeed(&memoryRepository{})
+	handler := NewHandler(feed, nil)
+	userID := uuid.New()
+	_, _ = feed.Record(context.Background(), userID, "note", "n", map[string]string{"title": "hi"})
+
+	e := echo.New()
+	call := func(query string, authed bool) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		c := e.NewContext(httptest.NewRequest(http.MethodGet, "/api/v1/sync"+query, nil), rec)
+		if authed {
+			authctx.Set(c, &authctx.User{ID: userID})
+		}
+		_ = handler.Sync(c)
+		return rec
+	}
+
+	if rec := call("", true); rec.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := call("?since=-1", true); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid cursor, got %d", rec.Code)
+	}
+	if rec := call("?limit=zero", true); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid limit, got %d", rec.Code)
+	}
+	if rec := call("", false); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a user, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,74 @@
+package changefeed
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/pkg/authctx"
+	"github.com/pixperk/goiler/pkg/response"
+)
+
+// Error codes returned by the sync endpoint
+const (
+	ErrCodeInvalidCursor = "INVALID_CURSOR"
+	ErrCodeCursorExpired = "CURSOR_EXPIRED"
+)
+
+// Handler handles HTTP requests for the change feed
+type Handler struct {
+	feed   *Feed
+	logger *slog.Logger
+}
+
+// NewHandler creates a new change feed handler
+func NewHandler(feed *Feed, logger *slog.Logger) *Handler {
+	return &Handler{feed: feed, logger: logger}
+}
+
+// Sync returns the current user's changes after a cursor
+// @Summary Sync changes
+// @Description Returns the current user's changes after the since cursor, oldest first. Omit since to start from the beginning of the retained log. Keep calling with the returned cursor while has_more is set. A 410 means changes were pruned and the client must reload its data.
+// @Tags Sync
+// @Security BearerAuth
+// @Produce json
+// @Param since query string false "Cursor from the previous response"
+// @Param limit query int false "Maximum changes to return (default 100, max 1000)"
+// @Success 200 {object} Page
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 410 {object} response.Response
+// @Router /api/v1/sync [get]
+func (h *Handler) Sync(c echo.Context) error {
+	userID, ok := authctx.UserID(c)
+	if !ok {
+		return response.Unauthorized(c, "User not authenticated")
+	}
+
+	limit := 0
+	if raw := c.QueryParam("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			return response.BadRequest(c, "limit must be a positive integer")
+		}
+		limit = n
+	}
+
+	page, err := h.feed.Changes(c.Request().Context(), userID, c.QueryParam("since"), limit)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidCursor):
+			return response.Error(c, http.StatusBadRequest, ErrCodeInvalidCursor, "Invalid sync cursor")
+		case errors.Is(err, ErrCursorExpired):
+			return response.Error(c, http.StatusGone, ErrCodeCursorExpired, "Sync cursor expired; reload and sync from the start")
+		}
+		h.logger.Error("failed to load sync changes",
+			slog.String("user_id", userID.String()),
+			slog.String("error", err.Error()),
+		)
+		return response.InternalError(c, "Failed to load changes")
+	}
+	return response.Success(c, page)
+}
@@ -0,0 +1,157 @@
+package changefeed
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MessageTypeChanges is the WebSocket message type users receive when new
+// changes are available
+const MessageTypeChanges = "sync.changes"
+
+// relayBatchSize is how many changes Watch reads per query
+const relayBatchSize = 500
+
+// pruneInterval is how often Retain prunes the log
+const pruneInterval = time.Hour
+
+// Changes are only ordered per user, so a change can commit after later
+// ones and leave a gap in the log for a while. The relay waits up to
+// relayGapTimeout for each gap to fill, as rolled back writes leave gaps
+// that never do, and tracks at most maxRelayGaps.
+const (
+	relayGapTimeout = time.Minute
+	maxRelayGaps    = 1000
+)
+
+// Pusher delivers a message to a user's WebSocket connections.
+// *websocket.Handler satisfies it.
+type Pusher interface {
+	BroadcastToUser(userID, messageType string, payload interface{}) error
+}
+
+// Notification tells a client to sync. Cursor is the user's newest change;
+// clients fetch from their own cursor rather than this one.
+type Notification struct {
+	Cursor  string `json:"cursor"`
+	Changes int    `json:"changes"`
+}
+
+// Watch polls the change log every interval and notifies users of new
+// changes until ctx is done. Every instance watches the shared log and
+// notifies the users connected to it.
+func (f *Feed) Watch(ctx context.Context, pusher Pusher, interval time.Duration) {
+	// Only changes made after the watch starts are announced
+	last, err := f.repo.LatestSeq(ctx)
+	started := err == nil
+	if err != nil {
+		f.logger.Error("failed to read sync position", slog.String("error", err.Error()))
+	}
+
+	gaps := make(map[int64]time.Time)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !started {
+				if last, err = f.repo.LatestSeq(ctx); err != nil {
+					continue
+				}
+				started = true
+			}
+			last = f.relay(ctx, pusher, last, gaps)
+		}
+	}
+}
+
+// relay notifies users of the changes after last and of those filling gaps,
+// the seqs below last not seen yet and when they were first missed. It
+// returns the new position.
+func (f *Feed) relay(ctx context.Context, pusher Pusher, last int64, gaps map[int64]time.Time) int64 {
+	now := f.clock.Now()
+	after := last
+	for seq, missed := range gaps {
+		if now.Sub(missed) >= relayGapTimeout {
+			delete(gaps, seq)
+		} else if seq <= after {
+			after = seq - 1
+		}
+	}
+
+	for {
+		changes, err := f.repo.ListChanges(ctx, after, relayBatchSize)
+		if err != nil {
+			f.logger.Error("failed to read sync changes", slog.String("error", err.Error()))
+			return last
+		}
+		if len(changes) == 0 {
+			return last
+		}
+
+		pending := make(map[uuid.UUID]*Notification)
+		for _, change := range changes {
+			if change.Seq <= last {
+				// Announced already, unless it filled a gap
+				if _, ok := gaps[change.Seq]; !ok {
+					continue
+				}
+				delete(gaps, change.Seq)
+			} else {
+				for seq := last + 1; seq < change.Seq && len(gaps) < maxRelayGaps; seq++ {
+					gaps[seq] = now
+				}
+				last = change.Seq
+			}
+
+			n := pending[change.UserID]
+			if n == nil {
+				n = &Notification{}
+				pending[change.UserID] = n
+			}
+			n.Cursor = FormatCursor(change.Seq)
+			n.Changes++
+		}
+		for userID, n := range pending {
+			payload, _ := json.Marshal(n)
+			if err := pusher.BroadcastToUser(userID.String(), MessageTypeChanges, payload); err != nil {
+				f.logger.Warn("failed to push sync notification",
+					slog.String("user_id", userID.String()),
+					slog.String("error", err.Error()),
+				)
+			}
+		}
+
+		after = changes[len(changes)-1].Seq
+		if len(changes) < relayBatchSize {
+			return last
+		}
+	}
+}
+
+// Retain prunes changes older than retention every hour until ctx is done
+func (f *Feed) Retain(ctx context.Context, retention time.Duration) {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := f.Prune(ctx, retention)
+			if err != nil {
+				f.logger.Error("failed to prune sync changes", slog.String("error", err.Error()))
+				continue
+			}
+			if deleted > 0 {
+				f.logger.Info("pruned sync changes", slog.Int64("deleted", deleted))
+			}
+		}
+	}
+}
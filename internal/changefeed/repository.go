@@ -0,0 +1,152 @@
+package changefeed
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pixperk/goiler/db/sqlc"
+	"github.com/pixperk/goiler/pkg/budget"
+)
+
+// PostgresRepository implements Repository using PostgreSQL
+type PostgresRepository struct {
+	queries *sqlc.Queries
+}
+
+// NewPostgresRepository creates a new PostgreSQL repository
+func NewPostgresRepository(db *pgxpool.Pool) *PostgresRepository {
+	return &PostgresRepository{queries: sqlc.New(db)}
+}
+
+// WithTx returns a repository that runs its queries in tx
+func (r *PostgresRepository) WithTx(tx pgx.Tx) *PostgresRepository {
+	return &PostgresRepository{queries: r.queries.WithTx(tx)}
+}
+
+// Create appends a change to the log
+func (r *PostgresRepository) Create(ctx context.Context, change *Change) error {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	seq, err := r.queries.CreateSyncChange(ctx, sqlc.CreateSyncChangeParams{
+		UserID:    change.UserID,
+		Entity:    change.Entity,
+		EntityID:  change.EntityID,
+		Op:        change.Op,
+		Data:      change.Data,
+		CreatedAt: sql.NullTime{Time: change.CreatedAt, Valid: true},
+	})
+	if err != nil {
+		return err
+	}
+	change.Seq = seq
+	return nil
+}
+
+// ListUserChanges returns a user's changes with after < seq <= until
+func (r *PostgresRepository) ListUserChanges(ctx context.Context, userID uuid.UUID, after, until int64, limit int) ([]*Change, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	rows, err := r.queries.ListSyncChanges(ctx, sqlc.ListSyncChangesParams{
+		UserID:     userID,
+		AfterSeq:   after,
+		UntilSeq:   until,
+		MaxChanges: int32(limit),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changesFromDB(rows), nil
+}
+
+// ListChanges returns every user's changes after seq
+func (r *PostgresRepository) ListChanges(ctx context.Context, after int64, limit int) ([]*Change, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	rows, err := r.queries.ListSyncChangesAfter(ctx, sqlc.ListSyncChangesAfterParams{
+		Seq:   after,
+		Limit: int32(limit),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changesFromDB(rows), nil
+}
+
+// LatestSeq returns the seq of the newest change, or 0 if there are none
+func (r *PostgresRepository) LatestSeq(ctx context.Context) (int64, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer cancel()
+
+	return r.queries.GetLatestSyncSeq(ctx)
+}
+
+// LatestUserSeq returns the seq of the newest change once the user's
+// pending changes have committed, or 0 if there are none
+func (r *PostgresRepository) LatestUserSeq(ctx context.Context, userID uuid.UUID) (int64, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer cancel()
+
+	return r.queries.GetLatestUserSyncSeq(ctx, userID)
+}
+
+// OldestSeq returns the seq of the oldest retained change, or 0 if there
+// are none
+func (r *PostgresRepository) OldestSeq(ctx context.Context) (int64, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer cancel()
+
+	return r.queries.GetOldestSyncSeq(ctx)
+}
+
+// DeleteBefore deletes changes created before the given time, except the
+// newest change
+func (r *PostgresRepository) DeleteBefore(ctx context.Context, before time.Time) (int64, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer cancel()
+
+	return r.queries.DeleteSyncChangesBefore(ctx, sql.NullTime{Time: before, Valid: true})
+}
+
+func changesFromDB(rows []*sqlc.SyncChange) []*Change {
+	changes := make([]*Change, len(rows))
+	for i, row := range rows {
+		changes[i] = &Change{
+			Seq:       row.Seq,
+			UserID:    row.UserID,
+			Entity:    row.Entity,
+			EntityID:  row.EntityID,
+			Op:        row.Op,
+			Data:      row.Data,
+			CreatedAt: row.CreatedAt.Time,
+		}
+	}
+	return changes
+}
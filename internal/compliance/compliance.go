@@ -0,0 +1,256 @@
+// Package compliance archives requests to regulated routes in an
+// append-only store, for deployments that must keep a record of who did
+// what. Archived requests carry their metadata and, optionally, their JSON
+// body with sensitive values redacted. They are kept for a retention period
+// and exported for auditors by time range.
+package compliance
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pixperk/goiler/internal/config"
+	"github.com/pixperk/goiler/pkg/clock"
+	"github.com/pixperk/goiler/pkg/idgen"
+	"github.com/pixperk/goiler/pkg/redact"
+)
+
+// ErrInvalidRange is returned for exports whose start isn't before their end
+var ErrInvalidRange = errors.New("invalid export range")
+
+// DefaultMaxBodyBytes is the largest body archived unless configured
+// otherwise
+const DefaultMaxBodyBytes = 64 * 1024
+
+// maintainInterval is how often Maintain prepares storage and applies
+// retention
+const maintainInterval = time.Hour
+
+// exportPageSize is how many records Export reads from the store at a time
+const exportPageSize = 500
+
+// Record is an archived request
+type Record struct {
+	ID        uuid.UUID  `json:"id"`
+	RequestID string     `json:"request_id,omitempty"`
+	TenantID  uuid.UUID  `json:"tenant_id"`
+	UserID    *uuid.UUID `json:"user_id,omitempty"`
+	Method    string     `json:"method"`
+	// Route is the matched route pattern, such as /api/v1/orgs/:id
+	Route string `json:"route"`
+	Path  string `json:"path"`
+	// Query is the query string with sensitive parameters redacted
+	Query     string `json:"query,omitempty"`
+	Status    int    `json:"status"`
+	LatencyMs int    `json:"latency_ms"`
+	IPAddress string `json:"ip_address"`
+	UserAgent string `json:"user_agent"`
+	// Body is the redacted JSON body, when bodies are captured
+	Body json.RawMessage `json:"body,omitempty"`
+	// BodyTruncated is set when the body was too large to archive
+	BodyTruncated bool      `json:"body_truncated,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// Filter selects archived requests with From <= created_at < To
+type Filter struct {
+	From   time.Time
+	To     time.Time
+	UserID *uuid.UUID
+}
+
+// Store is the append-only archive. Records are never changed or deleted
+// one by one; retention drops whole ranges of them.
+type Store interface {
+	Append(ctx context.Context, record *Record) error
+	// List returns records matching filter in (created_at, id) order,
+	// after the given record, or from the start if after is nil
+	List(ctx context.Context, filter Filter, after *Record, limit int) ([]*Record, error)
+	// Prepare readies the store for records created at the given time
+	Prepare(ctx context.Context, at time.Time) error
+	// DropBefore drops the ranges of records created entirely before the
+	// given time and returns how many were dropped
+	DropBefore(ctx context.Context, before time.Time) (int, error)
+}
+
+// routeRule matches archived routes
+type routeRule struct {
+	// method is empty to match every method
+	method string
+	path   string
+	prefix bool
+}
+
+func (r routeRule) matches(method, route string) bool {
+	if r.method != "" && r.method != method {
+		return false
+	}
+	if r.prefix {
+		return strings.HasPrefix(route, r.path)
+	}
+	return route == r.path
+}
+
+// parseRoute parses "METHOD /path" or "/path", where a trailing * matches
+// every route with the prefix
+func parseRoute(raw string) (routeRule, error) {
+	var rule routeRule
+	path := strings.TrimSpace(raw)
+	if method, rest, ok := strings.Cut(path, " "); ok {
+		rule.method = strings.ToUpper(method)
+		path = strings.TrimSpace(rest)
+	}
+	if !strings.HasPrefix(path, "/") {
+		return routeRule{}, fmt.Errorf("invalid compliance route %q: path must start with /", raw)
+	}
+	if strings.HasSuffix(path, "*") {
+		rule.prefix = true
+		path = strings.TrimSuffix(path, "*")
+	}
+	rule.path = path
+	return rule, nil
+}
+
+// Archive records requests to the configured routes
+type Archive struct {
+	store         Store
+	routes        []routeRule
+	captureBodies bool
+	maxBodyBytes  int
+	redactKeys    []string
+	logger        *slog.Logger
+	clock         clock.Clock
+	ids           idgen.Generator
+}
+
+// Option configures an Archive
+type Option func(*Archive)
+
+// WithLogger sets the logger
+func WithLogger(logger *slog.Logger) Option {
+	return func(a *Archive) {
+		a.logger = logger
+	}
+}
+
+// WithClock sets the clock used to stamp records, time requests and apply
+// retention
+func WithClock(c clock.Clock) Option {
+	return func(a *Archive) {
+		a.clock = c
+	}
+}
+
+// WithIDGenerator sets the generator for record IDs
+func WithIDGenerator(ids idgen.Generator) Option {
+	return func(a *Archive) {
+		a.ids = ids
+	}
+}
+
+// New creates an archive of the routes in cfg
+func New(store Store, cfg config.ComplianceConfig, opts ...Option) (*Archive, error) {
+	a := &Archive{
+		store:         store,
+		captureBodies: cfg.CaptureBodies,
+		maxBodyBytes:  cfg.MaxBodyBytes,
+		redactKeys:    cfg.RedactKeys,
+		logger:        slog.Default(),
+	}
+	for _, raw := range cfg.Routes {
+		rule, err := parseRoute(raw)
+		if err != nil {
+			return nil, err
+		}
+		a.routes = append(a.routes, rule)
+	}
+	if a.maxBodyBytes <= 0 {
+		a.maxBodyBytes = DefaultMaxBodyBytes
+	}
+	if a.redactKeys == nil {
+		a.redactKeys = redact.DefaultKeys
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	a.clock = clock.OrReal(a.clock)
+	a.ids = idgen.OrDefault(a.ids)
+	return a, nil
+}
+
+// Archives reports whether requests to a route are archived
+func (a *Archive) Archives(method, route string) bool {
+	for _, rule := range a.routes {
+		if rule.matches(method, route) {
+			return true
+		}
+	}
+	return false
+}
+
+// Export calls fn with each record matching filter, oldest first, until fn
+// returns an error
+func (a *Archive) Export(ctx context.Context, filter Filter, fn func(*Record) error) error {
+	if !filter.From.Before(filter.To) {
+		return ErrInvalidRange
+	}
+
+	var after *Record
+	for {
+		records, err := a.store.List(ctx, filter, after, exportPageSize)
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			if err := fn(record); err != nil {
+				return err
+			}
+		}
+		if len(records) < exportPageSize {
+			return nil
+		}
+		after = records[len(records)-1]
+	}
+}
+
+// Prune prepares storage for this month and the next, then drops records
+// older than retention. Zero retention keeps every record.
+func (a *Archive) Prune(ctx context.Context, retention time.Duration) (int, error) {
+	now := a.clock.Now()
+	for _, at := range []time.Time{now, now.AddDate(0, 1, 0)} {
+		if err := a.store.Prepare(ctx, at); err != nil {
+			return 0, err
+		}
+	}
+	if retention <= 0 {
+		return 0, nil
+	}
+	return a.store.DropBefore(ctx, now.Add(-retention))
+}
+
+// Maintain prunes the archive right away and then every hour until ctx is
+// done
+func (a *Archive) Maintain(ctx context.Context, retention time.Duration) {
+	ticker := time.NewTicker(maintainInterval)
+	defer ticker.Stop()
+	for {
+		dropped, err := a.Prune(ctx, retention)
+		if err != nil {
+			a.logger.Error("failed to maintain request archive", slog.String("error", err.Error()))
+		} else if dropped > 0 {
+			a.logger.Info("dropped archived requests past retention", slog.Int("partitions", dropped))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
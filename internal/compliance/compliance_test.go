@@ -0,0 +1,366 @@
+package compliance
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/internal/config"
+	"github.com/pixperk/goiler/internal/tenant"
+	"github.com/pixperk/goiler/internal/testutil"
+	"github.com/pixperk/goiler/pkg/authctx"
+	"github.com/pixperk/goiler/pkg/clock"
+)
+
+type memoryStore struct {
+	mu       sync.Mutex
+	records  []*Record
+	prepared []time.Time
+	// droppedBefore is the time of the last DropBefore call
+	droppedBefore time.Time
+}
+
+func (s *memoryStore) Append(_ context.Context, record *Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := *record
+	s.records = append(s.records, &stored)
+	return nil
+}
+
+func (s *memoryStore) List(_ context.Context, filter Filter, after *Record, limit int) ([]*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sorted := slices.Clone(s.records)
+	slices.SortFunc(sorted, compareRecords)
+
+	records := []*Record{}
+	for _, r := range sorted {
+		if r.CreatedAt.Before(filter.From) || !r.CreatedAt.Before(filter.To) {
+			continue
+		}
+		if filter.UserID != nil && (r.UserID == nil || *r.UserID != *filter.UserID) {
+			continue
+		}
+		if after != nil && compareRecords(r, after) <= 0 {
+			continue
+		}
+		records = append(records, r)
+		if len(records) == limit {
+			break
+		}
+	}
+	return records, nil
+}
+
+func (s *memoryStore) Prepare(_ context.Context, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prepared = append(s.prepared, at)
+	return nil
+}
+
+func (s *memoryStore) DropBefore(_ context.Context, before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.droppedBefore = before
+	return 1, nil
+}
+
+func (s *memoryStore) all() []*Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.records)
+}
+
+func compareRecords(a, b *Record) int {
+	if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+		return c
+	}
+	return bytes.Compare(a.ID[:], b.ID[:])
+}
+
+var testNow = time.Date(2026, 3, 15, 12, 0, 0, 0, time.UTC)
+
+func newTestArchive(t *testing.T, store Store, cfg config.ComplianceConfig) *Archive {
+	t.Helper()
+	archive, err := New(store, cfg,
+		WithLogger(testutil.Logger()),
+		WithClock(clock.NewFrozen(testNow)),
+	)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	return archive
+}
+
+func TestArchive_Archives(t *testing.T) {
+	archive := newTestArchive(t, &memoryStore{}, config.ComplianceConfig{
+		Routes: []string{"delete /api/v1/users/me", "/api/v1/orgs/:id", "POST /api/v1/admin/*"},
+	})
+
+	tests := []struct {
+		method, route string
+		want          bool
+	}{
+		{http.MethodDelete, "/api/v1/users/me", true},
+		{http.MethodGet, "/api/v1/users/me", false},
+		{http.MethodGet, "/api/v1/orgs/:id", true},
+		{http.MethodPost, "/api/v1/orgs/:id", true},
+		{http.MethodGet, "/api/v1/orgs/:id/switch", false},
+		{http.MethodPost, "/api/v1/admin/tenants", true},
+		{http.MethodGet, "/api/v1/admin/tenants", false},
+	}
+	for _, tt := range tests {
+		if got := archive.Archives(tt.method, tt.route); got != tt.want {
+			t.Errorf("Archives(%s %s) = %v, want %v", tt.method, tt.route, got, tt.want)
+		}
+	}
+
+	if _, err := New(&memoryStore{}, config.ComplianceConfig{Routes: []string{"POST users"}}); err == nil {
+		t.Error("Expected an error for a route without a leading slash")
+	}
+}
+
+// serve handles a request with the archive middleware on an /api/v1 group
+// and a user set by inner auth middleware
+func serve(archive *Archive, userID uuid.UUID, req *http.Request, handler echo.HandlerFunc) *httptest.ResponseRecorder {
+	e := echo.New()
+	api := e.Group("/api/v1", archive.Middleware())
+	api.POST("/things/:id", handler, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			authctx.Set(c, &authctx.User{ID: userID})
+			return next(c)
+		}
+	})
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestMiddleware_ArchivesRedactedRequest(t *testing.T) {
+	store := &memoryStore{}
+	archive := newTestArchive(t, store, config.ComplianceConfig{
+		Routes:        []string{"POST /api/v1/things/:id"},
+		CaptureBodies: true,
+		RedactKeys:    []string{"password", "token"},
+	})
+	userID := uuid.New()
+
+	body := `{"name":"widget","password":"hunter2","nested":{"api_token":"abc"}}`
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/things/42?token=secret&page=2", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(echo.HeaderXRealIP, "203.0.113.7")
+
+	var seen string
+	rec := serve(archive, userID, req, func(c echo.Context) error {
+		raw, _ := io.ReadAll(c.Request().Body)
+		seen = string(raw)
+		return c.NoContent(http.StatusCreated)
+	})
+
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d", rec.Code)
+	}
+	if seen != body {
+		t.Errorf("Expected the handler to read the full body, got %q", seen)
+	}
+
+	records := store.all()
+	if len(records) != 1 {
+		t.Fatalf("Expected 1 archived request, got %d", len(records))
+	}
+	r := records[0]
+	if r.Method != http.MethodPost || r.Route != "/api/v1/things/:id" || r.Path != "/api/v1/things/42" {
+		t.Errorf("Unexpected request %s %s %s", r.Method, r.Route, r.Path)
+	}
+	if r.Status != http.StatusCreated {
+		t.Errorf("Expected status 201, got %d", r.Status)
+	}
+	if r.UserID == nil || *r.UserID != userID {
+		t.Errorf("Expected user %s, got %v", userID, r.UserID)
+	}
+	if r.TenantID != tenant.DefaultID {
+		t.Errorf("Expected the default tenant, got %s", r.TenantID)
+	}
+	if r.IPAddress != "203.0.113.7" {
+		t.Errorf("Expected IP 203.0.113.7, got %q", r.IPAddress)
+	}
+	if !r.CreatedAt.Equal(testNow) {
+		t.Errorf("Expected created at %s, got %s", testNow, r.CreatedAt)
+	}
+	if strings.Contains(r.Query, "secret") || !strings.Contains(r.Query, "page=2") {
+		t.Errorf("Expected the token parameter redacted, got %q", r.Query)
+	}
+
+	var archived map[string]interface{}
+	if err := json.Unmarshal(r.Body, &archived); err != nil {
+		t.Fatalf("Archived body isn't JSON: %v", err)
+	}
+	if archived["name"] != "widget" || archived["password"] != "[REDACTED]" {
+		t.Errorf("Unexpected archived body %s", r.Body)
+	}
+	if nested := archived["nested"].(map[string]interface{}); nested["api_token"] != "[REDACTED]" {
+		t.Errorf("Expected nested token redacted, got %s", r.Body)
+	}
+}
+
+func TestMiddleware_LargeBodyAndErrors(t *testing.T) {
+	store := &memoryStore{}
+	archive := newTestArchive(t, store, config.ComplianceConfig{
+		Routes:        []string{"/api/v1/things/*"},
+		CaptureBodies: true,
+		MaxBodyBytes:  16,
+	})
+
+	body := `{"description":"longer than sixteen bytes"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/things/42", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+
+	var seen string
+	rec := serve(archive, uuid.New(), req, func(c echo.Context) error {
+		raw, _ := io.ReadAll(c.Request().Body)
+		seen = string(raw)
+		return echo.NewHTTPError(http.StatusConflict, "already exists")
+	})
+
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("Expected 409, got %d", rec.Code)
+	}
+	if seen != body {
+		t.Errorf("Expected the handler to read the full body, got %q", seen)
+	}
+	records := store.all()
+	if len(records) != 1 {
+		t.Fatalf("Expected 1 archived request, got %d", len(records))
+	}
+	if records[0].Status != http.StatusConflict {
+		t.Errorf("Expected the error status archived, got %d", records[0].Status)
+	}
+	if records[0].Body != nil || !records[0].BodyTruncated {
+		t.Errorf("Expected the body marked truncated, got %s, %v", records[0].Body, records[0].BodyTruncated)
+	}
+}
+
+func TestMiddleware_SkipsOtherRoutes(t *testing.T) {
+	store := &memoryStore{}
+	archive := newTestArchive(t, store, config.ComplianceConfig{Routes: []string{"DELETE /api/v1/things/:id"}})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/things/42", nil)
+	serve(archive, uuid.New(), req, func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+	if records := store.all(); len(records) != 0 {
+		t.Errorf("Expected nothing archived, got %d requests", len(records))
+	}
+}
+
+func TestArchive_ExportPages(t *testing.T) {
+	store := &memoryStore{}
+	archive := newTestArchive(t, store, config.ComplianceConfig{})
+	userID := uuid.New()
+
+	// One more than a page, all at the same time so only the IDs order them
+	for i := 0; i < exportPageSize+1; i++ {
+		store.Append(context.Background(), &Record{ID: uuid.New(), UserID: &userID, CreatedAt: testNow})
+	}
+	store.Append(context.Background(), &Record{ID: uuid.New(), CreatedAt: testNow})
+	store.Append(context.Background(), &Record{ID: uuid.New(), UserID: &userID, CreatedAt: testNow.Add(time.Hour)})
+
+	filter := Filter{From: testNow, To: testNow.Add(time.Hour), UserID: &userID}
+	seen := make(map[uuid.UUID]bool)
+	err := archive.Export(context.Background(), filter, func(r *Record) error {
+		seen[r.ID] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if len(seen) != exportPageSize+1 {
+		t.Errorf("Expected %d requests, got %d", exportPageSize+1, len(seen))
+	}
+
+	err = archive.Export(context.Background(), Filter{From: testNow, To: testNow}, func(*Record) error { return nil })
+	if err != ErrInvalidRange {
+		t.Errorf("Expected ErrInvalidRange, got %v", err)
+	}
+}
+
+func TestArchive_Prune(t *testing.T) {
+	store := &memoryStore{}
+	archive := newTestArchive(t, store, config.ComplianceConfig{})
+
+	if _, err := archive.Prune(context.Background(), 0); err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	want := []time.Time{testNow, testNow.AddDate(0, 1, 0)}
+	if !slices.EqualFunc(store.prepared, want, time.Time.Equal) {
+		t.Errorf("Expected %v prepared, got %v", want, store.prepared)
+	}
+	if !store.droppedBefore.IsZero() {
+		t.Error("Expected nothing dropped without retention")
+	}
+
+	if _, err := archive.Prune(context.Background(), 24*time.Hour); err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	if want := testNow.Add(-24 * time.Hour); !store.droppedBefore.Equal(want) {
+		t.Errorf("Expected records before %s dropped, got %s", want, store.droppedBefore)
+	}
+}
+
+func TestHandler_Export(t *testing.T) {
+	store := &memoryStore{}
+	archive := newTestArchive(t, store, config.ComplianceConfig{})
+	handler := NewHandler(archive, testutil.Logger())
+	store.Append(context.Background(), &Record{ID: uuid.New(), Method: http.MethodDelete, Route: "/api/v1/users/me", Status: 200, CreatedAt: testNow})
+
+	e := echo.New()
+	export := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/admin/compliance/requests?"+query, nil)
+		rec := httptest.NewRecorder()
+		if err := handler.Export(e.NewContext(req, rec)); err != nil {
+			t.Fatalf("Export failed: %v", err)
+		}
+		return rec
+	}
+
+	rec := export("from=2026-03-01T00:00:00Z&to=2026-04-01T00:00:00Z")
+	if rec.Code != http.StatusOK || rec.Header().Get(echo.HeaderContentType) != "application/x-ndjson" {
+		t.Fatalf("Expected an NDJSON export, got %d %s", rec.Code, rec.Header().Get(echo.HeaderContentType))
+	}
+	var record Record
+	if err := json.Unmarshal(rec.Body.Bytes(), &record); err != nil || record.Route != "/api/v1/users/me" {
+		t.Errorf("Unexpected NDJSON export %q: %v", rec.Body.String(), err)
+	}
+
+	rec = export("from=2026-03-01T00:00:00Z&to=2026-04-01T00:00:00Z&format=csv")
+	rows, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("Export isn't CSV: %v", err)
+	}
+	if len(rows) != 2 || !slices.Equal(rows[0], csvHeader) || rows[1][5] != http.MethodDelete {
+		t.Errorf("Unexpected CSV export %v", rows)
+	}
+
+	for _, query := range []string{
+		"to=2026-04-01T00:00:00Z",
+		"from=2026-04-01T00:00:00Z&to=2026-03-01T00:00:00Z",
+		"from=2026-03-01T00:00:00Z&to=2026-04-01T00:00:00Z&format=xml",
+	} {
+		if rec := export(query); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, rec.Code)
+		}
+	}
+}
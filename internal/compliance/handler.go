@@ -0,0 +1,139 @@
+package compliance
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/pkg/response"
+)
+
+// Export formats
+const (
+	FormatNDJSON = "ndjson"
+	FormatCSV    = "csv"
+)
+
+// csvHeader names the columns of CSV exports
+var csvHeader = []string{
+	"id", "created_at", "request_id", "tenant_id", "user_id", "method", "route", "path", "query",
+	"status", "latency_ms", "ip_address", "user_agent", "body_truncated", "body",
+}
+
+// Handler handles HTTP requests for the request archive
+type Handler struct {
+	archive *Archive
+	logger  *slog.Logger
+}
+
+// NewHandler creates a new compliance handler
+func NewHandler(archive *Archive, logger *slog.Logger) *Handler {
+	return &Handler{archive: archive, logger: logger}
+}
+
+// Export streams archived requests in a time range
+// @Summary Export archived requests
+// @Description Stream the archived requests created in [from, to), oldest first, as newline-delimited JSON or CSV (admin only)
+// @Tags Admin
+// @Security BearerAuth
+// @Produce application/x-ndjson
+// @Produce text/csv
+// @Param from query string true "Start of the range, RFC 3339"
+// @Param to query string true "End of the range (exclusive), RFC 3339"
+// @Param user_id query string false "Only requests by this user"
+// @Param format query string false "ndjson or csv" default(ndjson)
+// @Success 200 {array} Record
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Router /api/v1/admin/compliance/requests [get]
+func (h *Handler) Export(c echo.Context) error {
+	var filter Filter
+	var err error
+	if filter.From, err = time.Parse(time.RFC3339, c.QueryParam("from")); err != nil {
+		return response.BadRequest(c, "Invalid from time, expected RFC 3339")
+	}
+	if filter.To, err = time.Parse(time.RFC3339, c.QueryParam("to")); err != nil {
+		return response.BadRequest(c, "Invalid to time, expected RFC 3339")
+	}
+	if !filter.From.Before(filter.To) {
+		return response.BadRequest(c, "from must be before to")
+	}
+	if raw := c.QueryParam("user_id"); raw != "" {
+		userID, err := uuid.Parse(raw)
+		if err != nil {
+			return response.BadRequest(c, "Invalid user ID")
+		}
+		filter.UserID = &userID
+	}
+
+	format := c.QueryParam("format")
+	if format == "" {
+		format = FormatNDJSON
+	}
+	var write func(*Record) error
+	res := c.Response()
+	switch format {
+	case FormatNDJSON:
+		res.Header().Set(echo.HeaderContentType, "application/x-ndjson")
+		enc := json.NewEncoder(res)
+		write = func(r *Record) error { return enc.Encode(r) }
+	case FormatCSV:
+		res.Header().Set(echo.HeaderContentType, "text/csv")
+		w := csv.NewWriter(res)
+		defer w.Flush()
+		if err := w.Write(csvHeader); err != nil {
+			return err
+		}
+		write = func(r *Record) error { return w.Write(csvRow(r)) }
+	default:
+		return response.BadRequest(c, "format must be ndjson or csv")
+	}
+
+	filename := fmt.Sprintf("requests-%s-%s.%s", filter.From.UTC().Format("20060102T150405Z"), filter.To.UTC().Format("20060102T150405Z"), format)
+	res.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+	res.WriteHeader(http.StatusOK)
+
+	// The status is sent, so failures can only cut the export short
+	ctx := c.Request().Context()
+	if err := h.archive.Export(ctx, filter, write); err != nil && !errors.Is(err, ctx.Err()) {
+		h.logger.ErrorContext(ctx, "failed to export archived requests",
+			slog.Time("from", filter.From),
+			slog.Time("to", filter.To),
+			slog.String("error", err.Error()),
+		)
+	}
+	return nil
+}
+
+// csvRow formats a record as a row of csvHeader columns
+func csvRow(r *Record) []string {
+	userID := ""
+	if r.UserID != nil {
+		userID = r.UserID.String()
+	}
+	return []string{
+		r.ID.String(),
+		r.CreatedAt.UTC().Format(time.RFC3339Nano),
+		r.RequestID,
+		r.TenantID.String(),
+		userID,
+		r.Method,
+		r.Route,
+		r.Path,
+		r.Query,
+		strconv.Itoa(r.Status),
+		strconv.Itoa(r.LatencyMs),
+		r.IPAddress,
+		r.UserAgent,
+		strconv.FormatBool(r.BodyTruncated),
+		string(r.Body),
+	}
+}
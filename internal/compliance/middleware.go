@@ -0,0 +1,111 @@
+package compliance
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/internal/tenant"
+	"github.com/pixperk/goiler/pkg/authctx"
+	"github.com/pixperk/goiler/pkg/redact"
+)
+
+// readCloser closes the original body of a request whose body was read
+// ahead
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// Middleware returns middleware archiving requests to the configured
+// routes once they have been handled. It must run after routing, so use it
+// on a group or route, and after tenant resolution. Failures to archive are
+// logged; the request isn't failed.
+func (a *Archive) Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			if !a.Archives(req.Method, c.Path()) {
+				return next(c)
+			}
+
+			start := a.clock.Now()
+			record := &Record{
+				ID:        a.ids.NewID(),
+				Method:    req.Method,
+				Route:     c.Path(),
+				Path:      req.URL.Path,
+				Query:     redact.Query(req.URL.RawQuery, a.redactKeys),
+				IPAddress: c.RealIP(),
+				UserAgent: req.UserAgent(),
+				CreatedAt: start,
+			}
+			if a.captureBodies {
+				record.Body, record.BodyTruncated = a.captureBody(req)
+			}
+
+			err := next(c)
+			// Write the error response now so its status is archived; the
+			// error handler skips responses that are already committed
+			if err != nil {
+				c.Error(err)
+			}
+
+			// The request may have been replaced, e.g. by tenant resolution
+			ctx := c.Request().Context()
+			record.RequestID = c.Response().Header().Get(echo.HeaderXRequestID)
+			record.TenantID = tenant.ID(ctx)
+			if userID, ok := authctx.UserID(c); ok {
+				record.UserID = &userID
+			}
+			record.Status = c.Response().Status
+			record.LatencyMs = int(a.clock.Since(start).Milliseconds())
+
+			if archiveErr := a.store.Append(context.WithoutCancel(ctx), record); archiveErr != nil {
+				a.logger.ErrorContext(ctx, "failed to archive request",
+					slog.String("method", record.Method),
+					slog.String("route", record.Route),
+					slog.String("request_id", record.RequestID),
+					slog.String("error", archiveErr.Error()),
+				)
+			}
+			return err
+		}
+	}
+}
+
+// captureBody returns the redacted JSON body of req, leaving the body
+// readable by the handler. Bodies over the size limit are reported as
+// truncated, and bodies that aren't JSON are skipped.
+func (a *Archive) captureBody(req *http.Request) (json.RawMessage, bool) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, false
+	}
+	if !strings.HasPrefix(req.Header.Get(echo.HeaderContentType), echo.MIMEApplicationJSON) {
+		return nil, false
+	}
+
+	head, err := io.ReadAll(io.LimitReader(req.Body, int64(a.maxBodyBytes)+1))
+	req.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(head), req.Body), Closer: req.Body}
+	if err != nil {
+		return nil, false
+	}
+	if len(head) > a.maxBodyBytes {
+		return nil, true
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(head, &value); err != nil {
+		return nil, false
+	}
+	redacted, err := json.Marshal(redact.Value(value, a.redactKeys))
+	if err != nil {
+		return nil, false
+	}
+	return redacted, false
+}
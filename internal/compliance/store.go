@@ -0,0 +1,136 @@
+package compliance
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pixperk/goiler/db/sqlc"
+	"github.com/pixperk/goiler/internal/tenant"
+	"github.com/pixperk/goiler/pkg/budget"
+)
+
+// PostgresStore implements Store with the request_archive table, which is
+// partitioned by month. Triggers reject updates and deletes, and retention
+// drops whole partitions.
+type PostgresStore struct {
+	queries *sqlc.Queries
+}
+
+// NewPostgresStore creates a new PostgreSQL archive store
+func NewPostgresStore(db *pgxpool.Pool) *PostgresStore {
+	return &PostgresStore{queries: sqlc.New(db)}
+}
+
+// Append archives a request
+func (s *PostgresStore) Append(ctx context.Context, record *Record) error {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	params := sqlc.CreateArchivedRequestParams{
+		ID:            record.ID,
+		RequestID:     record.RequestID,
+		TenantID:      record.TenantID,
+		Method:        record.Method,
+		Route:         record.Route,
+		Path:          record.Path,
+		Query:         record.Query,
+		Status:        int32(record.Status),
+		LatencyMs:     int32(record.LatencyMs),
+		IpAddress:     record.IPAddress,
+		UserAgent:     record.UserAgent,
+		Body:          record.Body,
+		BodyTruncated: record.BodyTruncated,
+		CreatedAt:     sql.NullTime{Time: record.CreatedAt, Valid: true},
+	}
+	if record.UserID != nil {
+		params.UserID = pgtype.UUID{Bytes: *record.UserID, Valid: true}
+	}
+	return s.queries.CreateArchivedRequest(ctx, params)
+}
+
+// List returns archived requests to the tenant scope of ctx matching
+// filter after the given record
+func (s *PostgresStore) List(ctx context.Context, filter Filter, after *Record, limit int) ([]*Record, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	// Every record at From sorts after (From, nil ID)
+	params := sqlc.ListArchivedRequestsParams{
+		FromTime:       sql.NullTime{Time: filter.From, Valid: true},
+		ToTime:         sql.NullTime{Time: filter.To, Valid: true},
+		AfterCreatedAt: sql.NullTime{Time: filter.From, Valid: true},
+		AfterID:        uuid.Nil,
+		TenantID:       tenant.ScopeParam(ctx),
+		MaxRequests:    int32(limit),
+	}
+	if after != nil {
+		params.AfterCreatedAt = sql.NullTime{Time: after.CreatedAt, Valid: true}
+		params.AfterID = after.ID
+	}
+	if filter.UserID != nil {
+		params.UserID = pgtype.UUID{Bytes: *filter.UserID, Valid: true}
+	}
+
+	rows, err := s.queries.ListArchivedRequests(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]*Record, len(rows))
+	for i, row := range rows {
+		records[i] = &Record{
+			ID:            row.ID,
+			RequestID:     row.RequestID,
+			TenantID:      row.TenantID,
+			Method:        row.Method,
+			Route:         row.Route,
+			Path:          row.Path,
+			Query:         row.Query,
+			Status:        int(row.Status),
+			LatencyMs:     int(row.LatencyMs),
+			IPAddress:     row.IpAddress,
+			UserAgent:     row.UserAgent,
+			Body:          row.Body,
+			BodyTruncated: row.BodyTruncated,
+			CreatedAt:     row.CreatedAt.Time,
+		}
+		if row.UserID.Valid {
+			userID := uuid.UUID(row.UserID.Bytes)
+			records[i].UserID = &userID
+		}
+	}
+	return records, nil
+}
+
+// Prepare creates the partition for the month of the given time
+func (s *PostgresStore) Prepare(ctx context.Context, at time.Time) error {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return s.queries.CreateRequestArchivePartition(ctx, sql.NullTime{Time: at, Valid: true})
+}
+
+// DropBefore drops the partitions of months that ended before the given
+// time
+func (s *PostgresStore) DropBefore(ctx context.Context, before time.Time) (int, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer cancel()
+
+	dropped, err := s.queries.DropRequestArchivePartitions(ctx, sql.NullTime{Time: before, Valid: true})
+	return int(dropped), err
+}
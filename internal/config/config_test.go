@@ -0,0 +1,188 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// envSamples are values of each variable type that differ from every default
+var envSamples = map[string]string{
+	EnvString:   "sample",
+	EnvInteger:  "42",
+	EnvNumber:   "0.25",
+	EnvDuration: "42s",
+	EnvList:     "a,b",
+}
+
+func sampleValue(v EnvVar) string {
+	switch v.Type {
+	case EnvBoolean:
+		if v.Default == "true" {
+			return "false"
+		}
+		return "true"
+	case EnvMap:
+		if v.Name == "RBAC_ROLES" {
+			return "role=a:read b:write"
+		}
+		return "key=42s"
+	}
+	return envSamples[v.Type]
+}
+
+func TestEnvVars_MatchLoad(t *testing.T) {
+	vars, err := EnvVars(nil)
+	if err != nil {
+		t.Fatalf("EnvVars failed: %v", err)
+	}
+
+	seen := make(map[string]bool)
+	for _, v := range vars {
+		if seen[v.Name] {
+			t.Errorf("Variable %s is declared twice", v.Name)
+		}
+		seen[v.Name] = true
+
+		sample := sampleValue(v)
+		cfg := load(environment{lookup: func(key string) (string, bool) {
+			if key == v.Name {
+				return sample, true
+			}
+			return "", false
+		}})
+
+		if v.module != "" {
+			field := v.Field[strings.LastIndex(v.Field, ".")+1:]
+			got := reflect.ValueOf(cfg.Log.Modules[v.module]).FieldByName(field)
+			if !got.IsValid() || got.String() != sample {
+				t.Errorf("%s: expected %s to be %q, got %v", v.Name, v.Field, sample, got)
+			}
+			continue
+		}
+
+		_, got, err := envValue(reflect.ValueOf(cfg).Elem().FieldByIndex(v.index))
+		if err != nil {
+			t.Fatalf("%s: %v", v.Name, err)
+		}
+		if got != sample {
+			t.Errorf("%s: expected %s to be %q, got %q", v.Name, v.Field, sample, got)
+		}
+	}
+
+	for _, name := range []string{"APP_ENV", "DATABASE_URL", "LOG_WEBSOCKET_LEVEL", "AUTH_IMPERSONATION_PROTECTED_ROLES"} {
+		if !seen[name] {
+			t.Errorf("Expected %s to be listed", name)
+		}
+	}
+}
+
+func TestEnvVars_ModuleOverrides(t *testing.T) {
+	cfg := load(environment{lookup: func(key string) (string, bool) {
+		values := map[string]string{"LOG_WEBSOCKET_LEVEL": "warn", "LOG_AUTH_FORMAT": "text"}
+		value, ok := values[key]
+		return value, ok
+	}})
+
+	want := map[string]ModuleLogConfig{
+		"websocket": {Level: "warn"},
+		"auth":      {Format: "text"},
+	}
+	if !reflect.DeepEqual(cfg.Log.Modules, want) {
+		t.Errorf("Expected modules %v, got %v", want, cfg.Log.Modules)
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	tests := map[time.Duration]string{
+		0:                       "0s",
+		250 * time.Millisecond:  "250ms",
+		30 * time.Second:        "30s",
+		time.Minute:             "1m",
+		90 * time.Second:        "1m30s",
+		time.Hour:               "1h",
+		168 * time.Hour:         "168h",
+		time.Hour + time.Minute: "1h1m",
+	}
+	for d, want := range tests {
+		if got := formatDuration(d); got != want {
+			t.Errorf("formatDuration(%s) = %q, want %q", d, got, want)
+		}
+	}
+}
+
+func TestMissingInProduction(t *testing.T) {
+	vars, err := EnvVars(nil)
+	if err != nil {
+		t.Fatalf("EnvVars failed: %v", err)
+	}
+	env := map[string]string{"DATABASE_URL": "postgres://db/goiler", "JWT_SECRET": ""}
+	for _, v := range vars {
+		if v.RequiredInProduction && v.Name != "DATABASE_URL" && v.Name != "JWT_SECRET" {
+			env[v.Name] = "set"
+		}
+	}
+
+	missing, err := MissingInProduction(func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	})
+	if err != nil {
+		t.Fatalf("MissingInProduction failed: %v", err)
+	}
+	// Empty values count as missing
+	if want := []string{"JWT_SECRET"}; !slices.Equal(missing, want) {
+		t.Errorf("Expected %v missing, got %v", want, missing)
+	}
+}
+
+// TestGeneratedFiles fails when the checked-in .env.example or
+// env.schema.json is out of date with the config structs
+func TestGeneratedFiles(t *testing.T) {
+	docs, err := ReadFieldDocs("config.go")
+	if err != nil {
+		t.Fatalf("ReadFieldDocs failed: %v", err)
+	}
+	vars, err := EnvVars(docs)
+	if err != nil {
+		t.Fatalf("EnvVars failed: %v", err)
+	}
+
+	var example bytes.Buffer
+	if err := WriteEnvExample(&example, vars); err != nil {
+		t.Fatalf("WriteEnvExample failed: %v", err)
+	}
+	schema, err := EnvJSONSchema(vars)
+	if err != nil {
+		t.Fatalf("EnvJSONSchema failed: %v", err)
+	}
+
+	var parsed struct {
+		Properties     map[string]any `json:"properties"`
+		RequiredInProd []string       `json:"x-required-in-production"`
+	}
+	if err := json.Unmarshal(schema, &parsed); err != nil {
+		t.Fatalf("Schema isn't valid JSON: %v", err)
+	}
+	if len(parsed.Properties) != len(vars) {
+		t.Errorf("Expected %d properties, got %d", len(vars), len(parsed.Properties))
+	}
+	if !slices.Contains(parsed.RequiredInProd, "DATABASE_URL") {
+		t.Errorf("Expected DATABASE_URL to be required in production, got %v", parsed.RequiredInProd)
+	}
+
+	for path, want := range map[string][]byte{"../../.env.example": example.Bytes(), "../../env.schema.json": schema} {
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Read %s: %v", path, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s is out of date; run make env-example", path)
+		}
+	}
+}
@@ -0,0 +1,477 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Every configuration field carries an env tag naming the variable it is
+// read from, optionally followed by options:
+//
+//	Secret string `env:"JWT_SECRET,required_in_prod" example:"change-me"`
+//
+// required_in_prod marks variables whose default is only fit for
+// development. The example tag documents an empty default. Nested structs
+// need no tag; env:"-" skips a field.
+
+// Value types of environment variables
+const (
+	EnvString   = "string"
+	EnvInteger  = "integer"
+	EnvNumber   = "number"
+	EnvBoolean  = "boolean"
+	EnvDuration = "duration"
+	// EnvList is a comma-separated list
+	EnvList = "list"
+	// EnvMap is a comma-separated list of key=value entries
+	EnvMap = "map"
+)
+
+// EnvVar describes an environment variable read by Load
+type EnvVar struct {
+	Name string
+	// Field is the path of the Config field it sets, such as "App.Port"
+	Field string
+	// Section is the top-level group the variable belongs to, such as
+	// "Database"
+	Section string
+	// Group is the struct within the section, such as "Auth.Lockout", and
+	// GroupDoc its description
+	Group    string
+	GroupDoc string
+	Type     string
+	// Default is the value used when the variable is unset, formatted as it
+	// would be set
+	Default              string
+	Example              string
+	RequiredInProduction bool
+	Doc                  string
+
+	index  []int
+	module string // LogModules entry, for per-module log overrides
+}
+
+var (
+	durationType   = reflect.TypeOf(time.Duration(0))
+	moduleLogsType = reflect.TypeOf(map[string]ModuleLogConfig(nil))
+)
+
+// EnvVars lists every variable Load reads, in declaration order, with the
+// defaults from Defaults. Descriptions are taken from docs, which may be
+// nil.
+func EnvVars(docs FieldDocs) ([]EnvVar, error) {
+	defaults := reflect.ValueOf(Defaults()).Elem()
+	t := defaults.Type()
+
+	var vars []EnvVar
+	for i := range t.NumField() {
+		f := t.Field(i)
+		section := docs.text(t.Name() + "." + f.Name)
+		if section == "" {
+			section = f.Name
+		}
+		w := envWalker{docs: docs, section: section}
+		if err := w.walk(&vars, defaults.Field(i), f.Name, []int{i}, ""); err != nil {
+			return nil, err
+		}
+	}
+	return vars, nil
+}
+
+// envWalker collects the variables of one section
+type envWalker struct {
+	docs    FieldDocs
+	section string
+}
+
+func (w envWalker) walk(vars *[]EnvVar, v reflect.Value, path string, index []int, groupDoc string) error {
+	t := v.Type()
+	names := envNames(t)
+	groupDoc = names.replace(groupDoc)
+	for i := range t.NumField() {
+		f := t.Field(i)
+		fieldPath := path + "." + f.Name
+		fieldIndex := append(slices.Clone(index), i)
+		doc := names.replace(w.docs.field(t.Name(), f.Name))
+
+		tag, tagged := f.Tag.Lookup("env")
+		switch {
+		case tag == "-":
+			continue
+		case f.Type == moduleLogsType:
+			*vars = append(*vars, w.moduleVars(fieldPath, doc)...)
+			continue
+		case !tagged && f.Type.Kind() == reflect.Struct:
+			nestedDoc := doc
+			if nestedDoc == "" {
+				nestedDoc = w.docs.typeDoc(f.Type.Name())
+			}
+			if err := w.walk(vars, v.Field(i), fieldPath, fieldIndex, nestedDoc); err != nil {
+				return err
+			}
+			continue
+		case !tagged:
+			return fmt.Errorf("config field %s has no env tag", fieldPath)
+		}
+
+		name, opts, _ := strings.Cut(tag, ",")
+		typ, def, err := envValue(v.Field(i))
+		if err != nil {
+			return fmt.Errorf("config field %s: %w", fieldPath, err)
+		}
+		*vars = append(*vars, EnvVar{
+			Name:                 name,
+			Field:                fieldPath,
+			Section:              w.section,
+			Group:                path,
+			GroupDoc:             groupDoc,
+			Type:                 typ,
+			Default:              def,
+			Example:              f.Tag.Get("example"),
+			RequiredInProduction: slices.Contains(strings.Split(opts, ","), "required_in_prod"),
+			Doc:                  doc,
+			index:                fieldIndex,
+		})
+	}
+	return nil
+}
+
+// fieldNames maps the field names of a struct to their variables
+type fieldNames map[string]string
+
+// envNames returns the variables of t's tagged fields
+func envNames(t reflect.Type) fieldNames {
+	names := fieldNames{}
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if name, _, _ := strings.Cut(f.Tag.Get("env"), ","); name != "" && name != "-" {
+			names[f.Name] = name
+		}
+	}
+	return names
+}
+
+// goIdentifier matches exported Go identifiers in doc comments
+var goIdentifier = regexp.MustCompile(`\b[A-Z][A-Za-z0-9]*\b`)
+
+// replace rewrites the field names in a doc comment to their variables, so
+// "used when JWTPrivateKey is empty" reads "used when JWT_PRIVATE_KEY is
+// empty"
+func (n fieldNames) replace(doc string) string {
+	return goIdentifier.ReplaceAllStringFunc(doc, func(word string) string {
+		if name, ok := n[word]; ok {
+			return name
+		}
+		return word
+	})
+}
+
+// moduleVars lists the LOG_<MODULE>_LEVEL and LOG_<MODULE>_FORMAT
+// overrides of each of LogModules
+func (w envWalker) moduleVars(path, doc string) []EnvVar {
+	doc = strings.TrimSpace(doc + " Modules: " + strings.Join(LogModules, ", ") + ".")
+	var vars []EnvVar
+	for _, module := range LogModules {
+		prefix := "LOG_" + strings.ToUpper(module) + "_"
+		for _, field := range []string{"Level", "Format"} {
+			vars = append(vars, EnvVar{
+				Name:     prefix + strings.ToUpper(field),
+				Field:    fmt.Sprintf("%s[%s].%s", path, module, field),
+				Section:  w.section,
+				Group:    path,
+				GroupDoc: doc,
+				Type:     EnvString,
+				Doc:      w.docs.field("ModuleLogConfig", field),
+				module:   module,
+			})
+		}
+	}
+	return vars
+}
+
+// envValue returns the variable type of v and v formatted as a value
+func envValue(v reflect.Value) (string, string, error) {
+	if v.Type() == durationType {
+		return EnvDuration, formatDuration(time.Duration(v.Int())), nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return EnvString, v.String(), nil
+	case reflect.Int:
+		return EnvInteger, strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Float64:
+		return EnvNumber, strconv.FormatFloat(v.Float(), 'g', -1, 64), nil
+	case reflect.Bool:
+		return EnvBoolean, strconv.FormatBool(v.Bool()), nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.String {
+			return EnvList, strings.Join(v.Interface().([]string), ","), nil
+		}
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			break
+		}
+		entries := make([]string, 0, v.Len())
+		for _, key := range v.MapKeys() {
+			_, value, err := envValue(v.MapIndex(key))
+			if err != nil {
+				return "", "", err
+			}
+			if v.Type().Elem().Kind() == reflect.Slice {
+				value = strings.ReplaceAll(value, ",", " ")
+			}
+			entries = append(entries, key.String()+"="+value)
+		}
+		sort.Strings(entries)
+		return EnvMap, strings.Join(entries, ","), nil
+	}
+	return "", "", fmt.Errorf("unsupported type %s", v.Type())
+}
+
+// formatDuration formats d the way it is usually written, 1h rather than
+// 1h0m0s
+func formatDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+// MissingInProduction returns the variables marked required_in_prod that
+// lookup doesn't find
+func MissingInProduction(lookup func(string) (string, bool)) ([]string, error) {
+	vars, err := EnvVars(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	for _, v := range vars {
+		if value, ok := lookup(v.Name); v.RequiredInProduction && (!ok || value == "") {
+			missing = append(missing, v.Name)
+		}
+	}
+	return missing, nil
+}
+
+// FieldDocs holds the doc comments of the config structs, keyed by type
+// name for types and "Type.Field" for fields
+type FieldDocs map[string]string
+
+// ReadFieldDocs reads the doc and line comments of the structs declared in
+// the Go source file at path
+func ReadFieldDocs(path string) (FieldDocs, error) {
+	file, err := parser.ParseFile(token.NewFileSet(), path, nil, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	docs := FieldDocs{}
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			ts := spec.(*ast.TypeSpec)
+			st, ok := ts.Type.(*ast.StructType)
+			if !ok {
+				continue
+			}
+			if gen.Doc != nil {
+				docs[ts.Name.Name] = gen.Doc.Text()
+			}
+			for _, field := range st.Fields.List {
+				text := joinComments(field.Doc, field.Comment)
+				for _, name := range field.Names {
+					if text != "" {
+						docs[ts.Name.Name+"."+name.Name] = text
+					}
+				}
+			}
+		}
+	}
+	return docs, nil
+}
+
+// joinComments joins comment groups into sentences on one line
+func joinComments(groups ...*ast.CommentGroup) string {
+	var parts []string
+	for _, group := range groups {
+		if group == nil {
+			continue
+		}
+		if text := strings.Join(strings.Fields(group.Text()), " "); text != "" {
+			parts = append(parts, sentence(text))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// sentence capitalizes text and ends it with a period
+func sentence(text string) string {
+	r := []rune(text)
+	r[0] = unicode.ToUpper(r[0])
+	if last := r[len(r)-1]; last != '.' && last != ')' {
+		r = append(r, '.')
+	}
+	return string(r)
+}
+
+func (d FieldDocs) text(key string) string {
+	return strings.TrimSuffix(strings.Join(strings.Fields(d[key]), " "), ".")
+}
+
+func (d FieldDocs) field(typeName, field string) string {
+	return d[typeName+"."+field]
+}
+
+// typeDoc returns a type's doc comment without the leading type name
+func (d FieldDocs) typeDoc(typeName string) string {
+	text := strings.Join(strings.Fields(d[typeName]), " ")
+	if rest, ok := strings.CutPrefix(text, typeName+" "); ok && rest != "" {
+		return sentence(rest)
+	}
+	return text
+}
+
+// exampleWidth is the width .env.example comments are wrapped to
+const exampleWidth = 76
+
+// WriteEnvExample writes vars as a .env file: each variable set to its
+// default under its description. Variables without a default but with an
+// example are written commented out with the example.
+func WriteEnvExample(w io.Writer, vars []EnvVar) error {
+	var buf bytes.Buffer
+	buf.WriteString("# Generated from internal/config by \"goiler env\"; do not edit.\n")
+	buf.WriteString("# Document variables on their Config fields instead.\n")
+
+	section, group := "", ""
+	for _, v := range vars {
+		if v.Section != section {
+			fmt.Fprintf(&buf, "\n# %s\n", v.Section)
+			section, group = v.Section, v.Group
+			if v.GroupDoc != "" {
+				writeComment(&buf, v.GroupDoc)
+			}
+		} else if v.Group != group {
+			group = v.Group
+			buf.WriteString("\n")
+			if v.GroupDoc != "" {
+				writeComment(&buf, v.GroupDoc)
+			}
+		}
+
+		doc := v.Doc
+		if v.RequiredInProduction {
+			doc = strings.TrimSpace(doc + " Required in production.")
+		}
+		if doc != "" {
+			writeComment(&buf, doc)
+		}
+		switch {
+		case v.Default == "" && v.Example != "":
+			fmt.Fprintf(&buf, "# %s=%s\n", v.Name, v.Example)
+		default:
+			fmt.Fprintf(&buf, "%s=%s\n", v.Name, v.Default)
+		}
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// writeComment writes text as # comment lines wrapped to exampleWidth
+func writeComment(buf *bytes.Buffer, text string) {
+	line := "#"
+	for _, word := range strings.Fields(text) {
+		if len(line)+1+len(word) > exampleWidth && line != "#" {
+			buf.WriteString(line + "\n")
+			line = "#"
+		}
+		line += " " + word
+	}
+	buf.WriteString(line + "\n")
+}
+
+// envPatterns validate the string values of typed variables
+var envPatterns = map[string]string{
+	EnvInteger:  `^-?[0-9]+$`,
+	EnvNumber:   `^-?[0-9]+(\.[0-9]+)?([eE][-+]?[0-9]+)?$`,
+	EnvDuration: `^(0|-?([0-9]+(\.[0-9]*)?(ns|us|µs|ms|s|m|h))+)$`,
+}
+
+// envBooleans are the values strconv.ParseBool accepts
+var envBooleans = []string{"1", "t", "T", "TRUE", "true", "True", "0", "f", "F", "FALSE", "false", "False"}
+
+// EnvJSONSchema returns a JSON Schema of the environment: an object of
+// string values, typed by pattern, with defaults and descriptions. The
+// logical type, config field and production requirement are recorded in
+// x- keywords.
+func EnvJSONSchema(vars []EnvVar) ([]byte, error) {
+	properties := make(map[string]any, len(vars))
+	requiredInProd := []string{}
+	for _, v := range vars {
+		prop := map[string]any{
+			"type":      "string",
+			"x-type":    v.Type,
+			"x-field":   v.Field,
+			"x-section": v.Section,
+		}
+		if v.Doc != "" {
+			prop["description"] = v.Doc
+		}
+		if v.Default != "" {
+			prop["default"] = v.Default
+		}
+		if v.Example != "" {
+			prop["examples"] = []string{v.Example}
+		}
+		if pattern, ok := envPatterns[v.Type]; ok {
+			prop["pattern"] = pattern
+		}
+		if v.Type == EnvBoolean {
+			prop["enum"] = envBooleans
+		}
+		if v.RequiredInProduction {
+			prop["x-required-in-production"] = true
+			requiredInProd = append(requiredInProd, v.Name)
+		}
+		properties[v.Name] = prop
+	}
+
+	schema := map[string]any{
+		"$schema":                  "https://json-schema.org/draft/2020-12/schema",
+		"title":                    "goiler environment",
+		"description":              "Environment variables read by internal/config. Generated by \"goiler env\"; do not edit.",
+		"type":                     "object",
+		"properties":               properties,
+		"x-required-in-production": requiredInProd,
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(schema); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
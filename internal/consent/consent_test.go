@@ -0,0 +1,286 @@
+package consent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/internal/auth"
+	"github.com/pixperk/goiler/internal/testutil"
+	"github.com/pixperk/goiler/pkg/clock"
+	"github.com/pixperk/goiler/pkg/pagination"
+)
+
+type memoryRepository struct {
+	mu          sync.Mutex
+	policies    []*Policy
+	acceptances []*Acceptance
+	users       int64
+	listCalls   int
+}
+
+func (r *memoryRepository) CreatePolicy(_ context.Context, policy *Policy) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, p := range r.policies {
+		if p.Kind == policy.Kind && p.Version == policy.Version {
+			return ErrPolicyExists
+		}
+	}
+	r.policies = append(r.policies, policy)
+	return nil
+}
+
+func (r *memoryRepository) GetPolicy(_ context.Context, id uuid.UUID) (*Policy, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, p := range r.policies {
+		if p.ID == id {
+			return p, nil
+		}
+	}
+	return nil, ErrPolicyNotFound
+}
+
+func (r *memoryRepository) ListPolicies(_ context.Context) ([]*Policy, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.listCalls++
+	return append([]*Policy(nil), r.policies...), nil
+}
+
+func (r *memoryRepository) CreateAcceptance(_ context.Context, acceptance *Acceptance) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, a := range r.acceptances {
+		if a.UserID == acceptance.UserID && a.PolicyID == acceptance.PolicyID {
+			return nil
+		}
+	}
+	r.acceptances = append(r.acceptances, acceptance)
+	return nil
+}
+
+func (r *memoryRepository) ListUserAcceptances(_ context.Context, userID uuid.UUID) ([]*Acceptance, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var result []*Acceptance
+	for _, a := range r.acceptances {
+		if a.UserID == userID {
+			result = append(result, a)
+		}
+	}
+	return result, nil
+}
+
+func (r *memoryRepository) CountAcceptances(_ context.Context, policyID uuid.UUID) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var count int64
+	for _, a := range r.acceptances {
+		if a.PolicyID == policyID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *memoryRepository) CountUsers(_ context.Context) (int64, error) {
+	return r.users, nil
+}
+
+func (r *memoryRepository) ListAcceptances(_ context.Context, policyID uuid.UUID, limit, offset int) ([]*PolicyUser, error) {
+	return nil, nil
+}
+
+func (r *memoryRepository) ListUsersWithoutAcceptance(_ context.Context, policyID uuid.UUID, limit, offset int) ([]*PolicyUser, error) {
+	return nil, nil
+}
+
+func newTestService(t *testing.T) (*Service, *memoryRepository, *clock.Frozen) {
+	t.Helper()
+	repo := &memoryRepository{}
+	clk := testutil.Clock()
+	svc := NewService(repo,
+		WithClock(clk),
+		WithLogger(testutil.Logger()),
+	)
+	return svc, repo, clk
+}
+
+func mustCreatePolicy(t *testing.T, svc *Service, req CreatePolicyRequest) *Policy {
+	t.Helper()
+	policy, err := svc.CreatePolicy(context.Background(), &req)
+	if err != nil {
+		t.Fatalf("Failed to create policy: %v", err)
+	}
+	return policy
+}
+
+func TestPending_MandatoryVersions(t *testing.T) {
+	svc, _, clk := newTestService(t)
+	ctx := context.Background()
+	userID := uuid.New()
+
+	v1 := mustCreatePolicy(t, svc, CreatePolicyRequest{Kind: "terms", Version: "1", Mandatory: true})
+	pending, err := svc.Pending(ctx, userID)
+	if err != nil {
+		t.Fatalf("Pending failed: %v", err)
+	}
+	if len(pending) != 1 || pending[0].ID != v1.ID {
+		t.Fatalf("Expected terms v1 pending, got %+v", pending)
+	}
+
+	if _, err := svc.Accept(ctx, userID, v1.ID, "203.0.113.1", "test"); err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+	if pending, _ := svc.Pending(ctx, userID); len(pending) != 0 {
+		t.Fatalf("Expected nothing pending after accepting, got %+v", pending)
+	}
+
+	// An optional update is offered but doesn't block
+	clk.Advance(time.Hour)
+	v2 := mustCreatePolicy(t, svc, CreatePolicyRequest{Kind: "terms", Version: "2"})
+	if pending, _ := svc.Pending(ctx, userID); len(pending) != 0 {
+		t.Fatalf("Expected optional version not to block, got %+v", pending)
+	}
+	status, err := svc.Status(ctx, userID)
+	if err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	if len(status.Policies) != 1 || status.Policies[0].ID != v2.ID || status.Policies[0].Accepted {
+		t.Errorf("Expected unaccepted v2 to be current, got %+v", status.Policies)
+	}
+
+	// A mandatory update blocks until the current version is accepted
+	clk.Advance(time.Hour)
+	v3 := mustCreatePolicy(t, svc, CreatePolicyRequest{Kind: "terms", Version: "3", Mandatory: true})
+	pending, _ = svc.Pending(ctx, userID)
+	if len(pending) != 1 || pending[0].ID != v3.ID {
+		t.Fatalf("Expected terms v3 pending, got %+v", pending)
+	}
+
+	if _, err := svc.Accept(ctx, userID, v1.ID, "", ""); err != ErrPolicyNotCurrent {
+		t.Errorf("Expected ErrPolicyNotCurrent accepting an old version, got %v", err)
+	}
+	status, err = svc.Accept(ctx, userID, v3.ID, "", "")
+	if err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+	if status.Blocked {
+		t.Error("Expected user not to be blocked after accepting v3")
+	}
+}
+
+func TestPending_ScheduledPolicy(t *testing.T) {
+	svc, _, clk := newTestService(t)
+	ctx := context.Background()
+	userID := uuid.New()
+
+	effectiveAt := clk.Now().Add(24 * time.Hour)
+	mustCreatePolicy(t, svc, CreatePolicyRequest{Kind: "privacy", Version: "2024-02", Mandatory: true, EffectiveAt: &effectiveAt})
+
+	if pending, _ := svc.Pending(ctx, userID); len(pending) != 0 {
+		t.Fatalf("Expected scheduled policy not to block yet, got %+v", pending)
+	}
+
+	// Past the cache TTL and the effective date
+	clk.Advance(25 * time.Hour)
+	if pending, _ := svc.Pending(ctx, userID); len(pending) != 1 {
+		t.Fatalf("Expected policy to block once effective, got %+v", pending)
+	}
+}
+
+func TestPending_CachesUpToDateUsers(t *testing.T) {
+	svc, repo, clk := newTestService(t)
+	ctx := context.Background()
+	userID := uuid.New()
+
+	for range 3 {
+		if _, err := svc.Pending(ctx, userID); err != nil {
+			t.Fatalf("Pending failed: %v", err)
+		}
+	}
+	if repo.listCalls != 1 {
+		t.Errorf("Expected policies to be loaded once, got %d", repo.listCalls)
+	}
+
+	clk.Advance(DefaultCacheTTL)
+	if _, err := svc.Pending(ctx, userID); err != nil {
+		t.Fatalf("Pending failed: %v", err)
+	}
+	if repo.listCalls != 2 {
+		t.Errorf("Expected policies to be reloaded after the TTL, got %d loads", repo.listCalls)
+	}
+}
+
+func TestPolicyUsers_PendingTotal(t *testing.T) {
+	svc, repo, _ := newTestService(t)
+	ctx := context.Background()
+	repo.users = 5
+
+	policy := mustCreatePolicy(t, svc, CreatePolicyRequest{Kind: "terms", Version: "1"})
+	for range 2 {
+		if _, err := svc.Accept(ctx, uuid.New(), policy.ID, "", ""); err != nil {
+			t.Fatalf("Accept failed: %v", err)
+		}
+	}
+
+	if _, total, _ := svc.PolicyUsers(ctx, policy.ID, StatusAccepted, pagination.ListParams{Page: 1, PerPage: 20}); total != 2 {
+		t.Errorf("Expected 2 accepted, got %d", total)
+	}
+	if _, total, _ := svc.PolicyUsers(ctx, policy.ID, StatusPending, pagination.ListParams{Page: 1, PerPage: 20}); total != 3 {
+		t.Errorf("Expected 3 pending, got %d", total)
+	}
+	if _, _, err := svc.PolicyUsers(ctx, uuid.New(), StatusAccepted, pagination.ListParams{Page: 1, PerPage: 20}); err != ErrPolicyNotFound {
+		t.Errorf("Expected ErrPolicyNotFound, got %v", err)
+	}
+}
+
+func TestRequireAcceptance(t *testing.T) {
+	svc, _, _ := newTestService(t)
+	mustCreatePolicy(t, svc, CreatePolicyRequest{Kind: "terms", Version: "1", Mandatory: true})
+	h := NewHandler(svc, testutil.Logger())
+
+	e := echo.New()
+	mw := h.RequireAcceptance("/api/v1/users/me/consents", "/api/v1/admin/policies", "/api/v1/admin/users/:id/consents")
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusNoContent) }
+
+	tests := []struct {
+		name   string
+		path   string
+		route  string
+		authed bool
+		want   int
+	}{
+		{"blocked", "/api/v1/users/me", "", true, http.StatusForbidden},
+		{"exempt path", "/api/v1/users/me/consents", "", true, http.StatusNoContent},
+		{"exempt admin path", "/api/v1/admin/policies/" + uuid.NewString() + "/users", "", true, http.StatusNoContent},
+		{"exempt admin route", "/api/v1/admin/users/" + uuid.NewString() + "/consents", "/api/v1/admin/users/:id/consents", true, http.StatusNoContent},
+		{"other admin route", "/api/v1/admin/users/" + uuid.NewString() + "/emails", "/api/v1/admin/users/:id/emails", true, http.StatusForbidden},
+		{"anonymous", "/api/v1/users/me", "", false, http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			c := e.NewContext(httptest.NewRequest(http.MethodGet, tt.path, nil), rec)
+			c.SetPath(tt.route)
+			if tt.authed {
+				auth.SetCurrentUser(c, &auth.TokenPayload{UserID: uuid.New()})
+			}
+
+			if err := mw(ok)(c); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if rec.Code != tt.want {
+				t.Errorf("Expected status %d, got %d: %s", tt.want, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,273 @@
+package consent
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/internal/auth"
+	"github.com/pixperk/goiler/pkg/pagination"
+	"github.com/pixperk/goiler/pkg/response"
+	"github.com/pixperk/goiler/pkg/validator"
+)
+
+// ErrCodeAcceptanceRequired is returned while a mandatory policy is pending
+const ErrCodeAcceptanceRequired = "POLICY_ACCEPTANCE_REQUIRED"
+
+// Handler handles HTTP requests for policies and consent
+type Handler struct {
+	service *Service
+	logger  *slog.Logger
+}
+
+// NewHandler creates a new consent handler
+func NewHandler(service *Service, logger *slog.Logger) *Handler {
+	return &Handler{service: service, logger: logger}
+}
+
+// RequireAcceptance blocks authenticated users with a pending mandatory
+// policy. Requests under the exempt prefixes, such as the accept endpoint
+// and the policy admin routes, pass through; a prefix matches the request
+// path or the route's, so "/admin/users/:id/consents" exempts every user's.
+// It must run after the auth middleware; if the store can't be reached
+// requests are let through rather than failing.
+func (h *Handler) RequireAcceptance(exempt ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			payload := auth.GetCurrentUser(c)
+			if payload == nil {
+				return next(c)
+			}
+			path, route := c.Request().URL.Path, c.Path()
+			for _, prefix := range exempt {
+				if strings.HasPrefix(path, prefix) || (route != "" && strings.HasPrefix(route, prefix)) {
+					return next(c)
+				}
+			}
+
+			pending, err := h.service.Pending(c.Request().Context(), payload.UserID)
+			if err != nil {
+				h.logger.Error("failed to check policy acceptance",
+					slog.String("user_id", payload.UserID.String()),
+					slog.String("error", err.Error()),
+				)
+				return next(c)
+			}
+			if len(pending) == 0 {
+				return next(c)
+			}
+
+			details := make(map[string]string, len(pending))
+			for _, p := range pending {
+				details[p.Kind] = p.Version
+			}
+			return response.ErrorWithDetails(c, http.StatusForbidden, ErrCodeAcceptanceRequired,
+				"You must accept the updated policies to continue", details)
+		}
+	}
+}
+
+// ListCurrent returns the policies currently in effect
+// @Summary List current policies
+// @Description List the version of each policy currently in effect
+// @Tags Consent
+// @Produce json
+// @Success 200 {array} Policy
+// @Router /api/v1/policies [get]
+func (h *Handler) ListCurrent(c echo.Context) error {
+	policies, err := h.service.CurrentPolicies(c.Request().Context())
+	if err != nil {
+		return response.InternalError(c, "Failed to load policies")
+	}
+	return response.Success(c, policies)
+}
+
+// GetMyConsents returns the current user's consent status
+// @Summary Get consent status
+// @Description List the current policies and whether the current user accepted them
+// @Tags Consent
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} UserStatus
+// @Failure 401 {object} response.Response
+// @Router /api/v1/users/me/consents [get]
+func (h *Handler) GetMyConsents(c echo.Context) error {
+	payload := auth.GetCurrentUser(c)
+	if payload == nil {
+		return response.Unauthorized(c, "User not authenticated")
+	}
+
+	status, err := h.service.Status(c.Request().Context(), payload.UserID)
+	if err != nil {
+		return response.InternalError(c, "Failed to load consent status")
+	}
+	return response.Success(c, status)
+}
+
+// Accept records that the current user accepted a policy
+// @Summary Accept policy
+// @Description Accept the current version of a policy. The client IP and user agent are recorded.
+// @Tags Consent
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body AcceptRequest true "Policy to accept"
+// @Success 200 {object} UserStatus
+// @Failure 401 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Failure 409 {object} response.Response
+// @Failure 422 {object} response.Response
+// @Router /api/v1/users/me/consents [post]
+func (h *Handler) Accept(c echo.Context) error {
+	payload := auth.GetCurrentUser(c)
+	if payload == nil {
+		return response.Unauthorized(c, "User not authenticated")
+	}
+
+	var req AcceptRequest
+	if err := c.Bind(&req); err != nil {
+		return response.BadRequest(c, "Invalid request body")
+	}
+
+	if err := c.Validate(&req); err != nil {
+		return response.ValidationError(c, validator.FormatErrors(err))
+	}
+
+	status, err := h.service.Accept(c.Request().Context(), payload.UserID, req.PolicyID, c.RealIP(), c.Request().UserAgent())
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrPolicyNotFound):
+			return response.NotFound(c, "Policy not found")
+		case errors.Is(err, ErrPolicyNotCurrent):
+			return response.Conflict(c, "Only the current version of a policy can be accepted")
+		}
+		return response.InternalError(c, "Failed to record acceptance")
+	}
+	return response.Success(c, status)
+}
+
+// CreatePolicy publishes a policy version
+// @Summary Publish policy
+// @Description Publish a new policy version. Once a mandatory version takes effect, users must accept it before using the API (admin only).
+// @Tags Admin
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body CreatePolicyRequest true "Policy version"
+// @Success 201 {object} Policy
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 409 {object} response.Response
+// @Failure 422 {object} response.Response
+// @Router /api/v1/admin/policies [post]
+func (h *Handler) CreatePolicy(c echo.Context) error {
+	var req CreatePolicyRequest
+	if err := c.Bind(&req); err != nil {
+		return response.BadRequest(c, "Invalid request body")
+	}
+
+	if err := c.Validate(&req); err != nil {
+		return response.ValidationError(c, validator.FormatErrors(err))
+	}
+
+	policy, err := h.service.CreatePolicy(c.Request().Context(), &req)
+	if err != nil {
+		if errors.Is(err, ErrPolicyExists) {
+			return response.Conflict(c, "Policy version already exists")
+		}
+		return response.InternalError(c, "Failed to create policy")
+	}
+	return response.Created(c, policy)
+}
+
+// ListPolicies returns every policy version with its acceptance count
+// @Summary List policies
+// @Description List every policy version with the number of users who accepted it (admin only)
+// @Tags Admin
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {array} PolicySummary
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Router /api/v1/admin/policies [get]
+func (h *Handler) ListPolicies(c echo.Context) error {
+	policies, err := h.service.ListPolicies(c.Request().Context())
+	if err != nil {
+		return response.InternalError(c, "Failed to list policies")
+	}
+	return response.Success(c, policies)
+}
+
+// ListPolicyUsers lists the users who have or haven't accepted a policy
+// @Summary List policy acceptances
+// @Description List the users who accepted a policy version, or with status=pending those who haven't (admin only)
+// @Tags Admin
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Policy ID"
+// @Param status query string false "accepted or pending" default(accepted)
+// @Param page query int false "Page number" default(1)
+// @Param per_page query int false "Items per page, at most 100" default(20)
+// @Success 200 {array} PolicyUser
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Failure 422 {object} response.Response
+// @Router /api/v1/admin/policies/{id}/users [get]
+func (h *Handler) ListPolicyUsers(c echo.Context) error {
+	policyID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return response.BadRequest(c, "Invalid policy ID")
+	}
+
+	status := c.QueryParam("status")
+	if status == "" {
+		status = StatusAccepted
+	}
+	if status != StatusAccepted && status != StatusPending {
+		return response.BadRequest(c, "status must be accepted or pending")
+	}
+
+	params, err := pagination.Bind(c, pagination.Options{})
+	if err != nil {
+		return response.ValidationError(c, pagination.Details(err))
+	}
+
+	users, total, err := h.service.PolicyUsers(c.Request().Context(), policyID, status, params)
+	if err != nil {
+		if errors.Is(err, ErrPolicyNotFound) {
+			return response.NotFound(c, "Policy not found")
+		}
+		return response.InternalError(c, "Failed to list policy acceptances")
+	}
+	return response.Paginated(c, users, params.Page, params.PerPage, total)
+}
+
+// GetUserConsents returns a user's consent status
+// @Summary Get user consent status
+// @Description List the current policies and whether a user accepted them (admin only)
+// @Tags Admin
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {object} UserStatus
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Router /api/v1/admin/users/{id}/consents [get]
+func (h *Handler) GetUserConsents(c echo.Context) error {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return response.BadRequest(c, "Invalid user ID")
+	}
+
+	status, err := h.service.Status(c.Request().Context(), userID)
+	if err != nil {
+		return response.InternalError(c, "Failed to load consent status")
+	}
+	return response.Success(c, status)
+}
@@ -0,0 +1,239 @@
+package consent
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pixperk/goiler/db/sqlc"
+	"github.com/pixperk/goiler/internal/tenant"
+	"github.com/pixperk/goiler/pkg/budget"
+)
+
+// uniqueViolation is the Postgres error code for unique constraint violations
+const uniqueViolation = "23505"
+
+// PostgresRepository implements Repository using PostgreSQL
+type PostgresRepository struct {
+	queries *sqlc.Queries
+}
+
+// NewPostgresRepository creates a new PostgreSQL repository
+func NewPostgresRepository(db *pgxpool.Pool) *PostgresRepository {
+	return &PostgresRepository{queries: sqlc.New(db)}
+}
+
+// CreatePolicy stores a new policy version
+func (r *PostgresRepository) CreatePolicy(ctx context.Context, policy *Policy) error {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	err = r.queries.CreatePolicy(ctx, sqlc.CreatePolicyParams{
+		ID:          policy.ID,
+		Kind:        policy.Kind,
+		Version:     policy.Version,
+		Title:       policy.Title,
+		Url:         policy.URL,
+		Mandatory:   policy.Mandatory,
+		EffectiveAt: sql.NullTime{Time: policy.EffectiveAt, Valid: true},
+	})
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		return ErrPolicyExists
+	}
+	return err
+}
+
+// GetPolicy retrieves a policy by ID
+func (r *PostgresRepository) GetPolicy(ctx context.Context, id uuid.UUID) (*Policy, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	dbPolicy, err := r.queries.GetPolicy(ctx, id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrPolicyNotFound
+		}
+		return nil, err
+	}
+	return policyFromDB(dbPolicy), nil
+}
+
+// ListPolicies returns every policy version
+func (r *PostgresRepository) ListPolicies(ctx context.Context) ([]*Policy, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	dbPolicies, err := r.queries.ListPolicies(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	policies := make([]*Policy, len(dbPolicies))
+	for i, p := range dbPolicies {
+		policies[i] = policyFromDB(p)
+	}
+	return policies, nil
+}
+
+// CreateAcceptance records a user accepting a policy
+func (r *PostgresRepository) CreateAcceptance(ctx context.Context, acceptance *Acceptance) error {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	_, err = r.queries.CreatePolicyAcceptance(ctx, sqlc.CreatePolicyAcceptanceParams{
+		UserID:     acceptance.UserID,
+		PolicyID:   acceptance.PolicyID,
+		AcceptedAt: sql.NullTime{Time: acceptance.AcceptedAt, Valid: true},
+		IpAddress:  acceptance.IPAddress,
+		UserAgent:  acceptance.UserAgent,
+	})
+	return err
+}
+
+// ListUserAcceptances returns the policies a user accepted, if the user is
+// in the tenant scope of ctx
+func (r *PostgresRepository) ListUserAcceptances(ctx context.Context, userID uuid.UUID) ([]*Acceptance, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	dbAcceptances, err := r.queries.ListUserPolicyAcceptances(ctx, sqlc.ListUserPolicyAcceptancesParams{
+		UserID:   userID,
+		TenantID: tenant.ScopeParam(ctx),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	acceptances := make([]*Acceptance, len(dbAcceptances))
+	for i, a := range dbAcceptances {
+		acceptances[i] = &Acceptance{
+			UserID:     a.UserID,
+			PolicyID:   a.PolicyID,
+			AcceptedAt: a.AcceptedAt.Time,
+			IPAddress:  a.IpAddress,
+			UserAgent:  a.UserAgent,
+		}
+	}
+	return acceptances, nil
+}
+
+// CountAcceptances returns how many users in the tenant scope of ctx
+// accepted a policy
+func (r *PostgresRepository) CountAcceptances(ctx context.Context, policyID uuid.UUID) (int64, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer cancel()
+
+	return r.queries.CountPolicyAcceptances(ctx, sqlc.CountPolicyAcceptancesParams{
+		PolicyID: policyID,
+		TenantID: tenant.ScopeParam(ctx),
+	})
+}
+
+// CountUsers returns the number of users in the tenant scope of ctx
+func (r *PostgresRepository) CountUsers(ctx context.Context) (int64, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer cancel()
+
+	if scope := tenant.Scope(ctx); scope != nil {
+		return r.queries.CountTenantUsers(ctx, *scope)
+	}
+	return r.queries.CountUsers(ctx)
+}
+
+// ListAcceptances lists the users in the tenant scope of ctx who accepted a
+// policy, most recent first
+func (r *PostgresRepository) ListAcceptances(ctx context.Context, policyID uuid.UUID, limit, offset int) ([]*PolicyUser, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	rows, err := r.queries.ListPolicyAcceptances(ctx, sqlc.ListPolicyAcceptancesParams{
+		PolicyID: policyID,
+		TenantID: tenant.ScopeParam(ctx),
+		Lim:      int32(limit),
+		Off:      int32(offset),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]*PolicyUser, len(rows))
+	for i, row := range rows {
+		acceptedAt := row.AcceptedAt.Time
+		users[i] = &PolicyUser{
+			UserID:     row.UserID,
+			Email:      row.Email,
+			AcceptedAt: &acceptedAt,
+			IPAddress:  row.IpAddress,
+			UserAgent:  row.UserAgent,
+		}
+	}
+	return users, nil
+}
+
+// ListUsersWithoutAcceptance lists the users in the tenant scope of ctx who
+// haven't accepted a policy, oldest accounts first
+func (r *PostgresRepository) ListUsersWithoutAcceptance(ctx context.Context, policyID uuid.UUID, limit, offset int) ([]*PolicyUser, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	rows, err := r.queries.ListUsersWithoutPolicyAcceptance(ctx, sqlc.ListUsersWithoutPolicyAcceptanceParams{
+		PolicyID: policyID,
+		TenantID: tenant.ScopeParam(ctx),
+		Lim:      int32(limit),
+		Off:      int32(offset),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]*PolicyUser, len(rows))
+	for i, row := range rows {
+		users[i] = &PolicyUser{UserID: row.ID, Email: row.Email}
+	}
+	return users, nil
+}
+
+func policyFromDB(p *sqlc.Policy) *Policy {
+	return &Policy{
+		ID:          p.ID,
+		Kind:        p.Kind,
+		Version:     p.Version,
+		Title:       p.Title,
+		URL:         p.Url,
+		Mandatory:   p.Mandatory,
+		EffectiveAt: p.EffectiveAt.Time,
+		CreatedAt:   p.CreatedAt.Time,
+	}
+}
@@ -0,0 +1,457 @@
+package consent
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pixperk/goiler/pkg/clock"
+	"github.com/pixperk/goiler/pkg/idgen"
+	"github.com/pixperk/goiler/pkg/pagination"
+)
+
+var (
+	ErrPolicyNotFound   = errors.New("policy not found")
+	ErrPolicyExists     = errors.New("policy version already exists")
+	ErrPolicyNotCurrent = errors.New("policy is not the current version")
+)
+
+// Acceptance filters for PolicyUsers
+const (
+	StatusAccepted = "accepted"
+	StatusPending  = "pending"
+)
+
+// DefaultCacheTTL is how long policies and users known to be up to date
+// are cached. New policies published on another instance are enforced
+// here after at most this long.
+const DefaultCacheTTL = time.Minute
+
+// maxCachedUsers bounds the cache of users that accepted every mandatory policy
+const maxCachedUsers = 10000
+
+// Policy is a version of a document users accept, e.g. the terms of service
+type Policy struct {
+	ID          uuid.UUID `json:"id"`
+	Kind        string    `json:"kind"`
+	Version     string    `json:"version"`
+	Title       string    `json:"title,omitempty"`
+	URL         string    `json:"url,omitempty"`
+	Mandatory   bool      `json:"mandatory"`
+	EffectiveAt time.Time `json:"effective_at"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Acceptance records a user accepting a policy version
+type Acceptance struct {
+	UserID     uuid.UUID `json:"user_id"`
+	PolicyID   uuid.UUID `json:"policy_id"`
+	Email      string    `json:"email,omitempty"`
+	AcceptedAt time.Time `json:"accepted_at"`
+	IPAddress  string    `json:"ip_address,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+}
+
+// PolicyStatus is a current policy and whether a user accepted it
+type PolicyStatus struct {
+	Policy
+	Accepted   bool       `json:"accepted"`
+	AcceptedAt *time.Time `json:"accepted_at,omitempty"`
+	// Required is set when the user is blocked until they accept this policy
+	Required bool `json:"required"`
+}
+
+// UserStatus summarizes a user's consent to the current policies
+type UserStatus struct {
+	UserID   uuid.UUID      `json:"user_id"`
+	Policies []PolicyStatus `json:"policies"`
+	// Blocked is set while a mandatory policy is pending
+	Blocked bool `json:"blocked"`
+}
+
+// PolicyUser is a user in an admin acceptance query
+type PolicyUser struct {
+	UserID     uuid.UUID  `json:"user_id"`
+	Email      string     `json:"email"`
+	AcceptedAt *time.Time `json:"accepted_at,omitempty"`
+	IPAddress  string     `json:"ip_address,omitempty"`
+	UserAgent  string     `json:"user_agent,omitempty"`
+}
+
+// PolicySummary is a policy with its acceptance count
+type PolicySummary struct {
+	Policy
+	Current     bool  `json:"current"`
+	Acceptances int64 `json:"acceptances"`
+}
+
+// Repository stores policies and acceptances
+type Repository interface {
+	CreatePolicy(ctx context.Context, policy *Policy) error
+	GetPolicy(ctx context.Context, id uuid.UUID) (*Policy, error)
+	ListPolicies(ctx context.Context) ([]*Policy, error)
+	// CreateAcceptance records an acceptance; accepting twice keeps the first
+	CreateAcceptance(ctx context.Context, acceptance *Acceptance) error
+	ListUserAcceptances(ctx context.Context, userID uuid.UUID) ([]*Acceptance, error)
+	CountAcceptances(ctx context.Context, policyID uuid.UUID) (int64, error)
+	CountUsers(ctx context.Context) (int64, error)
+	ListAcceptances(ctx context.Context, policyID uuid.UUID, limit, offset int) ([]*PolicyUser, error)
+	ListUsersWithoutAcceptance(ctx context.Context, policyID uuid.UUID, limit, offset int) ([]*PolicyUser, error)
+}
+
+// Service manages policies and users' acceptance of them
+type Service struct {
+	repo     Repository
+	logger   *slog.Logger
+	clock    clock.Clock
+	ids      idgen.Generator
+	cacheTTL time.Duration
+
+	mu             sync.Mutex
+	policies       []*Policy
+	policiesLoaded time.Time
+	upToDate       map[uuid.UUID]time.Time // user -> cached until
+}
+
+// ServiceOption configures a Service
+type ServiceOption func(*Service)
+
+// WithLogger sets the logger
+func WithLogger(logger *slog.Logger) ServiceOption {
+	return func(s *Service) {
+		s.logger = logger
+	}
+}
+
+// WithClock sets the clock used for effective dates and acceptance times
+func WithClock(c clock.Clock) ServiceOption {
+	return func(s *Service) {
+		s.clock = c
+	}
+}
+
+// WithIDGenerator sets the generator for policy IDs
+func WithIDGenerator(ids idgen.Generator) ServiceOption {
+	return func(s *Service) {
+		s.ids = ids
+	}
+}
+
+// WithCacheTTL sets how long policies and up-to-date users are cached;
+// zero disables caching
+func WithCacheTTL(ttl time.Duration) ServiceOption {
+	return func(s *Service) {
+		s.cacheTTL = ttl
+	}
+}
+
+// NewService creates a new consent service
+func NewService(repo Repository, opts ...ServiceOption) *Service {
+	s := &Service{
+		repo:     repo,
+		logger:   slog.Default(),
+		cacheTTL: DefaultCacheTTL,
+		upToDate: make(map[uuid.UUID]time.Time),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.clock = clock.OrReal(s.clock)
+	s.ids = idgen.OrDefault(s.ids)
+	return s
+}
+
+// CreatePolicyRequest represents a new policy version
+type CreatePolicyRequest struct {
+	Kind      string `json:"kind" validate:"required,max=50"`
+	Version   string `json:"version" validate:"required,max=50"`
+	Title     string `json:"title,omitempty" validate:"max=255"`
+	URL       string `json:"url,omitempty" validate:"omitempty,url"`
+	Mandatory bool   `json:"mandatory"`
+	// EffectiveAt schedules the version; it takes effect immediately when omitted
+	EffectiveAt *time.Time `json:"effective_at,omitempty"`
+}
+
+// CreatePolicy publishes a new policy version. Once it takes effect, users
+// who haven't accepted it are blocked if it is mandatory.
+func (s *Service) CreatePolicy(ctx context.Context, req *CreatePolicyRequest) (*Policy, error) {
+	now := s.clock.Now()
+	policy := &Policy{
+		ID:          s.ids.NewID(),
+		Kind:        req.Kind,
+		Version:     req.Version,
+		Title:       req.Title,
+		URL:         req.URL,
+		Mandatory:   req.Mandatory,
+		EffectiveAt: now,
+		CreatedAt:   now,
+	}
+	if req.EffectiveAt != nil {
+		policy.EffectiveAt = *req.EffectiveAt
+	}
+
+	if err := s.repo.CreatePolicy(ctx, policy); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.policies = nil
+	s.upToDate = make(map[uuid.UUID]time.Time)
+	s.mu.Unlock()
+
+	s.logger.Info("policy published",
+		slog.String("kind", policy.Kind),
+		slog.String("version", policy.Version),
+		slog.Bool("mandatory", policy.Mandatory),
+		slog.Time("effective_at", policy.EffectiveAt),
+	)
+	return policy, nil
+}
+
+// loadPolicies returns all policies, cached for the cache TTL
+func (s *Service) loadPolicies(ctx context.Context) ([]*Policy, error) {
+	now := s.clock.Now()
+
+	s.mu.Lock()
+	if s.policies != nil && now.Sub(s.policiesLoaded) < s.cacheTTL {
+		policies := s.policies
+		s.mu.Unlock()
+		return policies, nil
+	}
+	s.mu.Unlock()
+
+	policies, err := s.repo.ListPolicies(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.policies = policies
+	s.policiesLoaded = now
+	s.mu.Unlock()
+	return policies, nil
+}
+
+// currentPolicies returns the latest effective version of each kind, and
+// for each kind the latest effective mandatory version
+func currentPolicies(policies []*Policy, now time.Time) (current, mandatory map[string]*Policy) {
+	current = make(map[string]*Policy)
+	mandatory = make(map[string]*Policy)
+	for _, p := range policies {
+		if p.EffectiveAt.After(now) {
+			continue
+		}
+		if c, ok := current[p.Kind]; !ok || p.EffectiveAt.After(c.EffectiveAt) {
+			current[p.Kind] = p
+		}
+		if m, ok := mandatory[p.Kind]; p.Mandatory && (!ok || p.EffectiveAt.After(m.EffectiveAt)) {
+			mandatory[p.Kind] = p
+		}
+	}
+	return current, mandatory
+}
+
+// CurrentPolicies returns the version of each policy currently in effect
+func (s *Service) CurrentPolicies(ctx context.Context) ([]Policy, error) {
+	policies, err := s.loadPolicies(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	current, _ := currentPolicies(policies, s.clock.Now())
+	result := make([]Policy, 0, len(current))
+	for _, p := range policies {
+		if current[p.Kind] == p {
+			result = append(result, *p)
+		}
+	}
+	return result, nil
+}
+
+// Status returns the current policies and whether the user accepted them.
+// A mandatory policy is satisfied by accepting it or any later version.
+func (s *Service) Status(ctx context.Context, userID uuid.UUID) (*UserStatus, error) {
+	policies, err := s.loadPolicies(ctx)
+	if err != nil {
+		return nil, err
+	}
+	acceptances, err := s.repo.ListUserAcceptances(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[uuid.UUID]*Policy, len(policies))
+	for _, p := range policies {
+		byID[p.ID] = p
+	}
+	// Latest accepted version of each kind
+	acceptedAt := make(map[uuid.UUID]time.Time, len(acceptances))
+	latestAccepted := make(map[string]*Policy)
+	for _, a := range acceptances {
+		acceptedAt[a.PolicyID] = a.AcceptedAt
+		if p, ok := byID[a.PolicyID]; ok {
+			if l, ok := latestAccepted[p.Kind]; !ok || p.EffectiveAt.After(l.EffectiveAt) {
+				latestAccepted[p.Kind] = p
+			}
+		}
+	}
+
+	current, mandatory := currentPolicies(policies, s.clock.Now())
+	status := &UserStatus{UserID: userID, Policies: []PolicyStatus{}}
+	for _, p := range policies {
+		if current[p.Kind] != p {
+			continue
+		}
+
+		ps := PolicyStatus{Policy: *p}
+		if at, ok := acceptedAt[p.ID]; ok {
+			ps.Accepted = true
+			ps.AcceptedAt = &at
+		}
+		if m, ok := mandatory[p.Kind]; ok {
+			l, accepted := latestAccepted[p.Kind]
+			ps.Required = !accepted || l.EffectiveAt.Before(m.EffectiveAt)
+		}
+		status.Blocked = status.Blocked || ps.Required
+		status.Policies = append(status.Policies, ps)
+	}
+	return status, nil
+}
+
+// Pending returns the policies a user must accept before using the API.
+// Users with nothing pending are cached for the cache TTL.
+func (s *Service) Pending(ctx context.Context, userID uuid.UUID) ([]Policy, error) {
+	now := s.clock.Now()
+
+	s.mu.Lock()
+	until, ok := s.upToDate[userID]
+	s.mu.Unlock()
+	if ok && now.Before(until) {
+		return nil, nil
+	}
+
+	status, err := s.Status(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Policy
+	for _, ps := range status.Policies {
+		if ps.Required {
+			pending = append(pending, ps.Policy)
+		}
+	}
+	if len(pending) == 0 && s.cacheTTL > 0 {
+		s.markUpToDate(userID, now)
+	}
+	return pending, nil
+}
+
+func (s *Service) markUpToDate(userID uuid.UUID, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.upToDate) >= maxCachedUsers {
+		for id, until := range s.upToDate {
+			if !now.Before(until) {
+				delete(s.upToDate, id)
+			}
+		}
+		if len(s.upToDate) >= maxCachedUsers {
+			s.upToDate = make(map[uuid.UUID]time.Time)
+		}
+	}
+	s.upToDate[userID] = now.Add(s.cacheTTL)
+}
+
+// AcceptRequest represents a policy acceptance
+type AcceptRequest struct {
+	PolicyID uuid.UUID `json:"policy_id" validate:"required"`
+}
+
+// Accept records that the user accepted a policy from the given client.
+// Only the version currently in effect can be accepted.
+func (s *Service) Accept(ctx context.Context, userID, policyID uuid.UUID, ipAddress, userAgent string) (*UserStatus, error) {
+	policy, err := s.repo.GetPolicy(ctx, policyID)
+	if err != nil {
+		return nil, err
+	}
+
+	policies, err := s.loadPolicies(ctx)
+	if err != nil {
+		return nil, err
+	}
+	current, _ := currentPolicies(policies, s.clock.Now())
+	if c, ok := current[policy.Kind]; !ok || c.ID != policy.ID {
+		return nil, ErrPolicyNotCurrent
+	}
+
+	err = s.repo.CreateAcceptance(ctx, &Acceptance{
+		UserID:     userID,
+		PolicyID:   policy.ID,
+		AcceptedAt: s.clock.Now(),
+		IPAddress:  ipAddress,
+		UserAgent:  userAgent,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("policy accepted",
+		slog.String("user_id", userID.String()),
+		slog.String("kind", policy.Kind),
+		slog.String("version", policy.Version),
+	)
+	return s.Status(ctx, userID)
+}
+
+// ListPolicies returns every policy version with its acceptance count
+func (s *Service) ListPolicies(ctx context.Context) ([]PolicySummary, error) {
+	policies, err := s.repo.ListPolicies(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	current, _ := currentPolicies(policies, s.clock.Now())
+	summaries := make([]PolicySummary, 0, len(policies))
+	for _, p := range policies {
+		count, err := s.repo.CountAcceptances(ctx, p.ID)
+		if err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, PolicySummary{
+			Policy:      *p,
+			Current:     current[p.Kind] == p,
+			Acceptances: count,
+		})
+	}
+	return summaries, nil
+}
+
+// PolicyUsers lists the users who accepted a policy version, most recent
+// first, or with StatusPending those who haven't, with the total count
+func (s *Service) PolicyUsers(ctx context.Context, policyID uuid.UUID, status string, params pagination.ListParams) ([]*PolicyUser, int64, error) {
+	if _, err := s.repo.GetPolicy(ctx, policyID); err != nil {
+		return nil, 0, err
+	}
+
+	accepted, err := s.repo.CountAcceptances(ctx, policyID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if status != StatusPending {
+		users, err := s.repo.ListAcceptances(ctx, policyID, params.Limit(), params.Offset())
+		return users, accepted, err
+	}
+
+	total, err := s.repo.CountUsers(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	users, err := s.repo.ListUsersWithoutAcceptance(ctx, policyID, params.Limit(), params.Offset())
+	return users, max(total-accepted, 0), err
+}
@@ -0,0 +1,343 @@
+package doctor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pixperk/goiler/internal/auth"
+	"github.com/pixperk/goiler/internal/config"
+	"github.com/pixperk/goiler/pkg/dbconn"
+	"github.com/pixperk/goiler/pkg/otel"
+	"github.com/pixperk/goiler/pkg/redisconn"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// undefinedTable is the Postgres error code for missing tables
+const undefinedTable = "42P01"
+
+// Queue and task type of the enqueue/dequeue round trip. The queue is
+// deleted afterwards, so workers never see it.
+const (
+	probeQueue    = "goiler_doctor"
+	probeTaskType = "doctor:probe"
+)
+
+// migrationFile matches golang-migrate up migrations, such as
+// 000001_init.up.sql
+var migrationFile = regexp.MustCompile(`^(\d+)_.+\.up\.sql$`)
+
+// Options configures the checks
+type Options struct {
+	// MigrationsDir holds the migrations the database should be up to
+	// date with
+	MigrationsDir string
+}
+
+// Checker runs smoke checks against the services in a config. Later checks
+// reuse the connections of earlier ones and are skipped when those failed.
+type Checker struct {
+	cfg   *config.Config
+	opts  Options
+	pool  *pgxpool.Pool
+	redis redis.UniversalClient
+}
+
+// New creates a checker for cfg
+func New(cfg *config.Config, opts Options) *Checker {
+	return &Checker{cfg: cfg, opts: opts}
+}
+
+// Checks returns the checks in the order they must run
+func (c *Checker) Checks() []Check {
+	return []Check{
+		{Name: "config", Run: c.checkConfig},
+		{Name: "database", Run: c.checkDatabase},
+		{Name: "migrations", Run: c.checkMigrations},
+		{Name: "redis", Run: c.checkRedis},
+		{Name: "queue", Run: c.checkQueue},
+		{Name: "otel", Run: c.checkOTEL},
+		{Name: "tokens", Run: c.checkTokens},
+	}
+}
+
+// Close closes the connections opened by the checks
+func (c *Checker) Close() {
+	if c.pool != nil {
+		c.pool.Close()
+	}
+	if c.redis != nil {
+		c.redis.Close()
+	}
+}
+
+// checkConfig runs the config validation the API runs at startup and, in
+// production, checks that variables marked required_in_prod are set
+func (c *Checker) checkConfig(ctx context.Context) (string, error) {
+	var errs []error
+	if err := dbconn.Validate(c.cfg.Database); err != nil {
+		errs = append(errs, fmt.Errorf("database: %w", err))
+	}
+	if err := redisconn.Validate(c.cfg.Redis); err != nil {
+		errs = append(errs, fmt.Errorf("redis: %w", err))
+	}
+	if err := auth.LockoutPolicyFromConfig(c.cfg.Auth.Lockout).Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("lockout: %w", err))
+	}
+	if err := auth.LoginAlertPolicyFromConfig(c.cfg.Auth.LoginAlerts).Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("login alerts: %w", err))
+	}
+	if _, err := auth.PasswordPolicyFromConfig(c.cfg.Auth.PasswordPolicy); err != nil {
+		errs = append(errs, fmt.Errorf("password policy: %w", err))
+	}
+	if c.cfg.App.Env == "production" {
+		missing, err := config.MissingInProduction(os.LookupEnv)
+		if err != nil {
+			errs = append(errs, err)
+		} else if len(missing) > 0 {
+			errs = append(errs, fmt.Errorf("production requires %s", strings.Join(missing, ", ")))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("environment %s", c.cfg.App.Env), nil
+}
+
+// checkDatabase connects to Postgres
+func (c *Checker) checkDatabase(ctx context.Context) (string, error) {
+	pool, err := dbconn.NewPool(ctx, c.cfg.Database)
+	if err != nil {
+		return "", err
+	}
+	var version string
+	if err := pool.QueryRow(ctx, "SHOW server_version").Scan(&version); err != nil {
+		pool.Close()
+		return "", err
+	}
+	c.pool = pool
+	return "PostgreSQL " + version, nil
+}
+
+// checkMigrations compares the applied migration version with the latest
+// migration in MigrationsDir
+func (c *Checker) checkMigrations(ctx context.Context) (string, error) {
+	if c.pool == nil {
+		return "", Skip("database unavailable")
+	}
+
+	var version int64
+	var dirty bool
+	err := c.pool.QueryRow(ctx, "SELECT version, dirty FROM schema_migrations LIMIT 1").Scan(&version, &dirty)
+	var pgErr *pgconn.PgError
+	if errors.Is(err, pgx.ErrNoRows) || (errors.As(err, &pgErr) && pgErr.Code == undefinedTable) {
+		return "", fmt.Errorf("no migrations applied")
+	}
+	if err != nil {
+		return "", err
+	}
+	if dirty {
+		return "", fmt.Errorf("migration %d failed halfway (dirty); fix the schema and force the version", version)
+	}
+
+	latest, err := LatestMigration(c.opts.MigrationsDir)
+	if err != nil {
+		return fmt.Sprintf("at version %d, not compared: %v", version, err), nil
+	}
+	switch {
+	case version < latest:
+		return "", fmt.Errorf("at version %d, %d pending up to %d", version, pendingMigrations(c.opts.MigrationsDir, version), latest)
+	case version > latest:
+		return "", fmt.Errorf("at version %d, ahead of the latest migration %d; is this build older than the schema?", version, latest)
+	}
+	return fmt.Sprintf("at version %d", version), nil
+}
+
+// LatestMigration returns the highest migration version in dir
+func LatestMigration(dir string) (int64, error) {
+	versions, err := migrationVersions(dir)
+	if err != nil {
+		return 0, err
+	}
+	if len(versions) == 0 {
+		return 0, fmt.Errorf("no migrations in %s", dir)
+	}
+	latest := versions[0]
+	for _, v := range versions[1:] {
+		latest = max(latest, v)
+	}
+	return latest, nil
+}
+
+func pendingMigrations(dir string, applied int64) int {
+	versions, _ := migrationVersions(dir)
+	pending := 0
+	for _, v := range versions {
+		if v > applied {
+			pending++
+		}
+	}
+	return pending
+}
+
+func migrationVersions(dir string) ([]int64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var versions []int64
+	for _, entry := range entries {
+		m := migrationFile.FindStringSubmatch(filepath.Base(entry.Name()))
+		if m == nil {
+			continue
+		}
+		v, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %s: %w", entry.Name(), err)
+		}
+		versions = append(versions, v)
+	}
+	return versions, nil
+}
+
+// checkRedis pings Redis
+func (c *Checker) checkRedis(ctx context.Context) (string, error) {
+	client := redisconn.NewClient(c.cfg.Redis)
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return "", err
+	}
+	c.redis = client
+	return fmt.Sprintf("%s mode", c.cfg.Redis.Mode), nil
+}
+
+// checkQueue enqueues a task on a throwaway queue and processes it with a
+// temporary asynq server, then deletes the queue
+func (c *Checker) checkQueue(ctx context.Context) (string, error) {
+	if c.redis == nil {
+		return "", Skip("redis unavailable")
+	}
+
+	connOpt := redisconn.ConnOpt(c.cfg.Redis)
+	token := uuid.NewString()
+	received := make(chan string, 1)
+
+	mux := asynq.NewServeMux()
+	mux.HandleFunc(probeTaskType, func(_ context.Context, task *asynq.Task) error {
+		received <- string(task.Payload())
+		return nil
+	})
+	srv := asynq.NewServer(connOpt, asynq.Config{
+		Queues:            map[string]int{probeQueue: 1},
+		Concurrency:       1,
+		TaskCheckInterval: 100 * time.Millisecond,
+		ShutdownTimeout:   time.Second,
+		LogLevel:          asynq.FatalLevel,
+	})
+	if err := srv.Start(mux); err != nil {
+		return "", fmt.Errorf("start server: %w", err)
+	}
+	defer func() {
+		srv.Shutdown()
+		inspector := asynq.NewInspector(connOpt)
+		inspector.DeleteQueue(probeQueue, true)
+		inspector.Close()
+	}()
+
+	client := asynq.NewClient(connOpt)
+	defer client.Close()
+
+	start := time.Now()
+	_, err := client.EnqueueContext(ctx, asynq.NewTask(probeTaskType, []byte(token)),
+		asynq.Queue(probeQueue),
+		asynq.MaxRetry(0),
+	)
+	if err != nil {
+		return "", fmt.Errorf("enqueue: %w", err)
+	}
+
+	select {
+	case got := <-received:
+		if got != token {
+			return "", fmt.Errorf("dequeued a different task than was enqueued")
+		}
+		return fmt.Sprintf("round trip in %s", time.Since(start).Round(time.Millisecond)), nil
+	case <-ctx.Done():
+		return "", fmt.Errorf("task enqueued but not dequeued: %w", ctx.Err())
+	}
+}
+
+// checkOTEL exports a span with the exporter the API uses
+func (c *Checker) checkOTEL(ctx context.Context) (string, error) {
+	if !c.cfg.OTEL.Enabled {
+		return "", Skip("OTEL_ENABLED is false")
+	}
+
+	exporter, err := otel.NewTraceExporter(ctx, c.cfg)
+	if err != nil {
+		return "", err
+	}
+	defer exporter.Shutdown(context.Background())
+
+	traceID, spanID := uuid.New(), uuid.New()
+	now := time.Now()
+	spans := tracetest.SpanStubs{{
+		Name: "goiler doctor",
+		SpanContext: trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID:    trace.TraceID(traceID),
+			SpanID:     trace.SpanID(spanID[:8]),
+			TraceFlags: trace.FlagsSampled,
+		}),
+		StartTime: now,
+		EndTime:   now,
+	}}
+	if err := exporter.ExportSpans(ctx, spans.Snapshots()); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("exported a test span to %s", c.cfg.OTEL.Endpoint), nil
+}
+
+// checkTokens issues and verifies an access token with the configured key
+// material
+func (c *Checker) checkTokens(ctx context.Context) (string, error) {
+	maker, err := auth.NewTokenMakerFromConfig(c.cfg.Auth,
+		auth.WithIssuer(c.cfg.Auth.TokenIssuer),
+		auth.WithAudience(c.cfg.Auth.TokenAudience),
+		auth.WithLeeway(c.cfg.Auth.TokenLeeway),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	userID := uuid.New()
+	token, _, err := maker.CreateToken(userID, "doctor@goiler.invalid", "user", auth.AccessToken, time.Minute)
+	if err != nil {
+		return "", fmt.Errorf("issue token: %w", err)
+	}
+	payload, err := maker.VerifyToken(token)
+	if err != nil {
+		return "", fmt.Errorf("verify token: %w", err)
+	}
+	if payload.UserID != userID {
+		return "", fmt.Errorf("verified token carries the wrong user")
+	}
+
+	kind := c.cfg.Auth.Type
+	if kind == "jwt" && c.cfg.Auth.JWTAlgorithm != "" {
+		kind += " " + c.cfg.Auth.JWTAlgorithm
+	}
+	return fmt.Sprintf("issued and verified a %s token", kind), nil
+}
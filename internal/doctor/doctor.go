@@ -0,0 +1,109 @@
+// Package doctor runs startup smoke checks against the services a
+// deployment depends on, so misconfiguration shows up before a deploy
+// rather than as a crash loop after it.
+package doctor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// Status is the outcome of a check
+type Status string
+
+// Check outcomes
+const (
+	StatusPass Status = "PASS"
+	StatusFail Status = "FAIL"
+	// StatusSkip checks don't apply to this configuration, or depend on a
+	// check that failed
+	StatusSkip Status = "SKIP"
+)
+
+// Check is a named smoke check. Run returns a short detail for the report,
+// or an error if the check failed; errors made with Skip mark the check as
+// skipped instead.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) (string, error)
+}
+
+// Result is the outcome of one check
+type Result struct {
+	Name     string
+	Status   Status
+	Detail   string
+	Duration time.Duration
+}
+
+type skipError struct {
+	reason string
+}
+
+func (e *skipError) Error() string {
+	return e.reason
+}
+
+// Skip returns an error that marks a check as skipped for reason
+func Skip(reason string) error {
+	return &skipError{reason: reason}
+}
+
+// Run runs checks in order, giving each up to timeout
+func Run(ctx context.Context, checks []Check, timeout time.Duration) []Result {
+	results := make([]Result, 0, len(checks))
+	for _, check := range checks {
+		results = append(results, run(ctx, check, timeout))
+	}
+	return results
+}
+
+func run(ctx context.Context, check Check, timeout time.Duration) Result {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	detail, err := check.Run(ctx)
+	result := Result{Name: check.Name, Status: StatusPass, Detail: detail, Duration: time.Since(start)}
+
+	var skip *skipError
+	switch {
+	case errors.As(err, &skip):
+		result.Status = StatusSkip
+		result.Detail = skip.reason
+	case err != nil:
+		result.Status = StatusFail
+		result.Detail = err.Error()
+	}
+	return result
+}
+
+// Passed reports whether no check failed
+func Passed(results []Result) bool {
+	for _, r := range results {
+		if r.Status == StatusFail {
+			return false
+		}
+	}
+	return true
+}
+
+// Write prints results as a table followed by a summary line
+func Write(w io.Writer, results []Result) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	counts := map[Status]int{}
+	for _, r := range results {
+		counts[r.Status]++
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.Status, r.Name, r.Duration.Round(time.Millisecond), r.Detail)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "\n%d passed, %d failed, %d skipped\n",
+		counts[StatusPass], counts[StatusFail], counts[StatusSkip])
+	return err
+}
@@ -0,0 +1,101 @@
+package doctor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pixperk/goiler/internal/config"
+)
+
+func TestRun_Statuses(t *testing.T) {
+	results := Run(context.Background(), []Check{
+		{Name: "ok", Run: func(context.Context) (string, error) { return "fine", nil }},
+		{Name: "broken", Run: func(context.Context) (string, error) { return "", errors.New("boom") }},
+		{Name: "n/a", Run: func(context.Context) (string, error) { return "", Skip("disabled") }},
+		{Name: "slow", Run: func(ctx context.Context) (string, error) {
+			<-ctx.Done()
+			return "", ctx.Err()
+		}},
+	}, 10*time.Millisecond)
+
+	want := []struct {
+		status Status
+		detail string
+	}{
+		{StatusPass, "fine"},
+		{StatusFail, "boom"},
+		{StatusSkip, "disabled"},
+		{StatusFail, context.DeadlineExceeded.Error()},
+	}
+	for i, w := range want {
+		if results[i].Status != w.status || results[i].Detail != w.detail {
+			t.Errorf("%s: expected %s %q, got %s %q", results[i].Name, w.status, w.detail, results[i].Status, results[i].Detail)
+		}
+	}
+	if Passed(results) {
+		t.Error("Expected failed checks to fail the run")
+	}
+	if !Passed(results[:1]) || !Passed(results[2:3]) {
+		t.Error("Expected passed and skipped checks to pass the run")
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, results); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "1 passed, 2 failed, 1 skipped") {
+		t.Errorf("Expected a summary line, got:\n%s", buf.String())
+	}
+}
+
+func TestLatestMigration(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"000001_init.up.sql", "000001_init.down.sql",
+		"000010_tickets.up.sql", "000010_tickets.down.sql",
+		"000002_sessions.up.sql", "README.md",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	latest, err := LatestMigration(dir)
+	if err != nil || latest != 10 {
+		t.Errorf("Expected version 10, got %d, %v", latest, err)
+	}
+	if pending := pendingMigrations(dir, 1); pending != 2 {
+		t.Errorf("Expected 2 pending migrations after version 1, got %d", pending)
+	}
+	if _, err := LatestMigration(t.TempDir()); err == nil {
+		t.Error("Expected an empty directory to fail")
+	}
+}
+
+func TestChecks_SkipWithoutConnections(t *testing.T) {
+	checker := New(&config.Config{}, Options{})
+	for _, check := range []func(context.Context) (string, error){checker.checkMigrations, checker.checkQueue, checker.checkOTEL} {
+		var skip *skipError
+		if _, err := check(context.Background()); !errors.As(err, &skip) {
+			t.Errorf("Expected the check to be skipped, got %v", err)
+		}
+	}
+}
+
+func TestCheckTokens(t *testing.T) {
+	cfg := &config.Config{Auth: config.AuthConfig{Type: "jwt", JWTSecret: strings.Repeat("s", 32)}}
+	if _, err := New(cfg, Options{}).checkTokens(context.Background()); err != nil {
+		t.Errorf("Expected a valid secret to pass, got %v", err)
+	}
+
+	cfg.Auth.JWTSecret = "short"
+	if _, err := New(cfg, Options{}).checkTokens(context.Background()); err == nil {
+		t.Error("Expected a short secret to fail")
+	}
+}
@@ -0,0 +1,154 @@
+// Package emaillog records the transactional emails the worker handles, so
+// support can tell whether a user was sent a reset email without searching
+// the worker's logs.
+package emaillog
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pixperk/goiler/pkg/clock"
+	"github.com/pixperk/goiler/pkg/idgen"
+	"github.com/pixperk/goiler/pkg/pagination"
+)
+
+// Email types
+const (
+	TypeGeneric       = "generic"
+	TypeWelcome       = "welcome"
+	TypePasswordReset = "password_reset"
+	TypeOrgInvitation = "org_invitation"
+	TypeSecurityAlert = "security_alert"
+)
+
+// Delivery statuses
+const (
+	StatusSent = "sent"
+	// StatusRetrying means the last attempt failed and another is scheduled
+	StatusRetrying = "retrying"
+	StatusFailed   = "failed"
+	// StatusSuppressed means the email was logged instead of sent, as it
+	// was requested in sandbox mode
+	StatusSuppressed = "suppressed"
+)
+
+// Delivery is a transactional email and the outcome of its latest attempt
+type Delivery struct {
+	ID uuid.UUID `json:"id"`
+	// TaskID identifies the worker task; attempts of a task update the
+	// same delivery
+	TaskID string `json:"-"`
+	// UserID is nil for emails to addresses without an account, such as
+	// invitations
+	UserID *uuid.UUID `json:"-"`
+	Email  string     `json:"email"`
+	Type   string     `json:"type"`
+	Status string     `json:"status"`
+	// Error is why the latest attempt failed
+	Error     string    `json:"error,omitempty" visible:"users:read"`
+	Attempts  int       `json:"attempts"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Repository stores deliveries
+type Repository interface {
+	// Record stores a delivery, or updates the status, error and attempts
+	// of the delivery with the same TaskID
+	Record(ctx context.Context, delivery *Delivery) error
+	ListForUser(ctx context.Context, userID uuid.UUID, limit, offset int) ([]*Delivery, error)
+	CountForUser(ctx context.Context, userID uuid.UUID) (int64, error)
+	// DeleteBefore deletes deliveries first handled before t
+	DeleteBefore(ctx context.Context, t time.Time) (int64, error)
+}
+
+// Service records and lists email deliveries
+type Service struct {
+	repo   Repository
+	logger *slog.Logger
+	clock  clock.Clock
+	ids    idgen.Generator
+}
+
+// ServiceOption configures a Service
+type ServiceOption func(*Service)
+
+// WithLogger sets the logger
+func WithLogger(logger *slog.Logger) ServiceOption {
+	return func(s *Service) {
+		s.logger = logger
+	}
+}
+
+// WithClock sets the clock used to stamp deliveries
+func WithClock(c clock.Clock) ServiceOption {
+	return func(s *Service) {
+		s.clock = c
+	}
+}
+
+// WithIDGenerator sets the generator for delivery IDs
+func WithIDGenerator(ids idgen.Generator) ServiceOption {
+	return func(s *Service) {
+		s.ids = ids
+	}
+}
+
+// NewService creates a new email log service
+func NewService(repo Repository, opts ...ServiceOption) *Service {
+	s := &Service{
+		repo:   repo,
+		logger: slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.clock = clock.OrReal(s.clock)
+	s.ids = idgen.OrDefault(s.ids)
+	return s
+}
+
+// Record stores the outcome of an attempt to send an email. A delivery
+// with the TaskID of an earlier attempt replaces its status.
+func (s *Service) Record(ctx context.Context, delivery *Delivery) error {
+	now := s.clock.Now()
+	if delivery.ID == uuid.Nil {
+		delivery.ID = s.ids.NewID()
+	}
+	if delivery.TaskID == "" {
+		delivery.TaskID = delivery.ID.String()
+	}
+	if delivery.Attempts < 1 {
+		delivery.Attempts = 1
+	}
+	delivery.CreatedAt = now
+	delivery.UpdatedAt = now
+	return s.repo.Record(ctx, delivery)
+}
+
+// ListForUser returns the emails sent to a user, newest first, with the
+// total count
+func (s *Service) ListForUser(ctx context.Context, userID uuid.UUID, params pagination.ListParams) ([]*Delivery, int64, error) {
+	total, err := s.repo.CountForUser(ctx, userID)
+	if err != nil {
+		return nil, 0, err
+	}
+	deliveries, err := s.repo.ListForUser(ctx, userID, params.Limit(), params.Offset())
+	return deliveries, total, err
+}
+
+// Purge deletes deliveries first handled before t and returns how many
+// were deleted
+func (s *Service) Purge(ctx context.Context, before time.Time) (int64, error) {
+	deleted, err := s.repo.DeleteBefore(ctx, before)
+	if err != nil {
+		return 0, err
+	}
+	s.logger.InfoContext(ctx, "purged email deliveries",
+		slog.Int64("deleted", deleted),
+		slog.Time("before", before),
+	)
+	return deleted, nil
+}
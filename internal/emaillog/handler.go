@@ -0,0 +1,75 @@
+package emaillog
+
+import (
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/internal/auth"
+	"github.com/pixperk/goiler/pkg/pagination"
+	"github.com/pixperk/goiler/pkg/response"
+)
+
+// Handler handles HTTP requests for the email log
+type Handler struct {
+	service *Service
+}
+
+// NewHandler creates a new email log handler
+func NewHandler(service *Service) *Handler {
+	return &Handler{service: service}
+}
+
+// ListMine lists the emails sent to the current user
+// @Summary List my emails
+// @Description List the transactional emails sent to the current user, newest first, with the status of the latest attempt
+// @Tags Users
+// @Security BearerAuth
+// @Produce json
+// @Param page query int false "Page number" default(1)
+// @Param per_page query int false "Items per page, at most 100" default(20)
+// @Success 200 {array} Delivery
+// @Failure 401 {object} response.Response
+// @Failure 422 {object} response.Response
+// @Router /api/v1/users/me/emails [get]
+func (h *Handler) ListMine(c echo.Context) error {
+	payload := auth.GetCurrentUser(c)
+	if payload == nil {
+		return response.Unauthorized(c, "User not authenticated")
+	}
+	return h.list(c, payload.UserID)
+}
+
+// ListForUser lists the emails sent to a user
+// @Summary List a user's emails
+// @Description List the transactional emails sent to a user, newest first, with why the latest attempt failed (admin only)
+// @Tags Admin
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "User ID"
+// @Param page query int false "Page number" default(1)
+// @Param per_page query int false "Items per page, at most 100" default(20)
+// @Success 200 {array} Delivery
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 422 {object} response.Response
+// @Router /api/v1/admin/users/{id}/emails [get]
+func (h *Handler) ListForUser(c echo.Context) error {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return response.BadRequest(c, "Invalid user ID")
+	}
+	return h.list(c, userID)
+}
+
+func (h *Handler) list(c echo.Context, userID uuid.UUID) error {
+	params, err := pagination.Bind(c, pagination.Options{})
+	if err != nil {
+		return response.ValidationError(c, pagination.Details(err))
+	}
+
+	deliveries, total, err := h.service.ListForUser(c.Request().Context(), userID, params)
+	if err != nil {
+		return response.InternalError(c, "Failed to list emails")
+	}
+	return response.Paginated(c, deliveries, params.Page, params.PerPage, total)
+}
@@ -0,0 +1,121 @@
+package emaillog
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pixperk/goiler/db/sqlc"
+	"github.com/pixperk/goiler/internal/tenant"
+	"github.com/pixperk/goiler/pkg/budget"
+)
+
+// PostgresRepository implements Repository using PostgreSQL
+type PostgresRepository struct {
+	queries *sqlc.Queries
+}
+
+// NewPostgresRepository creates a new PostgreSQL repository
+func NewPostgresRepository(db *pgxpool.Pool) *PostgresRepository {
+	return &PostgresRepository{queries: sqlc.New(db)}
+}
+
+// Record stores a delivery, keeping the creation time of an earlier attempt
+func (r *PostgresRepository) Record(ctx context.Context, delivery *Delivery) error {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	params := sqlc.RecordEmailDeliveryParams{
+		ID:        delivery.ID,
+		TaskID:    delivery.TaskID,
+		Email:     delivery.Email,
+		Type:      delivery.Type,
+		Status:    delivery.Status,
+		Attempts:  int32(delivery.Attempts),
+		HandledAt: sql.NullTime{Time: delivery.UpdatedAt, Valid: true},
+	}
+	if delivery.UserID != nil {
+		params.UserID = pgtype.UUID{Bytes: *delivery.UserID, Valid: true}
+	}
+	if delivery.Error != "" {
+		params.Error = pgtype.Text{String: delivery.Error, Valid: true}
+	}
+	return r.queries.RecordEmailDelivery(ctx, params)
+}
+
+// ListForUser returns a page of the deliveries to a user, newest first, if
+// the user is in the tenant scope of ctx
+func (r *PostgresRepository) ListForUser(ctx context.Context, userID uuid.UUID, limit, offset int) ([]*Delivery, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	rows, err := r.queries.ListUserEmailDeliveries(ctx, sqlc.ListUserEmailDeliveriesParams{
+		UserID:   pgtype.UUID{Bytes: userID, Valid: true},
+		TenantID: tenant.ScopeParam(ctx),
+		Lim:      int32(limit),
+		Off:      int32(offset),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := make([]*Delivery, len(rows))
+	for i, row := range rows {
+		deliveries[i] = deliveryFromDB(row)
+	}
+	return deliveries, nil
+}
+
+// CountForUser counts the deliveries to a user, if the user is in the
+// tenant scope of ctx
+func (r *PostgresRepository) CountForUser(ctx context.Context, userID uuid.UUID) (int64, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer cancel()
+
+	return r.queries.CountUserEmailDeliveries(ctx, sqlc.CountUserEmailDeliveriesParams{
+		UserID:   pgtype.UUID{Bytes: userID, Valid: true},
+		TenantID: tenant.ScopeParam(ctx),
+	})
+}
+
+// DeleteBefore deletes deliveries first handled before t
+func (r *PostgresRepository) DeleteBefore(ctx context.Context, t time.Time) (int64, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer cancel()
+
+	return r.queries.DeleteEmailDeliveriesBefore(ctx, sql.NullTime{Time: t, Valid: true})
+}
+
+func deliveryFromDB(row *sqlc.EmailDelivery) *Delivery {
+	delivery := &Delivery{
+		ID:        row.ID,
+		TaskID:    row.TaskID,
+		Email:     row.Email,
+		Type:      row.Type,
+		Status:    row.Status,
+		Error:     row.Error.String,
+		Attempts:  int(row.Attempts),
+		CreatedAt: row.CreatedAt.Time,
+		UpdatedAt: row.UpdatedAt.Time,
+	}
+	if row.UserID.Valid {
+		userID := uuid.UUID(row.UserID.Bytes)
+		delivery.UserID = &userID
+	}
+	return delivery
+}
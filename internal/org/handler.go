@@ -0,0 +1,341 @@
+package org
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/internal/auth"
+	"github.com/pixperk/goiler/pkg/response"
+	"github.com/pixperk/goiler/pkg/validator"
+)
+
+// Switcher reissues a user's credentials acting in an org.
+// *auth.Service satisfies it.
+type Switcher interface {
+	SwitchOrg(ctx context.Context, r *http.Request, orgID uuid.UUID) (*auth.AuthResponse, error)
+}
+
+// Handler handles HTTP requests for organizations
+type Handler struct {
+	service  *Service
+	switcher Switcher
+	logger   *slog.Logger
+}
+
+// NewHandler creates a new org handler
+func NewHandler(service *Service, switcher Switcher, logger *slog.Logger) *Handler {
+	return &Handler{service: service, switcher: switcher, logger: logger}
+}
+
+// Create creates an org owned by the current user
+// @Summary Create organization
+// @Description Create an organization; the current user becomes its owner
+// @Tags Organizations
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body CreateOrgRequest true "Organization"
+// @Success 201 {object} Organization
+// @Failure 401 {object} response.Response
+// @Failure 422 {object} response.Response
+// @Router /api/v1/orgs [post]
+func (h *Handler) Create(c echo.Context) error {
+	payload := auth.GetCurrentUser(c)
+	if payload == nil {
+		return response.Unauthorized(c, "User not authenticated")
+	}
+
+	var req CreateOrgRequest
+	if err := c.Bind(&req); err != nil {
+		return response.BadRequest(c, "Invalid request body")
+	}
+
+	if err := c.Validate(&req); err != nil {
+		return response.ValidationError(c, validator.FormatErrors(err))
+	}
+
+	org, err := h.service.Create(c.Request().Context(), payload.UserID, &req)
+	if err != nil {
+		return response.InternalError(c, "Failed to create organization")
+	}
+	return response.Created(c, org)
+}
+
+// ListMine lists the current user's orgs
+// @Summary List my organizations
+// @Description List the organizations the current user belongs to, with their role in each
+// @Tags Organizations
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {array} Organization
+// @Failure 401 {object} response.Response
+// @Router /api/v1/orgs [get]
+func (h *Handler) ListMine(c echo.Context) error {
+	payload := auth.GetCurrentUser(c)
+	if payload == nil {
+		return response.Unauthorized(c, "User not authenticated")
+	}
+
+	orgs, err := h.service.ListForUser(c.Request().Context(), payload.UserID)
+	if err != nil {
+		return response.InternalError(c, "Failed to list organizations")
+	}
+	return response.Success(c, orgs)
+}
+
+// Current returns the org the current user is acting in
+// @Summary Get active organization
+// @Description Get the organization selected by the org claim of the current credentials, with its members
+// @Tags Organizations
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} Organization
+// @Failure 401 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Router /api/v1/orgs/current [get]
+func (h *Handler) Current(c echo.Context) error {
+	payload := auth.GetCurrentUser(c)
+	if payload == nil {
+		return response.Unauthorized(c, "User not authenticated")
+	}
+	if payload.OrgID == nil {
+		return response.NotFound(c, "No active organization")
+	}
+	return h.respondOrg(c, payload.UserID, *payload.OrgID)
+}
+
+// Get returns one of the current user's orgs
+// @Summary Get organization
+// @Description Get one of the current user's organizations with its members
+// @Tags Organizations
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Organization ID"
+// @Success 200 {object} Organization
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Router /api/v1/orgs/{id} [get]
+func (h *Handler) Get(c echo.Context) error {
+	payload := auth.GetCurrentUser(c)
+	if payload == nil {
+		return response.Unauthorized(c, "User not authenticated")
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return response.BadRequest(c, "Invalid organization ID")
+	}
+	return h.respondOrg(c, payload.UserID, id)
+}
+
+// respondOrg responds with an org of the user's and its members
+func (h *Handler) respondOrg(c echo.Context, userID, orgID uuid.UUID) error {
+	org, err := h.service.Get(c.Request().Context(), userID, orgID)
+	if err != nil {
+		if errors.Is(err, ErrOrgNotFound) {
+			return response.NotFound(c, "Organization not found")
+		}
+		return response.InternalError(c, "Failed to load organization")
+	}
+	return response.Success(c, org)
+}
+
+// Invite invites someone to an org by email
+// @Summary Invite to organization
+// @Description Email an invitation to join the organization as an admin or member; inviting an address again replaces its pending invitation (owners and admins only)
+// @Tags Organizations
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path string true "Organization ID"
+// @Param request body InviteRequest true "Invitation"
+// @Success 201 {object} Invitation
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Failure 409 {object} response.Response
+// @Failure 422 {object} response.Response
+// @Router /api/v1/orgs/{id}/invitations [post]
+func (h *Handler) Invite(c echo.Context) error {
+	payload := auth.GetCurrentUser(c)
+	if payload == nil {
+		return response.Unauthorized(c, "User not authenticated")
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return response.BadRequest(c, "Invalid organization ID")
+	}
+
+	var req InviteRequest
+	if err := c.Bind(&req); err != nil {
+		return response.BadRequest(c, "Invalid request body")
+	}
+
+	if err := c.Validate(&req); err != nil {
+		return response.ValidationError(c, validator.FormatErrors(err))
+	}
+
+	inviter := Inviter{UserID: payload.UserID, Email: payload.Email}
+	invitation, err := h.service.Invite(c.Request().Context(), inviter, id, &req)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrOrgNotFound):
+			return response.NotFound(c, "Organization not found")
+		case errors.Is(err, ErrNotAllowed):
+			return response.Forbidden(c, "Only owners and admins can invite members")
+		case errors.Is(err, ErrAlreadyMember):
+			return response.Conflict(c, "User is already a member")
+		}
+		h.logger.ErrorContext(c.Request().Context(), "failed to invite to organization",
+			slog.String("org_id", id.String()),
+			slog.String("error", err.Error()),
+		)
+		return response.InternalError(c, "Failed to send invitation")
+	}
+	return response.Created(c, invitation)
+}
+
+// Accept joins the org of an invitation
+// @Summary Accept invitation
+// @Description Join an organization with the token from an invitation email; the invitation must have been sent to the current user's email
+// @Tags Organizations
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body AcceptRequest true "Invitation token"
+// @Success 200 {object} Organization
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Failure 409 {object} response.Response
+// @Failure 410 {object} response.Response
+// @Failure 422 {object} response.Response
+// @Router /api/v1/orgs/invitations/accept [post]
+func (h *Handler) Accept(c echo.Context) error {
+	payload := auth.GetCurrentUser(c)
+	if payload == nil {
+		return response.Unauthorized(c, "User not authenticated")
+	}
+
+	var req AcceptRequest
+	if err := c.Bind(&req); err != nil {
+		return response.BadRequest(c, "Invalid request body")
+	}
+
+	if err := c.Validate(&req); err != nil {
+		return response.ValidationError(c, validator.FormatErrors(err))
+	}
+
+	org, err := h.service.Accept(c.Request().Context(), payload.UserID, payload.Email, req.Token)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvitationNotFound):
+			return response.NotFound(c, "Invitation not found")
+		case errors.Is(err, ErrInvitationExpired):
+			return response.Error(c, http.StatusGone, "INVITATION_EXPIRED", "Invitation has expired")
+		case errors.Is(err, ErrInvitationEmail):
+			return response.Forbidden(c, "Invitation was sent to a different email")
+		case errors.Is(err, ErrAlreadyMember):
+			return response.Conflict(c, "Already a member of the organization")
+		}
+		return response.InternalError(c, "Failed to accept invitation")
+	}
+	return response.Success(c, org)
+}
+
+// Switch makes an org the current user's active org
+// @Summary Switch organization
+// @Description Reissue the current credentials with an org_id claim for one of the user's organizations. Bearer clients get a new token pair and their previous refresh token is revoked; cookie sessions are rotated.
+// @Tags Organizations
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Organization ID"
+// @Success 200 {object} auth.AuthResponse
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Router /api/v1/orgs/{id}/switch [post]
+func (h *Handler) Switch(c echo.Context) error {
+	payload := auth.GetCurrentUser(c)
+	if payload == nil {
+		return response.Unauthorized(c, "User not authenticated")
+	}
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return response.BadRequest(c, "Invalid organization ID")
+	}
+
+	ctx := auth.ClientContext(c)
+	if _, err := h.service.Member(ctx, payload.UserID, id); err != nil {
+		if errors.Is(err, ErrOrgNotFound) {
+			return response.NotFound(c, "Organization not found")
+		}
+		return response.InternalError(c, "Failed to switch organization")
+	}
+
+	result, err := h.switcher.SwitchOrg(ctx, c.Request(), id)
+	if err != nil {
+		if errors.Is(err, auth.ErrSessionNotFound) {
+			return response.Unauthorized(c, "Session has expired, please log in again")
+		}
+		return response.InternalError(c, "Failed to switch organization")
+	}
+
+	auth.SetCookies(c, result.Cookies())
+	return response.Success(c, result)
+}
+
+// RemoveMember removes a member from an org, or lets members leave
+// @Summary Remove organization member
+// @Description Remove a member from the organization. Members can remove themselves to leave; owners and admins remove others, and only owners remove owners. The last owner can't be removed.
+// @Tags Organizations
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Organization ID"
+// @Param user_id path string true "User ID"
+// @Success 200 {object} response.Response
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Failure 409 {object} response.Response
+// @Router /api/v1/orgs/{id}/members/{user_id} [delete]
+func (h *Handler) RemoveMember(c echo.Context) error {
+	payload := auth.GetCurrentUser(c)
+	if payload == nil {
+		return response.Unauthorized(c, "User not authenticated")
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return response.BadRequest(c, "Invalid organization ID")
+	}
+	userID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		return response.BadRequest(c, "Invalid user ID")
+	}
+
+	err = h.service.RemoveMember(c.Request().Context(), payload.UserID, id, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrOrgNotFound):
+			return response.NotFound(c, "Organization not found")
+		case errors.Is(err, ErrNotMember):
+			return response.NotFound(c, "Member not found")
+		case errors.Is(err, ErrNotAllowed):
+			return response.Forbidden(c, "Your organization role can't remove this member")
+		case errors.Is(err, ErrLastOwner):
+			return response.Conflict(c, "Organization must keep an owner")
+		}
+		return response.InternalError(c, "Failed to remove member")
+	}
+	return response.SuccessWithMessage(c, "Member removed", nil)
+}
@@ -0,0 +1,374 @@
+package org
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pixperk/goiler/internal/tenant"
+	"github.com/pixperk/goiler/internal/testutil"
+	"github.com/pixperk/goiler/pkg/clock"
+)
+
+type memoryRepository struct {
+	mu          sync.Mutex
+	orgs        map[uuid.UUID]*Organization
+	members     map[uuid.UUID][]*Member
+	invitations map[string]*Invitation
+	emails      map[uuid.UUID]string
+}
+
+func newMemoryRepository() *memoryRepository {
+	return &memoryRepository{
+		orgs:        make(map[uuid.UUID]*Organization),
+		members:     make(map[uuid.UUID][]*Member),
+		invitations: make(map[string]*Invitation),
+		emails:      make(map[uuid.UUID]string),
+	}
+}
+
+func (r *memoryRepository) CreateOrganization(_ context.Context, org *Organization, owner *Member) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored := *org
+	r.orgs[org.ID] = &stored
+	r.members[org.ID] = append(r.members[org.ID], r.withEmail(owner))
+	return nil
+}
+
+func (r *memoryRepository) GetOrganization(ctx context.Context, id uuid.UUID) (*Organization, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	org, ok := r.orgs[id]
+	if !ok || org.TenantID != tenant.ID(ctx) {
+		return nil, ErrOrgNotFound
+	}
+	copied := *org
+	return &copied, nil
+}
+
+func (r *memoryRepository) ListUserOrganizations(ctx context.Context, userID uuid.UUID) ([]*Organization, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	orgs := []*Organization{}
+	for id, members := range r.members {
+		for _, m := range members {
+			if m.UserID == userID && r.orgs[id].TenantID == tenant.ID(ctx) {
+				copied := *r.orgs[id]
+				copied.Role = m.Role
+				orgs = append(orgs, &copied)
+			}
+		}
+	}
+	return orgs, nil
+}
+
+func (r *memoryRepository) GetMember(_ context.Context, orgID, userID uuid.UUID) (*Member, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, m := range r.members[orgID] {
+		if m.UserID == userID {
+			copied := *m
+			return &copied, nil
+		}
+	}
+	return nil, ErrNotMember
+}
+
+func (r *memoryRepository) ListMembers(_ context.Context, orgID uuid.UUID) ([]*Member, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	members := []*Member{}
+	for _, m := range r.members[orgID] {
+		copied := *m
+		members = append(members, &copied)
+	}
+	return members, nil
+}
+
+func (r *memoryRepository) RemoveMember(_ context.Context, orgID, userID uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	kept := []*Member{}
+	owners := 0
+	for _, m := range r.members[orgID] {
+		if m.UserID == userID {
+			continue
+		}
+		if m.Role == RoleOwner {
+			owners++
+		}
+		kept = append(kept, m)
+	}
+	if len(kept) == len(r.members[orgID]) {
+		return ErrNotMember
+	}
+	if owners == 0 {
+		return ErrLastOwner
+	}
+	r.members[orgID] = kept
+	return nil
+}
+
+func (r *memoryRepository) CreateInvitation(_ context.Context, invitation *Invitation) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for hash, inv := range r.invitations {
+		if inv.OrgID == invitation.OrgID && inv.Email == invitation.Email && inv.AcceptedAt == nil {
+			delete(r.invitations, hash)
+		}
+	}
+	stored := *invitation
+	r.invitations[invitation.TokenHash] = &stored
+	return nil
+}
+
+func (r *memoryRepository) GetInvitationByTokenHash(_ context.Context, tokenHash string) (*Invitation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	inv, ok := r.invitations[tokenHash]
+	if !ok {
+		return nil, ErrInvitationNotFound
+	}
+	copied := *inv
+	return &copied, nil
+}
+
+func (r *memoryRepository) AcceptInvitation(_ context.Context, invitation *Invitation, member *Member) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored, ok := r.invitations[invitation.TokenHash]
+	if !ok || stored.AcceptedAt != nil {
+		return ErrInvitationNotFound
+	}
+	for _, m := range r.members[member.OrgID] {
+		if m.UserID == member.UserID {
+			return ErrAlreadyMember
+		}
+	}
+	acceptedAt := member.JoinedAt
+	stored.AcceptedAt = &acceptedAt
+	r.members[member.OrgID] = append(r.members[member.OrgID], r.withEmail(member))
+	return nil
+}
+
+// withEmail copies m with the email of its user, as ListOrgMembers joins it
+func (r *memoryRepository) withEmail(m *Member) *Member {
+	copied := *m
+	copied.Email = r.emails[m.UserID]
+	return &copied
+}
+
+// sentInvitation is an invitation email queued by recordingMailer
+type sentInvitation struct {
+	email, orgName, inviterEmail, role, acceptURL string
+}
+
+type recordingMailer struct {
+	sent []sentInvitation
+}
+
+func (m *recordingMailer) SendOrgInvitationEmail(_ context.Context, email, orgName, inviterEmail, role, acceptURL string, _ time.Time) (bool, error) {
+	m.sent = append(m.sent, sentInvitation{email, orgName, inviterEmail, role, acceptURL})
+	return false, nil
+}
+
+// token returns the invitation token from the last email's link
+func (m *recordingMailer) token(t *testing.T) string {
+	t.Helper()
+	if len(m.sent) == 0 {
+		t.Fatal("Expected an invitation email")
+	}
+	link, err := url.Parse(m.sent[len(m.sent)-1].acceptURL)
+	if err != nil {
+		t.Fatalf("Invalid invitation link: %v", err)
+	}
+	return link.Query().Get("token")
+}
+
+// user is a test user, registered with the repository so members carry
+// their email
+type user struct {
+	id    uuid.UUID
+	email string
+}
+
+func newUser(repo *memoryRepository, email string) user {
+	u := user{id: uuid.New(), email: email}
+	repo.emails[u.id] = email
+	return u
+}
+
+func newTestService(t *testing.T) (*Service, *memoryRepository, *recordingMailer, *clock.Frozen) {
+	t.Helper()
+	repo := newMemoryRepository()
+	mailer := &recordingMailer{}
+	clk := testutil.Clock()
+	service := NewService(repo,
+		WithLogger(testutil.Logger()),
+		WithClock(clk),
+		WithMailer(mailer),
+		WithInvitations("https://app.example.com/invitations/accept", 48*time.Hour),
+	)
+	return service, repo, mailer, clk
+}
+
+func TestService_InviteAndAccept(t *testing.T) {
+	service, repo, mailer, clk := newTestService(t)
+	ctx := context.Background()
+	owner := newUser(repo, "owner@example.com")
+	invitee := newUser(repo, "invitee@example.com")
+
+	org, err := service.Create(ctx, owner.id, &CreateOrgRequest{Name: "Acme"})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if org.Role != RoleOwner {
+		t.Errorf("Expected the creator to own the org, got role %q", org.Role)
+	}
+
+	inviter := Inviter{UserID: owner.id, Email: owner.email}
+	invitation, err := service.Invite(ctx, inviter, org.ID, &InviteRequest{Email: "Invitee@Example.com", Role: RoleAdmin})
+	if err != nil {
+		t.Fatalf("Invite failed: %v", err)
+	}
+	if invitation.Email != "invitee@example.com" || !invitation.ExpiresAt.Equal(clk.Now().Add(48*time.Hour)) {
+		t.Errorf("Unexpected invitation %+v", invitation)
+	}
+	want := sentInvitation{email: "invitee@example.com", orgName: "Acme", inviterEmail: owner.email, role: RoleAdmin}
+	if got := mailer.sent[0]; got.email != want.email || got.orgName != want.orgName || got.inviterEmail != want.inviterEmail || got.role != want.role {
+		t.Errorf("Expected invitation email %+v, got %+v", want, got)
+	}
+
+	// Inviting again replaces the pending invitation
+	first := mailer.token(t)
+	if _, err := service.Invite(ctx, inviter, org.ID, &InviteRequest{Email: invitee.email, Role: RoleMember}); err != nil {
+		t.Fatalf("Invite failed: %v", err)
+	}
+	if _, err := service.Accept(ctx, invitee.id, invitee.email, first); !errors.Is(err, ErrInvitationNotFound) {
+		t.Errorf("Expected the replaced invitation to be gone, got %v", err)
+	}
+	token := mailer.token(t)
+
+	if _, err := service.Accept(ctx, owner.id, owner.email, token); !errors.Is(err, ErrInvitationEmail) {
+		t.Errorf("Expected ErrInvitationEmail for another user, got %v", err)
+	}
+	other := tenant.WithTenant(ctx, &tenant.Tenant{ID: uuid.New(), Slug: "globex"})
+	if _, err := service.Accept(other, invitee.id, invitee.email, token); !errors.Is(err, ErrInvitationNotFound) {
+		t.Errorf("Expected invitations not to cross tenants, got %v", err)
+	}
+
+	joined, err := service.Accept(ctx, invitee.id, invitee.email, token)
+	if err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+	if joined.ID != org.ID || joined.Role != RoleMember {
+		t.Errorf("Expected to join %s as a member, got %+v", org.ID, joined)
+	}
+	if _, err := service.Accept(ctx, invitee.id, invitee.email, token); !errors.Is(err, ErrInvitationNotFound) {
+		t.Errorf("Expected an accepted invitation not to be reusable, got %v", err)
+	}
+
+	loaded, err := service.Get(ctx, invitee.id, org.ID)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if len(loaded.Members) != 2 || loaded.Role != RoleMember {
+		t.Errorf("Expected 2 members seen by a member, got %+v", loaded)
+	}
+
+	if _, err := service.Invite(ctx, inviter, org.ID, &InviteRequest{Email: invitee.email, Role: RoleMember}); !errors.Is(err, ErrAlreadyMember) {
+		t.Errorf("Expected ErrAlreadyMember, got %v", err)
+	}
+	memberInviter := Inviter{UserID: invitee.id, Email: invitee.email}
+	if _, err := service.Invite(ctx, memberInviter, org.ID, &InviteRequest{Email: "third@example.com", Role: RoleMember}); !errors.Is(err, ErrNotAllowed) {
+		t.Errorf("Expected members not to invite, got %v", err)
+	}
+
+	// Invitations expire
+	late := newUser(repo, "late@example.com")
+	if _, err := service.Invite(ctx, inviter, org.ID, &InviteRequest{Email: late.email, Role: RoleMember}); err != nil {
+		t.Fatalf("Invite failed: %v", err)
+	}
+	clk.Advance(48 * time.Hour)
+	if _, err := service.Accept(ctx, late.id, late.email, mailer.token(t)); !errors.Is(err, ErrInvitationExpired) {
+		t.Errorf("Expected ErrInvitationExpired, got %v", err)
+	}
+}
+
+func TestService_GetHidesOtherOrgs(t *testing.T) {
+	service, repo, _, _ := newTestService(t)
+	ctx := context.Background()
+	owner := newUser(repo, "owner@example.com")
+	stranger := newUser(repo, "stranger@example.com")
+
+	org, err := service.Create(ctx, owner.id, &CreateOrgRequest{Name: "Acme"})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	if _, err := service.Get(ctx, stranger.id, org.ID); !errors.Is(err, ErrOrgNotFound) {
+		t.Errorf("Expected non-members to get ErrOrgNotFound, got %v", err)
+	}
+	other := tenant.WithTenant(ctx, &tenant.Tenant{ID: uuid.New(), Slug: "globex"})
+	if _, err := service.Get(other, owner.id, org.ID); !errors.Is(err, ErrOrgNotFound) {
+		t.Errorf("Expected orgs of other tenants to be hidden, got %v", err)
+	}
+
+	orgs, err := service.ListForUser(ctx, owner.id)
+	if err != nil {
+		t.Fatalf("ListForUser failed: %v", err)
+	}
+	if len(orgs) != 1 || orgs[0].Role != RoleOwner {
+		t.Errorf("Expected one owned org, got %+v", orgs)
+	}
+}
+
+func TestService_RemoveMember(t *testing.T) {
+	service, repo, mailer, _ := newTestService(t)
+	ctx := context.Background()
+	owner := newUser(repo, "owner@example.com")
+	admin := newUser(repo, "admin@example.com")
+	member := newUser(repo, "member@example.com")
+
+	org, err := service.Create(ctx, owner.id, &CreateOrgRequest{Name: "Acme"})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	for _, u := range []struct {
+		user
+		role string
+	}{{admin, RoleAdmin}, {member, RoleMember}} {
+		inviter := Inviter{UserID: owner.id, Email: owner.email}
+		if _, err := service.Invite(ctx, inviter, org.ID, &InviteRequest{Email: u.email, Role: u.role}); err != nil {
+			t.Fatalf("Invite failed: %v", err)
+		}
+		if _, err := service.Accept(ctx, u.id, u.email, mailer.token(t)); err != nil {
+			t.Fatalf("Accept failed: %v", err)
+		}
+	}
+
+	if err := service.RemoveMember(ctx, member.id, org.ID, admin.id); !errors.Is(err, ErrNotAllowed) {
+		t.Errorf("Expected members not to remove others, got %v", err)
+	}
+	if err := service.RemoveMember(ctx, admin.id, org.ID, owner.id); !errors.Is(err, ErrNotAllowed) {
+		t.Errorf("Expected admins not to remove owners, got %v", err)
+	}
+	if err := service.RemoveMember(ctx, owner.id, org.ID, owner.id); !errors.Is(err, ErrLastOwner) {
+		t.Errorf("Expected the last owner to stay, got %v", err)
+	}
+
+	if err := service.RemoveMember(ctx, admin.id, org.ID, member.id); err != nil {
+		t.Fatalf("Expected admins to remove members, got %v", err)
+	}
+	if err := service.RemoveMember(ctx, admin.id, org.ID, admin.id); err != nil {
+		t.Fatalf("Expected members to leave, got %v", err)
+	}
+	if _, err := service.Get(ctx, admin.id, org.ID); !errors.Is(err, ErrOrgNotFound) {
+		t.Errorf("Expected a former member to lose access, got %v", err)
+	}
+}
@@ -0,0 +1,305 @@
+package org
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pixperk/goiler/db/sqlc"
+	"github.com/pixperk/goiler/internal/tenant"
+	"github.com/pixperk/goiler/pkg/budget"
+)
+
+// uniqueViolation is the Postgres error code for unique constraint violations
+const uniqueViolation = "23505"
+
+// PostgresRepository implements Repository using PostgreSQL
+type PostgresRepository struct {
+	db      *pgxpool.Pool
+	queries *sqlc.Queries
+}
+
+// NewPostgresRepository creates a new PostgreSQL repository
+func NewPostgresRepository(db *pgxpool.Pool) *PostgresRepository {
+	return &PostgresRepository{
+		db:      db,
+		queries: sqlc.New(db),
+	}
+}
+
+// CreateOrganization stores an org and its owner in one transaction
+func (r *PostgresRepository) CreateOrganization(ctx context.Context, org *Organization, owner *Member) error {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		q := r.queries.WithTx(tx)
+		err := q.CreateOrganization(ctx, sqlc.CreateOrganizationParams{
+			ID:        org.ID,
+			TenantID:  org.TenantID,
+			Name:      org.Name,
+			CreatedAt: sql.NullTime{Time: org.CreatedAt, Valid: true},
+		})
+		if err != nil {
+			return err
+		}
+		return q.CreateOrgMembership(ctx, membershipParams(owner))
+	})
+}
+
+// GetOrganization retrieves an org of the context's tenant by ID
+func (r *PostgresRepository) GetOrganization(ctx context.Context, id uuid.UUID) (*Organization, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	dbOrg, err := r.queries.GetOrganization(ctx, sqlc.GetOrganizationParams{
+		ID:       id,
+		TenantID: tenant.ID(ctx),
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrOrgNotFound
+		}
+		return nil, err
+	}
+	return &Organization{
+		ID:        dbOrg.ID,
+		TenantID:  dbOrg.TenantID,
+		Name:      dbOrg.Name,
+		CreatedAt: dbOrg.CreatedAt.Time,
+	}, nil
+}
+
+// ListUserOrganizations returns the orgs of the context's tenant a user
+// belongs to, by name
+func (r *PostgresRepository) ListUserOrganizations(ctx context.Context, userID uuid.UUID) ([]*Organization, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	rows, err := r.queries.ListUserOrganizations(ctx, sqlc.ListUserOrganizationsParams{
+		UserID:   userID,
+		TenantID: tenant.ID(ctx),
+	})
+	if err != nil {
+		return nil, err
+	}
+	orgs := make([]*Organization, len(rows))
+	for i, row := range rows {
+		orgs[i] = &Organization{
+			ID:        row.ID,
+			TenantID:  row.TenantID,
+			Name:      row.Name,
+			CreatedAt: row.CreatedAt.Time,
+			Role:      row.Role,
+		}
+	}
+	return orgs, nil
+}
+
+// GetMember retrieves a user's membership in an org
+func (r *PostgresRepository) GetMember(ctx context.Context, orgID, userID uuid.UUID) (*Member, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	m, err := r.queries.GetOrgMembership(ctx, sqlc.GetOrgMembershipParams{
+		OrgID:  orgID,
+		UserID: userID,
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotMember
+		}
+		return nil, err
+	}
+	return &Member{
+		OrgID:    m.OrgID,
+		UserID:   m.UserID,
+		Role:     m.Role,
+		JoinedAt: m.CreatedAt.Time,
+	}, nil
+}
+
+// ListMembers returns an org's members, longest-standing first
+func (r *PostgresRepository) ListMembers(ctx context.Context, orgID uuid.UUID) ([]*Member, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	rows, err := r.queries.ListOrgMembers(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	members := make([]*Member, len(rows))
+	for i, row := range rows {
+		members[i] = &Member{
+			OrgID:    row.OrgID,
+			UserID:   row.UserID,
+			Email:    row.Email,
+			Role:     row.Role,
+			JoinedAt: row.CreatedAt.Time,
+		}
+	}
+	return members, nil
+}
+
+// RemoveMember deletes a membership, rolling back if no owner is left
+func (r *PostgresRepository) RemoveMember(ctx context.Context, orgID, userID uuid.UUID) error {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		q := r.queries.WithTx(tx)
+		rows, err := q.DeleteOrgMembership(ctx, sqlc.DeleteOrgMembershipParams{
+			OrgID:  orgID,
+			UserID: userID,
+		})
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return ErrNotMember
+		}
+
+		owners, err := q.CountOrgOwners(ctx, orgID)
+		if err != nil {
+			return err
+		}
+		if owners == 0 {
+			return ErrLastOwner
+		}
+		return nil
+	})
+}
+
+// CreateInvitation replaces the pending invitations for the email with a
+// new one in one transaction
+func (r *PostgresRepository) CreateInvitation(ctx context.Context, invitation *Invitation) error {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		q := r.queries.WithTx(tx)
+		err := q.DeletePendingOrgInvitations(ctx, sqlc.DeletePendingOrgInvitationsParams{
+			OrgID: invitation.OrgID,
+			Email: invitation.Email,
+		})
+		if err != nil {
+			return err
+		}
+
+		params := sqlc.CreateOrgInvitationParams{
+			ID:        invitation.ID,
+			OrgID:     invitation.OrgID,
+			Email:     invitation.Email,
+			Role:      invitation.Role,
+			TokenHash: invitation.TokenHash,
+			ExpiresAt: sql.NullTime{Time: invitation.ExpiresAt, Valid: true},
+			CreatedAt: sql.NullTime{Time: invitation.CreatedAt, Valid: true},
+		}
+		if invitation.InvitedBy != nil {
+			params.InvitedBy = pgtype.UUID{Bytes: *invitation.InvitedBy, Valid: true}
+		}
+		return q.CreateOrgInvitation(ctx, params)
+	})
+}
+
+// GetInvitationByTokenHash retrieves an invitation by the hash of its token
+func (r *PostgresRepository) GetInvitationByTokenHash(ctx context.Context, tokenHash string) (*Invitation, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	inv, err := r.queries.GetOrgInvitationByToken(ctx, tokenHash)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrInvitationNotFound
+		}
+		return nil, err
+	}
+
+	invitation := &Invitation{
+		ID:        inv.ID,
+		OrgID:     inv.OrgID,
+		Email:     inv.Email,
+		Role:      inv.Role,
+		TokenHash: inv.TokenHash,
+		ExpiresAt: inv.ExpiresAt.Time,
+		CreatedAt: inv.CreatedAt.Time,
+	}
+	if inv.InvitedBy.Valid {
+		invitedBy := uuid.UUID(inv.InvitedBy.Bytes)
+		invitation.InvitedBy = &invitedBy
+	}
+	if inv.AcceptedAt.Valid {
+		acceptedAt := inv.AcceptedAt.Time
+		invitation.AcceptedAt = &acceptedAt
+	}
+	return invitation, nil
+}
+
+// AcceptInvitation marks an invitation accepted and adds its member in one
+// transaction. Invitations accepted concurrently are reported as not found.
+func (r *PostgresRepository) AcceptInvitation(ctx context.Context, invitation *Invitation, member *Member) error {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		q := r.queries.WithTx(tx)
+		rows, err := q.AcceptOrgInvitation(ctx, sqlc.AcceptOrgInvitationParams{
+			ID:         invitation.ID,
+			AcceptedAt: pgtype.Timestamptz{Time: member.JoinedAt, Valid: true},
+		})
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return ErrInvitationNotFound
+		}
+
+		err = q.CreateOrgMembership(ctx, membershipParams(member))
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+			return ErrAlreadyMember
+		}
+		return err
+	})
+}
+
+func membershipParams(m *Member) sqlc.CreateOrgMembershipParams {
+	return sqlc.CreateOrgMembershipParams{
+		OrgID:     m.OrgID,
+		UserID:    m.UserID,
+		Role:      m.Role,
+		CreatedAt: sql.NullTime{Time: m.JoinedAt, Valid: true},
+	}
+}
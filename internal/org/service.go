@@ -0,0 +1,439 @@
+// Package org implements organizations: teams of users within a tenant.
+// Members have an owner, admin or member role; owners and admins invite
+// others by email, and invitees join by accepting the emailed token. Users
+// pick the org they act in by switching to it, which reissues their
+// credentials with an org claim. The claim only selects the org; endpoints
+// still check membership.
+package org
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pixperk/goiler/internal/tenant"
+	"github.com/pixperk/goiler/pkg/clock"
+	"github.com/pixperk/goiler/pkg/idgen"
+)
+
+var (
+	ErrOrgNotFound        = errors.New("organization not found")
+	ErrNotMember          = errors.New("not a member of the organization")
+	ErrNotAllowed         = errors.New("organization role does not allow this")
+	ErrAlreadyMember      = errors.New("already a member of the organization")
+	ErrLastOwner          = errors.New("organization must keep an owner")
+	ErrInvitationNotFound = errors.New("invitation not found")
+	ErrInvitationExpired  = errors.New("invitation has expired")
+	// ErrInvitationEmail is returned when an invitation is accepted by a
+	// user other than the one it was sent to
+	ErrInvitationEmail = errors.New("invitation was sent to a different email")
+)
+
+// Member roles
+const (
+	// RoleOwner members manage the org and its members, including owners
+	RoleOwner = "owner"
+	// RoleAdmin members invite and remove members
+	RoleAdmin = "admin"
+	// RoleMember members belong to the org
+	RoleMember = "member"
+)
+
+// DefaultInvitationExpiry is how long invitations can be accepted
+const DefaultInvitationExpiry = 7 * 24 * time.Hour
+
+// Organization is a team of users. Role is the caller's role when listing
+// their orgs; Members is set when an org is loaded individually.
+type Organization struct {
+	ID        uuid.UUID `json:"id"`
+	TenantID  uuid.UUID `json:"-"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	Role      string    `json:"role,omitempty"`
+	Members   []*Member `json:"members,omitempty"`
+}
+
+// Member is a user's membership in an org
+type Member struct {
+	OrgID    uuid.UUID `json:"org_id"`
+	UserID   uuid.UUID `json:"user_id"`
+	Email    string    `json:"email,omitempty"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+// Invitation invites an email address to join an org. Only the hash of its
+// token is stored.
+type Invitation struct {
+	ID         uuid.UUID  `json:"id"`
+	OrgID      uuid.UUID  `json:"org_id"`
+	Email      string     `json:"email"`
+	Role       string     `json:"role"`
+	TokenHash  string     `json:"-"`
+	InvitedBy  *uuid.UUID `json:"invited_by,omitempty"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	AcceptedAt *time.Time `json:"accepted_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// Repository stores orgs, memberships and invitations. Orgs are scoped to
+// the tenant in the context, see tenant.ID.
+type Repository interface {
+	// CreateOrganization stores an org together with its first owner
+	CreateOrganization(ctx context.Context, org *Organization, owner *Member) error
+	GetOrganization(ctx context.Context, id uuid.UUID) (*Organization, error)
+	// ListUserOrganizations lists a user's orgs with their role in each
+	ListUserOrganizations(ctx context.Context, userID uuid.UUID) ([]*Organization, error)
+	// GetMember returns ErrNotMember if the user isn't in the org
+	GetMember(ctx context.Context, orgID, userID uuid.UUID) (*Member, error)
+	ListMembers(ctx context.Context, orgID uuid.UUID) ([]*Member, error)
+	// RemoveMember fails with ErrLastOwner rather than leave the org
+	// without an owner
+	RemoveMember(ctx context.Context, orgID, userID uuid.UUID) error
+	// CreateInvitation stores an invitation, replacing any pending one for
+	// the same email
+	CreateInvitation(ctx context.Context, invitation *Invitation) error
+	GetInvitationByTokenHash(ctx context.Context, tokenHash string) (*Invitation, error)
+	// AcceptInvitation marks the invitation accepted and adds its member
+	AcceptInvitation(ctx context.Context, invitation *Invitation, member *Member) error
+}
+
+// Mailer queues invitation emails and reports whether one was coalesced
+// into an identical email already queued. *worker.Client satisfies it.
+type Mailer interface {
+	SendOrgInvitationEmail(ctx context.Context, email, orgName, inviterEmail, role, acceptURL string, expiresAt time.Time) (bool, error)
+}
+
+// Service manages orgs and their members
+type Service struct {
+	repo             Repository
+	logger           *slog.Logger
+	clock            clock.Clock
+	ids              idgen.Generator
+	mailer           Mailer
+	invitationURL    string
+	invitationExpiry time.Duration
+}
+
+// ServiceOption configures a Service
+type ServiceOption func(*Service)
+
+// WithLogger sets the logger
+func WithLogger(logger *slog.Logger) ServiceOption {
+	return func(s *Service) {
+		s.logger = logger
+	}
+}
+
+// WithClock sets the clock used to stamp orgs and expire invitations
+func WithClock(c clock.Clock) ServiceOption {
+	return func(s *Service) {
+		s.clock = c
+	}
+}
+
+// WithIDGenerator sets the generator for org and invitation IDs
+func WithIDGenerator(ids idgen.Generator) ServiceOption {
+	return func(s *Service) {
+		s.ids = ids
+	}
+}
+
+// WithMailer sets where invitation emails are queued; invitations are
+// stored but not sent without one
+func WithMailer(mailer Mailer) ServiceOption {
+	return func(s *Service) {
+		s.mailer = mailer
+	}
+}
+
+// WithInvitations sets the page invitation emails link to, which gets the
+// token as the "token" query parameter, and how long invitations last
+func WithInvitations(acceptURL string, expiry time.Duration) ServiceOption {
+	return func(s *Service) {
+		s.invitationURL = acceptURL
+		s.invitationExpiry = expiry
+	}
+}
+
+// NewService creates a new org service
+func NewService(repo Repository, opts ...ServiceOption) *Service {
+	s := &Service{
+		repo:             repo,
+		logger:           slog.Default(),
+		invitationExpiry: DefaultInvitationExpiry,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.clock = clock.OrReal(s.clock)
+	s.ids = idgen.OrDefault(s.ids)
+	return s
+}
+
+// CreateOrgRequest represents a new org
+type CreateOrgRequest struct {
+	Name string `json:"name" validate:"required,max=255"`
+}
+
+// Create creates an org in the context's tenant, owned by userID
+func (s *Service) Create(ctx context.Context, userID uuid.UUID, req *CreateOrgRequest) (*Organization, error) {
+	now := s.clock.Now()
+	org := &Organization{
+		ID:        s.ids.NewID(),
+		TenantID:  tenant.ID(ctx),
+		Name:      req.Name,
+		CreatedAt: now,
+		Role:      RoleOwner,
+	}
+	owner := &Member{OrgID: org.ID, UserID: userID, Role: RoleOwner, JoinedAt: now}
+
+	if err := s.repo.CreateOrganization(ctx, org, owner); err != nil {
+		return nil, err
+	}
+
+	s.logger.InfoContext(ctx, "organization created",
+		slog.String("org_id", org.ID.String()),
+		slog.String("user_id", userID.String()),
+	)
+	return org, nil
+}
+
+// ListForUser returns the orgs a user belongs to
+func (s *Service) ListForUser(ctx context.Context, userID uuid.UUID) ([]*Organization, error) {
+	return s.repo.ListUserOrganizations(ctx, userID)
+}
+
+// Get returns an org with its members. Orgs the user isn't a member of are
+// reported as not found.
+func (s *Service) Get(ctx context.Context, userID, orgID uuid.UUID) (*Organization, error) {
+	member, err := s.Member(ctx, userID, orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	org, err := s.repo.GetOrganization(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	org.Role = member.Role
+	if org.Members, err = s.repo.ListMembers(ctx, orgID); err != nil {
+		return nil, err
+	}
+	return org, nil
+}
+
+// Member returns a user's membership in an org of the context's tenant,
+// or ErrOrgNotFound if they have none
+func (s *Service) Member(ctx context.Context, userID, orgID uuid.UUID) (*Member, error) {
+	if _, err := s.repo.GetOrganization(ctx, orgID); err != nil {
+		return nil, err
+	}
+	member, err := s.repo.GetMember(ctx, orgID, userID)
+	if errors.Is(err, ErrNotMember) {
+		return nil, ErrOrgNotFound
+	}
+	return member, err
+}
+
+// InviteRequest represents an invitation to an org
+type InviteRequest struct {
+	Email string `json:"email" validate:"required,email,max=255"`
+	Role  string `json:"role" validate:"required,oneof=admin member"`
+}
+
+// Inviter is the member sending an invitation
+type Inviter struct {
+	UserID uuid.UUID
+	Email  string
+}
+
+// Invite invites an email address to an org and emails it the invitation
+// link. Only owners and admins can invite. Inviting an address again
+// replaces its pending invitation.
+func (s *Service) Invite(ctx context.Context, inviter Inviter, orgID uuid.UUID, req *InviteRequest) (*Invitation, error) {
+	member, err := s.Member(ctx, inviter.UserID, orgID)
+	if err != nil {
+		return nil, err
+	}
+	if !canManage(member.Role) {
+		return nil, ErrNotAllowed
+	}
+
+	org, err := s.repo.GetOrganization(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	email := strings.ToLower(req.Email)
+	members, err := s.repo.ListMembers(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range members {
+		if strings.EqualFold(m.Email, email) {
+			return nil, ErrAlreadyMember
+		}
+	}
+
+	token, err := newInvitationToken()
+	if err != nil {
+		return nil, err
+	}
+	now := s.clock.Now()
+	invitation := &Invitation{
+		ID:        s.ids.NewID(),
+		OrgID:     orgID,
+		Email:     email,
+		Role:      req.Role,
+		TokenHash: hashInvitationToken(token),
+		InvitedBy: &inviter.UserID,
+		ExpiresAt: now.Add(s.invitationExpiry),
+		CreatedAt: now,
+	}
+	if err := s.repo.CreateInvitation(ctx, invitation); err != nil {
+		return nil, err
+	}
+
+	s.logger.InfoContext(ctx, "organization invitation created",
+		slog.String("org_id", orgID.String()),
+		slog.String("invitation_id", invitation.ID.String()),
+		slog.String("invited_by", inviter.UserID.String()),
+		slog.String("role", invitation.Role),
+	)
+
+	if s.mailer != nil {
+		link, err := invitationLink(s.invitationURL, token)
+		if err != nil {
+			return nil, fmt.Errorf("build invitation link: %w", err)
+		}
+		coalesced, err := s.mailer.SendOrgInvitationEmail(ctx, email, org.Name, inviter.Email, invitation.Role, link, invitation.ExpiresAt)
+		if err != nil {
+			return nil, fmt.Errorf("queue invitation email: %w", err)
+		}
+		if coalesced {
+			s.logger.InfoContext(ctx, "invitation email already queued",
+				slog.String("invitation_id", invitation.ID.String()),
+			)
+		}
+	}
+	return invitation, nil
+}
+
+// AcceptRequest carries the token from an invitation email
+type AcceptRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// Accept adds the signed-in user to the org they were invited to. The
+// invitation must be pending, unexpired, sent to the user's email and for
+// an org in the context's tenant.
+func (s *Service) Accept(ctx context.Context, userID uuid.UUID, email, token string) (*Organization, error) {
+	invitation, err := s.repo.GetInvitationByTokenHash(ctx, hashInvitationToken(token))
+	if err != nil {
+		return nil, err
+	}
+	if invitation.AcceptedAt != nil {
+		return nil, ErrInvitationNotFound
+	}
+	now := s.clock.Now()
+	if !now.Before(invitation.ExpiresAt) {
+		return nil, ErrInvitationExpired
+	}
+	if !strings.EqualFold(invitation.Email, email) {
+		return nil, ErrInvitationEmail
+	}
+
+	org, err := s.repo.GetOrganization(ctx, invitation.OrgID)
+	if err != nil {
+		if errors.Is(err, ErrOrgNotFound) {
+			return nil, ErrInvitationNotFound
+		}
+		return nil, err
+	}
+
+	member := &Member{OrgID: org.ID, UserID: userID, Email: email, Role: invitation.Role, JoinedAt: now}
+	if err := s.repo.AcceptInvitation(ctx, invitation, member); err != nil {
+		return nil, err
+	}
+	org.Role = member.Role
+
+	s.logger.InfoContext(ctx, "organization invitation accepted",
+		slog.String("org_id", org.ID.String()),
+		slog.String("invitation_id", invitation.ID.String()),
+		slog.String("user_id", userID.String()),
+	)
+	return org, nil
+}
+
+// RemoveMember removes userID from an org. Members can remove themselves to
+// leave; removing others takes an owner or admin, and only owners remove
+// owners. The last owner can't be removed.
+func (s *Service) RemoveMember(ctx context.Context, actorID, orgID, userID uuid.UUID) error {
+	actor, err := s.Member(ctx, actorID, orgID)
+	if err != nil {
+		return err
+	}
+
+	if actorID != userID {
+		target, err := s.repo.GetMember(ctx, orgID, userID)
+		if err != nil {
+			return err
+		}
+		if !canManage(actor.Role) || (target.Role == RoleOwner && actor.Role != RoleOwner) {
+			return ErrNotAllowed
+		}
+	}
+
+	if err := s.repo.RemoveMember(ctx, orgID, userID); err != nil {
+		return err
+	}
+
+	s.logger.InfoContext(ctx, "organization member removed",
+		slog.String("org_id", orgID.String()),
+		slog.String("user_id", userID.String()),
+		slog.String("removed_by", actorID.String()),
+	)
+	return nil
+}
+
+// canManage reports whether role can invite and remove members
+func canManage(role string) bool {
+	return role == RoleOwner || role == RoleAdmin
+}
+
+// newInvitationToken returns a random invitation token
+func newInvitationToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashInvitationToken returns the hash invitations are stored under
+func hashInvitationToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// invitationLink returns rawURL with token added as the "token" query
+// parameter
+func invitationLink(rawURL, token string) (string, error) {
+	link, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+	return link.String(), nil
+}
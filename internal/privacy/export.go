@@ -0,0 +1,154 @@
+package privacy
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Export is the personal data held about a user. Each field is a JSON file
+// in the export archive.
+type Export struct {
+	Account           Account            `json:"account"`
+	Identities        []Identity         `json:"identities"`
+	Sessions          []Session          `json:"sessions"`
+	Passkeys          []Passkey          `json:"passkeys"`
+	PolicyAcceptances []PolicyAcceptance `json:"policy_acceptances"`
+	Organizations     []Organization     `json:"organizations"`
+	Tickets           []Ticket           `json:"tickets"`
+	Emails            []Email            `json:"emails"`
+}
+
+// Account is the user's profile
+type Account struct {
+	ID              uuid.UUID  `json:"id"`
+	TenantID        uuid.UUID  `json:"tenant_id"`
+	Email           string     `json:"email"`
+	Name            string     `json:"name,omitempty"`
+	Role            string     `json:"role"`
+	Guest           bool       `json:"guest"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+// Identity is an external login linked to the account
+type Identity struct {
+	Provider       string    `json:"provider"`
+	ProviderUserID string    `json:"provider_user_id"`
+	Email          string    `json:"email,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// Session is a signed-in device, without its token
+type Session struct {
+	ID        uuid.UUID `json:"id"`
+	IPAddress string    `json:"ip_address"`
+	UserAgent string    `json:"user_agent"`
+	StartedAt time.Time `json:"started_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Passkey is a registered WebAuthn credential, without its key
+type Passkey struct {
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// PolicyAcceptance records the user accepting a policy version
+type PolicyAcceptance struct {
+	PolicyID   uuid.UUID `json:"policy_id"`
+	AcceptedAt time.Time `json:"accepted_at"`
+	IPAddress  string    `json:"ip_address"`
+	UserAgent  string    `json:"user_agent"`
+}
+
+// Organization is an org the user is a member of
+type Organization struct {
+	ID   uuid.UUID `json:"id"`
+	Name string    `json:"name"`
+	Role string    `json:"role"`
+}
+
+// Ticket is a support ticket the user opened, with its conversation
+type Ticket struct {
+	ID        uuid.UUID       `json:"id"`
+	Subject   string          `json:"subject"`
+	Status    string          `json:"status"`
+	CreatedAt time.Time       `json:"created_at"`
+	Messages  []TicketMessage `json:"messages"`
+}
+
+// TicketMessage is a message on a ticket
+type TicketMessage struct {
+	Staff     bool      `json:"staff"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Email is a transactional email sent to the user
+type Email struct {
+	Email  string    `json:"email"`
+	Type   string    `json:"type"`
+	Status string    `json:"status"`
+	SentAt time.Time `json:"sent_at"`
+}
+
+// manifest describes an export archive
+type manifest struct {
+	RequestID   uuid.UUID `json:"request_id"`
+	UserID      uuid.UUID `json:"user_id"`
+	GeneratedAt time.Time `json:"generated_at"`
+	Files       []string  `json:"files"`
+}
+
+// Archive zips the export as one indented JSON file per section, plus a
+// manifest.json naming them
+func (e *Export) Archive(requestID uuid.UUID, generatedAt time.Time) ([]byte, error) {
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"account.json", e.Account},
+		{"identities.json", e.Identities},
+		{"sessions.json", e.Sessions},
+		{"passkeys.json", e.Passkeys},
+		{"policy_acceptances.json", e.PolicyAcceptances},
+		{"organizations.json", e.Organizations},
+		{"tickets.json", e.Tickets},
+		{"emails.json", e.Emails},
+	}
+
+	m := manifest{RequestID: requestID, UserID: e.Account.ID, GeneratedAt: generatedAt}
+	for _, f := range files {
+		m.Files = append(m.Files, f.name)
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	write := func(name string, data interface{}) error {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: generatedAt})
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(data)
+	}
+	if err := write("manifest.json", m); err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if err := write(f.name, f.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
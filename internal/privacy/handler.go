@@ -0,0 +1,205 @@
+package privacy
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/internal/auth"
+	"github.com/pixperk/goiler/pkg/response"
+)
+
+// PasswordVerifier confirms users' passwords. *user.Service satisfies it.
+type PasswordVerifier interface {
+	VerifyPassword(ctx context.Context, userID uuid.UUID, password string) (bool, error)
+}
+
+// Handler handles HTTP requests for privacy requests
+type Handler struct {
+	service   *Service
+	passwords PasswordVerifier
+	logger    *slog.Logger
+}
+
+// NewHandler creates a new privacy handler. Erasures are confirmed with the
+// user's password through passwords.
+func NewHandler(service *Service, passwords PasswordVerifier, logger *slog.Logger) *Handler {
+	return &Handler{service: service, passwords: passwords, logger: logger}
+}
+
+// ErasureRequest confirms an erasure with the user's password. Accounts
+// without one, such as guests, leave it empty.
+type ErasureRequest struct {
+	Password string `json:"password"`
+}
+
+// RequestExport queues an export of the current user's data
+// @Summary Export my data
+// @Description Queue an archive of the current user's personal data. Poll the returned request until it completes, then download its archive. An export already in progress is returned instead of starting another.
+// @Tags Users
+// @Security BearerAuth
+// @Produce json
+// @Success 202 {object} Request
+// @Failure 401 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /api/v1/users/me/export [post]
+func (h *Handler) RequestExport(c echo.Context) error {
+	return h.request(c, TypeExport)
+}
+
+// RequestErasure queues the erasure of the current user's data
+// @Summary Erase my data
+// @Description Queue the erasure of the current user's personal data: the account is anonymized and deactivated, its logins, passkeys, avatar and sessions are deleted and its tokens revoked. The user's current password must be sent to confirm. Poll the returned request to follow it. Not allowed while impersonating.
+// @Tags Users
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body ErasureRequest true "Password confirmation"
+// @Success 202 {object} Request
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response "Not authenticated or wrong password"
+// @Failure 403 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /api/v1/users/me/erasure [post]
+func (h *Handler) RequestErasure(c echo.Context) error {
+	payload := auth.GetCurrentUser(c)
+	if payload == nil {
+		return response.Unauthorized(c, "User not authenticated")
+	}
+
+	var req ErasureRequest
+	if err := c.Bind(&req); err != nil {
+		return response.BadRequest(c, "Invalid request body")
+	}
+	ok, err := h.passwords.VerifyPassword(c.Request().Context(), payload.UserID, req.Password)
+	if err != nil {
+		h.logger.ErrorContext(c.Request().Context(), "failed to verify password", slog.String("error", err.Error()))
+		return response.InternalError(c, "Failed to queue request")
+	}
+	if !ok {
+		return response.Unauthorized(c, "Password is incorrect")
+	}
+	return h.request(c, TypeErasure)
+}
+
+func (h *Handler) request(c echo.Context, requestType string) error {
+	payload := auth.GetCurrentUser(c)
+	if payload == nil {
+		return response.Unauthorized(c, "User not authenticated")
+	}
+
+	ctx := c.Request().Context()
+	var req *Request
+	var err error
+	if requestType == TypeErasure {
+		req, err = h.service.RequestErasure(ctx, payload.UserID)
+	} else {
+		req, err = h.service.RequestExport(ctx, payload.UserID)
+	}
+	if err != nil {
+		h.logger.ErrorContext(ctx, "failed to request "+requestType, slog.String("error", err.Error()))
+		return response.InternalError(c, "Failed to queue request")
+	}
+	return response.Accepted(c, req)
+}
+
+// GetMine returns one of the current user's privacy requests
+// @Summary Get my privacy request
+// @Description Get the status of one of the current user's export or erasure requests
+// @Tags Users
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Request ID"
+// @Success 200 {object} Request
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Router /api/v1/users/me/privacy-requests/{id} [get]
+func (h *Handler) GetMine(c echo.Context) error {
+	payload := auth.GetCurrentUser(c)
+	if payload == nil {
+		return response.Unauthorized(c, "User not authenticated")
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return response.BadRequest(c, "Invalid request ID")
+	}
+
+	req, err := h.service.Get(c.Request().Context(), payload.UserID, id)
+	if err != nil {
+		if errors.Is(err, ErrRequestNotFound) {
+			return response.NotFound(c, "Request not found")
+		}
+		return response.InternalError(c, "Failed to get request")
+	}
+	return response.Success(c, req)
+}
+
+// DownloadArchive downloads the archive of one of the current user's exports
+// @Summary Download my data export
+// @Description Download the zip archive of a completed export of the current user's data, until it expires
+// @Tags Users
+// @Security BearerAuth
+// @Produce application/zip
+// @Param id path string true "Request ID"
+// @Success 200 {file} file
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Router /api/v1/users/me/privacy-requests/{id}/archive [get]
+func (h *Handler) DownloadArchive(c echo.Context) error {
+	payload := auth.GetCurrentUser(c)
+	if payload == nil {
+		return response.Unauthorized(c, "User not authenticated")
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return response.BadRequest(c, "Invalid request ID")
+	}
+
+	archive, err := h.service.Archive(c.Request().Context(), payload.UserID, id)
+	if err != nil {
+		if errors.Is(err, ErrArchiveUnavailable) {
+			return response.NotFound(c, "Export archive not available")
+		}
+		return response.InternalError(c, "Failed to get export archive")
+	}
+
+	c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="export-`+id.String()+`.zip"`)
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+	return c.Blob(http.StatusOK, "application/zip", archive)
+}
+
+// Get returns a privacy request of any user
+// @Summary Get privacy request
+// @Description Get the status of a user's export or erasure request (admin only)
+// @Tags Admin
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Request ID"
+// @Success 200 {object} Request
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Router /api/v1/admin/privacy-requests/{id} [get]
+func (h *Handler) Get(c echo.Context) error {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return response.BadRequest(c, "Invalid request ID")
+	}
+
+	req, err := h.service.GetAny(c.Request().Context(), id)
+	if err != nil {
+		if errors.Is(err, ErrRequestNotFound) {
+			return response.NotFound(c, "Request not found")
+		}
+		return response.InternalError(c, "Failed to get request")
+	}
+	return response.Success(c, req)
+}
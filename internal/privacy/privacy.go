@@ -0,0 +1,404 @@
+// Package privacy handles users' data protection requests: exporting their
+// personal data as a downloadable archive, and erasing it. Requests are
+// stored and processed by the worker, so users poll a request's status
+// until it completes.
+package privacy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pixperk/goiler/pkg/clock"
+	"github.com/pixperk/goiler/pkg/idgen"
+	"github.com/pixperk/goiler/pkg/storage"
+)
+
+var (
+	ErrRequestNotFound = errors.New("privacy request not found")
+	// ErrRequestOpen is returned when storing a request while the user has
+	// another of the same type pending or processing
+	ErrRequestOpen = errors.New("privacy request already open")
+	// ErrArchiveUnavailable is returned for exports that haven't completed
+	// or whose archive has expired
+	ErrArchiveUnavailable = errors.New("export archive not available")
+	// ErrNoQueue is returned for requests made without a queue to process
+	// them
+	ErrNoQueue = errors.New("no privacy request queue configured")
+)
+
+// failureReason is the error users see on requests the worker gave up on;
+// the cause is logged by the worker
+const failureReason = "the request could not be completed, please try again"
+
+// DefaultExportRetention is how long export archives can be downloaded
+// unless configured otherwise
+const DefaultExportRetention = 7 * 24 * time.Hour
+
+// Request types
+const (
+	TypeExport  = "export"
+	TypeErasure = "erasure"
+)
+
+// Request statuses
+const (
+	StatusPending    = "pending"
+	StatusProcessing = "processing"
+	StatusCompleted  = "completed"
+	StatusFailed     = "failed"
+)
+
+// Request is a user's request to export or erase their data
+type Request struct {
+	ID     uuid.UUID `json:"id"`
+	UserID uuid.UUID `json:"user_id"`
+	Type   string    `json:"type"`
+	Status string    `json:"status"`
+	// Error is why a failed request failed
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	// ExpiresAt is when a completed export's archive is deleted
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Repository stores requests and reads and erases the data they cover
+type Repository interface {
+	// Create stores a request, returning ErrRequestOpen if the user has an
+	// open request of the same type
+	Create(ctx context.Context, req *Request) error
+	Get(ctx context.Context, id uuid.UUID) (*Request, error)
+	// GetOpen returns the user's pending or processing request of a type
+	GetOpen(ctx context.Context, userID uuid.UUID, requestType string) (*Request, error)
+	// Start marks an open request as processing, reporting false if it
+	// already finished
+	Start(ctx context.Context, id uuid.UUID, at time.Time) (bool, error)
+	// Complete marks a request completed, storing the archive of an export
+	// until expiresAt
+	Complete(ctx context.Context, id uuid.UUID, archive []byte, at, expiresAt time.Time) error
+	// Fail marks a request that hasn't completed as failed
+	Fail(ctx context.Context, id uuid.UUID, reason string, at time.Time) error
+	// Archive returns the archive of the user's export while it hasn't
+	// expired at now
+	Archive(ctx context.Context, userID, id uuid.UUID, now time.Time) ([]byte, error)
+	// ExpireArchives deletes archives expiring at or before t and returns
+	// how many were deleted
+	ExpireArchives(ctx context.Context, t time.Time) (int64, error)
+	// ExportData gathers the personal data held about a user
+	ExportData(ctx context.Context, userID uuid.UUID) (*Export, error)
+	// AvatarKey returns the object key of the user's avatar, or "" if they
+	// have none
+	AvatarKey(ctx context.Context, userID uuid.UUID) (string, error)
+	// Erase deletes the user's personal data in one transaction, replacing
+	// their email with erasedEmail, deactivating the account and revoking
+	// their tokens
+	Erase(ctx context.Context, userID uuid.UUID, erasedEmail string) error
+}
+
+// SessionStore ends the cookie sessions of users, for sessions kept outside
+// the database. auth.SessionStore satisfies it.
+type SessionStore interface {
+	DeleteUserSessions(ctx context.Context, userID uuid.UUID) error
+}
+
+// Queue queues requests for the worker and reports whether one was
+// coalesced into an identical task already queued. *worker.Client
+// satisfies it.
+type Queue interface {
+	ExportUserData(ctx context.Context, requestID string) (bool, error)
+	EraseUserData(ctx context.Context, requestID string) (bool, error)
+}
+
+// Service accepts privacy requests and processes them
+type Service struct {
+	repo      Repository
+	queue     Queue
+	logger    *slog.Logger
+	clock     clock.Clock
+	ids       idgen.Generator
+	retention time.Duration
+	sessions  SessionStore
+	objects   storage.Store
+}
+
+// ServiceOption configures a Service
+type ServiceOption func(*Service)
+
+// WithLogger sets the logger
+func WithLogger(logger *slog.Logger) ServiceOption {
+	return func(s *Service) {
+		s.logger = logger
+	}
+}
+
+// WithClock sets the clock used to stamp requests and expire archives
+func WithClock(c clock.Clock) ServiceOption {
+	return func(s *Service) {
+		s.clock = c
+	}
+}
+
+// WithIDGenerator sets the generator for request IDs
+func WithIDGenerator(ids idgen.Generator) ServiceOption {
+	return func(s *Service) {
+		s.ids = ids
+	}
+}
+
+// WithQueue sets where requests are queued for processing; the API needs
+// one, the worker processing them doesn't
+func WithQueue(queue Queue) ServiceOption {
+	return func(s *Service) {
+		s.queue = queue
+	}
+}
+
+// WithExportRetention sets how long export archives can be downloaded
+func WithExportRetention(d time.Duration) ServiceOption {
+	return func(s *Service) {
+		if d > 0 {
+			s.retention = d
+		}
+	}
+}
+
+// WithSessions sets where erased users' cookie sessions are ended, for
+// sessions kept in Redis; sessions in the database are erased with the
+// rest of the data
+func WithSessions(sessions SessionStore) ServiceOption {
+	return func(s *Service) {
+		s.sessions = sessions
+	}
+}
+
+// WithObjectStore sets the store erased users' avatar images are deleted
+// from
+func WithObjectStore(store storage.Store) ServiceOption {
+	return func(s *Service) {
+		s.objects = store
+	}
+}
+
+// NewService creates a new privacy service
+func NewService(repo Repository, opts ...ServiceOption) *Service {
+	s := &Service{
+		repo:      repo,
+		logger:    slog.Default(),
+		retention: DefaultExportRetention,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.clock = clock.OrReal(s.clock)
+	s.ids = idgen.OrDefault(s.ids)
+	return s
+}
+
+// RequestExport queues an export of the user's data, or returns the export
+// already in progress
+func (s *Service) RequestExport(ctx context.Context, userID uuid.UUID) (*Request, error) {
+	return s.request(ctx, userID, TypeExport)
+}
+
+// RequestErasure queues the erasure of the user's data, or returns the
+// erasure already in progress
+func (s *Service) RequestErasure(ctx context.Context, userID uuid.UUID) (*Request, error) {
+	return s.request(ctx, userID, TypeErasure)
+}
+
+func (s *Service) request(ctx context.Context, userID uuid.UUID, requestType string) (*Request, error) {
+	if s.queue == nil {
+		return nil, ErrNoQueue
+	}
+
+	open, err := s.repo.GetOpen(ctx, userID, requestType)
+	if err == nil {
+		return open, nil
+	}
+	if !errors.Is(err, ErrRequestNotFound) {
+		return nil, err
+	}
+
+	now := s.clock.Now()
+	req := &Request{
+		ID:        s.ids.NewID(),
+		UserID:    userID,
+		Type:      requestType,
+		Status:    StatusPending,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := s.repo.Create(ctx, req); err != nil {
+		// A concurrent request got there first
+		if errors.Is(err, ErrRequestOpen) {
+			return s.repo.GetOpen(ctx, userID, requestType)
+		}
+		return nil, err
+	}
+
+	enqueue := s.queue.ExportUserData
+	if requestType == TypeErasure {
+		enqueue = s.queue.EraseUserData
+	}
+	coalesced, err := enqueue(ctx, req.ID.String())
+	if err != nil {
+		// Fail the request so the user can make another
+		if ferr := s.repo.Fail(ctx, req.ID, "failed to queue request", s.clock.Now()); ferr != nil {
+			s.logger.WarnContext(ctx, "failed to fail unqueued privacy request",
+				slog.String("request_id", req.ID.String()),
+				slog.String("error", ferr.Error()),
+			)
+		}
+		return nil, fmt.Errorf("failed to queue %s request: %w", requestType, err)
+	}
+
+	s.logger.InfoContext(ctx, "privacy request queued",
+		slog.String("request_id", req.ID.String()),
+		slog.String("user_id", userID.String()),
+		slog.String("type", requestType),
+		slog.Bool("coalesced", coalesced),
+	)
+	return req, nil
+}
+
+// Get returns one of the user's requests
+func (s *Service) Get(ctx context.Context, userID, id uuid.UUID) (*Request, error) {
+	req, err := s.repo.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if req.UserID != userID {
+		return nil, ErrRequestNotFound
+	}
+	return req, nil
+}
+
+// GetAny returns a request of any user
+func (s *Service) GetAny(ctx context.Context, id uuid.UUID) (*Request, error) {
+	return s.repo.Get(ctx, id)
+}
+
+// Archive returns the zip archive of the user's completed export
+func (s *Service) Archive(ctx context.Context, userID, id uuid.UUID) ([]byte, error) {
+	return s.repo.Archive(ctx, userID, id, s.clock.Now())
+}
+
+// ProcessExport gathers the user's data into the request's archive.
+// Requests that already finished are skipped, so retries are safe.
+func (s *Service) ProcessExport(ctx context.Context, id uuid.UUID) error {
+	req, ok, err := s.start(ctx, id, TypeExport)
+	if err != nil || !ok {
+		return err
+	}
+
+	data, err := s.repo.ExportData(ctx, req.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to gather export data: %w", err)
+	}
+	now := s.clock.Now()
+	archive, err := data.Archive(req.ID, now)
+	if err != nil {
+		return fmt.Errorf("failed to build export archive: %w", err)
+	}
+	if err := s.repo.Complete(ctx, req.ID, archive, now, now.Add(s.retention)); err != nil {
+		return err
+	}
+
+	s.logger.InfoContext(ctx, "privacy export completed",
+		slog.String("request_id", req.ID.String()),
+		slog.String("user_id", req.UserID.String()),
+		slog.Int("archive_bytes", len(archive)),
+	)
+	return nil
+}
+
+// ProcessErasure anonymizes and deactivates the user, deletes the rest of
+// their personal data and avatar image, and ends their sessions. Requests
+// that already finished are skipped, so retries are safe.
+func (s *Service) ProcessErasure(ctx context.Context, id uuid.UUID) error {
+	req, ok, err := s.start(ctx, id, TypeErasure)
+	if err != nil || !ok {
+		return err
+	}
+
+	// The image goes first: once the avatar's row is erased, a retry
+	// couldn't find its key
+	if s.objects != nil {
+		key, err := s.repo.AvatarKey(ctx, req.UserID)
+		if err != nil {
+			return fmt.Errorf("failed to find avatar: %w", err)
+		}
+		if key != "" {
+			if err := s.objects.Delete(ctx, key); err != nil {
+				return fmt.Errorf("failed to delete avatar: %w", err)
+			}
+		}
+	}
+	if err := s.repo.Erase(ctx, req.UserID, ErasedEmail(req.UserID)); err != nil {
+		return fmt.Errorf("failed to erase user data: %w", err)
+	}
+	// Sessions are ended after the password is erased, so none can be
+	// started in between
+	if s.sessions != nil {
+		if err := s.sessions.DeleteUserSessions(ctx, req.UserID); err != nil {
+			return fmt.Errorf("failed to end sessions: %w", err)
+		}
+	}
+	if err := s.repo.Complete(ctx, req.ID, nil, s.clock.Now(), time.Time{}); err != nil {
+		return err
+	}
+
+	s.logger.InfoContext(ctx, "privacy erasure completed",
+		slog.String("request_id", req.ID.String()),
+		slog.String("user_id", req.UserID.String()),
+	)
+	return nil
+}
+
+// start loads a request of requestType and marks it processing, reporting
+// false if it already finished
+func (s *Service) start(ctx context.Context, id uuid.UUID, requestType string) (*Request, bool, error) {
+	req, err := s.repo.Get(ctx, id)
+	if err != nil {
+		return nil, false, err
+	}
+	if req.Type != requestType {
+		return nil, false, fmt.Errorf("request %s is an %s request, not %s", id, req.Type, requestType)
+	}
+	ok, err := s.repo.Start(ctx, id, s.clock.Now())
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	return req, true, nil
+}
+
+// Fail marks a request as failed, for when the worker gives up on it. The
+// request's error is a generic one users can be shown; the worker logs the
+// cause.
+func (s *Service) Fail(ctx context.Context, id uuid.UUID) error {
+	return s.repo.Fail(ctx, id, failureReason, s.clock.Now())
+}
+
+// ExpireArchives deletes export archives past their expiry and returns how
+// many were deleted
+func (s *Service) ExpireArchives(ctx context.Context) (int64, error) {
+	deleted, err := s.repo.ExpireArchives(ctx, s.clock.Now())
+	if err != nil {
+		return 0, err
+	}
+	s.logger.InfoContext(ctx, "expired privacy export archives",
+		slog.Int64("deleted", deleted),
+	)
+	return deleted, nil
+}
+
+// ErasedEmail is the address an erased user's email is replaced with. It
+// stays unique per user and can't receive mail.
+func ErasedEmail(userID uuid.UUID) string {
+	return "erased+" + userID.String() + "@invalid"
+}
@@ -0,0 +1,489 @@
+package privacy
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/internal/auth"
+	"github.com/pixperk/goiler/internal/testutil"
+	"github.com/pixperk/goiler/pkg/authctx"
+	"github.com/pixperk/goiler/pkg/clock"
+	"github.com/pixperk/goiler/pkg/storage"
+)
+
+type memoryRepository struct {
+	mu       sync.Mutex
+	requests map[uuid.UUID]*Request
+	archives map[uuid.UUID][]byte
+	exports  map[uuid.UUID]*Export
+	erased   map[uuid.UUID]string
+	avatars  map[uuid.UUID]string
+}
+
+func newMemoryRepository() *memoryRepository {
+	return &memoryRepository{
+		requests: make(map[uuid.UUID]*Request),
+		archives: make(map[uuid.UUID][]byte),
+		exports:  make(map[uuid.UUID]*Export),
+		erased:   make(map[uuid.UUID]string),
+		avatars:  make(map[uuid.UUID]string),
+	}
+}
+
+func (r *memoryRepository) Create(ctx context.Context, req *Request) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.requests {
+		if existing.UserID == req.UserID && existing.Type == req.Type && open(existing) {
+			return ErrRequestOpen
+		}
+	}
+	stored := *req
+	r.requests[req.ID] = &stored
+	return nil
+}
+
+func open(req *Request) bool {
+	return req.Status == StatusPending || req.Status == StatusProcessing
+}
+
+func (r *memoryRepository) Get(ctx context.Context, id uuid.UUID) (*Request, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	req, ok := r.requests[id]
+	if !ok {
+		return nil, ErrRequestNotFound
+	}
+	copied := *req
+	return &copied, nil
+}
+
+func (r *memoryRepository) GetOpen(ctx context.Context, userID uuid.UUID, requestType string) (*Request, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, req := range r.requests {
+		if req.UserID == userID && req.Type == requestType && open(req) {
+			copied := *req
+			return &copied, nil
+		}
+	}
+	return nil, ErrRequestNotFound
+}
+
+func (r *memoryRepository) Start(ctx context.Context, id uuid.UUID, at time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	req, ok := r.requests[id]
+	if !ok || !open(req) {
+		return false, nil
+	}
+	req.Status = StatusProcessing
+	req.UpdatedAt = at
+	return true, nil
+}
+
+func (r *memoryRepository) Complete(ctx context.Context, id uuid.UUID, archive []byte, at, expiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	req := r.requests[id]
+	req.Status = StatusCompleted
+	req.Error = ""
+	req.UpdatedAt = at
+	req.CompletedAt = &at
+	if !expiresAt.IsZero() {
+		req.ExpiresAt = &expiresAt
+	}
+	if archive != nil {
+		r.archives[id] = archive
+	}
+	return nil
+}
+
+func (r *memoryRepository) Fail(ctx context.Context, id uuid.UUID, reason string, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if req, ok := r.requests[id]; ok && req.Status != StatusCompleted {
+		req.Status = StatusFailed
+		req.Error = reason
+		req.UpdatedAt = at
+	}
+	return nil
+}
+
+func (r *memoryRepository) Archive(ctx context.Context, userID, id uuid.UUID, now time.Time) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	req, ok := r.requests[id]
+	archive := r.archives[id]
+	if !ok || req.UserID != userID || archive == nil || !req.ExpiresAt.After(now) {
+		return nil, ErrArchiveUnavailable
+	}
+	return archive, nil
+}
+
+func (r *memoryRepository) ExpireArchives(ctx context.Context, t time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var deleted int64
+	for id := range r.archives {
+		if !r.requests[id].ExpiresAt.After(t) {
+			delete(r.archives, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (r *memoryRepository) ExportData(ctx context.Context, userID uuid.UUID) (*Export, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	export, ok := r.exports[userID]
+	if !ok {
+		return nil, errors.New("user not found")
+	}
+	return export, nil
+}
+
+func (r *memoryRepository) AvatarKey(ctx context.Context, userID uuid.UUID) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.avatars[userID], nil
+}
+
+func (r *memoryRepository) Erase(ctx context.Context, userID uuid.UUID, erasedEmail string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.erased[userID] = erasedEmail
+	delete(r.avatars, userID)
+	return nil
+}
+
+type memorySessions struct {
+	ended []uuid.UUID
+}
+
+func (s *memorySessions) DeleteUserSessions(ctx context.Context, userID uuid.UUID) error {
+	s.ended = append(s.ended, userID)
+	return nil
+}
+
+type staticPasswords map[uuid.UUID]string
+
+func (p staticPasswords) VerifyPassword(ctx context.Context, userID uuid.UUID, password string) (bool, error) {
+	return p[userID] == password, nil
+}
+
+type memoryQueue struct {
+	exports  []string
+	erasures []string
+	err      error
+}
+
+func (q *memoryQueue) ExportUserData(ctx context.Context, requestID string) (bool, error) {
+	if q.err != nil {
+		return false, q.err
+	}
+	q.exports = append(q.exports, requestID)
+	return false, nil
+}
+
+func (q *memoryQueue) EraseUserData(ctx context.Context, requestID string) (bool, error) {
+	if q.err != nil {
+		return false, q.err
+	}
+	q.erasures = append(q.erasures, requestID)
+	return false, nil
+}
+
+func newTestService(repo Repository, queue Queue, clk clock.Clock) *Service {
+	return NewService(repo,
+		WithQueue(queue),
+		WithClock(clk),
+		WithLogger(testutil.Logger()),
+		WithExportRetention(24*time.Hour),
+	)
+}
+
+func TestService_Export(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewFrozen(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	repo := newMemoryRepository()
+	queue := &memoryQueue{}
+	svc := newTestService(repo, queue, clk)
+
+	userID := uuid.New()
+	repo.exports[userID] = &Export{
+		Account: Account{ID: userID, Email: "user@example.com"},
+		Tickets: []Ticket{{Subject: "Help", Messages: []TicketMessage{{Body: "Hi"}}}},
+	}
+
+	req, err := svc.RequestExport(ctx, userID)
+	if err != nil {
+		t.Fatalf("Failed to request export: %v", err)
+	}
+	if req.Status != StatusPending || req.Type != TypeExport {
+		t.Errorf("Unexpected request: %+v", req)
+	}
+	again, err := svc.RequestExport(ctx, userID)
+	if err != nil || again.ID != req.ID {
+		t.Errorf("Expected the open export to be returned, got %+v, %v", again, err)
+	}
+	if len(queue.exports) != 1 || queue.exports[0] != req.ID.String() {
+		t.Fatalf("Expected one queued export, got %v", queue.exports)
+	}
+
+	if _, err := svc.Archive(ctx, userID, req.ID); !errors.Is(err, ErrArchiveUnavailable) {
+		t.Errorf("Expected no archive before processing, got %v", err)
+	}
+	if err := svc.ProcessExport(ctx, req.ID); err != nil {
+		t.Fatalf("Failed to process export: %v", err)
+	}
+	got, err := svc.Get(ctx, userID, req.ID)
+	if err != nil || got.Status != StatusCompleted || got.ExpiresAt == nil {
+		t.Fatalf("Expected a completed export with an expiry, got %+v, %v", got, err)
+	}
+	if _, err := svc.Get(ctx, uuid.New(), req.ID); !errors.Is(err, ErrRequestNotFound) {
+		t.Errorf("Expected another user's request to be hidden, got %v", err)
+	}
+
+	archive, err := svc.Archive(ctx, userID, req.ID)
+	if err != nil {
+		t.Fatalf("Failed to get archive: %v", err)
+	}
+	files := readArchive(t, archive)
+	for _, name := range []string{"manifest.json", "account.json", "tickets.json", "emails.json"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("Expected %s in archive, got %v", name, files)
+		}
+	}
+	var account Account
+	if err := json.Unmarshal(files["account.json"], &account); err != nil || account.Email != "user@example.com" {
+		t.Errorf("Unexpected account.json: %s", files["account.json"])
+	}
+	if _, err := svc.Archive(ctx, uuid.New(), req.ID); !errors.Is(err, ErrArchiveUnavailable) {
+		t.Errorf("Expected another user's archive to be unavailable, got %v", err)
+	}
+
+	clk.Advance(25 * time.Hour)
+	if _, err := svc.Archive(ctx, userID, req.ID); !errors.Is(err, ErrArchiveUnavailable) {
+		t.Errorf("Expected the expired archive to be unavailable, got %v", err)
+	}
+	if deleted, err := svc.ExpireArchives(ctx); err != nil || deleted != 1 {
+		t.Errorf("Expected 1 expired archive, got %d, %v", deleted, err)
+	}
+
+	next, err := svc.RequestExport(ctx, userID)
+	if err != nil || next.ID == req.ID {
+		t.Errorf("Expected a new export once the last one completed, got %+v, %v", next, err)
+	}
+}
+
+func readArchive(t *testing.T, archive []byte) map[string][]byte {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatalf("Invalid zip archive: %v", err)
+	}
+	files := make(map[string][]byte)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("Failed to open %s: %v", f.Name, err)
+		}
+		files[f.Name], _ = io.ReadAll(rc)
+		rc.Close()
+	}
+	return files
+}
+
+func TestService_QueueFailureFailsRequest(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryRepository()
+	queue := &memoryQueue{err: errors.New("redis down")}
+	svc := newTestService(repo, queue, clock.NewFrozen(time.Now()))
+
+	userID := uuid.New()
+	if _, err := svc.RequestErasure(ctx, userID); err == nil {
+		t.Fatal("Expected the queue error to be returned")
+	}
+	for _, req := range repo.requests {
+		if req.Status != StatusFailed {
+			t.Errorf("Expected the unqueued request to fail, got %s", req.Status)
+		}
+	}
+
+	queue.err = nil
+	if _, err := svc.RequestErasure(ctx, userID); err != nil {
+		t.Errorf("Expected a new erasure after the failed one, got %v", err)
+	}
+	if len(queue.erasures) != 1 {
+		t.Errorf("Expected one queued erasure, got %v", queue.erasures)
+	}
+
+	if _, err := NewService(repo).RequestExport(ctx, userID); !errors.Is(err, ErrNoQueue) {
+		t.Errorf("Expected ErrNoQueue without a queue, got %v", err)
+	}
+}
+
+func TestService_Erasure(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryRepository()
+	svc := newTestService(repo, &memoryQueue{}, clock.NewFrozen(time.Now()))
+
+	userID := uuid.New()
+	req, err := svc.RequestErasure(ctx, userID)
+	if err != nil {
+		t.Fatalf("Failed to request erasure: %v", err)
+	}
+	if err := svc.ProcessExport(ctx, req.ID); err == nil {
+		t.Error("Expected an erasure not to be processed as an export")
+	}
+	if err := svc.ProcessErasure(ctx, req.ID); err != nil {
+		t.Fatalf("Failed to process erasure: %v", err)
+	}
+	if repo.erased[userID] != ErasedEmail(userID) {
+		t.Errorf("Expected the user to be erased, got %q", repo.erased[userID])
+	}
+
+	// A retried task finds the request completed and leaves it alone
+	delete(repo.erased, userID)
+	if err := svc.ProcessErasure(ctx, req.ID); err != nil {
+		t.Fatalf("Failed to reprocess erasure: %v", err)
+	}
+	if _, ok := repo.erased[userID]; ok {
+		t.Error("Expected a completed erasure not to run again")
+	}
+
+	got, _ := svc.Get(ctx, userID, req.ID)
+	if got.Status != StatusCompleted || got.ExpiresAt != nil {
+		t.Errorf("Expected a completed erasure without an expiry, got %+v", got)
+	}
+}
+
+func TestService_ErasureCleansUp(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryRepository()
+	sessions := &memorySessions{}
+	store := storage.NewLocalStore(t.TempDir(), "http://localhost/files", []byte("secret"))
+	svc := NewService(repo,
+		WithQueue(&memoryQueue{}),
+		WithLogger(testutil.Logger()),
+		WithSessions(sessions),
+		WithObjectStore(store),
+	)
+
+	userID := uuid.New()
+	key := "avatars/" + userID.String() + "/avatar.png"
+	if err := store.Put(ctx, key, strings.NewReader("png"), 3, "image/png"); err != nil {
+		t.Fatal(err)
+	}
+	repo.avatars[userID] = key
+
+	req, _ := svc.RequestErasure(ctx, userID)
+	if err := svc.ProcessErasure(ctx, req.ID); err != nil {
+		t.Fatalf("Failed to process erasure: %v", err)
+	}
+	if _, err := store.Get(ctx, key); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Expected the avatar image to be deleted, got %v", err)
+	}
+	if len(sessions.ended) != 1 || sessions.ended[0] != userID {
+		t.Errorf("Expected the user's sessions to be ended, got %v", sessions.ended)
+	}
+
+	failed, _ := svc.RequestErasure(ctx, userID)
+	if err := svc.Fail(ctx, failed.ID); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := svc.Get(ctx, userID, failed.ID)
+	if got.Status != StatusFailed || got.Error != failureReason {
+		t.Errorf("Expected a failed request with the generic reason, got %+v", got)
+	}
+}
+
+func TestHandler_RequestErasure(t *testing.T) {
+	repo := newMemoryRepository()
+	svc := newTestService(repo, &memoryQueue{}, clock.NewFrozen(time.Now()))
+	userID := uuid.New()
+	handler := NewHandler(svc, staticPasswords{userID: "correct horse"}, testutil.Logger())
+
+	tests := []struct {
+		name string
+		body string
+		code int
+	}{
+		{"no password", `{}`, http.StatusUnauthorized},
+		{"wrong password", `{"password":"wrong"}`, http.StatusUnauthorized},
+		{"invalid body", `{"password":`, http.StatusBadRequest},
+		{"password", `{"password":"correct horse"}`, http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			authctx.Set(c, &authctx.User{ID: userID, Payload: &auth.TokenPayload{UserID: userID}})
+			if err := handler.RequestErasure(c); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if rec.Code != tt.code {
+				t.Errorf("Expected %d, got %d: %s", tt.code, rec.Code, rec.Body)
+			}
+		})
+	}
+	if len(repo.requests) != 1 {
+		t.Errorf("Expected one erasure request, got %d", len(repo.requests))
+	}
+}
+
+func TestHandler_DownloadArchive(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryRepository()
+	svc := newTestService(repo, &memoryQueue{}, clock.NewFrozen(time.Now()))
+	handler := NewHandler(svc, staticPasswords{}, testutil.Logger())
+
+	userID := uuid.New()
+	repo.exports[userID] = &Export{Account: Account{ID: userID}}
+	req, _ := svc.RequestExport(ctx, userID)
+
+	download := func() *httptest.ResponseRecorder {
+		e := echo.New()
+		rec := httptest.NewRecorder()
+		c := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+		c.SetParamNames("id")
+		c.SetParamValues(req.ID.String())
+		authctx.Set(c, &authctx.User{ID: userID, Payload: &auth.TokenPayload{UserID: userID}})
+		if err := handler.DownloadArchive(c); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return rec
+	}
+
+	if rec := download(); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 before the export completes, got %d", rec.Code)
+	}
+	if err := svc.ProcessExport(ctx, req.ID); err != nil {
+		t.Fatalf("Failed to process export: %v", err)
+	}
+	rec := download()
+	if rec.Code != http.StatusOK || rec.Header().Get(echo.HeaderContentType) != "application/zip" {
+		t.Fatalf("Expected a zip download, got %d %s", rec.Code, rec.Header().Get(echo.HeaderContentType))
+	}
+	if rec.Header().Get(echo.HeaderContentDisposition) == "" {
+		t.Error("Expected the archive to be sent as an attachment")
+	}
+	readArchive(t, rec.Body.Bytes())
+}
@@ -0,0 +1,434 @@
+package privacy
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pixperk/goiler/db"
+	"github.com/pixperk/goiler/db/sqlc"
+	"github.com/pixperk/goiler/internal/tenant"
+	"github.com/pixperk/goiler/pkg/budget"
+)
+
+// uniqueViolation is the Postgres error code for unique constraint violations
+const uniqueViolation = "23505"
+
+// exportPageSize is how many tickets or emails are read at a time when
+// gathering an export
+const exportPageSize = 100
+
+// PostgresRepository implements Repository using PostgreSQL
+type PostgresRepository struct {
+	db      *pgxpool.Pool
+	queries *sqlc.Queries
+}
+
+// NewPostgresRepository creates a new PostgreSQL repository
+func NewPostgresRepository(db *pgxpool.Pool) *PostgresRepository {
+	return &PostgresRepository{
+		db:      db,
+		queries: sqlc.New(db),
+	}
+}
+
+// Create stores a request
+func (r *PostgresRepository) Create(ctx context.Context, req *Request) error {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	err = r.queries.CreatePrivacyRequest(ctx, sqlc.CreatePrivacyRequestParams{
+		ID:          req.ID,
+		UserID:      req.UserID,
+		Type:        req.Type,
+		Status:      req.Status,
+		RequestedAt: sql.NullTime{Time: req.CreatedAt, Valid: true},
+	})
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		return ErrRequestOpen
+	}
+	return err
+}
+
+// Get retrieves a request by ID, if its user is in the tenant scope of ctx
+func (r *PostgresRepository) Get(ctx context.Context, id uuid.UUID) (*Request, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	row, err := r.queries.GetPrivacyRequest(ctx, sqlc.GetPrivacyRequestParams{
+		ID:       id,
+		TenantID: tenant.ScopeParam(ctx),
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrRequestNotFound
+		}
+		return nil, err
+	}
+	return requestFromDB((*sqlc.GetOpenPrivacyRequestRow)(row)), nil
+}
+
+// GetOpen retrieves the user's pending or processing request of a type
+func (r *PostgresRepository) GetOpen(ctx context.Context, userID uuid.UUID, requestType string) (*Request, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	row, err := r.queries.GetOpenPrivacyRequest(ctx, sqlc.GetOpenPrivacyRequestParams{
+		UserID: userID,
+		Type:   requestType,
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrRequestNotFound
+		}
+		return nil, err
+	}
+	return requestFromDB(row), nil
+}
+
+// Start marks an open request as processing
+func (r *PostgresRepository) Start(ctx context.Context, id uuid.UUID, at time.Time) (bool, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer cancel()
+
+	rows, err := r.queries.StartPrivacyRequest(ctx, sqlc.StartPrivacyRequestParams{
+		ID:        id,
+		UpdatedAt: sql.NullTime{Time: at, Valid: true},
+	})
+	return rows > 0, err
+}
+
+// Complete marks a request completed
+func (r *PostgresRepository) Complete(ctx context.Context, id uuid.UUID, archive []byte, at, expiresAt time.Time) error {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return r.queries.CompletePrivacyRequest(ctx, sqlc.CompletePrivacyRequestParams{
+		ID:          id,
+		Archive:     archive,
+		CompletedAt: pgtype.Timestamptz{Time: at, Valid: true},
+		ExpiresAt:   pgtype.Timestamptz{Time: expiresAt, Valid: !expiresAt.IsZero()},
+	})
+}
+
+// Fail marks a request that hasn't completed as failed
+func (r *PostgresRepository) Fail(ctx context.Context, id uuid.UUID, reason string, at time.Time) error {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return r.queries.FailPrivacyRequest(ctx, sqlc.FailPrivacyRequestParams{
+		ID:        id,
+		Error:     pgtype.Text{String: reason, Valid: true},
+		UpdatedAt: sql.NullTime{Time: at, Valid: true},
+	})
+}
+
+// Archive retrieves the archive of the user's unexpired export
+func (r *PostgresRepository) Archive(ctx context.Context, userID, id uuid.UUID, now time.Time) ([]byte, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	archive, err := r.queries.GetPrivacyExportArchive(ctx, sqlc.GetPrivacyExportArchiveParams{
+		ID:        id,
+		UserID:    userID,
+		ExpiresAt: pgtype.Timestamptz{Time: now, Valid: true},
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrArchiveUnavailable
+		}
+		return nil, err
+	}
+	return archive, nil
+}
+
+// ExpireArchives deletes archives expiring at or before t
+func (r *PostgresRepository) ExpireArchives(ctx context.Context, t time.Time) (int64, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer cancel()
+
+	return r.queries.ExpirePrivacyArchives(ctx, pgtype.Timestamptz{Time: t, Valid: true})
+}
+
+// ExportData gathers the personal data held about a user
+func (r *PostgresRepository) ExportData(ctx context.Context, userID uuid.UUID) (*Export, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	u, err := r.queries.GetUserAccount(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	export := &Export{
+		Account: Account{
+			ID:        u.ID,
+			TenantID:  u.TenantID,
+			Email:     u.Email,
+			Name:      u.Name.String,
+			Role:      u.Role,
+			Guest:     u.IsGuest,
+			CreatedAt: u.CreatedAt.Time,
+			UpdatedAt: u.UpdatedAt.Time,
+		},
+		Identities:        []Identity{},
+		Sessions:          []Session{},
+		Passkeys:          []Passkey{},
+		PolicyAcceptances: []PolicyAcceptance{},
+		Organizations:     []Organization{},
+		Tickets:           []Ticket{},
+		Emails:            []Email{},
+	}
+	if u.EmailVerifiedAt.Valid {
+		export.Account.EmailVerifiedAt = &u.EmailVerifiedAt.Time
+	}
+
+	identities, err := r.queries.ListUserIdentities(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range identities {
+		export.Identities = append(export.Identities, Identity{
+			Provider:       i.Provider,
+			ProviderUserID: i.ProviderUserID,
+			Email:          i.Email.String,
+			CreatedAt:      i.CreatedAt.Time,
+		})
+	}
+
+	tokens, err := r.queries.ListActiveRefreshTokens(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tokens {
+		export.Sessions = append(export.Sessions, Session{
+			ID:        t.ID,
+			IPAddress: t.IpAddress,
+			UserAgent: t.UserAgent,
+			StartedAt: t.SessionStartedAt.Time,
+			ExpiresAt: t.ExpiresAt.Time,
+		})
+	}
+
+	credentials, err := r.queries.ListWebauthnCredentials(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range credentials {
+		passkey := Passkey{Name: c.Name, CreatedAt: c.CreatedAt.Time}
+		if c.LastUsedAt.Valid {
+			passkey.LastUsedAt = &c.LastUsedAt.Time
+		}
+		export.Passkeys = append(export.Passkeys, passkey)
+	}
+
+	acceptances, err := r.queries.ListUserPolicyAcceptances(ctx, sqlc.ListUserPolicyAcceptancesParams{
+		UserID:   userID,
+		TenantID: tenant.ScopeParam(ctx),
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range acceptances {
+		export.PolicyAcceptances = append(export.PolicyAcceptances, PolicyAcceptance{
+			PolicyID:   a.PolicyID,
+			AcceptedAt: a.AcceptedAt.Time,
+			IPAddress:  a.IpAddress,
+			UserAgent:  a.UserAgent,
+		})
+	}
+
+	orgs, err := r.queries.ListUserOrganizations(ctx, sqlc.ListUserOrganizationsParams{
+		UserID:   userID,
+		TenantID: u.TenantID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, o := range orgs {
+		export.Organizations = append(export.Organizations, Organization{ID: o.ID, Name: o.Name, Role: o.Role})
+	}
+
+	for offset := 0; ; offset += exportPageSize {
+		tickets, err := r.queries.ListUserTickets(ctx, sqlc.ListUserTicketsParams{
+			UserID: userID,
+			Limit:  exportPageSize,
+			Offset: int32(offset),
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range tickets {
+			messages, err := r.queries.ListTicketMessages(ctx, t.ID)
+			if err != nil {
+				return nil, err
+			}
+			ticket := Ticket{
+				ID:        t.ID,
+				Subject:   t.Subject,
+				Status:    t.Status,
+				CreatedAt: t.CreatedAt.Time,
+				Messages:  make([]TicketMessage, len(messages)),
+			}
+			for i, m := range messages {
+				ticket.Messages[i] = TicketMessage{Staff: m.Staff, Body: m.Body, CreatedAt: m.CreatedAt.Time}
+			}
+			export.Tickets = append(export.Tickets, ticket)
+		}
+		if len(tickets) < exportPageSize {
+			break
+		}
+	}
+
+	for offset := 0; ; offset += exportPageSize {
+		deliveries, err := r.queries.ListUserEmailDeliveries(ctx, sqlc.ListUserEmailDeliveriesParams{
+			UserID:   pgtype.UUID{Bytes: userID, Valid: true},
+			TenantID: tenant.ScopeParam(ctx),
+			Lim:      exportPageSize,
+			Off:      int32(offset),
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range deliveries {
+			export.Emails = append(export.Emails, Email{
+				Email:  d.Email,
+				Type:   d.Type,
+				Status: d.Status,
+				SentAt: d.CreatedAt.Time,
+			})
+		}
+		if len(deliveries) < exportPageSize {
+			break
+		}
+	}
+
+	return export, nil
+}
+
+// AvatarKey returns the object key of the user's avatar, or "" if they have
+// none
+func (r *PostgresRepository) AvatarKey(ctx context.Context, userID uuid.UUID) (string, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer cancel()
+
+	avatar, err := r.queries.GetUserAvatar(ctx, userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", nil
+		}
+		return "", err
+	}
+	return avatar.Key, nil
+}
+
+// Erase anonymizes and deactivates the user and deletes their logins,
+// tokens, sessions, avatar, email log and export archives in one
+// transaction
+func (r *PostgresRepository) Erase(ctx context.Context, userID uuid.UUID, erasedEmail string) error {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	// Erasing twice leaves the same result, so the whole transaction is
+	// retried after serialization failures and dropped connections
+	return db.Retry(ctx, "privacy.erase", func(ctx context.Context) error {
+		return r.erase(ctx, userID, erasedEmail)
+	})
+}
+
+func (r *PostgresRepository) erase(ctx context.Context, userID uuid.UUID, erasedEmail string) error {
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		q := r.queries.WithTx(tx)
+		rows, err := q.AnonymizeUser(ctx, sqlc.AnonymizeUserParams{ID: userID, Email: erasedEmail})
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			// The account was deleted, taking its data with it
+			return nil
+		}
+
+		steps := []func(context.Context, uuid.UUID) error{
+			q.DeleteUserIdentities,
+			q.DeleteUserWebauthnCredentials,
+			q.DeleteUserRefreshTokens,
+			q.DeleteUserSessions,
+			q.AnonymizeUserPolicyAcceptances,
+			q.AnonymizeUserActivity,
+			q.ClearUserPrivacyArchives,
+		}
+		for _, step := range steps {
+			if err := step(ctx, userID); err != nil {
+				return err
+			}
+		}
+
+		if _, err := q.DeleteUserAvatar(ctx, userID); err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return err
+		}
+
+		nullableID := pgtype.UUID{Bytes: userID, Valid: true}
+		if err := q.DeleteUserEmailDeliveries(ctx, nullableID); err != nil {
+			return err
+		}
+		return q.AnonymizeUserAuditLogs(ctx, nullableID)
+	})
+}
+
+func requestFromDB(row *sqlc.GetOpenPrivacyRequestRow) *Request {
+	req := &Request{
+		ID:        row.ID,
+		UserID:    row.UserID,
+		Type:      row.Type,
+		Status:    row.Status,
+		Error:     row.Error.String,
+		CreatedAt: row.CreatedAt.Time,
+		UpdatedAt: row.UpdatedAt.Time,
+	}
+	if row.CompletedAt.Valid {
+		req.CompletedAt = &row.CompletedAt.Time
+	}
+	if row.ExpiresAt.Valid {
+		req.ExpiresAt = &row.ExpiresAt.Time
+	}
+	return req
+}
@@ -0,0 +1,68 @@
+package rbac
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/internal/auth"
+	"github.com/pixperk/goiler/pkg/response"
+)
+
+// RoleResponse is a role and the permissions it grants
+type RoleResponse struct {
+	Role        string       `json:"role"`
+	Permissions []Permission `json:"permissions"`
+}
+
+// Handler handles HTTP requests for roles and permissions
+type Handler struct {
+	authz *Authorizer
+}
+
+// NewHandler creates a new RBAC handler
+func NewHandler(authz *Authorizer) *Handler {
+	return &Handler{authz: authz}
+}
+
+// GetMyPermissions returns the current user's role and permissions
+// @Summary Get my permissions
+// @Description Returns the current user's role and the permissions it grants, so clients can hide actions the user can't take
+// @Tags Users
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} RoleResponse
+// @Failure 401 {object} response.Response
+// @Router /api/v1/users/me/permissions [get]
+func (h *Handler) GetMyPermissions(c echo.Context) error {
+	user := auth.GetCurrentUser(c)
+	if user == nil {
+		return response.Unauthorized(c, "User not authenticated")
+	}
+
+	return response.Success(c, h.role(user.Role))
+}
+
+// ListRoles returns every role and its permissions
+// @Summary List roles
+// @Description Returns the active roles and the permissions each grants (admin only)
+// @Tags Admin
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {array} RoleResponse
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Router /api/v1/admin/roles [get]
+func (h *Handler) ListRoles(c echo.Context) error {
+	policy := h.authz.Policy()
+	roles := make([]RoleResponse, 0, len(policy.Roles()))
+	for _, role := range policy.Roles() {
+		roles = append(roles, h.role(role))
+	}
+	return response.Success(c, roles)
+}
+
+func (h *Handler) role(role string) RoleResponse {
+	perms := h.authz.Policy().Permissions(role)
+	if perms == nil {
+		perms = []Permission{}
+	}
+	return RoleResponse{Role: role, Permissions: perms}
+}
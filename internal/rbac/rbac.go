@@ -0,0 +1,345 @@
+// Package rbac maps roles to permissions and authorizes requests against
+// them. A permission is "resource:action"; roles may also be granted
+// "resource:*" for every action on a resource or "*" for everything but
+// pushing WebSocket messages and the platform permissions, which act
+// across tenants.
+package rbac
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/internal/auth"
+	"github.com/pixperk/goiler/internal/tenant"
+	"github.com/pixperk/goiler/pkg/response"
+)
+
+var (
+	// ErrUnauthenticated is returned when no user is authenticated
+	ErrUnauthenticated = errors.New("not authenticated")
+	// ErrForbidden is returned when the user's role lacks a permission
+	ErrForbidden = errors.New("permission denied")
+)
+
+// Permission is an action on a resource, such as "users:write"
+type Permission string
+
+// Built-in permissions guarding the admin API
+const (
+	// PermAdminAccess is required for every route under /admin
+	PermAdminAccess Permission = "admin:access"
+
+	PermUsersRead     Permission = "users:read"
+	PermUsersWrite    Permission = "users:write"
+	PermPoliciesRead  Permission = "policies:read"
+	PermPoliciesWrite Permission = "policies:write"
+	PermTicketsRead   Permission = "tickets:read"
+	PermTicketsWrite  Permission = "tickets:write"
+	PermTasksRead     Permission = "tasks:read"
+	PermTasksWrite    Permission = "tasks:write"
+	PermSystemRead    Permission = "system:read"
+	PermSystemWrite   Permission = "system:write"
+
+	// PermComplianceRead allows exporting the request archive
+	PermComplianceRead Permission = "compliance:read"
+
+	// PermUsersImpersonate allows acting as other users
+	PermUsersImpersonate Permission = "users:impersonate"
+
+	// PermWebSocketPush allows pushing messages to the WebSocket
+	// connections of users in the pusher's tenant. PermAll doesn't grant
+	// it; roles are granted it by name or with "ws:*".
+	PermWebSocketPush Permission = "ws:push"
+
+	// PermAll grants every permission but PermWebSocketPush and the
+	// platform permissions
+	PermAll Permission = "*"
+)
+
+// Platform permissions act on the whole deployment rather than one tenant.
+// PermAll doesn't grant them, so tenant admins stay within their tenant;
+// roles are granted them by name or with "platform:*".
+const (
+	// PermPlatformTenants allows creating and listing tenants
+	PermPlatformTenants Permission = "platform:tenants"
+	// PermPlatformRead allows admin reads across tenants
+	PermPlatformRead Permission = "platform:read"
+	// PermPlatformIncidents allows declaring security incidents, which sign
+	// out users of every tenant and may rotate signing keys
+	PermPlatformIncidents Permission = "platform:incidents"
+	// PermPlatformBroadcast allows pushing WebSocket messages to every
+	// client and to the rooms of any tenant
+	PermPlatformBroadcast Permission = "platform:broadcast"
+)
+
+// platformResource is the resource of the platform permissions
+const platformResource = "platform"
+
+// DefaultRoles are the roles every deployment starts with: platform admins
+// operate the deployment, admins may do anything within their tenant, and
+// users only act on their own account
+var DefaultRoles = map[string][]Permission{
+	"platform_admin": {PermAll, platformResource + ":*"},
+	"admin":          {PermAll},
+	"user":           {},
+}
+
+var permissionPattern = regexp.MustCompile(`^[a-z0-9_.-]+:([a-z0-9_.-]+|\*)$`)
+
+// Validate checks that the permission is "*", "resource:*" or
+// "resource:action"
+func (p Permission) Validate() error {
+	if p == PermAll || permissionPattern.MatchString(string(p)) {
+		return nil
+	}
+	return fmt.Errorf("invalid permission %q: want resource:action", string(p))
+}
+
+// Policy is an immutable mapping of roles to the permissions they grant
+type Policy struct {
+	roles map[string][]Permission
+}
+
+// NewPolicy creates a policy, validating every permission
+func NewPolicy(roles map[string][]Permission) (*Policy, error) {
+	policy := &Policy{roles: make(map[string][]Permission, len(roles))}
+	for role, perms := range roles {
+		if role == "" {
+			return nil, fmt.Errorf("role name must not be empty")
+		}
+		for _, perm := range perms {
+			if err := perm.Validate(); err != nil {
+				return nil, fmt.Errorf("role %s: %w", role, err)
+			}
+		}
+		sorted := slices.Clone(perms)
+		slices.Sort(sorted)
+		policy.roles[role] = slices.Compact(sorted)
+	}
+	return policy, nil
+}
+
+// explicit reports whether perm is only granted by name or by its
+// resource's wildcard, never by PermAll
+func (p Permission) explicit() bool {
+	resource, _, _ := strings.Cut(string(p), ":")
+	return resource == platformResource || p == PermWebSocketPush
+}
+
+// Allows reports whether role grants perm, directly or via a wildcard.
+// PermAll doesn't grant explicit permissions.
+func (p *Policy) Allows(role string, perm Permission) bool {
+	resource, _, _ := strings.Cut(string(perm), ":")
+	for _, granted := range p.roles[role] {
+		if granted == perm || granted == Permission(resource+":*") ||
+			(granted == PermAll && !perm.explicit()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Permissions returns the permissions role grants
+func (p *Policy) Permissions(role string) []Permission {
+	return slices.Clone(p.roles[role])
+}
+
+// Roles returns the names of the roles in the policy, sorted
+func (p *Policy) Roles() []string {
+	names := make([]string, 0, len(p.roles))
+	for name := range p.roles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// HasRole reports whether the policy defines role
+func (p *Policy) HasRole(role string) bool {
+	_, ok := p.roles[role]
+	return ok
+}
+
+// Covers reports whether role grants every permission other grants
+func (p *Policy) Covers(role, other string) bool {
+	for _, perm := range p.roles[other] {
+		if !p.Allows(role, perm) {
+			return false
+		}
+	}
+	return true
+}
+
+// Source loads role permissions, such as from config or the database
+type Source interface {
+	LoadRoles(ctx context.Context) (map[string][]Permission, error)
+}
+
+// StaticSource is a fixed set of roles
+type StaticSource map[string][]Permission
+
+// LoadRoles implements Source
+func (s StaticSource) LoadRoles(context.Context) (map[string][]Permission, error) {
+	return s, nil
+}
+
+// Authorizer checks the current user's permissions against the active
+// policy. The policy can be replaced at runtime, such as when it is reloaded
+// from the database.
+type Authorizer struct {
+	policy atomic.Pointer[Policy]
+	logger *slog.Logger
+}
+
+// NewAuthorizer creates an authorizer enforcing policy; a nil policy
+// grants nothing until Reload
+func NewAuthorizer(policy *Policy, logger *slog.Logger) *Authorizer {
+	if policy == nil {
+		policy = &Policy{}
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	a := &Authorizer{logger: logger}
+	a.policy.Store(policy)
+	return a
+}
+
+// Policy returns the active policy
+func (a *Authorizer) Policy() *Policy {
+	return a.policy.Load()
+}
+
+// Reload replaces the policy with the roles loaded from source. The active
+// policy is kept if loading fails.
+func (a *Authorizer) Reload(ctx context.Context, source Source) error {
+	roles, err := source.LoadRoles(ctx)
+	if err != nil {
+		return fmt.Errorf("load roles: %w", err)
+	}
+	policy, err := NewPolicy(roles)
+	if err != nil {
+		return err
+	}
+	a.policy.Store(policy)
+	return nil
+}
+
+// Watch reloads the policy from source every interval until ctx is done
+func (a *Authorizer) Watch(ctx context.Context, source Source, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := a.Reload(ctx, source); err != nil {
+				a.logger.Error("failed to reload role permissions", slog.String("error", err.Error()))
+			}
+		}
+	}
+}
+
+// Can reports whether role grants perm
+func (a *Authorizer) Can(role string, perm Permission) bool {
+	return a.Policy().Allows(role, perm)
+}
+
+// HasRole reports whether the active policy defines role
+func (a *Authorizer) HasRole(role string) bool {
+	return a.Policy().HasRole(role)
+}
+
+// Covers reports whether role grants every permission other grants
+func (a *Authorizer) Covers(role, other string) bool {
+	return a.Policy().Covers(role, other)
+}
+
+// Authorize checks that the user authenticated in ctx holds every one of
+// perms. Services call it to guard operations regardless of the route.
+func (a *Authorizer) Authorize(ctx context.Context, perms ...Permission) error {
+	user, ok := auth.UserFromContext(ctx)
+	if !ok {
+		return ErrUnauthenticated
+	}
+	for _, perm := range perms {
+		if !a.Can(user.Role, perm) {
+			return fmt.Errorf("%w: %s", ErrForbidden, perm)
+		}
+	}
+	return nil
+}
+
+// CrossTenant returns middleware that lets users whose role holds perm read
+// across tenants: their GET requests span every tenant, or the one named
+// by the tenant_id query parameter. Other requests, and other users'
+// requests, stay within the request's tenant. It must run after the auth
+// middleware.
+func (a *Authorizer) CrossTenant(perm Permission) echo.MiddlewareFunc {
+	if err := perm.Validate(); err != nil {
+		panic(err)
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			user := auth.GetCurrentUser(c)
+			if user == nil || !a.Can(user.Role, perm) {
+				return next(c)
+			}
+			if method := c.Request().Method; method != http.MethodGet && method != http.MethodHead {
+				return next(c)
+			}
+
+			var only *uuid.UUID
+			if raw := c.QueryParam("tenant_id"); raw != "" {
+				id, err := uuid.Parse(raw)
+				if err != nil {
+					return response.BadRequest(c, "Invalid tenant_id")
+				}
+				only = &id
+			}
+			req := c.Request()
+			c.SetRequest(req.WithContext(tenant.WithCrossTenant(req.Context(), only)))
+			return next(c)
+		}
+	}
+}
+
+// RequirePermission returns middleware that only admits users whose role
+// holds every one of perms. It must run after the auth middleware.
+func (a *Authorizer) RequirePermission(perms ...Permission) echo.MiddlewareFunc {
+	for _, perm := range perms {
+		if err := perm.Validate(); err != nil {
+			panic(err)
+		}
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			user := auth.GetCurrentUser(c)
+			if user == nil {
+				return response.Unauthorized(c, "User not authenticated")
+			}
+
+			for _, perm := range perms {
+				if !a.Can(user.Role, perm) {
+					return response.ErrorWithDetails(c, http.StatusForbidden, "FORBIDDEN", "Insufficient permissions",
+						map[string]string{"permission": string(perm)})
+				}
+			}
+			return next(c)
+		}
+	}
+}
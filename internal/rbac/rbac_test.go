@@ -0,0 +1,208 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/internal/auth"
+	"github.com/pixperk/goiler/internal/config"
+	"github.com/pixperk/goiler/internal/tenant"
+)
+
+func TestPolicy_Allows(t *testing.T) {
+	policy, err := NewPolicy(map[string][]Permission{
+		"platform": {PermAll, "platform:*"},
+		"auditor":  {PermPlatformRead},
+		"pusher":   {PermWebSocketPush},
+		"admin":    {PermAll},
+		"support":  {PermAdminAccess, PermUsersRead, "tasks:*"},
+		"user":     {},
+	})
+	if err != nil {
+		t.Fatalf("NewPolicy failed: %v", err)
+	}
+
+	tests := []struct {
+		role string
+		perm Permission
+		want bool
+	}{
+		{"admin", PermSystemWrite, true},
+		{"admin", PermPlatformTenants, false},
+		{"admin", PermPlatformRead, false},
+		{"platform", PermPlatformTenants, true},
+		{"platform", PermSystemWrite, true},
+		{"auditor", PermPlatformRead, true},
+		{"auditor", PermPlatformTenants, false},
+		{"admin", PermWebSocketPush, false},
+		{"pusher", PermWebSocketPush, true},
+		{"support", PermUsersRead, true},
+		{"support", PermUsersWrite, false},
+		{"support", PermTasksWrite, true},
+		{"user", PermAdminAccess, false},
+		{"unknown", PermUsersRead, false},
+	}
+	for _, tt := range tests {
+		if got := policy.Allows(tt.role, tt.perm); got != tt.want {
+			t.Errorf("Allows(%s, %s) = %v, want %v", tt.role, tt.perm, got, tt.want)
+		}
+	}
+}
+
+func TestPolicy_Covers(t *testing.T) {
+	policy, err := NewPolicy(map[string][]Permission{
+		"platform": {PermAll, "platform:*"},
+		"admin":    {PermAll},
+		"support":  {PermAdminAccess, PermUsersRead, "tasks:*"},
+		"tasks":    {PermTasksWrite},
+		"user":     {},
+	})
+	if err != nil {
+		t.Fatalf("NewPolicy failed: %v", err)
+	}
+
+	tests := []struct {
+		role, other string
+		want        bool
+	}{
+		{"admin", "support", true},
+		{"admin", "platform", false},
+		{"platform", "admin", true},
+		{"support", "tasks", true},
+		{"support", "admin", false},
+		{"tasks", "support", false},
+		{"user", "user", true},
+		{"user", "unknown", true},
+	}
+	for _, tt := range tests {
+		if got := policy.Covers(tt.role, tt.other); got != tt.want {
+			t.Errorf("Covers(%s, %s) = %v, want %v", tt.role, tt.other, got, tt.want)
+		}
+	}
+}
+
+func TestNewPolicy_RejectsInvalidPermissions(t *testing.T) {
+	for _, perm := range []Permission{"users", "Users:read", "users:read:all", ":read", "*:read"} {
+		if _, err := NewPolicy(map[string][]Permission{"role": {perm}}); err == nil {
+			t.Errorf("Expected %q to be rejected", perm)
+		}
+	}
+}
+
+func TestConfigSource(t *testing.T) {
+	roles, _ := ConfigSource(config.RBACConfig{Roles: map[string][]string{
+		"support": {"admin:access", "users:read"},
+		"user":    {"reports:read"},
+	}}).LoadRoles(context.Background())
+
+	if len(roles["admin"]) != 1 || roles["admin"][0] != PermAll {
+		t.Errorf("Expected the built-in admin role to be kept, got %v", roles["admin"])
+	}
+	if len(roles["support"]) != 2 {
+		t.Errorf("Expected the configured support role, got %v", roles["support"])
+	}
+	if len(roles["user"]) != 1 || roles["user"][0] != "reports:read" {
+		t.Errorf("Expected the configured user role to replace the built-in one, got %v", roles["user"])
+	}
+	if len(DefaultRoles["user"]) != 0 {
+		t.Error("Expected DefaultRoles to be left unchanged")
+	}
+}
+
+func TestAuthorizer_Authorize(t *testing.T) {
+	authz := NewAuthorizer(nil, nil)
+	if err := authz.Reload(context.Background(), StaticSource{"support": {PermUsersRead}}); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+
+	if err := authz.Authorize(context.Background(), PermUsersRead); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("Expected ErrUnauthenticated without a user, got: %v", err)
+	}
+
+	user := &auth.TokenPayload{UserID: uuid.New(), Role: "support"}
+	ctx := auth.ContextWithUser(context.Background(), user)
+	if err := authz.Authorize(ctx, PermUsersRead); err != nil {
+		t.Errorf("Expected users:read to be allowed, got: %v", err)
+	}
+	if err := authz.Authorize(ctx, PermUsersRead, PermUsersWrite); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Expected ErrForbidden for users:write, got: %v", err)
+	}
+}
+
+func TestAuthorizer_RequirePermission(t *testing.T) {
+	authz := NewAuthorizer(nil, nil)
+	_ = authz.Reload(context.Background(), ConfigSource(config.RBACConfig{}))
+
+	e := echo.New()
+	handler := authz.RequirePermission(PermUsersWrite)(func(c echo.Context) error {
+		return c.NoContent(http.StatusNoContent)
+	})
+	call := func(role string) int {
+		rec := httptest.NewRecorder()
+		c := e.NewContext(httptest.NewRequest(http.MethodPatch, "/", nil), rec)
+		if role != "" {
+			auth.SetCurrentUser(c, &auth.TokenPayload{UserID: uuid.New(), Role: role})
+		}
+		_ = handler(c)
+		return rec.Code
+	}
+
+	if code := call("admin"); code != http.StatusNoContent {
+		t.Errorf("Expected admins to pass, got %d", code)
+	}
+	if code := call("user"); code != http.StatusForbidden {
+		t.Errorf("Expected users to be forbidden, got %d", code)
+	}
+	if code := call(""); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a user, got %d", code)
+	}
+}
+
+func TestAuthorizer_CrossTenant(t *testing.T) {
+	authz := NewAuthorizer(nil, nil)
+	_ = authz.Reload(context.Background(), ConfigSource(config.RBACConfig{}))
+
+	acme := &tenant.Tenant{ID: uuid.New(), Slug: "acme"}
+	e := echo.New()
+	var scope *uuid.UUID
+	handler := authz.CrossTenant(PermPlatformRead)(func(c echo.Context) error {
+		scope = tenant.Scope(c.Request().Context())
+		return c.NoContent(http.StatusNoContent)
+	})
+	call := func(method, target, role string) int {
+		req := httptest.NewRequest(method, target, nil)
+		req = req.WithContext(tenant.WithTenant(req.Context(), acme))
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		auth.SetCurrentUser(c, &auth.TokenPayload{UserID: uuid.New(), Role: role})
+		scope = nil
+		_ = handler(c)
+		return rec.Code
+	}
+
+	call(http.MethodGet, "/", "admin")
+	if scope == nil || *scope != acme.ID {
+		t.Errorf("Expected tenant admins to stay within their tenant, got %v", scope)
+	}
+	call(http.MethodGet, "/", "platform_admin")
+	if scope != nil {
+		t.Errorf("Expected platform admins to read across tenants, got %v", scope)
+	}
+	other := uuid.New()
+	call(http.MethodGet, "/?tenant_id="+other.String(), "platform_admin")
+	if scope == nil || *scope != other {
+		t.Errorf("Expected the named tenant %s, got %v", other, scope)
+	}
+	call(http.MethodPost, "/", "platform_admin")
+	if scope == nil || *scope != acme.ID {
+		t.Errorf("Expected writes to stay within the tenant, got %v", scope)
+	}
+	if code := call(http.MethodGet, "/?tenant_id=acme", "platform_admin"); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid tenant_id, got %d", code)
+	}
+}
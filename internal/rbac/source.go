@@ -0,0 +1,61 @@
+package rbac
+
+import (
+	"context"
+	"maps"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pixperk/goiler/db/sqlc"
+	"github.com/pixperk/goiler/internal/config"
+	"github.com/pixperk/goiler/pkg/budget"
+)
+
+// Role sources
+const (
+	SourceConfig   = "config"
+	SourcePostgres = "postgres"
+)
+
+// ConfigSource returns the built-in roles with the roles from cfg added;
+// configured roles replace built-in roles of the same name
+func ConfigSource(cfg config.RBACConfig) StaticSource {
+	roles := make(StaticSource, len(DefaultRoles)+len(cfg.Roles))
+	maps.Copy(roles, DefaultRoles)
+	for role, perms := range cfg.Roles {
+		roles[role] = make([]Permission, len(perms))
+		for i, perm := range perms {
+			roles[role][i] = Permission(perm)
+		}
+	}
+	return roles
+}
+
+// PostgresSource loads role permissions from the role_permissions table
+type PostgresSource struct {
+	queries *sqlc.Queries
+}
+
+// NewPostgresSource creates a source reading the role_permissions table
+func NewPostgresSource(db *pgxpool.Pool) *PostgresSource {
+	return &PostgresSource{queries: sqlc.New(db)}
+}
+
+// LoadRoles implements Source
+func (s *PostgresSource) LoadRoles(ctx context.Context) (map[string][]Permission, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	rows, err := s.queries.ListRolePermissions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	roles := make(map[string][]Permission)
+	for _, row := range rows {
+		roles[row.Role] = append(roles[row.Role], Permission(row.Permission))
+	}
+	return roles, nil
+}
@@ -0,0 +1,381 @@
+// Package scaffold generates a CRUD module laid out like internal/ticket: a
+// migration, sqlc queries and their generated code, and a package with the
+// model, a Repository interface and its Postgres implementation, a Service
+// configured with options, a Handler with its routes, and tests against an
+// in-memory repository. Records belong to the user who created them.
+package scaffold
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"go/format"
+	"go/token"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+//go:embed templates/*.tmpl
+var templateFS embed.FS
+
+var templates = template.Must(template.New("").ParseFS(templateFS, "templates/*.tmpl"))
+
+var (
+	ErrInvalidName  = errors.New("names must be lowercase snake_case, starting with a letter")
+	ErrNoFields     = errors.New("at least one field is required")
+	ErrFileExists   = errors.New("file already exists")
+	ErrReservedName = errors.New("field name is reserved")
+)
+
+var namePattern = regexp.MustCompile(`^[a-z][a-z0-9]*(_[a-z0-9]+)*$`)
+
+// reserved are the columns every generated table has
+var reserved = map[string]bool{"id": true, "user_id": true, "created_at": true, "updated_at": true}
+
+// imported are the packages generated files import, which the module's
+// package can't be named after
+var imported = map[string]bool{
+	"auth": true, "budget": true, "clock": true, "context": true, "echo": true, "errors": true,
+	"idgen": true, "pagination": true, "pgx": true, "response": true, "slog": true, "sql": true,
+	"sqlc": true, "testing": true, "time": true, "uuid": true, "validator": true,
+}
+
+// kind describes how a field type maps to SQL, the sqlc model and the
+// domain model
+type kind struct {
+	sql string
+	// goType is the domain type and dbType the type sqlc generates for it
+	goType, dbType string
+	validate       string
+	// samples are Go expressions the generated tests create and update
+	// records with
+	sample, update string
+}
+
+var kinds = map[string]kind{
+	"string": {sql: "VARCHAR(255)", goType: "string", dbType: "string", validate: "required,max=255", sample: `"example"`, update: `"updated"`},
+	"text":   {sql: "TEXT", goType: "string", dbType: "string", validate: "required", sample: `"Some text"`, update: `"Some other text"`},
+	"int":    {sql: "INTEGER", goType: "int", dbType: "int32", sample: "42", update: "7"},
+	"bigint": {sql: "BIGINT", goType: "int64", dbType: "int64", sample: "42", update: "7"},
+	"float":  {sql: "DOUBLE PRECISION", goType: "float64", dbType: "float64", sample: "9.5", update: "2.25"},
+	"bool":   {sql: "BOOLEAN", goType: "bool", dbType: "bool", sample: "true", update: "false"},
+	"time":   {sql: "TIMESTAMPTZ", goType: "time.Time", dbType: "sql.NullTime", sample: "time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)", update: "time.Date(2025, 6, 7, 8, 9, 10, 0, time.UTC)"},
+	"uuid":   {sql: "UUID", goType: "uuid.UUID", dbType: "uuid.UUID", sample: `uuid.MustParse("11111111-1111-1111-1111-111111111111")`, update: `uuid.MustParse("22222222-2222-2222-2222-222222222222")`},
+}
+
+// Kinds returns the supported field types
+func Kinds() []string {
+	names := make([]string, 0, len(kinds))
+	for name := range kinds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Field is a column of the generated table. Every field is NOT NULL.
+type Field struct {
+	// Name is the snake_case column and JSON name
+	Name string
+	Kind string
+}
+
+// ParseField parses a name:type argument, such as price:float
+func ParseField(arg string) (Field, error) {
+	name, typ, ok := strings.Cut(arg, ":")
+	if !ok {
+		return Field{}, fmt.Errorf("field %q: expected name:type", arg)
+	}
+	if !namePattern.MatchString(name) {
+		return Field{}, fmt.Errorf("field %q: %w", name, ErrInvalidName)
+	}
+	if reserved[name] {
+		return Field{}, fmt.Errorf("field %q: %w", name, ErrReservedName)
+	}
+	if _, ok := kinds[typ]; !ok {
+		return Field{}, fmt.Errorf("field %q: unknown type %q (want one of %s)", name, typ, strings.Join(Kinds(), ", "))
+	}
+	return Field{Name: name, Kind: typ}, nil
+}
+
+// GoName is the exported Go name of the field, as sqlc names it
+func (f Field) GoName() string { return camel(f.Name) }
+
+// SQLType is the column type
+func (f Field) SQLType() string { return kinds[f.Kind].sql }
+
+// GoType is the field's type in the domain model
+func (f Field) GoType() string { return kinds[f.Kind].goType }
+
+// DBType is the field's type in the sqlc model
+func (f Field) DBType() string { return kinds[f.Kind].dbType }
+
+// Validate is the field's validate tag, if any
+func (f Field) Validate() string { return kinds[f.Kind].validate }
+
+// Sample and Update are Go expressions of values for tests
+func (f Field) Sample() string { return kinds[f.Kind].sample }
+func (f Field) Update() string { return kinds[f.Kind].update }
+
+// ToDB converts expr from the domain type to the sqlc type
+func (f Field) ToDB(expr string) string {
+	switch f.Kind {
+	case "int":
+		return "int32(" + expr + ")"
+	case "time":
+		return "sql.NullTime{Time: " + expr + ", Valid: true}"
+	}
+	return expr
+}
+
+// FromDB converts expr from the sqlc type to the domain type
+func (f Field) FromDB(expr string) string {
+	switch f.Kind {
+	case "int":
+		return "int(" + expr + ")"
+	case "time":
+		return expr + ".Time"
+	}
+	return expr
+}
+
+// Module is the resource to generate
+type Module struct {
+	// Name is the singular snake_case name, such as blog_post
+	Name string
+	// Plural is the table name; Name with an s appended by default
+	Plural string
+	Fields []Field
+	// ModulePath is the Go module path generated imports start with
+	ModulePath string
+	// Migration is the migration's version number
+	Migration int
+}
+
+// Package is the Go package name: Name without underscores
+func (m *Module) Package() string { return strings.ReplaceAll(m.Name, "_", "") }
+
+// Type is the model's Go name, such as BlogPost
+func (m *Module) Type() string { return camel(m.Name) }
+
+// PluralType is the plural Go name, such as BlogPosts
+func (m *Module) PluralType() string { return camel(m.Plural) }
+
+// Var is the unexported Go name of one record, such as blogPost
+func (m *Module) Var() string { return lowerFirst(m.Type()) }
+
+// PluralVar is the unexported Go name of many records
+func (m *Module) PluralVar() string { return lowerFirst(m.PluralType()) }
+
+// Human and PluralHuman name records in messages and comments
+func (m *Module) Human() string       { return strings.ReplaceAll(m.Name, "_", " ") }
+func (m *Module) PluralHuman() string { return strings.ReplaceAll(m.Plural, "_", " ") }
+
+// Title names records in API docs, such as "Blog post"
+func (m *Module) Title() string { return upperFirst(m.Human()) }
+
+// Tag is the Swagger tag, such as "Blog posts"
+func (m *Module) Tag() string { return upperFirst(m.PluralHuman()) }
+
+// Route is the URL path segment, such as blog-posts
+func (m *Module) Route() string { return strings.ReplaceAll(m.Plural, "_", "-") }
+
+// Columns lists every column in table order
+func (m *Module) Columns() []string {
+	cols := []string{"id", "user_id"}
+	for _, f := range m.Fields {
+		cols = append(cols, f.Name)
+	}
+	return append(cols, "created_at", "updated_at")
+}
+
+// ColumnList is the comma-separated column list
+func (m *Module) ColumnList() string { return strings.Join(m.Columns(), ", ") }
+
+// Placeholders is $1 to $n for n columns
+func (m *Module) Placeholders() string {
+	ps := make([]string, len(m.Columns()))
+	for i := range ps {
+		ps[i] = "$" + strconv.Itoa(i+1)
+	}
+	return strings.Join(ps, ", ")
+}
+
+// Assignments is the SET list of the update query, whose first two
+// parameters are the id and user_id
+func (m *Module) Assignments() string {
+	var set []string
+	for i, f := range m.Fields {
+		set = append(set, fmt.Sprintf("%s = $%d", f.Name, i+3))
+	}
+	set = append(set, fmt.Sprintf("updated_at = $%d", len(m.Fields)+3))
+	return strings.Join(set, ", ")
+}
+
+// Validate checks the module's names
+func (m *Module) Validate() error {
+	if !namePattern.MatchString(m.Name) {
+		return fmt.Errorf("module %q: %w", m.Name, ErrInvalidName)
+	}
+	if token.IsKeyword(m.Package()) || imported[m.Package()] {
+		return fmt.Errorf("module %q: the package name %q is taken", m.Name, m.Package())
+	}
+	if !namePattern.MatchString(m.Plural) {
+		return fmt.Errorf("plural %q: %w", m.Plural, ErrInvalidName)
+	}
+	if m.Plural == m.Name {
+		return fmt.Errorf("plural %q must differ from the name", m.Plural)
+	}
+	if len(m.Fields) == 0 {
+		return ErrNoFields
+	}
+	seen := map[string]bool{}
+	for _, f := range m.Fields {
+		if seen[f.Name] {
+			return fmt.Errorf("field %q is repeated", f.Name)
+		}
+		seen[f.Name] = true
+	}
+	return nil
+}
+
+// File is a generated file, with its path relative to the project root
+type File struct {
+	Path    string
+	Content []byte
+}
+
+// Generate renders every file of the module
+func Generate(m *Module) ([]File, error) {
+	if m.Plural == "" {
+		m.Plural = m.Name + "s"
+	}
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+
+	pkgDir := filepath.Join("internal", m.Package())
+	migration := filepath.Join("db", "migrations", fmt.Sprintf("%06d_%s", m.Migration, m.Plural))
+	outputs := []struct{ template, path string }{
+		{"migration_up.sql.tmpl", migration + ".up.sql"},
+		{"migration_down.sql.tmpl", migration + ".down.sql"},
+		{"queries.sql.tmpl", filepath.Join("db", "queries", m.Name+".sql")},
+		{"sqlc.go.tmpl", filepath.Join("db", "sqlc", m.Name+".sql.go")},
+		{"model.go.tmpl", filepath.Join(pkgDir, "model.go")},
+		{"service.go.tmpl", filepath.Join(pkgDir, "service.go")},
+		{"repository.go.tmpl", filepath.Join(pkgDir, "repository.go")},
+		{"handler.go.tmpl", filepath.Join(pkgDir, "handler.go")},
+		{"routes.go.tmpl", filepath.Join(pkgDir, "routes.go")},
+		{"test.go.tmpl", filepath.Join(pkgDir, m.Package()+"_test.go")},
+	}
+
+	files := make([]File, 0, len(outputs))
+	for _, out := range outputs {
+		var buf bytes.Buffer
+		if err := templates.ExecuteTemplate(&buf, out.template, m); err != nil {
+			return nil, fmt.Errorf("render %s: %w", out.path, err)
+		}
+		content := buf.Bytes()
+		if strings.HasSuffix(out.path, ".go") {
+			src, err := format.Source(content)
+			if err != nil {
+				return nil, fmt.Errorf("format %s: %w", out.path, err)
+			}
+			content = src
+		}
+		files = append(files, File{Path: out.path, Content: content})
+	}
+	return files, nil
+}
+
+// Write writes files under root. It writes nothing if any file already
+// exists, so an existing module is never partly overwritten.
+func Write(root string, files []File) error {
+	for _, f := range files {
+		if _, err := os.Stat(filepath.Join(root, f.Path)); err == nil {
+			return fmt.Errorf("%s: %w", f.Path, ErrFileExists)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	for _, f := range files {
+		path := filepath.Join(root, f.Path)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, f.Content, 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var migrationPattern = regexp.MustCompile(`^(\d+)_.*\.up\.sql$`)
+
+// NextMigration returns the version after the highest in dir
+func NextMigration(dir string) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	latest := 0
+	for _, e := range entries {
+		if match := migrationPattern.FindStringSubmatch(e.Name()); match != nil {
+			if n, err := strconv.Atoi(match[1]); err == nil && n > latest {
+				latest = n
+			}
+		}
+	}
+	return latest + 1, nil
+}
+
+var modulePattern = regexp.MustCompile(`(?m)^module\s+(\S+)`)
+
+// ModulePath reads the module path from the go.mod in root
+func ModulePath(root string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(root, "go.mod"))
+	if err != nil {
+		return "", err
+	}
+	match := modulePattern.FindSubmatch(data)
+	if match == nil {
+		return "", errors.New("go.mod has no module directive")
+	}
+	return string(match[1]), nil
+}
+
+// camel converts snake_case to CamelCase the way sqlc does, with id as ID
+func camel(s string) string {
+	parts := strings.Split(s, "_")
+	for i, p := range parts {
+		if p == "id" {
+			parts[i] = "ID"
+			continue
+		}
+		parts[i] = upperFirst(p)
+	}
+	return strings.Join(parts, "")
+}
+
+func upperFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+func lowerFirst(s string) string {
+	if strings.HasPrefix(s, "ID") {
+		return "id" + s[2:]
+	}
+	if s == "" {
+		return s
+	}
+	return strings.ToLower(s[:1]) + s[1:]
+}
@@ -0,0 +1,155 @@
+package scaffold
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testModule(t *testing.T) *Module {
+	t.Helper()
+	var fields []Field
+	for _, arg := range []string{"title:string", "views:int", "published_at:time", "author_id:uuid"} {
+		f, err := ParseField(arg)
+		if err != nil {
+			t.Fatalf("ParseField(%q) failed: %v", arg, err)
+		}
+		fields = append(fields, f)
+	}
+	return &Module{Name: "blog_post", Fields: fields, ModulePath: "example.com/app", Migration: 7}
+}
+
+func TestParseField(t *testing.T) {
+	if f, err := ParseField("price:float"); err != nil || f.Name != "price" || f.SQLType() != "DOUBLE PRECISION" {
+		t.Errorf("ParseField(price:float) = %+v, %v", f, err)
+	}
+
+	for _, arg := range []string{"price", "price:money", "Price:float", "user_id:uuid"} {
+		if _, err := ParseField(arg); err == nil {
+			t.Errorf("ParseField(%q): expected an error", arg)
+		}
+	}
+	if _, err := ParseField("user_id:uuid"); !errors.Is(err, ErrReservedName) {
+		t.Errorf("Expected ErrReservedName, got %v", err)
+	}
+}
+
+func TestNames(t *testing.T) {
+	m := testModule(t)
+	m.Plural = "blog_posts"
+	if m.Package() != "blogpost" || m.Type() != "BlogPost" || m.PluralType() != "BlogPosts" ||
+		m.Var() != "blogPost" || m.Route() != "blog-posts" || m.Tag() != "Blog posts" {
+		t.Errorf("Unexpected names: %s %s %s %s %s %s", m.Package(), m.Type(), m.PluralType(), m.Var(), m.Route(), m.Tag())
+	}
+	if got := m.Fields[3].GoName(); got != "AuthorID" {
+		t.Errorf("Expected AuthorID, got %s", got)
+	}
+	if got := m.Assignments(); got != "title = $3, views = $4, published_at = $5, author_id = $6, updated_at = $7" {
+		t.Errorf("Unexpected assignments: %s", got)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	files, err := Generate(testModule(t))
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	byPath := map[string]string{}
+	for _, f := range files {
+		byPath[filepath.ToSlash(f.Path)] = string(f.Content)
+	}
+	for _, path := range []string{
+		"db/migrations/000007_blog_posts.up.sql",
+		"db/migrations/000007_blog_posts.down.sql",
+		"db/queries/blog_post.sql",
+		"db/sqlc/blog_post.sql.go",
+		"internal/blogpost/model.go",
+		"internal/blogpost/service.go",
+		"internal/blogpost/repository.go",
+		"internal/blogpost/handler.go",
+		"internal/blogpost/routes.go",
+		"internal/blogpost/blogpost_test.go",
+	} {
+		if _, ok := byPath[path]; !ok {
+			t.Errorf("Expected %s to be generated", path)
+		}
+	}
+
+	checks := map[string][]string{
+		"db/migrations/000007_blog_posts.up.sql": {"CREATE TABLE IF NOT EXISTS blog_posts", "published_at TIMESTAMPTZ NOT NULL"},
+		"db/queries/blog_post.sql":               {"-- name: ListBlogPosts :many", "-- name: UpdateBlogPost :execrows"},
+		"internal/blogpost/repository.go":        {"Views:       int32(blogPost.Views)", "PublishedAt: row.PublishedAt.Time"},
+		"internal/blogpost/handler.go":           {`"example.com/app/pkg/response"`, "@Router /api/v1/blog-posts/{id} [put]"},
+		"internal/blogpost/routes.go":            {`g.DELETE("/blog-posts/:id", h.Delete)`},
+	}
+	for path, wants := range checks {
+		for _, want := range wants {
+			if !strings.Contains(byPath[path], want) {
+				t.Errorf("Expected %s to contain %q", path, want)
+			}
+		}
+	}
+}
+
+func TestGenerate_RejectsInvalidModules(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(*Module)
+	}{
+		{"bad name", func(m *Module) { m.Name = "BlogPost" }},
+		{"keyword package", func(m *Module) { m.Name = "func" }},
+		{"imported package", func(m *Module) { m.Name = "time" }},
+		{"no fields", func(m *Module) { m.Fields = nil }},
+		{"repeated field", func(m *Module) { m.Fields = append(m.Fields, m.Fields[0]) }},
+		{"plural equals name", func(m *Module) { m.Name, m.Plural = "sheep", "sheep" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := testModule(t)
+			tt.edit(m)
+			if _, err := Generate(m); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
+
+func TestWrite_RefusesToOverwrite(t *testing.T) {
+	root := t.TempDir()
+	files := []File{
+		{Path: filepath.Join("internal", "thing", "a.go"), Content: []byte("package thing\n")},
+		{Path: filepath.Join("internal", "thing", "b.go"), Content: []byte("package thing\n")},
+	}
+	if err := os.MkdirAll(filepath.Join(root, "internal", "thing"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, files[1].Path), []byte("keep"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Write(root, files); !errors.Is(err, ErrFileExists) {
+		t.Fatalf("Expected ErrFileExists, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, files[0].Path)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected nothing to be written, got %v", err)
+	}
+}
+
+func TestNextMigration(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"000001_init.up.sql", "000001_init.down.sql", "000012_tickets.up.sql", "README.md"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	next, err := NextMigration(dir)
+	if err != nil {
+		t.Fatalf("NextMigration failed: %v", err)
+	}
+	if next != 13 {
+		t.Errorf("Expected 13, got %d", next)
+	}
+}
@@ -0,0 +1,356 @@
+package sdkgen
+
+import (
+	"fmt"
+	"go/format"
+	"strings"
+)
+
+// Go generates a Go client package using net/http. Each operation becomes a
+// method returning the envelope's data, or a Page for paginated routes;
+// error envelopes are returned as *APIError.
+func Go(spec *Spec, pkg string) ([]byte, error) {
+	var body strings.Builder
+	body.WriteString(goRuntime)
+	for _, name := range sortedSchemaNames(spec) {
+		writeGoType(&body, name, spec.Schemas[name])
+	}
+	for _, op := range spec.Operations {
+		writeGoMethod(&body, op)
+	}
+
+	imports := []string{"bytes", "context", "encoding/json", "fmt", "io", "net/http", "net/url", "strings"}
+	if strings.Contains(body.String(), "time.Time") {
+		imports = append(imports, "time")
+	}
+
+	var b strings.Builder
+	b.WriteString(generatedHeader("//"))
+	fmt.Fprintf(&b, "\n// Package %s is a client for %s.\npackage %s\n\nimport (\n", pkg, orDefault(spec.Title, "the API"), pkg)
+	for _, imp := range imports {
+		fmt.Fprintf(&b, "%q\n", imp)
+	}
+	b.WriteString(")\n")
+	b.WriteString(body.String())
+
+	src, err := format.Source([]byte(b.String()))
+	if err != nil {
+		return nil, fmt.Errorf("format generated Go client: %w", err)
+	}
+	return src, nil
+}
+
+const goRuntime = `
+// ErrorInfo describes a failed request
+type ErrorInfo struct {
+	Code    string            ` + "`json:\"code\"`" + `
+	Message string            ` + "`json:\"message\"`" + `
+	Details map[string]string ` + "`json:\"details,omitempty\"`" + `
+}
+
+// Meta holds pagination metadata
+type Meta struct {
+	Page       int    ` + "`json:\"page,omitempty\"`" + `
+	PerPage    int    ` + "`json:\"per_page,omitempty\"`" + `
+	Total      int64  ` + "`json:\"total,omitempty\"`" + `
+	TotalPages int    ` + "`json:\"total_pages,omitempty\"`" + `
+	NextCursor string ` + "`json:\"next_cursor,omitempty\"`" + `
+	PrevCursor string ` + "`json:\"prev_cursor,omitempty\"`" + `
+}
+
+// Envelope is the response envelope every route wraps its payload in
+type Envelope[T any] struct {
+	Success bool       ` + "`json:\"success\"`" + `
+	Message string     ` + "`json:\"message,omitempty\"`" + `
+	Data    T          ` + "`json:\"data,omitempty\"`" + `
+	Error   *ErrorInfo ` + "`json:\"error,omitempty\"`" + `
+	Meta    *Meta      ` + "`json:\"meta,omitempty\"`" + `
+}
+
+// Page is a page of a paginated listing
+type Page[T any] struct {
+	Items T
+	Meta  Meta
+}
+
+// APIError is returned for error envelopes and non-2xx responses
+type APIError struct {
+	Status  int
+	Code    string
+	Message string
+	Details map[string]string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.Status, e.Code, e.Message)
+}
+
+// Client calls the API
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	// Token returns the bearer token sent with each request, if any
+	Token func() string
+}
+
+// New creates a client for the API at baseURL
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimSuffix(baseURL, "/"), HTTPClient: http.DefaultClient}
+}
+
+func do[T any](ctx context.Context, c *Client, method, path string, query url.Values, body any) (*Envelope[T], error) {
+	target := c.BaseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != nil {
+		if token := c.Token(); token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	envelope := &Envelope[T]{Success: true}
+	if resp.StatusCode == http.StatusNoContent {
+		return envelope, nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(envelope); err != nil {
+		return nil, &APIError{Status: resp.StatusCode, Code: "INVALID_RESPONSE", Message: err.Error()}
+	}
+	if resp.StatusCode >= 300 || envelope.Error != nil {
+		apiErr := &APIError{Status: resp.StatusCode, Code: fmt.Sprintf("HTTP_%d", resp.StatusCode), Message: resp.Status}
+		if envelope.Error != nil {
+			apiErr.Code, apiErr.Message, apiErr.Details = envelope.Error.Code, envelope.Error.Message, envelope.Error.Details
+		}
+		return nil, apiErr
+	}
+	return envelope, nil
+}
+`
+
+func writeGoType(b *strings.Builder, name string, s *Schema) {
+	b.WriteString("\n")
+	if s.Description != "" {
+		fmt.Fprintf(b, "// %s %s\n", name, lowerFirst(s.Description))
+	}
+	if s.Type != "object" || len(s.Properties) == 0 {
+		fmt.Fprintf(b, "type %s %s\n", name, goType(s))
+		return
+	}
+	fmt.Fprintf(b, "type %s %s\n", name, goStruct(s))
+}
+
+func goStruct(s *Schema) string {
+	var b strings.Builder
+	b.WriteString("struct {\n")
+	for _, prop := range s.SortedProperties() {
+		ps := s.Properties[prop]
+		if ps.Description != "" {
+			fmt.Fprintf(&b, "// %s\n", ps.Description)
+		}
+		tag := prop
+		if !s.IsRequired(prop) {
+			tag += ",omitempty"
+		}
+		fmt.Fprintf(&b, "%s %s `json:%q`\n", exportedName(prop), goType(ps), tag)
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+func goType(s *Schema) string {
+	if s == nil {
+		return "json.RawMessage"
+	}
+	if s.Ref != "" {
+		return s.Ref
+	}
+
+	switch s.Type {
+	case "string":
+		if s.Format == "date-time" {
+			return "time.Time"
+		}
+		return "string"
+	case "integer":
+		if s.Format == "int32" {
+			return "int32"
+		}
+		return "int64"
+	case "number":
+		return "float64"
+	case "boolean":
+		return "bool"
+	case "array":
+		return "[]" + goType(s.Items)
+	case "object":
+		if len(s.Properties) > 0 {
+			return goStruct(s)
+		}
+		if s.AdditionalProperties != nil {
+			return "map[string]" + goType(s.AdditionalProperties)
+		}
+		return "map[string]any"
+	}
+	return "json.RawMessage"
+}
+
+func writeGoMethod(b *strings.Builder, op *Operation) {
+	method := exportedName(op.Name)
+	query := op.QueryParams()
+
+	// Query parameters go in a per-operation struct
+	queryType := method + "Query"
+	if len(query) > 0 {
+		fmt.Fprintf(b, "\n// %s holds the query parameters of %s\ntype %s struct {\n", queryType, method, queryType)
+		for _, p := range query {
+			fmt.Fprintf(b, "%s %s\n", exportedName(p.Name), goType(p.Schema))
+		}
+		b.WriteString("}\n")
+	}
+
+	args := []string{"ctx context.Context"}
+	for _, p := range op.PathParams() {
+		args = append(args, fmt.Sprintf("%s %s", goIdent(p.Name), goType(p.Schema)))
+	}
+	if op.Body != nil {
+		args = append(args, "body "+goType(op.Body))
+	}
+	if len(query) > 0 {
+		args = append(args, "query "+queryType)
+	}
+
+	data := "json.RawMessage"
+	if op.Response != nil {
+		data = goType(op.Response)
+	}
+	result := "error"
+	switch {
+	case op.Paginated:
+		result = fmt.Sprintf("(*Page[%s], error)", data)
+	case op.Response != nil:
+		result = fmt.Sprintf("(%s, error)", data)
+	case !op.NoContent:
+		result = "(json.RawMessage, error)"
+	}
+
+	b.WriteString("\n")
+	summary := orDefault(op.Summary, "calls "+op.Method+" "+op.Path)
+	fmt.Fprintf(b, "// %s: %s\n//\n// %s %s\n", method, summary, op.Method, op.Path)
+	if op.Deprecated {
+		b.WriteString("//\n// Deprecated: the route is deprecated.\n")
+	}
+	fmt.Fprintf(b, "func (c *Client) %s(%s) %s {\n", method, strings.Join(args, ", "), result)
+
+	path := fmt.Sprintf("%q", op.Path)
+	if params := op.PathParams(); len(params) > 0 {
+		format := op.Path
+		var values []string
+		for _, p := range params {
+			format = strings.ReplaceAll(format, "{"+p.Name+"}", "%s")
+			values = append(values, fmt.Sprintf("url.PathEscape(fmt.Sprint(%s))", goIdent(p.Name)))
+		}
+		path = fmt.Sprintf("fmt.Sprintf(%q, %s)", format, strings.Join(values, ", "))
+	}
+
+	queryArg := "nil"
+	if len(query) > 0 {
+		queryArg = "values"
+		b.WriteString("values := url.Values{}\n")
+		for _, p := range query {
+			field := "query." + exportedName(p.Name)
+			zero := goZero(p.Schema)
+			if zero == "" {
+				fmt.Fprintf(b, "values.Set(%q, fmt.Sprint(%s))\n", p.Name, field)
+				continue
+			}
+			fmt.Fprintf(b, "if %s != %s {\nvalues.Set(%q, fmt.Sprint(%s))\n}\n", field, zero, p.Name, field)
+		}
+	}
+	bodyArg := "nil"
+	if op.Body != nil {
+		bodyArg = "body"
+	}
+
+	call := fmt.Sprintf("do[%s](ctx, c, %q, %s, %s, %s)", data, op.Method, path, queryArg, bodyArg)
+	switch {
+	case op.Paginated:
+		fmt.Fprintf(b, "res, err := %s\nif err != nil {\nreturn nil, err\n}\n", call)
+		fmt.Fprintf(b, "page := &Page[%s]{Items: res.Data}\nif res.Meta != nil {\npage.Meta = *res.Meta\n}\nreturn page, nil\n", data)
+	case result == "error":
+		fmt.Fprintf(b, "_, err := %s\nreturn err\n", call)
+	default:
+		fmt.Fprintf(b, "res, err := %s\nif err != nil {\nvar zero %s\nreturn zero, err\n}\nreturn res.Data, nil\n", call, data)
+	}
+	b.WriteString("}\n")
+}
+
+// goZero returns the zero value literal for comparable parameter types
+func goZero(s *Schema) string {
+	switch goType(s) {
+	case "string":
+		return `""`
+	case "int32", "int64", "float64":
+		return "0"
+	case "bool":
+		return "false"
+	}
+	return ""
+}
+
+// goIdent converts a parameter name such as "user_id" to userID
+func goIdent(name string) string {
+	ident := lowerFirst(exportedName(name))
+	switch ident {
+	case "iD":
+		ident = "id"
+	case "uRL":
+		ident = "url"
+	}
+	if goReserved[ident] {
+		return ident + "Param"
+	}
+	return ident
+}
+
+// goReserved holds keywords and the names generated methods use
+var goReserved = map[string]bool{
+	"type": true, "func": true, "map": true, "range": true, "select": true, "default": true,
+	"package": true, "var": true, "go": true, "chan": true, "case": true, "interface": true,
+	"ctx": true, "c": true, "body": true, "query": true, "values": true, "res": true,
+	"err": true, "page": true, "zero": true, "url": true, "fmt": true,
+}
+
+func orDefault(s, fallback string) string {
+	if s == "" {
+		return fallback
+	}
+	return s
+}
@@ -0,0 +1,148 @@
+package sdkgen
+
+import (
+	"strings"
+	"testing"
+)
+
+// testSpec is a trimmed document in the shape swag generates
+const testSpec = `{
+  "swagger": "2.0",
+  "info": {"title": "Goiler API", "version": "1.0"},
+  "basePath": "/",
+  "paths": {
+    "/api/v1/users/me": {
+      "get": {
+        "summary": "Get user profile",
+        "tags": ["Users"],
+        "responses": {
+          "200": {"description": "OK", "schema": {"$ref": "#/definitions/user.UserResponse"}},
+          "401": {"description": "Unauthorized", "schema": {"$ref": "#/definitions/response.Response"}}
+        }
+      }
+    },
+    "/api/v1/users/me/identities/password": {
+      "post": {
+        "summary": "Link password login",
+        "parameters": [
+          {"in": "body", "name": "request", "required": true, "schema": {"$ref": "#/definitions/user.LinkPasswordRequest"}}
+        ],
+        "responses": {"200": {"description": "OK", "schema": {"$ref": "#/definitions/response.Response"}}}
+      }
+    },
+    "/api/v1/admin/policies/{id}/users": {
+      "get": {
+        "summary": "List policy acceptances",
+        "parameters": [
+          {"type": "string", "name": "id", "in": "path", "required": true},
+          {"type": "string", "name": "status", "in": "query"},
+          {"type": "integer", "name": "page", "in": "query"},
+          {"type": "integer", "name": "per_page", "in": "query"}
+        ],
+        "responses": {"200": {"description": "OK", "schema": {"type": "array", "items": {"$ref": "#/definitions/consent.PolicyUser"}}}}
+      }
+    }
+  },
+  "definitions": {
+    "response.Response": {
+      "type": "object",
+      "properties": {"success": {"type": "boolean"}, "data": {}, "message": {"type": "string"}}
+    },
+    "user.UserResponse": {
+      "type": "object",
+      "properties": {
+        "id": {"type": "string"},
+        "email": {"type": "string"},
+        "created_at": {"type": "string", "format": "date-time"},
+        "identities": {"type": "array", "items": {"$ref": "#/definitions/user.IdentityResponse"}}
+      }
+    },
+    "user.IdentityResponse": {"type": "object", "properties": {"provider": {"type": "string"}}},
+    "user.LinkPasswordRequest": {
+      "type": "object",
+      "required": ["password"],
+      "properties": {"password": {"type": "string", "minLength": 8}}
+    },
+    "consent.PolicyUser": {
+      "type": "object",
+      "properties": {"user_id": {"type": "string"}, "email": {"type": "string"}}
+    }
+  }
+}`
+
+func TestParse(t *testing.T) {
+	spec, err := Parse([]byte(testSpec))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	if _, ok := spec.Schemas["UserResponse"]; !ok {
+		t.Errorf("Expected package qualifiers to be dropped, got schemas %v", sortedSchemaNames(spec))
+	}
+
+	ops := make(map[string]*Operation)
+	for _, op := range spec.Operations {
+		ops[op.Name] = op
+	}
+
+	profile := ops["getUsersMe"]
+	if profile == nil || profile.Response == nil || profile.Response.Ref != "UserResponse" {
+		t.Fatalf("Expected getUsersMe returning UserResponse, got %+v", profile)
+	}
+
+	link := ops["postUsersMeIdentitiesPassword"]
+	if link == nil || link.Body == nil || link.Body.Ref != "LinkPasswordRequest" {
+		t.Fatalf("Expected postUsersMeIdentitiesPassword with a body, got %+v", link)
+	}
+	if link.Response != nil {
+		t.Error("Expected a route documented as the envelope to have untyped data")
+	}
+
+	list := ops["getAdminPoliciesByIDUsers"]
+	if list == nil || !list.Paginated || len(list.PathParams()) != 1 || len(list.QueryParams()) != 3 {
+		t.Fatalf("Expected paginated getAdminPoliciesByIDUsers, got %+v", list)
+	}
+}
+
+func TestParse_RejectsNonOpenAPI(t *testing.T) {
+	if _, err := Parse([]byte(`{"paths": {}}`)); err == nil {
+		t.Error("Expected an error for a document without a version")
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	spec, err := Parse([]byte(testSpec))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	goSrc, err := Go(spec, "client")
+	if err != nil {
+		t.Fatalf("Go failed: %v", err)
+	}
+	for _, want := range []string{
+		"func (c *Client) GetUsersMe(ctx context.Context) (UserResponse, error)",
+		"func (c *Client) PostUsersMeIdentitiesPassword(ctx context.Context, body LinkPasswordRequest) (json.RawMessage, error)",
+		"func (c *Client) GetAdminPoliciesByIDUsers(ctx context.Context, id string, query GetAdminPoliciesByIDUsersQuery) (*Page[[]PolicyUser], error)",
+		"CreatedAt  time.Time",
+	} {
+		if !strings.Contains(string(goSrc), want) {
+			t.Errorf("Expected Go client to contain %q", want)
+		}
+	}
+
+	tsSrc, err := TypeScript(spec)
+	if err != nil {
+		t.Fatalf("TypeScript failed: %v", err)
+	}
+	for _, want := range []string{
+		"async getUsersMe(): Promise<UserResponse>",
+		"async postUsersMeIdentitiesPassword(body: LinkPasswordRequest): Promise<unknown>",
+		"async getAdminPoliciesByIDUsers(id: string, query?: { status?: string; page?: number; per_page?: number }): Promise<Page<Array<PolicyUser>>>",
+		"password: string;",
+	} {
+		if !strings.Contains(string(tsSrc), want) {
+			t.Errorf("Expected TypeScript client to contain %q", want)
+		}
+	}
+}
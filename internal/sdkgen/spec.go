@@ -0,0 +1,468 @@
+// Package sdkgen generates typed API clients from the OpenAPI document the
+// server publishes at /swagger/doc.json. It reads Swagger 2.0, as written by
+// swag, and OpenAPI 3 documents.
+package sdkgen
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Spec is an API description reduced to what the generators need
+type Spec struct {
+	Title      string
+	Version    string
+	Operations []*Operation
+	Schemas    map[string]*Schema // by generated type name
+}
+
+// Operation is an API endpoint
+type Operation struct {
+	Name        string // lowerCamelCase method name, e.g. getUsersMe
+	Method      string
+	Path        string // full path with {param} placeholders
+	Summary     string
+	Tag         string
+	Params      []*Param // path and query parameters, path first
+	Body        *Schema
+	Response    *Schema // the data field of the envelope; nil when untyped
+	Paginated   bool    // takes page/per_page and returns meta
+	NoContent   bool    // responds 204 without a body
+	Deprecated  bool
+	Description string
+}
+
+// PathParams returns the operation's path parameters in path order
+func (o *Operation) PathParams() []*Param {
+	var params []*Param
+	for _, p := range o.Params {
+		if p.In == "path" {
+			params = append(params, p)
+		}
+	}
+	return params
+}
+
+// QueryParams returns the operation's query parameters
+func (o *Operation) QueryParams() []*Param {
+	var params []*Param
+	for _, p := range o.Params {
+		if p.In == "query" {
+			params = append(params, p)
+		}
+	}
+	return params
+}
+
+// Param is a path or query parameter
+type Param struct {
+	Name     string
+	In       string
+	Required bool
+	Schema   *Schema
+}
+
+// Schema is a JSON schema. Ref holds a generated type name.
+type Schema struct {
+	Ref                  string             `json:"-"`
+	RawRef               string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"-"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	AllOf                []*Schema          `json:"allOf,omitempty"`
+
+	RawAdditional json.RawMessage `json:"additionalProperties,omitempty"`
+}
+
+// IsRequired reports whether the object property is required
+func (s *Schema) IsRequired(name string) bool {
+	for _, r := range s.Required {
+		if r == name {
+			return true
+		}
+	}
+	return false
+}
+
+// SortedProperties returns the property names in order
+func (s *Schema) SortedProperties() []string {
+	names := make([]string, 0, len(s.Properties))
+	for name := range s.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// document covers the parts of Swagger 2.0 and OpenAPI 3 that are used
+type document struct {
+	Swagger  string `json:"swagger"`
+	OpenAPI  string `json:"openapi"`
+	BasePath string `json:"basePath"`
+	Info     struct {
+		Title   string `json:"title"`
+		Version string `json:"version"`
+	} `json:"info"`
+	Paths       map[string]map[string]json.RawMessage `json:"paths"`
+	Definitions map[string]*Schema                    `json:"definitions"`
+	Components  struct {
+		Schemas map[string]*Schema `json:"schemas"`
+	} `json:"components"`
+}
+
+type operationDoc struct {
+	OperationID string         `json:"operationId"`
+	Summary     string         `json:"summary"`
+	Description string         `json:"description"`
+	Tags        []string       `json:"tags"`
+	Deprecated  bool           `json:"deprecated"`
+	Parameters  []parameterDoc `json:"parameters"`
+	RequestBody *struct {
+		Content map[string]struct {
+			Schema *Schema `json:"schema"`
+		} `json:"content"`
+	} `json:"requestBody"`
+	Responses map[string]struct {
+		Schema  *Schema `json:"schema"`
+		Content map[string]struct {
+			Schema *Schema `json:"schema"`
+		} `json:"content"`
+	} `json:"responses"`
+}
+
+type parameterDoc struct {
+	Name     string        `json:"name"`
+	In       string        `json:"in"`
+	Required bool          `json:"required"`
+	Schema   *Schema       `json:"schema"` // Swagger 2.0 body and OpenAPI 3 parameters
+	Type     string        `json:"type"`   // Swagger 2.0 non-body parameters
+	Format   string        `json:"format"`
+	Items    *Schema       `json:"items"`
+	Enum     []interface{} `json:"enum"`
+}
+
+var httpMethods = []string{"get", "post", "put", "patch", "delete"}
+
+// Load reads an OpenAPI document from a file or an http(s) URL
+func Load(ctx context.Context, source string) ([]byte, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		return os.ReadFile(source)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch %s: %s", source, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// Parse parses a Swagger 2.0 or OpenAPI 3 JSON document
+func Parse(data []byte) (*Spec, error) {
+	var doc document
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parse OpenAPI document: %w", err)
+	}
+	if doc.Swagger == "" && doc.OpenAPI == "" {
+		return nil, fmt.Errorf("not an OpenAPI document: missing swagger or openapi version")
+	}
+
+	definitions := doc.Definitions
+	refPrefix := "#/definitions/"
+	if doc.OpenAPI != "" {
+		definitions = doc.Components.Schemas
+		refPrefix = "#/components/schemas/"
+	}
+
+	names := typeNames(definitions)
+	spec := &Spec{
+		Title:   doc.Info.Title,
+		Version: doc.Info.Version,
+		Schemas: make(map[string]*Schema, len(definitions)),
+	}
+	r := resolver{prefix: refPrefix, names: names, envelope: names["response.Response"]}
+	for raw, schema := range definitions {
+		if err := r.resolve(schema); err != nil {
+			return nil, fmt.Errorf("schema %s: %w", raw, err)
+		}
+		// The envelope is generated as Envelope; a type named Response would
+		// also shadow fetch's Response in TypeScript
+		if raw != "response.Response" {
+			spec.Schemas[names[raw]] = schema
+		}
+	}
+
+	basePath := strings.TrimSuffix(doc.BasePath, "/")
+	taken := make(map[string]bool)
+	for path, item := range doc.Paths {
+		for _, method := range httpMethods {
+			raw, ok := item[method]
+			if !ok {
+				continue
+			}
+			var opDoc operationDoc
+			if err := json.Unmarshal(raw, &opDoc); err != nil {
+				return nil, fmt.Errorf("%s %s: %w", strings.ToUpper(method), path, err)
+			}
+			op, err := r.operation(method, basePath+path, &opDoc)
+			if err != nil {
+				return nil, fmt.Errorf("%s %s: %w", strings.ToUpper(method), path, err)
+			}
+			spec.Operations = append(spec.Operations, op)
+		}
+	}
+
+	sort.Slice(spec.Operations, func(i, j int) bool {
+		if spec.Operations[i].Path != spec.Operations[j].Path {
+			return spec.Operations[i].Path < spec.Operations[j].Path
+		}
+		return spec.Operations[i].Method < spec.Operations[j].Method
+	})
+	for _, op := range spec.Operations {
+		name := op.Name
+		for n := 2; taken[op.Name]; n++ {
+			op.Name = fmt.Sprintf("%s%d", name, n)
+		}
+		taken[op.Name] = true
+	}
+
+	return spec, nil
+}
+
+type resolver struct {
+	prefix   string
+	names    map[string]string
+	envelope string // type name of response.Response
+}
+
+// resolve maps $refs to generated type names, recursively
+func (r resolver) resolve(s *Schema) error {
+	if s == nil {
+		return nil
+	}
+	if s.RawRef != "" {
+		raw, ok := strings.CutPrefix(s.RawRef, r.prefix)
+		if !ok {
+			return fmt.Errorf("unsupported $ref %q", s.RawRef)
+		}
+		name, ok := r.names[raw]
+		if !ok {
+			return fmt.Errorf("unknown $ref %q", s.RawRef)
+		}
+		s.Ref = name
+	}
+	// swag wraps refs with descriptions in allOf
+	if len(s.AllOf) == 1 && s.Ref == "" {
+		if err := r.resolve(s.AllOf[0]); err != nil {
+			return err
+		}
+		description := s.Description
+		*s = *s.AllOf[0]
+		if description != "" {
+			s.Description = description
+		}
+	}
+	if len(s.RawAdditional) > 0 && string(s.RawAdditional) != "false" && string(s.RawAdditional) != "true" {
+		s.AdditionalProperties = &Schema{}
+		if err := json.Unmarshal(s.RawAdditional, s.AdditionalProperties); err != nil {
+			return err
+		}
+	}
+	if string(s.RawAdditional) == "true" {
+		s.AdditionalProperties = &Schema{}
+	}
+
+	for _, child := range s.Properties {
+		if err := r.resolve(child); err != nil {
+			return err
+		}
+	}
+	if err := r.resolve(s.Items); err != nil {
+		return err
+	}
+	return r.resolve(s.AdditionalProperties)
+}
+
+func (r resolver) operation(method, path string, doc *operationDoc) (*Operation, error) {
+	op := &Operation{
+		Method:      strings.ToUpper(method),
+		Path:        path,
+		Summary:     doc.Summary,
+		Description: doc.Description,
+		Deprecated:  doc.Deprecated,
+		Name:        operationName(method, path, doc.OperationID),
+	}
+	if len(doc.Tags) > 0 {
+		op.Tag = doc.Tags[0]
+	}
+
+	var page, perPage bool
+	for _, p := range doc.Parameters {
+		schema := p.Schema
+		if schema == nil {
+			schema = &Schema{Type: p.Type, Format: p.Format, Items: p.Items, Enum: p.Enum}
+		}
+		if err := r.resolve(schema); err != nil {
+			return nil, err
+		}
+
+		switch p.In {
+		case "body":
+			op.Body = schema
+		case "path", "query":
+			op.Params = append(op.Params, &Param{Name: p.Name, In: p.In, Required: p.Required || p.In == "path", Schema: schema})
+			page = page || (p.In == "query" && p.Name == "page")
+			perPage = perPage || (p.In == "query" && p.Name == "per_page")
+		}
+	}
+	sort.SliceStable(op.Params, func(i, j int) bool {
+		return op.Params[i].In == "path" && op.Params[j].In != "path"
+	})
+	op.Paginated = page && perPage
+
+	if doc.RequestBody != nil {
+		if content, ok := doc.RequestBody.Content["application/json"]; ok {
+			if err := r.resolve(content.Schema); err != nil {
+				return nil, err
+			}
+			op.Body = content.Schema
+		}
+	}
+
+	for _, code := range []string{"200", "201", "202", "207", "204"} {
+		resp, ok := doc.Responses[code]
+		if !ok {
+			continue
+		}
+		if code == "204" {
+			op.NoContent = true
+			break
+		}
+		schema := resp.Schema
+		if content, ok := resp.Content["application/json"]; ok {
+			schema = content.Schema
+		}
+		if err := r.resolve(schema); err != nil {
+			return nil, err
+		}
+		// Handlers documented as returning the envelope itself have no typed data
+		if schema != nil && (r.envelope == "" || schema.Ref != r.envelope) {
+			op.Response = schema
+		}
+		break
+	}
+
+	return op, nil
+}
+
+// typeNames maps definition names such as "auth.LoginRequest" to type
+// names, dropping the package qualifier unless two packages share a name
+func typeNames(definitions map[string]*Schema) map[string]string {
+	bare := make(map[string]int, len(definitions))
+	for raw := range definitions {
+		bare[exportedName(lastSegment(raw))]++
+	}
+
+	names := make(map[string]string, len(definitions))
+	for raw := range definitions {
+		name := exportedName(lastSegment(raw))
+		if bare[name] > 1 {
+			name = exportedName(raw)
+		}
+		names[raw] = name
+	}
+	return names
+}
+
+func lastSegment(raw string) string {
+	if i := strings.LastIndex(raw, "."); i >= 0 {
+		return raw[i+1:]
+	}
+	return raw
+}
+
+// operationName derives a method name from the operation ID, or from the
+// method and the path below the API version prefix
+func operationName(method, path, operationID string) string {
+	if operationID != "" {
+		return lowerFirst(exportedName(operationID))
+	}
+
+	var parts []string
+	for _, segment := range strings.Split(path, "/") {
+		switch {
+		case segment == "" || segment == "api" || isVersionSegment(segment):
+			continue
+		case strings.HasPrefix(segment, "{"):
+			parts = append(parts, "By"+exportedName(strings.Trim(segment, "{}")))
+		default:
+			parts = append(parts, exportedName(segment))
+		}
+	}
+	return method + strings.Join(parts, "")
+}
+
+func isVersionSegment(s string) bool {
+	if len(s) < 2 || s[0] != 'v' {
+		return false
+	}
+	for _, c := range s[1:] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// exportedName converts "user_id", "auth.LoginRequest" or "dead-tasks" to
+// PascalCase, keeping ID and URL as initialisms
+func exportedName(s string) string {
+	var b strings.Builder
+	for _, word := range strings.FieldsFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+	}) {
+		switch strings.ToLower(word) {
+		case "id":
+			b.WriteString("ID")
+		case "url":
+			b.WriteString("URL")
+		default:
+			b.WriteString(strings.ToUpper(word[:1]) + word[1:])
+		}
+	}
+	name := b.String()
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		name = "X" + name
+	}
+	return name
+}
+
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToLower(s[:1]) + s[1:]
+}
@@ -0,0 +1,306 @@
+package sdkgen
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// TypeScript generates a dependency-free TypeScript client using fetch. Each
+// operation becomes a method returning the envelope's data, or a Page for
+// paginated routes; error envelopes are thrown as APIError.
+func TypeScript(spec *Spec) ([]byte, error) {
+	var b strings.Builder
+	b.WriteString(generatedHeader("//"))
+	b.WriteString(tsRuntime)
+
+	for _, name := range sortedSchemaNames(spec) {
+		writeTSType(&b, name, spec.Schemas[name])
+	}
+
+	b.WriteString("\nexport class Client extends BaseClient {\n")
+	for _, op := range spec.Operations {
+		writeTSMethod(&b, op)
+	}
+	b.WriteString("}\n")
+	return []byte(b.String()), nil
+}
+
+const tsRuntime = `
+export interface ErrorInfo {
+  code: string;
+  message: string;
+  details?: Record<string, string>;
+}
+
+export interface Meta {
+  page?: number;
+  per_page?: number;
+  total?: number;
+  total_pages?: number;
+  next_cursor?: string;
+  prev_cursor?: string;
+}
+
+/** The response envelope every route wraps its payload in */
+export interface Envelope<T> {
+  success: boolean;
+  message?: string;
+  data?: T;
+  error?: ErrorInfo;
+  meta?: Meta;
+}
+
+export interface Page<T> {
+  items: T;
+  meta: Meta;
+}
+
+/** Thrown for error envelopes and non-2xx responses */
+export class APIError extends Error {
+  constructor(
+    readonly status: number,
+    readonly code: string,
+    message: string,
+    readonly details?: Record<string, string>,
+  ) {
+    super(message);
+    this.name = "APIError";
+  }
+}
+
+export interface ClientOptions {
+  baseURL: string;
+  /** Bearer token, or a function returning the current one */
+  token?: string | (() => string | undefined);
+  fetch?: typeof fetch;
+  headers?: Record<string, string>;
+}
+
+type Query = Record<string, string | number | boolean | undefined | null>;
+
+export class BaseClient {
+  constructor(protected readonly options: ClientOptions) {}
+
+  protected async request<T>(method: string, path: string, query?: Query, body?: unknown): Promise<Envelope<T>> {
+    const url = new URL(path, this.options.baseURL);
+    for (const [key, value] of Object.entries(query ?? {})) {
+      if (value !== undefined && value !== null) url.searchParams.set(key, String(value));
+    }
+
+    const headers: Record<string, string> = { Accept: "application/json", ...this.options.headers };
+    const token = typeof this.options.token === "function" ? this.options.token() : this.options.token;
+    if (token) headers.Authorization = "Bearer " + token;
+    if (body !== undefined) headers["Content-Type"] = "application/json";
+
+    const res = await (this.options.fetch ?? fetch)(url, {
+      method,
+      headers,
+      body: body === undefined ? undefined : JSON.stringify(body),
+    });
+    if (res.status === 204) return { success: true };
+
+    let envelope: Envelope<T>;
+    try {
+      envelope = (await res.json()) as Envelope<T>;
+    } catch {
+      throw new APIError(res.status, "INVALID_RESPONSE", res.statusText);
+    }
+    if (!res.ok || envelope.error) {
+      const err = envelope.error ?? { code: "HTTP_" + res.status, message: res.statusText };
+      throw new APIError(res.status, err.code, err.message, err.details);
+    }
+    return envelope;
+  }
+}
+`
+
+func writeTSType(b *strings.Builder, name string, s *Schema) {
+	b.WriteString("\n")
+	if s.Description != "" {
+		fmt.Fprintf(b, "/** %s */\n", s.Description)
+	}
+	if s.Type != "object" || len(s.Properties) == 0 {
+		fmt.Fprintf(b, "export type %s = %s;\n", name, tsType(s))
+		return
+	}
+
+	fmt.Fprintf(b, "export interface %s {\n", name)
+	for _, prop := range s.SortedProperties() {
+		ps := s.Properties[prop]
+		if ps.Description != "" {
+			fmt.Fprintf(b, "  /** %s */\n", ps.Description)
+		}
+		optional := "?"
+		if s.IsRequired(prop) {
+			optional = ""
+		}
+		fmt.Fprintf(b, "  %s%s: %s;\n", tsPropertyName(prop), optional, tsType(ps))
+	}
+	b.WriteString("}\n")
+}
+
+func tsType(s *Schema) string {
+	if s == nil {
+		return "unknown"
+	}
+	if s.Ref != "" {
+		return s.Ref
+	}
+	if len(s.Enum) > 0 {
+		values := make([]string, len(s.Enum))
+		for i, v := range s.Enum {
+			values[i] = fmt.Sprintf("%q", fmt.Sprint(v))
+			if s.Type != "string" {
+				values[i] = fmt.Sprint(v)
+			}
+		}
+		return strings.Join(values, " | ")
+	}
+
+	switch s.Type {
+	case "string":
+		return "string"
+	case "integer", "number":
+		return "number"
+	case "boolean":
+		return "boolean"
+	case "array":
+		return "Array<" + tsType(s.Items) + ">"
+	case "object":
+		if len(s.Properties) > 0 {
+			var fields []string
+			for _, prop := range s.SortedProperties() {
+				optional := "?"
+				if s.IsRequired(prop) {
+					optional = ""
+				}
+				fields = append(fields, fmt.Sprintf("%s%s: %s", tsPropertyName(prop), optional, tsType(s.Properties[prop])))
+			}
+			return "{ " + strings.Join(fields, "; ") + " }"
+		}
+		if s.AdditionalProperties != nil {
+			return "Record<string, " + tsType(s.AdditionalProperties) + ">"
+		}
+		return "Record<string, unknown>"
+	}
+	return "unknown"
+}
+
+func tsPropertyName(name string) string {
+	for i, r := range name {
+		if !(r == '_' || r == '$' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || i > 0 && r >= '0' && r <= '9') {
+			return fmt.Sprintf("%q", name)
+		}
+	}
+	return name
+}
+
+func writeTSMethod(b *strings.Builder, op *Operation) {
+	var args []string
+	for _, p := range op.PathParams() {
+		args = append(args, fmt.Sprintf("%s: %s", tsIdent(p.Name), tsType(p.Schema)))
+	}
+	if op.Body != nil {
+		args = append(args, "body: "+tsType(op.Body))
+	}
+	query := op.QueryParams()
+	if len(query) > 0 {
+		var fields []string
+		optional := "?"
+		for _, p := range query {
+			mark := "?"
+			if p.Required {
+				mark, optional = "", ""
+			}
+			fields = append(fields, fmt.Sprintf("%s%s: %s", tsPropertyName(p.Name), mark, tsType(p.Schema)))
+		}
+		args = append(args, fmt.Sprintf("query%s: { %s }", optional, strings.Join(fields, "; ")))
+	}
+
+	data := "void"
+	if op.Response != nil {
+		data = tsType(op.Response)
+	} else if !op.NoContent {
+		data = "unknown"
+	}
+	result := data
+	if op.Paginated {
+		result = "Page<" + data + ">"
+	}
+
+	b.WriteString("\n")
+	writeTSDoc(b, op)
+	fmt.Fprintf(b, "  async %s(%s): Promise<%s> {\n", op.Name, strings.Join(args, ", "), result)
+
+	path := fmt.Sprintf("%q", op.Path)
+	if params := op.PathParams(); len(params) > 0 {
+		path = op.Path
+		for _, p := range params {
+			path = strings.ReplaceAll(path, "{"+p.Name+"}", "${encodeURIComponent(String("+tsIdent(p.Name)+"))}")
+		}
+		path = "`" + path + "`"
+	}
+	queryArg, bodyArg := "undefined", "undefined"
+	if len(query) > 0 {
+		queryArg = "query"
+	}
+	if op.Body != nil {
+		bodyArg = "body"
+	}
+	callArgs := fmt.Sprintf("%q, %s", op.Method, path)
+	if op.Body != nil {
+		callArgs += ", " + queryArg + ", " + bodyArg
+	} else if len(query) > 0 {
+		callArgs += ", " + queryArg
+	}
+
+	switch {
+	case op.Paginated:
+		fmt.Fprintf(b, "    const res = await this.request<%s>(%s);\n", data, callArgs)
+		b.WriteString("    return { items: res.data as " + data + ", meta: res.meta ?? {} };\n")
+	case data == "void":
+		fmt.Fprintf(b, "    await this.request<void>(%s);\n", callArgs)
+	default:
+		fmt.Fprintf(b, "    const res = await this.request<%s>(%s);\n", data, callArgs)
+		b.WriteString("    return res.data as " + data + ";\n")
+	}
+	b.WriteString("  }\n")
+}
+
+func writeTSDoc(b *strings.Builder, op *Operation) {
+	lines := []string{fmt.Sprintf("%s %s", op.Method, op.Path)}
+	if op.Summary != "" {
+		lines = append([]string{op.Summary}, lines...)
+	}
+	if op.Deprecated {
+		lines = append(lines, "@deprecated")
+	}
+	b.WriteString("  /**\n")
+	for _, line := range lines {
+		fmt.Fprintf(b, "   * %s\n", line)
+	}
+	b.WriteString("   */\n")
+}
+
+// tsIdent converts a parameter name such as "user_id" to userId
+func tsIdent(name string) string {
+	parts := strings.FieldsFunc(name, func(r rune) bool { return r == '_' || r == '-' || r == '.' })
+	for i := 1; i < len(parts); i++ {
+		parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+	}
+	return strings.Join(parts, "")
+}
+
+func sortedSchemaNames(spec *Spec) []string {
+	names := make([]string, 0, len(spec.Schemas))
+	for name := range spec.Schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func generatedHeader(comment string) string {
+	return comment + " Code generated by goiler sdk. DO NOT EDIT.\n"
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/pixperk/goiler/internal/config"
+	"github.com/pixperk/goiler/pkg/redact"
+)
+
+// accessLogger writes the per-request access log. Failed requests are
+// always logged, slow ones are promoted to warn, and the rest are sampled.
+// Sensitive query parameters, such as the WebSocket and event stream
+// ?token=, are redacted.
+type accessLogger struct {
+	logger        *slog.Logger
+	sampleRate    float64
+	slowThreshold time.Duration
+	skipPaths     map[string]bool
+}
+
+func newAccessLogger(cfg config.AccessLogConfig, logger *slog.Logger) *accessLogger {
+	skip := make(map[string]bool, len(cfg.SkipPaths))
+	for _, path := range cfg.SkipPaths {
+		skip[path] = true
+	}
+	return &accessLogger{
+		logger:        logger,
+		sampleRate:    cfg.SampleRate,
+		slowThreshold: cfg.SlowThreshold,
+		skipPaths:     skip,
+	}
+}
+
+// Middleware returns the request logger middleware
+func (l *accessLogger) Middleware() echo.MiddlewareFunc {
+	return middleware.RequestLoggerWithConfig(middleware.RequestLoggerConfig{
+		LogStatus:     true,
+		LogError:      true,
+		LogMethod:     true,
+		LogLatency:    true,
+		HandleError:   true,
+		LogValuesFunc: l.log,
+	})
+}
+
+func (l *accessLogger) log(c echo.Context, v middleware.RequestLoggerValues) error {
+	failed := v.Error != nil || v.Status >= 400
+	slow := l.slowThreshold > 0 && v.Latency >= l.slowThreshold
+	if !failed {
+		if l.skipPaths[c.Request().URL.Path] {
+			return nil
+		}
+		if !slow && !l.sampled() {
+			return nil
+		}
+	}
+
+	attrs := []slog.Attr{
+		slog.String("method", v.Method),
+		slog.String("uri", redact.URL(c.Request().URL, redact.DefaultKeys)),
+		slog.Int("status", v.Status),
+		slog.Duration("latency", v.Latency),
+		slog.String("request_id", c.Response().Header().Get(echo.HeaderXRequestID)),
+	}
+	ctx := c.Request().Context()
+
+	switch {
+	case v.Error != nil || v.Status >= 500:
+		if v.Error != nil {
+			attrs = append(attrs, slog.String("error", v.Error.Error()))
+		}
+		l.logger.LogAttrs(ctx, slog.LevelError, "request error", attrs...)
+	case slow:
+		l.logger.LogAttrs(ctx, slog.LevelWarn, "slow request", attrs...)
+	default:
+		l.logger.LogAttrs(ctx, slog.LevelInfo, "request", attrs...)
+	}
+	return nil
+}
+
+// sampled reports whether a successful request is logged
+func (l *accessLogger) sampled() bool {
+	return l.sampleRate >= 1 || rand.Float64() < l.sampleRate
+}
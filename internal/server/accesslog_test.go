@@ -0,0 +1,117 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/internal/config"
+)
+
+// newAccessLogTest returns an echo instance logging requests with cfg, and
+// a function returning the entries written since its last call
+func newAccessLogTest(t *testing.T, cfg config.AccessLogConfig) (*echo.Echo, func() []map[string]any) {
+	t.Helper()
+	var buf bytes.Buffer
+	l := newAccessLogger(cfg, slog.New(slog.NewJSONHandler(&buf, nil)))
+
+	e := echo.New()
+	e.Use(l.Middleware())
+	e.GET("/ok", func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+	e.GET("/health", func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+	e.GET("/missing", func(c echo.Context) error { return c.NoContent(http.StatusNotFound) })
+	e.GET("/broken", func(c echo.Context) error { return errors.New("db unavailable") })
+	e.GET("/slow", func(c echo.Context) error {
+		time.Sleep(5 * time.Millisecond)
+		return c.NoContent(http.StatusOK)
+	})
+
+	entries := func() []map[string]any {
+		var out []map[string]any
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			if line == "" {
+				continue
+			}
+			var entry map[string]any
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				t.Fatal(err)
+			}
+			out = append(out, entry)
+		}
+		buf.Reset()
+		return out
+	}
+	return e, entries
+}
+
+func get(e *echo.Echo, target string) {
+	e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+}
+
+func TestAccessLogger_Levels(t *testing.T) {
+	e, entries := newAccessLogTest(t, config.AccessLogConfig{SlowThreshold: time.Millisecond, SkipPaths: []string{"/health"}})
+
+	tests := []struct {
+		target string
+		level  string
+		msg    string
+	}{
+		// Successful requests aren't sampled with a rate of 0
+		{"/ok", "", ""},
+		{"/missing", "INFO", "request"},
+		{"/broken", "ERROR", "request error"},
+		{"/slow", "WARN", "slow request"},
+	}
+	for _, tt := range tests {
+		get(e, tt.target)
+		logged := entries()
+		if tt.level == "" {
+			if len(logged) != 0 {
+				t.Errorf("%s: expected no entry, got %v", tt.target, logged)
+			}
+			continue
+		}
+		if len(logged) != 1 || logged[0]["level"] != tt.level || logged[0]["msg"] != tt.msg {
+			t.Errorf("%s: expected a %s %q entry, got %v", tt.target, tt.level, tt.msg, logged)
+		}
+	}
+
+	get(e, "/broken")
+	if logged := entries(); len(logged) != 1 || logged[0]["error"] == nil || logged[0]["status"] != float64(http.StatusInternalServerError) {
+		t.Errorf("Expected the error and status to be logged, got %v", logged)
+	}
+}
+
+func TestAccessLogger_Sampling(t *testing.T) {
+	e, entries := newAccessLogTest(t, config.AccessLogConfig{SampleRate: 1, SkipPaths: []string{"/health"}})
+
+	get(e, "/ok")
+	if logged := entries(); len(logged) != 1 || logged[0]["msg"] != "request" {
+		t.Errorf("Expected every request to be logged with a rate of 1, got %v", logged)
+	}
+	get(e, "/health")
+	if logged := entries(); len(logged) != 0 {
+		t.Errorf("Expected skipped paths not to be logged, got %v", logged)
+	}
+}
+
+func TestAccessLogger_RedactsQuery(t *testing.T) {
+	e, entries := newAccessLogTest(t, config.AccessLogConfig{SampleRate: 1})
+
+	get(e, "/ok?token=eyJhbGciOi.secret&room=lobby")
+	logged := entries()
+	if len(logged) != 1 {
+		t.Fatalf("Expected 1 entry, got %v", logged)
+	}
+	uri, _ := logged[0]["uri"].(string)
+	if strings.Contains(uri, "eyJhbGciOi") || !strings.Contains(uri, "room=lobby") {
+		t.Errorf("Expected the token to be redacted and the rest kept, got %s", uri)
+	}
+}
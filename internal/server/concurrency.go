@@ -0,0 +1,192 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/pkg/clock"
+	"github.com/pixperk/goiler/pkg/response"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// RateLimitReasonConcurrency is reported when a consumer already has the
+// maximum number of requests in flight
+const RateLimitReasonConcurrency = "concurrency_exceeded"
+
+// ErrCodeConcurrencyLimit is the error code of concurrency limit rejections
+const ErrCodeConcurrencyLimit = "CONCURRENCY_LIMIT_EXCEEDED"
+
+// DefaultConcurrencyLeaseTTL is how long a slot outlives an instance that
+// died while holding it
+const DefaultConcurrencyLeaseTTL = 5 * time.Minute
+
+// Each semaphore is a sorted set of lease IDs scored by expiry. Expired
+// leases are dropped before counting, so slots held by crashed instances
+// free themselves.
+var (
+	acquireSlotScript = redis.NewScript(`
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", ARGV[1])
+if redis.call("ZCARD", KEYS[1]) >= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call("ZADD", KEYS[1], ARGV[3], ARGV[4])
+redis.call("PEXPIRE", KEYS[1], ARGV[5])
+return 1
+`)
+	renewSlotScript = redis.NewScript(`
+redis.call("ZADD", KEYS[1], "XX", ARGV[1], ARGV[2])
+redis.call("PEXPIRE", KEYS[1], ARGV[3])
+return 1
+`)
+)
+
+// slotStore holds the semaphores. redis.UniversalClient satisfies it.
+type slotStore interface {
+	redis.Scripter
+	ZRem(ctx context.Context, key string, members ...interface{}) *redis.IntCmd
+}
+
+// ConcurrencyLimiter caps the requests each consumer can have in flight on
+// expensive routes, such as one report at a time. Slots are Redis
+// semaphores shared by all instances; consumers are identified by user ID,
+// or client IP when anonymous. If Redis is unavailable requests are let
+// through.
+type ConcurrencyLimiter struct {
+	client   slotStore
+	prefix   string
+	leaseTTL time.Duration
+	logger   *slog.Logger
+	clock    clock.Clock
+
+	acquired metric.Int64Counter
+	rejected metric.Int64Counter
+}
+
+// NewConcurrencyLimiter creates a concurrency limiter. Slots are renewed
+// while their request runs and expire leaseTTL after an instance stops
+// renewing them.
+func NewConcurrencyLimiter(client redis.UniversalClient, leaseTTL time.Duration, logger *slog.Logger) *ConcurrencyLimiter {
+	if leaseTTL <= 0 {
+		leaseTTL = DefaultConcurrencyLeaseTTL
+	}
+
+	l := &ConcurrencyLimiter{
+		client:   client,
+		prefix:   "goiler:concurrency:",
+		leaseTTL: leaseTTL,
+		logger:   logger,
+		clock:    clock.New(),
+	}
+
+	meter := otel.Meter("goiler/ratelimit")
+	l.acquired, _ = meter.Int64Counter(
+		"concurrency_acquired_total",
+		metric.WithDescription("Total number of requests admitted by concurrency limits"),
+		metric.WithUnit("1"),
+	)
+	l.rejected, _ = meter.Int64Counter(
+		"concurrency_rejected_total",
+		metric.WithDescription("Total number of requests rejected by concurrency limits"),
+		metric.WithUnit("1"),
+	)
+	return l
+}
+
+// SetClock sets the clock lease expiries are computed from
+func (l *ConcurrencyLimiter) SetClock(c clock.Clock) {
+	l.clock = clock.OrReal(c)
+}
+
+// Limit allows each consumer at most max concurrent requests to the routes
+// it is applied to. Routes sharing a name share the slots:
+//
+//	admin.POST("/reports", h.Generate, limits.Limit("report", 1))
+func (l *ConcurrencyLimiter) Limit(name string, max int) echo.MiddlewareFunc {
+	policy := attribute.String("policy", name)
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			ctx := c.Request().Context()
+			key := l.prefix + name + ":" + requestConsumer(c)
+			lease := uuid.NewString()
+
+			ok, err := l.acquire(ctx, key, lease, max)
+			if err != nil {
+				l.logger.Error("concurrency limiter unavailable, allowing request",
+					slog.String("policy", name),
+					slog.String("error", err.Error()),
+				)
+				return next(c)
+			}
+			if !ok {
+				l.rejected.Add(ctx, 1, metric.WithAttributes(policy))
+				c.Response().Header().Set("X-RateLimit-Reason", RateLimitReasonConcurrency)
+				return response.ErrorWithDetails(c, http.StatusTooManyRequests, ErrCodeConcurrencyLimit,
+					fmt.Sprintf("Only %d %s request(s) can run at a time; wait for the current one to finish", max, name),
+					map[string]string{"policy": name, "limit": strconv.Itoa(max)})
+			}
+			l.acquired.Add(ctx, 1, metric.WithAttributes(policy))
+
+			stop := l.keepAlive(key, lease)
+			defer func() {
+				stop()
+				// Released even if the request was cancelled
+				if err := l.client.ZRem(context.WithoutCancel(ctx), key, lease).Err(); err != nil {
+					l.logger.Warn("failed to release concurrency slot",
+						slog.String("policy", name),
+						slog.String("error", err.Error()),
+					)
+				}
+			}()
+
+			return next(c)
+		}
+	}
+}
+
+func (l *ConcurrencyLimiter) acquire(ctx context.Context, key, lease string, max int) (bool, error) {
+	now := l.clock.Now()
+	acquired, err := acquireSlotScript.Run(ctx, l.client, []string{key},
+		now.UnixMilli(),
+		max,
+		now.Add(l.leaseTTL).UnixMilli(),
+		lease,
+		l.leaseTTL.Milliseconds(),
+	).Int()
+	return acquired == 1, err
+}
+
+// keepAlive renews the lease while the request runs, so long requests keep
+// their slot; the returned function stops renewing
+func (l *ConcurrencyLimiter) keepAlive(key, lease string) func() {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(l.leaseTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				err := renewSlotScript.Run(context.Background(), l.client, []string{key},
+					l.clock.Now().Add(l.leaseTTL).UnixMilli(),
+					lease,
+					l.leaseTTL.Milliseconds(),
+				).Err()
+				if err != nil {
+					l.logger.Warn("failed to renew concurrency slot", slog.String("error", err.Error()))
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
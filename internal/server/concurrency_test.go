@@ -0,0 +1,195 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/internal/testutil"
+	"github.com/pixperk/goiler/pkg/clock"
+	"github.com/redis/go-redis/v9"
+)
+
+// fakeSlots runs the semaphore scripts against sorted sets in memory, or
+// fails every call with err
+type fakeSlots struct {
+	mu   sync.Mutex
+	sets map[string]map[string]int64
+	err  error
+}
+
+func newFakeSlots() *fakeSlots {
+	return &fakeSlots{sets: make(map[string]map[string]int64)}
+}
+
+func (f *fakeSlots) EvalSha(ctx context.Context, sha1 string, keys []string, args ...interface{}) *redis.Cmd {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return redis.NewCmdResult(nil, f.err)
+	}
+	set := f.sets[keys[0]]
+	if set == nil {
+		set = make(map[string]int64)
+		f.sets[keys[0]] = set
+	}
+
+	switch sha1 {
+	case acquireSlotScript.Hash():
+		now, max := args[0].(int64), args[1].(int)
+		for lease, expires := range set {
+			if expires <= now {
+				delete(set, lease)
+			}
+		}
+		if len(set) >= max {
+			return redis.NewCmdResult(int64(0), nil)
+		}
+		set[args[3].(string)] = args[2].(int64)
+	case renewSlotScript.Hash():
+		if _, ok := set[args[1].(string)]; ok {
+			set[args[1].(string)] = args[0].(int64)
+		}
+	default:
+		return redis.NewCmdResult(nil, fmt.Errorf("NOSCRIPT %s", sha1))
+	}
+	return redis.NewCmdResult(int64(1), nil)
+}
+
+func (f *fakeSlots) Eval(ctx context.Context, script string, keys []string, args ...interface{}) *redis.Cmd {
+	return redis.NewCmdResult(nil, errors.New("unknown script"))
+}
+
+func (f *fakeSlots) EvalRO(ctx context.Context, script string, keys []string, args ...interface{}) *redis.Cmd {
+	return f.Eval(ctx, script, keys, args...)
+}
+
+func (f *fakeSlots) EvalShaRO(ctx context.Context, sha1 string, keys []string, args ...interface{}) *redis.Cmd {
+	return f.EvalSha(ctx, sha1, keys, args...)
+}
+
+func (f *fakeSlots) ScriptExists(ctx context.Context, hashes ...string) *redis.BoolSliceCmd {
+	return redis.NewBoolSliceResult(make([]bool, len(hashes)), nil)
+}
+
+func (f *fakeSlots) ScriptLoad(ctx context.Context, script string) *redis.StringCmd {
+	return redis.NewStringResult("", nil)
+}
+
+func (f *fakeSlots) ZRem(ctx context.Context, key string, members ...interface{}) *redis.IntCmd {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, member := range members {
+		delete(f.sets[key], member.(string))
+	}
+	return redis.NewIntResult(int64(len(members)), nil)
+}
+
+// held returns the leases in key
+func (f *fakeSlots) held(key string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.sets[key])
+}
+
+func newTestConcurrencyLimiter(slots *fakeSlots, clk clock.Clock) *ConcurrencyLimiter {
+	l := NewConcurrencyLimiter(nil, time.Minute, testutil.Logger())
+	l.client = slots
+	l.SetClock(clk)
+	return l
+}
+
+func TestConcurrencyLimiter_Limit(t *testing.T) {
+	slots := newFakeSlots()
+	l := newTestConcurrencyLimiter(slots, clock.NewFrozen(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)))
+
+	// Requests block until released, holding their slot
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	e := echo.New()
+	e.POST("/reports", func(c echo.Context) error {
+		entered <- struct{}{}
+		<-release
+		return c.NoContent(http.StatusAccepted)
+	}, l.Limit("report", 1))
+
+	do := func(ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/reports", nil)
+		req.RemoteAddr = ip + ":1234"
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- do("203.0.113.7") }()
+	<-entered
+
+	rec := do("203.0.113.7")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("X-RateLimit-Reason") != RateLimitReasonConcurrency {
+		t.Errorf("Expected a second report to be rejected, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Other consumers have slots of their own
+	go func() { done <- do("198.51.100.1") }()
+	<-entered
+	release <- struct{}{}
+	release <- struct{}{}
+	for range 2 {
+		if rec := <-done; rec.Code != http.StatusAccepted {
+			t.Errorf("Expected admitted reports to finish, got %d", rec.Code)
+		}
+	}
+
+	if n := slots.held("goiler:concurrency:report:ip:203.0.113.7"); n != 0 {
+		t.Errorf("Expected finished requests to release their slot, got %d held", n)
+	}
+	go func() { done <- do("203.0.113.7") }()
+	<-entered
+	release <- struct{}{}
+	if rec := <-done; rec.Code != http.StatusAccepted {
+		t.Errorf("Expected a released slot to be reused, got %d", rec.Code)
+	}
+}
+
+func TestConcurrencyLimiter_ExpiresAbandonedLeases(t *testing.T) {
+	slots := newFakeSlots()
+	frozen := clock.NewFrozen(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	l := newTestConcurrencyLimiter(slots, frozen)
+	ctx := context.Background()
+	key := "goiler:concurrency:report:user:1"
+
+	// A lease held by an instance that died without releasing it
+	if ok, err := l.acquire(ctx, key, "crashed", 1); !ok || err != nil {
+		t.Fatalf("Expected the first lease, got %v, %v", ok, err)
+	}
+	if ok, _ := l.acquire(ctx, key, "next", 1); ok {
+		t.Error("Expected the slot to be taken")
+	}
+
+	// Expiry follows the injected clock
+	frozen.Advance(time.Minute + time.Second)
+	if ok, err := l.acquire(ctx, key, "next", 1); !ok || err != nil {
+		t.Errorf("Expected the abandoned lease to expire, got %v, %v", ok, err)
+	}
+}
+
+func TestConcurrencyLimiter_AllowsWhenRedisIsDown(t *testing.T) {
+	slots := newFakeSlots()
+	slots.err = errors.New("connection refused")
+	l := newTestConcurrencyLimiter(slots, clock.New())
+
+	e := echo.New()
+	e.POST("/reports", func(c echo.Context) error { return c.NoContent(http.StatusAccepted) }, l.Limit("report", 1))
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/reports", nil))
+	if rec.Code != http.StatusAccepted {
+		t.Errorf("Expected requests to be let through without Redis, got %d", rec.Code)
+	}
+}
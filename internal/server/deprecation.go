@@ -0,0 +1,218 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/pkg/response"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Deprecation response headers
+const (
+	HeaderDeprecation = "Deprecation" // RFC 9745
+	HeaderSunset      = "Sunset"      // RFC 8594
+	HeaderLink        = "Link"
+)
+
+// DeprecationHeaders lists the headers exposed to browsers on deprecated routes
+var DeprecationHeaders = []string{HeaderDeprecation, HeaderSunset, HeaderLink}
+
+// maxDeprecationConsumers caps the consumers tracked per route; further
+// consumers are only counted
+const maxDeprecationConsumers = 1000
+
+// DeprecationPolicy describes a deprecated route. Deprecated routes keep
+// working; clients are told through headers and their calls are recorded.
+type DeprecationPolicy struct {
+	// Since is when the route was deprecated; zero sends "Deprecation: true"
+	Since time.Time `json:"since,omitempty"`
+	// Sunset is when the route is expected to stop working (optional)
+	Sunset time.Time `json:"sunset,omitempty"`
+	// Link points to migration docs (optional)
+	Link string `json:"link,omitempty"`
+	// Successor is the replacement route's URL (optional)
+	Successor string `json:"successor,omitempty"`
+}
+
+// DeprecatedConsumer describes a client that called a deprecated route
+type DeprecatedConsumer struct {
+	Consumer  string    `json:"consumer"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	Hits      int64     `json:"hits"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// DeprecatedRouteReport describes the calls to a deprecated route
+type DeprecatedRouteReport struct {
+	Method    string               `json:"method"`
+	Path      string               `json:"path"`
+	Policy    DeprecationPolicy    `json:"policy"`
+	Hits      int64                `json:"hits"`
+	Untracked int64                `json:"untracked_hits,omitempty"` // hits from consumers over the tracking cap
+	Consumers []DeprecatedConsumer `json:"consumers"`
+}
+
+type deprecatedRoute struct {
+	policy    DeprecationPolicy
+	hits      int64
+	untracked int64
+	consumers map[string]*DeprecatedConsumer
+}
+
+// DeprecationTracker marks routes deprecated and records which consumers
+// still call them. Consumers are identified by user ID when authenticated
+// and by client IP otherwise. Records are per instance and kept in memory.
+type DeprecationTracker struct {
+	mu     sync.Mutex
+	routes map[string]*deprecatedRoute // method + " " + path
+
+	hits metric.Int64Counter
+}
+
+// NewDeprecationTracker creates a deprecation tracker
+func NewDeprecationTracker() *DeprecationTracker {
+	t := &DeprecationTracker{routes: make(map[string]*deprecatedRoute)}
+	t.hits, _ = otel.Meter("goiler/http").Int64Counter(
+		"http_deprecated_requests_total",
+		metric.WithDescription("Total number of requests to deprecated routes, by route and consumer type"),
+		metric.WithUnit("1"),
+	)
+	return t
+}
+
+// Middleware marks the routes it is applied to as deprecated:
+//
+//	v1.GET("/users/me/profile", h.GetProfile, srv.Deprecations().Middleware(server.DeprecationPolicy{...}))
+func (t *DeprecationTracker) Middleware(policy DeprecationPolicy) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			header := c.Response().Header()
+			header.Set(HeaderDeprecation, policy.deprecationValue())
+			if !policy.Sunset.IsZero() {
+				header.Set(HeaderSunset, policy.Sunset.UTC().Format(http.TimeFormat))
+			}
+			if policy.Link != "" {
+				header.Add(HeaderLink, fmt.Sprintf(`<%s>; rel="deprecation"; type="text/html"`, policy.Link))
+			}
+			if policy.Successor != "" {
+				header.Add(HeaderLink, fmt.Sprintf(`<%s>; rel="successor-version"`, policy.Successor))
+			}
+
+			err := next(c)
+
+			// Recorded after the handler so route middleware such as auth has
+			// identified the user
+			t.record(c, policy)
+			return err
+		}
+	}
+}
+
+func (p DeprecationPolicy) deprecationValue() string {
+	if p.Since.IsZero() {
+		return "true"
+	}
+	return "@" + strconv.FormatInt(p.Since.Unix(), 10)
+}
+
+func (t *DeprecationTracker) record(c echo.Context, policy DeprecationPolicy) {
+	method, path := c.Request().Method, c.Path()
+	consumer := requestConsumer(c)
+	now := time.Now()
+
+	t.hits.Add(c.Request().Context(), 1, metric.WithAttributes(
+		attribute.String("method", method),
+		attribute.String("route", path),
+		attribute.String("consumer_type", consumerType(consumer)),
+	))
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := method + " " + path
+	route, ok := t.routes[key]
+	if !ok {
+		route = &deprecatedRoute{policy: policy, consumers: make(map[string]*DeprecatedConsumer)}
+		t.routes[key] = route
+	}
+	route.hits++
+
+	entry, ok := route.consumers[consumer]
+	if !ok {
+		if len(route.consumers) >= maxDeprecationConsumers {
+			route.untracked++
+			return
+		}
+		entry = &DeprecatedConsumer{Consumer: consumer, FirstSeen: now}
+		route.consumers[consumer] = entry
+	}
+	entry.Hits++
+	entry.LastSeen = now
+	entry.UserAgent = c.Request().UserAgent()
+}
+
+// consumerType buckets a consumer for the metric into "user" or "ip". The
+// consumers themselves are unbounded, so they're only listed in the report.
+func consumerType(consumer string) string {
+	kind, _, _ := strings.Cut(consumer, ":")
+	return kind
+}
+
+// Report returns the deprecated routes that have been called, most called
+// first, with their consumers ordered by most recent call
+func (t *DeprecationTracker) Report() []DeprecatedRouteReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	reports := make([]DeprecatedRouteReport, 0, len(t.routes))
+	for key, route := range t.routes {
+		method, path, _ := strings.Cut(key, " ")
+		report := DeprecatedRouteReport{
+			Method:    method,
+			Path:      path,
+			Policy:    route.policy,
+			Hits:      route.hits,
+			Untracked: route.untracked,
+			Consumers: make([]DeprecatedConsumer, 0, len(route.consumers)),
+		}
+		for _, consumer := range route.consumers {
+			report.Consumers = append(report.Consumers, *consumer)
+		}
+		sort.Slice(report.Consumers, func(i, j int) bool {
+			return report.Consumers[i].LastSeen.After(report.Consumers[j].LastSeen)
+		})
+		reports = append(reports, report)
+	}
+
+	sort.Slice(reports, func(i, j int) bool {
+		if reports[i].Hits != reports[j].Hits {
+			return reports[i].Hits > reports[j].Hits
+		}
+		return reports[i].Path < reports[j].Path
+	})
+	return reports
+}
+
+// listDeprecations reports which clients still call deprecated routes
+// @Summary Deprecated route usage
+// @Description Returns the deprecated routes called on this instance and the consumers calling them (admin only)
+// @Tags Admin
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {array} DeprecatedRouteReport
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Router /api/v1/admin/deprecations [get]
+func (s *Server) listDeprecations(c echo.Context) error {
+	return response.Success(c, s.deprecations.Report())
+}
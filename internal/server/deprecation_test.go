@@ -0,0 +1,148 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/pkg/authctx"
+	"go.opentelemetry.io/otel"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestDeprecationTracker_Middleware(t *testing.T) {
+	tracker := NewDeprecationTracker()
+	e := echo.New()
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusOK) }
+	e.GET("/v1/profile", ok, tracker.Middleware(DeprecationPolicy{
+		Since:     time.Unix(1700000000, 0),
+		Sunset:    time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		Link:      "https://docs.example.com/migrate",
+		Successor: "/v2/profile",
+	}))
+	e.GET("/v1/legacy", ok, tracker.Middleware(DeprecationPolicy{}))
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/profile", nil))
+	header := rec.Header()
+	if header.Get(HeaderDeprecation) != "@1700000000" || header.Get(HeaderSunset) != "Wed, 01 Jan 2025 00:00:00 GMT" {
+		t.Errorf("Unexpected deprecation headers: %v", header)
+	}
+	links := strings.Join(header.Values(HeaderLink), ", ")
+	if !strings.Contains(links, `<https://docs.example.com/migrate>; rel="deprecation"`) || !strings.Contains(links, `</v2/profile>; rel="successor-version"`) {
+		t.Errorf("Expected deprecation and successor links, got %s", links)
+	}
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/legacy", nil))
+	if rec.Header().Get(HeaderDeprecation) != "true" || rec.Header().Get(HeaderSunset) != "" || len(rec.Header().Values(HeaderLink)) != 0 {
+		t.Errorf("Expected only Deprecation: true, got %v", rec.Header())
+	}
+}
+
+func TestDeprecationTracker_Report(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	previous := otel.GetMeterProvider()
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	t.Cleanup(func() { otel.SetMeterProvider(previous) })
+
+	tracker := NewDeprecationTracker()
+	userID := uuid.New()
+	e := echo.New()
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusOK) }
+	signedIn := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if c.Request().Header.Get("X-Test-User") != "" {
+				authctx.Set(c, &authctx.User{ID: userID, Role: "user"})
+			}
+			return next(c)
+		}
+	}
+	// Auth runs inside the tracker, as route middleware does
+	e.GET("/v1/profile", ok, tracker.Middleware(DeprecationPolicy{}), signedIn)
+	e.GET("/v1/legacy", ok, tracker.Middleware(DeprecationPolicy{}))
+
+	call := func(path, ip string, user bool) {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = ip + ":1234"
+		req.Header.Set("User-Agent", "sdk/1.0")
+		if user {
+			req.Header.Set("X-Test-User", "1")
+		}
+		e.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	call("/v1/profile", "203.0.113.7", true)
+	call("/v1/profile", "203.0.113.7", true)
+	call("/v1/profile", "198.51.100.1", false)
+	call("/v1/legacy", "198.51.100.1", false)
+
+	reports := tracker.Report()
+	if len(reports) != 2 || reports[0].Path != "/v1/profile" || reports[0].Hits != 3 || reports[1].Hits != 1 {
+		t.Fatalf("Expected the most called route first, got %+v", reports)
+	}
+	consumers := make(map[string]int64)
+	for _, consumer := range reports[0].Consumers {
+		consumers[consumer.Consumer] = consumer.Hits
+		if consumer.UserAgent != "sdk/1.0" {
+			t.Errorf("Expected the user agent to be kept, got %q", consumer.UserAgent)
+		}
+	}
+	if consumers["user:"+userID.String()] != 2 || consumers["ip:198.51.100.1"] != 1 {
+		t.Errorf("Expected consumers by user ID and IP, got %v", consumers)
+	}
+
+	// The metric is labelled by consumer type, never by user ID or IP
+	var data metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &data); err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]int64)
+	for _, scope := range data.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			if m.Name != "http_deprecated_requests_total" {
+				continue
+			}
+			for _, point := range m.Data.(metricdata.Sum[int64]).DataPoints {
+				if _, ok := point.Attributes.Value("consumer"); ok {
+					t.Errorf("Expected no consumer label, got %v", point.Attributes)
+				}
+				route, _ := point.Attributes.Value("route")
+				kind, _ := point.Attributes.Value("consumer_type")
+				counts[route.AsString()+" "+kind.AsString()] += point.Value
+			}
+		}
+	}
+	want := map[string]int64{"/v1/profile user": 2, "/v1/profile ip": 1, "/v1/legacy ip": 1}
+	if len(counts) != len(want) {
+		t.Errorf("Expected %v, got %v", want, counts)
+	}
+	for key, n := range want {
+		if counts[key] != n {
+			t.Errorf("%s: expected %d, got %d", key, n, counts[key])
+		}
+	}
+}
+
+func TestDeprecationTracker_CapsConsumers(t *testing.T) {
+	tracker := NewDeprecationTracker()
+	e := echo.New()
+	e.GET("/v1/legacy", func(c echo.Context) error { return c.NoContent(http.StatusOK) }, tracker.Middleware(DeprecationPolicy{}))
+
+	for i := range maxDeprecationConsumers + 5 {
+		req := httptest.NewRequest(http.MethodGet, "/v1/legacy", nil)
+		req.RemoteAddr = fmt.Sprintf("10.0.%d.%d:1234", i/256, i%256)
+		e.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	report := tracker.Report()[0]
+	if len(report.Consumers) != maxDeprecationConsumers || report.Untracked != 5 || report.Hits != maxDeprecationConsumers+5 {
+		t.Errorf("Expected %d tracked consumers and 5 untracked hits, got %d and %d", maxDeprecationConsumers, len(report.Consumers), report.Untracked)
+	}
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/pkg/response"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// knownMethods bounds the method label of unmatched request metrics, since
+// clients may send any token as a method
+var knownMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodOptions: true,
+}
+
+// allowProbeMethods are tried, in the order Echo lists them in Allow, to
+// find the methods a path does answer
+var allowProbeMethods = []string{
+	http.MethodDelete,
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPatch,
+	http.MethodPost,
+	http.MethodPut,
+}
+
+// routeFallback answers requests that matched no route. Echo's router
+// returns echo.ErrNotFound for unknown paths and echo.ErrMethodNotAllowed,
+// with the Allow header set, for known paths requested with another method.
+// Both are written in the standard error envelope rather than Echo's
+// default body.
+//
+// Groups with middleware register a catch-all 404 route, which Echo prefers
+// over a 405, so a not-found path is first checked against the other
+// methods.
+type routeFallback struct {
+	logger *slog.Logger
+	routes *RouteRegistry
+	misses metric.Int64Counter
+}
+
+// newRouteFallback creates the fallback for unmatched requests
+func newRouteFallback(logger *slog.Logger, routes *RouteRegistry) *routeFallback {
+	f := &routeFallback{logger: logger, routes: routes}
+	f.misses, _ = otel.Meter("goiler/http").Int64Counter(
+		"http_unmatched_requests_total",
+		metric.WithDescription("Total number of requests matching no route, by method, status and catch-all route"),
+		metric.WithUnit("1"),
+	)
+	return f
+}
+
+// handle writes the response for err if it reports an unmatched request,
+// and reports whether it did
+func (f *routeFallback) handle(err error, c echo.Context) bool {
+	var code int
+	var errCode, message string
+	switch {
+	case errors.Is(err, echo.ErrNotFound):
+		code, errCode, message = http.StatusNotFound, "NOT_FOUND", "Route not found"
+		if allowed := f.allowedMethods(c); len(allowed) > 0 {
+			c.Response().Header().Set(echo.HeaderAllow, strings.Join(append([]string{http.MethodOptions}, allowed...), ", "))
+			code, errCode, message = http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "Method not allowed"
+		}
+	case errors.Is(err, echo.ErrMethodNotAllowed):
+		code, errCode, message = http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "Method not allowed"
+	default:
+		return false
+	}
+
+	req := c.Request()
+	method := req.Method
+	if !knownMethods[method] {
+		method = "OTHER"
+	}
+	// c.Path() is the catch-all or the route whose method didn't match,
+	// which keeps the label bounded unlike the request path
+	route := c.Path()
+	if route == "" {
+		route = "/*"
+	}
+	f.misses.Add(req.Context(), 1, metric.WithAttributes(
+		attribute.String("method", method),
+		attribute.Int("status", code),
+		attribute.String("route", route),
+	))
+
+	f.logger.Debug("unmatched request",
+		slog.Int("status", code),
+		slog.String("method", req.Method),
+		slog.String("path", req.URL.Path),
+		slog.String("allow", c.Response().Header().Get(echo.HeaderAllow)),
+	)
+
+	if err := response.Error(c, code, errCode, message); err != nil {
+		f.logger.Error("failed to send error response", slog.String("error", err.Error()))
+	}
+	return true
+}
+
+// allowedMethods returns the methods with a route for the request's path
+func (f *routeFallback) allowedMethods(c echo.Context) []string {
+	e, req := c.Echo(), c.Request()
+	path := echo.GetPath(req)
+	probe := e.NewContext(nil, nil)
+
+	var allowed []string
+	for _, method := range allowProbeMethods {
+		if method == req.Method {
+			continue
+		}
+		e.Router().Find(method, path, probe)
+		if f.routes.Has(method, probe.Path()) {
+			allowed = append(allowed, method)
+		}
+	}
+	return allowed
+}
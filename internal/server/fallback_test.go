@@ -0,0 +1,59 @@
+package server
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/internal/config"
+	"github.com/pixperk/goiler/pkg/response"
+)
+
+func TestRouteFallback(t *testing.T) {
+	s := New(&config.Config{}, slog.Default())
+	e := s.Echo()
+	noop := func(c echo.Context) error { return c.NoContent(http.StatusOK) }
+	e.GET("/health", noop)
+	v1 := e.Group("/api/v1")
+	v1.Use(func(next echo.HandlerFunc) echo.HandlerFunc { return next })
+	v1.GET("/users", noop)
+	v1.POST("/users", noop)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		status int
+		code   string
+		allow  string
+	}{
+		{"unknown path", http.MethodGet, "/nope", http.StatusNotFound, "NOT_FOUND", ""},
+		{"group catch-all", http.MethodGet, "/api/v1/nope", http.StatusNotFound, "NOT_FOUND", ""},
+		{"wrong method", http.MethodDelete, "/health", http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "OPTIONS, GET"},
+		{"wrong method in group", http.MethodDelete, "/api/v1/users", http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "OPTIONS, GET, POST"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+
+			if rec.Code != tt.status {
+				t.Fatalf("Expected status %d, got %d", tt.status, rec.Code)
+			}
+			if got := rec.Header().Get(echo.HeaderAllow); got != tt.allow {
+				t.Errorf("Expected Allow %q, got %q", tt.allow, got)
+			}
+			var body response.Response
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("Failed to decode body %q: %v", rec.Body.String(), err)
+			}
+			if body.Success || body.Error == nil || body.Error.Code != tt.code {
+				t.Errorf("Expected a %s error envelope, got %s", tt.code, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+)
+
+// listenFDsStart is the first file descriptor systemd passes to a
+// socket-activated service
+const listenFDsStart = 3
+
+// Listener sources reported when the server starts
+const (
+	listenerSystemd   = "systemd"
+	listenerReusePort = "reuseport"
+	listenerDefault   = "default"
+)
+
+// listen opens the server's listener. A socket passed by systemd socket
+// activation takes precedence; otherwise the port is bound, with
+// SO_REUSEPORT if enabled so a new process can bind it alongside this one.
+func (s *Server) listen() (net.Listener, string, error) {
+	ln, err := activatedListener()
+	if err != nil || ln != nil {
+		return ln, listenerSystemd, err
+	}
+
+	addr := ":" + s.config.App.Port
+	if s.config.App.ReusePort {
+		lc := net.ListenConfig{Control: reusePort}
+		ln, err := lc.Listen(context.Background(), "tcp", addr)
+		return ln, listenerReusePort, err
+	}
+	ln, err = net.Listen("tcp", addr)
+	return ln, listenerDefault, err
+}
+
+// activatedListener returns the first socket systemd passed to the process,
+// or nil if it wasn't socket-activated. The activation variables are
+// cleared so child processes don't claim the socket.
+func activatedListener() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds < 1 {
+		return nil, nil
+	}
+
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	// FileListener duplicates the descriptor, so the file can be closed
+	f := os.NewFile(listenFDsStart, "systemd-socket")
+	defer f.Close()
+	return net.FileListener(f)
+}
@@ -1,102 +1,313 @@
 package server
 
 import (
+	"container/list"
+	"context"
+	"math"
 	"net/http"
+	"slices"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/pkg/authctx"
+	"github.com/pixperk/goiler/pkg/clock"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
 	"golang.org/x/time/rate"
 )
 
 // RateLimiterConfig defines rate limiter configuration
 type RateLimiterConfig struct {
+	// Name identifies the policy in metrics (defaults to "default")
+	Name     string
 	Requests int
 	Duration time.Duration
 	KeyFunc  func(c echo.Context) string
+	// MaxVisitors caps the number of tracked visitors; the least recently
+	// seen one is evicted to make room. 0 disables the cap.
+	MaxVisitors int
+	// IdleTimeout is how long a visitor is remembered after its last
+	// request (defaults to 3 minutes)
+	IdleTimeout time.Duration
+	// Clock stamps visitor activity; defaults to the system clock
+	Clock clock.Clock
 }
 
+// Rate limit rejection reasons reported in the X-RateLimit-Reason header
+const (
+	RateLimitReasonExceeded = "rate_exceeded"
+)
+
 // visitor holds the rate limiter for each visitor
 type visitor struct {
+	key      string
 	limiter  *rate.Limiter
 	lastSeen time.Time
 }
 
-// RateLimiter middleware
+// RateLimiterStats reports the state of a rate limiter
+type RateLimiterStats struct {
+	Policy      string `json:"policy"`
+	Visitors    int    `json:"visitors"`
+	MaxVisitors int    `json:"max_visitors"`
+	Allowed     int64  `json:"allowed"`
+	Rejected    int64  `json:"rejected"`
+	// Evicted counts visitors dropped to stay under MaxVisitors
+	Evicted int64 `json:"evicted"`
+	// Expired counts visitors dropped after IdleTimeout
+	Expired int64 `json:"expired"`
+}
+
+// RateLimiter middleware. Visitors are kept in memory, least recently seen
+// last, so the map stays bounded under floods of spoofed client IPs.
 type RateLimiter struct {
-	visitors map[string]*visitor
-	mu       sync.RWMutex
-	config   RateLimiterConfig
+	visitors map[string]*list.Element
+	// recent orders visitors by last request, most recent first
+	recent *list.List
+	mu     sync.Mutex
+	config RateLimiterConfig
+	clock  clock.Clock
+
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	allowedCount  atomic.Int64
+	rejectedCount atomic.Int64
+	evictedCount  atomic.Int64
+	expiredCount  atomic.Int64
+
+	allowed   metric.Int64Counter
+	rejected  metric.Int64Counter
+	evictions metric.Int64Counter
 }
 
-// NewRateLimiter creates a new rate limiter
+// NewRateLimiter creates a new rate limiter. Call Stop to end its cleanup
+// goroutine.
 func NewRateLimiter(config RateLimiterConfig) *RateLimiter {
 	if config.KeyFunc == nil {
 		config.KeyFunc = func(c echo.Context) string {
 			return c.RealIP()
 		}
 	}
+	if config.Name == "" {
+		config.Name = "default"
+	}
+	if config.IdleTimeout <= 0 {
+		config.IdleTimeout = 3 * time.Minute
+	}
 
 	rl := &RateLimiter{
-		visitors: make(map[string]*visitor),
+		visitors: make(map[string]*list.Element),
+		recent:   list.New(),
 		config:   config,
+		clock:    clock.OrReal(config.Clock),
+		stop:     make(chan struct{}),
 	}
+	rl.initMetrics()
 
-	// Clean up old entries every minute
 	go rl.cleanupVisitors()
 
 	return rl
 }
 
+// initMetrics registers rate limiter metrics with the global meter provider
+func (rl *RateLimiter) initMetrics() {
+	meter := otel.Meter("goiler/ratelimit")
+
+	rl.allowed, _ = meter.Int64Counter(
+		"ratelimit_allowed_total",
+		metric.WithDescription("Total number of requests admitted by the rate limiter"),
+		metric.WithUnit("1"),
+	)
+
+	rl.rejected, _ = meter.Int64Counter(
+		"ratelimit_rejected_total",
+		metric.WithDescription("Total number of requests rejected by the rate limiter"),
+		metric.WithUnit("1"),
+	)
+
+	rl.evictions, _ = meter.Int64Counter(
+		"ratelimit_visitor_evictions_total",
+		metric.WithDescription("Total number of visitors dropped by the rate limiter, by reason (capacity or idle)"),
+		metric.WithUnit("1"),
+	)
+
+	policy := attribute.String("policy", rl.config.Name)
+	meter.Int64ObservableGauge(
+		"ratelimit_visitors",
+		metric.WithDescription("Number of visitors currently tracked by the rate limiter"),
+		metric.WithUnit("1"),
+		metric.WithInt64Callback(func(ctx context.Context, observer metric.Int64Observer) error {
+			observer.Observe(int64(rl.VisitorCount()), metric.WithAttributes(policy))
+			return nil
+		}),
+	)
+}
+
 // Middleware returns the rate limiter middleware
 func (rl *RateLimiter) Middleware() echo.MiddlewareFunc {
+	policy := attribute.String("policy", rl.config.Name)
+
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
+			ctx := c.Request().Context()
 			key := rl.config.KeyFunc(c)
 			limiter := rl.getVisitor(key)
 
-			if !limiter.Allow() {
+			header := c.Response().Header()
+			header.Set("X-RateLimit-Limit", strconv.Itoa(rl.config.Requests))
+
+			now := time.Now()
+			reservation := limiter.ReserveN(now, 1)
+			retryAfter := reservation.DelayFrom(now)
+			if !reservation.OK() {
+				// A burst below one request never fits and the delay is
+				// infinite, so clients are asked back after a window
+				retryAfter = max(rl.config.Duration, time.Second)
+			}
+			if retryAfter > 0 {
+				reservation.CancelAt(now)
+
+				rl.rejectedCount.Add(1)
+				rl.rejected.Add(ctx, 1, metric.WithAttributes(
+					policy,
+					attribute.String("reason", RateLimitReasonExceeded),
+				))
+
+				header.Set("X-RateLimit-Remaining", "0")
+				header.Set("X-RateLimit-Reason", RateLimitReasonExceeded)
+				header.Set(echo.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
 				return echo.NewHTTPError(http.StatusTooManyRequests, "rate limit exceeded")
 			}
 
+			rl.allowedCount.Add(1)
+			rl.allowed.Add(ctx, 1, metric.WithAttributes(policy))
+			header.Set("X-RateLimit-Remaining", strconv.Itoa(int(limiter.TokensAt(now))))
+
 			return next(c)
 		}
 	}
 }
 
-// getVisitor returns the rate limiter for a visitor
+// VisitorCount returns the number of tracked visitors
+func (rl *RateLimiter) VisitorCount() int {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return len(rl.visitors)
+}
+
+// Stats returns the limiter's visitor count and counters since it started
+func (rl *RateLimiter) Stats() RateLimiterStats {
+	return RateLimiterStats{
+		Policy:      rl.config.Name,
+		Visitors:    rl.VisitorCount(),
+		MaxVisitors: rl.config.MaxVisitors,
+		Allowed:     rl.allowedCount.Load(),
+		Rejected:    rl.rejectedCount.Load(),
+		Evicted:     rl.evictedCount.Load(),
+		Expired:     rl.expiredCount.Load(),
+	}
+}
+
+// Stop ends the cleanup goroutine. The middleware keeps working, but idle
+// visitors are then only dropped to make room under MaxVisitors.
+func (rl *RateLimiter) Stop() {
+	rl.stopOnce.Do(func() { close(rl.stop) })
+}
+
+// getVisitor returns the rate limiter for a visitor, evicting the least
+// recently seen visitor if a new one would exceed MaxVisitors
 func (rl *RateLimiter) getVisitor(key string) *rate.Limiter {
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
 
-	v, exists := rl.visitors[key]
-	if !exists {
-		limiter := rate.NewLimiter(rate.Every(rl.config.Duration/time.Duration(rl.config.Requests)), rl.config.Requests)
-		rl.visitors[key] = &visitor{limiter: limiter, lastSeen: time.Now()}
-		return limiter
+	now := rl.clock.Now()
+	if elem, exists := rl.visitors[key]; exists {
+		v := elem.Value.(*visitor)
+		v.lastSeen = now
+		rl.recent.MoveToFront(elem)
+		return v.limiter
 	}
 
-	v.lastSeen = time.Now()
-	return v.limiter
+	if rl.config.MaxVisitors > 0 {
+		for len(rl.visitors) >= rl.config.MaxVisitors {
+			rl.remove(rl.recent.Back())
+			rl.evictedCount.Add(1)
+			rl.evictions.Add(context.Background(), 1, metric.WithAttributes(
+				attribute.String("policy", rl.config.Name),
+				attribute.String("reason", "capacity"),
+			))
+		}
+	}
+
+	// Fewer than one request per window admits nothing
+	var limit rate.Limit
+	if rl.config.Requests > 0 {
+		limit = rate.Every(rl.config.Duration / time.Duration(rl.config.Requests))
+	}
+	limiter := rate.NewLimiter(limit, rl.config.Requests)
+	rl.visitors[key] = rl.recent.PushFront(&visitor{key: key, limiter: limiter, lastSeen: now})
+	return limiter
+}
+
+// remove forgets a visitor; rl.mu must be held
+func (rl *RateLimiter) remove(elem *list.Element) {
+	rl.recent.Remove(elem)
+	delete(rl.visitors, elem.Value.(*visitor).key)
 }
 
-// cleanupVisitors removes old entries
+// cleanupVisitors periodically drops idle visitors until Stop is called
 func (rl *RateLimiter) cleanupVisitors() {
+	ticker := time.NewTicker(rl.config.IdleTimeout / 3)
+	defer ticker.Stop()
+
 	for {
-		time.Sleep(time.Minute)
+		select {
+		case <-rl.stop:
+			return
+		case <-ticker.C:
+			rl.expireVisitors()
+		}
+	}
+}
 
-		rl.mu.Lock()
-		for key, v := range rl.visitors {
-			if time.Since(v.lastSeen) > 3*time.Minute {
-				delete(rl.visitors, key)
-			}
+// expireVisitors drops visitors idle for longer than IdleTimeout
+func (rl *RateLimiter) expireVisitors() {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	expired := 0
+	for elem := rl.recent.Back(); elem != nil; elem = rl.recent.Back() {
+		if rl.clock.Since(elem.Value.(*visitor).lastSeen) <= rl.config.IdleTimeout {
+			break
 		}
-		rl.mu.Unlock()
+		rl.remove(elem)
+		expired++
+	}
+	if expired > 0 {
+		rl.expiredCount.Add(int64(expired))
+		rl.evictions.Add(context.Background(), int64(expired), metric.WithAttributes(
+			attribute.String("policy", rl.config.Name),
+			attribute.String("reason", "idle"),
+		))
+	}
+}
+
+// requestConsumer identifies the caller as "user:<id>" or "ip:<addr>"
+func requestConsumer(c echo.Context) string {
+	if userID, ok := authctx.UserID(c); ok {
+		return "user:" + userID.String()
 	}
+	return "ip:" + c.RealIP()
 }
 
-// AuthMiddleware creates an authentication middleware
-func AuthMiddleware(validateToken func(string) (interface{}, error)) echo.MiddlewareFunc {
+// AuthMiddleware creates an authentication middleware that stores the user
+// validateToken returns via authctx, where RequireRoles and handlers read it
+func AuthMiddleware(validateToken func(string) (*authctx.User, error)) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			authHeader := c.Request().Header.Get("Authorization")
@@ -111,36 +322,26 @@ func AuthMiddleware(validateToken func(string) (interface{}, error)) echo.Middle
 			}
 
 			token := authHeader[len(bearerPrefix):]
-			claims, err := validateToken(token)
-			if err != nil {
+			user, err := validateToken(token)
+			if err != nil || user == nil {
 				return echo.NewHTTPError(http.StatusUnauthorized, "invalid or expired token")
 			}
 
-			// Store claims in context
-			c.Set("user", claims)
+			authctx.Set(c, user)
 			return next(c)
 		}
 	}
 }
 
-// RequireRoles creates a middleware that checks for required roles
+// RequireRoles creates a middleware that admits users holding one of the
+// given roles. It works with either auth middleware, as both store the user
+// via authctx; prefer rbac.Authorizer.RequirePermission for new routes.
 func RequireRoles(roles ...string) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
-			user := c.Get("user")
-			if user == nil {
-				return echo.NewHTTPError(http.StatusUnauthorized, "user not found in context")
-			}
-
-			// Type assert to get user claims
-			claims, ok := user.(map[string]interface{})
-			if !ok {
-				return echo.NewHTTPError(http.StatusInternalServerError, "invalid user claims")
-			}
-
-			userRole, ok := claims["role"].(string)
+			userRole, ok := authctx.Role(c)
 			if !ok {
-				return echo.NewHTTPError(http.StatusForbidden, "user role not found")
+				return echo.NewHTTPError(http.StatusUnauthorized, "user not found in context")
 			}
 
 			for _, role := range roles {
@@ -154,13 +355,32 @@ func RequireRoles(roles ...string) echo.MiddlewareFunc {
 	}
 }
 
-// TimeoutMiddleware adds a timeout to the request context
+// TimeoutMiddleware adds a deadline to the request context. Downstream calls
+// derive their own timeouts from it via pkg/budget. Event streams, which
+// stay open until the client leaves, get no deadline.
 func TimeoutMiddleware(timeout time.Duration) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
-			// The context timeout is already handled by Echo's TimeoutMiddleware
-			// This is a simpler version that just passes through
+			if timeout <= 0 || isEventStream(c) {
+				return next(c)
+			}
+
+			ctx, cancel := context.WithTimeout(c.Request().Context(), timeout)
+			defer cancel()
+
+			c.SetRequest(c.Request().WithContext(ctx))
 			return next(c)
 		}
 	}
 }
+
+// eventStreamRoutes lists the routes serving Server-Sent Events streams,
+// which stay open until the client leaves
+var eventStreamRoutes = []string{"/api/v1/events"}
+
+// isEventStream reports whether the request was routed to an event stream.
+// The route decides, not the Accept header, which any client could send to
+// escape the request deadline and the in-flight count.
+func isEventStream(c echo.Context) bool {
+	return c.Request().Method == http.MethodGet && slices.Contains(eventStreamRoutes, c.Path())
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/pkg/clock"
+)
+
+func newTestRateLimiter(t *testing.T, config RateLimiterConfig) (*RateLimiter, func(key string) int) {
+	t.Helper()
+	config.KeyFunc = func(c echo.Context) string { return c.Request().Header.Get("X-Visitor") }
+	rl := NewRateLimiter(config)
+	t.Cleanup(rl.Stop)
+
+	e := echo.New()
+	handler := rl.Middleware()(func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+	do := func(key string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Visitor", key)
+		rec := httptest.NewRecorder()
+		if err := handler(e.NewContext(req, rec)); err != nil {
+			if he, ok := err.(*echo.HTTPError); ok {
+				return he.Code
+			}
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return rec.Code
+	}
+	return rl, do
+}
+
+func TestRateLimiter_EvictsLeastRecentlySeen(t *testing.T) {
+	rl, do := newTestRateLimiter(t, RateLimiterConfig{Requests: 1, Duration: time.Hour, MaxVisitors: 2})
+
+	do("a")
+	do("b")
+	do("a") // rejected, but marks a as recently seen
+	do("c") // evicts b
+
+	if got := rl.VisitorCount(); got != 2 {
+		t.Fatalf("Expected 2 tracked visitors, got %d", got)
+	}
+	if code := do("a"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected a to still be limited, got %d", code)
+	}
+	if code := do("b"); code != http.StatusOK {
+		t.Errorf("Expected evicted visitor b to start over, got %d", code)
+	}
+
+	stats := rl.Stats()
+	if stats.Evicted != 2 || stats.Allowed != 4 || stats.Rejected != 2 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestRateLimiter_ExpiresIdleVisitors(t *testing.T) {
+	clk := clock.NewFrozen(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	rl, do := newTestRateLimiter(t, RateLimiterConfig{Requests: 10, Duration: time.Minute, IdleTimeout: time.Minute, Clock: clk})
+
+	do("old")
+	clk.Advance(45 * time.Second)
+	do("new")
+	clk.Advance(30 * time.Second)
+	rl.expireVisitors()
+
+	if got := rl.VisitorCount(); got != 1 {
+		t.Fatalf("Expected only the recent visitor to remain, got %d", got)
+	}
+	if stats := rl.Stats(); stats.Expired != 1 {
+		t.Errorf("Expected 1 expired visitor, got %d", stats.Expired)
+	}
+}
+
+func TestRateLimiter_StopIsIdempotent(t *testing.T) {
+	rl, do := newTestRateLimiter(t, RateLimiterConfig{Requests: 1, Duration: time.Minute})
+	rl.Stop()
+	rl.Stop()
+
+	if code := do("a"); code != http.StatusOK {
+		t.Errorf("Expected the middleware to keep working after Stop, got %d", code)
+	}
+}
+
+func TestRateLimiter_RetryAfterWithoutBurst(t *testing.T) {
+	rl := NewRateLimiter(RateLimiterConfig{Requests: 0, Duration: time.Minute})
+	t.Cleanup(rl.Stop)
+
+	e := echo.New()
+	handler := rl.Middleware()(func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+	rec := httptest.NewRecorder()
+	err := handler(e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec))
+
+	if he, ok := err.(*echo.HTTPError); !ok || he.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected 429, got %v", err)
+	}
+	if got := rec.Header().Get(echo.HeaderRetryAfter); got != "60" {
+		t.Errorf("Expected Retry-After of one window, got %q", got)
+	}
+}
+
+func TestIsEventStream_ByRoute(t *testing.T) {
+	e := echo.New()
+	deadlines := make(map[string]bool)
+	record := func(c echo.Context) error {
+		_, ok := c.Request().Context().Deadline()
+		deadlines[c.Path()] = ok
+		return c.NoContent(http.StatusOK)
+	}
+	e.Use(TimeoutMiddleware(time.Minute))
+	e.GET("/api/v1/events", record)
+	e.GET("/api/v1/users", record)
+
+	for _, path := range []string{"/api/v1/events", "/api/v1/users"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		// Asking for a stream doesn't make a route one
+		req.Header.Set(echo.HeaderAccept, "text/event-stream")
+		e.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	if deadlines["/api/v1/events"] {
+		t.Error("Expected the event stream route to get no deadline")
+	}
+	if !deadlines["/api/v1/users"] {
+		t.Error("Expected other routes to keep their deadline whatever they accept")
+	}
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/pkg/authctx"
+	"github.com/pixperk/goiler/pkg/response"
+)
+
+// DefaultReadOnlyMessage is returned to rejected requests when no reason is given
+const DefaultReadOnlyMessage = "The service is temporarily read-only. Please try again later."
+
+// ReadOnlyStatus describes the current read-only state
+type ReadOnlyStatus struct {
+	Enabled bool       `json:"enabled"`
+	Message string     `json:"message,omitempty"`
+	Since   *time.Time `json:"since,omitempty"`
+}
+
+// ReadOnlySwitch rejects mutating requests while enabled, e.g. during a
+// database failover or migration. The state is per instance; toggle every
+// instance or set APP_READ_ONLY when deploying.
+type ReadOnlySwitch struct {
+	mu      sync.RWMutex
+	enabled bool
+	message string
+	since   time.Time
+
+	// exempt holds path prefixes that stay writable
+	exempt []string
+}
+
+// NewReadOnlySwitch creates a read-only switch. Requests whose path starts
+// with one of the exempt prefixes are never rejected.
+func NewReadOnlySwitch(enabled bool, message string, exempt ...string) *ReadOnlySwitch {
+	s := &ReadOnlySwitch{exempt: exempt}
+	if enabled {
+		s.Enable(message)
+	}
+	return s
+}
+
+// Enabled reports whether mutating requests are being rejected
+func (s *ReadOnlySwitch) Enabled() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.enabled
+}
+
+// Enable starts rejecting mutating requests with the given message
+func (s *ReadOnlySwitch) Enable(message string) {
+	if message == "" {
+		message = DefaultReadOnlyMessage
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.enabled {
+		s.since = time.Now()
+	}
+	s.enabled = true
+	s.message = message
+}
+
+// Disable stops rejecting mutating requests
+func (s *ReadOnlySwitch) Disable() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.enabled = false
+	s.message = ""
+	s.since = time.Time{}
+}
+
+// Status returns the current state
+func (s *ReadOnlySwitch) Status() ReadOnlyStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	status := ReadOnlyStatus{Enabled: s.enabled, Message: s.message}
+	if s.enabled {
+		since := s.since
+		status.Since = &since
+	}
+	return status
+}
+
+// Middleware rejects POST, PUT, PATCH and DELETE requests with 503 while the
+// switch is enabled
+func (s *ReadOnlySwitch) Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !isMutating(c.Request().Method) || s.isExempt(c.Request().URL.Path) {
+				return next(c)
+			}
+
+			status := s.Status()
+			if !status.Enabled {
+				return next(c)
+			}
+
+			return response.Error(c, http.StatusServiceUnavailable, "READ_ONLY", status.Message)
+		}
+	}
+}
+
+func (s *ReadOnlySwitch) isExempt(path string) bool {
+	for _, prefix := range s.exempt {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func isMutating(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// ReadOnlyRequest represents a read-only toggle request
+type ReadOnlyRequest struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message,omitempty"`
+}
+
+// getReadOnly returns the read-only state
+// @Summary Get read-only mode
+// @Description Returns whether mutating requests are being rejected on this instance (admin only)
+// @Tags Admin
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} ReadOnlyStatus
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Router /api/v1/admin/read-only [get]
+func (s *Server) getReadOnly(c echo.Context) error {
+	return response.Success(c, s.readOnly.Status())
+}
+
+// setReadOnly toggles read-only mode
+// @Summary Set read-only mode
+// @Description Enables or disables read-only mode on this instance (admin only)
+// @Tags Admin
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body ReadOnlyRequest true "Read-only state"
+// @Success 200 {object} ReadOnlyStatus
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Router /api/v1/admin/read-only [put]
+func (s *Server) setReadOnly(c echo.Context) error {
+	var req ReadOnlyRequest
+	if err := c.Bind(&req); err != nil {
+		return response.BadRequest(c, "Invalid request body")
+	}
+
+	if req.Enabled {
+		s.readOnly.Enable(req.Message)
+	} else {
+		s.readOnly.Disable()
+	}
+
+	userID, _ := authctx.UserID(c)
+	s.logger.Warn("read-only mode changed",
+		slog.Bool("enabled", req.Enabled),
+		slog.String("user_id", userID.String()),
+	)
+
+	return response.Success(c, s.readOnly.Status())
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/internal/config"
+	"github.com/pixperk/goiler/internal/testutil"
+	"github.com/pixperk/goiler/pkg/response"
+)
+
+func TestReadOnlySwitch_Middleware(t *testing.T) {
+	s := NewReadOnlySwitch(false, "", "/api/v1/auth/")
+	e := echo.New()
+	e.Use(s.Middleware())
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusOK) }
+	e.GET("/api/v1/things", ok)
+	e.POST("/api/v1/things", ok)
+	e.DELETE("/api/v1/things", ok)
+	e.POST("/api/v1/auth/login", ok)
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec
+	}
+
+	if rec := do(http.MethodPost, "/api/v1/things"); rec.Code != http.StatusOK {
+		t.Errorf("Expected writes while disabled, got %d", rec.Code)
+	}
+
+	s.Enable("Failover in progress")
+	tests := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{http.MethodGet, "/api/v1/things", http.StatusOK},
+		{http.MethodPost, "/api/v1/things", http.StatusServiceUnavailable},
+		{http.MethodDelete, "/api/v1/things", http.StatusServiceUnavailable},
+		{http.MethodPost, "/api/v1/auth/login", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if rec := do(tt.method, tt.path); rec.Code != tt.status {
+			t.Errorf("%s %s: expected %d, got %d", tt.method, tt.path, tt.status, rec.Code)
+		}
+	}
+
+	var body response.Response
+	rec := do(http.MethodPost, "/api/v1/things")
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Error == nil || body.Error.Code != "READ_ONLY" || body.Error.Message != "Failover in progress" {
+		t.Errorf("Expected a READ_ONLY error with the message, got %s", rec.Body.String())
+	}
+
+	s.Disable()
+	if rec := do(http.MethodPost, "/api/v1/things"); rec.Code != http.StatusOK {
+		t.Errorf("Expected writes after disabling, got %d", rec.Code)
+	}
+}
+
+func TestReadOnlySwitch_Status(t *testing.T) {
+	s := NewReadOnlySwitch(true, "")
+	status := s.Status()
+	if !status.Enabled || status.Message != DefaultReadOnlyMessage || status.Since == nil {
+		t.Fatalf("Expected enabled with the default message, got %+v", status)
+	}
+
+	// Re-enabling changes the message but keeps when it started
+	since := *status.Since
+	s.Enable("Migrating")
+	if status := s.Status(); status.Message != "Migrating" || !status.Since.Equal(since) {
+		t.Errorf("Expected the new message since %v, got %+v", since, status)
+	}
+
+	s.Disable()
+	if status := s.Status(); status.Enabled || status.Message != "" || status.Since != nil {
+		t.Errorf("Expected a cleared status, got %+v", status)
+	}
+}
+
+func TestServer_ToggleReadOnly(t *testing.T) {
+	s := New(&config.Config{}, testutil.Logger())
+	s.Echo().Use(s.readOnly.Middleware())
+	allow := func(next echo.HandlerFunc) echo.HandlerFunc { return next }
+	s.RegisterAdminRoutes(s.Echo().Group("/api/v1/admin"), allow, allow)
+	s.Echo().POST("/api/v1/things", func(c echo.Context) error { return c.NoContent(http.StatusCreated) })
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		s.Echo().ServeHTTP(rec, req)
+		return rec
+	}
+
+	// The toggle stays writable, so read-only mode can be turned off again
+	if rec := do(http.MethodPut, "/api/v1/admin/read-only", `{"enabled":true,"message":"Maintenance"}`); rec.Code != http.StatusOK {
+		t.Fatalf("Expected the toggle to succeed, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := do(http.MethodPost, "/api/v1/things", ""); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected writes to be rejected, got %d", rec.Code)
+	}
+	if rec := do(http.MethodGet, "/api/v1/admin/read-only", ""); !strings.Contains(rec.Body.String(), "Maintenance") {
+		t.Errorf("Expected the status to carry the message, got %s", rec.Body.String())
+	}
+	if rec := do(http.MethodPut, "/api/v1/admin/read-only", `{"enabled":false}`); rec.Code != http.StatusOK {
+		t.Fatalf("Expected the toggle to succeed, got %d", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/api/v1/things", ""); rec.Code != http.StatusCreated {
+		t.Errorf("Expected writes once read-only mode is off, got %d", rec.Code)
+	}
+	if rec := do(http.MethodPut, "/api/v1/admin/read-only", `{`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid body, got %d", rec.Code)
+	}
+}
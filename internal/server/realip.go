@@ -0,0 +1,48 @@
+package server
+
+import (
+	"log/slog"
+	"net"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ipExtractor returns how c.RealIP finds the client: behind trusted proxies,
+// the first X-Forwarded-For hop that isn't one of them; otherwise the peer
+// address, so clients can't pick their own IP by sending the header.
+// Entries that are neither IPs nor CIDR ranges are skipped with a warning.
+func ipExtractor(proxies []string, logger *slog.Logger) echo.IPExtractor {
+	var trusted []echo.TrustOption
+	for _, proxy := range proxies {
+		proxy = strings.TrimSpace(proxy)
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil {
+				bits := 8 * net.IPv6len
+				if ip.To4() != nil {
+					ip, bits = ip.To4(), 8*net.IPv4len
+				}
+				trusted = append(trusted, echo.TrustIPRange(&net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}))
+				continue
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			logger.Warn("ignoring invalid APP_TRUSTED_PROXIES entry", slog.String("proxy", proxy))
+			continue
+		}
+		trusted = append(trusted, echo.TrustIPRange(ipNet))
+	}
+
+	if len(trusted) == 0 {
+		return echo.ExtractIPDirect()
+	}
+	// Only the configured ranges are trusted, not echo's default private
+	// and loopback ones
+	options := []echo.TrustOption{
+		echo.TrustLoopback(false),
+		echo.TrustLinkLocal(false),
+		echo.TrustPrivateNet(false),
+	}
+	return echo.ExtractIPFromXFFHeader(append(options, trusted...)...)
+}
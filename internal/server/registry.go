@@ -0,0 +1,136 @@
+package server
+
+import (
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Route conflict kinds
+const (
+	ConflictDuplicate = "duplicate" // same method and path registered twice
+	ConflictAmbiguous = "ambiguous" // same shape, different param names (e.g. /users/:id vs /users/:uid)
+)
+
+// RouteInfo describes a single route registration
+type RouteInfo struct {
+	Method     string   `json:"method"`
+	Path       string   `json:"path"`
+	Handler    string   `json:"handler"`
+	Middleware []string `json:"middleware,omitempty"`
+}
+
+// RouteConflict describes a registration that collides with an earlier one
+type RouteConflict struct {
+	Kind     string    `json:"kind"`
+	Existing RouteInfo `json:"existing"`
+	Incoming RouteInfo `json:"incoming"`
+}
+
+// RouteRegistry records every route added to Echo so that registrations
+// spread across main.go and routes.go can be inspected in one place
+type RouteRegistry struct {
+	mu        sync.RWMutex
+	routes    []RouteInfo
+	conflicts []RouteConflict
+	index     map[string]int // method + normalized path -> index in routes
+}
+
+// NewRouteRegistry creates a new route registry
+func NewRouteRegistry() *RouteRegistry {
+	return &RouteRegistry{
+		index: make(map[string]int),
+	}
+}
+
+// OnAdd records a route registration. It matches the signature of
+// echo.Echo.OnAddRouteHandler.
+func (r *RouteRegistry) OnAdd(host string, route echo.Route, handler echo.HandlerFunc, middleware []echo.MiddlewareFunc) {
+	info := RouteInfo{
+		Method:  route.Method,
+		Path:    route.Path,
+		Handler: route.Name,
+	}
+	for _, m := range middleware {
+		info.Middleware = append(info.Middleware, funcName(m))
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.routes = append(r.routes, info)
+
+	// Catch-all 404 routes are registered by every group.Use call and are
+	// expected to overlap, so they are not checked for conflicts
+	if route.Method == echo.RouteNotFound {
+		return
+	}
+
+	key := host + " " + route.Method + " " + normalizeRoutePath(route.Path)
+	if i, exists := r.index[key]; exists {
+		kind := ConflictAmbiguous
+		if r.routes[i].Path == route.Path {
+			kind = ConflictDuplicate
+		}
+		r.conflicts = append(r.conflicts, RouteConflict{
+			Kind:     kind,
+			Existing: r.routes[i],
+			Incoming: info,
+		})
+	}
+	r.index[key] = len(r.routes) - 1
+}
+
+// Routes returns all recorded route registrations in registration order
+func (r *RouteRegistry) Routes() []RouteInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	routes := make([]RouteInfo, len(r.routes))
+	copy(routes, r.routes)
+	return routes
+}
+
+// Has reports whether a route is registered for method and path on the
+// default host. Path parameter names are ignored.
+func (r *RouteRegistry) Has(method, path string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	_, ok := r.index[" "+method+" "+normalizeRoutePath(path)]
+	return ok
+}
+
+// Conflicts returns all detected duplicate or conflicting registrations
+func (r *RouteRegistry) Conflicts() []RouteConflict {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	conflicts := make([]RouteConflict, len(r.conflicts))
+	copy(conflicts, r.conflicts)
+	return conflicts
+}
+
+// normalizeRoutePath replaces path parameter names so that routes Echo
+// would treat as the same node compare equal
+func normalizeRoutePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") {
+			segments[i] = ":"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// funcName returns the fully qualified name of a function value
+func funcName(fn interface{}) string {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func {
+		return v.Type().String()
+	}
+	return runtime.FuncForPC(v.Pointer()).Name()
+}
@@ -1,9 +1,12 @@
 package server
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/pkg/otel"
+	"github.com/pixperk/goiler/pkg/response"
 	echoSwagger "github.com/swaggo/echo-swagger"
 )
 
@@ -13,6 +16,9 @@ func (s *Server) SetupRoutes() {
 	s.echo.GET("/health", s.healthCheck)
 	s.echo.GET("/ready", s.readyCheck)
 
+	// Prometheus metrics
+	s.echo.GET("/metrics", otel.MetricsHandler())
+
 	// Swagger docs (only in development)
 	if s.config.App.Env == "development" {
 		s.echo.GET("/swagger/*", echoSwagger.WrapHandler)
@@ -22,11 +28,15 @@ func (s *Server) SetupRoutes() {
 	v1 := s.echo.Group("/api/v1")
 
 	// Apply rate limiting to API routes
-	rateLimiter := NewRateLimiter(RateLimiterConfig{
-		Requests: s.config.RateLimit.Requests,
-		Duration: s.config.RateLimit.Duration,
+	s.rateLimiter = NewRateLimiter(RateLimiterConfig{
+		Name:        "api",
+		Requests:    s.config.RateLimit.Requests,
+		Duration:    s.config.RateLimit.Duration,
+		MaxVisitors: s.config.RateLimit.MaxVisitors,
+		IdleTimeout: s.config.RateLimit.VisitorIdleTimeout,
 	})
-	v1.Use(rateLimiter.Middleware())
+	s.OnShutdown(func(context.Context) { s.rateLimiter.Stop() })
+	v1.Use(s.rateLimiter.Middleware())
 
 	// Public routes (no auth required)
 	public := v1.Group("")
@@ -37,56 +47,128 @@ func (s *Server) SetupRoutes() {
 	// protected.Use(AuthMiddleware(tokenValidator))
 
 	// Example route groups:
-	// s.setupAuthRoutes(public)
-	// s.setupUserRoutes(protected)
-	// s.setupWebSocketRoutes(v1)
+	// s.RegisterAuthRoutes(public, authHandler)
+	// s.RegisterUserRoutes(v1, userHandler, authMiddleware)
+	// s.RegisterWebSocketRoutes(v1, wsHandler)
+	// s.Mount(protected, ticketModule, orgModule)
 }
 
-// RegisterAuthRoutes registers auth-related routes
-func (s *Server) RegisterAuthRoutes(group *echo.Group, handler interface{}) {
-	// Type assert handler to auth handler interface
-	type AuthHandler interface {
-		Register(c echo.Context) error
-		Login(c echo.Context) error
-		RefreshToken(c echo.Context) error
-		Logout(c echo.Context) error
-	}
+// RouteModule is a feature's routes. Mount registers them on a group that
+// already carries the middleware they share, such as authentication.
+type RouteModule interface {
+	Mount(g *echo.Group)
+}
 
-	if h, ok := handler.(AuthHandler); ok {
-		group.POST("/auth/register", h.Register)
-		group.POST("/auth/login", h.Login)
-		group.POST("/auth/refresh", h.RefreshToken)
-		group.POST("/auth/logout", h.Logout)
-	}
+// RouteModuleFunc adapts a function to RouteModule
+type RouteModuleFunc func(g *echo.Group)
+
+// Mount calls f(g)
+func (f RouteModuleFunc) Mount(g *echo.Group) {
+	f(g)
 }
 
-// RegisterUserRoutes registers user-related routes
-func (s *Server) RegisterUserRoutes(group *echo.Group, handler interface{}, authMiddleware echo.MiddlewareFunc) {
-	type UserHandler interface {
-		GetProfile(c echo.Context) error
-		UpdateProfile(c echo.Context) error
-		ChangePassword(c echo.Context) error
-		DeleteAccount(c echo.Context) error
+// Mount registers each module's routes on group, in order
+func (s *Server) Mount(group *echo.Group, modules ...RouteModule) {
+	for _, m := range modules {
+		m.Mount(group)
 	}
+}
 
-	if h, ok := handler.(UserHandler); ok {
-		users := group.Group("/users", authMiddleware)
-		users.GET("/me", h.GetProfile)
-		users.PUT("/me", h.UpdateProfile)
-		users.PUT("/me/password", h.ChangePassword)
-		users.DELETE("/me", h.DeleteAccount)
-	}
+// AuthRoutes are the handlers RegisterAuthRoutes mounts. *auth.Handler
+// satisfies it; a handler whose methods drift fails to compile rather than
+// silently losing its routes.
+type AuthRoutes interface {
+	Register(c echo.Context) error
+	Login(c echo.Context) error
+	RefreshToken(c echo.Context) error
+	Logout(c echo.Context) error
 }
 
-// RegisterWebSocketRoutes registers WebSocket routes
-func (s *Server) RegisterWebSocketRoutes(group *echo.Group, handler interface{}) {
-	type WSHandler interface {
-		HandleConnection(c echo.Context) error
-	}
+// RegisterAuthRoutes registers auth-related routes
+func (s *Server) RegisterAuthRoutes(group *echo.Group, handler AuthRoutes) {
+	group.POST("/auth/register", handler.Register)
+	group.POST("/auth/login", handler.Login)
+	group.POST("/auth/refresh", handler.RefreshToken)
+	group.POST("/auth/logout", handler.Logout)
+}
+
+// UserRoutes are the handlers RegisterUserRoutes mounts. *user.Handler
+// satisfies it.
+type UserRoutes interface {
+	GetProfile(c echo.Context) error
+	UpdateProfile(c echo.Context) error
+	ChangePassword(c echo.Context) error
+	DeleteAccount(c echo.Context) error
+}
+
+// RegisterUserRoutes registers user-related routes behind authMiddleware
+func (s *Server) RegisterUserRoutes(group *echo.Group, handler UserRoutes, authMiddleware echo.MiddlewareFunc) {
+	users := group.Group("/users", authMiddleware)
+	users.GET("/me", handler.GetProfile)
+	users.PUT("/me", handler.UpdateProfile)
+	users.PUT("/me/password", handler.ChangePassword)
+	users.DELETE("/me", handler.DeleteAccount)
+}
+
+// WebSocketRoutes are the handlers RegisterWebSocketRoutes mounts.
+// *websocket.Handler satisfies it.
+type WebSocketRoutes interface {
+	HandleConnection(c echo.Context) error
+	HandleEvents(c echo.Context) error
+}
+
+// RegisterWebSocketRoutes registers WebSocket routes and the event stream
+// fallback. Mounted on another group than /api/v1, the stream's route must
+// be added to eventStreamRoutes to be exempt from the request deadline.
+func (s *Server) RegisterWebSocketRoutes(group *echo.Group, handler WebSocketRoutes) {
+	group.GET("/ws", handler.HandleConnection)
+	group.GET("/events", handler.HandleEvents)
+}
+
+// RegisterAdminRoutes registers admin-only routes. The group is expected to
+// carry authentication middleware; canRead and canWrite guard the routes
+// that inspect and change the server.
+func (s *Server) RegisterAdminRoutes(group *echo.Group, canRead, canWrite echo.MiddlewareFunc) {
+	group.GET("/routes", s.listRoutes, canRead)
+	group.GET("/read-only", s.getReadOnly, canRead)
+	group.PUT("/read-only", s.setReadOnly, canWrite)
+	group.GET("/deprecations", s.listDeprecations, canRead)
+	group.GET("/rate-limit", s.getRateLimitStats, canRead)
+}
 
-	if h, ok := handler.(WSHandler); ok {
-		group.GET("/ws", h.HandleConnection)
+// listRoutes returns all registered routes and detected conflicts
+// @Summary List routes
+// @Description Returns all registered routes with their handlers, middleware, and conflicting registrations (admin only)
+// @Tags Admin
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Router /api/v1/admin/routes [get]
+func (s *Server) listRoutes(c echo.Context) error {
+	return response.Success(c, map[string]interface{}{
+		"routes":    s.routes.Routes(),
+		"conflicts": s.routes.Conflicts(),
+	})
+}
+
+// getRateLimitStats reports the in-memory rate limiter's state
+// @Summary Rate limiter stats
+// @Description Returns the visitors tracked by the API rate limiter on this instance and its request and eviction counts (admin only)
+// @Tags Admin
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} RateLimiterStats
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 404 {object} response.Response "Routes not set up"
+// @Router /api/v1/admin/rate-limit [get]
+func (s *Server) getRateLimitStats(c echo.Context) error {
+	if s.rateLimiter == nil {
+		return response.NotFound(c, "Rate limiting is not set up")
 	}
+	return response.Success(c, s.rateLimiter.Stats())
 }
 
 // healthCheck returns the health status
@@ -109,8 +191,15 @@ func (s *Server) healthCheck(c echo.Context) error {
 // @Tags Health
 // @Produce json
 // @Success 200 {object} map[string]string
+// @Failure 503 {object} map[string]string
 // @Router /ready [get]
 func (s *Server) readyCheck(c echo.Context) error {
+	if s.Draining() {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{
+			"status": "draining",
+		})
+	}
+
 	// TODO: Add actual readiness checks (DB connection, Redis, etc.)
 	return c.JSON(http.StatusOK, map[string]string{
 		"status": "ready",
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/internal/config"
+)
+
+type stubAuthRoutes struct{}
+
+func (stubAuthRoutes) Register(c echo.Context) error     { return c.NoContent(http.StatusCreated) }
+func (stubAuthRoutes) Login(c echo.Context) error        { return c.NoContent(http.StatusOK) }
+func (stubAuthRoutes) RefreshToken(c echo.Context) error { return c.NoContent(http.StatusOK) }
+func (stubAuthRoutes) Logout(c echo.Context) error       { return c.NoContent(http.StatusNoContent) }
+
+func TestMountAndRegister(t *testing.T) {
+	s := New(&config.Config{}, slog.Default())
+	v1 := s.Echo().Group("/api/v1")
+
+	s.RegisterAuthRoutes(v1, stubAuthRoutes{})
+	s.Mount(v1, RouteModuleFunc(func(g *echo.Group) {
+		g.GET("/things", func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+	}))
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{http.MethodPost, "/api/v1/auth/register", http.StatusCreated},
+		{http.MethodPost, "/api/v1/auth/logout", http.StatusNoContent},
+		{http.MethodGet, "/api/v1/things", http.StatusOK},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		s.Echo().ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+		if rec.Code != tt.status {
+			t.Errorf("%s %s: expected %d, got %d", tt.method, tt.path, tt.status, rec.Code)
+		}
+	}
+	if !s.routes.Has(http.MethodPost, "/api/v1/auth/refresh") {
+		t.Error("Expected the refresh route to be registered")
+	}
+}
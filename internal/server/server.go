@@ -6,12 +6,18 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/pixperk/goiler/internal/config"
+	"github.com/pixperk/goiler/pkg/requestid"
+	"github.com/pixperk/goiler/pkg/response"
+	"github.com/pixperk/goiler/pkg/sandbox"
 	"github.com/pixperk/goiler/pkg/validator"
 )
 
@@ -20,8 +26,24 @@ type Server struct {
 	echo   *echo.Echo
 	config *config.Config
 	logger *slog.Logger
+	routes *RouteRegistry
+	json   *response.JSONSerializer
+
+	readOnly     *ReadOnlySwitch
+	deprecations *DeprecationTracker
+	rateLimiter  *RateLimiter
+
+	draining   atomic.Bool
+	onShutdown []func(ctx context.Context)
+
+	// active counts requests in flight, not counting WebSocket connections
+	active atomic.Int64
 }
 
+// readOnlyExempt lists path prefixes that stay writable in read-only mode:
+// auth, so users can still log in, and the toggle itself
+var readOnlyExempt = []string{"/api/v1/auth/", "/api/v1/admin/read-only"}
+
 // New creates a new server instance
 func New(cfg *config.Config, logger *slog.Logger) *Server {
 	e := echo.New()
@@ -31,51 +53,50 @@ func New(cfg *config.Config, logger *slog.Logger) *Server {
 	// Set custom validator
 	e.Validator = validator.New()
 
-	// Set custom error handler
-	e.HTTPErrorHandler = customErrorHandler(logger)
+	// Read client IPs past trusted proxies only
+	e.IPExtractor = ipExtractor(cfg.App.TrustedProxies, logger)
+
+	// Set JSON serializer (field casing and time format)
+	serializer := response.NewJSONSerializer(response.JSONOptions{
+		FieldCase:  cfg.JSON.FieldCase,
+		TimeFormat: cfg.JSON.TimeFormat,
+	})
+	e.JSONSerializer = serializer
+
+	// Record route registrations for inspection
+	routes := NewRouteRegistry()
+	e.OnAddRouteHandler = routes.OnAdd
+
+	// Set custom error handler, answering unmatched routes in the standard
+	// error format
+	e.HTTPErrorHandler = customErrorHandler(logger, newRouteFallback(logger, routes))
 
 	return &Server{
 		echo:   e,
 		config: cfg,
 		logger: logger,
+		routes: routes,
+		json:   serializer,
+
+		readOnly:     NewReadOnlySwitch(cfg.App.ReadOnly, cfg.App.ReadOnlyMessage, readOnlyExempt...),
+		deprecations: NewDeprecationTracker(),
 	}
 }
 
 // SetupMiddleware configures all middleware
 func (s *Server) SetupMiddleware() {
-	// Request ID
+	// Request ID, also carried in the request context for enqueued tasks
 	s.echo.Use(middleware.RequestID())
+	s.echo.Use(requestid.Middleware())
+
+	// Requests in flight, for ActiveRequests
+	s.echo.Use(s.countActive)
+
+	// Request deadline
+	s.echo.Use(TimeoutMiddleware(s.config.App.RequestTimeout))
 
 	// Logger
-	s.echo.Use(middleware.RequestLoggerWithConfig(middleware.RequestLoggerConfig{
-		LogStatus:   true,
-		LogURI:      true,
-		LogError:    true,
-		LogMethod:   true,
-		LogLatency:  true,
-		HandleError: true,
-		LogValuesFunc: func(c echo.Context, v middleware.RequestLoggerValues) error {
-			if v.Error == nil {
-				s.logger.Info("request",
-					slog.String("method", v.Method),
-					slog.String("uri", v.URI),
-					slog.Int("status", v.Status),
-					slog.Duration("latency", v.Latency),
-					slog.String("request_id", c.Response().Header().Get(echo.HeaderXRequestID)),
-				)
-			} else {
-				s.logger.Error("request error",
-					slog.String("method", v.Method),
-					slog.String("uri", v.URI),
-					slog.Int("status", v.Status),
-					slog.Duration("latency", v.Latency),
-					slog.String("error", v.Error.Error()),
-					slog.String("request_id", c.Response().Header().Get(echo.HeaderXRequestID)),
-				)
-			}
-			return nil
-		},
-	}))
+	s.echo.Use(newAccessLogger(s.config.Log.Access, s.logger).Middleware())
 
 	// Recover
 	s.echo.Use(middleware.RecoverWithConfig(middleware.RecoverConfig{
@@ -90,13 +111,29 @@ func (s *Server) SetupMiddleware() {
 	}))
 
 	// CORS
-	s.echo.Use(middleware.CORSWithConfig(middleware.CORSConfig{
-		AllowOrigins:     []string{"*"},
-		AllowMethods:     []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions},
-		AllowHeaders:     []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization},
-		AllowCredentials: true,
-		MaxAge:           86400,
-	}))
+	allowHeaders := []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization, response.EnvelopeHeader}
+	if s.config.Auth.Mode == "cookie" {
+		allowHeaders = append(allowHeaders, s.config.Auth.Session.CSRFHeader)
+	}
+	if s.config.Tenancy.Enabled && s.config.Tenancy.Header != "" {
+		allowHeaders = append(allowHeaders, s.config.Tenancy.Header)
+	}
+	exposeHeaders := slices.Concat(response.PaginationHeaders, []string{response.HeaderSuccess}, DeprecationHeaders)
+	if s.config.Tenancy.Sandbox {
+		allowHeaders = append(allowHeaders, sandbox.Header)
+		exposeHeaders = append(exposeHeaders, sandbox.Header)
+	}
+	// Credentials are only sent to the origins named, never to any
+	if origins := s.config.App.CORSOrigins; len(origins) > 0 {
+		s.echo.Use(middleware.CORSWithConfig(middleware.CORSConfig{
+			AllowOrigins:     origins,
+			AllowMethods:     []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions},
+			AllowHeaders:     allowHeaders,
+			ExposeHeaders:    exposeHeaders,
+			AllowCredentials: !slices.Contains(origins, "*"),
+			MaxAge:           86400,
+		}))
+	}
 
 	// Secure headers
 	s.echo.Use(middleware.SecureWithConfig(middleware.SecureConfig{
@@ -107,27 +144,123 @@ func (s *Server) SetupMiddleware() {
 		ContentSecurityPolicy: "default-src 'self'",
 	}))
 
+	// Read-only mode
+	s.echo.Use(s.readOnly.Middleware())
+
 	// Body limit
 	s.echo.Use(middleware.BodyLimit("2M"))
 
-	// Gzip compression
+	// Gzip compression; event streams are flushed event by event
 	s.echo.Use(middleware.GzipWithConfig(middleware.GzipConfig{
-		Level: 5,
+		Skipper: isEventStream,
+		Level:   5,
 	}))
 }
 
+// countActive counts requests in flight. WebSocket connections and event
+// streams aren't counted, as they stay open for the life of the connection.
+func (s *Server) countActive(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if c.IsWebSocket() || isEventStream(c) {
+			return next(c)
+		}
+		s.active.Add(1)
+		defer s.active.Add(-1)
+		return next(c)
+	}
+}
+
+// ActiveRequests returns how many HTTP requests are in flight
+func (s *Server) ActiveRequests() int64 {
+	return s.active.Load()
+}
+
 // Echo returns the underlying echo instance
 func (s *Server) Echo() *echo.Echo {
 	return s.echo
 }
 
-// Start starts the server with graceful shutdown
+// SetPermissions sets how the JSON serializer checks the permissions of
+// response fields restricted with `visible` tags. Restricted fields are
+// hidden from everyone until it's set.
+func (s *Server) SetPermissions(can response.PermissionFunc) {
+	s.json.SetPermissions(can)
+}
+
+// ReadOnly returns the read-only switch
+func (s *Server) ReadOnly() *ReadOnlySwitch {
+	return s.readOnly
+}
+
+// Deprecations returns the deprecation tracker, whose Middleware marks
+// routes deprecated
+func (s *Server) Deprecations() *DeprecationTracker {
+	return s.deprecations
+}
+
+// Routes returns the route registry
+func (s *Server) Routes() *RouteRegistry {
+	return s.routes
+}
+
+// LogRoutes logs all registered routes and warns about conflicting registrations
+func (s *Server) LogRoutes() {
+	for _, route := range s.routes.Routes() {
+		if route.Method == echo.RouteNotFound {
+			continue
+		}
+		s.logger.Info("route registered",
+			slog.String("method", route.Method),
+			slog.String("path", route.Path),
+			slog.String("handler", route.Handler),
+			slog.Any("middleware", route.Middleware),
+		)
+	}
+
+	for _, conflict := range s.routes.Conflicts() {
+		s.logger.Warn("conflicting route registration",
+			slog.String("kind", conflict.Kind),
+			slog.String("method", conflict.Incoming.Method),
+			slog.String("path", conflict.Incoming.Path),
+			slog.String("existing_path", conflict.Existing.Path),
+			slog.String("existing_handler", conflict.Existing.Handler),
+			slog.String("handler", conflict.Incoming.Handler),
+		)
+	}
+}
+
+// OnShutdown registers fn to run when the server shuts down, alongside
+// draining in-flight requests. fn should return once its work is done or ctx
+// expires, e.g. the WebSocket hub's Shutdown.
+func (s *Server) OnShutdown(fn func(ctx context.Context)) {
+	s.onShutdown = append(s.onShutdown, fn)
+}
+
+// Draining reports whether the server is shutting down
+func (s *Server) Draining() bool {
+	return s.draining.Load()
+}
+
+// Start starts the server with graceful shutdown. The listener is a socket
+// passed by systemd if there is one, so restarts never refuse connections;
+// with APP_REUSE_PORT a new process can also bind the port before this one
+// stops.
 func (s *Server) Start() error {
+	s.LogRoutes()
+
+	ln, source, err := s.listen()
+	if err != nil {
+		return err
+	}
+	s.echo.Listener = ln
+
 	// Start server in goroutine
 	go func() {
-		addr := ":" + s.config.App.Port
-		s.logger.Info("starting server", slog.String("addr", addr))
-		if err := s.echo.Start(addr); err != nil && err != http.ErrServerClosed {
+		s.logger.Info("starting server",
+			slog.String("addr", ln.Addr().String()),
+			slog.String("listener", source),
+		)
+		if err := s.echo.Start(""); err != nil && err != http.ErrServerClosed {
 			s.logger.Error("server error", slog.String("error", err.Error()))
 		}
 	}()
@@ -137,13 +270,33 @@ func (s *Server) Start() error {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
+	// Fail readiness first so load balancers stop routing here while the
+	// listener still accepts
+	s.draining.Store(true)
+	if delay := s.config.App.ShutdownDelay; delay > 0 {
+		s.logger.Info("draining before shutdown", slog.Duration("delay", delay))
+		time.Sleep(delay)
+	}
+
 	s.logger.Info("shutting down server...")
 
 	// Graceful shutdown with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.App.ShutdownTimeout)
 	defer cancel()
 
-	if err := s.echo.Shutdown(ctx); err != nil {
+	// Hijacked connections such as WebSockets aren't tracked by Shutdown, so
+	// the hooks close them while requests drain
+	var wg sync.WaitGroup
+	for _, fn := range s.onShutdown {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn(ctx)
+		}()
+	}
+	err = s.echo.Shutdown(ctx)
+	wg.Wait()
+	if err != nil {
 		return err
 	}
 
@@ -152,11 +305,14 @@ func (s *Server) Start() error {
 }
 
 // customErrorHandler returns a custom error handler
-func customErrorHandler(logger *slog.Logger) echo.HTTPErrorHandler {
+func customErrorHandler(logger *slog.Logger, fallback *routeFallback) echo.HTTPErrorHandler {
 	return func(err error, c echo.Context) {
 		if c.Response().Committed {
 			return
 		}
+		if fallback.handle(err, c) {
+			return
+		}
 
 		code := http.StatusInternalServerError
 		message := "Internal server error"
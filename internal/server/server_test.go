@@ -0,0 +1,81 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/internal/config"
+	"github.com/pixperk/goiler/internal/testutil"
+)
+
+func TestServer_CORS(t *testing.T) {
+	tests := []struct {
+		name        string
+		origins     []string
+		origin      string
+		allow       string
+		credentials string
+	}{
+		{"no origins", nil, "https://evil.example.com", "", ""},
+		{"listed origin", []string{"https://app.example.com"}, "https://app.example.com", "https://app.example.com", "true"},
+		{"unlisted origin", []string{"https://app.example.com"}, "https://evil.example.com", "", ""},
+		{"any origin", []string{"*"}, "https://evil.example.com", "*", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New(&config.Config{App: config.AppConfig{CORSOrigins: tt.origins}}, testutil.Logger())
+			s.SetupMiddleware()
+			s.Echo().GET("/me", func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+
+			req := httptest.NewRequest(http.MethodGet, "/me", nil)
+			req.Header.Set(echo.HeaderOrigin, tt.origin)
+			rec := httptest.NewRecorder()
+			s.Echo().ServeHTTP(rec, req)
+
+			if got := rec.Header().Get(echo.HeaderAccessControlAllowOrigin); got != tt.allow {
+				t.Errorf("Expected Access-Control-Allow-Origin %q, got %q", tt.allow, got)
+			}
+			if got := rec.Header().Get(echo.HeaderAccessControlAllowCredentials); got != tt.credentials {
+				t.Errorf("Expected Access-Control-Allow-Credentials %q, got %q", tt.credentials, got)
+			}
+		})
+	}
+}
+
+func TestServer_TrustedProxies(t *testing.T) {
+	tests := []struct {
+		name    string
+		proxies []string
+		remote  string
+		xff     string
+		want    string
+	}{
+		{"no proxies ignore the header", nil, "10.0.0.5:1234", "203.0.113.7", "10.0.0.5"},
+		{"trusted range", []string{"10.0.0.0/8"}, "10.0.0.5:1234", "203.0.113.7", "203.0.113.7"},
+		{"trusted address", []string{"10.0.0.5"}, "10.0.0.5:1234", "203.0.113.7", "203.0.113.7"},
+		{"spoofed hop", []string{"10.0.0.0/8"}, "10.0.0.5:1234", "198.51.100.1, 203.0.113.7", "203.0.113.7"},
+		{"untrusted peer", []string{"10.0.0.0/8"}, "192.0.2.9:1234", "203.0.113.7", "192.0.2.9"},
+		{"invalid entries skipped", []string{"not-an-ip"}, "10.0.0.5:1234", "203.0.113.7", "10.0.0.5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New(&config.Config{App: config.AppConfig{TrustedProxies: tt.proxies}}, testutil.Logger())
+			var got string
+			s.Echo().GET("/ip", func(c echo.Context) error {
+				got = c.RealIP()
+				return c.NoContent(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/ip", nil)
+			req.RemoteAddr = tt.remote
+			req.Header.Set(echo.HeaderXForwardedFor, tt.xff)
+			s.Echo().ServeHTTP(httptest.NewRecorder(), req)
+
+			if got != tt.want {
+				t.Errorf("Expected client IP %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
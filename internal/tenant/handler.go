@@ -0,0 +1,93 @@
+package tenant
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/pkg/response"
+	"github.com/pixperk/goiler/pkg/validator"
+)
+
+// Handler handles HTTP requests for tenants
+type Handler struct {
+	service *Service
+	logger  *slog.Logger
+}
+
+// NewHandler creates a new tenant handler
+func NewHandler(service *Service, logger *slog.Logger) *Handler {
+	return &Handler{service: service, logger: logger}
+}
+
+// Current returns the tenant the request resolved to
+// @Summary Get current tenant
+// @Description Return the tenant selected by the tenant header or subdomain, or the default tenant
+// @Tags Tenants
+// @Produce json
+// @Success 200 {object} Tenant
+// @Failure 404 {object} response.Response
+// @Router /api/v1/tenant [get]
+func (h *Handler) Current(c echo.Context) error {
+	t, ok := FromContext(c.Request().Context())
+	if !ok {
+		t = Default()
+	}
+	return response.Success(c, t)
+}
+
+// Create adds a tenant
+// @Summary Create tenant
+// @Description Create a tenant, or with sandbox_of the sandbox of a live tenant. Its slug selects it in the tenant header or as a subdomain (admin only).
+// @Tags Admin
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body CreateTenantRequest true "Tenant"
+// @Success 201 {object} Tenant
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 409 {object} response.Response
+// @Failure 422 {object} response.Response
+// @Router /api/v1/admin/tenants [post]
+func (h *Handler) Create(c echo.Context) error {
+	var req CreateTenantRequest
+	if err := c.Bind(&req); err != nil {
+		return response.BadRequest(c, "Invalid request body")
+	}
+
+	if err := c.Validate(&req); err != nil {
+		return response.ValidationError(c, validator.FormatErrors(err))
+	}
+
+	t, err := h.service.Create(c.Request().Context(), &req)
+	if err != nil {
+		if errors.Is(err, ErrTenantExists) {
+			return response.Conflict(c, "A tenant with this slug, or a sandbox of this tenant, already exists")
+		}
+		if errors.Is(err, ErrInvalidSandbox) {
+			return response.Error(c, http.StatusUnprocessableEntity, ErrCodeInvalidSandbox, "Sandboxes must belong to an existing live tenant")
+		}
+		return response.InternalError(c, "Failed to create tenant")
+	}
+	return response.Created(c, t)
+}
+
+// List returns every tenant
+// @Summary List tenants
+// @Description List every tenant ordered by slug (admin only)
+// @Tags Admin
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {array} Tenant
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Router /api/v1/admin/tenants [get]
+func (h *Handler) List(c echo.Context) error {
+	tenants, err := h.service.List(c.Request().Context())
+	if err != nil {
+		return response.InternalError(c, "Failed to list tenants")
+	}
+	return response.Success(c, tenants)
+}
@@ -0,0 +1,155 @@
+package tenant
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pixperk/goiler/db/sqlc"
+	"github.com/pixperk/goiler/pkg/budget"
+)
+
+// uniqueViolation is the Postgres error code for unique constraint violations
+const uniqueViolation = "23505"
+
+// Repository stores tenants
+type Repository interface {
+	Create(ctx context.Context, t *Tenant) error
+	GetByID(ctx context.Context, id uuid.UUID) (*Tenant, error)
+	GetBySlug(ctx context.Context, slug string) (*Tenant, error)
+	// GetSandbox retrieves the sandbox tenant of the tenant liveID
+	GetSandbox(ctx context.Context, liveID uuid.UUID) (*Tenant, error)
+	List(ctx context.Context) ([]*Tenant, error)
+}
+
+// PostgresRepository implements Repository using PostgreSQL
+type PostgresRepository struct {
+	queries *sqlc.Queries
+}
+
+// NewPostgresRepository creates a new PostgreSQL repository
+func NewPostgresRepository(db *pgxpool.Pool) *PostgresRepository {
+	return &PostgresRepository{queries: sqlc.New(db)}
+}
+
+// Create stores a new tenant
+func (r *PostgresRepository) Create(ctx context.Context, t *Tenant) error {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	err = r.queries.CreateTenant(ctx, sqlc.CreateTenantParams{
+		ID:        t.ID,
+		Slug:      t.Slug,
+		Name:      t.Name,
+		SandboxOf: uuidParam(t.SandboxOf),
+		CreatedAt: sql.NullTime{Time: t.CreatedAt, Valid: true},
+	})
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		return ErrTenantExists
+	}
+	return err
+}
+
+// GetByID retrieves a tenant by ID
+func (r *PostgresRepository) GetByID(ctx context.Context, id uuid.UUID) (*Tenant, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	dbTenant, err := r.queries.GetTenant(ctx, id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrTenantNotFound
+		}
+		return nil, err
+	}
+	return tenantFromDB(dbTenant), nil
+}
+
+// GetBySlug retrieves a tenant by slug
+func (r *PostgresRepository) GetBySlug(ctx context.Context, slug string) (*Tenant, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	dbTenant, err := r.queries.GetTenantBySlug(ctx, slug)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrTenantNotFound
+		}
+		return nil, err
+	}
+	return tenantFromDB(dbTenant), nil
+}
+
+// GetSandbox retrieves the sandbox tenant of the tenant liveID
+func (r *PostgresRepository) GetSandbox(ctx context.Context, liveID uuid.UUID) (*Tenant, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	dbTenant, err := r.queries.GetSandboxTenant(ctx, pgtype.UUID{Bytes: liveID, Valid: true})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrTenantNotFound
+		}
+		return nil, err
+	}
+	return tenantFromDB(dbTenant), nil
+}
+
+// List returns every tenant
+func (r *PostgresRepository) List(ctx context.Context) ([]*Tenant, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	dbTenants, err := r.queries.ListTenants(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tenants := make([]*Tenant, len(dbTenants))
+	for i, t := range dbTenants {
+		tenants[i] = tenantFromDB(t)
+	}
+	return tenants, nil
+}
+
+func tenantFromDB(t *sqlc.Tenant) *Tenant {
+	tenant := &Tenant{
+		ID:        t.ID,
+		Slug:      t.Slug,
+		Name:      t.Name,
+		CreatedAt: t.CreatedAt.Time,
+	}
+	if t.SandboxOf.Valid {
+		live := uuid.UUID(t.SandboxOf.Bytes)
+		tenant.SandboxOf = &live
+	}
+	return tenant
+}
+
+// uuidParam converts an optional ID to a nullable query parameter
+func uuidParam(id *uuid.UUID) pgtype.UUID {
+	if id == nil {
+		return pgtype.UUID{}
+	}
+	return pgtype.UUID{Bytes: *id, Valid: true}
+}
@@ -0,0 +1,105 @@
+package tenant
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/internal/config"
+	"github.com/pixperk/goiler/pkg/response"
+)
+
+// Error codes returned by the resolver
+const (
+	ErrCodeTenantNotFound = "TENANT_NOT_FOUND"
+	ErrCodeTenantRequired = "TENANT_REQUIRED"
+)
+
+// Resolver finds the tenant of each request from a header or the subdomain
+type Resolver struct {
+	service *Service
+	logger  *slog.Logger
+
+	// header names the request header carrying the tenant slug
+	header string
+	// baseDomain, if set, makes <slug>.<baseDomain> hosts select the tenant
+	baseDomain string
+	// required rejects requests that name no tenant instead of serving them
+	// as the default tenant
+	required bool
+}
+
+// NewResolver creates a resolver looking tenants up through service
+func NewResolver(service *Service, cfg config.TenancyConfig, logger *slog.Logger) *Resolver {
+	return &Resolver{
+		service:    service,
+		logger:     logger,
+		header:     cfg.Header,
+		baseDomain: strings.ToLower(strings.Trim(cfg.BaseDomain, ".")),
+		required:   cfg.Required,
+	}
+}
+
+// Middleware puts the request's tenant on the request context. The header
+// takes precedence over the subdomain. Unknown tenants are rejected with
+// 404; requests naming no tenant belong to the default tenant, as a
+// fallback, unless a tenant is required.
+func (r *Resolver) Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+
+			slug := r.Slug(req)
+			if slug == "" {
+				if r.required {
+					return response.Error(c, http.StatusBadRequest, ErrCodeTenantRequired, "Tenant is required")
+				}
+				c.SetRequest(req.WithContext(WithFallback(req.Context(), Default())))
+				return next(c)
+			}
+
+			t, err := r.service.BySlug(req.Context(), slug)
+			if err != nil {
+				if errors.Is(err, ErrTenantNotFound) {
+					return response.Error(c, http.StatusNotFound, ErrCodeTenantNotFound, "Tenant not found")
+				}
+				r.logger.Error("failed to resolve tenant",
+					slog.String("slug", slug),
+					slog.String("error", err.Error()),
+				)
+				return response.InternalError(c, "Failed to resolve tenant")
+			}
+
+			c.SetRequest(req.WithContext(WithTenant(req.Context(), t)))
+			return next(c)
+		}
+	}
+}
+
+// Slug returns the tenant slug a request names, or "" if it names none
+func (r *Resolver) Slug(req *http.Request) string {
+	if r.header != "" {
+		if slug := strings.ToLower(strings.TrimSpace(req.Header.Get(r.header))); slug != "" {
+			return slug
+		}
+	}
+	if r.baseDomain == "" {
+		return ""
+	}
+
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	label, ok := strings.CutSuffix(host, "."+r.baseDomain)
+	// Only direct subdomains name a tenant
+	if !ok || label == "" || strings.Contains(label, ".") {
+		return ""
+	}
+	return label
+}
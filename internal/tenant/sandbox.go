@@ -0,0 +1,89 @@
+package tenant
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/internal/config"
+	"github.com/pixperk/goiler/pkg/response"
+	"github.com/pixperk/goiler/pkg/sandbox"
+)
+
+// Error codes returned by the sandbox router, and for invalid sandboxes by
+// the handler
+const (
+	ErrCodeSandboxDisabled = "SANDBOX_DISABLED"
+	ErrCodeSandboxNotFound = "SANDBOX_NOT_FOUND"
+	ErrCodeInvalidSandbox  = "INVALID_SANDBOX"
+)
+
+// IsSandbox reports whether t holds another tenant's sandbox data
+func (t *Tenant) IsSandbox() bool {
+	return t.SandboxOf != nil
+}
+
+// SandboxRouter serves requests in sandbox mode from the sandbox tenant of
+// the tenant they resolved to
+type SandboxRouter struct {
+	service *Service
+	logger  *slog.Logger
+	enabled bool
+}
+
+// NewSandboxRouter creates a router looking sandbox tenants up through
+// service
+func NewSandboxRouter(service *Service, cfg config.TenancyConfig, logger *slog.Logger) *SandboxRouter {
+	return &SandboxRouter{service: service, logger: logger, enabled: cfg.Sandbox}
+}
+
+// Middleware puts requests sending a true sandbox.Header in sandbox mode,
+// scoped to their tenant's sandbox tenant, and echoes the header back. It
+// must run after the Resolver, if any. Sandbox tenants are created like any
+// other, naming their live tenant in SandboxOf; requests for a tenant without one are rejected with 404, and every
+// sandbox request with 400 while sandbox mode is off, so test traffic never
+// reaches live data. Requests resolving to a sandbox tenant directly are in
+// sandbox mode too.
+func (r *SandboxRouter) Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			ctx := req.Context()
+
+			t, ok := FromContext(ctx)
+			if !ok {
+				t = Default()
+			}
+
+			if !sandbox.Requested(req) {
+				if t.IsSandbox() {
+					c.SetRequest(req.WithContext(sandbox.With(ctx)))
+				}
+				return next(c)
+			}
+			if !r.enabled {
+				return response.Error(c, http.StatusBadRequest, ErrCodeSandboxDisabled, "Sandbox mode is not enabled")
+			}
+
+			if !t.IsSandbox() {
+				twin, err := r.service.Sandbox(ctx, t)
+				if err != nil {
+					if errors.Is(err, ErrTenantNotFound) {
+						return response.Error(c, http.StatusNotFound, ErrCodeSandboxNotFound, "No sandbox is set up for this tenant")
+					}
+					r.logger.Error("failed to resolve sandbox tenant",
+						slog.String("tenant_id", t.ID.String()),
+						slog.String("error", err.Error()),
+					)
+					return response.InternalError(c, "Failed to resolve tenant")
+				}
+				t = twin
+			}
+
+			c.Response().Header().Set(sandbox.Header, "true")
+			c.SetRequest(req.WithContext(sandbox.With(WithTenant(ctx, t))))
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pixperk/goiler/pkg/clock"
+	"github.com/pixperk/goiler/pkg/idgen"
+)
+
+// DefaultCacheTTL is how long tenants looked up by slug are cached
+const DefaultCacheTTL = time.Minute
+
+// cachedTenant is a tenant and when its cache entry expires
+type cachedTenant struct {
+	tenant  *Tenant
+	expires time.Time
+}
+
+// Service manages tenants and resolves them by slug
+type Service struct {
+	repo     Repository
+	logger   *slog.Logger
+	clock    clock.Clock
+	ids      idgen.Generator
+	cacheTTL time.Duration
+
+	mu        sync.Mutex
+	bySlug    map[string]cachedTenant
+	sandboxes map[uuid.UUID]cachedTenant
+}
+
+// ServiceOption configures a Service
+type ServiceOption func(*Service)
+
+// WithLogger sets the logger
+func WithLogger(logger *slog.Logger) ServiceOption {
+	return func(s *Service) {
+		s.logger = logger
+	}
+}
+
+// WithClock sets the clock used for creation times and cache expiry
+func WithClock(c clock.Clock) ServiceOption {
+	return func(s *Service) {
+		s.clock = c
+	}
+}
+
+// WithIDGenerator sets the generator for tenant IDs
+func WithIDGenerator(ids idgen.Generator) ServiceOption {
+	return func(s *Service) {
+		s.ids = ids
+	}
+}
+
+// WithCacheTTL sets how long tenants looked up by slug are cached; zero
+// disables caching. Unknown slugs are never cached, so a tenant created on
+// another instance resolves immediately.
+func WithCacheTTL(ttl time.Duration) ServiceOption {
+	return func(s *Service) {
+		s.cacheTTL = ttl
+	}
+}
+
+// NewService creates a new tenant service
+func NewService(repo Repository, opts ...ServiceOption) *Service {
+	s := &Service{
+		repo:      repo,
+		logger:    slog.Default(),
+		cacheTTL:  DefaultCacheTTL,
+		bySlug:    make(map[string]cachedTenant),
+		sandboxes: make(map[uuid.UUID]cachedTenant),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.clock = clock.OrReal(s.clock)
+	s.ids = idgen.OrDefault(s.ids)
+	return s
+}
+
+// CreateTenantRequest represents a new tenant
+type CreateTenantRequest struct {
+	Slug string `json:"slug" validate:"required,max=63,slug"`
+	Name string `json:"name" validate:"required,max=255"`
+	// SandboxOf makes the tenant the sandbox of another, live tenant, which
+	// may have one
+	SandboxOf *uuid.UUID `json:"sandbox_of,omitempty"`
+}
+
+// Create adds a tenant
+func (s *Service) Create(ctx context.Context, req *CreateTenantRequest) (*Tenant, error) {
+	if req.SandboxOf != nil {
+		live, err := s.repo.GetByID(ctx, *req.SandboxOf)
+		if errors.Is(err, ErrTenantNotFound) {
+			return nil, ErrInvalidSandbox
+		}
+		if err != nil {
+			return nil, err
+		}
+		if live.IsSandbox() {
+			return nil, ErrInvalidSandbox
+		}
+	}
+
+	t := &Tenant{
+		ID:        s.ids.NewID(),
+		Slug:      req.Slug,
+		Name:      req.Name,
+		CreatedAt: s.clock.Now(),
+		SandboxOf: req.SandboxOf,
+	}
+	if err := s.repo.Create(ctx, t); err != nil {
+		return nil, err
+	}
+
+	attrs := []any{
+		slog.String("tenant_id", t.ID.String()),
+		slog.String("slug", t.Slug),
+	}
+	if t.SandboxOf != nil {
+		attrs = append(attrs, slog.String("sandbox_of", t.SandboxOf.String()))
+	}
+	s.logger.InfoContext(ctx, "tenant created", attrs...)
+	return t, nil
+}
+
+// List returns every tenant ordered by slug
+func (s *Service) List(ctx context.Context) ([]*Tenant, error) {
+	return s.repo.List(ctx)
+}
+
+// BySlug returns the tenant with the given slug, cached for the cache TTL
+func (s *Service) BySlug(ctx context.Context, slug string) (*Tenant, error) {
+	now := s.clock.Now()
+
+	s.mu.Lock()
+	cached, ok := s.bySlug[slug]
+	s.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.tenant, nil
+	}
+
+	t, err := s.repo.GetBySlug(ctx, slug)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.cacheTTL > 0 {
+		s.mu.Lock()
+		s.bySlug[slug] = cachedTenant{tenant: t, expires: now.Add(s.cacheTTL)}
+		s.mu.Unlock()
+	}
+	return t, nil
+}
+
+// Sandbox returns the sandbox tenant of live, cached for the cache TTL
+func (s *Service) Sandbox(ctx context.Context, live *Tenant) (*Tenant, error) {
+	now := s.clock.Now()
+
+	s.mu.Lock()
+	cached, ok := s.sandboxes[live.ID]
+	s.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.tenant, nil
+	}
+
+	t, err := s.repo.GetSandbox(ctx, live.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.cacheTTL > 0 {
+		s.mu.Lock()
+		s.sandboxes[live.ID] = cachedTenant{tenant: t, expires: now.Add(s.cacheTTL)}
+		s.mu.Unlock()
+	}
+	return t, nil
+}
@@ -0,0 +1,128 @@
+// Package tenant partitions users into tenants. Resolver puts the tenant of
+// each request on its context, from a header or subdomain, and repositories
+// scope their queries to ID(ctx). Contexts without a tenant, such as
+// requests while tenancy is off, belong to the default tenant.
+package tenant
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+var (
+	ErrTenantNotFound = errors.New("tenant not found")
+	ErrTenantExists   = errors.New("tenant already exists")
+	// ErrInvalidSandbox is returned for sandboxes of unknown tenants or of
+	// other sandboxes
+	ErrInvalidSandbox = errors.New("sandbox must belong to a live tenant")
+)
+
+// DefaultID is the tenant existing users were moved to when tenancy was
+// introduced, created by the tenants migration
+var DefaultID = uuid.MustParse("00000000-0000-0000-0000-000000000001")
+
+// DefaultSlug is the slug of the default tenant
+const DefaultSlug = "default"
+
+// Tenant is an isolated group of users
+type Tenant struct {
+	ID        uuid.UUID `json:"id"`
+	Slug      string    `json:"slug"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	// SandboxOf is the tenant whose sandbox data this one holds, if any
+	SandboxOf *uuid.UUID `json:"sandbox_of,omitempty"`
+}
+
+// Default returns the default tenant
+func Default() *Tenant {
+	return &Tenant{ID: DefaultID, Slug: DefaultSlug, Name: "Default"}
+}
+
+type contextKey struct{}
+
+// WithTenant returns a context carrying t
+func WithTenant(ctx context.Context, t *Tenant) context.Context {
+	return context.WithValue(ctx, contextKey{}, t)
+}
+
+type fallbackKey struct{}
+
+// WithFallback returns a context carrying t as the tenant of a request that
+// named none. Credentials issued in another tenant may replace it, see
+// Named.
+func WithFallback(ctx context.Context, t *Tenant) context.Context {
+	return context.WithValue(WithTenant(ctx, t), fallbackKey{}, t)
+}
+
+// Named reports whether ctx carries a tenant the request named, rather than
+// none or the fallback of WithFallback
+func Named(ctx context.Context) bool {
+	t, ok := FromContext(ctx)
+	fallback, _ := ctx.Value(fallbackKey{}).(*Tenant)
+	return ok && t != fallback
+}
+
+// FromContext returns the tenant stored in ctx, if any
+func FromContext(ctx context.Context) (*Tenant, bool) {
+	t, ok := ctx.Value(contextKey{}).(*Tenant)
+	return t, ok && t != nil
+}
+
+// ID returns the ID of the tenant in ctx, or DefaultID if there is none
+func ID(ctx context.Context) uuid.UUID {
+	if t, ok := FromContext(ctx); ok {
+		return t.ID
+	}
+	return DefaultID
+}
+
+// Normalize maps the zero ID, carried by credentials issued before tenancy,
+// to DefaultID
+func Normalize(id uuid.UUID) uuid.UUID {
+	if id == uuid.Nil {
+		return DefaultID
+	}
+	return id
+}
+
+type crossTenantKey struct{}
+
+// WithCrossTenant returns a context whose queries span every tenant, or
+// only the tenant only when it isn't nil. Platform operators' admin reads
+// run in such contexts.
+func WithCrossTenant(ctx context.Context, only *uuid.UUID) context.Context {
+	return context.WithValue(ctx, crossTenantKey{}, &crossTenant{only: only})
+}
+
+type crossTenant struct {
+	only *uuid.UUID
+}
+
+// Scope returns the tenant that queries listing rows of every user, or
+// reading them by ID, are limited to: the tenant in ctx, or the one a
+// cross-tenant context names. It returns nil, for no limit, in cross-tenant
+// contexts naming no tenant and in contexts without a tenant, such as
+// background tasks.
+func Scope(ctx context.Context) *uuid.UUID {
+	if cross, ok := ctx.Value(crossTenantKey{}).(*crossTenant); ok {
+		return cross.only
+	}
+	if t, ok := FromContext(ctx); ok {
+		id := t.ID
+		return &id
+	}
+	return nil
+}
+
+// ScopeParam returns Scope(ctx) as a nullable query parameter
+func ScopeParam(ctx context.Context) pgtype.UUID {
+	if id := Scope(ctx); id != nil {
+		return pgtype.UUID{Bytes: *id, Valid: true}
+	}
+	return pgtype.UUID{}
+}
@@ -0,0 +1,336 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/internal/config"
+	"github.com/pixperk/goiler/internal/testutil"
+	"github.com/pixperk/goiler/pkg/clock"
+	"github.com/pixperk/goiler/pkg/sandbox"
+)
+
+type memoryRepository struct {
+	mu      sync.Mutex
+	tenants map[string]*Tenant
+	lookups int
+}
+
+func newMemoryRepository() *memoryRepository {
+	return &memoryRepository{tenants: make(map[string]*Tenant)}
+}
+
+func (r *memoryRepository) Create(_ context.Context, t *Tenant) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.tenants[t.Slug]; ok {
+		return ErrTenantExists
+	}
+	stored := *t
+	r.tenants[t.Slug] = &stored
+	return nil
+}
+
+func (r *memoryRepository) GetByID(_ context.Context, id uuid.UUID) (*Tenant, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, t := range r.tenants {
+		if t.ID == id {
+			copied := *t
+			return &copied, nil
+		}
+	}
+	return nil, ErrTenantNotFound
+}
+
+func (r *memoryRepository) GetBySlug(_ context.Context, slug string) (*Tenant, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lookups++
+	t, ok := r.tenants[slug]
+	if !ok {
+		return nil, ErrTenantNotFound
+	}
+	copied := *t
+	return &copied, nil
+}
+
+func (r *memoryRepository) GetSandbox(_ context.Context, liveID uuid.UUID) (*Tenant, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, t := range r.tenants {
+		if t.SandboxOf != nil && *t.SandboxOf == liveID {
+			copied := *t
+			return &copied, nil
+		}
+	}
+	return nil, ErrTenantNotFound
+}
+
+func (r *memoryRepository) List(_ context.Context) ([]*Tenant, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	tenants := []*Tenant{}
+	for _, t := range r.tenants {
+		copied := *t
+		tenants = append(tenants, &copied)
+	}
+	return tenants, nil
+}
+
+func newTestService(t *testing.T) (*Service, *memoryRepository, *clock.Frozen) {
+	t.Helper()
+	repo := newMemoryRepository()
+	clk := testutil.Clock()
+	service := NewService(repo,
+		WithLogger(testutil.Logger()),
+		WithClock(clk),
+	)
+	return service, repo, clk
+}
+
+func TestContext(t *testing.T) {
+	ctx := context.Background()
+	if _, ok := FromContext(ctx); ok {
+		t.Error("Expected no tenant on an empty context")
+	}
+	if ID(ctx) != DefaultID {
+		t.Errorf("Expected the default tenant, got %s", ID(ctx))
+	}
+
+	acme := &Tenant{ID: uuid.New(), Slug: "acme"}
+	if got := ID(WithTenant(ctx, acme)); got != acme.ID {
+		t.Errorf("Expected tenant %s, got %s", acme.ID, got)
+	}
+
+	if Named(ctx) || !Named(WithTenant(ctx, acme)) || Named(WithFallback(ctx, Default())) {
+		t.Error("Expected only tenants the request named to be named")
+	}
+
+	if Normalize(uuid.Nil) != DefaultID {
+		t.Error("Expected the nil ID to normalize to the default tenant")
+	}
+	if Normalize(acme.ID) != acme.ID {
+		t.Error("Expected other IDs to be kept")
+	}
+}
+
+func TestScope(t *testing.T) {
+	ctx := context.Background()
+	if Scope(ctx) != nil || ScopeParam(ctx).Valid {
+		t.Error("Expected contexts without a tenant not to be scoped")
+	}
+
+	acme := &Tenant{ID: uuid.New(), Slug: "acme"}
+	scoped := WithTenant(ctx, acme)
+	if got := Scope(scoped); got == nil || *got != acme.ID {
+		t.Errorf("Expected the scope to be tenant %s, got %v", acme.ID, got)
+	}
+	if Scope(WithCrossTenant(scoped, nil)) != nil {
+		t.Error("Expected a cross-tenant context not to be scoped")
+	}
+	other := uuid.New()
+	if got := ScopeParam(WithCrossTenant(scoped, &other)); !got.Valid || got.Bytes != other {
+		t.Errorf("Expected the scope to be the named tenant %s, got %v", other, got)
+	}
+}
+
+func TestService_BySlugCachesKnownTenants(t *testing.T) {
+	service, repo, clk := newTestService(t)
+	ctx := context.Background()
+
+	created, err := service.Create(ctx, &CreateTenantRequest{Slug: "acme", Name: "Acme"})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := service.Create(ctx, &CreateTenantRequest{Slug: "acme", Name: "Acme again"}); !errors.Is(err, ErrTenantExists) {
+		t.Errorf("Expected ErrTenantExists, got %v", err)
+	}
+
+	for range 3 {
+		got, err := service.BySlug(ctx, "acme")
+		if err != nil {
+			t.Fatalf("BySlug failed: %v", err)
+		}
+		if got.ID != created.ID {
+			t.Errorf("Expected tenant %s, got %s", created.ID, got.ID)
+		}
+	}
+	if repo.lookups != 1 {
+		t.Errorf("Expected 1 repository lookup, got %d", repo.lookups)
+	}
+
+	clk.Advance(DefaultCacheTTL)
+	if _, err := service.BySlug(ctx, "acme"); err != nil {
+		t.Fatalf("BySlug failed: %v", err)
+	}
+	if repo.lookups != 2 {
+		t.Errorf("Expected the expired entry to be looked up again, got %d lookups", repo.lookups)
+	}
+
+	// Unknown slugs aren't cached, so tenants created elsewhere resolve
+	if _, err := service.BySlug(ctx, "globex"); !errors.Is(err, ErrTenantNotFound) {
+		t.Errorf("Expected ErrTenantNotFound, got %v", err)
+	}
+	repo.Create(ctx, &Tenant{ID: uuid.New(), Slug: "globex"})
+	if _, err := service.BySlug(ctx, "globex"); err != nil {
+		t.Errorf("Expected the new tenant to resolve, got %v", err)
+	}
+}
+
+func TestResolver_Middleware(t *testing.T) {
+	service, _, _ := newTestService(t)
+	acme, err := service.Create(context.Background(), &CreateTenantRequest{Slug: "acme", Name: "Acme"})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	logger := testutil.Logger()
+
+	tests := []struct {
+		name     string
+		required bool
+		host     string
+		header   string
+		want     int
+		wantID   uuid.UUID
+	}{
+		{name: "header", host: "api.example.com", header: "acme", want: http.StatusOK, wantID: acme.ID},
+		{name: "header is case insensitive", host: "api.example.com", header: "ACME", want: http.StatusOK, wantID: acme.ID},
+		{name: "subdomain", host: "acme.example.com", want: http.StatusOK, wantID: acme.ID},
+		{name: "subdomain with port", host: "acme.example.com:8080", want: http.StatusOK, wantID: acme.ID},
+		{name: "header wins over subdomain", host: "globex.example.com", header: "acme", want: http.StatusOK, wantID: acme.ID},
+		{name: "unknown tenant", host: "globex.example.com", want: http.StatusNotFound},
+		{name: "nested subdomain names no tenant", host: "www.acme.example.com", want: http.StatusOK, wantID: DefaultID},
+		{name: "other domain names no tenant", host: "acme.example.org", want: http.StatusOK, wantID: DefaultID},
+		{name: "no tenant falls back to default", host: "example.com", want: http.StatusOK, wantID: DefaultID},
+		{name: "no tenant when required", required: true, host: "example.com", want: http.StatusBadRequest},
+		{name: "tenant when required", required: true, host: "acme.example.com", want: http.StatusOK, wantID: acme.ID},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver := NewResolver(service, config.TenancyConfig{
+				Header:     "X-Tenant",
+				BaseDomain: "example.com",
+				Required:   tt.required,
+			}, logger)
+
+			var gotID uuid.UUID
+			next := func(c echo.Context) error {
+				gotID = ID(c.Request().Context())
+				return c.NoContent(http.StatusOK)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/users/me", nil)
+			req.Host = tt.host
+			if tt.header != "" {
+				req.Header.Set("X-Tenant", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			if err := resolver.Middleware()(next)(echo.New().NewContext(req, rec)); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if rec.Code != tt.want {
+				t.Fatalf("Expected status %d, got %d: %s", tt.want, rec.Code, rec.Body.String())
+			}
+			if tt.want == http.StatusOK && gotID != tt.wantID {
+				t.Errorf("Expected tenant %s, got %s", tt.wantID, gotID)
+			}
+		})
+	}
+}
+
+func TestSandboxRouter_Middleware(t *testing.T) {
+	service, repo, _ := newTestService(t)
+	ctx := context.Background()
+	repo.Create(ctx, Default())
+	acme, _ := service.Create(ctx, &CreateTenantRequest{Slug: "acme", Name: "Acme"})
+	acmeSandbox, _ := service.Create(ctx, &CreateTenantRequest{Slug: "acme-test", Name: "Acme sandbox", SandboxOf: &acme.ID})
+	defaultSandbox, _ := service.Create(ctx, &CreateTenantRequest{Slug: "test", Name: "Sandbox", SandboxOf: &DefaultID})
+	globex, _ := service.Create(ctx, &CreateTenantRequest{Slug: "globex", Name: "Globex"})
+	// A slug alone doesn't make a tenant a sandbox
+	lookalike, _ := service.Create(ctx, &CreateTenantRequest{Slug: "globex-sandbox", Name: "Globex sandbox"})
+	logger := testutil.Logger()
+
+	tests := []struct {
+		name        string
+		disabled    bool
+		tenant      *Tenant
+		header      string
+		want        int
+		wantID      uuid.UUID
+		wantSandbox bool
+	}{
+		{name: "live request", tenant: acme, want: http.StatusOK, wantID: acme.ID},
+		{name: "sandbox of tenant", tenant: acme, header: "true", want: http.StatusOK, wantID: acmeSandbox.ID, wantSandbox: true},
+		{name: "sandbox without tenancy", header: "true", want: http.StatusOK, wantID: defaultSandbox.ID, wantSandbox: true},
+		{name: "sandbox tenant selected directly", tenant: acmeSandbox, want: http.StatusOK, wantID: acmeSandbox.ID, wantSandbox: true},
+		{name: "sandbox not set up", tenant: globex, header: "true", want: http.StatusNotFound},
+		{name: "tenant named like a sandbox", tenant: lookalike, want: http.StatusOK, wantID: lookalike.ID},
+		{name: "sandbox disabled", disabled: true, tenant: acme, header: "true", want: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := NewSandboxRouter(service, config.TenancyConfig{Sandbox: !tt.disabled}, logger)
+
+			var gotID uuid.UUID
+			var gotSandbox bool
+			next := func(c echo.Context) error {
+				gotID = ID(c.Request().Context())
+				gotSandbox = sandbox.Enabled(c.Request().Context())
+				return c.NoContent(http.StatusOK)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/products", nil)
+			if tt.tenant != nil {
+				req = req.WithContext(WithTenant(req.Context(), tt.tenant))
+			}
+			if tt.header != "" {
+				req.Header.Set(sandbox.Header, tt.header)
+			}
+			rec := httptest.NewRecorder()
+			if err := router.Middleware()(next)(echo.New().NewContext(req, rec)); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if rec.Code != tt.want {
+				t.Fatalf("Expected status %d, got %d: %s", tt.want, rec.Code, rec.Body.String())
+			}
+			if tt.want == http.StatusOK && (gotID != tt.wantID || gotSandbox != tt.wantSandbox) {
+				t.Errorf("Expected tenant %s in sandbox %v, got %s in sandbox %v", tt.wantID, tt.wantSandbox, gotID, gotSandbox)
+			}
+		})
+	}
+}
+
+func TestService_CreateSandbox(t *testing.T) {
+	service, _, _ := newTestService(t)
+	ctx := context.Background()
+	acme, _ := service.Create(ctx, &CreateTenantRequest{Slug: "acme", Name: "Acme"})
+
+	sandboxTenant, err := service.Create(ctx, &CreateTenantRequest{Slug: "acme-test", Name: "Acme sandbox", SandboxOf: &acme.ID})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if !sandboxTenant.IsSandbox() || acme.IsSandbox() {
+		t.Error("Expected only the tenant naming a live tenant to be a sandbox")
+	}
+	if got, err := service.Sandbox(ctx, acme); err != nil || got.ID != sandboxTenant.ID {
+		t.Errorf("Expected the sandbox of acme, got %v, %v", got, err)
+	}
+
+	unknown := uuid.New()
+	for name, live := range map[string]*uuid.UUID{"unknown tenant": &unknown, "sandbox of a sandbox": &sandboxTenant.ID} {
+		if _, err := service.Create(ctx, &CreateTenantRequest{Slug: "nested", Name: "Nested", SandboxOf: live}); !errors.Is(err, ErrInvalidSandbox) {
+			t.Errorf("%s: expected ErrInvalidSandbox, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+// Package testutil holds the fixtures shared by the package tests
+package testutil
+
+import (
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/pixperk/goiler/pkg/clock"
+)
+
+// Epoch is the time test clocks start at
+var Epoch = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// Clock returns a frozen clock set to Epoch
+func Clock() *clock.Frozen {
+	return clock.NewFrozen(Epoch)
+}
+
+// Logger returns a logger that discards everything
+func Logger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
@@ -0,0 +1,235 @@
+package ticket
+
+import (
+	"errors"
+	"log/slog"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/internal/auth"
+	"github.com/pixperk/goiler/pkg/pagination"
+	"github.com/pixperk/goiler/pkg/response"
+	"github.com/pixperk/goiler/pkg/validator"
+)
+
+// Handler handles HTTP requests for support tickets
+type Handler struct {
+	service *Service
+	logger  *slog.Logger
+}
+
+// NewHandler creates a new ticket handler
+func NewHandler(service *Service, logger *slog.Logger) *Handler {
+	return &Handler{service: service, logger: logger}
+}
+
+// Create opens a ticket for the current user
+// @Summary Open ticket
+// @Description Open a support ticket with a subject and a first message
+// @Tags Tickets
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body CreateTicketRequest true "Ticket"
+// @Success 201 {object} Ticket
+// @Failure 401 {object} response.Response
+// @Failure 422 {object} response.Response
+// @Router /api/v1/tickets [post]
+func (h *Handler) Create(c echo.Context) error {
+	payload := auth.GetCurrentUser(c)
+	if payload == nil {
+		return response.Unauthorized(c, "User not authenticated")
+	}
+
+	var req CreateTicketRequest
+	if err := c.Bind(&req); err != nil {
+		return response.BadRequest(c, "Invalid request body")
+	}
+
+	if err := c.Validate(&req); err != nil {
+		return response.ValidationError(c, validator.FormatErrors(err))
+	}
+
+	ticket, err := h.service.Create(c.Request().Context(), payload.UserID, &req)
+	if err != nil {
+		return response.InternalError(c, "Failed to open ticket")
+	}
+	return response.Created(c, ticket)
+}
+
+// ListMine lists the current user's tickets
+// @Summary List my tickets
+// @Description List the current user's tickets, most recently updated first
+// @Tags Tickets
+// @Security BearerAuth
+// @Produce json
+// @Param page query int false "Page number" default(1)
+// @Param per_page query int false "Items per page, at most 100" default(20)
+// @Success 200 {array} Ticket
+// @Failure 401 {object} response.Response
+// @Failure 422 {object} response.Response
+// @Router /api/v1/tickets [get]
+func (h *Handler) ListMine(c echo.Context) error {
+	payload := auth.GetCurrentUser(c)
+	if payload == nil {
+		return response.Unauthorized(c, "User not authenticated")
+	}
+
+	params, err := pagination.Bind(c, pagination.Options{})
+	if err != nil {
+		return response.ValidationError(c, pagination.Details(err))
+	}
+
+	tickets, total, err := h.service.ListForUser(c.Request().Context(), payload.UserID, params)
+	if err != nil {
+		return response.InternalError(c, "Failed to list tickets")
+	}
+	return response.Paginated(c, tickets, params.Page, params.PerPage, total)
+}
+
+// GetMine returns one of the current user's tickets
+// @Summary Get my ticket
+// @Description Get one of the current user's tickets with its messages
+// @Tags Tickets
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Ticket ID"
+// @Success 200 {object} Ticket
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Router /api/v1/tickets/{id} [get]
+func (h *Handler) GetMine(c echo.Context) error {
+	payload := auth.GetCurrentUser(c)
+	if payload == nil {
+		return response.Unauthorized(c, "User not authenticated")
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return response.BadRequest(c, "Invalid ticket ID")
+	}
+
+	ticket, err := h.service.GetForUser(c.Request().Context(), payload.UserID, id)
+	if err != nil {
+		if errors.Is(err, ErrTicketNotFound) {
+			return response.NotFound(c, "Ticket not found")
+		}
+		return response.InternalError(c, "Failed to load ticket")
+	}
+	return response.Success(c, ticket)
+}
+
+// List lists every user's tickets
+// @Summary List tickets
+// @Description List tickets, most recently updated first, optionally in one status (admin only)
+// @Tags Admin
+// @Security BearerAuth
+// @Produce json
+// @Param status query string false "open, answered or closed"
+// @Param page query int false "Page number" default(1)
+// @Param per_page query int false "Items per page, at most 100" default(20)
+// @Success 200 {array} Ticket
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 422 {object} response.Response
+// @Router /api/v1/admin/tickets [get]
+func (h *Handler) List(c echo.Context) error {
+	status := c.QueryParam("status")
+	switch status {
+	case "", StatusOpen, StatusAnswered, StatusClosed:
+	default:
+		return response.BadRequest(c, "status must be open, answered or closed")
+	}
+
+	params, err := pagination.Bind(c, pagination.Options{})
+	if err != nil {
+		return response.ValidationError(c, pagination.Details(err))
+	}
+
+	tickets, total, err := h.service.List(c.Request().Context(), status, params)
+	if err != nil {
+		return response.InternalError(c, "Failed to list tickets")
+	}
+	return response.Paginated(c, tickets, params.Page, params.PerPage, total)
+}
+
+// Get returns any ticket
+// @Summary Get ticket
+// @Description Get a ticket with its messages (admin only)
+// @Tags Admin
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Ticket ID"
+// @Success 200 {object} Ticket
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Router /api/v1/admin/tickets/{id} [get]
+func (h *Handler) Get(c echo.Context) error {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return response.BadRequest(c, "Invalid ticket ID")
+	}
+
+	ticket, err := h.service.Get(c.Request().Context(), id)
+	if err != nil {
+		if errors.Is(err, ErrTicketNotFound) {
+			return response.NotFound(c, "Ticket not found")
+		}
+		return response.InternalError(c, "Failed to load ticket")
+	}
+	return response.Success(c, ticket)
+}
+
+// Reply adds a staff reply to a ticket
+// @Summary Reply to ticket
+// @Description Reply to a ticket, optionally closing it. The ticket's user is notified and, if connected, receives a ticket.reply WebSocket message (admin only).
+// @Tags Admin
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path string true "Ticket ID"
+// @Param request body ReplyRequest true "Reply"
+// @Success 201 {object} Message
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Failure 409 {object} response.Response
+// @Failure 422 {object} response.Response
+// @Router /api/v1/admin/tickets/{id}/replies [post]
+func (h *Handler) Reply(c echo.Context) error {
+	payload := auth.GetCurrentUser(c)
+	if payload == nil {
+		return response.Unauthorized(c, "User not authenticated")
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return response.BadRequest(c, "Invalid ticket ID")
+	}
+
+	var req ReplyRequest
+	if err := c.Bind(&req); err != nil {
+		return response.BadRequest(c, "Invalid request body")
+	}
+
+	if err := c.Validate(&req); err != nil {
+		return response.ValidationError(c, validator.FormatErrors(err))
+	}
+
+	message, err := h.service.Reply(c.Request().Context(), id, payload.UserID, &req)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrTicketNotFound):
+			return response.NotFound(c, "Ticket not found")
+		case errors.Is(err, ErrTicketClosed):
+			return response.Conflict(c, "Ticket is closed")
+		}
+		return response.InternalError(c, "Failed to reply to ticket")
+	}
+	return response.Created(c, message)
+}
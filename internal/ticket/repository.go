@@ -0,0 +1,231 @@
+package ticket
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pixperk/goiler/db/sqlc"
+	"github.com/pixperk/goiler/internal/tenant"
+	"github.com/pixperk/goiler/pkg/budget"
+)
+
+// PostgresRepository implements Repository using PostgreSQL
+type PostgresRepository struct {
+	db      *pgxpool.Pool
+	queries *sqlc.Queries
+}
+
+// NewPostgresRepository creates a new PostgreSQL repository
+func NewPostgresRepository(db *pgxpool.Pool) *PostgresRepository {
+	return &PostgresRepository{
+		db:      db,
+		queries: sqlc.New(db),
+	}
+}
+
+// CreateTicket stores a ticket and its first message in one transaction
+func (r *PostgresRepository) CreateTicket(ctx context.Context, ticket *Ticket, first *Message) error {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		q := r.queries.WithTx(tx)
+		err := q.CreateTicket(ctx, sqlc.CreateTicketParams{
+			ID:        ticket.ID,
+			UserID:    ticket.UserID,
+			Subject:   ticket.Subject,
+			Status:    ticket.Status,
+			CreatedAt: sql.NullTime{Time: ticket.CreatedAt, Valid: true},
+			UpdatedAt: sql.NullTime{Time: ticket.UpdatedAt, Valid: true},
+		})
+		if err != nil {
+			return err
+		}
+		return q.CreateTicketMessage(ctx, messageParams(first))
+	})
+}
+
+// GetTicket retrieves a ticket by ID, without its messages, if its user is
+// in the tenant scope of ctx
+func (r *PostgresRepository) GetTicket(ctx context.Context, id uuid.UUID) (*Ticket, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	dbTicket, err := r.queries.GetTicket(ctx, sqlc.GetTicketParams{
+		ID:       id,
+		TenantID: tenant.ScopeParam(ctx),
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrTicketNotFound
+		}
+		return nil, err
+	}
+	return ticketFromDB(dbTicket), nil
+}
+
+// ListUserTickets returns a page of a user's tickets
+func (r *PostgresRepository) ListUserTickets(ctx context.Context, userID uuid.UUID, limit, offset int) ([]*Ticket, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	dbTickets, err := r.queries.ListUserTickets(ctx, sqlc.ListUserTicketsParams{
+		UserID: userID,
+		Limit:  int32(limit),
+		Offset: int32(offset),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ticketsFromDB(dbTickets), nil
+}
+
+// CountUserTickets counts a user's tickets
+func (r *PostgresRepository) CountUserTickets(ctx context.Context, userID uuid.UUID) (int64, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer cancel()
+
+	return r.queries.CountUserTickets(ctx, userID)
+}
+
+// ListTickets returns a page of the tickets in the tenant scope of ctx,
+// optionally in one status
+func (r *PostgresRepository) ListTickets(ctx context.Context, status string, limit, offset int) ([]*Ticket, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	dbTickets, err := r.queries.ListTickets(ctx, sqlc.ListTicketsParams{
+		Status:   status,
+		TenantID: tenant.ScopeParam(ctx),
+		Lim:      int32(limit),
+		Off:      int32(offset),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ticketsFromDB(dbTickets), nil
+}
+
+// CountTickets counts the tickets in the tenant scope of ctx, optionally in
+// one status
+func (r *PostgresRepository) CountTickets(ctx context.Context, status string) (int64, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer cancel()
+
+	return r.queries.CountTickets(ctx, sqlc.CountTicketsParams{
+		Status:   status,
+		TenantID: tenant.ScopeParam(ctx),
+	})
+}
+
+// ListMessages returns a ticket's messages, oldest first
+func (r *PostgresRepository) ListMessages(ctx context.Context, ticketID uuid.UUID) ([]*Message, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	dbMessages, err := r.queries.ListTicketMessages(ctx, ticketID)
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]*Message, len(dbMessages))
+	for i, m := range dbMessages {
+		messages[i] = messageFromDB(m)
+	}
+	return messages, nil
+}
+
+// AddMessage stores a message and updates its ticket's status in one
+// transaction
+func (r *PostgresRepository) AddMessage(ctx context.Context, message *Message, status string) error {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		q := r.queries.WithTx(tx)
+		if err := q.CreateTicketMessage(ctx, messageParams(message)); err != nil {
+			return err
+		}
+		return q.UpdateTicketStatus(ctx, sqlc.UpdateTicketStatusParams{
+			ID:        message.TicketID,
+			Status:    status,
+			UpdatedAt: sql.NullTime{Time: message.CreatedAt, Valid: true},
+		})
+	})
+}
+
+func messageParams(m *Message) sqlc.CreateTicketMessageParams {
+	params := sqlc.CreateTicketMessageParams{
+		ID:        m.ID,
+		TicketID:  m.TicketID,
+		Staff:     m.Staff,
+		Body:      m.Body,
+		CreatedAt: sql.NullTime{Time: m.CreatedAt, Valid: true},
+	}
+	if m.AuthorID != nil {
+		params.AuthorID = pgtype.UUID{Bytes: *m.AuthorID, Valid: true}
+	}
+	return params
+}
+
+func ticketFromDB(t *sqlc.Ticket) *Ticket {
+	return &Ticket{
+		ID:        t.ID,
+		UserID:    t.UserID,
+		Subject:   t.Subject,
+		Status:    t.Status,
+		CreatedAt: t.CreatedAt.Time,
+		UpdatedAt: t.UpdatedAt.Time,
+	}
+}
+
+func ticketsFromDB(dbTickets []*sqlc.Ticket) []*Ticket {
+	tickets := make([]*Ticket, len(dbTickets))
+	for i, t := range dbTickets {
+		tickets[i] = ticketFromDB(t)
+	}
+	return tickets
+}
+
+func messageFromDB(m *sqlc.TicketMessage) *Message {
+	message := &Message{
+		ID:        m.ID,
+		TicketID:  m.TicketID,
+		Staff:     m.Staff,
+		Body:      m.Body,
+		CreatedAt: m.CreatedAt.Time,
+	}
+	if m.AuthorID.Valid {
+		authorID := uuid.UUID(m.AuthorID.Bytes)
+		message.AuthorID = &authorID
+	}
+	return message
+}
@@ -0,0 +1,345 @@
+// Package ticket implements support tickets: users open tickets and follow
+// them, and staff reply. It doubles as a reference for how a module is put
+// together: a Repository interface with a Postgres implementation, a
+// Service configured with options, and a Handler with validation and
+// pagination.
+package ticket
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pixperk/goiler/pkg/clock"
+	"github.com/pixperk/goiler/pkg/idgen"
+	"github.com/pixperk/goiler/pkg/pagination"
+)
+
+var (
+	ErrTicketNotFound = errors.New("ticket not found")
+	ErrTicketClosed   = errors.New("ticket is closed")
+)
+
+// Ticket statuses
+const (
+	// StatusOpen tickets are waiting for staff
+	StatusOpen = "open"
+	// StatusAnswered tickets were replied to by staff
+	StatusAnswered = "answered"
+	// StatusClosed tickets take no more replies
+	StatusClosed = "closed"
+)
+
+// NotificationTypeReply is the notification task type sent to users when
+// staff reply to their ticket
+const NotificationTypeReply = "ticket_reply"
+
+// MessageTypeReply is the WebSocket message type users receive when staff
+// reply to their ticket
+const MessageTypeReply = "ticket.reply"
+
+// Ticket is a support request and, when loaded individually, its
+// conversation
+type Ticket struct {
+	ID        uuid.UUID  `json:"id"`
+	UserID    uuid.UUID  `json:"user_id"`
+	Subject   string     `json:"subject"`
+	Status    string     `json:"status"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	Messages  []*Message `json:"messages,omitempty"`
+}
+
+// Message is a message on a ticket, from its user or from staff
+type Message struct {
+	ID       uuid.UUID `json:"id"`
+	TicketID uuid.UUID `json:"ticket_id"`
+	// AuthorID is nil once the author's account is deleted, and on staff
+	// messages shown to the ticket's user
+	AuthorID  *uuid.UUID `json:"author_id,omitempty"`
+	Staff     bool       `json:"staff"`
+	Body      string     `json:"body"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// forCustomer returns the message as the ticket's user sees it, without
+// the staff member who wrote it
+func (m *Message) forCustomer() *Message {
+	if !m.Staff {
+		return m
+	}
+	redacted := *m
+	redacted.AuthorID = nil
+	return &redacted
+}
+
+// Repository stores tickets and their messages
+type Repository interface {
+	// CreateTicket stores a ticket together with its first message
+	CreateTicket(ctx context.Context, ticket *Ticket, first *Message) error
+	GetTicket(ctx context.Context, id uuid.UUID) (*Ticket, error)
+	ListUserTickets(ctx context.Context, userID uuid.UUID, limit, offset int) ([]*Ticket, error)
+	CountUserTickets(ctx context.Context, userID uuid.UUID) (int64, error)
+	// ListTickets lists tickets in status, or in every status if empty
+	ListTickets(ctx context.Context, status string, limit, offset int) ([]*Ticket, error)
+	CountTickets(ctx context.Context, status string) (int64, error)
+	ListMessages(ctx context.Context, ticketID uuid.UUID) ([]*Message, error)
+	// AddMessage stores a message and moves its ticket to status
+	AddMessage(ctx context.Context, message *Message, status string) error
+}
+
+// Notifier queues notifications to users and reports whether one was
+// coalesced into an identical notification already queued.
+// *worker.Client satisfies it.
+type Notifier interface {
+	SendNotification(ctx context.Context, userID, notificationType, title, message string, data map[string]interface{}) (bool, error)
+}
+
+// Pusher delivers a message to a user's WebSocket connections.
+// *websocket.Handler satisfies it.
+type Pusher interface {
+	BroadcastToUser(userID, messageType string, payload interface{}) error
+}
+
+// Service manages support tickets
+type Service struct {
+	repo     Repository
+	logger   *slog.Logger
+	clock    clock.Clock
+	ids      idgen.Generator
+	notifier Notifier
+	pusher   Pusher
+}
+
+// ServiceOption configures a Service
+type ServiceOption func(*Service)
+
+// WithLogger sets the logger
+func WithLogger(logger *slog.Logger) ServiceOption {
+	return func(s *Service) {
+		s.logger = logger
+	}
+}
+
+// WithClock sets the clock used to stamp tickets and messages
+func WithClock(c clock.Clock) ServiceOption {
+	return func(s *Service) {
+		s.clock = c
+	}
+}
+
+// WithIDGenerator sets the generator for ticket and message IDs
+func WithIDGenerator(ids idgen.Generator) ServiceOption {
+	return func(s *Service) {
+		s.ids = ids
+	}
+}
+
+// WithNotifier sets where reply notifications are queued; replies notify
+// nobody without one
+func WithNotifier(notifier Notifier) ServiceOption {
+	return func(s *Service) {
+		s.notifier = notifier
+	}
+}
+
+// WithPusher sets how replies reach users' WebSocket connections
+func WithPusher(pusher Pusher) ServiceOption {
+	return func(s *Service) {
+		s.pusher = pusher
+	}
+}
+
+// NewService creates a new ticket service
+func NewService(repo Repository, opts ...ServiceOption) *Service {
+	s := &Service{
+		repo:   repo,
+		logger: slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.clock = clock.OrReal(s.clock)
+	s.ids = idgen.OrDefault(s.ids)
+	return s
+}
+
+// CreateTicketRequest represents a new ticket
+type CreateTicketRequest struct {
+	Subject string `json:"subject" validate:"required,max=255"`
+	Message string `json:"message" validate:"required,max=10000"`
+}
+
+// Create opens a ticket for a user
+func (s *Service) Create(ctx context.Context, userID uuid.UUID, req *CreateTicketRequest) (*Ticket, error) {
+	now := s.clock.Now()
+	ticket := &Ticket{
+		ID:        s.ids.NewID(),
+		UserID:    userID,
+		Subject:   req.Subject,
+		Status:    StatusOpen,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	first := &Message{
+		ID:        s.ids.NewID(),
+		TicketID:  ticket.ID,
+		AuthorID:  &userID,
+		Body:      req.Message,
+		CreatedAt: now,
+	}
+
+	if err := s.repo.CreateTicket(ctx, ticket, first); err != nil {
+		return nil, err
+	}
+	ticket.Messages = []*Message{first}
+
+	s.logger.InfoContext(ctx, "ticket opened",
+		slog.String("ticket_id", ticket.ID.String()),
+		slog.String("user_id", userID.String()),
+	)
+	return ticket, nil
+}
+
+// ListForUser returns a user's tickets, most recently updated first, with
+// the total count
+func (s *Service) ListForUser(ctx context.Context, userID uuid.UUID, params pagination.ListParams) ([]*Ticket, int64, error) {
+	total, err := s.repo.CountUserTickets(ctx, userID)
+	if err != nil {
+		return nil, 0, err
+	}
+	tickets, err := s.repo.ListUserTickets(ctx, userID, params.Limit(), params.Offset())
+	return tickets, total, err
+}
+
+// GetForUser returns one of a user's tickets with its messages, leaving
+// out who on staff wrote the replies. Other users' tickets are reported as
+// not found.
+func (s *Service) GetForUser(ctx context.Context, userID, id uuid.UUID) (*Ticket, error) {
+	ticket, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if ticket.UserID != userID {
+		return nil, ErrTicketNotFound
+	}
+	for i, message := range ticket.Messages {
+		ticket.Messages[i] = message.forCustomer()
+	}
+	return ticket, nil
+}
+
+// List returns tickets in status, or in every status if empty, most
+// recently updated first, with the total count
+func (s *Service) List(ctx context.Context, status string, params pagination.ListParams) ([]*Ticket, int64, error) {
+	total, err := s.repo.CountTickets(ctx, status)
+	if err != nil {
+		return nil, 0, err
+	}
+	tickets, err := s.repo.ListTickets(ctx, status, params.Limit(), params.Offset())
+	return tickets, total, err
+}
+
+// Get returns a ticket with its messages
+func (s *Service) Get(ctx context.Context, id uuid.UUID) (*Ticket, error) {
+	ticket, err := s.repo.GetTicket(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if ticket.Messages, err = s.repo.ListMessages(ctx, id); err != nil {
+		return nil, err
+	}
+	return ticket, nil
+}
+
+// ReplyRequest represents a staff reply
+type ReplyRequest struct {
+	Message string `json:"message" validate:"required,max=10000"`
+	// Close closes the ticket with this reply
+	Close bool `json:"close"`
+}
+
+// Reply adds a staff reply to a ticket and tells its user, with a
+// notification task and over WebSocket. Delivery failures are logged; the
+// reply is stored either way.
+func (s *Service) Reply(ctx context.Context, ticketID, staffID uuid.UUID, req *ReplyRequest) (*Message, error) {
+	ticket, err := s.repo.GetTicket(ctx, ticketID)
+	if err != nil {
+		return nil, err
+	}
+	if ticket.Status == StatusClosed {
+		return nil, ErrTicketClosed
+	}
+
+	status := StatusAnswered
+	if req.Close {
+		status = StatusClosed
+	}
+	message := &Message{
+		ID:        s.ids.NewID(),
+		TicketID:  ticket.ID,
+		AuthorID:  &staffID,
+		Staff:     true,
+		Body:      req.Message,
+		CreatedAt: s.clock.Now(),
+	}
+	if err := s.repo.AddMessage(ctx, message, status); err != nil {
+		return nil, err
+	}
+
+	s.logger.InfoContext(ctx, "ticket replied",
+		slog.String("ticket_id", ticket.ID.String()),
+		slog.String("staff_id", staffID.String()),
+		slog.String("status", status),
+	)
+	s.deliverReply(ctx, ticket, message, status)
+	return message, nil
+}
+
+// ReplyEvent is pushed to a user's WebSocket connections when staff reply
+type ReplyEvent struct {
+	TicketID uuid.UUID `json:"ticket_id"`
+	Subject  string    `json:"subject"`
+	Status   string    `json:"status"`
+	Message  *Message  `json:"message"`
+}
+
+// deliverReply tells the ticket's user about a staff reply
+func (s *Service) deliverReply(ctx context.Context, ticket *Ticket, message *Message, status string) {
+	userID := ticket.UserID.String()
+
+	if s.notifier != nil {
+		coalesced, err := s.notifier.SendNotification(ctx, userID, NotificationTypeReply,
+			"New reply to your ticket", ticket.Subject,
+			map[string]interface{}{"ticket_id": ticket.ID.String(), "status": status},
+		)
+		switch {
+		case err != nil:
+			s.logger.ErrorContext(ctx, "failed to queue ticket reply notification",
+				slog.String("ticket_id", ticket.ID.String()),
+				slog.String("error", err.Error()),
+			)
+		case coalesced:
+			s.logger.InfoContext(ctx, "ticket reply notification already queued",
+				slog.String("ticket_id", ticket.ID.String()),
+			)
+		}
+	}
+
+	if s.pusher != nil {
+		err := s.pusher.BroadcastToUser(userID, MessageTypeReply, ReplyEvent{
+			TicketID: ticket.ID,
+			Subject:  ticket.Subject,
+			Status:   status,
+			Message:  message.forCustomer(),
+		})
+		if err != nil {
+			s.logger.ErrorContext(ctx, "failed to push ticket reply",
+				slog.String("ticket_id", ticket.ID.String()),
+				slog.String("error", err.Error()),
+			)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package ticket
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pixperk/goiler/internal/testutil"
+	"github.com/pixperk/goiler/pkg/clock"
+	"github.com/pixperk/goiler/pkg/pagination"
+)
+
+type memoryRepository struct {
+	mu       sync.Mutex
+	tickets  map[uuid.UUID]*Ticket
+	messages []*Message
+}
+
+func (r *memoryRepository) CreateTicket(_ context.Context, ticket *Ticket, first *Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored := *ticket
+	r.tickets[ticket.ID] = &stored
+	r.messages = append(r.messages, first)
+	return nil
+}
+
+func (r *memoryRepository) GetTicket(_ context.Context, id uuid.UUID) (*Ticket, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ticket, ok := r.tickets[id]
+	if !ok {
+		return nil, ErrTicketNotFound
+	}
+	copied := *ticket
+	return &copied, nil
+}
+
+func (r *memoryRepository) list(match func(*Ticket) bool) []*Ticket {
+	var result []*Ticket
+	for _, t := range r.tickets {
+		if match(t) {
+			copied := *t
+			result = append(result, &copied)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].UpdatedAt.After(result[j].UpdatedAt) })
+	return result
+}
+
+func page(tickets []*Ticket, limit, offset int) []*Ticket {
+	if offset >= len(tickets) {
+		return []*Ticket{}
+	}
+	return tickets[offset:min(offset+limit, len(tickets))]
+}
+
+func (r *memoryRepository) ListUserTickets(_ context.Context, userID uuid.UUID, limit, offset int) ([]*Ticket, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return page(r.list(func(t *Ticket) bool { return t.UserID == userID }), limit, offset), nil
+}
+
+func (r *memoryRepository) CountUserTickets(_ context.Context, userID uuid.UUID) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return int64(len(r.list(func(t *Ticket) bool { return t.UserID == userID }))), nil
+}
+
+func (r *memoryRepository) ListTickets(_ context.Context, status string, limit, offset int) ([]*Ticket, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return page(r.list(func(t *Ticket) bool { return status == "" || t.Status == status }), limit, offset), nil
+}
+
+func (r *memoryRepository) CountTickets(_ context.Context, status string) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return int64(len(r.list(func(t *Ticket) bool { return status == "" || t.Status == status }))), nil
+}
+
+func (r *memoryRepository) ListMessages(_ context.Context, ticketID uuid.UUID) ([]*Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result := []*Message{}
+	for _, m := range r.messages {
+		if m.TicketID == ticketID {
+			result = append(result, m)
+		}
+	}
+	return result, nil
+}
+
+func (r *memoryRepository) AddMessage(_ context.Context, message *Message, status string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ticket, ok := r.tickets[message.TicketID]
+	if !ok {
+		return ErrTicketNotFound
+	}
+	ticket.Status = status
+	ticket.UpdatedAt = message.CreatedAt
+	r.messages = append(r.messages, message)
+	return nil
+}
+
+type notification struct {
+	userID, notificationType string
+	data                     map[string]interface{}
+}
+
+type recordingNotifier struct {
+	sent []notification
+	err  error
+}
+
+func (n *recordingNotifier) SendNotification(_ context.Context, userID, notificationType, _, _ string, data map[string]interface{}) (bool, error) {
+	n.sent = append(n.sent, notification{userID: userID, notificationType: notificationType, data: data})
+	return false, n.err
+}
+
+type push struct {
+	userID, messageType string
+	payload             interface{}
+}
+
+type recordingPusher struct {
+	pushed []push
+}
+
+func (p *recordingPusher) BroadcastToUser(userID, messageType string, payload interface{}) error {
+	p.pushed = append(p.pushed, push{userID: userID, messageType: messageType, payload: payload})
+	return nil
+}
+
+func newTestService(t *testing.T) (*Service, *recordingNotifier, *recordingPusher, *clock.Frozen) {
+	t.Helper()
+	notifier := &recordingNotifier{}
+	pusher := &recordingPusher{}
+	clk := testutil.Clock()
+	svc := NewService(&memoryRepository{tickets: make(map[uuid.UUID]*Ticket)},
+		WithClock(clk),
+		WithLogger(testutil.Logger()),
+		WithNotifier(notifier),
+		WithPusher(pusher),
+	)
+	return svc, notifier, pusher, clk
+}
+
+func mustCreate(t *testing.T, svc *Service, userID uuid.UUID, subject string) *Ticket {
+	t.Helper()
+	ticket, err := svc.Create(context.Background(), userID, &CreateTicketRequest{Subject: subject, Message: "Help"})
+	if err != nil {
+		t.Fatalf("Failed to create ticket: %v", err)
+	}
+	return ticket
+}
+
+func TestCreate_ListsForOwnerOnly(t *testing.T) {
+	svc, _, _, clk := newTestService(t)
+	ctx := context.Background()
+	alice, bob := uuid.New(), uuid.New()
+
+	first := mustCreate(t, svc, alice, "First")
+	clk.Set(clk.Now().Add(time.Minute))
+	second := mustCreate(t, svc, alice, "Second")
+	mustCreate(t, svc, bob, "Other")
+
+	if first.Status != StatusOpen || len(first.Messages) != 1 || *first.Messages[0].AuthorID != alice {
+		t.Errorf("Expected an open ticket with the user's message, got %+v", first)
+	}
+
+	tickets, total, err := svc.ListForUser(ctx, alice, pagination.ListParams{Page: 1, PerPage: 1})
+	if err != nil {
+		t.Fatalf("ListForUser failed: %v", err)
+	}
+	if total != 2 || len(tickets) != 1 || tickets[0].ID != second.ID {
+		t.Errorf("Expected the newest of 2 tickets, got %d of %d", len(tickets), total)
+	}
+
+	if _, err := svc.GetForUser(ctx, bob, first.ID); !errors.Is(err, ErrTicketNotFound) {
+		t.Errorf("Expected another user's ticket to be not found, got %v", err)
+	}
+	got, err := svc.GetForUser(ctx, alice, first.ID)
+	if err != nil || len(got.Messages) != 1 {
+		t.Errorf("Expected the owner to see the ticket with its message, got %+v, %v", got, err)
+	}
+}
+
+func TestReply_NotifiesAndPushes(t *testing.T) {
+	svc, notifier, pusher, _ := newTestService(t)
+	ctx := context.Background()
+	userID, staffID := uuid.New(), uuid.New()
+	ticket := mustCreate(t, svc, userID, "Broken")
+
+	message, err := svc.Reply(ctx, ticket.ID, staffID, &ReplyRequest{Message: "Fixed"})
+	if err != nil {
+		t.Fatalf("Reply failed: %v", err)
+	}
+	if !message.Staff || *message.AuthorID != staffID {
+		t.Errorf("Expected a staff message, got %+v", message)
+	}
+
+	got, _ := svc.Get(ctx, ticket.ID)
+	if got.Status != StatusAnswered || len(got.Messages) != 2 {
+		t.Errorf("Expected an answered ticket with 2 messages, got %s with %d", got.Status, len(got.Messages))
+	}
+
+	if len(notifier.sent) != 1 || notifier.sent[0].userID != userID.String() || notifier.sent[0].notificationType != NotificationTypeReply {
+		t.Errorf("Expected a reply notification to the user, got %+v", notifier.sent)
+	}
+	if len(pusher.pushed) != 1 || pusher.pushed[0].userID != userID.String() || pusher.pushed[0].messageType != MessageTypeReply {
+		t.Fatalf("Expected a reply pushed to the user, got %+v", pusher.pushed)
+	}
+	if event := pusher.pushed[0].payload.(ReplyEvent); event.Message.ID != message.ID || event.Message.AuthorID != nil {
+		t.Errorf("Expected the pushed event to carry the reply without its author, got %+v", event.Message)
+	}
+
+	// The user sees replies as from staff, not from a staff member
+	mine, err := svc.GetForUser(ctx, userID, ticket.ID)
+	if err != nil {
+		t.Fatalf("GetForUser failed: %v", err)
+	}
+	if mine.Messages[0].AuthorID == nil || mine.Messages[1].AuthorID != nil || !mine.Messages[1].Staff {
+		t.Errorf("Expected only the user's own message to carry an author, got %+v and %+v", mine.Messages[0], mine.Messages[1])
+	}
+	if got, _ := svc.Get(ctx, ticket.ID); got.Messages[1].AuthorID == nil || *got.Messages[1].AuthorID != staffID {
+		t.Errorf("Expected staff to still see the reply's author, got %+v", got.Messages[1])
+	}
+}
+
+func TestReply_Close(t *testing.T) {
+	svc, notifier, _, _ := newTestService(t)
+	ctx := context.Background()
+	ticket := mustCreate(t, svc, uuid.New(), "Question")
+	notifier.err = errors.New("queue down")
+
+	// Failing to notify doesn't fail the reply
+	if _, err := svc.Reply(ctx, ticket.ID, uuid.New(), &ReplyRequest{Message: "Done", Close: true}); err != nil {
+		t.Fatalf("Reply failed: %v", err)
+	}
+
+	closed, _, _ := svc.List(ctx, StatusClosed, pagination.ListParams{Page: 1, PerPage: 20})
+	if len(closed) != 1 {
+		t.Errorf("Expected the ticket to be closed, got %d closed tickets", len(closed))
+	}
+	if _, err := svc.Reply(ctx, ticket.ID, uuid.New(), &ReplyRequest{Message: "Again"}); !errors.Is(err, ErrTicketClosed) {
+		t.Errorf("Expected ErrTicketClosed, got %v", err)
+	}
+	if _, err := svc.Reply(ctx, uuid.New(), uuid.New(), &ReplyRequest{Message: "?"}); !errors.Is(err, ErrTicketNotFound) {
+		t.Errorf("Expected ErrTicketNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,170 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pixperk/goiler/internal/auth"
+	"github.com/pixperk/goiler/internal/tenant"
+	"github.com/pixperk/goiler/pkg/clock"
+	"github.com/pixperk/goiler/pkg/idgen"
+	"github.com/pixperk/goiler/pkg/pagination"
+)
+
+// ErrActivityDisabled is returned when the service has no activity log
+var ErrActivityDisabled = errors.New("activity log is not enabled")
+
+// Activity actions
+const (
+	ActivityProfileUpdated  = "profile_updated"
+	ActivityPasswordChanged = "password_changed"
+	ActivityAccountDeleted  = "account_deleted"
+	ActivitySuspended       = "account_suspended"
+	ActivityUnsuspended     = "account_unsuspended"
+)
+
+// Activity is a change to an account, as recorded in the append-only
+// activity log
+type Activity struct {
+	ID       uuid.UUID `json:"id"`
+	UserID   uuid.UUID `json:"-"`
+	TenantID uuid.UUID `json:"-"`
+	Action   string    `json:"action"`
+	// Fields are the JSON names of the changed profile fields
+	Fields    []string  `json:"fields,omitempty"`
+	IPAddress string    `json:"ip_address,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ActivityRepository stores the activity log. Entries are never changed
+// or deleted.
+type ActivityRepository interface {
+	RecordActivity(ctx context.Context, activity *Activity) error
+	ListActivity(ctx context.Context, userID uuid.UUID, limit, offset int) ([]*Activity, error)
+	CountActivity(ctx context.Context, userID uuid.UUID) (int64, error)
+}
+
+// AuditedRepository is a Repository that records profile updates,
+// password changes, suspensions and deletions in an activity log. Recording failures
+// are logged rather than failing the change, which has already been made.
+// UpdatePassword isn't recorded: it re-hashes the password the user
+// already has at login, while changes go through Update.
+type AuditedRepository struct {
+	Repository
+	activity ActivityRepository
+	logger   *slog.Logger
+	clock    clock.Clock
+	ids      idgen.Generator
+}
+
+// NewAuditedRepository wraps repo so that changes are recorded in activity
+func NewAuditedRepository(repo Repository, activity ActivityRepository, logger *slog.Logger, c clock.Clock, ids idgen.Generator) *AuditedRepository {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &AuditedRepository{
+		Repository: repo,
+		activity:   activity,
+		logger:     logger,
+		clock:      clock.OrReal(c),
+		ids:        idgen.OrDefault(ids),
+	}
+}
+
+// Update updates a user and records which profile fields changed, and
+// whether the password did
+func (r *AuditedRepository) Update(ctx context.Context, user *User) error {
+	before, lookupErr := r.Repository.GetByID(ctx, user.ID)
+	if err := r.Repository.Update(ctx, user); err != nil {
+		return err
+	}
+	if lookupErr != nil {
+		return nil
+	}
+
+	var changed []string
+	if user.Email != before.Email {
+		changed = append(changed, "email")
+	}
+	if user.Name != before.Name {
+		changed = append(changed, "name")
+	}
+	if len(changed) > 0 {
+		r.record(ctx, user.ID, ActivityProfileUpdated, changed)
+	}
+	if user.PasswordHash != before.PasswordHash {
+		r.record(ctx, user.ID, ActivityPasswordChanged, nil)
+	}
+	return nil
+}
+
+// SetActive suspends or reactivates a user and records the change
+func (r *AuditedRepository) SetActive(ctx context.Context, id uuid.UUID, active bool) error {
+	if err := r.Repository.SetActive(ctx, id, active); err != nil {
+		return err
+	}
+	action := ActivitySuspended
+	if active {
+		action = ActivityUnsuspended
+	}
+	r.record(ctx, id, action, nil)
+	return nil
+}
+
+// Delete deletes a user and records the deletion, which outlives the user
+func (r *AuditedRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	if err := r.Repository.Delete(ctx, id); err != nil {
+		return err
+	}
+	r.record(ctx, id, ActivityAccountDeleted, nil)
+	return nil
+}
+
+func (r *AuditedRepository) record(ctx context.Context, userID uuid.UUID, action string, fields []string) {
+	activity := &Activity{
+		ID:        r.ids.NewID(),
+		UserID:    userID,
+		TenantID:  tenant.ID(ctx),
+		Action:    action,
+		Fields:    fields,
+		CreatedAt: r.clock.Now(),
+	}
+	if client, ok := auth.ClientFromContext(ctx); ok {
+		activity.IPAddress = client.IPAddress
+		activity.UserAgent = client.UserAgent
+	}
+
+	if err := r.activity.RecordActivity(ctx, activity); err != nil {
+		r.logger.ErrorContext(ctx, "failed to record user activity",
+			slog.String("action", action),
+			slog.String("user_id", userID.String()),
+			slog.String("error", err.Error()),
+		)
+	}
+}
+
+// WithActivity enables listing the activity log recorded by an
+// AuditedRepository
+func WithActivity(repo ActivityRepository) ServiceOption {
+	return func(s *Service) {
+		s.activity = repo
+	}
+}
+
+// ListActivity returns a user's account activity, newest first, with the
+// total count
+func (s *Service) ListActivity(ctx context.Context, userID uuid.UUID, params pagination.ListParams) ([]*Activity, int64, error) {
+	if s.activity == nil {
+		return nil, 0, ErrActivityDisabled
+	}
+	total, err := s.activity.CountActivity(ctx, userID)
+	if err != nil {
+		return nil, 0, err
+	}
+	activity, err := s.activity.ListActivity(ctx, userID, params.Limit(), params.Offset())
+	return activity, total, err
+}
@@ -0,0 +1,280 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/pixperk/goiler/internal/auth"
+	"github.com/pixperk/goiler/pkg/clock"
+	"github.com/pixperk/goiler/pkg/pagination"
+)
+
+// memoryRepository keeps users in memory; methods the tests don't use
+// panic through the nil embedded Repository
+type memoryRepository struct {
+	Repository
+	mu    sync.Mutex
+	users map[uuid.UUID]*User
+}
+
+func newMemoryRepository(users ...*User) *memoryRepository {
+	r := &memoryRepository{users: make(map[uuid.UUID]*User)}
+	for _, u := range users {
+		r.users[u.ID] = u
+	}
+	return r
+}
+
+func (r *memoryRepository) GetByID(ctx context.Context, id uuid.UUID) (*User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, ok := r.users[id]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	copied := *u
+	return &copied, nil
+}
+
+func (r *memoryRepository) Update(ctx context.Context, user *User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.users[user.ID]; !ok {
+		return ErrUserNotFound
+	}
+	copied := *user
+	r.users[user.ID] = &copied
+	return nil
+}
+
+func (r *memoryRepository) UpdatePassword(ctx context.Context, id uuid.UUID, oldHash, newHash string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, ok := r.users[id]
+	if !ok {
+		return false, ErrUserNotFound
+	}
+	if u.PasswordHash != oldHash {
+		return false, nil
+	}
+	u.PasswordHash = newHash
+	return true, nil
+}
+
+func (r *memoryRepository) UpdateRole(ctx context.Context, id uuid.UUID, role string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, ok := r.users[id]
+	if !ok {
+		return ErrUserNotFound
+	}
+	u.Role = role
+	return nil
+}
+
+func (r *memoryRepository) SetActive(ctx context.Context, id uuid.UUID, active bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, ok := r.users[id]
+	if !ok {
+		return ErrUserNotFound
+	}
+	u.Suspended = !active
+	return nil
+}
+
+func (r *memoryRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.users[id]; !ok {
+		return ErrUserNotFound
+	}
+	delete(r.users, id)
+	return nil
+}
+
+type memoryActivity struct {
+	mu      sync.Mutex
+	entries []*Activity
+}
+
+func (m *memoryActivity) RecordActivity(ctx context.Context, activity *Activity) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries = append(m.entries, activity)
+	return nil
+}
+
+func (m *memoryActivity) ListActivity(ctx context.Context, userID uuid.UUID, limit, offset int) ([]*Activity, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var list []*Activity
+	for _, a := range slices.Backward(m.entries) {
+		if a.UserID == userID {
+			list = append(list, a)
+		}
+	}
+	return list[min(offset, len(list)):min(offset+limit, len(list))], nil
+}
+
+func (m *memoryActivity) CountActivity(ctx context.Context, userID uuid.UUID) (int64, error) {
+	list, _ := m.ListActivity(ctx, userID, len(m.entries), 0)
+	return int64(len(list)), nil
+}
+
+func (m *memoryActivity) actions() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	actions := make([]string, len(m.entries))
+	for i, a := range m.entries {
+		actions[i] = a.Action
+		if len(a.Fields) > 0 {
+			actions[i] += fmt.Sprint(a.Fields)
+		}
+	}
+	return actions
+}
+
+func TestAuditedRepository(t *testing.T) {
+	u := &User{ID: uuid.New(), Email: "a@example.com", Name: "A", PasswordHash: "old"}
+	activity := &memoryActivity{}
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	repo := NewAuditedRepository(newMemoryRepository(u), activity, nil, clock.NewFrozen(now), nil)
+	ctx := auth.ContextWithClient(context.Background(), auth.ClientInfo{IPAddress: "203.0.113.7", UserAgent: "test"})
+
+	steps := []struct {
+		name   string
+		change func() error
+		want   []string
+	}{
+		{"profile", func() error {
+			return repo.Update(ctx, &User{ID: u.ID, Email: "b@example.com", Name: "B", PasswordHash: "old"})
+		}, []string{"profile_updated[email name]"}},
+		{"unchanged", func() error {
+			return repo.Update(ctx, &User{ID: u.ID, Email: "b@example.com", Name: "B", PasswordHash: "old"})
+		}, nil},
+		{"password change", func() error {
+			return repo.Update(ctx, &User{ID: u.ID, Email: "b@example.com", Name: "B", PasswordHash: "new"})
+		}, []string{ActivityPasswordChanged}},
+		// Re-hashing at login isn't a change the user made
+		{"rehash", func() error {
+			_, err := repo.UpdatePassword(ctx, u.ID, "new", "rehashed")
+			return err
+		}, nil},
+		{"suspend", func() error { return repo.SetActive(ctx, u.ID, false) }, []string{ActivitySuspended}},
+		{"unsuspend", func() error { return repo.SetActive(ctx, u.ID, true) }, []string{ActivityUnsuspended}},
+		{"delete", func() error { return repo.Delete(ctx, u.ID) }, []string{ActivityAccountDeleted}},
+		{"failed delete", func() error {
+			if err := repo.Delete(ctx, u.ID); err == nil {
+				return fmt.Errorf("expected deleting a missing user to fail")
+			}
+			return nil
+		}, nil},
+	}
+
+	var want []string
+	for _, step := range steps {
+		if err := step.change(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		want = append(want, step.want...)
+		if got := activity.actions(); !slices.Equal(got, want) {
+			t.Fatalf("%s: expected activity %v, got %v", step.name, want, got)
+		}
+	}
+
+	for _, a := range activity.entries {
+		if a.UserID != u.ID || a.IPAddress != "203.0.113.7" || a.UserAgent != "test" || !a.CreatedAt.Equal(now) {
+			t.Errorf("Expected the entry to name the user, client and time, got %+v", a)
+		}
+	}
+}
+
+func TestService_ListActivity(t *testing.T) {
+	ctx := context.Background()
+	params := pagination.ListParams{Page: 1, PerPage: 2}
+	if _, _, err := NewService(newMemoryRepository(), nil).ListActivity(ctx, uuid.New(), params); err != ErrActivityDisabled {
+		t.Errorf("Expected ErrActivityDisabled without an activity log, got %v", err)
+	}
+
+	userID := uuid.New()
+	activity := &memoryActivity{}
+	for _, action := range []string{ActivityProfileUpdated, ActivitySuspended, ActivityUnsuspended} {
+		activity.RecordActivity(ctx, &Activity{ID: uuid.New(), UserID: userID, Action: action})
+	}
+	activity.RecordActivity(ctx, &Activity{ID: uuid.New(), UserID: uuid.New(), Action: ActivityAccountDeleted})
+
+	list, total, err := NewService(newMemoryRepository(), nil, WithActivity(activity)).ListActivity(ctx, userID, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 3 || len(list) != 2 || list[0].Action != ActivityUnsuspended {
+		t.Errorf("Expected the 2 newest of 3 entries, got %d of %d", len(list), total)
+	}
+}
+
+// TestUserAuditAppendOnly checks the user_audit triggers against the
+// Postgres at TEST_DATABASE_URL, in a schema of its own
+func TestUserAuditAppendOnly(t *testing.T) {
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, url)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close(ctx)
+
+	schema := "goiler_test_" + uuid.NewString()[:8]
+	if _, err := conn.Exec(ctx, "CREATE SCHEMA "+schema+"; SET search_path TO "+schema); err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Exec(ctx, "DROP SCHEMA "+schema+" CASCADE")
+	migration, err := os.ReadFile("../../db/migrations/000020_user_audit.up.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Exec(ctx, string(migration)); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+
+	id := uuid.New()
+	if _, err := conn.Exec(ctx, `INSERT INTO user_audit (id, user_id, tenant_id, action, ip_address, user_agent)
+		VALUES ($1, $2, $3, 'profile_updated', '203.0.113.7', 'test')`, id, uuid.New(), uuid.New()); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		sql     string
+		args    []any
+		allowed bool
+	}{
+		{"change action", "UPDATE user_audit SET action = 'account_deleted' WHERE id = $1", []any{id}, false},
+		{"blank only the IP", "UPDATE user_audit SET ip_address = '' WHERE id = $1", []any{id}, false},
+		{"delete", "DELETE FROM user_audit WHERE id = $1", []any{id}, false},
+		{"truncate", "TRUNCATE user_audit", nil, false},
+		// Erasure blanks the client
+		{"erase client", "UPDATE user_audit SET ip_address = '', user_agent = '' WHERE id = $1", []any{id}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := conn.Exec(ctx, tt.sql, tt.args...)
+			if tt.allowed && err != nil {
+				t.Errorf("Expected the statement to be allowed, got %v", err)
+			}
+			if !tt.allowed && err == nil {
+				t.Error("Expected the statement to be rejected")
+			}
+		})
+	}
+}
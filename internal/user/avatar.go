@@ -0,0 +1,214 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pixperk/goiler/pkg/imaging"
+	"github.com/pixperk/goiler/pkg/storage"
+)
+
+var (
+	ErrAvatarNotFound = errors.New("avatar not set")
+	// ErrInvalidImage is returned for uploads that aren't a JPEG, PNG or GIF
+	// image, or whose dimensions are too large
+	ErrInvalidImage = errors.New("invalid image")
+	// ErrAvatarTooLarge is returned for uploads over the size limit
+	ErrAvatarTooLarge = errors.New("avatar file too large")
+	// ErrAvatarsDisabled is returned when the service has no object store
+	ErrAvatarsDisabled = errors.New("avatars are not enabled")
+)
+
+// Avatar is the stored image a user has uploaded as their avatar
+type Avatar struct {
+	UserID      uuid.UUID
+	Key         string // object key in the store
+	ContentType string
+	SizeBytes   int
+	UpdatedAt   time.Time
+}
+
+// AvatarResponse represents an avatar in API responses. The URL is
+// presigned, so it can be used without authentication until it expires.
+type AvatarResponse struct {
+	URL         string    `json:"url"`
+	ContentType string    `json:"content_type"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// AvatarRepository stores which object is each user's avatar
+type AvatarRepository interface {
+	GetAvatar(ctx context.Context, userID uuid.UUID) (*Avatar, error)
+	// SetAvatar records the user's avatar, replacing any previous one
+	SetAvatar(ctx context.Context, avatar *Avatar) error
+	// DeleteAvatar removes the user's avatar and returns its object key
+	DeleteAvatar(ctx context.Context, userID uuid.UUID) (string, error)
+}
+
+// AvatarPolicy limits avatar uploads. Zero fields use the defaults.
+type AvatarPolicy struct {
+	MaxBytes  int
+	MaxPixels int
+	// Size is the width and height avatars are scaled down to
+	Size int
+	// URLExpiry is how long avatar URLs are valid
+	URLExpiry time.Duration
+}
+
+// Avatar defaults
+const (
+	DefaultAvatarMaxBytes  = 1 << 20
+	DefaultAvatarMaxPixels = 25_000_000
+	DefaultAvatarSize      = 256
+)
+
+func (p AvatarPolicy) withDefaults() AvatarPolicy {
+	if p.MaxBytes <= 0 {
+		p.MaxBytes = DefaultAvatarMaxBytes
+	}
+	if p.MaxPixels <= 0 {
+		p.MaxPixels = DefaultAvatarMaxPixels
+	}
+	if p.Size <= 0 {
+		p.Size = DefaultAvatarSize
+	}
+	if p.URLExpiry <= 0 {
+		p.URLExpiry = storage.DefaultURLExpiry
+	}
+	return p
+}
+
+// avatars holds what the service needs for avatars, set by WithAvatars
+type avatars struct {
+	repo   AvatarRepository
+	store  storage.Store
+	policy AvatarPolicy
+	logger *slog.Logger
+}
+
+// WithAvatars enables avatar uploads, stored in store and recorded in repo
+func WithAvatars(repo AvatarRepository, store storage.Store, policy AvatarPolicy) ServiceOption {
+	return func(s *Service) {
+		s.avatars = &avatars{
+			repo:   repo,
+			store:  store,
+			policy: policy.withDefaults(),
+			logger: slog.Default(),
+		}
+	}
+}
+
+// AvatarMaxBytes is the largest avatar upload accepted, or 0 when avatars
+// aren't enabled
+func (s *Service) AvatarMaxBytes() int {
+	if s.avatars == nil {
+		return 0
+	}
+	return s.avatars.policy.MaxBytes
+}
+
+// SetAvatar validates an uploaded image, scales it down to a square
+// thumbnail and stores it as the user's avatar. JPEGs stay JPEGs; other
+// formats are stored as PNGs. The previous avatar's object is deleted.
+func (s *Service) SetAvatar(ctx context.Context, userID uuid.UUID, data []byte) (*AvatarResponse, error) {
+	a := s.avatars
+	if a == nil {
+		return nil, ErrAvatarsDisabled
+	}
+	if len(data) > a.policy.MaxBytes {
+		return nil, ErrAvatarTooLarge
+	}
+	if _, err := s.repo.GetByID(ctx, userID); err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	img, format, err := imaging.Decode(data, a.policy.MaxPixels)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	var buf bytes.Buffer
+	contentType, ext, err := imaging.Encode(&buf, imaging.Thumbnail(img, a.policy.Size), format)
+	if err != nil {
+		return nil, err
+	}
+
+	previous, err := a.repo.GetAvatar(ctx, userID)
+	if err != nil && !errors.Is(err, ErrAvatarNotFound) {
+		return nil, err
+	}
+
+	// Each upload gets a new key, so URLs to the old image handed out
+	// earlier (and any caches) never show the new one under the old name
+	avatar := &Avatar{
+		UserID:      userID,
+		Key:         "avatars/" + userID.String() + "/" + s.ids.NewID().String() + ext,
+		ContentType: contentType,
+		SizeBytes:   buf.Len(),
+		UpdatedAt:   s.clock.Now(),
+	}
+	if err := a.store.Put(ctx, avatar.Key, &buf, int64(avatar.SizeBytes), contentType); err != nil {
+		return nil, fmt.Errorf("store avatar: %w", err)
+	}
+	if err := a.repo.SetAvatar(ctx, avatar); err != nil {
+		a.deleteObject(ctx, avatar.Key)
+		return nil, err
+	}
+	if previous != nil && previous.Key != avatar.Key {
+		a.deleteObject(ctx, previous.Key)
+	}
+
+	return s.avatarResponse(ctx, avatar)
+}
+
+// GetAvatar returns a presigned URL for the user's avatar
+func (s *Service) GetAvatar(ctx context.Context, userID uuid.UUID) (*AvatarResponse, error) {
+	if s.avatars == nil {
+		return nil, ErrAvatarsDisabled
+	}
+	avatar, err := s.avatars.repo.GetAvatar(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.avatarResponse(ctx, avatar)
+}
+
+// DeleteAvatar removes the user's avatar and its object
+func (s *Service) DeleteAvatar(ctx context.Context, userID uuid.UUID) error {
+	if s.avatars == nil {
+		return ErrAvatarsDisabled
+	}
+	key, err := s.avatars.repo.DeleteAvatar(ctx, userID)
+	if err != nil {
+		return err
+	}
+	s.avatars.deleteObject(ctx, key)
+	return nil
+}
+
+func (s *Service) avatarResponse(ctx context.Context, avatar *Avatar) (*AvatarResponse, error) {
+	expiry := s.avatars.policy.URLExpiry
+	url, err := s.avatars.store.PresignGet(ctx, avatar.Key, expiry)
+	if err != nil {
+		return nil, err
+	}
+	return &AvatarResponse{
+		URL:         url,
+		ContentType: avatar.ContentType,
+		ExpiresAt:   s.clock.Now().Add(expiry),
+		UpdatedAt:   avatar.UpdatedAt,
+	}, nil
+}
+
+// deleteObject deletes a replaced or removed avatar's object. A failure
+// only leaves an orphaned object behind, so it's logged, not returned.
+func (a *avatars) deleteObject(ctx context.Context, key string) {
+	if err := a.store.Delete(ctx, key); err != nil {
+		a.logger.WarnContext(ctx, "failed to delete avatar object", "key", key, "error", err)
+	}
+}
@@ -0,0 +1,269 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/internal/auth"
+	"github.com/pixperk/goiler/pkg/storage"
+)
+
+// memoryAvatars keeps avatars in memory
+type memoryAvatars struct {
+	mu      sync.Mutex
+	avatars map[uuid.UUID]*Avatar
+}
+
+func newMemoryAvatars() *memoryAvatars {
+	return &memoryAvatars{avatars: make(map[uuid.UUID]*Avatar)}
+}
+
+func (r *memoryAvatars) GetAvatar(ctx context.Context, userID uuid.UUID) (*Avatar, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	avatar, ok := r.avatars[userID]
+	if !ok {
+		return nil, ErrAvatarNotFound
+	}
+	copied := *avatar
+	return &copied, nil
+}
+
+func (r *memoryAvatars) SetAvatar(ctx context.Context, avatar *Avatar) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	copied := *avatar
+	r.avatars[avatar.UserID] = &copied
+	return nil
+}
+
+func (r *memoryAvatars) DeleteAvatar(ctx context.Context, userID uuid.UUID) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	avatar, ok := r.avatars[userID]
+	if !ok {
+		return "", ErrAvatarNotFound
+	}
+	delete(r.avatars, userID)
+	return avatar.Key, nil
+}
+
+// testImage encodes a width x height PNG
+func testImage(t *testing.T, width, height int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := range width {
+		for y := range height {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func newAvatarService(t *testing.T, users ...*User) (*Service, *memoryAvatars, *storage.LocalStore) {
+	t.Helper()
+	avatars := newMemoryAvatars()
+	store := storage.NewLocalStore(t.TempDir(), "http://localhost:8080/files", []byte("signing-key"))
+	svc := NewService(newMemoryRepository(users...), nil, WithAvatars(avatars, store, AvatarPolicy{MaxBytes: 64 << 10, Size: 16}))
+	return svc, avatars, store
+}
+
+// stored reports whether the store holds an object under key
+func stored(t *testing.T, store storage.Store, key string) bool {
+	t.Helper()
+	r, err := store.Get(context.Background(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	return true
+}
+
+func TestService_SetAvatar(t *testing.T) {
+	ctx := context.Background()
+	u := &User{ID: uuid.New(), Email: "user@example.com"}
+	svc, avatars, store := newAvatarService(t, u)
+
+	if _, err := svc.SetAvatar(ctx, uuid.New(), testImage(t, 32, 32)); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+	if _, err := svc.SetAvatar(ctx, u.ID, []byte("not an image")); !errors.Is(err, ErrInvalidImage) {
+		t.Errorf("Expected ErrInvalidImage, got %v", err)
+	}
+	if _, err := svc.SetAvatar(ctx, u.ID, make([]byte, 64<<10+1)); !errors.Is(err, ErrAvatarTooLarge) {
+		t.Errorf("Expected ErrAvatarTooLarge, got %v", err)
+	}
+
+	resp, err := svc.SetAvatar(ctx, u.ID, testImage(t, 64, 32))
+	if err != nil {
+		t.Fatalf("SetAvatar failed: %v", err)
+	}
+	first, _ := avatars.GetAvatar(ctx, u.ID)
+	if resp.ContentType != "image/png" || resp.URL == "" || !strings.HasPrefix(first.Key, "avatars/"+u.ID.String()+"/") {
+		t.Fatalf("Unexpected avatar %+v stored as %+v", resp, first)
+	}
+	r, err := store.Get(ctx, first.Key)
+	if err != nil {
+		t.Fatalf("Expected the image to be stored: %v", err)
+	}
+	img, err := png.Decode(r)
+	r.Close()
+	if err != nil || img.Bounds().Dx() != 16 || img.Bounds().Dy() != 16 {
+		t.Errorf("Expected a 16x16 thumbnail, got %v, %v", img.Bounds(), err)
+	}
+
+	// Replacing the avatar deletes the previous image
+	if _, err := svc.SetAvatar(ctx, u.ID, testImage(t, 32, 32)); err != nil {
+		t.Fatalf("SetAvatar failed: %v", err)
+	}
+	second, _ := avatars.GetAvatar(ctx, u.ID)
+	if second.Key == first.Key || stored(t, store, first.Key) || !stored(t, store, second.Key) {
+		t.Errorf("Expected the new image to replace %s, got %s", first.Key, second.Key)
+	}
+}
+
+func TestService_GetAndDeleteAvatar(t *testing.T) {
+	ctx := context.Background()
+	u := &User{ID: uuid.New(), Email: "user@example.com"}
+	svc, avatars, store := newAvatarService(t, u)
+
+	if _, err := svc.GetAvatar(ctx, u.ID); !errors.Is(err, ErrAvatarNotFound) {
+		t.Errorf("Expected ErrAvatarNotFound, got %v", err)
+	}
+	if err := svc.DeleteAvatar(ctx, u.ID); !errors.Is(err, ErrAvatarNotFound) {
+		t.Errorf("Expected ErrAvatarNotFound, got %v", err)
+	}
+
+	if _, err := svc.SetAvatar(ctx, u.ID, testImage(t, 32, 32)); err != nil {
+		t.Fatalf("SetAvatar failed: %v", err)
+	}
+	avatar, _ := avatars.GetAvatar(ctx, u.ID)
+	resp, err := svc.GetAvatar(ctx, u.ID)
+	if err != nil || !strings.Contains(resp.URL, avatar.Key) || !resp.ExpiresAt.After(resp.UpdatedAt) {
+		t.Fatalf("Expected a presigned URL for %s, got %+v, %v", avatar.Key, resp, err)
+	}
+
+	if err := svc.DeleteAvatar(ctx, u.ID); err != nil {
+		t.Fatalf("DeleteAvatar failed: %v", err)
+	}
+	if stored(t, store, avatar.Key) {
+		t.Error("Expected the image to be deleted")
+	}
+	if _, err := svc.GetAvatar(ctx, u.ID); !errors.Is(err, ErrAvatarNotFound) {
+		t.Errorf("Expected ErrAvatarNotFound after deleting, got %v", err)
+	}
+
+	disabled := NewService(newMemoryRepository(u), nil)
+	if _, err := disabled.GetAvatar(ctx, u.ID); !errors.Is(err, ErrAvatarsDisabled) {
+		t.Errorf("Expected ErrAvatarsDisabled, got %v", err)
+	}
+	if err := disabled.DeleteAvatar(ctx, u.ID); !errors.Is(err, ErrAvatarsDisabled) {
+		t.Errorf("Expected ErrAvatarsDisabled, got %v", err)
+	}
+}
+
+func TestService_DeleteRemovesAvatar(t *testing.T) {
+	ctx := context.Background()
+	u := &User{ID: uuid.New(), Email: "user@example.com"}
+	withoutAvatar := &User{ID: uuid.New(), Email: "other@example.com"}
+	svc, avatars, store := newAvatarService(t, u, withoutAvatar)
+
+	if _, err := svc.SetAvatar(ctx, u.ID, testImage(t, 32, 32)); err != nil {
+		t.Fatalf("SetAvatar failed: %v", err)
+	}
+	avatar, _ := avatars.GetAvatar(ctx, u.ID)
+
+	if err := svc.Delete(ctx, u.ID); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if stored(t, store, avatar.Key) {
+		t.Error("Expected deleting the account to delete its avatar image")
+	}
+	if err := svc.Delete(ctx, withoutAvatar.ID); err != nil {
+		t.Errorf("Expected accounts without an avatar to be deleted, got %v", err)
+	}
+}
+
+func TestHandler_Avatar(t *testing.T) {
+	u := &User{ID: uuid.New(), Email: "user@example.com"}
+	svc, _, _ := newAvatarService(t, u)
+	h := NewHandler(svc)
+
+	e := echo.New()
+	signedIn := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			auth.SetCurrentUser(c, &auth.TokenPayload{UserID: u.ID, Email: u.Email, Role: "user"})
+			return next(c)
+		}
+	}
+	e.PUT("/users/me/avatar", h.UploadAvatar, signedIn)
+	e.GET("/users/me/avatar", h.GetAvatar, signedIn)
+	e.DELETE("/users/me/avatar", h.DeleteAvatar, signedIn)
+	e.GET("/anonymous/avatar", h.GetAvatar)
+
+	upload := func(field string, data []byte) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		part, _ := form.CreateFormFile(field, "avatar.png")
+		part.Write(data)
+		form.Close()
+		req := httptest.NewRequest(http.MethodPut, "/users/me/avatar", &body)
+		req.Header.Set(echo.HeaderContentType, form.FormDataContentType())
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+	do := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec
+	}
+
+	tests := []struct {
+		name string
+		run  func() *httptest.ResponseRecorder
+		code int
+	}{
+		{"anonymous", func() *httptest.ResponseRecorder { return do(http.MethodGet, "/anonymous/avatar") }, http.StatusUnauthorized},
+		{"get unset", func() *httptest.ResponseRecorder { return do(http.MethodGet, "/users/me/avatar") }, http.StatusNotFound},
+		{"missing file", func() *httptest.ResponseRecorder { return upload("picture", testImage(t, 8, 8)) }, http.StatusBadRequest},
+		{"too large", func() *httptest.ResponseRecorder { return upload(avatarField, make([]byte, 64<<10+1)) }, http.StatusRequestEntityTooLarge},
+		{"not an image", func() *httptest.ResponseRecorder { return upload(avatarField, []byte("not an image")) }, http.StatusUnprocessableEntity},
+		{"upload", func() *httptest.ResponseRecorder { return upload(avatarField, testImage(t, 32, 32)) }, http.StatusOK},
+		{"get", func() *httptest.ResponseRecorder { return do(http.MethodGet, "/users/me/avatar") }, http.StatusOK},
+		{"delete", func() *httptest.ResponseRecorder { return do(http.MethodDelete, "/users/me/avatar") }, http.StatusNoContent},
+		{"delete again", func() *httptest.ResponseRecorder { return do(http.MethodDelete, "/users/me/avatar") }, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if rec := tt.run(); rec.Code != tt.code {
+			t.Errorf("%s: expected %d, got %d: %s", tt.name, tt.code, rec.Code, rec.Body.String())
+		}
+	}
+
+	disabled := NewHandler(NewService(newMemoryRepository(u), nil))
+	e.GET("/disabled/avatar", disabled.GetAvatar, signedIn)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/disabled/avatar", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 with avatars disabled, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,58 @@
+package user
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/pixperk/goiler/pkg/storage"
+)
+
+// GuestRepository deletes abandoned guest accounts. *PostgresRepository
+// satisfies it.
+type GuestRepository interface {
+	// DeleteStaleGuests deletes the guests created before cutoff and issued
+	// no refresh token or session since, and returns how many it deleted
+	// and the object keys of their avatars
+	DeleteStaleGuests(ctx context.Context, cutoff time.Time) (int64, []string, error)
+}
+
+// GuestPurger deletes guest accounts nobody has used for a while, with
+// their avatar images
+type GuestPurger struct {
+	repo   GuestRepository
+	store  storage.Store
+	logger *slog.Logger
+}
+
+// NewGuestPurger creates a purger deleting guests from repo and their
+// avatar images from store
+func NewGuestPurger(repo GuestRepository, store storage.Store, logger *slog.Logger) *GuestPurger {
+	return &GuestPurger{repo: repo, store: store, logger: logger}
+}
+
+// PurgeGuests deletes the guests created before cutoff and issued no
+// refresh token or session since, and returns how many it deleted. The
+// accounts are gone once their rows are, so avatar images that fail to
+// delete are logged and left behind rather than failing the purge.
+func (p *GuestPurger) PurgeGuests(ctx context.Context, cutoff time.Time) (int64, error) {
+	deleted, keys, err := p.repo.DeleteStaleGuests(ctx, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	for _, key := range keys {
+		if err := p.store.Delete(ctx, key); err != nil {
+			p.logger.WarnContext(ctx, "failed to delete avatar of purged guest",
+				slog.String("key", key),
+				slog.String("error", err.Error()),
+			)
+		}
+	}
+	if deleted > 0 {
+		p.logger.InfoContext(ctx, "purged stale guests",
+			slog.Int64("deleted", deleted),
+			slog.Time("cutoff", cutoff),
+		)
+	}
+	return deleted, nil
+}
@@ -0,0 +1,55 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pixperk/goiler/internal/testutil"
+	"github.com/pixperk/goiler/pkg/storage"
+)
+
+// staleGuests returns fixed results from DeleteStaleGuests and records the
+// cutoff it was called with
+type staleGuests struct {
+	deleted int64
+	keys    []string
+	err     error
+	cutoff  time.Time
+}
+
+func (r *staleGuests) DeleteStaleGuests(ctx context.Context, cutoff time.Time) (int64, []string, error) {
+	r.cutoff = cutoff
+	return r.deleted, r.keys, r.err
+}
+
+func TestGuestPurger_PurgeGuests(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewLocalStore(t.TempDir(), "http://localhost:8080/files", []byte("signing-key"))
+	if err := store.Put(ctx, "avatars/guest.png", strings.NewReader("png"), 3, "image/png"); err != nil {
+		t.Fatal(err)
+	}
+	logger := testutil.Logger()
+	cutoff := time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+
+	// An image that fails to delete doesn't fail the purge: the accounts
+	// are already gone
+	repo := &staleGuests{deleted: 3, keys: []string{"../outside", "avatars/guest.png"}}
+	deleted, err := NewGuestPurger(repo, store, logger).PurgeGuests(ctx, cutoff)
+	if err != nil || deleted != 3 {
+		t.Fatalf("Expected 3 guests purged, got %d, %v", deleted, err)
+	}
+	if !repo.cutoff.Equal(cutoff) {
+		t.Errorf("Expected the cutoff to be passed on, got %v", repo.cutoff)
+	}
+	if _, err := store.Get(ctx, "avatars/guest.png"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Expected the guest's avatar to be deleted, got %v", err)
+	}
+
+	failing := &staleGuests{err: errors.New("connection refused")}
+	if _, err := NewGuestPurger(failing, store, logger).PurgeGuests(ctx, cutoff); err == nil {
+		t.Error("Expected repository errors to be returned")
+	}
+}
@@ -1,9 +1,19 @@
 package user
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 	"github.com/pixperk/goiler/internal/auth"
+	"github.com/pixperk/goiler/internal/rbac"
+	"github.com/pixperk/goiler/pkg/bulk"
+	"github.com/pixperk/goiler/pkg/pagination"
 	"github.com/pixperk/goiler/pkg/response"
 	"github.com/pixperk/goiler/pkg/validator"
 )
@@ -39,9 +49,124 @@ func (h *Handler) GetProfile(c echo.Context) error {
 		return response.NotFound(c, "User not found")
 	}
 
+	identities, err := h.service.ListIdentities(c.Request().Context(), payload.UserID)
+	if err != nil {
+		return response.InternalError(c, "Failed to load login methods")
+	}
+	user.Identities = identities
+
+	// The caller's own profile includes the fields hidden from other users
+	response.SetAllFields(c, true)
 	return response.Success(c, user)
 }
 
+// ListIdentities returns the current user's login methods
+// @Summary List login methods
+// @Description List the password and external identities the current user can log in with
+// @Tags Users
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {array} IdentityResponse
+// @Failure 401 {object} response.Response
+// @Router /api/v1/users/me/identities [get]
+func (h *Handler) ListIdentities(c echo.Context) error {
+	payload := auth.GetCurrentUser(c)
+	if payload == nil {
+		return response.Unauthorized(c, "User not authenticated")
+	}
+
+	identities, err := h.service.ListIdentities(c.Request().Context(), payload.UserID)
+	if err != nil {
+		return response.InternalError(c, "Failed to load login methods")
+	}
+
+	response.SetAllFields(c, true)
+	return response.Success(c, identities)
+}
+
+// LinkPasswordRequest represents a request to add password login
+type LinkPasswordRequest struct {
+	Password string `json:"password" validate:"required,min=8"`
+}
+
+// LinkPassword adds password login to the current user
+// @Summary Link password login
+// @Description Add a password to an account that only has external identities
+// @Tags Users
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body LinkPasswordRequest true "New password"
+// @Success 200 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 409 {object} response.Response
+// @Failure 422 {object} response.Response
+// @Router /api/v1/users/me/identities/password [post]
+func (h *Handler) LinkPassword(c echo.Context) error {
+	payload := auth.GetCurrentUser(c)
+	if payload == nil {
+		return response.Unauthorized(c, "User not authenticated")
+	}
+
+	var req LinkPasswordRequest
+	if err := c.Bind(&req); err != nil {
+		return response.BadRequest(c, "Invalid request body")
+	}
+
+	if err := c.Validate(&req); err != nil {
+		return response.ValidationError(c, validator.FormatErrors(err))
+	}
+
+	err := h.service.SetPassword(auth.ClientContext(c), payload.UserID, req.Password)
+	if err != nil {
+		if errors.Is(err, ErrIdentityAlreadyLinked) {
+			return response.Conflict(c, "Password login is already set up")
+		}
+		if errors.Is(err, ErrGuestAccount) {
+			return response.Conflict(c, "Guest accounts set a password by upgrading")
+		}
+		var policyErr *auth.PasswordPolicyError
+		if errors.As(err, &policyErr) {
+			return auth.WeakPassword(c, policyErr)
+		}
+		return response.InternalError(c, "Failed to link password")
+	}
+
+	return response.SuccessWithMessage(c, "Password login linked", nil)
+}
+
+// UnlinkIdentity removes a login method from the current user
+// @Summary Unlink login method
+// @Description Remove a login method; the last remaining method can't be removed
+// @Tags Users
+// @Security BearerAuth
+// @Produce json
+// @Param provider path string true "Provider (password, google, github, ...)"
+// @Success 204 "No Content"
+// @Failure 401 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Failure 409 {object} response.Response
+// @Router /api/v1/users/me/identities/{provider} [delete]
+func (h *Handler) UnlinkIdentity(c echo.Context) error {
+	payload := auth.GetCurrentUser(c)
+	if payload == nil {
+		return response.Unauthorized(c, "User not authenticated")
+	}
+
+	err := h.service.UnlinkIdentity(c.Request().Context(), payload.UserID, c.Param("provider"))
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrIdentityNotFound):
+			return response.NotFound(c, "Login method not linked")
+		case errors.Is(err, ErrLastLoginMethod):
+			return response.Conflict(c, "Cannot remove your last login method")
+		}
+		return response.InternalError(c, "Failed to unlink login method")
+	}
+
+	return response.NoContent(c)
+}
+
 // UpdateProfileRequest represents a profile update request
 type UpdateProfileRequest struct {
 	Email string `json:"email" validate:"omitempty,email"`
@@ -59,6 +184,8 @@ type UpdateProfileRequest struct {
 // @Success 200 {object} UserResponse
 // @Failure 400 {object} response.Response
 // @Failure 401 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Failure 409 {object} response.Response "Email taken, or guest accounts can't set an email"
 // @Failure 422 {object} response.Response
 // @Router /api/v1/users/me [put]
 func (h *Handler) UpdateProfile(c echo.Context) error {
@@ -76,14 +203,23 @@ func (h *Handler) UpdateProfile(c echo.Context) error {
 		return response.ValidationError(c, validator.FormatErrors(err))
 	}
 
-	user, err := h.service.Update(c.Request().Context(), payload.UserID, &UpdateRequest{
+	user, err := h.service.Update(auth.ClientContext(c), payload.UserID, &UpdateRequest{
 		Email: req.Email,
 		Name:  req.Name,
 	})
 	if err != nil {
+		switch {
+		case errors.Is(err, ErrGuestAccount):
+			return response.Conflict(c, "Guest accounts set an email by upgrading")
+		case errors.Is(err, ErrEmailTaken):
+			return response.Conflict(c, "Email is already taken")
+		case errors.Is(err, ErrUserNotFound):
+			return response.NotFound(c, "User not found")
+		}
 		return response.InternalError(c, "Failed to update profile")
 	}
 
+	response.SetAllFields(c, true)
 	return response.SuccessWithMessage(c, "Profile updated successfully", user)
 }
 
@@ -121,11 +257,15 @@ func (h *Handler) ChangePassword(c echo.Context) error {
 		return response.ValidationError(c, validator.FormatErrors(err))
 	}
 
-	err := h.service.ChangePassword(c.Request().Context(), payload.UserID, req.CurrentPassword, req.NewPassword)
+	err := h.service.ChangePassword(auth.ClientContext(c), payload.UserID, req.CurrentPassword, req.NewPassword)
 	if err != nil {
 		if err == ErrInvalidPassword {
 			return response.Unauthorized(c, "Current password is incorrect")
 		}
+		var policyErr *auth.PasswordPolicyError
+		if errors.As(err, &policyErr) {
+			return auth.WeakPassword(c, policyErr)
+		}
 		return response.InternalError(c, "Failed to change password")
 	}
 
@@ -147,7 +287,7 @@ func (h *Handler) DeleteAccount(c echo.Context) error {
 		return response.Unauthorized(c, "User not authenticated")
 	}
 
-	err := h.service.Delete(c.Request().Context(), payload.UserID)
+	err := h.service.Delete(auth.ClientContext(c), payload.UserID)
 	if err != nil {
 		return response.InternalError(c, "Failed to delete account")
 	}
@@ -155,6 +295,119 @@ func (h *Handler) DeleteAccount(c echo.Context) error {
 	return response.NoContent(c)
 }
 
+// ListActivity lists the current user's account activity
+// @Summary List my account activity
+// @Description List profile updates, password changes and other changes to the current user's account, newest first, with the client that made them
+// @Tags Users
+// @Security BearerAuth
+// @Produce json
+// @Param page query int false "Page number" default(1)
+// @Param per_page query int false "Items per page, at most 100" default(20)
+// @Success 200 {array} Activity
+// @Failure 401 {object} response.Response
+// @Failure 422 {object} response.Response
+// @Failure 503 {object} response.Response "Activity log not enabled"
+// @Router /api/v1/users/me/activity [get]
+func (h *Handler) ListActivity(c echo.Context) error {
+	payload := auth.GetCurrentUser(c)
+	if payload == nil {
+		return response.Unauthorized(c, "User not authenticated")
+	}
+
+	params, err := pagination.Bind(c, pagination.Options{})
+	if err != nil {
+		return response.ValidationError(c, pagination.Details(err))
+	}
+
+	activity, total, err := h.service.ListActivity(c.Request().Context(), payload.UserID, params)
+	if err != nil {
+		if errors.Is(err, ErrActivityDisabled) {
+			return response.Error(c, http.StatusServiceUnavailable, "ACTIVITY_DISABLED", "Activity log is not enabled")
+		}
+		return response.InternalError(c, "Failed to list activity")
+	}
+	return response.Paginated(c, activity, params.Page, params.PerPage, total)
+}
+
+// ListUsers lists users for callers holding users:read, with their email
+// addresses. With a cursor parameter the list is keyset-paginated by
+// creation time instead, which stays fast on large tables.
+// @Summary List users
+// @Description List users with their email addresses, newest first by default (users:read)
+// @Tags Users
+// @Security BearerAuth
+// @Produce json
+// @Param page query int false "Page number" default(1)
+// @Param per_page query int false "Items per page, at most 100" default(20)
+// @Param q query string false "Case-insensitive name or email substring"
+// @Param role query string false "Only users with this role"
+// @Param created_after query string false "Only users created at or after this time (RFC 3339)"
+// @Param created_before query string false "Only users created before this time (RFC 3339)"
+// @Param sort query string false "Sort field" Enums(created_at, email, name) default(created_at)
+// @Param order query string false "Sort order" Enums(asc, desc) default(desc)
+// @Param cursor query string false "Cursor from meta.next_cursor or meta.prev_cursor; send it empty to start cursor pagination, newest first"
+// @Success 200 {array} UserResponse
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 422 {object} response.Response
+// @Router /api/v1/users [get]
+func (h *Handler) ListUsers(c echo.Context) error {
+	params, err := pagination.Bind(c, pagination.Options{
+		Sorts:       ListSorts,
+		DefaultSort: SortCreatedAt,
+		Filters:     []string{"q", "role", "created_after", "created_before"},
+	})
+	if err != nil {
+		return response.ValidationError(c, pagination.Details(err))
+	}
+
+	// The route requires users:read, which also shows emails, so searching
+	// and sorting by them reveals nothing hidden
+	filter := ListFilter{SearchEmail: true}
+	filter.Search, _ = params.Filter("q")
+	filter.Role, _ = params.Filter("role")
+	if raw, ok := params.Filter("created_after"); ok {
+		if filter.CreatedAfter, err = time.Parse(time.RFC3339, raw); err != nil {
+			return response.BadRequest(c, "Invalid created_after time, expected RFC 3339")
+		}
+	}
+	if raw, ok := params.Filter("created_before"); ok {
+		if filter.CreatedBefore, err = time.Parse(time.RFC3339, raw); err != nil {
+			return response.BadRequest(c, "Invalid created_before time, expected RFC 3339")
+		}
+	}
+
+	if params.UsesCursor() {
+		return h.listUsersByCursor(c, filter, params)
+	}
+
+	users, total, err := h.service.List(c.Request().Context(), filter, params)
+	if err != nil {
+		return response.InternalError(c, "Failed to list users")
+	}
+	return response.Paginated(c, users, params.Page, params.PerPage, total)
+}
+
+// listUsersByCursor answers ListUsers in cursor mode, which only walks the
+// default order
+func (h *Handler) listUsersByCursor(c echo.Context, filter ListFilter, params pagination.ListParams) error {
+	if params.Sort != SortCreatedAt || !params.Descending() {
+		return response.ValidationError(c, map[string]string{
+			"cursor": "cursor pagination only supports sort=created_at and order=desc",
+		})
+	}
+
+	users, next, prev, err := h.service.ListByCursor(c.Request().Context(), filter, params.Cursor, params.PerPage)
+	if errors.Is(err, pagination.ErrInvalidCursor) {
+		return response.ValidationError(c, map[string]string{"cursor": "invalid cursor"})
+	}
+	if err != nil {
+		return response.InternalError(c, "Failed to list users")
+	}
+	return response.CursorPaginated(c, users, params.PerPage, next, prev)
+}
+
 // GetUser returns a user by ID (admin only)
 // @Summary Get user by ID
 // @Description Get a user by their ID (admin only)
@@ -181,3 +434,260 @@ func (h *Handler) GetUser(c echo.Context) error {
 
 	return response.Success(c, user)
 }
+
+// RoleUpdate is a single role change in a bulk request
+type RoleUpdate struct {
+	UserID string `json:"user_id" validate:"required,uuid"`
+	Role   string `json:"role" validate:"required,max=50"`
+}
+
+// BulkUpdateRolesRequest represents a bulk role update request
+type BulkUpdateRolesRequest struct {
+	Updates []RoleUpdate `json:"updates" validate:"required,min=1,max=100,dive"`
+}
+
+// BulkUpdateRoles changes the roles of several users at once (admin only)
+// @Summary Bulk update user roles
+// @Description Apply several role changes and report a status per item. Roles must be defined by the RBAC policy, and the admin's role must grant every permission of both the user's current role and the new one (admin only)
+// @Tags Users
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body BulkUpdateRolesRequest true "Role updates"
+// @Success 207 {object} bulk.Response
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 422 {object} response.Response
+// @Router /api/v1/admin/users/roles [patch]
+func (h *Handler) BulkUpdateRoles(c echo.Context) error {
+	var req BulkUpdateRolesRequest
+	if err := c.Bind(&req); err != nil {
+		return response.BadRequest(c, "Invalid request body")
+	}
+
+	if err := c.Validate(&req); err != nil {
+		return response.ValidationError(c, validator.FormatErrors(err))
+	}
+
+	results := bulk.Execute(c.Request().Context(), req.Updates, bulk.Options{MapError: mapBulkError},
+		func(ctx context.Context, update RoleUpdate) (interface{}, error) {
+			id := uuid.MustParse(update.UserID)
+			if err := h.service.UpdateRole(ctx, id, update.Role); err != nil {
+				return nil, err
+			}
+			return update, nil
+		})
+
+	return bulk.Respond(c, results)
+}
+
+// mapBulkError maps user errors to per-item bulk statuses
+func mapBulkError(err error) (int, *response.ErrorInfo) {
+	switch {
+	case errors.Is(err, ErrUserNotFound):
+		return http.StatusNotFound, &response.ErrorInfo{Code: "NOT_FOUND", Message: "User not found"}
+	case errors.Is(err, ErrUnknownRole):
+		return http.StatusBadRequest, &response.ErrorInfo{Code: "BAD_REQUEST", Message: "Unknown role"}
+	case errors.Is(err, ErrRoleNotAllowed), errors.Is(err, rbac.ErrForbidden):
+		return http.StatusForbidden, &response.ErrorInfo{Code: "FORBIDDEN", Message: "Role change exceeds your permissions"}
+	}
+	return http.StatusInternalServerError, &response.ErrorInfo{Code: "INTERNAL_ERROR", Message: "Failed to update user"}
+}
+
+// SuspendUser deactivates a user's account (admin only)
+// @Summary Suspend user
+// @Description Deactivate a user's account without deleting it. Logins and token refreshes are rejected with ACCOUNT_SUSPENDED and the user's refresh tokens and sessions are revoked; access tokens already issued expire on their own (admin only).
+// @Tags Admin
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {object} response.Response
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Failure 409 {object} response.Response "Admins can't suspend themselves"
+// @Router /api/v1/admin/users/{id}/suspend [post]
+func (h *Handler) SuspendUser(c echo.Context) error {
+	payload := auth.GetCurrentUser(c)
+	if payload == nil {
+		return response.Unauthorized(c, "User not authenticated")
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return response.BadRequest(c, "Invalid user ID")
+	}
+
+	if err := h.service.Suspend(auth.ClientContext(c), payload.UserID, id); err != nil {
+		switch {
+		case errors.Is(err, ErrSuspendSelf):
+			return response.Conflict(c, "You can't suspend your own account")
+		case errors.Is(err, ErrUserNotFound):
+			return response.NotFound(c, "User not found")
+		}
+		return response.InternalError(c, "Failed to suspend user")
+	}
+
+	return response.SuccessWithMessage(c, "User suspended", nil)
+}
+
+// UnsuspendUser reactivates a suspended account (admin only)
+// @Summary Unsuspend user
+// @Description Reactivate a suspended account so the user can log in again (admin only)
+// @Tags Admin
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {object} response.Response
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Router /api/v1/admin/users/{id}/unsuspend [post]
+func (h *Handler) UnsuspendUser(c echo.Context) error {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return response.BadRequest(c, "Invalid user ID")
+	}
+
+	if err := h.service.Unsuspend(auth.ClientContext(c), id); err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return response.NotFound(c, "User not found")
+		}
+		return response.InternalError(c, "Failed to unsuspend user")
+	}
+
+	return response.SuccessWithMessage(c, "User unsuspended", nil)
+}
+
+// avatarField is the multipart form field avatars are uploaded in
+const avatarField = "avatar"
+
+// UploadAvatar sets the current user's avatar from a multipart upload
+// @Summary Upload avatar
+// @Description Upload a JPEG, PNG or GIF image as the current user's avatar. It's cropped to a square and scaled down, and the response carries a presigned URL to read it from.
+// @Tags Users
+// @Security BearerAuth
+// @Accept multipart/form-data
+// @Produce json
+// @Param avatar formData file true "Image file"
+// @Success 200 {object} AvatarResponse
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 413 {object} response.Response
+// @Failure 422 {object} response.Response
+// @Failure 503 {object} response.Response
+// @Router /api/v1/users/me/avatar [put]
+func (h *Handler) UploadAvatar(c echo.Context) error {
+	payload := auth.GetCurrentUser(c)
+	if payload == nil {
+		return response.Unauthorized(c, "User not authenticated")
+	}
+	maxBytes := h.service.AvatarMaxBytes()
+	if maxBytes == 0 {
+		return avatarsDisabled(c)
+	}
+
+	header, err := c.FormFile(avatarField)
+	if err != nil {
+		return response.BadRequest(c, "Missing avatar file")
+	}
+	if header.Size > int64(maxBytes) {
+		return avatarTooLarge(c, maxBytes)
+	}
+	file, err := header.Open()
+	if err != nil {
+		return response.BadRequest(c, "Invalid avatar file")
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, int64(maxBytes)+1))
+	if err != nil {
+		return response.BadRequest(c, "Invalid avatar file")
+	}
+
+	avatar, err := h.service.SetAvatar(c.Request().Context(), payload.UserID, data)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrAvatarTooLarge):
+			return avatarTooLarge(c, maxBytes)
+		case errors.Is(err, ErrInvalidImage):
+			return response.ValidationError(c, map[string]string{avatarField: "must be a JPEG, PNG or GIF image within the size limits"})
+		case errors.Is(err, ErrUserNotFound):
+			return response.NotFound(c, "User not found")
+		}
+		return response.InternalError(c, "Failed to save avatar")
+	}
+
+	return response.Success(c, avatar)
+}
+
+// GetAvatar returns a URL for the current user's avatar
+// @Summary Get avatar
+// @Description Get a presigned URL for the current user's avatar, valid until expires_at
+// @Tags Users
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} AvatarResponse
+// @Failure 401 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Failure 503 {object} response.Response
+// @Router /api/v1/users/me/avatar [get]
+func (h *Handler) GetAvatar(c echo.Context) error {
+	payload := auth.GetCurrentUser(c)
+	if payload == nil {
+		return response.Unauthorized(c, "User not authenticated")
+	}
+
+	avatar, err := h.service.GetAvatar(c.Request().Context(), payload.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrAvatarsDisabled):
+			return avatarsDisabled(c)
+		case errors.Is(err, ErrAvatarNotFound):
+			return response.NotFound(c, "Avatar not set")
+		}
+		return response.InternalError(c, "Failed to load avatar")
+	}
+
+	return response.Success(c, avatar)
+}
+
+// DeleteAvatar removes the current user's avatar
+// @Summary Delete avatar
+// @Description Remove the current user's avatar
+// @Tags Users
+// @Security BearerAuth
+// @Success 204 "No Content"
+// @Failure 401 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Failure 503 {object} response.Response
+// @Router /api/v1/users/me/avatar [delete]
+func (h *Handler) DeleteAvatar(c echo.Context) error {
+	payload := auth.GetCurrentUser(c)
+	if payload == nil {
+		return response.Unauthorized(c, "User not authenticated")
+	}
+
+	err := h.service.DeleteAvatar(c.Request().Context(), payload.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrAvatarsDisabled):
+			return avatarsDisabled(c)
+		case errors.Is(err, ErrAvatarNotFound):
+			return response.NotFound(c, "Avatar not set")
+		}
+		return response.InternalError(c, "Failed to delete avatar")
+	}
+
+	return response.NoContent(c)
+}
+
+func avatarTooLarge(c echo.Context, maxBytes int) error {
+	return response.Error(c, http.StatusRequestEntityTooLarge, "FILE_TOO_LARGE", fmt.Sprintf("Avatar files must be at most %d bytes", maxBytes))
+}
+
+func avatarsDisabled(c echo.Context) error {
+	return response.Error(c, http.StatusServiceUnavailable, "AVATARS_DISABLED", "Avatars are not enabled")
+}
@@ -2,22 +2,70 @@ package user
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pixperk/goiler/db"
 	"github.com/pixperk/goiler/db/sqlc"
+	"github.com/pixperk/goiler/internal/tenant"
+	"github.com/pixperk/goiler/pkg/budget"
+	"github.com/pixperk/goiler/pkg/pagination"
 )
 
-// Repository defines the interface for user data access
+// uniqueViolation is the Postgres error code for unique constraint violations
+const uniqueViolation = "23505"
+
+// emailConstraint is the unique index on full accounts' emails per tenant
+const emailConstraint = "users_tenant_email_key"
+
+// isEmailTaken reports whether err is a violation of emailConstraint, as
+// when two registrations for the same email race
+func isEmailTaken(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolation && pgErr.ConstraintName == emailConstraint
+}
+
+// Repository defines the interface for user data access. Every method is
+// scoped to the tenant in the context, see tenant.ID.
 type Repository interface {
 	Create(ctx context.Context, user *User) error
 	GetByID(ctx context.Context, id uuid.UUID) (*User, error)
 	GetByEmail(ctx context.Context, email string) (*User, error)
 	Update(ctx context.Context, user *User) error
+	// UpdatePassword replaces the password hash with one of the same
+	// password, such as when re-hashing with new parameters at login, as
+	// long as it's still oldHash. It reports false, leaving the hash
+	// alone, if the password changed since oldHash was read. Password
+	// changes go through Update.
+	UpdatePassword(ctx context.Context, id uuid.UUID, oldHash, newHash string) (bool, error)
+	UpgradeGuest(ctx context.Context, id uuid.UUID, email, passwordHash string) error
+	UpdateRole(ctx context.Context, id uuid.UUID, role string) error
+	// SetActive suspends (false) or reactivates (true) a user
+	SetActive(ctx context.Context, id uuid.UUID, active bool) error
+	MarkEmailVerified(ctx context.Context, id uuid.UUID) error
 	Delete(ctx context.Context, id uuid.UUID) error
-	List(ctx context.Context, limit, offset int) ([]*User, int64, error)
+	List(ctx context.Context, filter ListFilter, params pagination.ListParams) ([]*User, int64, error)
+	// ListAfter returns up to limit users created before the position,
+	// newest first, or the newest users if after is nil
+	ListAfter(ctx context.Context, filter ListFilter, after *ListPosition, limit int) ([]*User, error)
+	// ListBefore returns up to limit users created after the position that
+	// are closest to it, newest first
+	ListBefore(ctx context.Context, filter ListFilter, before ListPosition, limit int) ([]*User, error)
+	ListIdentities(ctx context.Context, userID uuid.UUID) ([]*Identity, error)
+	CreateIdentity(ctx context.Context, identity *Identity) error
+	DeleteIdentity(ctx context.Context, userID uuid.UUID, provider string) error
+	// UnlinkLoginMethod removes the password (ProviderPassword) or a linked
+	// identity, returning ErrLastLoginMethod instead if it's the user's last
+	// way to log in. Concurrent unlinks can't leave the user without one.
+	UnlinkLoginMethod(ctx context.Context, userID uuid.UUID, provider string) error
 }
 
 // PostgresRepository implements Repository using PostgreSQL
@@ -34,20 +82,48 @@ func NewPostgresRepository(db *pgxpool.Pool) *PostgresRepository {
 	}
 }
 
-// Create creates a new user
+// Create creates a new user in the context's tenant. It returns
+// ErrEmailTaken if the email is registered, even by a request that raced
+// this one past the caller's check.
 func (r *PostgresRepository) Create(ctx context.Context, user *User) error {
-	return r.queries.CreateUser(ctx, sqlc.CreateUserParams{
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	user.TenantID = tenant.ID(ctx)
+	err = r.queries.CreateUser(ctx, sqlc.CreateUserParams{
 		ID:           user.ID,
 		Email:        user.Email,
 		Name:         stringToPgText(user.Name),
 		PasswordHash: user.PasswordHash,
 		Role:         user.Role,
+		TenantID:     user.TenantID,
+		IsGuest:      user.Guest,
 	})
+	if isEmailTaken(err) {
+		return ErrEmailTaken
+	}
+	return err
 }
 
 // GetByID retrieves a user by ID
 func (r *PostgresRepository) GetByID(ctx context.Context, id uuid.UUID) (*User, error) {
-	dbUser, err := r.queries.GetUserByID(ctx, id)
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	var dbUser *sqlc.User
+	err = db.Retry(ctx, "user.get_by_id", func(ctx context.Context) error {
+		dbUser, err = r.queries.GetUserByID(ctx, sqlc.GetUserByIDParams{
+			ID:       id,
+			TenantID: tenant.ID(ctx),
+		})
+		return err
+	})
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			return nil, ErrUserNotFound
@@ -56,19 +132,36 @@ func (r *PostgresRepository) GetByID(ctx context.Context, id uuid.UUID) (*User,
 	}
 
 	return &User{
-		ID:           dbUser.ID,
-		Email:        dbUser.Email,
-		Name:         pgTextToString(dbUser.Name),
-		PasswordHash: dbUser.PasswordHash,
-		Role:         dbUser.Role,
-		CreatedAt:    dbUser.CreatedAt.Time,
-		UpdatedAt:    dbUser.UpdatedAt.Time,
+		ID:            dbUser.ID,
+		Email:         dbUser.Email,
+		Name:          pgTextToString(dbUser.Name),
+		PasswordHash:  dbUser.PasswordHash,
+		Role:          dbUser.Role,
+		TenantID:      dbUser.TenantID,
+		EmailVerified: dbUser.EmailVerifiedAt.Valid,
+		Guest:         dbUser.IsGuest,
+		Suspended:     !dbUser.IsActive,
+		CreatedAt:     dbUser.CreatedAt.Time,
+		UpdatedAt:     dbUser.UpdatedAt.Time,
 	}, nil
 }
 
 // GetByEmail retrieves a user by email
 func (r *PostgresRepository) GetByEmail(ctx context.Context, email string) (*User, error) {
-	dbUser, err := r.queries.GetUserByEmail(ctx, email)
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	var dbUser *sqlc.User
+	err = db.Retry(ctx, "user.get_by_email", func(ctx context.Context) error {
+		dbUser, err = r.queries.GetUserByEmail(ctx, sqlc.GetUserByEmailParams{
+			TenantID: tenant.ID(ctx),
+			Email:    email,
+		})
+		return err
+	})
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			return nil, ErrUserNotFound
@@ -77,60 +170,565 @@ func (r *PostgresRepository) GetByEmail(ctx context.Context, email string) (*Use
 	}
 
 	return &User{
-		ID:           dbUser.ID,
-		Email:        dbUser.Email,
-		Name:         pgTextToString(dbUser.Name),
-		PasswordHash: dbUser.PasswordHash,
-		Role:         dbUser.Role,
-		CreatedAt:    dbUser.CreatedAt.Time,
-		UpdatedAt:    dbUser.UpdatedAt.Time,
+		ID:            dbUser.ID,
+		Email:         dbUser.Email,
+		Name:          pgTextToString(dbUser.Name),
+		PasswordHash:  dbUser.PasswordHash,
+		Role:          dbUser.Role,
+		TenantID:      dbUser.TenantID,
+		EmailVerified: dbUser.EmailVerifiedAt.Valid,
+		Guest:         dbUser.IsGuest,
+		Suspended:     !dbUser.IsActive,
+		CreatedAt:     dbUser.CreatedAt.Time,
+		UpdatedAt:     dbUser.UpdatedAt.Time,
 	}, nil
 }
 
-// Update updates a user
+// Update updates a user. It returns ErrEmailTaken if the new email is
+// registered.
 func (r *PostgresRepository) Update(ctx context.Context, user *User) error {
-	return r.queries.UpdateUser(ctx, sqlc.UpdateUserParams{
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	err = r.queries.UpdateUser(ctx, sqlc.UpdateUserParams{
 		ID:           user.ID,
 		Email:        user.Email,
 		Name:         stringToPgText(user.Name),
 		PasswordHash: user.PasswordHash,
+		TenantID:     tenant.ID(ctx),
+	})
+	if isEmailTaken(err) {
+		return ErrEmailTaken
+	}
+	return err
+}
+
+// UpdatePassword replaces a user's password hash while it's still oldHash,
+// and reports whether it did
+func (r *PostgresRepository) UpdatePassword(ctx context.Context, id uuid.UUID, oldHash, newHash string) (bool, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer cancel()
+
+	rows, err := r.queries.UpdateUserPassword(ctx, sqlc.UpdateUserPasswordParams{
+		NewHash:  newHash,
+		ID:       id,
+		TenantID: tenant.ID(ctx),
+		OldHash:  oldHash,
+	})
+	return rows > 0, err
+}
+
+// UpgradeGuest gives a guest user an email and password, making it a full
+// account with the same ID. It returns ErrUserNotFound if the user isn't a
+// guest, and ErrEmailTaken if the email is registered.
+func (r *PostgresRepository) UpgradeGuest(ctx context.Context, id uuid.UUID, email, passwordHash string) error {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	rows, err := r.queries.UpgradeGuestUser(ctx, sqlc.UpgradeGuestUserParams{
+		ID:           id,
+		Email:        email,
+		PasswordHash: passwordHash,
+		TenantID:     tenant.ID(ctx),
+	})
+	if isEmailTaken(err) {
+		return ErrEmailTaken
+	}
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// MarkEmailVerified records that a user's email has been verified
+func (r *PostgresRepository) MarkEmailVerified(ctx context.Context, id uuid.UUID) error {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return r.queries.VerifyUserEmail(ctx, sqlc.VerifyUserEmailParams{
+		ID:       id,
+		TenantID: tenant.ID(ctx),
 	})
 }
 
+// UpdateRole changes a user's role
+func (r *PostgresRepository) UpdateRole(ctx context.Context, id uuid.UUID, role string) error {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	rows, err := r.queries.UpdateUserRole(ctx, sqlc.UpdateUserRoleParams{
+		ID:       id,
+		Role:     role,
+		TenantID: tenant.ID(ctx),
+	})
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// SetActive suspends or reactivates a user. It returns ErrUserNotFound if
+// the user doesn't exist.
+func (r *PostgresRepository) SetActive(ctx context.Context, id uuid.UUID, active bool) error {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	rows, err := r.queries.SetUserActive(ctx, sqlc.SetUserActiveParams{
+		ID:       id,
+		IsActive: active,
+		TenantID: tenant.ID(ctx),
+	})
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
 // Delete deletes a user
 func (r *PostgresRepository) Delete(ctx context.Context, id uuid.UUID) error {
-	return r.queries.DeleteUser(ctx, id)
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return r.queries.DeleteUser(ctx, sqlc.DeleteUserParams{
+		ID:       id,
+		TenantID: tenant.ID(ctx),
+	})
 }
 
-// List returns a paginated list of users
-func (r *PostgresRepository) List(ctx context.Context, limit, offset int) ([]*User, int64, error) {
+// DeleteStaleGuests deletes the guests, in every tenant, created before
+// cutoff and issued no refresh token or session since, and returns how many
+// it deleted and the object keys of their avatars
+func (r *PostgresRepository) DeleteStaleGuests(ctx context.Context, cutoff time.Time) (int64, []string, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer cancel()
+
+	rows, err := r.queries.DeleteStaleGuestUsers(ctx, sql.NullTime{Time: cutoff, Valid: true})
+	if err != nil {
+		return 0, nil, err
+	}
+	var keys []string
+	for _, row := range rows {
+		if row.Key.Valid {
+			keys = append(keys, row.Key.String)
+		}
+	}
+	return int64(len(rows)), keys, nil
+}
+
+// List returns a page of the users matching filter, sorted by params.Sort
+// (one of ListSorts), and how many match in total
+func (r *PostgresRepository) List(ctx context.Context, filter ListFilter, params pagination.ListParams) ([]*User, int64, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cancel()
+
+	search := stringToPgText(likeEscaper.Replace(filter.Search))
+	role := stringToPgText(filter.Role)
+	createdAfter := timeToPgTimestamptz(filter.CreatedAfter)
+	createdBefore := timeToPgTimestamptz(filter.CreatedBefore)
+
 	dbUsers, err := r.queries.ListUsers(ctx, sqlc.ListUsersParams{
-		Limit:  int32(limit),
-		Offset: int32(offset),
+		TenantID:      tenant.ID(ctx),
+		Search:        search,
+		SearchEmail:   filter.SearchEmail,
+		Role:          role,
+		CreatedAfter:  createdAfter,
+		CreatedBefore: createdBefore,
+		SortBy:        params.Sort,
+		Descending:    params.Descending(),
+		MaxEntries:    int32(params.Limit()),
+		SkipEntries:   int32(params.Offset()),
 	})
 	if err != nil {
 		return nil, 0, err
 	}
 
-	count, err := r.queries.CountUsers(ctx)
+	count, err := r.queries.CountListedUsers(ctx, sqlc.CountListedUsersParams{
+		TenantID:      tenant.ID(ctx),
+		Search:        search,
+		SearchEmail:   filter.SearchEmail,
+		Role:          role,
+		CreatedAfter:  createdAfter,
+		CreatedBefore: createdBefore,
+	})
 	if err != nil {
 		return nil, 0, err
 	}
 
+	return usersFromDB(dbUsers), count, nil
+}
+
+// ListAfter returns a keyset page of users created before the position,
+// newest first
+func (r *PostgresRepository) ListAfter(ctx context.Context, filter ListFilter, after *ListPosition, limit int) ([]*User, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	params := sqlc.ListUsersAfterParams{
+		TenantID:      tenant.ID(ctx),
+		Search:        stringToPgText(likeEscaper.Replace(filter.Search)),
+		SearchEmail:   filter.SearchEmail,
+		Role:          stringToPgText(filter.Role),
+		CreatedAfter:  timeToPgTimestamptz(filter.CreatedAfter),
+		CreatedBefore: timeToPgTimestamptz(filter.CreatedBefore),
+		MaxEntries:    int32(limit),
+	}
+	if after != nil {
+		params.AfterCreatedAt = pgtype.Timestamptz{Time: after.CreatedAt, Valid: true}
+		params.AfterID = pgtype.UUID{Bytes: after.ID, Valid: true}
+	}
+
+	dbUsers, err := r.queries.ListUsersAfter(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return usersFromDB(dbUsers), nil
+}
+
+// ListBefore returns the keyset page of users created just after the
+// position, newest first
+func (r *PostgresRepository) ListBefore(ctx context.Context, filter ListFilter, before ListPosition, limit int) ([]*User, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	dbUsers, err := r.queries.ListUsersBefore(ctx, sqlc.ListUsersBeforeParams{
+		TenantID:        tenant.ID(ctx),
+		BeforeCreatedAt: sql.NullTime{Time: before.CreatedAt, Valid: true},
+		BeforeID:        before.ID,
+		Search:          stringToPgText(likeEscaper.Replace(filter.Search)),
+		SearchEmail:     filter.SearchEmail,
+		Role:            stringToPgText(filter.Role),
+		CreatedAfter:    timeToPgTimestamptz(filter.CreatedAfter),
+		CreatedBefore:   timeToPgTimestamptz(filter.CreatedBefore),
+		MaxEntries:      int32(limit),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The query walks towards newer users oldest first
+	slices.Reverse(dbUsers)
+	return usersFromDB(dbUsers), nil
+}
+
+// ListIdentities returns the external identities linked to a user
+func (r *PostgresRepository) ListIdentities(ctx context.Context, userID uuid.UUID) ([]*Identity, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	dbIdentities, err := r.queries.ListUserIdentities(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	identities := make([]*Identity, len(dbIdentities))
+	for i, dbIdentity := range dbIdentities {
+		identities[i] = &Identity{
+			ID:             dbIdentity.ID,
+			UserID:         dbIdentity.UserID,
+			Provider:       dbIdentity.Provider,
+			ProviderUserID: dbIdentity.ProviderUserID,
+			Email:          pgTextToString(dbIdentity.Email),
+			CreatedAt:      dbIdentity.CreatedAt.Time,
+		}
+	}
+
+	return identities, nil
+}
+
+// CreateIdentity links an external identity to a user
+func (r *PostgresRepository) CreateIdentity(ctx context.Context, identity *Identity) error {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	err = r.queries.CreateUserIdentity(ctx, sqlc.CreateUserIdentityParams{
+		ID:             identity.ID,
+		UserID:         identity.UserID,
+		Provider:       identity.Provider,
+		ProviderUserID: identity.ProviderUserID,
+		Email:          stringToPgText(identity.Email),
+	})
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		return ErrIdentityAlreadyLinked
+	}
+	return err
+}
+
+// DeleteIdentity unlinks an external identity from a user
+func (r *PostgresRepository) DeleteIdentity(ctx context.Context, userID uuid.UUID, provider string) error {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	rows, err := r.queries.DeleteUserIdentity(ctx, sqlc.DeleteUserIdentityParams{
+		UserID:   userID,
+		Provider: provider,
+	})
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrIdentityNotFound
+	}
+	return nil
+}
+
+// UnlinkLoginMethod removes a login method in a transaction holding the
+// user's row lock, so the methods are counted and removed one unlink at a
+// time
+func (r *PostgresRepository) UnlinkLoginMethod(ctx context.Context, userID uuid.UUID, provider string) error {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		q := r.queries.WithTx(tx)
+		methods, err := q.LockUserLoginMethods(ctx, sqlc.LockUserLoginMethodsParams{
+			ID:       userID,
+			TenantID: tenant.ID(ctx),
+		})
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrUserNotFound
+			}
+			return err
+		}
+		remaining := methods.Identities
+		if methods.HasPassword {
+			remaining++
+		}
+
+		var rows int64
+		if provider == ProviderPassword {
+			rows, err = q.ClearUserPassword(ctx, sqlc.ClearUserPasswordParams{
+				ID:       userID,
+				TenantID: tenant.ID(ctx),
+			})
+		} else {
+			rows, err = q.DeleteUserIdentity(ctx, sqlc.DeleteUserIdentityParams{
+				UserID:   userID,
+				Provider: provider,
+			})
+		}
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return ErrIdentityNotFound
+		}
+		// Rolled back with the error
+		if remaining <= 1 {
+			return ErrLastLoginMethod
+		}
+		return nil
+	})
+}
+
+// GetAvatar returns the user's avatar
+func (r *PostgresRepository) GetAvatar(ctx context.Context, userID uuid.UUID) (*Avatar, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	dbAvatar, err := r.queries.GetUserAvatar(ctx, userID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrAvatarNotFound
+		}
+		return nil, err
+	}
+
+	return &Avatar{
+		UserID:      dbAvatar.UserID,
+		Key:         dbAvatar.Key,
+		ContentType: dbAvatar.ContentType,
+		SizeBytes:   int(dbAvatar.SizeBytes),
+		UpdatedAt:   dbAvatar.UpdatedAt.Time,
+	}, nil
+}
+
+// SetAvatar records the user's avatar, replacing any previous one
+func (r *PostgresRepository) SetAvatar(ctx context.Context, avatar *Avatar) error {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return r.queries.UpsertUserAvatar(ctx, sqlc.UpsertUserAvatarParams{
+		UserID:      avatar.UserID,
+		Key:         avatar.Key,
+		ContentType: avatar.ContentType,
+		SizeBytes:   int32(avatar.SizeBytes),
+		UpdatedAt:   sql.NullTime{Time: avatar.UpdatedAt, Valid: true},
+	})
+}
+
+// DeleteAvatar removes the user's avatar and returns its object key
+func (r *PostgresRepository) DeleteAvatar(ctx context.Context, userID uuid.UUID) (string, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer cancel()
+
+	key, err := r.queries.DeleteUserAvatar(ctx, userID)
+	if err == pgx.ErrNoRows {
+		return "", ErrAvatarNotFound
+	}
+	return key, err
+}
+
+// RecordActivity appends an entry to the activity log
+func (r *PostgresRepository) RecordActivity(ctx context.Context, activity *Activity) error {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	fields := activity.Fields
+	if fields == nil {
+		fields = []string{}
+	}
+	return r.queries.RecordUserActivity(ctx, sqlc.RecordUserActivityParams{
+		ID:        activity.ID,
+		UserID:    activity.UserID,
+		TenantID:  activity.TenantID,
+		Action:    activity.Action,
+		Fields:    fields,
+		IpAddress: activity.IPAddress,
+		UserAgent: activity.UserAgent,
+		CreatedAt: sql.NullTime{Time: activity.CreatedAt, Valid: true},
+	})
+}
+
+// ListActivity returns a page of a user's activity, newest first
+func (r *PostgresRepository) ListActivity(ctx context.Context, userID uuid.UUID, limit, offset int) ([]*Activity, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	rows, err := r.queries.ListUserActivity(ctx, sqlc.ListUserActivityParams{
+		UserID: userID,
+		Limit:  int32(limit),
+		Offset: int32(offset),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	activity := make([]*Activity, len(rows))
+	for i, row := range rows {
+		activity[i] = &Activity{
+			ID:        row.ID,
+			UserID:    row.UserID,
+			TenantID:  row.TenantID,
+			Action:    row.Action,
+			Fields:    row.Fields,
+			IPAddress: row.IpAddress,
+			UserAgent: row.UserAgent,
+			CreatedAt: row.CreatedAt.Time,
+		}
+	}
+	return activity, nil
+}
+
+// CountActivity returns how many activity entries a user has
+func (r *PostgresRepository) CountActivity(ctx context.Context, userID uuid.UUID) (int64, error) {
+	ctx, cancel, err := budget.DB.WithTimeout(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer cancel()
+
+	return r.queries.CountUserActivity(ctx, userID)
+}
+
+// likeEscaper escapes LIKE wildcards so searches match them literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func usersFromDB(dbUsers []*sqlc.User) []*User {
 	users := make([]*User, len(dbUsers))
 	for i, dbUser := range dbUsers {
 		users[i] = &User{
-			ID:           dbUser.ID,
-			Email:        dbUser.Email,
-			Name:         pgTextToString(dbUser.Name),
-			PasswordHash: dbUser.PasswordHash,
-			Role:         dbUser.Role,
-			CreatedAt:    dbUser.CreatedAt.Time,
-			UpdatedAt:    dbUser.UpdatedAt.Time,
+			ID:            dbUser.ID,
+			Email:         dbUser.Email,
+			Name:          pgTextToString(dbUser.Name),
+			PasswordHash:  dbUser.PasswordHash,
+			Role:          dbUser.Role,
+			TenantID:      dbUser.TenantID,
+			EmailVerified: dbUser.EmailVerifiedAt.Valid,
+			Guest:         dbUser.IsGuest,
+			Suspended:     !dbUser.IsActive,
+			CreatedAt:     dbUser.CreatedAt.Time,
+			UpdatedAt:     dbUser.UpdatedAt.Time,
 		}
 	}
+	return users
+}
 
-	return users, count, nil
+func timeToPgTimestamptz(t time.Time) pgtype.Timestamptz {
+	if t.IsZero() {
+		return pgtype.Timestamptz{Valid: false}
+	}
+	return pgtype.Timestamptz{Time: t, Valid: true}
 }
 
 // Helper functions for null string handling
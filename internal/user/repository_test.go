@@ -0,0 +1,148 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pixperk/goiler/internal/tenant"
+	"github.com/pixperk/goiler/pkg/pagination"
+)
+
+// newTestPostgresRepository returns a repository on the Postgres at
+// TEST_DATABASE_URL, in a schema of its own holding every migration
+func newTestPostgresRepository(t *testing.T) (*PostgresRepository, *pgxpool.Pool) {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	ctx := context.Background()
+
+	schema := "goiler_test_" + uuid.NewString()[:8]
+	cfg, err := pgxpool.ParseConfig(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.ConnConfig.RuntimeParams["search_path"] = schema + ",public"
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() {
+		pool.Exec(context.Background(), "DROP SCHEMA "+schema+" CASCADE")
+		pool.Close()
+	})
+
+	if _, err := pool.Exec(ctx, "CREATE SCHEMA "+schema); err != nil {
+		t.Fatal(err)
+	}
+	migrations, err := filepath.Glob("../../db/migrations/*.up.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range migrations {
+		migration, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := pool.Exec(ctx, string(migration)); err != nil {
+			t.Fatalf("Failed to apply %s: %v", filepath.Base(name), err)
+		}
+	}
+	return NewPostgresRepository(pool), pool
+}
+
+func TestPostgresRepository_UnlinkLoginMethodConcurrently(t *testing.T) {
+	repo, pool := newTestPostgresRepository(t)
+	ctx := context.Background()
+
+	for round := range 20 {
+		// A password and one identity: only one of them can go
+		userID := uuid.New()
+		if _, err := pool.Exec(ctx, "INSERT INTO users (id, email, password_hash, tenant_id) VALUES ($1, $2, 'hash', $3)",
+			userID, userID.String()+"@example.com", tenant.DefaultID); err != nil {
+			t.Fatalf("Failed to insert user: %v", err)
+		}
+		if _, err := pool.Exec(ctx, "INSERT INTO user_identities (user_id, provider, provider_user_id) VALUES ($1, 'google', $2)",
+			userID, userID.String()); err != nil {
+			t.Fatalf("Failed to insert identity: %v", err)
+		}
+
+		var wg sync.WaitGroup
+		errs := make([]error, 2)
+		for i, provider := range []string{ProviderPassword, "google"} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs[i] = repo.UnlinkLoginMethod(ctx, userID, provider)
+			}()
+		}
+		wg.Wait()
+
+		removed, last := 0, 0
+		for _, err := range errs {
+			switch {
+			case err == nil:
+				removed++
+			case errors.Is(err, ErrLastLoginMethod):
+				last++
+			default:
+				t.Fatalf("round %d: unexpected error: %v", round, err)
+			}
+		}
+		if removed != 1 || last != 1 {
+			t.Fatalf("round %d: expected one unlink to win, got %v", round, errs)
+		}
+
+		var remaining int
+		err := pool.QueryRow(ctx, `SELECT (password_hash <> '')::int + (SELECT COUNT(*) FROM user_identities WHERE user_id = $1)
+			FROM users WHERE id = $1`, userID).Scan(&remaining)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if remaining != 1 {
+			t.Fatalf("round %d: expected 1 login method left, got %d", round, remaining)
+		}
+	}
+
+	// Unknown users are reported as not found
+	if err := repo.UnlinkLoginMethod(ctx, uuid.New(), "github"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+}
+
+func TestPostgresRepository_ListSearchesLiterally(t *testing.T) {
+	repo, pool := newTestPostgresRepository(t)
+	ctx := context.Background()
+
+	for _, name := range []string{"50% off", "5000 off", "a_b", "axb", `back\slash`} {
+		if _, err := pool.Exec(ctx, "INSERT INTO users (id, email, name, password_hash, tenant_id) VALUES ($1, $2, $3, 'hash', $4)",
+			uuid.New(), uuid.NewString()+"@example.com", name, tenant.DefaultID); err != nil {
+			t.Fatalf("Failed to insert user: %v", err)
+		}
+	}
+
+	tests := []struct {
+		search string
+		want   string
+	}{
+		{"0%", "50% off"},
+		{"a_b", "a_b"},
+		{`k\s`, `back\slash`},
+	}
+	for _, tt := range tests {
+		users, total, err := repo.List(ctx, ListFilter{Search: tt.search}, pagination.ListParams{Page: 1, PerPage: 10, Sort: SortCreatedAt})
+		if err != nil {
+			t.Fatalf("List(%q) failed: %v", tt.search, err)
+		}
+		if total != 1 || len(users) != 1 || users[0].Name != tt.want {
+			t.Errorf("Expected %q to match only %q, got %d users", tt.search, tt.want, total)
+		}
+	}
+}
@@ -7,50 +7,212 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/pixperk/goiler/internal/auth"
+	"github.com/pixperk/goiler/internal/rbac"
+	"github.com/pixperk/goiler/internal/tenant"
+	"github.com/pixperk/goiler/pkg/clock"
+	"github.com/pixperk/goiler/pkg/events"
+	"github.com/pixperk/goiler/pkg/idgen"
+	"github.com/pixperk/goiler/pkg/pagination"
 )
 
 var (
 	ErrUserNotFound    = errors.New("user not found")
 	ErrInvalidPassword = errors.New("invalid password")
 	ErrEmailTaken      = errors.New("email already taken")
+	// ErrGuestAccount is returned when a guest tries to set an email or
+	// password other than by upgrading
+	ErrGuestAccount = errors.New("guest account must be upgraded")
+
+	// ErrUnknownRole is returned when a user is given a role the RBAC
+	// policy doesn't define
+	ErrUnknownRole = errors.New("unknown role")
+	// ErrRoleNotAllowed is returned when a role change would grant or take
+	// away permissions the caller's role doesn't hold
+	ErrRoleNotAllowed = errors.New("role change not allowed")
+
+	ErrIdentityNotFound      = errors.New("identity not linked")
+	ErrIdentityAlreadyLinked = errors.New("identity already linked")
+	ErrLastLoginMethod       = errors.New("cannot remove the last login method")
 )
 
+// ProviderPassword identifies password logins among a user's identities
+const ProviderPassword = "password"
+
 // User represents a user entity
 type User struct {
-	ID           uuid.UUID `json:"id"`
-	Email        string    `json:"email"`
-	Name         string    `json:"name,omitempty"`
-	PasswordHash string    `json:"-"`
-	Role         string    `json:"role"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ID            uuid.UUID `json:"id"`
+	Email         string    `json:"email"`
+	Name          string    `json:"name,omitempty"`
+	PasswordHash  string    `json:"-"`
+	Role          string    `json:"role"`
+	TenantID      uuid.UUID `json:"tenant_id"`
+	EmailVerified bool      `json:"email_verified"`
+	Guest         bool      `json:"guest"` // no email or password until upgraded
+	Suspended     bool      `json:"suspended"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
 }
 
-// UserResponse represents user data in API responses
-type UserResponse struct {
+// Fields users can be listed by
+const (
+	SortCreatedAt = "created_at"
+	SortEmail     = "email"
+	SortName      = "name"
+)
+
+// ListSorts are the sort fields List accepts
+var ListSorts = []string{SortCreatedAt, SortEmail, SortName}
+
+// ListFilter narrows a user list. Zero fields don't filter.
+type ListFilter struct {
+	// Search is a case-insensitive substring of the name
+	Search string
+	// SearchEmail also matches Search against email addresses; only set it
+	// for callers allowed to see them
+	SearchEmail bool
+	Role        string
+	// CreatedAfter and CreatedBefore bound the creation time, inclusive and
+	// exclusive
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+}
+
+// ListPosition is a place in the user list by creation time, the keyset
+// that cursors encode
+type ListPosition struct {
+	CreatedAt time.Time `json:"t"`
 	ID        uuid.UUID `json:"id"`
-	Email     string    `json:"email"`
-	Name      string    `json:"name,omitempty"`
-	Role      string    `json:"role"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// listCursor is the content of a user list cursor: the position of the
+// last user of a page, or of the first one when paging back
+type listCursor struct {
+	ListPosition
+	Back bool `json:"b,omitempty"`
+}
+
+// UserResponse represents user data in API responses. Contact details are
+// only shown to callers holding users:read, except on the user's own
+// profile.
+type UserResponse struct {
+	ID            uuid.UUID           `json:"id"`
+	Email         string              `json:"email" visible:"users:read"`
+	Name          string              `json:"name,omitempty"`
+	Role          string              `json:"role"`
+	EmailVerified bool                `json:"email_verified" visible:"users:read"`
+	Guest         bool                `json:"guest,omitempty"`
+	Suspended     bool                `json:"suspended,omitempty" visible:"users:read"`
+	Identities    []*IdentityResponse `json:"identities,omitempty"`
+	CreatedAt     time.Time           `json:"created_at"`
+	UpdatedAt     time.Time           `json:"updated_at"`
+}
+
+// Identity is an external login identity (e.g. an OAuth provider account)
+// linked to a user
+type Identity struct {
+	ID             uuid.UUID
+	UserID         uuid.UUID
+	Provider       string
+	ProviderUserID string
+	Email          string
+	CreatedAt      time.Time
+}
+
+// IdentityResponse represents a login method in API responses. Like the
+// user's email, the identity's is only shown to callers holding users:read,
+// except to the user.
+type IdentityResponse struct {
+	Provider string     `json:"provider"`
+	Email    string     `json:"email,omitempty" visible:"users:read"`
+	LinkedAt *time.Time `json:"linked_at,omitempty"`
 }
 
 // Service handles user business logic
 type Service struct {
-	repo   Repository
-	hasher auth.PasswordHasher
+	repo     Repository
+	hasher   auth.PasswordHasher
+	clock    clock.Clock
+	ids      idgen.Generator
+	auditor  *auth.Auditor
+	policy   *auth.PasswordPolicy
+	avatars  *avatars
+	activity ActivityRepository
+	revoker  TokenRevoker
+	events   events.Publisher
+	roles    RoleAuthorizer
+}
+
+// RoleAuthorizer guards role changes: it checks the caller's permissions
+// and compares roles. rbac.Authorizer satisfies it.
+type RoleAuthorizer interface {
+	Authorize(ctx context.Context, perms ...rbac.Permission) error
+	HasRole(role string) bool
+	Covers(role, other string) bool
+}
+
+// ServiceOption configures a Service
+type ServiceOption func(*Service)
+
+// WithClock sets the clock used to stamp updates; defaults to the system clock
+func WithClock(c clock.Clock) ServiceOption {
+	return func(s *Service) {
+		s.clock = c
+	}
+}
+
+// WithIDGenerator sets the generator for new identity IDs; defaults to
+// idgen.Default
+func WithIDGenerator(ids idgen.Generator) ServiceOption {
+	return func(s *Service) {
+		s.ids = ids
+	}
+}
+
+// WithAuditor sets the auditor password changes are recorded with
+func WithAuditor(auditor *auth.Auditor) ServiceOption {
+	return func(s *Service) {
+		s.auditor = auditor
+	}
+}
+
+// WithPasswordPolicy sets the policy new passwords are checked against
+func WithPasswordPolicy(policy *auth.PasswordPolicy) ServiceOption {
+	return func(s *Service) {
+		s.policy = policy
+	}
+}
+
+// WithEventPublisher sets where profile updates and account deletions are
+// published, see events.UserUpdated and events.UserDeleted
+func WithEventPublisher(p events.Publisher) ServiceOption {
+	return func(s *Service) {
+		s.events = p
+	}
+}
+
+// WithRoleAuthorizer sets the authorizer role changes are checked with.
+// Without one any role is accepted.
+func WithRoleAuthorizer(roles RoleAuthorizer) ServiceOption {
+	return func(s *Service) {
+		s.roles = roles
+	}
 }
 
 // NewService creates a new user service
-func NewService(repo Repository, hasher auth.PasswordHasher) *Service {
+func NewService(repo Repository, hasher auth.PasswordHasher, opts ...ServiceOption) *Service {
 	if hasher == nil {
 		hasher = auth.DefaultPasswordHasher()
 	}
-	return &Service{
+	s := &Service{
 		repo:   repo,
 		hasher: hasher,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.clock = clock.OrReal(s.clock)
+	s.ids = idgen.OrDefault(s.ids)
+	return s
 }
 
 // GetByID retrieves a user by ID
@@ -61,12 +223,15 @@ func (s *Service) GetByID(ctx context.Context, id uuid.UUID) (*UserResponse, err
 	}
 
 	return &UserResponse{
-		ID:        user.ID,
-		Email:     user.Email,
-		Name:      user.Name,
-		Role:      user.Role,
-		CreatedAt: user.CreatedAt,
-		UpdatedAt: user.UpdatedAt,
+		ID:            user.ID,
+		Email:         user.Email,
+		Name:          user.Name,
+		Role:          user.Role,
+		EmailVerified: user.EmailVerified,
+		Guest:         user.Guest,
+		Suspended:     user.Suspended,
+		CreatedAt:     user.CreatedAt,
+		UpdatedAt:     user.UpdatedAt,
 	}, nil
 }
 
@@ -78,12 +243,15 @@ func (s *Service) GetByEmail(ctx context.Context, email string) (*UserResponse,
 	}
 
 	return &UserResponse{
-		ID:        user.ID,
-		Email:     user.Email,
-		Name:      user.Name,
-		Role:      user.Role,
-		CreatedAt: user.CreatedAt,
-		UpdatedAt: user.UpdatedAt,
+		ID:            user.ID,
+		Email:         user.Email,
+		Name:          user.Name,
+		Role:          user.Role,
+		EmailVerified: user.EmailVerified,
+		Guest:         user.Guest,
+		Suspended:     user.Suspended,
+		CreatedAt:     user.CreatedAt,
+		UpdatedAt:     user.UpdatedAt,
 	}, nil
 }
 
@@ -100,35 +268,67 @@ func (s *Service) Update(ctx context.Context, id uuid.UUID, req *UpdateRequest)
 		return nil, ErrUserNotFound
 	}
 
+	var changed []string
 	// Check if email is being changed and is already taken
 	if req.Email != "" && req.Email != user.Email {
+		if user.Guest {
+			return nil, ErrGuestAccount
+		}
 		existing, _ := s.repo.GetByEmail(ctx, req.Email)
 		if existing != nil {
 			return nil, ErrEmailTaken
 		}
 		user.Email = req.Email
+		changed = append(changed, "email")
 	}
 
-	if req.Name != "" {
+	if req.Name != "" && req.Name != user.Name {
 		user.Name = req.Name
+		changed = append(changed, "name")
 	}
 
-	user.UpdatedAt = time.Now()
+	user.UpdatedAt = s.clock.Now()
 
 	if err := s.repo.Update(ctx, user); err != nil {
 		return nil, err
 	}
+	if len(changed) > 0 {
+		events.UserUpdated.Publish(s.events, events.UserUpdatedEvent{
+			UserID:    user.ID,
+			TenantID:  tenant.ID(ctx),
+			Fields:    changed,
+			UpdatedAt: user.UpdatedAt,
+		})
+	}
 
 	return &UserResponse{
-		ID:        user.ID,
-		Email:     user.Email,
-		Name:      user.Name,
-		Role:      user.Role,
-		CreatedAt: user.CreatedAt,
-		UpdatedAt: user.UpdatedAt,
+		ID:            user.ID,
+		Email:         user.Email,
+		Name:          user.Name,
+		Role:          user.Role,
+		EmailVerified: user.EmailVerified,
+		Guest:         user.Guest,
+		Suspended:     user.Suspended,
+		CreatedAt:     user.CreatedAt,
+		UpdatedAt:     user.UpdatedAt,
 	}, nil
 }
 
+// VerifyPassword reports whether password is the user's, for confirming
+// who is making a sensitive request. Accounts without a password, such as
+// guests, have nothing to confirm with and always pass.
+func (s *Service) VerifyPassword(ctx context.Context, id uuid.UUID, password string) (bool, error) {
+	user, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return false, ErrUserNotFound
+	}
+	if user.PasswordHash == "" {
+		return true, nil
+	}
+	valid, err := s.hasher.Verify(password, user.PasswordHash)
+	return err == nil && valid, nil
+}
+
 // ChangePassword changes a user's password
 func (s *Service) ChangePassword(ctx context.Context, id uuid.UUID, currentPassword, newPassword string) error {
 	user, err := s.repo.GetByID(ctx, id)
@@ -142,6 +342,10 @@ func (s *Service) ChangePassword(ctx context.Context, id uuid.UUID, currentPassw
 		return ErrInvalidPassword
 	}
 
+	if err := s.policy.Check(ctx, newPassword); err != nil {
+		return err
+	}
+
 	// Hash new password
 	hash, err := s.hasher.Hash(newPassword)
 	if err != nil {
@@ -149,28 +353,74 @@ func (s *Service) ChangePassword(ctx context.Context, id uuid.UUID, currentPassw
 	}
 
 	user.PasswordHash = hash
-	user.UpdatedAt = time.Now()
+	user.UpdatedAt = s.clock.Now()
 
-	return s.repo.Update(ctx, user)
+	if err := s.repo.Update(ctx, user); err != nil {
+		return err
+	}
+	s.auditor.Record(ctx, auth.AuditPasswordChanged, user.ID, nil)
+	return nil
 }
 
-// Delete deletes a user account
-func (s *Service) Delete(ctx context.Context, id uuid.UUID) error {
-	return s.repo.Delete(ctx, id)
+// UpdateRole changes a user's role. With a RoleAuthorizer, the caller in
+// ctx must hold users:write, the role must exist, and the caller's role
+// must cover both the user's current role and the new one, so admins can't
+// grant or take away more than they hold.
+func (s *Service) UpdateRole(ctx context.Context, id uuid.UUID, role string) error {
+	if s.roles != nil {
+		if err := s.roles.Authorize(ctx, rbac.PermUsersWrite); err != nil {
+			return err
+		}
+		if !s.roles.HasRole(role) {
+			return ErrUnknownRole
+		}
+		caller, _ := auth.UserFromContext(ctx)
+		user, err := s.repo.GetByID(ctx, id)
+		if err != nil {
+			return ErrUserNotFound
+		}
+		if !s.roles.Covers(caller.Role, role) || !s.roles.Covers(caller.Role, user.Role) {
+			return ErrRoleNotAllowed
+		}
+	}
+	return s.repo.UpdateRole(ctx, id, role)
 }
 
-// List returns a paginated list of users
-func (s *Service) List(ctx context.Context, page, perPage int) ([]*UserResponse, int64, error) {
-	if page < 1 {
-		page = 1
-	}
-	if perPage < 1 || perPage > 100 {
-		perPage = 20
+// Delete deletes a user account, and its avatar image when avatars are
+// enabled
+func (s *Service) Delete(ctx context.Context, id uuid.UUID) error {
+	// The avatar's row goes with the user, so its key is read first
+	var avatarKey string
+	if s.avatars != nil {
+		avatar, err := s.avatars.repo.GetAvatar(ctx, id)
+		if err != nil && !errors.Is(err, ErrAvatarNotFound) {
+			return err
+		}
+		if avatar != nil {
+			avatarKey = avatar.Key
+		}
 	}
 
-	offset := (page - 1) * perPage
+	if err := s.repo.Delete(ctx, id); err != nil {
+		return err
+	}
+	if avatarKey != "" {
+		s.avatars.deleteObject(ctx, avatarKey)
+	}
+	events.UserDeleted.Publish(s.events, events.UserDeletedEvent{
+		UserID:    id,
+		TenantID:  tenant.ID(ctx),
+		DeletedAt: s.clock.Now(),
+	})
+	return nil
+}
 
-	users, total, err := s.repo.List(ctx, perPage, offset)
+// List returns a page of users
+func (s *Service) List(ctx context.Context, filter ListFilter, params pagination.ListParams) ([]*UserResponse, int64, error) {
+	if params.Sort == "" {
+		params.Sort = SortCreatedAt
+	}
+	users, total, err := s.repo.List(ctx, filter, params)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -178,14 +428,181 @@ func (s *Service) List(ctx context.Context, page, perPage int) ([]*UserResponse,
 	responses := make([]*UserResponse, len(users))
 	for i, user := range users {
 		responses[i] = &UserResponse{
-			ID:        user.ID,
-			Email:     user.Email,
-			Name:      user.Name,
-			Role:      user.Role,
-			CreatedAt: user.CreatedAt,
-			UpdatedAt: user.UpdatedAt,
+			ID:            user.ID,
+			Email:         user.Email,
+			Name:          user.Name,
+			Role:          user.Role,
+			EmailVerified: user.EmailVerified,
+			Guest:         user.Guest,
+			Suspended:     user.Suspended,
+			CreatedAt:     user.CreatedAt,
+			UpdatedAt:     user.UpdatedAt,
 		}
 	}
 
 	return responses, total, nil
 }
+
+// ListByCursor returns a keyset page of users, newest first, with the
+// cursors of the next and previous pages. An empty cursor starts at the
+// newest user; a cursor not from an earlier page returns
+// pagination.ErrInvalidCursor.
+func (s *Service) ListByCursor(ctx context.Context, filter ListFilter, cursor string, limit int) ([]*UserResponse, string, string, error) {
+	var pos listCursor
+	if cursor != "" {
+		if err := pagination.DecodeCursor(cursor, &pos); err != nil {
+			return nil, "", "", err
+		}
+		if pos.ID == uuid.Nil || pos.CreatedAt.IsZero() {
+			return nil, "", "", pagination.ErrInvalidCursor
+		}
+	}
+
+	// One extra row tells whether there is a page beyond this one
+	var users []*User
+	var err error
+	switch {
+	case cursor == "":
+		users, err = s.repo.ListAfter(ctx, filter, nil, limit+1)
+	case pos.Back:
+		users, err = s.repo.ListBefore(ctx, filter, pos.ListPosition, limit+1)
+	default:
+		users, err = s.repo.ListAfter(ctx, filter, &pos.ListPosition, limit+1)
+	}
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	more := len(users) > limit
+	if more {
+		if pos.Back {
+			// Paging back keeps the users closest to the cursor, the oldest
+			users = users[1:]
+		} else {
+			users = users[:limit]
+		}
+	}
+
+	// Paging forward, there are newer users if we started from a cursor;
+	// paging back, there are older users: the ones we came from
+	hasNext, hasPrev := more, cursor != ""
+	if pos.Back {
+		hasNext, hasPrev = true, more
+	}
+
+	var next, prev string
+	if len(users) > 0 {
+		if hasNext {
+			last := users[len(users)-1]
+			if next, err = pagination.EncodeCursor(listCursor{ListPosition: ListPosition{CreatedAt: last.CreatedAt, ID: last.ID}}); err != nil {
+				return nil, "", "", err
+			}
+		}
+		if hasPrev {
+			first := users[0]
+			if prev, err = pagination.EncodeCursor(listCursor{ListPosition: ListPosition{CreatedAt: first.CreatedAt, ID: first.ID}, Back: true}); err != nil {
+				return nil, "", "", err
+			}
+		}
+	}
+
+	responses := make([]*UserResponse, len(users))
+	for i, user := range users {
+		responses[i] = &UserResponse{
+			ID:            user.ID,
+			Email:         user.Email,
+			Name:          user.Name,
+			Role:          user.Role,
+			EmailVerified: user.EmailVerified,
+			Guest:         user.Guest,
+			Suspended:     user.Suspended,
+			CreatedAt:     user.CreatedAt,
+			UpdatedAt:     user.UpdatedAt,
+		}
+	}
+	return responses, next, prev, nil
+}
+
+// ListIdentities returns every login method of a user, including password
+// login if the user has a password
+func (s *Service) ListIdentities(ctx context.Context, userID uuid.UUID) ([]*IdentityResponse, error) {
+	user, err := s.repo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	identities, err := s.repo.ListIdentities(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	responses := make([]*IdentityResponse, 0, len(identities)+1)
+	if user.PasswordHash != "" {
+		responses = append(responses, &IdentityResponse{Provider: ProviderPassword, Email: user.Email})
+	}
+	for _, identity := range identities {
+		linkedAt := identity.CreatedAt
+		responses = append(responses, &IdentityResponse{
+			Provider: identity.Provider,
+			Email:    identity.Email,
+			LinkedAt: &linkedAt,
+		})
+	}
+
+	return responses, nil
+}
+
+// LinkIdentity links an external provider identity to a user. It is meant
+// to be called by provider callbacks once the identity has been verified.
+func (s *Service) LinkIdentity(ctx context.Context, userID uuid.UUID, provider, providerUserID, email string) error {
+	if provider == ProviderPassword {
+		return ErrIdentityAlreadyLinked
+	}
+
+	return s.repo.CreateIdentity(ctx, &Identity{
+		ID:             s.ids.NewID(),
+		UserID:         userID,
+		Provider:       provider,
+		ProviderUserID: providerUserID,
+		Email:          email,
+		CreatedAt:      s.clock.Now(),
+	})
+}
+
+// SetPassword adds password login to a user who doesn't have a password yet
+func (s *Service) SetPassword(ctx context.Context, userID uuid.UUID, password string) error {
+	user, err := s.repo.GetByID(ctx, userID)
+	if err != nil {
+		return ErrUserNotFound
+	}
+	if user.Guest {
+		return ErrGuestAccount
+	}
+	if user.PasswordHash != "" {
+		return ErrIdentityAlreadyLinked
+	}
+
+	if err := s.policy.Check(ctx, password); err != nil {
+		return err
+	}
+
+	hash, err := s.hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+
+	user.PasswordHash = hash
+	user.UpdatedAt = s.clock.Now()
+
+	if err := s.repo.Update(ctx, user); err != nil {
+		return err
+	}
+	s.auditor.Record(ctx, auth.AuditPasswordChanged, user.ID, map[string]any{"initial": true})
+	return nil
+}
+
+// UnlinkIdentity removes a login method from a user. The last remaining
+// login method can't be removed.
+func (s *Service) UnlinkIdentity(ctx context.Context, userID uuid.UUID, provider string) error {
+	return s.repo.UnlinkLoginMethod(ctx, userID, provider)
+}
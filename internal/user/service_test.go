@@ -0,0 +1,186 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pixperk/goiler/internal/auth"
+	"github.com/pixperk/goiler/internal/rbac"
+	"github.com/pixperk/goiler/pkg/pagination"
+)
+
+func TestService_UpdateRole(t *testing.T) {
+	authz := rbac.NewAuthorizer(nil, nil)
+	if err := authz.Reload(context.Background(), rbac.StaticSource{
+		"admin":   {rbac.PermAll},
+		"support": {rbac.PermAdminAccess, rbac.PermUsersRead, rbac.PermUsersWrite},
+		"auditor": {rbac.PermAdminAccess, rbac.PermUsersRead},
+		"user":    {},
+	}); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+
+	target := &User{ID: uuid.New(), Email: "user@example.com", Role: "user"}
+	boss := &User{ID: uuid.New(), Email: "admin@example.com", Role: "admin"}
+	repo := newMemoryRepository(target, boss)
+	svc := NewService(repo, nil, WithRoleAuthorizer(authz))
+
+	as := func(role string) context.Context {
+		return auth.ContextWithUser(context.Background(), &auth.TokenPayload{UserID: uuid.New(), Role: role})
+	}
+
+	tests := []struct {
+		name   string
+		caller string
+		userID uuid.UUID
+		role   string
+		want   error
+	}{
+		{"unauthenticated", "", target.ID, "auditor", rbac.ErrUnauthenticated},
+		{"without users:write", "auditor", target.ID, "auditor", rbac.ErrForbidden},
+		{"unknown role", "admin", target.ID, "superuser", ErrUnknownRole},
+		{"unknown user", "admin", uuid.New(), "auditor", ErrUserNotFound},
+		{"granting more", "support", target.ID, "admin", ErrRoleNotAllowed},
+		{"demoting more", "support", boss.ID, "user", ErrRoleNotAllowed},
+		{"granting less", "support", target.ID, "auditor", nil},
+	}
+	for _, tt := range tests {
+		ctx := context.Background()
+		if tt.caller != "" {
+			ctx = as(tt.caller)
+		}
+		if err := svc.UpdateRole(ctx, tt.userID, tt.role); !errors.Is(err, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, err)
+		}
+	}
+
+	if u, _ := repo.GetByID(context.Background(), target.ID); u.Role != "auditor" {
+		t.Errorf("Expected the role to be updated, got %s", u.Role)
+	}
+	if u, _ := repo.GetByID(context.Background(), boss.ID); u.Role != "admin" {
+		t.Errorf("Expected refused changes to be skipped, got %s", u.Role)
+	}
+}
+
+// follows reports whether u comes after pos in the newest first order
+func follows(u *User, pos ListPosition) bool {
+	if !u.CreatedAt.Equal(pos.CreatedAt) {
+		return u.CreatedAt.Before(pos.CreatedAt)
+	}
+	return bytes.Compare(u.ID[:], pos.ID[:]) < 0
+}
+
+// newestFirst returns the repository's users sorted like ListUsersAfter
+func (r *memoryRepository) newestFirst() []*User {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	users := make([]*User, 0, len(r.users))
+	for _, u := range r.users {
+		copied := *u
+		users = append(users, &copied)
+	}
+	slices.SortFunc(users, func(a, b *User) int {
+		if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+			return c
+		}
+		return bytes.Compare(b.ID[:], a.ID[:])
+	})
+	return users
+}
+
+func (r *memoryRepository) ListAfter(ctx context.Context, filter ListFilter, after *ListPosition, limit int) ([]*User, error) {
+	var page []*User
+	for _, u := range r.newestFirst() {
+		if len(page) < limit && (after == nil || follows(u, *after)) {
+			page = append(page, u)
+		}
+	}
+	return page, nil
+}
+
+func (r *memoryRepository) ListBefore(ctx context.Context, filter ListFilter, before ListPosition, limit int) ([]*User, error) {
+	cursor := &User{CreatedAt: before.CreatedAt, ID: before.ID}
+	var newer []*User
+	for _, u := range r.newestFirst() {
+		if follows(cursor, ListPosition{CreatedAt: u.CreatedAt, ID: u.ID}) {
+			newer = append(newer, u)
+		}
+	}
+	if len(newer) > limit {
+		newer = newer[len(newer)-limit:]
+	}
+	return newer, nil
+}
+
+func TestService_ListByCursor(t *testing.T) {
+	start := time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+	// users[0] is the oldest; users[1] and users[2] were created at the same
+	// time, so the ID breaks the tie
+	users := make([]*User, 5)
+	for i := range users {
+		users[i] = &User{ID: uuid.New(), Email: fmt.Sprintf("user%d@example.com", i), CreatedAt: start.Add(time.Duration(i) * time.Minute)}
+	}
+	users[2].CreatedAt = users[1].CreatedAt
+	if bytes.Compare(users[2].ID[:], users[1].ID[:]) < 0 {
+		users[1].ID, users[2].ID = users[2].ID, users[1].ID
+	}
+	svc := NewService(newMemoryRepository(users...), nil)
+	ctx := context.Background()
+
+	page := func(cursor string, want ...int) (string, string) {
+		t.Helper()
+		got, next, prev, err := svc.ListByCursor(ctx, ListFilter{}, cursor, 2)
+		if err != nil {
+			t.Fatalf("ListByCursor failed: %v", err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("Expected %d users, got %d", len(want), len(got))
+		}
+		for i, w := range want {
+			if got[i].ID != users[w].ID {
+				t.Fatalf("Expected user %d at %d, got %s", w, i, got[i].Email)
+			}
+		}
+		return next, prev
+	}
+
+	next, prev := page("", 4, 3)
+	if next == "" || prev != "" {
+		t.Fatalf("Expected only a next page from the start, got next %q prev %q", next, prev)
+	}
+	next, prev = page(next, 2, 1)
+	if next == "" || prev == "" {
+		t.Fatal("Expected next and previous pages in the middle")
+	}
+	last, lastPrev := page(next, 0)
+	if last != "" || lastPrev == "" {
+		t.Fatalf("Expected only a previous page at the end, got next %q prev %q", last, lastPrev)
+	}
+
+	// Walking back returns the same pages, newest first
+	back, backPrev := page(lastPrev, 2, 1)
+	if back == "" || backPrev == "" {
+		t.Fatal("Expected next and previous pages walking back through the middle")
+	}
+	first, firstPrev := page(backPrev, 4, 3)
+	if first == "" || firstPrev != "" {
+		t.Fatalf("Expected only a next page back at the start, got next %q prev %q", first, firstPrev)
+	}
+	if again, _ := page(first, 2, 1); again == "" {
+		t.Error("Expected the next page's cursor to lead on from the start again")
+	}
+
+	if _, _, _, err := svc.ListByCursor(ctx, ListFilter{}, "not-a-cursor", 2); !errors.Is(err, pagination.ErrInvalidCursor) {
+		t.Errorf("Expected ErrInvalidCursor, got %v", err)
+	}
+	empty, _ := pagination.EncodeCursor(listCursor{})
+	if _, _, _, err := svc.ListByCursor(ctx, ListFilter{}, empty, 2); !errors.Is(err, pagination.ErrInvalidCursor) {
+		t.Errorf("Expected a cursor without a position to be rejected, got %v", err)
+	}
+}
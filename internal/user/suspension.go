@@ -0,0 +1,48 @@
+package user
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+// ErrSuspendSelf is returned when an admin tries to suspend their own
+// account
+var ErrSuspendSelf = errors.New("cannot suspend your own account")
+
+// TokenRevoker signs a user out everywhere. *auth.Service satisfies it.
+type TokenRevoker interface {
+	RevokeUserTokens(ctx context.Context, userID uuid.UUID, reason string) error
+}
+
+// WithTokenRevoker sets what signs suspended users out. Without one,
+// suspended users keep their refresh tokens, although they can't use them.
+func WithTokenRevoker(r TokenRevoker) ServiceOption {
+	return func(s *Service) {
+		s.revoker = r
+	}
+}
+
+// Suspend deactivates a user's account on behalf of adminID. The account
+// keeps its data but can't log in or refresh its tokens until unsuspended,
+// and its refresh tokens and sessions are revoked.
+func (s *Service) Suspend(ctx context.Context, adminID, id uuid.UUID) error {
+	if adminID == id {
+		return ErrSuspendSelf
+	}
+	if err := s.repo.SetActive(ctx, id, false); err != nil {
+		return err
+	}
+	if s.revoker != nil {
+		if err := s.revoker.RevokeUserTokens(ctx, id, "suspended"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Unsuspend reactivates a suspended account
+func (s *Service) Unsuspend(ctx context.Context, id uuid.UUID) error {
+	return s.repo.SetActive(ctx, id, true)
+}
@@ -0,0 +1,269 @@
+// Package websocket wires pkg/websocket into the app, configuring the hub
+// from config.WebSocketConfig.
+package websocket
+
+import (
+	"compress/flate"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/pixperk/goiler/internal/auth"
+	"github.com/pixperk/goiler/internal/config"
+	"github.com/pixperk/goiler/internal/tenant"
+	"github.com/pixperk/goiler/internal/user"
+	"github.com/pixperk/goiler/pkg/authctx"
+	"github.com/pixperk/goiler/pkg/pubsub"
+	"github.com/pixperk/goiler/pkg/redisconn"
+	"github.com/pixperk/goiler/pkg/sandbox"
+	"github.com/pixperk/goiler/pkg/websocket"
+)
+
+// New creates a hub and its connection handler, with an RPC router,
+// connection quotas, origin checks, message limits, room roles, session
+// resumption, slow client eviction and compression configured from cfg.
+// Register RPC methods on hub.RPCRouter() and start the hub with Run.
+// extra configures the handler further, after cfg.
+func New(cfg config.WebSocketConfig, logger *slog.Logger, extra ...HandlerOption) (*websocket.Hub, *websocket.Handler, error) {
+	for name, n := range map[string]int{
+		"WS_MAX_CONNECTIONS_PER_IP": cfg.MaxConnectionsPerIP,
+		"WS_READ_BUFFER_SIZE":       cfg.ReadBufferSize,
+		"WS_WRITE_BUFFER_SIZE":      cfg.WriteBufferSize,
+		"WS_MAX_MESSAGE_SIZE":       cfg.MaxMessageSize,
+		"WS_COMPRESSION_THRESHOLD":  cfg.CompressionThreshold,
+		"WS_HUB_SHARDS":             cfg.HubShards,
+	} {
+		if n < 0 {
+			return nil, nil, fmt.Errorf("%s must not be negative, got %d", name, n)
+		}
+	}
+	if cfg.CompressionLevel < flate.HuffmanOnly || cfg.CompressionLevel > flate.BestCompression {
+		return nil, nil, fmt.Errorf("WS_COMPRESSION_LEVEL must be between %d and %d, got %d", flate.HuffmanOnly, flate.BestCompression, cfg.CompressionLevel)
+	}
+
+	hub := websocket.NewHub(logger, websocket.WithShards(cfg.HubShards))
+	if err := hub.SetConnectionQuota(cfg.MaxConnectionsPerUser, cfg.QuotaPolicy); err != nil {
+		return nil, nil, err
+	}
+	if err := hub.SetRateLimit(websocket.RateLimit{
+		MessagesPerSecond:     cfg.RateLimit,
+		Burst:                 cfg.RateBurst,
+		RoomMessagesPerSecond: cfg.RoomRateLimit,
+		RoomBurst:             cfg.RoomRateBurst,
+		Policy:                cfg.RateLimitPolicy,
+	}); err != nil {
+		return nil, nil, err
+	}
+	if err := hub.SetResume(cfg.ResumeWindow, cfg.ResumeHistory); err != nil {
+		return nil, nil, err
+	}
+	if err := hub.SetSlowClientPolicy(websocket.SlowClientPolicy{
+		MaxDrops:   cfg.SlowClientMaxDrops,
+		MaxLatency: cfg.SlowClientMaxLatency,
+		Window:     cfg.SlowClientWindow,
+	}); err != nil {
+		return nil, nil, err
+	}
+	if authorizer := roleAuthorizer(cfg); authorizer != nil {
+		hub.SetRoomAuthorizer(authorizer)
+	}
+	hub.SetRPCRouter(websocket.NewRPCRouter(
+		websocket.WithRPCTimeout(cfg.RPCTimeout),
+		websocket.WithRPCConcurrency(cfg.RPCMaxConcurrent),
+		websocket.WithRPCLogger(logger),
+	))
+	opts := []websocket.HandlerOption{
+		websocket.WithAllowedOrigins(cfg.AllowedOrigins...),
+		websocket.WithMaxConnectionsPerIP(cfg.MaxConnectionsPerIP),
+		websocket.WithBufferSizes(cfg.ReadBufferSize, cfg.WriteBufferSize),
+		websocket.WithMaxMessageSize(int64(cfg.MaxMessageSize)),
+		websocket.WithPongTimeout(cfg.PongTimeout),
+		websocket.WithPushKeys(cfg.PushAPIKeys...),
+	}
+	if cfg.Compression {
+		opts = append(opts, websocket.WithCompression(cfg.CompressionLevel, cfg.CompressionThreshold))
+	}
+	opts = append(opts, extra...)
+	return hub, websocket.NewHandler(hub, logger, opts...), nil
+}
+
+// roleAuthorizer returns the room roles of cfg, or nil without any
+func roleAuthorizer(cfg config.WebSocketConfig) websocket.RoomAuthorizer {
+	if len(cfg.RoomJoinRoles) == 0 && len(cfg.RoomPublishRoles) == 0 {
+		return nil
+	}
+	return websocket.RoleAuthorizer{
+		Join:    cfg.RoomJoinRoles,
+		Publish: cfg.RoomPublishRoles,
+	}
+}
+
+// TenantRooms keeps clients to the rooms of their tenant, named
+// "<tenant ID>:<room>", so room traffic doesn't cross tenants. Next, if
+// set, decides on the rest of the name. Shared rooms, such as the live
+// metrics room, are left to Next and RestrictRoom. The server still pushes
+// to any room.
+type TenantRooms struct {
+	Next   websocket.RoomAuthorizer
+	Shared []string
+}
+
+// CanJoin implements websocket.RoomAuthorizer
+func (a TenantRooms) CanJoin(client *Client, room string) bool {
+	room, ok := a.scope(client, room)
+	return ok && (a.Next == nil || a.Next.CanJoin(client, room))
+}
+
+// CanPublish implements websocket.RoomAuthorizer
+func (a TenantRooms) CanPublish(client *Client, room string) bool {
+	room, ok := a.scope(client, room)
+	return ok && (a.Next == nil || a.Next.CanPublish(client, room))
+}
+
+// scope returns room without the client's tenant prefix, and false if
+// room belongs to another tenant
+func (a TenantRooms) scope(client *Client, room string) (string, bool) {
+	if slices.Contains(a.Shared, room) {
+		return room, true
+	}
+	return strings.CutPrefix(room, TenantRoom(tenant.ID(client.Context()), ""))
+}
+
+// TenantRoom returns the name of a tenant's room under TenantRooms
+func TenantRoom(tenantID uuid.UUID, room string) string {
+	return tenantID.String() + ":" + room
+}
+
+// IsolateTenants installs TenantRooms on hub, in front of the room roles
+// of cfg, for when tenancy is on. The live metrics room stays shared.
+func IsolateTenants(cfg config.WebSocketConfig, hub *websocket.Hub) {
+	hub.SetRoomAuthorizer(TenantRooms{
+		Next:   roleAuthorizer(cfg),
+		Shared: []string{websocket.MetricsRoom},
+	})
+}
+
+// Broker relays hub's broadcasts between instances as WS_BROKER selects. It
+// returns nil when broadcasts stay on this instance. Start the returned hub
+// with Run.
+func Broker(cfg config.WebSocketConfig, redisCfg config.RedisConfig, hub *websocket.Hub, logger *slog.Logger) (*websocket.BrokerHub, error) {
+	switch cfg.Broker {
+	case "":
+		return nil, nil
+	case "redis":
+		broker := websocket.NewRedisBroker(redisconn.NewClient(redisCfg), cfg.BrokerChannel)
+		return websocket.NewBrokerHub(hub, broker, logger), nil
+	default:
+		return nil, fmt.Errorf("unknown websocket broker %q", cfg.Broker)
+	}
+}
+
+// Sender publishes messages for users to the API instances through the
+// broker WS_BROKER selects, for processes without a hub such as the worker.
+// It returns nil without a broker, as no instance would receive them.
+func Sender(cfg config.WebSocketConfig, redisCfg config.RedisConfig, logger *slog.Logger) (*websocket.BrokerSender, error) {
+	switch cfg.Broker {
+	case "":
+		return nil, nil
+	case "redis":
+		broker := websocket.NewRedisBroker(redisconn.NewClient(redisCfg), cfg.BrokerChannel)
+		return websocket.NewBrokerSender(broker, logger), nil
+	default:
+		return nil, fmt.Errorf("unknown websocket broker %q", cfg.Broker)
+	}
+}
+
+// RelayTaskProgress sends the task.progress events published on bus to the
+// users they're for through sender, a hub or a broker Sender, until ctx is
+// done
+func RelayTaskProgress(ctx context.Context, bus *pubsub.PubSub, sender websocket.UserSender, logger *slog.Logger) {
+	websocket.RelayTaskProgress(ctx, bus, sender, logger)
+}
+
+// Authenticator validates the tokens WebSocket clients send in the token
+// query parameter or the authenticate handshake with service, like the auth
+// middleware: tokens must belong to the tenant and sandbox mode of the
+// upgrade request.
+func Authenticator(service *auth.Service) websocket.TokenAuthenticator {
+	return websocket.TokenAuthenticatorFunc(func(ctx context.Context, token string) (*authctx.User, error) {
+		payload, err := service.ValidateToken(ctx, token)
+		if err != nil {
+			return nil, err
+		}
+		if payload.Sandbox != sandbox.Enabled(ctx) || payload.Tenant() != tenant.ID(ctx) {
+			return nil, auth.ErrInvalidToken
+		}
+		return payload.AuthUser(), nil
+	})
+}
+
+// HandlerOption configures the connection handler
+type HandlerOption = websocket.HandlerOption
+
+// UserFinder finds users in the tenant of ctx, such as user.Repository
+type UserFinder interface {
+	GetByID(ctx context.Context, id uuid.UUID) (*user.User, error)
+}
+
+// PushWithinTenant keeps the messages users push to users within their
+// tenant: recipients users can't find are rejected with 404
+func PushWithinTenant(users UserFinder) HandlerOption {
+	return websocket.WithPushRecipients(func(ctx context.Context, userID string) (bool, error) {
+		id, err := uuid.Parse(userID)
+		if err != nil {
+			return false, nil
+		}
+		if _, err := users.GetByID(ctx, id); err != nil {
+			if errors.Is(err, user.ErrUserNotFound) {
+				return false, nil
+			}
+			return false, err
+		}
+		return true, nil
+	})
+}
+
+// MetricFunc reads a live metric
+type MetricFunc = websocket.MetricFunc
+
+// Client is a WebSocket connection
+type Client = websocket.Client
+
+// Live metric names, as selected by WS_LIVE_METRICS
+const (
+	MetricActiveRequests   = "active_requests"
+	MetricConnectedClients = "connected_clients"
+	MetricQueueDepth       = "queue_depth"
+)
+
+// LiveMetrics creates the streamer behind the metrics:live room, streaming
+// the metrics in cfg.LiveMetrics out of available, and restricts the room to
+// the clients admin accepts. It returns nil when the interval is zero.
+// Selected metrics that aren't available, such as the queue depth without
+// the scaling monitor, are left out with a warning.
+func LiveMetrics(cfg config.WebSocketConfig, hub *websocket.Hub, available map[string]MetricFunc, admin func(*Client) bool, logger *slog.Logger) (*websocket.MetricsStreamer, error) {
+	if cfg.LiveMetricsInterval <= 0 {
+		return nil, nil
+	}
+
+	selected := make(map[string]websocket.MetricFunc, len(cfg.LiveMetrics))
+	for _, name := range cfg.LiveMetrics {
+		switch name {
+		case MetricActiveRequests, MetricConnectedClients, MetricQueueDepth:
+		default:
+			return nil, fmt.Errorf("unknown live metric %q", name)
+		}
+		fn, ok := available[name]
+		if !ok {
+			logger.Warn("live metric unavailable", slog.String("metric", name))
+			continue
+		}
+		selected[name] = fn
+	}
+	hub.RestrictRoom(websocket.MetricsRoom, admin)
+	return websocket.NewMetricsStreamer(hub, cfg.LiveMetricsInterval, selected, logger), nil
+}
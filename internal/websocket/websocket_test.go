@@ -0,0 +1,65 @@
+package websocket
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/internal/config"
+	"github.com/pixperk/goiler/internal/tenant"
+	"github.com/pixperk/goiler/internal/testutil"
+	"github.com/pixperk/goiler/pkg/websocket"
+)
+
+func TestIsolateTenants(t *testing.T) {
+	logger := testutil.Logger()
+	hub := websocket.NewHub(logger)
+	go hub.Run()
+	IsolateTenants(config.WebSocketConfig{RoomJoinRoles: map[string][]string{"ops:": {"admin"}}}, hub)
+
+	// Requests name their tenant in a header, as the resolver would
+	e := echo.New()
+	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			id := uuid.MustParse(c.Request().Header.Get("X-Tenant"))
+			c.SetRequest(c.Request().WithContext(tenant.WithTenant(c.Request().Context(), &tenant.Tenant{ID: id})))
+			return next(c)
+		}
+	})
+	e.GET("/events", websocket.NewHandler(hub, logger).HandleEvents)
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	own, other := uuid.New(), uuid.New()
+	tests := []struct {
+		name string
+		room string
+		want int
+	}{
+		{"own tenant", TenantRoom(own, "chat:general"), http.StatusOK},
+		{"other tenant", TenantRoom(other, "chat:general"), http.StatusForbidden},
+		{"no tenant", "chat:general", http.StatusForbidden},
+		{"role prefix after the tenant", TenantRoom(own, "ops:alerts"), http.StatusForbidden},
+		{"shared", websocket.MetricsRoom, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/events?rooms="+tt.room, nil)
+			req.Header.Set("X-Tenant", own.String())
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("GET failed: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("Expected %d for %s, got %d", tt.want, tt.room, resp.StatusCode)
+			}
+		})
+	}
+}
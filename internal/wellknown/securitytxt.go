@@ -0,0 +1,88 @@
+package wellknown
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/pkg/clock"
+)
+
+// DefaultSecurityValidity is how far ahead security.txt expires unless
+// configured otherwise
+const DefaultSecurityValidity = 180 * 24 * time.Hour
+
+// SecurityText is a security.txt file (RFC 9116), telling researchers how
+// to report vulnerabilities
+type SecurityText struct {
+	// Contacts are mailto:, tel: or https: URIs; at least one is required
+	Contacts        []string
+	Policy          string
+	Encryption      string
+	Acknowledgments string
+	Hiring          string
+	Languages       []string
+	Canonical       string
+	// Validity is how far ahead of now the Expires field is set
+	Validity time.Duration
+}
+
+// Validate checks that there is a contact and that every field that takes
+// a URI has one
+func (t *SecurityText) Validate() error {
+	if len(t.Contacts) == 0 {
+		return fmt.Errorf("%w: security.txt needs a contact", ErrInvalidDocument)
+	}
+	uris := append([]string{t.Policy, t.Encryption, t.Acknowledgments, t.Hiring, t.Canonical}, t.Contacts...)
+	for _, uri := range uris {
+		if uri == "" {
+			continue
+		}
+		if u, err := url.Parse(uri); err != nil || u.Scheme == "" {
+			return fmt.Errorf("%w: security.txt value %q is not a URI", ErrInvalidDocument, uri)
+		}
+	}
+	return nil
+}
+
+// Render writes the file as of now. Expires is rounded down to the day, so
+// the file only changes daily.
+func (t *SecurityText) Render(now time.Time) string {
+	validity := t.Validity
+	if validity <= 0 {
+		validity = DefaultSecurityValidity
+	}
+	expires := now.UTC().Add(validity).Truncate(24 * time.Hour)
+
+	var b strings.Builder
+	for _, contact := range t.Contacts {
+		fmt.Fprintf(&b, "Contact: %s\n", contact)
+	}
+	fmt.Fprintf(&b, "Expires: %s\n", expires.Format(time.RFC3339))
+	fields := []struct{ name, value string }{
+		{"Encryption", t.Encryption},
+		{"Acknowledgments", t.Acknowledgments},
+		{"Policy", t.Policy},
+		{"Hiring", t.Hiring},
+		{"Preferred-Languages", strings.Join(t.Languages, ", ")},
+		{"Canonical", t.Canonical},
+	}
+	for _, f := range fields {
+		if f.value != "" {
+			fmt.Fprintf(&b, "%s: %s\n", f.name, f.value)
+		}
+	}
+	return b.String()
+}
+
+// Handler serves the file, rendered with clk's time
+func (t *SecurityText) Handler(clk clock.Clock) echo.HandlerFunc {
+	clk = clock.OrReal(clk)
+	return func(c echo.Context) error {
+		setCacheControl(c)
+		return c.Blob(http.StatusOK, echo.MIMETextPlainCharsetUTF8, []byte(t.Render(clk.Now())))
+	}
+}
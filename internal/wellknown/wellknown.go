@@ -0,0 +1,157 @@
+// Package wellknown serves the documents under /.well-known (RFC 8615):
+// security.txt, the change-password redirect, the Android and iOS app
+// association files, and documents features register themselves, such as
+// the JWKS or, later, OpenID Connect discovery.
+package wellknown
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/internal/config"
+	"github.com/pixperk/goiler/pkg/clock"
+)
+
+// Prefix is the path documents are served under
+const Prefix = "/.well-known"
+
+// Document names
+const (
+	SecurityTxt             = "security.txt"
+	ChangePassword          = "change-password"
+	AssetLinks              = "assetlinks.json"
+	AppleAppSiteAssociation = "apple-app-site-association"
+	JWKS                    = "jwks.json"
+)
+
+// ErrInvalidDocument is returned for documents that can't be served, such
+// as a file that isn't JSON
+var ErrInvalidDocument = errors.New("invalid well-known document")
+
+// cacheMaxAge is how long clients may cache documents
+const cacheMaxAge = time.Hour
+
+// Registry holds the documents served under Prefix by name
+type Registry struct {
+	docs map[string]echo.HandlerFunc
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{docs: make(map[string]echo.HandlerFunc)}
+}
+
+// New creates a registry with the documents cfg configures. Documents
+// whose settings are empty aren't served.
+func New(cfg config.WellKnownConfig, clk clock.Clock) (*Registry, error) {
+	r := NewRegistry()
+
+	if len(cfg.SecurityContacts) > 0 {
+		txt := &SecurityText{
+			Contacts:        cfg.SecurityContacts,
+			Policy:          cfg.SecurityPolicy,
+			Encryption:      cfg.SecurityEncryption,
+			Acknowledgments: cfg.SecurityAcknowledgments,
+			Hiring:          cfg.SecurityHiring,
+			Languages:       cfg.SecurityLanguages,
+			Canonical:       cfg.SecurityCanonical,
+			Validity:        cfg.SecurityValidity,
+		}
+		if err := txt.Validate(); err != nil {
+			return nil, err
+		}
+		r.Register(SecurityTxt, txt.Handler(clk))
+	}
+	if cfg.ChangePasswordURL != "" {
+		r.Register(ChangePassword, Redirect(cfg.ChangePasswordURL))
+	}
+
+	files := []struct{ name, path string }{
+		{AssetLinks, cfg.AssetLinksFile},
+		{AppleAppSiteAssociation, cfg.AppleAppSiteAssociationFile},
+	}
+	for _, f := range files {
+		if f.path == "" {
+			continue
+		}
+		h, err := JSONFile(f.path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.name, err)
+		}
+		r.Register(f.name, h)
+	}
+
+	return r, nil
+}
+
+// Register serves h at Prefix/name, replacing any document registered
+// under that name
+func (r *Registry) Register(name string, h echo.HandlerFunc) {
+	r.docs[name] = h
+}
+
+// Names returns the registered document names, sorted
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.docs))
+	for name := range r.docs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Mount adds a GET route for each registered document. Documents are
+// public, so mount them outside authentication and tenant resolution.
+func (r *Registry) Mount(e *echo.Echo) {
+	for _, name := range r.Names() {
+		e.GET(Prefix+"/"+name, r.docs[name])
+	}
+}
+
+// JSON serves v, encoded once up front
+func JSON(v any) (echo.HandlerFunc, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidDocument, err)
+	}
+	return blob(echo.MIMEApplicationJSON, body), nil
+}
+
+// JSONFile serves the JSON file at path, read once up front. The file is
+// checked to be valid JSON, since app association files that don't parse
+// are silently ignored by the platforms.
+func JSONFile(path string) (echo.HandlerFunc, error) {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid(body) {
+		return nil, fmt.Errorf("%w: %s is not valid JSON", ErrInvalidDocument, path)
+	}
+	return blob(echo.MIMEApplicationJSON, body), nil
+}
+
+// Redirect redirects to url, as /.well-known/change-password does
+func Redirect(url string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return c.Redirect(http.StatusFound, url)
+	}
+}
+
+func blob(contentType string, body []byte) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		setCacheControl(c)
+		return c.Blob(http.StatusOK, contentType, body)
+	}
+}
+
+func setCacheControl(c echo.Context) {
+	c.Response().Header().Set(echo.HeaderCacheControl, "public, max-age="+strconv.Itoa(int(cacheMaxAge.Seconds())))
+}
@@ -0,0 +1,128 @@
+package wellknown
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/internal/config"
+	"github.com/pixperk/goiler/pkg/clock"
+)
+
+func serve(t *testing.T, r *Registry, path string) *httptest.ResponseRecorder {
+	t.Helper()
+	e := echo.New()
+	r.Mount(e)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec
+}
+
+func TestNew_Unconfigured(t *testing.T) {
+	r, err := New(config.WellKnownConfig{}, nil)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if names := r.Names(); len(names) != 0 {
+		t.Errorf("Expected no documents, got %v", names)
+	}
+	if rec := serve(t, r, "/.well-known/security.txt"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", rec.Code)
+	}
+}
+
+func TestSecurityTxt(t *testing.T) {
+	clk := clock.NewFrozen(time.Date(2026, 3, 10, 15, 4, 5, 0, time.UTC))
+	r, err := New(config.WellKnownConfig{
+		SecurityContacts:  []string{"mailto:security@example.com", "https://example.com/report"},
+		SecurityPolicy:    "https://example.com/policy",
+		SecurityLanguages: []string{"en", "de"},
+		SecurityValidity:  30 * 24 * time.Hour,
+	}, clk)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	rec := serve(t, r, "/.well-known/security.txt")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	if ct := rec.Header().Get(echo.HeaderContentType); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Content type mismatch: got %q", ct)
+	}
+	want := "Contact: mailto:security@example.com\n" +
+		"Contact: https://example.com/report\n" +
+		"Expires: 2026-04-09T00:00:00Z\n" +
+		"Policy: https://example.com/policy\n" +
+		"Preferred-Languages: en, de\n"
+	if rec.Body.String() != want {
+		t.Errorf("Body mismatch:\ngot:\n%s\nwant:\n%s", rec.Body.String(), want)
+	}
+}
+
+func TestSecurityTxt_Invalid(t *testing.T) {
+	_, err := New(config.WellKnownConfig{SecurityContacts: []string{"security@example.com"}}, nil)
+	if !errors.Is(err, ErrInvalidDocument) {
+		t.Errorf("Expected ErrInvalidDocument for a contact without a scheme, got %v", err)
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	r, err := New(config.WellKnownConfig{ChangePasswordURL: "https://example.com/settings/password"}, nil)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	rec := serve(t, r, "/.well-known/change-password")
+	if rec.Code != http.StatusFound || rec.Header().Get(echo.HeaderLocation) != "https://example.com/settings/password" {
+		t.Errorf("Expected a redirect, got %d to %q", rec.Code, rec.Header().Get(echo.HeaderLocation))
+	}
+}
+
+func TestAppAssociationFiles(t *testing.T) {
+	dir := t.TempDir()
+	assetLinks := filepath.Join(dir, "assetlinks.json")
+	if err := os.WriteFile(assetLinks, []byte(`[{"relation":["delegate_permission/common.handle_all_urls"]}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	invalid := filepath.Join(dir, "aasa.json")
+	if err := os.WriteFile(invalid, []byte(`{"applinks":`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := New(config.WellKnownConfig{AssetLinksFile: assetLinks}, nil)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	rec := serve(t, r, "/.well-known/assetlinks.json")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "handle_all_urls") {
+		t.Errorf("Expected the file, got %d %q", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get(echo.HeaderContentType); ct != echo.MIMEApplicationJSON {
+		t.Errorf("Content type mismatch: got %q", ct)
+	}
+
+	_, err = New(config.WellKnownConfig{AppleAppSiteAssociationFile: invalid}, nil)
+	if !errors.Is(err, ErrInvalidDocument) {
+		t.Errorf("Expected ErrInvalidDocument for invalid JSON, got %v", err)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	r := NewRegistry()
+	h, err := JSON(map[string]string{"issuer": "https://example.com"})
+	if err != nil {
+		t.Fatalf("JSON failed: %v", err)
+	}
+	r.Register("openid-configuration", h)
+
+	rec := serve(t, r, "/.well-known/openid-configuration")
+	if rec.Code != http.StatusOK || rec.Body.String() != `{"issuer":"https://example.com"}` {
+		t.Errorf("Expected the document, got %d %q", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,259 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/internal/auth"
+	"github.com/pixperk/goiler/pkg/bulk"
+	"github.com/pixperk/goiler/pkg/pagination"
+	"github.com/pixperk/goiler/pkg/response"
+	"github.com/pixperk/goiler/pkg/validator"
+	"github.com/pixperk/goiler/pkg/worker"
+)
+
+// AdminHandler handles admin HTTP requests for dead-letter tasks, queues
+// and schedules
+type AdminHandler struct {
+	deadLetters *DeadLetterQueue
+	scaling     *ScalingMonitor
+	schedules   *ScheduleList
+}
+
+// NewAdminHandler creates a new worker admin handler. scaling may be nil
+// when queue sampling is disabled.
+func NewAdminHandler(deadLetters *DeadLetterQueue, scaling *ScalingMonitor, schedules *ScheduleList) *AdminHandler {
+	return &AdminHandler{deadLetters: deadLetters, scaling: scaling, schedules: schedules}
+}
+
+// QueueScaling returns the latest queue depth sample for autoscalers
+// @Summary Queue scaling signals
+// @Description Latest queue backlog and latency sample, suitable for the KEDA metrics-api scaler (admin only)
+// @Tags Admin
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} worker.ScalingSnapshot
+// @Failure 503 {object} response.Response
+// @Router /api/v1/admin/queues/scaling [get]
+func (h *AdminHandler) QueueScaling(c echo.Context) error {
+	if h.scaling == nil {
+		return response.Error(c, http.StatusServiceUnavailable, "SCALING_DISABLED", "Queue sampling is disabled")
+	}
+
+	snapshot := h.scaling.Snapshot()
+	if snapshot == nil {
+		return response.Error(c, http.StatusServiceUnavailable, "NOT_SAMPLED", "Queues have not been sampled yet")
+	}
+
+	return response.Success(c, snapshot)
+}
+
+// ListSchedules lists the periodic tasks of the running workers
+// @Summary List schedules
+// @Description List the periodic tasks registered by running worker schedulers, with redacted payload previews and when they run next (admin only)
+// @Tags Admin
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {array} worker.ScheduleEntry
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Router /api/v1/admin/tasks/schedules [get]
+func (h *AdminHandler) ListSchedules(c echo.Context) error {
+	schedules, err := h.schedules.List()
+	if err != nil {
+		return response.InternalError(c, "Failed to list schedules")
+	}
+
+	return response.Success(c, schedules)
+}
+
+// ListDeadTasks lists archived tasks in a queue
+// @Summary List dead tasks
+// @Description List archived tasks with redacted payload previews (admin only)
+// @Tags Admin
+// @Security BearerAuth
+// @Produce json
+// @Param queue query string false "Queue name" default(default)
+// @Param page query int false "Page number" default(1)
+// @Param per_page query int false "Items per page, at most 100" default(20)
+// @Success 200 {array} worker.DeadTask
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 422 {object} response.Response
+// @Router /api/v1/admin/tasks/dead [get]
+func (h *AdminHandler) ListDeadTasks(c echo.Context) error {
+	queue := c.QueryParam("queue")
+	if queue == "" {
+		queue = "default"
+	}
+
+	params, err := pagination.Bind(c, pagination.Options{})
+	if err != nil {
+		return response.ValidationError(c, pagination.Details(err))
+	}
+
+	tasks, total, err := h.deadLetters.List(c.Request().Context(), queue, params.Page, params.PerPage)
+	if err != nil {
+		return response.InternalError(c, "Failed to list dead tasks")
+	}
+
+	return response.Paginated(c, tasks, params.Page, params.PerPage, int64(total))
+}
+
+// RetryDeadTask re-enqueues a single dead task
+// @Summary Retry dead task
+// @Tags Admin
+// @Security BearerAuth
+// @Produce json
+// @Param queue path string true "Queue name"
+// @Param id path string true "Task ID"
+// @Success 200 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Router /api/v1/admin/tasks/dead/{queue}/{id}/retry [post]
+func (h *AdminHandler) RetryDeadTask(c echo.Context) error {
+	err := h.deadLetters.Retry(c.Request().Context(), c.Param("queue"), c.Param("id"))
+	if err != nil {
+		if errors.Is(err, worker.ErrDeadTaskNotFound) {
+			return response.NotFound(c, "Dead task not found")
+		}
+		return response.InternalError(c, "Failed to retry task")
+	}
+
+	return response.SuccessWithMessage(c, "Task scheduled for retry", nil)
+}
+
+// DeleteDeadTask permanently removes a single dead task
+// @Summary Delete dead task
+// @Tags Admin
+// @Security BearerAuth
+// @Param queue path string true "Queue name"
+// @Param id path string true "Task ID"
+// @Success 204 "No Content"
+// @Failure 404 {object} response.Response
+// @Router /api/v1/admin/tasks/dead/{queue}/{id} [delete]
+func (h *AdminHandler) DeleteDeadTask(c echo.Context) error {
+	err := h.deadLetters.Delete(c.Request().Context(), c.Param("queue"), c.Param("id"))
+	if err != nil {
+		if errors.Is(err, worker.ErrDeadTaskNotFound) {
+			return response.NotFound(c, "Dead task not found")
+		}
+		return response.InternalError(c, "Failed to delete task")
+	}
+
+	return response.NoContent(c)
+}
+
+// AnnotateRequest represents a dead task annotation request
+type AnnotateRequest struct {
+	Note string `json:"note" validate:"required,max=2000"`
+}
+
+// AnnotateDeadTask attaches an operator note to a dead task
+// @Summary Annotate dead task
+// @Tags Admin
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param queue path string true "Queue name"
+// @Param id path string true "Task ID"
+// @Param request body AnnotateRequest true "Annotation"
+// @Success 200 {object} worker.Annotation
+// @Failure 404 {object} response.Response
+// @Failure 422 {object} response.Response
+// @Router /api/v1/admin/tasks/dead/{queue}/{id}/annotation [put]
+func (h *AdminHandler) AnnotateDeadTask(c echo.Context) error {
+	var req AnnotateRequest
+	if err := c.Bind(&req); err != nil {
+		return response.BadRequest(c, "Invalid request body")
+	}
+
+	if err := c.Validate(&req); err != nil {
+		return response.ValidationError(c, validator.FormatErrors(err))
+	}
+
+	author := ""
+	if payload := auth.GetCurrentUser(c); payload != nil {
+		author = payload.Email
+	}
+
+	annotation, err := h.deadLetters.Annotate(c.Request().Context(), c.Param("queue"), c.Param("id"), author, req.Note)
+	if err != nil {
+		if errors.Is(err, worker.ErrDeadTaskNotFound) {
+			return response.NotFound(c, "Dead task not found")
+		}
+		return response.InternalError(c, "Failed to annotate task")
+	}
+
+	return response.Success(c, annotation)
+}
+
+// DeadTaskRef identifies a dead task in a bulk request
+type DeadTaskRef struct {
+	Queue string `json:"queue" validate:"required"`
+	ID    string `json:"id" validate:"required"`
+}
+
+// BulkDeadTasksRequest represents a bulk retry or delete request
+type BulkDeadTasksRequest struct {
+	Tasks []DeadTaskRef `json:"tasks" validate:"required,min=1,max=100,dive"`
+}
+
+// BulkRetryDeadTasks re-enqueues several dead tasks
+// @Summary Bulk retry dead tasks
+// @Tags Admin
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body BulkDeadTasksRequest true "Tasks to retry"
+// @Success 207 {object} bulk.Response
+// @Failure 422 {object} response.Response
+// @Router /api/v1/admin/tasks/dead/retry [post]
+func (h *AdminHandler) BulkRetryDeadTasks(c echo.Context) error {
+	return h.bulk(c, h.deadLetters.Retry)
+}
+
+// BulkDeleteDeadTasks permanently removes several dead tasks
+// @Summary Bulk delete dead tasks
+// @Tags Admin
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body BulkDeadTasksRequest true "Tasks to delete"
+// @Success 207 {object} bulk.Response
+// @Failure 422 {object} response.Response
+// @Router /api/v1/admin/tasks/dead/delete [post]
+func (h *AdminHandler) BulkDeleteDeadTasks(c echo.Context) error {
+	return h.bulk(c, h.deadLetters.Delete)
+}
+
+// bulk applies op to every task in a bulk request
+func (h *AdminHandler) bulk(c echo.Context, op func(ctx context.Context, queue, id string) error) error {
+	var req BulkDeadTasksRequest
+	if err := c.Bind(&req); err != nil {
+		return response.BadRequest(c, "Invalid request body")
+	}
+
+	if err := c.Validate(&req); err != nil {
+		return response.ValidationError(c, validator.FormatErrors(err))
+	}
+
+	results := bulk.Execute(c.Request().Context(), req.Tasks, bulk.Options{MapError: mapDeadTaskError},
+		func(ctx context.Context, ref DeadTaskRef) (interface{}, error) {
+			if err := op(ctx, ref.Queue, ref.ID); err != nil {
+				return nil, err
+			}
+			return ref, nil
+		})
+
+	return bulk.Respond(c, results)
+}
+
+// mapDeadTaskError maps dead-letter errors to per-item bulk statuses
+func mapDeadTaskError(err error) (int, *response.ErrorInfo) {
+	if errors.Is(err, worker.ErrDeadTaskNotFound) {
+		return http.StatusNotFound, &response.ErrorInfo{Code: "NOT_FOUND", Message: "Dead task not found"}
+	}
+	return http.StatusInternalServerError, &response.ErrorInfo{Code: "INTERNAL_ERROR", Message: "Operation failed"}
+}
@@ -2,32 +2,83 @@ package worker
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"time"
 
 	"github.com/hibiken/asynq"
 	"github.com/pixperk/goiler/internal/config"
+	"github.com/pixperk/goiler/pkg/budget"
+	"github.com/pixperk/goiler/pkg/idgen"
+	"github.com/pixperk/goiler/pkg/redisconn"
+	"github.com/pixperk/goiler/pkg/sandbox"
+	"github.com/pixperk/goiler/pkg/worker"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
 )
 
 // Client represents the Asynq client for enqueueing tasks
 type Client struct {
-	client *asynq.Client
-	logger *slog.Logger
+	client    *asynq.Client
+	logger    *slog.Logger
+	dedupTTLs map[string]time.Duration
+	ids       idgen.Generator
+
+	enqueued     metric.Int64Counter
+	deduplicated metric.Int64Counter
+}
+
+// EnqueueResult reports the outcome of an enqueue that may be deduplicated
+type EnqueueResult struct {
+	// Info is nil when the task was coalesced into an existing one
+	Info *asynq.TaskInfo
+	// Coalesced is true when an identical task was already enqueued within
+	// its dedup window, so no new task was created
+	Coalesced bool
 }
 
-// NewClient creates a new worker client
-func NewClient(cfg *config.Config, logger *slog.Logger) *Client {
-	redisOpt := asynq.RedisClientOpt{
-		Addr:     cfg.Redis.Addr,
-		Password: cfg.Redis.Password,
-		DB:       cfg.Redis.DB,
+// NewClient creates a new worker client. Task IDs use the configured ID
+// format; unknown formats are an error.
+func NewClient(cfg *config.Config, logger *slog.Logger) (*Client, error) {
+	ids, err := idgen.New(cfg.App.IDFormat)
+	if err != nil {
+		return nil, err
 	}
 
-	return &Client{
-		client: asynq.NewClient(redisOpt),
-		logger: logger,
+	c := &Client{
+		client:    asynq.NewClient(redisconn.ConnOpt(cfg.Redis)),
+		logger:    logger,
+		dedupTTLs: cfg.Worker.DedupTTLs,
+		ids:       ids,
 	}
+	c.initMetrics()
+
+	return c, nil
+}
+
+// initMetrics registers enqueue metrics with the global meter provider
+func (c *Client) initMetrics() {
+	meter := otel.Meter("goiler/worker")
+
+	c.enqueued, _ = meter.Int64Counter(
+		"worker_tasks_enqueued_total",
+		metric.WithDescription("Total number of tasks enqueued"),
+		metric.WithUnit("1"),
+	)
+
+	c.deduplicated, _ = meter.Int64Counter(
+		"worker_tasks_deduplicated_total",
+		metric.WithDescription("Total number of enqueues coalesced into an existing task"),
+		metric.WithUnit("1"),
+	)
+}
+
+// DedupTTL returns the configured dedup window for a task type, or zero if
+// tasks of that type are not deduplicated
+func (c *Client) DedupTTL(taskType string) time.Duration {
+	return c.dedupTTLs[taskType]
 }
 
 // Close closes the client connection
@@ -35,9 +86,38 @@ func (c *Client) Close() error {
 	return c.client.Close()
 }
 
-// Enqueue enqueues a task with default options
+// Enqueue enqueues a task with default options. Task types with a configured
+// dedup window are enqueued as unique tasks and return asynq.ErrDuplicateTask
+// when an identical task is already pending.. It
+// reports whether the task was coalesced into one already pending.
 func (c *Client) Enqueue(ctx context.Context, task *asynq.Task, opts ...asynq.Option) (*asynq.TaskInfo, error) {
-	info, err := c.client.EnqueueContext(ctx, task, opts...)
+	enqueueCtx, cancel, err := budget.Redis.WithTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	// Defaults are prepended so explicit options from the caller win
+	defaults := []asynq.Option{asynq.TaskID(c.ids.NewID().String())}
+	if ttl := c.DedupTTL(task.Type()); ttl > 0 {
+		defaults = append(defaults, asynq.Unique(ttl))
+	}
+	opts = append(defaults, opts...)
+
+	task, err = withContextMetadata(ctx, task, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	taskType := attribute.String("type", task.Type())
+	info, err := c.client.EnqueueContext(enqueueCtx, task, opts...)
+	if errors.Is(err, asynq.ErrDuplicateTask) {
+		c.deduplicated.Add(ctx, 1, metric.WithAttributes(taskType))
+		c.logger.InfoContext(ctx, "task deduplicated",
+			slog.String("type", task.Type()),
+		)
+		return nil, err
+	}
 	if err != nil {
 		c.logger.ErrorContext(ctx, "failed to enqueue task",
 			slog.String("type", task.Type()),
@@ -46,6 +126,11 @@ func (c *Client) Enqueue(ctx context.Context, task *asynq.Task, opts ...asynq.Op
 		return nil, err
 	}
 
+	attrs := []attribute.KeyValue{taskType, attribute.String("queue", info.Queue)}
+	if sandbox.Enabled(ctx) {
+		attrs = append(attrs, sandbox.Attribute)
+	}
+	c.enqueued.Add(ctx, 1, metric.WithAttributes(attrs...))
 	c.logger.InfoContext(ctx, "task enqueued",
 		slog.String("type", task.Type()),
 		slog.String("id", info.ID),
@@ -55,6 +140,32 @@ func (c *Client) Enqueue(ctx context.Context, task *asynq.Task, opts ...asynq.Op
 	return info, nil
 }
 
+// withContextMetadata attaches the trace context, request ID and sandbox
+// mode of ctx to the task, so the worker continues the trace of the request
+// that enqueued it. Unique tasks only carry the sandbox mode: the rest
+// differs per request and would stop identical tasks from being
+// deduplicated.
+func withContextMetadata(ctx context.Context, task *asynq.Task, opts []asynq.Option) (*asynq.Task, error) {
+	md := worker.ContextMetadata(ctx)
+	for _, opt := range opts {
+		if opt.Type() == asynq.UniqueOpt {
+			md = worker.Metadata{}
+			if sandbox.Enabled(ctx) {
+				md[worker.MetadataSandbox] = "true"
+			}
+			break
+		}
+	}
+	if len(md) == 0 {
+		return task, nil
+	}
+	payload, err := worker.WithMetadata(task.Payload(), md)
+	if err != nil {
+		return nil, err
+	}
+	return newTask(task.Type(), payload), nil
+}
+
 // EnqueueIn enqueues a task to be processed after a delay
 func (c *Client) EnqueueIn(ctx context.Context, task *asynq.Task, delay time.Duration, opts ...asynq.Option) (*asynq.TaskInfo, error) {
 	opts = append(opts, asynq.ProcessIn(delay))
@@ -73,70 +184,191 @@ func (c *Client) EnqueueUnique(ctx context.Context, task *asynq.Task, ttl time.D
 	return c.Enqueue(ctx, task, opts...)
 }
 
-// SendEmail enqueues an email delivery task
-func (c *Client) SendEmail(ctx context.Context, to, subject, body string) error {
+// EnqueueOrCoalesce enqueues a task and reports whether it was newly
+// enqueued or coalesced into an identical pending task. Coalescing is not an
+// error, so callers can tell users their request is already in progress.
+func (c *Client) EnqueueOrCoalesce(ctx context.Context, task *asynq.Task, opts ...asynq.Option) (*EnqueueResult, error) {
+	info, err := c.Enqueue(ctx, task, opts...)
+	if errors.Is(err, asynq.ErrDuplicateTask) {
+		return &EnqueueResult{Coalesced: true}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &EnqueueResult{Info: info}, nil
+}
+
+// SendEmail enqueues an email delivery task and reports whether it was
+// coalesced into one already pending
+func (c *Client) SendEmail(ctx context.Context, to, subject, body string) (bool, error) {
 	task, err := NewEmailDeliveryTask(to, subject, body)
 	if err != nil {
-		return fmt.Errorf("failed to create email task: %w", err)
+		return false, fmt.Errorf("failed to create email task: %w", err)
 	}
 
-	_, err = c.Enqueue(ctx, task, asynq.Queue("default"))
-	return err
+	result, err := c.EnqueueOrCoalesce(ctx, task, asynq.Queue("default"))
+	if err != nil {
+		return false, err
+	}
+	return result.Coalesced, nil
 }
 
-// SendWelcomeEmail enqueues a welcome email task
-func (c *Client) SendWelcomeEmail(ctx context.Context, userID, email, name string) error {
-	task, err := NewWelcomeEmailTask(userID, email, name)
+// SendWelcomeEmail enqueues a welcome email task and reports whether it was
+// coalesced into one already pending
+func (c *Client) SendWelcomeEmail(ctx context.Context, userID, email, name, verificationURL string) (bool, error) {
+	task, err := NewWelcomeEmailTask(userID, email, name, verificationURL)
 	if err != nil {
-		return fmt.Errorf("failed to create welcome email task: %w", err)
+		return false, fmt.Errorf("failed to create welcome email task: %w", err)
 	}
 
-	_, err = c.Enqueue(ctx, task, asynq.Queue("default"))
-	return err
+	result, err := c.EnqueueOrCoalesce(ctx, task, asynq.Queue("default"))
+	if err != nil {
+		return false, err
+	}
+	return result.Coalesced, nil
 }
 
-// SendPasswordResetEmail enqueues a password reset email task
-func (c *Client) SendPasswordResetEmail(ctx context.Context, userID, email, resetToken string, expiresAt time.Time) error {
+// SendPasswordResetEmail enqueues a password reset email task and reports
+// whether it was coalesced into one already pending
+func (c *Client) SendPasswordResetEmail(ctx context.Context, userID, email, resetToken string, expiresAt time.Time) (bool, error) {
 	task, err := NewPasswordResetEmailTask(userID, email, resetToken, expiresAt)
 	if err != nil {
-		return fmt.Errorf("failed to create password reset task: %w", err)
+		return false, fmt.Errorf("failed to create password reset task: %w", err)
 	}
 
-	_, err = c.Enqueue(ctx, task, asynq.Queue("critical"))
-	return err
+	result, err := c.EnqueueOrCoalesce(ctx, task, asynq.Queue("critical"))
+	if err != nil {
+		return false, err
+	}
+	return result.Coalesced, nil
+}
+
+// SendOrgInvitationEmail enqueues an organization invitation email task and
+// reports whether it was coalesced into one already pending
+func (c *Client) SendOrgInvitationEmail(ctx context.Context, email, orgName, inviterEmail, role, acceptURL string, expiresAt time.Time) (bool, error) {
+	task, err := NewOrgInvitationEmailTask(OrgInvitationPayload{
+		Email:        email,
+		OrgName:      orgName,
+		InviterEmail: inviterEmail,
+		Role:         role,
+		AcceptURL:    acceptURL,
+		ExpiresAt:    expiresAt,
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to create org invitation task: %w", err)
+	}
+
+	result, err := c.EnqueueOrCoalesce(ctx, task, asynq.Queue("default"))
+	if err != nil {
+		return false, err
+	}
+	return result.Coalesced, nil
 }
 
-// SendNotification enqueues a notification task
-func (c *Client) SendNotification(ctx context.Context, userID, notificationType, title, message string, data map[string]interface{}) error {
+// SendNotification enqueues a notification task and reports whether it was
+// coalesced into one already pending
+func (c *Client) SendNotification(ctx context.Context, userID, notificationType, title, message string, data map[string]interface{}) (bool, error) {
 	task, err := NewNotificationTask(userID, notificationType, title, message, data)
 	if err != nil {
-		return fmt.Errorf("failed to create notification task: %w", err)
+		return false, fmt.Errorf("failed to create notification task: %w", err)
 	}
 
-	_, err = c.Enqueue(ctx, task, asynq.Queue("default"))
-	return err
+	result, err := c.EnqueueOrCoalesce(ctx, task, asynq.Queue("default"))
+	if err != nil {
+		return false, err
+	}
+	return result.Coalesced, nil
 }
 
-// GenerateReport enqueues a report generation task
-func (c *Client) GenerateReport(ctx context.Context, reportID, reportType, userID string, startDate, endDate time.Time) error {
+// SendSecurityAlert enqueues a security alert task and reports whether it
+// was coalesced into one already pending
+func (c *Client) SendSecurityAlert(ctx context.Context, payload SecurityAlertPayload) (bool, error) {
+	task, err := NewSecurityAlertTask(payload)
+	if err != nil {
+		return false, fmt.Errorf("failed to create security alert task: %w", err)
+	}
+
+	result, err := c.EnqueueOrCoalesce(ctx, task, asynq.Queue("critical"))
+	if err != nil {
+		return false, err
+	}
+	return result.Coalesced, nil
+}
+
+// GenerateReport enqueues a report generation task and reports whether it
+// was coalesced into one already pending
+func (c *Client) GenerateReport(ctx context.Context, reportID, reportType, userID string, startDate, endDate time.Time) (bool, error) {
 	task, err := NewReportTask(reportID, reportType, userID, startDate, endDate)
 	if err != nil {
-		return fmt.Errorf("failed to create report task: %w", err)
+		return false, fmt.Errorf("failed to create report task: %w", err)
 	}
 
-	_, err = c.Enqueue(ctx, task, asynq.Queue("low"))
-	return err
+	result, err := c.EnqueueOrCoalesce(ctx, task, asynq.Queue("low"))
+	if err != nil {
+		return false, err
+	}
+	return result.Coalesced, nil
 }
 
-// ScheduleCleanup enqueues a data cleanup task
-func (c *Client) ScheduleCleanup(ctx context.Context, cleanupType string, olderThan time.Time) error {
+// ScheduleCleanup enqueues a data cleanup task and reports whether it was
+// coalesced into one already pending
+func (c *Client) ScheduleCleanup(ctx context.Context, cleanupType string, olderThan time.Time) (bool, error) {
 	task, err := NewCleanupTask(cleanupType, olderThan)
 	if err != nil {
-		return fmt.Errorf("failed to create cleanup task: %w", err)
+		return false, fmt.Errorf("failed to create cleanup task: %w", err)
 	}
 
-	_, err = c.Enqueue(ctx, task, asynq.Queue("low"))
-	return err
+	result, err := c.EnqueueOrCoalesce(ctx, task, asynq.Queue("low"))
+	if err != nil {
+		return false, err
+	}
+	return result.Coalesced, nil
+}
+
+// CleanupTokens enqueues a refresh token cleanup task and reports whether
+// it was coalesced into one already pending
+func (c *Client) CleanupTokens(ctx context.Context) (bool, error) {
+	task, err := NewTokenCleanupTask()
+	if err != nil {
+		return false, fmt.Errorf("failed to create token cleanup task: %w", err)
+	}
+
+	result, err := c.EnqueueOrCoalesce(ctx, task, asynq.Queue("low"))
+	if err != nil {
+		return false, err
+	}
+	return result.Coalesced, nil
+}
+
+// ExportUserData enqueues a task that builds the archive of a privacy
+// export request and reports whether it was coalesced into one already
+// pending
+func (c *Client) ExportUserData(ctx context.Context, requestID string) (bool, error) {
+	task, err := NewPrivacyExportTask(requestID)
+	if err != nil {
+		return false, fmt.Errorf("failed to create privacy export task: %w", err)
+	}
+
+	result, err := c.EnqueueOrCoalesce(ctx, task, asynq.Queue("low"))
+	if err != nil {
+		return false, err
+	}
+	return result.Coalesced, nil
+}
+
+// EraseUserData enqueues a task that carries out a privacy erasure request
+// and reports whether it was coalesced into one already pending
+func (c *Client) EraseUserData(ctx context.Context, requestID string) (bool, error) {
+	task, err := NewPrivacyErasureTask(requestID)
+	if err != nil {
+		return false, fmt.Errorf("failed to create privacy erasure task: %w", err)
+	}
+
+	result, err := c.EnqueueOrCoalesce(ctx, task, asynq.Queue("default"))
+	if err != nil {
+		return false, err
+	}
+	return result.Coalesced, nil
 }
 
 // Inspector provides access to inspect queues
@@ -146,14 +378,8 @@ type Inspector struct {
 
 // NewInspector creates a new queue inspector
 func NewInspector(cfg *config.Config) *Inspector {
-	redisOpt := asynq.RedisClientOpt{
-		Addr:     cfg.Redis.Addr,
-		Password: cfg.Redis.Password,
-		DB:       cfg.Redis.DB,
-	}
-
 	return &Inspector{
-		inspector: asynq.NewInspector(redisOpt),
+		inspector: asynq.NewInspector(redisconn.ConnOpt(cfg.Redis)),
 	}
 }
 
@@ -0,0 +1,138 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hibiken/asynq"
+	"github.com/pixperk/goiler/internal/config"
+	"github.com/pixperk/goiler/internal/testutil"
+	"github.com/pixperk/goiler/pkg/requestid"
+	"github.com/pixperk/goiler/pkg/worker"
+)
+
+func useCodec(t *testing.T, name string) {
+	t.Helper()
+	if err := SetPayloadCodec(name); err != nil {
+		t.Fatalf("SetPayloadCodec(%q) failed: %v", name, err)
+	}
+	t.Cleanup(func() { SetPayloadCodec("json") })
+}
+
+func TestProtoCodec_ReportRoundTrip(t *testing.T) {
+	useCodec(t, "protobuf")
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(36*time.Hour + 500*time.Millisecond)
+	task, err := NewReportTask("report-1", "usage", "user-1", start, end)
+	if err != nil {
+		t.Fatalf("NewReportTask failed: %v", err)
+	}
+	if task.Payload()[1] != (worker.ProtoCodec{}).ID() {
+		t.Fatalf("Expected protobuf codec, got id %d", task.Payload()[1])
+	}
+
+	payload, err := worker.ParsePayload[ReportPayload](task)
+	if err != nil {
+		t.Fatalf("ParsePayload failed: %v", err)
+	}
+	if payload.ReportID != "report-1" || payload.UserID != "user-1" || payload.ReportType != "usage" {
+		t.Errorf("Unexpected payload: %+v", payload)
+	}
+	if !payload.StartDate.Equal(start) || !payload.EndDate.Equal(end) {
+		t.Errorf("Dates not preserved: %v - %v", payload.StartDate, payload.EndDate)
+	}
+}
+
+func TestProtoCodec_FallsBackToJSON(t *testing.T) {
+	useCodec(t, "protobuf")
+
+	task, err := NewWelcomeEmailTask("user-1", "test@example.com", "Test", "")
+	if err != nil {
+		t.Fatalf("NewWelcomeEmailTask failed: %v", err)
+	}
+	if task.Payload()[1] != (worker.JSONCodec{}).ID() {
+		t.Errorf("Expected JSON fallback, got codec id %d", task.Payload()[1])
+	}
+
+	payload, err := worker.ParsePayload[WelcomeEmailPayload](task)
+	if err != nil {
+		t.Fatalf("ParsePayload failed: %v", err)
+	}
+	if payload.Email != "test@example.com" {
+		t.Errorf("Unexpected payload: %+v", payload)
+	}
+}
+
+func TestParsePayload_LegacyJSON(t *testing.T) {
+	data, _ := json.Marshal(CleanupPayload{Type: "sessions"})
+
+	payload, err := worker.ParsePayload[CleanupPayload](asynq.NewTask(TypeDataCleanup, data))
+	if err != nil {
+		t.Fatalf("ParsePayload failed: %v", err)
+	}
+	if payload.Type != "sessions" {
+		t.Errorf("Unexpected payload: %+v", payload)
+	}
+}
+
+func TestWithContextMetadata(t *testing.T) {
+	ctx := requestid.WithID(context.Background(), "req-1")
+	task, err := NewWelcomeEmailTask("user-1", "test@example.com", "Test", "")
+	if err != nil {
+		t.Fatalf("NewWelcomeEmailTask failed: %v", err)
+	}
+
+	traced, err := withContextMetadata(ctx, task, nil)
+	if err != nil {
+		t.Fatalf("withContextMetadata failed: %v", err)
+	}
+	md, _, err := worker.SplitMetadata(traced.Payload())
+	if err != nil || md[worker.MetadataRequestID] != "req-1" {
+		t.Fatalf("Expected the request ID in the task metadata, got %v, %v", md, err)
+	}
+	payload, err := worker.ParsePayload[WelcomeEmailPayload](traced)
+	if err != nil || payload.Email != "test@example.com" {
+		t.Errorf("Expected the payload to parse, got %+v, %v", payload, err)
+	}
+
+	// Unique tasks keep their payload so duplicates are still detected
+	unique, err := withContextMetadata(ctx, task, []asynq.Option{asynq.Unique(time.Minute)})
+	if err != nil {
+		t.Fatalf("withContextMetadata failed: %v", err)
+	}
+	if unique != task {
+		t.Error("Expected unique tasks to be left unchanged")
+	}
+}
+
+func TestOptionsFor(t *testing.T) {
+	for _, taskType := range []string{
+		TypeEmailDelivery, TypeWelcomeEmail, TypePasswordResetEmail, TypeNotification,
+		TypeReportGeneration, TypeDataCleanup, TypeSecurityAlert, TypeTokenCleanup,
+		TypeOrgInvitationEmail, TypePrivacyExport, TypePrivacyErasure,
+	} {
+		if _, ok := taskOptions[taskType]; !ok {
+			t.Errorf("Expected options for %s", taskType)
+		}
+	}
+
+	// Other task types, such as ones rebuilt to carry metadata, get the
+	// defaults rather than none
+	opts := optionsFor("custom:task")
+	if len(opts) != 1 || opts[0].Type() != asynq.MaxRetryOpt || opts[0].Value() != 3 {
+		t.Errorf("Expected the default options, got %v", opts)
+	}
+	if opts := optionsFor(TypeReportGeneration); len(opts) != 2 {
+		t.Errorf("Expected the report options, got %v", opts)
+	}
+}
+
+func TestNewClient_InvalidIDFormat(t *testing.T) {
+	cfg := &config.Config{App: config.AppConfig{IDFormat: "snowflake"}}
+	if _, err := NewClient(cfg, testutil.Logger()); err == nil {
+		t.Error("Expected an unknown ID format to be an error")
+	}
+}
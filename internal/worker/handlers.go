@@ -2,42 +2,104 @@ package worker
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/hibiken/asynq"
+	"github.com/pixperk/goiler/internal/emaillog"
+	"github.com/pixperk/goiler/pkg/clock"
+	"github.com/pixperk/goiler/pkg/events"
+	"github.com/pixperk/goiler/pkg/sandbox"
+	"github.com/pixperk/goiler/pkg/worker"
+)
+
+// TokenPurger deletes refresh tokens that can no longer be used
+type TokenPurger interface {
+	PurgeExpiredRefreshTokens(ctx context.Context) (int64, error)
+}
+
+// EmailLog records the outcome of email tasks. *emaillog.Service satisfies
+// it.
+type EmailLog interface {
+	Record(ctx context.Context, delivery *emaillog.Delivery) error
+	Purge(ctx context.Context, before time.Time) (int64, error)
+}
+
+// PrivacyProcessor carries out privacy requests. *privacy.Service satisfies
+// it.
+type PrivacyProcessor interface {
+	ProcessExport(ctx context.Context, id uuid.UUID) error
+	ProcessErasure(ctx context.Context, id uuid.UUID) error
+	// Fail marks a request as failed once its task gives up
+	Fail(ctx context.Context, id uuid.UUID) error
+	ExpireArchives(ctx context.Context) (int64, error)
+}
+
+// GuestPurger deletes abandoned guest accounts. *user.GuestPurger
+// satisfies it.
+type GuestPurger interface {
+	PurgeGuests(ctx context.Context, cutoff time.Time) (int64, error)
+}
+
+// Cleanup types handled by data cleanup tasks
+const (
+	// CleanupEmailDeliveries deletes email log entries older than the
+	// task's OlderThan, or its MaxAge
+	CleanupEmailDeliveries = "email_deliveries"
+	// CleanupPrivacyExports deletes export archives past their expiry; the
+	// task's OlderThan is ignored
+	CleanupPrivacyExports = "privacy_exports"
+	// CleanupGuests deletes guest accounts created before the task's
+	// OlderThan, or its MaxAge, and issued no refresh token or session
+	// since
+	CleanupGuests = "guests"
 )
 
 // Handlers holds task handlers and their dependencies
 type Handlers struct {
-	logger *slog.Logger
+	logger      *slog.Logger
+	heartbeats  *worker.HeartbeatStore
+	tokenPurger TokenPurger
+	emailLog    EmailLog
+	privacy     PrivacyProcessor
+	guests      GuestPurger
+	progress    events.Publisher
+	clock       clock.Clock
 	// Add your service dependencies here
 	// emailService    EmailService
 	// notificationSvc NotificationService
 }
 
 // NewHandlers creates a new handlers instance
-func NewHandlers(logger *slog.Logger) *Handlers {
+func NewHandlers(logger *slog.Logger, heartbeats *worker.HeartbeatStore) *Handlers {
 	return &Handlers{
-		logger: logger,
+		logger:     logger,
+		heartbeats: heartbeats,
+		clock:      clock.New(),
 	}
 }
 
 // HandleEmailDelivery handles email delivery tasks
 func (h *Handlers) HandleEmailDelivery(ctx context.Context, t *asynq.Task) error {
 	start := time.Now()
-	LogTaskStart(ctx, h.logger, TypeEmailDelivery)
+	worker.LogTaskStart(ctx, h.logger, TypeEmailDelivery)
 	defer func() {
-		LogTaskComplete(ctx, h.logger, TypeEmailDelivery, time.Since(start))
+		worker.LogTaskComplete(ctx, h.logger, TypeEmailDelivery, time.Since(start))
 	}()
 
-	payload, err := ParsePayload[EmailDeliveryPayload](t)
+	payload, err := worker.ParsePayload[EmailDeliveryPayload](t)
 	if err != nil {
-		LogTaskError(ctx, h.logger, TypeEmailDelivery, err)
+		worker.LogTaskError(ctx, h.logger, TypeEmailDelivery, err)
 		return err
 	}
 
+	if h.suppressed(ctx, emaillog.TypeGeneric, "", payload.To) {
+		return nil
+	}
+
 	h.logger.InfoContext(ctx, "sending email",
 		slog.String("to", payload.To),
 		slog.String("subject", payload.Subject),
@@ -46,35 +108,45 @@ func (h *Handlers) HandleEmailDelivery(ctx context.Context, t *asynq.Task) error
 	// TODO: Implement actual email sending
 	// err = h.emailService.Send(ctx, payload.To, payload.Subject, payload.Body)
 	// if err != nil {
+	//     h.recordEmail(ctx, emaillog.TypeGeneric, "", payload.To, err)
 	//     return fmt.Errorf("failed to send email: %w", err)
 	// }
 
+	h.recordEmail(ctx, emaillog.TypeGeneric, "", payload.To, nil)
+
 	return nil
 }
 
 // HandleWelcomeEmail handles welcome email tasks
 func (h *Handlers) HandleWelcomeEmail(ctx context.Context, t *asynq.Task) error {
 	start := time.Now()
-	LogTaskStart(ctx, h.logger, TypeWelcomeEmail)
+	worker.LogTaskStart(ctx, h.logger, TypeWelcomeEmail)
 	defer func() {
-		LogTaskComplete(ctx, h.logger, TypeWelcomeEmail, time.Since(start))
+		worker.LogTaskComplete(ctx, h.logger, TypeWelcomeEmail, time.Since(start))
 	}()
 
-	payload, err := ParsePayload[WelcomeEmailPayload](t)
+	payload, err := worker.ParsePayload[WelcomeEmailPayload](t)
 	if err != nil {
-		LogTaskError(ctx, h.logger, TypeWelcomeEmail, err)
+		worker.LogTaskError(ctx, h.logger, TypeWelcomeEmail, err)
 		return err
 	}
 
+	if h.suppressed(ctx, emaillog.TypeWelcome, payload.UserID, payload.Email) {
+		return nil
+	}
+
 	h.logger.InfoContext(ctx, "sending welcome email",
 		slog.String("user_id", payload.UserID),
 		slog.String("email", payload.Email),
 		slog.String("name", payload.Name),
+		slog.Bool("verification", payload.VerificationURL != ""),
 	)
 
 	// TODO: Implement welcome email sending
 	// template := h.emailService.GetTemplate("welcome")
-	// err = h.emailService.SendTemplate(ctx, payload.Email, template, map[string]string{"name": payload.Name})
+	// err = h.emailService.SendTemplate(ctx, payload.Email, template, map[string]string{"name": payload.Name, "verification_url": payload.VerificationURL})
+
+	h.recordEmail(ctx, emaillog.TypeWelcome, payload.UserID, payload.Email, nil)
 
 	return nil
 }
@@ -82,20 +154,26 @@ func (h *Handlers) HandleWelcomeEmail(ctx context.Context, t *asynq.Task) error
 // HandlePasswordResetEmail handles password reset email tasks
 func (h *Handlers) HandlePasswordResetEmail(ctx context.Context, t *asynq.Task) error {
 	start := time.Now()
-	LogTaskStart(ctx, h.logger, TypePasswordResetEmail)
+	worker.LogTaskStart(ctx, h.logger, TypePasswordResetEmail)
 	defer func() {
-		LogTaskComplete(ctx, h.logger, TypePasswordResetEmail, time.Since(start))
+		worker.LogTaskComplete(ctx, h.logger, TypePasswordResetEmail, time.Since(start))
 	}()
 
-	payload, err := ParsePayload[PasswordResetPayload](t)
+	payload, err := worker.ParsePayload[PasswordResetPayload](t)
 	if err != nil {
-		LogTaskError(ctx, h.logger, TypePasswordResetEmail, err)
+		worker.LogTaskError(ctx, h.logger, TypePasswordResetEmail, err)
 		return err
 	}
 
 	// Check if reset token has expired before sending
-	if time.Now().After(payload.ExpiresAt) {
-		return fmt.Errorf("password reset token has expired")
+	if h.clock.Now().After(payload.ExpiresAt) {
+		err := fmt.Errorf("password reset token has expired")
+		h.recordEmail(ctx, emaillog.TypePasswordReset, payload.UserID, payload.Email, err)
+		return err
+	}
+
+	if h.suppressed(ctx, emaillog.TypePasswordReset, payload.UserID, payload.Email) {
+		return nil
 	}
 
 	h.logger.InfoContext(ctx, "sending password reset email",
@@ -105,23 +183,73 @@ func (h *Handlers) HandlePasswordResetEmail(ctx context.Context, t *asynq.Task)
 
 	// TODO: Implement password reset email sending
 
+	h.recordEmail(ctx, emaillog.TypePasswordReset, payload.UserID, payload.Email, nil)
+
+	return nil
+}
+
+// HandleOrgInvitationEmail handles organization invitation email tasks
+func (h *Handlers) HandleOrgInvitationEmail(ctx context.Context, t *asynq.Task) error {
+	start := time.Now()
+	worker.LogTaskStart(ctx, h.logger, TypeOrgInvitationEmail)
+	defer func() {
+		worker.LogTaskComplete(ctx, h.logger, TypeOrgInvitationEmail, time.Since(start))
+	}()
+
+	payload, err := worker.ParsePayload[OrgInvitationPayload](t)
+	if err != nil {
+		worker.LogTaskError(ctx, h.logger, TypeOrgInvitationEmail, err)
+		return err
+	}
+
+	// An expired invitation can't be accepted, so retrying won't help
+	if h.clock.Now().After(payload.ExpiresAt) {
+		err := fmt.Errorf("org invitation has expired: %w", asynq.SkipRetry)
+		h.recordEmail(ctx, emaillog.TypeOrgInvitation, "", payload.Email, err)
+		return err
+	}
+
+	if h.suppressed(ctx, emaillog.TypeOrgInvitation, "", payload.Email) {
+		return nil
+	}
+
+	h.logger.InfoContext(ctx, "sending org invitation email",
+		slog.String("email", payload.Email),
+		slog.String("org", payload.OrgName),
+		slog.String("role", payload.Role),
+	)
+
+	// TODO: Implement invitation email sending with the accept link
+	// err = h.emailService.SendTemplate(ctx, payload.Email, "org_invitation", payload)
+
+	h.recordEmail(ctx, emaillog.TypeOrgInvitation, "", payload.Email, nil)
+
 	return nil
 }
 
 // HandleNotification handles notification tasks
 func (h *Handlers) HandleNotification(ctx context.Context, t *asynq.Task) error {
 	start := time.Now()
-	LogTaskStart(ctx, h.logger, TypeNotification)
+	worker.LogTaskStart(ctx, h.logger, TypeNotification)
 	defer func() {
-		LogTaskComplete(ctx, h.logger, TypeNotification, time.Since(start))
+		worker.LogTaskComplete(ctx, h.logger, TypeNotification, time.Since(start))
 	}()
 
-	payload, err := ParsePayload[NotificationPayload](t)
+	payload, err := worker.ParsePayload[NotificationPayload](t)
 	if err != nil {
-		LogTaskError(ctx, h.logger, TypeNotification, err)
+		worker.LogTaskError(ctx, h.logger, TypeNotification, err)
 		return err
 	}
 
+	if sandbox.Enabled(ctx) {
+		h.logger.InfoContext(ctx, "notification suppressed in sandbox mode",
+			slog.String("user_id", payload.UserID),
+			slog.String("type", payload.Type),
+			slog.String("title", payload.Title),
+		)
+		return nil
+	}
+
 	h.logger.InfoContext(ctx, "sending notification",
 		slog.String("user_id", payload.UserID),
 		slog.String("type", payload.Type),
@@ -134,17 +262,60 @@ func (h *Handlers) HandleNotification(ctx context.Context, t *asynq.Task) error
 	return nil
 }
 
+// HandleSecurityAlert handles security alert tasks
+func (h *Handlers) HandleSecurityAlert(ctx context.Context, t *asynq.Task) error {
+	start := time.Now()
+	worker.LogTaskStart(ctx, h.logger, TypeSecurityAlert)
+	defer func() {
+		worker.LogTaskComplete(ctx, h.logger, TypeSecurityAlert, time.Since(start))
+	}()
+
+	payload, err := worker.ParsePayload[SecurityAlertPayload](t)
+	if err != nil {
+		worker.LogTaskError(ctx, h.logger, TypeSecurityAlert, err)
+		return err
+	}
+
+	if h.suppressed(ctx, emaillog.TypeSecurityAlert, payload.UserID, payload.Email) {
+		return nil
+	}
+
+	h.logger.WarnContext(ctx, "sending security alert",
+		slog.String("user_id", payload.UserID),
+		slog.String("event", payload.Event),
+		slog.String("ip_address", payload.IPAddress),
+		slog.String("user_agent", payload.UserAgent),
+		slog.String("country", payload.Country),
+		slog.Int("failed_attempts", payload.FailedAttempts),
+		slog.Bool("secure_account_link", payload.SecureAccountURL != ""),
+		slog.Time("occurred_at", payload.OccurredAt),
+	)
+
+	// TODO: Implement security alert email with the device and location
+	// details and the "secure my account" link
+	// err = h.emailService.SendTemplate(ctx, payload.Email, "security_alert", payload)
+
+	h.recordEmail(ctx, emaillog.TypeSecurityAlert, payload.UserID, payload.Email, nil)
+
+	return nil
+}
+
 // HandleReportGeneration handles report generation tasks
 func (h *Handlers) HandleReportGeneration(ctx context.Context, t *asynq.Task) error {
 	start := time.Now()
-	LogTaskStart(ctx, h.logger, TypeReportGeneration)
+	worker.LogTaskStart(ctx, h.logger, TypeReportGeneration)
 	defer func() {
-		LogTaskComplete(ctx, h.logger, TypeReportGeneration, time.Since(start))
+		worker.LogTaskComplete(ctx, h.logger, TypeReportGeneration, time.Since(start))
 	}()
 
-	payload, err := ParsePayload[ReportPayload](t)
+	// Report generation can run for a long time; heartbeat so the janitor
+	// can tell a slow report from an orphaned one
+	heartbeat := h.heartbeats.Start(ctx, TypeReportGeneration)
+	defer heartbeat.Stop()
+
+	payload, err := worker.ParsePayload[ReportPayload](t)
 	if err != nil {
-		LogTaskError(ctx, h.logger, TypeReportGeneration, err)
+		worker.LogTaskError(ctx, h.logger, TypeReportGeneration, err)
 		return err
 	}
 
@@ -153,42 +324,219 @@ func (h *Handlers) HandleReportGeneration(ctx context.Context, t *asynq.Task) er
 		slog.String("report_type", payload.ReportType),
 		slog.String("user_id", payload.UserID),
 	)
+	progress := worker.NewProgress(ctx, h.progress, TypeReportGeneration, payload.UserID)
+	heartbeat.Progress(0)
+	progress.Update(0, "Generating report")
 
 	// TODO: Implement report generation
 	// 1. Query data for the date range
 	// 2. Generate report in requested format
 	// 3. Store report file
-	// 4. Notify user that report is ready
-
-	return nil
+	// Call heartbeat.Progress between steps, more often than
+	// WORKER_ORPHAN_TIMEOUT, or the janitor cancels the task; call
+	// progress.Update to tell the user, and once the file is stored:
+	// progress.Complete("Report ready", map[string]any{"report_id": payload.ReportID})
+	// Until then the task fails rather than announcing a report that
+	// doesn't exist.
+	err = fmt.Errorf("report generation is not implemented: %w", asynq.SkipRetry)
+	worker.LogTaskError(ctx, h.logger, TypeReportGeneration, err)
+	progress.Fail("Report could not be generated")
+	return err
 }
 
 // HandleDataCleanup handles data cleanup tasks
 func (h *Handlers) HandleDataCleanup(ctx context.Context, t *asynq.Task) error {
 	start := time.Now()
-	LogTaskStart(ctx, h.logger, TypeDataCleanup)
+	worker.LogTaskStart(ctx, h.logger, TypeDataCleanup)
 	defer func() {
-		LogTaskComplete(ctx, h.logger, TypeDataCleanup, time.Since(start))
+		worker.LogTaskComplete(ctx, h.logger, TypeDataCleanup, time.Since(start))
 	}()
 
-	payload, err := ParsePayload[CleanupPayload](t)
+	payload, err := worker.ParsePayload[CleanupPayload](t)
 	if err != nil {
-		LogTaskError(ctx, h.logger, TypeDataCleanup, err)
+		worker.LogTaskError(ctx, h.logger, TypeDataCleanup, err)
 		return err
 	}
+	if payload.MaxAge > 0 {
+		payload.OlderThan = h.clock.Now().Add(-payload.MaxAge)
+	}
 
 	h.logger.InfoContext(ctx, "running data cleanup",
 		slog.String("type", payload.Type),
 		slog.Time("older_than", payload.OlderThan),
 	)
 
-	// TODO: Implement data cleanup based on type
-	// switch payload.Type {
+	// TODO: Implement other data cleanup types
 	// case "sessions":
 	//     return h.sessionRepo.DeleteOlderThan(ctx, payload.OlderThan)
 	// case "logs":
 	//     return h.logRepo.DeleteOlderThan(ctx, payload.OlderThan)
-	// }
+	switch payload.Type {
+	case CleanupEmailDeliveries:
+		if h.emailLog == nil {
+			err := fmt.Errorf("no email log configured: %w", asynq.SkipRetry)
+			worker.LogTaskError(ctx, h.logger, TypeDataCleanup, err)
+			return err
+		}
+		if _, err := h.emailLog.Purge(ctx, payload.OlderThan); err != nil {
+			worker.LogTaskError(ctx, h.logger, TypeDataCleanup, err)
+			return fmt.Errorf("failed to purge email deliveries: %w", err)
+		}
+	case CleanupPrivacyExports:
+		if h.privacy == nil {
+			err := fmt.Errorf("no privacy service configured: %w", asynq.SkipRetry)
+			worker.LogTaskError(ctx, h.logger, TypeDataCleanup, err)
+			return err
+		}
+		if _, err := h.privacy.ExpireArchives(ctx); err != nil {
+			worker.LogTaskError(ctx, h.logger, TypeDataCleanup, err)
+			return fmt.Errorf("failed to expire privacy exports: %w", err)
+		}
+	case CleanupGuests:
+		if h.guests == nil {
+			err := fmt.Errorf("no guest purger configured: %w", asynq.SkipRetry)
+			worker.LogTaskError(ctx, h.logger, TypeDataCleanup, err)
+			return err
+		}
+		if _, err := h.guests.PurgeGuests(ctx, payload.OlderThan); err != nil {
+			worker.LogTaskError(ctx, h.logger, TypeDataCleanup, err)
+			return fmt.Errorf("failed to purge guests: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// HandleTokenCleanup handles refresh token cleanup tasks
+func (h *Handlers) HandleTokenCleanup(ctx context.Context, t *asynq.Task) error {
+	start := time.Now()
+	worker.LogTaskStart(ctx, h.logger, TypeTokenCleanup)
+	defer func() {
+		worker.LogTaskComplete(ctx, h.logger, TypeTokenCleanup, time.Since(start))
+	}()
+
+	if h.tokenPurger == nil {
+		err := fmt.Errorf("no token store configured: %w", asynq.SkipRetry)
+		worker.LogTaskError(ctx, h.logger, TypeTokenCleanup, err)
+		return err
+	}
+
+	deleted, err := h.tokenPurger.PurgeExpiredRefreshTokens(ctx)
+	if err != nil {
+		worker.LogTaskError(ctx, h.logger, TypeTokenCleanup, err)
+		return fmt.Errorf("failed to purge refresh tokens: %w", err)
+	}
+
+	h.logger.InfoContext(ctx, "purged refresh tokens",
+		slog.Int64("deleted", deleted),
+	)
 
 	return nil
 }
+
+// HandlePrivacyExport handles privacy export tasks
+func (h *Handlers) HandlePrivacyExport(ctx context.Context, t *asynq.Task) error {
+	return h.handlePrivacyRequest(ctx, t, TypePrivacyExport, PrivacyProcessor.ProcessExport)
+}
+
+// HandlePrivacyErasure handles privacy erasure tasks
+func (h *Handlers) HandlePrivacyErasure(ctx context.Context, t *asynq.Task) error {
+	return h.handlePrivacyRequest(ctx, t, TypePrivacyErasure, PrivacyProcessor.ProcessErasure)
+}
+
+// handlePrivacyRequest processes a privacy request with process. The request is marked failed once the task
+// won't be retried, so users polling it see it won't complete.
+func (h *Handlers) handlePrivacyRequest(ctx context.Context, t *asynq.Task, taskType string, process func(PrivacyProcessor, context.Context, uuid.UUID) error) error {
+	start := time.Now()
+	worker.LogTaskStart(ctx, h.logger, taskType)
+	defer func() {
+		worker.LogTaskComplete(ctx, h.logger, taskType, time.Since(start))
+	}()
+
+	payload, err := worker.ParsePayload[PrivacyRequestPayload](t)
+	if err != nil {
+		worker.LogTaskError(ctx, h.logger, taskType, err)
+		return err
+	}
+	id, err := uuid.Parse(payload.RequestID)
+	if err != nil {
+		err = fmt.Errorf("invalid privacy request ID %q: %w", payload.RequestID, asynq.SkipRetry)
+		worker.LogTaskError(ctx, h.logger, taskType, err)
+		return err
+	}
+	if h.privacy == nil {
+		err := fmt.Errorf("no privacy service configured: %w", asynq.SkipRetry)
+		worker.LogTaskError(ctx, h.logger, taskType, err)
+		return err
+	}
+
+	if err := process(h.privacy, ctx, id); err != nil {
+		worker.LogTaskError(ctx, h.logger, taskType, err)
+		retried, _ := asynq.GetRetryCount(ctx)
+		maxRetry, ok := asynq.GetMaxRetry(ctx)
+		if !ok || retried >= maxRetry {
+			if ferr := h.privacy.Fail(ctx, id); ferr != nil {
+				h.logger.WarnContext(ctx, "failed to mark privacy request failed",
+					slog.String("request_id", payload.RequestID),
+					slog.String("error", ferr.Error()),
+				)
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// suppressed logs and records an email instead of sending it when the task
+// was enqueued in sandbox mode, reporting whether it did
+func (h *Handlers) suppressed(ctx context.Context, emailType, userID, email string) bool {
+	if !sandbox.Enabled(ctx) {
+		return false
+	}
+
+	h.logger.InfoContext(ctx, "email suppressed in sandbox mode",
+		slog.String("type", emailType),
+		slog.String("to", email),
+	)
+	h.recordEmail(ctx, emailType, userID, email, nil)
+	return true
+}
+
+// recordEmail records the outcome of an email task attempt in the email
+// log, if there is one. sendErr is nil once the email was sent, or
+// suppressed in sandbox mode. A failure is StatusRetrying while asynq will
+// try again. Failing to record never fails the task.
+func (h *Handlers) recordEmail(ctx context.Context, emailType, userID, email string, sendErr error) {
+	if h.emailLog == nil {
+		return
+	}
+
+	retried, _ := asynq.GetRetryCount(ctx)
+	delivery := &emaillog.Delivery{
+		Email:    email,
+		Type:     emailType,
+		Status:   emaillog.StatusSent,
+		Attempts: retried + 1,
+	}
+	if sandbox.Enabled(ctx) {
+		delivery.Status = emaillog.StatusSuppressed
+	}
+	delivery.TaskID, _ = asynq.GetTaskID(ctx)
+	if id, err := uuid.Parse(userID); err == nil {
+		delivery.UserID = &id
+	}
+	if sendErr != nil {
+		delivery.Status = emaillog.StatusFailed
+		delivery.Error = sendErr.Error()
+		if maxRetry, ok := asynq.GetMaxRetry(ctx); ok && retried < maxRetry && !errors.Is(sendErr, asynq.SkipRetry) {
+			delivery.Status = emaillog.StatusRetrying
+		}
+	}
+
+	if err := h.emailLog.Record(ctx, delivery); err != nil {
+		h.logger.WarnContext(ctx, "failed to record email delivery",
+			slog.String("type", emailType),
+			slog.String("error", err.Error()),
+		)
+	}
+}
@@ -0,0 +1,310 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
+	"github.com/pixperk/goiler/internal/emaillog"
+	"github.com/pixperk/goiler/internal/testutil"
+	"github.com/pixperk/goiler/pkg/clock"
+	"github.com/pixperk/goiler/pkg/events"
+	"github.com/pixperk/goiler/pkg/sandbox"
+)
+
+func TestHandlePasswordResetEmail_Expiry(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	clk := clock.NewFrozen(now)
+	h := NewHandlers(testutil.Logger(), nil)
+	h.clock = clk
+
+	task, err := NewPasswordResetEmailTask("user-1", "test@example.com", "reset-token", now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to create task: %v", err)
+	}
+
+	if err := h.HandlePasswordResetEmail(context.Background(), task); err != nil {
+		t.Fatalf("Expected unexpired reset to be sent, got: %v", err)
+	}
+
+	clk.Advance(2 * time.Hour)
+	if err := h.HandlePasswordResetEmail(context.Background(), task); err == nil {
+		t.Error("Expected expired reset to be rejected")
+	}
+}
+
+func TestHandleOrgInvitationEmail_Expiry(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	clk := clock.NewFrozen(now)
+	h := NewHandlers(testutil.Logger(), nil)
+	h.clock = clk
+
+	task, err := NewOrgInvitationEmailTask(OrgInvitationPayload{
+		Email:     "invitee@example.com",
+		OrgName:   "Acme",
+		Role:      "member",
+		AcceptURL: "https://app.example.com/invitations/accept?token=abc",
+		ExpiresAt: now.Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create task: %v", err)
+	}
+
+	if err := h.HandleOrgInvitationEmail(context.Background(), task); err != nil {
+		t.Fatalf("Expected unexpired invitation to be sent, got: %v", err)
+	}
+
+	clk.Advance(2 * time.Hour)
+	if err := h.HandleOrgInvitationEmail(context.Background(), task); !errors.Is(err, asynq.SkipRetry) {
+		t.Errorf("Expected expired invitation to be skipped without retries, got: %v", err)
+	}
+}
+
+type memoryEmailLog struct {
+	deliveries   []*emaillog.Delivery
+	purgedBefore time.Time
+}
+
+func (l *memoryEmailLog) Record(ctx context.Context, delivery *emaillog.Delivery) error {
+	l.deliveries = append(l.deliveries, delivery)
+	return nil
+}
+
+func (l *memoryEmailLog) Purge(ctx context.Context, before time.Time) (int64, error) {
+	l.purgedBefore = before
+	return 0, nil
+}
+
+// memoryGuests records the cutoff guests are purged before
+type memoryGuests struct {
+	cutoff time.Time
+}
+
+func (g *memoryGuests) PurgeGuests(ctx context.Context, cutoff time.Time) (int64, error) {
+	g.cutoff = cutoff
+	return 0, nil
+}
+
+func TestHandleDataCleanup_MaxAge(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	log := &memoryEmailLog{}
+	h := NewHandlers(testutil.Logger(), nil)
+	h.clock = clock.NewFrozen(now)
+	h.emailLog = log
+
+	task, err := NewPeriodicCleanupTask(CleanupEmailDeliveries, 90*24*time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create task: %v", err)
+	}
+	if err := h.HandleDataCleanup(context.Background(), task); err != nil {
+		t.Fatalf("Failed to handle cleanup: %v", err)
+	}
+	if want := now.AddDate(0, 0, -90); !log.purgedBefore.Equal(want) {
+		t.Errorf("Expected entries before %v to be purged, got %v", want, log.purgedBefore)
+	}
+
+	task, err = NewPeriodicCleanupTask(CleanupGuests, 30*24*time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create task: %v", err)
+	}
+	if err := h.HandleDataCleanup(context.Background(), task); !errors.Is(err, asynq.SkipRetry) {
+		t.Errorf("Expected guest cleanup without a purger to be skipped, got: %v", err)
+	}
+	guests := &memoryGuests{}
+	h.guests = guests
+	if err := h.HandleDataCleanup(context.Background(), task); err != nil {
+		t.Fatalf("Failed to handle cleanup: %v", err)
+	}
+	if want := now.AddDate(0, 0, -30); !guests.cutoff.Equal(want) {
+		t.Errorf("Expected guests before %v to be purged, got %v", want, guests.cutoff)
+	}
+}
+
+func TestHandlers_RecordEmails(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	clk := clock.NewFrozen(now)
+	log := &memoryEmailLog{}
+	h := NewHandlers(testutil.Logger(), nil)
+	h.clock = clk
+	h.emailLog = log
+
+	userID := uuid.New()
+	welcome, _ := NewWelcomeEmailTask(userID.String(), "user@example.com", "User", "")
+	if err := h.HandleWelcomeEmail(context.Background(), welcome); err != nil {
+		t.Fatalf("Failed to handle welcome email: %v", err)
+	}
+
+	invitation, _ := NewOrgInvitationEmailTask(OrgInvitationPayload{
+		Email:     "invitee@example.com",
+		OrgName:   "Acme",
+		ExpiresAt: now.Add(-time.Hour),
+	})
+	_ = h.HandleOrgInvitationEmail(context.Background(), invitation)
+
+	if len(log.deliveries) != 2 {
+		t.Fatalf("Expected 2 recorded deliveries, got %d", len(log.deliveries))
+	}
+	sent := log.deliveries[0]
+	if sent.Type != emaillog.TypeWelcome || sent.Status != emaillog.StatusSent || sent.UserID == nil || *sent.UserID != userID {
+		t.Errorf("Unexpected welcome delivery: %+v", sent)
+	}
+	failed := log.deliveries[1]
+	if failed.Type != emaillog.TypeOrgInvitation || failed.Status != emaillog.StatusFailed || failed.UserID != nil || failed.Error == "" {
+		t.Errorf("Unexpected invitation delivery: %+v", failed)
+	}
+}
+
+func TestHandlers_SuppressEmailsInSandbox(t *testing.T) {
+	log := &memoryEmailLog{}
+	h := NewHandlers(testutil.Logger(), nil)
+	h.emailLog = log
+
+	welcome, _ := NewWelcomeEmailTask(uuid.New().String(), "user@example.com", "User", "")
+	if err := h.HandleWelcomeEmail(sandbox.With(context.Background()), welcome); err != nil {
+		t.Fatalf("Failed to handle welcome email: %v", err)
+	}
+	if len(log.deliveries) != 1 || log.deliveries[0].Status != emaillog.StatusSuppressed {
+		t.Errorf("Expected a suppressed delivery, got %+v", log.deliveries)
+	}
+}
+
+type memoryPrivacy struct {
+	exported []uuid.UUID
+	failed   map[uuid.UUID]bool
+	err      error
+}
+
+func (p *memoryPrivacy) ProcessExport(ctx context.Context, id uuid.UUID) error {
+	if p.err != nil {
+		return p.err
+	}
+	p.exported = append(p.exported, id)
+	return nil
+}
+
+func (p *memoryPrivacy) ProcessErasure(ctx context.Context, id uuid.UUID) error {
+	return p.err
+}
+
+func (p *memoryPrivacy) Fail(ctx context.Context, id uuid.UUID) error {
+	p.failed[id] = true
+	return nil
+}
+
+func (p *memoryPrivacy) ExpireArchives(ctx context.Context) (int64, error) {
+	return 0, nil
+}
+
+func TestHandlePrivacyRequests(t *testing.T) {
+	privacy := &memoryPrivacy{failed: make(map[uuid.UUID]bool)}
+	h := NewHandlers(testutil.Logger(), nil)
+
+	id := uuid.New()
+	export, _ := NewPrivacyExportTask(id.String())
+	if err := h.HandlePrivacyExport(context.Background(), export); !errors.Is(err, asynq.SkipRetry) {
+		t.Errorf("Expected the task to be skipped without a privacy service, got: %v", err)
+	}
+
+	h.privacy = privacy
+	if err := h.HandlePrivacyExport(context.Background(), export); err != nil {
+		t.Fatalf("Failed to handle export: %v", err)
+	}
+	if len(privacy.exported) != 1 || privacy.exported[0] != id {
+		t.Errorf("Expected request %s to be exported, got %v", id, privacy.exported)
+	}
+
+	// Outside asynq there are no retries left, so the request is failed
+	privacy.err = errors.New("database down")
+	erasure, _ := NewPrivacyErasureTask(id.String())
+	if err := h.HandlePrivacyErasure(context.Background(), erasure); err == nil {
+		t.Fatal("Expected the erasure error to be returned")
+	}
+	if !privacy.failed[id] {
+		t.Error("Expected the request to be marked failed on its last attempt")
+	}
+
+	invalid, _ := NewPrivacyErasureTask("not-a-uuid")
+	if err := h.HandlePrivacyErasure(context.Background(), invalid); !errors.Is(err, asynq.SkipRetry) {
+		t.Errorf("Expected an invalid request ID to be skipped, got: %v", err)
+	}
+}
+
+// recordingPublisher keeps the payloads published to it
+type recordingPublisher struct {
+	payloads []any
+}
+
+func (p *recordingPublisher) Publish(topic string, payload interface{}) int {
+	p.payloads = append(p.payloads, payload)
+	return 1
+}
+
+func TestHandleReportGeneration_NotImplemented(t *testing.T) {
+	pub := &recordingPublisher{}
+	h := NewHandlers(testutil.Logger(), nil)
+	h.progress = pub
+
+	task, err := NewReportTask("report-1", "sales", "user-1", time.Now().AddDate(0, -1, 0), time.Now())
+	if err != nil {
+		t.Fatalf("Failed to create task: %v", err)
+	}
+	if err := h.HandleReportGeneration(context.Background(), task); !errors.Is(err, asynq.SkipRetry) {
+		t.Errorf("Expected the stub to fail without retries, got: %v", err)
+	}
+
+	// The user is told the report failed, never that it's ready
+	if len(pub.payloads) == 0 {
+		t.Fatal("Expected progress events")
+	}
+	for _, payload := range pub.payloads {
+		if event, _ := events.TaskProgress.Payload(payload); event.Status == events.TaskCompleted {
+			t.Errorf("Expected no completion from the stub, got %+v", event)
+		}
+	}
+	last, _ := events.TaskProgress.Payload(pub.payloads[len(pub.payloads)-1])
+	if last.Status != events.TaskFailed || last.Error != "Report could not be generated" {
+		t.Errorf("Expected a failure with a user-safe message, got %+v", last)
+	}
+}
+
+// fakeTokenPurger purges a fixed number of tokens, or fails with err
+type fakeTokenPurger struct {
+	deleted int64
+	err     error
+	calls   int
+}
+
+func (p *fakeTokenPurger) PurgeExpiredRefreshTokens(ctx context.Context) (int64, error) {
+	p.calls++
+	return p.deleted, p.err
+}
+
+func TestHandleTokenCleanup(t *testing.T) {
+	h := NewHandlers(testutil.Logger(), nil)
+	task, err := NewTokenCleanupTask()
+	if err != nil {
+		t.Fatalf("Failed to create task: %v", err)
+	}
+
+	if err := h.HandleTokenCleanup(context.Background(), task); !errors.Is(err, asynq.SkipRetry) {
+		t.Errorf("Expected cleanup without a token store to be skipped, got: %v", err)
+	}
+
+	purger := &fakeTokenPurger{deleted: 3}
+	h.tokenPurger = purger
+	if err := h.HandleTokenCleanup(context.Background(), task); err != nil {
+		t.Fatalf("Failed to handle cleanup: %v", err)
+	}
+	if purger.calls != 1 {
+		t.Errorf("Expected one purge, got %d", purger.calls)
+	}
+
+	// Failures are retried
+	purger.err = errors.New("connection refused")
+	if err := h.HandleTokenCleanup(context.Background(), task); err == nil || errors.Is(err, asynq.SkipRetry) {
+		t.Errorf("Expected a retryable error, got: %v", err)
+	}
+}
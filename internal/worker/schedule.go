@@ -0,0 +1,103 @@
+package worker
+
+import (
+	"log/slog"
+
+	"github.com/hibiken/asynq"
+	"github.com/pixperk/goiler/pkg/worker"
+)
+
+// Names of the built-in schedules, registered when the worker has what
+// their tasks need
+const (
+	// ScheduleTokenCleanup purges expired refresh tokens hourly
+	ScheduleTokenCleanup = "token_cleanup"
+	// ScheduleEmailLogCleanup deletes email log entries older than
+	// WORKER_EMAIL_LOG_RETENTION nightly
+	ScheduleEmailLogCleanup = "email_log_cleanup"
+	// SchedulePrivacyExportCleanup deletes expired export archives hourly
+	SchedulePrivacyExportCleanup = "privacy_export_cleanup"
+	// ScheduleGuestCleanup deletes guest accounts unused for
+	// WORKER_GUEST_RETENTION nightly
+	ScheduleGuestCleanup = "guest_cleanup"
+)
+
+// ScheduleOff turns a schedule off in WORKER_SCHEDULES
+const ScheduleOff = "off"
+
+// Schedule enqueues task periodically on spec, a cron expression such as
+// "0 3 * * *" or a descriptor such as "@daily" or "@every 30m", in UTC.
+// WORKER_SCHEDULES can override the spec by name or turn the schedule off.
+// Register schedules before Start; they're ignored on workers with the
+// scheduler disabled.
+func (s *Server) Schedule(name, spec string, task *asynq.Task, opts ...asynq.Option) error {
+	if s.scheduler == nil {
+		return nil
+	}
+	if override, ok := s.scheduleSpecs[name]; ok {
+		spec = override
+	}
+	if spec == ScheduleOff {
+		s.logger.Info("schedule turned off", slog.String("name", name))
+		return nil
+	}
+	return s.scheduler.Register(worker.Schedule{Name: name, Spec: spec, Task: task, Options: opts})
+}
+
+// Schedules returns the schedules registered on this worker, sorted by
+// name
+func (s *Server) Schedules() []worker.Schedule {
+	if s.scheduler == nil {
+		return nil
+	}
+	return s.scheduler.Schedules()
+}
+
+// scheduleBuiltins registers the built-in schedules whose dependencies
+// are set, and warns about overrides naming no schedule
+func (s *Server) scheduleBuiltins() error {
+	if s.scheduler == nil {
+		return nil
+	}
+
+	builtins := []struct {
+		name    string
+		spec    string
+		enabled bool
+		task    func() (*asynq.Task, error)
+	}{
+		{ScheduleTokenCleanup, "@hourly", s.handlers.tokenPurger != nil, NewTokenCleanupTask},
+		{ScheduleEmailLogCleanup, "0 3 * * *", s.handlers.emailLog != nil && s.emailLogRetention > 0, func() (*asynq.Task, error) {
+			return NewPeriodicCleanupTask(CleanupEmailDeliveries, s.emailLogRetention)
+		}},
+		{SchedulePrivacyExportCleanup, "@hourly", s.handlers.privacy != nil, func() (*asynq.Task, error) {
+			return NewPeriodicCleanupTask(CleanupPrivacyExports, 0)
+		}},
+		{ScheduleGuestCleanup, "30 3 * * *", s.handlers.guests != nil && s.guestRetention > 0, func() (*asynq.Task, error) {
+			return NewPeriodicCleanupTask(CleanupGuests, s.guestRetention)
+		}},
+	}
+	for _, builtin := range builtins {
+		if !builtin.enabled {
+			continue
+		}
+		task, err := builtin.task()
+		if err != nil {
+			return err
+		}
+		if err := s.Schedule(builtin.name, builtin.spec, task, asynq.Queue("low")); err != nil {
+			return err
+		}
+	}
+
+	registered := make(map[string]bool)
+	for _, schedule := range s.scheduler.Schedules() {
+		registered[schedule.Name] = true
+	}
+	for name, spec := range s.scheduleSpecs {
+		if spec != ScheduleOff && !registered[name] {
+			s.logger.Warn("WORKER_SCHEDULES overrides an unregistered schedule", slog.String("name", name))
+		}
+	}
+	return nil
+}
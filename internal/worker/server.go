@@ -3,26 +3,38 @@ package worker
 import (
 	"context"
 	"log/slog"
+	"time"
 
 	"github.com/hibiken/asynq"
 	"github.com/pixperk/goiler/internal/config"
+	"github.com/pixperk/goiler/pkg/clock"
+	"github.com/pixperk/goiler/pkg/events"
+	"github.com/pixperk/goiler/pkg/redisconn"
+	"github.com/pixperk/goiler/pkg/worker"
+	"github.com/redis/go-redis/v9"
 )
 
 // Server represents the Asynq worker server
 type Server struct {
-	server   *asynq.Server
-	mux      *asynq.ServeMux
-	handlers *Handlers
-	logger   *slog.Logger
+	server    *asynq.Server
+	mux       *asynq.ServeMux
+	handlers  *Handlers
+	logger    *slog.Logger
+	redis     redis.UniversalClient
+	inspector *asynq.Inspector
+	janitor   *worker.Janitor
+	cancel    context.CancelFunc
+
+	// scheduler enqueues periodic tasks, nil when disabled
+	scheduler         *worker.Scheduler
+	scheduleSpecs     map[string]string
+	emailLogRetention time.Duration
+	guestRetention    time.Duration
 }
 
 // NewServer creates a new worker server
 func NewServer(cfg *config.Config, logger *slog.Logger) *Server {
-	redisOpt := asynq.RedisClientOpt{
-		Addr:     cfg.Redis.Addr,
-		Password: cfg.Redis.Password,
-		DB:       cfg.Redis.DB,
-	}
+	redisOpt := redisconn.ConnOpt(cfg.Redis)
 
 	server := asynq.NewServer(
 		redisOpt,
@@ -31,11 +43,7 @@ func NewServer(cfg *config.Config, logger *slog.Logger) *Server {
 			Concurrency: 10,
 
 			// Queue priorities
-			Queues: map[string]int{
-				"critical": 6,
-				"default":  3,
-				"low":      1,
-			},
+			Queues: QueuePriorities,
 
 			// Retry configuration
 			RetryDelayFunc: asynq.DefaultRetryDelayFunc,
@@ -53,14 +61,32 @@ func NewServer(cfg *config.Config, logger *slog.Logger) *Server {
 		},
 	)
 
-	handlers := NewHandlers(logger)
+	rdb := redisconn.NewClient(cfg.Redis)
+	inspector := asynq.NewInspectorFromRedisClient(rdb)
+	heartbeats := worker.NewHeartbeatStore(rdb, cfg.Worker.HeartbeatInterval)
+
+	handlers := NewHandlers(logger, heartbeats)
 	mux := asynq.NewServeMux()
+	mux.Use(worker.TracingMiddleware(cfg.OTEL.ServiceName))
+
+	var scheduler *worker.Scheduler
+	if cfg.Worker.Scheduler {
+		scheduler = worker.NewScheduler(redisOpt, asynq.SchedulerOpts{Logger: &asynqLogger{logger: logger}}, logger)
+	}
 
 	return &Server{
-		server:   server,
-		mux:      mux,
-		handlers: handlers,
-		logger:   logger,
+		server:    server,
+		mux:       mux,
+		handlers:  handlers,
+		logger:    logger,
+		redis:     rdb,
+		inspector: inspector,
+		janitor:   worker.NewJanitor(inspector, heartbeats, Queues(), cfg.Worker.OrphanTimeout, logger),
+
+		scheduler:         scheduler,
+		scheduleSpecs:     cfg.Worker.Schedules,
+		emailLogRetention: cfg.Worker.EmailLogRetention,
+		guestRetention:    cfg.Worker.GuestRetention,
 	}
 }
 
@@ -69,22 +95,86 @@ func (s *Server) RegisterHandlers() {
 	s.mux.HandleFunc(TypeEmailDelivery, s.handlers.HandleEmailDelivery)
 	s.mux.HandleFunc(TypeWelcomeEmail, s.handlers.HandleWelcomeEmail)
 	s.mux.HandleFunc(TypePasswordResetEmail, s.handlers.HandlePasswordResetEmail)
+	s.mux.HandleFunc(TypeOrgInvitationEmail, s.handlers.HandleOrgInvitationEmail)
 	s.mux.HandleFunc(TypeNotification, s.handlers.HandleNotification)
+	s.mux.HandleFunc(TypeSecurityAlert, s.handlers.HandleSecurityAlert)
 	s.mux.HandleFunc(TypeReportGeneration, s.handlers.HandleReportGeneration)
 	s.mux.HandleFunc(TypeDataCleanup, s.handlers.HandleDataCleanup)
+	s.mux.HandleFunc(TypeTokenCleanup, s.handlers.HandleTokenCleanup)
+	s.mux.HandleFunc(TypePrivacyExport, s.handlers.HandlePrivacyExport)
+	s.mux.HandleFunc(TypePrivacyErasure, s.handlers.HandlePrivacyErasure)
+}
+
+// SetTokenPurger sets the store cleaned by token cleanup tasks
+func (s *Server) SetTokenPurger(purger TokenPurger) {
+	s.handlers.tokenPurger = purger
+}
+
+// SetEmailLog sets where the outcome of email tasks is recorded. Emails
+// aren't recorded without one.
+func (s *Server) SetEmailLog(log EmailLog) {
+	s.handlers.emailLog = log
+}
+
+// SetPrivacy sets the service that processes privacy export and erasure
+// tasks
+func (s *Server) SetPrivacy(privacy PrivacyProcessor) {
+	s.handlers.privacy = privacy
+}
+
+// SetGuestPurger sets what deletes abandoned guest accounts for the
+// guest_cleanup schedule
+func (s *Server) SetGuestPurger(guests GuestPurger) {
+	s.handlers.guests = guests
 }
 
-// Start starts the worker server
+// SetProgressPublisher sets where task handlers publish the task.progress
+// events telling users how their tasks are doing. Nothing is published
+// without one.
+func (s *Server) SetProgressPublisher(publisher events.Publisher) {
+	s.handlers.progress = publisher
+}
+
+// SetClock sets the clock used for expiry checks and orphan detection
+func (s *Server) SetClock(c clock.Clock) {
+	s.handlers.clock = c
+	s.handlers.heartbeats.SetClock(c)
+	s.janitor.SetClock(c)
+}
+
+// Start starts the worker server and the scheduler in the background
 func (s *Server) Start() error {
 	s.RegisterHandlers()
+	if err := s.scheduleBuiltins(); err != nil {
+		return err
+	}
 	s.logger.Info("starting worker server")
-	return s.server.Start(s.mux)
+	if err := s.server.Start(s.mux); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	go s.janitor.Run(ctx)
+
+	if s.scheduler == nil {
+		s.logger.Info("scheduler disabled, periodic tasks are left to the worker with WORKER_SCHEDULER on")
+		return nil
+	}
+	return s.scheduler.Start()
 }
 
-// Shutdown gracefully shuts down the server
+// Shutdown gracefully shuts down the scheduler and the server
 func (s *Server) Shutdown() {
 	s.logger.Info("shutting down worker server")
+	if s.scheduler != nil {
+		s.scheduler.Shutdown()
+	}
+	if s.cancel != nil {
+		s.cancel()
+	}
 	s.server.Shutdown()
+	s.redis.Close()
 }
 
 // asynqLogger adapts slog.Logger to asynq.Logger interface
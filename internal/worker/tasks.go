@@ -1,25 +1,62 @@
 package worker
 
 import (
-	"context"
-	"encoding/json"
-	"fmt"
-	"log/slog"
 	"time"
 
 	"github.com/hibiken/asynq"
+	"github.com/pixperk/goiler/pkg/worker"
 )
 
 // Task type constants
 const (
-	TypeEmailDelivery     = "email:delivery"
-	TypeWelcomeEmail      = "email:welcome"
+	TypeEmailDelivery      = "email:delivery"
+	TypeWelcomeEmail       = "email:welcome"
 	TypePasswordResetEmail = "email:password_reset"
-	TypeNotification      = "notification:send"
-	TypeReportGeneration  = "report:generate"
-	TypeDataCleanup       = "data:cleanup"
+	TypeNotification       = "notification:send"
+	TypeReportGeneration   = "report:generate"
+	TypeDataCleanup        = "data:cleanup"
+	TypeSecurityAlert      = "security:alert"
+	TypeTokenCleanup       = "auth:token_cleanup"
+	TypeOrgInvitationEmail = "email:org_invitation"
+	TypePrivacyExport      = "privacy:export"
+	TypePrivacyErasure     = "privacy:erasure"
 )
 
+// taskOptions are the options each task type is created with. Tasks are
+// rebuilt from them when metadata is attached at enqueue time, so options
+// of other task types are lost then; pass those to Enqueue instead.
+var taskOptions = map[string][]asynq.Option{
+	TypeEmailDelivery:      {asynq.MaxRetry(3)},
+	TypeWelcomeEmail:       {asynq.MaxRetry(3)},
+	TypePasswordResetEmail: {asynq.MaxRetry(3)},
+	TypeNotification:       {asynq.MaxRetry(5)},
+	TypeReportGeneration:   {asynq.MaxRetry(2), asynq.Timeout(30 * time.Minute)},
+	TypeDataCleanup:        {asynq.MaxRetry(1)},
+	TypeSecurityAlert:      {asynq.MaxRetry(5)},
+	TypeTokenCleanup:       {asynq.MaxRetry(1)},
+	TypeOrgInvitationEmail: {asynq.MaxRetry(3)},
+	TypePrivacyExport:      {asynq.MaxRetry(3), asynq.Timeout(10 * time.Minute)},
+	TypePrivacyErasure:     {asynq.MaxRetry(5)},
+}
+
+// defaultTaskOptions are the options of task types missing from
+// taskOptions
+var defaultTaskOptions = []asynq.Option{asynq.MaxRetry(3)}
+
+// newTask creates a task with its type's options
+func newTask(taskType string, payload []byte) *asynq.Task {
+	return asynq.NewTask(taskType, payload, optionsFor(taskType)...)
+}
+
+// optionsFor returns the options of a task type, or the defaults for types
+// without any
+func optionsFor(taskType string) []asynq.Option {
+	if opts, ok := taskOptions[taskType]; ok {
+		return opts
+	}
+	return defaultTaskOptions
+}
+
 // EmailDeliveryPayload represents email delivery task payload
 type EmailDeliveryPayload struct {
 	To      string `json:"to"`
@@ -32,16 +69,29 @@ type WelcomeEmailPayload struct {
 	UserID string `json:"user_id"`
 	Email  string `json:"email"`
 	Name   string `json:"name"`
+	// VerificationURL is the email verification link, if one was issued
+	VerificationURL string `json:"verification_url,omitempty"`
 }
 
 // PasswordResetPayload represents password reset email task payload
 type PasswordResetPayload struct {
-	UserID     string `json:"user_id"`
-	Email      string `json:"email"`
-	ResetToken string `json:"reset_token"`
+	UserID     string    `json:"user_id"`
+	Email      string    `json:"email"`
+	ResetToken string    `json:"reset_token"`
 	ExpiresAt  time.Time `json:"expires_at"`
 }
 
+// OrgInvitationPayload represents organization invitation email task payload
+type OrgInvitationPayload struct {
+	Email        string `json:"email"`
+	OrgName      string `json:"org_name"`
+	InviterEmail string `json:"inviter_email"`
+	Role         string `json:"role"`
+	// AcceptURL is the invitation link, carrying the invitation token
+	AcceptURL string    `json:"accept_url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
 // NotificationPayload represents notification task payload
 type NotificationPayload struct {
 	UserID  string                 `json:"user_id"`
@@ -60,15 +110,38 @@ type ReportPayload struct {
 	EndDate    time.Time `json:"end_date"`
 }
 
-// CleanupPayload represents data cleanup task payload
+// CleanupPayload represents data cleanup task payload. Periodic cleanups
+// set MaxAge instead of OlderThan, which is then computed when they run.
 type CleanupPayload struct {
-	Type      string    `json:"type"`
-	OlderThan time.Time `json:"older_than"`
+	Type      string        `json:"type"`
+	OlderThan time.Time     `json:"older_than"`
+	MaxAge    time.Duration `json:"max_age,omitempty"`
+}
+
+// SecurityAlertPayload represents security alert task payload
+type SecurityAlertPayload struct {
+	UserID     string    `json:"user_id"`
+	Email      string    `json:"email"`
+	Event      string    `json:"event"`
+	IPAddress  string    `json:"ip_address,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	Country    string    `json:"country,omitempty"`
+	OccurredAt time.Time `json:"occurred_at"`
+	// FailedAttempts is the number of failed logins, for failed login alerts
+	FailedAttempts int `json:"failed_attempts,omitempty"`
+	// SecureAccountURL is the "secure my account" link that signs the user
+	// out everywhere
+	SecureAccountURL string `json:"secure_account_url,omitempty"`
+}
+
+// PrivacyRequestPayload represents privacy export and erasure task payload
+type PrivacyRequestPayload struct {
+	RequestID string `json:"request_id"`
 }
 
 // NewEmailDeliveryTask creates a new email delivery task
 func NewEmailDeliveryTask(to, subject, body string) (*asynq.Task, error) {
-	payload, err := json.Marshal(EmailDeliveryPayload{
+	payload, err := worker.EncodePayload(EmailDeliveryPayload{
 		To:      to,
 		Subject: subject,
 		Body:    body,
@@ -76,25 +149,27 @@ func NewEmailDeliveryTask(to, subject, body string) (*asynq.Task, error) {
 	if err != nil {
 		return nil, err
 	}
-	return asynq.NewTask(TypeEmailDelivery, payload), nil
+	return newTask(TypeEmailDelivery, payload), nil
 }
 
-// NewWelcomeEmailTask creates a new welcome email task
-func NewWelcomeEmailTask(userID, email, name string) (*asynq.Task, error) {
-	payload, err := json.Marshal(WelcomeEmailPayload{
-		UserID: userID,
-		Email:  email,
-		Name:   name,
+// NewWelcomeEmailTask creates a new welcome email task. verificationURL may
+// be empty when no verification link was issued.
+func NewWelcomeEmailTask(userID, email, name, verificationURL string) (*asynq.Task, error) {
+	payload, err := worker.EncodePayload(WelcomeEmailPayload{
+		UserID:          userID,
+		Email:           email,
+		Name:            name,
+		VerificationURL: verificationURL,
 	})
 	if err != nil {
 		return nil, err
 	}
-	return asynq.NewTask(TypeWelcomeEmail, payload, asynq.MaxRetry(3)), nil
+	return newTask(TypeWelcomeEmail, payload), nil
 }
 
 // NewPasswordResetEmailTask creates a new password reset email task
 func NewPasswordResetEmailTask(userID, email, resetToken string, expiresAt time.Time) (*asynq.Task, error) {
-	payload, err := json.Marshal(PasswordResetPayload{
+	payload, err := worker.EncodePayload(PasswordResetPayload{
 		UserID:     userID,
 		Email:      email,
 		ResetToken: resetToken,
@@ -103,12 +178,21 @@ func NewPasswordResetEmailTask(userID, email, resetToken string, expiresAt time.
 	if err != nil {
 		return nil, err
 	}
-	return asynq.NewTask(TypePasswordResetEmail, payload, asynq.MaxRetry(3)), nil
+	return newTask(TypePasswordResetEmail, payload), nil
+}
+
+// NewOrgInvitationEmailTask creates a new organization invitation email task
+func NewOrgInvitationEmailTask(payload OrgInvitationPayload) (*asynq.Task, error) {
+	data, err := worker.EncodePayload(payload)
+	if err != nil {
+		return nil, err
+	}
+	return newTask(TypeOrgInvitationEmail, data), nil
 }
 
 // NewNotificationTask creates a new notification task
 func NewNotificationTask(userID, notificationType, title, message string, data map[string]interface{}) (*asynq.Task, error) {
-	payload, err := json.Marshal(NotificationPayload{
+	payload, err := worker.EncodePayload(NotificationPayload{
 		UserID:  userID,
 		Type:    notificationType,
 		Title:   title,
@@ -118,12 +202,12 @@ func NewNotificationTask(userID, notificationType, title, message string, data m
 	if err != nil {
 		return nil, err
 	}
-	return asynq.NewTask(TypeNotification, payload, asynq.MaxRetry(5)), nil
+	return newTask(TypeNotification, payload), nil
 }
 
 // NewReportTask creates a new report generation task
 func NewReportTask(reportID, reportType, userID string, startDate, endDate time.Time) (*asynq.Task, error) {
-	payload, err := json.Marshal(ReportPayload{
+	payload, err := worker.EncodePayload(ReportPayload{
 		ReportID:   reportID,
 		ReportType: reportType,
 		UserID:     userID,
@@ -133,19 +217,64 @@ func NewReportTask(reportID, reportType, userID string, startDate, endDate time.
 	if err != nil {
 		return nil, err
 	}
-	return asynq.NewTask(TypeReportGeneration, payload, asynq.MaxRetry(2), asynq.Timeout(30*time.Minute)), nil
+	return newTask(TypeReportGeneration, payload), nil
 }
 
 // NewCleanupTask creates a new data cleanup task
 func NewCleanupTask(cleanupType string, olderThan time.Time) (*asynq.Task, error) {
-	payload, err := json.Marshal(CleanupPayload{
+	payload, err := worker.EncodePayload(CleanupPayload{
 		Type:      cleanupType,
 		OlderThan: olderThan,
 	})
 	if err != nil {
 		return nil, err
 	}
-	return asynq.NewTask(TypeDataCleanup, payload, asynq.MaxRetry(1)), nil
+	return newTask(TypeDataCleanup, payload), nil
+}
+
+// NewPeriodicCleanupTask creates a data cleanup task deleting data older
+// than maxAge when it runs, for schedules
+func NewPeriodicCleanupTask(cleanupType string, maxAge time.Duration) (*asynq.Task, error) {
+	payload, err := worker.EncodePayload(CleanupPayload{
+		Type:   cleanupType,
+		MaxAge: maxAge,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newTask(TypeDataCleanup, payload), nil
+}
+
+// NewSecurityAlertTask creates a new security alert task
+func NewSecurityAlertTask(payload SecurityAlertPayload) (*asynq.Task, error) {
+	data, err := worker.EncodePayload(payload)
+	if err != nil {
+		return nil, err
+	}
+	return newTask(TypeSecurityAlert, data), nil
+}
+
+// NewTokenCleanupTask creates a task that purges expired and revoked refresh tokens
+func NewTokenCleanupTask() (*asynq.Task, error) {
+	return newTask(TypeTokenCleanup, nil), nil
+}
+
+// NewPrivacyExportTask creates a task that builds a user's data export
+func NewPrivacyExportTask(requestID string) (*asynq.Task, error) {
+	payload, err := worker.EncodePayload(PrivacyRequestPayload{RequestID: requestID})
+	if err != nil {
+		return nil, err
+	}
+	return newTask(TypePrivacyExport, payload), nil
+}
+
+// NewPrivacyErasureTask creates a task that erases a user's data
+func NewPrivacyErasureTask(requestID string) (*asynq.Task, error) {
+	payload, err := worker.EncodePayload(PrivacyRequestPayload{RequestID: requestID})
+	if err != nil {
+		return nil, err
+	}
+	return newTask(TypePrivacyErasure, payload), nil
 }
 
 // ScheduleCleanupTask creates a scheduled cleanup task
@@ -160,44 +289,12 @@ func ScheduleCleanupTask(cleanupType string, olderThan time.Time, schedule strin
 
 // TaskInfo represents information about a task
 type TaskInfo struct {
-	ID        string    `json:"id"`
-	Type      string    `json:"type"`
-	Queue     string    `json:"queue"`
-	Payload   []byte    `json:"payload"`
-	State     string    `json:"state"`
-	CreatedAt time.Time `json:"created_at"`
+	ID          string     `json:"id"`
+	Type        string     `json:"type"`
+	Queue       string     `json:"queue"`
+	Payload     []byte     `json:"payload"`
+	State       string     `json:"state"`
+	CreatedAt   time.Time  `json:"created_at"`
 	ProcessedAt *time.Time `json:"processed_at,omitempty"`
 	CompletedAt *time.Time `json:"completed_at,omitempty"`
 }
-
-// ParsePayload is a helper to parse task payloads
-func ParsePayload[T any](task *asynq.Task) (*T, error) {
-	var payload T
-	if err := json.Unmarshal(task.Payload(), &payload); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal payload: %w", err)
-	}
-	return &payload, nil
-}
-
-// LogTaskStart logs task start
-func LogTaskStart(ctx context.Context, logger *slog.Logger, taskType string) {
-	logger.InfoContext(ctx, "starting task",
-		slog.String("type", taskType),
-	)
-}
-
-// LogTaskComplete logs task completion
-func LogTaskComplete(ctx context.Context, logger *slog.Logger, taskType string, duration time.Duration) {
-	logger.InfoContext(ctx, "task completed",
-		slog.String("type", taskType),
-		slog.Duration("duration", duration),
-	)
-}
-
-// LogTaskError logs task error
-func LogTaskError(ctx context.Context, logger *slog.Logger, taskType string, err error) {
-	logger.ErrorContext(ctx, "task failed",
-		slog.String("type", taskType),
-		slog.String("error", err.Error()),
-	)
-}
@@ -0,0 +1,134 @@
+package worker
+
+import (
+	"errors"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+var errInvalidWire = errors.New("invalid protobuf payload")
+
+// ReportPayloadVersion is the current schema version of ReportPayload.
+// Bump it when fields change and handle older versions in UpgradeFrom.
+const ReportPayloadVersion = 1
+
+// PayloadVersion implements worker.Versioned
+func (p ReportPayload) PayloadVersion() uint16 { return ReportPayloadVersion }
+
+// MarshalWire implements worker.WireMarshaler. The layout matches
+// goiler.worker.v1.ReportPayload in payloads.proto.
+func (p ReportPayload) MarshalWire() ([]byte, error) {
+	var b []byte
+	b = appendString(b, 1, p.ReportID)
+	b = appendString(b, 2, p.ReportType)
+	b = appendString(b, 3, p.UserID)
+	b = appendTimestamp(b, 4, p.StartDate)
+	b = appendTimestamp(b, 5, p.EndDate)
+	return b, nil
+}
+
+// UnmarshalWire implements worker.WireMarshaler. Unknown fields are skipped so
+// payloads from newer producers still decode.
+func (p *ReportPayload) UnmarshalWire(data []byte) error {
+	*p = ReportPayload{}
+	return consumeFields(data, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		var err error
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			p.ReportID = string(value)
+		case num == 2 && typ == protowire.BytesType:
+			p.ReportType = string(value)
+		case num == 3 && typ == protowire.BytesType:
+			p.UserID = string(value)
+		case num == 4 && typ == protowire.BytesType:
+			p.StartDate, err = consumeTimestamp(value)
+		case num == 5 && typ == protowire.BytesType:
+			p.EndDate, err = consumeTimestamp(value)
+		}
+		return err
+	})
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+// appendTimestamp encodes t as a google.protobuf.Timestamp
+func appendTimestamp(b []byte, num protowire.Number, t time.Time) []byte {
+	if t.IsZero() {
+		return b
+	}
+	var ts []byte
+	if secs := t.Unix(); secs != 0 {
+		ts = protowire.AppendTag(ts, 1, protowire.VarintType)
+		ts = protowire.AppendVarint(ts, uint64(secs))
+	}
+	if nanos := t.Nanosecond(); nanos != 0 {
+		ts = protowire.AppendTag(ts, 2, protowire.VarintType)
+		ts = protowire.AppendVarint(ts, uint64(nanos))
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, ts)
+}
+
+func consumeTimestamp(data []byte) (time.Time, error) {
+	var secs, nanos int64
+	err := consumeFields(data, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if typ != protowire.VarintType {
+			return nil
+		}
+		v, n := protowire.ConsumeVarint(value)
+		if n < 0 {
+			return errInvalidWire
+		}
+		switch num {
+		case 1:
+			secs = int64(v)
+		case 2:
+			nanos = int64(v)
+		}
+		return nil
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(secs, nanos).UTC(), nil
+}
+
+// consumeFields walks the fields of a message, passing length-delimited
+// values without their length prefix and varints still encoded
+func consumeFields(data []byte, fn func(num protowire.Number, typ protowire.Type, value []byte) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return errInvalidWire
+		}
+		data = data[n:]
+
+		var value []byte
+		if typ == protowire.BytesType {
+			v, m := protowire.ConsumeBytes(data)
+			if m < 0 {
+				return errInvalidWire
+			}
+			value, n = v, m
+		} else {
+			n = protowire.ConsumeFieldValue(num, typ, data)
+			if n < 0 {
+				return errInvalidWire
+			}
+			value = data[:n]
+		}
+
+		if err := fn(num, typ, value); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
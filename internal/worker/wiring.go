@@ -0,0 +1,74 @@
+package worker
+
+import (
+	"log/slog"
+	"slices"
+
+	"github.com/hibiken/asynq"
+	"github.com/pixperk/goiler/internal/config"
+	"github.com/pixperk/goiler/pkg/redisconn"
+	"github.com/pixperk/goiler/pkg/worker"
+	"github.com/redis/go-redis/v9"
+)
+
+// QueuePriorities maps each queue to its processing weight
+var QueuePriorities = map[string]int{
+	"critical": 6,
+	"default":  3,
+	"low":      1,
+}
+
+// Queues returns the names of the queues in QueuePriorities
+func Queues() []string {
+	queues := make([]string, 0, len(QueuePriorities))
+	for queue := range QueuePriorities {
+		queues = append(queues, queue)
+	}
+	slices.Sort(queues)
+	return queues
+}
+
+// ScalingMonitor samples the app's queues; see pkg/worker
+type ScalingMonitor = worker.ScalingMonitor
+
+// NewScalingMonitor creates a scaling monitor for the app's queues,
+// sampling them every cfg.Worker.ScalingInterval
+func NewScalingMonitor(cfg *config.Config, logger *slog.Logger) *ScalingMonitor {
+	inspector := asynq.NewInspector(redisconn.ConnOpt(cfg.Redis))
+	return worker.NewScalingMonitor(inspector, Queues(), cfg.Worker.ScalingInterval, logger)
+}
+
+// ScheduleList lists the schedules of running workers; see pkg/worker
+type ScheduleList = worker.ScheduleList
+
+// NewScheduleList creates a schedule list on its own Redis connection
+func NewScheduleList(cfg *config.Config) *ScheduleList {
+	return worker.NewScheduleList(asynq.NewInspector(redisconn.ConnOpt(cfg.Redis)))
+}
+
+// DeadLetterQueue is a dead-letter queue manager that owns its Redis
+// connection
+type DeadLetterQueue struct {
+	*worker.DeadLetterQueue
+	redis redis.UniversalClient
+}
+
+// NewDeadLetterQueue creates a dead-letter queue manager on its own Redis
+// connection
+func NewDeadLetterQueue(cfg *config.Config) *DeadLetterQueue {
+	rdb := redisconn.NewClient(cfg.Redis)
+	return &DeadLetterQueue{
+		DeadLetterQueue: worker.NewDeadLetterQueue(rdb),
+		redis:           rdb,
+	}
+}
+
+// Close closes the Redis connection
+func (d *DeadLetterQueue) Close() error {
+	return d.redis.Close()
+}
+
+// SetPayloadCodec selects the codec used for new task payloads by name
+func SetPayloadCodec(name string) error {
+	return worker.SetPayloadCodec(name)
+}
@@ -0,0 +1,85 @@
+// Package authctx is the single place the authenticated user is stored on a
+// request. Auth middleware calls Set; handlers, middleware and services read
+// the user back with the typed getters instead of ad-hoc context keys.
+package authctx
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// echoKey is the echo context key the user is stored under
+const echoKey = "authctx.user"
+
+type contextKey struct{}
+
+// User is the authenticated caller
+type User struct {
+	ID    uuid.UUID
+	Email string
+	Role  string
+	// TenantID is the tenant the user belongs to
+	TenantID uuid.UUID
+	// OrgID is the organization the user is acting in, if they chose one
+	OrgID *uuid.UUID
+	// ImpersonatedBy is the admin acting as the user, if any
+	ImpersonatedBy *uuid.UUID
+	// Payload is the verified credential the user authenticated with, such
+	// as *auth.TokenPayload
+	Payload any
+}
+
+// Set stores user in the echo context, and in the request context so
+// services called with c.Request().Context() see it too
+func Set(c echo.Context, user *User) {
+	c.Set(echoKey, user)
+	c.SetRequest(c.Request().WithContext(WithUser(c.Request().Context(), user)))
+}
+
+// Get returns the authenticated user, if any
+func Get(c echo.Context) (*User, bool) {
+	if user, ok := c.Get(echoKey).(*User); ok && user != nil {
+		return user, true
+	}
+	return FromContext(c.Request().Context())
+}
+
+// UserID returns the authenticated user's ID
+func UserID(c echo.Context) (uuid.UUID, bool) {
+	user, ok := Get(c)
+	if !ok {
+		return uuid.Nil, false
+	}
+	return user.ID, true
+}
+
+// Role returns the authenticated user's role
+func Role(c echo.Context) (string, bool) {
+	user, ok := Get(c)
+	if !ok {
+		return "", false
+	}
+	return user.Role, true
+}
+
+// Payload returns the credential the user authenticated with, or nil
+func Payload(c echo.Context) any {
+	user, ok := Get(c)
+	if !ok {
+		return nil
+	}
+	return user.Payload
+}
+
+// WithUser returns a context carrying user
+func WithUser(ctx context.Context, user *User) context.Context {
+	return context.WithValue(ctx, contextKey{}, user)
+}
+
+// FromContext returns the user stored in ctx, if any
+func FromContext(ctx context.Context) (*User, bool) {
+	user, ok := ctx.Value(contextKey{}).(*User)
+	return user, ok && user != nil
+}
@@ -0,0 +1,65 @@
+package authctx
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+func TestSetAndGet(t *testing.T) {
+	e := echo.New()
+	c := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+
+	if _, ok := Get(c); ok {
+		t.Fatal("Expected no user before Set")
+	}
+	if _, ok := Role(c); ok {
+		t.Error("Expected no role before Set")
+	}
+	if Payload(c) != nil {
+		t.Error("Expected no payload before Set")
+	}
+
+	type token struct{ id string }
+	user := &User{ID: uuid.New(), Email: "ctx@example.com", Role: "admin", Payload: &token{id: "t1"}}
+	Set(c, user)
+
+	if id, ok := UserID(c); !ok || id != user.ID {
+		t.Errorf("Expected user ID %s, got %s", user.ID, id)
+	}
+	if role, ok := Role(c); !ok || role != "admin" {
+		t.Errorf("Expected role admin, got %q", role)
+	}
+	if payload, ok := Payload(c).(*token); !ok || payload.id != "t1" {
+		t.Errorf("Expected the stored payload, got %v", Payload(c))
+	}
+
+	fromRequest, ok := FromContext(c.Request().Context())
+	if !ok || fromRequest != user {
+		t.Error("Expected Set to store the user in the request context")
+	}
+}
+
+func TestGetFallsBackToRequestContext(t *testing.T) {
+	user := &User{ID: uuid.New(), Role: "user"}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req = req.WithContext(WithUser(req.Context(), user))
+	c := echo.New().NewContext(req, httptest.NewRecorder())
+
+	if got, ok := Get(c); !ok || got != user {
+		t.Errorf("Expected the user from the request context, got %v", got)
+	}
+}
+
+func TestFromContext_Nil(t *testing.T) {
+	if _, ok := FromContext(context.Background()); ok {
+		t.Error("Expected no user in an empty context")
+	}
+	if _, ok := FromContext(WithUser(context.Background(), nil)); ok {
+		t.Error("Expected a nil user to count as unauthenticated")
+	}
+}
@@ -0,0 +1,135 @@
+package budget
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+// ErrExhausted is returned when the remaining request time is below a budget's minimum
+var ErrExhausted = errors.New("deadline budget exhausted")
+
+// Budget derives timeouts for downstream calls from the request deadline.
+// Each call may consume at most Fraction of the time that is left, so
+// successive calls get shrinking timeouts and a slow dependency can't eat
+// the whole request timeout.
+type Budget struct {
+	// Fraction of the remaining time a single call may use (0 < Fraction <= 1)
+	Fraction float64
+	// Min is the smallest timeout worth attempting a call with
+	Min time.Duration
+	// Max caps the timeout, and is used as-is when the context has no deadline
+	Max time.Duration
+}
+
+// Default budgets for common downstream dependencies
+var (
+	DB = Budget{
+		Fraction: 0.5,
+		Min:      50 * time.Millisecond,
+		Max:      5 * time.Second,
+	}
+	Redis = Budget{
+		Fraction: 0.25,
+		Min:      10 * time.Millisecond,
+		Max:      time.Second,
+	}
+	HTTP = Budget{
+		Fraction: 0.75,
+		Min:      100 * time.Millisecond,
+		Max:      10 * time.Second,
+	}
+)
+
+// Remaining returns the time left until the context deadline
+func Remaining(ctx context.Context) (time.Duration, bool) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0, false
+	}
+	return time.Until(deadline), true
+}
+
+// Timeout returns the timeout a call should use given the context deadline
+func (b Budget) Timeout(ctx context.Context) (time.Duration, error) {
+	remaining, ok := Remaining(ctx)
+	if !ok {
+		return b.Max, nil
+	}
+
+	if remaining < b.Min || remaining <= 0 {
+		return 0, ErrExhausted
+	}
+
+	timeout := time.Duration(float64(remaining) * b.Fraction)
+	if timeout < b.Min {
+		timeout = b.Min
+	}
+	if b.Max > 0 && timeout > b.Max {
+		timeout = b.Max
+	}
+
+	return timeout, nil
+}
+
+// WithTimeout returns a child context bounded by the budget
+func (b Budget) WithTimeout(ctx context.Context) (context.Context, context.CancelFunc, error) {
+	timeout, err := b.Timeout(ctx)
+	if err != nil {
+		return ctx, func() {}, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, cancel, nil
+}
+
+// Transport wraps an http.RoundTripper so every outbound request is bounded by the budget
+func (b Budget) Transport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &transport{budget: b, next: next}
+}
+
+// Client returns an http.Client whose requests are bounded by the budget
+func (b Budget) Client() *http.Client {
+	return &http.Client{Transport: b.Transport(nil)}
+}
+
+// transport applies a Budget to outbound HTTP requests
+type transport struct {
+	budget Budget
+	next   http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel, err := t.budget.WithTimeout(req.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	// Keep the context alive until the body has been read
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelOnClose releases the budget context when the response body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
@@ -0,0 +1,60 @@
+package budget
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBudget_NoDeadline(t *testing.T) {
+	b := Budget{Fraction: 0.5, Min: 10 * time.Millisecond, Max: time.Second}
+
+	timeout, err := b.Timeout(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if timeout != time.Second {
+		t.Errorf("Timeout mismatch: got %v, want %v", timeout, time.Second)
+	}
+}
+
+func TestBudget_Shrinks(t *testing.T) {
+	b := Budget{Fraction: 0.5, Min: time.Millisecond, Max: time.Hour}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	timeout, err := b.Timeout(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if timeout > 5*time.Second || timeout < 4*time.Second {
+		t.Errorf("Expected roughly half the remaining time, got %v", timeout)
+	}
+}
+
+func TestBudget_Exhausted(t *testing.T) {
+	b := Budget{Fraction: 0.5, Min: time.Second, Max: time.Hour}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if _, err := b.Timeout(ctx); err != ErrExhausted {
+		t.Errorf("Expected ErrExhausted, got: %v", err)
+	}
+}
+
+func TestBudget_Min(t *testing.T) {
+	b := Budget{Fraction: 0.1, Min: 500 * time.Millisecond, Max: time.Hour}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	timeout, err := b.Timeout(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if timeout != 500*time.Millisecond {
+		t.Errorf("Timeout mismatch: got %v, want %v", timeout, 500*time.Millisecond)
+	}
+}
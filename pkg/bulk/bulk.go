@@ -0,0 +1,132 @@
+package bulk
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/pkg/response"
+)
+
+// DefaultConcurrency is used when no concurrency limit is given
+const DefaultConcurrency = 8
+
+// Result holds the outcome of a single operation in a bulk request
+type Result struct {
+	Index  int                 `json:"index"`
+	Status int                 `json:"status"`
+	Data   interface{}         `json:"data,omitempty"`
+	Error  *response.ErrorInfo `json:"error,omitempty"`
+}
+
+// OK reports whether the operation succeeded
+func (r Result) OK() bool {
+	return r.Status < http.StatusBadRequest
+}
+
+// ErrorMapper maps an operation error to an HTTP status and error info
+type ErrorMapper func(err error) (int, *response.ErrorInfo)
+
+// DefaultErrorMapper reports every error as an internal error
+func DefaultErrorMapper(err error) (int, *response.ErrorInfo) {
+	return http.StatusInternalServerError, &response.ErrorInfo{
+		Code:    "INTERNAL_ERROR",
+		Message: err.Error(),
+	}
+}
+
+// Options configures bulk execution
+type Options struct {
+	// Concurrency bounds the number of operations running at once
+	Concurrency int
+	// MapError converts operation errors into per-item statuses
+	MapError ErrorMapper
+}
+
+// Execute runs fn for every item with bounded concurrency and returns one
+// result per item, in input order. A failing item does not stop the others.
+func Execute[T any](ctx context.Context, items []T, opts Options, fn func(ctx context.Context, item T) (interface{}, error)) []Result {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultConcurrency
+	}
+	if opts.MapError == nil {
+		opts.MapError = DefaultErrorMapper
+	}
+
+	results := make([]Result, len(items))
+	sem := make(chan struct{}, opts.Concurrency)
+	var wg sync.WaitGroup
+
+	for i, item := range items {
+		if ctx.Err() != nil {
+			results[i] = Result{
+				Index:  i,
+				Status: http.StatusServiceUnavailable,
+				Error: &response.ErrorInfo{
+					Code:    "CANCELLED",
+					Message: "Request was cancelled before this operation ran",
+				},
+			}
+			continue
+		}
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, item T) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			data, err := fn(ctx, item)
+			if err != nil {
+				status, info := opts.MapError(err)
+				results[i] = Result{Index: i, Status: status, Error: info}
+				return
+			}
+			results[i] = Result{Index: i, Status: http.StatusOK, Data: data}
+		}(i, item)
+	}
+
+	wg.Wait()
+	return results
+}
+
+// Summary counts succeeded and failed operations
+type Summary struct {
+	Total     int `json:"total"`
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+}
+
+// Summarize counts the outcomes of a bulk request
+func Summarize(results []Result) Summary {
+	s := Summary{Total: len(results)}
+	for _, r := range results {
+		if r.OK() {
+			s.Succeeded++
+		} else {
+			s.Failed++
+		}
+	}
+	return s
+}
+
+// Response is the data returned for a bulk request
+type Response struct {
+	Summary Summary  `json:"summary"`
+	Results []Result `json:"results"`
+}
+
+// Respond writes results as a 207 multi-status response
+func Respond(c echo.Context, results []Result) error {
+	summary := Summarize(results)
+	message := fmt.Sprintf("%d of %d operations succeeded", summary.Succeeded, summary.Total)
+
+	return response.MultiStatus(c, summary.Failed == 0, message, Response{
+		Summary: summary,
+		Results: results,
+	})
+}
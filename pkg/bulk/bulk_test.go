@@ -0,0 +1,64 @@
+package bulk
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestExecute_PartialResults(t *testing.T) {
+	items := []int{1, 2, 3, 4}
+
+	results := Execute(context.Background(), items, Options{}, func(ctx context.Context, n int) (interface{}, error) {
+		if n%2 == 0 {
+			return nil, errors.New("even")
+		}
+		return n, nil
+	})
+
+	if len(results) != len(items) {
+		t.Fatalf("Expected %d results, got %d", len(items), len(results))
+	}
+	for i, r := range results {
+		if r.Index != i {
+			t.Errorf("Result %d has index %d", i, r.Index)
+		}
+		wantOK := items[i]%2 != 0
+		if r.OK() != wantOK {
+			t.Errorf("Result %d: OK = %v, want %v", i, r.OK(), wantOK)
+		}
+		if !wantOK && r.Status != http.StatusInternalServerError {
+			t.Errorf("Result %d: status = %d, want %d", i, r.Status, http.StatusInternalServerError)
+		}
+	}
+
+	summary := Summarize(results)
+	if summary.Succeeded != 2 || summary.Failed != 2 {
+		t.Errorf("Summary mismatch: %+v", summary)
+	}
+}
+
+func TestExecute_BoundedConcurrency(t *testing.T) {
+	items := make([]int, 20)
+	var running, peak int32
+
+	Execute(context.Background(), items, Options{Concurrency: 3}, func(ctx context.Context, _ int) (interface{}, error) {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return nil, nil
+	})
+
+	if peak > 3 {
+		t.Errorf("Expected at most 3 concurrent operations, got %d", peak)
+	}
+}
@@ -0,0 +1,82 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time. Services take a Clock instead of calling time.Now
+// so tests can control expiry.
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	Until(t time.Time) time.Duration
+}
+
+// Real is the system clock
+type Real struct{}
+
+// New returns the system clock
+func New() Clock {
+	return Real{}
+}
+
+// Now returns the current time
+func (Real) Now() time.Time { return time.Now() }
+
+// Since returns the time elapsed since t
+func (Real) Since(t time.Time) time.Duration { return time.Since(t) }
+
+// Until returns the duration until t
+func (Real) Until(t time.Time) time.Duration { return time.Until(t) }
+
+// Frozen is a clock that only moves when told to. It is safe for
+// concurrent use.
+type Frozen struct {
+	mu  sync.RWMutex
+	now time.Time
+}
+
+// NewFrozen returns a clock stopped at now
+func NewFrozen(now time.Time) *Frozen {
+	return &Frozen{now: now}
+}
+
+// Now returns the frozen time
+func (f *Frozen) Now() time.Time {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.now
+}
+
+// Since returns the frozen time elapsed since t
+func (f *Frozen) Since(t time.Time) time.Duration {
+	return f.Now().Sub(t)
+}
+
+// Until returns the frozen duration until t
+func (f *Frozen) Until(t time.Time) time.Duration {
+	return t.Sub(f.Now())
+}
+
+// Set moves the clock to now
+func (f *Frozen) Set(now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = now
+}
+
+// Advance moves the clock forward by d
+func (f *Frozen) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+}
+
+// OrReal returns c, or the system clock if c is nil
+func OrReal(c Clock) Clock {
+	if c == nil {
+		return New()
+	}
+	return c
+}
@@ -0,0 +1,38 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFrozen(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := NewFrozen(start)
+
+	if !c.Now().Equal(start) {
+		t.Fatalf("Expected %v, got %v", start, c.Now())
+	}
+
+	c.Advance(90 * time.Second)
+	if got := c.Since(start); got != 90*time.Second {
+		t.Errorf("Expected 90s since start, got %v", got)
+	}
+	if got := c.Until(start.Add(2 * time.Minute)); got != 30*time.Second {
+		t.Errorf("Expected 30s until deadline, got %v", got)
+	}
+
+	c.Set(start)
+	if !c.Now().Equal(start) {
+		t.Errorf("Expected clock reset to %v, got %v", start, c.Now())
+	}
+}
+
+func TestOrReal(t *testing.T) {
+	if _, ok := OrReal(nil).(Real); !ok {
+		t.Error("Expected the real clock for nil")
+	}
+	frozen := NewFrozen(time.Now())
+	if OrReal(frozen) != Clock(frozen) {
+		t.Error("Expected the given clock to be kept")
+	}
+}
@@ -0,0 +1,105 @@
+package dbconn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pixperk/goiler/internal/config"
+)
+
+var ErrInvalidConfig = errors.New("invalid database config")
+
+// Validate checks the pool settings. Call it at startup; NewPool assumes a
+// valid config.
+func Validate(cfg config.DatabaseConfig) error {
+	if cfg.MaxConns < 0 || cfg.MinConns < 0 {
+		return fmt.Errorf("%w: DB_MAX_CONNS and DB_MIN_CONNS must not be negative", ErrInvalidConfig)
+	}
+	if cfg.StatementTimeout < 0 {
+		return fmt.Errorf("%w: the statement timeout must not be negative", ErrInvalidConfig)
+	}
+
+	poolConfig, err := PoolConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+	// Compared after parsing so the URL's pool_max_conns and the library
+	// default count too
+	if poolConfig.MinConns > poolConfig.MaxConns {
+		return fmt.Errorf("%w: min conns (%d) exceeds max conns (%d)", ErrInvalidConfig, poolConfig.MinConns, poolConfig.MaxConns)
+	}
+	return nil
+}
+
+// PoolConfig parses DATABASE_URL and applies the pool and session settings.
+// Parameters already set in the URL, such as pool_max_conns or
+// statement_timeout, take precedence over the settings.
+func PoolConfig(cfg config.DatabaseConfig) (*pgxpool.Config, error) {
+	poolConfig, err := pgxpool.ParseConfig(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	// pgxpool removes the pool parameters it reads, so they're looked up
+	// in the URL parsed as a plain connection string
+	connConfig, err := pgconn.ParseConfig(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	inURL := func(param string) bool {
+		_, ok := connConfig.RuntimeParams[param]
+		return ok
+	}
+
+	if cfg.MaxConns > 0 && !inURL("pool_max_conns") {
+		poolConfig.MaxConns = int32(cfg.MaxConns)
+	}
+	if cfg.MinConns > 0 && !inURL("pool_min_conns") {
+		poolConfig.MinConns = int32(cfg.MinConns)
+	}
+	if cfg.MaxConnLifetime > 0 && !inURL("pool_max_conn_lifetime") {
+		poolConfig.MaxConnLifetime = cfg.MaxConnLifetime
+	}
+	if cfg.MaxConnIdleTime > 0 && !inURL("pool_max_conn_idle_time") {
+		poolConfig.MaxConnIdleTime = cfg.MaxConnIdleTime
+	}
+	if cfg.HealthCheckPeriod > 0 && !inURL("pool_health_check_period") {
+		poolConfig.HealthCheckPeriod = cfg.HealthCheckPeriod
+	}
+
+	params := poolConfig.ConnConfig.RuntimeParams
+	if _, ok := params["statement_timeout"]; !ok && cfg.StatementTimeout > 0 {
+		params["statement_timeout"] = strconv.FormatInt(cfg.StatementTimeout.Milliseconds(), 10)
+	}
+	if _, ok := params["application_name"]; !ok && cfg.ApplicationName != "" {
+		params["application_name"] = cfg.ApplicationName
+	}
+
+	return poolConfig, nil
+}
+
+// PoolOption customizes the pool config before the pool is created
+type PoolOption func(*pgxpool.Config)
+
+// WithTracer traces every query run on the pool
+func WithTracer(tracer pgx.QueryTracer) PoolOption {
+	return func(poolConfig *pgxpool.Config) {
+		poolConfig.ConnConfig.Tracer = tracer
+	}
+}
+
+// NewPool creates a connection pool from the config
+func NewPool(ctx context.Context, cfg config.DatabaseConfig, opts ...PoolOption) (*pgxpool.Pool, error) {
+	poolConfig, err := PoolConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	for _, opt := range opts {
+		opt(poolConfig)
+	}
+	return pgxpool.NewWithConfig(ctx, poolConfig)
+}
@@ -0,0 +1,123 @@
+package events
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pixperk/goiler/pkg/watchdog"
+)
+
+// UserCreatedEvent is published when an account is registered, including
+// guest accounts
+type UserCreatedEvent struct {
+	UserID    uuid.UUID `json:"user_id"`
+	TenantID  uuid.UUID `json:"tenant_id"`
+	Guest     bool      `json:"guest,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// UserUpdatedEvent is published when a user changes their profile
+type UserUpdatedEvent struct {
+	UserID   uuid.UUID `json:"user_id"`
+	TenantID uuid.UUID `json:"tenant_id"`
+	// Fields are the JSON names of the changed profile fields
+	Fields    []string  `json:"fields"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// UserDeletedEvent is published when an account is deleted
+type UserDeletedEvent struct {
+	UserID    uuid.UUID `json:"user_id"`
+	TenantID  uuid.UUID `json:"tenant_id"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// AuthEvent is a security-relevant action on an account, as recorded in
+// the audit trail. UserID is unset for failed logins to unknown emails.
+type AuthEvent struct {
+	ID         uuid.UUID      `json:"id"`
+	Type       string         `json:"type"`
+	UserID     *uuid.UUID     `json:"user_id,omitempty"`
+	IPAddress  string         `json:"ip_address,omitempty"`
+	UserAgent  string         `json:"user_agent,omitempty"`
+	Details    map[string]any `json:"details,omitempty"`
+	OccurredAt time.Time      `json:"occurred_at"`
+}
+
+// RoomEvent is published when a WebSocket room is created or emptied and
+// when clients join or leave it
+type RoomEvent struct {
+	Room     string    `json:"room"`
+	ClientID string    `json:"client_id,omitempty"`
+	UserID   string    `json:"user_id,omitempty"`
+	Members  int       `json:"members"`
+	Time     time.Time `json:"time"`
+}
+
+// Task statuses of a TaskProgressEvent
+const (
+	TaskRunning   = "running"
+	TaskCompleted = "completed"
+	TaskFailed    = "failed"
+)
+
+// TaskProgressEvent is published by a background task as it advances and
+// when it finishes, for the user waiting on it
+type TaskProgressEvent struct {
+	TaskID   string `json:"task_id"`
+	TaskType string `json:"task_type"`
+	UserID   string `json:"user_id"`
+	// Status is running, completed or failed
+	Status string `json:"status"`
+	// Progress is how far the task has got, from 0 to 1
+	Progress float64 `json:"progress"`
+	Message  string  `json:"message,omitempty"`
+	// Result describes what a completed task produced, such as a download
+	// URL
+	Result map[string]any `json:"result,omitempty"`
+	Error  string         `json:"error,omitempty"`
+	Time   time.Time      `json:"time"`
+}
+
+// User topics
+var (
+	UserCreated = define[UserCreatedEvent]("user.created", "An account was registered")
+	UserUpdated = define[UserUpdatedEvent]("user.updated", "A user changed their profile")
+	UserDeleted = define[UserDeletedEvent]("user.deleted", "An account was deleted")
+)
+
+// AuditTopicPrefix prefixes the type of an audit event to form its topic
+const AuditTopicPrefix = "audit."
+
+// Audit topics, one per auth audit event type
+var (
+	AuthLoginSucceeded   = define[AuthEvent](AuditTopicPrefix+"auth.login_succeeded", "A user logged in")
+	AuthLoginFailed      = define[AuthEvent](AuditTopicPrefix+"auth.login_failed", "A login was rejected; details carry the reason")
+	AuthLogout           = define[AuthEvent](AuditTopicPrefix+"auth.logout", "A user logged out")
+	AuthPasswordChanged  = define[AuthEvent](AuditTopicPrefix+"auth.password_changed", "A user changed or set their password")
+	AuthTokenRevoked     = define[AuthEvent](AuditTopicPrefix+"auth.token_revoked", "Refresh tokens or sessions were revoked")
+	AuthPasskeyAdded     = define[AuthEvent](AuditTopicPrefix+"auth.passkey_added", "A user registered a passkey")
+	AuthGuestUpgraded    = define[AuthEvent](AuditTopicPrefix+"auth.guest_upgraded", "A guest account got an email and password")
+	AuthIncidentDeclared = define[AuthEvent](AuditTopicPrefix+"auth.incident_declared", "An admin declared a security incident, signing out everyone or a role")
+)
+
+// AuditTopic returns the topic of an audit event type. Types missing from
+// the catalog get an uncataloged topic named the same way.
+func AuditTopic(eventType string) Topic[AuthEvent] {
+	return Topic[AuthEvent]{name: AuditTopicPrefix + eventType}
+}
+
+// WebSocket room topics
+var (
+	RoomCreated      = define[RoomEvent]("ws.room.created", "A client joined a room that had no members")
+	RoomEmptied      = define[RoomEvent]("ws.room.emptied", "The last member left a room")
+	RoomMemberJoined = define[RoomEvent]("ws.room.member_joined", "A client joined a room")
+	RoomMemberLeft   = define[RoomEvent]("ws.room.member_left", "A client left a room")
+)
+
+// WatchdogAlert is published when the leak watchdog raises an alert
+var WatchdogAlert = define[watchdog.Alert](watchdog.TopicAlert, "The leak watchdog found too many goroutines or a queue backing up")
+
+// TaskProgress is published by task handlers, and relayed to the user's
+// WebSocket connections
+var TaskProgress = define[TaskProgressEvent]("task.progress", "A background task advanced or finished, for the user waiting on it")
@@ -0,0 +1,131 @@
+// Package events is the catalog of domain events: every topic published on
+// the in-process pubsub, with its payload type. Topics are typed, so
+// publishers can't send the wrong payload and subscribers get it back
+// without guessing, and each carries a JSON Schema of its payload for
+// consumers outside the process.
+//
+//	events.UserCreated.Publish(bus, events.UserCreatedPayload{...})
+//
+//	sub := bus.Subscribe(ctx, "welcome", events.UserCreated.Name())
+//	for e := range sub.Channel {
+//		created, ok := events.UserCreated.Payload(e.Payload)
+//		...
+//	}
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Publisher publishes payloads to topics. *pubsub.PubSub satisfies it.
+type Publisher interface {
+	Publish(topic string, payload interface{}) int
+}
+
+// Topic is a topic whose payloads are Ts
+type Topic[T any] struct {
+	name string
+}
+
+// Name is the topic's name, as used with pubsub
+func (t Topic[T]) Name() string {
+	return t.name
+}
+
+// String implements fmt.Stringer
+func (t Topic[T]) String() string {
+	return t.name
+}
+
+// Publish publishes payload to the topic and returns how many subscribers
+// received it. A nil publisher publishes nothing.
+func (t Topic[T]) Publish(p Publisher, payload T) int {
+	if p == nil {
+		return 0
+	}
+	return p.Publish(t.name, payload)
+}
+
+// Payload returns a published payload as a T. Pointers to a T are
+// accepted too.
+func (t Topic[T]) Payload(payload interface{}) (T, bool) {
+	switch v := payload.(type) {
+	case T:
+		return v, true
+	case *T:
+		if v != nil {
+			return *v, true
+		}
+	}
+	var zero T
+	return zero, false
+}
+
+// Decode decodes a JSON payload of the topic, such as one relayed from
+// another instance
+func (t Topic[T]) Decode(data []byte) (T, error) {
+	var payload T
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return payload, fmt.Errorf("decode %s payload: %w", t.name, err)
+	}
+	return payload, nil
+}
+
+// Definition describes a cataloged topic
+type Definition struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Schema      *Schema `json:"schema"`
+
+	decode func(data []byte) (any, error)
+}
+
+// catalog holds every defined topic by name
+var catalog = map[string]*Definition{}
+
+// define adds a topic to the catalog. Names must be unique.
+func define[T any](name, description string) Topic[T] {
+	if _, ok := catalog[name]; ok {
+		panic("events: topic " + name + " defined twice")
+	}
+	t := Topic[T]{name: name}
+	catalog[name] = &Definition{
+		Name:        name,
+		Description: description,
+		Schema:      SchemaOf[T](),
+		decode: func(data []byte) (any, error) {
+			return t.Decode(data)
+		},
+	}
+	return t
+}
+
+// Catalog returns the definitions of all topics, sorted by name
+func Catalog() []Definition {
+	defs := make([]Definition, 0, len(catalog))
+	for _, def := range catalog {
+		defs = append(defs, *def)
+	}
+	sort.Slice(defs, func(i, j int) bool { return defs[i].Name < defs[j].Name })
+	return defs
+}
+
+// Lookup returns the definition of the named topic
+func Lookup(name string) (Definition, bool) {
+	def, ok := catalog[name]
+	if !ok {
+		return Definition{}, false
+	}
+	return *def, true
+}
+
+// Decode decodes a JSON payload of the named topic into its payload type
+func Decode(name string, data []byte) (any, error) {
+	def, ok := catalog[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown event topic %q", name)
+	}
+	return def.decode(data)
+}
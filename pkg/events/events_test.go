@@ -0,0 +1,108 @@
+package events
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// recorder is a Publisher keeping what was published
+type recorder struct {
+	topics   []string
+	payloads []interface{}
+}
+
+func (r *recorder) Publish(topic string, payload interface{}) int {
+	r.topics = append(r.topics, topic)
+	r.payloads = append(r.payloads, payload)
+	return 1
+}
+
+func TestTopic_PublishAndPayload(t *testing.T) {
+	rec := &recorder{}
+	event := UserCreatedEvent{UserID: uuid.New(), CreatedAt: time.Unix(1_700_000_000, 0).UTC()}
+
+	if n := UserCreated.Publish(rec, event); n != 1 {
+		t.Errorf("Expected 1 delivery, got %d", n)
+	}
+	if rec.topics[0] != "user.created" {
+		t.Errorf("Topic mismatch: got %q", rec.topics[0])
+	}
+
+	got, ok := UserCreated.Payload(rec.payloads[0])
+	if !ok || got.UserID != event.UserID {
+		t.Errorf("Payload mismatch: got %+v, %v", got, ok)
+	}
+	if got, ok := UserCreated.Payload(&event); !ok || got.UserID != event.UserID {
+		t.Errorf("Pointer payloads should be accepted, got %+v, %v", got, ok)
+	}
+	if _, ok := UserDeleted.Payload(rec.payloads[0]); ok {
+		t.Error("A payload of another topic should not be accepted")
+	}
+
+	if n := UserCreated.Publish(nil, event); n != 0 {
+		t.Errorf("A nil publisher should publish nothing, got %d", n)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	id := uuid.New()
+	payload, err := Decode("user.deleted", []byte(`{"user_id":"`+id.String()+`","deleted_at":"2026-01-02T03:04:05Z"}`))
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	deleted, ok := payload.(UserDeletedEvent)
+	if !ok || deleted.UserID != id {
+		t.Errorf("Payload mismatch: got %#v", payload)
+	}
+
+	if _, err := Decode("user.nope", []byte(`{}`)); err == nil {
+		t.Error("Expected an error for an unknown topic")
+	}
+	if _, err := Decode("user.deleted", []byte(`[`)); err == nil {
+		t.Error("Expected an error for invalid JSON")
+	}
+}
+
+func TestCatalog(t *testing.T) {
+	defs := Catalog()
+	names := make([]string, len(defs))
+	for i, def := range defs {
+		names[i] = def.Name
+		if def.Description == "" || def.Schema == nil {
+			t.Errorf("%s is missing a description or schema", def.Name)
+		}
+	}
+	if !slices.IsSorted(names) {
+		t.Errorf("Catalog should be sorted, got %v", names)
+	}
+	for _, name := range []string{"user.created", "audit.auth.login_failed", "ws.room.created", "watchdog.alert"} {
+		if _, ok := Lookup(name); !ok {
+			t.Errorf("Expected %s in the catalog", name)
+		}
+	}
+	if AuditTopic("auth.login_failed").Name() != AuthLoginFailed.Name() {
+		t.Error("AuditTopic should name the cataloged topic")
+	}
+}
+
+func TestSchemaOf(t *testing.T) {
+	s := SchemaOf[AuthEvent]()
+	if s.Type != "object" {
+		t.Fatalf("Type mismatch: got %q", s.Type)
+	}
+	if !slices.Equal(s.Required, []string{"id", "type", "occurred_at"}) {
+		t.Errorf("Required mismatch: got %v", s.Required)
+	}
+	if p := s.Properties["user_id"]; p == nil || p.Format != "uuid" {
+		t.Errorf("user_id should be a uuid, got %+v", p)
+	}
+	if p := s.Properties["occurred_at"]; p == nil || p.Format != "date-time" {
+		t.Errorf("occurred_at should be a date-time, got %+v", p)
+	}
+	if p := SchemaOf[UserUpdatedEvent]().Properties["fields"]; p == nil || p.Type != "array" || p.Items.Type != "string" {
+		t.Errorf("fields should be an array of strings, got %+v", p)
+	}
+}
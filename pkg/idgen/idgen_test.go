@@ -0,0 +1,56 @@
+package idgen
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		format  string
+		version int
+	}{
+		{FormatV4, 4},
+		{FormatV7, 7},
+		{"", 7},
+	}
+	for _, tt := range tests {
+		gen, err := New(tt.format)
+		if err != nil {
+			t.Fatalf("New(%q) failed: %v", tt.format, err)
+		}
+		if got := int(gen.NewID().Version()); got != tt.version {
+			t.Errorf("New(%q): expected version %d, got %d", tt.format, tt.version, got)
+		}
+	}
+
+	if _, err := New("v1"); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
+
+func TestULID_Timestamp(t *testing.T) {
+	before := time.Now().UnixMilli()
+	id := ULID.NewID()
+	after := time.Now().UnixMilli()
+
+	var ts [8]byte
+	copy(ts[2:], id[:6])
+	ms := int64(binary.BigEndian.Uint64(ts[:]))
+	if ms < before || ms > after {
+		t.Errorf("Expected timestamp between %d and %d, got %d", before, after, ms)
+	}
+}
+
+func TestTimeOrdered(t *testing.T) {
+	for _, gen := range []Generator{V7, ULID} {
+		prev := gen.NewID()
+		time.Sleep(2 * time.Millisecond)
+		next := gen.NewID()
+		if bytes.Compare(prev[:], next[:]) >= 0 {
+			t.Errorf("Expected %s to sort before %s", prev, next)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+// Package imaging validates uploaded images and scales them down, for
+// avatars and other user images. It uses only the standard library's
+// decoders, so JPEG, PNG and GIF are supported.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif" // registers the GIF decoder
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+// Formats, as reported by image.Decode
+const (
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+	FormatGIF  = "gif"
+)
+
+var (
+	// ErrUnsupportedFormat is returned for data that isn't a JPEG, PNG or
+	// GIF image
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+	// ErrTooLarge is returned for images with more pixels than allowed
+	ErrTooLarge = errors.New("image dimensions too large")
+)
+
+// jpegQuality is the quality JPEGs are re-encoded with
+const jpegQuality = 90
+
+// Decode decodes a JPEG, PNG or GIF image. The dimensions are checked
+// against maxPixels before decoding, so small files that expand to huge
+// images are rejected cheaply. GIFs decode to their first frame.
+func Decode(data []byte, maxPixels int) (image.Image, string, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || (format != FormatJPEG && format != FormatPNG && format != FormatGIF) {
+		return nil, "", ErrUnsupportedFormat
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, "", ErrUnsupportedFormat
+	}
+	if maxPixels > 0 && cfg.Width*cfg.Height > maxPixels {
+		return nil, "", fmt.Errorf("%w: %dx%d", ErrTooLarge, cfg.Width, cfg.Height)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrUnsupportedFormat, err)
+	}
+	return img, format, nil
+}
+
+// Thumbnail crops img to a centered square and scales it down to size by
+// size pixels, averaging the source pixels each one covers. Images smaller
+// than size are only cropped.
+func Thumbnail(img image.Image, size int) *image.RGBA {
+	b := img.Bounds()
+	side := min(b.Dx(), b.Dy())
+	origin := image.Pt(b.Min.X+(b.Dx()-side)/2, b.Min.Y+(b.Dy()-side)/2)
+	square := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(square, square.Bounds(), img, origin, draw.Src)
+	if side <= size {
+		return square
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		y0, y1 := y*side/size, (y+1)*side/size
+		for x := 0; x < size; x++ {
+			x0, x1 := x*side/size, (x+1)*side/size
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := square.Pix[sy*square.Stride+x0*4 : sy*square.Stride+x1*4]
+				for i := 0; i < len(row); i += 4 {
+					sum[0] += int(row[i])
+					sum[1] += int(row[i+1])
+					sum[2] += int(row[i+2])
+					sum[3] += int(row[i+3])
+				}
+			}
+			n := (x1 - x0) * (y1 - y0)
+			i := dst.PixOffset(x, y)
+			for c := range sum {
+				dst.Pix[i+c] = uint8(sum[c] / n)
+			}
+		}
+	}
+	return dst
+}
+
+// Encode writes img as a JPEG if format is FormatJPEG, and as a PNG
+// otherwise, so transparency survives. It returns the content type and file
+// extension written.
+func Encode(w io.Writer, img image.Image, format string) (contentType, ext string, err error) {
+	if format == FormatJPEG {
+		return "image/jpeg", ".jpg", jpeg.Encode(w, img, &jpeg.Options{Quality: jpegQuality})
+	}
+	return "image/png", ".png", png.Encode(w, img)
+}
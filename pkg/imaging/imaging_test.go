@@ -0,0 +1,76 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func encodePNG(t *testing.T, w, h int, fill color.Color) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, fill)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecode(t *testing.T) {
+	img, format, err := Decode(encodePNG(t, 40, 20, color.White), 1000)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if format != FormatPNG || img.Bounds().Dx() != 40 {
+		t.Errorf("Unexpected result: %s %v", format, img.Bounds())
+	}
+
+	if _, _, err := Decode(encodePNG(t, 40, 30, color.White), 1000); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Expected ErrTooLarge, got %v", err)
+	}
+	if _, _, err := Decode([]byte("<svg></svg>"), 0); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("Expected ErrUnsupportedFormat, got %v", err)
+	}
+}
+
+func TestThumbnail(t *testing.T) {
+	img, _, err := Decode(encodePNG(t, 300, 200, color.RGBA{R: 200, A: 255}), 0)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+
+	thumb := Thumbnail(img, 50)
+	if thumb.Bounds() != image.Rect(0, 0, 50, 50) {
+		t.Errorf("Bounds mismatch: got %v", thumb.Bounds())
+	}
+	if got := thumb.RGBAAt(25, 25); got != (color.RGBA{R: 200, A: 255}) {
+		t.Errorf("Color mismatch: got %v", got)
+	}
+
+	small := Thumbnail(img, 500)
+	if small.Bounds() != image.Rect(0, 0, 200, 200) {
+		t.Errorf("Small images should only be cropped, got %v", small.Bounds())
+	}
+}
+
+func TestEncode(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	var buf bytes.Buffer
+	contentType, ext, err := Encode(&buf, img, FormatGIF)
+	if err != nil || contentType != "image/png" || ext != ".png" {
+		t.Errorf("GIFs should be encoded as PNG, got %s %s %v", contentType, ext, err)
+	}
+	buf.Reset()
+	contentType, ext, err = Encode(&buf, img, FormatJPEG)
+	if err != nil || contentType != "image/jpeg" || ext != ".jpg" {
+		t.Errorf("JPEGs should stay JPEG, got %s %s %v", contentType, ext, err)
+	}
+}
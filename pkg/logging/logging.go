@@ -0,0 +1,85 @@
+package logging
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/pixperk/goiler/internal/config"
+)
+
+// LevelOff disables logging for a module
+const LevelOff = slog.Level(100)
+
+// Factory builds the root logger and per-module child loggers
+type Factory struct {
+	cfg  config.LogConfig
+	out  io.Writer
+	root *slog.Logger
+}
+
+// New creates a new logger factory writing to stdout
+func New(cfg config.LogConfig) *Factory {
+	return NewWithWriter(cfg, os.Stdout)
+}
+
+// NewWithWriter creates a new logger factory writing to w
+func NewWithWriter(cfg config.LogConfig, w io.Writer) *Factory {
+	f := &Factory{
+		cfg: cfg,
+		out: w,
+	}
+	f.root = slog.New(f.handler(cfg.Level, cfg.Format))
+	return f
+}
+
+// Logger returns the root logger
+func (f *Factory) Logger() *slog.Logger {
+	return f.root
+}
+
+// For returns a logger for the given module, tagged with a component
+// attribute and using the module's level and format overrides if any
+func (f *Factory) For(module string) *slog.Logger {
+	override, ok := f.cfg.Modules[module]
+	if !ok {
+		return f.root.With(slog.String("component", module))
+	}
+
+	level := override.Level
+	if level == "" {
+		level = f.cfg.Level
+	}
+	format := override.Format
+	if format == "" {
+		format = f.cfg.Format
+	}
+
+	return slog.New(f.handler(level, format)).With(slog.String("component", module))
+}
+
+// handler creates a slog handler for the given level and format
+func (f *Factory) handler(level, format string) slog.Handler {
+	opts := &slog.HandlerOptions{Level: ParseLevel(level)}
+	if strings.EqualFold(format, "text") {
+		return slog.NewTextHandler(f.out, opts)
+	}
+	return slog.NewJSONHandler(f.out, opts)
+}
+
+// ParseLevel converts a level name to a slog.Level, defaulting to info
+func ParseLevel(level string) slog.Level {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	case "off", "none":
+		return LevelOff
+	default:
+		return slog.LevelInfo
+	}
+}
@@ -0,0 +1,326 @@
+package otel
+
+import (
+	"context"
+	"encoding/binary"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pixperk/goiler/internal/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DBTracingOption configures a DBTracingWrapper
+type DBTracingOption func(*DBTracingWrapper)
+
+// WithStatementSampleRate sets the fraction of requests, from 0 to 1, whose
+// statements are recorded. The decision is made per trace, so a sampled
+// request records all of its statements.
+func WithStatementSampleRate(rate float64) DBTracingOption {
+	return func(w *DBTracingWrapper) {
+		w.sampleRate = rate
+	}
+}
+
+// WithSlowQueryThreshold records the statement of any query that takes at
+// least threshold, sampled or not
+func WithSlowQueryThreshold(threshold time.Duration) DBTracingOption {
+	return func(w *DBTracingWrapper) {
+		w.slowThreshold = threshold
+	}
+}
+
+// WithStatementScrubbing replaces string and numeric literals with ? before
+// statements are recorded
+func WithStatementScrubbing(scrub bool) DBTracingOption {
+	return func(w *DBTracingWrapper) {
+		w.scrub = scrub
+	}
+}
+
+// WithMaxStatementLength truncates recorded statements
+func WithMaxStatementLength(n int) DBTracingOption {
+	return func(w *DBTracingWrapper) {
+		w.maxLength = n
+	}
+}
+
+// DBTracingOptions returns the options described by cfg
+func DBTracingOptions(cfg config.DBTracingConfig) []DBTracingOption {
+	return []DBTracingOption{
+		WithStatementSampleRate(cfg.StatementSampleRate),
+		WithSlowQueryThreshold(cfg.SlowQueryThreshold),
+		WithStatementScrubbing(cfg.ScrubStatements),
+		WithMaxStatementLength(cfg.MaxStatementLength),
+	}
+}
+
+// DBTracingWrapper wraps database operations with tracing. It also
+// implements pgx.QueryTracer, so it can be set on a pool to trace every
+// query. Statements are only recorded for sampled requests and slow queries.
+type DBTracingWrapper struct {
+	tracer trace.Tracer
+	mp     *MeterProvider
+
+	sampleRate    float64
+	slowThreshold time.Duration
+	scrub         bool
+	maxLength     int
+}
+
+// NewDBTracingWrapper creates a new database tracing wrapper. Without
+// options every statement is recorded unscrubbed, truncated to 1000 bytes.
+func NewDBTracingWrapper(serviceName string, mp *MeterProvider, opts ...DBTracingOption) *DBTracingWrapper {
+	w := &DBTracingWrapper{
+		tracer:     otel.Tracer(serviceName + "-db"),
+		mp:         mp,
+		sampleRate: 1,
+		maxLength:  1000,
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	return w
+}
+
+// TraceQuery traces a database query
+func (w *DBTracingWrapper) TraceQuery(ctx context.Context, operation, query string, fn func() error) error {
+	start := time.Now()
+
+	ctx, span := w.start(ctx, operation)
+	defer span.End()
+
+	err := fn()
+
+	w.finish(ctx, span, operation, query, time.Since(start), err)
+	return err
+}
+
+// dbQueryKey carries the state of a pgx query between start and end
+type dbQueryKey struct{}
+
+type dbQuery struct {
+	operation string
+	sql       string
+	start     time.Time
+}
+
+// TraceQueryStart implements pgx.QueryTracer
+func (w *DBTracingWrapper) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	operation := queryOperation(data.SQL)
+	ctx, _ = w.start(ctx, operation)
+	return context.WithValue(ctx, dbQueryKey{}, &dbQuery{
+		operation: operation,
+		sql:       data.SQL,
+		start:     time.Now(),
+	})
+}
+
+// TraceQueryEnd implements pgx.QueryTracer
+func (w *DBTracingWrapper) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	span := trace.SpanFromContext(ctx)
+	defer span.End()
+
+	query, ok := ctx.Value(dbQueryKey{}).(*dbQuery)
+	if !ok {
+		return
+	}
+	if data.Err == nil {
+		span.SetAttributes(attribute.Int64("db.rows_affected", data.CommandTag.RowsAffected()))
+	}
+	w.finish(ctx, span, query.operation, query.sql, time.Since(query.start), data.Err)
+}
+
+func (w *DBTracingWrapper) start(ctx context.Context, operation string) (context.Context, trace.Span) {
+	return w.tracer.Start(ctx, "db."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemPostgreSQL,
+			attribute.String("db.operation", operation),
+		),
+	)
+}
+
+func (w *DBTracingWrapper) finish(ctx context.Context, span trace.Span, operation, query string, duration time.Duration, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetAttributes(attribute.Bool("error", true))
+	}
+
+	if span.IsRecording() {
+		slow := w.slowThreshold > 0 && duration >= w.slowThreshold
+		if slow {
+			span.SetAttributes(attribute.Bool("db.slow", true))
+		}
+		if slow || w.sampled(span.SpanContext()) {
+			span.SetAttributes(attribute.String("db.statement", w.statement(query)))
+		}
+	}
+
+	if w.mp != nil {
+		w.mp.RecordDBQuery(ctx, operation, duration)
+	}
+}
+
+// sampled decides whether to record statements for a span's trace. The
+// decision is derived from the trace ID, like the SDK's ratio sampler.
+func (w *DBTracingWrapper) sampled(sc trace.SpanContext) bool {
+	switch {
+	case w.sampleRate >= 1:
+		return true
+	case w.sampleRate <= 0:
+		return false
+	}
+
+	bound := uint64(w.sampleRate * (1 << 63))
+	if !sc.TraceID().IsValid() {
+		return rand.Uint64()>>1 < bound
+	}
+	traceID := sc.TraceID()
+	return binary.BigEndian.Uint64(traceID[8:16])>>1 < bound
+}
+
+// statement prepares a query for recording
+func (w *DBTracingWrapper) statement(query string) string {
+	if w.scrub {
+		query = ScrubStatement(query)
+	}
+	if w.maxLength > 0 {
+		query = truncateQuery(query, w.maxLength)
+	}
+	return query
+}
+
+// queryOperation names a query for its span: the sqlc query name when
+// present, otherwise the leading SQL keyword
+func queryOperation(sql string) string {
+	sql = strings.TrimSpace(sql)
+	if rest, ok := strings.CutPrefix(sql, "-- name: "); ok {
+		if name, _, ok := strings.Cut(rest, " "); ok && name != "" {
+			return name
+		}
+	}
+
+	keyword, _, _ := strings.Cut(sql, " ")
+	if keyword == "" {
+		return "query"
+	}
+	return strings.ToLower(keyword)
+}
+
+// ScrubStatement replaces string literals, dollar-quoted strings and
+// numeric literals in a SQL statement with ?. Identifiers, keywords,
+// comments and $n placeholders are kept.
+func ScrubStatement(sql string) string {
+	var b strings.Builder
+	b.Grow(len(sql))
+
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == '-' && i+1 < len(sql) && sql[i+1] == '-':
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 {
+				end = len(sql) - i
+			}
+			b.WriteString(sql[i : i+end])
+			i += end
+
+		case c == '\'':
+			// Doubled quotes escape a quote inside the literal
+			i++
+			for i < len(sql) {
+				if sql[i] == '\'' {
+					if i+1 < len(sql) && sql[i+1] == '\'' {
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				i++
+			}
+			b.WriteByte('?')
+
+		case c == '$' && i+1 < len(sql) && isDigit(sql[i+1]):
+			j := i + 1
+			for j < len(sql) && isDigit(sql[j]) {
+				j++
+			}
+			b.WriteString(sql[i:j])
+			i = j
+
+		case c == '$':
+			tag, ok := dollarTag(sql[i:])
+			if !ok {
+				b.WriteByte(c)
+				i++
+				continue
+			}
+			end := strings.Index(sql[i+len(tag):], tag)
+			if end < 0 {
+				i = len(sql)
+			} else {
+				i += len(tag) + end + len(tag)
+			}
+			b.WriteByte('?')
+
+		case isDigit(c) && (i == 0 || !isIdentChar(sql[i-1])):
+			j := i
+			for j < len(sql) && (isDigit(sql[j]) || sql[j] == '.') {
+				j++
+			}
+			b.WriteByte('?')
+			i = j
+
+		case isIdentChar(c):
+			j := i
+			for j < len(sql) && isIdentChar(sql[j]) {
+				j++
+			}
+			b.WriteString(sql[i:j])
+			i = j
+
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+
+	return b.String()
+}
+
+// dollarTag returns the opening tag of a dollar-quoted string, such as $$
+// or $body$
+func dollarTag(s string) (string, bool) {
+	for j := 1; j < len(s); j++ {
+		if s[j] == '$' {
+			return s[:j+1], true
+		}
+		if !isIdentChar(s[j]) {
+			return "", false
+		}
+	}
+	return "", false
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || isDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// truncateQuery truncates a query to a maximum length
+func truncateQuery(query string, maxLen int) string {
+	if len(query) <= maxLen {
+		return query
+	}
+	return query[:maxLen] + "..."
+}
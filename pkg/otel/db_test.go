@@ -0,0 +1,66 @@
+package otel
+
+import (
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestScrubStatement(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want string
+	}{
+		{"string literal", "SELECT * FROM users WHERE email = 'a@b.com'", "SELECT * FROM users WHERE email = ?"},
+		{"escaped quote", "SELECT 'it''s' AS x", "SELECT ? AS x"},
+		{"numbers", "SELECT * FROM t LIMIT 10 OFFSET 2.5", "SELECT * FROM t LIMIT ? OFFSET ?"},
+		{"placeholders kept", "UPDATE users SET role = $2 WHERE id = $1", "UPDATE users SET role = $2 WHERE id = $1"},
+		{"identifiers with digits", "SELECT col1 FROM t2", "SELECT col1 FROM t2"},
+		{"dollar quoted", "SELECT $tag$secret$tag$, $$x$$", "SELECT ?, ?"},
+		{"comment kept", "-- name: GetUser :one\nSELECT 1", "-- name: GetUser :one\nSELECT ?"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ScrubStatement(tt.sql); got != tt.want {
+				t.Errorf("ScrubStatement(%q) = %q, want %q", tt.sql, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryOperation(t *testing.T) {
+	if op := queryOperation("-- name: GetUserByID :one\nSELECT 1"); op != "GetUserByID" {
+		t.Errorf("Expected sqlc query name, got %q", op)
+	}
+	if op := queryOperation("  INSERT INTO t VALUES (1)"); op != "insert" {
+		t.Errorf("Expected leading keyword, got %q", op)
+	}
+}
+
+func TestDBTracingWrapper_SampledPerTrace(t *testing.T) {
+	w := NewDBTracingWrapper("test", nil, WithStatementSampleRate(0.5))
+
+	low := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{0x01, 8: 0x00, 15: 0x01},
+		SpanID:  trace.SpanID{0x01},
+	})
+	high := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{0x01, 8: 0xff, 15: 0xff},
+		SpanID:  trace.SpanID{0x01},
+	})
+
+	for i := 0; i < 3; i++ {
+		if !w.sampled(low) {
+			t.Error("Expected low trace ID to be sampled")
+		}
+		if w.sampled(high) {
+			t.Error("Expected high trace ID not to be sampled")
+		}
+	}
+
+	if NewDBTracingWrapper("test", nil, WithStatementSampleRate(0)).sampled(low) {
+		t.Error("Expected no sampling at rate 0")
+	}
+}
@@ -3,11 +3,11 @@ package otel
 import (
 	"context"
 	"log/slog"
-	"net/http"
 	"runtime"
 	"time"
 
 	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/exporters/prometheus"
@@ -15,6 +15,7 @@ import (
 	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
 
 	"github.com/pixperk/goiler/internal/config"
+	"github.com/pixperk/goiler/pkg/sandbox"
 )
 
 // MeterProvider wraps the OpenTelemetry meter provider
@@ -187,13 +188,17 @@ func (mp *MeterProvider) Shutdown(ctx context.Context) error {
 	return nil
 }
 
-// RecordRequest records an HTTP request metric
+// RecordRequest records an HTTP request metric, labelled when ctx is in
+// sandbox mode
 func (mp *MeterProvider) RecordRequest(ctx context.Context, method, path string, statusCode int, duration time.Duration) {
 	attrs := []attribute.KeyValue{
 		attribute.String("method", method),
 		attribute.String("path", path),
 		attribute.Int("status_code", statusCode),
 	}
+	if sandbox.Enabled(ctx) {
+		attrs = append(attrs, sandbox.Attribute)
+	}
 
 	mp.RequestCounter.Add(ctx, 1, metric.WithAttributes(attrs...))
 	mp.RequestDuration.Record(ctx, duration.Seconds(), metric.WithAttributes(attrs...))
@@ -237,11 +242,9 @@ func (mp *MeterProvider) DecrementActiveRequests(ctx context.Context) {
 	mp.ActiveRequests.Add(ctx, -1)
 }
 
-// MetricsHandler returns an HTTP handler for Prometheus metrics
+// MetricsHandler returns an HTTP handler for Prometheus metrics.
+// The Prometheus exporter registers with the default registry, so this
+// serves every metric recorded through the meter provider.
 func MetricsHandler() echo.HandlerFunc {
-	return func(c echo.Context) error {
-		// The promhttp.Handler() would be used here in a real implementation
-		// For now, we return a placeholder
-		return c.String(http.StatusOK, "# Metrics endpoint - configure with promhttp.Handler()")
-	}
+	return echo.WrapHandler(promhttp.Handler())
 }
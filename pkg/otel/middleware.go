@@ -1,10 +1,10 @@
 package otel
 
 import (
-	"context"
 	"time"
 
 	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/pkg/redact"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/propagation"
@@ -12,7 +12,8 @@ import (
 	"go.opentelemetry.io/otel/trace"
 )
 
-// TracingMiddleware returns an Echo middleware for distributed tracing
+// TracingMiddleware returns an Echo middleware for distributed tracing.
+// Sensitive query parameters are redacted from url.full.
 func TracingMiddleware(serviceName string) echo.MiddlewareFunc {
 	tracer := otel.Tracer(serviceName)
 	propagator := otel.GetTextMapPropagator()
@@ -35,7 +36,7 @@ func TracingMiddleware(serviceName string) echo.MiddlewareFunc {
 				trace.WithSpanKind(trace.SpanKindServer),
 				trace.WithAttributes(
 					semconv.HTTPRequestMethodKey.String(req.Method),
-					semconv.URLFull(req.URL.String()),
+					semconv.URLFull(redact.URL(req.URL, redact.DefaultKeys)),
 					semconv.URLPath(req.URL.Path),
 					semconv.ServerAddress(req.Host),
 					semconv.URLScheme(req.URL.Scheme),
@@ -93,7 +94,9 @@ func MetricsMiddleware(mp *MeterProvider) echo.MiddlewareFunc {
 				path = c.Request().URL.Path
 			}
 
-			mp.RecordRequest(ctx, c.Request().Method, path, c.Response().Status, duration)
+			// The request context carries the sandbox mode set by later
+			// middleware
+			mp.RecordRequest(c.Request().Context(), c.Request().Method, path, c.Response().Status, duration)
 
 			if err != nil {
 				mp.RecordError(ctx, "http")
@@ -160,7 +163,9 @@ func CombinedMiddleware(serviceName string, mp *MeterProvider) echo.MiddlewareFu
 			// Record metrics
 			if mp != nil {
 				duration := time.Since(start)
-				mp.RecordRequest(ctx, req.Method, spanName, statusCode, duration)
+				// The request context carries the sandbox mode set by
+				// later middleware
+				mp.RecordRequest(c.Request().Context(), req.Method, spanName, statusCode, duration)
 				if err != nil {
 					mp.RecordError(ctx, "http")
 				}
@@ -170,55 +175,3 @@ func CombinedMiddleware(serviceName string, mp *MeterProvider) echo.MiddlewareFu
 		}
 	}
 }
-
-// DBTracingWrapper wraps database operations with tracing
-type DBTracingWrapper struct {
-	tracer trace.Tracer
-	mp     *MeterProvider
-}
-
-// NewDBTracingWrapper creates a new database tracing wrapper
-func NewDBTracingWrapper(serviceName string, mp *MeterProvider) *DBTracingWrapper {
-	return &DBTracingWrapper{
-		tracer: otel.Tracer(serviceName + "-db"),
-		mp:     mp,
-	}
-}
-
-// TraceQuery traces a database query
-func (w *DBTracingWrapper) TraceQuery(ctx context.Context, operation, query string, fn func() error) error {
-	start := time.Now()
-
-	ctx, span := w.tracer.Start(ctx, "db."+operation,
-		trace.WithSpanKind(trace.SpanKindClient),
-		trace.WithAttributes(
-			semconv.DBSystemPostgreSQL,
-			attribute.String("db.operation", operation),
-			attribute.String("db.statement", truncateQuery(query, 1000)),
-		),
-	)
-	defer span.End()
-
-	err := fn()
-
-	duration := time.Since(start)
-
-	if err != nil {
-		span.RecordError(err)
-		span.SetAttributes(attribute.Bool("error", true))
-	}
-
-	if w.mp != nil {
-		w.mp.RecordDBQuery(ctx, operation, duration)
-	}
-
-	return err
-}
-
-// truncateQuery truncates a query to a maximum length
-func truncateQuery(query string, maxLen int) string {
-	if len(query) <= maxLen {
-		return query
-	}
-	return query[:maxLen] + "..."
-}
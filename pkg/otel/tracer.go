@@ -3,9 +3,11 @@ package otel
 import (
 	"context"
 	"log/slog"
+	"strings"
 
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
 	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/sdk/resource"
@@ -33,11 +35,7 @@ func NewTracerProvider(ctx context.Context, cfg *config.Config, logger *slog.Log
 		}, nil
 	}
 
-	// Create OTLP HTTP exporter
-	exporter, err := otlptracehttp.New(ctx,
-		otlptracehttp.WithEndpoint(cfg.OTEL.Endpoint),
-		otlptracehttp.WithInsecure(),
-	)
+	exporter, err := NewTraceExporter(ctx, cfg)
 	if err != nil {
 		return nil, err
 	}
@@ -84,6 +82,19 @@ func NewTracerProvider(ctx context.Context, cfg *config.Config, logger *slog.Log
 	}, nil
 }
 
+// NewTraceExporter creates the OTLP HTTP exporter spans are sent with. The
+// endpoint is either host:port, sent to over plain HTTP, or a URL such as
+// http://collector:4318, whose scheme decides.
+func NewTraceExporter(ctx context.Context, cfg *config.Config) (*otlptrace.Exporter, error) {
+	if strings.Contains(cfg.OTEL.Endpoint, "://") {
+		return otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(cfg.OTEL.Endpoint))
+	}
+	return otlptracehttp.New(ctx,
+		otlptracehttp.WithEndpoint(cfg.OTEL.Endpoint),
+		otlptracehttp.WithInsecure(),
+	)
+}
+
 // Tracer returns the tracer instance
 func (tp *TracerProvider) Tracer() trace.Tracer {
 	return tp.tracer
@@ -0,0 +1,34 @@
+package pagination
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+// ErrInvalidCursor is returned for cursors that weren't produced by
+// EncodeCursor, or not for the value decoded into
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// EncodeCursor encodes a keyset position, such as the sort key and ID of
+// the last item of a page, as an opaque URL-safe cursor. Cursors aren't
+// signed; decoders must treat the values as untrusted input.
+func EncodeCursor(position interface{}) (string, error) {
+	data, err := json.Marshal(position)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// DecodeCursor decodes a cursor from EncodeCursor into position
+func DecodeCursor(cursor string, position interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return ErrInvalidCursor
+	}
+	if err := json.Unmarshal(data, position); err != nil {
+		return ErrInvalidCursor
+	}
+	return nil
+}
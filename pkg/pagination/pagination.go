@@ -0,0 +1,195 @@
+// Package pagination binds the query parameters shared by list endpoints
+// (page, per_page, cursor, sort, order and filters) into a ListParams, so
+// handlers don't each parse and cap them.
+package pagination
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Defaults used when Options leaves them unset
+const (
+	DefaultPerPage = 20
+	MaxPerPage     = 100
+)
+
+// MaxOffset is the largest offset a page may start at, so offsets fit the
+// int32 LIMIT/OFFSET parameters of the queries
+const MaxOffset = math.MaxInt32
+
+// Sort orders
+const (
+	OrderAsc  = "asc"
+	OrderDesc = "desc"
+)
+
+// Options describes what a list endpoint accepts
+type Options struct {
+	// DefaultPerPage is used when per_page is missing (defaults to 20)
+	DefaultPerPage int
+	// MaxPerPage caps per_page; larger values are lowered to it (defaults
+	// to 100)
+	MaxPerPage int
+	// Sorts are the fields the list can be sorted by. Without them the sort
+	// parameter is rejected.
+	Sorts []string
+	// DefaultSort is used when sort is missing
+	DefaultSort string
+	// DefaultOrder is used when order is missing (defaults to desc)
+	DefaultOrder string
+	// Filters are the query parameters copied into ListParams.Filters
+	Filters []string
+}
+
+// ListParams are the bound parameters of a list request
+type ListParams struct {
+	Page    int
+	PerPage int
+	// Cursor is the opaque position to continue from, if the client sent one
+	Cursor string
+	Sort   string
+	Order  string
+	// Filters holds the non-empty filter parameters by name
+	Filters map[string]string
+
+	// cursorSent is set when the request had a cursor parameter, even an
+	// empty one
+	cursorSent bool
+}
+
+// Offset returns the number of items before the page, at most MaxOffset
+func (p ListParams) Offset() int {
+	if p.PerPage > 0 && p.Page-1 > MaxOffset/p.PerPage {
+		return MaxOffset
+	}
+	return max((p.Page-1)*p.PerPage, 0)
+}
+
+// Limit returns the page size
+func (p ListParams) Limit() int {
+	return p.PerPage
+}
+
+// UsesCursor reports whether the client asked for cursor pagination. An
+// empty cursor parameter asks for the first page.
+func (p ListParams) UsesCursor() bool {
+	return p.cursorSent || p.Cursor != ""
+}
+
+// Descending reports whether the list is sorted in descending order
+func (p ListParams) Descending() bool {
+	return p.Order == OrderDesc
+}
+
+// Filter returns the value of a filter and whether it was set
+func (p ListParams) Filter(name string) (string, bool) {
+	value, ok := p.Filters[name]
+	return value, ok
+}
+
+// Error reports invalid list parameters, by parameter name
+type Error struct {
+	Details map[string]string
+}
+
+func (e *Error) Error() string {
+	names := make([]string, 0, len(e.Details))
+	for name := range e.Details {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return "invalid list parameters: " + strings.Join(names, ", ")
+}
+
+// Bind reads the list parameters of a request. Invalid values are
+// reported together in an *Error, whose Details suit
+// response.ValidationError.
+func Bind(c echo.Context, opts Options) (ListParams, error) {
+	if opts.DefaultPerPage <= 0 {
+		opts.DefaultPerPage = DefaultPerPage
+	}
+	if opts.MaxPerPage <= 0 {
+		opts.MaxPerPage = MaxPerPage
+	}
+	if opts.DefaultOrder == "" {
+		opts.DefaultOrder = OrderDesc
+	}
+
+	params := ListParams{
+		Page:    1,
+		PerPage: min(opts.DefaultPerPage, opts.MaxPerPage),
+		Cursor:  c.QueryParam("cursor"),
+		Sort:    opts.DefaultSort,
+		Order:   opts.DefaultOrder,
+		Filters: make(map[string]string),
+	}
+	_, params.cursorSent = c.QueryParams()["cursor"]
+	details := make(map[string]string)
+
+	if raw := c.QueryParam("page"); raw != "" {
+		page, err := strconv.Atoi(raw)
+		if err != nil || page < 1 {
+			details["page"] = "must be a positive integer"
+		} else {
+			params.Page = page
+		}
+	}
+	if raw := c.QueryParam("per_page"); raw != "" {
+		perPage, err := strconv.Atoi(raw)
+		if err != nil || perPage < 1 {
+			details["per_page"] = "must be a positive integer"
+		} else {
+			params.PerPage = min(perPage, opts.MaxPerPage)
+		}
+	}
+	if maxPage := MaxOffset/params.PerPage + 1; params.Page > maxPage {
+		details["page"] = fmt.Sprintf("must be at most %d", maxPage)
+		params.Page = maxPage
+	}
+	if raw := c.QueryParam("sort"); raw != "" {
+		if !slices.Contains(opts.Sorts, raw) {
+			details["sort"] = sortMessage(opts.Sorts)
+		} else {
+			params.Sort = raw
+		}
+	}
+	if raw := strings.ToLower(c.QueryParam("order")); raw != "" {
+		if raw != OrderAsc && raw != OrderDesc {
+			details["order"] = "must be asc or desc"
+		} else {
+			params.Order = raw
+		}
+	}
+	for _, name := range opts.Filters {
+		if value := c.QueryParam(name); value != "" {
+			params.Filters[name] = value
+		}
+	}
+
+	if len(details) > 0 {
+		return params, &Error{Details: details}
+	}
+	return params, nil
+}
+
+// Details returns the invalid parameters of an error returned by Bind
+func Details(err error) map[string]string {
+	if e, ok := err.(*Error); ok {
+		return e.Details
+	}
+	return map[string]string{"query": err.Error()}
+}
+
+// sortMessage describes the accepted sort fields
+func sortMessage(sorts []string) string {
+	if len(sorts) == 0 {
+		return "is not supported"
+	}
+	return "must be one of " + strings.Join(sorts, ", ")
+}
@@ -0,0 +1,152 @@
+package pagination
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func newTestContext(query string) echo.Context {
+	req := httptest.NewRequest(http.MethodGet, "/?"+query, nil)
+	return echo.New().NewContext(req, httptest.NewRecorder())
+}
+
+func TestBind_Defaults(t *testing.T) {
+	params, err := Bind(newTestContext(""), Options{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if params.Page != 1 || params.PerPage != DefaultPerPage || params.Order != OrderDesc {
+		t.Errorf("Unexpected defaults: %+v", params)
+	}
+	if params.Offset() != 0 || params.Limit() != DefaultPerPage {
+		t.Errorf("Expected offset 0 and limit %d, got %d and %d", DefaultPerPage, params.Offset(), params.Limit())
+	}
+}
+
+func TestBind_PageAndCap(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		opts    Options
+		page    int
+		perPage int
+	}{
+		{"explicit", "page=3&per_page=10", Options{}, 3, 10},
+		{"capped", "per_page=500", Options{}, 1, MaxPerPage},
+		{"custom cap", "per_page=80", Options{MaxPerPage: 50}, 1, 50},
+		{"custom default", "", Options{DefaultPerPage: 5}, 1, 5},
+		{"default above cap", "", Options{DefaultPerPage: 50, MaxPerPage: 25}, 1, 25},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, err := Bind(newTestContext(tt.query), tt.opts)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if params.Page != tt.page || params.PerPage != tt.perPage {
+				t.Errorf("Expected page %d per_page %d, got %d and %d", tt.page, tt.perPage, params.Page, params.PerPage)
+			}
+		})
+	}
+}
+
+func TestBind_Invalid(t *testing.T) {
+	_, err := Bind(newTestContext("page=0&per_page=abc&sort=name&order=up"), Options{Sorts: []string{"created_at"}})
+
+	var perr *Error
+	if !errors.As(err, &perr) {
+		t.Fatalf("Expected *Error, got %v", err)
+	}
+	details := Details(err)
+	for _, name := range []string{"page", "per_page", "sort", "order"} {
+		if details[name] == "" {
+			t.Errorf("Expected %s to be reported, got %v", name, details)
+		}
+	}
+}
+
+func TestBind_OffsetBound(t *testing.T) {
+	maxPage := MaxOffset/MaxPerPage + 1
+	params, err := Bind(newTestContext(fmt.Sprintf("page=%d&per_page=%d", maxPage, MaxPerPage)), Options{})
+	if err != nil || params.Offset() > MaxOffset {
+		t.Errorf("Expected the last page to bind, got %d and %v", params.Offset(), err)
+	}
+
+	params, err = Bind(newTestContext(fmt.Sprintf("page=%d&per_page=%d", maxPage+1, MaxPerPage)), Options{})
+	if Details(err)["page"] == "" {
+		t.Errorf("Expected a page past the offset bound to be reported, got %v", err)
+	}
+	if params.Page != maxPage || params.Offset() > MaxOffset {
+		t.Errorf("Expected the page to be clamped to %d, got %d", maxPage, params.Page)
+	}
+
+	// Offsets of params built by hand are clamped too
+	if offset := (ListParams{Page: math.MaxInt, PerPage: MaxPerPage}).Offset(); offset != MaxOffset {
+		t.Errorf("Expected offset %d, got %d", MaxOffset, offset)
+	}
+}
+
+func TestBind_SortOrderCursorAndFilters(t *testing.T) {
+	c := newTestContext("sort=email&order=ASC&cursor=abc&status=open&role=")
+	params, err := Bind(c, Options{
+		Sorts:       []string{"created_at", "email"},
+		DefaultSort: "created_at",
+		Filters:     []string{"status", "role"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if params.Sort != "email" || params.Order != OrderAsc || params.Descending() {
+		t.Errorf("Expected email ascending, got %s %s", params.Sort, params.Order)
+	}
+	if params.Cursor != "abc" {
+		t.Errorf("Expected cursor abc, got %q", params.Cursor)
+	}
+	if status, ok := params.Filter("status"); !ok || status != "open" {
+		t.Errorf("Expected status filter open, got %q", status)
+	}
+	if _, ok := params.Filter("role"); ok {
+		t.Error("Expected the empty role filter to be left out")
+	}
+}
+
+func TestBind_CursorMode(t *testing.T) {
+	if params, _ := Bind(newTestContext("page=2"), Options{}); params.UsesCursor() {
+		t.Error("Expected offset pagination without a cursor")
+	}
+	if params, _ := Bind(newTestContext("cursor="), Options{}); !params.UsesCursor() || params.Cursor != "" {
+		t.Errorf("Expected an empty cursor to start cursor pagination, got %+v", params)
+	}
+}
+
+func TestCursor_RoundTrip(t *testing.T) {
+	type position struct {
+		CreatedAt string `json:"t"`
+		ID        string `json:"id"`
+	}
+	cursor, err := EncodeCursor(position{CreatedAt: "2026-01-01T00:00:00Z", ID: "abc"})
+	if err != nil {
+		t.Fatalf("Failed to encode cursor: %v", err)
+	}
+
+	var got position
+	if err := DecodeCursor(cursor, &got); err != nil {
+		t.Fatalf("Failed to decode cursor: %v", err)
+	}
+	if got.ID != "abc" || got.CreatedAt != "2026-01-01T00:00:00Z" {
+		t.Errorf("Unexpected position: %+v", got)
+	}
+
+	for _, bad := range []string{"not base64!", "bm90IGpzb24"} {
+		if err := DecodeCursor(bad, &got); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("Expected ErrInvalidCursor for %q, got %v", bad, err)
+		}
+	}
+}
@@ -0,0 +1,396 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// StagePanicError is reported on a pipeline's error channel when a stage
+// panics. The event is dropped and the pipeline moves on to the next one.
+type StagePanicError struct {
+	// Stage is the index of the stage that panicked
+	Stage int
+	Event Event
+	Value interface{}
+	Stack []byte
+}
+
+func (e *StagePanicError) Error() string {
+	return fmt.Sprintf("pipeline stage %d panicked on %s event: %v", e.Stage, e.Event.Topic, e.Value)
+}
+
+// PipelineOption configures a Pipeline
+type PipelineOption func(*Pipeline)
+
+// WithName names the pipeline in logs and metrics
+func WithName(name string) PipelineOption {
+	return func(p *Pipeline) {
+		p.name = name
+	}
+}
+
+// WithLogger sets the logger. It defaults to slog.Default().
+func WithLogger(logger *slog.Logger) PipelineOption {
+	return func(p *Pipeline) {
+		p.logger = logger
+	}
+}
+
+// WithStallTimeout sets how long an event may spend in the stages before
+// the pipeline counts as stalled. A stalled processing loop is abandoned
+// and a new one takes the following events; the stuck event's result is
+// still delivered if its stage ever returns, and the abandoned loop then
+// exits without taking another event. Zero disables stall detection.
+func WithStallTimeout(timeout time.Duration) PipelineOption {
+	return func(p *Pipeline) {
+		p.stallTimeout = timeout
+	}
+}
+
+// pipelineLoop is one run of the processing loop
+type pipelineLoop struct {
+	// busySince is when the current event entered the stages, in Unix
+	// nanoseconds, or 0 between events
+	busySince atomic.Int64
+	// abandoned is closed once the loop stalled and was replaced, while
+	// holding Pipeline.mu
+	abandoned chan struct{}
+	// exited is set when the loop returns; guarded by Pipeline.mu
+	exited bool
+}
+
+// Pipeline chains multiple processing stages. Events pass through the
+// stages one at a time on a processing loop. A panicking stage drops its
+// event with a StagePanicError, and the loop is restarted if it dies or
+// stalls, so one bad stage can't wedge the pipeline.
+type Pipeline struct {
+	stages []func(Event) (Event, error)
+	input  chan Event
+	output chan Event
+	errors chan error
+	ctx    context.Context
+
+	name         string
+	logger       *slog.Logger
+	stallTimeout time.Duration
+
+	mu      sync.Mutex
+	current *pipelineLoop
+	loops   sync.WaitGroup
+	// stuck counts abandoned loops whose stage hasn't returned yet
+	stuck    atomic.Int64
+	stopped  chan struct{}
+	stopOnce sync.Once
+
+	// dropped counts errors dropped because the errors buffer was full
+	dropped atomic.Int64
+
+	panics        metric.Int64Counter
+	restarts      metric.Int64Counter
+	droppedErrors metric.Int64Counter
+}
+
+// NewPipeline creates a new processing pipeline
+func NewPipeline(ctx context.Context, bufferSize int, opts ...PipelineOption) *Pipeline {
+	p := &Pipeline{
+		stages:  make([]func(Event) (Event, error), 0),
+		input:   make(chan Event, bufferSize),
+		output:  make(chan Event, bufferSize),
+		errors:  make(chan error, bufferSize),
+		ctx:     ctx,
+		name:    "pipeline",
+		logger:  slog.Default(),
+		stopped: make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	p.initMetrics()
+	return p
+}
+
+// initMetrics registers pipeline metrics with the global meter provider
+func (p *Pipeline) initMetrics() {
+	meter := otel.Meter("goiler/pubsub")
+
+	p.panics, _ = meter.Int64Counter(
+		"pubsub_pipeline_stage_panics_total",
+		metric.WithDescription("Total number of pipeline stage panics, by pipeline and stage"),
+		metric.WithUnit("1"),
+	)
+	p.restarts, _ = meter.Int64Counter(
+		"pubsub_pipeline_restarts_total",
+		metric.WithDescription("Total number of pipeline processing loops restarted, by pipeline and reason"),
+		metric.WithUnit("1"),
+	)
+	p.droppedErrors, _ = meter.Int64Counter(
+		"pubsub_pipeline_errors_dropped_total",
+		metric.WithDescription("Total number of pipeline errors dropped because nobody read the errors channel, by pipeline"),
+		metric.WithUnit("1"),
+	)
+	meter.Int64ObservableGauge(
+		"pubsub_pipeline_stuck_loops",
+		metric.WithDescription("Number of stalled pipeline processing loops whose stage hasn't returned"),
+		metric.WithUnit("1"),
+		metric.WithInt64Callback(func(ctx context.Context, observer metric.Int64Observer) error {
+			observer.Observe(int64(p.StuckLoops()), metric.WithAttributes(attribute.String("pipeline", p.name)))
+			return nil
+		}),
+	)
+}
+
+// AddStage adds a processing stage to the pipeline
+func (p *Pipeline) AddStage(stage func(Event) (Event, error)) *Pipeline {
+	p.stages = append(p.stages, stage)
+	return p
+}
+
+// Start starts the pipeline. Once the input is closed or the context is
+// done, the output and error channels are closed after every loop,
+// including stuck ones, has finished.
+func (p *Pipeline) Start() {
+	p.startLoop()
+	if p.stallTimeout > 0 {
+		go p.watchStalls()
+	}
+	go func() {
+		<-p.stopped
+		p.loops.Wait()
+		close(p.output)
+		close(p.errors)
+	}()
+}
+
+// StuckLoops returns how many stalled processing loops are still waiting
+// on a stage
+func (p *Pipeline) StuckLoops() int {
+	return int(p.stuck.Load())
+}
+
+// DroppedErrors returns how many errors were dropped because the errors
+// channel was full
+func (p *Pipeline) DroppedErrors() int64 {
+	return p.dropped.Load()
+}
+
+// startLoop starts a processing loop, replacing the current one
+func (p *Pipeline) startLoop() {
+	loop := &pipelineLoop{abandoned: make(chan struct{})}
+	p.mu.Lock()
+	p.current = loop
+	p.mu.Unlock()
+
+	p.loops.Add(1)
+	go p.run(loop)
+}
+
+// stop marks the pipeline as no longer taking events
+func (p *Pipeline) stop() {
+	p.stopOnce.Do(func() { close(p.stopped) })
+}
+
+// run processes events until the pipeline stops or the loop is abandoned
+func (p *Pipeline) run(loop *pipelineLoop) {
+	defer p.loops.Done()
+	defer p.exit(loop)
+	// Stage panics are recovered in runStage; anything else that kills the
+	// loop restarts it
+	defer func() {
+		if r := recover(); r != nil {
+			p.logger.Error("pipeline loop panicked, restarting",
+				slog.String("pipeline", p.name),
+				slog.Any("panic", r),
+				slog.String("stack", string(debug.Stack())),
+			)
+			select {
+			case <-loop.abandoned:
+				// Already replaced
+			default:
+				p.restart("panic")
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-p.ctx.Done():
+			p.stop()
+			return
+		case <-p.stopped:
+			return
+		case <-loop.abandoned:
+			return
+		case event, ok := <-p.input:
+			if !ok {
+				p.stop()
+				return
+			}
+
+			event, err := p.process(loop, event)
+			abandoned := p.finish(loop)
+			if err != nil {
+				p.reportError(err)
+			} else {
+				select {
+				case p.output <- event:
+				case <-p.ctx.Done():
+				}
+			}
+			// The loop that replaced this one takes the next events;
+			// taking one here too would run them concurrently
+			if abandoned {
+				return
+			}
+		}
+	}
+}
+
+// finish marks the loop idle after an event, reporting whether it was
+// abandoned while busy. The stall watcher only abandons busy loops and
+// holds the lock while checking, so once finish returns false the loop
+// can't be abandoned before its next event.
+func (p *Pipeline) finish(loop *pipelineLoop) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	loop.busySince.Store(0)
+	select {
+	case <-loop.abandoned:
+		return true
+	default:
+		return false
+	}
+}
+
+// exit records that a loop returned, so it no longer counts as stuck
+func (p *Pipeline) exit(loop *pipelineLoop) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	loop.exited = true
+	select {
+	case <-loop.abandoned:
+		p.stuck.Add(-1)
+	default:
+	}
+}
+
+// process passes an event through every stage
+func (p *Pipeline) process(loop *pipelineLoop, event Event) (Event, error) {
+	loop.busySince.Store(time.Now().UnixNano())
+	for i, stage := range p.stages {
+		var err error
+		event, err = p.runStage(i, stage, event)
+		if err != nil {
+			return event, err
+		}
+	}
+	return event, nil
+}
+
+// runStage runs a stage, converting a panic to a StagePanicError
+func (p *Pipeline) runStage(i int, stage func(Event) (Event, error), event Event) (out Event, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			panicErr := &StagePanicError{Stage: i, Event: event, Value: r, Stack: debug.Stack()}
+			p.panics.Add(context.Background(), 1, metric.WithAttributes(
+				attribute.String("pipeline", p.name),
+				attribute.Int("stage", i),
+			))
+			p.logger.Error("pipeline stage panicked",
+				slog.String("pipeline", p.name),
+				slog.Int("stage", i),
+				slog.String("topic", event.Topic),
+				slog.Any("panic", r),
+				slog.String("stack", string(panicErr.Stack)),
+			)
+			out, err = event, panicErr
+		}
+	}()
+	return stage(event)
+}
+
+// reportError sends a stage error without blocking; nobody reading the
+// errors must not wedge the pipeline. Dropped errors are counted and
+// logged.
+func (p *Pipeline) reportError(err error) {
+	select {
+	case p.errors <- err:
+	default:
+		dropped := p.dropped.Add(1)
+		p.droppedErrors.Add(context.Background(), 1, metric.WithAttributes(attribute.String("pipeline", p.name)))
+		p.logger.Warn("pipeline error buffer full, dropping error",
+			slog.String("pipeline", p.name),
+			slog.String("error", err.Error()),
+			slog.Int64("dropped_total", dropped),
+		)
+	}
+}
+
+// restart starts a new processing loop unless the pipeline has stopped
+func (p *Pipeline) restart(reason string) {
+	select {
+	case <-p.stopped:
+		return
+	default:
+	}
+	p.restarts.Add(context.Background(), 1, metric.WithAttributes(
+		attribute.String("pipeline", p.name),
+		attribute.String("reason", reason),
+	))
+	p.startLoop()
+}
+
+// watchStalls abandons the current loop whenever its event has been in the
+// stages longer than the stall timeout
+func (p *Pipeline) watchStalls() {
+	ticker := time.NewTicker(p.stallTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stopped:
+			return
+		case <-ticker.C:
+			p.mu.Lock()
+			loop := p.current
+			since := loop.busySince.Load()
+			stalled := !loop.exited && since != 0 && time.Since(time.Unix(0, since)) > p.stallTimeout
+			if stalled {
+				close(loop.abandoned)
+				p.stuck.Add(1)
+			}
+			p.mu.Unlock()
+			if !stalled {
+				continue
+			}
+
+			p.logger.Warn("pipeline stalled, restarting processing loop",
+				slog.String("pipeline", p.name),
+				slog.Duration("stalled_for", time.Since(time.Unix(0, since))),
+			)
+			p.restart("stall")
+		}
+	}
+}
+
+// Input returns the input channel
+func (p *Pipeline) Input() chan<- Event {
+	return p.input
+}
+
+// Output returns the output channel
+func (p *Pipeline) Output() <-chan Event {
+	return p.output
+}
+
+// Errors returns the errors channel
+func (p *Pipeline) Errors() <-chan error {
+	return p.errors
+}
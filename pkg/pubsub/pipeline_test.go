@@ -0,0 +1,155 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pixperk/goiler/internal/testutil"
+)
+
+func newTestPipeline(ctx context.Context, opts ...PipelineOption) *Pipeline {
+	opts = append([]PipelineOption{WithLogger(testutil.Logger())}, opts...)
+	return NewPipeline(ctx, 10, opts...)
+}
+
+func receive[T any](t *testing.T, ch <-chan T) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the pipeline")
+	}
+	var zero T
+	return zero
+}
+
+func TestPipeline_StagePanic(t *testing.T) {
+	p := newTestPipeline(context.Background())
+	p.AddStage(func(e Event) (Event, error) {
+		if e.Payload == "bad" {
+			panic("boom")
+		}
+		return e, nil
+	})
+	p.Start()
+
+	p.Input() <- Event{Topic: "t", Payload: "bad"}
+	p.Input() <- Event{Topic: "t", Payload: "good"}
+
+	err := receive(t, p.Errors())
+	var panicErr *StagePanicError
+	if !errors.As(err, &panicErr) || panicErr.Stage != 0 || panicErr.Value != "boom" {
+		t.Fatalf("Expected a StagePanicError from stage 0, got %v", err)
+	}
+	if event := receive(t, p.Output()); event.Payload != "good" {
+		t.Errorf("Expected the next event processed, got %v", event.Payload)
+	}
+
+	close(p.input)
+	if _, ok := <-p.Output(); ok {
+		t.Error("Expected the output closed after the input")
+	}
+}
+
+func TestPipeline_StallRestartsLoop(t *testing.T) {
+	p := newTestPipeline(context.Background(), WithStallTimeout(20*time.Millisecond))
+	release := make(chan struct{})
+	p.AddStage(func(e Event) (Event, error) {
+		if e.Payload == "stuck" {
+			<-release
+		}
+		return e, nil
+	})
+	p.Start()
+
+	p.Input() <- Event{Topic: "t", Payload: "stuck"}
+	p.Input() <- Event{Topic: "t", Payload: "next"}
+
+	if event := receive(t, p.Output()); event.Payload != "next" {
+		t.Fatalf("Expected the event after the stuck one, got %v", event.Payload)
+	}
+	if n := p.StuckLoops(); n != 1 {
+		t.Errorf("Expected 1 stuck loop, got %d", n)
+	}
+
+	close(release)
+	if event := receive(t, p.Output()); event.Payload != "stuck" {
+		t.Errorf("Expected the stuck event delivered once released, got %v", event.Payload)
+	}
+	deadline := time.Now().Add(time.Second)
+	for p.StuckLoops() != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := p.StuckLoops(); n != 0 {
+		t.Errorf("Expected no stuck loops once released, got %d", n)
+	}
+}
+
+func TestPipeline_ErrorsDontBlock(t *testing.T) {
+	p := NewPipeline(context.Background(), 1, WithLogger(testutil.Logger()))
+	p.AddStage(func(e Event) (Event, error) {
+		if e.Payload == "fail" {
+			return e, errors.New("failed")
+		}
+		return e, nil
+	})
+	p.Start()
+
+	// Nobody reads the errors, which hold one
+	for i := 0; i < 3; i++ {
+		p.Input() <- Event{Topic: "t", Payload: "fail"}
+	}
+	p.Input() <- Event{Topic: "t", Payload: "ok"}
+	if event := receive(t, p.Output()); event.Payload != "ok" {
+		t.Errorf("Expected the pipeline to keep going, got %v", event.Payload)
+	}
+	if n := p.DroppedErrors(); n != 2 {
+		t.Errorf("Expected 2 dropped errors, got %d", n)
+	}
+}
+
+func TestPipeline_AbandonedLoopExits(t *testing.T) {
+	p := NewPipeline(context.Background(), 100,
+		WithLogger(testutil.Logger()),
+		WithStallTimeout(20*time.Millisecond),
+	)
+	release := make(chan struct{})
+	var running, overlaps atomic.Int32
+	p.AddStage(func(e Event) (Event, error) {
+		if e.Payload == "stuck" {
+			<-release
+			return e, nil
+		}
+		if running.Add(1) > 1 {
+			overlaps.Add(1)
+		}
+		time.Sleep(time.Millisecond)
+		running.Add(-1)
+		return e, nil
+	})
+	p.Start()
+
+	p.Input() <- Event{Topic: "t", Payload: "stuck"}
+	p.Input() <- Event{Topic: "t", Payload: "next"}
+	receive(t, p.Output())
+
+	// Release the stuck stage while events are waiting: the abandoned loop
+	// must exit instead of taking some of them
+	for i := 0; i < 50; i++ {
+		p.Input() <- Event{Topic: "t", Payload: i}
+	}
+	close(release)
+	for i := 0; i < 51; i++ {
+		receive(t, p.Output())
+	}
+	if n := overlaps.Load(); n != 0 {
+		t.Errorf("Expected events to be processed one at a time, %d overlapped", n)
+	}
+	if n := p.StuckLoops(); n != 0 {
+		t.Errorf("Expected the abandoned loop to exit, got %d stuck", n)
+	}
+}
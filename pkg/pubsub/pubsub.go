@@ -1,4 +1,6 @@
-package channel
+// Package pubsub is an in-process publish/subscribe system with worker
+// pools, fan-out and pipelines built on top of it.
+package pubsub
 
 import (
 	"context"
@@ -31,11 +33,15 @@ type PubSub struct {
 	bufferSize  int
 }
 
-// NewPubSub creates a new PubSub instance
-func NewPubSub(logger *slog.Logger, bufferSize int) *PubSub {
+// New creates a PubSub whose subscribers buffer bufferSize events (100
+// when not positive). A nil logger logs to slog.Default().
+func New(logger *slog.Logger, bufferSize int) *PubSub {
 	if bufferSize <= 0 {
 		bufferSize = 100
 	}
+	if logger == nil {
+		logger = slog.Default()
+	}
 	return &PubSub{
 		subscribers: make(map[string]map[string]*Subscriber),
 		logger:      logger,
@@ -141,6 +147,20 @@ func (ps *PubSub) GetSubscriberCount(topic string) int {
 	return len(ps.subscribers[topic])
 }
 
+// Backlog returns the number of events waiting in the fullest subscriber's
+// buffer and the buffer size. Events to a full subscriber are dropped.
+func (ps *PubSub) Backlog() (queued, capacity int) {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	for _, subs := range ps.subscribers {
+		for _, sub := range subs {
+			queued = max(queued, len(sub.Channel))
+		}
+	}
+	return queued, ps.bufferSize
+}
+
 // GetTopics returns all active topics
 func (ps *PubSub) GetTopics() []string {
 	ps.mu.RLock()
@@ -295,78 +315,3 @@ func (f *Fanout) run() {
 		}
 	}
 }
-
-// Pipeline chains multiple processing stages
-type Pipeline struct {
-	stages []func(Event) (Event, error)
-	input  chan Event
-	output chan Event
-	errors chan error
-	ctx    context.Context
-}
-
-// NewPipeline creates a new processing pipeline
-func NewPipeline(ctx context.Context, bufferSize int) *Pipeline {
-	return &Pipeline{
-		stages: make([]func(Event) (Event, error), 0),
-		input:  make(chan Event, bufferSize),
-		output: make(chan Event, bufferSize),
-		errors: make(chan error, bufferSize),
-		ctx:    ctx,
-	}
-}
-
-// AddStage adds a processing stage to the pipeline
-func (p *Pipeline) AddStage(stage func(Event) (Event, error)) *Pipeline {
-	p.stages = append(p.stages, stage)
-	return p
-}
-
-// Start starts the pipeline
-func (p *Pipeline) Start() {
-	go func() {
-		for {
-			select {
-			case <-p.ctx.Done():
-				close(p.output)
-				close(p.errors)
-				return
-			case event, ok := <-p.input:
-				if !ok {
-					close(p.output)
-					close(p.errors)
-					return
-				}
-
-				// Process through all stages
-				var err error
-				for _, stage := range p.stages {
-					event, err = stage(event)
-					if err != nil {
-						p.errors <- err
-						break
-					}
-				}
-
-				if err == nil {
-					p.output <- event
-				}
-			}
-		}
-	}()
-}
-
-// Input returns the input channel
-func (p *Pipeline) Input() chan<- Event {
-	return p.input
-}
-
-// Output returns the output channel
-func (p *Pipeline) Output() <-chan Event {
-	return p.output
-}
-
-// Errors returns the errors channel
-func (p *Pipeline) Errors() <-chan error {
-	return p.errors
-}
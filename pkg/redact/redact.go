@@ -0,0 +1,78 @@
+// Package redact hides sensitive values in decoded JSON and query strings
+// before they are logged or stored.
+package redact
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Placeholder replaces redacted values
+const Placeholder = "[REDACTED]"
+
+// DefaultKeys are the keys whose values are redacted unless configured
+// otherwise
+var DefaultKeys = []string{"password", "token", "secret", "authorization", "api_key", "apikey", "credential", "verification_url"}
+
+// IsSensitive reports whether key contains any of keys, case-insensitively
+func IsSensitive(key string, keys []string) bool {
+	key = strings.ToLower(key)
+	for _, sensitive := range keys {
+		if strings.Contains(key, strings.ToLower(sensitive)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Value replaces the values of sensitive keys throughout a decoded JSON
+// value, in place
+func Value(value interface{}, keys []string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, inner := range v {
+			if IsSensitive(key, keys) {
+				v[key] = Placeholder
+				continue
+			}
+			v[key] = Value(inner, keys)
+		}
+		return v
+	case []interface{}:
+		for i, inner := range v {
+			v[i] = Value(inner, keys)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// Query returns a raw query string with the values of sensitive parameters
+// replaced. Unparseable query strings are redacted whole.
+func Query(rawQuery string, keys []string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return Placeholder
+	}
+	for key := range values {
+		if IsSensitive(key, keys) {
+			values[key] = []string{Placeholder}
+		}
+	}
+	return values.Encode()
+}
+
+// URL returns u as a string with the values of sensitive query parameters
+// replaced, and without its password
+func URL(u *url.URL, keys []string) string {
+	redacted := *u
+	redacted.RawQuery = Query(u.RawQuery, keys)
+	if u.User != nil {
+		redacted.User = url.User(u.User.Username())
+	}
+	return redacted.String()
+}
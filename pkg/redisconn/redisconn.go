@@ -0,0 +1,138 @@
+package redisconn
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/hibiken/asynq"
+	"github.com/pixperk/goiler/internal/config"
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis deployment modes
+const (
+	ModeStandalone = "standalone"
+	ModeSentinel   = "sentinel"
+	ModeCluster    = "cluster"
+)
+
+var ErrInvalidConfig = errors.New("invalid redis config")
+
+// Validate checks that the settings required by the configured mode are
+// present and that any TLS files load. Call it at startup; ConnOpt and
+// NewClient assume a valid config.
+func Validate(cfg config.RedisConfig) error {
+	switch cfg.Mode {
+	case "", ModeStandalone:
+		if cfg.Addr == "" {
+			return fmt.Errorf("%w: REDIS_ADDR is required", ErrInvalidConfig)
+		}
+	case ModeSentinel:
+		if cfg.SentinelMaster == "" || len(cfg.SentinelAddrs) == 0 {
+			return fmt.Errorf("%w: sentinel mode requires REDIS_SENTINEL_MASTER and REDIS_SENTINEL_ADDRS", ErrInvalidConfig)
+		}
+	case ModeCluster:
+		if len(cfg.ClusterAddrs) == 0 {
+			return fmt.Errorf("%w: cluster mode requires REDIS_CLUSTER_ADDRS", ErrInvalidConfig)
+		}
+	default:
+		return fmt.Errorf("%w: unknown mode %q", ErrInvalidConfig, cfg.Mode)
+	}
+
+	if _, err := TLSConfig(cfg.TLS); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+	return nil
+}
+
+// TLSConfig builds the TLS config for Redis connections, or returns nil
+// when TLS is disabled
+func TLSConfig(cfg config.RedisTLSConfig) (*tls.Config, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// ConnOpt returns asynq connection options for the configured mode.
+// Sentinel mode follows the current primary, so connections survive a
+// failover.
+func ConnOpt(cfg config.RedisConfig) asynq.RedisConnOpt {
+	// Validated at startup
+	tlsConfig, _ := TLSConfig(cfg.TLS)
+
+	switch cfg.Mode {
+	case ModeSentinel:
+		return asynq.RedisFailoverClientOpt{
+			MasterName:       cfg.SentinelMaster,
+			SentinelAddrs:    cfg.SentinelAddrs,
+			SentinelPassword: cfg.SentinelPassword,
+			Username:         cfg.Username,
+			Password:         cfg.Password,
+			DB:               cfg.DB,
+			DialTimeout:      cfg.DialTimeout,
+			ReadTimeout:      cfg.ReadTimeout,
+			WriteTimeout:     cfg.WriteTimeout,
+			PoolSize:         cfg.PoolSize,
+			TLSConfig:        tlsConfig,
+		}
+	case ModeCluster:
+		return asynq.RedisClusterClientOpt{
+			Addrs:        cfg.ClusterAddrs,
+			Username:     cfg.Username,
+			Password:     cfg.Password,
+			DialTimeout:  cfg.DialTimeout,
+			ReadTimeout:  cfg.ReadTimeout,
+			WriteTimeout: cfg.WriteTimeout,
+			TLSConfig:    tlsConfig,
+		}
+	default:
+		return asynq.RedisClientOpt{
+			Addr:         cfg.Addr,
+			Username:     cfg.Username,
+			Password:     cfg.Password,
+			DB:           cfg.DB,
+			DialTimeout:  cfg.DialTimeout,
+			ReadTimeout:  cfg.ReadTimeout,
+			WriteTimeout: cfg.WriteTimeout,
+			PoolSize:     cfg.PoolSize,
+			TLSConfig:    tlsConfig,
+		}
+	}
+}
+
+// NewClient creates a Redis client for the configured mode, for subsystems
+// that talk to Redis directly
+func NewClient(cfg config.RedisConfig) redis.UniversalClient {
+	return ConnOpt(cfg).MakeRedisClient().(redis.UniversalClient)
+}
@@ -0,0 +1,56 @@
+package redisconn
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hibiken/asynq"
+	"github.com/pixperk/goiler/internal/config"
+)
+
+func TestConnOpt_Modes(t *testing.T) {
+	sentinel := config.RedisConfig{
+		Mode:           ModeSentinel,
+		SentinelMaster: "mymaster",
+		SentinelAddrs:  []string{"sentinel-1:26379"},
+	}
+	if _, ok := ConnOpt(sentinel).(asynq.RedisFailoverClientOpt); !ok {
+		t.Error("Sentinel mode should use a failover client")
+	}
+
+	cluster := config.RedisConfig{Mode: ModeCluster, ClusterAddrs: []string{"redis-1:6379"}}
+	if _, ok := ConnOpt(cluster).(asynq.RedisClusterClientOpt); !ok {
+		t.Error("Cluster mode should use a cluster client")
+	}
+
+	standalone := config.RedisConfig{Addr: "localhost:6379"}
+	if _, ok := ConnOpt(standalone).(asynq.RedisClientOpt); !ok {
+		t.Error("Empty mode should use a standalone client")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   config.RedisConfig
+		valid bool
+	}{
+		{"standalone", config.RedisConfig{Addr: "localhost:6379"}, true},
+		{"sentinel without master", config.RedisConfig{Mode: ModeSentinel, SentinelAddrs: []string{"s:26379"}}, false},
+		{"cluster without nodes", config.RedisConfig{Mode: ModeCluster}, false},
+		{"unknown mode", config.RedisConfig{Mode: "ring", Addr: "localhost:6379"}, false},
+		{"missing CA file", config.RedisConfig{Addr: "localhost:6379", TLS: config.RedisTLSConfig{Enabled: true, CAFile: "/nonexistent/ca.pem"}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.cfg)
+			if tt.valid && err != nil {
+				t.Errorf("Expected valid config, got %v", err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidConfig) {
+				t.Errorf("Expected ErrInvalidConfig, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+// Package requestid carries the ID of the request being served in its
+// context, so work started on its behalf, such as background tasks, can be
+// tied back to it.
+package requestid
+
+import (
+	"context"
+
+	"github.com/labstack/echo/v4"
+)
+
+type contextKey struct{}
+
+// WithID returns a context carrying the request ID
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the request ID stored in ctx, or "" if there is none
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// Middleware stores the X-Request-ID assigned by echo's RequestID middleware
+// in the request context. It must run after that middleware.
+func Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if id := c.Response().Header().Get(echo.HeaderXRequestID); id != "" {
+				c.SetRequest(c.Request().WithContext(WithID(c.Request().Context(), id)))
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package requestid
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+func TestFromContext(t *testing.T) {
+	if id := FromContext(context.Background()); id != "" {
+		t.Errorf("Expected no request ID, got %q", id)
+	}
+	if id := FromContext(WithID(context.Background(), "req-1")); id != "req-1" {
+		t.Errorf("Expected req-1, got %q", id)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	e := echo.New()
+	e.Use(middleware.RequestID(), Middleware())
+
+	var got string
+	e.GET("/", func(c echo.Context) error {
+		got = FromContext(c.Request().Context())
+		return c.NoContent(http.StatusNoContent)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(echo.HeaderXRequestID, "incoming-id")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if got != "incoming-id" {
+		t.Errorf("Expected the request ID in the context, got %q", got)
+	}
+}
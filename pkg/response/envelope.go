@@ -0,0 +1,94 @@
+package response
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// EnvelopeHeader lets a client ask for unwrapped success payloads by
+// sending "none". Errors keep the standard structure either way.
+const EnvelopeHeader = "X-Response-Envelope"
+
+// EnvelopeNone is the EnvelopeHeader value requesting unwrapped payloads
+const EnvelopeNone = "none"
+
+// Pagination headers carry the metadata of unwrapped paginated responses
+const (
+	HeaderTotalCount = "X-Total-Count"
+	HeaderPage       = "X-Page"
+	HeaderPerPage    = "X-Per-Page"
+	HeaderTotalPages = "X-Total-Pages"
+	HeaderNextCursor = "X-Next-Cursor"
+	HeaderPrevCursor = "X-Prev-Cursor"
+)
+
+// HeaderSuccess is "false" on unwrapped responses whose envelope would have
+// carried success=false, such as multi-status responses with failed items
+const HeaderSuccess = "X-Success"
+
+// PaginationHeaders lists the headers set on unwrapped paginated responses,
+// for CORS exposure
+var PaginationHeaders = []string{
+	HeaderTotalCount, HeaderPage, HeaderPerPage, HeaderTotalPages,
+	HeaderNextCursor, HeaderPrevCursor,
+}
+
+const unwrappedKey = "response.unwrapped"
+
+// Unwrapped returns middleware that makes a route or group respond with
+// bare payloads instead of the success/data envelope, e.g. for consumers
+// that expect a plain array
+func Unwrapped() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			SetUnwrapped(c, true)
+			return next(c)
+		}
+	}
+}
+
+// SetUnwrapped overrides the envelope choice for the current request
+func SetUnwrapped(c echo.Context, unwrapped bool) {
+	c.Set(unwrappedKey, unwrapped)
+}
+
+// IsUnwrapped reports whether success responses for the request omit the
+// envelope. A route setting takes precedence over EnvelopeHeader.
+func IsUnwrapped(c echo.Context) bool {
+	if unwrapped, ok := c.Get(unwrappedKey).(bool); ok {
+		return unwrapped
+	}
+	return strings.EqualFold(c.Request().Header.Get(EnvelopeHeader), EnvelopeNone)
+}
+
+// writeSuccess writes a success response, wrapped unless the request opted out
+func writeSuccess(c echo.Context, statusCode int, resp Response) error {
+	c.Response().Header().Add(echo.HeaderVary, EnvelopeHeader)
+
+	if !IsUnwrapped(c) {
+		return c.JSON(statusCode, resp)
+	}
+
+	if !resp.Success {
+		c.Response().Header().Set(HeaderSuccess, "false")
+	}
+	if resp.Meta != nil {
+		header := c.Response().Header()
+		// Cursor pages have no page number or total
+		if resp.Meta.Page > 0 {
+			header.Set(HeaderTotalCount, strconv.FormatInt(resp.Meta.Total, 10))
+			header.Set(HeaderPage, strconv.Itoa(resp.Meta.Page))
+			header.Set(HeaderTotalPages, strconv.Itoa(resp.Meta.TotalPages))
+		}
+		header.Set(HeaderPerPage, strconv.Itoa(resp.Meta.PerPage))
+		if resp.Meta.NextCursor != "" {
+			header.Set(HeaderNextCursor, resp.Meta.NextCursor)
+		}
+		if resp.Meta.PrevCursor != "" {
+			header.Set(HeaderPrevCursor, resp.Meta.PrevCursor)
+		}
+	}
+	return c.JSON(statusCode, resp.Data)
+}
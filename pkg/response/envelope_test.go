@@ -0,0 +1,146 @@
+package response
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func newTestContext(header string) (echo.Context, *httptest.ResponseRecorder) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if header != "" {
+		req.Header.Set(EnvelopeHeader, header)
+	}
+	rec := httptest.NewRecorder()
+	return echo.New().NewContext(req, rec), rec
+}
+
+func TestSuccess_Envelope(t *testing.T) {
+	tests := []struct {
+		name      string
+		header    string
+		unwrapped *bool
+		want      string
+	}{
+		{"default", "", nil, `{"success":true,"data":[1,2]}`},
+		{"header", "none", nil, `[1,2]`},
+		{"header case", "None", nil, `[1,2]`},
+		{"route", "", ptr(true), `[1,2]`},
+		{"route overrides header", "none", ptr(false), `{"success":true,"data":[1,2]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, rec := newTestContext(tt.header)
+			if tt.unwrapped != nil {
+				SetUnwrapped(c, *tt.unwrapped)
+			}
+
+			if err := Success(c, []int{1, 2}); err != nil {
+				t.Fatalf("Success failed: %v", err)
+			}
+			if got := strings.TrimSpace(rec.Body.String()); got != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestPaginated_UnwrappedHeaders(t *testing.T) {
+	c, rec := newTestContext(EnvelopeNone)
+
+	if err := Paginated(c, []string{"a"}, 2, 10, 25); err != nil {
+		t.Fatalf("Paginated failed: %v", err)
+	}
+	if got := strings.TrimSpace(rec.Body.String()); got != `["a"]` {
+		t.Errorf("Expected bare array, got %s", got)
+	}
+
+	want := map[string]string{
+		HeaderTotalCount: "25",
+		HeaderPage:       "2",
+		HeaderPerPage:    "10",
+		HeaderTotalPages: "3",
+	}
+	for header, value := range want {
+		if got := rec.Header().Get(header); got != value {
+			t.Errorf("Expected %s %q, got %q", header, value, got)
+		}
+	}
+}
+
+func TestCursorPaginated(t *testing.T) {
+	c, rec := newTestContext("")
+	if err := CursorPaginated(c, []string{"a"}, 10, "next", ""); err != nil {
+		t.Fatalf("CursorPaginated failed: %v", err)
+	}
+	want := `{"success":true,"data":["a"],"meta":{"per_page":10,"next_cursor":"next"}}`
+	if got := strings.TrimSpace(rec.Body.String()); got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+
+	c, rec = newTestContext(EnvelopeNone)
+	if err := CursorPaginated(c, []string{"a"}, 10, "next", "prev"); err != nil {
+		t.Fatalf("CursorPaginated failed: %v", err)
+	}
+	if got := rec.Header().Get(HeaderNextCursor); got != "next" {
+		t.Errorf("Expected next cursor header, got %q", got)
+	}
+	if got := rec.Header().Get(HeaderPrevCursor); got != "prev" {
+		t.Errorf("Expected prev cursor header, got %q", got)
+	}
+	if got := rec.Header().Get(HeaderTotalCount); got != "" {
+		t.Errorf("Expected no total for a cursor page, got %q", got)
+	}
+}
+
+func TestError_KeepsEnvelope(t *testing.T) {
+	c, rec := newTestContext(EnvelopeNone)
+
+	if err := NotFound(c, "missing"); err != nil {
+		t.Fatalf("NotFound failed: %v", err)
+	}
+	want := `{"success":false,"error":{"code":"NOT_FOUND","message":"missing"}}`
+	if got := strings.TrimSpace(rec.Body.String()); got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestMultiStatus_ReportsFailure(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		success bool
+		want    string
+		flag    string
+	}{
+		{"wrapped failure", "", false, `{"success":false,"message":"1 of 2 failed","data":[1,2]}`, ""},
+		{"unwrapped failure", EnvelopeNone, false, `[1,2]`, "false"},
+		{"unwrapped success", EnvelopeNone, true, `[1,2]`, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, rec := newTestContext(tt.header)
+			if err := MultiStatus(c, tt.success, "1 of 2 failed", []int{1, 2}); err != nil {
+				t.Fatalf("MultiStatus failed: %v", err)
+			}
+			if rec.Code != http.StatusMultiStatus {
+				t.Errorf("Expected 207, got %d", rec.Code)
+			}
+			if got := strings.TrimSpace(rec.Body.String()); got != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, got)
+			}
+			if got := rec.Header().Get(HeaderSuccess); got != tt.flag {
+				t.Errorf("Expected %s %q, got %q", HeaderSuccess, tt.flag, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package response
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/pkg/authctx"
+)
+
+// VisibleTag is the struct tag restricting a response field to callers
+// whose role grants one of the listed permissions, e.g. `visible:"users:read"`
+// or `visible:"users:read,tickets:read"`. The field is left out for every
+// other caller, including anonymous ones, and for every caller until the
+// serializer is given a PermissionFunc.
+const VisibleTag = "visible"
+
+// PermissionFunc reports whether role grants permission, such as a wrapper
+// around rbac.Authorizer.Can
+type PermissionFunc func(role, permission string) bool
+
+const allFieldsKey = "response.all_fields"
+
+// AllFields returns middleware that makes a route respond with every field
+// regardless of VisibleTag, e.g. for routes returning the caller's own data
+func AllFields() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			SetAllFields(c, true)
+			return next(c)
+		}
+	}
+}
+
+// SetAllFields overrides whether role-restricted fields are included for
+// the current request
+func SetAllFields(c echo.Context, all bool) {
+	c.Set(allFieldsKey, all)
+}
+
+// projection decides which restricted fields a response includes
+type projection struct {
+	role string
+	can  PermissionFunc
+	all  bool
+}
+
+// projectionFor returns the projection for the caller of a request
+func projectionFor(c echo.Context, can PermissionFunc) projection {
+	if all, ok := c.Get(allFieldsKey).(bool); ok && all {
+		return projection{all: true}
+	}
+	role, _ := authctx.Role(c)
+	return projection{role: role, can: can}
+}
+
+// includes reports whether a struct field is visible to the caller
+func (p projection) includes(field reflect.StructField) bool {
+	perms, ok := field.Tag.Lookup(VisibleTag)
+	if !ok || p.all {
+		return true
+	}
+	if p.role == "" || p.can == nil {
+		return false
+	}
+	for _, perm := range strings.Split(perms, ",") {
+		if p.can(p.role, strings.TrimSpace(perm)) {
+			return true
+		}
+	}
+	return false
+}
+
+// restrictedTypes caches mayRestrict results by type
+var restrictedTypes sync.Map // map[reflect.Type]bool
+
+// mayRestrict reports whether values of t can contain fields tagged with
+// VisibleTag. Interfaces can hold anything, so they count.
+func mayRestrict(t reflect.Type) bool {
+	if cached, ok := restrictedTypes.Load(t); ok {
+		return cached.(bool)
+	}
+	result := typeMayRestrict(t, make(map[reflect.Type]bool))
+	restrictedTypes.Store(t, result)
+	return result
+}
+
+func typeMayRestrict(t reflect.Type, seen map[reflect.Type]bool) bool {
+	if seen[t] {
+		return false
+	}
+	seen[t] = true
+
+	switch t.Kind() {
+	case reflect.Interface:
+		return true
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		return typeMayRestrict(t.Elem(), seen)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() && !field.Anonymous {
+				continue
+			}
+			if _, ok := field.Tag.Lookup(VisibleTag); ok {
+				return true
+			}
+			if typeMayRestrict(field.Type, seen) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hasRestrictedFields reports whether v holds any field tagged with
+// VisibleTag. Only the parts of v whose type may hold one are walked.
+func hasRestrictedFields(v reflect.Value) bool {
+	if !v.IsValid() || !mayRestrict(v.Type()) {
+		return false
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return !v.IsNil() && hasRestrictedFields(v.Elem())
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() && !field.Anonymous {
+				continue
+			}
+			if _, ok := field.Tag.Lookup(VisibleTag); ok {
+				return true
+			}
+			if hasRestrictedFields(v.Field(i)) {
+				return true
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if hasRestrictedFields(v.Index(i)) {
+				return true
+			}
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			if hasRestrictedFields(iter.Value()) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package response
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/pkg/authctx"
+)
+
+type projectedUser struct {
+	Name      string `json:"name"`
+	Email     string `json:"email" visible:"users:read"`
+	LastLogin string `json:"last_login,omitempty" visible:"users:read, sessions:read"`
+}
+
+// rolePermissions grants the permissions listed per role
+func rolePermissions(role, permission string) bool {
+	granted := map[string][]string{
+		"admin":   {"users:read", "sessions:read"},
+		"support": {"sessions:read"},
+	}
+	return slices.Contains(granted[role], permission)
+}
+
+func TestSerializer_RoleProjection(t *testing.T) {
+	users := []projectedUser{{Name: "Ada", Email: "ada@example.com", LastLogin: "today"}}
+
+	tests := []struct {
+		name string
+		opts JSONOptions
+		can  PermissionFunc
+		role string
+		all  bool
+		want string
+	}{
+		{"anonymous", JSONOptions{}, rolePermissions, "", false, `{"data":[{"name":"Ada"}],"success":true}`},
+		{"user", JSONOptions{}, rolePermissions, "user", false, `{"data":[{"name":"Ada"}],"success":true}`},
+		{"support", JSONOptions{}, rolePermissions, "support", false, `{"data":[{"last_login":"today","name":"Ada"}],"success":true}`},
+		{"admin", JSONOptions{}, rolePermissions, "admin", false, `{"data":[{"email":"ada@example.com","last_login":"today","name":"Ada"}],"success":true}`},
+		{"no permissions", JSONOptions{}, nil, "admin", false, `{"data":[{"name":"Ada"}],"success":true}`},
+		{"all fields", JSONOptions{}, rolePermissions, "user", true, `{"success":true,"data":[{"name":"Ada","email":"ada@example.com","last_login":"today"}]}`},
+		{"camel case", JSONOptions{FieldCase: CamelCase}, rolePermissions, "support", false, `{"data":[{"lastLogin":"today","name":"Ada"}],"success":true}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			serializer := NewJSONSerializer(tt.opts)
+			serializer.SetPermissions(tt.can)
+			e.JSONSerializer = serializer
+			rec := httptest.NewRecorder()
+			c := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+			if tt.role != "" {
+				authctx.Set(c, &authctx.User{Role: tt.role})
+			}
+			if tt.all {
+				SetAllFields(c, true)
+			}
+
+			if err := Success(c, users); err != nil {
+				t.Fatalf("Success failed: %v", err)
+			}
+			if got := strings.TrimSpace(rec.Body.String()); got != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestHasRestrictedFields(t *testing.T) {
+	type plain struct {
+		Name string `json:"name"`
+	}
+
+	tests := []struct {
+		name  string
+		value interface{}
+		want  bool
+	}{
+		{"plain struct", plain{Name: "Ada"}, false},
+		{"tagged struct", &projectedUser{}, true},
+		{"empty slice", []projectedUser{}, false},
+		{"behind interface", Response{Data: []interface{}{plain{}, projectedUser{}}}, true},
+		{"nil interface", Response{}, false},
+		{"map values", map[string]*projectedUser{"a": {}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasRestrictedFields(reflect.ValueOf(tt.value)); got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
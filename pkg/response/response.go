@@ -28,11 +28,16 @@ type Meta struct {
 	PerPage    int   `json:"per_page,omitempty"`
 	Total      int64 `json:"total,omitempty"`
 	TotalPages int   `json:"total_pages,omitempty"`
+	// NextCursor and PrevCursor continue a cursor-paginated list; they are
+	// empty at either end
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
 }
 
-// Success returns a successful response
+// Success returns a successful response. Success helpers write only data,
+// without the envelope, when the request opts out (see IsUnwrapped).
 func Success(c echo.Context, data interface{}) error {
-	return c.JSON(http.StatusOK, Response{
+	return writeSuccess(c, http.StatusOK, Response{
 		Success: true,
 		Data:    data,
 	})
@@ -40,7 +45,7 @@ func Success(c echo.Context, data interface{}) error {
 
 // SuccessWithMessage returns a successful response with a message
 func SuccessWithMessage(c echo.Context, message string, data interface{}) error {
-	return c.JSON(http.StatusOK, Response{
+	return writeSuccess(c, http.StatusOK, Response{
 		Success: true,
 		Message: message,
 		Data:    data,
@@ -49,25 +54,52 @@ func SuccessWithMessage(c echo.Context, message string, data interface{}) error
 
 // Created returns a 201 created response
 func Created(c echo.Context, data interface{}) error {
-	return c.JSON(http.StatusCreated, Response{
+	return writeSuccess(c, http.StatusCreated, Response{
 		Success: true,
 		Data:    data,
 	})
 }
 
+// CreatedWithMessage returns a 201 created response with a message
+func CreatedWithMessage(c echo.Context, message string, data interface{}) error {
+	return writeSuccess(c, http.StatusCreated, Response{
+		Success: true,
+		Message: message,
+		Data:    data,
+	})
+}
+
+// Accepted returns a 202 accepted response, for work that finishes later
+func Accepted(c echo.Context, data interface{}) error {
+	return writeSuccess(c, http.StatusAccepted, Response{
+		Success: true,
+		Data:    data,
+	})
+}
+
+// AcceptedWithMessage returns a 202 accepted response with a message
+func AcceptedWithMessage(c echo.Context, message string, data interface{}) error {
+	return writeSuccess(c, http.StatusAccepted, Response{
+		Success: true,
+		Message: message,
+		Data:    data,
+	})
+}
+
 // NoContent returns a 204 no content response
 func NoContent(c echo.Context) error {
 	return c.NoContent(http.StatusNoContent)
 }
 
-// Paginated returns a paginated response
+// Paginated returns a paginated response. Unwrapped responses carry the
+// pagination metadata in X-Total-Count, X-Page, X-Per-Page and X-Total-Pages.
 func Paginated(c echo.Context, data interface{}, page, perPage int, total int64) error {
 	totalPages := int(total) / perPage
 	if int(total)%perPage > 0 {
 		totalPages++
 	}
 
-	return c.JSON(http.StatusOK, Response{
+	return writeSuccess(c, http.StatusOK, Response{
 		Success: true,
 		Data:    data,
 		Meta: &Meta{
@@ -79,6 +111,34 @@ func Paginated(c echo.Context, data interface{}, page, perPage int, total int64)
 	})
 }
 
+// CursorPaginated returns a page of a keyset-paginated list. Unlike
+// Paginated it reports no total, which is what makes keyset pages cheap on
+// large tables; next and prev are the cursors of the neighbouring pages, or
+// empty when there is none.
+func CursorPaginated(c echo.Context, data interface{}, perPage int, next, prev string) error {
+	return writeSuccess(c, http.StatusOK, Response{
+		Success: true,
+		Data:    data,
+		Meta: &Meta{
+			PerPage:    perPage,
+			NextCursor: next,
+			PrevCursor: prev,
+		},
+	})
+}
+
+// MultiStatus returns a 207 multi-status response for requests that apply
+// several operations at once. success should only be true if every
+// operation succeeded; per-item statuses belong in data. Unwrapped
+// responses report a false success in HeaderSuccess.
+func MultiStatus(c echo.Context, success bool, message string, data interface{}) error {
+	return writeSuccess(c, http.StatusMultiStatus, Response{
+		Success: success,
+		Message: message,
+		Data:    data,
+	})
+}
+
 // Error returns an error response
 func Error(c echo.Context, statusCode int, code, message string) error {
 	return c.JSON(statusCode, Response{
@@ -0,0 +1,438 @@
+package response
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Field casing options
+const (
+	SnakeCase = "snake"
+	CamelCase = "camel"
+)
+
+// Time format options
+const (
+	TimeRFC3339     = "rfc3339"
+	TimeEpochMillis = "epoch_millis"
+)
+
+// JSONOptions configures how responses are serialized
+type JSONOptions struct {
+	FieldCase  string // SnakeCase (default) or CamelCase
+	TimeFormat string // TimeRFC3339 (default) or TimeEpochMillis
+}
+
+// JSONSerializer implements echo.JSONSerializer with configurable field
+// casing and time formats. Struct json tags are written in snake_case; with
+// CamelCase, the field names from json tags are converted on the way out and
+// the request body keys naming fields are converted back before binding.
+// Map keys and the output of json.Marshaler values are opaque data and kept
+// as they are. Fields tagged with VisibleTag are left out
+// for callers without one of the listed permissions.
+type JSONSerializer struct {
+	opts     JSONOptions
+	can      PermissionFunc
+	fallback echo.DefaultJSONSerializer
+}
+
+// NewJSONSerializer creates a new JSON serializer
+func NewJSONSerializer(opts JSONOptions) *JSONSerializer {
+	if opts.FieldCase == "" {
+		opts.FieldCase = SnakeCase
+	}
+	if opts.TimeFormat == "" {
+		opts.TimeFormat = TimeRFC3339
+	}
+	return &JSONSerializer{opts: opts}
+}
+
+// SetPermissions sets how the permissions listed in VisibleTag are checked.
+// Call it before serving requests.
+func (s *JSONSerializer) SetPermissions(can PermissionFunc) {
+	s.can = can
+}
+
+// passthrough reports whether the default encoding already matches the options
+func (s *JSONSerializer) passthrough() bool {
+	return s.opts.FieldCase == SnakeCase && s.opts.TimeFormat == TimeRFC3339
+}
+
+// Serialize converts an interface into JSON and writes it to the response
+func (s *JSONSerializer) Serialize(c echo.Context, i interface{}, indent string) error {
+	p := projectionFor(c, s.can)
+	v := reflect.ValueOf(i)
+	if s.passthrough() && (p.all || !hasRestrictedFields(v)) {
+		return s.fallback.Serialize(c, i, indent)
+	}
+
+	value, err := s.normalize(v, p)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(c.Response())
+	if indent != "" {
+		enc.SetIndent("", indent)
+	}
+	return enc.Encode(value)
+}
+
+// Deserialize reads JSON from the request body and converts it into an interface
+func (s *JSONSerializer) Deserialize(c echo.Context, i interface{}) error {
+	if s.opts.FieldCase != CamelCase {
+		return s.fallback.Deserialize(c, i)
+	}
+
+	var raw interface{}
+	dec := json.NewDecoder(c.Request().Body)
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error()).SetInternal(err)
+	}
+
+	data, err := json.Marshal(fieldsToSnake(raw, reflect.TypeOf(i)))
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(data, i); err != nil {
+		if ute, ok := err.(*json.UnmarshalTypeError); ok {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Unmarshal type error: expected=%v, got=%v, field=%v, offset=%v", ute.Type, ute.Value, ute.Field, ute.Offset)).SetInternal(err)
+		}
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error()).SetInternal(err)
+	}
+	return nil
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+)
+
+// normalize converts a value into a generic JSON tree with keys and times
+// rewritten according to the serializer options and fields the projection
+// hides left out
+func (s *JSONSerializer) normalize(v reflect.Value, p projection) (interface{}, error) {
+	if !v.IsValid() {
+		return nil, nil
+	}
+
+	if v.Type() == timeType {
+		return s.formatTime(v.Interface().(time.Time)), nil
+	}
+
+	// Pointers are followed before looking for marshalers: *time.Time
+	// implements json.Marshaler too, and would skip formatTime
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil, nil
+		}
+		return s.normalize(v.Elem(), p)
+	}
+
+	if v.Type().Implements(jsonMarshalerType) || reflect.PointerTo(v.Type()).Implements(jsonMarshalerType) {
+		return s.fromMarshaler(v)
+	}
+	if v.Type().Implements(textMarshalerType) {
+		text, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return nil, err
+		}
+		return string(text), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		out := make(map[string]interface{})
+		if err := s.normalizeStruct(v, out, p); err != nil {
+			return nil, err
+		}
+		return out, nil
+
+	case reflect.Map:
+		if v.IsNil() {
+			return nil, nil
+		}
+		out := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			value, err := s.normalize(iter.Value(), p)
+			if err != nil {
+				return nil, err
+			}
+			out[fmt.Sprint(iter.Key().Interface())] = value
+		}
+		return out, nil
+
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil, nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			// []byte keeps its base64 encoding
+			return v.Interface(), nil
+		}
+		out := make([]interface{}, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			value, err := s.normalize(v.Index(i), p)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = value
+		}
+		return out, nil
+
+	default:
+		return v.Interface(), nil
+	}
+}
+
+// normalizeStruct writes the exported fields of a struct into out, honoring
+// json tags and the projection
+func (s *JSONSerializer) normalizeStruct(v reflect.Value, out map[string]interface{}, p projection) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() && !field.Anonymous {
+			continue
+		}
+
+		tag := field.Tag.Get("json")
+		if tag == "-" || !p.includes(field) {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		omitEmpty := strings.Contains(opts, "omitempty")
+		fv := v.Field(i)
+
+		// Embedded structs without a name are flattened, as encoding/json does
+		if field.Anonymous && name == "" {
+			ev := fv
+			if ev.Kind() == reflect.Ptr {
+				if ev.IsNil() {
+					continue
+				}
+				ev = ev.Elem()
+			}
+			if ev.Kind() == reflect.Struct {
+				if err := s.normalizeStruct(ev, out, p); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+
+		if omitEmpty && isEmptyValue(fv) {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		value, err := s.normalize(fv, p)
+		if err != nil {
+			return err
+		}
+		out[s.key(name)] = value
+	}
+	return nil
+}
+
+// fromMarshaler encodes a json.Marshaler and converts the result into a
+// generic tree, keeping its keys
+func (s *JSONSerializer) fromMarshaler(v reflect.Value) (interface{}, error) {
+	// Marshalers with pointer receivers are only called through a pointer
+	if v.CanAddr() && !v.Type().Implements(jsonMarshalerType) {
+		v = v.Addr()
+	}
+	data, err := json.Marshal(v.Interface())
+	if err != nil {
+		return nil, err
+	}
+
+	var raw interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+// formatTime formats a time according to the serializer options
+func (s *JSONSerializer) formatTime(t time.Time) interface{} {
+	if s.opts.TimeFormat == TimeEpochMillis {
+		return t.UnixMilli()
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+// key converts a field name according to the serializer options
+func (s *JSONSerializer) key(name string) string {
+	if s.opts.FieldCase == CamelCase {
+		return toCamel(name)
+	}
+	return name
+}
+
+// fieldsToSnake rewrites the keys of a generic JSON tree that name fields of
+// t from camelCase to their json tags. Keys that match no field, map keys
+// and values bound to interfaces or json.Unmarshalers are left as they are.
+func fieldsToSnake(v interface{}, t reflect.Type) interface{} {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t == timeType || reflect.PointerTo(t).Implements(jsonUnmarshalerType) {
+		return v
+	}
+
+	switch tv := v.(type) {
+	case map[string]interface{}:
+		switch t.Kind() {
+		case reflect.Struct:
+			fields := jsonFields(t)
+			out := make(map[string]interface{}, len(tv))
+			for k, value := range tv {
+				name := k
+				if _, ok := fields[k]; !ok {
+					if _, ok := fields[toSnake(k)]; ok {
+						name = toSnake(k)
+					}
+				}
+				out[name] = fieldsToSnake(value, fields[name])
+			}
+			return out
+		case reflect.Map:
+			for k, value := range tv {
+				tv[k] = fieldsToSnake(value, t.Elem())
+			}
+		}
+		return tv
+	case []interface{}:
+		if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+			for i, value := range tv {
+				tv[i] = fieldsToSnake(value, t.Elem())
+			}
+		}
+		return tv
+	default:
+		return v
+	}
+}
+
+// jsonFields returns the types of a struct's fields by json name, with
+// embedded structs flattened as encoding/json does
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" || (!field.IsExported() && !field.Anonymous) {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+
+		if field.Anonymous && name == "" {
+			ft := field.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				for embedded, et := range jsonFields(ft) {
+					if _, ok := fields[embedded]; !ok {
+						fields[embedded] = et
+					}
+				}
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+// toCamel converts snake_case to camelCase
+func toCamel(s string) string {
+	if !strings.Contains(s, "_") {
+		return s
+	}
+
+	var b strings.Builder
+	upper := false
+	for i, r := range s {
+		if r == '_' {
+			upper = i > 0
+			continue
+		}
+		if upper {
+			b.WriteRune(unicode.ToUpper(r))
+			upper = false
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// toSnake converts camelCase to snake_case, keeping acronyms together (userID -> user_id)
+func toSnake(s string) string {
+	runes := []rune(s)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				prev := runes[i-1]
+				nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+				if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+					b.WriteRune('_')
+				}
+			}
+			b.WriteRune(unicode.ToLower(r))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// isEmptyValue mirrors encoding/json's omitempty rules
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package response
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// pointerMarshaler implements json.Marshaler on its pointer only
+type pointerMarshaler struct{ value string }
+
+func (m *pointerMarshaler) MarshalJSON() ([]byte, error) {
+	return []byte(`{"marshaled_value":"` + m.value + `"}`), nil
+}
+
+type timedRecord struct {
+	CreatedAt time.Time         `json:"created_at"`
+	DeletedAt *time.Time        `json:"deleted_at"`
+	ExpiresAt *time.Time        `json:"expires_at,omitempty"`
+	Custom    *pointerMarshaler `json:"custom"`
+}
+
+func TestSerializer_Normalize(t *testing.T) {
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	record := timedRecord{CreatedAt: at, DeletedAt: &at, Custom: &pointerMarshaler{value: "x"}}
+
+	tests := []struct {
+		name string
+		opts JSONOptions
+		want string
+	}{
+		{"epoch millis", JSONOptions{TimeFormat: TimeEpochMillis}, `{"data":{"created_at":1767323045000,"custom":{"marshaled_value":"x"},"deleted_at":1767323045000},"success":true}`},
+		{"camel case", JSONOptions{FieldCase: CamelCase}, `{"data":{"createdAt":"2026-01-02T03:04:05Z","custom":{"marshaled_value":"x"},"deletedAt":"2026-01-02T03:04:05Z"},"success":true}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			e.JSONSerializer = NewJSONSerializer(tt.opts)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+
+			if err := Success(c, &record); err != nil {
+				t.Fatalf("Success failed: %v", err)
+			}
+			if got := strings.TrimSpace(rec.Body.String()); got != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+// opaqueRecord carries client data whose keys belong to the client
+type opaqueRecord struct {
+	EventType string          `json:"event_type"`
+	Payload   json.RawMessage `json:"payload"`
+	Details   map[string]any  `json:"details"`
+}
+
+func TestSerializer_CamelCaseKeepsOpaqueKeys(t *testing.T) {
+	e := echo.New()
+	e.JSONSerializer = NewJSONSerializer(JSONOptions{FieldCase: CamelCase})
+	body := `{"eventType":"order.created","payload":{"order_id":"o1","line_items":[{"unit_price":5}]},"details":{"ip_address":"203.0.113.7","nested_map":{"max_value":1}}}`
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	c := e.NewContext(req, httptest.NewRecorder())
+	var record opaqueRecord
+	if err := c.Bind(&record); err != nil {
+		t.Fatalf("Bind failed: %v", err)
+	}
+	if record.EventType != "order.created" {
+		t.Errorf("Expected the field key to be converted, got %+v", record)
+	}
+	if !strings.Contains(string(record.Payload), `"order_id"`) || !strings.Contains(string(record.Payload), `"unit_price"`) {
+		t.Errorf("Expected the raw payload to keep its keys, got %s", record.Payload)
+	}
+	if _, ok := record.Details["ip_address"]; !ok {
+		t.Errorf("Expected the map to keep its keys, got %v", record.Details)
+	}
+	if nested, _ := record.Details["nested_map"].(map[string]any); nested["max_value"] == nil {
+		t.Errorf("Expected nested map keys to be kept, got %v", record.Details)
+	}
+
+	rec := httptest.NewRecorder()
+	if err := Success(e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec), &record); err != nil {
+		t.Fatalf("Success failed: %v", err)
+	}
+	var out struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := out.Data["eventType"]; !ok {
+		t.Errorf("Expected the field name in camelCase, got %s", rec.Body.String())
+	}
+	payload, details := string(out.Data["payload"]), string(out.Data["details"])
+	for _, key := range []string{`"order_id"`, `"line_items"`, `"unit_price"`} {
+		if !strings.Contains(payload, key) {
+			t.Errorf("Expected the raw payload to round-trip %s, got %s", key, payload)
+		}
+	}
+	for _, key := range []string{`"ip_address"`, `"nested_map"`, `"max_value"`} {
+		if !strings.Contains(details, key) {
+			t.Errorf("Expected the map to round-trip %s, got %s", key, details)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+// Package sandbox marks work done in sandbox mode, where API consumers
+// integrate against production infrastructure without touching live data:
+// requests are served from a separate tenant, emails and notifications are
+// logged instead of sent, and metrics and traces are labelled.
+package sandbox
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Header is the request header asking for sandbox mode
+const Header = "X-Sandbox"
+
+// Attribute labels the spans and metrics of work in sandbox mode
+var Attribute = attribute.Bool("sandbox", true)
+
+type contextKey struct{}
+
+// With returns a context in sandbox mode, labelling its span
+func With(ctx context.Context) context.Context {
+	trace.SpanFromContext(ctx).SetAttributes(Attribute)
+	return context.WithValue(ctx, contextKey{}, true)
+}
+
+// Enabled reports whether ctx is in sandbox mode
+func Enabled(ctx context.Context) bool {
+	enabled, _ := ctx.Value(contextKey{}).(bool)
+	return enabled
+}
+
+// Requested reports whether req asks for sandbox mode with a true Header
+func Requested(req *http.Request) bool {
+	enabled, _ := strconv.ParseBool(req.Header.Get(Header))
+	return enabled
+}
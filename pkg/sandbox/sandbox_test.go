@@ -0,0 +1,26 @@
+package sandbox
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEnabled(t *testing.T) {
+	if Enabled(context.Background()) {
+		t.Error("Expected a plain context not to be in sandbox mode")
+	}
+	if !Enabled(With(context.Background())) {
+		t.Error("Expected sandbox mode")
+	}
+}
+
+func TestRequested(t *testing.T) {
+	for value, want := range map[string]bool{"": false, "true": true, "1": true, "false": false, "yes": false} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set(Header, value)
+		if got := Requested(req); got != want {
+			t.Errorf("Requested with %q = %v, want %v", value, got, want)
+		}
+	}
+}
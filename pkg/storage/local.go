@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/pkg/clock"
+	"github.com/pixperk/goiler/pkg/response"
+)
+
+// LocalStore stores objects as files under a directory. Its presigned URLs
+// point at Handler, which checks their signature and expiry.
+type LocalStore struct {
+	dir       string
+	publicURL string
+	key       []byte
+	clock     clock.Clock
+}
+
+// NewLocalStore creates a store writing under dir, whose files are served
+// by Handler mounted at publicURL. signingKey signs the read URLs.
+func NewLocalStore(dir, publicURL string, signingKey []byte) *LocalStore {
+	return &LocalStore{
+		dir:       dir,
+		publicURL: strings.TrimSuffix(publicURL, "/"),
+		key:       signingKey,
+		clock:     clock.New(),
+	}
+}
+
+// SetClock sets the clock used to sign and check URL expiry
+func (s *LocalStore) SetClock(c clock.Clock) {
+	s.clock = c
+}
+
+func (s *LocalStore) path(key string) (string, error) {
+	if err := ValidateKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
+
+// Put writes the object to a temporary file and renames it into place, so
+// readers never see a partial file
+func (s *LocalStore) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Get opens the object's file
+func (s *LocalStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+// Delete removes the object's file
+func (s *LocalStore) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// PresignGet returns a URL under the public URL carrying the expiry and its
+// signature
+func (s *LocalStore) PresignGet(ctx context.Context, key string, expires time.Duration) (string, error) {
+	if err := ValidateKey(key); err != nil {
+		return "", err
+	}
+	expiresAt := strconv.FormatInt(s.clock.Now().Add(expires).Unix(), 10)
+	query := url.Values{
+		"expires":   {expiresAt},
+		"signature": {s.sign(key, expiresAt)},
+	}
+	return s.publicURL + "/" + escapePath(key) + "?" + query.Encode(), nil
+}
+
+func (s *LocalStore) sign(key, expiresAt string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(key + "\n" + expiresAt))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// RoutePath is the path of the public URL, where Handler should be mounted
+func (s *LocalStore) RoutePath() string {
+	u, err := url.Parse(s.publicURL)
+	if err != nil {
+		return ""
+	}
+	return u.Path
+}
+
+// Handler serves the files PresignGet links to. Mount it on a wildcard
+// route such as GET /files/*, without authentication: the signature is the
+// credential.
+func (s *LocalStore) Handler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		key, err := url.PathUnescape(c.Param("*"))
+		if err != nil || ValidateKey(key) != nil {
+			return response.NotFound(c, "File not found")
+		}
+
+		expiresAt := c.QueryParam("expires")
+		expiry, err := strconv.ParseInt(expiresAt, 10, 64)
+		if err != nil || !hmac.Equal([]byte(c.QueryParam("signature")), []byte(s.sign(key, expiresAt))) {
+			return response.Forbidden(c, "Invalid file signature")
+		}
+		if s.clock.Now().Unix() >= expiry {
+			return response.Forbidden(c, "File link has expired")
+		}
+
+		f, err := s.Get(c.Request().Context(), key)
+		if errors.Is(err, ErrNotFound) {
+			return response.NotFound(c, "File not found")
+		}
+		if err != nil {
+			return response.InternalError(c, "Failed to read file")
+		}
+		defer f.Close()
+
+		file := f.(*os.File)
+		info, err := file.Stat()
+		if err != nil {
+			return response.InternalError(c, "Failed to read file")
+		}
+		c.Response().Header().Set(echo.HeaderCacheControl, "private, max-age="+strconv.FormatInt(max(expiry-s.clock.Now().Unix(), 0), 10))
+		http.ServeContent(c.Response(), c.Request(), info.Name(), info.ModTime(), file)
+		return nil
+	}
+}
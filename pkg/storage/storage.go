@@ -0,0 +1,93 @@
+// Package storage stores files such as avatars in an object store: the
+// local disk, S3 or any S3-compatible store, or Google Cloud Storage. Files
+// are read through presigned URLs, so they never pass through the API.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pixperk/goiler/internal/config"
+)
+
+// Backends
+const (
+	BackendLocal = "local"
+	BackendS3    = "s3"
+	BackendGCS   = "gcs"
+)
+
+var (
+	// ErrNotFound is returned for keys with no object
+	ErrNotFound = errors.New("object not found")
+	// ErrInvalidKey is returned for keys that are empty, absolute or climb
+	// out of the store with ..
+	ErrInvalidKey = errors.New("invalid object key")
+	// ErrInvalidConfig is returned by New for unusable settings
+	ErrInvalidConfig = errors.New("invalid storage config")
+)
+
+// DefaultURLExpiry is how long presigned URLs are valid unless configured
+// otherwise
+const DefaultURLExpiry = 15 * time.Minute
+
+// Store stores objects by key. Keys are slash-separated paths such as
+// avatars/<user>/<id>.png.
+type Store interface {
+	// Put stores an object of size bytes, replacing any with the same key
+	Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error
+	// Get opens an object for reading
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes an object; deleting a missing object isn't an error
+	Delete(ctx context.Context, key string) error
+	// PresignGet returns a URL anyone can read the object from until it
+	// expires
+	PresignGet(ctx context.Context, key string, expires time.Duration) (string, error)
+}
+
+// New creates the store selected by cfg.Backend
+func New(cfg config.StorageConfig) (Store, error) {
+	switch cfg.Backend {
+	case "", BackendLocal:
+		if cfg.LocalDir == "" || cfg.PublicURL == "" || cfg.SigningKey == "" {
+			return nil, fmt.Errorf("%w: the local backend requires STORAGE_LOCAL_DIR, STORAGE_PUBLIC_URL and STORAGE_SIGNING_KEY", ErrInvalidConfig)
+		}
+		return NewLocalStore(cfg.LocalDir, cfg.PublicURL, []byte(cfg.SigningKey)), nil
+	case BackendS3, BackendGCS:
+		s3cfg := S3Config{
+			Endpoint:        cfg.Endpoint,
+			Region:          cfg.Region,
+			Bucket:          cfg.Bucket,
+			AccessKeyID:     cfg.AccessKeyID,
+			SecretAccessKey: cfg.SecretAccessKey,
+			PathStyle:       cfg.PathStyle,
+		}
+		if cfg.Backend == BackendGCS {
+			s3cfg = gcsConfig(s3cfg)
+		}
+		if s3cfg.Bucket == "" || s3cfg.AccessKeyID == "" || s3cfg.SecretAccessKey == "" {
+			return nil, fmt.Errorf("%w: the %s backend requires STORAGE_BUCKET, STORAGE_ACCESS_KEY_ID and STORAGE_SECRET_ACCESS_KEY", ErrInvalidConfig, cfg.Backend)
+		}
+		return NewS3Store(s3cfg)
+	default:
+		return nil, fmt.Errorf("%w: unknown backend %q", ErrInvalidConfig, cfg.Backend)
+	}
+}
+
+// ValidateKey checks that key is a relative slash-separated path without
+// empty, . or .. segments
+func ValidateKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return ErrInvalidKey
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return ErrInvalidKey
+		}
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package validator
 
 import (
 	"reflect"
+	"regexp"
 	"strings"
 
 	"github.com/go-playground/validator/v10"
@@ -27,6 +28,7 @@ func New() *CustomValidator {
 
 	// Register custom validations here
 	_ = v.RegisterValidation("password", validatePassword)
+	_ = v.RegisterValidation("slug", validateSlug)
 
 	return &CustomValidator{validator: v}
 }
@@ -71,11 +73,21 @@ func formatErrorMessage(e validator.FieldError) string {
 		return "Must be a valid URL"
 	case "oneof":
 		return "Must be one of: " + e.Param()
+	case "slug":
+		return "Must be lowercase letters, digits and inner hyphens"
 	default:
 		return "Invalid value"
 	}
 }
 
+// slugPattern matches DNS-label-like identifiers such as "acme-corp"
+var slugPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// validateSlug validates identifiers used in URLs and subdomains
+func validateSlug(fl validator.FieldLevel) bool {
+	return slugPattern.MatchString(fl.Field().String())
+}
+
 // validatePassword validates password strength
 func validatePassword(fl validator.FieldLevel) bool {
 	password := fl.Field().String()
@@ -0,0 +1,203 @@
+// Package waitfor holds a binary's startup until the services it depends on
+// are reachable, in place of wait-for-it scripts in docker-compose and
+// Kubernetes initContainers. A dependency is ready once its host resolves
+// and a TCP connection to it succeeds; nothing is spoken over the
+// connection.
+package waitfor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/pixperk/goiler/internal/config"
+	"github.com/pixperk/goiler/pkg/dbconn"
+	"github.com/pixperk/goiler/pkg/redisconn"
+)
+
+// Dependency names WAIT_FOR resolves from the config
+const (
+	Postgres = "postgres"
+	Redis    = "redis"
+)
+
+var ErrInvalidTarget = errors.New("invalid WAIT_FOR entry")
+
+// Backoff between attempts on one target. It doubles from minDelay up to
+// maxDelay and is jittered by up to half.
+const (
+	minDelay    = 200 * time.Millisecond
+	maxDelay    = 5 * time.Second
+	dialTimeout = 2 * time.Second
+)
+
+// Target is a dependency to wait for. It is ready when any of its addresses
+// accepts a connection, so a Sentinel or cluster deployment is ready once
+// one node is up.
+type Target struct {
+	Name  string
+	Addrs []string
+}
+
+// Targets resolves the WAIT_FOR entries against the config
+func Targets(cfg *config.Config) ([]Target, error) {
+	targets := make([]Target, 0, len(cfg.App.WaitFor))
+	for _, entry := range cfg.App.WaitFor {
+		switch entry {
+		case Postgres:
+			addrs, err := postgresAddrs(cfg.Database)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s: %v", ErrInvalidTarget, entry, err)
+			}
+			targets = append(targets, Target{Name: entry, Addrs: addrs})
+		case Redis:
+			targets = append(targets, Target{Name: entry, Addrs: redisAddrs(cfg.Redis)})
+		default:
+			if _, port, err := net.SplitHostPort(entry); err != nil || port == "" {
+				return nil, fmt.Errorf("%w: %q is neither postgres, redis nor a host:port", ErrInvalidTarget, entry)
+			}
+			targets = append(targets, Target{Name: entry, Addrs: []string{entry}})
+		}
+	}
+	return targets, nil
+}
+
+func postgresAddrs(cfg config.DatabaseConfig) ([]string, error) {
+	poolConfig, err := dbconn.PoolConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	conn := poolConfig.ConnConfig
+	addrs := []string{net.JoinHostPort(conn.Host, strconv.Itoa(int(conn.Port)))}
+	for _, fallback := range conn.Fallbacks {
+		addrs = append(addrs, net.JoinHostPort(fallback.Host, strconv.Itoa(int(fallback.Port))))
+	}
+	return addrs, nil
+}
+
+func redisAddrs(cfg config.RedisConfig) []string {
+	switch cfg.Mode {
+	case redisconn.ModeSentinel:
+		return cfg.SentinelAddrs
+	case redisconn.ModeCluster:
+		return cfg.ClusterAddrs
+	}
+	return []string{cfg.Addr}
+}
+
+// Dependencies waits for the WAIT_FOR dependencies, giving up after
+// WAIT_FOR_TIMEOUT. It returns at once when WAIT_FOR is empty.
+func Dependencies(ctx context.Context, cfg *config.Config, logger *slog.Logger) error {
+	targets, err := Targets(cfg)
+	if err != nil || len(targets) == 0 {
+		return err
+	}
+	if cfg.App.WaitForTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.App.WaitForTimeout)
+		defer cancel()
+	}
+	return Wait(ctx, targets, logger)
+}
+
+// Wait blocks until every target is ready or ctx ends. Targets are waited
+// for concurrently, so the slowest one sets the wait.
+func Wait(ctx context.Context, targets []Target, logger *slog.Logger) error {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	errs := make(chan error, len(targets))
+	for _, target := range targets {
+		go func() {
+			errs <- waitTarget(ctx, target, logger)
+		}()
+	}
+
+	var failed []error
+	for range targets {
+		if err := <-errs; err != nil {
+			failed = append(failed, err)
+		}
+	}
+	return errors.Join(failed...)
+}
+
+func waitTarget(ctx context.Context, target Target, logger *slog.Logger) error {
+	start := time.Now()
+	delay := minDelay
+	for attempt := 1; ; attempt++ {
+		lastErr := reachAny(ctx, target.Addrs)
+		if lastErr == nil {
+			logger.InfoContext(ctx, "dependency ready",
+				slog.String("dependency", target.Name),
+				slog.Int("attempts", attempt),
+				slog.Duration("waited", time.Since(start)),
+			)
+			return nil
+		}
+		logger.InfoContext(ctx, "waiting for dependency",
+			slog.String("dependency", target.Name),
+			slog.Int("attempt", attempt),
+			slog.String("error", lastErr.Error()),
+		)
+
+		timer := time.NewTimer(jitter(delay))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%s not ready after %s: %w", target.Name, time.Since(start).Round(time.Millisecond), lastErr)
+		case <-timer.C:
+		}
+		delay = min(delay*2, maxDelay)
+	}
+}
+
+// reachAny returns nil once one of addrs resolves and accepts a connection,
+// or the last error
+func reachAny(ctx context.Context, addrs []string) error {
+	var lastErr error
+	for _, addr := range addrs {
+		if lastErr = reach(ctx, addr); lastErr == nil {
+			return nil
+		}
+	}
+	if lastErr == nil {
+		lastErr = errors.New("no addresses configured")
+	}
+	return lastErr
+}
+
+// reach resolves addr's host and opens a TCP connection to it. Resolution
+// is checked separately so the logs tell a missing DNS record from a
+// closed port.
+func reach(ctx context.Context, addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if _, err := net.DefaultResolver.LookupHost(ctx, host); err != nil {
+		return fmt.Errorf("resolve %s: %w", host, err)
+	}
+
+	dialCtx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(dialCtx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// jitter spreads d over [d/2, d] so replicas starting together don't probe
+// in step
+func jitter(d time.Duration) time.Duration {
+	half := d / 2
+	return half + rand.N(d-half+1)
+}
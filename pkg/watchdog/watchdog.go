@@ -0,0 +1,292 @@
+// Package watchdog watches the process for leaks that otherwise only show
+// up as an OOM: goroutine counts that keep growing and in-memory queues
+// that stop draining. Breaches are logged with a sample of the busiest
+// goroutine stacks, counted in metrics and passed to alert handlers.
+package watchdog
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pixperk/goiler/pkg/clock"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Alert kinds
+const (
+	KindGoroutines      = "goroutines"
+	KindGoroutineGrowth = "goroutine_growth"
+	KindQueueBacklog    = "queue_backlog"
+)
+
+// TopicAlert is the pubsub topic alerts are conventionally published to
+const TopicAlert = "watchdog.alert"
+
+// Config holds the watchdog thresholds; zero values disable a check
+type Config struct {
+	// Interval is how often the checks run
+	Interval time.Duration
+	// MaxGoroutines alerts when the goroutine count exceeds it
+	MaxGoroutines int
+	// GrowthChecks alerts when the goroutine count rose on this many
+	// consecutive checks
+	GrowthChecks int
+	// MaxQueueFill alerts when a watched queue is fuller than this fraction
+	// of its capacity
+	MaxQueueFill float64
+	// StackSamples is how many distinct goroutine stacks, busiest first,
+	// are logged with a goroutine alert
+	StackSamples int
+}
+
+// Alert describes a breached threshold
+type Alert struct {
+	Kind  string    `json:"kind"`
+	Name  string    `json:"name"`
+	Value int       `json:"value"`
+	Limit int       `json:"limit"`
+	Time  time.Time `json:"time"`
+}
+
+// QueueFunc reports how many items a queue holds and how many it can hold
+type QueueFunc func() (queued, capacity int)
+
+// Watchdog runs the checks. Each breach alerts once when it starts and logs
+// when it clears.
+type Watchdog struct {
+	config Config
+	logger *slog.Logger
+	clock  clock.Clock
+
+	// goroutines counts goroutines; replaced in tests
+	goroutines func() int
+
+	mu       sync.Mutex
+	queues   map[string]QueueFunc
+	handlers []func(Alert)
+	breached map[string]bool
+	last     int
+	rising   int
+
+	alerts metric.Int64Counter
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New creates a watchdog. A nil logger logs to slog.Default().
+func New(cfg Config, logger *slog.Logger, c clock.Clock) *Watchdog {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	w := &Watchdog{
+		config:     cfg,
+		logger:     logger,
+		clock:      clock.OrReal(c),
+		goroutines: runtime.NumGoroutine,
+		queues:     make(map[string]QueueFunc),
+		breached:   make(map[string]bool),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	w.initMetrics()
+	return w
+}
+
+// initMetrics registers watchdog metrics with the global meter provider
+func (w *Watchdog) initMetrics() {
+	meter := otel.Meter("goiler/watchdog")
+
+	w.alerts, _ = meter.Int64Counter(
+		"watchdog_alerts_total",
+		metric.WithDescription("Total number of thresholds breached, by kind and name"),
+		metric.WithUnit("1"),
+	)
+
+	meter.Int64ObservableGauge(
+		"watchdog_queue_backlog",
+		metric.WithDescription("Number of items waiting in a watched in-memory queue"),
+		metric.WithUnit("1"),
+		metric.WithInt64Callback(func(ctx context.Context, observer metric.Int64Observer) error {
+			w.mu.Lock()
+			queues := make(map[string]QueueFunc, len(w.queues))
+			for name, fn := range w.queues {
+				queues[name] = fn
+			}
+			w.mu.Unlock()
+
+			for name, fn := range queues {
+				queued, _ := fn()
+				observer.Observe(int64(queued), metric.WithAttributes(attribute.String("queue", name)))
+			}
+			return nil
+		}),
+	)
+}
+
+// WatchQueue adds a queue to check against MaxQueueFill, such as a
+// channel's len and cap
+func (w *Watchdog) WatchQueue(name string, fn QueueFunc) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.queues[name] = fn
+}
+
+// OnAlert registers fn to be called with each alert. fn is called from the
+// watchdog's goroutine and must not block.
+func (w *Watchdog) OnAlert(fn func(Alert)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.handlers = append(w.handlers, fn)
+}
+
+// Start runs the checks every interval until Close is called
+func (w *Watchdog) Start() {
+	go func() {
+		defer close(w.done)
+
+		ticker := time.NewTicker(w.config.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				w.Check()
+			case <-w.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the checks
+func (w *Watchdog) Close() {
+	close(w.stop)
+	<-w.done
+}
+
+// Check runs every check once and returns the alerts raised. Breaches that
+// were already alerted on aren't raised again until they clear.
+func (w *Watchdog) Check() []Alert {
+	w.mu.Lock()
+	now := w.clock.Now()
+	count := w.goroutines()
+
+	var alerts []Alert
+	raise := func(alert Alert, breached bool) {
+		key := alert.Kind + ":" + alert.Name
+		switch {
+		case breached && !w.breached[key]:
+			alert.Time = now
+			alerts = append(alerts, alert)
+		case !breached && w.breached[key]:
+			w.logger.Info("watchdog threshold cleared",
+				slog.String("kind", alert.Kind),
+				slog.String("name", alert.Name),
+				slog.Int("value", alert.Value),
+			)
+		}
+		w.breached[key] = breached
+	}
+
+	if limit := w.config.MaxGoroutines; limit > 0 {
+		raise(Alert{Kind: KindGoroutines, Name: "runtime", Value: count, Limit: limit}, count > limit)
+	}
+
+	if w.last > 0 && count > w.last {
+		w.rising++
+	} else {
+		w.rising = 0
+	}
+	w.last = count
+	if checks := w.config.GrowthChecks; checks > 0 {
+		raise(Alert{Kind: KindGoroutineGrowth, Name: "runtime", Value: count, Limit: checks}, w.rising >= checks)
+	}
+
+	if fill := w.config.MaxQueueFill; fill > 0 {
+		names := make([]string, 0, len(w.queues))
+		for name := range w.queues {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			queued, capacity := w.queues[name]()
+			if capacity <= 0 {
+				continue
+			}
+			limit := int(fill * float64(capacity))
+			raise(Alert{Kind: KindQueueBacklog, Name: name, Value: queued, Limit: limit}, queued > limit)
+		}
+	}
+
+	handlers := w.handlers
+	w.mu.Unlock()
+
+	for _, alert := range alerts {
+		w.report(alert, handlers)
+	}
+	return alerts
+}
+
+// report logs an alert, with stack samples for goroutine alerts, and hands
+// it to the alert handlers
+func (w *Watchdog) report(alert Alert, handlers []func(Alert)) {
+	attrs := []any{
+		slog.String("kind", alert.Kind),
+		slog.String("name", alert.Name),
+		slog.Int("value", alert.Value),
+		slog.Int("limit", alert.Limit),
+		slog.Int("goroutines", w.goroutines()),
+	}
+	if alert.Kind != KindQueueBacklog && w.config.StackSamples > 0 {
+		attrs = append(attrs, slog.Any("stacks", StackSamples(w.config.StackSamples)))
+	}
+	w.logger.Error("watchdog threshold breached", attrs...)
+
+	w.alerts.Add(context.Background(), 1, metric.WithAttributes(
+		attribute.String("kind", alert.Kind),
+		attribute.String("name", alert.Name),
+	))
+	for _, fn := range handlers {
+		fn(alert)
+	}
+}
+
+// StackSamples returns up to n distinct goroutine stacks, those shared by
+// the most goroutines first, each prefixed with its goroutine count
+func StackSamples(n int) []string {
+	var buf bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&buf, 1); err != nil {
+		return []string{fmt.Sprintf("failed to read goroutine profile: %v", err)}
+	}
+
+	// The profile starts with a summary line, then lists stacks by count,
+	// separated by blank lines
+	blocks := bytes.Split(buf.Bytes(), []byte("\n\n"))
+	if len(blocks) > 0 {
+		if header, rest, ok := bytes.Cut(blocks[0], []byte("\n")); ok && bytes.HasPrefix(header, []byte("goroutine profile:")) {
+			blocks[0] = rest
+		}
+	}
+
+	samples := make([]string, 0, n)
+	for _, block := range blocks {
+		block = bytes.TrimSpace(block)
+		if len(block) == 0 {
+			continue
+		}
+		if len(samples) == n {
+			break
+		}
+		samples = append(samples, string(block))
+	}
+	return samples
+}
@@ -0,0 +1,114 @@
+package watchdog
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pixperk/goiler/pkg/clock"
+)
+
+func newTestWatchdog(cfg Config, count *int) *Watchdog {
+	w := New(cfg, nil, clock.NewFrozen(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)))
+	w.goroutines = func() int { return *count }
+	return w
+}
+
+func TestWatchdog_MaxGoroutines(t *testing.T) {
+	count := 50
+	w := newTestWatchdog(Config{MaxGoroutines: 100}, &count)
+
+	var handled []Alert
+	w.OnAlert(func(alert Alert) { handled = append(handled, alert) })
+
+	if alerts := w.Check(); len(alerts) != 0 {
+		t.Fatalf("Expected no alerts below the limit, got %v", alerts)
+	}
+
+	count = 150
+	alerts := w.Check()
+	if len(alerts) != 1 || alerts[0].Kind != KindGoroutines || alerts[0].Value != 150 || alerts[0].Limit != 100 {
+		t.Fatalf("Expected a goroutine alert, got %v", alerts)
+	}
+	if alerts[0].Time.IsZero() {
+		t.Errorf("Expected the alert to be stamped")
+	}
+	if len(handled) != 1 {
+		t.Errorf("Expected the handler to get the alert, got %d", len(handled))
+	}
+
+	// Still breached: no repeat until it clears
+	if alerts := w.Check(); len(alerts) != 0 {
+		t.Errorf("Expected an ongoing breach not to alert again, got %v", alerts)
+	}
+	count = 50
+	w.Check()
+	count = 150
+	if alerts := w.Check(); len(alerts) != 1 {
+		t.Errorf("Expected a new breach to alert, got %v", alerts)
+	}
+}
+
+func TestWatchdog_GoroutineGrowth(t *testing.T) {
+	count := 10
+	w := newTestWatchdog(Config{GrowthChecks: 3}, &count)
+
+	w.Check()
+	for i := 0; i < 2; i++ {
+		count += 5
+		if alerts := w.Check(); len(alerts) != 0 {
+			t.Fatalf("Check %d: expected no alert before the growth limit, got %v", i+1, alerts)
+		}
+	}
+
+	// A dip resets the streak
+	count--
+	w.Check()
+	for i := 0; i < 2; i++ {
+		count += 5
+		w.Check()
+	}
+
+	count += 5
+	alerts := w.Check()
+	if len(alerts) != 1 || alerts[0].Kind != KindGoroutineGrowth {
+		t.Fatalf("Expected a growth alert after 3 rising checks, got %v", alerts)
+	}
+}
+
+func TestWatchdog_QueueBacklog(t *testing.T) {
+	count := 1
+	w := newTestWatchdog(Config{MaxQueueFill: 0.8}, &count)
+
+	queued := 80
+	w.WatchQueue("broadcast", func() (int, int) { return queued, 100 })
+	w.WatchQueue("unbounded", func() (int, int) { return 1000, 0 })
+
+	if alerts := w.Check(); len(alerts) != 0 {
+		t.Fatalf("Expected no alert at the fill limit, got %v", alerts)
+	}
+
+	queued = 81
+	alerts := w.Check()
+	if len(alerts) != 1 || alerts[0].Kind != KindQueueBacklog || alerts[0].Name != "broadcast" || alerts[0].Limit != 80 {
+		t.Fatalf("Expected a backlog alert for the broadcast queue, got %v", alerts)
+	}
+}
+
+func TestStackSamples(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	for i := 0; i < 3; i++ {
+		go func() { <-block }()
+	}
+
+	samples := StackSamples(2)
+	if len(samples) == 0 || len(samples) > 2 {
+		t.Fatalf("Expected 1-2 samples, got %d", len(samples))
+	}
+	for _, sample := range samples {
+		if strings.HasPrefix(sample, "goroutine profile:") {
+			t.Errorf("Expected the profile header to be dropped, got %q", sample)
+		}
+	}
+}
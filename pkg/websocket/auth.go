@@ -0,0 +1,164 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/pkg/authctx"
+)
+
+// Message types of the authenticate handshake
+const (
+	// MessageTypeAuthenticate is sent by anonymous clients with
+	// {"token": "..."} to authenticate the connection
+	MessageTypeAuthenticate = "authenticate"
+	// MessageTypeAuthenticated confirms the handshake with the user ID
+	MessageTypeAuthenticated = "authenticated"
+)
+
+// Error codes sent to clients whose handshake failed
+const (
+	AuthCodeUnauthorized         = "UNAUTHORIZED"
+	AuthCodeAlreadyAuthenticated = "ALREADY_AUTHENTICATED"
+)
+
+// TokenQueryParam is the query parameter carrying a token on upgrades, for
+// browsers, which can't set an Authorization header on them
+const TokenQueryParam = "token"
+
+// errTooManyConnections is returned when authenticating a connection would
+// exceed the user's connection quota
+var errTooManyConnections = errors.New("too many connections")
+
+// TokenAuthenticator validates the access tokens clients authenticate with.
+// ctx carries the values of the upgrade request, such as its tenant.
+type TokenAuthenticator interface {
+	AuthenticateToken(ctx context.Context, token string) (*authctx.User, error)
+}
+
+// TokenAuthenticatorFunc adapts a function to TokenAuthenticator
+type TokenAuthenticatorFunc func(ctx context.Context, token string) (*authctx.User, error)
+
+// AuthenticateToken calls f
+func (f TokenAuthenticatorFunc) AuthenticateToken(ctx context.Context, token string) (*authctx.User, error) {
+	return f(ctx, token)
+}
+
+// SetTokenAuthenticator sets the authenticator validating tokens sent in
+// the token query parameter and the authenticate handshake. Without one,
+// connections only authenticate through the auth middleware.
+func (h *Hub) SetTokenAuthenticator(authenticator TokenAuthenticator) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.authenticator = authenticator
+}
+
+// tokenAuthenticator returns the configured authenticator, if any
+func (h *Hub) tokenAuthenticator() TokenAuthenticator {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.authenticator
+}
+
+// queryUser authenticates the token query parameter of an upgrade request.
+// It returns nil without a token or an authenticator.
+func (h *Handler) queryUser(c echo.Context) (*authctx.User, error) {
+	token := c.QueryParam(TokenQueryParam)
+	authenticator := h.hub.tokenAuthenticator()
+	if token == "" || authenticator == nil {
+		return nil, nil
+	}
+	return authenticator.AuthenticateToken(c.Request().Context(), token)
+}
+
+// authenticate handles the authenticate handshake, upgrading an anonymous
+// connection to the token's user
+func (c *Client) authenticate(message *Message) {
+	authenticator := c.hub.tokenAuthenticator()
+	if authenticator == nil {
+		c.sendError(AuthCodeUnauthorized, "Token authentication is not enabled", message.Type)
+		return
+	}
+	if c.hub.clientUserID(c) != "" {
+		c.sendError(AuthCodeAlreadyAuthenticated, "The connection is already authenticated", message.Type)
+		return
+	}
+
+	var payload struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(message.Payload, &payload); err != nil || payload.Token == "" {
+		c.sendError(AuthCodeUnauthorized, "A token is required", message.Type)
+		return
+	}
+	user, err := authenticator.AuthenticateToken(c.ctx, payload.Token)
+	if err != nil {
+		c.logger.Debug("websocket authentication failed",
+			slog.String("client_id", c.ID),
+			slog.String("error", err.Error()),
+		)
+		c.sendError(AuthCodeUnauthorized, "Invalid token", message.Type)
+		return
+	}
+
+	if err := c.hub.authenticateClient(c, user.ID.String(), user.Role); err != nil {
+		c.logger.Warn("websocket connection quota exceeded",
+			slog.String("user_id", user.ID.String()),
+		)
+		go c.close(CloseTooManyConnections, "too many connections")
+		return
+	}
+
+	reply, _ := json.Marshal(map[string]string{"user_id": user.ID.String()})
+	_ = c.Send(&Message{Type: MessageTypeAuthenticated, Payload: reply})
+}
+
+// authenticateClient records client as connected for userID, enforcing the
+// connection quota like a new authenticated connection
+func (h *Hub) authenticateClient(client *Client, userID, role string) error {
+	h.mu.Lock()
+
+	if h.quota > 0 && h.quotaPolicy == QuotaReject && len(h.userClients(userID)) >= h.quota {
+		h.mu.Unlock()
+		return errTooManyConnections
+	}
+	client.UserID = userID
+	client.Role = role
+	if h.clients[client] {
+		s := h.shardFor(userID)
+		s.mu.Lock()
+		s.addUser(client)
+		s.mu.Unlock()
+	}
+	evicted := h.evictOverQuota(userID)
+	h.logger.Info("client authenticated",
+		slog.String("client_id", client.ID),
+		slog.String("user_id", userID),
+	)
+
+	h.mu.Unlock()
+
+	h.closeEvicted(evicted)
+	return nil
+}
+
+// clientUserID reads the client's user ID, which the handshake may set
+// while the hub reads it
+func (h *Hub) clientUserID(client *Client) string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return client.UserID
+}
+
+// sendError sends an error message with code to the client
+func (c *Client) sendError(code, message, messageType string) {
+	payload, _ := json.Marshal(map[string]string{
+		"code":    code,
+		"message": message,
+		"type":    messageType,
+	})
+	_ = c.Send(&Message{Type: "error", Payload: payload})
+}
@@ -0,0 +1,81 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/pixperk/goiler/internal/testutil"
+	"github.com/pixperk/goiler/pkg/authctx"
+)
+
+func newAuthTestClient(t *testing.T, authenticator TokenAuthenticator) *Client {
+	t.Helper()
+	logger := testutil.Logger()
+	hub := NewHub(logger)
+	if authenticator != nil {
+		hub.SetTokenAuthenticator(authenticator)
+	}
+	client := &Client{ID: "browser", hub: hub, logger: logger, ctx: context.Background(), send: make(chan []byte, 16), rooms: make(map[string]bool)}
+	hub.registerClient(client)
+	return client
+}
+
+func authenticateMessage(token string) *Message {
+	payload, _ := json.Marshal(map[string]string{"token": token})
+	return &Message{Type: MessageTypeAuthenticate, Payload: payload}
+}
+
+func TestAuthenticate_Handshake(t *testing.T) {
+	user := &authctx.User{ID: uuid.New(), Role: "user"}
+	client := newAuthTestClient(t, TokenAuthenticatorFunc(func(_ context.Context, token string) (*authctx.User, error) {
+		if token != "valid" {
+			return nil, errors.New("invalid token")
+		}
+		return user, nil
+	}))
+
+	client.authenticate(authenticateMessage("forged"))
+	if reply := string(<-client.send); !strings.Contains(reply, AuthCodeUnauthorized) {
+		t.Errorf("Expected %s for an invalid token, got %s", AuthCodeUnauthorized, reply)
+	}
+	if client.UserID != "" {
+		t.Fatalf("Expected the connection to stay anonymous, got user %s", client.UserID)
+	}
+
+	client.authenticate(authenticateMessage("valid"))
+	if reply := string(<-client.send); !strings.Contains(reply, MessageTypeAuthenticated) || !strings.Contains(reply, user.ID.String()) {
+		t.Errorf("Expected the handshake to be confirmed, got %s", reply)
+	}
+	if client.UserID != user.ID.String() || client.Role != "user" {
+		t.Errorf("Expected the connection to belong to the user, got %s (%s)", client.UserID, client.Role)
+	}
+	if n := client.hub.UserConnections(user.ID.String()); n != 1 {
+		t.Errorf("Expected 1 connection for the user, got %d", n)
+	}
+
+	client.hub.BroadcastToUser(user.ID.String(), &Message{Type: "notification"})
+	if reply := string(<-client.send); !strings.Contains(reply, "notification") {
+		t.Errorf("Expected messages for the user, got %s", reply)
+	}
+
+	client.authenticate(authenticateMessage("valid"))
+	if reply := string(<-client.send); !strings.Contains(reply, AuthCodeAlreadyAuthenticated) {
+		t.Errorf("Expected %s, got %s", AuthCodeAlreadyAuthenticated, reply)
+	}
+}
+
+func TestAuthenticate_RequiresAuthenticator(t *testing.T) {
+	client := newAuthTestClient(t, nil)
+
+	client.authenticate(authenticateMessage("valid"))
+	if reply := string(<-client.send); !strings.Contains(reply, AuthCodeUnauthorized) {
+		t.Errorf("Expected %s without an authenticator, got %s", AuthCodeUnauthorized, reply)
+	}
+	if client.UserID != "" {
+		t.Errorf("Expected the connection to stay anonymous, got user %s", client.UserID)
+	}
+}
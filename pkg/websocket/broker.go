@@ -0,0 +1,192 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Broker carries broadcasts between the instances sharing it. Every
+// subscriber, the publishing instance's included, receives each published
+// message.
+type Broker interface {
+	// Publish sends data to every subscriber
+	Publish(ctx context.Context, data []byte) error
+	// Subscribe calls deliver with each message until ctx is cancelled or
+	// the subscription fails
+	Subscribe(ctx context.Context, deliver func(data []byte)) error
+}
+
+// envelope is a broadcast as it travels through the broker. A message with
+// a UserID goes to that user's connections, any other to its room or to
+// everyone.
+type envelope struct {
+	UserID  string   `json:"user_id,omitempty"`
+	Message *Message `json:"message"`
+}
+
+const (
+	brokerBufferSize     = 256
+	brokerPublishTimeout = 2 * time.Second
+	brokerRetryDelay     = time.Second
+)
+
+// BrokerHub relays a Hub's broadcasts through a Broker, so BroadcastToAll,
+// BroadcastToRoom and BroadcastToUser reach clients connected to any
+// instance. Room policies and restrictions are checked on the sending
+// instance. Presence and client counts stay per instance.
+type BrokerHub struct {
+	*Hub
+	broker Broker
+	out    chan *envelope
+	logger *slog.Logger
+}
+
+// NewBrokerHub routes hub's broadcasts through broker. Nothing is published
+// or delivered until Run is called.
+func NewBrokerHub(hub *Hub, broker Broker, logger *slog.Logger) *BrokerHub {
+	b := &BrokerHub{
+		Hub:    hub,
+		broker: broker,
+		out:    make(chan *envelope, brokerBufferSize),
+		logger: logger,
+	}
+
+	hub.mu.Lock()
+	hub.broker = b
+	hub.mu.Unlock()
+
+	return b
+}
+
+// brokerHub returns the broker relaying the hub's broadcasts, if any
+func (h *Hub) brokerHub() *BrokerHub {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.broker
+}
+
+// Run publishes and delivers broadcasts until ctx is cancelled. The
+// subscription is re-established after a failure.
+func (b *BrokerHub) Run(ctx context.Context) {
+	go b.publish(ctx)
+
+	for {
+		err := b.broker.Subscribe(ctx, b.receive)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			err = errors.New("subscription ended")
+		}
+		b.logger.Warn("websocket broker subscription lost",
+			slog.String("error", err.Error()),
+		)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(brokerRetryDelay):
+		}
+	}
+}
+
+// relay queues env for publishing. When the queue is full the broadcast is
+// delivered locally only, rather than blocking the hub.
+func (b *BrokerHub) relay(env *envelope) {
+	select {
+	case b.out <- env:
+	default:
+		b.logger.Warn("websocket broker queue full, delivering locally")
+		b.deliver(env)
+	}
+}
+
+func (b *BrokerHub) publish(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case env := <-b.out:
+			data, err := json.Marshal(env)
+			if err != nil {
+				b.logger.Error("failed to encode broadcast", slog.String("error", err.Error()))
+				continue
+			}
+
+			publishCtx, cancel := context.WithTimeout(ctx, brokerPublishTimeout)
+			err = b.broker.Publish(publishCtx, data)
+			cancel()
+			if err != nil {
+				// Local clients should still get the message
+				b.logger.Warn("failed to publish broadcast, delivering locally",
+					slog.String("error", err.Error()),
+				)
+				b.deliver(env)
+			}
+		}
+	}
+}
+
+// receive delivers a broadcast published by any instance
+func (b *BrokerHub) receive(data []byte) {
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil || env.Message == nil {
+		b.logger.Warn("dropping invalid broadcast from broker")
+		return
+	}
+	b.deliver(&env)
+}
+
+func (b *BrokerHub) deliver(env *envelope) {
+	if env.UserID != "" {
+		b.Hub.deliverToUser(env.UserID, env.Message)
+		return
+	}
+	b.Hub.deliverMessage(env.Message)
+}
+
+// RedisBroker is a Broker over a Redis pub/sub channel
+type RedisBroker struct {
+	client  redis.UniversalClient
+	channel string
+}
+
+// NewRedisBroker creates a broker publishing to channel
+func NewRedisBroker(client redis.UniversalClient, channel string) *RedisBroker {
+	return &RedisBroker{client: client, channel: channel}
+}
+
+// Publish sends data to the channel
+func (r *RedisBroker) Publish(ctx context.Context, data []byte) error {
+	return r.client.Publish(ctx, r.channel, data).Err()
+}
+
+// Subscribe delivers the channel's messages until ctx is cancelled or the
+// subscription closes
+func (r *RedisBroker) Subscribe(ctx context.Context, deliver func(data []byte)) error {
+	sub := r.client.Subscribe(ctx, r.channel)
+	defer sub.Close()
+
+	// Wait for the subscription to be confirmed so failures are reported
+	if _, err := sub.Receive(ctx); err != nil {
+		return err
+	}
+
+	messages := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg, ok := <-messages:
+			if !ok {
+				return errors.New("redis subscription closed")
+			}
+			deliver([]byte(msg.Payload))
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pixperk/goiler/internal/testutil"
+)
+
+// memoryBroker fans published messages out to every subscriber, like Redis
+// pub/sub does
+type memoryBroker struct {
+	mu          sync.Mutex
+	subscribers []chan []byte
+	failPublish bool
+}
+
+func (m *memoryBroker) Publish(ctx context.Context, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.failPublish {
+		return errors.New("broker down")
+	}
+	for _, sub := range m.subscribers {
+		sub <- data
+	}
+	return nil
+}
+
+func (m *memoryBroker) Subscribe(ctx context.Context, deliver func(data []byte)) error {
+	sub := make(chan []byte, 16)
+	m.mu.Lock()
+	m.subscribers = append(m.subscribers, sub)
+	m.mu.Unlock()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case data := <-sub:
+			deliver(data)
+		}
+	}
+}
+
+func (m *memoryBroker) waitSubscribers(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		m.mu.Lock()
+		count := len(m.subscribers)
+		m.mu.Unlock()
+		if count == n {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("Expected %d subscribers", n)
+}
+
+func newBrokerTestClient(hub *Hub, id, userID string) *Client {
+	client := &Client{ID: id, UserID: userID, hub: hub, send: make(chan []byte, 16), rooms: make(map[string]bool)}
+	hub.registerClient(client)
+	return client
+}
+
+func receive(t *testing.T, client *Client) string {
+	t.Helper()
+	select {
+	case data := <-client.send:
+		return string(data)
+	case <-time.After(time.Second):
+		t.Fatalf("Expected a message for %s", client.ID)
+		return ""
+	}
+}
+
+func TestBrokerHub_RelaysBetweenInstances(t *testing.T) {
+	logger := testutil.Logger()
+	broker := &memoryBroker{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hubA, hubB := NewHub(logger), NewHub(logger)
+	brokerA, brokerB := NewBrokerHub(hubA, broker, logger), NewBrokerHub(hubB, broker, logger)
+	go brokerA.Run(ctx)
+	go brokerB.Run(ctx)
+	broker.waitSubscribers(t, 2)
+
+	alice := newBrokerTestClient(hubA, "a1", "alice")
+	bob := newBrokerTestClient(hubB, "b1", "bob")
+	hubB.addClientToRoom(bob, "chat")
+
+	// Room broadcasts go through the hub loop on the sending instance
+	brokerA.BroadcastToRoom("chat", &Message{Type: "chat", Payload: json.RawMessage(`{"text":"hi"}`)})
+	hubA.broadcastMessage(<-hubA.broadcast)
+	if got := receive(t, bob); !strings.Contains(got, `"text":"hi"`) {
+		t.Errorf("Expected the room message on the other instance, got %s", got)
+	}
+	if len(alice.send) != 0 {
+		t.Error("Expected the room message only in the room")
+	}
+
+	brokerB.BroadcastToUser("alice", &Message{Type: "notice"})
+	if got := receive(t, alice); !strings.Contains(got, `"notice"`) {
+		t.Errorf("Expected the user message on the other instance, got %s", got)
+	}
+	if len(bob.send) != 0 {
+		t.Error("Expected the user message only for its user")
+	}
+}
+
+func TestBrokerHub_DeliversLocallyWhenPublishFails(t *testing.T) {
+	logger := testutil.Logger()
+	broker := &memoryBroker{failPublish: true}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := NewHub(logger)
+	brokerHub := NewBrokerHub(hub, broker, logger)
+	go brokerHub.Run(ctx)
+
+	alice := newBrokerTestClient(hub, "a1", "alice")
+	brokerHub.BroadcastToUser("alice", &Message{Type: "notice"})
+	if got := receive(t, alice); !strings.Contains(got, `"notice"`) {
+		t.Errorf("Expected local delivery, got %s", got)
+	}
+}
@@ -0,0 +1,426 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// Time allowed to write a message to the peer
+	writeWait = 10 * time.Second
+
+	// Default time allowed to read the next pong message from the peer;
+	// pings are sent every 9/10 of it
+	pongWait = 60 * time.Second
+
+	// Default maximum message size allowed from peer
+	maxMessageSize = 512 * 1024 // 512 KB
+)
+
+// Client represents a WebSocket client connection
+type Client struct {
+	ID     string
+	UserID string
+	// Role is the authenticated user's role when the connection opened, ""
+	// for anonymous connections
+	Role   string
+	hub    *Hub
+	conn   *websocket.Conn
+	send   chan []byte
+	rooms  map[string]bool
+	logger *slog.Logger
+
+	// ip is the remote address the connection came from, "" for clients
+	// not created by the handler
+	ip string
+	// readLimit is the largest message the client may send
+	readLimit int64
+	// codec encodes the client's messages; nil means JSON
+	codec Codec
+	// compressed is set when the connection negotiated permessage-deflate;
+	// messages of at least compressThreshold bytes are then compressed
+	compressed        bool
+	compressThreshold int
+	// bytes counts the bytes sent, if set
+	bytes *byteCounters
+	// pongWait is how long the connection may go without a pong before
+	// it's considered dead
+	pongWait time.Duration
+	// slow tracks whether the client keeps up, nil when slow clients
+	// aren't evicted
+	slow *slowTracker
+
+	// resumeToken names the client's session once it leaves; since is the
+	// seq of the last message kept when it registered. resume is the
+	// session to restore on registering, if any. replayedThrough is the seq
+	// of the last message replayed to it, which shards still delivering
+	// skip.
+	resumeToken     string
+	since           uint64
+	resume          *resumeState
+	replayedThrough uint64
+
+	// closeFrame is written when the hub closes the send channel; written
+	// is closed once the write pump has returned
+	closeFrame []byte
+	written    chan struct{}
+
+	// ctx is cancelled when the connection closes, aborting in-flight RPC
+	// calls
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// slots bounds the client's in-flight RPC calls; created on the first
+	// call and only touched by the read pump
+	slots chan struct{}
+
+	// Rate limit bucket and the hub's room buckets, taken on the first
+	// message and only touched by the read pump
+	limiter     *rate.Limiter
+	roomLimits  *roomLimiters
+	ratePolicy  string
+	rateLimited bool
+}
+
+// NewClient creates a new client instance
+func NewClient(hub *Hub, conn *websocket.Conn, userID string, logger *slog.Logger) *Client {
+	return newClient(context.Background(), hub, conn, userID, logger)
+}
+
+// newClient creates a client whose context, passed to RPC handlers and the
+// token authenticator, carries the values of parent
+func newClient(parent context.Context, hub *Hub, conn *websocket.Conn, userID string, logger *slog.Logger) *Client {
+	ctx, cancel := context.WithCancel(parent)
+	return &Client{
+		ID:     uuid.New().String(),
+		UserID: userID,
+		hub:    hub,
+		conn:   conn,
+		send:   make(chan []byte, 256),
+		rooms:  make(map[string]bool),
+		logger: logger,
+		ctx:    ctx,
+		cancel: cancel,
+
+		readLimit: maxMessageSize,
+		pongWait:  pongWait,
+		slow:      hub.newSlowTracker(),
+		written:   make(chan struct{}),
+	}
+}
+
+// Message represents a WebSocket message. ID, Method, Params, Result and
+// Error are only set on RPC calls and their results.
+type Message struct {
+	Type    string          `json:"type"`
+	Room    string          `json:"room,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+
+	ID     string          `json:"id,omitempty"`
+	Method string          `json:"method,omitempty"`
+	Params json.RawMessage `json:"params,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *RPCError       `json:"error,omitempty"`
+
+	// Seq numbers the messages kept for resumption, in the order this
+	// instance delivered them; 0 for others
+	Seq uint64 `json:"seq,omitempty"`
+
+	// sender is the client that sent the message to be relayed, nil for
+	// messages from the server
+	sender *Client
+}
+
+// Encode encodes the message to JSON
+func (m *Message) Encode() ([]byte, error) {
+	return json.Marshal(m)
+}
+
+// DecodeMessage decodes a JSON message
+func DecodeMessage(data []byte) (*Message, error) {
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// ReadPump pumps messages from the WebSocket connection to the hub
+func (c *Client) ReadPump() {
+	defer func() {
+		c.cancel()
+		enqueue(c.hub, c.hub.unregister, c)
+		c.conn.Close()
+	}()
+
+	c.conn.SetReadLimit(c.readLimit)
+	c.conn.SetReadDeadline(time.Now().Add(c.pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		c.conn.SetReadDeadline(time.Now().Add(c.pongWait))
+		return nil
+	})
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				// No pong within pongWait: the peer is gone or stuck
+				c.logger.Warn("websocket connection unresponsive",
+					slog.String("client_id", c.ID),
+					slog.Duration("pong_timeout", c.pongWait),
+				)
+				c.hub.metrics.countEvicted(context.Background(), EvictUnresponsive)
+			} else if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure, websocket.CloseServiceRestart) {
+				c.logger.Error("websocket read error",
+					slog.String("client_id", c.ID),
+					slog.String("error", err.Error()),
+				)
+			}
+			break
+		}
+
+		message, err := c.wireCodec().Decode(data)
+		if err != nil {
+			c.logger.Warn("invalid message format",
+				slog.String("client_id", c.ID),
+				slog.String("error", err.Error()),
+			)
+			continue
+		}
+
+		switch c.checkRate(message) {
+		case rateDrop:
+			continue
+		case rateDisconnect:
+			closeConn(c.conn, websocket.ClosePolicyViolation, "rate limit exceeded")
+			return
+		}
+
+		c.handleMessage(message)
+	}
+}
+
+// WritePump pumps messages from the hub to the WebSocket connection
+func (c *Client) WritePump() {
+	ticker := time.NewTicker(c.pongWait * 9 / 10)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+		if c.written != nil {
+			close(c.written)
+		}
+	}()
+
+	for {
+		select {
+		case message, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				// Hub closed the channel
+				c.conn.WriteMessage(websocket.CloseMessage, c.closeFrame)
+				return
+			}
+
+			// Add queued messages to the current websocket message; binary
+			// messages can't be delimited, so each gets its own
+			batch := [][]byte{message}
+			n := len(c.send)
+			for i := 0; i < n; i++ {
+				batch = append(batch, <-c.send)
+			}
+
+			start := time.Now()
+			frameType := c.wireCodec().FrameType()
+			if frameType == websocket.BinaryMessage {
+				for _, data := range batch {
+					if err := c.writeFrame(frameType, [][]byte{data}); err != nil {
+						return
+					}
+				}
+			} else if err := c.writeFrame(frameType, batch); err != nil {
+				return
+			}
+			if c.slow.wrote(time.Since(start)) {
+				c.hub.evictSlow(c)
+			}
+
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// handleMessage processes incoming messages
+func (c *Client) handleMessage(message *Message) {
+	switch message.Type {
+	case "join":
+		var payload struct {
+			Room string `json:"room"`
+		}
+		if err := json.Unmarshal(message.Payload, &payload); err == nil && payload.Room != "" {
+			if c.rejectRestricted(message.Type, payload.Room) {
+				return
+			}
+			enqueue(c.hub, c.hub.joinRoom, &RoomRequest{Client: c, Room: payload.Room})
+		}
+
+	case "leave":
+		var payload struct {
+			Room string `json:"room"`
+		}
+		if err := json.Unmarshal(message.Payload, &payload); err == nil && payload.Room != "" {
+			enqueue(c.hub, c.hub.leaveRoom, &RoomRequest{Client: c, Room: payload.Room})
+		}
+
+	case "broadcast":
+		if c.rejectReadOnly(message) {
+			return
+		}
+		// Broadcast to all clients
+		enqueue(c.hub, c.hub.broadcast, message)
+
+	case "room":
+		if c.rejectReadOnly(message) {
+			return
+		}
+		// Broadcast to room
+		if message.Room != "" {
+			if c.rejectRestricted(message.Type, message.Room) {
+				return
+			}
+			message.sender = c
+			c.hub.BroadcastToRoom(message.Room, message)
+		}
+
+	case MessageTypeAuthenticate:
+		c.authenticate(message)
+
+	case MessageTypeRPC:
+		router := c.hub.RPCRouter()
+		if router == nil {
+			c.replyRPC(message.ID, nil, NewRPCError(RPCCodeMethodNotFound, "RPC is not enabled"))
+			return
+		}
+		router.dispatch(c, message)
+
+	case "ping":
+		// Respond with pong
+		response := &Message{Type: "pong"}
+		if data, err := c.encode(response); err == nil {
+			c.send <- data
+		}
+
+	default:
+		c.logger.Debug("unknown message type",
+			slog.String("type", message.Type),
+			slog.String("client_id", c.ID),
+		)
+	}
+}
+
+// rejectReadOnly replies with an error and returns true if the hub's write
+// gate is blocking client messages
+func (c *Client) rejectReadOnly(message *Message) bool {
+	if !c.hub.writesBlocked() {
+		return false
+	}
+
+	payload, _ := json.Marshal(map[string]string{
+		"code":    "READ_ONLY",
+		"message": "The service is temporarily read-only",
+		"type":    message.Type,
+	})
+	_ = c.Send(&Message{Type: "error", Payload: payload})
+	return true
+}
+
+// rpcSlots returns the client's RPC concurrency semaphore, creating it with
+// capacity n on first use
+func (c *Client) rpcSlots(n int) chan struct{} {
+	if c.slots == nil {
+		c.slots = make(chan struct{}, n)
+	}
+	return c.slots
+}
+
+// replyRPC sends the result of call id to the client. It is safe to call
+// from any goroutine, and drops the reply if the client has disconnected.
+func (c *Client) replyRPC(id string, result any, rpcErr *RPCError) {
+	reply := &Message{Type: MessageTypeRPCResult, ID: id}
+	if rpcErr != nil {
+		reply.Error = rpcErr
+	} else {
+		data, err := json.Marshal(result)
+		if err != nil {
+			reply.Error = NewRPCError(RPCCodeInternal, "Result could not be encoded")
+		} else {
+			reply.Result = data
+		}
+	}
+
+	data, err := c.encode(reply)
+	if err != nil {
+		c.logger.Error("failed to encode rpc result",
+			slog.String("client_id", c.ID),
+			slog.String("error", err.Error()),
+		)
+		return
+	}
+	if !c.hub.sendToClient(c, data) {
+		c.logger.Warn("dropping rpc result",
+			slog.String("client_id", c.ID),
+			slog.String("id", id),
+		)
+	}
+}
+
+// Send sends a message to the client
+func (c *Client) Send(message *Message) error {
+	data, err := c.encode(message)
+	if err != nil {
+		return err
+	}
+
+	if !c.hub.queue(context.Background(), c, data) {
+		return ErrBufferFull
+	}
+	return nil
+}
+
+// JoinRoom joins a room
+func (c *Client) JoinRoom(room string) {
+	enqueue(c.hub, c.hub.joinRoom, &RoomRequest{Client: c, Room: room})
+}
+
+// LeaveRoom leaves a room
+func (c *Client) LeaveRoom(room string) {
+	enqueue(c.hub, c.hub.leaveRoom, &RoomRequest{Client: c, Room: room})
+}
+
+// Context returns the client's context, which carries the values of the
+// upgrade request such as its tenant and is cancelled when the connection
+// closes
+func (c *Client) Context() context.Context {
+	return c.ctx
+}
+
+// GetRooms returns the rooms the client is in
+func (c *Client) GetRooms() []string {
+	rooms := make([]string, 0, len(c.rooms))
+	for room := range c.rooms {
+		rooms = append(rooms, room)
+	}
+	return rooms
+}
@@ -0,0 +1,111 @@
+package websocket
+
+import (
+	"github.com/gorilla/websocket"
+)
+
+// Subprotocols selecting the built-in codecs
+const (
+	SubprotocolJSON     = "json"
+	SubprotocolMsgpack  = "msgpack"
+	SubprotocolProtobuf = "protobuf"
+)
+
+// Codec encodes messages on the wire. Clients pick one by requesting its
+// subprotocol in the Sec-WebSocket-Protocol header on upgrade; those that
+// request none, or none the handler supports, get JSON. Payloads, params
+// and results stay JSON documents inside the hub whatever the codec.
+type Codec interface {
+	// Subprotocol is the name clients request the codec by
+	Subprotocol() string
+	// FrameType is the frame messages are sent in: websocket.TextMessage
+	// or websocket.BinaryMessage
+	FrameType() int
+	Encode(message *Message) ([]byte, error)
+	Decode(data []byte) (*Message, error)
+}
+
+// Built-in codecs. JSONCodec is the default; MsgpackCodec and ProtobufCodec
+// spare high-throughput clients from parsing JSON envelopes.
+var (
+	JSONCodec     Codec = jsonCodec{}
+	MsgpackCodec  Codec = msgpackCodec{}
+	ProtobufCodec Codec = protobufCodec{}
+)
+
+// jsonCodec sends messages as JSON text frames, several per frame separated
+// by newlines when the client falls behind
+type jsonCodec struct{}
+
+func (jsonCodec) Subprotocol() string                     { return SubprotocolJSON }
+func (jsonCodec) FrameType() int                          { return websocket.TextMessage }
+func (jsonCodec) Encode(message *Message) ([]byte, error) { return message.Encode() }
+func (jsonCodec) Decode(data []byte) (*Message, error)    { return DecodeMessage(data) }
+
+// wireCodec returns the codec the client negotiated
+func (c *Client) wireCodec() Codec {
+	if c.codec == nil {
+		return JSONCodec
+	}
+	return c.codec
+}
+
+// encode encodes message with the client's codec
+func (c *Client) encode(message *Message) ([]byte, error) {
+	return c.wireCodec().Encode(message)
+}
+
+// WithCodecs sets the codecs clients may negotiate, in order of preference
+// when a client requests several. JSON stays the fallback for clients
+// requesting none of them. Without it, every built-in codec is supported.
+func WithCodecs(codecs ...Codec) HandlerOption {
+	return func(h *Handler) {
+		h.codecs = codecs
+	}
+}
+
+// negotiatedCodec returns the codec of the subprotocol the upgrade agreed on
+func (h *Handler) negotiatedCodec(conn *websocket.Conn) Codec {
+	protocol := conn.Subprotocol()
+	for _, codec := range h.codecs {
+		if codec.Subprotocol() == protocol {
+			return codec
+		}
+	}
+	return JSONCodec
+}
+
+// subprotocols lists the subprotocols of codecs for the upgrader
+func subprotocols(codecs []Codec) []string {
+	protocols := make([]string, len(codecs))
+	for i, codec := range codecs {
+		protocols[i] = codec.Subprotocol()
+	}
+	return protocols
+}
+
+// encoded encodes a message once per codec as it is delivered to clients
+// using different codecs
+type encoded struct {
+	message *Message
+	frames  map[Codec][]byte
+}
+
+// newEncoded prepares message for delivery
+func newEncoded(message *Message) *encoded {
+	return &encoded{message: message, frames: make(map[Codec][]byte, 1)}
+}
+
+// frame returns the message encoded for client's codec
+func (e *encoded) frame(client *Client) ([]byte, error) {
+	codec := client.wireCodec()
+	if data, ok := e.frames[codec]; ok {
+		return data, nil
+	}
+	data, err := codec.Encode(e.message)
+	if err != nil {
+		return nil, err
+	}
+	e.frames[codec] = data
+	return data, nil
+}
@@ -0,0 +1,160 @@
+package websocket
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/internal/testutil"
+)
+
+func TestCodecs_RoundTrip(t *testing.T) {
+	messages := []*Message{
+		{Type: "room", Room: "chat:general", Payload: json.RawMessage(`{"count":3,"big":18446744073709551615,"ratio":0.5,"tags":["a","b"],"ok":true,"none":null}`), Seq: 300},
+		{Type: MessageTypeRPC, ID: "1", Method: "users.get", Params: json.RawMessage(`{"id":"42"}`)},
+		{Type: MessageTypeRPCResult, ID: "1", Error: &RPCError{Code: RPCCodeInvalidParams, Message: "Bad id", Details: map[string]any{"field": "id"}}},
+		{Type: "pong"},
+	}
+	for _, codec := range []Codec{JSONCodec, MsgpackCodec, ProtobufCodec} {
+		t.Run(codec.Subprotocol(), func(t *testing.T) {
+			for _, want := range messages {
+				data, err := codec.Encode(want)
+				if err != nil {
+					t.Fatalf("Encode(%s) failed: %v", want.Type, err)
+				}
+				got, err := codec.Decode(data)
+				if err != nil {
+					t.Fatalf("Decode(%s) failed: %v", want.Type, err)
+				}
+				assertSameMessage(t, want, got)
+			}
+		})
+	}
+}
+
+// assertSameMessage compares messages, ignoring the formatting of their
+// JSON fields
+func assertSameMessage(t *testing.T, want, got *Message) {
+	t.Helper()
+	if got.Type != want.Type || got.Room != want.Room || got.ID != want.ID || got.Method != want.Method || got.Seq != want.Seq {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+	for name, pair := range map[string][2]json.RawMessage{
+		"payload": {want.Payload, got.Payload},
+		"params":  {want.Params, got.Params},
+		"result":  {want.Result, got.Result},
+	} {
+		if !sameJSON(t, pair[0], pair[1]) {
+			t.Errorf("Expected %s %s, got %s", name, pair[0], pair[1])
+		}
+	}
+	if (want.Error == nil) != (got.Error == nil) {
+		t.Fatalf("Expected error %v, got %v", want.Error, got.Error)
+	}
+	if want.Error != nil {
+		if got.Error.Code != want.Error.Code || got.Error.Message != want.Error.Message || !reflect.DeepEqual(got.Error.Details, want.Error.Details) {
+			t.Errorf("Expected error %+v, got %+v", want.Error, got.Error)
+		}
+	}
+}
+
+func sameJSON(t *testing.T, a, b json.RawMessage) bool {
+	t.Helper()
+	if len(a) == 0 || len(b) == 0 {
+		return len(a) == len(b)
+	}
+	decode := func(raw json.RawMessage) any {
+		dec := json.NewDecoder(strings.NewReader(string(raw)))
+		dec.UseNumber()
+		var v any
+		if err := dec.Decode(&v); err != nil {
+			t.Fatalf("invalid JSON %s: %v", raw, err)
+		}
+		return v
+	}
+	return reflect.DeepEqual(decode(a), decode(b))
+}
+
+func TestCodecs_RejectMalformed(t *testing.T) {
+	tests := []struct {
+		name  string
+		codec Codec
+		data  []byte
+	}{
+		{"msgpack truncated", MsgpackCodec, []byte{0x81, 0xa4, 't', 'y'}},
+		{"msgpack not a map", MsgpackCodec, []byte{0x92, 0x01, 0x02}},
+		{"msgpack huge array", MsgpackCodec, []byte{0xdd, 0xff, 0xff, 0xff, 0xff}},
+		{"msgpack non-string type", MsgpackCodec, []byte{0x81, 0xa4, 't', 'y', 'p', 'e', 0x01}},
+		{"protobuf truncated", ProtobufCodec, []byte{0x0a, 0x05, 'p'}},
+		{"protobuf payload not JSON", ProtobufCodec, []byte{0x1a, 0x02, '{', '{'}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if msg, err := tt.codec.Decode(tt.data); err == nil {
+				t.Errorf("Expected an error, got %+v", msg)
+			}
+		})
+	}
+}
+
+func TestHandler_NegotiatesCodec(t *testing.T) {
+	logger := testutil.Logger()
+	hub := NewHub(logger)
+	go hub.Run()
+
+	e := echo.New()
+	e.GET("/ws", NewHandler(hub, logger).HandleConnection)
+	server := httptest.NewServer(e)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+
+	tests := []struct {
+		requested []string
+		codec     Codec
+	}{
+		{nil, JSONCodec},
+		{[]string{"unknown"}, JSONCodec},
+		{[]string{SubprotocolMsgpack}, MsgpackCodec},
+		{[]string{"unknown", SubprotocolProtobuf}, ProtobufCodec},
+	}
+	for _, tt := range tests {
+		t.Run(tt.codec.Subprotocol(), func(t *testing.T) {
+			dialer := websocket.Dialer{Subprotocols: tt.requested}
+			conn, _, err := dialer.Dial(url, nil)
+			if err != nil {
+				t.Fatalf("Dial failed: %v", err)
+			}
+			defer conn.Close()
+
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			frameType, data, err := conn.ReadMessage()
+			if err != nil {
+				t.Fatalf("ReadMessage failed: %v", err)
+			}
+			if frameType != tt.codec.FrameType() {
+				t.Errorf("Expected frame type %d, got %d", tt.codec.FrameType(), frameType)
+			}
+			welcome, err := tt.codec.Decode(data)
+			if err != nil || welcome.Type != "connected" {
+				t.Fatalf("Expected the welcome message, got %+v (%v)", welcome, err)
+			}
+
+			ping, _ := tt.codec.Encode(&Message{Type: "ping"})
+			if err := conn.WriteMessage(tt.codec.FrameType(), ping); err != nil {
+				t.Fatalf("WriteMessage failed: %v", err)
+			}
+			_, data, err = conn.ReadMessage()
+			if err != nil {
+				t.Fatalf("ReadMessage failed: %v", err)
+			}
+			if pong, err := tt.codec.Decode(data); err != nil || pong.Type != "pong" {
+				t.Errorf("Expected pong, got %+v (%v)", pong, err)
+			}
+		})
+	}
+}
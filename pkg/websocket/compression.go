@@ -0,0 +1,144 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// WithCompression negotiates permessage-deflate with clients offering it,
+// compressing messages of at least threshold bytes at level, from -2
+// (flate.HuffmanOnly) to 9 (flate.BestCompression). Compression trades CPU
+// for bandwidth, so it pays off for large broadcast payloads; smaller
+// messages are sent as they are.
+func WithCompression(level, threshold int) HandlerOption {
+	return func(h *Handler) {
+		h.upgrader.EnableCompression = true
+		h.compressionLevel = level
+		h.compressionThreshold = threshold
+	}
+}
+
+// byteCounters measure the bytes sent to clients, so the bandwidth saved by
+// compression can be compared with the message bytes
+type byteCounters struct {
+	// messages counts message bytes before compression
+	messages metric.Int64Counter
+	// wire counts bytes written to connections, framing included
+	wire metric.Int64Counter
+}
+
+// newByteCounters registers the byte counters with the global meter
+// provider
+func newByteCounters() *byteCounters {
+	meter := otel.Meter("goiler/websocket")
+
+	messages, _ := meter.Int64Counter(
+		"websocket_message_bytes_total",
+		metric.WithDescription("Total bytes of messages sent to WebSocket clients before compression, by whether the connection compresses"),
+		metric.WithUnit("By"),
+	)
+	wire, _ := meter.Int64Counter(
+		"websocket_wire_bytes_total",
+		metric.WithDescription("Total bytes written to WebSocket connections, by whether the connection compresses"),
+		metric.WithUnit("By"),
+	)
+	return &byteCounters{messages: messages, wire: wire}
+}
+
+// compresses reports whether the upgrade of r negotiates permessage-deflate
+func (h *Handler) compresses(r *http.Request) bool {
+	if !h.upgrader.EnableCompression {
+		return false
+	}
+	for _, header := range r.Header.Values("Sec-WebSocket-Extensions") {
+		for _, ext := range strings.Split(header, ",") {
+			name, _, _ := strings.Cut(ext, ";")
+			if strings.EqualFold(strings.TrimSpace(name), "permessage-deflate") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// upgrade upgrades the request to a WebSocket connection whose writes are
+// counted
+func (h *Handler) upgrade(c echo.Context) (*websocket.Conn, error) {
+	attrs := compressionAttrs(h.compresses(c.Request()))
+	w := &countingResponse{
+		ResponseWriter: c.Response(),
+		add: func(n int) {
+			h.bytes.wire.Add(context.Background(), int64(n), attrs)
+		},
+	}
+	return h.upgrader.Upgrade(w, c.Request(), nil)
+}
+
+// compressionAttrs labels byte counts with whether the connection
+// compresses
+func compressionAttrs(compressed bool) metric.AddOption {
+	return metric.WithAttributeSet(attribute.NewSet(attribute.Bool("compressed", compressed)))
+}
+
+// countingResponse hands the upgrader a connection counting its writes
+type countingResponse struct {
+	http.ResponseWriter
+	add func(n int)
+}
+
+// Hijack implements http.Hijacker
+func (r *countingResponse) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(r.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	return &countingConn{Conn: conn, add: r.add}, brw, nil
+}
+
+// countingConn counts the bytes written to a connection
+type countingConn struct {
+	net.Conn
+	add func(n int)
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.add(n)
+	return n, err
+}
+
+// writeFrame writes parts as one message of frameType, separated by
+// newlines, compressing it when it reaches the client's threshold
+func (c *Client) writeFrame(frameType int, parts [][]byte) error {
+	size := len(parts) - 1
+	for _, part := range parts {
+		size += len(part)
+	}
+	if c.compressed {
+		c.conn.EnableWriteCompression(size >= c.compressThreshold)
+	}
+
+	w, err := c.conn.NextWriter(frameType)
+	if err != nil {
+		return err
+	}
+	for i, part := range parts {
+		if i > 0 {
+			w.Write([]byte{'\n'})
+		}
+		w.Write(part)
+	}
+	if c.bytes != nil {
+		c.bytes.messages.Add(context.Background(), int64(size), compressionAttrs(c.compressed))
+	}
+	return w.Close()
+}
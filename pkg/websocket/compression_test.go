@@ -0,0 +1,81 @@
+package websocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/internal/testutil"
+)
+
+func TestHandler_Compresses(t *testing.T) {
+	logger := testutil.Logger()
+	tests := []struct {
+		name       string
+		opts       []HandlerOption
+		extensions string
+		want       bool
+	}{
+		{"disabled", nil, "permessage-deflate; client_max_window_bits", false},
+		{"offered", []HandlerOption{WithCompression(1, 0)}, "permessage-deflate; client_max_window_bits", true},
+		{"among others", []HandlerOption{WithCompression(1, 0)}, "x-webkit-deflate-frame, permessage-deflate", true},
+		{"not offered", []HandlerOption{WithCompression(1, 0)}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHandler(NewHub(logger), logger, tt.opts...)
+			req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+			if tt.extensions != "" {
+				req.Header.Set("Sec-WebSocket-Extensions", tt.extensions)
+			}
+			if got := h.compresses(req); got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestHandler_CompressedBroadcast(t *testing.T) {
+	logger := testutil.Logger()
+	hub := NewHub(logger)
+	go hub.Run()
+
+	e := echo.New()
+	e.GET("/ws", NewHandler(hub, logger, WithCompression(1, 64)).HandleConnection)
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	dialer := websocket.Dialer{EnableCompression: true}
+	conn, resp, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	if ext := resp.Header.Get("Sec-WebSocket-Extensions"); !strings.Contains(ext, "permessage-deflate") {
+		t.Fatalf("Expected permessage-deflate to be negotiated, got %q", ext)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := conn.ReadMessage(); err != nil {
+		t.Fatalf("Expected the welcome message: %v", err)
+	}
+
+	// Both sides of the threshold arrive intact
+	for _, size := range []int{10, 64 * 1024} {
+		payload := `"` + strings.Repeat("a", size) + `"`
+		hub.BroadcastToAll(&Message{Type: "bulk", Payload: []byte(payload)})
+
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage failed: %v", err)
+		}
+		msg, err := DecodeMessage(data)
+		if err != nil || string(msg.Payload) != payload {
+			t.Fatalf("Expected the %d byte payload, got %d bytes (%v)", size, len(data), err)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package websocket
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+)
+
+// drainPollInterval is how often Drain checks whether clients have left
+const drainPollInterval = 100 * time.Millisecond
+
+// drainRetryAfter is the Retry-After sent to clients refused while draining;
+// by then the replacement process is usually accepting connections
+const drainRetryAfter = time.Second
+
+// Drain closes every client with a "service restart" close frame so it
+// reconnects, which reaches the new process during a handover, and waits
+// for the clients to disconnect. New connections are refused from the
+// start. Connections still open when ctx is done are closed outright.
+func (h *Hub) Drain(ctx context.Context) {
+	h.draining.Store(true)
+
+	h.mu.RLock()
+	clients := make([]*Client, 0, len(h.clients))
+	for client := range h.clients {
+		clients = append(clients, client)
+	}
+	h.mu.RUnlock()
+
+	if len(clients) == 0 {
+		return
+	}
+	h.logger.Info("draining websocket clients", slog.Int("clients", len(clients)))
+
+	// Event streams end at once; their clients reconnect on their own
+	msg := websocket.FormatCloseMessage(websocket.CloseServiceRestart, "server restarting")
+	deadline := time.Now().Add(writeWait)
+	for _, client := range clients {
+		if client.conn == nil {
+			client.cancel()
+			continue
+		}
+		client.conn.WriteControl(websocket.CloseMessage, msg, deadline)
+	}
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for h.GetConnectedClients() > 0 {
+		select {
+		case <-ctx.Done():
+			h.logger.Warn("closing websocket clients that didn't disconnect",
+				slog.Int("clients", h.GetConnectedClients()),
+			)
+			// Closing the connection ends the read pump, which unregisters
+			// the client
+			for _, client := range clients {
+				if client.conn != nil {
+					client.conn.Close()
+				}
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// shutdownReason is the reason of the close frames sent on Shutdown
+const shutdownReason = "server shutting down"
+
+// Shutdown stops the hub for good as the process exits. New connections are
+// refused, each client is sent the messages already queued for it followed
+// by a "service restart" close frame so it reconnects to another instance,
+// and Run returns once they are written. Unlike Drain, it doesn't wait for
+// clients to disconnect.
+// Connections still writing when ctx is done are closed outright and ctx's
+// error is returned. Messages broadcast after Shutdown are dropped.
+func (h *Hub) Shutdown(ctx context.Context) error {
+	h.draining.Store(true)
+	defer h.stopOnce.Do(func() { close(h.done) })
+
+	h.mu.RLock()
+	clients := make([]*Client, 0, len(h.clients))
+	for client := range h.clients {
+		clients = append(clients, client)
+	}
+	h.mu.RUnlock()
+
+	if len(clients) == 0 {
+		return nil
+	}
+	h.logger.Info("closing websocket clients", slog.Int("clients", len(clients)))
+
+	frame := websocket.FormatCloseMessage(websocket.CloseServiceRestart, shutdownReason)
+	for _, client := range clients {
+		h.removeClient(client, frame)
+	}
+
+	for i, client := range clients {
+		if client.written == nil {
+			continue
+		}
+		select {
+		case <-client.written:
+		case <-ctx.Done():
+			h.logger.Warn("closing websocket clients that didn't flush",
+				slog.Int("clients", len(clients)-i),
+			)
+			for _, client := range clients[i:] {
+				if client.conn != nil {
+					client.conn.Close()
+				} else if client.cancel != nil {
+					client.cancel()
+				}
+			}
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Draining reports whether Drain or Shutdown has started
+func (h *Hub) Draining() bool {
+	return h.draining.Load()
+}
+
+// rejectDraining refuses a connection while the hub is draining
+func rejectDraining(c echo.Context) error {
+	c.Response().Header().Set(echo.HeaderRetryAfter, strconv.Itoa(int(drainRetryAfter.Seconds())))
+	return echo.NewHTTPError(http.StatusServiceUnavailable, "server is restarting")
+}
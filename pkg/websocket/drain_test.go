@@ -0,0 +1,100 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/internal/testutil"
+)
+
+func TestHub_Shutdown(t *testing.T) {
+	logger := testutil.Logger()
+	hub := NewHub(logger)
+	stopped := make(chan struct{})
+	go func() {
+		hub.Run()
+		close(stopped)
+	}()
+
+	e := echo.New()
+	e.GET("/ws", NewHandler(hub, logger).HandleConnection)
+	server := httptest.NewServer(e)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := conn.ReadMessage(); err != nil {
+		t.Fatalf("Expected the welcome message: %v", err)
+	}
+
+	for hub.GetConnectedClients() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	hub.mu.RLock()
+	var client *Client
+	for c := range hub.clients {
+		client = c
+	}
+	hub.mu.RUnlock()
+	for range 3 {
+		if err := client.Send(&Message{Type: "update"}); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := hub.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+
+	// The queued messages arrive before the close frame
+	var updates int
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			var closeErr *websocket.CloseError
+			if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseServiceRestart || closeErr.Text != shutdownReason {
+				t.Fatalf("Expected a service restart close frame, got %v", err)
+			}
+			break
+		}
+		updates += strings.Count(string(data), `"update"`)
+	}
+	if updates != 3 {
+		t.Errorf("Expected the 3 queued messages, got %d", updates)
+	}
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Run to return")
+	}
+
+	// Nothing blocks on the stopped loop, and new connections are refused
+	done := make(chan struct{})
+	go func() {
+		hub.BroadcastToAll(&Message{Type: "late"})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected broadcasts after Shutdown to be dropped")
+	}
+	if _, resp, err := websocket.DefaultDialer.Dial(url, nil); err == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 after Shutdown, got %v", err)
+	}
+}
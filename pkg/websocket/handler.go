@@ -0,0 +1,281 @@
+package websocket
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/pkg/authctx"
+)
+
+var (
+	ErrBufferFull       = errors.New("client buffer full")
+	ErrConnectionClosed = errors.New("connection closed")
+)
+
+// Handler handles WebSocket connections
+type Handler struct {
+	hub      *Hub
+	upgrader websocket.Upgrader
+	logger   *slog.Logger
+
+	// origins are the browser origins allowed to connect, besides the
+	// server's own; "*" allows any
+	origins []string
+	// maxPerIP limits the connections open from each IP; 0 means unlimited
+	maxPerIP int
+	// readLimit is the largest message clients may send
+	readLimit int64
+	// pongWait is how long connections may go without a pong
+	pongWait time.Duration
+	// codecs are the codecs clients may negotiate besides JSON
+	codecs []Codec
+
+	// Compression of messages of at least compressionThreshold bytes, when
+	// the upgrader enables it
+	compressionLevel     int
+	compressionThreshold int
+	bytes                *byteCounters
+
+	// pushKeys are the SHA-256 sums of the API keys accepted by the push
+	// endpoints
+	pushKeys [][sha256.Size]byte
+	// pushRecipient, if set, checks the recipients of users' pushes
+	pushRecipient func(ctx context.Context, userID string) (bool, error)
+}
+
+// NewHandler creates a new WebSocket handler. Without options, it accepts
+// same-origin browsers and any non-browser client, with 1 KB buffers,
+// messages of up to 512 KB, a 60 second pong timeout and every built-in
+// codec, without compression.
+func NewHandler(hub *Hub, logger *slog.Logger, opts ...HandlerOption) *Handler {
+	h := &Handler{
+		hub: hub,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 1024,
+		},
+		logger:    logger,
+		readLimit: maxMessageSize,
+		pongWait:  pongWait,
+		codecs:    []Codec{JSONCodec, MsgpackCodec, ProtobufCodec},
+		bytes:     newByteCounters(),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	h.upgrader.CheckOrigin = h.checkOrigin
+	h.upgrader.Subprotocols = subprotocols(h.codecs)
+	return h
+}
+
+// HandleConnection handles WebSocket connection upgrades
+// @Summary WebSocket connection
+// @Description Upgrade to WebSocket connection. Browsers, which can't send an Authorization header, authenticate with the token query parameter or by sending an authenticate message with the token once connected; other connections are anonymous. Clients reconnecting with the resume token of their welcome message rejoin their rooms and receive the messages they missed.
+// @Tags WebSocket
+// @Produce json
+// @Param token query string false "Access token"
+// @Param resume query string false "Resume token of a previous connection"
+// @Param cursor query integer false "Seq of the last message received before disconnecting"
+// @Success 101 "Switching Protocols"
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 503 {object} response.Response
+// @Router /api/v1/ws [get]
+func (h *Handler) HandleConnection(c echo.Context) error {
+	if !h.checkOrigin(c.Request()) {
+		return h.rejectOrigin(c)
+	}
+	if h.hub.Draining() {
+		return rejectDraining(c)
+	}
+
+	// Get the user from the auth context or the token query parameter
+	// (optional - can be anonymous and authenticate later)
+	user, ok := authctx.Get(c)
+	if !ok {
+		var err error
+		if user, err = h.queryUser(c); err != nil {
+			return echo.NewHTTPError(http.StatusUnauthorized, "invalid token")
+		}
+	}
+	userID, role := "", ""
+	if user != nil {
+		userID, role = user.ID.String(), user.Role
+	}
+	if h.hub.overQuota(userID) {
+		return h.rejectOverQuota(c, slog.String("user_id", userID))
+	}
+	if !h.reserveIP(c.RealIP()) {
+		return h.rejectOverQuota(c, slog.String("ip", c.RealIP()))
+	}
+
+	// Upgrade HTTP connection to WebSocket
+	conn, err := h.upgrade(c)
+	if err != nil {
+		h.hub.releaseIP(c.RealIP())
+		h.logger.Error("websocket upgrade failed", slog.String("error", err.Error()))
+		return err
+	}
+
+	// Create new client, keeping the request's values such as its tenant
+	client := h.newClient(c, conn, userID)
+	client.Role = role
+	h.hub.issueResumeToken(client)
+	h.prepareResume(c, client)
+
+	// Queue the welcome message ahead of any broadcast
+	payload := `{"message": "Connected to WebSocket server", "client_id": "` + client.ID + `"`
+	if userID != "" {
+		payload += `, "user_id": "` + userID + `"`
+	}
+	if client.resumeToken != "" {
+		payload += `, "resume_token": "` + client.resumeToken + `"`
+	}
+	welcome := &Message{
+		Type:    "connected",
+		Payload: []byte(payload + "}"),
+	}
+	if data, err := client.encode(welcome); err == nil {
+		client.send <- data
+	}
+
+	// Register client with hub
+	if !enqueue(h.hub, h.hub.register, client) {
+		h.hub.releaseIP(client.ip)
+		client.cancel()
+		closeConn(conn, websocket.CloseServiceRestart, shutdownReason)
+		return nil
+	}
+
+	// Start client goroutines
+	go client.WritePump()
+	go client.ReadPump()
+
+	return nil
+}
+
+// HandleAuthenticatedConnection handles WebSocket connections requiring authentication
+func (h *Handler) HandleAuthenticatedConnection(c echo.Context) error {
+	userID, ok := authctx.UserID(c)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "authentication required")
+	}
+	if !h.checkOrigin(c.Request()) {
+		return h.rejectOrigin(c)
+	}
+	if h.hub.Draining() {
+		return rejectDraining(c)
+	}
+	if h.hub.overQuota(userID.String()) {
+		return h.rejectOverQuota(c, slog.String("user_id", userID.String()))
+	}
+	if !h.reserveIP(c.RealIP()) {
+		return h.rejectOverQuota(c, slog.String("ip", c.RealIP()))
+	}
+
+	conn, err := h.upgrade(c)
+	if err != nil {
+		h.hub.releaseIP(c.RealIP())
+		h.logger.Error("websocket upgrade failed", slog.String("error", err.Error()))
+		return err
+	}
+
+	client := h.newClient(c, conn, userID.String())
+	client.Role, _ = authctx.Role(c)
+	h.hub.issueResumeToken(client)
+	h.prepareResume(c, client)
+
+	payload := `{"message": "Connected to WebSocket server", "client_id": "` + client.ID + `", "user_id": "` + userID.String() + `"`
+	if client.resumeToken != "" {
+		payload += `, "resume_token": "` + client.resumeToken + `"`
+	}
+	welcome := &Message{
+		Type:    "connected",
+		Payload: []byte(payload + "}"),
+	}
+	if data, err := client.encode(welcome); err == nil {
+		client.send <- data
+	}
+
+	if !enqueue(h.hub, h.hub.register, client) {
+		h.hub.releaseIP(client.ip)
+		client.cancel()
+		closeConn(conn, websocket.CloseServiceRestart, shutdownReason)
+		return nil
+	}
+
+	go client.WritePump()
+	go client.ReadPump()
+
+	return nil
+}
+
+// BroadcastToAll broadcasts a message to all connected clients
+func (h *Handler) BroadcastToAll(messageType string, payload interface{}) error {
+	data, err := encodePayload(payload)
+	if err != nil {
+		return err
+	}
+
+	h.hub.BroadcastToAll(&Message{
+		Type:    messageType,
+		Payload: data,
+	})
+	return nil
+}
+
+// BroadcastToRoom broadcasts a message to all clients in a room
+func (h *Handler) BroadcastToRoom(room, messageType string, payload interface{}) error {
+	data, err := encodePayload(payload)
+	if err != nil {
+		return err
+	}
+
+	h.hub.BroadcastToRoom(room, &Message{
+		Type:    messageType,
+		Payload: data,
+	})
+	return nil
+}
+
+// BroadcastToUser broadcasts a message to a specific user
+func (h *Handler) BroadcastToUser(userID, messageType string, payload interface{}) error {
+	data, err := encodePayload(payload)
+	if err != nil {
+		return err
+	}
+
+	h.hub.BroadcastToUser(userID, &Message{
+		Type:    messageType,
+		Payload: data,
+	})
+	return nil
+}
+
+// GetStats returns WebSocket statistics
+func (h *Handler) GetStats() Stats {
+	return h.hub.Stats()
+}
+
+// encodePayload encodes a payload to JSON
+func encodePayload(payload interface{}) ([]byte, error) {
+	if payload == nil {
+		return nil, nil
+	}
+
+	switch p := payload.(type) {
+	case []byte:
+		return p, nil
+	case string:
+		return []byte(p), nil
+	default:
+		return nil, errors.New("unsupported payload type")
+	}
+}
@@ -0,0 +1,517 @@
+// Package websocket is a WebSocket hub with rooms, per-user delivery and
+// request/response RPC. It has no app dependencies: handlers read the user
+// from pkg/authctx and everything else is injected.
+package websocket
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pixperk/goiler/pkg/events"
+)
+
+// Hub maintains the set of active clients and broadcasts messages. Rooms,
+// users and clients are spread across shards, which deliver messages in
+// parallel: messages to one room, one user or everyone arrive in the order
+// they were sent, but messages of different kinds may be reordered.
+type Hub struct {
+	// Registered clients
+	clients map[*Client]bool
+
+	// Shards holding rooms, users and clients for delivery
+	shards     []*hubShard
+	shardCount int
+
+	// Number of clients by remote IP, counting connections reserved before
+	// they register
+	ips map[string]int
+
+	// Inbound messages from clients
+	broadcast chan *Message
+
+	// Register requests from clients
+	register chan *Client
+
+	// Unregister requests from clients
+	unregister chan *Client
+
+	// Join room requests
+	joinRoom chan *RoomRequest
+
+	// Leave room requests
+	leaveRoom chan *RoomRequest
+
+	// Mutex for the registry and settings; deliveries only take shard locks
+	mu sync.RWMutex
+
+	// Publisher for room lifecycle events (optional)
+	events EventPublisher
+
+	// Gate for client writes (optional)
+	writeGate WriteGate
+
+	// Router for client RPC calls (optional)
+	rpc *RPCRouter
+
+	// Set once Drain or Shutdown starts; new connections are refused
+	draining atomic.Bool
+
+	// Closed by Shutdown to stop Run
+	done     chan struct{}
+	stopOnce sync.Once
+
+	// Per-user connection limit and what happens beyond it (optional)
+	quota       int
+	quotaPolicy string
+
+	// Limits on the messages each client sends, and the room buckets they
+	// share (nil without a room limit)
+	rateLimit  RateLimit
+	roomLimits *roomLimiters
+
+	// When clients too slow to keep up are evicted
+	slowPolicy SlowClientPolicy
+
+	// Policies limiting the messages relayed to rooms, by room
+	policies map[string]*roomPolicy
+
+	// Checks of who may join and send to restricted rooms, by room
+	restricted map[string]func(*Client) bool
+
+	// Decides who may join and send to rooms (optional)
+	roomAuthorizer RoomAuthorizer
+
+	// Broker relaying broadcasts between instances (optional)
+	broker *BrokerHub
+
+	// Validates tokens sent on upgrades and in handshakes (optional)
+	authenticator TokenAuthenticator
+
+	// Counts of the messages sent to clients
+	metrics *hubMetrics
+
+	// Messages and sessions kept for resumption (optional)
+	history *history
+
+	// Logger
+	logger *slog.Logger
+}
+
+// Room lifecycle event topics, cataloged in pkg/events
+var (
+	TopicRoomCreated      = events.RoomCreated.Name()
+	TopicRoomEmptied      = events.RoomEmptied.Name()
+	TopicRoomMemberJoined = events.RoomMemberJoined.Name()
+	TopicRoomMemberLeft   = events.RoomMemberLeft.Name()
+)
+
+// RoomEvent is the payload published for room lifecycle events
+type RoomEvent = events.RoomEvent
+
+// EventPublisher publishes hub events to topics. *pubsub.PubSub satisfies it.
+// Publish must not block, as it is called from the hub loop.
+type EventPublisher interface {
+	Publish(topic string, payload interface{}) int
+}
+
+// WriteGate rejects messages that clients send to other clients while it is
+// enabled. *server.ReadOnlySwitch satisfies it.
+type WriteGate interface {
+	Enabled() bool
+}
+
+// roomEvent pairs a topic with its payload until it is published
+type roomEvent struct {
+	topic   events.Topic[RoomEvent]
+	payload RoomEvent
+}
+
+// RoomRequest represents a request to join or leave a room
+type RoomRequest struct {
+	Client *Client
+	Room   string
+}
+
+// NewHub creates a new Hub instance
+func NewHub(logger *slog.Logger, opts ...HubOption) *Hub {
+	h := &Hub{
+		clients:    make(map[*Client]bool),
+		ips:        make(map[string]int),
+		broadcast:  make(chan *Message, 256),
+		register:   make(chan *Client),
+		unregister: make(chan *Client),
+		joinRoom:   make(chan *RoomRequest),
+		leaveRoom:  make(chan *RoomRequest),
+		policies:   make(map[string]*roomPolicy),
+		restricted: make(map[string]func(*Client) bool),
+		done:       make(chan struct{}),
+		logger:     logger,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	h.newShards()
+	h.metrics = newHubMetrics(h)
+	return h
+}
+
+// SetEventPublisher sets the publisher used for room lifecycle events
+func (h *Hub) SetEventPublisher(publisher EventPublisher) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.events = publisher
+}
+
+// SetWriteGate sets the gate consulted before relaying client messages
+func (h *Hub) SetWriteGate(gate WriteGate) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.writeGate = gate
+}
+
+// SetRPCRouter sets the router serving client RPC calls. Without one, calls
+// fail with METHOD_NOT_FOUND.
+func (h *Hub) SetRPCRouter(router *RPCRouter) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.rpc = router
+}
+
+// RPCRouter returns the configured RPC router, if any
+func (h *Hub) RPCRouter() *RPCRouter {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.rpc
+}
+
+// writesBlocked reports whether the write gate is rejecting client messages
+func (h *Hub) writesBlocked() bool {
+	h.mu.RLock()
+	gate := h.writeGate
+	h.mu.RUnlock()
+
+	return gate != nil && gate.Enabled()
+}
+
+// publish sends room events to the configured publisher, if any
+func (h *Hub) publish(pending []roomEvent) {
+	h.mu.RLock()
+	publisher := h.events
+	h.mu.RUnlock()
+
+	if publisher == nil {
+		return
+	}
+
+	for _, e := range pending {
+		e.topic.Publish(publisher, e.payload)
+	}
+}
+
+// newRoomEvent creates a room event for the given client
+func newRoomEvent(topic events.Topic[RoomEvent], room string, client *Client, members int) roomEvent {
+	event := roomEvent{
+		topic: topic,
+		payload: RoomEvent{
+			Room:    room,
+			Members: members,
+			Time:    time.Now(),
+		},
+	}
+	if client != nil {
+		event.payload.ClientID = client.ID
+		event.payload.UserID = client.UserID
+	}
+	return event
+}
+
+// Run starts the hub's main loop and the shards' delivery goroutines,
+// returning after Shutdown
+func (h *Hub) Run() {
+	for _, s := range h.shards {
+		go s.run()
+	}
+	for {
+		select {
+		case <-h.done:
+			return
+
+		case client := <-h.register:
+			h.registerClient(client)
+
+		case client := <-h.unregister:
+			h.unregisterClient(client)
+
+		case request := <-h.joinRoom:
+			h.addClientToRoom(request.Client, request.Room)
+
+		case request := <-h.leaveRoom:
+			h.removeClientFromRoom(request.Client, request.Room)
+
+		case message := <-h.broadcast:
+			h.broadcastMessage(message)
+		}
+	}
+}
+
+// registerClient adds a client to the hub
+func (h *Hub) registerClient(client *Client) {
+	h.mu.Lock()
+
+	// The client's IP slot was reserved before the upgrade
+	h.clients[client] = true
+	var pending []roomEvent
+	if client.resume != nil {
+		pending = h.restoreSession(client)
+	} else {
+		if h.history != nil {
+			client.since = h.history.current()
+		}
+		s := h.clientShard(client)
+		s.mu.Lock()
+		s.clients[client] = true
+		s.mu.Unlock()
+		if client.UserID != "" {
+			s := h.shardFor(client.UserID)
+			s.mu.Lock()
+			s.addUser(client)
+			s.mu.Unlock()
+		}
+	}
+	evicted := h.evictOverQuota(client.UserID)
+	h.logger.Info("client registered",
+		slog.String("client_id", client.ID),
+		slog.String("user_id", client.UserID),
+	)
+
+	h.mu.Unlock()
+
+	h.publish(pending)
+	h.closeEvicted(evicted)
+}
+
+// closeEvicted closes the connections evicted by the connection quota
+func (h *Hub) closeEvicted(evicted []*Client) {
+	for _, old := range evicted {
+		h.logger.Info("evicting client over connection quota",
+			slog.String("client_id", old.ID),
+			slog.String("user_id", old.UserID),
+		)
+		h.metrics.countEvicted(context.Background(), EvictQuota)
+		go old.close(CloseTooManyConnections, "replaced by a newer connection")
+	}
+}
+
+// enqueue sends v to the hub loop on ch, or drops it and reports false once
+// the hub has shut down
+func enqueue[T any](h *Hub, ch chan T, v T) bool {
+	select {
+	case ch <- v:
+		return true
+	case <-h.done:
+		return false
+	}
+}
+
+// unregisterClient removes a client from the hub
+func (h *Hub) unregisterClient(client *Client) {
+	h.removeClient(client, nil)
+}
+
+// removeClient removes a client from the hub, closing its send channel. The
+// write pump then writes the messages still queued, followed by closeFrame.
+func (h *Hub) removeClient(client *Client, closeFrame []byte) {
+	h.mu.Lock()
+
+	var pending []roomEvent
+	if _, ok := h.clients[client]; ok {
+		delete(h.clients, client)
+		h.saveSession(client)
+		client.closeFrame = closeFrame
+
+		s := h.clientShard(client)
+		s.mu.Lock()
+		delete(s.clients, client)
+		s.mu.Unlock()
+		if client.UserID != "" {
+			s := h.shardFor(client.UserID)
+			s.mu.Lock()
+			s.removeUser(client)
+			s.mu.Unlock()
+		}
+		h.dropIP(client.ip)
+
+		// Remove from all rooms
+		for room := range client.rooms {
+			s := h.shardFor(room)
+			s.mu.Lock()
+			pending = append(pending, s.leave(client, room)...)
+			s.mu.Unlock()
+		}
+
+		// Deliveries no longer see the client, so its channel can be closed
+		close(client.send)
+
+		h.logger.Info("client unregistered",
+			slog.String("client_id", client.ID),
+			slog.String("user_id", client.UserID),
+		)
+	}
+
+	h.mu.Unlock()
+	h.publish(pending)
+}
+
+// addClientToRoom adds a client to a room
+func (h *Hub) addClientToRoom(client *Client, room string) {
+	h.mu.Lock()
+	s := h.shardFor(room)
+	s.mu.Lock()
+	pending := s.join(client, room)
+	s.mu.Unlock()
+	client.rooms[room] = true
+
+	h.logger.Info("client joined room",
+		slog.String("client_id", client.ID),
+		slog.String("room", room),
+	)
+
+	h.mu.Unlock()
+	h.publish(pending)
+}
+
+// removeClientFromRoom removes a client from a room
+func (h *Hub) removeClientFromRoom(client *Client, room string) {
+	h.mu.Lock()
+	s := h.shardFor(room)
+	s.mu.Lock()
+	pending := s.leave(client, room)
+	s.mu.Unlock()
+	delete(client.rooms, room)
+
+	h.logger.Info("client left room",
+		slog.String("client_id", client.ID),
+		slog.String("room", room),
+	)
+
+	h.mu.Unlock()
+	h.publish(pending)
+}
+
+// broadcastMessage sends a message to appropriate clients, or hands it to
+// the broker to reach clients on every instance
+func (h *Hub) broadcastMessage(message *Message) {
+	if message.Room != "" {
+		if violation := h.checkRoomPolicy(message); violation != nil {
+			h.rejectRoomMessage(message, violation)
+			return
+		}
+	}
+
+	if broker := h.brokerHub(); broker != nil {
+		broker.relay(&envelope{Message: message})
+		return
+	}
+	h.deliverMessage(message)
+}
+
+// deliverMessage sends a message to this instance's clients: the room's
+// members, or everyone. The shards deliver it in their own goroutines once
+// Run has started them.
+func (h *Hub) deliverMessage(message *Message) {
+	message = h.record(message, "")
+	if message.Room != "" {
+		h.shardFor(message.Room).dispatch(message)
+		return
+	}
+	for _, s := range h.shards {
+		s.dispatch(message)
+	}
+}
+
+// BroadcastToAll sends a message to all connected clients
+func (h *Hub) BroadcastToAll(message *Message) {
+	enqueue(h, h.broadcast, message)
+}
+
+// BroadcastToRoom sends a message to all clients in a room
+func (h *Hub) BroadcastToRoom(room string, message *Message) {
+	message.Room = room
+	enqueue(h, h.broadcast, message)
+}
+
+// BroadcastToUser sends a message to a specific user
+func (h *Hub) BroadcastToUser(userID string, message *Message) {
+	if broker := h.brokerHub(); broker != nil {
+		broker.relay(&envelope{UserID: userID, Message: message})
+		return
+	}
+	h.deliverToUser(userID, message)
+}
+
+// deliverToUser sends a message to a user's connections on this instance
+func (h *Hub) deliverToUser(userID string, message *Message) {
+	ctx, span := startFanOut("websocket.user_message", message)
+	var recipients, dropped int
+	defer func() { endFanOut(span, recipients, dropped) }()
+
+	message = h.record(message, userID)
+	s := h.shardFor(userID)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	frames := newEncoded(message)
+	for _, client := range s.users[userID] {
+		if client.replayed(message) {
+			continue
+		}
+		data, err := frames.frame(client)
+		if err != nil {
+			h.metrics.countDropped(ctx, DropEncodeFailed)
+			dropped++
+			continue
+		}
+		if !h.queue(ctx, client, data) {
+			dropped++
+			continue
+		}
+		recipients++
+	}
+}
+
+// sendToClient queues data for a single client, reporting false if the
+// client has unregistered or its buffer is full. Unlike writing to
+// client.send directly, it is safe to call after the client disconnects.
+func (h *Hub) sendToClient(client *Client, data []byte) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if !h.clients[client] {
+		return false
+	}
+	return h.queue(context.Background(), client, data)
+}
+
+// Backlog returns how many broadcasts are waiting for the hub loop and how
+// many can wait before senders block
+func (h *Hub) Backlog() (queued, capacity int) {
+	return len(h.broadcast), cap(h.broadcast)
+}
+
+// GetConnectedClients returns the number of connected clients
+func (h *Hub) GetConnectedClients() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.clients)
+}
+
+// GetRoomClients returns the number of clients in a room
+func (h *Hub) GetRoomClients(room string) int {
+	s := h.shardFor(room)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.rooms[room])
+}
@@ -0,0 +1,103 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"sort"
+	"time"
+)
+
+// MetricsRoom is the room live server metrics are streamed to
+const MetricsRoom = "metrics:live"
+
+// MessageTypeMetrics is the type of the messages streamed to MetricsRoom
+const MessageTypeMetrics = "metrics"
+
+// MetricFunc reads the current value of a metric. It is called from the
+// streamer's goroutine and must not block for long.
+type MetricFunc func() any
+
+// MetricsSnapshot is the payload of a metrics message. Behind a BrokerHub
+// every instance streams its own snapshots, told apart by Instance.
+type MetricsSnapshot struct {
+	Instance string         `json:"instance,omitempty"`
+	Time     time.Time      `json:"time"`
+	Metrics  map[string]any `json:"metrics"`
+}
+
+// MetricsStreamer broadcasts a snapshot of its metrics to MetricsRoom every
+// interval, for live dashboards. Nothing is read while the room is empty.
+type MetricsStreamer struct {
+	hub      *Hub
+	instance string
+	interval time.Duration
+	logger   *slog.Logger
+	names    []string
+	metrics  map[string]MetricFunc
+}
+
+// NewMetricsStreamer creates a streamer of metrics, by name
+func NewMetricsStreamer(hub *Hub, interval time.Duration, metrics map[string]MetricFunc, logger *slog.Logger) *MetricsStreamer {
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	instance, _ := os.Hostname()
+
+	return &MetricsStreamer{
+		hub:      hub,
+		instance: instance,
+		interval: interval,
+		logger:   logger,
+		names:    names,
+		metrics:  metrics,
+	}
+}
+
+// Metrics returns the names of the streamed metrics
+func (s *MetricsStreamer) Metrics() []string {
+	return s.names
+}
+
+// Run streams until ctx is cancelled
+func (s *MetricsStreamer) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if s.hub.GetRoomClients(MetricsRoom) == 0 {
+				continue
+			}
+			s.broadcast(s.Snapshot())
+		}
+	}
+}
+
+// Snapshot reads every metric
+func (s *MetricsStreamer) Snapshot() *MetricsSnapshot {
+	snapshot := &MetricsSnapshot{
+		Instance: s.instance,
+		Time:     time.Now(),
+		Metrics:  make(map[string]any, len(s.names)),
+	}
+	for _, name := range s.names {
+		snapshot.Metrics[name] = s.metrics[name]()
+	}
+	return snapshot
+}
+
+func (s *MetricsStreamer) broadcast(snapshot *MetricsSnapshot) {
+	payload, err := json.Marshal(snapshot)
+	if err != nil {
+		s.logger.Error("failed to encode metrics", slog.String("error", err.Error()))
+		return
+	}
+	s.hub.BroadcastToRoom(MetricsRoom, &Message{Type: MessageTypeMetrics, Payload: payload})
+}
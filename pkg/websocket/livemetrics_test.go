@@ -0,0 +1,71 @@
+package websocket
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pixperk/goiler/internal/testutil"
+)
+
+func TestRestrictRoom_RejectsOthers(t *testing.T) {
+	logger := testutil.Logger()
+	hub := NewHub(logger)
+	hub.RestrictRoom(MetricsRoom, func(c *Client) bool { return c.Role == "admin" })
+
+	admin := &Client{ID: "admin", Role: "admin", hub: hub, logger: logger, send: make(chan []byte, 16), rooms: make(map[string]bool)}
+	user := &Client{ID: "user", Role: "user", hub: hub, logger: logger, send: make(chan []byte, 16), rooms: make(map[string]bool)}
+
+	if !hub.allowed(admin, MetricsRoom) || !hub.allowed(user, "chat") {
+		t.Error("Expected admins in the restricted room and everyone elsewhere")
+	}
+
+	user.handleMessage(&Message{Type: "join", Payload: json.RawMessage(`{"room":"metrics:live"}`)})
+	user.handleMessage(&Message{Type: "room", Room: MetricsRoom, Payload: json.RawMessage(`{}`)})
+	if len(user.send) != 2 {
+		t.Fatalf("Expected 2 errors, got %d messages", len(user.send))
+	}
+	for range 2 {
+		if reply := string(<-user.send); !strings.Contains(reply, "FORBIDDEN") {
+			t.Errorf("Expected FORBIDDEN, got %s", reply)
+		}
+	}
+	if hub.GetRoomClients(MetricsRoom) != 0 || len(hub.broadcast) != 0 {
+		t.Error("Expected the user neither to join nor to send")
+	}
+}
+
+func TestMetricsStreamer_BroadcastsSnapshot(t *testing.T) {
+	logger := testutil.Logger()
+	hub := NewHub(logger)
+	admin := &Client{ID: "admin", hub: hub, send: make(chan []byte, 16), rooms: make(map[string]bool)}
+	hub.registerClient(admin)
+	hub.addClientToRoom(admin, MetricsRoom)
+
+	streamer := NewMetricsStreamer(hub, time.Second, map[string]MetricFunc{
+		"connected_clients": func() any { return hub.GetConnectedClients() },
+		"queue_depth":       func() any { return 7 },
+	}, logger)
+	if names := streamer.Metrics(); len(names) != 2 || names[0] != "connected_clients" {
+		t.Errorf("Expected sorted metric names, got %v", names)
+	}
+
+	streamer.broadcast(streamer.Snapshot())
+	hub.broadcastMessage(<-hub.broadcast)
+
+	var message struct {
+		Type    string          `json:"type"`
+		Room    string          `json:"room"`
+		Payload MetricsSnapshot `json:"payload"`
+	}
+	if err := json.Unmarshal(<-admin.send, &message); err != nil {
+		t.Fatalf("Invalid message: %v", err)
+	}
+	if message.Type != MessageTypeMetrics || message.Room != MetricsRoom {
+		t.Errorf("Expected a metrics message to %s, got %s to %s", MetricsRoom, message.Type, message.Room)
+	}
+	if message.Payload.Metrics["connected_clients"] != float64(1) || message.Payload.Metrics["queue_depth"] != float64(7) {
+		t.Errorf("Unexpected metrics: %v", message.Payload.Metrics)
+	}
+}
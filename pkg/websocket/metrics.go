@@ -0,0 +1,203 @@
+package websocket
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/pkg/response"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Reasons messages to clients are dropped, the reason label of
+// websocket_messages_dropped_total
+const (
+	// DropBufferFull is a client whose send buffer is full, usually because
+	// it reads slower than messages arrive
+	DropBufferFull = "buffer_full"
+	// DropEncodeFailed is a message the client's codec can't encode
+	DropEncodeFailed = "encode_failed"
+	// DropRoomPolicy is a message rejected by its room's policy
+	DropRoomPolicy = "room_policy"
+)
+
+// tracer traces broadcast fan-out
+var tracer = otel.Tracer("goiler/websocket")
+
+// Stats describes the hub's clients, and the messages it has sent since it
+// started
+type Stats struct {
+	ConnectedClients int `json:"connected_clients"`
+	// Users counts the distinct authenticated users connected
+	Users int `json:"users"`
+	Rooms int `json:"rooms"`
+	// MessagesSent counts messages queued to clients, once per recipient
+	MessagesSent int64 `json:"messages_sent"`
+	// MessagesDropped counts messages not queued to a recipient, for any
+	// reason; BufferFull counts those dropped for a full send buffer
+	MessagesDropped int64 `json:"messages_dropped"`
+	BufferFull      int64 `json:"buffer_full"`
+	// Evicted counts clients closed for being slow, unresponsive or over
+	// their user's connection quota
+	Evicted int64 `json:"evicted"`
+	// Backlog is the number of broadcasts waiting for the hub loop
+	Backlog int `json:"backlog"`
+}
+
+// hubMetrics count the messages the hub sends, for Stats and OTel
+type hubMetrics struct {
+	sentCounter    metric.Int64Counter
+	droppedCounter metric.Int64Counter
+	evictedCounter metric.Int64Counter
+
+	sent       atomic.Int64
+	dropped    atomic.Int64
+	bufferFull atomic.Int64
+	evicted    atomic.Int64
+}
+
+// newHubMetrics registers the hub's metrics with the global meter provider
+func newHubMetrics(h *Hub) *hubMetrics {
+	meter := otel.Meter("goiler/websocket")
+	m := &hubMetrics{}
+
+	m.sentCounter, _ = meter.Int64Counter(
+		"websocket_messages_sent_total",
+		metric.WithDescription("Total number of messages queued to WebSocket clients, once per recipient"),
+		metric.WithUnit("1"),
+	)
+	m.droppedCounter, _ = meter.Int64Counter(
+		"websocket_messages_dropped_total",
+		metric.WithDescription("Total number of messages not delivered to WebSocket clients, by reason"),
+		metric.WithUnit("1"),
+	)
+	m.evictedCounter, _ = meter.Int64Counter(
+		"websocket_clients_evicted_total",
+		metric.WithDescription("Total number of WebSocket clients closed by the server, by reason"),
+		metric.WithUnit("1"),
+	)
+	meter.Int64ObservableGauge(
+		"websocket_connected_clients",
+		metric.WithDescription("Number of connected WebSocket clients and event streams"),
+		metric.WithUnit("1"),
+		metric.WithInt64Callback(func(ctx context.Context, observer metric.Int64Observer) error {
+			observer.Observe(int64(h.GetConnectedClients()))
+			return nil
+		}),
+	)
+	meter.Int64ObservableGauge(
+		"websocket_rooms",
+		metric.WithDescription("Number of rooms with at least one member"),
+		metric.WithUnit("1"),
+		metric.WithInt64Callback(func(ctx context.Context, observer metric.Int64Observer) error {
+			observer.Observe(int64(h.roomCount()))
+			return nil
+		}),
+	)
+	return m
+}
+
+// countSent counts a message queued to a client
+func (m *hubMetrics) countSent(ctx context.Context) {
+	m.sent.Add(1)
+	m.sentCounter.Add(ctx, 1)
+}
+
+// countDropped counts a message not delivered for reason
+func (m *hubMetrics) countDropped(ctx context.Context, reason string) {
+	m.dropped.Add(1)
+	if reason == DropBufferFull {
+		m.bufferFull.Add(1)
+	}
+	m.droppedCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("reason", reason)))
+}
+
+// countEvicted counts a client closed for reason
+func (m *hubMetrics) countEvicted(ctx context.Context, reason string) {
+	m.evicted.Add(1)
+	m.evictedCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("reason", reason)))
+}
+
+// queue puts data on the client's send buffer without blocking, counting it
+// as sent or dropped
+func (h *Hub) queue(ctx context.Context, client *Client, data []byte) bool {
+	select {
+	case client.send <- data:
+		h.metrics.countSent(ctx)
+		client.slow.queued()
+		return true
+	default:
+		h.metrics.countDropped(ctx, DropBufferFull)
+		if client.slow.dropped() {
+			h.evictSlow(client)
+		}
+		return false
+	}
+}
+
+// startFanOut starts the span of a message's delivery to this instance's
+// clients
+func startFanOut(name string, message *Message) (context.Context, trace.Span) {
+	return tracer.Start(context.Background(), name, trace.WithAttributes(
+		attribute.String("websocket.message_type", message.Type),
+		attribute.String("websocket.room", message.Room),
+	))
+}
+
+// endFanOut records how many clients a message was queued to and how many
+// missed it, then ends the span
+func endFanOut(span trace.Span, recipients, dropped int) {
+	span.SetAttributes(
+		attribute.Int("websocket.recipients", recipients),
+		attribute.Int("websocket.dropped", dropped),
+	)
+	span.End()
+}
+
+// roomCount returns the number of rooms with at least one member
+func (h *Hub) roomCount() int {
+	rooms := 0
+	for _, s := range h.shards {
+		s.mu.RLock()
+		rooms += len(s.rooms)
+		s.mu.RUnlock()
+	}
+	return rooms
+}
+
+// Stats returns the hub's client counts and message totals
+func (h *Hub) Stats() Stats {
+	h.mu.RLock()
+	stats := Stats{ConnectedClients: len(h.clients)}
+	h.mu.RUnlock()
+	for _, s := range h.shards {
+		s.mu.RLock()
+		stats.Users += len(s.users)
+		stats.Rooms += len(s.rooms)
+		s.mu.RUnlock()
+	}
+
+	stats.MessagesSent = h.metrics.sent.Load()
+	stats.MessagesDropped = h.metrics.dropped.Load()
+	stats.BufferFull = h.metrics.bufferFull.Load()
+	stats.Evicted = h.metrics.evicted.Load()
+	stats.Backlog, _ = h.Backlog()
+	return stats
+}
+
+// HandleStats returns the hub's statistics
+// @Summary WebSocket statistics
+// @Description Report this instance's connected clients, users and rooms, and the messages sent and dropped since it started
+// @Tags WebSocket
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} Stats
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Router /api/v1/ws/stats [get]
+func (h *Handler) HandleStats(c echo.Context) error {
+	return response.Success(c, h.GetStats())
+}
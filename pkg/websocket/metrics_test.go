@@ -0,0 +1,39 @@
+package websocket
+
+import (
+	"testing"
+
+	"github.com/pixperk/goiler/internal/testutil"
+)
+
+func TestHub_Stats(t *testing.T) {
+	logger := testutil.Logger()
+	hub := NewHub(logger)
+	if err := hub.SetRoomPolicy("quiet", RoomPolicy{AllowedTypes: []string{"room"}}); err != nil {
+		t.Fatalf("SetRoomPolicy failed: %v", err)
+	}
+
+	fast := &Client{ID: "fast", UserID: "user-1", hub: hub, logger: logger, send: make(chan []byte, 16), rooms: make(map[string]bool)}
+	slow := &Client{ID: "slow", hub: hub, logger: logger, send: make(chan []byte, 1), rooms: make(map[string]bool)}
+	hub.registerClient(fast)
+	hub.registerClient(slow)
+	hub.addClientToRoom(fast, "chat")
+
+	hub.deliverMessage(&Message{Type: "update"})
+	hub.deliverMessage(&Message{Type: "update"})
+	hub.deliverMessage(&Message{Type: "update", Room: "chat"})
+	hub.deliverToUser("user-1", &Message{Type: "notice"})
+	hub.broadcastMessage(&Message{Type: "other", Room: "quiet"})
+
+	want := Stats{
+		ConnectedClients: 2,
+		Users:            1,
+		Rooms:            1,
+		MessagesSent:     5,
+		MessagesDropped:  2,
+		BufferFull:       1,
+	}
+	if got := hub.Stats(); got != want {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+}
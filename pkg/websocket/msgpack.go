@@ -0,0 +1,444 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/gorilla/websocket"
+)
+
+// msgpackMaxDepth bounds the nesting of decoded values
+const msgpackMaxDepth = 64
+
+var errMsgpackTruncated = errors.New("msgpack: unexpected end of data")
+
+// msgpackCodec sends messages as MessagePack maps in binary frames, with
+// the same keys as JSON. Payloads, params and results are converted between
+// JSON and native MessagePack values.
+type msgpackCodec struct{}
+
+func (msgpackCodec) Subprotocol() string { return SubprotocolMsgpack }
+func (msgpackCodec) FrameType() int      { return websocket.BinaryMessage }
+
+// msgpackField is a message field as it is encoded: a string, or a JSON
+// document converted to a native value
+type msgpackField struct {
+	key   string
+	value string
+	raw   []byte
+}
+
+// Encode encodes message as a MessagePack map
+func (msgpackCodec) Encode(message *Message) ([]byte, error) {
+	fields := []msgpackField{
+		{key: "type", value: message.Type},
+		{key: "room", value: message.Room},
+		{key: "payload", raw: message.Payload},
+		{key: "id", value: message.ID},
+		{key: "method", value: message.Method},
+		{key: "params", raw: message.Params},
+		{key: "result", raw: message.Result},
+	}
+	if message.Seq > 0 {
+		fields = append(fields, msgpackField{key: "seq", raw: strconv.AppendUint(nil, message.Seq, 10)})
+	}
+	if message.Error != nil {
+		raw, err := json.Marshal(message.Error)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, msgpackField{key: "error", raw: raw})
+	}
+
+	// Like in JSON, type is always sent and empty fields are left out
+	present := fields[:0]
+	for _, f := range fields {
+		if f.key == "type" || f.value != "" || len(f.raw) > 0 {
+			present = append(present, f)
+		}
+	}
+
+	b := appendMsgpackMapHeader(nil, len(present))
+	for _, f := range present {
+		b = appendMsgpackString(b, f.key)
+		if len(f.raw) == 0 {
+			b = appendMsgpackString(b, f.value)
+			continue
+		}
+		var err error
+		if b, err = appendMsgpackJSON(b, f.raw); err != nil {
+			return nil, fmt.Errorf("msgpack: %s: %w", f.key, err)
+		}
+	}
+	return b, nil
+}
+
+// Decode decodes a MessagePack map into a message
+func (msgpackCodec) Decode(data []byte) (*Message, error) {
+	r := &msgpackReader{data: data}
+	value, err := r.readValue(0)
+	if err != nil {
+		return nil, err
+	}
+	if r.pos != len(data) {
+		return nil, errors.New("msgpack: trailing data")
+	}
+	fields, ok := value.(map[string]any)
+	if !ok {
+		return nil, errors.New("msgpack: message must be a map")
+	}
+
+	var msg Message
+	for key, value := range fields {
+		var target *string
+		switch key {
+		case "type":
+			target = &msg.Type
+		case "room":
+			target = &msg.Room
+		case "id":
+			target = &msg.ID
+		case "method":
+			target = &msg.Method
+		}
+		if target != nil {
+			s, ok := value.(string)
+			if !ok && value != nil {
+				return nil, fmt.Errorf("msgpack: %s must be a string", key)
+			}
+			*target = s
+			continue
+		}
+
+		if value == nil {
+			continue
+		}
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("msgpack: %s: %w", key, err)
+		}
+		switch key {
+		case "payload":
+			msg.Payload = raw
+		case "params":
+			msg.Params = raw
+		case "result":
+			msg.Result = raw
+		case "seq":
+			if msg.Seq, err = strconv.ParseUint(string(raw), 10, 64); err != nil {
+				return nil, errors.New("msgpack: seq must be an unsigned integer")
+			}
+		case "error":
+			if err := json.Unmarshal(raw, &msg.Error); err != nil {
+				return nil, fmt.Errorf("msgpack: error: %w", err)
+			}
+		}
+	}
+	return &msg, nil
+}
+
+// appendMsgpackJSON converts the JSON document raw to MessagePack
+func appendMsgpackJSON(b, raw []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var value any
+	if err := dec.Decode(&value); err != nil {
+		return nil, err
+	}
+	return appendMsgpackValue(b, value)
+}
+
+// appendMsgpackValue appends a value decoded from JSON
+func appendMsgpackValue(b []byte, value any) ([]byte, error) {
+	switch v := value.(type) {
+	case nil:
+		return append(b, 0xc0), nil
+	case bool:
+		if v {
+			return append(b, 0xc3), nil
+		}
+		return append(b, 0xc2), nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return appendMsgpackInt(b, i), nil
+		}
+		if u, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			return binary.BigEndian.AppendUint64(append(b, 0xcf), u), nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return binary.BigEndian.AppendUint64(append(b, 0xcb), math.Float64bits(f)), nil
+	case string:
+		return appendMsgpackString(b, v), nil
+	case []any:
+		b = appendMsgpackHeader(b, len(v), 0x90, 0xdc, 0xdd)
+		for _, item := range v {
+			var err error
+			if b, err = appendMsgpackValue(b, item); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case map[string]any:
+		b = appendMsgpackMapHeader(b, len(v))
+		for key, item := range v {
+			b = appendMsgpackString(b, key)
+			var err error
+			if b, err = appendMsgpackValue(b, item); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	default:
+		return nil, fmt.Errorf("unsupported value %T", value)
+	}
+}
+
+// appendMsgpackInt appends i in its smallest encoding
+func appendMsgpackInt(b []byte, i int64) []byte {
+	switch {
+	case i >= 0 && i <= math.MaxInt8:
+		return append(b, byte(i))
+	case i < 0 && i >= -32:
+		return append(b, byte(i))
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		return append(b, 0xd0, byte(i))
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		return binary.BigEndian.AppendUint16(append(b, 0xd1), uint16(i))
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		return binary.BigEndian.AppendUint32(append(b, 0xd2), uint32(i))
+	default:
+		return binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(i))
+	}
+}
+
+// appendMsgpackString appends s as a str
+func appendMsgpackString(b []byte, s string) []byte {
+	n := len(s)
+	switch {
+	case n < 32:
+		b = append(b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, 0xd9, byte(n))
+	default:
+		b = appendMsgpackHeader(b, n, 0, 0xda, 0xdb)
+	}
+	return append(b, s...)
+}
+
+// appendMsgpackMapHeader appends the header of a map of n pairs
+func appendMsgpackMapHeader(b []byte, n int) []byte {
+	return appendMsgpackHeader(b, n, 0x80, 0xde, 0xdf)
+}
+
+// appendMsgpackHeader appends the header of a collection of n items: fix
+// when it fits in four bits and fix is non-zero, else the 16 or 32-bit form
+func appendMsgpackHeader(b []byte, n int, fix, code16, code32 byte) []byte {
+	switch {
+	case fix != 0 && n < 16:
+		return append(b, fix|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, code16), uint16(n))
+	default:
+		return binary.BigEndian.AppendUint32(append(b, code32), uint32(n))
+	}
+}
+
+// msgpackReader decodes MessagePack values into the types JSON decodes to,
+// except that integers stay integers and bin values are []byte
+type msgpackReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *msgpackReader) next(n int) ([]byte, error) {
+	if n < 0 || len(r.data)-r.pos < n {
+		return nil, errMsgpackTruncated
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+// length reads a big-endian length of size bytes
+func (r *msgpackReader) length(size int) (int, error) {
+	b, err := r.next(size)
+	if err != nil {
+		return 0, err
+	}
+	switch size {
+	case 1:
+		return int(b[0]), nil
+	case 2:
+		return int(binary.BigEndian.Uint16(b)), nil
+	default:
+		return int(binary.BigEndian.Uint32(b)), nil
+	}
+}
+
+func (r *msgpackReader) readValue(depth int) (any, error) {
+	if depth > msgpackMaxDepth {
+		return nil, errors.New("msgpack: nested too deeply")
+	}
+	head, err := r.next(1)
+	if err != nil {
+		return nil, err
+	}
+	c := head[0]
+
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xf0 == 0x80:
+		return r.readMap(int(c&0x0f), depth)
+	case c&0xf0 == 0x90:
+		return r.readArray(int(c&0x0f), depth)
+	case c&0xe0 == 0xa0:
+		return r.readString(int(c & 0x1f))
+	}
+
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := r.length(1 << (c - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		b, err := r.next(n)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.Clone(b), nil
+	case 0xca:
+		b, err := r.next(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	case 0xcb:
+		b, err := r.next(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		b, err := r.next(1 << (c - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		var u uint64
+		for _, x := range b {
+			u = u<<8 | uint64(x)
+		}
+		if u <= math.MaxInt64 {
+			return int64(u), nil
+		}
+		return u, nil
+	case 0xd0:
+		b, err := r.next(1)
+		if err != nil {
+			return nil, err
+		}
+		return int64(int8(b[0])), nil
+	case 0xd1:
+		b, err := r.next(2)
+		if err != nil {
+			return nil, err
+		}
+		return int64(int16(binary.BigEndian.Uint16(b))), nil
+	case 0xd2:
+		b, err := r.next(4)
+		if err != nil {
+			return nil, err
+		}
+		return int64(int32(binary.BigEndian.Uint32(b))), nil
+	case 0xd3:
+		b, err := r.next(8)
+		if err != nil {
+			return nil, err
+		}
+		return int64(binary.BigEndian.Uint64(b)), nil
+	case 0xd9, 0xda, 0xdb:
+		n, err := r.length(1 << (c - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return r.readString(n)
+	case 0xdc, 0xdd:
+		n, err := r.length(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return r.readArray(n, depth)
+	case 0xde, 0xdf:
+		n, err := r.length(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return r.readMap(n, depth)
+	default:
+		return nil, fmt.Errorf("msgpack: unsupported type 0x%02x", c)
+	}
+}
+
+func (r *msgpackReader) readString(n int) (string, error) {
+	b, err := r.next(n)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (r *msgpackReader) readArray(n, depth int) ([]any, error) {
+	// Every item takes at least a byte
+	if n > len(r.data)-r.pos {
+		return nil, errMsgpackTruncated
+	}
+	items := make([]any, n)
+	for i := range items {
+		item, err := r.readValue(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		items[i] = item
+	}
+	return items, nil
+}
+
+func (r *msgpackReader) readMap(n, depth int) (map[string]any, error) {
+	// Every pair takes at least two bytes
+	if n > (len(r.data)-r.pos)/2 {
+		return nil, errMsgpackTruncated
+	}
+	m := make(map[string]any, n)
+	for range n {
+		key, err := r.readValue(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		s, ok := key.(string)
+		if !ok {
+			return nil, errors.New("msgpack: map keys must be strings")
+		}
+		value, err := r.readValue(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		m[s] = value
+	}
+	return m, nil
+}
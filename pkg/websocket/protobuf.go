@@ -0,0 +1,196 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Field numbers of the Message and Error types in message.proto
+const (
+	protoFieldType    protowire.Number = 1
+	protoFieldRoom    protowire.Number = 2
+	protoFieldPayload protowire.Number = 3
+	protoFieldID      protowire.Number = 4
+	protoFieldMethod  protowire.Number = 5
+	protoFieldParams  protowire.Number = 6
+	protoFieldResult  protowire.Number = 7
+	protoFieldError   protowire.Number = 8
+	protoFieldSeq     protowire.Number = 9
+
+	protoFieldErrorCode    protowire.Number = 1
+	protoFieldErrorMessage protowire.Number = 2
+	protoFieldErrorDetails protowire.Number = 3
+)
+
+// protobufCodec sends messages as the Message type of message.proto in
+// binary frames. Payloads, params, results and error details are carried
+// as JSON bytes, so they can be relayed to clients using other codecs.
+type protobufCodec struct{}
+
+func (protobufCodec) Subprotocol() string { return SubprotocolProtobuf }
+func (protobufCodec) FrameType() int      { return websocket.BinaryMessage }
+
+// Encode encodes message as a Message
+func (protobufCodec) Encode(message *Message) ([]byte, error) {
+	var b []byte
+	b = appendProtoString(b, protoFieldType, message.Type)
+	b = appendProtoString(b, protoFieldRoom, message.Room)
+	b = appendProtoBytes(b, protoFieldPayload, message.Payload)
+	b = appendProtoString(b, protoFieldID, message.ID)
+	b = appendProtoString(b, protoFieldMethod, message.Method)
+	b = appendProtoBytes(b, protoFieldParams, message.Params)
+	b = appendProtoBytes(b, protoFieldResult, message.Result)
+
+	if e := message.Error; e != nil {
+		var details []byte
+		if e.Details != nil {
+			var err error
+			if details, err = json.Marshal(e.Details); err != nil {
+				return nil, err
+			}
+		}
+		var eb []byte
+		eb = appendProtoString(eb, protoFieldErrorCode, e.Code)
+		eb = appendProtoString(eb, protoFieldErrorMessage, e.Message)
+		eb = appendProtoBytes(eb, protoFieldErrorDetails, details)
+
+		b = protowire.AppendTag(b, protoFieldError, protowire.BytesType)
+		b = protowire.AppendBytes(b, eb)
+	}
+	if message.Seq > 0 {
+		b = protowire.AppendTag(b, protoFieldSeq, protowire.VarintType)
+		b = protowire.AppendVarint(b, message.Seq)
+	}
+	return b, nil
+}
+
+// Decode decodes a Message. Unknown fields are skipped, and JSON fields
+// must hold valid JSON.
+func (protobufCodec) Decode(data []byte) (*Message, error) {
+	var msg Message
+	err := consumeProtoFields(data, func(num protowire.Number, value []byte) error {
+		switch num {
+		case protoFieldType:
+			msg.Type = string(value)
+		case protoFieldRoom:
+			msg.Room = string(value)
+		case protoFieldID:
+			msg.ID = string(value)
+		case protoFieldMethod:
+			msg.Method = string(value)
+		case protoFieldPayload:
+			msg.Payload = bytes.Clone(value)
+		case protoFieldParams:
+			msg.Params = bytes.Clone(value)
+		case protoFieldResult:
+			msg.Result = bytes.Clone(value)
+		case protoFieldError:
+			e, err := decodeProtoError(value)
+			if err != nil {
+				return err
+			}
+			msg.Error = e
+		}
+		return nil
+	}, func(num protowire.Number, value uint64) {
+		if num == protoFieldSeq {
+			msg.Seq = value
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for name, raw := range map[string][]byte{"payload": msg.Payload, "params": msg.Params, "result": msg.Result} {
+		if len(raw) > 0 && !json.Valid(raw) {
+			return nil, fmt.Errorf("protobuf: %s is not valid JSON", name)
+		}
+	}
+	return &msg, nil
+}
+
+// decodeProtoError decodes an Error
+func decodeProtoError(data []byte) (*RPCError, error) {
+	var e RPCError
+	err := consumeProtoFields(data, func(num protowire.Number, value []byte) error {
+		switch num {
+		case protoFieldErrorCode:
+			e.Code = string(value)
+		case protoFieldErrorMessage:
+			e.Message = string(value)
+		case protoFieldErrorDetails:
+			if err := json.Unmarshal(value, &e.Details); err != nil {
+				return fmt.Errorf("protobuf: error details: %w", err)
+			}
+		}
+		return nil
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// consumeProtoFields calls field with the length-delimited fields of data
+// and varint, if set, with its varint fields, skipping fields of other wire
+// types. Like proto3, the last occurrence of a field wins.
+func consumeProtoFields(data []byte, field func(num protowire.Number, value []byte) error, varint func(num protowire.Number, value uint64)) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		if typ == protowire.VarintType && varint != nil {
+			value, n := protowire.ConsumeVarint(data)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			data = data[n:]
+			varint(num, value)
+			continue
+		}
+		if typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, data)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			data = data[n:]
+			continue
+		}
+
+		value, n := protowire.ConsumeBytes(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		if err := field(num, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendProtoString appends a string field, leaving it out when empty like
+// proto3
+func appendProtoString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+// appendProtoBytes appends a bytes field, leaving it out when empty
+func appendProtoBytes(b []byte, num protowire.Number, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
@@ -0,0 +1,200 @@
+package websocket
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"slices"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/pkg/authctx"
+	"github.com/pixperk/goiler/pkg/response"
+)
+
+// PushKeyHeader carries the API key of a service pushing messages
+const PushKeyHeader = "X-API-Key"
+
+// reservedTypes are the message types the hub sends itself, which pushed
+// messages may not impersonate
+var reservedTypes = []string{
+	"connected", "error", "pong",
+	MessageTypeAuthenticated, MessageTypeResumed, MessageTypeRPCResult, MessageTypeMetrics,
+	MessageTypeTaskProgress,
+}
+
+// PushRequest is a message pushed to clients through the API
+type PushRequest struct {
+	Type    string          `json:"type" example:"order.shipped"`
+	Payload json.RawMessage `json:"payload,omitempty" swaggertype:"object"`
+}
+
+// WithPushKeys accepts the API keys in keys, sent in the X-API-Key header,
+// on the push endpoints guarded by RequirePushKey
+func WithPushKeys(keys ...string) HandlerOption {
+	return func(h *Handler) {
+		for _, key := range keys {
+			if key != "" {
+				h.pushKeys = append(h.pushKeys, sha256.Sum256([]byte(key)))
+			}
+		}
+	}
+}
+
+// WithPushRecipients checks the recipient of each message users push to a
+// user with allowed, such as to keep pushes within the pusher's tenant.
+// ctx is the push request's. Messages pushed with an API key aren't
+// checked.
+func WithPushRecipients(allowed func(ctx context.Context, userID string) (bool, error)) HandlerOption {
+	return func(h *Handler) {
+		h.pushRecipient = allowed
+	}
+}
+
+// RequirePushKey lets requests with a valid push API key through and
+// rejects those with an invalid one. Requests without a key go through
+// otherwise instead, such as authentication and a permission check.
+func (h *Handler) RequirePushKey(otherwise ...echo.MiddlewareFunc) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		fallback := next
+		for i := len(otherwise) - 1; i >= 0; i-- {
+			fallback = otherwise[i](fallback)
+		}
+		return func(c echo.Context) error {
+			key := c.Request().Header.Get(PushKeyHeader)
+			if key == "" {
+				return fallback(c)
+			}
+			if !h.validPushKey(key) {
+				return response.Unauthorized(c, "Invalid API key")
+			}
+			return next(c)
+		}
+	}
+}
+
+// validPushKey reports whether key is one of the push API keys, comparing
+// hashes in constant time
+func (h *Handler) validPushKey(key string) bool {
+	sum := sha256.Sum256([]byte(key))
+	valid := false
+	for _, k := range h.pushKeys {
+		if subtle.ConstantTimeCompare(sum[:], k[:]) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+// HandlePushAll broadcasts a message to every connected client
+// @Summary Broadcast to all WebSocket clients
+// @Description Push a message to every client connected to any instance. Requires the platform:broadcast permission or a push API key in X-API-Key.
+// @Tags WebSocket
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body PushRequest true "Message"
+// @Success 202 {object} Message
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 422 {object} response.Response
+// @Router /api/v1/ws/broadcast [post]
+func (h *Handler) HandlePushAll(c echo.Context) error {
+	message, err := h.bindPush(c)
+	if message == nil {
+		return err
+	}
+	h.hub.BroadcastToAll(message)
+	h.logPush(c, message, slog.String("target", "all"))
+	return response.Accepted(c, message)
+}
+
+// HandlePushRoom broadcasts a message to a room
+// @Summary Broadcast to a WebSocket room
+// @Description Push a message to the members of a room, in any tenant, on any instance. Requires the platform:broadcast permission or a push API key in X-API-Key.
+// @Tags WebSocket
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param room path string true "Room name"
+// @Param request body PushRequest true "Message"
+// @Success 202 {object} Message
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 422 {object} response.Response
+// @Router /api/v1/ws/rooms/{room}/broadcast [post]
+func (h *Handler) HandlePushRoom(c echo.Context) error {
+	message, err := h.bindPush(c)
+	if message == nil {
+		return err
+	}
+	room := c.Param("room")
+	h.hub.BroadcastToRoom(room, message)
+	h.logPush(c, message, slog.String("room", room))
+	return response.Accepted(c, message)
+}
+
+// HandlePushUser sends a message to a user's connections
+// @Summary Send to a WebSocket user
+// @Description Push a message to every connection of a user on any instance. Requires the ws:push permission, for users in the pusher's tenant, or a push API key in X-API-Key.
+// @Tags WebSocket
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param request body PushRequest true "Message"
+// @Success 202 {object} Message
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 404 {object} response.Response "User not in the pusher's tenant"
+// @Failure 422 {object} response.Response
+// @Router /api/v1/ws/users/{id}/send [post]
+func (h *Handler) HandlePushUser(c echo.Context) error {
+	message, err := h.bindPush(c)
+	if message == nil {
+		return err
+	}
+	userID := c.Param("id")
+	if _, pushedByUser := authctx.Get(c); pushedByUser && h.pushRecipient != nil {
+		allowed, err := h.pushRecipient(c.Request().Context(), userID)
+		if err != nil {
+			h.logger.Error("failed to check push recipient", slog.String("user_id", userID), slog.String("error", err.Error()))
+			return response.InternalError(c, "Failed to push message")
+		}
+		if !allowed {
+			return response.NotFound(c, "User not found")
+		}
+	}
+	h.hub.BroadcastToUser(userID, message)
+	h.logPush(c, message, slog.String("user_id", userID))
+	return response.Accepted(c, message)
+}
+
+// bindPush reads the message to push, writing the error response and
+// returning nil when it's invalid
+func (h *Handler) bindPush(c echo.Context) (*Message, error) {
+	var req PushRequest
+	if err := c.Bind(&req); err != nil {
+		return nil, response.BadRequest(c, "Invalid request body")
+	}
+	if req.Type == "" {
+		return nil, response.ValidationError(c, map[string]string{"type": "type is required"})
+	}
+	if slices.Contains(reservedTypes, req.Type) {
+		return nil, response.ValidationError(c, map[string]string{"type": "type " + req.Type + " is reserved"})
+	}
+	return &Message{Type: req.Type, Payload: req.Payload}, nil
+}
+
+// logPush logs a pushed message with who pushed it
+func (h *Handler) logPush(c echo.Context, message *Message, target slog.Attr) {
+	pusher := slog.String("pusher", "api_key")
+	if user, ok := authctx.Get(c); ok {
+		pusher = slog.String("pusher", user.ID.String())
+	}
+	h.logger.Info("message pushed", slog.String("type", message.Type), target, pusher)
+}
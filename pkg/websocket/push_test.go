@@ -0,0 +1,132 @@
+package websocket
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/internal/testutil"
+	"github.com/pixperk/goiler/pkg/authctx"
+)
+
+func TestHandler_Push(t *testing.T) {
+	logger := testutil.Logger()
+	hub := NewHub(logger)
+	go hub.Run()
+	defer hub.Shutdown(t.Context())
+
+	member := &Client{ID: "member", UserID: "user-1", hub: hub, logger: logger, send: make(chan []byte, 16), rooms: make(map[string]bool)}
+	hub.registerClient(member)
+	hub.addClientToRoom(member, "chat")
+
+	h := NewHandler(hub, logger, WithPushKeys("secret"))
+	e := echo.New()
+	// Requests without a key are turned away, like a missing user token
+	canPush := h.RequirePushKey(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			return c.NoContent(http.StatusUnauthorized)
+		}
+	})
+	e.POST("/ws/broadcast", h.HandlePushAll, canPush)
+	e.POST("/ws/rooms/:room/broadcast", h.HandlePushRoom, canPush)
+	e.POST("/ws/users/:id/send", h.HandlePushUser, canPush)
+
+	tests := []struct {
+		name string
+		path string
+		key  string
+		body string
+		code int
+		want string
+	}{
+		{"all", "/ws/broadcast", "secret", `{"type":"notice"}`, http.StatusAccepted, "notice"},
+		{"room", "/ws/rooms/chat/broadcast", "secret", `{"type":"chat","payload":{"text":"hi"}}`, http.StatusAccepted, "chat"},
+		{"user", "/ws/users/user-1/send", "secret", `{"type":"alert"}`, http.StatusAccepted, "alert"},
+		{"other room", "/ws/rooms/other/broadcast", "secret", `{"type":"chat"}`, http.StatusAccepted, ""},
+		{"invalid key", "/ws/broadcast", "wrong", `{"type":"notice"}`, http.StatusUnauthorized, ""},
+		{"no key", "/ws/broadcast", "", `{"type":"notice"}`, http.StatusUnauthorized, ""},
+		{"no type", "/ws/broadcast", "secret", `{}`, http.StatusUnprocessableEntity, ""},
+		{"reserved type", "/ws/broadcast", "secret", `{"type":"connected"}`, http.StatusUnprocessableEntity, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			if tt.key != "" {
+				req.Header.Set(PushKeyHeader, tt.key)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			if rec.Code != tt.code {
+				t.Fatalf("Expected %d, got %d: %s", tt.code, rec.Code, rec.Body)
+			}
+
+			if tt.want == "" {
+				// Give a misdirected broadcast time to arrive
+				time.Sleep(20 * time.Millisecond)
+				if len(member.send) != 0 {
+					t.Fatalf("Expected no message, got %d", len(member.send))
+				}
+				return
+			}
+			select {
+			case data := <-member.send:
+				if msg, err := DecodeMessage(data); err != nil || msg.Type != tt.want {
+					t.Errorf("Expected a %s message, got %s", tt.want, data)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("Expected a %s message", tt.want)
+			}
+		})
+	}
+}
+
+func TestHandler_PushRecipients(t *testing.T) {
+	logger := testutil.Logger()
+	hub := NewHub(logger)
+	go hub.Run()
+	defer hub.Shutdown(t.Context())
+
+	h := NewHandler(hub, logger, WithPushKeys("secret"), WithPushRecipients(func(_ context.Context, userID string) (bool, error) {
+		return userID == "same-tenant", nil
+	}))
+	e := echo.New()
+	canPush := h.RequirePushKey(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			authctx.Set(c, &authctx.User{ID: uuid.New(), Role: "user"})
+			return next(c)
+		}
+	})
+	e.POST("/ws/users/:id/send", h.HandlePushUser, canPush)
+
+	tests := []struct {
+		name string
+		user string
+		key  string
+		code int
+	}{
+		{"same tenant", "same-tenant", "", http.StatusAccepted},
+		{"other tenant", "other-tenant", "", http.StatusNotFound},
+		// API keys aren't tied to a tenant
+		{"api key", "other-tenant", "secret", http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/ws/users/"+tt.user+"/send", strings.NewReader(`{"type":"alert"}`))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			if tt.key != "" {
+				req.Header.Set(PushKeyHeader, tt.key)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			if rec.Code != tt.code {
+				t.Errorf("Expected %d, got %d: %s", tt.code, rec.Code, rec.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package websocket
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/pkg/authctx"
+	"github.com/pixperk/goiler/pkg/response"
+)
+
+// CloseTooManyConnections is the close code sent to connections refused or
+// evicted for exceeding the per-user connection quota
+const CloseTooManyConnections = 4429
+
+// Connection quota policies
+const (
+	// QuotaReject refuses new connections once a user is at the limit
+	QuotaReject = "reject"
+	// QuotaEvictOldest accepts new connections and closes the user's oldest
+	QuotaEvictOldest = "evict_oldest"
+)
+
+// Presence describes a user's connections to this hub. With several
+// instances, each reports only the connections it holds.
+type Presence struct {
+	UserID      string `json:"user_id"`
+	Online      bool   `json:"online"`
+	Connections int    `json:"connections"`
+	// Limit is the per-user connection quota, 0 if unlimited
+	Limit int `json:"limit,omitempty"`
+}
+
+// SetConnectionQuota limits how many connections each authenticated user
+// may have open; anonymous connections are not counted. The quota is per
+// hub, so per instance: behind a load balancer a user may hold up to limit
+// connections on every instance. It is also soft: connections opened at
+// the same instant may briefly exceed it. A limit of 0 disables the quota.
+func (h *Hub) SetConnectionQuota(limit int, policy string) error {
+	switch policy {
+	case QuotaReject, QuotaEvictOldest:
+	default:
+		return fmt.Errorf("unknown connection quota policy %q", policy)
+	}
+	if limit < 0 {
+		return fmt.Errorf("connection quota must not be negative, got %d", limit)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.quota = limit
+	h.quotaPolicy = policy
+	return nil
+}
+
+// UserConnections returns the number of connections a user has open
+func (h *Hub) UserConnections(userID string) int {
+	return len(h.userClients(userID))
+}
+
+// Presence returns the user's connection count and quota on this hub
+func (h *Hub) Presence(userID string) Presence {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	connections := len(h.userClients(userID))
+	return Presence{
+		UserID:      userID,
+		Online:      connections > 0,
+		Connections: connections,
+		Limit:       h.quota,
+	}
+}
+
+// overQuota reports whether a new connection for the user must be refused
+func (h *Hub) overQuota(userID string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return userID != "" && h.quota > 0 && h.quotaPolicy == QuotaReject && len(h.userClients(userID)) >= h.quota
+}
+
+// reserveIP takes a connection slot for ip, reporting false if the per-IP
+// limit leaves none. Checking and taking the slot under one lock keeps
+// concurrent upgrades from all passing the check. The slot is freed when
+// the client is removed from the hub, or by releaseIP if it never joins.
+func (h *Handler) reserveIP(ip string) bool {
+	if ip == "" {
+		return true
+	}
+	h.hub.mu.Lock()
+	defer h.hub.mu.Unlock()
+	if h.maxPerIP > 0 && h.hub.ips[ip] >= h.maxPerIP {
+		return false
+	}
+	h.hub.ips[ip]++
+	return true
+}
+
+// releaseIP frees a slot taken by reserveIP for a client that never joined
+// the hub
+func (h *Hub) releaseIP(ip string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.dropIP(ip)
+}
+
+// dropIP frees one of ip's connection slots. The caller must hold h.mu.
+func (h *Hub) dropIP(ip string) {
+	if ip == "" {
+		return
+	}
+	if h.ips[ip]--; h.ips[ip] <= 0 {
+		delete(h.ips, ip)
+	}
+}
+
+// evictOverQuota returns the user's oldest clients beyond the quota when
+// the policy evicts. The caller must hold h.mu.
+func (h *Hub) evictOverQuota(userID string) []*Client {
+	if userID == "" || h.quota == 0 || h.quotaPolicy != QuotaEvictOldest {
+		return nil
+	}
+	clients := h.userClients(userID)
+	excess := len(clients) - h.quota
+	if excess <= 0 {
+		return nil
+	}
+	return clients[:excess]
+}
+
+// closeConn sends a close frame with code and reason, then closes the
+// connection. Closing ends the read pump, which unregisters the client.
+func closeConn(conn *websocket.Conn, code int, reason string) {
+	msg := websocket.FormatCloseMessage(code, reason)
+	conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(writeWait))
+	conn.Close()
+}
+
+// close closes the client's connection with code and reason. Event stream
+// clients have no connection and are cancelled, ending their stream.
+func (c *Client) close(code int, reason string) {
+	if c.conn == nil {
+		c.cancel()
+		return
+	}
+	closeConn(c.conn, code, reason)
+}
+
+// rejectOverQuota upgrades and immediately closes a connection over the
+// per-user quota or the per-IP limit, so browser clients can read the close
+// code. attr identifies who is over the limit.
+func (h *Handler) rejectOverQuota(c echo.Context, attr slog.Attr) error {
+	h.logger.Warn("websocket connection quota exceeded", attr)
+	conn, err := h.upgrader.Upgrade(c.Response(), c.Request(), nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusTooManyRequests, "too many connections")
+	}
+	closeConn(conn, CloseTooManyConnections, "too many connections")
+	return nil
+}
+
+// HandlePresence returns a user's connection count on this instance. Guard
+// it with RequireSelf.
+// @Summary WebSocket presence
+// @Description Report whether a user is connected to the instance serving the request and how many connections they have open there, with the per-instance limit. Users may look up their own presence; others need a permission
+// @Tags WebSocket
+// @Security BearerAuth
+// @Produce json
+// @Param user_id path string true "User ID"
+// @Success 200 {object} Presence
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Router /api/v1/ws/presence/{user_id} [get]
+func (h *Handler) HandlePresence(c echo.Context) error {
+	return response.Success(c, h.hub.Presence(c.Param("user_id")))
+}
+
+// RequireSelf lets users through for their own user_id path parameter.
+// Requests about other users go through otherwise instead, such as a
+// permission check; without it they are refused.
+func (h *Handler) RequireSelf(otherwise ...echo.MiddlewareFunc) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		fallback := next
+		for i := len(otherwise) - 1; i >= 0; i-- {
+			fallback = otherwise[i](fallback)
+		}
+		return func(c echo.Context) error {
+			userID, ok := authctx.UserID(c)
+			if !ok {
+				return response.Unauthorized(c, "User not authenticated")
+			}
+			if userID.String() == c.Param("user_id") {
+				return next(c)
+			}
+			if len(otherwise) == 0 {
+				return response.Forbidden(c, "Insufficient permissions")
+			}
+			return fallback(c)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package websocket
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/internal/testutil"
+	"github.com/pixperk/goiler/pkg/authctx"
+)
+
+func TestHub_ConnectionQuota(t *testing.T) {
+	logger := testutil.Logger()
+	hub := NewHub(logger)
+	if err := hub.SetConnectionQuota(1, "drop_newest"); err == nil {
+		t.Error("Expected an unknown policy to be rejected")
+	}
+	if err := hub.SetConnectionQuota(-1, QuotaReject); err == nil {
+		t.Error("Expected a negative limit to be rejected")
+	}
+	if err := hub.SetConnectionQuota(2, QuotaReject); err != nil {
+		t.Fatal(err)
+	}
+
+	for range 2 {
+		hub.registerClient(newClient(context.Background(), hub, nil, "user-1", logger))
+	}
+	hub.registerClient(newClient(context.Background(), hub, nil, "", logger))
+
+	if !hub.overQuota("user-1") {
+		t.Error("Expected a third connection to be refused")
+	}
+	if hub.overQuota("user-2") || hub.overQuota("") {
+		t.Error("Expected other users and anonymous connections not to be limited")
+	}
+	if p := hub.Presence("user-1"); !p.Online || p.Connections != 2 || p.Limit != 2 {
+		t.Errorf("Unexpected presence: %+v", p)
+	}
+	if p := hub.Presence("user-2"); p.Online || p.Connections != 0 {
+		t.Errorf("Expected user-2 to be offline, got %+v", p)
+	}
+
+	// The quota is per hub: another instance knows nothing of these
+	// connections and accepts the user's next ones
+	other := NewHub(logger)
+	if err := other.SetConnectionQuota(2, QuotaReject); err != nil {
+		t.Fatal(err)
+	}
+	if other.overQuota("user-1") || other.Presence("user-1").Online {
+		t.Error("Expected another hub not to count this hub's connections")
+	}
+}
+
+func TestHub_ConnectionQuotaEvictsOldest(t *testing.T) {
+	logger := testutil.Logger()
+	hub := NewHub(logger)
+	if err := hub.SetConnectionQuota(1, QuotaEvictOldest); err != nil {
+		t.Fatal(err)
+	}
+
+	oldest := newClient(context.Background(), hub, nil, "user-1", logger)
+	hub.registerClient(oldest)
+	if hub.overQuota("user-1") {
+		t.Error("Expected evict_oldest never to refuse connections")
+	}
+	newest := newClient(context.Background(), hub, nil, "user-1", logger)
+	hub.registerClient(newest)
+
+	select {
+	case <-oldest.ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the oldest connection to be closed")
+	}
+	if newest.ctx.Err() != nil {
+		t.Error("Expected the newest connection to be kept")
+	}
+}
+
+func TestHandler_RequireSelf(t *testing.T) {
+	logger := testutil.Logger()
+	h := NewHandler(NewHub(logger), logger)
+	self := uuid.New()
+	other := uuid.New().String()
+
+	// otherwise stands in for a permission check, admitting callers with
+	// the X-Allowed header
+	permission := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if c.Request().Header.Get("X-Allowed") == "" {
+				return c.NoContent(http.StatusForbidden)
+			}
+			return next(c)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		guard     echo.MiddlewareFunc
+		user      bool
+		target    string
+		permitted bool
+		code      int
+	}{
+		{"own presence", h.RequireSelf(permission), true, self.String(), false, http.StatusOK},
+		{"other user", h.RequireSelf(permission), true, other, false, http.StatusForbidden},
+		{"other user with permission", h.RequireSelf(permission), true, other, true, http.StatusOK},
+		{"other user without fallback", h.RequireSelf(), true, other, true, http.StatusForbidden},
+		{"anonymous", h.RequireSelf(permission), false, self.String(), true, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			e.GET("/ws/presence/:user_id", h.HandlePresence, func(next echo.HandlerFunc) echo.HandlerFunc {
+				return func(c echo.Context) error {
+					if tt.user {
+						authctx.Set(c, &authctx.User{ID: self})
+					}
+					return next(c)
+				}
+			}, tt.guard)
+
+			req := httptest.NewRequest(http.MethodGet, "/ws/presence/"+tt.target, nil)
+			if tt.permitted {
+				req.Header.Set("X-Allowed", "yes")
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			if rec.Code != tt.code {
+				t.Errorf("Expected %d, got %d: %s", tt.code, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package websocket
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// Rate limit policies, applied to messages over a client's limits
+const (
+	// RateLimitWarn handles the message anyway, warning the client
+	RateLimitWarn = "warn"
+	// RateLimitDrop drops the message, telling the client why
+	RateLimitDrop = "drop"
+	// RateLimitDisconnect closes the connection with a policy violation
+	RateLimitDisconnect = "disconnect"
+)
+
+// maxRoomLimiters caps the room buckets a hub keeps; beyond it, the least
+// recently used bucket is forgotten
+const maxRoomLimiters = 4096
+
+// RateLimit limits the messages each client may send, so a single chatty
+// client can't flood the hub. Zero rates don't limit.
+type RateLimit struct {
+	// MessagesPerSecond caps every message a client sends, allowing bursts
+	// of up to Burst messages
+	MessagesPerSecond float64
+	// Burst defaults to MessagesPerSecond rounded up
+	Burst int
+	// RoomMessagesPerSecond caps the messages clients send to each room,
+	// together, allowing bursts of up to RoomBurst messages
+	RoomMessagesPerSecond float64
+	// RoomBurst defaults to RoomMessagesPerSecond rounded up
+	RoomBurst int
+	// Policy is what happens to messages over the limits: RateLimitWarn,
+	// RateLimitDrop or RateLimitDisconnect
+	Policy string
+}
+
+// rateAction is what the read pump does with a message after the rate
+// limit checked it
+type rateAction int
+
+const (
+	rateAllow rateAction = iota
+	rateDrop
+	rateDisconnect
+)
+
+// SetRateLimit limits the messages each client sends. Client buckets are
+// created on a client's first message, so connections keep the limits in
+// force then; room buckets start afresh.
+func (h *Hub) SetRateLimit(limit RateLimit) error {
+	switch limit.Policy {
+	case RateLimitWarn, RateLimitDrop, RateLimitDisconnect:
+	default:
+		return fmt.Errorf("unknown rate limit policy %q", limit.Policy)
+	}
+	for _, r := range []float64{limit.MessagesPerSecond, limit.RoomMessagesPerSecond} {
+		if r < 0 || math.IsInf(r, 0) || math.IsNaN(r) {
+			return fmt.Errorf("message rates must be non-negative numbers")
+		}
+	}
+	if limit.Burst < 0 || limit.RoomBurst < 0 {
+		return fmt.Errorf("bursts must not be negative")
+	}
+
+	if limit.Burst == 0 {
+		limit.Burst = int(math.Ceil(limit.MessagesPerSecond))
+	}
+	if limit.RoomBurst == 0 {
+		limit.RoomBurst = int(math.Ceil(limit.RoomMessagesPerSecond))
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.rateLimit = limit
+	h.roomLimits = nil
+	if limit.RoomMessagesPerSecond > 0 {
+		h.roomLimits = newRoomLimiters(limit.RoomMessagesPerSecond, limit.RoomBurst, maxRoomLimiters)
+	}
+	return nil
+}
+
+// RateLimit returns the limits on the messages each client sends
+func (h *Hub) RateLimit() RateLimit {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.rateLimit
+}
+
+// checkRate takes a token for message from the client's bucket and, for
+// room messages, from the room's bucket shared by all clients, and returns what the policy does with the
+// message. Only the read pump calls it.
+func (c *Client) checkRate(message *Message) rateAction {
+	if c.limiter == nil {
+		c.initRateLimit()
+	}
+	if c.limiter.Allow() && c.roomLimits.allow(message) {
+		c.rateLimited = false
+		return rateAllow
+	}
+
+	// Log once per run of limited messages rather than for each
+	if !c.rateLimited {
+		c.logger.Warn("websocket client rate limited",
+			slog.String("client_id", c.ID),
+			slog.String("user_id", c.UserID),
+			slog.String("room", message.Room),
+			slog.String("policy", c.ratePolicy),
+		)
+	}
+	c.rateLimited = true
+
+	if c.ratePolicy == RateLimitDisconnect {
+		return rateDisconnect
+	}
+
+	payload, _ := json.Marshal(map[string]string{
+		"code":    RoomCodeRateLimited,
+		"message": "You are sending too many messages",
+		"type":    message.Type,
+		"room":    message.Room,
+	})
+	_ = c.Send(&Message{Type: "error", Payload: payload})
+
+	if c.ratePolicy == RateLimitWarn {
+		return rateAllow
+	}
+	return rateDrop
+}
+
+// initRateLimit creates the client's bucket from the hub's limits
+func (c *Client) initRateLimit() {
+	c.hub.mu.RLock()
+	limit := c.hub.rateLimit
+	c.roomLimits = c.hub.roomLimits
+	c.hub.mu.RUnlock()
+
+	c.limiter = rate.NewLimiter(rate.Inf, 0)
+	if limit.MessagesPerSecond > 0 {
+		c.limiter = rate.NewLimiter(rate.Limit(limit.MessagesPerSecond), limit.Burst)
+	}
+	c.ratePolicy = limit.Policy
+}
+
+// roomLimiters keeps a bucket per room, shared by the clients sending to
+// it, least recently used last so the map stays bounded however many rooms
+// clients name
+type roomLimiters struct {
+	mu      sync.Mutex
+	rate    rate.Limit
+	burst   int
+	max     int
+	buckets map[string]*list.Element
+	// recent orders buckets by last message, most recent first
+	recent *list.List
+}
+
+// roomBucket is a room's bucket in roomLimiters.recent
+type roomBucket struct {
+	room    string
+	limiter *rate.Limiter
+}
+
+func newRoomLimiters(perSecond float64, burst, max int) *roomLimiters {
+	return &roomLimiters{
+		rate:    rate.Limit(perSecond),
+		burst:   burst,
+		max:     max,
+		buckets: make(map[string]*list.Element),
+		recent:  list.New(),
+	}
+}
+
+// allow takes a token from the bucket of the room message is sent to,
+// evicting the least recently used bucket if a new one would exceed the cap.
+// Nil limiters allow everything.
+func (l *roomLimiters) allow(message *Message) bool {
+	if l == nil || message.Type != "room" || message.Room == "" {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if elem, ok := l.buckets[message.Room]; ok {
+		l.recent.MoveToFront(elem)
+		return elem.Value.(*roomBucket).limiter.Allow()
+	}
+	for len(l.buckets) >= l.max {
+		oldest := l.recent.Back()
+		l.recent.Remove(oldest)
+		delete(l.buckets, oldest.Value.(*roomBucket).room)
+	}
+	limiter := rate.NewLimiter(l.rate, l.burst)
+	l.buckets[message.Room] = l.recent.PushFront(&roomBucket{room: message.Room, limiter: limiter})
+	return limiter.Allow()
+}
+
+// len returns the number of room buckets kept
+func (l *roomLimiters) len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
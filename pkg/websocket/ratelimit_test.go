@@ -0,0 +1,134 @@
+package websocket
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pixperk/goiler/internal/testutil"
+)
+
+func newRateTestClient(t *testing.T, limit RateLimit) *Client {
+	t.Helper()
+	logger := testutil.Logger()
+	hub := NewHub(logger)
+	if err := hub.SetRateLimit(limit); err != nil {
+		t.Fatalf("SetRateLimit failed: %v", err)
+	}
+	return newRateTestPeer(hub, "chatty")
+}
+
+// newRateTestPeer registers another client on hub
+func newRateTestPeer(hub *Hub, id string) *Client {
+	client := &Client{ID: id, hub: hub, logger: hub.logger, send: make(chan []byte, 16), rooms: make(map[string]bool)}
+	hub.registerClient(client)
+	return client
+}
+
+func TestRateLimit_Policies(t *testing.T) {
+	tests := []struct {
+		policy string
+		want   rateAction
+		reply  bool
+	}{
+		{RateLimitWarn, rateAllow, true},
+		{RateLimitDrop, rateDrop, true},
+		{RateLimitDisconnect, rateDisconnect, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			client := newRateTestClient(t, RateLimit{MessagesPerSecond: 0.001, Burst: 2, Policy: tt.policy})
+
+			for range 2 {
+				if action := client.checkRate(&Message{Type: "ping"}); action != rateAllow {
+					t.Fatalf("Expected the burst to be allowed, got %v", action)
+				}
+			}
+			if action := client.checkRate(&Message{Type: "ping"}); action != tt.want {
+				t.Errorf("Expected %v over the limit, got %v", tt.want, action)
+			}
+			if got := len(client.send) == 1; got != tt.reply {
+				t.Fatalf("Expected a reply: %v, got %d messages", tt.reply, len(client.send))
+			}
+			if tt.reply {
+				if reply := string(<-client.send); !strings.Contains(reply, RoomCodeRateLimited) {
+					t.Errorf("Expected %s, got %s", RoomCodeRateLimited, reply)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimit_PerRoom(t *testing.T) {
+	client := newRateTestClient(t, RateLimit{RoomMessagesPerSecond: 0.001, RoomBurst: 1, Policy: RateLimitDrop})
+
+	if action := client.checkRate(&Message{Type: "room", Room: "a"}); action != rateAllow {
+		t.Fatalf("Expected the first message to room a, got %v", action)
+	}
+	if action := client.checkRate(&Message{Type: "room", Room: "a"}); action != rateDrop {
+		t.Errorf("Expected room a to be limited, got %v", action)
+	}
+	if action := client.checkRate(&Message{Type: "room", Room: "b"}); action != rateAllow {
+		t.Errorf("Expected room b to have its own bucket, got %v", action)
+	}
+	if action := client.checkRate(&Message{Type: "ping"}); action != rateAllow {
+		t.Errorf("Expected messages outside rooms to be unlimited, got %v", action)
+	}
+
+	// The room's bucket is shared: another client can't send to room a
+	// either
+	other := newRateTestPeer(client.hub, "other")
+	if action := other.checkRate(&Message{Type: "room", Room: "a"}); action != rateDrop {
+		t.Errorf("Expected room a to be limited for every client, got %v", action)
+	}
+}
+
+func TestRoomLimiters_EvictsLeastRecentlyUsed(t *testing.T) {
+	limits := newRoomLimiters(0.001, 1, 2)
+	send := func(room string) bool {
+		return limits.allow(&Message{Type: "room", Room: room})
+	}
+
+	if !send("a") || !send("b") {
+		t.Fatal("Expected the first message to each room to be allowed")
+	}
+	// Touching a leaves b least recently used, so c evicts b
+	if send("a") {
+		t.Error("Expected room a to be limited")
+	}
+	if !send("c") {
+		t.Error("Expected the first message to room c to be allowed")
+	}
+	if n := limits.len(); n != 2 {
+		t.Errorf("Expected the cap of 2 buckets to hold, got %d", n)
+	}
+	if send("a") {
+		t.Error("Expected room a to keep its bucket")
+	}
+	if !send("b") {
+		t.Error("Expected room b to get a fresh bucket after eviction")
+	}
+
+	var unlimited *roomLimiters
+	if !unlimited.allow(&Message{Type: "room", Room: "a"}) {
+		t.Error("Expected no room limit to allow everything")
+	}
+}
+
+func TestSetRateLimit_Validates(t *testing.T) {
+	hub := NewHub(testutil.Logger())
+	for _, limit := range []RateLimit{
+		{Policy: "ignore"},
+		{MessagesPerSecond: -1, Policy: RateLimitDrop},
+		{RoomBurst: -1, Policy: RateLimitDrop},
+	} {
+		if err := hub.SetRateLimit(limit); err == nil {
+			t.Errorf("Expected %+v to be rejected", limit)
+		}
+	}
+	if err := hub.SetRateLimit(RateLimit{MessagesPerSecond: 2.5, Policy: RateLimitWarn}); err != nil {
+		t.Fatalf("SetRateLimit failed: %v", err)
+	}
+	if burst := hub.RateLimit().Burst; burst != 3 {
+		t.Errorf("Expected the burst to default to 3, got %d", burst)
+	}
+}
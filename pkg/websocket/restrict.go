@@ -0,0 +1,122 @@
+package websocket
+
+import (
+	"encoding/json"
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+// RoomAuthorizer decides who may join rooms and send to them, so private
+// rooms don't need changes to the client's message handling. It is
+// consulted on every join and room message, with the client's current user
+// and role; the server can always broadcast to any room.
+type RoomAuthorizer interface {
+	CanJoin(client *Client, room string) bool
+	CanPublish(client *Client, room string) bool
+}
+
+// AllowAllRooms lets every client join and send to every room. It is the
+// default authorizer.
+type AllowAllRooms struct{}
+
+// CanJoin implements RoomAuthorizer
+func (AllowAllRooms) CanJoin(*Client, string) bool { return true }
+
+// CanPublish implements RoomAuthorizer
+func (AllowAllRooms) CanPublish(*Client, string) bool { return true }
+
+// RoleAuthorizer authorizes rooms by name prefix: a room matching a prefix
+// in Join may only be joined by clients with one of the prefix's roles, and
+// likewise for Publish. The longest matching prefix applies, so
+// {"team:": {"user"}, "team:ops:": {"admin"}} opens team rooms to users but
+// team:ops: rooms to admins only. Rooms matching no prefix are open.
+type RoleAuthorizer struct {
+	Join    map[string][]string
+	Publish map[string][]string
+}
+
+// CanJoin implements RoomAuthorizer
+func (a RoleAuthorizer) CanJoin(client *Client, room string) bool {
+	return roleAllowed(a.Join, client.Role, room)
+}
+
+// CanPublish implements RoomAuthorizer
+func (a RoleAuthorizer) CanPublish(client *Client, room string) bool {
+	return roleAllowed(a.Publish, client.Role, room)
+}
+
+// roleAllowed reports whether role may use room under the roles of its
+// longest matching prefix
+func roleAllowed(prefixes map[string][]string, role, room string) bool {
+	match, found := "", false
+	for prefix := range prefixes {
+		if strings.HasPrefix(room, prefix) && (!found || len(prefix) > len(match)) {
+			match, found = prefix, true
+		}
+	}
+	return !found || slices.Contains(prefixes[match], role)
+}
+
+// SetRoomAuthorizer sets the authorizer consulted when clients join rooms
+// and send to them, on top of RestrictRoom. nil restores AllowAllRooms.
+func (h *Hub) SetRoomAuthorizer(authorizer RoomAuthorizer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.roomAuthorizer = authorizer
+}
+
+// authorized asks the room authorizer whether client may join room, for
+// join messages, or send to it
+func (h *Hub) authorized(client *Client, messageType, room string) bool {
+	h.mu.RLock()
+	authorizer := h.roomAuthorizer
+	h.mu.RUnlock()
+
+	if authorizer == nil {
+		return true
+	}
+	if messageType == "join" {
+		return authorizer.CanJoin(client, room)
+	}
+	return authorizer.CanPublish(client, room)
+}
+
+// RestrictRoom limits who may join room and send to it to the clients
+// allow accepts. Others get an error message with code FORBIDDEN. The
+// server can still broadcast to the room.
+func (h *Hub) RestrictRoom(room string, allow func(*Client) bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.restricted[room] = allow
+}
+
+// allowed reports whether client may join and send to room
+func (h *Hub) allowed(client *Client, room string) bool {
+	h.mu.RLock()
+	allow, ok := h.restricted[room]
+	h.mu.RUnlock()
+
+	return !ok || allow(client)
+}
+
+// rejectRestricted replies with an error and returns true if the client
+// may not use room for messageType
+func (c *Client) rejectRestricted(messageType, room string) bool {
+	if c.hub.allowed(c, room) && c.hub.authorized(c, messageType, room) {
+		return false
+	}
+
+	c.logger.Debug("client denied restricted room",
+		slog.String("client_id", c.ID),
+		slog.String("room", room),
+	)
+	payload, _ := json.Marshal(map[string]string{
+		"code":    "FORBIDDEN",
+		"message": "You may not use this room",
+		"type":    messageType,
+		"room":    room,
+	})
+	_ = c.Send(&Message{Type: "error", Payload: payload})
+	return true
+}
@@ -0,0 +1,78 @@
+package websocket
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/pixperk/goiler/internal/testutil"
+)
+
+func TestRoleAuthorizer(t *testing.T) {
+	authorizer := RoleAuthorizer{
+		Join: map[string][]string{
+			"team:":     {"user", "admin"},
+			"team:ops:": {"admin"},
+		},
+		Publish: map[string][]string{
+			"announcements": {"admin"},
+		},
+	}
+	user := &Client{Role: "user"}
+	admin := &Client{Role: "admin"}
+	anonymous := &Client{}
+
+	tests := []struct {
+		name    string
+		allowed bool
+		want    bool
+	}{
+		{"user joins team room", authorizer.CanJoin(user, "team:web"), true},
+		{"anonymous joins team room", authorizer.CanJoin(anonymous, "team:web"), false},
+		{"user joins ops room", authorizer.CanJoin(user, "team:ops:pager"), false},
+		{"admin joins ops room", authorizer.CanJoin(admin, "team:ops:pager"), true},
+		{"anonymous joins open room", authorizer.CanJoin(anonymous, "chat:general"), true},
+		{"user joins announcements", authorizer.CanJoin(user, "announcements"), true},
+		{"user publishes announcements", authorizer.CanPublish(user, "announcements"), false},
+		{"admin publishes announcements", authorizer.CanPublish(admin, "announcements"), true},
+	}
+	for _, tt := range tests {
+		if tt.allowed != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, tt.allowed)
+		}
+	}
+}
+
+func TestRoomAuthorizer_Consulted(t *testing.T) {
+	logger := testutil.Logger()
+	hub := NewHub(logger)
+	hub.SetRoomAuthorizer(RoleAuthorizer{
+		Join:    map[string][]string{"private:": {"admin"}},
+		Publish: map[string][]string{"announcements": {"admin"}},
+	})
+	user := &Client{ID: "user", Role: "user", hub: hub, logger: logger, send: make(chan []byte, 16), rooms: make(map[string]bool)}
+
+	user.handleMessage(&Message{Type: "join", Payload: json.RawMessage(`{"room":"private:board"}`)})
+	user.handleMessage(&Message{Type: "room", Room: "announcements", Payload: json.RawMessage(`{}`)})
+	if len(user.send) != 2 {
+		t.Fatalf("Expected 2 errors, got %d messages", len(user.send))
+	}
+	for range 2 {
+		if reply := string(<-user.send); !strings.Contains(reply, "FORBIDDEN") {
+			t.Errorf("Expected FORBIDDEN, got %s", reply)
+		}
+	}
+	if len(hub.joinRoom) != 0 || len(hub.broadcast) != 0 {
+		t.Error("Expected the user neither to join nor to send")
+	}
+
+	user.handleMessage(&Message{Type: "room", Room: "chat:general", Payload: json.RawMessage(`{}`)})
+	if len(user.send) != 0 || len(hub.broadcast) != 1 {
+		t.Error("Expected rooms without rules to stay open")
+	}
+
+	hub.SetRoomAuthorizer(nil)
+	if !hub.authorized(user, "room", "announcements") {
+		t.Error("Expected a nil authorizer to allow every room")
+	}
+}
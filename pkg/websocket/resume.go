@@ -0,0 +1,334 @@
+package websocket
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Query parameters of a reconnecting client
+const (
+	// ResumeTokenParam carries the resume token of the previous connection
+	ResumeTokenParam = "resume"
+	// ResumeCursorParam carries the seq of the last message the client
+	// received; without it, messages are replayed from the disconnect
+	ResumeCursorParam = "cursor"
+)
+
+// MessageTypeResumed is the type of the message telling a reconnecting
+// client whether its session was resumed
+const MessageTypeResumed = "resumed"
+
+// resumeSweepInterval is how often expired sessions are dropped
+const resumeSweepInterval = time.Second
+
+// ResumeResult is the payload of a resumed message. Complete is false when
+// some missed messages were no longer kept or didn't fit in the client's
+// buffer, so the client should reload its state.
+type ResumeResult struct {
+	Resumed  bool     `json:"resumed"`
+	Rooms    []string `json:"rooms,omitempty"`
+	Replayed int      `json:"replayed"`
+	Complete bool     `json:"complete"`
+}
+
+// history keeps the messages delivered during the resume window and the
+// sessions of disconnected clients
+type history struct {
+	mu     sync.Mutex
+	window time.Duration
+	size   int
+
+	// seq is the number of the last message kept; trimmed the number of the
+	// last one dropped
+	seq     uint64
+	trimmed uint64
+	entries []historyEntry
+
+	sessions  map[string]*resumeSession
+	lastSweep time.Time
+}
+
+// historyEntry is a delivered message, with the user it was sent to if any
+type historyEntry struct {
+	message *Message
+	userID  string
+	at      time.Time
+}
+
+// resumeSession is what a client reconnecting with its token gets back
+type resumeSession struct {
+	userID string
+	rooms  []string
+	// since is the seq when the client connected, and seq when it left;
+	// messages in between were delivered to it
+	since   uint64
+	seq     uint64
+	expires time.Time
+}
+
+// resumeState is a session being restored on a new client, with the rooms
+// it may still join
+type resumeState struct {
+	session *resumeSession
+	rooms   []string
+	after   uint64
+}
+
+// SetResume lets clients reconnecting within window rejoin their rooms and
+// receive the messages they missed, out of the last size delivered. Clients
+// get a resume token in their welcome message and reconnect with it in the
+// resume query parameter. History is kept per instance, so clients must
+// reconnect to the same one. A window of 0 disables resumption.
+func (h *Hub) SetResume(window time.Duration, size int) error {
+	if window < 0 || size < 0 {
+		return fmt.Errorf("resume window and history size must not be negative, got %s and %d", window, size)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if window == 0 || size == 0 {
+		h.history = nil
+		return nil
+	}
+	h.history = &history{
+		window:   window,
+		size:     size,
+		sessions: make(map[string]*resumeSession),
+	}
+	return nil
+}
+
+// issueResumeToken gives client a resume token if resumption is enabled
+func (h *Hub) issueResumeToken(client *Client) {
+	h.mu.RLock()
+	enabled := h.history != nil
+	h.mu.RUnlock()
+	if !enabled {
+		return
+	}
+
+	b := make([]byte, 16)
+	rand.Read(b)
+	client.resumeToken = hex.EncodeToString(b)
+}
+
+// record numbers message and keeps it for resumption, returning the copy
+// to deliver. A resumed client may find it in history while a shard is
+// still delivering it; replayed keeps it from arriving twice.
+func (h *Hub) record(message *Message, userID string) *Message {
+	if h.history == nil {
+		return message
+	}
+	stamped := *message
+	h.history.append(&stamped, userID)
+	return &stamped
+}
+
+// replayed reports whether message was already replayed to the client
+func (c *Client) replayed(message *Message) bool {
+	return message.Seq != 0 && message.Seq <= c.replayedThrough
+}
+
+// append numbers and keeps message, dropping the messages beyond the window
+// and size
+func (hs *history) append(message *Message, userID string) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	now := time.Now()
+	hs.seq++
+	message.Seq = hs.seq
+	hs.entries = append(hs.entries, historyEntry{message: message, userID: userID, at: now})
+
+	drop := 0
+	for drop < len(hs.entries) && (len(hs.entries)-drop > hs.size || now.Sub(hs.entries[drop].at) > hs.window) {
+		drop++
+	}
+	if drop > 0 {
+		hs.trimmed = hs.entries[drop-1].message.Seq
+		hs.entries = slices.Delete(hs.entries, 0, drop)
+	}
+}
+
+// current returns the seq of the last message kept
+func (hs *history) current() uint64 {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	return hs.seq
+}
+
+// save keeps the session of a client leaving, under its token
+func (hs *history) save(token string, session *resumeSession) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(hs.lastSweep) >= resumeSweepInterval {
+		for t, s := range hs.sessions {
+			if now.After(s.expires) {
+				delete(hs.sessions, t)
+			}
+		}
+		hs.lastSweep = now
+	}
+	session.seq = hs.seq
+	session.expires = now.Add(hs.window)
+	hs.sessions[token] = session
+}
+
+// take removes and returns the unexpired session saved under token
+func (hs *history) take(token string) *resumeSession {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	session, ok := hs.sessions[token]
+	if !ok {
+		return nil
+	}
+	delete(hs.sessions, token)
+	if time.Now().After(session.expires) {
+		return nil
+	}
+	return session
+}
+
+// missed returns the messages after seq sent to everyone, to rooms or to
+// userID, and whether none have been dropped from history
+func (hs *history) missed(after uint64, rooms []string, userID string) ([]*Message, bool) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	var messages []*Message
+	for _, entry := range hs.entries {
+		if entry.message.Seq <= after {
+			continue
+		}
+		if entry.userID != "" {
+			if entry.userID != userID {
+				continue
+			}
+		} else if entry.message.Room != "" && !slices.Contains(rooms, entry.message.Room) {
+			continue
+		}
+		messages = append(messages, entry.message)
+	}
+	return messages, hs.trimmed <= after
+}
+
+// saveSession keeps the session of a client leaving the hub. The caller
+// must hold h.mu.
+func (h *Hub) saveSession(client *Client) {
+	if h.history == nil || client.resumeToken == "" {
+		return
+	}
+	rooms := make([]string, 0, len(client.rooms))
+	for room := range client.rooms {
+		rooms = append(rooms, room)
+	}
+	slices.Sort(rooms)
+	h.history.save(client.resumeToken, &resumeSession{
+		userID: client.UserID,
+		rooms:  rooms,
+		since:  client.since,
+	})
+}
+
+// prepareResume looks up the session named by the resume query parameter.
+// Sessions of another user aren't resumed, and rooms the client may no
+// longer join are left out. The session is restored when the client
+// registers.
+func (h *Handler) prepareResume(c echo.Context, client *Client) {
+	token := c.QueryParam(ResumeTokenParam)
+	if token == "" {
+		return
+	}
+
+	h.hub.mu.RLock()
+	hs := h.hub.history
+	h.hub.mu.RUnlock()
+
+	state := &resumeState{}
+	client.resume = state
+	if hs == nil {
+		return
+	}
+	session := hs.take(token)
+	if session == nil || session.userID != client.UserID {
+		return
+	}
+
+	state.session = session
+	state.after = session.seq
+	if cursor, err := strconv.ParseUint(c.QueryParam(ResumeCursorParam), 10, 64); err == nil && cursor < session.seq {
+		state.after = max(cursor, session.since)
+	}
+	for _, room := range session.rooms {
+		if h.hub.allowed(client, room) && h.hub.authorized(client, "join", room) {
+			state.rooms = append(state.rooms, room)
+		}
+	}
+}
+
+// restoreSession adds a resuming client to the shards, rejoins the rooms of
+// its session and queues the messages it missed, followed by a resumed
+// message. The caller must hold h.mu; the shards are locked throughout, so
+// no live message is queued in between.
+func (h *Hub) restoreSession(client *Client) []roomEvent {
+	state := client.resume
+	client.resume = nil
+
+	h.lockShards()
+	defer h.unlockShards()
+	h.clientShard(client).clients[client] = true
+	if client.UserID != "" {
+		h.shardFor(client.UserID).addUser(client)
+	}
+	if h.history != nil {
+		client.since = h.history.current()
+	}
+
+	result := ResumeResult{}
+	var pending []roomEvent
+	if state.session != nil && h.history != nil {
+		for _, room := range state.rooms {
+			pending = append(pending, h.shardFor(room).join(client, room)...)
+			client.rooms[room] = true
+		}
+		client.replayedThrough = client.since
+		missed, complete := h.history.missed(state.after, state.rooms, client.UserID)
+		// Messages recorded since are delivered live once the shards unlock
+		missed = slices.DeleteFunc(missed, func(m *Message) bool { return m.Seq > client.since })
+
+		// Leave room for the resumed message and what follows it
+		space := cap(client.send) - len(client.send) - 2
+		if len(missed) > space {
+			missed, complete = missed[:max(space, 0)], false
+		}
+		for _, message := range missed {
+			data, err := client.encode(message)
+			if err != nil || !h.queue(context.Background(), client, data) {
+				complete = false
+				continue
+			}
+			result.Replayed++
+		}
+		result.Resumed = true
+		result.Rooms = state.rooms
+		result.Complete = complete
+	}
+
+	payload, _ := json.Marshal(result)
+	if data, err := client.encode(&Message{Type: MessageTypeResumed, Payload: payload}); err == nil {
+		h.queue(context.Background(), client, data)
+	}
+	return pending
+}
@@ -0,0 +1,152 @@
+package websocket
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/internal/testutil"
+)
+
+// readMessages reads the next frame, splitting the messages batched in it
+func readMessages(t *testing.T, conn *websocket.Conn) []*Message {
+	t.Helper()
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage failed: %v", err)
+	}
+	var messages []*Message
+	for _, line := range strings.Split(string(data), "\n") {
+		msg, err := DecodeMessage([]byte(line))
+		if err != nil {
+			t.Fatalf("Invalid message %q: %v", line, err)
+		}
+		messages = append(messages, msg)
+	}
+	return messages
+}
+
+// readUntil reads messages until one of type messageType arrives, returning
+// those before it and it
+func readUntil(t *testing.T, conn *websocket.Conn, messageType string) ([]*Message, *Message) {
+	t.Helper()
+	var before []*Message
+	for {
+		for _, msg := range readMessages(t, conn) {
+			if msg.Type == messageType {
+				return before, msg
+			}
+			before = append(before, msg)
+		}
+	}
+}
+
+func TestHandler_Resume(t *testing.T) {
+	logger := testutil.Logger()
+	hub := NewHub(logger)
+	if err := hub.SetResume(time.Minute, 100); err != nil {
+		t.Fatalf("SetResume failed: %v", err)
+	}
+	go hub.Run()
+
+	e := echo.New()
+	e.GET("/ws", NewHandler(hub, logger).HandleConnection)
+	server := httptest.NewServer(e)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, welcome := readUntil(t, conn, "connected")
+	var connected struct {
+		ResumeToken string `json:"resume_token"`
+	}
+	if err := json.Unmarshal(welcome.Payload, &connected); err != nil || connected.ResumeToken == "" {
+		t.Fatalf("Expected a resume token, got %s", welcome.Payload)
+	}
+
+	conn.WriteJSON(&Message{Type: "join", Payload: json.RawMessage(`{"room":"chat"}`)})
+	for hub.GetRoomClients("chat") == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	conn.Close()
+	for hub.GetConnectedClients() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Missed while disconnected
+	hub.BroadcastToRoom("chat", &Message{Type: "chat-1"})
+	hub.BroadcastToRoom("other", &Message{Type: "other"})
+	hub.BroadcastToAll(&Message{Type: "notice"})
+	for hub.history.current() < 3 {
+		time.Sleep(time.Millisecond)
+	}
+
+	conn, _, err = websocket.DefaultDialer.Dial(url+"?resume="+connected.ResumeToken, nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	replayed, resumed := readUntil(t, conn, MessageTypeResumed)
+
+	var types []string
+	for _, msg := range replayed {
+		types = append(types, msg.Type)
+	}
+	if strings.Join(types, ",") != "connected,chat-1,notice" {
+		t.Errorf("Expected the welcome and the missed messages, got %v", types)
+	}
+	var result ResumeResult
+	json.Unmarshal(resumed.Payload, &result)
+	if !result.Resumed || !result.Complete || result.Replayed != 2 || len(result.Rooms) != 1 || result.Rooms[0] != "chat" {
+		t.Errorf("Expected a complete resume of chat, got %+v", result)
+	}
+
+	hub.BroadcastToRoom("chat", &Message{Type: "chat-2"})
+	if _, msg := readUntil(t, conn, "chat-2"); msg.Seq != 4 {
+		t.Errorf("Expected seq 4, got %d", msg.Seq)
+	}
+
+	// Tokens are used once
+	again, _, err := websocket.DefaultDialer.Dial(url+"?resume="+connected.ResumeToken, nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer again.Close()
+	again.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, resumed = readUntil(t, again, MessageTypeResumed)
+	json.Unmarshal(resumed.Payload, &result)
+	if result.Resumed {
+		t.Errorf("Expected a used token not to resume, got %+v", result)
+	}
+}
+
+func TestHistory_Missed(t *testing.T) {
+	hs := &history{window: time.Minute, size: 3, sessions: make(map[string]*resumeSession)}
+	for _, msg := range []*Message{
+		{Type: "a", Room: "chat"},
+		{Type: "b"},
+		{Type: "c", Room: "other"},
+		{Type: "d", Room: "chat"},
+	} {
+		hs.append(msg, "")
+	}
+	hs.append(&Message{Type: "e"}, "user-1")
+	hs.append(&Message{Type: "f"}, "user-2")
+
+	missed, complete := hs.missed(3, []string{"chat"}, "user-1")
+	if len(missed) != 2 || missed[0].Type != "d" || missed[1].Type != "e" || !complete {
+		t.Errorf("Expected d and e, complete, got %d messages, complete %v", len(missed), complete)
+	}
+	if _, complete := hs.missed(1, []string{"chat"}, ""); complete {
+		t.Error("Expected trimmed messages to be reported")
+	}
+}
@@ -0,0 +1,239 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/pkg/response"
+	"golang.org/x/time/rate"
+)
+
+// Error codes sent to clients whose room message a policy rejected
+const (
+	RoomCodeMessageTooLarge = "MESSAGE_TOO_LARGE"
+	RoomCodeTypeNotAllowed  = "MESSAGE_TYPE_NOT_ALLOWED"
+	RoomCodeRateLimited     = "RATE_LIMITED"
+)
+
+// RoomPolicy limits the messages a room relays, so one noisy room can't
+// hold up the hub loop for everyone else. Zero fields don't limit.
+type RoomPolicy struct {
+	// MaxPayloadBytes caps the size of each message's payload
+	MaxPayloadBytes int `json:"max_payload_bytes,omitempty"`
+	// AllowedTypes lists the message types the room relays
+	AllowedTypes []string `json:"allowed_types,omitempty"`
+	// MessagesPerSecond caps the messages relayed to the room, across all
+	// senders, allowing bursts of up to Burst messages
+	MessagesPerSecond float64 `json:"messages_per_second,omitempty"`
+	// Burst defaults to MessagesPerSecond rounded up
+	Burst int `json:"burst,omitempty"`
+}
+
+// Validate reports whether the policy's limits make sense
+func (p RoomPolicy) Validate() error {
+	switch {
+	case p.MaxPayloadBytes < 0:
+		return fmt.Errorf("max_payload_bytes must not be negative, got %d", p.MaxPayloadBytes)
+	case p.MaxPayloadBytes > maxMessageSize:
+		return fmt.Errorf("max_payload_bytes must not exceed the %d byte read limit", maxMessageSize)
+	case p.MessagesPerSecond < 0 || math.IsInf(p.MessagesPerSecond, 0) || math.IsNaN(p.MessagesPerSecond):
+		return fmt.Errorf("messages_per_second must be a non-negative number")
+	case p.Burst < 0:
+		return fmt.Errorf("burst must not be negative, got %d", p.Burst)
+	case slices.Contains(p.AllowedTypes, ""):
+		return errors.New("allowed_types must not contain an empty type")
+	}
+	return nil
+}
+
+// roomPolicy is a room's policy with the limiter enforcing its rate
+type roomPolicy struct {
+	RoomPolicy
+	limiter *rate.Limiter
+}
+
+// roomViolation describes why a room message was rejected
+type roomViolation struct {
+	code    string
+	message string
+}
+
+// SetRoomPolicy sets the policy for a room, replacing any earlier one and
+// resetting its rate limit. Policies outlive the room's members, so one can
+// be set before anyone joins.
+func (h *Hub) SetRoomPolicy(room string, policy RoomPolicy) error {
+	if room == "" {
+		return errors.New("room is required")
+	}
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+
+	p := &roomPolicy{RoomPolicy: policy}
+	p.AllowedTypes = slices.Clone(policy.AllowedTypes)
+	if policy.MessagesPerSecond > 0 {
+		if p.Burst == 0 {
+			p.Burst = int(math.Ceil(policy.MessagesPerSecond))
+		}
+		p.limiter = rate.NewLimiter(rate.Limit(policy.MessagesPerSecond), p.Burst)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.policies[room] = p
+	return nil
+}
+
+// RoomPolicy returns the policy set for a room, if any
+func (h *Hub) RoomPolicy(room string) (RoomPolicy, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	p, ok := h.policies[room]
+	if !ok {
+		return RoomPolicy{}, false
+	}
+	policy := p.RoomPolicy
+	policy.AllowedTypes = slices.Clone(p.AllowedTypes)
+	return policy, true
+}
+
+// ClearRoomPolicy removes a room's policy, reporting whether it had one
+func (h *Hub) ClearRoomPolicy(room string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	_, ok := h.policies[room]
+	delete(h.policies, room)
+	return ok
+}
+
+// checkRoomPolicy returns why the room's policy rejects the message, or nil
+// if it may be relayed. Accepted messages count towards the rate limit.
+func (h *Hub) checkRoomPolicy(message *Message) *roomViolation {
+	h.mu.RLock()
+	p := h.policies[message.Room]
+	h.mu.RUnlock()
+
+	switch {
+	case p == nil:
+		return nil
+	case p.MaxPayloadBytes > 0 && len(message.Payload) > p.MaxPayloadBytes:
+		return &roomViolation{RoomCodeMessageTooLarge, fmt.Sprintf("Payloads in this room are limited to %d bytes", p.MaxPayloadBytes)}
+	case len(p.AllowedTypes) > 0 && !slices.Contains(p.AllowedTypes, message.Type):
+		return &roomViolation{RoomCodeTypeNotAllowed, "This room doesn't accept " + message.Type + " messages"}
+	case p.limiter != nil && !p.limiter.Allow():
+		return &roomViolation{RoomCodeRateLimited, "This room is receiving too many messages"}
+	}
+	return nil
+}
+
+// rejectRoomMessage tells the client that sent a message why its room
+// didn't relay it. Messages sent by the server are dropped with a log.
+func (h *Hub) rejectRoomMessage(message *Message, violation *roomViolation) {
+	h.metrics.countDropped(context.Background(), DropRoomPolicy)
+	if message.sender == nil {
+		h.logger.Warn("room policy dropped server message",
+			slog.String("room", message.Room),
+			slog.String("type", message.Type),
+			slog.String("code", violation.code),
+		)
+		return
+	}
+
+	h.logger.Debug("room policy rejected message",
+		slog.String("client_id", message.sender.ID),
+		slog.String("room", message.Room),
+		slog.String("code", violation.code),
+	)
+	payload, _ := json.Marshal(map[string]string{
+		"code":    violation.code,
+		"message": violation.message,
+		"type":    message.Type,
+		"room":    message.Room,
+	})
+	reply := &Message{Type: "error", Payload: payload}
+	if data, err := message.sender.encode(reply); err == nil {
+		h.sendToClient(message.sender, data)
+	}
+}
+
+// RoomInfo describes a room on this hub
+type RoomInfo struct {
+	Room    string      `json:"room"`
+	Members int         `json:"members"`
+	Policy  *RoomPolicy `json:"policy,omitempty"`
+}
+
+// HandleGetRoom returns a room's members and policy
+// @Summary Get WebSocket room
+// @Description Report how many clients are in a room on this instance and the policy limiting its messages (admin only)
+// @Tags Admin
+// @Security BearerAuth
+// @Produce json
+// @Param room path string true "Room name"
+// @Success 200 {object} RoomInfo
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Router /api/v1/admin/ws/rooms/{room} [get]
+func (h *Handler) HandleGetRoom(c echo.Context) error {
+	room := c.Param("room")
+	info := RoomInfo{Room: room, Members: h.hub.GetRoomClients(room)}
+	if policy, ok := h.hub.RoomPolicy(room); ok {
+		info.Policy = &policy
+	}
+	return response.Success(c, info)
+}
+
+// HandleSetRoomPolicy sets the policy limiting a room's messages
+// @Summary Set WebSocket room policy
+// @Description Limit the payload size, message types and message rate a room relays on this instance, replacing any earlier policy (admin only)
+// @Tags Admin
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param room path string true "Room name"
+// @Param request body RoomPolicy true "Room policy"
+// @Success 200 {object} RoomInfo
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 422 {object} response.Response
+// @Router /api/v1/admin/ws/rooms/{room}/policy [put]
+func (h *Handler) HandleSetRoomPolicy(c echo.Context) error {
+	var policy RoomPolicy
+	if err := c.Bind(&policy); err != nil {
+		return response.BadRequest(c, "Invalid request body")
+	}
+
+	room := c.Param("room")
+	if err := h.hub.SetRoomPolicy(room, policy); err != nil {
+		return response.ValidationError(c, map[string]string{"policy": err.Error()})
+	}
+	h.logger.Info("room policy set", slog.String("room", room))
+	return h.HandleGetRoom(c)
+}
+
+// HandleClearRoomPolicy removes a room's policy
+// @Summary Clear WebSocket room policy
+// @Description Stop limiting a room's messages on this instance (admin only)
+// @Tags Admin
+// @Security BearerAuth
+// @Param room path string true "Room name"
+// @Success 204
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Router /api/v1/admin/ws/rooms/{room}/policy [delete]
+func (h *Handler) HandleClearRoomPolicy(c echo.Context) error {
+	if !h.hub.ClearRoomPolicy(c.Param("room")) {
+		return response.NotFound(c, "Room has no policy")
+	}
+	return response.NoContent(c)
+}
@@ -0,0 +1,91 @@
+package websocket
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/pixperk/goiler/internal/testutil"
+)
+
+func newPolicyTestHub(t *testing.T) (*Hub, *Client, *Client) {
+	t.Helper()
+	hub := NewHub(testutil.Logger())
+	sender := &Client{ID: "sender", hub: hub, send: make(chan []byte, 16), rooms: make(map[string]bool)}
+	member := &Client{ID: "member", hub: hub, send: make(chan []byte, 16), rooms: make(map[string]bool)}
+	for _, c := range []*Client{sender, member} {
+		hub.registerClient(c)
+		hub.addClientToRoom(c, "chat")
+	}
+	return hub, sender, member
+}
+
+func TestRoomPolicy_Enforced(t *testing.T) {
+	hub, sender, member := newPolicyTestHub(t)
+	if err := hub.SetRoomPolicy("chat", RoomPolicy{
+		MaxPayloadBytes:   8,
+		AllowedTypes:      []string{"room"},
+		MessagesPerSecond: 0.001,
+		Burst:             1,
+	}); err != nil {
+		t.Fatalf("SetRoomPolicy failed: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		message *Message
+		code    string
+	}{
+		{"too large", &Message{Type: "room", Payload: json.RawMessage(`"too large"`)}, RoomCodeMessageTooLarge},
+		{"type", &Message{Type: "chat", Payload: json.RawMessage(`1`)}, RoomCodeTypeNotAllowed},
+		{"allowed", &Message{Type: "room", Payload: json.RawMessage(`1`)}, ""},
+		{"rate", &Message{Type: "room", Payload: json.RawMessage(`2`)}, RoomCodeRateLimited},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.message.Room = "chat"
+			tt.message.sender = sender
+			hub.broadcastMessage(tt.message)
+
+			if tt.code == "" {
+				if len(member.send) != 1 || len(sender.send) != 1 {
+					t.Fatalf("Expected the message relayed to both members, got %d and %d", len(member.send), len(sender.send))
+				}
+				<-member.send
+				<-sender.send
+				return
+			}
+			if len(member.send) != 0 {
+				t.Fatal("Expected the message not to be relayed")
+			}
+			if len(sender.send) != 1 {
+				t.Fatalf("Expected an error for the sender, got %d messages", len(sender.send))
+			}
+			if reply := string(<-sender.send); !strings.Contains(reply, tt.code) {
+				t.Errorf("Expected %s, got %s", tt.code, reply)
+			}
+		})
+	}
+}
+
+func TestRoomPolicy_SetAndClear(t *testing.T) {
+	hub, _, member := newPolicyTestHub(t)
+
+	if err := hub.SetRoomPolicy("chat", RoomPolicy{MaxPayloadBytes: -1}); err == nil {
+		t.Error("Expected a negative payload limit to be rejected")
+	}
+	if err := hub.SetRoomPolicy("chat", RoomPolicy{AllowedTypes: []string{"room"}}); err != nil {
+		t.Fatalf("SetRoomPolicy failed: %v", err)
+	}
+	if policy, ok := hub.RoomPolicy("chat"); !ok || len(policy.AllowedTypes) != 1 {
+		t.Errorf("Expected the policy to be returned, got %+v", policy)
+	}
+
+	if !hub.ClearRoomPolicy("chat") || hub.ClearRoomPolicy("chat") {
+		t.Error("Expected only the first clear to find a policy")
+	}
+	hub.broadcastMessage(&Message{Type: "chat", Room: "chat"})
+	if len(member.send) != 1 {
+		t.Error("Expected messages to be relayed once the policy is cleared")
+	}
+}
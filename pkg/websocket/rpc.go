@@ -0,0 +1,261 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// RPC message types. Clients send "rpc" messages with an id, method and
+// params; the server answers each with an "rpc_result" carrying the same id
+// and either a result or an error.
+const (
+	MessageTypeRPC       = "rpc"
+	MessageTypeRPCResult = "rpc_result"
+)
+
+// RPC error codes
+const (
+	RPCCodeInvalidRequest  = "INVALID_REQUEST"
+	RPCCodeInvalidParams   = "INVALID_PARAMS"
+	RPCCodeMethodNotFound  = "METHOD_NOT_FOUND"
+	RPCCodeTooManyRequests = "TOO_MANY_REQUESTS"
+	RPCCodeTimeout         = "TIMEOUT"
+	RPCCodeInternal        = "INTERNAL_ERROR"
+)
+
+const (
+	defaultRPCTimeout       = 10 * time.Second
+	defaultRPCMaxConcurrent = 8
+)
+
+// RPCError is the error half of an RPC response. Handlers return one to
+// control the code clients see; any other error is reported as
+// INTERNAL_ERROR without its message.
+type RPCError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Details any    `json:"details,omitempty"`
+}
+
+// Error implements error
+func (e *RPCError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// NewRPCError creates an RPC error with the given code and message
+func NewRPCError(code, message string) *RPCError {
+	return &RPCError{Code: code, Message: message}
+}
+
+// RPCCall is a single method invocation from a client
+type RPCCall struct {
+	Client *Client
+	ID     string
+	Method string
+	Params json.RawMessage
+}
+
+// Bind decodes the call's params into v, returning an INVALID_PARAMS error
+// if they don't match
+func (c *RPCCall) Bind(v any) error {
+	if len(c.Params) == 0 {
+		return NewRPCError(RPCCodeInvalidParams, "params are required")
+	}
+	if err := json.Unmarshal(c.Params, v); err != nil {
+		return NewRPCError(RPCCodeInvalidParams, err.Error())
+	}
+	return nil
+}
+
+// RPCHandler serves an RPC method. The returned value is encoded as the
+// call's result. ctx is cancelled when the call times out or the client
+// disconnects.
+type RPCHandler func(ctx context.Context, call *RPCCall) (any, error)
+
+// RPCRouter dispatches RPC calls to registered methods
+type RPCRouter struct {
+	mu            sync.RWMutex
+	methods       map[string]RPCHandler
+	timeout       time.Duration
+	maxConcurrent int
+	logger        *slog.Logger
+}
+
+// RPCOption configures an RPCRouter
+type RPCOption func(*RPCRouter)
+
+// WithRPCTimeout sets how long a call may run before it fails with TIMEOUT
+func WithRPCTimeout(timeout time.Duration) RPCOption {
+	return func(r *RPCRouter) {
+		if timeout > 0 {
+			r.timeout = timeout
+		}
+	}
+}
+
+// WithRPCConcurrency sets how many calls each client may have in flight;
+// further calls fail with TOO_MANY_REQUESTS until one completes
+func WithRPCConcurrency(n int) RPCOption {
+	return func(r *RPCRouter) {
+		if n > 0 {
+			r.maxConcurrent = n
+		}
+	}
+}
+
+// WithRPCLogger sets the logger used for failed calls
+func WithRPCLogger(logger *slog.Logger) RPCOption {
+	return func(r *RPCRouter) {
+		if logger != nil {
+			r.logger = logger
+		}
+	}
+}
+
+// NewRPCRouter creates an RPC router with no methods
+func NewRPCRouter(opts ...RPCOption) *RPCRouter {
+	r := &RPCRouter{
+		methods:       make(map[string]RPCHandler),
+		timeout:       defaultRPCTimeout,
+		maxConcurrent: defaultRPCMaxConcurrent,
+		logger:        slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Handle registers handler for method, replacing any existing handler
+func (r *RPCRouter) Handle(method string, handler RPCHandler) {
+	if method == "" || handler == nil {
+		panic("websocket: RPC method and handler are required")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.methods[method] = handler
+}
+
+// Methods returns the registered method names
+func (r *RPCRouter) Methods() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	methods := make([]string, 0, len(r.methods))
+	for method := range r.methods {
+		methods = append(methods, method)
+	}
+	return methods
+}
+
+func (r *RPCRouter) handler(method string) (RPCHandler, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	handler, ok := r.methods[method]
+	return handler, ok
+}
+
+// dispatch validates a call and runs it in its own goroutine, replying to
+// the client when it completes. Rejected calls are answered immediately.
+func (r *RPCRouter) dispatch(client *Client, message *Message) {
+	if message.ID == "" || message.Method == "" {
+		client.replyRPC(message.ID, nil, NewRPCError(RPCCodeInvalidRequest, "id and method are required"))
+		return
+	}
+
+	handler, ok := r.handler(message.Method)
+	if !ok {
+		client.replyRPC(message.ID, nil, NewRPCError(RPCCodeMethodNotFound, "unknown method "+message.Method))
+		return
+	}
+
+	slots := client.rpcSlots(r.maxConcurrent)
+	select {
+	case slots <- struct{}{}:
+	default:
+		client.replyRPC(message.ID, nil, &RPCError{
+			Code:    RPCCodeTooManyRequests,
+			Message: "Too many calls in flight",
+			Details: map[string]int{"max_concurrent": r.maxConcurrent},
+		})
+		return
+	}
+
+	call := &RPCCall{
+		Client: client,
+		ID:     message.ID,
+		Method: message.Method,
+		Params: message.Params,
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(client.ctx, r.timeout)
+		defer cancel()
+
+		// The slot is held until the handler returns, even if it outlives
+		// its deadline, so handlers that ignore ctx still count against the
+		// limit. It is freed before the reply goes out, so a client may make
+		// its next call as soon as it has the result.
+		done := make(chan rpcOutcome, 1)
+		go func() {
+			result, err := r.invoke(ctx, handler, call)
+			<-slots
+			done <- rpcOutcome{result: result, err: err}
+		}()
+
+		select {
+		case outcome := <-done:
+			client.replyRPC(call.ID, outcome.result, r.rpcError(call, outcome.err))
+		case <-ctx.Done():
+			client.replyRPC(call.ID, nil, r.rpcError(call, ctx.Err()))
+		}
+	}()
+}
+
+// rpcOutcome is what a handler returned
+type rpcOutcome struct {
+	result any
+	err    error
+}
+
+// invoke runs handler, turning a panic into an error
+func (r *RPCRouter) invoke(ctx context.Context, handler RPCHandler, call *RPCCall) (result any, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return handler(ctx, call)
+}
+
+// rpcError maps a handler error to the error sent to the client
+func (r *RPCRouter) rpcError(call *RPCCall, err error) *RPCError {
+	if err == nil {
+		return nil
+	}
+
+	var rpcErr *RPCError
+	if errors.As(err, &rpcErr) {
+		return rpcErr
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return NewRPCError(RPCCodeTimeout, "Call timed out")
+	}
+	if errors.Is(err, context.Canceled) {
+		// The client disconnected; there is no one to reply to
+		return NewRPCError(RPCCodeInternal, "Call cancelled")
+	}
+
+	r.logger.Error("rpc call failed",
+		slog.String("method", call.Method),
+		slog.String("client_id", call.Client.ID),
+		slog.String("error", err.Error()),
+	)
+	return NewRPCError(RPCCodeInternal, "Internal error")
+}
@@ -0,0 +1,170 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/pixperk/goiler/internal/testutil"
+)
+
+func newRPCTestClient(t *testing.T) *Client {
+	t.Helper()
+	logger := testutil.Logger()
+	hub := NewHub(logger)
+	client := &Client{ID: "browser", hub: hub, logger: logger, ctx: context.Background(), send: make(chan []byte, 16), rooms: make(map[string]bool)}
+	hub.registerClient(client)
+	return client
+}
+
+// rpcReply waits for the client's next RPC result
+func rpcReply(t *testing.T, client *Client) *Message {
+	t.Helper()
+	select {
+	case data := <-client.send:
+		var reply Message
+		if err := json.Unmarshal(data, &reply); err != nil {
+			t.Fatal(err)
+		}
+		if reply.Type != MessageTypeRPCResult {
+			t.Fatalf("Expected an rpc_result, got %s", data)
+		}
+		return &reply
+	case <-time.After(time.Second):
+		t.Fatal("Expected an RPC reply")
+		return nil
+	}
+}
+
+func TestRPCRouter_Dispatch(t *testing.T) {
+	logger := testutil.Logger()
+	router := NewRPCRouter(WithRPCLogger(logger))
+	router.Handle("echo", func(ctx context.Context, call *RPCCall) (any, error) {
+		var params struct {
+			Text string `json:"text"`
+		}
+		if err := call.Bind(&params); err != nil {
+			return nil, err
+		}
+		return map[string]string{"text": params.Text}, nil
+	})
+	router.Handle("forbidden", func(ctx context.Context, call *RPCCall) (any, error) {
+		return nil, NewRPCError("FORBIDDEN", "Not allowed")
+	})
+	router.Handle("broken", func(ctx context.Context, call *RPCCall) (any, error) {
+		return nil, errors.New("connection to db lost")
+	})
+	router.Handle("panics", func(ctx context.Context, call *RPCCall) (any, error) {
+		panic("nil map")
+	})
+	client := newRPCTestClient(t)
+
+	tests := []struct {
+		name    string
+		message *Message
+		code    string
+	}{
+		{"missing id", &Message{Type: MessageTypeRPC, Method: "echo"}, RPCCodeInvalidRequest},
+		{"missing method", &Message{Type: MessageTypeRPC, ID: "1"}, RPCCodeInvalidRequest},
+		{"unknown method", &Message{Type: MessageTypeRPC, ID: "1", Method: "missing"}, RPCCodeMethodNotFound},
+		{"missing params", &Message{Type: MessageTypeRPC, ID: "1", Method: "echo"}, RPCCodeInvalidParams},
+		{"invalid params", &Message{Type: MessageTypeRPC, ID: "1", Method: "echo", Params: json.RawMessage(`{"text":1}`)}, RPCCodeInvalidParams},
+		{"handler error", &Message{Type: MessageTypeRPC, ID: "1", Method: "forbidden"}, "FORBIDDEN"},
+		{"internal error", &Message{Type: MessageTypeRPC, ID: "1", Method: "broken"}, RPCCodeInternal},
+		{"panic", &Message{Type: MessageTypeRPC, ID: "1", Method: "panics"}, RPCCodeInternal},
+	}
+	for _, tt := range tests {
+		router.dispatch(client, tt.message)
+		reply := rpcReply(t, client)
+		if reply.ID != tt.message.ID || reply.Error == nil || reply.Error.Code != tt.code {
+			t.Errorf("%s: expected %s, got %+v", tt.name, tt.code, reply)
+		}
+	}
+
+	// Internal errors don't leak their message
+	router.dispatch(client, &Message{Type: MessageTypeRPC, ID: "2", Method: "broken"})
+	if reply := rpcReply(t, client); reply.Error.Message != "Internal error" {
+		t.Errorf("Expected the error message to be hidden, got %q", reply.Error.Message)
+	}
+
+	router.dispatch(client, &Message{Type: MessageTypeRPC, ID: "3", Method: "echo", Params: json.RawMessage(`{"text":"hi"}`)})
+	reply := rpcReply(t, client)
+	if reply.ID != "3" || reply.Error != nil || string(reply.Result) != `{"text":"hi"}` {
+		t.Errorf("Expected the echoed result, got %+v", reply)
+	}
+}
+
+func TestRPCRouter_Timeout(t *testing.T) {
+	router := NewRPCRouter(WithRPCTimeout(20 * time.Millisecond))
+	release := make(chan struct{})
+	defer close(release)
+	router.Handle("slow", func(ctx context.Context, call *RPCCall) (any, error) {
+		// Ignores ctx, like a handler stuck on a call without a deadline
+		<-release
+		return "late", nil
+	})
+	client := newRPCTestClient(t)
+
+	router.dispatch(client, &Message{Type: MessageTypeRPC, ID: "1", Method: "slow"})
+	if reply := rpcReply(t, client); reply.Error == nil || reply.Error.Code != RPCCodeTimeout {
+		t.Errorf("Expected %s, got %+v", RPCCodeTimeout, reply)
+	}
+}
+
+func TestRPCRouter_Concurrency(t *testing.T) {
+	router := NewRPCRouter(WithRPCConcurrency(1), WithRPCTimeout(time.Minute))
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	router.Handle("wait", func(ctx context.Context, call *RPCCall) (any, error) {
+		entered <- struct{}{}
+		<-release
+		return "done", nil
+	})
+	client := newRPCTestClient(t)
+	other := newRPCTestClient(t)
+
+	router.dispatch(client, &Message{Type: MessageTypeRPC, ID: "1", Method: "wait"})
+	<-entered
+
+	router.dispatch(client, &Message{Type: MessageTypeRPC, ID: "2", Method: "wait"})
+	if reply := rpcReply(t, client); reply.ID != "2" || reply.Error == nil || reply.Error.Code != RPCCodeTooManyRequests {
+		t.Errorf("Expected the second call to be rejected, got %+v", reply)
+	}
+
+	// The limit is per client
+	router.dispatch(other, &Message{Type: MessageTypeRPC, ID: "1", Method: "wait"})
+	<-entered
+	release <- struct{}{}
+	release <- struct{}{}
+	if reply := rpcReply(t, client); reply.ID != "1" || reply.Error != nil {
+		t.Errorf("Expected the first call to complete, got %+v", reply)
+	}
+	if reply := rpcReply(t, other); reply.Error != nil {
+		t.Errorf("Expected the other client's call to complete, got %+v", reply)
+	}
+
+	// A completed call frees its slot
+	router.dispatch(client, &Message{Type: MessageTypeRPC, ID: "3", Method: "wait"})
+	<-entered
+	release <- struct{}{}
+	if reply := rpcReply(t, client); reply.ID != "3" || reply.Error != nil {
+		t.Errorf("Expected the slot to be reused, got %+v", reply)
+	}
+}
+
+func TestRPCRouter_Handle(t *testing.T) {
+	router := NewRPCRouter()
+	router.Handle("a", func(ctx context.Context, call *RPCCall) (any, error) { return nil, nil })
+	if methods := router.Methods(); len(methods) != 1 || methods[0] != "a" {
+		t.Errorf("Expected [a], got %v", methods)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected registering a nil handler to panic")
+		}
+	}()
+	router.Handle("b", nil)
+}
@@ -0,0 +1,226 @@
+package websocket
+
+import (
+	"hash/fnv"
+	"log/slog"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pixperk/goiler/pkg/events"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// shardQueueSize is how many messages can wait for a shard's goroutine
+// before the hub loop blocks
+const shardQueueSize = 256
+
+// HubOption configures a Hub
+type HubOption func(*Hub)
+
+// WithShards splits the hub's clients, rooms and users across n shards,
+// each with its own lock and delivery goroutine, so a broadcast to one room
+// doesn't wait for another's. Rooms and users are assigned to shards by the
+// hash of their name and clients by the hash of their ID. Values below 1
+// use GOMAXPROCS, the default.
+func WithShards(n int) HubOption {
+	return func(h *Hub) {
+		h.shardCount = n
+	}
+}
+
+// hubShard holds the rooms and users hashed to it, and the clients hashed
+// to it for broadcasts to everyone. Deliveries only take its lock.
+type hubShard struct {
+	index int
+	hub   *Hub
+
+	mu      sync.RWMutex
+	clients map[*Client]bool
+	rooms   map[string]map[*Client]bool
+	// Authenticated clients by user ID, oldest connection first
+	users map[string][]*Client
+
+	// queue feeds the shard's goroutine once Run has started it; before,
+	// messages are delivered by the caller
+	queue   chan *Message
+	running atomic.Bool
+}
+
+// newShards creates the hub's shards
+func (h *Hub) newShards() {
+	n := h.shardCount
+	if n < 1 {
+		n = runtime.GOMAXPROCS(0)
+	}
+	h.shards = make([]*hubShard, n)
+	for i := range h.shards {
+		h.shards[i] = &hubShard{
+			index:   i,
+			hub:     h,
+			clients: make(map[*Client]bool),
+			rooms:   make(map[string]map[*Client]bool),
+			users:   make(map[string][]*Client),
+			queue:   make(chan *Message, shardQueueSize),
+		}
+	}
+}
+
+// shardFor returns the shard of a room or user
+func (h *Hub) shardFor(key string) *hubShard {
+	if len(h.shards) == 1 {
+		return h.shards[0]
+	}
+	f := fnv.New32a()
+	f.Write([]byte(key))
+	return h.shards[f.Sum32()%uint32(len(h.shards))]
+}
+
+// clientShard returns the shard delivering broadcasts to everyone to client
+func (h *Hub) clientShard(client *Client) *hubShard {
+	return h.shardFor(client.ID)
+}
+
+// lockShards locks every shard, stopping deliveries; unlockShards resumes
+// them
+func (h *Hub) lockShards() {
+	for _, s := range h.shards {
+		s.mu.Lock()
+	}
+}
+
+func (h *Hub) unlockShards() {
+	for _, s := range h.shards {
+		s.mu.Unlock()
+	}
+}
+
+// userClients returns a user's clients on this instance, oldest first
+func (h *Hub) userClients(userID string) []*Client {
+	if userID == "" {
+		return nil
+	}
+	s := h.shardFor(userID)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.users[userID])
+}
+
+// run delivers the shard's queued messages until Shutdown
+func (s *hubShard) run() {
+	s.running.Store(true)
+	defer s.running.Store(false)
+	for {
+		select {
+		case <-s.hub.done:
+			return
+		case message := <-s.queue:
+			s.deliver(message)
+		}
+	}
+}
+
+// dispatch hands message to the shard's goroutine, or delivers it right
+// away when the goroutine isn't running
+func (s *hubShard) dispatch(message *Message) {
+	if s.running.Load() {
+		enqueue(s.hub, s.queue, message)
+		return
+	}
+	s.deliver(message)
+}
+
+// deliver sends message to the shard's members of its room, or to all the
+// shard's clients
+func (s *hubShard) deliver(message *Message) {
+	ctx, span := startFanOut("websocket.broadcast", message)
+	span.SetAttributes(attribute.Int("websocket.shard", s.index))
+	var recipients, dropped int
+	defer func() { endFanOut(span, recipients, dropped) }()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// If room is specified, only send to clients in that room
+	clients := s.clients
+	if message.Room != "" {
+		clients = s.rooms[message.Room]
+	}
+
+	h := s.hub
+	frames := newEncoded(message)
+	for client := range clients {
+		if client.replayed(message) {
+			continue
+		}
+		data, err := frames.frame(client)
+		if err != nil {
+			h.logger.Error("failed to encode message", slog.String("error", err.Error()))
+			h.metrics.countDropped(ctx, DropEncodeFailed)
+			dropped++
+			continue
+		}
+		if !h.queue(ctx, client, data) {
+			// Client's send buffer is full, skip
+			h.logger.Warn("client buffer full, dropping message",
+				slog.String("client_id", client.ID),
+			)
+			dropped++
+			continue
+		}
+		recipients++
+	}
+}
+
+// join adds a client to a room of the shard, returning the room events to
+// publish. The caller must hold s.mu.
+func (s *hubShard) join(client *Client, room string) []roomEvent {
+	var pending []roomEvent
+	if s.rooms[room] == nil {
+		s.rooms[room] = make(map[*Client]bool)
+		pending = append(pending, newRoomEvent(events.RoomCreated, room, client, 0))
+	}
+	if !s.rooms[room][client] {
+		s.rooms[room][client] = true
+		pending = append(pending, newRoomEvent(events.RoomMemberJoined, room, client, len(s.rooms[room])))
+	}
+	return pending
+}
+
+// leave removes a client from a room of the shard, returning the room
+// events to publish. The caller must hold s.mu.
+func (s *hubShard) leave(client *Client, room string) []roomEvent {
+	clients, ok := s.rooms[room]
+	if !ok {
+		return nil
+	}
+
+	var pending []roomEvent
+	if _, member := clients[client]; member {
+		delete(clients, client)
+		pending = append(pending, newRoomEvent(events.RoomMemberLeft, room, client, len(clients)))
+	}
+	if len(clients) == 0 {
+		delete(s.rooms, room)
+		pending = append(pending, newRoomEvent(events.RoomEmptied, room, nil, 0))
+	}
+	return pending
+}
+
+// addUser indexes an authenticated client under its user. The caller must
+// hold s.mu.
+func (s *hubShard) addUser(client *Client) {
+	s.users[client.UserID] = append(s.users[client.UserID], client)
+}
+
+// removeUser drops a client from its user's index. The caller must hold
+// s.mu.
+func (s *hubShard) removeUser(client *Client) {
+	remaining := slices.DeleteFunc(s.users[client.UserID], func(c *Client) bool { return c == client })
+	if len(remaining) == 0 {
+		delete(s.users, client.UserID)
+	} else {
+		s.users[client.UserID] = remaining
+	}
+}
@@ -0,0 +1,102 @@
+package websocket
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pixperk/goiler/internal/testutil"
+)
+
+func TestHub_Shards(t *testing.T) {
+	logger := testutil.Logger()
+	hub := NewHub(logger, WithShards(4))
+	if len(hub.shards) != 4 {
+		t.Fatalf("Expected 4 shards, got %d", len(hub.shards))
+	}
+	go hub.Run()
+	defer hub.Shutdown(t.Context())
+
+	var clients []*Client
+	for i := range 32 {
+		client := &Client{
+			ID:     fmt.Sprintf("client-%d", i),
+			UserID: fmt.Sprintf("user-%d", i%8),
+			hub:    hub,
+			logger: logger,
+			send:   make(chan []byte, 64),
+			rooms:  make(map[string]bool),
+		}
+		hub.registerClient(client)
+		hub.addClientToRoom(client, fmt.Sprintf("room-%d", i%16))
+		clients = append(clients, client)
+	}
+
+	stats := hub.Stats()
+	if stats.ConnectedClients != 32 || stats.Users != 8 || stats.Rooms != 16 {
+		t.Errorf("Expected 32 clients, 8 users and 16 rooms, got %+v", stats)
+	}
+	if n := hub.GetRoomClients("room-3"); n != 2 {
+		t.Errorf("Expected 2 clients in room-3, got %d", n)
+	}
+	if n := hub.UserConnections("user-3"); n != 4 {
+		t.Errorf("Expected 4 connections for user-3, got %d", n)
+	}
+
+	hub.deliverMessage(&Message{Type: "room-1", Room: "room-3"})
+	hub.deliverMessage(&Message{Type: "all"})
+	hub.deliverMessage(&Message{Type: "room-2", Room: "room-3"})
+	hub.deliverToUser("user-3", &Message{Type: "user"})
+
+	for i, client := range clients {
+		want := []string{"all"}
+		switch {
+		case i%16 == 3:
+			want = []string{"room-1", "all", "room-2", "user"}
+		case i%8 == 3:
+			want = []string{"all", "user"}
+		}
+		got := make(map[string]int)
+		var order []string
+		for range want {
+			select {
+			case data := <-client.send:
+				msg, err := DecodeMessage(data)
+				if err != nil {
+					t.Fatalf("Invalid message: %v", err)
+				}
+				got[msg.Type]++
+				if msg.Type == "room-1" || msg.Type == "room-2" {
+					order = append(order, msg.Type)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%s: expected %v, got %v", client.ID, want, got)
+			}
+		}
+		for _, typ := range want {
+			if got[typ] != 1 {
+				t.Errorf("%s: expected %v, got %v", client.ID, want, got)
+			}
+		}
+		if len(order) == 2 && order[0] != "room-1" {
+			t.Errorf("%s: expected room messages in order, got %v", client.ID, order)
+		}
+	}
+
+	hub.unregisterClient(clients[3])
+	if n := hub.GetRoomClients("room-3"); n != 1 {
+		t.Errorf("Expected 1 client left in room-3, got %d", n)
+	}
+	if n := hub.UserConnections("user-3"); n != 3 {
+		t.Errorf("Expected 3 connections left for user-3, got %d", n)
+	}
+}
+
+func TestClient_Replayed(t *testing.T) {
+	client := &Client{replayedThrough: 5}
+	for seq, want := range map[uint64]bool{0: false, 3: true, 5: true, 6: false} {
+		if got := client.replayed(&Message{Seq: seq}); got != want {
+			t.Errorf("Seq %d: expected replayed %v, got %v", seq, want, got)
+		}
+	}
+}
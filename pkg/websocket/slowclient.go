@@ -0,0 +1,138 @@
+package websocket
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// CloseSlowConsumer is the close code of connections evicted for reading
+// slower than messages arrive. Clients should reconnect, resuming their
+// session to catch up.
+const CloseSlowConsumer = 4408
+
+// Reasons clients are evicted, the reason label of
+// websocket_clients_evicted_total
+const (
+	// EvictSlowConsumer is a client that stayed slow for the slow client
+	// window
+	EvictSlowConsumer = "slow_consumer"
+	// EvictUnresponsive is a connection that stopped answering pings
+	EvictUnresponsive = "unresponsive"
+	// EvictQuota is a connection replaced by a newer one of its user over
+	// the connection quota
+	EvictQuota = "quota"
+)
+
+// SlowClientPolicy evicts clients that stay slow for Window. A client is
+// slow while at least MaxDrops consecutive messages to it were dropped for
+// a full send buffer, or while its last write took longer than MaxLatency.
+// A zero MaxDrops or MaxLatency ignores that signal, and a zero Window
+// disables eviction.
+type SlowClientPolicy struct {
+	MaxDrops   int           `json:"max_drops"`
+	MaxLatency time.Duration `json:"max_latency"`
+	Window     time.Duration `json:"window"`
+}
+
+// SetSlowClientPolicy sets when slow clients are evicted. Clients keep the
+// policy in force when they connected.
+func (h *Hub) SetSlowClientPolicy(policy SlowClientPolicy) error {
+	if policy.MaxDrops < 0 || policy.MaxLatency < 0 || policy.Window < 0 {
+		return fmt.Errorf("slow client limits must not be negative")
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.slowPolicy = policy
+	return nil
+}
+
+// SlowClientPolicy returns when slow clients are evicted
+func (h *Hub) SlowClientPolicy() SlowClientPolicy {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.slowPolicy
+}
+
+// slowTracker follows how far behind a client is. Deliveries from every
+// shard and the client's writer update it concurrently.
+type slowTracker struct {
+	policy SlowClientPolicy
+
+	drops   atomic.Int64
+	latency atomic.Int64
+	// slowSince is when the client became slow, in Unix nanoseconds, or 0
+	slowSince atomic.Int64
+	evicted   atomic.Bool
+}
+
+// newSlowTracker returns a tracker for a new client under the hub's
+// policy, or nil when eviction is disabled
+func (h *Hub) newSlowTracker() *slowTracker {
+	policy := h.SlowClientPolicy()
+	if policy.Window == 0 || (policy.MaxDrops == 0 && policy.MaxLatency == 0) {
+		return nil
+	}
+	return &slowTracker{policy: policy}
+}
+
+// dropped records a message dropped for a full buffer, reporting whether
+// the client must now be evicted
+func (t *slowTracker) dropped() bool {
+	if t == nil {
+		return false
+	}
+	t.drops.Add(1)
+	return t.update(time.Now())
+}
+
+// queued records a message queued to the client, ending a run of drops
+func (t *slowTracker) queued() {
+	if t == nil || t.drops.Load() == 0 {
+		return
+	}
+	t.drops.Store(0)
+	t.update(time.Now())
+}
+
+// wrote records how long a write to the client took, reporting whether
+// the client must now be evicted
+func (t *slowTracker) wrote(latency time.Duration) bool {
+	if t == nil {
+		return false
+	}
+	t.latency.Store(int64(latency))
+	return t.update(time.Now())
+}
+
+// update tracks when the client became slow, reporting true once when it
+// has been slow for the whole window
+func (t *slowTracker) update(now time.Time) bool {
+	slow := (t.policy.MaxDrops > 0 && t.drops.Load() >= int64(t.policy.MaxDrops)) ||
+		(t.policy.MaxLatency > 0 && time.Duration(t.latency.Load()) > t.policy.MaxLatency)
+	if !slow {
+		t.slowSince.Store(0)
+		return false
+	}
+	since := t.slowSince.Load()
+	if since == 0 {
+		t.slowSince.CompareAndSwap(0, now.UnixNano())
+		return false
+	}
+	return now.Sub(time.Unix(0, since)) >= t.policy.Window && t.evicted.CompareAndSwap(false, true)
+}
+
+// evictSlow closes a client that stayed slow for the window
+func (h *Hub) evictSlow(client *Client) {
+	h.logger.Warn("evicting slow client",
+		slog.String("client_id", client.ID),
+		slog.String("user_id", client.UserID),
+		slog.Int64("consecutive_drops", client.slow.drops.Load()),
+		slog.Duration("write_latency", time.Duration(client.slow.latency.Load())),
+	)
+	h.metrics.countEvicted(context.Background(), EvictSlowConsumer)
+	go client.close(CloseSlowConsumer, "slow consumer")
+}
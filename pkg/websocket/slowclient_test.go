@@ -0,0 +1,93 @@
+package websocket
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pixperk/goiler/internal/testutil"
+)
+
+func TestSlowTracker(t *testing.T) {
+	tracker := &slowTracker{policy: SlowClientPolicy{MaxDrops: 2, MaxLatency: time.Second, Window: time.Minute}}
+	start := time.Now()
+
+	tracker.drops.Store(1)
+	if tracker.update(start) || tracker.slowSince.Load() != 0 {
+		t.Fatal("Expected one drop not to make the client slow")
+	}
+	tracker.drops.Store(2)
+	if tracker.update(start) {
+		t.Fatal("Expected no eviction as the client becomes slow")
+	}
+	if tracker.update(start.Add(30 * time.Second)) {
+		t.Fatal("Expected no eviction within the window")
+	}
+
+	// A queued message ends the run of drops, restarting the window
+	tracker.queued()
+	if tracker.slowSince.Load() != 0 {
+		t.Fatal("Expected a queued message to end the slow period")
+	}
+
+	tracker.latency.Store(int64(2 * time.Second))
+	tracker.update(start.Add(time.Minute))
+	if tracker.update(start.Add(90 * time.Second)) {
+		t.Fatal("Expected the window to restart after the client caught up")
+	}
+	if !tracker.update(start.Add(2 * time.Minute)) {
+		t.Fatal("Expected eviction after a window of slow writes")
+	}
+	if tracker.update(start.Add(3 * time.Minute)) {
+		t.Error("Expected the client to be evicted only once")
+	}
+
+	var disabled *slowTracker
+	if disabled.dropped() || disabled.wrote(time.Hour) {
+		t.Error("Expected a nil tracker never to evict")
+	}
+}
+
+func TestHub_SlowClientPolicy(t *testing.T) {
+	logger := testutil.Logger()
+	hub := NewHub(logger)
+	if err := hub.SetSlowClientPolicy(SlowClientPolicy{MaxDrops: -1}); err == nil {
+		t.Error("Expected negative limits to be rejected")
+	}
+	if hub.newSlowTracker() != nil {
+		t.Error("Expected no tracker without a policy")
+	}
+	if err := hub.SetSlowClientPolicy(SlowClientPolicy{Window: time.Minute}); err != nil {
+		t.Fatal(err)
+	}
+	if hub.newSlowTracker() != nil {
+		t.Error("Expected no tracker without a signal")
+	}
+}
+
+func TestHub_EvictsSlowClient(t *testing.T) {
+	logger := testutil.Logger()
+	hub := NewHub(logger)
+	if err := hub.SetSlowClientPolicy(SlowClientPolicy{MaxDrops: 1, Window: time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+
+	// An event stream client that never reads
+	client := newClient(context.Background(), hub, nil, "user-1", logger)
+	client.send = make(chan []byte, 1)
+	hub.registerClient(client)
+
+	hub.deliverToUser("user-1", &Message{Type: "fill"})
+	hub.deliverToUser("user-1", &Message{Type: "dropped"})
+	time.Sleep(5 * time.Millisecond)
+	hub.deliverToUser("user-1", &Message{Type: "dropped"})
+
+	select {
+	case <-client.ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the slow client to be closed")
+	}
+	if stats := hub.Stats(); stats.Evicted != 1 {
+		t.Errorf("Expected 1 eviction, got %d", stats.Evicted)
+	}
+}
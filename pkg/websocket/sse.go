@@ -0,0 +1,190 @@
+package websocket
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/pkg/authctx"
+)
+
+// EventsRoomsParam is the query parameter listing the rooms an event stream
+// subscribes to, comma separated
+const EventsRoomsParam = "rooms"
+
+// eventsKeepAlive is how often an idle event stream is sent a comment, so
+// proxies don't time it out and dead streams are noticed
+const eventsKeepAlive = 15 * time.Second
+
+// HandleEvents streams hub messages as Server-Sent Events, for clients
+// behind proxies that block WebSockets and for dashboards that only listen.
+// The stream receives the messages broadcast to everyone, to the rooms
+// listed in the rooms query parameter and to the user, each as the data of
+// an unnamed event in the WebSocket JSON format.
+// @Summary Event stream
+// @Description Stream broadcasts, room messages and the user's messages as Server-Sent Events. Browsers authenticate with the token query parameter; other streams are anonymous and only receive broadcasts and room messages.
+// @Tags WebSocket
+// @Produce text/event-stream
+// @Param rooms query string false "Comma-separated rooms to subscribe to"
+// @Param token query string false "Access token"
+// @Success 200 {string} string "Event stream"
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 429 {object} response.Response
+// @Failure 503 {object} response.Response
+// @Router /api/v1/events [get]
+func (h *Handler) HandleEvents(c echo.Context) error {
+	if !h.checkOrigin(c.Request()) {
+		return h.rejectOrigin(c)
+	}
+	if h.hub.Draining() {
+		return rejectDraining(c)
+	}
+
+	user, ok := authctx.Get(c)
+	if !ok {
+		var err error
+		if user, err = h.queryUser(c); err != nil {
+			return echo.NewHTTPError(http.StatusUnauthorized, "invalid token")
+		}
+	}
+	userID, role := "", ""
+	if user != nil {
+		userID, role = user.ID.String(), user.Role
+	}
+	if h.hub.overQuota(userID) || !h.reserveIP(c.RealIP()) {
+		h.logger.Warn("websocket connection quota exceeded",
+			slog.String("user_id", userID),
+			slog.String("ip", c.RealIP()),
+		)
+		return echo.NewHTTPError(http.StatusTooManyRequests, "too many connections")
+	}
+
+	// The stream ends when the request does
+	client := newClient(c.Request().Context(), h.hub, nil, userID, h.logger)
+	client.Role = role
+	client.ip = c.RealIP()
+
+	rooms := eventRooms(c.QueryParam(EventsRoomsParam))
+	for _, room := range rooms {
+		if !h.hub.allowed(client, room) || !h.hub.authorized(client, "join", room) {
+			h.hub.releaseIP(client.ip)
+			client.cancel()
+			return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("room %q not allowed", room))
+		}
+	}
+
+	payload := `{"message": "Connected to event stream", "client_id": "` + client.ID + `"`
+	if userID != "" {
+		payload += `, "user_id": "` + userID + `"`
+	}
+	if data, err := client.encode(&Message{Type: "connected", Payload: []byte(payload + "}")}); err == nil {
+		client.send <- data
+	}
+
+	if !enqueue(h.hub, h.hub.register, client) {
+		h.hub.releaseIP(client.ip)
+		client.cancel()
+		return rejectDraining(c)
+	}
+	defer func() {
+		client.cancel()
+		enqueue(h.hub, h.hub.unregister, client)
+		close(client.written)
+	}()
+	for _, room := range rooms {
+		client.JoinRoom(room)
+	}
+
+	return client.streamEvents(c.Response())
+}
+
+// eventRooms parses the rooms query parameter
+func eventRooms(param string) []string {
+	var rooms []string
+	for _, room := range strings.Split(param, ",") {
+		if room = strings.TrimSpace(room); room != "" {
+			rooms = append(rooms, room)
+		}
+	}
+	return rooms
+}
+
+// streamEvents writes the client's messages to w until the client is
+// cancelled, the hub closes its send channel or a write fails. Like the
+// write pump, it writes the messages already queued before returning.
+func (c *Client) streamEvents(w *echo.Response) error {
+	rc := http.NewResponseController(w)
+	header := w.Header()
+	header.Set(echo.HeaderContentType, "text/event-stream")
+	header.Set(echo.HeaderCacheControl, "no-cache")
+	header.Set(echo.HeaderConnection, "keep-alive")
+	// Disable response buffering in nginx
+	header.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	// EventSource reconnects after retry milliseconds once the stream ends
+	fmt.Fprintf(w, "retry: %d\n\n", drainRetryAfter.Milliseconds())
+	if err := rc.Flush(); err != nil {
+		return nil
+	}
+
+	ticker := time.NewTicker(eventsKeepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return nil
+
+		case message, ok := <-c.send:
+			if !ok {
+				// Hub closed the channel
+				return nil
+			}
+			start := time.Now()
+			rc.SetWriteDeadline(start.Add(writeWait))
+			if err := writeEvent(w, message); err != nil {
+				return nil
+			}
+			n := len(c.send)
+			for i := 0; i < n; i++ {
+				if err := writeEvent(w, <-c.send); err != nil {
+					return nil
+				}
+			}
+			if err := rc.Flush(); err != nil {
+				return nil
+			}
+			if c.slow.wrote(time.Since(start)) {
+				c.hub.evictSlow(c)
+			}
+
+		case <-ticker.C:
+			rc.SetWriteDeadline(time.Now().Add(writeWait))
+			if _, err := w.Write([]byte(": ping\n\n")); err != nil {
+				return nil
+			}
+			if err := rc.Flush(); err != nil {
+				return nil
+			}
+		}
+	}
+}
+
+// writeEvent writes data as an unnamed event, one data field per line
+func writeEvent(w http.ResponseWriter, data []byte) error {
+	var buf bytes.Buffer
+	for _, line := range bytes.Split(data, []byte{'\n'}) {
+		buf.WriteString("data: ")
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+	_, err := w.Write(buf.Bytes())
+	return err
+}
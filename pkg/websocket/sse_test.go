@@ -0,0 +1,90 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/internal/testutil"
+)
+
+func TestHandler_Events(t *testing.T) {
+	logger := testutil.Logger()
+	hub := NewHub(logger)
+	go hub.Run()
+	hub.RestrictRoom("private", func(*Client) bool { return false })
+
+	e := echo.New()
+	e.GET("/events", NewHandler(hub, logger).HandleEvents)
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/events?rooms=private")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected 403 for a restricted room, got %d", resp.StatusCode)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/events?rooms=chat,+news", nil)
+	req.Header.Set(echo.HeaderAccept, "text/event-stream")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get(echo.HeaderContentType); ct != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %q", ct)
+	}
+
+	events := bufio.NewScanner(resp.Body)
+	next := func() *Message {
+		t.Helper()
+		for events.Scan() {
+			if data, ok := strings.CutPrefix(events.Text(), "data: "); ok {
+				msg, err := DecodeMessage([]byte(data))
+				if err != nil {
+					t.Fatalf("Invalid event %q: %v", data, err)
+				}
+				return msg
+			}
+		}
+		t.Fatalf("Stream ended: %v", events.Err())
+		return nil
+	}
+	if msg := next(); msg.Type != "connected" {
+		t.Fatalf("Expected the welcome message, got %+v", msg)
+	}
+
+	for hub.GetRoomClients("news") == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	hub.BroadcastToRoom("other", &Message{Type: "skipped", Room: "other"})
+	hub.BroadcastToRoom("news", &Message{Type: "headline", Room: "news", Payload: []byte(`{"title":"hello"}`)})
+	hub.BroadcastToAll(&Message{Type: "notice"})
+
+	if msg := next(); msg.Type != "headline" || string(msg.Payload) != `{"title":"hello"}` {
+		t.Errorf("Expected the room message, got %+v", msg)
+	}
+	if msg := next(); msg.Type != "notice" {
+		t.Errorf("Expected the broadcast, got %+v", msg)
+	}
+
+	// The client leaves the hub when the stream is closed
+	resp.Body.Close()
+	for hub.GetConnectedClients() > 0 {
+		if ctx.Err() != nil {
+			t.Fatal("Expected the client to be unregistered")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
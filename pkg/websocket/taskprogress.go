@@ -0,0 +1,78 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+
+	"github.com/pixperk/goiler/pkg/events"
+	"github.com/pixperk/goiler/pkg/pubsub"
+)
+
+// MessageTypeTaskProgress is the type of the messages telling a user how
+// their background tasks are doing. The payload is an
+// events.TaskProgressEvent.
+const MessageTypeTaskProgress = "task.progress"
+
+// UserSender sends messages to a user's connections. *Hub and *BrokerHub
+// satisfy it, and so does *BrokerSender in processes without a hub.
+type UserSender interface {
+	BroadcastToUser(userID string, message *Message)
+}
+
+// BrokerSender publishes messages for users to a Broker without a hub of
+// its own, so processes such as the worker reach the clients connected to
+// the instances subscribed to it
+type BrokerSender struct {
+	broker Broker
+	logger *slog.Logger
+}
+
+// NewBrokerSender creates a sender publishing to broker
+func NewBrokerSender(broker Broker, logger *slog.Logger) *BrokerSender {
+	return &BrokerSender{broker: broker, logger: logger}
+}
+
+// BroadcastToUser publishes message for the user's connections on every
+// instance
+func (s *BrokerSender) BroadcastToUser(userID string, message *Message) {
+	data, err := json.Marshal(&envelope{UserID: userID, Message: message})
+	if err != nil {
+		s.logger.Error("failed to encode message", slog.String("error", err.Error()))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), brokerPublishTimeout)
+	defer cancel()
+	if err := s.broker.Publish(ctx, data); err != nil {
+		s.logger.Warn("failed to publish message",
+			slog.String("user_id", userID),
+			slog.String("error", err.Error()),
+		)
+	}
+}
+
+// RelayTaskProgress sends the task progress events published on bus to the
+// users they're for, as task.progress messages, until ctx is done
+func RelayTaskProgress(ctx context.Context, bus *pubsub.PubSub, sender UserSender, logger *slog.Logger) {
+	sub := bus.Subscribe(ctx, "websocket-task-progress", events.TaskProgress.Name())
+	defer bus.Unsubscribe(sub)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-sub.Channel:
+			progress, ok := events.TaskProgress.Payload(e.Payload)
+			if !ok || progress.UserID == "" {
+				continue
+			}
+			payload, err := json.Marshal(progress)
+			if err != nil {
+				logger.Error("failed to encode task progress", slog.String("error", err.Error()))
+				continue
+			}
+			sender.BroadcastToUser(progress.UserID, &Message{Type: MessageTypeTaskProgress, Payload: payload})
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/pixperk/goiler/internal/testutil"
+	"github.com/pixperk/goiler/pkg/events"
+	"github.com/pixperk/goiler/pkg/pubsub"
+)
+
+func TestRelayTaskProgress_ThroughBroker(t *testing.T) {
+	logger := testutil.Logger()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// An API instance with a connected user
+	broker := &memoryBroker{}
+	hub := NewHub(logger)
+	go NewBrokerHub(hub, broker, logger).Run(ctx)
+	broker.waitSubscribers(t, 1)
+	client := &Client{ID: "client", UserID: "user-1", hub: hub, logger: logger, send: make(chan []byte, 16), rooms: make(map[string]bool)}
+	hub.registerClient(client)
+
+	// A worker publishing task progress
+	bus := pubsub.New(logger, 10)
+	go RelayTaskProgress(ctx, bus, NewBrokerSender(broker, logger), logger)
+	for bus.GetSubscriberCount(events.TaskProgress.Name()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	events.TaskProgress.Publish(bus, events.TaskProgressEvent{TaskID: "task-2", UserID: "user-2", Status: events.TaskRunning})
+	events.TaskProgress.Publish(bus, events.TaskProgressEvent{
+		TaskID:   "task-1",
+		TaskType: "report:generate",
+		UserID:   "user-1",
+		Status:   events.TaskCompleted,
+		Progress: 1,
+		Result:   map[string]any{"report_id": "r-1"},
+	})
+
+	select {
+	case data := <-client.send:
+		msg, err := DecodeMessage(data)
+		if err != nil || msg.Type != MessageTypeTaskProgress {
+			t.Fatalf("Expected a task.progress message, got %s", data)
+		}
+		var progress events.TaskProgressEvent
+		if err := json.Unmarshal(msg.Payload, &progress); err != nil {
+			t.Fatalf("Invalid payload: %v", err)
+		}
+		if progress.TaskID != "task-1" || progress.Status != events.TaskCompleted || progress.Result["report_id"] != "r-1" {
+			t.Errorf("Expected task-1 completed with its report, got %+v", progress)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the task progress to reach the user")
+	}
+	if len(client.send) != 0 {
+		t.Errorf("Expected only the user's task progress, got %d more messages", len(client.send))
+	}
+}
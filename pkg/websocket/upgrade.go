@@ -0,0 +1,117 @@
+package websocket
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+)
+
+// HandlerOption configures a Handler
+type HandlerOption func(*Handler)
+
+// WithAllowedOrigins accepts upgrades from browsers on origins, such as
+// https://app.example.com, besides the server's own; "*" accepts any
+// origin. Clients sending no Origin header aren't browsers and are always
+// accepted.
+func WithAllowedOrigins(origins ...string) HandlerOption {
+	return func(h *Handler) {
+		h.origins = append(h.origins, origins...)
+	}
+}
+
+// WithBufferSizes sets the read and write buffer sizes of connections, in
+// bytes; zero keeps the default. Messages larger than the buffers still
+// fit, in several reads or writes.
+func WithBufferSizes(read, write int) HandlerOption {
+	return func(h *Handler) {
+		if read > 0 {
+			h.upgrader.ReadBufferSize = read
+		}
+		if write > 0 {
+			h.upgrader.WriteBufferSize = write
+		}
+	}
+}
+
+// WithMaxMessageSize sets the largest message clients may send, in bytes;
+// connections sending larger ones are closed with 1009 (message too big)
+func WithMaxMessageSize(size int64) HandlerOption {
+	return func(h *Handler) {
+		if size > 0 {
+			h.readLimit = size
+		}
+	}
+}
+
+// WithMaxConnectionsPerIP limits the connections open from each client IP,
+// authenticated or not; 0 means unlimited. Like the per-user quota, the
+// limit is soft and upgrades over it are closed with
+// CloseTooManyConnections.
+func WithMaxConnectionsPerIP(limit int) HandlerOption {
+	return func(h *Handler) {
+		if limit > 0 {
+			h.maxPerIP = limit
+		}
+	}
+}
+
+// WithPongTimeout sets how long a connection may go without answering a
+// ping before it's closed as unresponsive; pings are sent every 9/10 of
+// it. Zero keeps the default of 60 seconds.
+func WithPongTimeout(timeout time.Duration) HandlerOption {
+	return func(h *Handler) {
+		if timeout > 0 {
+			h.pongWait = timeout
+		}
+	}
+}
+
+// checkOrigin accepts requests from the allowed origins, the server's own
+// origin and non-browser clients
+func (h *Handler) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range h.origins {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// rejectOrigin refuses an upgrade from an origin that isn't allowed
+func (h *Handler) rejectOrigin(c echo.Context) error {
+	h.logger.Warn("websocket origin not allowed",
+		slog.String("origin", c.Request().Header.Get("Origin")),
+		slog.String("ip", c.RealIP()),
+	)
+	return echo.NewHTTPError(http.StatusForbidden, "origin not allowed")
+}
+
+// newClient creates the client of an upgraded connection, keeping the
+// request's values such as its tenant
+func (h *Handler) newClient(c echo.Context, conn *websocket.Conn, userID string) *Client {
+	client := newClient(context.WithoutCancel(c.Request().Context()), h.hub, conn, userID, h.logger)
+	client.ip = c.RealIP()
+	client.readLimit = h.readLimit
+	client.pongWait = h.pongWait
+	client.codec = h.negotiatedCodec(conn)
+	client.bytes = h.bytes
+	if h.compresses(c.Request()) {
+		client.compressed = true
+		client.compressThreshold = h.compressionThreshold
+		if err := conn.SetCompressionLevel(h.compressionLevel); err != nil {
+			h.logger.Warn("invalid websocket compression level", slog.String("error", err.Error()))
+		}
+	}
+	return client
+}
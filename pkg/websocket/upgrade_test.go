@@ -0,0 +1,113 @@
+package websocket
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pixperk/goiler/internal/testutil"
+)
+
+func TestHandler_CheckOrigin(t *testing.T) {
+	logger := testutil.Logger()
+	tests := []struct {
+		name    string
+		allowed []string
+		origin  string
+		want    bool
+	}{
+		{"no origin", nil, "", true},
+		{"same origin", nil, "https://api.example.com", true},
+		{"cross origin", nil, "https://evil.example.com", false},
+		{"allowed origin", []string{"https://app.example.com"}, "https://app.example.com", true},
+		{"allowed with trailing slash", []string{"https://app.example.com/"}, "https://app.example.com", true},
+		{"other origin", []string{"https://app.example.com"}, "https://evil.example.com", false},
+		{"any origin", []string{"*"}, "https://evil.example.com", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHandler(NewHub(logger), logger, WithAllowedOrigins(tt.allowed...))
+			req := httptest.NewRequest(http.MethodGet, "https://api.example.com/api/v1/ws", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if got := h.checkOrigin(req); got != tt.want {
+				t.Errorf("Expected %v for origin %q, got %v", tt.want, tt.origin, got)
+			}
+		})
+	}
+}
+
+func TestHandleConnection_RejectsOrigin(t *testing.T) {
+	logger := testutil.Logger()
+	h := NewHandler(NewHub(logger), logger, WithAllowedOrigins("https://app.example.com"))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/ws", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	c := echo.New().NewContext(req, httptest.NewRecorder())
+
+	var httpErr *echo.HTTPError
+	if err := h.HandleConnection(c); !errors.As(err, &httpErr) || httpErr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403, got %v", err)
+	}
+}
+
+func TestHandler_IPLimit(t *testing.T) {
+	logger := testutil.Logger()
+	hub := NewHub(logger)
+	h := NewHandler(hub, logger, WithMaxConnectionsPerIP(2))
+
+	clients := make([]*Client, 2)
+	for i := range clients {
+		if !h.reserveIP("203.0.113.7") {
+			t.Fatal("Expected connections under the limit to be allowed")
+		}
+		clients[i] = &Client{ID: "c", hub: hub, logger: logger, ip: "203.0.113.7", send: make(chan []byte, 16), rooms: make(map[string]bool)}
+		hub.registerClient(clients[i])
+	}
+	if h.reserveIP("203.0.113.7") {
+		t.Error("Expected a third connection from the IP to be refused")
+	}
+	if !h.reserveIP("198.51.100.1") {
+		t.Error("Expected other IPs to be unaffected")
+	}
+	hub.releaseIP("198.51.100.1")
+
+	hub.unregisterClient(clients[0])
+	if !h.reserveIP("203.0.113.7") {
+		t.Error("Expected closing a connection to free a slot")
+	}
+	// An upgrade that fails gives its slot back
+	hub.releaseIP("203.0.113.7")
+	hub.unregisterClient(clients[1])
+	if len(hub.ips) != 0 {
+		t.Errorf("Expected every IP to be forgotten once its connections closed, got %v", hub.ips)
+	}
+}
+
+func TestHandler_IPLimitReservesAtomically(t *testing.T) {
+	logger := testutil.Logger()
+	h := NewHandler(NewHub(logger), logger, WithMaxConnectionsPerIP(3))
+
+	// Upgrades racing past the check would all be admitted if the slot
+	// were only taken on registering
+	var wg sync.WaitGroup
+	var admitted atomic.Int32
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if h.reserveIP("203.0.113.7") {
+				admitted.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := admitted.Load(); n != 3 {
+		t.Errorf("Expected exactly 3 connections admitted, got %d", n)
+	}
+}
@@ -0,0 +1,208 @@
+// Package worker holds the reusable parts of the asynq worker: versioned
+// payload codecs, task heartbeats and the orphan janitor, dead-letter
+// management and queue scaling metrics. Queues and connections are passed
+// in by the caller.
+package worker
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	ErrUnsupportedPayload = errors.New("payload type not supported by codec")
+	ErrUnknownCodec       = errors.New("unknown payload codec")
+)
+
+// Codec serializes task payloads
+type Codec interface {
+	// Name identifies the codec in configuration
+	Name() string
+	// ID identifies the codec inside encoded payloads
+	ID() byte
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// WireMarshaler is implemented by payloads that encode themselves in
+// protobuf wire format without generated code
+type WireMarshaler interface {
+	MarshalWire() ([]byte, error)
+}
+
+// WireUnmarshaler is the decoding counterpart of WireMarshaler
+type WireUnmarshaler interface {
+	UnmarshalWire(data []byte) error
+}
+
+// Versioned is implemented by payloads whose schema has changed over time.
+// The version is written alongside the payload when it is enqueued.
+type Versioned interface {
+	PayloadVersion() uint16
+}
+
+// Upgrader is implemented by payloads that can fill in fields missing from
+// older schema versions. UpgradeFrom is called after decoding a payload
+// written with an older version.
+type Upgrader interface {
+	UpgradeFrom(version uint16) error
+}
+
+// JSONCodec encodes payloads as JSON
+type JSONCodec struct{}
+
+func (JSONCodec) Name() string                               { return "json" }
+func (JSONCodec) ID() byte                                   { return 1 }
+func (JSONCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+// ProtoCodec encodes payloads in protobuf wire format. Payloads must be
+// generated proto.Message types or implement WireMarshaler and WireUnmarshaler.
+type ProtoCodec struct{}
+
+func (ProtoCodec) Name() string { return "protobuf" }
+func (ProtoCodec) ID() byte     { return 2 }
+
+func (ProtoCodec) Marshal(v interface{}) ([]byte, error) {
+	switch m := v.(type) {
+	case proto.Message:
+		return proto.Marshal(m)
+	case WireMarshaler:
+		return m.MarshalWire()
+	}
+	return nil, fmt.Errorf("%w: %T", ErrUnsupportedPayload, v)
+}
+
+func (ProtoCodec) Unmarshal(data []byte, v interface{}) error {
+	switch m := v.(type) {
+	case proto.Message:
+		return proto.Unmarshal(data, m)
+	case WireUnmarshaler:
+		return m.UnmarshalWire(data)
+	}
+	return fmt.Errorf("%w: %T", ErrUnsupportedPayload, v)
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[byte]Codec{
+		JSONCodec{}.ID():  JSONCodec{},
+		ProtoCodec{}.ID(): ProtoCodec{},
+	}
+	payloadCodec Codec = JSONCodec{}
+)
+
+// RegisterCodec makes a codec available for decoding and for SetPayloadCodec
+func RegisterCodec(codec Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[codec.ID()] = codec
+}
+
+// SetPayloadCodec selects the codec used for new task payloads by name.
+// Payloads the codec can't encode fall back to JSON.
+func SetPayloadCodec(name string) error {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	for _, codec := range codecs {
+		if codec.Name() == name {
+			payloadCodec = codec
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrUnknownCodec, name)
+}
+
+func codecByID(id byte) (Codec, bool) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	codec, ok := codecs[id]
+	return codec, ok
+}
+
+func currentCodec() Codec {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	return payloadCodec
+}
+
+// Encoded payloads start with a 4-byte header:
+//
+//	magic (0xA7) | codec ID | schema version (uint16, big endian)
+//
+// Payloads without the magic byte predate the header and are decoded as
+// version 1 JSON.
+const (
+	payloadMagic      = 0xA7
+	payloadHeaderSize = 4
+)
+
+// EncodePayload serializes v with the configured codec and prefixes the
+// codec and schema version
+func EncodePayload(v interface{}) ([]byte, error) {
+	codec := currentCodec()
+	body, err := codec.Marshal(v)
+	if errors.Is(err, ErrUnsupportedPayload) {
+		codec = JSONCodec{}
+		body, err = codec.Marshal(v)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	version := uint16(1)
+	if versioned, ok := v.(Versioned); ok {
+		version = versioned.PayloadVersion()
+	}
+
+	data := make([]byte, payloadHeaderSize, payloadHeaderSize+len(body))
+	data[0] = payloadMagic
+	data[1] = codec.ID()
+	binary.BigEndian.PutUint16(data[2:], version)
+	return append(data, body...), nil
+}
+
+// DecodePayload deserializes an encoded payload into v, upgrading it if it
+// was written with an older schema version, and returns that version
+func DecodePayload(data []byte, v interface{}) (uint16, error) {
+	codec, version, body, err := splitPayload(data)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := codec.Unmarshal(body, v); err != nil {
+		return 0, err
+	}
+
+	if versioned, ok := v.(Versioned); ok && version < versioned.PayloadVersion() {
+		if upgrader, ok := v.(Upgrader); ok {
+			if err := upgrader.UpgradeFrom(version); err != nil {
+				return 0, fmt.Errorf("failed to upgrade payload from version %d: %w", version, err)
+			}
+		}
+	}
+
+	return version, nil
+}
+
+// splitPayload parses the payload header, skipping any metadata
+func splitPayload(data []byte) (Codec, uint16, []byte, error) {
+	_, data, err := SplitMetadata(data)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	if len(data) < payloadHeaderSize || data[0] != payloadMagic {
+		return JSONCodec{}, 1, data, nil
+	}
+
+	codec, ok := codecByID(data[1])
+	if !ok {
+		return nil, 0, nil, fmt.Errorf("%w: id %d", ErrUnknownCodec, data[1])
+	}
+	return codec, binary.BigEndian.Uint16(data[2:4]), data[payloadHeaderSize:], nil
+}
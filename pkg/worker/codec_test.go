@@ -0,0 +1,53 @@
+package worker
+
+import (
+	"errors"
+	"testing"
+)
+
+type upgradingPayload struct {
+	Name     string `json:"name"`
+	Priority int    `json:"priority"`
+	upgraded uint16
+}
+
+func (p upgradingPayload) PayloadVersion() uint16 { return 2 }
+
+func (p *upgradingPayload) UpgradeFrom(version uint16) error {
+	p.upgraded = version
+	if p.Priority == 0 {
+		p.Priority = 5
+	}
+	return nil
+}
+
+func TestDecodePayload_Upgrades(t *testing.T) {
+	data := []byte{payloadMagic, (JSONCodec{}).ID(), 0, 1}
+	data = append(data, `{"name":"old"}`...)
+
+	var payload upgradingPayload
+	version, err := DecodePayload(data, &payload)
+	if err != nil {
+		t.Fatalf("DecodePayload failed: %v", err)
+	}
+	if version != 1 || payload.upgraded != 1 {
+		t.Errorf("Expected upgrade from version 1, got version %d upgraded %d", version, payload.upgraded)
+	}
+	if payload.Priority != 5 {
+		t.Errorf("Expected default priority after upgrade, got %d", payload.Priority)
+	}
+}
+
+func TestDecodePayload_UnknownCodec(t *testing.T) {
+	var payload upgradingPayload
+	_, err := DecodePayload([]byte{payloadMagic, 99, 0, 1}, &payload)
+	if !errors.Is(err, ErrUnknownCodec) {
+		t.Errorf("Expected ErrUnknownCodec, got %v", err)
+	}
+}
+
+func TestSetPayloadCodec_Unknown(t *testing.T) {
+	if err := SetPayloadCodec("xml"); !errors.Is(err, ErrUnknownCodec) {
+		t.Errorf("Expected ErrUnknownCodec, got %v", err)
+	}
+}
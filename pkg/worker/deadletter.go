@@ -0,0 +1,195 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hibiken/asynq"
+	"github.com/pixperk/goiler/pkg/redact"
+	"github.com/redis/go-redis/v9"
+)
+
+var ErrDeadTaskNotFound = errors.New("dead task not found")
+
+// annotationKeyPrefix prefixes the Redis hashes holding failure annotations, one per queue
+const annotationKeyPrefix = "goiler:deadletter:annotations:"
+
+// defaultPreviewBytes caps the payload preview returned for a dead task
+const defaultPreviewBytes = 2048
+
+// Annotation is an operator note attached to a dead task
+type Annotation struct {
+	Note      string    `json:"note"`
+	Author    string    `json:"author"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// DeadTask is an archived task that exhausted its retries
+type DeadTask struct {
+	ID               string          `json:"id"`
+	Queue            string          `json:"queue"`
+	Type             string          `json:"type"`
+	Payload          json.RawMessage `json:"payload"`
+	PayloadTruncated bool            `json:"payload_truncated,omitempty"`
+	Retried          int             `json:"retried"`
+	MaxRetry         int             `json:"max_retry"`
+	LastError        string          `json:"last_error"`
+	LastFailedAt     time.Time       `json:"last_failed_at"`
+	Annotation       *Annotation     `json:"annotation,omitempty"`
+}
+
+// DeadLetterQueue inspects and manages archived (dead) tasks
+type DeadLetterQueue struct {
+	inspector    *asynq.Inspector
+	redis        redis.UniversalClient
+	previewBytes int
+}
+
+// NewDeadLetterQueue creates a dead-letter queue manager on rdb, which
+// also stores task annotations. The caller owns rdb and closes it.
+func NewDeadLetterQueue(rdb redis.UniversalClient) *DeadLetterQueue {
+	return &DeadLetterQueue{
+		inspector:    asynq.NewInspectorFromRedisClient(rdb),
+		redis:        rdb,
+		previewBytes: defaultPreviewBytes,
+	}
+}
+
+// List returns a page of dead tasks in a queue along with the total count
+func (d *DeadLetterQueue) List(ctx context.Context, queue string, page, perPage int) ([]*DeadTask, int, error) {
+	info, err := d.inspector.GetQueueInfo(queue)
+	if err != nil {
+		if errors.Is(err, asynq.ErrQueueNotFound) {
+			return []*DeadTask{}, 0, nil
+		}
+		return nil, 0, err
+	}
+
+	infos, err := d.inspector.ListArchivedTasks(queue, asynq.Page(page), asynq.PageSize(perPage))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	annotations, err := d.redis.HGetAll(ctx, annotationKeyPrefix+queue).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	tasks := make([]*DeadTask, len(infos))
+	for i, info := range infos {
+		tasks[i] = d.newDeadTask(info)
+		if raw, ok := annotations[info.ID]; ok {
+			var annotation Annotation
+			if json.Unmarshal([]byte(raw), &annotation) == nil {
+				tasks[i].Annotation = &annotation
+			}
+		}
+	}
+
+	return tasks, info.Archived, nil
+}
+
+// Retry moves a dead task back to pending so it runs again
+func (d *DeadLetterQueue) Retry(ctx context.Context, queue, id string) error {
+	if err := d.ensureArchived(queue, id); err != nil {
+		return err
+	}
+	if err := d.inspector.RunTask(queue, id); err != nil {
+		return err
+	}
+	return d.redis.HDel(ctx, annotationKeyPrefix+queue, id).Err()
+}
+
+// Delete permanently removes a dead task
+func (d *DeadLetterQueue) Delete(ctx context.Context, queue, id string) error {
+	if err := d.ensureArchived(queue, id); err != nil {
+		return err
+	}
+	if err := d.inspector.DeleteTask(queue, id); err != nil {
+		return err
+	}
+	return d.redis.HDel(ctx, annotationKeyPrefix+queue, id).Err()
+}
+
+// Annotate attaches an operator note to a dead task, replacing any previous note
+func (d *DeadLetterQueue) Annotate(ctx context.Context, queue, id, author, note string) (*Annotation, error) {
+	if err := d.ensureArchived(queue, id); err != nil {
+		return nil, err
+	}
+
+	annotation := &Annotation{
+		Note:      note,
+		Author:    author,
+		UpdatedAt: time.Now(),
+	}
+	data, err := json.Marshal(annotation)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := d.redis.HSet(ctx, annotationKeyPrefix+queue, id, data).Err(); err != nil {
+		return nil, err
+	}
+	return annotation, nil
+}
+
+// ensureArchived makes sure a task exists and is archived, so live tasks
+// can't be retried or deleted through the dead-letter API
+func (d *DeadLetterQueue) ensureArchived(queue, id string) error {
+	info, err := d.inspector.GetTaskInfo(queue, id)
+	if err != nil {
+		if errors.Is(err, asynq.ErrQueueNotFound) || errors.Is(err, asynq.ErrTaskNotFound) {
+			return ErrDeadTaskNotFound
+		}
+		return err
+	}
+	if info.State != asynq.TaskStateArchived {
+		return ErrDeadTaskNotFound
+	}
+	return nil
+}
+
+// newDeadTask converts task info into a DeadTask with a redacted payload preview
+func (d *DeadLetterQueue) newDeadTask(info *asynq.TaskInfo) *DeadTask {
+	preview, truncated := PreviewPayload(info.Payload, d.previewBytes)
+	return &DeadTask{
+		ID:               info.ID,
+		Queue:            info.Queue,
+		Type:             info.Type,
+		Payload:          preview,
+		PayloadTruncated: truncated,
+		Retried:          info.Retried,
+		MaxRetry:         info.MaxRetry,
+		LastError:        info.LastErr,
+		LastFailedAt:     info.LastFailedAt,
+	}
+}
+
+// PreviewPayload returns a JSON preview of a task payload with sensitive
+// fields redacted. Previews longer than maxBytes are cut short and returned
+// as a JSON string. Non-JSON payloads are summarized by size.
+func PreviewPayload(payload []byte, maxBytes int) (json.RawMessage, bool) {
+	if codec, _, body, err := splitPayload(payload); err == nil && codec.ID() == (JSONCodec{}).ID() {
+		payload = body
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(payload, &value); err != nil {
+		summary, _ := json.Marshal(fmt.Sprintf("[%d bytes, not JSON]", len(payload)))
+		return summary, false
+	}
+
+	redacted, err := json.Marshal(redact.Value(value, redact.DefaultKeys))
+	if err != nil {
+		return nil, false
+	}
+	if maxBytes <= 0 || len(redacted) <= maxBytes {
+		return redacted, false
+	}
+
+	truncated, _ := json.Marshal(string(redacted[:maxBytes]))
+	return truncated, true
+}
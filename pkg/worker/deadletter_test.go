@@ -0,0 +1,54 @@
+package worker
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestPreviewPayload_Redacts(t *testing.T) {
+	payload, _ := json.Marshal(map[string]string{
+		"user_id":     "user-1",
+		"email":       "test@example.com",
+		"reset_token": "super-secret-token",
+	})
+
+	preview, truncated := PreviewPayload(payload, 0)
+	if truncated {
+		t.Fatal("Preview should not be truncated")
+	}
+	if strings.Contains(string(preview), "super-secret-token") {
+		t.Errorf("Preview leaks sensitive value: %s", preview)
+	}
+	if !strings.Contains(string(preview), "test@example.com") {
+		t.Errorf("Preview should keep non-sensitive fields: %s", preview)
+	}
+}
+
+func TestPreviewPayload_Truncates(t *testing.T) {
+	payload, _ := json.Marshal(map[string]string{"body": strings.Repeat("x", 500)})
+
+	preview, truncated := PreviewPayload(payload, 100)
+	if !truncated {
+		t.Fatal("Preview should be truncated")
+	}
+	if !json.Valid(preview) {
+		t.Errorf("Truncated preview should still be valid JSON: %s", preview)
+	}
+}
+
+func TestPreviewPayload_NotJSON(t *testing.T) {
+	preview, _ := PreviewPayload([]byte{0x08, 0x96, 0x01}, 100)
+	if string(preview) != `"[3 bytes, not JSON]"` {
+		t.Errorf("Unexpected preview for binary payload: %s", preview)
+	}
+}
+
+func TestPreviewPayload_Encoded(t *testing.T) {
+	payload, _ := EncodePayload(map[string]string{"email": "test@example.com", "reset_token": "secret"})
+
+	preview, _ := PreviewPayload(payload, 0)
+	if !strings.Contains(string(preview), "test@example.com") || strings.Contains(string(preview), `"secret"`) {
+		t.Errorf("Unexpected preview for encoded payload: %s", preview)
+	}
+}
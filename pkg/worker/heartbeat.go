@@ -0,0 +1,332 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/hibiken/asynq"
+	"github.com/pixperk/goiler/pkg/clock"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// heartbeatKeyPrefix prefixes the Redis hashes holding task heartbeats, one per queue
+const heartbeatKeyPrefix = "goiler:heartbeats:"
+
+// HeartbeatRecord is the liveness state of a running task
+type HeartbeatRecord struct {
+	TaskID    string    `json:"task_id"`
+	Queue     string    `json:"queue"`
+	Type      string    `json:"type"`
+	Progress  float64   `json:"progress"`
+	StartedAt time.Time `json:"started_at"`
+	BeatAt    time.Time `json:"beat_at"`
+}
+
+// HeartbeatStore records task heartbeats in Redis
+type HeartbeatStore struct {
+	redis    redis.UniversalClient
+	interval time.Duration
+	clock    clock.Clock
+}
+
+// NewHeartbeatStore creates a new heartbeat store. Heartbeats recorded by
+// Progress are written at most every interval while a task runs.
+func NewHeartbeatStore(rdb redis.UniversalClient, interval time.Duration) *HeartbeatStore {
+	return &HeartbeatStore{redis: rdb, interval: interval, clock: clock.New()}
+}
+
+// SetClock sets the clock used to time heartbeats
+func (s *HeartbeatStore) SetClock(c clock.Clock) {
+	s.clock = c
+}
+
+// Heartbeat keeps a running task's liveness up to date until stopped. The
+// task only counts as alive while its handler calls Progress: a handler
+// wedged between calls stops beating and is found by the Janitor, even
+// though its process is still up.
+type Heartbeat struct {
+	store  *HeartbeatStore
+	mu     sync.Mutex
+	record HeartbeatRecord
+	dirty  bool
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// Start begins heartbeating for the task being processed in ctx. It returns
+// a no-op heartbeat when heartbeats are disabled or ctx isn't a task
+// context, so handlers can always defer Stop.
+func (s *HeartbeatStore) Start(ctx context.Context, taskType string) *Heartbeat {
+	id, okID := asynq.GetTaskID(ctx)
+	queue, okQueue := asynq.GetQueueName(ctx)
+	if s == nil || s.interval <= 0 || !okID || !okQueue {
+		return &Heartbeat{}
+	}
+
+	now := s.clock.Now()
+	hb := &Heartbeat{
+		store: s,
+		record: HeartbeatRecord{
+			TaskID:    id,
+			Queue:     queue,
+			Type:      taskType,
+			StartedAt: now,
+			BeatAt:    now,
+		},
+		dirty: true,
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+
+	// Detached from ctx so the final Stop can still clean up after cancellation
+	beatCtx := context.WithoutCancel(ctx)
+	hb.beat(beatCtx)
+	go func() {
+		defer close(hb.done)
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				hb.beat(beatCtx)
+			case <-hb.stop:
+				s.redis.HDel(beatCtx, heartbeatKeyPrefix+queue, id)
+				return
+			}
+		}
+	}()
+
+	return hb
+}
+
+// Progress records a beat and how far the task has got, from 0 to 1. It
+// is written to Redis within the heartbeat interval. Handlers call it
+// between steps, more often than the orphan timeout.
+func (h *Heartbeat) Progress(progress float64) {
+	if h.store == nil {
+		return
+	}
+	h.mu.Lock()
+	h.record.Progress = progress
+	h.record.BeatAt = h.store.clock.Now()
+	h.dirty = true
+	h.mu.Unlock()
+}
+
+// Stop ends heartbeating and removes the task's heartbeat
+func (h *Heartbeat) Stop() {
+	if h.store == nil {
+		return
+	}
+	close(h.stop)
+	<-h.done
+}
+
+// beat writes the record if Progress changed it since the last write
+func (h *Heartbeat) beat(ctx context.Context) {
+	data, ok := h.pending()
+	if !ok {
+		return
+	}
+	h.store.redis.HSet(ctx, heartbeatKeyPrefix+h.record.Queue, h.record.TaskID, data)
+}
+
+// pending returns the encoded record when it has changed since it was last
+// returned
+func (h *Heartbeat) pending() ([]byte, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.dirty {
+		return nil, false
+	}
+	data, err := json.Marshal(h.record)
+	if err != nil {
+		return nil, false
+	}
+	h.dirty = false
+	return data, true
+}
+
+// Stale returns the heartbeats in queue that haven't beaten since before cutoff
+func (s *HeartbeatStore) Stale(ctx context.Context, queue string, cutoff time.Time) ([]HeartbeatRecord, error) {
+	entries, err := s.redis.HGetAll(ctx, heartbeatKeyPrefix+queue).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var stale []HeartbeatRecord
+	for id, raw := range entries {
+		var record HeartbeatRecord
+		if json.Unmarshal([]byte(raw), &record) != nil {
+			record = HeartbeatRecord{TaskID: id, Queue: queue}
+		}
+		if record.BeatAt.Before(cutoff) {
+			stale = append(stale, record)
+		}
+	}
+	return stale, nil
+}
+
+// Remove deletes a task's heartbeat
+func (s *HeartbeatStore) Remove(ctx context.Context, queue, id string) error {
+	return s.redis.HDel(ctx, heartbeatKeyPrefix+queue, id).Err()
+}
+
+// taskInspector is the part of the asynq inspector the janitor uses;
+// *asynq.Inspector satisfies it
+type taskInspector interface {
+	GetTaskInfo(queue, id string) (*asynq.TaskInfo, error)
+	Servers() ([]*asynq.ServerInfo, error)
+	CancelProcessing(id string) error
+}
+
+// heartbeatSource finds and clears stale heartbeats; *HeartbeatStore
+// satisfies it
+type heartbeatSource interface {
+	Stale(ctx context.Context, queue string, cutoff time.Time) ([]HeartbeatRecord, error)
+	Remove(ctx context.Context, queue, id string) error
+}
+
+// Janitor finds tasks whose heartbeat stopped while they were still active.
+// Cancelling an orphan makes a wedged handler return, so asynq retries it or
+// archives it once retries are exhausted. Cancellation is delivered to the
+// worker processing the task, so tasks of a worker that died aren't
+// cancelled: asynq recovers them when their lease expires, and the janitor
+// only clears the leftover heartbeat.
+type Janitor struct {
+	inspector  taskInspector
+	heartbeats heartbeatSource
+	queues     []string
+	timeout    time.Duration
+	logger     *slog.Logger
+	orphaned   metric.Int64Counter
+	clock      clock.Clock
+}
+
+// NewJanitor creates a janitor that treats tasks in queues as orphaned after
+// timeout without a heartbeat
+func NewJanitor(inspector *asynq.Inspector, heartbeats *HeartbeatStore, queues []string, timeout time.Duration, logger *slog.Logger) *Janitor {
+	orphaned, _ := otel.Meter("goiler/worker").Int64Counter(
+		"worker_tasks_orphaned_total",
+		metric.WithDescription("Total number of active tasks found without a recent heartbeat"),
+		metric.WithUnit("1"),
+	)
+
+	return &Janitor{
+		inspector:  inspector,
+		heartbeats: heartbeats,
+		queues:     queues,
+		timeout:    timeout,
+		logger:     logger,
+		orphaned:   orphaned,
+		clock:      clock.New(),
+	}
+}
+
+// SetClock sets the clock used to find stale heartbeats
+func (j *Janitor) SetClock(c clock.Clock) {
+	j.clock = c
+}
+
+// Run sweeps every half timeout until ctx is done. A zero timeout disables
+// the janitor.
+func (j *Janitor) Run(ctx context.Context) {
+	if j.timeout <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(j.timeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := j.Sweep(ctx); err != nil {
+				j.logger.WarnContext(ctx, "orphan sweep failed", slog.String("error", err.Error()))
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Sweep handles stale heartbeats in every queue and returns the number of
+// orphaned tasks it cancelled
+func (j *Janitor) Sweep(ctx context.Context) (int, error) {
+	cutoff := j.clock.Now().Add(-j.timeout)
+	cancelled := 0
+
+	// Read lazily: most sweeps find nothing stale
+	var running map[string]bool
+
+	for _, queue := range j.queues {
+		stale, err := j.heartbeats.Stale(ctx, queue, cutoff)
+		if err != nil {
+			return cancelled, err
+		}
+
+		for _, record := range stale {
+			info, err := j.inspector.GetTaskInfo(queue, record.TaskID)
+			if err != nil && !errors.Is(err, asynq.ErrTaskNotFound) && !errors.Is(err, asynq.ErrQueueNotFound) {
+				return cancelled, err
+			}
+
+			active := info != nil && info.State == asynq.TaskStateActive
+			if active && running == nil {
+				if running, err = j.runningTasks(); err != nil {
+					return cancelled, err
+				}
+			}
+
+			switch {
+			case active && !running[record.TaskID]:
+				j.logger.InfoContext(ctx, "leaving task of a dead worker to lease recovery",
+					slog.String("id", record.TaskID),
+					slog.String("queue", queue),
+					slog.String("type", info.Type),
+					slog.Time("last_heartbeat", record.BeatAt),
+				)
+			case active:
+				j.logger.WarnContext(ctx, "cancelling orphaned task",
+					slog.String("id", record.TaskID),
+					slog.String("queue", queue),
+					slog.String("type", info.Type),
+					slog.Time("last_heartbeat", record.BeatAt),
+				)
+				if err := j.inspector.CancelProcessing(record.TaskID); err != nil {
+					return cancelled, err
+				}
+				j.orphaned.Add(ctx, 1, metric.WithAttributes(attribute.String("type", info.Type)))
+				cancelled++
+			}
+
+			if err := j.heartbeats.Remove(ctx, queue, record.TaskID); err != nil {
+				return cancelled, err
+			}
+		}
+	}
+
+	return cancelled, nil
+}
+
+// runningTasks returns the IDs of the tasks being processed by live workers
+func (j *Janitor) runningTasks() (map[string]bool, error) {
+	servers, err := j.inspector.Servers()
+	if err != nil {
+		return nil, err
+	}
+	running := make(map[string]bool)
+	for _, server := range servers {
+		for _, w := range server.ActiveWorkers {
+			running[w.TaskID] = true
+		}
+	}
+	return running, nil
+}
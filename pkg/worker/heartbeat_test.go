@@ -0,0 +1,154 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hibiken/asynq"
+	"github.com/pixperk/goiler/internal/testutil"
+	"github.com/pixperk/goiler/pkg/clock"
+)
+
+func TestHeartbeat_BeatsOnProgress(t *testing.T) {
+	start := time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+	frozen := clock.NewFrozen(start)
+	store := &HeartbeatStore{interval: time.Second, clock: frozen}
+	hb := &Heartbeat{
+		store:  store,
+		record: HeartbeatRecord{TaskID: "task-1", Queue: "default", StartedAt: start, BeatAt: start},
+		dirty:  true,
+	}
+
+	if _, ok := hb.pending(); !ok {
+		t.Fatal("Expected the first beat to be written")
+	}
+
+	// A handler that stops reporting progress stops beating, however long
+	// its process keeps running
+	frozen.Advance(time.Minute)
+	if _, ok := hb.pending(); ok {
+		t.Fatal("Expected no beat without progress")
+	}
+
+	frozen.Advance(time.Minute)
+	hb.Progress(0.5)
+	data, ok := hb.pending()
+	if !ok {
+		t.Fatal("Expected progress to be written with the next beat")
+	}
+	var record HeartbeatRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		t.Fatal(err)
+	}
+	if !record.BeatAt.Equal(start.Add(2*time.Minute)) || record.Progress != 0.5 {
+		t.Errorf("Expected a beat at the progress call, got %+v", record)
+	}
+	if _, ok := hb.pending(); ok {
+		t.Error("Expected a beat to be written once")
+	}
+
+	var disabled Heartbeat
+	disabled.Progress(1)
+	disabled.Stop()
+}
+
+// fakeHeartbeats serves fixed stale heartbeats and records removals
+type fakeHeartbeats struct {
+	stale   []HeartbeatRecord
+	removed []string
+}
+
+func (f *fakeHeartbeats) Stale(ctx context.Context, queue string, cutoff time.Time) ([]HeartbeatRecord, error) {
+	var stale []HeartbeatRecord
+	for _, record := range f.stale {
+		if record.Queue == queue && record.BeatAt.Before(cutoff) {
+			stale = append(stale, record)
+		}
+	}
+	return stale, nil
+}
+
+func (f *fakeHeartbeats) Remove(ctx context.Context, queue, id string) error {
+	f.removed = append(f.removed, id)
+	return nil
+}
+
+// fakeInspector reports task states and live workers, and records
+// cancellations
+type fakeInspector struct {
+	tasks     map[string]*asynq.TaskInfo
+	running   []string
+	cancelled []string
+}
+
+func (f *fakeInspector) GetTaskInfo(queue, id string) (*asynq.TaskInfo, error) {
+	if info, ok := f.tasks[id]; ok {
+		return info, nil
+	}
+	return nil, asynq.ErrTaskNotFound
+}
+
+func (f *fakeInspector) Servers() ([]*asynq.ServerInfo, error) {
+	server := &asynq.ServerInfo{ID: "server-1"}
+	for _, id := range f.running {
+		server.ActiveWorkers = append(server.ActiveWorkers, &asynq.WorkerInfo{TaskID: id})
+	}
+	return []*asynq.ServerInfo{server}, nil
+}
+
+func (f *fakeInspector) CancelProcessing(id string) error {
+	f.cancelled = append(f.cancelled, id)
+	return nil
+}
+
+func TestJanitor_Sweep(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+	old := now.Add(-5 * time.Minute)
+	active := func(id string) *asynq.TaskInfo {
+		return &asynq.TaskInfo{ID: id, Queue: "default", Type: "report:generate", State: asynq.TaskStateActive}
+	}
+
+	heartbeats := &fakeHeartbeats{stale: []HeartbeatRecord{
+		{TaskID: "wedged", Queue: "default", BeatAt: old},
+		{TaskID: "dead-worker", Queue: "default", BeatAt: old},
+		{TaskID: "finished", Queue: "default", BeatAt: old},
+		{TaskID: "retrying", Queue: "default", BeatAt: old},
+		{TaskID: "beating", Queue: "default", BeatAt: now.Add(-time.Second)},
+	}}
+	inspector := &fakeInspector{
+		tasks: map[string]*asynq.TaskInfo{
+			"wedged":      active("wedged"),
+			"dead-worker": active("dead-worker"),
+			"retrying":    {ID: "retrying", Queue: "default", State: asynq.TaskStateRetry},
+			"beating":     active("beating"),
+		},
+		running: []string{"wedged", "beating"},
+	}
+
+	janitor := NewJanitor(nil, nil, []string{"default"}, time.Minute, testutil.Logger())
+	janitor.inspector = inspector
+	janitor.heartbeats = heartbeats
+	janitor.SetClock(clock.NewFrozen(now))
+
+	cancelled, err := janitor.Sweep(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the orphan of a live worker is cancelled; the dead worker's task
+	// is left to asynq lease recovery
+	if cancelled != 1 || len(inspector.cancelled) != 1 || inspector.cancelled[0] != "wedged" {
+		t.Errorf("Expected only the wedged task to be cancelled, got %d %v", cancelled, inspector.cancelled)
+	}
+	want := map[string]bool{"wedged": true, "dead-worker": true, "finished": true, "retrying": true}
+	if len(heartbeats.removed) != len(want) {
+		t.Fatalf("Expected every stale heartbeat to be removed, got %v", heartbeats.removed)
+	}
+	for _, id := range heartbeats.removed {
+		if !want[id] {
+			t.Errorf("Unexpected removal of %s", id)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/hibiken/asynq"
+	"github.com/pixperk/goiler/pkg/requestid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ParsePayload is a helper to parse task payloads. Payloads written with
+// an older schema version are upgraded if the type implements Upgrader.
+func ParsePayload[T any](task *asynq.Task) (*T, error) {
+	var payload T
+	if _, err := DecodePayload(task.Payload(), &payload); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal payload: %w", err)
+	}
+	return &payload, nil
+}
+
+// LogTaskStart logs task start
+func LogTaskStart(ctx context.Context, logger *slog.Logger, taskType string) {
+	logger.InfoContext(ctx, "starting task", taskAttrs(ctx, taskType)...)
+}
+
+// LogTaskComplete logs task completion
+func LogTaskComplete(ctx context.Context, logger *slog.Logger, taskType string, duration time.Duration) {
+	logger.InfoContext(ctx, "task completed",
+		append(taskAttrs(ctx, taskType), slog.Duration("duration", duration))...,
+	)
+}
+
+// LogTaskError logs task error
+func LogTaskError(ctx context.Context, logger *slog.Logger, taskType string, err error) {
+	logger.ErrorContext(ctx, "task failed",
+		append(taskAttrs(ctx, taskType), slog.String("error", err.Error()))...,
+	)
+}
+
+// taskAttrs returns the log attributes identifying a task and, when it was
+// enqueued by a request, the request and trace it belongs to
+func taskAttrs(ctx context.Context, taskType string) []any {
+	attrs := []any{slog.String("type", taskType)}
+	if id := requestid.FromContext(ctx); id != "" {
+		attrs = append(attrs, slog.String("request_id", id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		attrs = append(attrs, slog.String("trace_id", sc.TraceID().String()))
+	}
+	return attrs
+}
@@ -0,0 +1,61 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/hibiken/asynq"
+	"github.com/pixperk/goiler/pkg/events"
+)
+
+// Progress reports a task's progress and outcome to the user waiting on
+// it, as task.progress events. A nil publisher or an empty user ID makes
+// it a no-op, so handlers can always report.
+type Progress struct {
+	publisher events.Publisher
+	event     events.TaskProgressEvent
+}
+
+// NewProgress creates a progress reporter for the task being processed in
+// ctx, on behalf of userID
+func NewProgress(ctx context.Context, publisher events.Publisher, taskType, userID string) *Progress {
+	id, _ := asynq.GetTaskID(ctx)
+	return &Progress{
+		publisher: publisher,
+		event: events.TaskProgressEvent{
+			TaskID:   id,
+			TaskType: taskType,
+			UserID:   userID,
+		},
+	}
+}
+
+// Update reports how far the task has got, from 0 to 1, and what it's doing
+func (p *Progress) Update(progress float64, message string) {
+	p.publish(events.TaskRunning, progress, message, nil, "")
+}
+
+// Complete reports that the task finished, with what it produced
+func (p *Progress) Complete(message string, result map[string]any) {
+	p.publish(events.TaskCompleted, 1, message, result, "")
+}
+
+// Fail reports that the task gave up. message is shown to the user, so it
+// must not carry internal details such as error strings; log the error
+// itself instead.
+func (p *Progress) Fail(message string) {
+	p.publish(events.TaskFailed, p.event.Progress, "", nil, message)
+}
+
+func (p *Progress) publish(status string, progress float64, message string, result map[string]any, errMsg string) {
+	if p.publisher == nil || p.event.UserID == "" {
+		return
+	}
+	p.event.Status = status
+	p.event.Progress = min(max(progress, 0), 1)
+	p.event.Message = message
+	p.event.Result = result
+	p.event.Error = errMsg
+	p.event.Time = time.Now()
+	events.TaskProgress.Publish(p.publisher, p.event)
+}
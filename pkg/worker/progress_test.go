@@ -0,0 +1,53 @@
+package worker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pixperk/goiler/pkg/events"
+)
+
+// recordingPublisher keeps the payloads published to it
+type recordingPublisher struct {
+	payloads []any
+}
+
+func (p *recordingPublisher) Publish(topic string, payload interface{}) int {
+	p.payloads = append(p.payloads, payload)
+	return 1
+}
+
+func TestProgress(t *testing.T) {
+	pub := &recordingPublisher{}
+	progress := NewProgress(context.Background(), pub, "report:generate", "user-1")
+	progress.Update(0.4, "Querying")
+	progress.Update(1.5, "Rendering")
+	progress.Fail("Report could not be generated")
+	progress.Complete("Report ready", map[string]any{"report_id": "r-1"})
+
+	want := []events.TaskProgressEvent{
+		{Status: events.TaskRunning, Progress: 0.4, Message: "Querying"},
+		{Status: events.TaskRunning, Progress: 1, Message: "Rendering"},
+		{Status: events.TaskFailed, Progress: 1, Error: "Report could not be generated"},
+		{Status: events.TaskCompleted, Progress: 1, Message: "Report ready"},
+	}
+	if len(pub.payloads) != len(want) {
+		t.Fatalf("Expected %d events, got %d", len(want), len(pub.payloads))
+	}
+	for i, w := range want {
+		got, ok := events.TaskProgress.Payload(pub.payloads[i])
+		if !ok {
+			t.Fatalf("Event %d: unexpected payload %T", i, pub.payloads[i])
+		}
+		if got.UserID != "user-1" || got.TaskType != "report:generate" || got.Status != w.Status ||
+			got.Progress != w.Progress || got.Message != w.Message || got.Error != w.Error {
+			t.Errorf("Event %d: expected %+v, got %+v", i, w, got)
+		}
+	}
+
+	NewProgress(context.Background(), pub, "report:generate", "").Update(0.5, "Anonymous")
+	NewProgress(context.Background(), nil, "report:generate", "user-1").Update(0.5, "Unpublished")
+	if len(pub.payloads) != len(want) {
+		t.Error("Expected no events without a user or publisher")
+	}
+}
@@ -0,0 +1,204 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/hibiken/asynq"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// QueueStats is a sample of a single queue
+type QueueStats struct {
+	Pending   int `json:"pending"`
+	Active    int `json:"active"`
+	Scheduled int `json:"scheduled"`
+	Retry     int `json:"retry"`
+	Archived  int `json:"archived"`
+	// Backlog is the work waiting for a worker: pending plus retry tasks
+	Backlog int `json:"backlog"`
+	// LatencySeconds is the age of the oldest pending task
+	LatencySeconds float64 `json:"latency_seconds"`
+	Paused         bool    `json:"paused"`
+}
+
+// ScalingSnapshot is the latest sample of all queues. Its flat totals are
+// meant for autoscalers such as the KEDA metrics-api scaler.
+type ScalingSnapshot struct {
+	SampledAt         time.Time             `json:"sampled_at"`
+	TotalBacklog      int                   `json:"total_backlog"`
+	TotalActive       int                   `json:"total_active"`
+	MaxLatencySeconds float64               `json:"max_latency_seconds"`
+	Queues            map[string]QueueStats `json:"queues"`
+}
+
+// ScalingMonitor periodically samples queue depth and latency and exports
+// them as metrics
+type ScalingMonitor struct {
+	inspector *asynq.Inspector
+	queues    []string
+	interval  time.Duration
+	logger    *slog.Logger
+
+	mu       sync.RWMutex
+	snapshot *ScalingSnapshot
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewScalingMonitor creates a monitor sampling queues every interval. The
+// monitor takes ownership of the inspector and closes it on Close.
+func NewScalingMonitor(inspector *asynq.Inspector, queues []string, interval time.Duration, logger *slog.Logger) *ScalingMonitor {
+	m := &ScalingMonitor{
+		inspector: inspector,
+		queues:    queues,
+		interval:  interval,
+		logger:    logger,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	m.initMetrics()
+
+	return m
+}
+
+// initMetrics registers queue gauges with the global meter provider. The
+// gauges report the latest sample rather than querying Redis on scrape.
+func (m *ScalingMonitor) initMetrics() {
+	meter := otel.Meter("goiler/worker")
+
+	meter.Int64ObservableGauge(
+		"worker_queue_depth",
+		metric.WithDescription("Number of tasks in a queue by state"),
+		metric.WithUnit("1"),
+		metric.WithInt64Callback(func(ctx context.Context, observer metric.Int64Observer) error {
+			snapshot := m.Snapshot()
+			if snapshot == nil {
+				return nil
+			}
+			for queue, stats := range snapshot.Queues {
+				q := attribute.String("queue", queue)
+				observer.Observe(int64(stats.Pending), metric.WithAttributes(q, attribute.String("state", "pending")))
+				observer.Observe(int64(stats.Active), metric.WithAttributes(q, attribute.String("state", "active")))
+				observer.Observe(int64(stats.Scheduled), metric.WithAttributes(q, attribute.String("state", "scheduled")))
+				observer.Observe(int64(stats.Retry), metric.WithAttributes(q, attribute.String("state", "retry")))
+				observer.Observe(int64(stats.Archived), metric.WithAttributes(q, attribute.String("state", "archived")))
+			}
+			return nil
+		}),
+	)
+
+	meter.Float64ObservableGauge(
+		"worker_queue_latency_seconds",
+		metric.WithDescription("Age of the oldest pending task in a queue"),
+		metric.WithUnit("s"),
+		metric.WithFloat64Callback(func(ctx context.Context, observer metric.Float64Observer) error {
+			snapshot := m.Snapshot()
+			if snapshot == nil {
+				return nil
+			}
+			for queue, stats := range snapshot.Queues {
+				observer.Observe(stats.LatencySeconds, metric.WithAttributes(attribute.String("queue", queue)))
+			}
+			return nil
+		}),
+	)
+}
+
+// Start samples the queues every interval until Close is called
+func (m *ScalingMonitor) Start() {
+	go func() {
+		defer close(m.done)
+
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+
+		m.sample()
+		for {
+			select {
+			case <-ticker.C:
+				m.sample()
+			case <-m.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops sampling and closes the inspector
+func (m *ScalingMonitor) Close() error {
+	close(m.stop)
+	<-m.done
+	return m.inspector.Close()
+}
+
+// Snapshot returns the latest sample, or nil before the first sample
+func (m *ScalingMonitor) Snapshot() *ScalingSnapshot {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.snapshot
+}
+
+// sample reads every queue and replaces the snapshot. Queues that fail to
+// load keep their previous values so a Redis hiccup doesn't read as an
+// empty backlog.
+func (m *ScalingMonitor) sample() {
+	previous := m.Snapshot()
+	snapshot := &ScalingSnapshot{
+		SampledAt: time.Now(),
+		Queues:    make(map[string]QueueStats, len(m.queues)),
+	}
+
+	for _, queue := range m.queues {
+		stats, err := m.sampleQueue(queue)
+		if err != nil {
+			m.logger.Warn("failed to sample queue",
+				slog.String("queue", queue),
+				slog.String("error", err.Error()),
+			)
+			if previous == nil {
+				continue
+			}
+			stats = previous.Queues[queue]
+		}
+
+		snapshot.Queues[queue] = stats
+		snapshot.TotalBacklog += stats.Backlog
+		snapshot.TotalActive += stats.Active
+		if stats.LatencySeconds > snapshot.MaxLatencySeconds {
+			snapshot.MaxLatencySeconds = stats.LatencySeconds
+		}
+	}
+
+	m.mu.Lock()
+	m.snapshot = snapshot
+	m.mu.Unlock()
+}
+
+func (m *ScalingMonitor) sampleQueue(queue string) (QueueStats, error) {
+	info, err := m.inspector.GetQueueInfo(queue)
+	if err != nil {
+		// Queues are created on first enqueue
+		if errors.Is(err, asynq.ErrQueueNotFound) {
+			return QueueStats{}, nil
+		}
+		return QueueStats{}, err
+	}
+
+	return QueueStats{
+		Pending:        info.Pending,
+		Active:         info.Active,
+		Scheduled:      info.Scheduled,
+		Retry:          info.Retry,
+		Archived:       info.Archived,
+		Backlog:        info.Pending + info.Retry,
+		LatencySeconds: info.Latency.Seconds(),
+		Paused:         info.Paused,
+	}, nil
+}
@@ -0,0 +1,209 @@
+package worker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hibiken/asynq"
+)
+
+var ErrScheduleNotFound = errors.New("schedule not found")
+
+// Schedule is a task enqueued periodically. Spec is a cron expression such
+// as "0 3 * * *", or a descriptor such as "@daily" or "@every 30m",
+// evaluated in UTC.
+type Schedule struct {
+	Name    string
+	Spec    string
+	Task    *asynq.Task
+	Options []asynq.Option
+}
+
+// Scheduler enqueues tasks on their schedules, with an asynq scheduler
+// that registers its entries in Redis for ScheduleList
+type Scheduler struct {
+	scheduler *asynq.Scheduler
+	logger    *slog.Logger
+
+	mu        sync.Mutex
+	schedules map[string]scheduled
+}
+
+// scheduled is a registered schedule with its asynq entry ID
+type scheduled struct {
+	Schedule
+	id string
+}
+
+// NewScheduler creates a scheduler with its own Redis connection, closed on
+// Shutdown. opts configures the asynq scheduler; enqueue failures are
+// logged unless opts sets PostEnqueueFunc.
+func NewScheduler(redisOpt asynq.RedisConnOpt, opts asynq.SchedulerOpts, logger *slog.Logger) *Scheduler {
+	if opts.PostEnqueueFunc == nil {
+		opts.PostEnqueueFunc = func(info *asynq.TaskInfo, err error) {
+			if err != nil {
+				logger.Error("failed to enqueue scheduled task", slog.String("error", err.Error()))
+				return
+			}
+			logger.Debug("enqueued scheduled task",
+				slog.String("task_id", info.ID),
+				slog.String("type", info.Type),
+				slog.String("queue", info.Queue),
+			)
+		}
+	}
+	return &Scheduler{
+		scheduler: asynq.NewScheduler(redisOpt, &opts),
+		logger:    logger,
+		schedules: make(map[string]scheduled),
+	}
+}
+
+// Register adds a schedule. Names are unique, and invalid specs are
+// rejected.
+func (s *Scheduler) Register(schedule Schedule) error {
+	if schedule.Name == "" {
+		return errors.New("schedule name is required")
+	}
+	if schedule.Task == nil {
+		return fmt.Errorf("schedule %s has no task", schedule.Name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.schedules[schedule.Name]; ok {
+		return fmt.Errorf("schedule %s is already registered", schedule.Name)
+	}
+	id, err := s.scheduler.Register(schedule.Spec, schedule.Task, schedule.Options...)
+	if err != nil {
+		return fmt.Errorf("invalid spec %q for schedule %s: %w", schedule.Spec, schedule.Name, err)
+	}
+	s.schedules[schedule.Name] = scheduled{Schedule: schedule, id: id}
+
+	s.logger.Info("registered schedule",
+		slog.String("name", schedule.Name),
+		slog.String("spec", schedule.Spec),
+		slog.String("type", schedule.Task.Type()),
+	)
+	return nil
+}
+
+// Unregister removes a schedule by name
+func (s *Scheduler) Unregister(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.schedules[name]
+	if !ok {
+		return ErrScheduleNotFound
+	}
+	if err := s.scheduler.Unregister(entry.id); err != nil {
+		return err
+	}
+	delete(s.schedules, name)
+	return nil
+}
+
+// Schedules returns the registered schedules sorted by name
+func (s *Scheduler) Schedules() []Schedule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	schedules := make([]Schedule, 0, len(s.schedules))
+	for _, entry := range s.schedules {
+		schedules = append(schedules, entry.Schedule)
+	}
+	slices.SortFunc(schedules, func(a, b Schedule) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return schedules
+}
+
+// Start starts enqueuing tasks in the background
+func (s *Scheduler) Start() error {
+	return s.scheduler.Start()
+}
+
+// Shutdown stops enqueuing tasks, removes the scheduler's entries from
+// Redis and closes its connection
+func (s *Scheduler) Shutdown() {
+	s.scheduler.Shutdown()
+}
+
+// ScheduleEntry is a schedule registered by a running scheduler, in any
+// process
+type ScheduleEntry struct {
+	ID      string          `json:"id"`
+	Spec    string          `json:"spec"`
+	Type    string          `json:"type"`
+	Queue   string          `json:"queue"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	Options []string        `json:"options,omitempty"`
+	Next    time.Time       `json:"next"`
+	Prev    *time.Time      `json:"prev,omitempty"`
+}
+
+// ScheduleList lists the schedules of the schedulers running against a
+// Redis instance
+type ScheduleList struct {
+	inspector *asynq.Inspector
+}
+
+// NewScheduleList creates a schedule list reading from inspector, which it
+// closes on Close
+func NewScheduleList(inspector *asynq.Inspector) *ScheduleList {
+	return &ScheduleList{inspector: inspector}
+}
+
+// List returns the registered schedules, the next due first. Schedules
+// registered by several schedulers are listed once per scheduler.
+func (l *ScheduleList) List() ([]*ScheduleEntry, error) {
+	entries, err := l.inspector.SchedulerEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	schedules := make([]*ScheduleEntry, 0, len(entries))
+	for _, entry := range entries {
+		schedules = append(schedules, newScheduleEntry(entry))
+	}
+	slices.SortStableFunc(schedules, func(a, b *ScheduleEntry) int {
+		return a.Next.Compare(b.Next)
+	})
+	return schedules, nil
+}
+
+// Close closes the inspector
+func (l *ScheduleList) Close() error {
+	return l.inspector.Close()
+}
+
+// newScheduleEntry converts an asynq scheduler entry, with a redacted
+// payload preview
+func newScheduleEntry(entry *asynq.SchedulerEntry) *ScheduleEntry {
+	schedule := &ScheduleEntry{
+		ID:    entry.ID,
+		Spec:  entry.Spec,
+		Type:  entry.Task.Type(),
+		Queue: "default",
+		Next:  entry.Next,
+	}
+	if payload := entry.Task.Payload(); len(payload) > 0 {
+		schedule.Payload, _ = PreviewPayload(payload, defaultPreviewBytes)
+	}
+	for _, opt := range entry.Opts {
+		if opt.Type() == asynq.QueueOpt {
+			schedule.Queue, _ = opt.Value().(string)
+		}
+		schedule.Options = append(schedule.Options, opt.String())
+	}
+	if !entry.Prev.IsZero() {
+		prev := entry.Prev
+		schedule.Prev = &prev
+	}
+	return schedule
+}
@@ -0,0 +1,70 @@
+package worker
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hibiken/asynq"
+	"github.com/pixperk/goiler/internal/testutil"
+)
+
+func TestScheduler_Register(t *testing.T) {
+	logger := testutil.Logger()
+	scheduler := NewScheduler(asynq.RedisClientOpt{Addr: "localhost:6379"}, asynq.SchedulerOpts{}, logger)
+	task := asynq.NewTask("data:cleanup", nil)
+
+	if err := scheduler.Register(Schedule{Name: "nightly", Spec: "0 3 * * *", Task: task}); err != nil {
+		t.Fatalf("Failed to register schedule: %v", err)
+	}
+	if err := scheduler.Register(Schedule{Name: "frequent", Spec: "@every 30m", Task: task}); err != nil {
+		t.Fatalf("Failed to register schedule: %v", err)
+	}
+	if err := scheduler.Register(Schedule{Name: "nightly", Spec: "@daily", Task: task}); err == nil {
+		t.Error("Expected a duplicate name to be rejected")
+	}
+	if err := scheduler.Register(Schedule{Name: "broken", Spec: "every night", Task: task}); err == nil {
+		t.Error("Expected an invalid spec to be rejected")
+	}
+
+	schedules := scheduler.Schedules()
+	if len(schedules) != 2 || schedules[0].Name != "frequent" || schedules[1].Name != "nightly" {
+		t.Fatalf("Expected frequent and nightly schedules, got %+v", schedules)
+	}
+
+	if err := scheduler.Unregister("frequent"); err != nil {
+		t.Fatalf("Failed to unregister schedule: %v", err)
+	}
+	if err := scheduler.Unregister("frequent"); !errors.Is(err, ErrScheduleNotFound) {
+		t.Errorf("Expected ErrScheduleNotFound, got %v", err)
+	}
+	if n := len(scheduler.Schedules()); n != 1 {
+		t.Errorf("Expected 1 schedule left, got %d", n)
+	}
+}
+
+func TestNewScheduleEntry(t *testing.T) {
+	next := time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+	entry := newScheduleEntry(&asynq.SchedulerEntry{
+		ID:   "entry-1",
+		Spec: "0 3 * * *",
+		Task: asynq.NewTask("email:delivery", []byte(`{"to":"user@example.com","reset_token":"secret"}`)),
+		Opts: []asynq.Option{asynq.Queue("low"), asynq.MaxRetry(1)},
+		Next: next,
+	})
+
+	if entry.Type != "email:delivery" || entry.Queue != "low" || !entry.Next.Equal(next) || entry.Prev != nil {
+		t.Errorf("Unexpected entry: %+v", entry)
+	}
+	if len(entry.Options) != 2 {
+		t.Errorf("Expected 2 options, got %v", entry.Options)
+	}
+	if string(entry.Payload) == "" || string(entry.Payload) == `{"to":"user@example.com","reset_token":"secret"}` {
+		t.Errorf("Expected a redacted payload preview, got %s", entry.Payload)
+	}
+
+	bare := newScheduleEntry(&asynq.SchedulerEntry{Task: asynq.NewTask("auth:token_cleanup", nil), Prev: next})
+	if bare.Queue != "default" || bare.Payload != nil || bare.Prev == nil {
+		t.Errorf("Expected the default queue, no payload and the previous run, got %+v", bare)
+	}
+}
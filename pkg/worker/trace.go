@@ -0,0 +1,144 @@
+package worker
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/hibiken/asynq"
+	"github.com/pixperk/goiler/pkg/requestid"
+	"github.com/pixperk/goiler/pkg/sandbox"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Metadata travels with a task payload without being part of it, such as
+// the trace context and request ID of the request that enqueued the task
+type Metadata map[string]string
+
+// Metadata keys
+const (
+	// MetadataRequestID holds the originating request ID
+	MetadataRequestID = "request_id"
+	// MetadataSandbox is "true" for tasks enqueued in sandbox mode
+	MetadataSandbox = "sandbox"
+)
+
+// Payloads carrying metadata start with:
+//
+//	magic (0xA8) | metadata length (uint16, big endian) | metadata (JSON)
+//
+// followed by the encoded payload, header included
+const (
+	metadataMagic      = 0xA8
+	metadataHeaderSize = 3
+)
+
+// ContextMetadata returns the trace context, request ID and sandbox mode of
+// ctx, for attaching to the tasks it enqueues
+func ContextMetadata(ctx context.Context) Metadata {
+	md := Metadata{}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.MapCarrier(md))
+	if id := requestid.FromContext(ctx); id != "" {
+		md[MetadataRequestID] = id
+	}
+	if sandbox.Enabled(ctx) {
+		md[MetadataSandbox] = "true"
+	}
+	return md
+}
+
+// WithMetadata prefixes an encoded payload with metadata. Empty metadata
+// leaves the payload as is.
+func WithMetadata(payload []byte, md Metadata) ([]byte, error) {
+	if len(md) == 0 {
+		return payload, nil
+	}
+
+	encoded, err := json.Marshal(md)
+	if err != nil {
+		return nil, err
+	}
+	if len(encoded) > math.MaxUint16 {
+		return nil, fmt.Errorf("task metadata too large: %d bytes", len(encoded))
+	}
+
+	data := make([]byte, metadataHeaderSize, metadataHeaderSize+len(encoded)+len(payload))
+	data[0] = metadataMagic
+	binary.BigEndian.PutUint16(data[1:], uint16(len(encoded)))
+	data = append(data, encoded...)
+	return append(data, payload...), nil
+}
+
+// SplitMetadata separates the metadata from an encoded payload. Payloads
+// without metadata are returned unchanged with nil metadata.
+func SplitMetadata(data []byte) (Metadata, []byte, error) {
+	if len(data) < metadataHeaderSize || data[0] != metadataMagic {
+		return nil, data, nil
+	}
+
+	end := metadataHeaderSize + int(binary.BigEndian.Uint16(data[1:3]))
+	if end > len(data) {
+		return nil, nil, fmt.Errorf("task metadata truncated: want %d bytes, have %d", end, len(data))
+	}
+
+	var md Metadata
+	if err := json.Unmarshal(data[metadataHeaderSize:end], &md); err != nil {
+		return nil, nil, fmt.Errorf("invalid task metadata: %w", err)
+	}
+	return md, data[end:], nil
+}
+
+// TracingMiddleware continues the trace of the request that enqueued each
+// task: it starts a consumer span under the trace context in the task
+// metadata and puts the request ID and sandbox mode in the task context
+func TracingMiddleware(tracerName string) asynq.MiddlewareFunc {
+	tracer := otel.Tracer(tracerName)
+
+	return func(next asynq.Handler) asynq.Handler {
+		return asynq.HandlerFunc(func(ctx context.Context, task *asynq.Task) error {
+			// Payloads with unreadable metadata still run; decoding them
+			// reports the error
+			sandboxed := false
+			if md, _, err := SplitMetadata(task.Payload()); err == nil && len(md) > 0 {
+				ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(md))
+				if id := md[MetadataRequestID]; id != "" {
+					ctx = requestid.WithID(ctx, id)
+				}
+				sandboxed = md[MetadataSandbox] == "true"
+			}
+
+			attrs := []attribute.KeyValue{attribute.String("task.type", task.Type())}
+			if id, ok := asynq.GetTaskID(ctx); ok {
+				attrs = append(attrs, attribute.String("task.id", id))
+			}
+			if queue, ok := asynq.GetQueueName(ctx); ok {
+				attrs = append(attrs, attribute.String("task.queue", queue))
+			}
+			if id := requestid.FromContext(ctx); id != "" {
+				attrs = append(attrs, attribute.String("request_id", id))
+			}
+
+			ctx, span := tracer.Start(ctx, task.Type(),
+				trace.WithSpanKind(trace.SpanKindConsumer),
+				trace.WithAttributes(attrs...),
+			)
+			defer span.End()
+			if sandboxed {
+				ctx = sandbox.With(ctx)
+			}
+
+			err := next.ProcessTask(ctx, task)
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+			return err
+		})
+	}
+}
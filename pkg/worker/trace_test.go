@@ -0,0 +1,94 @@
+package worker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hibiken/asynq"
+	"github.com/pixperk/goiler/pkg/requestid"
+	"github.com/pixperk/goiler/pkg/sandbox"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestWithMetadata_RoundTrip(t *testing.T) {
+	payload, err := EncodePayload(upgradingPayload{Name: "traced", Priority: 1})
+	if err != nil {
+		t.Fatalf("EncodePayload failed: %v", err)
+	}
+
+	data, err := WithMetadata(payload, Metadata{MetadataRequestID: "req-1"})
+	if err != nil {
+		t.Fatalf("WithMetadata failed: %v", err)
+	}
+
+	md, body, err := SplitMetadata(data)
+	if err != nil {
+		t.Fatalf("SplitMetadata failed: %v", err)
+	}
+	if md[MetadataRequestID] != "req-1" || string(body) != string(payload) {
+		t.Errorf("Expected the metadata and payload back, got %v and %q", md, body)
+	}
+
+	// Handlers decode payloads with metadata as usual
+	var decoded upgradingPayload
+	if _, err := DecodePayload(data, &decoded); err != nil {
+		t.Fatalf("DecodePayload failed: %v", err)
+	}
+	if decoded.Name != "traced" {
+		t.Errorf("Expected the payload to decode, got %+v", decoded)
+	}
+
+	if same, _ := WithMetadata(payload, nil); string(same) != string(payload) {
+		t.Error("Expected empty metadata to leave the payload unchanged")
+	}
+	if _, _, err := SplitMetadata(data[:5]); err == nil {
+		t.Error("Expected truncated metadata to fail")
+	}
+}
+
+func TestTracingMiddleware(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator()) })
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	parent := trace.ContextWithSpanContext(sandbox.With(requestid.WithID(context.Background(), "req-1")), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	}))
+
+	md := ContextMetadata(parent)
+	if md[MetadataRequestID] != "req-1" || md["traceparent"] == "" || md[MetadataSandbox] != "true" {
+		t.Fatalf("Expected the request ID, trace context and sandbox mode, got %v", md)
+	}
+	payload, err := WithMetadata(nil, md)
+	if err != nil {
+		t.Fatalf("WithMetadata failed: %v", err)
+	}
+
+	var gotRequestID string
+	var gotTraceID trace.TraceID
+	var gotSandbox bool
+	handler := TracingMiddleware("test")(asynq.HandlerFunc(func(ctx context.Context, task *asynq.Task) error {
+		gotRequestID = requestid.FromContext(ctx)
+		gotSandbox = sandbox.Enabled(ctx)
+		gotTraceID = trace.SpanContextFromContext(ctx).TraceID()
+		return nil
+	}))
+	if err := handler.ProcessTask(context.Background(), asynq.NewTask("test:task", payload)); err != nil {
+		t.Fatalf("ProcessTask failed: %v", err)
+	}
+
+	if gotRequestID != "req-1" {
+		t.Errorf("Expected request ID req-1, got %q", gotRequestID)
+	}
+	if gotTraceID != traceID {
+		t.Errorf("Expected the enqueuing trace to continue, got trace %s", gotTraceID)
+	}
+	if !gotSandbox {
+		t.Error("Expected the task to run in sandbox mode")
+	}
+}